@@ -0,0 +1,116 @@
+// Command rotate-keys re-encrypts stored secrets under the primary
+// encryption key, and encrypts config values for use in YAML or the
+// environment.
+//
+// Usage:
+//
+//	rotate-keys rotate
+//	rotate-keys encrypt-config market_data.api_key < plaintext
+//
+// Keys are read from ENCRYPTION_KEYS or ENCRYPTION_KEYS_FILE, with the
+// primary set by ENCRYPTION_PRIMARY_KEY_ID. To rotate, add the new key,
+// make it primary, run rotate, then remove the old key once it reports no
+// remaining rows.
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+
+    _ "github.com/lib/pq"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    appconfig "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/webhooks"
+)
+
+// configSecrets maps config fields that may be encrypted to the associated
+// data they are bound to
+var configSecrets = map[string][]byte{
+    "market_data.api_key": appconfig.MarketDataAPIKeyAssociatedData,
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+    }
+
+    keyring, err := crypto.LoadKeyring(
+        os.Getenv("ENCRYPTION_PRIMARY_KEY_ID"),
+        os.Getenv("ENCRYPTION_KEYS"),
+        os.Getenv("ENCRYPTION_KEYS_FILE"),
+    )
+    if err != nil {
+        log.Fatalf("Failed to load encryption keys: %v", err)
+    }
+    if keyring == nil {
+        log.Fatal("ENCRYPTION_KEYS or ENCRYPTION_KEYS_FILE must be set")
+    }
+
+    switch os.Args[1] {
+    case "rotate":
+        rotate(keyring)
+    case "encrypt-config":
+        if len(os.Args) != 3 {
+            usage()
+        }
+        encryptConfig(keyring, os.Args[2])
+    default:
+        usage()
+    }
+}
+
+func rotate(keyring *crypto.Keyring) {
+    dsn := os.Getenv("DATABASE_URL")
+    if dsn == "" {
+        dsn = "postgresql://localhost:5432/wolfai?sslmode=disable"
+    }
+    db, err := sql.Open("postgres", dsn)
+    if err != nil {
+        log.Fatalf("Failed to connect to database: %v", err)
+    }
+    defer db.Close()
+
+    ctx := context.Background()
+    columns := append(append([]crypto.Column{}, auth.EncryptedColumns...), webhooks.EncryptedColumns...)
+    for _, col := range columns {
+        n, err := keyring.Rotate(ctx, db, col)
+        if err != nil {
+            log.Fatalf("Rotating %s.%s failed after %d rows: %v", col.Table, col.Column, n, err)
+        }
+        log.Printf("Rotated %d rows in %s.%s", n, col.Table, col.Column)
+    }
+}
+
+func encryptConfig(keyring *crypto.Keyring, field string) {
+    aad, ok := configSecrets[field]
+    if !ok {
+        log.Fatalf("Unknown config field %q", field)
+    }
+
+    plaintext, err := io.ReadAll(os.Stdin)
+    if err != nil {
+        log.Fatalf("Failed to read value: %v", err)
+    }
+    value := strings.TrimSpace(string(plaintext))
+    if value == "" {
+        log.Fatal("No value provided on stdin")
+    }
+
+    ciphertext, err := keyring.Encrypt([]byte(value), aad)
+    if err != nil {
+        log.Fatalf("Failed to encrypt value: %v", err)
+    }
+    fmt.Println(ciphertext)
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: rotate-keys rotate | encrypt-config <field>")
+    os.Exit(2)
+}
@@ -3,26 +3,79 @@ package main
 import (
     "context"
     "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
     "log"
     "net/http"
     "os"
     "os/signal"
+    "sort"
+    "strconv"
+    "strings"
     "syscall"
     "time"
 
+    "github.com/go-redis/redis/v8"
     "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5/pgxpool"
     _ "github.com/lib/pq"
+    "github.com/prometheus/client_golang/prometheus"
     "github.com/rs/cors"
+    "gopkg.in/yaml.v2"
 
+    aimodels "github.com/Cryptoprojectsfun/quantai-clone/internal/ai/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/degrade"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/api/handlers"
+    apimiddleware "github.com/Cryptoprojectsfun/quantai-clone/internal/api/middleware"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/billing"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+    appconfig "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/database"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/jobs"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/mail"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/lstm"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/repository"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/analytics"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/market"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/regime"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/valuation"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/webhooks"
+)
+
+// Prediction worker pool sizing
+const (
+    minPredictionWorkers       = 2
+    maxPredictionWorkers       = 16
+    targetPredictionQueueDepth = 20
 )
 
 func main() {
+    checkConfigOnly := flag.Bool("check-config", false, "validate the config file, print it with secrets masked and exit")
+    configFile := flag.String("config", getEnv("CONFIG_FILE", "config/app.yaml"), "config file -check-config validates")
+    flag.Parse()
+    if *checkConfigOnly {
+        os.Exit(checkConfig(*configFile, os.Stdout))
+    }
+
     // Load configuration
     config := loadConfig()
+    if err := config.Validate(); err != nil {
+        log.Fatalf("%v", err)
+    }
 
     // Initialize database connection
     db, err := sql.Open("postgres", config.DatabaseURL)
@@ -31,63 +84,695 @@ func main() {
     }
     defer db.Close()
 
-    // Initialize services
+    // pgx adds a native pool for array scans and bulk writes; everything
+    // else stays on database/sql
+    var pgxPool *pgxpool.Pool
+    switch config.DatabaseDriver {
+    case database.DriverPQ:
+    case database.DriverPgx:
+        pgxPool, err = database.NewPool(context.Background(), config.DatabaseURL)
+        if err != nil {
+            log.Fatalf("Failed to connect pgx pool: %v", err)
+        }
+        defer pgxPool.Close()
+    default:
+        log.Fatalf("Invalid DATABASE_DRIVER %q, want pq or pgx", config.DatabaseDriver)
+    }
+
+    appLogger, err := logger.New(logger.Config{Level: getEnv("LOG_LEVEL", "info")})
+    if err != nil {
+        log.Fatalf("Failed to initialize logger: %v", err)
+    }
+    logger.SetDefault(appLogger)
+
+    // Load master keys for secrets stored at rest
+    keyring, err := crypto.LoadKeyring(config.EncryptionPrimaryKeyID, config.EncryptionKeys, config.EncryptionKeysFile)
+    if err != nil {
+        log.Fatalf("Failed to load encryption keys: %v", err)
+    }
+    if err := config.MarketData.DecryptSecrets(keyring); err != nil {
+        log.Fatalf("Failed to decrypt secrets: %v", err)
+    }
+
+    // Initialize Redis and market data cache. Without Redis, caches are
+    // bypassed and Redis-backed features fall back or switch off.
+    var rdb *redis.Client
+    if config.RedisEnabled {
+        rdb = redis.NewClient(&redis.Options{Addr: config.RedisAddr})
+        defer rdb.Close()
+    } else {
+        log.Printf("REDIS_ENABLED is false, running without Redis")
+    }
+    redisHealth := cache.NewRedisHealth(rdb, config.RedisDownAfter, appLogger).
+        WithRegisterer(prometheus.DefaultRegisterer)
+
+    // Account for provider requests before anything makes them
+    providerBudgets, err := monitoring.ParseProviderBudgets(config.ProviderBudgets)
+    if err != nil {
+        log.Fatalf("Invalid PROVIDER_BUDGETS: %v", err)
+    }
+    providerUsage := monitoring.NewProviderUsage(db, providerBudgets, appLogger).
+        WithHardLimit(config.ProviderBudgetHardLimit).
+        WithRegisterer(prometheus.DefaultRegisterer)
+    if err := providerUsage.Load(context.Background()); err != nil {
+        log.Printf("Provider budgets start from zero: %v", err)
+    }
+
+    marketCollector := market.NewMarketDataCollector(
+        db,
+        config.MarketData.Provider,
+        config.MarketData.APIKey,
+        config.MarketData.Symbols,
+        config.MarketData.UpdateInterval,
+        appLogger,
+    ).WithJitter(config.MarketData.CollectionJitter).
+        WithPool(pgxPool).
+        WithUsage(providerUsage, market.DegradedCollection{
+            Symbols:  config.MarketData.DegradedSymbols,
+            Interval: config.MarketData.DegradedInterval,
+        })
+    // The hottest symbols are also kept in process for a few seconds. The
+    // local cache only serves once subscribed to the pipeline's updates,
+    // below.
+    var localMarketData *cache.LocalMarketData
+    if rdb != nil && config.Cache.LocalSize > 0 {
+        localMarketData = cache.NewLocalMarketData(config.Cache.LocalSize, config.Cache.LocalTTL).
+            WithRegisterer(prometheus.DefaultRegisterer)
+    }
+    marketCache := cache.NewMarketDataCache(rdb, config.Cache.TTL, marketCollector, appLogger).
+        WithHealth(redisHealth).
+        WithUsage(providerUsage, config.MarketData.Provider).
+        WithLocal(localMarketData)
+
+    // Warm the cache before accepting connections
+    if rdb != nil {
+        prefetchCtx, cancelPrefetch := context.WithTimeout(context.Background(), config.Cache.PrefetchTimeout)
+        if err := marketCache.Prefetch(prefetchCtx, config.MarketData.Symbols); err != nil {
+            log.Printf("Market data cache prefetch incomplete: %v", err)
+        }
+        cancelPrefetch()
+    }
+
+    // Initialize services. Sessions and tokens_revoked_at are checked in
+    // the database too, so the blacklist needn't fail closed without Redis.
+    blacklist := auth.NewTokenBlacklist(nil)
+    if rdb != nil {
+        blacklist = auth.NewRedisTokenBlacklist(rdb).WithLocalFallback(redisHealth)
+    }
+    // Subscription tiers decide what each user may do; see auth.DefaultTiers
+    tiers := auth.DefaultTiers()
+    if config.SubscriptionTiersFile != "" {
+        tiers, err = auth.LoadTiers(config.SubscriptionTiersFile)
+        if err != nil {
+            log.Fatalf("Failed to load subscription tiers: %v", err)
+        }
+    }
+    featureGate := auth.NewFeatureGate(tiers)
+    authMetrics := auth.NewMetrics(prometheus.DefaultRegisterer)
+    authService := auth.NewService(db, config.JWTSecret).
+        WithBootstrapAdmin(config.AdminEmail).
+        WithBlacklist(blacklist).
+        WithTiers(featureGate).
+        WithMetrics(authMetrics)
+    if rdb != nil {
+        authService.WithCodeAttempts(rdb, redisHealth)
+    }
+    if keyring != nil {
+        authService.WithKeyring(keyring)
+    } else {
+        log.Printf("ENCRYPTION_KEYS not set, two-factor authentication disabled")
+    }
+    artifactStore, err := artifacts.NewStore(config.Artifacts)
+    if err != nil {
+        log.Fatalf("Failed to open model artifact store: %v", err)
+    }
+    artifactCache := artifacts.NewCache(artifactStore, config.Artifacts.CacheDir, config.Artifacts.CacheBudgetBytes)
+    // Background work, from maintenance jobs to training and model
+    // serving processes, is stopped through jobsCtx at shutdown
+    jobsCtx, cancelJobs := context.WithCancel(context.Background())
+    defer cancelJobs()
+    mlService := ml.NewService(db, config.ModelPath).
+        WithLifetime(jobsCtx).
+        WithLogBroker(rdb).
+        WithArtifacts(artifactStore, artifactCache)
+    modelManager := ml.NewModelManager(db)
+    predictionQueue := ml.NewPredictionQueue(mlService, config.PredictionQueue, prometheus.DefaultRegisterer)
+    autoScaler := ml.NewAutoScaler(prometheus.DefaultRegisterer)
+    metrics := monitoring.NewMetrics("wolfai")
+    metrics.StartMetricsCollection(time.Minute)
+    componentErrors := monitoring.NewComponentErrors(metrics, prometheus.DefaultRegisterer)
+    healthChecker := monitoring.NewHealthChecker(db, config.HealthCheckInterval)
+    if rdb != nil {
+        healthChecker.RegisterCheck("redis", redisHealth.HealthCheck())
+        // Admin request signing refuses requests while Redis is down, so a
+        // server signing them isn't ready without it
+        if config.AdminAPISecret != "" {
+            healthChecker.RequireForReadiness("redis")
+        }
+    }
+    // Sentiment source weights are recalibrated against realised returns
+    // by the sentiment_calibration job
+    sentimentModel, err := aimodels.NewSentimentModel(aimodels.ModelConfig{}, db)
+    if err != nil {
+        log.Fatalf("Failed to create sentiment model: %v", err)
+    }
+    sentimentHealth := monitoring.NewModelHealthCheck("sentiment")
+    sentimentHealth.UpdateCalibration(sentimentModel.LastCalibratedAt)
+    healthChecker.RegisterCheck("sentiment_model", sentimentHealth.Check)
+    if config.LSTMModelPath != "" {
+        lstmPool := lstm.NewService(db, config.LSTMModelPath, appLogger).
+            WithLifetime(jobsCtx).
+            WithErrors(componentErrors).
+            WithArtifacts(artifactCache).
+            WithMetrics(prometheus.DefaultRegisterer)
+        defer lstmPool.CleanupCache(0)
+        healthChecker.RegisterCheck("model_pool", monitoring.NewModelPoolCheck(lstmPool).Check)
+        // Without warming up, the first request to each model after a
+        // deploy waits for its process to start and often times out
+        if !config.LSTMLazy {
+            healthChecker.RegisterCheck("model_warmup", lstmPool.WarmUpCheck)
+            healthChecker.RequireForReadiness("model_warmup")
+            go func() {
+                active, err := modelManager.ListModels(jobsCtx, ml.StatusActive)
+                if err != nil {
+                    log.Printf("Failed to list models to warm up, loading them on demand: %v", err)
+                }
+                var warm []lstm.WarmUpModel
+                for _, info := range active {
+                    if info.Type != lstm.ModelType {
+                        continue
+                    }
+                    model := lstm.WarmUpModel{Name: info.Name, Version: info.Version}
+                    if info.Schema != nil {
+                        model.Inputs = len(info.Schema.Features)
+                    }
+                    warm = append(warm, model)
+                }
+                lstmPool.WarmUp(jobsCtx, warm, config.LSTMWarmUpConcurrency)
+            }()
+        }
+    }
+    marketCollector.WithErrors(componentErrors)
     portfolioService := portfolio.NewPortfolioService(db)
-    portfolioAnalyzer := portfolio.NewPortfolioAnalyzer(db)
-    portfolioOptimizer := portfolio.NewPortfolioOptimizer(db)
-    riskManager := risk.NewRiskManager(db)
+    // Cash and stablecoins are valued without market data, and carry no
+    // market risk
+    valuers := valuation.NewRegistry(db,
+        portfolio.NewCachedPriceSource(marketCache, portfolio.NewDBPriceSource(db)), config.PortfolioCurrency)
+    // Sharpe ratios everywhere are measured against the same risk-free rate
+    var riskFree risk.RiskFreeRateProvider = risk.StaticRiskFreeRate(config.RiskFreeRate)
+    if config.RiskFreeRateSymbol != "" {
+        riskFree = risk.NewMarketRiskFreeRate(db, config.RiskFreeRateSymbol).WithFallback(riskFree)
+    }
+    portfolioAnalyzer := portfolio.NewPortfolioAnalyzer(db).WithMarketSymbol(config.MarketSymbol).WithValuers(valuers).
+        WithRiskFreeRate(riskFree)
+    portfolioOptimizer := portfolio.NewPortfolioOptimizer(db).WithConfig(portfolio.OptimizerConfig{
+        EWMAHalfLifeDays: config.EWMAHalfLifeDays,
+        MarketSymbol:     config.MarketSymbol,
+    }).WithMetrics(metrics).WithValuers(valuers).WithPool(pgxPool).WithRiskFreeRate(riskFree).
+        WithSamplePolicy(config.SamplePolicy)
+    regimeDetector := regime.NewDetector(db).WithConfig(config.Regime).WithSymbols(marketCollector)
+    riskManager := risk.NewRiskManager(db).WithRegimes(regimeDetector, config.RegimeVolAlertMultiplier).
+        WithPriceSource(portfolio.NewCachedPriceSource(marketCache, portfolio.NewDBPriceSource(db))).
+        WithValuers(valuers).
+        WithSamplePolicy(config.SamplePolicy).
+        WithPerformance(portfolioAnalyzer, config.MarketSymbol, portfolio.DefaultInformationRatioWindow).
+        WithMaxExpectedShortfall(config.MaxExpectedShortfall)
+    riskMonitor := risk.NewMonitor(riskManager, rdb, risk.LogAlertSink{}).WithDebounce(config.RiskMonitorDebounce)
+    mailTransport, err := mail.NewTransport(config.Mail)
+    if err != nil {
+        log.Fatalf("Failed to configure mail: %v", err)
+    }
+    mailRenderer, err := mail.NewRenderer()
+    if err != nil {
+        log.Fatalf("Failed to load email templates: %v", err)
+    }
+    mailQueue := mail.NewQueue(db, mailRenderer, mailTransport, config.Mail)
+    // Automatic model rollbacks are emailed to admins
+    modelManager.WithNotifier(ml.NewMailAdminNotifier(db, mailQueue))
+    cryptoBoundary, err := calendar.ParseCryptoBoundary(config.CryptoDayBoundary)
+    if err != nil {
+        log.Fatalf("Invalid CRYPTO_DAY_BOUNDARY: %v", err)
+    }
+    tradingHolidays, err := calendar.ParseHolidays(config.TradingHolidays)
+    if err != nil {
+        log.Fatalf("Invalid TRADING_HOLIDAYS: %v", err)
+    }
+    tradingCalendars := calendar.NewCalendars()
+    if err := tradingCalendars.Configure(tradingHolidays...); err != nil {
+        log.Fatalf("Invalid TRADING_HOLIDAYS: %v", err)
+    }
+    calendars := calendar.NewResolver(db, cryptoBoundary).WithTradingCalendars(tradingCalendars)
+    snapshotter := portfolio.NewSnapshotter(db,
+        portfolio.NewCachedPriceSource(marketCache, portfolio.NewDBPriceSource(db)), cryptoBoundary).
+        WithTradingCalendars(tradingCalendars).
+        WithMetrics(monitoring.NewPortfolioMetrics(prometheus.DefaultRegisterer, config.MonitoredPortfolios))
+    consistencyChecker := portfolio.NewConsistencyChecker(db, config.ConsistencyTolerance).
+        WithRegisterer(prometheus.DefaultRegisterer)
 
     // Initialize handlers
+    authHandler := handlers.NewAuthHandler(authService)
+    accountHandler := handlers.NewAccountHandler(authService)
+    adminHandler := handlers.NewAdminHandler(authService)
+    // None of the routed analytics endpoints generate market analyses, so
+    // no AI service is wired in and GetMarketAnalysis returns ErrNoAIService
+    // for a symbol without a stored analysis
+    analyticsService := analytics.NewService(db, nil).
+        WithMarketSymbol(config.MarketSymbol).
+        WithBetaCalculator(portfolioAnalyzer).
+        WithRiskFreeRate(riskFree).
+        WithSamplePolicy(config.SamplePolicy).
+        WithSubscriptions(marketCollector).
+        WithOptimizer(portfolioOptimizer).
+        WithCalendars(calendars).
+        WithMaxAnalysisAge(time.Duration(config.MaxAnalysisAgeHours) * time.Hour).
+        WithAnalysisHistory(time.Duration(config.AnalysisHistoryRetentionHours) * time.Hour).
+        WithRegisterer(prometheus.DefaultRegisterer)
+    // Webhook secrets are stored encrypted, so webhooks need a keyring
+    var webhookQueue *webhooks.Queue
+    if keyring != nil {
+        webhookQueue = webhooks.NewQueue(db, keyring)
+        analyticsService.WithWebhooks(webhookQueue)
+    } else {
+        log.Printf("ENCRYPTION_KEYS not set, webhooks disabled")
+    }
+    analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, portfolioService)
+    leaderboards := analytics.NewLeaderboards(db)
+    leaderboardHandler := handlers.NewLeaderboardHandler(leaderboards)
+    providerUsageHandler := handlers.NewProviderUsageHandler(providerUsage)
+    dataConsistencyHandler := handlers.NewDataConsistencyHandler(consistencyChecker)
+    recomputer := jobs.NewRecomputer(db, config.Recompute,
+        jobs.RecomputeStep{Name: "snapshots", Run: snapshotter.Revalue},
+        jobs.RecomputeStep{Name: "nav", Run: func(ctx context.Context, id int64, _, _ time.Time) error {
+            return analyticsService.RecomputeNAV(ctx, id)
+        }},
+        jobs.RecomputeStep{Name: "risk", Run: func(ctx context.Context, id int64, _, _ time.Time) error {
+            _, err := riskManager.RecordRisk(ctx, id)
+            return err
+        }},
+    ).WithOnComplete(analyticsService.InvalidateCaches)
+    recomputeHandler := handlers.NewRecomputeHandler(recomputer)
+    exporter := jobs.NewExporter(db, artifactStore, jobs.ExportConfig{
+        SigningKey:  []byte(config.ExportSigningKey),
+        MinInterval: config.ExportMinInterval,
+        Retention:   config.ExportRetention,
+        URLTTL:      config.ExportURLTTL,
+    })
+    exportHandler := handlers.NewExportHandler(exporter)
+    regimeHandler := handlers.NewRegimeHandler(regimeDetector)
+    marketDataHandler := handlers.NewMarketDataHandler(repository.NewCompressedMarketDataRepository(database.New(db)))
+    portfolioEventsHandler := handlers.NewPortfolioEventsHandler(portfolio.NewEventLog(db))
+    cacheHandler := handlers.NewCacheHandler(cache.NewCacheStatsService(rdb, config.Cache.MaxNamespaceMemoryMB).WithHealth(redisHealth)).
+        WithAdmin(cache.NewCacheAdmin(rdb).WithHealth(redisHealth).WithFlushConfirmAbove(int64(config.Cache.FlushConfirmAbove)), authService)
+    // Each member's prediction is settled a day later against the close, so
+    // scheduled models retrain once their live error degrades
+    predictionOutcomes := ml.NewPredictionOutcomes(db)
+    ensemble := ml.NewEnsemble(db, modelManager, ml.NewMarketFeatureSource(db), predictionQueue.Submit).
+        WithOutcomes(predictionOutcomes)
+    modelTrainer := ml.NewModelTrainer(db, modelManager, mlService, appLogger).
+        WithErrors(componentErrors).
+        WithDegradationDetector(ml.NewDegradationDetector(modelManager, prometheus.DefaultRegisterer))
+    // Usage metered hourly in Redis and recorded for billing
+    usageMeter := billing.NewUsageMeter(rdb, db).WithHealth(redisHealth)
+    usageLedger := billing.NewUsageLedger(db, rdb).WithHealth(redisHealth)
+    usageHandler := handlers.NewUsageHandler(usageLedger)
+    predictionUsage := ml.NewUsageTracker(db).WithMeter(usageMeter)
+    // Ensemble predictions are kept for PredictionTTL, for portfolio
+    // prediction overlays to reuse
+    predictionHistory := ml.NewPredictionHistory(db, config.PredictionTTL)
+    mlHandler := handlers.NewMLHandler(mlService, modelManager).
+        WithQueue(predictionQueue).
+        WithUsage(predictionUsage).
+        WithTrainingEvents(mlService.Events()).
+        WithEnsemble(ensemble).
+        WithHistory(predictionHistory).
+        WithTrainer(modelTrainer)
+    // Subscribed predictions regenerated off-peak. The ensemble predicts at
+    // its models' horizon, so the timeframe is what the prediction is kept
+    // and listed under.
+    predictionSubscriptions := ml.NewPredictionSubscriptions(db)
+    subscriptionScheduler := ml.NewSubscriptionScheduler(db,
+        func(ctx context.Context, symbol, timeframe string) (*ml.EnsemblePrediction, error) {
+            return ensemble.Predict(ctx, symbol)
+        }, featureGate, config.PredictionSubscriptions).
+        WithMailer(mailQueue).
+        WithMeter(usageMeter)
+    predictionSubscriptionHandler := handlers.NewPredictionSubscriptionHandler(predictionSubscriptions)
+    trainingLogs := handlers.NewTrainingLogStreamer(rdb, mlService)
+    portfolioRepo := repository.NewPortfolioRepository(database.New(db))
     portfolioHandler := handlers.NewPortfolioHandler(
         portfolioService,
         portfolioAnalyzer,
         portfolioOptimizer,
         riskManager,
-    )
+    ).WithPriceSource(portfolio.NewCachedPriceSource(marketCache, portfolio.NewDBPriceSource(db))).
+        WithTransfer(portfolio.NewPortfolioTransfer(db)).
+        WithRiskMonitor(riskMonitor).
+        WithSearch(portfolioRepo).
+        WithRepository(portfolioRepo).
+        WithTiers(featureGate, portfolioRepo).
+        WithStageMetrics(monitoring.NewStageMetrics(prometheus.DefaultRegisterer)).
+        WithPredictions(ml.NewPredictionResolver(predictionHistory, ensemble.Predict, predictionUsage, featureGate)).
+        WithSectionBudget(config.PortfolioSectionBudget).
+        WithDegradedMetrics(degrade.NewMetrics(prometheus.DefaultRegisterer)).
+        WithLimits(handlers.PositionLimits{
+            MaxPositionValueUSD:  config.MaxPositionValueUSD,
+            MaxPortfolioValueUSD: config.MaxPortfolioValueUSD,
+        })
+
+    // Usage counted against tier limits
+    portfolioCount := func(ctx context.Context, user *models.User) (int, error) {
+        return portfolioRepo.CountByUser(ctx, user.ID)
+    }
+    predictionsToday := func(ctx context.Context, user *models.User) (int, error) {
+        return predictionUsage.PredictionsToday(ctx, user.ID)
+    }
+    subscriptionCount := func(ctx context.Context, user *models.User) (int, error) {
+        return predictionSubscriptions.Count(ctx, user.ID)
+    }
 
     // Initialize middleware
-    authMiddleware := middleware.NewAuthMiddleware(config.JWTSecret)
+    authMiddleware := middleware.NewAuthMiddleware(authService).WithMetrics(authMetrics)
+    userContexts := middleware.NewUserContextCache(rdb, db, featureGate, predictionsToday).WithHealth(redisHealth)
+    clientIPResolver, err := middleware.NewClientIPResolver(config.TrustedProxies)
+    if err != nil {
+        log.Fatalf("Failed to configure trusted proxies: %v", err)
+    }
 
     // Create router
     router := mux.NewRouter()
 
     // Apply global middleware
-    router.Use(middleware.Recovery)
+    router.Use(middleware.NewRecoverer(appLogger, prometheus.DefaultRegisterer).Recovery)
+    router.Use(appLogger.BindRequest)
+    router.Use(middleware.ResolveClientIP(clientIPResolver))
     router.Use(middleware.RateLimit(config.RateLimit))
-    router.Use(cors.New(cors.Options{
-        AllowedOrigins:   config.AllowedOrigins,
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Authorization", "Content-Type"},
-        AllowCredentials: true,
-    }).Handler)
+
+    router.HandleFunc("/health", healthChecker.HTTPHandler()).Methods("GET")
+    router.HandleFunc("/ready", healthChecker.ReadinessHandler()).Methods("GET")
 
     // API routes
     api := router.PathPrefix("/api/v1").Subrouter()
 
     // Public routes
-    api.HandleFunc("/auth/register", handlers.RegisterHandler).Methods("POST")
-    api.HandleFunc("/auth/login", handlers.LoginHandler).Methods("POST")
+    api.HandleFunc("/auth/register", authHandler.Register).Methods("POST")
+    api.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
+    api.HandleFunc("/auth/2fa", authHandler.CompleteTwoFactor).Methods("POST")
+    // Export downloads are authorized by their signed URL
+    api.HandleFunc("/exports/{id}/download", exportHandler.DownloadExport).Methods("GET")
+
+    // Ends an impersonation with its own token, so it is routed ahead of
+    // the read-only guard on the protected routes
+    api.Handle("/impersonation", authMiddleware.RequireAuth(http.HandlerFunc(adminHandler.EndImpersonation))).Methods("DELETE")
 
     // Protected routes
     protected := api.PathPrefix("").Subrouter()
-    protected.Use(authMiddleware.RequireAuth)
+    // Rebind the request logger once the user is known
+    protected.Use(authMiddleware.RequireAuth, appLogger.BindRequest, middleware.EnrichUserContext(userContexts))
+    // Impersonating admins may only read, and everything they do is audited
+    protected.Use(authMiddleware.GuardImpersonation)
+    protected.Use(usageMeter.CountRequests)
+    // Retried writes carrying an Idempotency-Key replay the first response
+    protected.Use(apimiddleware.NewIdempotency(rdb).WithHealth(redisHealth).Handle)
+
+    // Account routes
+    protected.HandleFunc("/me", accountHandler.GetProfile).Methods("GET")
+    protected.HandleFunc("/me", accountHandler.UpdateProfile).Methods("PUT")
+    protected.HandleFunc("/me/password", accountHandler.ChangePassword).Methods("POST")
+    protected.HandleFunc("/me/export", exportHandler.StartExport).Methods("POST")
+    protected.HandleFunc("/me/export/{id}", exportHandler.GetExport).Methods("GET")
+    protected.HandleFunc("/me", accountHandler.DeleteAccount).Methods("DELETE")
+    protected.HandleFunc("/me/sessions", accountHandler.ListSessions).Methods("GET")
+    protected.HandleFunc("/usage/history", usageHandler.GetUsageHistory).Methods("GET")
+    protected.HandleFunc("/me/sessions/{id}", accountHandler.RevokeSession).Methods("DELETE")
+    protected.HandleFunc("/me/2fa/setup", accountHandler.SetupTwoFactor).Methods("POST")
+    protected.HandleFunc("/me/2fa/verify", accountHandler.VerifyTwoFactor).Methods("POST")
+    protected.HandleFunc("/me/2fa/recovery-codes", accountHandler.RegenerateRecoveryCodes).Methods("POST")
 
     // Portfolio routes
-    protected.HandleFunc("/portfolios", portfolioHandler.CreatePortfolio).Methods("POST")
+    protected.Handle("/portfolios", limited(featureGate, auth.LimitMaxPortfolios, portfolioCount, portfolioHandler.CreatePortfolio)).Methods("POST")
+    protected.Handle("/portfolios/import", limited(featureGate, auth.LimitMaxPortfolios, portfolioCount, portfolioHandler.ImportPortfolio)).Methods("POST")
+    protected.HandleFunc("/portfolios", portfolioHandler.ListPortfolios).Methods("GET")
+    protected.HandleFunc("/portfolios", portfolioHandler.BulkDeletePortfolios).Methods("DELETE")
+    protected.HandleFunc("/portfolios/search", portfolioHandler.SearchPortfolios).Methods("GET")
     protected.HandleFunc("/portfolios/{id}", portfolioHandler.GetPortfolio).Methods("GET")
+    protected.Handle("/portfolios/{id}/fork", limited(featureGate, auth.LimitMaxPortfolios, portfolioCount, portfolioHandler.ForkPortfolio)).Methods("POST")
     protected.HandleFunc("/portfolios/{id}/analyze", portfolioHandler.AnalyzePortfolio).Methods("GET")
-    protected.HandleFunc("/portfolios/{id}/optimize", portfolioHandler.OptimizePortfolio).Methods("POST")
+    protected.Handle("/portfolios/{id}/predictions", middleware.ConditionalGET(http.HandlerFunc(portfolioHandler.GetPositionPredictions))).Methods("GET")
+    protected.Handle("/portfolios/{id}/optimize", gated(featureGate, auth.FeatureOptimization, portfolioHandler.OptimizePortfolio)).Methods("POST")
     protected.HandleFunc("/portfolios/{id}/risk", portfolioHandler.GetRiskMetrics).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/monte-carlo-stress", portfolioHandler.MonteCarloStressTest).Methods("POST")
+    protected.Handle("/portfolios/{id}/efficient-frontier", gated(featureGate, auth.FeatureOptimization, portfolioHandler.GetEfficientFrontier)).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/export", portfolioHandler.ExportPortfolio).Methods("GET")
+    protected.Handle("/portfolios/{id}/what-if-optimization", gated(featureGate, auth.FeatureOptimization, analyticsHandler.GetWhatIfOptimization)).Methods("GET")
+    protected.Handle("/portfolios/{id}/rebalancing-orders", gated(featureGate, auth.FeatureOptimization, analyticsHandler.GetRebalancingOrders)).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/drawdown-recovery", analyticsHandler.GetDrawdownRecovery).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/performance", analyticsHandler.GetDailyPerformance).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/diversification-trend", analyticsHandler.GetDiversificationTrend).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/allocation/history", analyticsHandler.GetAllocationHistory).Methods("GET")
+    protected.HandleFunc("/portfolios/{id}/cashflows", analyticsHandler.RecordCashflow).Methods("POST")
+    protected.HandleFunc("/users/me/aggregate-view", analyticsHandler.GetAggregateView).Methods("GET")
+
+    // Analytics routes
+    protected.HandleFunc("/analytics/market-regime", analyticsHandler.GetMarketRegime).Methods("GET")
+    protected.HandleFunc("/market/{symbol}/regime", regimeHandler.GetRegime).Methods("GET")
+    protected.HandleFunc("/market/{symbol}/seasonality", analyticsHandler.GetSeasonality).Methods("GET")
+    protected.HandleFunc("/market/{symbol}/impact", analyticsHandler.GetMarketImpact).Methods("GET")
+    protected.HandleFunc("/market/{symbol}/regime/history", regimeHandler.GetRegimeHistory).Methods("GET")
+    protected.HandleFunc("/leaderboard/models", leaderboardHandler.GetModelLeaderboard).Methods("GET")
+    protected.HandleFunc("/leaderboard/strategies", leaderboardHandler.GetStrategyLeaderboard).Methods("GET")
+
+    // ML routes
+    protected.Handle("/market/{symbol}/predictions/ensemble", limited(featureGate, auth.LimitDailyPredictions, predictionsToday, mlHandler.GetEnsemblePrediction)).Methods("GET")
+    protected.Handle("/ml/predict", limited(featureGate, auth.LimitDailyPredictions, predictionsToday, mlHandler.GetPrediction)).Methods("POST")
+    protected.Handle("/ml/predict/batch", limited(featureGate, auth.LimitDailyPredictions, predictionsToday, mlHandler.BatchPredict)).Methods("POST")
+    protected.HandleFunc("/predictions/subscriptions", predictionSubscriptionHandler.ListSubscriptions).Methods("GET")
+    protected.Handle("/predictions/subscriptions", limited(featureGate, auth.LimitPredictionSubscriptions, subscriptionCount, predictionSubscriptionHandler.CreateSubscription)).Methods("POST")
+    protected.HandleFunc("/predictions/subscriptions/{id}", predictionSubscriptionHandler.DeleteSubscription).Methods("DELETE")
+    protected.HandleFunc("/ml/models/{name}", mlHandler.GetModel).Methods("GET")
+    protected.HandleFunc("/ml/models/{name}/{version}", mlHandler.GetModel).Methods("GET")
+    protected.Handle("/ml/train/{id}/events", permit(auth.PermManageJobs, mlHandler.StreamTrainingEvents)).Methods("GET")
+    protected.Handle("/ml/jobs/{id}/logs/stream", permit(auth.PermManageJobs, trainingLogs.StreamLogs)).Methods("GET")
+
+    // Admin routes, each gated on a permission
+    admin := protected.PathPrefix("/admin").Subrouter()
+    if config.AdminAPISecret != "" {
+        admin.Use(middleware.NewRequestSigner(rdb, config.AdminAPISecret).WithHealth(redisHealth).Verify)
+    } else {
+        log.Printf("ADMIN_API_UNSIGNED set, admin request signing disabled")
+    }
+    admin.Handle("/models", permit(auth.PermManageModels, mlHandler.ListModels)).Methods("GET")
+    admin.Handle("/models/{name}/{version}/status", permit(auth.PermManageModels, mlHandler.UpdateModelStatus)).Methods("PUT")
+    admin.Handle("/models/{name}/rollback", permit(auth.PermManageModels, mlHandler.RollbackModel)).Methods("POST")
+    admin.Handle("/models/{name}/hyperparameter-search", permit(auth.PermManageJobs, mlHandler.StartHyperparameterSearch)).Methods("POST")
+    admin.Handle("/models/{name}/hyperparameter-search", permit(auth.PermManageJobs, mlHandler.GetHyperparameterSearch)).Methods("GET")
+    admin.Handle("/jobs", permit(auth.PermManageJobs, mlHandler.StartTraining)).Methods("POST")
+    admin.Handle("/jobs/{id}", permit(auth.PermManageJobs, mlHandler.GetTrainingStatus)).Methods("GET")
+    admin.Handle("/recompute", permit(auth.PermManageJobs, recomputeHandler.StartRecompute)).Methods("POST")
+    admin.Handle("/recompute/{id}", permit(auth.PermManageJobs, recomputeHandler.GetRecompute)).Methods("GET")
+    admin.Handle("/recompute/{id}/pause", permit(auth.PermManageJobs, recomputeHandler.PauseRecompute)).Methods("POST")
+    admin.Handle("/recompute/{id}/resume", permit(auth.PermManageJobs, recomputeHandler.ResumeRecompute)).Methods("POST")
+    admin.Handle("/analytics/stale-count", permit(auth.PermViewStats, analyticsHandler.GetStaleAnalysisCount)).Methods("GET")
+    admin.Handle("/market-data/stats", permit(auth.PermViewStats, marketDataHandler.GetStats)).Methods("GET")
+    admin.Handle("/cache/stats", permit(auth.PermViewStats, cacheHandler.GetStats)).Methods("GET")
+    admin.Handle("/cache/keys", permit(auth.PermManageCache, cacheHandler.ListKeys)).Methods("GET")
+    admin.Handle("/cache/entry", permit(auth.PermManageCache, cacheHandler.GetEntry)).Methods("GET")
+    admin.Handle("/cache/entry", permit(auth.PermManageCache, cacheHandler.DeleteEntry)).Methods("DELETE")
+    admin.Handle("/cache/flush", permit(auth.PermManageCache, cacheHandler.FlushPrefix)).Methods("POST")
+    admin.Handle("/provider-usage", permit(auth.PermViewStats, providerUsageHandler.GetProviderUsage)).Methods("GET")
+    admin.Handle("/usage/history", permit(auth.PermViewStats, usageHandler.GetAllUsageHistory)).Methods("GET")
+    admin.Handle("/usage/billing", permit(auth.PermViewStats, usageHandler.ExportBilling)).Methods("GET")
+    admin.Handle("/audit", permit(auth.PermViewAudit, adminHandler.ListAuditLog)).Methods("GET")
+    admin.Handle("/impersonate/{userID}", permit(auth.PermImpersonate, adminHandler.Impersonate)).Methods("POST")
+    admin.Handle("/portfolios/{id}/replay", permit(auth.PermViewAudit, portfolioEventsHandler.ReplayPortfolio)).Methods("GET")
+    admin.Handle("/portfolios/{id}/consistency", permit(auth.PermViewAudit, portfolioEventsHandler.CheckConsistency)).Methods("GET")
+    admin.Handle("/portfolios/{id}/repair", permit(auth.PermManageJobs, dataConsistencyHandler.RepairPortfolio)).Methods("POST")
+    admin.Handle("/data-inconsistencies", permit(auth.PermViewStats, dataConsistencyHandler.ListInconsistencies)).Methods("GET")
+    admin.Handle("/stats", middleware.RequirePermission(auth.PermViewStats)(metrics.MetricsHandler())).Methods("GET")
+    admin.Handle("/monitoring/regression-check", permit(auth.PermViewStats,
+        metrics.RegressionCheckHandler(monitoring.DefaultRegressionThresholds))).Methods("GET")
+    if webhookQueue != nil {
+        webhookHandler := handlers.NewWebhookHandler(webhookQueue)
+        admin.Handle("/webhooks", permit(auth.PermManageJobs, webhookHandler.RegisterWebhook)).Methods("POST")
+        admin.Handle("/webhooks/{id}", permit(auth.PermManageJobs, webhookHandler.DeleteWebhook)).Methods("DELETE")
+    }
+    admin.Handle("/users/{id}/role", permit(auth.PermManageRoles, adminHandler.UpdateUserRole)).Methods("PUT")
+    admin.Handle("/users/{id}/tier", permit(auth.PermManageTiers, adminHandler.UpdateUserTier)).Methods("PUT")
+
+    // CORS wraps the router rather than running as its middleware, so
+    // preflights are answered for every route, ahead of authentication
+    handler := cors.New(cors.Options{
+        AllowedOrigins:   config.AllowedOrigins,
+        AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Authorization", "Content-Type"},
+        ExposedHeaders:   []string{middleware.ImpersonatedByHeader},
+        AllowCredentials: true,
+    }).Handler(middleware.Routing(router))
 
     // Create server
     srv := &http.Server{
         Addr:         ":" + config.Port,
-        Handler:      router,
+        Handler:      handler,
         ReadTimeout:  15 * time.Second,
         WriteTimeout: 15 * time.Second,
         IdleTimeout:  60 * time.Second,
     }
 
+    // Background maintenance jobs
+    scheduler := jobs.NewScheduler(appLogger)
+    scheduler.Register(jobs.Job{
+        Name:     "account_purge",
+        Interval: time.Hour,
+        Run: func(ctx context.Context) error {
+            _, err := authService.PurgeDeletedAccounts(ctx)
+            return err
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "session_cleanup",
+        Interval: time.Hour,
+        Run: func(ctx context.Context) error {
+            _, err := authService.CleanupExpiredSessions(ctx)
+            return err
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "market_regimes",
+        Interval: time.Hour,
+        Run:      regimeDetector.Record,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "artifact_cache_cleanup",
+        Interval: time.Hour,
+        Run: func(ctx context.Context) error {
+            _, err := artifactCache.Cleanup()
+            return err
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "portfolio_snapshots",
+        Interval: config.SnapshotInterval,
+        Run: func(ctx context.Context) error {
+            // NAV is unitized from the snapshots, so it follows each run
+            snapErr := snapshotter.Run(ctx)
+            if err := analyticsService.RecomputeAllNAV(ctx); err != nil {
+                return err
+            }
+            return snapErr
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "data_consistency",
+        Interval: config.ConsistencyCheckInterval,
+        Run:      consistencyChecker.Run,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "risk_analysis",
+        Interval: config.RiskRecalcInterval,
+        Run:      riskMonitor.RunFull,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "analysis_purge",
+        Interval: time.Hour,
+        Run:      analyticsService.PurgeStaleAnalyses,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "prediction_outcomes",
+        Interval: time.Hour,
+        Run: func(ctx context.Context) error {
+            _, err := predictionOutcomes.Settle(ctx)
+            return err
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "sentiment_calibration",
+        Interval: config.SentimentCalibrationInterval,
+        Run: func(ctx context.Context) error {
+            if err := sentimentModel.CalibrateSourceWeights(ctx, db, config.SentimentCalibrationLookback); err != nil {
+                return err
+            }
+            sentimentHealth.UpdateCalibration(sentimentModel.LastCalibratedAt)
+            return nil
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "leaderboards",
+        Interval: time.Hour,
+        Run:      leaderboards.Refresh,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "provider_usage_flush",
+        Interval: time.Hour,
+        Run:      providerUsage.Flush,
+    })
+    if rdb != nil {
+        scheduler.Register(jobs.Job{
+            Name:     "usage_aggregation",
+            Interval: time.Hour,
+            Run:      usageLedger.Aggregate,
+        })
+    }
+    scheduler.Register(jobs.Job{
+        Name:     "prediction_subscriptions",
+        Interval: config.PredictionSubscriptionInterval,
+        Run: func(ctx context.Context) error {
+            _, err := subscriptionScheduler.Run(ctx)
+            return err
+        },
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "recompute",
+        Interval: config.RecomputePollInterval,
+        Run:      recomputer.Run,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "user_exports",
+        Interval: config.ExportPollInterval,
+        Run:      exporter.Run,
+    })
+    scheduler.Register(jobs.Job{
+        Name:     "user_export_cleanup",
+        Interval: time.Hour,
+        Run: func(ctx context.Context) error {
+            _, err := exporter.Cleanup(ctx)
+            return err
+        },
+    })
+    if mailTransport != nil {
+        scheduler.Register(jobs.Job{
+            Name:     "mail_delivery",
+            Interval: config.MailDeliveryInterval,
+            Run: func(ctx context.Context) error {
+                _, err := mailQueue.Deliver(ctx)
+                return err
+            },
+        })
+    } else {
+        log.Printf("MAIL_HOST and MAIL_DEV_DIR not set, queued email will not be delivered")
+    }
+    if webhookQueue != nil {
+        scheduler.Register(jobs.Job{
+            Name:     "webhook_delivery",
+            Interval: config.WebhookDeliveryInterval,
+            Run: func(ctx context.Context) error {
+                _, err := webhookQueue.Deliver(ctx)
+                return err
+            },
+        })
+    }
+    scheduler.Start(jobsCtx)
+    // Checks also ping Redis, which is how it is noticed coming back
+    // while callers skip it
+    healthChecker.StartChecks(jobsCtx)
+    go func() {
+        if err := riskMonitor.Start(jobsCtx); err != nil && !errors.Is(err, context.Canceled) {
+            log.Printf("Intraday risk monitor stopped: %v", err)
+        }
+    }()
+    go autoScaler.Run(jobsCtx, predictionQueue, minPredictionWorkers, maxPredictionWorkers, targetPredictionQueueDepth)
+    if localMarketData != nil {
+        go localMarketData.Subscribe(jobsCtx, rdb, appLogger)
+    }
+
     // Start server
     go func() {
         log.Printf("Server starting on port %s", config.Port)
@@ -110,27 +795,285 @@ func main() {
         log.Fatalf("Server forced to shutdown: %v", err)
     }
 
+    cancelJobs()
+    scheduler.Wait()
+    mlService.Wait()
+    if err := providerUsage.Flush(ctx); err != nil {
+        log.Printf("Failed to flush provider usage: %v", err)
+    }
+
     log.Println("Server stopped")
 }
 
+func permit(perm auth.Permission, h http.HandlerFunc) http.Handler {
+    return middleware.RequirePermission(perm)(h)
+}
+
+// gated serves h only to users whose subscription tier grants feature
+func gated(gate *auth.FeatureGate, feature string, h http.HandlerFunc) http.Handler {
+    return middleware.RequireFeature(gate, feature)(h)
+}
+
+// limited serves h only while usage is under the user's tier limit
+func limited(gate *auth.FeatureGate, limit string, usage middleware.Usage, h http.HandlerFunc) http.Handler {
+    return middleware.RequireUnderLimit(gate, limit, usage)(h)
+}
+
 type Config struct {
     Port           string
     DatabaseURL    string
+    // DatabaseDriver is pq, or pgx to also open a pgx pool
+    DatabaseDriver string
+    RedisAddr      string
+    // RedisEnabled false runs without Redis, for small deployments. Redis
+    // failing for RedisDownAfter switches off the features needing it.
+    RedisEnabled        bool
+    RedisDownAfter      time.Duration
+    HealthCheckInterval time.Duration
     JWTSecret      string
+    AdminEmail     string
+    // AdminAPISecret signs admin requests; see middleware.SignRequest
+    AdminAPISecret string
+    // AdminAPIUnsigned serves admin routes without AdminAPISecret, for
+    // development only
+    AdminAPIUnsigned bool
+    // EncryptionKeys holds master keys as id:base64key pairs; see crypto.ParseKeys
+    EncryptionKeys         string
+    EncryptionKeysFile     string
+    EncryptionPrimaryKeyID string
+    ModelPath      string
+    // LSTMModelPath serves LSTM models from long-running processes; empty
+    // leaves the pool off
+    LSTMModelPath  string
+    // LSTMLazy skips warming up the pool's models at startup, for
+    // development; each is loaded by its first request instead.
+    // LSTMWarmUpConcurrency is how many models warm up at once.
+    LSTMLazy              bool
+    LSTMWarmUpConcurrency int
+    EWMAHalfLifeDays float64
+    Artifacts      appconfig.ArtifactStoreConfig
     RateLimit      int
     AllowedOrigins []string
+    TrustedProxies []string
+    MarketSymbol   string
+    // RiskFreeRate is the annual risk-free rate Sharpe ratios are measured
+    // against. With RiskFreeRateSymbol set it is only the fallback for when
+    // that symbol's yield, in percent, hasn't been collected recently.
+    RiskFreeRate       float64
+    RiskFreeRateSymbol string
+    // PortfolioCurrency is the currency cash holdings are valued in
+    PortfolioCurrency string
+    // MaxAnalysisAgeHours is how long market analyses are kept before
+    // they are purged
+    MaxAnalysisAgeHours int
+    // AnalysisHistoryRetentionHours is how long past market analyses are
+    // kept in market_analysis_history; zero keeps no history
+    AnalysisHistoryRetentionHours int
+    // SamplePolicy is the minimum history of each statistic, below which
+    // it is reported as null
+    SamplePolicy sample.Policy
+    Regime         regime.Config
+    // RegimeVolAlertMultiplier scales the volatility alert threshold during
+    // high-volatility regimes
+    RegimeVolAlertMultiplier float64
+    // RiskMonitorDebounce is the minimum time between intraday risk
+    // recalculations of a portfolio, and RiskRecalcInterval how often the
+    // full risk analysis runs
+    RiskMonitorDebounce time.Duration
+    RiskRecalcInterval  time.Duration
+    // SentimentCalibrationInterval is how often sentiment source weights
+    // are recalibrated, from the outcomes of the last
+    // SentimentCalibrationLookback
+    SentimentCalibrationInterval time.Duration
+    SentimentCalibrationLookback time.Duration
+    // MaxExpectedShortfall is the expected shortfall, as a fraction of a
+    // portfolio's value, above which ES_EXCEEDED is raised
+    MaxExpectedShortfall float64
+    // PortfolioSectionBudget is how long each of the analytics, risk and
+    // prediction sections of a portfolio's detail may take before it is
+    // served without them
+    PortfolioSectionBudget time.Duration
+    // MaxPositionValueUSD and MaxPortfolioValueUSD cap what a single asset
+    // and a whole portfolio may be worth when portfolios are created or
+    // imported; zero leaves a cap off
+    MaxPositionValueUSD  float64
+    MaxPortfolioValueUSD float64
+    MarketData     appconfig.MarketDataConfig
+    // ProviderBudgets are monthly request budgets of external providers,
+    // as provider:requests[:cost_per_request]. Under
+    // ProviderBudgetHardLimit a provider past its budget is called less;
+    // otherwise it is only alerted on.
+    ProviderBudgets         []string
+    ProviderBudgetHardLimit bool
+    Cache          appconfig.CacheConfig
+    Mail           appconfig.MailConfig
+    // MailDeliveryInterval is how often queued email is sent
+    MailDeliveryInterval time.Duration
+    // WebhookDeliveryInterval is how often queued webhook events are sent
+    WebhookDeliveryInterval time.Duration
+    // CryptoDayBoundary is where crypto days end: "utc", or "user" for
+    // midnight in each user's timezone
+    CryptoDayBoundary string
+    // TradingHolidays are market closures beyond the built-in calendars,
+    // as asset_class:YYYY-MM-DD
+    TradingHolidays []string
+    // SnapshotInterval is how often portfolio snapshots are refreshed; the
+    // last refresh of each day is its close
+    SnapshotInterval time.Duration
+    // MonitoredPortfolios are the IDs of the portfolios exported with
+    // gauges of their own; the others only count towards the distributions
+    MonitoredPortfolios []string
+    // ConsistencyCheckInterval is how often portfolio values are checked
+    // against their holdings, and ConsistencyTolerance the relative
+    // difference still taken as consistent
+    ConsistencyCheckInterval time.Duration
+    ConsistencyTolerance     float64
+    // Recompute bounds the load of admin-requested recomputes, and
+    // RecomputePollInterval is how often queued ones are picked up
+    Recompute             jobs.RecomputeConfig
+    RecomputePollInterval time.Duration
+    // ExportSigningKey signs data export download URLs, which are valid
+    // for ExportURLTTL. Users get one export per ExportMinInterval, kept
+    // for ExportRetention.
+    ExportSigningKey   string
+    ExportMinInterval  time.Duration
+    ExportRetention    time.Duration
+    ExportURLTTL       time.Duration
+    ExportPollInterval time.Duration
+    // PredictionSubscriptions bounds the off-peak regeneration of
+    // subscribed predictions, run every PredictionSubscriptionInterval
+    PredictionSubscriptions        ml.SubscriptionConfig
+    PredictionSubscriptionInterval time.Duration
+    // PredictionTTL is how long an ensemble prediction is reused before
+    // the models are run again
+    PredictionTTL time.Duration
+    // SubscriptionTiersFile replaces the default subscription tiers; see
+    // config/tiers.example.yaml
+    SubscriptionTiersFile string
+    // PredictionQueue sizes the prediction queue's priority lanes
+    PredictionQueue ml.QueueConfig
 }
 
 func loadConfig() Config {
     return Config{
         Port:        getEnv("PORT", "8080"),
         DatabaseURL: getEnv("DATABASE_URL", "postgresql://localhost:5432/wolfai?sslmode=disable"),
+        DatabaseDriver: getEnv("DATABASE_DRIVER", database.DriverPQ),
+        RedisAddr:   getEnv("REDIS_ADDR", "localhost:6379"),
+        RedisEnabled:        getEnvBool("REDIS_ENABLED", true),
+        RedisDownAfter:      getEnvDuration("REDIS_DOWN_AFTER", cache.DefaultRedisDownAfter),
+        HealthCheckInterval: getEnvDuration("HEALTH_CHECK_INTERVAL", 10*time.Second),
         JWTSecret:   getEnv("JWT_SECRET", "your-secret-key"),
+        AdminEmail:  getEnv("ADMIN_EMAIL", ""),
+        AdminAPISecret: getEnv("ADMIN_API_SECRET", ""),
+        AdminAPIUnsigned: getEnvBool("ADMIN_API_UNSIGNED", false),
+        EncryptionKeys:         getEnv("ENCRYPTION_KEYS", ""),
+        EncryptionKeysFile:     getEnv("ENCRYPTION_KEYS_FILE", ""),
+        EncryptionPrimaryKeyID: getEnv("ENCRYPTION_PRIMARY_KEY_ID", ""),
+        ModelPath:   getEnv("MODEL_PATH", "./models"),
+        LSTMModelPath: getEnv("LSTM_MODEL_PATH", ""),
+        LSTMLazy:               getEnvBool("LSTM_LAZY", false),
+        LSTMWarmUpConcurrency:  getEnvInt("LSTM_WARMUP_CONCURRENCY", 2),
+        EWMAHalfLifeDays: getEnvFloat("EWMA_HALF_LIFE_DAYS", portfolio.DefaultEWMAHalfLifeDays),
+        Artifacts: appconfig.ArtifactStoreConfig{
+            Backend:          getEnv("ARTIFACT_STORE_BACKEND", "local"),
+            Path:             getEnv("ARTIFACT_STORE_PATH", "./models/store"),
+            Endpoint:         getEnv("ARTIFACT_STORE_ENDPOINT", ""),
+            Bucket:           getEnv("ARTIFACT_STORE_BUCKET", ""),
+            Region:           getEnv("ARTIFACT_STORE_REGION", ""),
+            AccessKey:        getEnv("ARTIFACT_STORE_ACCESS_KEY", ""),
+            SecretKey:        getEnv("ARTIFACT_STORE_SECRET_KEY", ""),
+            UseSSL:           getEnv("ARTIFACT_STORE_USE_SSL", "true") == "true",
+            CacheDir:         getEnv("ARTIFACT_CACHE_DIR", "./models/cache"),
+            CacheBudgetBytes: int64(getEnvInt("ARTIFACT_CACHE_BUDGET_MB", 10240)) << 20,
+        },
         RateLimit:   100,
         AllowedOrigins: []string{
             "http://localhost:3000",
             "https://wolfai.com",
         },
+        TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+        MarketSymbol:   getEnv("MARKET_SYMBOL", "SPY"),
+        RiskFreeRate: getEnvFloat("RISK_FREE_RATE", risk.DefaultRiskFreeRate),
+        RiskFreeRateSymbol: getEnv("RISK_FREE_RATE_SYMBOL", ""),
+        PortfolioCurrency: getEnv("PORTFOLIO_CURRENCY", valuation.DefaultCurrency),
+        MaxAnalysisAgeHours: getEnvInt("MAX_ANALYSIS_AGE_HOURS", 24),
+        AnalysisHistoryRetentionHours: getEnvInt("ANALYSIS_HISTORY_RETENTION_HOURS", 0),
+        SamplePolicy:   loadSamplePolicy(),
+        Regime:         loadRegimeConfig(),
+        RegimeVolAlertMultiplier: getEnvFloat("REGIME_VOL_ALERT_MULTIPLIER", 0.75),
+        RiskMonitorDebounce: getEnvDuration("RISK_MONITOR_DEBOUNCE", 30*time.Second),
+        RiskRecalcInterval:  getEnvDuration("RISK_RECALC_INTERVAL", 15*time.Minute),
+        SentimentCalibrationInterval: getEnvDuration("SENTIMENT_CALIBRATION_INTERVAL", 24*time.Hour),
+        SentimentCalibrationLookback: getEnvDuration("SENTIMENT_CALIBRATION_LOOKBACK", 90*24*time.Hour),
+        MaxExpectedShortfall: getEnvFloat("MAX_EXPECTED_SHORTFALL", risk.DefaultMaxExpectedShortfall),
+        PortfolioSectionBudget: getEnvDuration("PORTFOLIO_SECTION_BUDGET", degrade.DefaultBudget),
+        MaxPositionValueUSD:    getEnvFloat("MAX_POSITION_VALUE_USD", 1000000),
+        MaxPortfolioValueUSD:   getEnvFloat("MAX_PORTFOLIO_VALUE_USD", 10000000),
+        MarketData: appconfig.MarketDataConfig{
+            Provider:         getEnv("MARKET_DATA_PROVIDER", "alphavantage"),
+            APIKey:           getEnv("MARKET_DATA_API_KEY", ""),
+            UpdateInterval:   getEnvDuration("MARKET_DATA_UPDATE_INTERVAL", 5*time.Minute),
+            CollectionJitter: getEnvDuration("MARKET_DATA_COLLECTION_JITTER", market.DefaultCollectionJitter),
+            Symbols:          getEnvList("MARKET_SYMBOLS", []string{"BTC", "ETH", "SPY"}),
+            DegradedSymbols:  getEnvList("MARKET_DATA_DEGRADED_SYMBOLS", nil),
+            DegradedInterval: getEnvDuration("MARKET_DATA_DEGRADED_INTERVAL", time.Hour),
+        },
+        ProviderBudgets:         getEnvList("PROVIDER_BUDGETS", nil),
+        ProviderBudgetHardLimit: getEnvBool("PROVIDER_BUDGET_HARD_LIMIT", true),
+        Cache: appconfig.CacheConfig{
+            TTL:                  getEnvDuration("CACHE_TTL", 5*time.Minute),
+            PrefetchTimeout:      getEnvDuration("CACHE_PREFETCH_TIMEOUT", 30*time.Second),
+            MaxNamespaceMemoryMB: getEnvFloat("CACHE_MAX_NAMESPACE_MEMORY_MB", 256),
+            LocalSize:            getEnvInt("CACHE_LOCAL_SIZE", 1024),
+            LocalTTL:             getEnvDuration("CACHE_LOCAL_TTL", cache.DefaultLocalTTL),
+            FlushConfirmAbove:    getEnvInt("CACHE_FLUSH_CONFIRM_ABOVE", cache.DefaultFlushConfirmAbove),
+        },
+        Mail: appconfig.MailConfig{
+            Host:                 getEnv("MAIL_HOST", ""),
+            Port:                 getEnvInt("MAIL_PORT", 587),
+            Username:             getEnv("MAIL_USERNAME", ""),
+            Password:             getEnv("MAIL_PASSWORD", ""),
+            From:                 getEnv("MAIL_FROM", "noreply@wolfai.com"),
+            TLS:                  getEnv("MAIL_TLS", "starttls"),
+            DevDir:               getEnv("MAIL_DEV_DIR", ""),
+            MaxAttempts:          getEnvInt("MAIL_MAX_ATTEMPTS", 5),
+            RecipientHourlyLimit: getEnvInt("MAIL_RECIPIENT_HOURLY_LIMIT", 10),
+        },
+        MailDeliveryInterval:     getEnvDuration("MAIL_DELIVERY_INTERVAL", 30*time.Second),
+        WebhookDeliveryInterval:  getEnvDuration("WEBHOOK_DELIVERY_INTERVAL", 30*time.Second),
+        CryptoDayBoundary:        getEnv("CRYPTO_DAY_BOUNDARY", "utc"),
+        TradingHolidays:          getEnvList("TRADING_HOLIDAYS", nil),
+        SnapshotInterval:         getEnvDuration("SNAPSHOT_INTERVAL", 15*time.Minute),
+        MonitoredPortfolios:      getEnvList("MONITORED_PORTFOLIOS", nil),
+        ConsistencyCheckInterval: getEnvDuration("CONSISTENCY_CHECK_INTERVAL", time.Hour),
+        ConsistencyTolerance:     getEnvFloat("CONSISTENCY_TOLERANCE", portfolio.DefaultConsistencyTolerance),
+        Recompute: jobs.RecomputeConfig{
+            BatchSize:     getEnvInt("RECOMPUTE_BATCH_SIZE", 50),
+            DutyCycle:     getEnvFloat("RECOMPUTE_DUTY_CYCLE", 0.5),
+            MaxConnsInUse: getEnvInt("RECOMPUTE_MAX_CONNS_IN_USE", 0),
+        },
+        RecomputePollInterval: getEnvDuration("RECOMPUTE_POLL_INTERVAL", 30*time.Second),
+        ExportSigningKey:      getEnv("EXPORT_SIGNING_KEY", getEnv("JWT_SECRET", "your-secret-key")),
+        ExportMinInterval:     getEnvDuration("EXPORT_MIN_INTERVAL", 24*time.Hour),
+        ExportRetention:       getEnvDuration("EXPORT_RETENTION", 7*24*time.Hour),
+        ExportURLTTL:          getEnvDuration("EXPORT_URL_TTL", 24*time.Hour),
+        ExportPollInterval:    getEnvDuration("EXPORT_POLL_INTERVAL", 30*time.Second),
+        PredictionSubscriptions: ml.SubscriptionConfig{
+            BatchSize:        getEnvInt("PREDICTION_SUBSCRIPTION_BATCH_SIZE", 100),
+            OffPeakStartHour: getEnvInt("PREDICTION_SUBSCRIPTION_OFF_PEAK_START", 1),
+            OffPeakEndHour:   getEnvInt("PREDICTION_SUBSCRIPTION_OFF_PEAK_END", 6),
+            NotifyAfter:      getEnvInt("PREDICTION_SUBSCRIPTION_NOTIFY_AFTER", 3),
+            ManageURL:        getEnv("PREDICTION_SUBSCRIPTIONS_URL", "https://wolfai.com/predictions/subscriptions"),
+        },
+        PredictionSubscriptionInterval: getEnvDuration("PREDICTION_SUBSCRIPTION_INTERVAL", 5*time.Minute),
+        PredictionTTL:                  getEnvDuration("PREDICTION_TTL", ml.DefaultPredictionTTL),
+        SubscriptionTiersFile:          getEnv("SUBSCRIPTION_TIERS_FILE", ""),
+        PredictionQueue: ml.QueueConfig{
+            Capacity:         getEnvInt("PREDICTION_QUEUE_CAPACITY", 1000),
+            InteractiveShare: getEnvFloat("PREDICTION_INTERACTIVE_SHARE", 0.25),
+            BulkCapacity:     getEnvInt("PREDICTION_BULK_QUEUE_CAPACITY", 500),
+        },
     }
 }
 
@@ -139,4 +1082,213 @@ func getEnv(key, fallback string) string {
         return value
     }
     return fallback
-}
\ No newline at end of file
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+    if value, exists := os.LookupEnv(key); exists {
+        if d, err := time.ParseDuration(value); err == nil {
+            return d
+        }
+    }
+    return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+    if value, exists := os.LookupEnv(key); exists {
+        if b, err := strconv.ParseBool(value); err == nil {
+            return b
+        }
+    }
+    return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+    if value, exists := os.LookupEnv(key); exists {
+        if n, err := strconv.Atoi(value); err == nil {
+            return n
+        }
+    }
+    return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+    if value, exists := os.LookupEnv(key); exists {
+        if f, err := strconv.ParseFloat(value, 64); err == nil {
+            return f
+        }
+    }
+    return fallback
+}
+
+func getEnvList(key string, fallback []string) []string {
+    if value, exists := os.LookupEnv(key); exists && value != "" {
+        return strings.Split(value, ",")
+    }
+    return fallback
+}
+
+// loadRegimeConfig overrides the default regime windows from the
+// environment
+func loadRegimeConfig() regime.Config {
+    c := regime.DefaultConfig()
+    c.ADXWindow = getEnvInt("REGIME_ADX_WINDOW", c.ADXWindow)
+    c.VolWindow = getEnvInt("REGIME_VOL_WINDOW", c.VolWindow)
+    c.VolLookback = getEnvInt("REGIME_VOL_LOOKBACK", c.VolLookback)
+    c.MAWindow = getEnvInt("REGIME_MA_WINDOW", c.MAWindow)
+    c.SlopeLookback = getEnvInt("REGIME_SLOPE_LOOKBACK", c.SlopeLookback)
+    c.HighVolPercentile = getEnvFloat("REGIME_HIGH_VOL_PERCENTILE", c.HighVolPercentile)
+    return c
+}
+
+// loadSamplePolicy overrides the default minimum observations of each
+// statistic from MIN_OBSERVATIONS_<METRIC>, such as
+// MIN_OBSERVATIONS_SHARPE_RATIO
+func loadSamplePolicy() sample.Policy {
+    policy := sample.DefaultPolicy()
+    for metric, required := range policy {
+        policy[metric] = getEnvInt("MIN_OBSERVATIONS_"+strings.ToUpper(string(metric)), required)
+    }
+    return policy
+}
+
+// envChecker collects the Violations of the config loadConfig reads, each
+// at the environment variable it was read from
+type envChecker struct {
+    violations []appconfig.Violation
+}
+
+func (c *envChecker) addf(env, format string, args ...interface{}) {
+    c.violations = append(c.violations, appconfig.Violation{Path: env, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *envChecker) positive(env string, d time.Duration) {
+    if d <= 0 {
+        c.addf(env, "must be positive, got %v", d)
+    }
+}
+
+// section adds the violations of a section's Validate error. Their YAML
+// paths become prefix followed by the path upper-cased, unless renamed
+// lists the variable a path is read from.
+func (c *envChecker) section(prefix string, err error, renamed map[string]string) {
+    var verr *appconfig.ValidationError
+    if !errors.As(err, &verr) {
+        if err != nil {
+            c.addf(strings.TrimSuffix(prefix, "_"), "%v", err)
+        }
+        return
+    }
+    for _, v := range verr.Violations {
+        env, ok := renamed[v.Path]
+        if !ok {
+            env = prefix + strings.ToUpper(v.Path)
+        }
+        c.violations = append(c.violations, appconfig.Violation{Path: env, Message: v.Message})
+    }
+}
+
+// Validate checks the config the server runs with, returning a
+// *appconfig.ValidationError listing every problem at once. The sections
+// shared with the config file are checked as -check-config checks them.
+func (c Config) Validate() error {
+    var check envChecker
+
+    if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+        check.addf("PORT", "must be between 1 and 65535, got %q", c.Port)
+    }
+    if c.DatabaseURL == "" {
+        check.addf("DATABASE_URL", "is required")
+    }
+    if c.RedisEnabled && c.RedisAddr == "" {
+        check.addf("REDIS_ADDR", "is required unless REDIS_ENABLED is false")
+    }
+    if c.JWTSecret == "" {
+        check.addf("JWT_SECRET", "is required")
+    }
+    // Admin routes are only served unsigned when development explicitly
+    // asks for it
+    if c.AdminAPISecret == "" && !c.AdminAPIUnsigned {
+        check.addf("ADMIN_API_SECRET", "is required; set ADMIN_API_UNSIGNED=true to serve admin routes unsigned in development")
+    }
+    if c.ModelPath == "" {
+        check.addf("MODEL_PATH", "is required")
+    }
+    if c.EWMAHalfLifeDays <= 0 {
+        check.addf("EWMA_HALF_LIFE_DAYS", "must be positive, got %v", c.EWMAHalfLifeDays)
+    }
+    if c.MarketSymbol == "" {
+        check.addf("MARKET_SYMBOL", "is required")
+    }
+    if c.MaxAnalysisAgeHours <= 0 {
+        check.addf("MAX_ANALYSIS_AGE_HOURS", "must be positive, got %d", c.MaxAnalysisAgeHours)
+    }
+    if c.AnalysisHistoryRetentionHours < 0 {
+        check.addf("ANALYSIS_HISTORY_RETENTION_HOURS", "must be non-negative, got %d", c.AnalysisHistoryRetentionHours)
+    }
+    if c.MaxPositionValueUSD < 0 {
+        check.addf("MAX_POSITION_VALUE_USD", "must be non-negative, got %v", c.MaxPositionValueUSD)
+    }
+    if c.MaxPortfolioValueUSD < 0 {
+        check.addf("MAX_PORTFOLIO_VALUE_USD", "must be non-negative, got %v", c.MaxPortfolioValueUSD)
+    }
+    if c.MaxPositionValueUSD > 0 && c.MaxPortfolioValueUSD > 0 && c.MaxPositionValueUSD > c.MaxPortfolioValueUSD {
+        check.addf("MAX_POSITION_VALUE_USD", "must not exceed MAX_PORTFOLIO_VALUE_USD (%v), got %v", c.MaxPortfolioValueUSD, c.MaxPositionValueUSD)
+    }
+    if c.CryptoDayBoundary != "utc" && c.CryptoDayBoundary != "user" {
+        check.addf("CRYPTO_DAY_BOUNDARY", "must be one of utc, user, got %q", c.CryptoDayBoundary)
+    }
+
+    // Jobs tick on these, and a ticker can't tick every 0s
+    for env, d := range map[string]time.Duration{
+        "HEALTH_CHECK_INTERVAL":            c.HealthCheckInterval,
+        "RISK_RECALC_INTERVAL":             c.RiskRecalcInterval,
+        "SENTIMENT_CALIBRATION_INTERVAL":   c.SentimentCalibrationInterval,
+        "MAIL_DELIVERY_INTERVAL":           c.MailDeliveryInterval,
+        "WEBHOOK_DELIVERY_INTERVAL":        c.WebhookDeliveryInterval,
+        "SNAPSHOT_INTERVAL":                c.SnapshotInterval,
+        "CONSISTENCY_CHECK_INTERVAL":       c.ConsistencyCheckInterval,
+        "RECOMPUTE_POLL_INTERVAL":          c.RecomputePollInterval,
+        "EXPORT_POLL_INTERVAL":             c.ExportPollInterval,
+        "PREDICTION_SUBSCRIPTION_INTERVAL": c.PredictionSubscriptionInterval,
+    } {
+        check.positive(env, d)
+    }
+
+    check.section("ARTIFACT_STORE_", c.Artifacts.Validate(), map[string]string{"cache_budget_bytes": "ARTIFACT_CACHE_BUDGET_MB"})
+    check.section("MARKET_DATA_", c.MarketData.Validate(), map[string]string{"symbols": "MARKET_SYMBOLS"})
+    check.section("CACHE_", c.Cache.Validate(), nil)
+    check.section("MAIL_", c.Mail.Validate(), nil)
+
+    if len(check.violations) == 0 {
+        return nil
+    }
+    // Sorted by variable, as the intervals are checked in no set order
+    sort.SliceStable(check.violations, func(i, j int) bool { return check.violations[i].Path < check.violations[j].Path })
+    return &appconfig.ValidationError{Violations: check.violations}
+}
+
+// checkConfig loads the config file at path and prints the effective
+// config, after defaults and environment overrides, with its secrets
+// masked, followed by every problem found. It returns the exit code, 0 when
+// the config is valid.
+func checkConfig(path string, w io.Writer) int {
+    cfg, err := appconfig.Parse(path)
+    if err != nil {
+        fmt.Fprintf(w, "Failed to load %s: %v\n", path, err)
+        return 1
+    }
+
+    report, err := yaml.Marshal(cfg.Redacted())
+    if err != nil {
+        fmt.Fprintf(w, "Failed to render %s: %v\n", path, err)
+        return 1
+    }
+    fmt.Fprintf(w, "# Effective config from %s, secrets masked\n%s", path, report)
+
+    if err := cfg.Validate(); err != nil {
+        fmt.Fprintf(w, "\n%v\n", err)
+        return 1
+    }
+    fmt.Fprintln(w, "\nConfig is valid")
+    return 0
+}
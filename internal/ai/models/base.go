@@ -2,6 +2,10 @@ package models
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
@@ -101,6 +105,99 @@ type ModelConfig struct {
 	EarlyStopPatience int
 }
 
+// ErrNotTrained is returned by Predict before a model has been trained
+var ErrNotTrained = errors.New("model not trained")
+
+// ErrInsufficientData is returned for training data or a price history too
+// short for a model
+var ErrInsufficientData = errors.New("insufficient data")
+
+// ErrInvalidValidationData is returned by Validate for missing predictions
+// or predictions that don't line up with their actuals
+var ErrInvalidValidationData = errors.New("invalid validation data")
+
+// GetConfidence implements the Model interface, returning the directional
+// accuracy of the last validation or training run
+func (m *BaseModel) GetConfidence() float64 {
+	return m.confidence
+}
+
+// Validate implements the Model interface for models embedding BaseModel.
+// Each prediction is scored by the midpoint of its predicted range against
+// the actual price, and traded by going long when that midpoint is above
+// the previous actual price and short otherwise.
+func (m *BaseModel) Validate(ctx context.Context, data *ValidationData) (*ValidationResults, error) {
+	if data == nil || len(data.Predictions) == 0 {
+		return nil, fmt.Errorf("%w: no predictions", ErrInvalidValidationData)
+	}
+	if len(data.Predictions) != len(data.Actuals) {
+		return nil, fmt.Errorf("%w: %d predictions for %d actuals", ErrInvalidValidationData, len(data.Predictions), len(data.Actuals))
+	}
+
+	midpoints := make([]float64, len(data.Predictions))
+	for i, p := range data.Predictions {
+		if p == nil {
+			return nil, fmt.Errorf("%w: prediction %d is missing", ErrInvalidValidationData, i)
+		}
+		midpoints[i] = (p.PredictedHigh + p.PredictedLow) / 2
+	}
+
+	results := &ValidationResults{
+		RMSE:     CalculateRMSE(midpoints, data.Actuals),
+		MAE:      CalculateMAE(midpoints, data.Actuals),
+		Accuracy: CalculateDirectionalAccuracy(midpoints, data.Actuals),
+	}
+
+	var pnl []float64
+	var wins int
+	var grossProfit, grossLoss float64
+	equity, peak := 1.0, 1.0
+	for i := 1; i < len(data.Actuals); i++ {
+		prev := data.Actuals[i-1]
+		if prev == 0 {
+			continue
+		}
+		r := (data.Actuals[i] - prev) / prev
+		if midpoints[i] < prev {
+			r = -r
+		}
+		pnl = append(pnl, r)
+		if r > 0 {
+			wins++
+			grossProfit += r
+		} else {
+			grossLoss -= r
+		}
+
+		equity *= 1 + r
+		peak = math.Max(peak, equity)
+		results.MaxDrawdown = math.Max(results.MaxDrawdown, (peak-equity)/peak)
+	}
+
+	if len(pnl) > 0 {
+		results.WinRate = float64(wins) / float64(len(pnl))
+		var mean, variance float64
+		for _, r := range pnl {
+			mean += r
+		}
+		mean /= float64(len(pnl))
+		for _, r := range pnl {
+			variance += (r - mean) * (r - mean)
+		}
+		if sd := math.Sqrt(variance / float64(len(pnl))); sd > 0 {
+			results.SharpeRatio = mean / sd
+		}
+	}
+	// Without losing trades the profit factor is unbounded, and left at 0
+	if grossLoss > 0 {
+		results.ProfitFactor = grossProfit / grossLoss
+	}
+
+	m.metrics = *results
+	m.confidence = results.Accuracy
+	return results, nil
+}
+
 // Common utility functions for all models
 
 // CalculateRMSE calculates Root Mean Square Error
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// minCalibrationSamples is the fewest joined observations a source needs
+// before its weight is recalibrated
+const minCalibrationSamples = 10
+
+// sentimentSample pairs a sentiment reading with the return that followed
+type sentimentSample struct {
+	sentiment float64
+	nextDay   float64
+}
+
+// sourceCalibration is the calibrated result for one source
+type sourceCalibration struct {
+	weight      float64
+	correlation float64
+	samples     int
+}
+
+// CalibrateSourceWeights recalculates source weights from the outcomes
+// recorded within lookback. Each source is weighted by the absolute
+// correlation between its sentiment and the next-day return, scaled so the
+// most predictive source has weight 1. Sources without enough data keep
+// their current weight. The new weights are persisted before being applied.
+func (m *SentimentModel) CalibrateSourceWeights(ctx context.Context, db *sql.DB, lookback time.Duration) error {
+	query := `
+		SELECT a.source, a.sentiment, o.next_day_return
+		FROM prediction_outcomes o
+		JOIN market_analysis a
+			ON a.asset_symbol = o.asset_symbol
+			AND a.source = o.source
+			AND date_trunc('day', a.updated_at) = date_trunc('day', o.observed_at)
+		WHERE o.observed_at >= $1 AND o.next_day_return IS NOT NULL
+	`
+	rows, err := db.QueryContext(ctx, query, time.Now().Add(-lookback))
+	if err != nil {
+		return fmt.Errorf("failed to query prediction outcomes: %w", err)
+	}
+	defer rows.Close()
+
+	samples := make(map[string][]sentimentSample)
+	for rows.Next() {
+		var source string
+		var s sentimentSample
+		if err := rows.Scan(&source, &s.sentiment, &s.nextDay); err != nil {
+			return fmt.Errorf("failed to scan prediction outcome: %w", err)
+		}
+		samples[source] = append(samples[source], s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read prediction outcomes: %w", err)
+	}
+
+	calibrations := calibrateSources(samples)
+	if len(calibrations) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for source, c := range calibrations {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO sentiment_source_calibrations (source, weight, correlation, sample_count, calibrated_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (source) DO UPDATE SET
+				weight = EXCLUDED.weight,
+				correlation = EXCLUDED.correlation,
+				sample_count = EXCLUDED.sample_count,
+				calibrated_at = EXCLUDED.calibrated_at
+		`, source, c.weight, c.correlation, c.samples, now)
+		if err != nil {
+			return fmt.Errorf("failed to save calibration for %s: %w", source, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for source, c := range calibrations {
+		m.sourceWeights[source] = c.weight
+	}
+	m.LastCalibratedAt = now
+	m.mu.Unlock()
+
+	return nil
+}
+
+// loadSourceWeights replaces the current weights with the last persisted
+// calibration, if any
+func (m *SentimentModel) loadSourceWeights(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT source, weight, calibrated_at FROM sentiment_source_calibrations
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	weights := make(map[string]float64)
+	var latest time.Time
+	for rows.Next() {
+		var source string
+		var weight float64
+		var calibratedAt time.Time
+		if err := rows.Scan(&source, &weight, &calibratedAt); err != nil {
+			return err
+		}
+		weights[source] = weight
+		if calibratedAt.After(latest) {
+			latest = calibratedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for source, weight := range weights {
+		m.sourceWeights[source] = weight
+	}
+	m.LastCalibratedAt = latest
+	return nil
+}
+
+// SourceWeight returns the current weight for source
+func (m *SentimentModel) SourceWeight(source string) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sourceWeights[source]
+}
+
+// calibrateSources computes weights for every source with enough samples
+// and a defined correlation, normalised so the largest weight is 1
+func calibrateSources(samples map[string][]sentimentSample) map[string]sourceCalibration {
+	calibrations := make(map[string]sourceCalibration)
+	maxAbs := 0.0
+	for source, s := range samples {
+		if len(s) < minCalibrationSamples {
+			continue
+		}
+		r, ok := correlation(s)
+		if !ok {
+			continue
+		}
+		calibrations[source] = sourceCalibration{correlation: r, samples: len(s)}
+		maxAbs = math.Max(maxAbs, math.Abs(r))
+	}
+
+	if maxAbs == 0 {
+		return nil
+	}
+	for source, c := range calibrations {
+		c.weight = math.Abs(c.correlation) / maxAbs
+		calibrations[source] = c
+	}
+	return calibrations
+}
+
+// correlation returns the Pearson correlation of sentiment and next-day
+// return, or false if either series has no variance
+func correlation(samples []sentimentSample) (float64, bool) {
+	n := float64(len(samples))
+	var sumX, sumY float64
+	for _, s := range samples {
+		sumX += s.sentiment
+		sumY += s.nextDay
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, varX, varY float64
+	for _, s := range samples {
+		dx, dy := s.sentiment-meanX, s.nextDay-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+
+	r := cov / math.Sqrt(varX*varY)
+	// Guard against rounding pushing a perfect correlation past 1
+	return math.Max(-1, math.Min(1, r)), true
+}
+
+func copyWeights(weights map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(weights))
+	for k, v := range weights {
+		c[k] = v
+	}
+	return c
+}
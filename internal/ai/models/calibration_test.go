@@ -0,0 +1,80 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalibrateSources(t *testing.T) {
+	var perfect, noisy, short []sentimentSample
+	noise := []float64{0.03, -0.05, 0.01, 0.04, -0.02, 0.05, -0.04, 0.02, -0.01, 0.03}
+	for i := 0; i < 10; i++ {
+		sentiment := float64(i)/5 - 1
+		perfect = append(perfect, sentimentSample{sentiment: sentiment, nextDay: 0.02 * sentiment})
+		noisy = append(noisy, sentimentSample{sentiment: sentiment, nextDay: 0.01*sentiment + noise[i]})
+	}
+	short = perfect[:3]
+
+	calibrations := calibrateSources(map[string][]sentimentSample{
+		"news":    perfect,
+		"twitter": noisy,
+		"reddit":  short,
+	})
+
+	assert.Contains(t, calibrations, "news")
+	assert.InDelta(t, 1.0, calibrations["news"].weight, 1e-9)
+	assert.InDelta(t, 1.0, calibrations["news"].correlation, 1e-9)
+	assert.Equal(t, 10, calibrations["news"].samples)
+
+	assert.Contains(t, calibrations, "twitter")
+	assert.Less(t, calibrations["twitter"].weight, 1.0)
+	assert.Greater(t, calibrations["twitter"].weight, 0.0)
+
+	assert.NotContains(t, calibrations, "reddit")
+}
+
+func TestCalibrateSources_NegativeCorrelation(t *testing.T) {
+	var inverse []sentimentSample
+	for i := 0; i < 10; i++ {
+		sentiment := float64(i)/5 - 1
+		inverse = append(inverse, sentimentSample{sentiment: sentiment, nextDay: -0.01 * sentiment})
+	}
+
+	calibrations := calibrateSources(map[string][]sentimentSample{"news": inverse})
+	assert.InDelta(t, 1.0, calibrations["news"].weight, 1e-9)
+	assert.InDelta(t, -1.0, calibrations["news"].correlation, 1e-9)
+}
+
+func TestCorrelation_NoVariance(t *testing.T) {
+	flat := []sentimentSample{{0.5, 0.01}, {0.5, 0.02}, {0.5, -0.01}}
+	_, ok := correlation(flat)
+	assert.False(t, ok)
+}
+
+func TestNewSentimentModel_LoadsCalibration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	calibratedAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT source, weight, calibrated_at FROM sentiment_source_calibrations").
+		WillReturnRows(sqlmock.NewRows([]string{"source", "weight", "calibrated_at"}).
+			AddRow("news", 0.4, calibratedAt))
+	m, err := NewSentimentModel(ModelConfig{}, db)
+	require.NoError(t, err)
+	assert.Equal(t, 0.4, m.sourceWeights["news"])
+	assert.Equal(t, calibratedAt, m.LastCalibratedAt)
+
+	// A calibration that can't be read isn't replaced by the defaults
+	// without anyone knowing
+	mock.ExpectQuery("SELECT source, weight, calibrated_at FROM sentiment_source_calibrations").
+		WillReturnError(errors.New("relation does not exist"))
+	_, err = NewSentimentModel(ModelConfig{}, db)
+	assert.ErrorContains(t, err, "relation does not exist")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
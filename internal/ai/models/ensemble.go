@@ -2,7 +2,9 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"math"
+	"sort"
 	"sync"
 
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
@@ -57,6 +59,16 @@ func (m *EnsembleModel) AddModel(model Model, id string, weight float64) {
 	m.modelScores[id] = 1.0 // Initial score
 }
 
+// Train implements the Model interface, training each model in turn
+func (m *EnsembleModel) Train(ctx context.Context, data *TrainingData) error {
+	for i, model := range m.models {
+		if err := model.Train(ctx, data); err != nil {
+			return fmt.Errorf("failed to train %s: %w", m.getModelID(i), err)
+		}
+	}
+	return nil
+}
+
 // Predict generates ensemble predictions
 func (m *EnsembleModel) Predict(ctx context.Context, input *PredictionInput) (*PredictionOutput, error) {
 	var (
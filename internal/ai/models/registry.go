@@ -0,0 +1,66 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the natively built models. Transformer is only registered in
+// builds with the gorgonia tag; Ridge is registered in every build.
+const (
+	Ridge       = "ridge"
+	Transformer = "transformer"
+)
+
+// ErrUnknownModel is returned by New for a name no model registered under
+var ErrUnknownModel = errors.New("unknown model")
+
+// Factory builds a model from its configuration
+type Factory func(config ModelConfig) Model
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a model available to New under name. Models register
+// themselves from init, so which are available depends on the build tags.
+// It panics if name is registered twice.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("models: Register called twice for model " + name)
+	}
+	factories[name] = factory
+}
+
+// New builds the model registered under name
+func New(name string, config ModelConfig) (Model, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		err := fmt.Errorf("%w %q, registered: %s", ErrUnknownModel, name, strings.Join(Registered(), ", "))
+		if name == Transformer {
+			err = fmt.Errorf("%w (build with -tags gorgonia)", err)
+		}
+		return nil, err
+	}
+	return factory(config), nil
+}
+
+// Registered returns the names of the registered models, sorted
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	// The ridge model is registered in every build
+	assert.Contains(t, Registered(), Ridge)
+	model, err := New(Ridge, ModelConfig{})
+	if assert.NoError(t, err) {
+		assert.IsType(t, &RidgeModel{}, model)
+	}
+
+	_, err = New("prophet", ModelConfig{})
+	assert.True(t, errors.Is(err, ErrUnknownModel))
+
+	assert.Panics(t, func() {
+		Register(Ridge, func(ModelConfig) Model { return NewRidgeModel(ModelConfig{}) })
+	})
+}
+
+// TestModels_ValidateContract holds every registered model, whichever
+// build tags registered it, to the same Validate contract
+func TestModels_ValidateContract(t *testing.T) {
+	actuals := []float64{100, 102, 101, 104, 103, 107, 105, 108}
+	exact := make([]*PredictionOutput, len(actuals))
+	for i, a := range actuals {
+		exact[i] = &PredictionOutput{PredictedHigh: a + 1, PredictedLow: a - 1}
+	}
+
+	for _, name := range Registered() {
+		t.Run(name, func(t *testing.T) {
+			model, err := New(name, ModelConfig{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			ctx := context.Background()
+
+			invalid := []*ValidationData{
+				nil,
+				{},
+				{Predictions: exact, Actuals: actuals[:3]},
+				{Predictions: []*PredictionOutput{exact[0], nil}, Actuals: actuals[:2]},
+			}
+			for _, data := range invalid {
+				_, err := model.Validate(ctx, data)
+				assert.True(t, errors.Is(err, ErrInvalidValidationData), "%v", err)
+			}
+
+			// Predictions centred on the actual prices are exact and trade
+			// every move the right way
+			results, err := model.Validate(ctx, &ValidationData{Predictions: exact, Actuals: actuals})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.InDelta(t, 0, results.RMSE, 1e-9)
+			assert.InDelta(t, 0, results.MAE, 1e-9)
+			assert.Equal(t, 1.0, results.Accuracy)
+			assert.Equal(t, 1.0, results.WinRate)
+			assert.Equal(t, 0.0, results.MaxDrawdown)
+			assert.Greater(t, results.SharpeRatio, 0.0)
+			assert.Equal(t, 1.0, model.GetConfidence())
+
+			// Flat predictions never call a rise, so only the three falls are
+			// called right, and trade three of the seven moves wrong
+			flat := make([]*PredictionOutput, len(actuals))
+			for i := range flat {
+				flat[i] = &PredictionOutput{PredictedHigh: 104, PredictedLow: 104}
+			}
+			results, err = model.Validate(ctx, &ValidationData{Predictions: flat, Actuals: actuals})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.GreaterOrEqual(t, results.RMSE, results.MAE)
+			assert.InDelta(t, 3.0/7, results.Accuracy, 1e-9)
+			assert.InDelta(t, 4.0/7, results.WinRate, 1e-9)
+			assert.Greater(t, results.MaxDrawdown, 0.0)
+			assert.Greater(t, results.ProfitFactor, 0.0)
+			assert.InDelta(t, 3.0/7, model.GetConfidence(), 1e-9)
+		})
+	}
+}
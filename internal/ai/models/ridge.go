@@ -0,0 +1,314 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"gonum.org/v1/gonum/mat"
+)
+
+func init() {
+	Register(Ridge, func(config ModelConfig) Model {
+		return NewRidgeModel(config)
+	})
+}
+
+const (
+	// ridgeLookback is the price history behind each feature vector
+	ridgeLookback = 20
+	// minRidgeSamples is the fewest feature vectors Train fits on
+	minRidgeSamples = 30
+	// DefaultRidgePenalty is the L2 penalty on the standardized features
+	DefaultRidgePenalty = 1.0
+	// supportResistanceWindow is the window of the levels Predict reports
+	supportResistanceWindow = 5
+)
+
+// ridgeFeatures names the indicator features RidgeModel regresses on
+var ridgeFeatures = []string{"return_1", "return_5", "sma_20_gap", "rsi_14", "volatility_10", "volume_20_ratio"}
+
+// RidgeModel is a ridge regression of the return over the prediction
+// horizon on indicator features of the price history. It needs no build
+// tag and trains in one linear solve, giving a default build a baseline
+// native model.
+type RidgeModel struct {
+	BaseModel
+
+	penalty float64
+	horizon int
+
+	// means and scales standardize the features before the coefficients
+	// apply
+	means        []float64
+	scales       []float64
+	coefficients []float64
+	intercept    float64
+	// residual is the standard deviation of the training residuals, the
+	// half-width of the predicted range as a return
+	residual float64
+	trained  bool
+}
+
+// NewRidgeModel creates an untrained ridge model predicting
+// config.PredictionSteps prices ahead, or one when that isn't set
+func NewRidgeModel(config ModelConfig) *RidgeModel {
+	horizon := config.PredictionSteps
+	if horizon < 1 {
+		horizon = 1
+	}
+	return &RidgeModel{
+		BaseModel: BaseModel{config: config},
+		penalty:   DefaultRidgePenalty,
+		horizon:   horizon,
+	}
+}
+
+// WithPenalty sets the L2 penalty of the coefficients
+func (m *RidgeModel) WithPenalty(penalty float64) *RidgeModel {
+	m.penalty = penalty
+	return m
+}
+
+// Train implements the Model interface. Each price is labelled with its
+// label in data.Labels, the future price, or without labels with the
+// price the horizon ahead.
+func (m *RidgeModel) Train(ctx context.Context, data *TrainingData) error {
+	if data == nil {
+		return fmt.Errorf("%w: no training data", ErrInsufficientData)
+	}
+	if len(data.Labels) > 0 && len(data.Labels) != len(data.Prices) {
+		return fmt.Errorf("%w: %d labels for %d prices", ErrInsufficientData, len(data.Labels), len(data.Prices))
+	}
+
+	var features [][]float64
+	var targets []float64
+	for i := ridgeLookback; i < len(data.Prices); i++ {
+		if data.Prices[i] == 0 {
+			continue
+		}
+		var future float64
+		switch {
+		case len(data.Labels) > 0:
+			future = data.Labels[i]
+		case i+m.horizon < len(data.Prices):
+			future = data.Prices[i+m.horizon]
+		default:
+			continue
+		}
+		x, ok := indicatorFeatures(data.Prices, data.Volumes, i)
+		if !ok {
+			continue
+		}
+		features = append(features, x)
+		targets = append(targets, future/data.Prices[i]-1)
+	}
+	if len(features) < minRidgeSamples {
+		return fmt.Errorf("%w: %d training samples, %d needed", ErrInsufficientData, len(features), minRidgeSamples)
+	}
+
+	k := len(ridgeFeatures)
+	n := float64(len(features))
+	means := make([]float64, k)
+	scales := make([]float64, k)
+	for _, x := range features {
+		for j, v := range x {
+			means[j] += v / n
+		}
+	}
+	for _, x := range features {
+		for j, v := range x {
+			scales[j] += (v - means[j]) * (v - means[j]) / n
+		}
+	}
+	for j := range scales {
+		scales[j] = math.Sqrt(scales[j])
+		// A constant feature, such as volume when there is none, is
+		// standardized to zero rather than divided by zero
+		if scales[j] == 0 {
+			scales[j] = 1
+		}
+	}
+
+	var meanTarget float64
+	for _, y := range targets {
+		meanTarget += y / n
+	}
+
+	// Solve (ZᵀZ + λI)β = Zᵀy on the standardized features Z and the
+	// centred targets, leaving the intercept unpenalized
+	gram := mat.NewSymDense(k, nil)
+	moments := mat.NewVecDense(k, nil)
+	z := make([]float64, k)
+	for i, x := range features {
+		for j := range x {
+			z[j] = (x[j] - means[j]) / scales[j]
+		}
+		for a := 0; a < k; a++ {
+			moments.SetVec(a, moments.AtVec(a)+z[a]*(targets[i]-meanTarget))
+			for b := a; b < k; b++ {
+				gram.SetSym(a, b, gram.At(a, b)+z[a]*z[b])
+			}
+		}
+	}
+	for j := 0; j < k; j++ {
+		gram.SetSym(j, j, gram.At(j, j)+m.penalty)
+	}
+
+	var chol mat.Cholesky
+	if ok := chol.Factorize(gram); !ok {
+		return fmt.Errorf("ridge system is not positive definite, penalty %v", m.penalty)
+	}
+	var beta mat.VecDense
+	if err := chol.SolveVecTo(&beta, moments); err != nil {
+		return fmt.Errorf("failed to solve ridge system: %w", err)
+	}
+
+	m.means = means
+	m.scales = scales
+	m.coefficients = make([]float64, k)
+	for j := range m.coefficients {
+		m.coefficients[j] = beta.AtVec(j)
+	}
+	m.intercept = meanTarget
+
+	var squares float64
+	var hits int
+	for i, x := range features {
+		predicted := m.predictReturn(x)
+		squares += (targets[i] - predicted) * (targets[i] - predicted)
+		if (predicted > 0) == (targets[i] > 0) {
+			hits++
+		}
+	}
+	m.residual = math.Sqrt(squares / n)
+	m.confidence = float64(hits) / n
+	m.trained = true
+	return nil
+}
+
+// Predict implements the Model interface, predicting a range around the
+// last close moved by the predicted return, as wide as the training
+// residuals either side
+func (m *RidgeModel) Predict(ctx context.Context, input *PredictionInput) (*PredictionOutput, error) {
+	if !m.trained {
+		return nil, ErrNotTrained
+	}
+	if input == nil || len(input.Historical) <= ridgeLookback {
+		return nil, fmt.Errorf("%w: %d prices of history needed", ErrInsufficientData, ridgeLookback+1)
+	}
+
+	closes := make([]float64, len(input.Historical))
+	volumes := make([]float64, len(input.Historical))
+	for i, bar := range input.Historical {
+		closes[i] = bar.Close
+		volumes[i] = bar.Volume
+	}
+	last := len(closes) - 1
+	x, ok := indicatorFeatures(closes, volumes, last)
+	if !ok {
+		return nil, fmt.Errorf("%w: history has non-positive prices", ErrInsufficientData)
+	}
+
+	r := m.predictReturn(x)
+	price := closes[last]
+	supports, resistances := CalculateSupportResistance(closes, supportResistanceWindow)
+	output := &PredictionOutput{
+		PredictedHigh:    price * (1 + r + m.residual),
+		PredictedLow:     price * (1 + r - m.residual),
+		Confidence:       m.confidence,
+		SupportLevels:    supports,
+		ResistanceLevels: resistances,
+	}
+
+	// Moves within half the residual are noise and signal nothing
+	if m.residual > 0 && math.Abs(r) > m.residual/2 {
+		signal := models.Signal{
+			Type:        "BUY",
+			Strength:    math.Min(1, math.Abs(r)/m.residual),
+			Description: fmt.Sprintf("Ridge baseline expects a %+.2f%% move", r*100),
+			CreatedAt:   input.Historical[last].Time,
+		}
+		if r < 0 {
+			signal.Type = "SELL"
+		}
+		output.Signals = []models.Signal{signal}
+	}
+
+	return output, nil
+}
+
+// predictReturn applies the fitted coefficients to unstandardized features
+func (m *RidgeModel) predictReturn(x []float64) float64 {
+	r := m.intercept
+	for j, v := range x {
+		r += m.coefficients[j] * (v - m.means[j]) / m.scales[j]
+	}
+	return r
+}
+
+// indicatorFeatures returns the ridgeFeatures of the price at i, or false
+// when the lookback before it holds a non-positive price. Volumes may be
+// shorter than prices, leaving their feature at zero.
+func indicatorFeatures(prices, volumes []float64, i int) ([]float64, bool) {
+	if i < ridgeLookback {
+		return nil, false
+	}
+	window := prices[i-ridgeLookback : i+1]
+	for _, p := range window {
+		if p <= 0 {
+			return nil, false
+		}
+	}
+
+	var sma float64
+	for _, p := range window[1:] {
+		sma += p / ridgeLookback
+	}
+
+	// RSI over the last 14 changes, rescaled from [0, 100] to [-1, 1]
+	var gains, losses float64
+	for j := i - 13; j <= i; j++ {
+		change := prices[j] - prices[j-1]
+		if change > 0 {
+			gains += change
+		} else {
+			losses -= change
+		}
+	}
+	rsi := 0.0
+	if gains+losses > 0 {
+		rsi = 2*gains/(gains+losses) - 1
+	}
+
+	var mean, variance float64
+	returns := make([]float64, 10)
+	for j := range returns {
+		returns[j] = prices[i-j]/prices[i-j-1] - 1
+		mean += returns[j] / 10
+	}
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean) / 10
+	}
+
+	volumeRatio := 0.0
+	if i < len(volumes) {
+		var meanVolume float64
+		for _, v := range volumes[i-ridgeLookback+1 : i+1] {
+			meanVolume += v / ridgeLookback
+		}
+		if meanVolume > 0 {
+			volumeRatio = volumes[i]/meanVolume - 1
+		}
+	}
+
+	return []float64{
+		prices[i]/prices[i-1] - 1,
+		prices[i]/prices[i-5] - 1,
+		prices[i]/sma - 1,
+		rsi,
+		math.Sqrt(variance),
+		volumeRatio,
+	}, true
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// trendingPrices rises 0.5% a day with a wobble, so the next return is
+// predictable from the last ones
+func trendingPrices(n int) []float64 {
+	prices := make([]float64, n)
+	prices[0] = 100
+	for i := 1; i < n; i++ {
+		prices[i] = prices[i-1] * (1.005 + 0.002*math.Sin(float64(i)))
+	}
+	return prices
+}
+
+func TestRidgeModel_TrainPredict(t *testing.T) {
+	ctx := context.Background()
+	prices := trendingPrices(120)
+	volumes := make([]float64, len(prices))
+	for i := range volumes {
+		volumes[i] = 1000 + 10*float64(i%7)
+	}
+
+	model := NewRidgeModel(ModelConfig{})
+	_, err := model.Predict(ctx, &PredictionInput{})
+	assert.True(t, errors.Is(err, ErrNotTrained))
+
+	if !assert.NoError(t, model.Train(ctx, &TrainingData{AssetSymbol: "AAPL", Prices: prices, Volumes: volumes})) {
+		return
+	}
+	assert.Greater(t, model.GetConfidence(), 0.9)
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	historical := make([]OHLCV, len(prices))
+	for i, p := range prices {
+		historical[i] = OHLCV{Time: start.AddDate(0, 0, i), Close: p, High: p, Low: p, Open: p, Volume: volumes[i]}
+	}
+	output, err := model.Predict(ctx, &PredictionInput{AssetSymbol: "AAPL", Historical: historical})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	last := prices[len(prices)-1]
+	assert.LessOrEqual(t, output.PredictedLow, output.PredictedHigh)
+	assert.Greater(t, (output.PredictedHigh+output.PredictedLow)/2, last)
+	assert.InDelta(t, model.GetConfidence(), output.Confidence, 1e-9)
+	if assert.Len(t, output.Signals, 1) {
+		assert.Equal(t, "BUY", output.Signals[0].Type)
+		assert.Equal(t, historical[len(historical)-1].Time, output.Signals[0].CreatedAt)
+	}
+
+	t.Run("Too little history", func(t *testing.T) {
+		err := NewRidgeModel(ModelConfig{}).Train(ctx, &TrainingData{Prices: prices[:40]})
+		assert.True(t, errors.Is(err, ErrInsufficientData))
+
+		_, err = model.Predict(ctx, &PredictionInput{Historical: historical[:ridgeLookback]})
+		assert.True(t, errors.Is(err, ErrInsufficientData))
+	})
+
+	t.Run("Labels must line up with prices", func(t *testing.T) {
+		err := NewRidgeModel(ModelConfig{}).Train(ctx, &TrainingData{Prices: prices, Labels: prices[1:]})
+		assert.True(t, errors.Is(err, ErrInsufficientData))
+	})
+}
@@ -2,30 +2,49 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 	"sync"
-
-	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
-	"gorgonia.org/gorgonia"
-	"gorgonia.org/tensor"
+	"time"
 )
 
-// SentimentModel implements sentiment analysis for market and social data
+// SentimentModel implements sentiment analysis for market and social data.
+// Texts are scored by a network only built with the gorgonia tag; source
+// weights and their calibration work in every build.
 type SentimentModel struct {
 	BaseModel
 
 	// Model specific fields
-	vocabSize   int
-	embedSize   int
-	maxSeqLen   int
-	graph       *gorgonia.ExprGraph
-	weights     map[string]*gorgonia.Node
-	vocab       map[string]int
-	embeddings  *gorgonia.Node
-	optimizer   gorgonia.Solver
+	vocabSize int
+	embedSize int
+	maxSeqLen int
+	vocab     map[string]int
+	// network scores preprocessed texts, nil in builds without gorgonia
+	network sentimentNetwork
+
+	// Per-source weights and accuracies, guarded by mu since calibration
+	// can run while batches are being analysed
+	mu             sync.RWMutex
+	sourceWeights  map[string]float64
+	sourceAccuracy map[string]float64
+
+	// LastCalibratedAt is when source weights were last calibrated, or zero
+	// if the defaults are in use
+	LastCalibratedAt time.Time
+}
+
+// sentimentNetwork scores the token indices of a text in [-1, 1]
+type sentimentNetwork interface {
+	score(indices []int) (float64, error)
 }
 
+// ErrSentimentUnavailable is returned by AnalyzeSentiment in builds without
+// the gorgonia tag, which leave out the network scoring texts
+var ErrSentimentUnavailable = errors.New("sentiment network not built in, build with -tags gorgonia")
+
 // SentimentInput represents input data for sentiment analysis
 type SentimentInput struct {
 	Text      string
@@ -43,108 +62,30 @@ type SentimentOutput struct {
 	MarketImpact float64 // predicted market impact
 }
 
-// NewSentimentModel creates a new sentiment analysis model
-func NewSentimentModel(config ModelConfig) *SentimentModel {
-	return &SentimentModel{
+// NewSentimentModel creates a new sentiment analysis model. Source weights
+// are loaded from the last calibration in db; if db is nil or nothing has
+// been calibrated yet, the default weights are used.
+func NewSentimentModel(config ModelConfig, db *sql.DB) (*SentimentModel, error) {
+	m := &SentimentModel{
 		BaseModel: BaseModel{
 			config: config,
 		},
-		vocabSize:  50000, // Size of vocabulary
-		embedSize:  300,   // Dimension of word embeddings
-		maxSeqLen:  128,   // Maximum sequence length
-		weights:    make(map[string]*gorgonia.Node),
-		vocab:      make(map[string]int),
+		vocabSize:      50000, // Size of vocabulary
+		embedSize:      300,   // Dimension of word embeddings
+		maxSeqLen:      128,   // Maximum sequence length
+		vocab:          make(map[string]int),
+		sourceWeights:  copyWeights(defaultSourceWeights),
+		sourceAccuracy: copyWeights(defaultSourceAccuracy),
 	}
-}
+	m.network = newSentimentNetwork(m)
 
-// initializeModel sets up the sentiment analysis model
-func (m *SentimentModel) initializeModel() error {
-	m.graph = gorgonia.NewGraph()
-
-	// Word embeddings
-	m.embeddings = gorgonia.NewMatrix(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(m.vocabSize, m.embedSize),
-		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-	)
-
-	// Convolutional layers for n-gram feature extraction
-	filterSizes := []int{2, 3, 4} // for bi-grams, tri-grams, and 4-grams
-	numFilters := 100
-
-	for _, size := range filterSizes {
-		// Convolution filters
-		m.weights["conv_"+string(size)] = gorgonia.NewTensor(
-			m.graph,
-			tensor.Float64,
-			4, // 4D tensor for conv2d
-			gorgonia.WithShape(numFilters, 1, size, m.embedSize),
-			gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-		)
-
-		// Bias terms
-		m.weights["conv_bias_"+string(size)] = gorgonia.NewVector(
-			m.graph,
-			tensor.Float64,
-			gorgonia.WithShape(numFilters),
-			gorgonia.WithInit(gorgonia.Zeroes()),
-		)
+	if db != nil {
+		if err := m.loadSourceWeights(context.Background(), db); err != nil {
+			return nil, fmt.Errorf("failed to load sentiment source weights: %w", err)
+		}
 	}
 
-	// Attention mechanism weights
-	m.weights["attention_w"] = gorgonia.NewMatrix(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(m.embedSize, m.embedSize),
-		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-	)
-
-	m.weights["attention_v"] = gorgonia.NewVector(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(m.embedSize),
-		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-	)
-
-	// Final classification layers
-	totalFeatures := len(filterSizes) * numFilters
-
-	m.weights["fc1"] = gorgonia.NewMatrix(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(totalFeatures, 256),
-		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-	)
-
-	m.weights["fc1_bias"] = gorgonia.NewVector(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(256),
-		gorgonia.WithInit(gorgonia.Zeroes()),
-	)
-
-	m.weights["fc2"] = gorgonia.NewMatrix(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(256, 1),
-		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
-	)
-
-	m.weights["fc2_bias"] = gorgonia.NewVector(
-		m.graph,
-		tensor.Float64,
-		gorgonia.WithShape(1),
-		gorgonia.WithInit(gorgonia.Zeroes()),
-	)
-
-	// Initialize optimizer
-	m.optimizer = gorgonia.NewAdamSolver(
-		gorgonia.WithBatchSize(float64(m.config.BatchSize)),
-		gorgonia.WithLearnRate(m.config.LearningRate),
-	)
-
-	return nil
+	return m, nil
 }
 
 // preprocess prepares text input for the model
@@ -165,83 +106,12 @@ func (m *SentimentModel) preprocess(text string) []int {
 	return indices
 }
 
-// attention implements scaled dot-product attention
-func (m *SentimentModel) attention(input *gorgonia.Node) (*gorgonia.Node, error) {
-	// Calculate attention scores
-	scores := gorgonia.Must(gorgonia.Mul(input, m.weights["attention_w"]))
-	scores = gorgonia.Must(gorgonia.Mul(scores, m.weights["attention_v"]))
-	
-	// Apply softmax
-	attnWeights := gorgonia.Must(gorgonia.SoftMax(scores))
-	
-	// Weight the input
-	return gorgonia.HadamardProd(input, attnWeights)
-}
-
-// forward performs the forward pass of the sentiment model
-func (m *SentimentModel) forward(input *gorgonia.Node) (*gorgonia.Node, error) {
-	// Embed input tokens
-	embedded := gorgonia.Must(gorgonia.Mul(input, m.embeddings))
-
-	// Apply CNN layers in parallel
-	var convOutputs []*gorgonia.Node
-	filterSizes := []int{2, 3, 4}
-	
-	for _, size := range filterSizes {
-		// Convolution
-		conv := gorgonia.Must(gorgonia.Conv2d(
-			embedded,
-			m.weights["conv_"+string(size)],
-			[]int{1, 1}, // strides
-			[]int{0, 0}, // padding
-			[]int{1, 1}, // dilation
-		))
-
-		// Add bias
-		conv = gorgonia.Must(gorgonia.Add(conv, m.weights["conv_bias_"+string(size)]))
-
-		// Apply ReLU
-		conv = gorgonia.Must(gorgonia.Rectify(conv))
-
-		// Max pooling
-		pool := gorgonia.Must(gorgonia.MaxPool2D(
-			conv,
-			tensor.Shape{2, 2},
-			[]int{2, 2},
-			[]int{0, 0},
-		))
-
-		convOutputs = append(convOutputs, pool)
-	}
-
-	// Concatenate CNN outputs
-	concat := gorgonia.Must(gorgonia.Concat(0, convOutputs...))
-
-	// Apply attention
-	attnOutput, err := m.attention(concat)
-	if err != nil {
-		return nil, err
-	}
-
-	// Fully connected layers
-	fc1 := gorgonia.Must(gorgonia.Add(
-		gorgonia.Must(gorgonia.Mul(attnOutput, m.weights["fc1"])),
-		m.weights["fc1_bias"],
-	))
-	fc1 = gorgonia.Must(gorgonia.Rectify(fc1))
-
-	// Final output layer
-	output := gorgonia.Must(gorgonia.Add(
-		gorgonia.Must(gorgonia.Mul(fc1, m.weights["fc2"])),
-		m.weights["fc2_bias"],
-	))
-	
-	// Tanh activation for [-1, 1] sentiment range
-	return gorgonia.Tanh(output)
-}
-
 // AnalyzeSentiment performs sentiment analysis on a batch of texts
 func (m *SentimentModel) AnalyzeSentiment(ctx context.Context, inputs []SentimentInput) ([]SentimentOutput, error) {
+	if m.network == nil {
+		return nil, ErrSentimentUnavailable
+	}
+
 	batchSize := len(inputs)
 	outputs := make([]SentimentOutput, batchSize)
 	var wg sync.WaitGroup
@@ -254,18 +124,14 @@ func (m *SentimentModel) AnalyzeSentiment(ctx context.Context, inputs []Sentimen
 
 			// Preprocess text
 			indices := m.preprocess(in.Text)
-			inputTensor := tensor.New(tensor.WithShape(1, m.maxSeqLen), tensor.WithBacking(indices))
 
-			// Forward pass
-			pred, err := m.forward(gorgonia.NodeFromAny(m.graph, inputTensor))
+			// Score the text
+			sentiment, err := m.network.score(indices)
 			if err != nil {
 				// Handle error
 				return
 			}
 
-			// Extract predictions
-			sentiment := pred.Value().Data().(float64)
-			
 			// Extract key phrases and topics
 			keyPhrases := m.extractKeyPhrases(in.Text)
 			topics := m.identifyTopics(in.Text)
@@ -302,7 +168,9 @@ func (m *SentimentModel) identifyTopics(text string) []string {
 
 func (m *SentimentModel) calculateConfidence(sentiment float64, source string) float64 {
 	// Base confidence on model's historical accuracy for the source
+	m.mu.RLock()
 	baseConfidence := m.sourceAccuracy[source]
+	m.mu.RUnlock()
 	
 	// Adjust based on sentiment strength
 	sentimentStrength := math.Abs(sentiment)
@@ -312,21 +180,23 @@ func (m *SentimentModel) calculateConfidence(sentiment float64, source string) f
 
 func (m *SentimentModel) estimateMarketImpact(sentiment float64, sourceImpact float64, source string) float64 {
 	// Weight based on source reliability
+	m.mu.RLock()
 	sourceWeight := m.sourceWeights[source]
+	m.mu.RUnlock()
 	
 	// Combine sentiment strength with source impact
 	return sentiment * sourceImpact * sourceWeight
 }
 
-// Source-specific weights and accuracies
+// Default source-specific weights and accuracies, used until calibrated
 var (
-	sourceWeights = map[string]float64{
+	defaultSourceWeights = map[string]float64{
 		"news":    0.8,
 		"twitter": 0.4,
 		"reddit":  0.3,
 	}
 
-	sourceAccuracy = map[string]float64{
+	defaultSourceAccuracy = map[string]float64{
 		"news":    0.85,
 		"twitter": 0.70,
 		"reddit":  0.65,
@@ -0,0 +1,211 @@
+//go:build gorgonia
+
+package models
+
+import (
+	"strconv"
+
+	"gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// cnnNetwork is the convolutional network with attention that scores the
+// texts of a SentimentModel
+type cnnNetwork struct {
+	config     ModelConfig
+	vocabSize  int
+	embedSize  int
+	maxSeqLen  int
+	graph      *gorgonia.ExprGraph
+	weights    map[string]*gorgonia.Node
+	embeddings *gorgonia.Node
+	optimizer  gorgonia.Solver
+}
+
+func newSentimentNetwork(m *SentimentModel) sentimentNetwork {
+	return &cnnNetwork{
+		config:    m.config,
+		vocabSize: m.vocabSize,
+		embedSize: m.embedSize,
+		maxSeqLen: m.maxSeqLen,
+		weights:   make(map[string]*gorgonia.Node),
+	}
+}
+
+// initializeModel sets up the network
+func (m *cnnNetwork) initializeModel() error {
+	m.graph = gorgonia.NewGraph()
+
+	// Word embeddings
+	m.embeddings = gorgonia.NewMatrix(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(m.vocabSize, m.embedSize),
+		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+	)
+
+	// Convolutional layers for n-gram feature extraction
+	filterSizes := []int{2, 3, 4} // for bi-grams, tri-grams, and 4-grams
+	numFilters := 100
+
+	for _, size := range filterSizes {
+		// Convolution filters
+		m.weights["conv_"+strconv.Itoa(size)] = gorgonia.NewTensor(
+			m.graph,
+			tensor.Float64,
+			4, // 4D tensor for conv2d
+			gorgonia.WithShape(numFilters, 1, size, m.embedSize),
+			gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+		)
+
+		// Bias terms
+		m.weights["conv_bias_"+strconv.Itoa(size)] = gorgonia.NewVector(
+			m.graph,
+			tensor.Float64,
+			gorgonia.WithShape(numFilters),
+			gorgonia.WithInit(gorgonia.Zeroes()),
+		)
+	}
+
+	// Attention mechanism weights
+	m.weights["attention_w"] = gorgonia.NewMatrix(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(m.embedSize, m.embedSize),
+		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+	)
+
+	m.weights["attention_v"] = gorgonia.NewVector(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(m.embedSize),
+		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+	)
+
+	// Final classification layers
+	totalFeatures := len(filterSizes) * numFilters
+
+	m.weights["fc1"] = gorgonia.NewMatrix(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(totalFeatures, 256),
+		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+	)
+
+	m.weights["fc1_bias"] = gorgonia.NewVector(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(256),
+		gorgonia.WithInit(gorgonia.Zeroes()),
+	)
+
+	m.weights["fc2"] = gorgonia.NewMatrix(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(256, 1),
+		gorgonia.WithInit(gorgonia.GlorotN(1.0)),
+	)
+
+	m.weights["fc2_bias"] = gorgonia.NewVector(
+		m.graph,
+		tensor.Float64,
+		gorgonia.WithShape(1),
+		gorgonia.WithInit(gorgonia.Zeroes()),
+	)
+
+	// Initialize optimizer
+	m.optimizer = gorgonia.NewAdamSolver(
+		gorgonia.WithBatchSize(float64(m.config.BatchSize)),
+		gorgonia.WithLearnRate(m.config.LearningRate),
+	)
+
+	return nil
+}
+
+// attention implements scaled dot-product attention
+func (m *cnnNetwork) attention(input *gorgonia.Node) (*gorgonia.Node, error) {
+	// Calculate attention scores
+	scores := gorgonia.Must(gorgonia.Mul(input, m.weights["attention_w"]))
+	scores = gorgonia.Must(gorgonia.Mul(scores, m.weights["attention_v"]))
+
+	// Apply softmax
+	attnWeights := gorgonia.Must(gorgonia.SoftMax(scores))
+
+	// Weight the input
+	return gorgonia.HadamardProd(input, attnWeights)
+}
+
+// forward performs the forward pass of the network
+func (m *cnnNetwork) forward(input *gorgonia.Node) (*gorgonia.Node, error) {
+	// Embed input tokens
+	embedded := gorgonia.Must(gorgonia.Mul(input, m.embeddings))
+
+	// Apply CNN layers in parallel
+	var convOutputs []*gorgonia.Node
+	filterSizes := []int{2, 3, 4}
+
+	for _, size := range filterSizes {
+		// Convolution
+		conv := gorgonia.Must(gorgonia.Conv2d(
+			embedded,
+			m.weights["conv_"+strconv.Itoa(size)],
+			tensor.Shape{size, m.embedSize}, // kernel
+			[]int{0, 0},                     // padding
+			[]int{1, 1},                     // strides
+			[]int{1, 1},                     // dilation
+		))
+
+		// Add bias
+		conv = gorgonia.Must(gorgonia.Add(conv, m.weights["conv_bias_"+strconv.Itoa(size)]))
+
+		// Apply ReLU
+		conv = gorgonia.Must(gorgonia.Rectify(conv))
+
+		// Max pooling
+		pool := gorgonia.Must(gorgonia.MaxPool2D(
+			conv,
+			tensor.Shape{2, 2},
+			[]int{2, 2},
+			[]int{0, 0},
+		))
+
+		convOutputs = append(convOutputs, pool)
+	}
+
+	// Concatenate CNN outputs
+	concat := gorgonia.Must(gorgonia.Concat(0, convOutputs...))
+
+	// Apply attention
+	attnOutput, err := m.attention(concat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fully connected layers
+	fc1 := gorgonia.Must(gorgonia.Add(
+		gorgonia.Must(gorgonia.Mul(attnOutput, m.weights["fc1"])),
+		m.weights["fc1_bias"],
+	))
+	fc1 = gorgonia.Must(gorgonia.Rectify(fc1))
+
+	// Final output layer
+	output := gorgonia.Must(gorgonia.Add(
+		gorgonia.Must(gorgonia.Mul(fc1, m.weights["fc2"])),
+		m.weights["fc2_bias"],
+	))
+
+	// Tanh activation for [-1, 1] sentiment range
+	return gorgonia.Tanh(output)
+}
+
+// score implements sentimentNetwork
+func (m *cnnNetwork) score(indices []int) (float64, error) {
+	inputTensor := tensor.New(tensor.WithShape(1, m.maxSeqLen), tensor.WithBacking(indices))
+
+	// Forward pass
+	pred, err := m.forward(gorgonia.NodeFromAny(m.graph, inputTensor))
+	if err != nil {
+		return 0, err
+	}
+	return pred.Value().Data().(float64), nil
+}
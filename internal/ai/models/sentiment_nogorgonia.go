@@ -0,0 +1,9 @@
+//go:build !gorgonia
+
+package models
+
+// newSentimentNetwork leaves SentimentModel without a network, as scoring
+// texts needs gorgonia
+func newSentimentNetwork(*SentimentModel) sentimentNetwork {
+	return nil
+}
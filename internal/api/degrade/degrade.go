@@ -0,0 +1,158 @@
+// Package degrade serves a response's optional sections within a time
+// budget, so a slow or failing subsystem costs the response that section
+// rather than the whole response.
+package degrade
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Header lists the sections a response was served without, comma-separated
+const Header = "X-Degraded"
+
+// Why a section was left out
+const (
+    ReasonTimeout = "timeout"
+    ReasonError   = "error"
+)
+
+const (
+    // DefaultBudget is how long each section may take
+    DefaultBudget = 2 * time.Second
+    // renderReserve is the part of the request's remaining time kept for
+    // writing the response once the sections are in
+    renderReserve = 100 * time.Millisecond
+)
+
+// Section is an optional part of a response, loaded by Load
+type Section struct {
+    Name string
+    Load func(ctx context.Context) (interface{}, error)
+}
+
+// Missing names a section a response was served without, and why
+type Missing struct {
+    Section string `json:"section"`
+    Reason  string `json:"reason"`
+    Error   string `json:"error,omitempty"`
+}
+
+// Result is what came of a Run: the values of the sections that finished
+// in time, and what is missing of the rest in the order they were given
+type Result struct {
+    Values   map[string]interface{}
+    Degraded []Missing
+}
+
+// SetHeader lists the missing sections in the Header of w, if any
+func (r Result) SetHeader(w http.ResponseWriter) {
+    if len(r.Degraded) == 0 {
+        return
+    }
+    names := make([]string, len(r.Degraded))
+    for i, m := range r.Degraded {
+        names[i] = m.Section
+    }
+    w.Header().Set(Header, strings.Join(names, ","))
+}
+
+// Budget is the time each section gets: perSection, or less when ctx's
+// deadline leaves less than that before the response must be written
+func Budget(ctx context.Context, perSection time.Duration) time.Duration {
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        return perSection
+    }
+    if left := time.Until(deadline) - renderReserve; left < perSection {
+        if left < 0 {
+            return 0
+        }
+        return left
+    }
+    return perSection
+}
+
+type loaded struct {
+    value interface{}
+    err   error
+}
+
+// Run loads sections concurrently, giving each budget. Run returns within
+// budget even when a section ignores its context: the section is reported
+// missing and whatever it returns later is discarded.
+func Run(ctx context.Context, budget time.Duration, sections ...Section) Result {
+    results := make([]chan loaded, len(sections))
+    contexts := make([]context.Context, len(sections))
+    for i, section := range sections {
+        sectionCtx, cancel := context.WithTimeout(ctx, budget)
+        defer cancel()
+        results[i] = make(chan loaded, 1)
+        contexts[i] = sectionCtx
+        go func(ctx context.Context, load func(context.Context) (interface{}, error), done chan<- loaded) {
+            value, err := load(ctx)
+            done <- loaded{value: value, err: err}
+        }(sectionCtx, section.Load, results[i])
+    }
+
+    result := Result{Values: make(map[string]interface{}, len(sections))}
+    for i, section := range sections {
+        // By the time a later section is collected its deadline may have
+        // passed too, so a result that is already in wins over the deadline
+        var got loaded
+        select {
+        case got = <-results[i]:
+        default:
+            select {
+            case got = <-results[i]:
+            case <-contexts[i].Done():
+                got.err = contexts[i].Err()
+            }
+        }
+        switch {
+        case got.err == nil:
+            result.Values[section.Name] = got.value
+        case errors.Is(got.err, context.DeadlineExceeded):
+            result.Degraded = append(result.Degraded, Missing{Section: section.Name, Reason: ReasonTimeout})
+        default:
+            result.Degraded = append(result.Degraded, Missing{Section: section.Name, Reason: ReasonError, Error: got.err.Error()})
+        }
+    }
+    return result
+}
+
+// Metrics counts responses served without a section as
+// degraded_responses_total, by endpoint, section and reason
+type Metrics struct {
+    degraded *prometheus.CounterVec
+}
+
+// NewMetrics registers the counter with reg when reg is non-nil
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+    m := &Metrics{
+        degraded: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "degraded_responses_total",
+            Help: "Responses served without an optional section that failed or ran out of time",
+        }, []string{"endpoint", "section", "reason"}),
+    }
+    if reg != nil {
+        reg.MustRegister(m.degraded)
+    }
+    return m
+}
+
+// Observe counts the sections result is missing. It does nothing on a nil
+// Metrics.
+func (m *Metrics) Observe(endpoint string, result Result) {
+    if m == nil {
+        return
+    }
+    for _, missing := range result.Degraded {
+        m.degraded.WithLabelValues(endpoint, missing.Section, missing.Reason).Inc()
+    }
+}
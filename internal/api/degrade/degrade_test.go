@@ -0,0 +1,76 @@
+package degrade
+
+import (
+    "context"
+    "errors"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+    assert.Equal(t, time.Second, Budget(context.Background(), time.Second))
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    assert.Equal(t, 100*time.Millisecond, Budget(ctx, 100*time.Millisecond))
+    // The deadline leaves less than the budget, less the render reserve
+    budget := Budget(ctx, time.Minute)
+    assert.Greater(t, budget, 800*time.Millisecond)
+    assert.LessOrEqual(t, budget, time.Second-renderReserve)
+
+    past, cancel := context.WithTimeout(context.Background(), renderReserve/2)
+    defer cancel()
+    assert.Equal(t, time.Duration(0), Budget(past, time.Second))
+}
+
+func TestRun(t *testing.T) {
+    section := func(name string, delay time.Duration, value interface{}, err error) Section {
+        return Section{Name: name, Load: func(ctx context.Context) (interface{}, error) {
+            // Sleeps through ctx, as a stuck dependency would
+            time.Sleep(delay)
+            return value, err
+        }}
+    }
+
+    start := time.Now()
+    result := Run(context.Background(), 50*time.Millisecond,
+        section("analytics", time.Second, "late", nil),
+        section("risk", 0, nil, errors.New("no prices")),
+        section("predictions", time.Millisecond, []string{"BTC"}, nil),
+    )
+    assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+    assert.Equal(t, map[string]interface{}{"predictions": []string{"BTC"}}, result.Values)
+    assert.Equal(t, []Missing{
+        {Section: "analytics", Reason: ReasonTimeout},
+        {Section: "risk", Reason: ReasonError, Error: "no prices"},
+    }, result.Degraded)
+
+    rec := httptest.NewRecorder()
+    result.SetHeader(rec)
+    assert.Equal(t, "analytics,risk", rec.Header().Get(Header))
+
+    rec = httptest.NewRecorder()
+    Run(context.Background(), time.Second, section("risk", 0, 0.2, nil)).SetHeader(rec)
+    assert.Empty(t, rec.Header().Values(Header))
+
+    // A section that honours its context times out the same way
+    result = Run(context.Background(), 10*time.Millisecond, Section{Name: "risk", Load: func(ctx context.Context) (interface{}, error) {
+        <-ctx.Done()
+        return nil, ctx.Err()
+    }})
+    assert.Equal(t, []Missing{{Section: "risk", Reason: ReasonTimeout}}, result.Degraded)
+
+    // A section that finished in time isn't reported timed out because its
+    // deadline has passed by the time it is collected
+    for i := 0; i < 20; i++ {
+        result = Run(context.Background(), 10*time.Millisecond,
+            section("analytics", 30*time.Millisecond, "late", nil),
+            section("predictions", 0, []string{"BTC"}, nil),
+        )
+        assert.Equal(t, map[string]interface{}{"predictions": []string{"BTC"}}, result.Values)
+    }
+}
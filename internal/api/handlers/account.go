@@ -0,0 +1,219 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type AccountHandler struct {
+    service *auth.Service
+}
+
+func NewAccountHandler(service *auth.Service) *AccountHandler {
+    return &AccountHandler{service: service}
+}
+
+func (h *AccountHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    profile, err := h.service.GetProfile(r.Context(), user.ID)
+    if errors.Is(err, auth.ErrUserNotFound) {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, profile)
+}
+
+func (h *AccountHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    var req auth.ProfileUpdate
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    profile, err := h.service.UpdateProfile(r.Context(), user, req)
+    switch {
+    case errors.Is(err, auth.ErrInvalidProfile):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case errors.Is(err, auth.ErrUserNotFound):
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, profile)
+}
+
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    token, _ := r.Context().Value("token").(string)
+
+    var req struct {
+        CurrentPassword string `json:"current_password"`
+        NewPassword     string `json:"new_password"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    newToken, err := h.service.ChangePassword(r.Context(), user, token, req.CurrentPassword, req.NewPassword)
+    switch {
+    case errors.Is(err, auth.ErrInvalidPassword):
+        http.Error(w, err.Error(), http.StatusForbidden)
+        return
+    case errors.Is(err, auth.ErrPasswordTooShort),
+        errors.Is(err, auth.ErrPasswordTooLong),
+        errors.Is(err, auth.ErrPasswordTooWeak):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]string{
+        "token": newToken,
+    })
+}
+
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    token, _ := r.Context().Value("token").(string)
+
+    if err := h.service.DeleteAccount(r.Context(), user, token); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    token, _ := r.Context().Value("token").(string)
+
+    sessions, err := h.service.ListSessions(r.Context(), user.ID, h.service.SessionID(token))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, sessions)
+}
+
+func (h *AccountHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    sessionID := mux.Vars(r)["id"]
+
+    err := h.service.RevokeSession(r.Context(), user, sessionID)
+    if errors.Is(err, auth.ErrSessionNotFound) {
+        http.Error(w, "Session not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    setup, err := h.service.SetupTwoFactor(r.Context(), user)
+    switch {
+    case errors.Is(err, auth.ErrTwoFactorEnabled):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case errors.Is(err, auth.ErrTwoFactorUnavailable):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, setup)
+}
+
+func (h *AccountHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    var req struct {
+        Code string `json:"code"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    codes, err := h.service.EnableTwoFactor(r.Context(), user, req.Code)
+    if err != nil {
+        writeTwoFactorError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string][]string{
+        "recovery_codes": codes,
+    })
+}
+
+func (h *AccountHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    var req struct {
+        Code string `json:"code"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    codes, err := h.service.RegenerateRecoveryCodes(r.Context(), user, req.Code)
+    if err != nil {
+        writeTwoFactorError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string][]string{
+        "recovery_codes": codes,
+    })
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, auth.ErrInvalidCode):
+        http.Error(w, err.Error(), http.StatusForbidden)
+    case errors.Is(err, auth.ErrTooManyAttempts):
+        http.Error(w, err.Error(), http.StatusTooManyRequests)
+    case errors.Is(err, auth.ErrTwoFactorEnabled),
+        errors.Is(err, auth.ErrTwoFactorNotPending),
+        errors.Is(err, auth.ErrTwoFactorDisabled):
+        http.Error(w, err.Error(), http.StatusConflict)
+    case errors.Is(err, auth.ErrTwoFactorUnavailable):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type AdminHandler struct {
+    service *auth.Service
+}
+
+func NewAdminHandler(service *auth.Service) *AdminHandler {
+    return &AdminHandler{service: service}
+}
+
+func (h *AdminHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+    actor := r.Context().Value("user").(*models.User)
+    userID := mux.Vars(r)["id"]
+
+    var req struct {
+        Role string `json:"role"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    err := h.service.SetUserRole(r.Context(), actor, userID, req.Role)
+    switch {
+    case errors.Is(err, auth.ErrInvalidRole):
+        http.Error(w, "Invalid role", http.StatusBadRequest)
+        return
+    case errors.Is(err, auth.ErrUserNotFound):
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    case errors.Is(err, auth.ErrLastAdmin):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]string{
+        "id":   userID,
+        "role": req.Role,
+    })
+}
+
+// UpdateUserTier moves a user to another subscription tier. Downgrading
+// keeps the user's resources; those beyond the new limits become read-only.
+func (h *AdminHandler) UpdateUserTier(w http.ResponseWriter, r *http.Request) {
+    actor := r.Context().Value("user").(*models.User)
+    userID := mux.Vars(r)["id"]
+
+    var req struct {
+        Tier string `json:"tier"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    err := h.service.SetUserTier(r.Context(), actor, userID, req.Tier)
+    switch {
+    case errors.Is(err, auth.ErrInvalidTier):
+        http.Error(w, "Invalid subscription tier", http.StatusBadRequest)
+        return
+    case errors.Is(err, auth.ErrUserNotFound):
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]string{
+        "id":   userID,
+        "tier": req.Tier,
+    })
+}
+
+func (h *AdminHandler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+    limit := 100
+    if v := r.URL.Query().Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 || n > 1000 {
+            http.Error(w, "Invalid limit", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    entries, err := h.service.ListAuditLog(r.Context(), limit)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, entries)
+}
+
+// Impersonate issues a token with which the admin sees the API as the
+// user for auth.ImpersonationLifetime, read-only and audited throughout
+func (h *AdminHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+    actor := r.Context().Value("user").(*models.User)
+    userID, err := uuid.Parse(mux.Vars(r)["userID"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    result, err := h.service.Impersonate(r.Context(), actor, userID)
+    switch {
+    case errors.Is(err, auth.ErrUserNotFound):
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    case errors.Is(err, auth.ErrCannotImpersonate):
+        http.Error(w, err.Error(), http.StatusForbidden)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusCreated, result)
+}
+
+// EndImpersonation ends the impersonation the request's token belongs to
+// before it expires
+func (h *AdminHandler) EndImpersonation(w http.ResponseWriter, r *http.Request) {
+    imp := auth.ImpersonationFromContext(r.Context())
+    if imp == nil {
+        http.Error(w, "Not impersonating", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.service.EndImpersonation(r.Context(), imp); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
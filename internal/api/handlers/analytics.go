@@ -0,0 +1,442 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/analytics"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+// Seasonality lookback bounds, in years
+const (
+    defaultSeasonalityYears = 5
+    maxSeasonalityYears     = 20
+)
+
+// Performance lookback bounds, in days
+const (
+    defaultPerformanceDays = 90
+    maxPerformanceDays     = 3650
+)
+
+// PortfolioLookup is the part of portfolio.PortfolioService the handler
+// uses to check that the caller owns a portfolio
+type PortfolioLookup interface {
+    Get(ctx context.Context, id int64, userID uuid.UUID) (*models.Portfolio, error)
+}
+
+type AnalyticsHandler struct {
+    service    *analytics.Service
+    portfolios PortfolioLookup
+}
+
+func NewAnalyticsHandler(service *analytics.Service, portfolios PortfolioLookup) *AnalyticsHandler {
+    return &AnalyticsHandler{
+        service:    service,
+        portfolios: portfolios,
+    }
+}
+
+// GetMarketRegime returns the cross-asset regime of all subscribed symbols
+func (h *AnalyticsHandler) GetMarketRegime(w http.ResponseWriter, r *http.Request) {
+    report, err := h.service.DetectGlobalRegime(r.Context())
+    if errors.Is(err, analytics.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, report)
+}
+
+// GetSeasonality returns return statistics by weekday, month and around
+// month-end. Symbols with under a year of data get a 422.
+func (h *AnalyticsHandler) GetSeasonality(w http.ResponseWriter, r *http.Request) {
+    years := defaultSeasonalityYears
+    if v := r.URL.Query().Get("years"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > maxSeasonalityYears {
+            http.Error(w, "years must be between 1 and 20", http.StatusBadRequest)
+            return
+        }
+        years = n
+    }
+
+    report, err := h.service.GetSeasonality(r.Context(), mux.Vars(r)["symbol"], years)
+    if errors.Is(err, analytics.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, report)
+}
+
+// GetWhatIfOptimization estimates how much rebalancing the portfolio to
+// the optimizer's weights would improve its Sharpe ratio and risk
+func (h *AnalyticsHandler) GetWhatIfOptimization(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+
+    estimate, err := h.service.WhatIfOptimization(r.Context(), id)
+    if errors.Is(err, analytics.ErrEmptyPortfolio) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        http.Error(w, "Portfolio not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, estimate)
+}
+
+// GetRebalancingOrders lists the trades that would move the portfolio to
+// the optimizer's weights, with the market impact of each
+func (h *AnalyticsHandler) GetRebalancingOrders(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+
+    orders, err := h.service.GenerateRebalancingOrders(r.Context(), id)
+    if errors.Is(err, analytics.ErrEmptyPortfolio) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        http.Error(w, "Portfolio not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, orders)
+}
+
+// GetMarketImpact estimates the slippage of trading a quantity of the
+// symbol, e.g. ?quantity=1000&side=BUY
+func (h *AnalyticsHandler) GetMarketImpact(w http.ResponseWriter, r *http.Request) {
+    quantity, err := strconv.ParseFloat(r.URL.Query().Get("quantity"), 64)
+    if err != nil || quantity <= 0 {
+        http.Error(w, "quantity must be a positive number", http.StatusBadRequest)
+        return
+    }
+
+    estimate, err := h.service.EstimateMarketImpact(r.Context(), mux.Vars(r)["symbol"], quantity, r.URL.Query().Get("side"))
+    if errors.Is(err, analytics.ErrInvalidSide) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if errors.Is(err, analytics.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, estimate)
+}
+
+// GetDrawdownRecovery estimates how long the portfolio will take to recover
+// from its current drawdown, from how long its past drawdowns took
+func (h *AnalyticsHandler) GetDrawdownRecovery(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+
+    recovery, err := h.service.EstimateRecoveryTime(r.Context(), id)
+    if errors.Is(err, analytics.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, recovery)
+}
+
+// GetDailyPerformance returns the portfolio's daily value series. A tz
+// query parameter re-buckets the days into that IANA timezone; without it
+// days are as the portfolio's snapshots were dated. basis=nav returns NAV
+// per unit instead, in the snapshots' days only.
+func (h *AnalyticsHandler) GetDailyPerformance(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+
+    days, ok := lookbackDays(w, r)
+    if !ok {
+        return
+    }
+
+    var loc *time.Location
+    if tz := r.URL.Query().Get("tz"); tz != "" {
+        var err error
+        // Local would be the server's zone, which is what tz is meant to avoid
+        if loc, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+            http.Error(w, "Unknown timezone "+strconv.Quote(tz), http.StatusBadRequest)
+            return
+        }
+    }
+
+    var series *analytics.PerformanceSeries
+    var err error
+    switch basis := r.URL.Query().Get("basis"); basis {
+    case "", analytics.BasisValue:
+        series, err = h.service.GetDailyPerformance(r.Context(), id, days, loc)
+    case analytics.BasisNAV:
+        if loc != nil {
+            http.Error(w, "tz is not supported with basis=nav", http.StatusBadRequest)
+            return
+        }
+        series, err = h.service.GetNAVPerformance(r.Context(), id, days)
+    default:
+        http.Error(w, "Unknown basis "+strconv.Quote(basis), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, series)
+}
+
+// GetDiversificationTrend returns the portfolio's diversification score on
+// each snapshot day, flagging a decline over the last 30 days
+func (h *AnalyticsHandler) GetDiversificationTrend(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+    days, ok := lookbackDays(w, r)
+    if !ok {
+        return
+    }
+
+    points, err := h.service.GetDiversificationTrend(r.Context(), id, days)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, analytics.NewDiversificationTrend(points))
+}
+
+// Allocation history range bounds, in days
+const (
+    defaultAllocationHistoryDays = 365
+    maxAllocationHistoryDays     = 3650
+)
+
+// GetAllocationHistory returns each holding's weight of the portfolio per
+// period, from its snapshots between the from and to dates. granularity is
+// daily, weekly (the default) or monthly, and holdings that never reach
+// other_below of the portfolio are grouped under Other.
+func (h *AnalyticsHandler) GetAllocationHistory(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.ownedPortfolio(w, r); !ok {
+        return
+    }
+
+    query := r.URL.Query()
+    now := time.Now().UTC()
+    to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    if v := query.Get("to"); v != "" {
+        t, err := time.Parse("2006-01-02", v)
+        if err != nil {
+            http.Error(w, "to must be a date formatted as YYYY-MM-DD", http.StatusBadRequest)
+            return
+        }
+        to = t
+    }
+    from := to.AddDate(0, 0, -defaultAllocationHistoryDays)
+    if v := query.Get("from"); v != "" {
+        t, err := time.Parse("2006-01-02", v)
+        if err != nil {
+            http.Error(w, "from must be a date formatted as YYYY-MM-DD", http.StatusBadRequest)
+            return
+        }
+        from = t
+    }
+    if from.After(to) || to.Sub(from) > maxAllocationHistoryDays*24*time.Hour {
+        http.Error(w, "from must not be after to, nor more than 3650 days earlier", http.StatusBadRequest)
+        return
+    }
+
+    granularity := query.Get("granularity")
+    switch granularity {
+    case "":
+        granularity = analytics.GranularityWeekly
+    case analytics.GranularityDaily, analytics.GranularityWeekly, analytics.GranularityMonthly:
+    default:
+        http.Error(w, "Unknown granularity "+strconv.Quote(granularity), http.StatusBadRequest)
+        return
+    }
+
+    otherBelow := analytics.DefaultAllocationOtherBelow
+    if v := query.Get("other_below"); v != "" {
+        f, err := strconv.ParseFloat(v, 64)
+        if err != nil || f < 0 || f >= 1 {
+            http.Error(w, "other_below must be a weight from 0 up to 1", http.StatusBadRequest)
+            return
+        }
+        otherBelow = f
+    }
+
+    history, err := h.service.GetAllocationHistory(r.Context(), id, from, to, granularity, otherBelow)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, history)
+}
+
+// RecordCashflow records a deposit, or a withdrawal for a negative amount,
+// of the body's currency, the portfolio's currency if omitted, and returns
+// the portfolio's cash afterwards. A withdrawal of more cash than a portfolio
+// without margin holds gets a 422.
+func (h *AnalyticsHandler) RecordCashflow(w http.ResponseWriter, r *http.Request) {
+    id, ok := h.ownedPortfolio(w, r)
+    if !ok {
+        return
+    }
+
+    var flow analytics.Cashflow
+    if err := json.NewDecoder(r.Body).Decode(&flow); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if flow.Currency != "" && !isCurrencyCode(flow.Currency) {
+        http.Error(w, "currency must be a three-letter code", http.StatusBadRequest)
+        return
+    }
+    if flow.At.IsZero() {
+        flow.At = time.Now().UTC()
+    }
+
+    cash, err := h.service.RecordCashflow(r.Context(), id, flow)
+    switch {
+    case errors.Is(err, analytics.ErrInvalidCashflow):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case errors.Is(err, portfolio.ErrInsufficientBalance):
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    case errors.Is(err, sql.ErrNoRows):
+        http.Error(w, "Portfolio not found", http.StatusNotFound)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusCreated, map[string]interface{}{"cash_balance": cash})
+}
+
+func isCurrencyCode(s string) bool {
+    if len(s) != 3 {
+        return false
+    }
+    for _, c := range s {
+        if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+            return false
+        }
+    }
+    return true
+}
+
+// GetAggregateView returns the combined risk and return of the user's
+// portfolios. Portfolios that share under three snapshot days get a 422.
+func (h *AnalyticsHandler) GetAggregateView(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    view, err := h.service.GetAggregatePortfolioView(r.Context(), user.ID)
+    if errors.Is(err, analytics.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, view)
+}
+
+// GetStaleAnalysisCount returns how many market analyses are old enough
+// to be purged
+func (h *AnalyticsHandler) GetStaleAnalysisCount(w http.ResponseWriter, r *http.Request) {
+    count, err := h.service.CountStaleAnalyses(r.Context())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]int{"count": count})
+}
+
+// ownedPortfolio parses the id path parameter, answering 400 when it isn't
+// a portfolio ID and 404 when the caller doesn't own the portfolio, so
+// other users' portfolios look as if they don't exist
+func (h *AnalyticsHandler) ownedPortfolio(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return 0, false
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    if _, err := h.portfolios.Get(r.Context(), id, user.ID); err != nil {
+        http.Error(w, "Portfolio not found", http.StatusNotFound)
+        return 0, false
+    }
+    return id, true
+}
+
+// lookbackDays parses the days query parameter of the performance
+// endpoints, answering 400 when it is out of range
+func lookbackDays(w http.ResponseWriter, r *http.Request) (int, bool) {
+    v := r.URL.Query().Get("days")
+    if v == "" {
+        return defaultPerformanceDays, true
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 1 || n > maxPerformanceDays {
+        http.Error(w, "days must be between 1 and 3650", http.StatusBadRequest)
+        return 0, false
+    }
+    return n, true
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/analytics"
+)
+
+// fakePortfolios looks portfolios up by ID in a map of their owners
+type fakePortfolios map[int64]uuid.UUID
+
+func (f fakePortfolios) Get(ctx context.Context, id int64, userID uuid.UUID) (*models.Portfolio, error) {
+    if owner, ok := f[id]; !ok || owner != userID {
+        return nil, sql.ErrNoRows
+    }
+    return &models.Portfolio{ID: id}, nil
+}
+
+// portfolioRequest is a request for portfolio id made by user
+func portfolioRequest(method, target, body, id string, user *models.User) *http.Request {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    req = mux.SetURLVars(req, map[string]string{"id": id})
+    return req.WithContext(context.WithValue(req.Context(), "user", user))
+}
+
+func TestAnalyticsHandler_RecordCashflow_NotOwner(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+
+    owner, other := &models.User{ID: uuid.New()}, &models.User{ID: uuid.New()}
+    handler := NewAnalyticsHandler(analytics.NewService(db, nil), fakePortfolios{7: owner.ID})
+
+    rec := httptest.NewRecorder()
+    handler.RecordCashflow(rec, portfolioRequest(http.MethodPost, "/portfolios/7/cashflows",
+        `{"amount": 1000}`, "7", other))
+    assert.Equal(t, http.StatusNotFound, rec.Code)
+
+    rec = httptest.NewRecorder()
+    handler.RecordCashflow(rec, portfolioRequest(http.MethodPost, "/portfolios/x/cashflows",
+        `{"amount": 1000}`, "x", owner))
+    assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+    // Nothing was recorded against the portfolio
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
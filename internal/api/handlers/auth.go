@@ -2,9 +2,12 @@ package handlers
 
 import (
     "encoding/json"
+    "errors"
     "net/http"
 
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
 )
 
 type AuthHandler struct {
@@ -46,13 +49,47 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    token, err := h.service.Login(r.Context(), req.Email, req.Password)
+    client := auth.ClientInfo{UserAgent: r.UserAgent(), IP: middleware.ClientIP(r)}
+    result, err := h.service.Login(r.Context(), req.Email, req.Password, client)
     if err != nil {
         http.Error(w, err.Error(), http.StatusUnauthorized)
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]string{
+    render.JSON(w, r, http.StatusOK, result)
+}
+
+// CompleteTwoFactor exchanges the challenge from Login and a TOTP or
+// recovery code for a token
+func (h *AuthHandler) CompleteTwoFactor(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        ChallengeToken string `json:"challenge_token"`
+        Code           string `json:"code"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    client := auth.ClientInfo{UserAgent: r.UserAgent(), IP: middleware.ClientIP(r)}
+    token, err := h.service.CompleteTwoFactor(r.Context(), req.ChallengeToken, req.Code, client)
+    switch {
+    case errors.Is(err, auth.ErrTooManyAttempts):
+        http.Error(w, err.Error(), http.StatusTooManyRequests)
+        return
+    case errors.Is(err, auth.ErrInvalidChallenge), errors.Is(err, auth.ErrInvalidCode):
+        http.Error(w, err.Error(), http.StatusUnauthorized)
+        return
+    case errors.Is(err, auth.ErrTwoFactorUnavailable):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]string{
         "token": token,
     })
 }
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type CacheHandler struct {
+    stats *cache.CacheStatsService
+    admin *cache.CacheAdmin
+    audit *auth.Service
+}
+
+func NewCacheHandler(stats *cache.CacheStatsService) *CacheHandler {
+    return &CacheHandler{stats: stats}
+}
+
+// WithAdmin serves the key inspection and flush endpoints through admin,
+// recording each request in audit's log
+func (h *CacheHandler) WithAdmin(admin *cache.CacheAdmin, audit *auth.Service) *CacheHandler {
+    h.admin = admin
+    h.audit = audit
+    return h
+}
+
+// GetStats reports the estimated Redis memory of each cache namespace,
+// with warnings for namespaces over the configured limit
+func (h *CacheHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+    stats, err := h.stats.GetStats(r.Context())
+    if errors.Is(err, cache.ErrRedisUnavailable) {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, stats)
+}
+
+// ListKeys pages through the keys matching pattern with their TTL and
+// size. The next_cursor of a page fetches the next one, until it is 0.
+func (h *CacheHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    pattern := query.Get("pattern")
+    if pattern == "" {
+        http.Error(w, "pattern is required", http.StatusBadRequest)
+        return
+    }
+    var cursor uint64
+    if v := query.Get("cursor"); v != "" {
+        n, err := strconv.ParseUint(v, 10, 64)
+        if err != nil {
+            http.Error(w, "Invalid cursor", http.StatusBadRequest)
+            return
+        }
+        cursor = n
+    }
+    limit := cache.DefaultKeysPageSize
+    if v := query.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 || n > cache.MaxKeysPageSize {
+            http.Error(w, "Invalid limit", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    if !h.recordAction(w, r, "cache.keys_listed", pattern, "") {
+        return
+    }
+    page, err := h.admin.Keys(r.Context(), pattern, cursor, limit)
+    if err != nil {
+        writeCacheAdminError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, page)
+}
+
+// GetEntry returns the value of a key, decoded when its namespace has a
+// known schema and as stored otherwise
+func (h *CacheHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
+    key := r.URL.Query().Get("key")
+    if key == "" {
+        http.Error(w, "key is required", http.StatusBadRequest)
+        return
+    }
+
+    if !h.recordAction(w, r, "cache.entry_viewed", key, "") {
+        return
+    }
+    entry, err := h.admin.Entry(r.Context(), key)
+    if err != nil {
+        writeCacheAdminError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, entry)
+}
+
+// DeleteEntry removes one cache key, such as a stale or corrupt entry
+func (h *CacheHandler) DeleteEntry(w http.ResponseWriter, r *http.Request) {
+    key := r.URL.Query().Get("key")
+    if key == "" {
+        http.Error(w, "key is required", http.StatusBadRequest)
+        return
+    }
+
+    if !h.recordAction(w, r, "cache.entry_deleted", key, "") {
+        return
+    }
+    if err := h.admin.Delete(r.Context(), key); err != nil {
+        writeCacheAdminError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// FlushPrefix deletes every cache key under prefix. A prefix with more
+// keys than the confirmation threshold answers 409 with a
+// confirmation_token, which confirm must repeat to go ahead.
+func (h *CacheHandler) FlushPrefix(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    prefix := query.Get("prefix")
+    if prefix == "" {
+        http.Error(w, "prefix is required", http.StatusBadRequest)
+        return
+    }
+    token := query.Get("confirm")
+
+    details := ""
+    if token != "" {
+        details = "confirmed"
+    }
+    if !h.recordAction(w, r, "cache.flushed", prefix, details) {
+        return
+    }
+    result, err := h.admin.Flush(r.Context(), prefix, token)
+    var confirm *cache.ConfirmationRequiredError
+    if errors.As(err, &confirm) {
+        render.JSON(w, r, http.StatusConflict, map[string]interface{}{
+            "error":              confirm.Error(),
+            "prefix":             confirm.Prefix,
+            "keys":               confirm.Keys,
+            "confirmation_token": confirm.Token,
+            "expires_in_seconds": int64(confirm.ExpiresIn.Seconds()),
+        })
+        return
+    }
+    if err != nil {
+        writeCacheAdminError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, result)
+}
+
+// recordAction audits a cache admin request before it is served, so none
+// goes unrecorded. It answers 500 itself when the audit can't be written.
+func (h *CacheHandler) recordAction(w http.ResponseWriter, r *http.Request, action, target, details string) bool {
+    actor := r.Context().Value("user").(*models.User)
+    if err := h.audit.RecordAdminAction(r.Context(), actor, action, target, details); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return false
+    }
+    return true
+}
+
+func writeCacheAdminError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, cache.ErrNotCacheKey):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, cache.ErrKeyNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, cache.ErrRedisUnavailable):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+)
+
+func TestCacheHandler_GetStats(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    ctx := context.Background()
+
+    for i := 0; i < 150; i++ {
+        client.Set(ctx, fmt.Sprintf("market:data:SYM%d", i), strings.Repeat("x", 1000), 0)
+    }
+    client.Set(ctx, "prediction:lstm:BTC", `{"confidence": 0.8}`, 0)
+    client.Set(ctx, "session:abc", "not a cache key", 0)
+
+    // A limit tiny enough for market data to exceed
+    handler := NewCacheHandler(cache.NewCacheStatsService(client, 0.1))
+    rec := httptest.NewRecorder()
+    handler.GetStats(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil))
+    if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+        return
+    }
+
+    var stats cache.CacheStats
+    if !assert.NoError(t, json.NewDecoder(rec.Body).Decode(&stats)) {
+        return
+    }
+    assert.Equal(t, int64(151), stats.TotalKeys)
+
+    market := stats.ByNamespace["market:data"]
+    assert.Equal(t, int64(150), market.Keys)
+    assert.Equal(t, 100, market.SampledKeys)
+    // Each value is 1000 bytes, so 150 of them are at least 0.14 MB
+    assert.Greater(t, market.EstimatedMemoryMB, 0.14)
+    assert.Equal(t, int64(1), stats.ByNamespace["prediction"].Keys)
+    assert.Equal(t, int64(0), stats.ByNamespace["portfolio"].Keys)
+
+    if assert.NotEmpty(t, stats.Warnings) {
+        assert.Contains(t, stats.Warnings[0], "namespace market:data")
+    }
+}
+
+func TestCacheHandler_GetStats_RedisDisabled(t *testing.T) {
+    handler := NewCacheHandler(cache.NewCacheStatsService(nil, 256))
+    rec := httptest.NewRecorder()
+    handler.GetStats(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil))
+    assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
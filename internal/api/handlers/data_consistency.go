@@ -0,0 +1,56 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+type DataConsistencyHandler struct {
+    checker *portfolio.ConsistencyChecker
+}
+
+func NewDataConsistencyHandler(checker *portfolio.ConsistencyChecker) *DataConsistencyHandler {
+    return &DataConsistencyHandler{checker: checker}
+}
+
+// ListInconsistencies returns the portfolios whose stored value disagrees
+// with their holdings, the largest difference first
+func (h *DataConsistencyHandler) ListInconsistencies(w http.ResponseWriter, r *http.Request) {
+    open, err := h.checker.Open(r.Context())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, open)
+}
+
+// RepairPortfolio rewrites the portfolio's denormalized holdings and value
+// from the source given as ?source=positions or ?source=assets
+func (h *DataConsistencyHandler) RepairPortfolio(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    result, err := h.checker.Repair(r.Context(), id, r.URL.Query().Get("source"))
+    switch {
+    case errors.Is(err, portfolio.ErrUnknownHoldingsSource):
+        http.Error(w, "source must be positions or assets", http.StatusBadRequest)
+        return
+    case errors.Is(err, portfolio.ErrConsistencyPortfolioNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/jobs"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type ExportHandler struct {
+    exporter *jobs.Exporter
+}
+
+func NewExportHandler(exporter *jobs.Exporter) *ExportHandler {
+    return &ExportHandler{exporter: exporter}
+}
+
+// StartExport queues an export of all the caller's data. Its progress is
+// polled with GetExport, which gives a download URL once it completes.
+func (h *ExportHandler) StartExport(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    job, err := h.exporter.Enqueue(r.Context(), user.ID)
+    if err != nil {
+        writeExportError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusAccepted, job)
+}
+
+func (h *ExportHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid export ID", http.StatusBadRequest)
+        return
+    }
+
+    job, err := h.exporter.Get(r.Context(), user.ID, id)
+    if err != nil {
+        writeExportError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, job)
+}
+
+// DownloadExport serves an export's archive to anyone holding its signed
+// URL, so it needs no session. Range requests resume interrupted downloads.
+func (h *ExportHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid export ID", http.StatusBadRequest)
+        return
+    }
+    expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid download URL", http.StatusBadRequest)
+        return
+    }
+
+    archive, err := h.exporter.Open(r.Context(), id, expires, r.URL.Query().Get("signature"))
+    if err != nil {
+        writeExportError(w, err)
+        return
+    }
+    defer archive.Close()
+
+    name := fmt.Sprintf("export-%d.zip", id)
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+    if seeker, ok := archive.(io.ReadSeeker); ok {
+        http.ServeContent(w, r, name, time.Time{}, seeker)
+        return
+    }
+    io.Copy(w, archive)
+}
+
+func writeExportError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, jobs.ErrExportRateLimited):
+        http.Error(w, err.Error(), http.StatusTooManyRequests)
+    case errors.Is(err, jobs.ErrExportNotFound):
+        http.Error(w, "Export not found", http.StatusNotFound)
+    case errors.Is(err, jobs.ErrInvalidSignature):
+        http.Error(w, err.Error(), http.StatusForbidden)
+    case errors.Is(err, jobs.ErrExportNotReady):
+        http.Error(w, err.Error(), http.StatusGone)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
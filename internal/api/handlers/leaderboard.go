@@ -0,0 +1,78 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/analytics"
+)
+
+type LeaderboardHandler struct {
+    leaderboards *analytics.Leaderboards
+}
+
+func NewLeaderboardHandler(leaderboards *analytics.Leaderboards) *LeaderboardHandler {
+    return &LeaderboardHandler{leaderboards: leaderboards}
+}
+
+// GetModelLeaderboard ranks active models by their prediction outcomes
+// over the window query parameter, 7d, 30d or 90d. sort orders them by
+// accuracy, calibration or error.
+func (h *LeaderboardHandler) GetModelLeaderboard(w http.ResponseWriter, r *http.Request) {
+    window, ok := leaderboardWindow(w, r)
+    if !ok {
+        return
+    }
+
+    lb, err := h.leaderboards.Models(r.Context(), window, r.URL.Query().Get("sort"))
+    if errors.Is(err, analytics.ErrUnknownSort) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, lb)
+}
+
+// GetStrategyLeaderboard ranks the user's paper trading portfolios by
+// their performance over the window query parameter. sort orders them by
+// sharpe, return or drawdown.
+func (h *LeaderboardHandler) GetStrategyLeaderboard(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    window, ok := leaderboardWindow(w, r)
+    if !ok {
+        return
+    }
+
+    lb, err := h.leaderboards.Strategies(r.Context(), user.ID, window, r.URL.Query().Get("sort"))
+    if errors.Is(err, analytics.ErrUnknownSort) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, lb)
+}
+
+// leaderboardWindow parses the window query parameter, answering 400 when
+// it isn't one of analytics.LeaderboardWindows
+func leaderboardWindow(w http.ResponseWriter, r *http.Request) (int, bool) {
+    v := r.URL.Query().Get("window")
+    if v == "" {
+        return analytics.DefaultLeaderboardWindow, true
+    }
+    window, err := analytics.ParseLeaderboardWindow(v)
+    if err != nil {
+        http.Error(w, "window must be 7d, 30d or 90d", http.StatusBadRequest)
+        return 0, false
+    }
+    return window, true
+}
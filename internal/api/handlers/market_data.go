@@ -0,0 +1,28 @@
+package handlers
+
+import (
+    "net/http"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/repository"
+)
+
+type MarketDataHandler struct {
+    store *repository.CompressedMarketDataRepository
+}
+
+func NewMarketDataHandler(store *repository.CompressedMarketDataRepository) *MarketDataHandler {
+    return &MarketDataHandler{store: store}
+}
+
+// GetStats reports the size of the compressed price history and how much
+// the delta encoding saves over raw float64 candles
+func (h *MarketDataHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+    stats, err := h.store.Stats(r.Context())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, stats)
+}
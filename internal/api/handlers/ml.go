@@ -1,21 +1,117 @@
 package handlers
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "net/http"
     "strconv"
+    "sync"
 
     "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
-    "github.com/Cryptoprojectsfun/quantai-clone/internal/validators"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
+// batchPredictWorkers bounds how many batch items run at once
+const batchPredictWorkers = 8
+
+// PredictionService is the part of ml.Service the handler uses
+type PredictionService interface {
+    Predict(ctx context.Context, req *ml.PredictionRequest) (*ml.PredictionResponse, error)
+    ValidateBatch(ctx context.Context, reqs []ml.PredictionRequest) error
+    StartTraining(ctx context.Context, config *ml.TrainingConfig) (int64, error)
+    GetTrainingStatus(ctx context.Context, jobID int64) (string, error)
+}
+
+// PredictionUsage records completed predictions against the caller's quota
+type PredictionUsage interface {
+    RecordPredictions(ctx context.Context, count int) error
+}
+
 type MLHandler struct {
-    service *ml.Service
+    service  PredictionService
+    manager  *ml.ModelManager
+    queue    *ml.PredictionQueue
+    usage    PredictionUsage
+    events   *ml.TrainingEventHub
+    ensemble *ml.Ensemble
+    history  *ml.PredictionHistory
+    trainer  *ml.ModelTrainer
+}
+
+// BatchPredictionResult is the outcome of one batch item. Exactly one of
+// Prediction and Error is set.
+type BatchPredictionResult struct {
+    Index      int                    `json:"index"`
+    Prediction *ml.PredictionResponse `json:"prediction,omitempty"`
+    Error      string                 `json:"error,omitempty"`
+}
+
+type BatchPredictionResponse struct {
+    Results   []BatchPredictionResult `json:"results"`
+    Succeeded int                     `json:"succeeded"`
+    Failed    int                     `json:"failed"`
+}
+
+func NewMLHandler(service PredictionService, manager *ml.ModelManager) *MLHandler {
+    return &MLHandler{
+        service: service,
+        manager: manager,
+    }
+}
+
+// WithQueue routes predictions through queue instead of running them on
+// the request goroutine. Batch items queue as bulk work.
+func (h *MLHandler) WithQueue(queue *ml.PredictionQueue) *MLHandler {
+    h.queue = queue
+    return h
+}
+
+// WithUsage counts successful predictions against usage; failed ones are
+// never counted
+func (h *MLHandler) WithUsage(usage PredictionUsage) *MLHandler {
+    h.usage = usage
+    return h
+}
+
+// WithEnsemble enables the ensemble prediction endpoint
+func (h *MLHandler) WithEnsemble(ensemble *ml.Ensemble) *MLHandler {
+    h.ensemble = ensemble
+    return h
+}
+
+// WithHistory keeps ensemble predictions in history, for portfolio
+// prediction overlays to reuse
+func (h *MLHandler) WithHistory(history *ml.PredictionHistory) *MLHandler {
+    h.history = history
+    return h
 }
 
-func NewMLHandler(service *ml.Service) *MLHandler {
-    return &MLHandler{service: service}
+// WithTrainer enables the hyperparameter search endpoints
+func (h *MLHandler) WithTrainer(trainer *ml.ModelTrainer) *MLHandler {
+    h.trainer = trainer
+    return h
+}
+
+// predict runs req through the queue, at the context's priority, when
+// there is one
+func (h *MLHandler) predict(ctx context.Context, req *ml.PredictionRequest) (*ml.PredictionResponse, error) {
+    if h.queue != nil {
+        return h.queue.Submit(ctx, req)
+    }
+    return h.service.Predict(ctx, req)
+}
+
+func (h *MLHandler) recordUsage(ctx context.Context, count int) {
+    if h.usage == nil || count == 0 {
+        return
+    }
+    if err := h.usage.RecordPredictions(ctx, count); err != nil {
+        logger.FromContext(ctx).Errorf("Failed to record %d predictions: %v", count, err)
+    }
 }
 
 func (h *MLHandler) GetPrediction(w http.ResponseWriter, r *http.Request) {
@@ -38,13 +134,74 @@ func (h *MLHandler) GetPrediction(w http.ResponseWriter, r *http.Request) {
         Version:   req.Version,
     }
 
-    resp, err := h.service.Predict(r.Context(), predReq)
+    resp, err := h.predict(r.Context(), predReq)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writePredictionError(w, r, err)
+        return
+    }
+    h.recordUsage(r.Context(), 1)
+
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+// GetEnsemblePrediction combines every active model applicable to the
+// symbol. Members that fail are flagged in the components rather than
+// failing the request.
+func (h *MLHandler) GetEnsemblePrediction(w http.ResponseWriter, r *http.Request) {
+    if h.ensemble == nil {
+        http.Error(w, "Ensemble predictions are not enabled", http.StatusNotImplemented)
         return
     }
 
-    json.NewEncoder(w).Encode(resp)
+    symbol := mux.Vars(r)["symbol"]
+    result, err := h.ensemble.Predict(r.Context(), symbol)
+    if err != nil {
+        writePredictionError(w, r, err)
+        return
+    }
+
+    var succeeded int
+    for _, c := range result.Components {
+        if !c.Missing {
+            succeeded++
+        }
+    }
+    h.recordUsage(r.Context(), succeeded)
+    if h.history != nil {
+        if _, err := h.history.Record(r.Context(), result); err != nil {
+            logger.FromContext(r.Context()).Errorf("Failed to store ensemble prediction for %s: %v", symbol, err)
+        }
+    }
+
+    render.JSON(w, r, http.StatusOK, result)
+}
+
+// writePredictionError maps prediction errors to responses. Schema
+// mismatches are a 422 listing every offending field, and bulk work turned
+// away by backpressure a 429 saying when to retry.
+func writePredictionError(w http.ResponseWriter, r *http.Request, err error) {
+    var validationErr *ml.FeatureValidationError
+    switch {
+    case errors.Is(err, ml.ErrQueueBackpressure):
+        writeBackpressure(w, err)
+    case errors.As(err, &validationErr):
+        render.JSON(w, r, http.StatusUnprocessableEntity, map[string]interface{}{
+            "errors": validationErr.Mismatches,
+        })
+    case errors.Is(err, ml.ErrModelNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, ml.ErrModelArchived):
+        http.Error(w, err.Error(), http.StatusGone)
+    case errors.Is(err, ml.ErrQueueFull), errors.Is(err, ml.ErrEnsembleUnavailable):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func writeBackpressure(w http.ResponseWriter, err error) {
+    w.Header().Set("Retry-After", strconv.Itoa(int(ml.BulkRetryAfter.Seconds())))
+    http.Error(w, err.Error(), http.StatusTooManyRequests)
 }
 
 func (h *MLHandler) StartTraining(w http.ResponseWriter, r *http.Request) {
@@ -60,7 +217,11 @@ func (h *MLHandler) StartTraining(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]int64{"job_id": jobID})
+    resp := map[string]int64{"job_id": jobID}
+    if config.Seed != nil {
+        resp["seed"] = *config.Seed
+    }
+    render.JSON(w, r, http.StatusOK, resp)
 }
 
 func (h *MLHandler) GetTrainingStatus(w http.ResponseWriter, r *http.Request) {
@@ -77,7 +238,7 @@ func (h *MLHandler) GetTrainingStatus(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]string{"status": status})
+    render.JSON(w, r, http.StatusOK, map[string]string{"status": status})
 }
 
 func (h *MLHandler) BatchPredict(w http.ResponseWriter, r *http.Request) {
@@ -92,15 +253,202 @@ func (h *MLHandler) BatchPredict(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    var responses []ml.PredictionResponse
-    for _, req := range reqs {
-        resp, err := h.service.Predict(r.Context(), &req)
-        if err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
-            return
+    if err := h.service.ValidateBatch(r.Context(), reqs); err != nil {
+        writePredictionError(w, r, err)
+        return
+    }
+    // Turn the batch away whole rather than fail most of its items
+    if h.queue != nil && h.queue.Backpressured(ml.PriorityBulk) {
+        writeBackpressure(w, ml.ErrQueueBackpressure)
+        return
+    }
+
+    ctx := ml.WithPriority(r.Context(), ml.PriorityBulk)
+    resp := BatchPredictionResponse{Results: h.runBatch(ctx, reqs)}
+    for _, result := range resp.Results {
+        if result.Error != "" {
+            resp.Failed++
+        } else {
+            resp.Succeeded++
         }
-        responses = append(responses, *resp)
+    }
+    h.recordUsage(ctx, resp.Succeeded)
+
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+// runBatch predicts reqs on a bounded pool of workers and returns results
+// in input order. Items not started before ctx is done fail with its error.
+func (h *MLHandler) runBatch(ctx context.Context, reqs []ml.PredictionRequest) []BatchPredictionResult {
+    results := make([]BatchPredictionResult, len(reqs))
+    indexes := make(chan int)
+
+    workers := batchPredictWorkers
+    if len(reqs) < workers {
+        workers = len(reqs)
+    }
+
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for idx := range indexes {
+                result := BatchPredictionResult{Index: idx}
+                if err := ctx.Err(); err != nil {
+                    result.Error = err.Error()
+                } else if pred, err := h.predict(ctx, &reqs[idx]); err != nil {
+                    result.Error = err.Error()
+                } else {
+                    result.Prediction = pred
+                }
+                results[idx] = result
+            }
+        }()
+    }
+
+    for i := range reqs {
+        indexes <- i
+    }
+    close(indexes)
+    wg.Wait()
+
+    return results
+}
+
+// GetModel returns a model's info, including the feature schema requests
+// must follow. Without a version the active version is returned.
+func (h *MLHandler) GetModel(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+
+    var info *ml.ModelInfo
+    var err error
+    if version, ok := vars["version"]; ok {
+        info, err = h.manager.GetModel(r.Context(), vars["name"], version)
+    } else {
+        info, err = h.manager.GetActiveModel(r.Context(), vars["name"])
+    }
+    if errors.Is(err, ml.ErrModelNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, info)
+}
+
+func (h *MLHandler) ListModels(w http.ResponseWriter, r *http.Request) {
+    models, err := h.manager.ListModels(r.Context(), r.URL.Query().Get("status"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, models)
+}
+
+func (h *MLHandler) UpdateModelStatus(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+
+    var req struct {
+        Status string `json:"status"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    actor := r.Context().Value("user").(*models.User)
+    var err error
+    switch req.Status {
+    case ml.StatusActive:
+        err = h.manager.ActivateModel(r.Context(), actor.Email, vars["name"], vars["version"])
+    case ml.StatusArchived:
+        err = h.manager.ArchiveModel(r.Context(), vars["name"], vars["version"])
+    default:
+        err = h.manager.UpdateModelStatus(r.Context(), vars["name"], vars["version"], req.Status)
+    }
+    switch {
+    case errors.Is(err, ml.ErrModelNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    case errors.Is(err, ml.ErrModelArchived), errors.Is(err, ml.ErrModelPinned):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// StartHyperparameterSearch starts a grid search over a model's learning
+// rate and batch size. Its trials train for far longer than a request, so
+// it runs in the background and GetHyperparameterSearch reports it.
+func (h *MLHandler) StartHyperparameterSearch(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        ml.HyperparamGrid
+        ValidationSymbol string `json:"validation_symbol"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    search, err := h.trainer.StartHyperparameterSearch(r.Context(), mux.Vars(r)["name"], req.HyperparamGrid, req.ValidationSymbol)
+    if err != nil {
+        writeSearchError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusAccepted, search)
+}
+
+func (h *MLHandler) GetHyperparameterSearch(w http.ResponseWriter, r *http.Request) {
+    search, err := h.trainer.HyperparameterSearch(mux.Vars(r)["name"])
+    if err != nil {
+        writeSearchError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, search)
+}
+
+func writeSearchError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ml.ErrInvalidHyperparamGrid):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, ml.ErrModelNotFound), errors.Is(err, ml.ErrSearchNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, ml.ErrSearchRunning):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// RollbackModel re-activates the version that was active before the
+// current one
+func (h *MLHandler) RollbackModel(w http.ResponseWriter, r *http.Request) {
+    actor := r.Context().Value("user").(*models.User)
+
+    result, err := h.manager.RollbackModel(r.Context(), actor.Email, mux.Vars(r)["name"])
+    switch {
+    case errors.Is(err, ml.ErrModelNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    case errors.Is(err, ml.ErrNoPreviousVersion):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
 
-    json.NewEncoder(w).Encode(responses)
+    render.JSON(w, r, http.StatusOK, result)
 }
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+)
+
+// fakePredictionService fails predictions for symbols in failing
+type fakePredictionService struct {
+    failing map[string]bool
+
+    mu    sync.Mutex
+    calls int
+}
+
+func (f *fakePredictionService) Predict(ctx context.Context, req *ml.PredictionRequest) (*ml.PredictionResponse, error) {
+    f.mu.Lock()
+    f.calls++
+    f.mu.Unlock()
+
+    if f.failing[req.Symbol] {
+        return nil, fmt.Errorf("no market data for %s", req.Symbol)
+    }
+    return &ml.PredictionResponse{Symbol: req.Symbol, Confidence: 0.9}, nil
+}
+
+func (f *fakePredictionService) ValidateBatch(ctx context.Context, reqs []ml.PredictionRequest) error {
+    return nil
+}
+
+func (f *fakePredictionService) StartTraining(ctx context.Context, config *ml.TrainingConfig) (int64, error) {
+    return 0, errors.New("not implemented")
+}
+
+func (f *fakePredictionService) GetTrainingStatus(ctx context.Context, jobID int64) (string, error) {
+    return "", errors.New("not implemented")
+}
+
+type countingUsage struct {
+    count int
+}
+
+func (u *countingUsage) RecordPredictions(ctx context.Context, count int) error {
+    u.count += count
+    return nil
+}
+
+func batchBody(n int) string {
+    reqs := make([]ml.PredictionRequest, n)
+    for i := range reqs {
+        reqs[i] = ml.PredictionRequest{Symbol: fmt.Sprintf("SYM%d", i), ModelName: "lstm", Features: []float64{1}}
+    }
+    body, _ := json.Marshal(reqs)
+    return string(body)
+}
+
+func TestMLHandler_BatchPredict(t *testing.T) {
+    t.Run("Failed item does not fail the batch", func(t *testing.T) {
+        service := &fakePredictionService{failing: map[string]bool{"SYM3": true}}
+        usage := &countingUsage{}
+        handler := NewMLHandler(service, nil).WithUsage(usage)
+
+        req := httptest.NewRequest("POST", "/ml/predict/batch", strings.NewReader(batchBody(10)))
+        rec := httptest.NewRecorder()
+        handler.BatchPredict(rec, req)
+
+        assert.Equal(t, http.StatusOK, rec.Code)
+
+        var resp BatchPredictionResponse
+        if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+            t.Fatalf("Failed to decode response: %v", err)
+        }
+        assert.Equal(t, 9, resp.Succeeded)
+        assert.Equal(t, 1, resp.Failed)
+        if !assert.Len(t, resp.Results, 10) {
+            return
+        }
+        for i, result := range resp.Results {
+            assert.Equal(t, i, result.Index)
+            if i == 3 {
+                assert.Nil(t, result.Prediction)
+                assert.Contains(t, result.Error, "SYM3")
+                continue
+            }
+            assert.Empty(t, result.Error)
+            if assert.NotNil(t, result.Prediction) {
+                assert.Equal(t, fmt.Sprintf("SYM%d", i), result.Prediction.Symbol)
+            }
+        }
+
+        // Only successful predictions count against the quota
+        assert.Equal(t, 9, usage.count)
+    })
+
+    t.Run("Cancelled request stops starting items", func(t *testing.T) {
+        service := &fakePredictionService{}
+        usage := &countingUsage{}
+        handler := NewMLHandler(service, nil).WithUsage(usage)
+
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        req := httptest.NewRequest("POST", "/ml/predict/batch", strings.NewReader(batchBody(10))).WithContext(ctx)
+        rec := httptest.NewRecorder()
+        handler.BatchPredict(rec, req)
+
+        var resp BatchPredictionResponse
+        if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+            t.Fatalf("Failed to decode response: %v", err)
+        }
+        assert.Equal(t, 0, resp.Succeeded)
+        assert.Equal(t, 10, resp.Failed)
+        assert.Equal(t, 0, service.calls)
+        assert.Equal(t, 0, usage.count)
+    })
+
+    t.Run("Oversized batch is rejected", func(t *testing.T) {
+        handler := NewMLHandler(&fakePredictionService{}, nil)
+
+        req := httptest.NewRequest("POST", "/ml/predict/batch", strings.NewReader(batchBody(101)))
+        rec := httptest.NewRecorder()
+        handler.BatchPredict(rec, req)
+
+        assert.Equal(t, http.StatusBadRequest, rec.Code)
+    })
+}
@@ -1,12 +1,27 @@
 package handlers
 
 import (
+    "context"
     "encoding/json"
+    "errors"
+    "fmt"
     "net/http"
     "strconv"
+    "strings"
+    "time"
 
     "github.com/gorilla/mux"
+    "github.com/shopspring/decimal"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/degrade"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/format"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/repository"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
 )
@@ -16,8 +31,38 @@ type PortfolioHandler struct {
     analyzer        *portfolio.PortfolioAnalyzer
     optimizer       *portfolio.PortfolioOptimizer
     riskManager     *risk.RiskManager
+    priceSource     models.PriceSource
+    transfer        *portfolio.PortfolioTransfer
+    riskMonitor     *risk.Monitor
+    search          *repository.PortfolioRepository
+    repo            *repository.PortfolioRepository
+    tiers           *auth.FeatureGate
+    portfolios      *repository.PortfolioRepository
+    stageMetrics    *monitoring.StageMetrics
+    predictions     *ml.PredictionResolver
+    sectionBudget   time.Duration
+    degradedMetrics *degrade.Metrics
+    limits          PositionLimits
 }
 
+// PositionLimits caps the value of a single position and of all of a
+// portfolio's positions, in USD. A zero limit is not enforced.
+type PositionLimits struct {
+    MaxPositionValueUSD  float64
+    MaxPortfolioValueUSD float64
+}
+
+// ErrPositionLimit is returned for positions worth more than PositionLimits
+// allow
+var ErrPositionLimit = errors.New("position limit exceeded")
+
+// MaxBulkDeletePortfolios caps the portfolios one bulk delete request covers
+const MaxBulkDeletePortfolios = 50
+
+// positionPredictionsMaxAge is how long clients may reuse a portfolio's
+// prediction overlay before asking again
+const positionPredictionsMaxAge = time.Minute
+
 func NewPortfolioHandler(
     ps *portfolio.PortfolioService,
     pa *portfolio.PortfolioAnalyzer,
@@ -29,6 +74,127 @@ func NewPortfolioHandler(
         analyzer:        pa,
         optimizer:       po,
         riskManager:     rm,
+        sectionBudget:   degrade.DefaultBudget,
+    }
+}
+
+// WithPriceSource enables live position valuation in GetPortfolio
+func (h *PortfolioHandler) WithPriceSource(source models.PriceSource) *PortfolioHandler {
+    h.priceSource = source
+    return h
+}
+
+// WithTransfer enables portfolio export and import
+func (h *PortfolioHandler) WithTransfer(transfer *portfolio.PortfolioTransfer) *PortfolioHandler {
+    h.transfer = transfer
+    return h
+}
+
+// WithRiskMonitor tells monitor about portfolios whose positions change
+func (h *PortfolioHandler) WithRiskMonitor(monitor *risk.Monitor) *PortfolioHandler {
+    h.riskMonitor = monitor
+    return h
+}
+
+// WithSearch enables full-text portfolio search
+func (h *PortfolioHandler) WithSearch(repo *repository.PortfolioRepository) *PortfolioHandler {
+    h.search = repo
+    return h
+}
+
+// WithRepository enables listing and forking portfolios, and deleting them
+// in bulk
+func (h *PortfolioHandler) WithRepository(repo *repository.PortfolioRepository) *PortfolioHandler {
+    h.repo = repo
+    return h
+}
+
+// WithTiers reports in GetPortfolio whether a portfolio is read-only, as
+// happens to the newest portfolios beyond the limit of a downgraded tier
+func (h *PortfolioHandler) WithTiers(gate *auth.FeatureGate, portfolios *repository.PortfolioRepository) *PortfolioHandler {
+    h.tiers = gate
+    h.portfolios = portfolios
+    return h
+}
+
+// WithStageMetrics exports how long GetPortfolio spends in each stage
+func (h *PortfolioHandler) WithStageMetrics(metrics *monitoring.StageMetrics) *PortfolioHandler {
+    h.stageMetrics = metrics
+    return h
+}
+
+// WithPredictions enables the prediction overlay of positions
+func (h *PortfolioHandler) WithPredictions(resolver *ml.PredictionResolver) *PortfolioHandler {
+    h.predictions = resolver
+    return h
+}
+
+// WithSectionBudget sets how long each of GetPortfolio's optional sections
+// may take before the portfolio is served without it
+func (h *PortfolioHandler) WithSectionBudget(budget time.Duration) *PortfolioHandler {
+    h.sectionBudget = budget
+    return h
+}
+
+// WithDegradedMetrics counts GetPortfolio responses served without a
+// section
+func (h *PortfolioHandler) WithDegradedMetrics(metrics *degrade.Metrics) *PortfolioHandler {
+    h.degradedMetrics = metrics
+    return h
+}
+
+// WithLimits rejects created and imported portfolios whose positions,
+// valued at live prices, exceed limits
+func (h *PortfolioHandler) WithLimits(limits PositionLimits) *PortfolioHandler {
+    h.limits = limits
+    return h
+}
+
+// checkLimits values positions at live prices, or at their entry price
+// without a price source, and checks them against the handler's limits
+func (h *PortfolioHandler) checkLimits(ctx context.Context, positions []models.Position) error {
+    total := decimal.Zero
+    for _, pos := range positions {
+        price := pos.EntryPrice
+        if h.priceSource != nil {
+            live, err := h.priceSource.GetPrice(ctx, pos.Symbol)
+            if err != nil {
+                return fmt.Errorf("failed to get price for %s: %w", pos.Symbol, err)
+            }
+            price = models.DecimalFromFloat(live)
+        }
+        value := pos.MarketValue(price)
+        if limit := h.limits.MaxPositionValueUSD; limit > 0 && value.InexactFloat64() > limit {
+            return fmt.Errorf("%w: position %s is worth $%.2f, above the maximum position value of $%.2f",
+                ErrPositionLimit, pos.Symbol, value.InexactFloat64(), limit)
+        }
+        total = total.Add(value)
+    }
+    if limit := h.limits.MaxPortfolioValueUSD; limit > 0 && total.InexactFloat64() > limit {
+        return fmt.Errorf("%w: portfolio would be worth $%.2f, above the maximum portfolio value of $%.2f",
+            ErrPositionLimit, total.InexactFloat64(), limit)
+    }
+    return nil
+}
+
+// withinLimits writes the error response and returns false when positions
+// exceed the handler's limits
+func (h *PortfolioHandler) withinLimits(w http.ResponseWriter, r *http.Request, positions []models.Position) bool {
+    err := h.checkLimits(r.Context(), positions)
+    if errors.Is(err, ErrPositionLimit) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return false
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return false
+    }
+    return true
+}
+
+func (h *PortfolioHandler) positionsChanged(portfolioID int64) {
+    if h.riskMonitor != nil {
+        h.riskMonitor.PositionsChanged(portfolioID)
     }
 }
 
@@ -39,6 +205,10 @@ func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Reques
         return
     }
 
+    if !h.withinLimits(w, r, portfolio.Positions) {
+        return
+    }
+
     user := r.Context().Value("user").(*models.User)
     portfolio.UserID = user.ID
 
@@ -46,11 +216,18 @@ func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Reques
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
+    h.positionsChanged(portfolio.ID)
 
-    w.WriteHeader(http.StatusCreated)
-    json.NewEncoder(w).Encode(portfolio)
+    render.JSON(w, r, http.StatusCreated, withFormatting(&portfolio))
 }
 
+// GetPortfolio returns the portfolio with its positions valued at live
+// prices, and its analytics, risk and prediction overlay. Each of those is
+// given the section budget, cut short by the request's deadline: a section
+// that fails or runs out of time is served as null, listed under "degraded"
+// with the reason, and named in the X-Degraded header. Admins, and callers
+// sending X-Debug-Timing: 1, also get how long each stage of the request
+// took under "debug".
 func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id, err := strconv.ParseInt(vars["id"], 10, 64)
@@ -59,14 +236,364 @@ func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request)
         return
     }
 
+    ctx, timer := monitoring.WithStageTimer(r.Context())
+    r = r.WithContext(ctx)
+    defer func() { h.stageMetrics.Observe(timer.Breakdown()) }()
+
     user := r.Context().Value("user").(*models.User)
-    portfolio, err := h.portfolioService.Get(r.Context(), id, user.ID)
+    done := monitoring.StartStage(ctx, monitoring.StagePortfolio)
+    p, err := h.portfolioService.Get(ctx, id, user.ID)
+    done()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    resp := struct {
+        formattedPortfolio
+        Positions   []formattedPosition            `json:"positions,omitempty"`
+        ReadOnly    bool                           `json:"read_only"`
+        Analytics   *portfolio.PortfolioMetrics    `json:"analytics"`
+        Risk        *risk.RiskMetrics              `json:"risk"`
+        Predictions []portfolio.PositionPrediction `json:"predictions"`
+        Degraded    []degrade.Missing              `json:"degraded,omitempty"`
+        Debug       *debugInfo                     `json:"debug,omitempty"`
+    }{formattedPortfolio: withFormatting(p)}
+
+    if h.tiers != nil {
+        done := monitoring.StartStage(ctx, monitoring.StageTiers)
+        rank, err := h.portfolios.Rank(ctx, id, user.ID)
+        done()
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        resp.ReadOnly = h.tiers.ReadOnly(user, auth.LimitMaxPortfolios, rank)
+    }
+
+    // A price outage shouldn't hide the portfolio, so serve it unvalued
+    if h.priceSource != nil {
+        positions, err := p.LivePositions(ctx, h.priceSource)
+        if err != nil {
+            logger.FromContext(ctx).Warnf("Failed to value positions for portfolio %d: %v", id, err)
+        } else {
+            resp.Positions = formatPositions(positions)
+        }
+    }
+
+    result := degrade.Run(ctx, degrade.Budget(ctx, h.sectionBudget), h.detailSections(user, p)...)
+    resp.Analytics, _ = result.Values[sectionAnalytics].(*portfolio.PortfolioMetrics)
+    resp.Risk, _ = result.Values[sectionRisk].(*risk.RiskMetrics)
+    resp.Predictions, _ = result.Values[sectionPredictions].([]portfolio.PositionPrediction)
+    resp.Degraded = result.Degraded
+    h.degradedMetrics.Observe("portfolio_detail", result)
+    result.SetHeader(w)
+
+    resp.Debug = timingDebug(r, user, timer)
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+// The optional sections of GetPortfolio
+const (
+    sectionAnalytics   = "analytics"
+    sectionRisk        = "risk"
+    sectionPredictions = "predictions"
+)
+
+// detailSections are the optional sections of p's detail that are
+// configured. The prediction overlay only shows stored predictions, as
+// generating them counts against the caller's daily limit.
+func (h *PortfolioHandler) detailSections(user *models.User, p *models.Portfolio) []degrade.Section {
+    var sections []degrade.Section
+    if h.analyzer != nil {
+        sections = append(sections, degrade.Section{Name: sectionAnalytics, Load: func(ctx context.Context) (interface{}, error) {
+            return h.analyzer.AnalyzePortfolio(ctx, p.ID)
+        }})
+    }
+    if h.riskManager != nil {
+        sections = append(sections, degrade.Section{Name: sectionRisk, Load: func(ctx context.Context) (interface{}, error) {
+            return h.riskManager.AnalyzeRisk(ctx, p.ID)
+        }})
+    }
+    if h.predictions != nil {
+        sections = append(sections, degrade.Section{Name: sectionPredictions, Load: func(ctx context.Context) (interface{}, error) {
+            predictions, _, err := h.predictions.Resolve(ctx, user, portfolio.HeldSymbols(p), false)
+            if err != nil {
+                return nil, err
+            }
+            return portfolio.OverlayPredictions(ctx, p, predictions, h.priceSource), nil
+        }})
+    }
+    return sections
+}
+
+// GetPositionPredictions returns each position with the latest valid
+// prediction of its symbol compared to its entry and current prices.
+// Symbols without one are listed with a null prediction; ?generate=true
+// generates them while the caller's daily prediction limit allows.
+func (h *PortfolioHandler) GetPositionPredictions(w http.ResponseWriter, r *http.Request) {
+    if h.predictions == nil {
+        http.Error(w, "Portfolio predictions are not enabled", http.StatusNotImplemented)
+        return
+    }
+
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    p, err := h.portfolioService.Get(r.Context(), id, user.ID)
     if err != nil {
         http.Error(w, err.Error(), http.StatusNotFound)
         return
     }
 
-    json.NewEncoder(w).Encode(portfolio)
+    generate := r.URL.Query().Get("generate") == "true"
+    predictions, quotaExhausted, err := h.predictions.Resolve(r.Context(), user, portfolio.HeldSymbols(p), generate)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    resp := struct {
+        PortfolioID    int64                          `json:"portfolio_id"`
+        Positions      []portfolio.PositionPrediction `json:"positions"`
+        QuotaExhausted bool                           `json:"quota_exhausted,omitempty"`
+    }{
+        PortfolioID:    id,
+        Positions:      portfolio.OverlayPredictions(r.Context(), p, predictions, h.priceSource),
+        QuotaExhausted: quotaExhausted,
+    }
+
+    freshUntil := time.Now().Add(positionPredictionsMaxAge)
+    for _, stored := range predictions {
+        if stored.ValidUntil.Before(freshUntil) {
+            freshUntil = stored.ValidUntil
+        }
+    }
+    middleware.SetVersion(w, strconv.FormatInt(id, 10)+"@"+p.UpdatedAt.Format(time.RFC3339Nano), freshUntil)
+
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+// formattedPortfolio is a portfolio with the display formatting of its
+// value under "formatting"
+type formattedPortfolio struct {
+    *models.Portfolio
+    Formatting format.Block `json:"formatting"`
+}
+
+func withFormatting(p *models.Portfolio) formattedPortfolio {
+    return formattedPortfolio{Portfolio: p, Formatting: format.Block{
+        "total_value": format.Amount(p.TotalValue, models.PortfolioCurrency),
+    }}
+}
+
+// formattedPosition is a live position with the display formatting of its
+// prices and amounts under "formatting"
+type formattedPosition struct {
+    models.LivePosition
+    Formatting format.Block `json:"formatting"`
+}
+
+func formatPositions(positions []models.LivePosition) []formattedPosition {
+    formatted := make([]formattedPosition, len(positions))
+    for i, pos := range positions {
+        currency := models.PortfolioCurrency
+        formatted[i] = formattedPosition{LivePosition: pos, Formatting: format.Block{
+            "avg_price":      format.Price(pos.AvgPrice, pos.Type, currency),
+            "current_price":  format.Price(decimal.NewFromFloat(pos.CurrentPrice), pos.Type, currency),
+            "value":          format.Amount(pos.Value, currency),
+            "unrealized_pnl": format.Amount(decimal.NewFromFloat(pos.UnrealizedPnL), currency),
+            "day_change":     format.Amount(decimal.NewFromFloat(pos.DayChange), currency),
+        }}
+    }
+    return formatted
+}
+
+// debugInfo is what a response carries under "debug" for callers who ask
+type debugInfo struct {
+    Timing monitoring.TimingBreakdown `json:"timing"`
+}
+
+// timingDebug returns the stage timings so far when user is an admin or
+// the request sent X-Debug-Timing: 1, and nil otherwise
+func timingDebug(r *http.Request, user *models.User, timer *monitoring.StageTimer) *debugInfo {
+    if user.Role != auth.RoleAdmin && !monitoring.TimingRequested(r) {
+        return nil
+    }
+    return &debugInfo{Timing: timer.Breakdown()}
+}
+
+// ListPortfolios returns a page of the user's portfolios, newest first.
+// type=paper lists only paper trading portfolios, type=live the others,
+// e.g. ?type=paper&limit=20&offset=40.
+func (h *PortfolioHandler) ListPortfolios(w http.ResponseWriter, r *http.Request) {
+    if h.repo == nil {
+        http.Error(w, "Portfolio listing is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    var paperTrading *bool
+    switch t := r.URL.Query().Get("type"); t {
+    case "":
+    case "paper", "live":
+        paper := t == "paper"
+        paperTrading = &paper
+    default:
+        http.Error(w, "type must be paper or live", http.StatusBadRequest)
+        return
+    }
+
+    limit, ok := nonNegativeParam(w, r, "limit")
+    if !ok {
+        return
+    }
+    offset, ok := nonNegativeParam(w, r, "offset")
+    if !ok {
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    portfolios, err := h.repo.List(r.Context(), user.ID, paperTrading, limit, offset)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    resp := make([]formattedPortfolio, len(portfolios))
+    for i := range portfolios {
+        resp[i] = withFormatting(&portfolios[i])
+    }
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+// nonNegativeParam parses the query parameter name, 0 when absent,
+// answering 400 when it isn't a non-negative integer
+func nonNegativeParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+    v := r.URL.Query().Get(name)
+    if v == "" {
+        return 0, true
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 0 {
+        http.Error(w, name+" must be a non-negative integer", http.StatusBadRequest)
+        return 0, false
+    }
+    return n, true
+}
+
+// ForkPortfolio creates a paper trading copy of one of the user's
+// portfolios, named by the body, e.g. {"name": "Sandbox"}
+func (h *PortfolioHandler) ForkPortfolio(w http.ResponseWriter, r *http.Request) {
+    if h.repo == nil {
+        http.Error(w, "Portfolio forking is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    var req struct {
+        Name string `json:"name"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+    if strings.TrimSpace(req.Name) == "" {
+        http.Error(w, "name is required", http.StatusBadRequest)
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    fork, err := h.repo.Fork(r.Context(), id, user.ID, req.Name)
+    if errors.Is(err, repository.ErrPortfolioNotFound) {
+        http.Error(w, "Portfolio not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    h.positionsChanged(fork.ID)
+
+    render.JSON(w, r, http.StatusCreated, withFormatting(fork))
+}
+
+// SearchPortfolios finds the user's portfolios by keywords in their name
+// or description, e.g. ?q=retirement+crypto&limit=10
+func (h *PortfolioHandler) SearchPortfolios(w http.ResponseWriter, r *http.Request) {
+    if h.search == nil {
+        http.Error(w, "Portfolio search is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    limit := 10
+    if v := r.URL.Query().Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    portfolios, err := h.search.Search(r.Context(), user.ID, r.URL.Query().Get("q"), limit)
+    if errors.Is(err, repository.ErrSearchQueryTooShort) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if portfolios == nil {
+        portfolios = []*models.Portfolio{}
+    }
+
+    render.JSON(w, r, http.StatusOK, portfolios)
+}
+
+// BulkDeletePortfolios deletes the caller's portfolios among the IDs in the
+// body, e.g. {"ids": [1, 2]}, reporting those it didn't delete rather than
+// failing on them
+func (h *PortfolioHandler) BulkDeletePortfolios(w http.ResponseWriter, r *http.Request) {
+    if h.repo == nil {
+        http.Error(w, "Bulk portfolio deletion is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    var req struct {
+        IDs []int64 `json:"ids"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+    if len(req.IDs) == 0 {
+        http.Error(w, "no portfolio IDs given", http.StatusBadRequest)
+        return
+    }
+    if len(req.IDs) > MaxBulkDeletePortfolios {
+        http.Error(w, fmt.Sprintf("at most %d portfolios can be deleted at once", MaxBulkDeletePortfolios), http.StatusBadRequest)
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    result, err := h.repo.BulkDelete(r.Context(), user.ID, req.IDs)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, result)
 }
 
 func (h *PortfolioHandler) AnalyzePortfolio(w http.ResponseWriter, r *http.Request) {
@@ -84,7 +611,7 @@ func (h *PortfolioHandler) AnalyzePortfolio(w http.ResponseWriter, r *http.Reque
         return
     }
 
-    json.NewEncoder(w).Encode(metrics)
+    render.JSON(w, r, http.StatusOK, metrics)
 }
 
 func (h *PortfolioHandler) OptimizePortfolio(w http.ResponseWriter, r *http.Request) {
@@ -96,32 +623,148 @@ func (h *PortfolioHandler) OptimizePortfolio(w http.ResponseWriter, r *http.Requ
     }
 
     var params struct {
-        RiskTolerance float64 `json:"risk_tolerance"`
+        RiskTolerance        float64                        `json:"risk_tolerance"`
+        ExpectedReturnMethod portfolio.ExpectedReturnMethod `json:"expected_return_method"`
     }
     if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
+    if !params.ExpectedReturnMethod.Valid() {
+        http.Error(w, "expected_return_method must be one of: historical, ewma, capm", http.StatusBadRequest)
+        return
+    }
 
     user := r.Context().Value("user").(*models.User)
-    portfolio, err := h.portfolioService.Get(r.Context(), id, user.ID)
+    p, err := h.portfolioService.Get(r.Context(), id, user.ID)
     if err != nil {
         http.Error(w, err.Error(), http.StatusNotFound)
         return
     }
 
-    symbols := make([]string, len(portfolio.Positions))
-    for i, pos := range portfolio.Positions {
+    symbols := make([]string, len(p.Positions))
+    for i, pos := range p.Positions {
         symbols[i] = pos.Symbol
     }
 
-    result, err := h.optimizer.Optimize(r.Context(), symbols, params.RiskTolerance)
+    result, err := h.optimizer.Optimize(r.Context(), symbols, params.RiskTolerance, params.ExpectedReturnMethod)
+    if errors.Is(err, portfolio.ErrInsufficientData) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
-    json.NewEncoder(w).Encode(result)
+    // The weights of a run that didn't converge are only its last iterate,
+    // so say so at the top level rather than leave it to the diagnostics
+    resp := struct {
+        *portfolio.OptimizationResult
+        Converged bool   `json:"converged"`
+        Warning   string `json:"warning,omitempty"`
+    }{OptimizationResult: result, Converged: result.Diagnostics.Converged}
+    if !resp.Converged {
+        resp.Warning = "optimization did not converge; weights are provisional"
+    }
+
+    render.JSON(w, r, http.StatusOK, resp)
+}
+
+func (h *PortfolioHandler) GetEfficientFrontier(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := strconv.ParseInt(vars["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    points := 20
+    if v := r.URL.Query().Get("points"); v != "" {
+        points, err = strconv.Atoi(v)
+        if err != nil || points < 3 {
+            http.Error(w, "points must be an integer of at least 3", http.StatusBadRequest)
+            return
+        }
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    p, err := h.portfolioService.Get(r.Context(), id, user.ID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    symbols := make([]string, len(p.Positions))
+    for i, pos := range p.Positions {
+        symbols[i] = pos.Symbol
+    }
+
+    frontier, err := h.optimizer.GenerateEfficientFrontier(r.Context(), symbols, points)
+    if errors.Is(err, portfolio.ErrInsufficientData) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, map[string]interface{}{
+        "symbols":  symbols,
+        "frontier": frontier,
+    })
+}
+
+func (h *PortfolioHandler) MonteCarloStressTest(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := strconv.ParseInt(vars["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    var params struct {
+        Scenario    string `json:"scenario"`
+        Simulations int    `json:"simulations"`
+        Seed        *int64 `json:"seed"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if params.Simulations == 0 {
+        params.Simulations = 10000
+    }
+    if params.Simulations < 1 {
+        http.Error(w, "simulations must be positive", http.StatusBadRequest)
+        return
+    }
+
+    scenario, err := risk.LookupScenario(params.Scenario)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    portfolio, err := h.portfolioService.Get(r.Context(), id, user.ID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    dist, err := h.riskManager.MonteCarloStressTest(r.Context(), portfolio.ID, scenario, params.Simulations, params.Seed)
+    if errors.Is(err, risk.ErrNoPositions) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, dist)
 }
 
 func (h *PortfolioHandler) GetRiskMetrics(w http.ResponseWriter, r *http.Request) {
@@ -145,5 +788,103 @@ func (h *PortfolioHandler) GetRiskMetrics(w http.ResponseWriter, r *http.Request
         return
     }
 
-    json.NewEncoder(w).Encode(metrics)
+    render.JSON(w, r, http.StatusOK, metrics)
+}
+
+// ExportPortfolio returns the portfolio as a versioned document that
+// ImportPortfolio accepts. Balance and entry prices are only included with
+// ?include_values=true.
+func (h *PortfolioHandler) ExportPortfolio(w http.ResponseWriter, r *http.Request) {
+    if h.transfer == nil {
+        http.Error(w, "Portfolio export is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    vars := mux.Vars(r)
+    id, err := strconv.ParseInt(vars["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return
+    }
+
+    includeValues := false
+    if v := r.URL.Query().Get("include_values"); v != "" {
+        includeValues, err = strconv.ParseBool(v)
+        if err != nil {
+            http.Error(w, "include_values must be true or false", http.StatusBadRequest)
+            return
+        }
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    portfolio, err := h.portfolioService.Get(r.Context(), id, user.ID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, h.transfer.Export(portfolio, includeValues))
+}
+
+// ImportPortfolio creates a portfolio from an exported document. With
+// ?dry_run=true the document is validated and the changes it would make
+// are returned without writing anything.
+func (h *PortfolioHandler) ImportPortfolio(w http.ResponseWriter, r *http.Request) {
+    if h.transfer == nil {
+        http.Error(w, "Portfolio import is not configured", http.StatusNotImplemented)
+        return
+    }
+
+    dryRun := false
+    if v := r.URL.Query().Get("dry_run"); v != "" {
+        var err error
+        dryRun, err = strconv.ParseBool(v)
+        if err != nil {
+            http.Error(w, "dry_run must be true or false", http.StatusBadRequest)
+            return
+        }
+    }
+
+    var doc portfolio.ExportDocument
+    if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    plan, err := h.transfer.Plan(&doc)
+    if errors.Is(err, portfolio.ErrUnsupportedSchemaVersion) || errors.Is(err, portfolio.ErrInvalidExport) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    if !h.withinLimits(w, r, plan.Portfolio.Positions) {
+        return
+    }
+
+    resp := struct {
+        DryRun    bool                     `json:"dry_run"`
+        Portfolio *models.Portfolio        `json:"portfolio,omitempty"`
+        Changes   []portfolio.ImportChange `json:"changes"`
+    }{DryRun: dryRun, Changes: plan.Changes}
+
+    if dryRun {
+        render.JSON(w, r, http.StatusOK, resp)
+        return
+    }
+
+    user := r.Context().Value("user").(*models.User)
+    plan.Portfolio.UserID = user.ID
+
+    if err := h.transfer.Import(r.Context(), plan); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    resp.Portfolio = &plan.Portfolio
+    h.positionsChanged(plan.Portfolio.ID)
+
+    render.JSON(w, r, http.StatusCreated, resp)
 }
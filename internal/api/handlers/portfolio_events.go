@@ -0,0 +1,91 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+type PortfolioEventsHandler struct {
+    events *portfolio.EventLog
+}
+
+func NewPortfolioEventsHandler(events *portfolio.EventLog) *PortfolioEventsHandler {
+    return &PortfolioEventsHandler{events: events}
+}
+
+// ReplayPortfolio returns the portfolio's state rebuilt from its events as
+// of the as_of query parameter, or now, along with the events applied
+func (h *PortfolioEventsHandler) ReplayPortfolio(w http.ResponseWriter, r *http.Request) {
+    id, ok := eventsPortfolioID(w, r)
+    if !ok {
+        return
+    }
+
+    asOf := time.Now()
+    if v := r.URL.Query().Get("as_of"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            http.Error(w, "as_of must be an RFC 3339 time", http.StatusBadRequest)
+            return
+        }
+        asOf = t
+    }
+
+    state, err := h.events.ReplayPortfolio(r.Context(), id, asOf)
+    if err != nil {
+        writePortfolioEventsError(w, err)
+        return
+    }
+    events, err := h.events.Events(r.Context(), id, asOf)
+    if err != nil {
+        writePortfolioEventsError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, struct {
+        AsOf   time.Time                  `json:"as_of"`
+        State  *portfolio.PortfolioState  `json:"state"`
+        Events []portfolio.PortfolioEvent `json:"events"`
+    }{asOf, state, events})
+}
+
+// CheckConsistency replays the portfolio's events and reports where the
+// result differs from the live tables
+func (h *PortfolioEventsHandler) CheckConsistency(w http.ResponseWriter, r *http.Request) {
+    id, ok := eventsPortfolioID(w, r)
+    if !ok {
+        return
+    }
+
+    report, err := h.events.Verify(r.Context(), id)
+    if err != nil {
+        writePortfolioEventsError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, report)
+}
+
+func eventsPortfolioID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+        return 0, false
+    }
+    return id, true
+}
+
+func writePortfolioEventsError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, portfolio.ErrNoPortfolioEvents):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/repository"
+)
+
+// fixedPrices prices symbols from a map
+type fixedPrices map[string]float64
+
+func (p fixedPrices) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    price, ok := p[symbol]
+    if !ok {
+        return 0, fmt.Errorf("no price for %s", symbol)
+    }
+    return price, nil
+}
+
+func TestPortfolioHandler_CheckLimits(t *testing.T) {
+    handler := NewPortfolioHandler(nil, nil, nil, nil).
+        WithPriceSource(fixedPrices{"AAPL": 200, "BTC": 50000}).
+        WithLimits(PositionLimits{MaxPositionValueUSD: 100000, MaxPortfolioValueUSD: 150000})
+    position := func(symbol string, quantity int64) models.Position {
+        // Entry prices are ignored in favour of live prices
+        return models.Position{Symbol: symbol, Quantity: decimal.NewFromInt(quantity), EntryPrice: decimal.NewFromInt(1)}
+    }
+    ctx := context.Background()
+
+    assert.NoError(t, handler.checkLimits(ctx, []models.Position{position("AAPL", 100), position("BTC", 2)}))
+
+    err := handler.checkLimits(ctx, []models.Position{position("AAPL", 10000)})
+    assert.ErrorIs(t, err, ErrPositionLimit)
+    assert.Contains(t, err.Error(), "position AAPL is worth $2000000.00, above the maximum position value of $100000.00")
+
+    // Each position is within its limit, but together they aren't
+    err = handler.checkLimits(ctx, []models.Position{position("AAPL", 400), position("BTC", 2), position("BTC", 1)})
+    assert.ErrorIs(t, err, ErrPositionLimit)
+    assert.Contains(t, err.Error(), "portfolio would be worth $230000.00, above the maximum portfolio value of $150000.00")
+
+    err = handler.checkLimits(ctx, []models.Position{position("ETH", 1)})
+    assert.Error(t, err)
+    assert.NotErrorIs(t, err, ErrPositionLimit)
+
+    rec := httptest.NewRecorder()
+    ok := handler.withinLimits(rec, httptest.NewRequest(http.MethodPost, "/portfolios", nil),
+        []models.Position{position("AAPL", 10000)})
+    assert.False(t, ok)
+    assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPortfolioHandler_BulkDeletePortfolios(t *testing.T) {
+    call := func(handler *PortfolioHandler, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodDelete, "/portfolios", strings.NewReader(body))
+        req = req.WithContext(context.WithValue(req.Context(), "user", &models.User{ID: uuid.New()}))
+        rec := httptest.NewRecorder()
+        handler.BulkDeletePortfolios(rec, req)
+        return rec
+    }
+
+    rec := call(NewPortfolioHandler(nil, nil, nil, nil), `{"ids": [1]}`)
+    assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+    // Invalid requests are rejected before the database is touched
+    handler := NewPortfolioHandler(nil, nil, nil, nil).WithRepository(repository.NewPortfolioRepository(nil))
+    tooMany := make([]string, MaxBulkDeletePortfolios+1)
+    for i := range tooMany {
+        tooMany[i] = strconv.Itoa(i + 1)
+    }
+    for name, body := range map[string]string{
+        "malformed": `{"ids": [`,
+        "uuid IDs":  `{"ids": ["6ba7b810-9dad-11d1-80b4-00c04fd430c8"]}`,
+        "no IDs":    `{"ids": []}`,
+        "too many":  `{"ids": [` + strings.Join(tooMany, ",") + `]}`,
+    } {
+        rec := call(handler, body)
+        assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+    }
+}
+
+func TestPortfolioHandler_ListPortfolios(t *testing.T) {
+    call := func(handler *PortfolioHandler, query string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/portfolios"+query, nil)
+        req = req.WithContext(context.WithValue(req.Context(), "user", &models.User{ID: uuid.New()}))
+        rec := httptest.NewRecorder()
+        handler.ListPortfolios(rec, req)
+        return rec
+    }
+
+    rec := call(NewPortfolioHandler(nil, nil, nil, nil), "")
+    assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+    handler := NewPortfolioHandler(nil, nil, nil, nil).WithRepository(repository.NewPortfolioRepository(nil))
+    for _, query := range []string{"?type=demo", "?limit=ten", "?offset=-1"} {
+        rec := call(handler, query)
+        assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+    }
+}
+
+func TestPortfolioHandler_ForkPortfolio(t *testing.T) {
+    call := func(handler *PortfolioHandler, id, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodPost, "/portfolios/"+id+"/fork", strings.NewReader(body))
+        req = mux.SetURLVars(req, map[string]string{"id": id})
+        req = req.WithContext(context.WithValue(req.Context(), "user", &models.User{ID: uuid.New()}))
+        rec := httptest.NewRecorder()
+        handler.ForkPortfolio(rec, req)
+        return rec
+    }
+
+    rec := call(NewPortfolioHandler(nil, nil, nil, nil), "1", `{"name": "Sandbox"}`)
+    assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+    handler := NewPortfolioHandler(nil, nil, nil, nil).WithRepository(repository.NewPortfolioRepository(nil))
+    for name, tc := range map[string]struct{ id, body string }{
+        "uuid ID":   {"6ba7b810-9dad-11d1-80b4-00c04fd430c8", `{"name": "Sandbox"}`},
+        "malformed": {"1", `{"name": `},
+        "no name":   {"1", `{"name": "  "}`},
+    } {
+        rec := call(handler, tc.id, tc.body)
+        assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+    }
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type PredictionSubscriptionHandler struct {
+    subscriptions *ml.PredictionSubscriptions
+}
+
+func NewPredictionSubscriptionHandler(subscriptions *ml.PredictionSubscriptions) *PredictionSubscriptionHandler {
+    return &PredictionSubscriptionHandler{subscriptions: subscriptions}
+}
+
+type createPredictionSubscriptionRequest struct {
+    Symbol    string `json:"symbol"`
+    Timeframe string `json:"timeframe"`
+    Cadence   string `json:"cadence"`
+}
+
+// ListSubscriptions returns the caller's prediction subscriptions, each
+// with the latest prediction generated for it
+func (h *PredictionSubscriptionHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    subs, err := h.subscriptions.List(r.Context(), user.ID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, subs)
+}
+
+// CreateSubscription subscribes the caller to scheduled predictions of a
+// symbol. Cadence defaults to daily.
+func (h *PredictionSubscriptionHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+
+    var req createPredictionSubscriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+    if req.Cadence == "" {
+        req.Cadence = ml.CadenceDaily
+    }
+
+    sub, err := h.subscriptions.Create(r.Context(), user.ID, req.Symbol, req.Timeframe, req.Cadence)
+    switch {
+    case errors.Is(err, ml.ErrInvalidSubscription):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case errors.Is(err, ml.ErrSubscriptionExists):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusCreated, sub)
+}
+
+// DeleteSubscription unsubscribes the caller, dropping the predictions
+// generated for the subscription
+func (h *PredictionSubscriptionHandler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+    user := r.Context().Value("user").(*models.User)
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+        return
+    }
+
+    err = h.subscriptions.Delete(r.Context(), user.ID, id)
+    if errors.Is(err, ml.ErrSubscriptionNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
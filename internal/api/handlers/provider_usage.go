@@ -0,0 +1,46 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// Daily provider usage is summarised for up to a quarter
+const (
+    defaultProviderUsageDays = 30
+    maxProviderUsageDays     = 90
+)
+
+type ProviderUsageHandler struct {
+    usage *monitoring.ProviderUsage
+}
+
+func NewProviderUsageHandler(usage *monitoring.ProviderUsage) *ProviderUsageHandler {
+    return &ProviderUsageHandler{usage: usage}
+}
+
+// GetProviderUsage reports each external provider's requests and estimated
+// spend this month against its budget, and the requests of each component
+// by day over the last days days
+func (h *ProviderUsageHandler) GetProviderUsage(w http.ResponseWriter, r *http.Request) {
+    days := defaultProviderUsageDays
+    if v := r.URL.Query().Get("days"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > maxProviderUsageDays {
+            http.Error(w, "days must be between 1 and 90", http.StatusBadRequest)
+            return
+        }
+        days = n
+    }
+
+    summary, err := h.usage.Summary(r.Context(), days)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, summary)
+}
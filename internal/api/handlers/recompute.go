@@ -0,0 +1,108 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/jobs"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type RecomputeHandler struct {
+    recomputer *jobs.Recomputer
+}
+
+func NewRecomputeHandler(recomputer *jobs.Recomputer) *RecomputeHandler {
+    return &RecomputeHandler{recomputer: recomputer}
+}
+
+// StartRecompute queues a recompute of the requested scope. The job runs in
+// the background; its progress is polled with GetRecompute.
+func (h *RecomputeHandler) StartRecompute(w http.ResponseWriter, r *http.Request) {
+    actor := r.Context().Value("user").(*models.User)
+
+    var scope jobs.RecomputeScope
+    if err := json.NewDecoder(r.Body).Decode(&scope); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    job, err := h.recomputer.Enqueue(r.Context(), scope, actor.ID)
+    if err != nil {
+        writeRecomputeError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusAccepted, job)
+}
+
+func (h *RecomputeHandler) GetRecompute(w http.ResponseWriter, r *http.Request) {
+    id, ok := recomputeID(w, r)
+    if !ok {
+        return
+    }
+
+    job, err := h.recomputer.Get(r.Context(), id)
+    if err != nil {
+        writeRecomputeError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, job)
+}
+
+func (h *RecomputeHandler) PauseRecompute(w http.ResponseWriter, r *http.Request) {
+    id, ok := recomputeID(w, r)
+    if !ok {
+        return
+    }
+
+    job, err := h.recomputer.Pause(r.Context(), id)
+    if err != nil {
+        writeRecomputeError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, job)
+}
+
+func (h *RecomputeHandler) ResumeRecompute(w http.ResponseWriter, r *http.Request) {
+    id, ok := recomputeID(w, r)
+    if !ok {
+        return
+    }
+
+    job, err := h.recomputer.Resume(r.Context(), id)
+    if err != nil {
+        writeRecomputeError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, job)
+}
+
+func recomputeID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid job ID", http.StatusBadRequest)
+        return 0, false
+    }
+    return id, true
+}
+
+func writeRecomputeError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, jobs.ErrInvalidScope):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, jobs.ErrRecomputeNotFound):
+        http.Error(w, "Recompute job not found", http.StatusNotFound)
+    case errors.Is(err, jobs.ErrRecomputeState):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/regime"
+)
+
+// Stored regime history is served for up to a year
+const (
+    defaultRegimeHistoryDays = 90
+    maxRegimeHistoryDays     = 365
+)
+
+type RegimeHandler struct {
+    detector *regime.Detector
+}
+
+func NewRegimeHandler(detector *regime.Detector) *RegimeHandler {
+    return &RegimeHandler{detector: detector}
+}
+
+// GetRegime returns the current regime of a symbol, or of the market
+// composite for the symbol MARKET
+func (h *RegimeHandler) GetRegime(w http.ResponseWriter, r *http.Request) {
+    reading, err := h.detector.Detect(r.Context(), mux.Vars(r)["symbol"])
+    if errors.Is(err, regime.ErrInsufficientHistory) {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, reading)
+}
+
+// GetRegimeHistory returns the stored daily regimes of a symbol over the
+// last days days
+func (h *RegimeHandler) GetRegimeHistory(w http.ResponseWriter, r *http.Request) {
+    days := defaultRegimeHistoryDays
+    if v := r.URL.Query().Get("days"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > maxRegimeHistoryDays {
+            http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+            return
+        }
+        days = n
+    }
+
+    readings, err := h.detector.History(r.Context(), mux.Vars(r)["symbol"], days)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if readings == nil {
+        readings = []regime.Reading{}
+    }
+
+    render.JSON(w, r, http.StatusOK, readings)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+)
+
+// sseHeartbeatInterval keeps idle training streams open through proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// WithTrainingEvents enables streaming training progress from hub
+func (h *MLHandler) WithTrainingEvents(hub *ml.TrainingEventHub) *MLHandler {
+    h.events = hub
+    return h
+}
+
+// StreamTrainingEvents streams a training job's status changes, log lines
+// and epoch metrics as Server-Sent Events. Clients resume with
+// Last-Event-ID, and the stream ends once the job completes or fails.
+func (h *MLHandler) StreamTrainingEvents(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    jobID, err := strconv.ParseInt(vars["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid job ID", http.StatusBadRequest)
+        return
+    }
+    if h.events == nil {
+        http.Error(w, "Training events are not enabled", http.StatusNotFound)
+        return
+    }
+
+    status, err := h.service.GetTrainingStatus(r.Context(), jobID)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.Error(w, "Training job not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+    // The stream outlives the server's WriteTimeout, so lift the deadline
+    // for this response only
+    rc := http.NewResponseController(w)
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    rc.Flush()
+
+    stream := &sseStream{w: w, rc: rc}
+    heartbeat := time.NewTicker(sseHeartbeatInterval)
+    defer heartbeat.Stop()
+
+    for {
+        replay, live, ok := h.events.Subscribe(jobID, lastID)
+        if !ok {
+            // Nothing published here, e.g. the job ran before a restart;
+            // fall back to the status in the database
+            h.streamStoredStatus(r.Context(), stream, heartbeat, jobID, status)
+            return
+        }
+
+        for _, event := range replay {
+            if stream.send(event) != nil || isFinalEvent(event) {
+                return
+            }
+            lastID = event.ID
+        }
+        if live == nil {
+            return
+        }
+
+        if !h.streamLive(r.Context(), stream, heartbeat, jobID, live, &lastID) {
+            return
+        }
+        // The subscription was dropped for falling behind; resubscribe
+        // from the last event sent
+    }
+}
+
+// streamLive forwards live events until the job finishes or the client
+// goes away, returning false in either case. It returns true if the hub
+// dropped the subscription.
+func (h *MLHandler) streamLive(ctx context.Context, stream *sseStream, heartbeat *time.Ticker, jobID int64, live chan ml.TrainingEvent, lastID *int64) bool {
+    defer h.events.Unsubscribe(jobID, live)
+
+    for {
+        select {
+        case <-ctx.Done():
+            return false
+        case <-heartbeat.C:
+            if stream.heartbeat() != nil {
+                return false
+            }
+        case event, open := <-live:
+            if !open {
+                return true
+            }
+            if stream.send(event) != nil || isFinalEvent(event) {
+                return false
+            }
+            *lastID = event.ID
+        }
+    }
+}
+
+// streamStoredStatus sends the job's stored status and polls it on every
+// heartbeat until it is terminal
+func (h *MLHandler) streamStoredStatus(ctx context.Context, stream *sseStream, heartbeat *time.Ticker, jobID int64, status string) {
+    event := ml.TrainingEvent{JobID: jobID, Type: ml.EventStatus, Status: status, Time: time.Now()}
+    if stream.send(event) != nil || isFinalEvent(event) {
+        return
+    }
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-heartbeat.C:
+            current, err := h.service.GetTrainingStatus(ctx, jobID)
+            if err != nil || current == status {
+                if stream.heartbeat() != nil {
+                    return
+                }
+                continue
+            }
+            status = current
+            event := ml.TrainingEvent{JobID: jobID, Type: ml.EventStatus, Status: status, Time: time.Now()}
+            if stream.send(event) != nil || isFinalEvent(event) {
+                return
+            }
+        }
+    }
+}
+
+func isFinalEvent(event ml.TrainingEvent) bool {
+    return event.Type == ml.EventStatus && ml.IsTerminalStatus(event.Status)
+}
+
+type sseStream struct {
+    w  http.ResponseWriter
+    rc *http.ResponseController
+}
+
+func (s *sseStream) send(event ml.TrainingEvent) error {
+    data, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    if event.ID > 0 {
+        if _, err := fmt.Fprintf(s.w, "id: %d\n", event.ID); err != nil {
+            return err
+        }
+    }
+    if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+        return err
+    }
+    return s.rc.Flush()
+}
+
+func (s *sseStream) heartbeat() error {
+    if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+        return err
+    }
+    return s.rc.Flush()
+}
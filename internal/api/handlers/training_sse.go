@@ -0,0 +1,125 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+)
+
+// TrainingStatusSource looks up the status of training jobs
+type TrainingStatusSource interface {
+    GetTrainingStatus(ctx context.Context, jobID int64) (string, error)
+}
+
+// TrainingLogStreamer relays training output published to Redis by
+// whichever instance runs the job
+type TrainingLogStreamer struct {
+    client *redis.Client
+    jobs   TrainingStatusSource
+}
+
+func NewTrainingLogStreamer(client *redis.Client, jobs TrainingStatusSource) *TrainingLogStreamer {
+    return &TrainingLogStreamer{client: client, jobs: jobs}
+}
+
+// StreamLogs streams a training job's output lines as Server-Sent Events
+// data frames, ending with a done frame once the job completes or fails.
+// Only lines printed after the client connects are sent.
+func (s *TrainingLogStreamer) StreamLogs(w http.ResponseWriter, r *http.Request) {
+    jobID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid job ID", http.StatusBadRequest)
+        return
+    }
+
+    // Logs are relayed through Redis, so without it there is nothing to
+    // stream
+    if s.client == nil {
+        http.Error(w, "Log streaming requires Redis", http.StatusServiceUnavailable)
+        return
+    }
+
+    // Subscribe before reading the status, so a job finishing in between
+    // is seen either way
+    sub := s.client.Subscribe(r.Context(), ml.TrainingLogChannel(jobID))
+    defer sub.Close()
+    if _, err := sub.Receive(r.Context()); err != nil {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+
+    status, err := s.jobs.GetTrainingStatus(r.Context(), jobID)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.Error(w, "Training job not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    // The stream outlives the server's WriteTimeout, so lift the deadline
+    // for this response only
+    rc := http.NewResponseController(w)
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    rc.Flush()
+
+    stream := &sseStream{w: w, rc: rc}
+    if ml.IsTerminalStatus(status) {
+        done, _ := json.Marshal(ml.TrainingLogMessage{Type: ml.LogMessageDone, Status: status})
+        stream.data(string(done))
+        return
+    }
+
+    heartbeat := time.NewTicker(sseHeartbeatInterval)
+    defer heartbeat.Stop()
+
+    messages := sub.Channel()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-heartbeat.C:
+            if stream.heartbeat() != nil {
+                return
+            }
+        case msg, open := <-messages:
+            if !open {
+                return
+            }
+            if stream.data(msg.Payload) != nil {
+                return
+            }
+            var parsed ml.TrainingLogMessage
+            if json.Unmarshal([]byte(msg.Payload), &parsed) == nil && parsed.Type == ml.LogMessageDone {
+                return
+            }
+        }
+    }
+}
+
+// data sends payload as an unnamed event
+func (s *sseStream) data(payload string) error {
+    if _, err := fmt.Fprintf(s.w, "data: %s\n\n", payload); err != nil {
+        return err
+    }
+    return s.rc.Flush()
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/gorilla/mux"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+)
+
+type staticTrainingStatus string
+
+func (s staticTrainingStatus) GetTrainingStatus(ctx context.Context, jobID int64) (string, error) {
+    return string(s), nil
+}
+
+func TestTrainingLogStreamer_StreamLogs(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    defer client.Close()
+
+    router := mux.NewRouter()
+    router.HandleFunc("/ml/jobs/{id}/logs/stream", NewTrainingLogStreamer(client, staticTrainingStatus("running")).StreamLogs)
+    server := httptest.NewServer(router)
+    defer server.Close()
+
+    resp, err := http.Get(server.URL + "/ml/jobs/7/logs/stream")
+    if err != nil {
+        t.Fatalf("Failed to open stream: %v", err)
+    }
+    defer resp.Body.Close()
+    assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+    // Headers are only written once the handler has subscribed
+    channel := ml.TrainingLogChannel(7)
+    assert.Equal(t, 1, mr.PubSubNumSub(channel)[channel])
+
+    publish := func(msg ml.TrainingLogMessage) {
+        data, _ := json.Marshal(msg)
+        assert.NoError(t, client.Publish(context.Background(), channel, data).Err())
+    }
+    for i := 1; i <= 5; i++ {
+        publish(ml.TrainingLogMessage{Type: ml.LogMessageLine, Line: fmt.Sprintf("epoch=%d loss=0.%d", i, 10-i)})
+    }
+    publish(ml.TrainingLogMessage{Type: ml.LogMessageDone, Status: "completed"})
+
+    frames := make(chan []string, 1)
+    go func() {
+        var data []string
+        scanner := bufio.NewScanner(resp.Body)
+        for scanner.Scan() {
+            if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+                data = append(data, strings.TrimPrefix(line, "data: "))
+            }
+        }
+        frames <- data
+    }()
+
+    var data []string
+    select {
+    case data = <-frames:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Stream did not close after the done frame")
+    }
+
+    if !assert.Len(t, data, 6) {
+        return
+    }
+    for i, frame := range data[:5] {
+        var msg ml.TrainingLogMessage
+        assert.NoError(t, json.Unmarshal([]byte(frame), &msg))
+        assert.Equal(t, ml.LogMessageLine, msg.Type)
+        assert.Equal(t, fmt.Sprintf("epoch=%d loss=0.%d", i+1, 9-i), msg.Line)
+    }
+    assert.JSONEq(t, `{"type":"done","status":"completed"}`, data[5])
+}
+
+func TestTrainingLogStreamer_FinishedJob(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    defer client.Close()
+
+    req := httptest.NewRequest(http.MethodGet, "/ml/jobs/8/logs/stream", nil)
+    req = mux.SetURLVars(req, map[string]string{"id": "8"})
+    rec := httptest.NewRecorder()
+
+    NewTrainingLogStreamer(client, staticTrainingStatus("failed")).StreamLogs(rec, req)
+
+    assert.Equal(t, http.StatusOK, rec.Code)
+    assert.Equal(t, "data: {\"type\":\"done\",\"status\":\"failed\"}\n\n", rec.Body.String())
+}
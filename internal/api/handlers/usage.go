@@ -0,0 +1,112 @@
+package handlers
+
+import (
+    "bytes"
+    "net/http"
+    "time"
+
+    "github.com/google/uuid"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/billing"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Usage history covers the last week unless asked for up to a quarter
+const (
+    defaultUsageHistory = 7 * 24 * time.Hour
+    maxUsageHistory     = 92 * 24 * time.Hour
+)
+
+type UsageHandler struct {
+    ledger *billing.UsageLedger
+}
+
+func NewUsageHandler(ledger *billing.UsageLedger) *UsageHandler {
+    return &UsageHandler{ledger: ledger}
+}
+
+// GetUsageHistory returns the caller's hourly requests and predictions
+// between the from and to query times
+func (h *UsageHandler) GetUsageHistory(w http.ResponseWriter, r *http.Request) {
+    user, ok := r.Context().Value("user").(*models.User)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    h.writeHistory(w, r, user.ID)
+}
+
+// GetAllUsageHistory is GetUsageHistory across every user, or the one given
+// by user_id
+func (h *UsageHandler) GetAllUsageHistory(w http.ResponseWriter, r *http.Request) {
+    userID := uuid.Nil
+    if v := r.URL.Query().Get("user_id"); v != "" {
+        id, err := uuid.Parse(v)
+        if err != nil {
+            http.Error(w, "Invalid user ID", http.StatusBadRequest)
+            return
+        }
+        userID = id
+    }
+    h.writeHistory(w, r, userID)
+}
+
+func (h *UsageHandler) writeHistory(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+    to := time.Now()
+    if v := r.URL.Query().Get("to"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+            return
+        }
+        to = t
+    }
+    from := to.Add(-defaultUsageHistory)
+    if v := r.URL.Query().Get("from"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+            return
+        }
+        from = t
+    }
+    if !from.Before(to) || to.Sub(from) > maxUsageHistory {
+        http.Error(w, "from must be before to and at most 92 days earlier", http.StatusBadRequest)
+        return
+    }
+
+    records, err := h.ledger.History(r.Context(), userID, from, to)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    render.JSON(w, r, http.StatusOK, records)
+}
+
+// ExportBilling returns a CSV of each user's usage in the month query
+// parameter, such as 2024-03, or in the previous month
+func (h *UsageHandler) ExportBilling(w http.ResponseWriter, r *http.Request) {
+    now := time.Now().UTC()
+    month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+    if v := r.URL.Query().Get("month"); v != "" {
+        m, err := time.Parse("2006-01", v)
+        if err != nil {
+            http.Error(w, "month must be formatted as YYYY-MM", http.StatusBadRequest)
+            return
+        }
+        month = m
+    }
+
+    // Buffered so a failed query is still reported as an error
+    var buf bytes.Buffer
+    if err := h.ledger.WriteBillingCSV(r.Context(), &buf, month); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", `attachment; filename="usage-`+month.Format("2006-01")+`.csv"`)
+    w.Write(buf.Bytes())
+}
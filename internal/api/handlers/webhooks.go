@@ -0,0 +1,74 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/webhooks"
+)
+
+type WebhookHandler struct {
+    queue *webhooks.Queue
+}
+
+func NewWebhookHandler(queue *webhooks.Queue) *WebhookHandler {
+    return &WebhookHandler{queue: queue}
+}
+
+type registerWebhookRequest struct {
+    URL    string   `json:"url"`
+    Events []string `json:"events"`
+}
+
+type registerWebhookResponse struct {
+    Webhook *webhooks.Webhook `json:"webhook"`
+    // Secret signs every delivery; it isn't shown again
+    Secret string `json:"secret"`
+}
+
+// RegisterWebhook subscribes a URL to events, such as GLOBAL_REGIME_CHANGE
+func (h *WebhookHandler) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+    var req registerWebhookRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    webhook, secret, err := h.queue.Register(r.Context(), req.URL, req.Events)
+    if err != nil {
+        writeWebhookError(w, err)
+        return
+    }
+
+    render.JSON(w, r, http.StatusCreated, registerWebhookResponse{Webhook: webhook, Secret: secret})
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.queue.Remove(r.Context(), id); err != nil {
+        writeWebhookError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, webhooks.ErrInvalidURL), errors.Is(err, webhooks.ErrNoEvents):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, webhooks.ErrNotFound):
+        http.Error(w, "Webhook not found", http.StatusNotFound)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -31,7 +31,7 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 		}
 
 		token := parts[1]
-		user, err := m.auth.ValidateToken(token)
+		user, err := m.auth.ValidateToken(r.Context(), token)
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client's key for a retryable request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayHeader marks a response replayed from an earlier
+	// request with the same key
+	IdempotentReplayHeader = "Idempotent-Replayed"
+
+	idempotencyKeyPrefix = "idempotency:"
+	maxIdempotencyKeyLen = 255
+	maxIdempotentBody    = 10 << 20
+)
+
+// Idempotency replays the stored response to a request whose Idempotency-Key
+// was already used, instead of running the handler again. Duplicates that
+// arrive while the first request is still running wait for its response.
+//
+// It fails open: without a reachable Redis, requests run as if they carried
+// no key rather than being refused.
+type Idempotency struct {
+	client *redis.Client
+	health *cache.RedisHealth
+	// lockTTL bounds how long a crashed request can hold its key
+	lockTTL time.Duration
+	// resultTTL is how long a response is replayed for
+	resultTTL    time.Duration
+	pollInterval time.Duration
+	waitTimeout  time.Duration
+}
+
+func NewIdempotency(client *redis.Client) *Idempotency {
+	return &Idempotency{
+		client:       client,
+		lockTTL:      30 * time.Second,
+		resultTTL:    24 * time.Hour,
+		pollInterval: 50 * time.Millisecond,
+		waitTimeout:  10 * time.Second,
+	}
+}
+
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (m *Idempotency) WithHealth(health *cache.RedisHealth) *Idempotency {
+	m.health = health
+	return m
+}
+
+// storedResponse is a response kept for replay, with the hash of the
+// request it answered so a key can't be reused for a different request
+type storedResponse struct {
+	RequestHash string      `json:"request_hash"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Handle applies to requests carrying an Idempotency-Key; others pass
+// straight through. Keys are scoped to the user, method and path.
+func (m *Idempotency) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.client == nil || !m.health.Available() {
+			m.health.Fallback("idempotency", nil)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(r, key)
+		requestHash := hashRequest(body)
+		deadline := time.Now().Add(m.waitTimeout)
+		ticker := time.NewTicker(m.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			stored, locked, err := m.claim(r.Context(), scope)
+			if err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				m.health.Fallback("idempotency", err)
+				logger.FromContext(r.Context()).Warnf("Idempotency key unchecked, Redis unavailable: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if stored != nil {
+				if stored.RequestHash != requestHash {
+					http.Error(w, "Idempotency key was used for a different request", http.StatusUnprocessableEntity)
+					return
+				}
+				replay(w, stored)
+				return
+			}
+			if locked {
+				m.serve(w, r, next, scope, requestHash)
+				return
+			}
+
+			// Another request with the key is running; wait for its
+			// response, or take over if it gives up its lock without one
+			if time.Now().After(deadline) {
+				http.Error(w, "A request with this idempotency key is still in progress", http.StatusConflict)
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// claim returns the stored response for scope, or takes its lock when
+// there is none. The lookup and lock run in one transaction, so of
+// concurrent duplicates exactly one finds neither and holds the lock.
+func (m *Idempotency) claim(ctx context.Context, scope string) (*storedResponse, bool, error) {
+	var result *redis.StringCmd
+	var lock *redis.BoolCmd
+	_, err := m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		result = pipe.Get(ctx, scope+":result")
+		lock = pipe.SetNX(ctx, scope+":lock", 1, m.lockTTL)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+
+	raw, err := result.Bytes()
+	if err == redis.Nil {
+		return nil, lock.Val(), nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// The response was stored after the lock was released, so any lock
+	// just taken guards nothing
+	if lock.Val() {
+		m.client.Del(ctx, scope+":lock")
+	}
+
+	var stored storedResponse
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, false, err
+	}
+	return &stored, false, nil
+}
+
+// serve runs next and stores its response for replay. Server errors
+// aren't stored, so the request can be retried with the same key.
+func (m *Idempotency) serve(w http.ResponseWriter, r *http.Request, next http.Handler, scope, requestHash string) {
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		// Stores outlive a cancelled request, so waiting duplicates get
+		// the response
+		ctx, cancel := lifecycle.Detach(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if rec.status < http.StatusInternalServerError {
+			stored, err := json.Marshal(storedResponse{
+				RequestHash: requestHash,
+				Status:      rec.status,
+				Header:      rec.Header().Clone(),
+				Body:        rec.body.Bytes(),
+			})
+			if err == nil {
+				m.client.Set(ctx, scope+":result", stored, m.resultTTL)
+			}
+		}
+		m.client.Del(ctx, scope+":lock")
+	}()
+
+	next.ServeHTTP(rec, r)
+}
+
+func replay(w http.ResponseWriter, stored *storedResponse) {
+	for k, v := range stored.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(IdempotentReplayHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+func idempotencyScope(r *http.Request, key string) string {
+	user := "anonymous"
+	if u, ok := r.Context().Value("user").(*models.User); ok {
+		user = u.ID.String()
+	}
+	sum := sha256.Sum256([]byte(user + "\n" + r.Method + "\n" + r.URL.Path + "\n" + key))
+	return idempotencyKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func hashRequest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// responseRecorder passes a response through while keeping a copy
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.status = status
+	rec.wroteHeader = true
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency_ConcurrentDuplicates(t *testing.T) {
+	mr := miniredis.RunT(t)
+	idempotency := NewIdempotency(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	var calls int32
+	handler := idempotency.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		// Slow enough that the duplicate arrives while this one runs
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"order":%d}`, n)
+	}))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/portfolios", strings.NewReader(`{"name":"Growth"}`))
+		req.Header.Set(IdempotencyKeyHeader, "create-growth")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	start := make(chan struct{})
+	responses := make([]*httptest.ResponseRecorder, 2)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			responses[i] = send()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, rec := range responses {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	assert.Equal(t, responses[0].Body.String(), responses[1].Body.String())
+	// Exactly one of the two was served from the stored result
+	replayed := responses[0].Header().Get(IdempotentReplayHeader) + responses[1].Header().Get(IdempotentReplayHeader)
+	assert.Equal(t, "true", replayed)
+
+	t.Run("Later retry is replayed", func(t *testing.T) {
+		rec := send()
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, responses[0].Body.String(), rec.Body.String())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestIdempotency_Handle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	idempotency := NewIdempotency(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	idempotency.waitTimeout = 100 * time.Millisecond
+
+	var calls int32
+	status := http.StatusOK
+	handler := idempotency.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(status)
+	}))
+
+	send := func(key, body string) int {
+		req := httptest.NewRequest("POST", "/api/v1/portfolios", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("No key", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		send("", `{}`)
+		send("", `{}`)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("Key reused for a different body", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("reused", `{"name":"Growth"}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, send("reused", `{"name":"Income"}`))
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		status = http.StatusInternalServerError
+		assert.Equal(t, http.StatusInternalServerError, send("retried", `{}`))
+		status = http.StatusOK
+		assert.Equal(t, http.StatusOK, send("retried", `{}`))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("Key still locked", func(t *testing.T) {
+		mr.Set(idempotencyScope(httptest.NewRequest("POST", "/api/v1/portfolios", nil), "locked")+":lock", "1")
+		assert.Equal(t, http.StatusConflict, send("locked", `{}`))
+	})
+
+	t.Run("Redis unavailable", func(t *testing.T) {
+		// Requests run unprotected rather than fail
+		atomic.StoreInt32(&calls, 0)
+		mr.Close()
+		assert.Equal(t, http.StatusOK, send("down", `{}`))
+		assert.Equal(t, http.StatusOK, send("down", `{}`))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("Redis disabled", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		disabled := NewIdempotency(nil).Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+		}))
+		req := httptest.NewRequest("POST", "/api/v1/portfolios", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "disabled")
+		rec := httptest.NewRecorder()
+		disabled.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
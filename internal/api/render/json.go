@@ -0,0 +1,289 @@
+package render
+
+import (
+    "bytes"
+    "context"
+    "encoding"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// WarningsField is the field of an object response the fields written as
+// null in place of NaN or infinite numbers are listed under
+const WarningsField = "warnings"
+
+// ImpersonatedByField is the field of an object response naming the admin
+// who made the request while impersonating; see WithImpersonator
+const ImpersonatedByField = "impersonated_by"
+
+type impersonatorKey struct{}
+
+// WithImpersonator marks responses to requests made with ctx as made by
+// adminEmail while impersonating, so JSON names them under
+// ImpersonatedByField
+func WithImpersonator(ctx context.Context, adminEmail string) context.Context {
+    return context.WithValue(ctx, impersonatorKey{}, adminEmail)
+}
+
+// JSON writes v as the response body with status. v is encoded before
+// anything is written, so a value that can't be encoded gets a 500 instead
+// of a half-written body. NaN and infinite numbers, which JSON can't
+// represent, are written as null; see Marshal.
+func JSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+    body, err := Marshal(v)
+    if err != nil {
+        logger.FromContext(r.Context()).Errorf("Failed to encode %s %s response: %v", r.Method, r.URL.Path, err)
+        http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+        return
+    }
+    if admin, ok := r.Context().Value(impersonatorKey{}).(string); ok {
+        body = withField(body, ImpersonatedByField, admin)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    w.Write(body)
+}
+
+// withField adds name to body when it encodes an object, ahead of its
+// other fields
+func withField(body []byte, name string, value interface{}) []byte {
+    if len(body) == 0 || body[0] != '{' {
+        return body
+    }
+    field, err := json.Marshal(map[string]interface{}{name: value})
+    if err != nil {
+        return body
+    }
+
+    out := append([]byte{}, field[:len(field)-1]...)
+    if rest := bytes.TrimSpace(body[1:]); len(rest) > 0 && rest[0] != '}' {
+        out = append(out, ',')
+    }
+    return append(out, body[1:]...)
+}
+
+// Marshal encodes v as encoding/json would, newline terminated like
+// json.Encoder. Where v holds NaN or infinite numbers they are encoded as
+// null instead of failing, and when v encodes as an object the fields
+// affected are listed under WarningsField, e.g.
+// "risk_metrics.BTC.sharpe_ratio is NaN".
+func Marshal(v interface{}) ([]byte, error) {
+    var buf bytes.Buffer
+    err := json.NewEncoder(&buf).Encode(v)
+    var unsupported *json.UnsupportedValueError
+    if !errors.As(err, &unsupported) {
+        return buf.Bytes(), err
+    }
+
+    s := &sanitizer{}
+    clean, err := s.value(reflect.ValueOf(v), "")
+    if err != nil {
+        return nil, err
+    }
+    if obj, ok := clean.(map[string]interface{}); ok && len(s.warnings) > 0 {
+        warnings, _ := obj[WarningsField].([]interface{})
+        for _, w := range s.warnings {
+            warnings = append(warnings, w)
+        }
+        obj[WarningsField] = warnings
+    }
+
+    buf.Reset()
+    if err := json.NewEncoder(&buf).Encode(clean); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+var (
+    marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+    textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// sanitizer copies a value into the maps, slices and scalars it encodes as,
+// following encoding/json's rules, replacing NaN and infinite numbers with
+// nil and noting the path of each
+type sanitizer struct {
+    warnings []string
+}
+
+func (s *sanitizer) value(v reflect.Value, path string) (interface{}, error) {
+    if !v.IsValid() {
+        return nil, nil
+    }
+    if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+        if v.IsNil() {
+            return nil, nil
+        }
+    }
+    // Types that encode themselves are left to do so
+    if v.Type().Implements(marshalerType) || v.Type().Implements(textMarshalerType) ||
+        (v.CanAddr() && (v.Addr().Type().Implements(marshalerType) || v.Addr().Type().Implements(textMarshalerType))) {
+        raw, err := json.Marshal(v.Interface())
+        if err != nil {
+            return nil, err
+        }
+        return json.RawMessage(raw), nil
+    }
+
+    switch v.Kind() {
+    case reflect.Ptr, reflect.Interface:
+        return s.value(v.Elem(), path)
+    case reflect.Float32, reflect.Float64:
+        f := v.Float()
+        if math.IsNaN(f) || math.IsInf(f, 0) {
+            s.warnings = append(s.warnings, fmt.Sprintf("%s is %v", pathOrRoot(path), f))
+            return nil, nil
+        }
+        return f, nil
+    case reflect.Struct:
+        obj := make(map[string]interface{})
+        if err := s.fields(v, path, obj); err != nil {
+            return nil, err
+        }
+        return obj, nil
+    case reflect.Map:
+        if v.IsNil() {
+            return nil, nil
+        }
+        obj := make(map[string]interface{}, v.Len())
+        iter := v.MapRange()
+        for iter.Next() {
+            key, err := mapKey(iter.Key())
+            if err != nil {
+                return nil, err
+            }
+            if obj[key], err = s.value(iter.Value(), joinPath(path, key)); err != nil {
+                return nil, err
+            }
+        }
+        return obj, nil
+    case reflect.Slice, reflect.Array:
+        if v.Kind() == reflect.Slice && v.IsNil() {
+            return nil, nil
+        }
+        if v.Type().Elem().Kind() == reflect.Uint8 {
+            return v.Interface(), nil
+        }
+        items := make([]interface{}, v.Len())
+        for i := range items {
+            item, err := s.value(v.Index(i), path+"["+strconv.Itoa(i)+"]")
+            if err != nil {
+                return nil, err
+            }
+            items[i] = item
+        }
+        return items, nil
+    default:
+        return v.Interface(), nil
+    }
+}
+
+// fields adds the encoded fields of struct v to obj, including those of
+// its embedded structs
+func (s *sanitizer) fields(v reflect.Value, path string, obj map[string]interface{}) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        tag := field.Tag.Get("json")
+        if tag == "-" {
+            continue
+        }
+        name, opts, _ := strings.Cut(tag, ",")
+
+        fv := v.Field(i)
+        if field.Anonymous && name == "" {
+            ft := field.Type
+            if ft.Kind() == reflect.Ptr {
+                ft = ft.Elem()
+            }
+            if ft.Kind() == reflect.Struct {
+                if fv.Kind() == reflect.Ptr {
+                    if fv.IsNil() {
+                        continue
+                    }
+                    fv = fv.Elem()
+                }
+                if err := s.fields(fv, path, obj); err != nil {
+                    return err
+                }
+                continue
+            }
+        }
+        if !field.IsExported() {
+            continue
+        }
+        if name == "" {
+            name = field.Name
+        }
+        if strings.Contains(","+opts+",", ",omitempty,") && isEmpty(fv) {
+            continue
+        }
+
+        value, err := s.value(fv, joinPath(path, name))
+        if err != nil {
+            return err
+        }
+        obj[name] = value
+    }
+    return nil
+}
+
+func mapKey(k reflect.Value) (string, error) {
+    if k.Kind() == reflect.String {
+        return k.String(), nil
+    }
+    if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+        text, err := tm.MarshalText()
+        return string(text), err
+    }
+    switch k.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return strconv.FormatInt(k.Int(), 10), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return strconv.FormatUint(k.Uint(), 10), nil
+    }
+    return "", &json.UnsupportedTypeError{Type: k.Type()}
+}
+
+// isEmpty reports whether omitempty leaves v out
+func isEmpty(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+        return v.Len() == 0
+    case reflect.Bool:
+        return !v.Bool()
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return v.Int() == 0
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return v.Uint() == 0
+    case reflect.Float32, reflect.Float64:
+        return v.Float() == 0
+    case reflect.Interface, reflect.Ptr:
+        return v.IsNil()
+    }
+    return false
+}
+
+func joinPath(path, name string) string {
+    if path == "" {
+        return name
+    }
+    return path + "." + name
+}
+
+func pathOrRoot(path string) string {
+    if path == "" {
+        return "value"
+    }
+    return path
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+    "encoding/json"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// riskMetrics has the shape of the analytics risk metrics, which can't be
+// imported here as analytics depends on render through monitoring
+type riskMetrics struct {
+    Volatility   float64 `json:"volatility"`
+    SharpeRatio  float64 `json:"sharpe_ratio"`
+    SortinoRatio float64 `json:"sortino_ratio"`
+    MaxDrawdown  float64 `json:"max_drawdown"`
+}
+
+func TestJSON_RiskMetricsWithNaN(t *testing.T) {
+    // A constant price series has no volatility, leaving the Sharpe and
+    // Sortino ratios undefined
+    metrics := riskMetrics{
+        Volatility:   0,
+        SharpeRatio:  math.NaN(),
+        SortinoRatio: math.Inf(1),
+        MaxDrawdown:  0.1,
+    }
+
+    rec := httptest.NewRecorder()
+    JSON(rec, httptest.NewRequest(http.MethodGet, "/portfolios/1/risk", nil), http.StatusOK, metrics)
+
+    assert.Equal(t, http.StatusOK, rec.Code)
+    assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+    require.True(t, json.Valid(rec.Body.Bytes()), rec.Body.String())
+
+    var decoded map[string]interface{}
+    require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+    assert.Nil(t, decoded["sharpe_ratio"])
+    assert.Contains(t, decoded, "sharpe_ratio")
+    assert.Nil(t, decoded["sortino_ratio"])
+    assert.Equal(t, 0.1, decoded["max_drawdown"])
+    assert.Equal(t, []interface{}{"sharpe_ratio is NaN", "sortino_ratio is +Inf"}, decoded["warnings"])
+
+    // The null round-trips back into the struct's zero value
+    var back riskMetrics
+    require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &back))
+    assert.Equal(t, 0.1, back.MaxDrawdown)
+}
+
+func TestMarshal_NestedPaths(t *testing.T) {
+    type point struct {
+        Date  time.Time `json:"date"`
+        Score float64   `json:"score"`
+        Note  string    `json:"note,omitempty"`
+    }
+    body, err := Marshal(struct {
+        Metrics  map[string]riskMetrics `json:"risk_metrics"`
+        Points   []point                `json:"points"`
+        Warnings []string               `json:"warnings"`
+        Hidden   float64                `json:"-"`
+    }{
+        Metrics:  map[string]riskMetrics{"BTC": {SharpeRatio: math.NaN()}},
+        Points:   []point{{Score: 1}, {Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Score: math.Inf(-1)}},
+        Warnings: []string{"optimization did not converge"},
+        Hidden:   math.NaN(),
+    })
+    require.NoError(t, err)
+
+    var decoded map[string]interface{}
+    require.NoError(t, json.Unmarshal(body, &decoded))
+    assert.NotContains(t, decoded, "Hidden")
+    points := decoded["points"].([]interface{})
+    assert.Equal(t, "2024-01-02T00:00:00Z", points[1].(map[string]interface{})["date"])
+    assert.NotContains(t, points[0], "note")
+
+    // Warnings the response already had are kept
+    assert.Equal(t, []interface{}{
+        "optimization did not converge",
+        "risk_metrics.BTC.sharpe_ratio is NaN",
+        "points[1].score is -Inf",
+    }, decoded["warnings"])
+}
+
+func TestMarshal_FiniteValuesUnchanged(t *testing.T) {
+    v := riskMetrics{Volatility: 0.2, SharpeRatio: 1.5}
+    want, err := json.Marshal(v)
+    require.NoError(t, err)
+
+    body, err := Marshal(v)
+    require.NoError(t, err)
+    assert.Equal(t, string(want)+"\n", string(body))
+
+    // Bodies other than objects have nowhere to hold warnings
+    body, err = Marshal([]float64{1, math.NaN()})
+    require.NoError(t, err)
+    assert.JSONEq(t, `[1, null]`, string(body))
+}
+
+func TestJSON_EncodingFailure(t *testing.T) {
+    rec := httptest.NewRecorder()
+    JSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusCreated, map[string]interface{}{
+        "results": make(chan int),
+    })
+
+    // Nothing of the body was written before encoding failed
+    assert.Equal(t, http.StatusInternalServerError, rec.Code)
+    assert.NotEqual(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+func TestJSON_Impersonated(t *testing.T) {
+    render := func(v interface{}) string {
+        req := httptest.NewRequest(http.MethodGet, "/portfolios", nil)
+        req = req.WithContext(WithImpersonator(req.Context(), "support@wolfai.com"))
+        rec := httptest.NewRecorder()
+        JSON(rec, req, http.StatusOK, v)
+        require.True(t, json.Valid(rec.Body.Bytes()), rec.Body.String())
+        return rec.Body.String()
+    }
+
+    assert.JSONEq(t, `{"impersonated_by": "support@wolfai.com", "volatility": 0.2, "sharpe_ratio": 1.5, "sortino_ratio": 0, "max_drawdown": 0}`,
+        render(riskMetrics{Volatility: 0.2, SharpeRatio: 1.5}))
+    assert.JSONEq(t, `{"impersonated_by": "support@wolfai.com"}`, render(map[string]int{}))
+    // Bodies other than objects have nowhere to name the admin
+    assert.JSONEq(t, `[1, 2]`, render([]int{1, 2}))
+}
@@ -1,6 +1,8 @@
 package validators
 
 import (
+    "github.com/shopspring/decimal"
+
     "github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
@@ -9,7 +11,7 @@ type CreatePortfolioRequest struct {
     Name        string         `json:"name"`
     Description string         `json:"description"`
     RiskLevel   models.RiskLevel `json:"risk_level"`
-    Balance     float64        `json:"balance"`
+    Balance     decimal.Decimal `json:"balance"`
     Strategy    string         `json:"strategy"`
 }
 
@@ -23,7 +25,7 @@ func (r *CreatePortfolioRequest) Validate() []middleware.ValidationError {
         })
     }
 
-    if r.Balance < 0 {
+    if r.Balance.IsNegative() {
         errors = append(errors, middleware.ValidationError{
             Field:   "balance",
             Message: "must be non-negative",
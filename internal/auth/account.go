@@ -0,0 +1,310 @@
+package auth
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "golang.org/x/crypto/bcrypt"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// AccountPurgeGrace is how long a deleted account's data is kept before purge
+const AccountPurgeGrace = 30 * 24 * time.Hour
+
+var (
+    ErrInvalidPassword = errors.New("current password is incorrect")
+    ErrInvalidProfile  = errors.New("invalid profile")
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ProfileUpdate holds the fields a user may change on their own account.
+// Nil fields are left unchanged.
+type ProfileUpdate struct {
+    Name         *string `json:"name"`
+    Timezone     *string `json:"timezone"`
+    BaseCurrency *string `json:"base_currency"`
+}
+
+func (u ProfileUpdate) validate() error {
+    if u.Name != nil && (strings.TrimSpace(*u.Name) == "" || len(*u.Name) > 255) {
+        return fmt.Errorf("%w: name must be between 1 and 255 characters", ErrInvalidProfile)
+    }
+    if u.Timezone != nil {
+        if _, err := time.LoadLocation(*u.Timezone); err != nil || *u.Timezone == "" {
+            return fmt.Errorf("%w: unknown timezone %q", ErrInvalidProfile, *u.Timezone)
+        }
+    }
+    if u.BaseCurrency != nil && !currencyPattern.MatchString(*u.BaseCurrency) {
+        return fmt.Errorf("%w: base currency must be an ISO 4217 code", ErrInvalidProfile)
+    }
+    return nil
+}
+
+// GetProfile returns the account of an active user
+func (s *Service) GetProfile(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+    var user models.User
+    query := `
+        SELECT id, email, name, role, subscription_tier, timezone, base_currency,
+               created_at, updated_at, last_login_at
+        FROM users
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+    err := s.db.QueryRowContext(ctx, query, userID).Scan(
+        &user.ID, &user.Email, &user.Name, &user.Role, &user.SubscriptionTier,
+        &user.Timezone, &user.BaseCurrency,
+        &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+    )
+    if err == sql.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &user, nil
+}
+
+// UpdateProfile applies update to the actor's own account
+func (s *Service) UpdateProfile(ctx context.Context, actor *models.User, update ProfileUpdate) (*models.User, error) {
+    if err := update.validate(); err != nil {
+        return nil, err
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    query := `
+        UPDATE users
+        SET name = COALESCE($1, name),
+            timezone = COALESCE($2, timezone),
+            base_currency = COALESCE($3, base_currency),
+            updated_at = $4
+        WHERE id = $5 AND deleted_at IS NULL
+    `
+    result, err := tx.ExecContext(ctx, query,
+        update.Name, update.Timezone, update.BaseCurrency, time.Now(), actor.ID,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to update profile: %w", err)
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return nil, err
+    } else if n == 0 {
+        return nil, ErrUserNotFound
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.profile_updated", actor.ID.String(), update.changedFields()); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return s.GetProfile(ctx, actor.ID)
+}
+
+func (u ProfileUpdate) changedFields() string {
+    var fields []string
+    if u.Name != nil {
+        fields = append(fields, "name")
+    }
+    if u.Timezone != nil {
+        fields = append(fields, "timezone")
+    }
+    if u.BaseCurrency != nil {
+        fields = append(fields, "base_currency")
+    }
+    return strings.Join(fields, ",")
+}
+
+// ChangePassword replaces the actor's password after checking the current
+// one. Every existing token, including currentToken, is revoked along with
+// all other sessions; the returned token keeps the caller's session alive.
+func (s *Service) ChangePassword(ctx context.Context, actor *models.User, currentToken, currentPassword, newPassword string) (string, error) {
+    if err := ValidatePassword(newPassword); err != nil {
+        return "", err
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return "", err
+    }
+    defer tx.Rollback()
+
+    var hash string
+    err = tx.QueryRowContext(ctx,
+        "SELECT password_hash FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE",
+        actor.ID,
+    ).Scan(&hash)
+    if err == sql.ErrNoRows {
+        return "", ErrUserNotFound
+    }
+    if err != nil {
+        return "", err
+    }
+
+    if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(currentPassword)); err != nil {
+        return "", ErrInvalidPassword
+    }
+
+    newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+    if err != nil {
+        return "", err
+    }
+
+    now := time.Now()
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE users SET password_hash = $1, tokens_revoked_at = $2, updated_at = $2 WHERE id = $3",
+        newHash, now, actor.ID,
+    ); err != nil {
+        return "", fmt.Errorf("failed to update password: %w", err)
+    }
+
+    sessionID := s.SessionID(currentToken)
+    if err := revokeOtherSessions(ctx, tx, actor.ID, sessionID, now); err != nil {
+        return "", err
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.password_changed", actor.ID.String(), ""); err != nil {
+        return "", err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return "", err
+    }
+
+    if err := s.revoke(ctx, currentToken, tokenLifetime); err != nil {
+        return "", fmt.Errorf("failed to revoke current token: %w", err)
+    }
+
+    // The new token replaces the revoked one in the same session
+    token, err := s.issueToken(actor, sessionID)
+    if err != nil {
+        return "", err
+    }
+    s.metrics.countToken(TokenRefreshed)
+    return token, nil
+}
+
+// DeleteAccount soft-deletes the actor's account, revokes all of its tokens
+// and schedules its data for purge after AccountPurgeGrace. Deleting an
+// already deleted account is a no-op.
+func (s *Service) DeleteAccount(ctx context.Context, actor *models.User, currentToken string) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    now := time.Now()
+    query := `
+        UPDATE users
+        SET deleted_at = $1, purge_after = $2, tokens_revoked_at = $1, updated_at = $1
+        WHERE id = $3 AND deleted_at IS NULL
+    `
+    result, err := tx.ExecContext(ctx, query, now, now.Add(AccountPurgeGrace), actor.ID)
+    if err != nil {
+        return fmt.Errorf("failed to delete account: %w", err)
+    }
+
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if n > 0 {
+        if err := revokeOtherSessions(ctx, tx, actor.ID, "", now); err != nil {
+            return err
+        }
+        if err := writeAudit(ctx, tx, actor.Email, "user.deleted", actor.ID.String(),
+            fmt.Sprintf("purge after %s", now.Add(AccountPurgeGrace).Format(time.RFC3339)),
+        ); err != nil {
+            return err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    if currentToken != "" {
+        if err := s.revoke(ctx, currentToken, tokenLifetime); err != nil {
+            return fmt.Errorf("failed to revoke current token: %w", err)
+        }
+    }
+
+    return nil
+}
+
+// PurgeDeletedAccounts removes the portfolios and personal data of accounts
+// whose grace period has ended. It returns the number of accounts purged.
+func (s *Service) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+    rows, err := s.db.QueryContext(ctx,
+        "SELECT id FROM users WHERE deleted_at IS NOT NULL AND purge_after <= $1",
+        time.Now(),
+    )
+    if err != nil {
+        return 0, err
+    }
+
+    var ids []uuid.UUID
+    for rows.Next() {
+        var id uuid.UUID
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    // Purge one account per transaction so a failure doesn't roll back the rest
+    purged := 0
+    for _, id := range ids {
+        if err := s.purgeAccount(ctx, id); err != nil {
+            return purged, fmt.Errorf("failed to purge account %s: %w", id, err)
+        }
+        purged++
+    }
+
+    return purged, nil
+}
+
+func (s *Service) purgeAccount(ctx context.Context, userID uuid.UUID) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    statements := []string{
+        "DELETE FROM positions WHERE portfolio_id IN (SELECT id FROM portfolios WHERE user_id = $1)",
+        "DELETE FROM portfolios WHERE user_id = $1",
+        "DELETE FROM users WHERE id = $1",
+    }
+    for _, stmt := range statements {
+        if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+            return err
+        }
+    }
+
+    if err := writeAudit(ctx, tx, "system", "user.purged", userID.String(), ""); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
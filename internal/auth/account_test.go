@@ -0,0 +1,162 @@
+package auth
+
+import (
+    "context"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "golang.org/x/crypto/bcrypt"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestService_UpdateProfile(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    user := &models.User{ID: uuid.New(), Email: "user@example.com", Role: RoleUser}
+    ctx := context.Background()
+
+    t.Run("Update preferences and write audit entry", func(t *testing.T) {
+        tz, currency := "Europe/Berlin", "EUR"
+
+        mock.ExpectBegin()
+        mock.ExpectExec("UPDATE users SET name = COALESCE(.+)").
+            WithArgs(nil, tz, currency, sqlmock.AnyArg(), user.ID).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("user@example.com", "user.profile_updated", user.ID.String(), "timezone,base_currency", "").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+        mock.ExpectQuery("SELECT (.+) FROM users WHERE id = (.+) AND deleted_at IS NULL").
+            WithArgs(user.ID).
+            WillReturnRows(sqlmock.NewRows([]string{
+                "id", "email", "name", "role", "subscription_tier", "timezone", "base_currency",
+                "created_at", "updated_at", "last_login_at",
+            }).AddRow(user.ID, user.Email, "User", RoleUser, "free", tz, currency, nil, nil, nil))
+
+        profile, err := service.UpdateProfile(ctx, user, ProfileUpdate{Timezone: &tz, BaseCurrency: &currency})
+        assert.NoError(t, err)
+        assert.Equal(t, "EUR", profile.BaseCurrency)
+    })
+
+    t.Run("Reject unknown timezone", func(t *testing.T) {
+        tz := "Mars/Olympus_Mons"
+        _, err := service.UpdateProfile(ctx, user, ProfileUpdate{Timezone: &tz})
+        assert.ErrorIs(t, err, ErrInvalidProfile)
+    })
+
+    t.Run("Reject invalid currency", func(t *testing.T) {
+        currency := "usd"
+        _, err := service.UpdateProfile(ctx, user, ProfileUpdate{BaseCurrency: &currency})
+        assert.ErrorIs(t, err, ErrInvalidProfile)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_ChangePassword(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    user := &models.User{ID: uuid.New(), Email: "user@example.com", Role: RoleUser}
+    ctx := context.Background()
+
+    hash, err := bcrypt.GenerateFromPassword([]byte("oldpassword1"), bcrypt.MinCost)
+    assert.NoError(t, err)
+
+    t.Run("Wrong current password", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT password_hash FROM users").
+            WithArgs(user.ID).
+            WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(hash)))
+        mock.ExpectRollback()
+
+        _, err := service.ChangePassword(ctx, user, "old-token", "guess1234", "newpassword1")
+        assert.ErrorIs(t, err, ErrInvalidPassword)
+        assert.False(t, service.blacklist.IsBlacklisted(context.Background(), "old-token"))
+    })
+
+    t.Run("Weak new password", func(t *testing.T) {
+        _, err := service.ChangePassword(ctx, user, "old-token", "oldpassword1", "short")
+        assert.ErrorIs(t, err, ErrPasswordTooShort)
+    })
+
+    t.Run("Change password and revoke other sessions", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT password_hash FROM users").
+            WithArgs(user.ID).
+            WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(hash)))
+        mock.ExpectExec("UPDATE users SET password_hash = (.+), tokens_revoked_at = (.+)").
+            WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), user.ID).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE sessions SET revoked_at = (.+) WHERE user_id = (.+) AND id <> (.+)").
+            WithArgs(sqlmock.AnyArg(), user.ID, "").
+            WillReturnResult(sqlmock.NewResult(0, 2))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("user@example.com", "user.password_changed", user.ID.String(), "", "").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+
+        token, err := service.ChangePassword(ctx, user, "old-token", "oldpassword1", "newpassword1")
+        assert.NoError(t, err)
+        assert.NotEmpty(t, token)
+        assert.True(t, service.blacklist.IsBlacklisted(context.Background(), "old-token"))
+        assert.False(t, service.blacklist.IsBlacklisted(context.Background(), token))
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_DeleteAccountIsIdempotent(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    user := &models.User{ID: uuid.New(), Email: "user@example.com", Role: RoleUser}
+    ctx := context.Background()
+
+    // First call deletes the account and audits it
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE users SET deleted_at = (.+) WHERE id = (.+) AND deleted_at IS NULL").
+        WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), user.ID).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("UPDATE sessions SET revoked_at = (.+) WHERE user_id = (.+)").
+        WithArgs(sqlmock.AnyArg(), user.ID, "").
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("INSERT INTO audit_logs").
+        WithArgs("user@example.com", "user.deleted", user.ID.String(), sqlmock.AnyArg(), "").
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectCommit()
+
+    // Second call finds nothing to delete and writes no audit entry
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE users SET deleted_at = (.+) WHERE id = (.+) AND deleted_at IS NULL").
+        WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), user.ID).
+        WillReturnResult(sqlmock.NewResult(0, 0))
+    mock.ExpectCommit()
+
+    assert.NoError(t, service.DeleteAccount(ctx, user, "session-token"))
+    assert.NoError(t, service.DeleteAccount(ctx, user, "session-token"))
+    assert.True(t, service.blacklist.IsBlacklisted(context.Background(), "session-token"))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidatePassword(t *testing.T) {
+    assert.ErrorIs(t, ValidatePassword("abc123"), ErrPasswordTooShort)
+    assert.ErrorIs(t, ValidatePassword("abcdefghij"), ErrPasswordTooWeak)
+    assert.ErrorIs(t, ValidatePassword("1234567890"), ErrPasswordTooWeak)
+    assert.NoError(t, ValidatePassword("correct horse 1"))
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+    "context"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+)
+
+const codeAttemptKeyPrefix = "auth:2fa_attempts:"
+
+// redisAttemptLimiter counts code attempts in Redis, so the limit holds
+// across instances and restarts. The window starts at a key's first
+// attempt. While Redis is unreachable attempts are counted in memory.
+type redisAttemptLimiter struct {
+    client redis.Cmdable
+    max    int
+    window time.Duration
+    local  *attemptLimiter
+    health *cache.RedisHealth
+}
+
+func newRedisAttemptLimiter(client redis.Cmdable, max int, window time.Duration, health *cache.RedisHealth) *redisAttemptLimiter {
+    return &redisAttemptLimiter{
+        client: client,
+        max:    max,
+        window: window,
+        local:  newAttemptLimiter(max, window),
+        health: health,
+    }
+}
+
+func (l *redisAttemptLimiter) attempt(ctx context.Context, key string) bool {
+    if !l.health.Available() {
+        l.health.Fallback("code_attempts", nil)
+        return l.local.attempt(ctx, key)
+    }
+
+    // SETNX starts the window with the first attempt, and INCR in the
+    // same transaction counts every attempt, including parallel ones
+    var count *redis.IntCmd
+    _, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.SetNX(ctx, codeAttemptKeyPrefix+key, 0, l.window)
+        count = pipe.Incr(ctx, codeAttemptKeyPrefix+key)
+        return nil
+    })
+    if err != nil {
+        l.health.Fallback("code_attempts", err)
+        return l.local.attempt(ctx, key)
+    }
+    return count.Val() <= int64(l.max)
+}
+
+func (l *redisAttemptLimiter) reset(ctx context.Context, key string) {
+    l.local.reset(ctx, key)
+    if err := l.client.Del(ctx, codeAttemptKeyPrefix+key).Err(); err != nil {
+        l.health.Fallback("code_attempts", err)
+    }
+}
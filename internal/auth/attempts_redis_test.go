@@ -0,0 +1,66 @@
+package auth
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestCodeAttemptLimiters_Parallel(t *testing.T) {
+    mr := miniredis.RunT(t)
+    limiters := map[string]codeAttemptLimiter{
+        "memory": newAttemptLimiter(maxCodeAttempts, codeAttemptWindow),
+        "redis": newRedisAttemptLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+            maxCodeAttempts, codeAttemptWindow, nil),
+    }
+
+    for name, limiter := range limiters {
+        t.Run(name, func(t *testing.T) {
+            // Guesses made at once get no more attempts than guesses made
+            // one after another
+            var allowed int32
+            var wg sync.WaitGroup
+            for i := 0; i < 50; i++ {
+                wg.Add(1)
+                go func() {
+                    defer wg.Done()
+                    if limiter.attempt(context.Background(), "user") {
+                        atomic.AddInt32(&allowed, 1)
+                    }
+                }()
+            }
+            wg.Wait()
+            assert.Equal(t, int32(maxCodeAttempts), allowed)
+
+            limiter.reset(context.Background(), "user")
+            assert.True(t, limiter.attempt(context.Background(), "user"))
+        })
+    }
+}
+
+func TestRedisAttemptLimiter(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    ctx := context.Background()
+
+    limiter := newRedisAttemptLimiter(client, 2, time.Minute, nil)
+    assert.True(t, limiter.attempt(ctx, "user"))
+    assert.True(t, limiter.attempt(ctx, "user"))
+
+    // Another instance sees the same count
+    other := newRedisAttemptLimiter(client, 2, time.Minute, nil)
+    assert.False(t, other.attempt(ctx, "user"))
+    assert.True(t, other.attempt(ctx, "someone-else"))
+
+    // The window starts with the first attempt
+    ttl := mr.TTL(codeAttemptKeyPrefix + "user")
+    assert.True(t, ttl > 0 && ttl <= time.Minute, "ttl %s", ttl)
+    mr.FastForward(time.Minute + time.Second)
+    assert.True(t, limiter.attempt(ctx, "user"))
+}
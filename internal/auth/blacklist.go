@@ -0,0 +1,74 @@
+package auth
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// TokenBlacklist records revoked tokens until they would have expired anyway
+type TokenBlacklist interface {
+    Add(ctx context.Context, token string, ttl time.Duration) error
+    IsBlacklisted(ctx context.Context, token string) bool
+    // Len returns the number of revocations that haven't expired
+    Len() (int, error)
+}
+
+// NewTokenBlacklist returns a Redis-backed blacklist, or an in-memory one
+// when client is nil. The in-memory blacklist is lost on restart.
+func NewTokenBlacklist(client redis.Cmdable) TokenBlacklist {
+    if client == nil {
+        return newMemoryTokenBlacklist()
+    }
+    return NewRedisTokenBlacklist(client)
+}
+
+type memoryTokenBlacklist struct {
+    tokens map[string]time.Time
+    mu     sync.RWMutex
+}
+
+func newMemoryTokenBlacklist() *memoryTokenBlacklist {
+    return &memoryTokenBlacklist{
+        tokens: make(map[string]time.Time),
+    }
+}
+
+func (b *memoryTokenBlacklist) Add(ctx context.Context, token string, ttl time.Duration) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    now := time.Now()
+    for t, expiry := range b.tokens {
+        if now.After(expiry) {
+            delete(b.tokens, t)
+        }
+    }
+
+    b.tokens[token] = now.Add(ttl)
+    return nil
+}
+
+func (b *memoryTokenBlacklist) IsBlacklisted(ctx context.Context, token string) bool {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    expiry, ok := b.tokens[token]
+    return ok && time.Now().Before(expiry)
+}
+
+func (b *memoryTokenBlacklist) Len() (int, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    now := time.Now()
+    n := 0
+    for _, expiry := range b.tokens {
+        if now.Before(expiry) {
+            n++
+        }
+    }
+    return n, nil
+}
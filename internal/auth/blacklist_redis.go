@@ -0,0 +1,118 @@
+package auth
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+)
+
+const blacklistKeyPrefix = "auth:blacklist:"
+
+// blacklistTimeout bounds a revocation or lookup, which a request waits on
+// even when its own context has no deadline
+const blacklistTimeout = time.Second
+
+// blacklistLenTimeout bounds counting the blacklist, which is done when
+// metrics are scraped
+const blacklistLenTimeout = 2 * time.Second
+
+// RedisTokenBlacklist keeps revoked tokens in Redis so revocations survive
+// restarts and are shared between instances
+type RedisTokenBlacklist struct {
+    client redis.Cmdable
+    // local mirrors this instance's revocations when set, and answers for
+    // Redis while it is unreachable
+    local  *memoryTokenBlacklist
+    health *cache.RedisHealth
+}
+
+func NewRedisTokenBlacklist(client redis.Cmdable) *RedisTokenBlacklist {
+    return &RedisTokenBlacklist{client: client}
+}
+
+// WithLocalFallback stops the blacklist failing closed: while Redis is
+// unreachable only revocations made by this instance are seen. That is only
+// safe where revocation is also checked in the database, as Service does
+// through sessions and tokens_revoked_at; the blacklist there only makes a
+// revocation take effect sooner.
+func (b *RedisTokenBlacklist) WithLocalFallback(health *cache.RedisHealth) *RedisTokenBlacklist {
+    b.local = newMemoryTokenBlacklist()
+    b.health = health
+    return b
+}
+
+func (b *RedisTokenBlacklist) Add(ctx context.Context, token string, ttl time.Duration) error {
+    // Tokens that have already expired are rejected on their own
+    if ttl <= 0 {
+        return nil
+    }
+    ctx, cancel := context.WithTimeout(ctx, blacklistTimeout)
+    defer cancel()
+    if b.local == nil {
+        return b.client.Set(ctx, blacklistKeyPrefix+token, 1, ttl).Err()
+    }
+
+    b.local.Add(ctx, token, ttl)
+    if !b.health.Available() {
+        b.health.Fallback("token_blacklist", nil)
+        return nil
+    }
+    if err := b.client.Set(ctx, blacklistKeyPrefix+token, 1, ttl).Err(); err != nil {
+        b.health.Fallback("token_blacklist", err)
+    }
+    return nil
+}
+
+// IsBlacklisted fails closed: if Redis cannot be reached the token is
+// treated as revoked, unless the blacklist has a local fallback
+func (b *RedisTokenBlacklist) IsBlacklisted(ctx context.Context, token string) bool {
+    if b.local != nil {
+        if b.local.IsBlacklisted(ctx, token) {
+            return true
+        }
+        if !b.health.Available() {
+            b.health.Fallback("token_blacklist", nil)
+            return false
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, blacklistTimeout)
+    defer cancel()
+    n, err := b.client.Exists(ctx, blacklistKeyPrefix+token).Result()
+    if err != nil {
+        if b.local != nil {
+            b.health.Fallback("token_blacklist", err)
+            return false
+        }
+        return true
+    }
+    return n > 0
+}
+
+// Len counts the revocations in Redis, or this instance's while Redis is
+// unreachable and the blacklist has a local fallback
+func (b *RedisTokenBlacklist) Len() (int, error) {
+    if b.local != nil && !b.health.Available() {
+        return b.local.Len()
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), blacklistLenTimeout)
+    defer cancel()
+
+    n := 0
+    iter := b.client.Scan(ctx, 0, blacklistKeyPrefix+"*", 1000).Iterator()
+    for iter.Next(ctx) {
+        n++
+    }
+    if err := iter.Err(); err != nil {
+        if b.local != nil {
+            return b.local.Len()
+        }
+        return 0, fmt.Errorf("failed to count blacklist: %w", err)
+    }
+    return n, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestRedisTokenBlacklist_SurvivesRestart(t *testing.T) {
+    ctx := context.Background()
+    mr := miniredis.RunT(t)
+
+    manager := NewJWTManager("secret", time.Minute, time.Hour,
+        NewTokenBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()})))
+
+    access, _, err := manager.GenerateTokens(1, "user@example.com", RoleUser)
+    assert.NoError(t, err)
+
+    claims, err := manager.ValidateToken(ctx, access)
+    assert.NoError(t, err)
+    assert.NoError(t, manager.BlacklistToken(ctx, access, claims))
+
+    // A fresh manager and client have no in-memory state of the revocation
+    restarted := NewJWTManager("secret", time.Minute, time.Hour,
+        NewTokenBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()})))
+
+    _, err = restarted.ValidateToken(ctx, access)
+    assert.ErrorIs(t, err, ErrTokenBlacklisted)
+
+    // The key lives only as long as the token would have
+    ttl := mr.TTL(blacklistKeyPrefix + access)
+    assert.True(t, ttl > 0 && ttl <= time.Minute, "ttl %s", ttl)
+
+    mr.FastForward(time.Minute + time.Second)
+    assert.False(t, restarted.blacklist.IsBlacklisted(ctx, access))
+}
+
+func TestNewTokenBlacklist_InMemoryFallback(t *testing.T) {
+    ctx := context.Background()
+    blacklist := NewTokenBlacklist(nil)
+
+    assert.NoError(t, blacklist.Add(ctx, "token", time.Minute))
+    assert.True(t, blacklist.IsBlacklisted(ctx, "token"))
+    assert.False(t, blacklist.IsBlacklisted(ctx, "other"))
+}
+
+func TestRedisTokenBlacklist_LocalFallback(t *testing.T) {
+    ctx := context.Background()
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+    strict := NewRedisTokenBlacklist(client)
+    lenient := NewRedisTokenBlacklist(client).WithLocalFallback(nil)
+    assert.NoError(t, lenient.Add(ctx, "revoked-here", time.Minute))
+    assert.NoError(t, strict.Add(ctx, "revoked-elsewhere", time.Minute))
+    assert.True(t, lenient.IsBlacklisted(ctx, "revoked-elsewhere"))
+
+    mr.Close()
+
+    // Without a fallback every token reads as revoked
+    assert.True(t, strict.IsBlacklisted(ctx, "valid"))
+
+    // With one, only this instance's revocations are still seen
+    assert.False(t, lenient.IsBlacklisted(ctx, "valid"))
+    assert.True(t, lenient.IsBlacklisted(ctx, "revoked-here"))
+    assert.False(t, lenient.IsBlacklisted(ctx, "revoked-elsewhere"))
+    assert.NoError(t, lenient.Add(ctx, "revoked-during-outage", time.Minute))
+    assert.True(t, lenient.IsBlacklisted(ctx, "revoked-during-outage"))
+}
+
+func TestRedisTokenBlacklist_UsesCallerContext(t *testing.T) {
+    mr := miniredis.RunT(t)
+    blacklist := NewRedisTokenBlacklist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    assert.ErrorIs(t, blacklist.Add(ctx, "token", time.Minute), context.Canceled)
+    assert.False(t, mr.Exists(blacklistKeyPrefix+"token"))
+
+    // A lookup that can't complete fails closed
+    assert.True(t, blacklist.IsBlacklisted(ctx, "valid"))
+}
@@ -0,0 +1,34 @@
+package auth
+
+import "context"
+
+type contextKey int
+
+const (
+    clientIPKey contextKey = iota
+    impersonationKey
+)
+
+// WithClientIP records the caller's address so audit entries written while
+// handling the request can include it
+func WithClientIP(ctx context.Context, ip string) context.Context {
+    return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIPFromContext returns the address set by WithClientIP, or ""
+func ClientIPFromContext(ctx context.Context) string {
+    ip, _ := ctx.Value(clientIPKey).(string)
+    return ip
+}
+
+// WithImpersonation marks the request as made during imp
+func WithImpersonation(ctx context.Context, imp *Impersonation) context.Context {
+    return context.WithValue(ctx, impersonationKey, imp)
+}
+
+// ImpersonationFromContext returns the impersonation the request is made
+// during, or nil
+func ImpersonationFromContext(ctx context.Context) *Impersonation {
+    imp, _ := ctx.Value(impersonationKey).(*Impersonation)
+    return imp
+}
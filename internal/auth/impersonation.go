@@ -0,0 +1,244 @@
+package auth
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// ImpersonationLifetime is how long an impersonation token stays valid
+const ImpersonationLifetime = 15 * time.Minute
+
+var (
+    ErrCannotImpersonate = errors.New("user cannot be impersonated")
+    ErrReadOnly          = errors.New("read-only while impersonating")
+)
+
+// Impersonation is a support session in which an admin sees the API as
+// another user. It grants only reads; see middleware.GuardImpersonation.
+type Impersonation struct {
+    ID         string    `json:"id"`
+    AdminID    uuid.UUID `json:"admin_id"`
+    AdminEmail string    `json:"admin_email"`
+    UserID     uuid.UUID `json:"user_id"`
+    ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ImpersonationResult is a started impersonation and the token to make its
+// requests with
+type ImpersonationResult struct {
+    Token string `json:"token"`
+    Impersonation
+}
+
+// auditExecer is a *sql.DB or a *sql.Tx
+type auditExecer interface {
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// writeImpersonationAudit is writeAudit for actions taken during imp,
+// recording the real admin as well as the user they act as
+func writeImpersonationAudit(ctx context.Context, exec auditExecer, imp *Impersonation, action, details string) error {
+    query := `
+        INSERT INTO audit_logs (actor_email, actor_id, impersonated_user_id, action, target_id, details, ip)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+    if _, err := exec.ExecContext(ctx, query,
+        imp.AdminEmail, imp.AdminID, imp.UserID, action, imp.UserID.String(), details, ClientIPFromContext(ctx),
+    ); err != nil {
+        return fmt.Errorf("failed to write audit log: %w", err)
+    }
+    return nil
+}
+
+func impersonationBlacklistKey(id string) string {
+    return "impersonation:" + id
+}
+
+// Impersonate starts a session in which admin sees the API as userID for
+// ImpersonationLifetime. Users able to impersonate can't be impersonated
+// themselves, so an impersonation never grants more than a user's access.
+func (s *Service) Impersonate(ctx context.Context, admin *models.User, userID uuid.UUID) (*ImpersonationResult, error) {
+    if admin.ID == userID {
+        return nil, ErrCannotImpersonate
+    }
+
+    id, err := newSessionID()
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var role string
+    err = tx.QueryRowContext(ctx,
+        "SELECT role FROM users WHERE id = $1 AND deleted_at IS NULL", userID,
+    ).Scan(&role)
+    if err == sql.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if HasPermission(role, PermImpersonate) {
+        return nil, ErrCannotImpersonate
+    }
+
+    now := time.Now()
+    imp := Impersonation{
+        ID:         id,
+        AdminID:    admin.ID,
+        AdminEmail: admin.Email,
+        UserID:     userID,
+        ExpiresAt:  now.Add(ImpersonationLifetime),
+    }
+    if _, err := tx.ExecContext(ctx, `
+        INSERT INTO impersonations (id, admin_id, user_id, started_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5)`,
+        imp.ID, imp.AdminID, imp.UserID, now, imp.ExpiresAt,
+    ); err != nil {
+        return nil, fmt.Errorf("failed to start impersonation: %w", err)
+    }
+    if err := writeImpersonationAudit(ctx, tx, &imp, "impersonation.started", imp.ID); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id":     userID,
+        "imp":         imp.ID,
+        "admin_id":    admin.ID,
+        "admin_email": admin.Email,
+        "iat":         now.Unix(),
+        "exp":         imp.ExpiresAt.Unix(),
+    }).SignedString(s.jwtSecret)
+    if err != nil {
+        return nil, err
+    }
+    s.metrics.countToken(TokenIssued)
+    return &ImpersonationResult{Token: token, Impersonation: imp}, nil
+}
+
+// Impersonation returns the impersonation a token was issued for, or nil
+// for ordinary tokens and those that can't be parsed. It doesn't check the
+// impersonation is still live; ValidateToken does.
+func (s *Service) Impersonation(tokenString string) *Impersonation {
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, errors.New("invalid signing method")
+        }
+        return s.jwtSecret, nil
+    })
+    if err != nil {
+        return nil
+    }
+
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        return nil
+    }
+    id, _ := claims["imp"].(string)
+    if id == "" {
+        return nil
+    }
+    adminID, err := uuid.Parse(fmt.Sprint(claims["admin_id"]))
+    if err != nil {
+        return nil
+    }
+    userID, err := uuid.Parse(fmt.Sprint(claims["user_id"]))
+    if err != nil {
+        return nil
+    }
+    adminEmail, _ := claims["admin_email"].(string)
+    exp, _ := claims["exp"].(float64)
+    return &Impersonation{
+        ID:         id,
+        AdminID:    adminID,
+        AdminEmail: adminEmail,
+        UserID:     userID,
+        ExpiresAt:  time.Unix(int64(exp), 0),
+    }
+}
+
+// impersonatedUser returns the user impersonation id acts as, as long as it
+// hasn't ended or expired and its admin may still impersonate
+func (s *Service) impersonatedUser(ctx context.Context, id string) (*models.User, error) {
+    if s.blacklist.IsBlacklisted(ctx, impersonationBlacklistKey(id)) {
+        return nil, ErrTokenRevoked
+    }
+
+    var user models.User
+    var adminRole string
+    var expiresAt time.Time
+    var endedAt sql.NullTime
+    err := s.db.QueryRowContext(ctx, `
+        SELECT u.id, u.email, u.name, u.role, u.subscription_tier, a.role, i.expires_at, i.ended_at
+        FROM impersonations i
+        JOIN users u ON u.id = i.user_id AND u.deleted_at IS NULL
+        JOIN users a ON a.id = i.admin_id AND a.deleted_at IS NULL
+        WHERE i.id = $1`, id,
+    ).Scan(&user.ID, &user.Email, &user.Name, &user.Role, &user.SubscriptionTier, &adminRole, &expiresAt, &endedAt)
+    if err == sql.ErrNoRows {
+        return nil, ErrTokenRevoked
+    }
+    if err != nil {
+        return nil, err
+    }
+    if endedAt.Valid || !expiresAt.After(time.Now()) || !HasPermission(adminRole, PermImpersonate) {
+        return nil, ErrTokenRevoked
+    }
+    return &user, nil
+}
+
+// RecordImpersonatedRequest writes an audit entry for a request made
+// during imp. blocked marks requests refused for not being reads.
+func (s *Service) RecordImpersonatedRequest(ctx context.Context, imp *Impersonation, method, path string, blocked bool) error {
+    action := "impersonation.request"
+    if blocked {
+        action = "impersonation.blocked"
+    }
+    return writeImpersonationAudit(ctx, s.db, imp, action, method+" "+path)
+}
+
+// EndImpersonation ends imp before it expires. Its tokens are blacklisted
+// so they stop working without a database lookup.
+func (s *Service) EndImpersonation(ctx context.Context, imp *Impersonation) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    now := time.Now()
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE impersonations SET ended_at = COALESCE(ended_at, $1) WHERE id = $2", now, imp.ID,
+    ); err != nil {
+        return fmt.Errorf("failed to end impersonation: %w", err)
+    }
+    if err := writeImpersonationAudit(ctx, tx, imp, "impersonation.ended", imp.ID); err != nil {
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    if ttl := imp.ExpiresAt.Sub(now); ttl > 0 {
+        if err := s.revoke(ctx, impersonationBlacklistKey(imp.ID), ttl); err != nil {
+            logger.FromContext(ctx).Errorf("Failed to blacklist impersonation %s: %v", imp.ID, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+    "context"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestService_Impersonate(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    admin := &models.User{ID: uuid.New(), Email: "support@wolfai.com", Role: RoleAdmin}
+
+    t.Run("Not oneself", func(t *testing.T) {
+        _, err := service.Impersonate(context.Background(), admin, admin.ID)
+        assert.ErrorIs(t, err, ErrCannotImpersonate)
+    })
+
+    t.Run("Not other admins", func(t *testing.T) {
+        other := uuid.New()
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT role FROM users").
+            WithArgs(other).
+            WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(RoleAdmin))
+        mock.ExpectRollback()
+
+        _, err := service.Impersonate(context.Background(), admin, other)
+        assert.ErrorIs(t, err, ErrCannotImpersonate)
+    })
+
+    t.Run("Unknown user", func(t *testing.T) {
+        missing := uuid.New()
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT role FROM users").
+            WithArgs(missing).
+            WillReturnRows(sqlmock.NewRows([]string{"role"}))
+        mock.ExpectRollback()
+
+        _, err := service.Impersonate(context.Background(), admin, missing)
+        assert.ErrorIs(t, err, ErrUserNotFound)
+    })
+
+    t.Run("Ordinary tokens carry no impersonation", func(t *testing.T) {
+        token, err := service.issueToken(&models.User{ID: uuid.New()}, "sess-1")
+        assert.NoError(t, err)
+        assert.Nil(t, service.Impersonation(token))
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
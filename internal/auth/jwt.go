@@ -1,12 +1,24 @@
 package auth
 
 import (
+    "context"
     "crypto/rand"
     "encoding/base64"
+    "errors"
     "time"
     "github.com/golang-jwt/jwt/v5"
 )
 
+var (
+    ErrInvalidToken         = errors.New("invalid token")
+    ErrInvalidSigningMethod = errors.New("invalid signing method")
+    ErrTokenBlacklisted     = errors.New("token has been revoked")
+)
+
+// RefreshRouteClass is the route class RefreshTokens counts validating
+// refresh tokens under
+const RefreshRouteClass = "refresh"
+
 type Claims struct {
     UserID    int64    `json:"uid"`
     Email     string   `json:"email"`
@@ -19,19 +31,43 @@ type JWTManager struct {
     secretKey      []byte
     accessExpiry   time.Duration
     refreshExpiry  time.Duration
-    blacklist      *TokenBlacklist
+    blacklist      TokenBlacklist
+    metrics        *Metrics
 }
 
-func NewJWTManager(secretKey string, accessExpiry, refreshExpiry time.Duration) *JWTManager {
+// NewJWTManager creates a token manager. A nil blacklist falls back to the
+// in-memory implementation.
+func NewJWTManager(secretKey string, accessExpiry, refreshExpiry time.Duration, blacklist TokenBlacklist) *JWTManager {
+    if blacklist == nil {
+        blacklist = NewTokenBlacklist(nil)
+    }
+
     return &JWTManager{
         secretKey:     []byte(secretKey),
         accessExpiry:  accessExpiry,
         refreshExpiry: refreshExpiry,
-        blacklist:     NewTokenBlacklist(),
+        blacklist:     blacklist,
     }
 }
 
+// WithMetrics counts issued, refreshed and revoked tokens and refresh token
+// validations in metrics, and reports the size of the manager's blacklist
+func (m *JWTManager) WithMetrics(metrics *Metrics) *JWTManager {
+    m.metrics = metrics
+    metrics.watchBlacklist(m.blacklist)
+    return m
+}
+
 func (m *JWTManager) GenerateTokens(userID int64, email, role string) (string, string, error) {
+    accessToken, refreshToken, err := m.generateTokens(userID, email, role)
+    if err != nil {
+        return "", "", err
+    }
+    m.metrics.countToken(TokenIssued)
+    return accessToken, refreshToken, nil
+}
+
+func (m *JWTManager) generateTokens(userID int64, email, role string) (string, string, error) {
     sessionID, err := generateSessionID()
     if err != nil {
         return "", "", err 
@@ -67,8 +103,8 @@ func (m *JWTManager) generateToken(userID int64, email, role, sessionID string,
     return token.SignedString(m.secretKey)
 }
 
-func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
-    if m.blacklist.IsBlacklisted(tokenString) {
+func (m *JWTManager) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+    if m.blacklist.IsBlacklisted(ctx, tokenString) {
         return nil, ErrTokenBlacklisted
     }
 
@@ -90,22 +126,32 @@ func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
     return nil, ErrInvalidToken
 }
 
-func (m *JWTManager) BlacklistToken(tokenString string, claims *Claims) error {
+func (m *JWTManager) BlacklistToken(ctx context.Context, tokenString string, claims *Claims) error {
     expiry := time.Until(claims.ExpiresAt.Time)
-    return m.blacklist.Add(tokenString, expiry)
+    if err := m.blacklist.Add(ctx, tokenString, expiry); err != nil {
+        return err
+    }
+    m.metrics.countToken(TokenRevoked)
+    return nil
 }
 
-func (m *JWTManager) RefreshTokens(refreshToken string) (string, string, error) {
-    claims, err := m.ValidateToken(refreshToken)
+func (m *JWTManager) RefreshTokens(ctx context.Context, refreshToken string) (string, string, error) {
+    claims, err := m.ValidateToken(ctx, refreshToken)
+    m.metrics.ObserveValidation(RefreshRouteClass, err)
     if err != nil {
         return "", "", err
     }
 
-    if err := m.BlacklistToken(refreshToken, claims); err != nil {
+    if err := m.BlacklistToken(ctx, refreshToken, claims); err != nil {
         return "", "", err
     }
 
-    return m.GenerateTokens(claims.UserID, claims.Email, claims.Role)
+    accessToken, newRefreshToken, err := m.generateTokens(claims.UserID, claims.Email, claims.Role)
+    if err != nil {
+        return "", "", err
+    }
+    m.metrics.countToken(TokenRefreshed)
+    return accessToken, newRefreshToken, nil
 }
 
 func generateSessionID() (string, error) {
@@ -0,0 +1,148 @@
+package auth
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "sync"
+
+    jwtv3 "github.com/golang-jwt/jwt"
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrMalformedToken is for credentials that aren't a token at all, such as
+// an Authorization header that isn't a bearer token
+var ErrMalformedToken = errors.New("malformed token")
+
+// Token validation outcomes, as labelled on auth_token_validations_total
+const (
+    OutcomeValid            = "valid"
+    OutcomeExpired          = "expired"
+    OutcomeInvalidSignature = "invalid_signature"
+    // OutcomeBlacklisted covers every revocation: blacklisted tokens,
+    // revoked sessions and tokens issued before a password change
+    OutcomeBlacklisted = "blacklisted"
+    OutcomeMalformed   = "malformed"
+    // OutcomeInvalid is a well-formed, signed token rejected for any other
+    // reason, e.g. its user no longer exists
+    OutcomeInvalid = "invalid"
+)
+
+// Token lifecycle events, as labelled on auth_tokens_total
+const (
+    TokenIssued    = "issued"
+    TokenRefreshed = "refreshed"
+    TokenRevoked   = "revoked"
+)
+
+// Metrics counts token validation outcomes and token lifecycle events, and
+// reports the size of a token blacklist. A nil *Metrics records nothing.
+type Metrics struct {
+    validations   *prometheus.CounterVec
+    tokens        *prometheus.CounterVec
+    blacklistSize prometheus.GaugeFunc
+
+    mu        sync.Mutex
+    blacklist TokenBlacklist
+    lastSize  float64
+}
+
+// NewMetrics creates auth metrics, registered with reg when reg is non-nil
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+    m := &Metrics{
+        validations: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "auth_token_validations_total",
+            Help: "Number of token validations, by outcome and route class",
+        }, []string{"outcome", "route_class"}),
+        tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "auth_tokens_total",
+            Help: "Number of tokens issued, refreshed and revoked",
+        }, []string{"event"}),
+    }
+    m.blacklistSize = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "auth_token_blacklist_size",
+        Help: "Number of revocations in the token blacklist",
+    }, m.readBlacklistSize)
+    if reg != nil {
+        reg.MustRegister(m.validations, m.tokens, m.blacklistSize)
+    }
+    return m
+}
+
+// ObserveValidation counts the outcome of validating a token for a route
+// of routeClass, err being the validation's error, and returns the outcome
+func (m *Metrics) ObserveValidation(routeClass string, err error) string {
+    outcome := ValidationOutcome(err)
+    if m != nil {
+        m.validations.WithLabelValues(outcome, routeClass).Inc()
+    }
+    return outcome
+}
+
+func (m *Metrics) countToken(event string) {
+    if m != nil {
+        m.tokens.WithLabelValues(event).Inc()
+    }
+}
+
+// watchBlacklist makes blacklist the one whose size is reported
+func (m *Metrics) watchBlacklist(blacklist TokenBlacklist) {
+    if m == nil {
+        return
+    }
+    m.mu.Lock()
+    m.blacklist = blacklist
+    m.mu.Unlock()
+}
+
+// readBlacklistSize reads the blacklist's size when scraped, keeping the
+// last size read while the blacklist can't report one
+func (m *Metrics) readBlacklistSize() float64 {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.blacklist == nil {
+        return 0
+    }
+    if n, err := m.blacklist.Len(); err == nil {
+        m.lastSize = float64(n)
+    }
+    return m.lastSize
+}
+
+// ValidationOutcome classifies the error validating a token returned, from
+// either Service or JWTManager
+func ValidationOutcome(err error) string {
+    var v3 *jwtv3.ValidationError
+    switch {
+    case err == nil:
+        return OutcomeValid
+    case errors.Is(err, ErrTokenRevoked), errors.Is(err, ErrTokenBlacklisted):
+        return OutcomeBlacklisted
+    case errors.Is(err, jwt.ErrTokenExpired):
+        return OutcomeExpired
+    case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable),
+        errors.Is(err, ErrInvalidSigningMethod):
+        return OutcomeInvalidSignature
+    case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, ErrMalformedToken):
+        return OutcomeMalformed
+    case errors.As(err, &v3):
+        switch {
+        case v3.Errors&jwtv3.ValidationErrorMalformed != 0:
+            return OutcomeMalformed
+        case v3.Errors&(jwtv3.ValidationErrorSignatureInvalid|jwtv3.ValidationErrorUnverifiable) != 0:
+            return OutcomeInvalidSignature
+        case v3.Errors&jwtv3.ValidationErrorExpired != 0:
+            return OutcomeExpired
+        }
+    }
+    return OutcomeInvalid
+}
+
+// Fingerprint identifies a token in logs without revealing it: the first 8
+// hex characters of its SHA-256
+func Fingerprint(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])[:8]
+}
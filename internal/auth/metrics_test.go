@@ -0,0 +1,146 @@
+package auth
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    jwtv3 "github.com/golang-jwt/jwt"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestMetrics_ValidationOutcomes(t *testing.T) {
+    manager := NewJWTManager("secret", time.Minute, time.Hour, nil)
+    expiredManager := NewJWTManager("secret", -time.Minute, time.Hour, nil)
+    otherManager := NewJWTManager("other-secret", time.Minute, time.Hour, nil)
+    service := NewService(nil, "secret")
+
+    serviceToken := func(t *testing.T, method jwtv3.SigningMethod, key interface{}, claims jwtv3.MapClaims) string {
+        token, err := jwtv3.NewWithClaims(method, claims).SignedString(key)
+        require.NoError(t, err)
+        return token
+    }
+    now := time.Now()
+
+    tests := []struct {
+        name     string
+        validate func(t *testing.T) error
+        outcome  string
+    }{
+        {"valid token", func(t *testing.T) error {
+            access, _, err := manager.GenerateTokens(1, "user@example.com", RoleUser)
+            require.NoError(t, err)
+            _, err = manager.ValidateToken(context.Background(), access)
+            return err
+        }, OutcomeValid},
+        {"expired token", func(t *testing.T) error {
+            access, _, err := expiredManager.GenerateTokens(1, "user@example.com", RoleUser)
+            require.NoError(t, err)
+            _, err = manager.ValidateToken(context.Background(), access)
+            return err
+        }, OutcomeExpired},
+        {"token signed with another key", func(t *testing.T) error {
+            access, _, err := otherManager.GenerateTokens(1, "user@example.com", RoleUser)
+            require.NoError(t, err)
+            _, err = manager.ValidateToken(context.Background(), access)
+            return err
+        }, OutcomeInvalidSignature},
+        {"blacklisted token", func(t *testing.T) error {
+            access, _, err := manager.GenerateTokens(1, "user@example.com", RoleUser)
+            require.NoError(t, err)
+            claims, err := manager.ValidateToken(context.Background(), access)
+            require.NoError(t, err)
+            require.NoError(t, manager.BlacklistToken(context.Background(), access, claims))
+            _, err = manager.ValidateToken(context.Background(), access)
+            return err
+        }, OutcomeBlacklisted},
+        {"malformed token", func(t *testing.T) error {
+            _, err := manager.ValidateToken(context.Background(), "not-a-token")
+            return err
+        }, OutcomeMalformed},
+        {"expired service token", func(t *testing.T) error {
+            _, err := service.ValidateToken(context.Background(), serviceToken(t, jwtv3.SigningMethodHS256, []byte("secret"), jwtv3.MapClaims{
+                "user_id": 1, "iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(-time.Hour).Unix(),
+            }))
+            return err
+        }, OutcomeExpired},
+        {"service token signed with another key", func(t *testing.T) error {
+            _, err := service.ValidateToken(context.Background(), serviceToken(t, jwtv3.SigningMethodHS256, []byte("other-secret"), jwtv3.MapClaims{
+                "user_id": 1, "exp": now.Add(time.Hour).Unix(),
+            }))
+            return err
+        }, OutcomeInvalidSignature},
+        {"unsigned service token", func(t *testing.T) error {
+            _, err := service.ValidateToken(context.Background(), serviceToken(t, jwtv3.SigningMethodNone, jwtv3.UnsafeAllowNoneSignatureType, jwtv3.MapClaims{
+                "user_id": 1, "exp": now.Add(time.Hour).Unix(),
+            }))
+            return err
+        }, OutcomeInvalidSignature},
+        {"blacklisted service token", func(t *testing.T) error {
+            token := serviceToken(t, jwtv3.SigningMethodHS256, []byte("secret"), jwtv3.MapClaims{
+                "user_id": 1, "exp": now.Add(time.Hour).Unix(),
+            })
+            require.NoError(t, service.blacklist.Add(context.Background(), token, time.Hour))
+            _, err := service.ValidateToken(context.Background(), token)
+            return err
+        }, OutcomeBlacklisted},
+        {"malformed service token", func(t *testing.T) error {
+            _, err := service.ValidateToken(context.Background(), "not.a.token")
+            return err
+        }, OutcomeMalformed},
+        {"two-factor challenge used as a token", func(t *testing.T) error {
+            _, err := service.ValidateToken(context.Background(), serviceToken(t, jwtv3.SigningMethodHS256, []byte("secret"), jwtv3.MapClaims{
+                "user_id": 1, "purpose": "2fa", "exp": now.Add(time.Hour).Unix(),
+            }))
+            return err
+        }, OutcomeInvalid},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            metrics := NewMetrics(prometheus.NewRegistry())
+            assert.Equal(t, tt.outcome, metrics.ObserveValidation("api", tt.validate(t)))
+
+            assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validations.WithLabelValues(tt.outcome, "api")))
+            assert.Equal(t, 1, testutil.CollectAndCount(metrics.validations))
+        })
+    }
+}
+
+func TestJWTManager_LifecycleMetrics(t *testing.T) {
+    metrics := NewMetrics(prometheus.NewRegistry())
+    manager := NewJWTManager("secret", time.Minute, time.Hour, nil).WithMetrics(metrics)
+    count := func(event string) float64 {
+        return testutil.ToFloat64(metrics.tokens.WithLabelValues(event))
+    }
+
+    _, refresh, err := manager.GenerateTokens(1, "user@example.com", RoleUser)
+    require.NoError(t, err)
+    assert.Equal(t, 1.0, count(TokenIssued))
+    assert.Equal(t, 0.0, testutil.ToFloat64(metrics.blacklistSize))
+
+    // Refreshing revokes the refresh token used, rather than issuing anew
+    _, _, err = manager.RefreshTokens(context.Background(), refresh)
+    require.NoError(t, err)
+    assert.Equal(t, 1.0, count(TokenIssued))
+    assert.Equal(t, 1.0, count(TokenRefreshed))
+    assert.Equal(t, 1.0, count(TokenRevoked))
+    assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validations.WithLabelValues(OutcomeValid, RefreshRouteClass)))
+    assert.Equal(t, 1.0, testutil.ToFloat64(metrics.blacklistSize))
+
+    // A refresh token can only be used once
+    _, _, err = manager.RefreshTokens(context.Background(), refresh)
+    assert.ErrorIs(t, err, ErrTokenBlacklisted)
+    assert.Equal(t, 1.0, count(TokenRefreshed))
+    assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validations.WithLabelValues(OutcomeBlacklisted, RefreshRouteClass)))
+}
+
+func TestFingerprint(t *testing.T) {
+    // The first 8 hex characters of the token's SHA-256
+    assert.Equal(t, "2c26b46b", Fingerprint("foo"))
+    assert.Equal(t, Fingerprint("token"), Fingerprint("token"))
+    assert.NotEqual(t, Fingerprint("token"), Fingerprint("other"))
+}
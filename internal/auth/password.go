@@ -0,0 +1,43 @@
+package auth
+
+import (
+    "errors"
+    "unicode"
+)
+
+const (
+    minPasswordLength = 8
+    // bcrypt ignores everything past 72 bytes
+    maxPasswordLength = 72
+)
+
+var (
+    ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+    ErrPasswordTooLong  = errors.New("password must be at most 72 bytes")
+    ErrPasswordTooWeak  = errors.New("password must contain a letter and a digit")
+)
+
+// ValidatePassword enforces the password policy for new passwords
+func ValidatePassword(password string) error {
+    if len(password) < minPasswordLength {
+        return ErrPasswordTooShort
+    }
+    if len(password) > maxPasswordLength {
+        return ErrPasswordTooLong
+    }
+
+    var hasLetter, hasDigit bool
+    for _, r := range password {
+        switch {
+        case unicode.IsLetter(r):
+            hasLetter = true
+        case unicode.IsDigit(r):
+            hasDigit = true
+        }
+    }
+    if !hasLetter || !hasDigit {
+        return ErrPasswordTooWeak
+    }
+
+    return nil
+}
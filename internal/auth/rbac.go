@@ -0,0 +1,55 @@
+package auth
+
+// Roles a user can hold. New users get RoleUser.
+const (
+    RoleUser    = "user"
+    RoleAnalyst = "analyst"
+    RoleAdmin   = "admin"
+)
+
+type Permission string
+
+const (
+    PermViewStats    Permission = "stats:view"
+    PermManageModels Permission = "models:manage"
+    PermManageJobs   Permission = "jobs:manage"
+    PermViewAudit    Permission = "audit:view"
+    PermManageRoles  Permission = "roles:manage"
+    PermManageTiers  Permission = "tiers:manage"
+    PermImpersonate  Permission = "users:impersonate"
+    PermManageCache  Permission = "cache:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+    RoleUser: {},
+    RoleAnalyst: {
+        PermViewStats,
+        PermManageJobs,
+    },
+    RoleAdmin: {
+        PermViewStats,
+        PermManageModels,
+        PermManageJobs,
+        PermViewAudit,
+        PermManageRoles,
+        PermManageTiers,
+        PermImpersonate,
+        PermManageCache,
+    },
+}
+
+// ValidRole reports whether role is one of the defined roles
+func ValidRole(role string) bool {
+    _, ok := rolePermissions[role]
+    return ok
+}
+
+// HasPermission reports whether role grants perm. Unknown roles grant nothing.
+func HasPermission(role string, perm Permission) bool {
+    for _, p := range rolePermissions[role] {
+        if p == perm {
+            return true
+        }
+    }
+    return false
+}
@@ -5,67 +5,346 @@ import (
     "database/sql"
     "time"
     "errors"
+    "fmt"
+    "strings"
 
+    "github.com/go-redis/redis/v8"
     "github.com/golang-jwt/jwt"
+    "github.com/google/uuid"
     "golang.org/x/crypto/bcrypt"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
+var (
+    ErrInvalidRole  = errors.New("invalid role")
+    ErrUserNotFound = errors.New("user not found")
+    ErrLastAdmin    = errors.New("cannot demote the last admin")
+    ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// tokenLifetime is how long a login token stays valid
+const tokenLifetime = 24 * time.Hour
+
 type Service struct {
-    db        *sql.DB
-    jwtSecret []byte
+    db             *sql.DB
+    jwtSecret      []byte
+    bootstrapAdmin string
+    blacklist      TokenBlacklist
+    touches        *sessionTouches
+    keyring        *crypto.Keyring
+    codeAttempts   codeAttemptLimiter
+    tiers          *FeatureGate
+    metrics        *Metrics
+}
+
+type AuditEntry struct {
+    ID         int64  `json:"id"`
+    ActorEmail string `json:"actor_email"`
+    Action     string `json:"action"`
+    TargetID   string `json:"target_id"`
+    Details    string `json:"details"`
+    IP         string `json:"ip"`
+    // ActorID and ImpersonatedUserID are set on entries written while an
+    // admin impersonated a user
+    ActorID            *uuid.UUID `json:"actor_id,omitempty"`
+    ImpersonatedUserID *uuid.UUID `json:"impersonated_user_id,omitempty"`
+    CreatedAt          time.Time  `json:"created_at"`
 }
 
 func NewService(db *sql.DB, jwtSecret string) *Service {
     return &Service{
-        db:        db,
-        jwtSecret: []byte(jwtSecret),
+        db:           db,
+        jwtSecret:    []byte(jwtSecret),
+        blacklist:    NewTokenBlacklist(nil),
+        touches:      &sessionTouches{seen: make(map[string]time.Time)},
+        codeAttempts: newAttemptLimiter(maxCodeAttempts, codeAttemptWindow),
+        tiers:        NewFeatureGate(DefaultTiers()),
+    }
+}
+
+// WithBlacklist sets the store used to revoke individual tokens
+func (s *Service) WithBlacklist(blacklist TokenBlacklist) *Service {
+    if blacklist != nil {
+        s.blacklist = blacklist
+        s.metrics.watchBlacklist(blacklist)
     }
+    return s
+}
+
+// WithCodeAttempts counts two-factor code attempts in Redis, so their
+// limit is shared between instances. Attempts are counted in memory while
+// health reports Redis unreachable.
+func (s *Service) WithCodeAttempts(client redis.Cmdable, health *cache.RedisHealth) *Service {
+    s.codeAttempts = newRedisAttemptLimiter(client, maxCodeAttempts, codeAttemptWindow, health)
+    return s
+}
+
+// WithMetrics counts issued and revoked tokens in metrics, and reports the
+// size of the service's blacklist
+func (s *Service) WithMetrics(metrics *Metrics) *Service {
+    s.metrics = metrics
+    metrics.watchBlacklist(s.blacklist)
+    return s
+}
+
+// revoke blacklists key, a token or a session or impersonation key, for ttl
+func (s *Service) revoke(ctx context.Context, key string, ttl time.Duration) error {
+    if err := s.blacklist.Add(ctx, key, ttl); err != nil {
+        return err
+    }
+    s.metrics.countToken(TokenRevoked)
+    return nil
+}
+
+// WithBootstrapAdmin makes the user registering with email an admin, so a
+// fresh deployment always has a way into the admin routes
+func (s *Service) WithBootstrapAdmin(email string) *Service {
+    s.bootstrapAdmin = strings.ToLower(strings.TrimSpace(email))
+    return s
+}
+
+// WithTiers sets the subscription tiers SetUserTier accepts
+func (s *Service) WithTiers(tiers *FeatureGate) *Service {
+    if tiers != nil {
+        s.tiers = tiers
+    }
+    return s
 }
 
 func (s *Service) Register(ctx context.Context, email, password, name string) error {
+    if err := ValidatePassword(password); err != nil {
+        return err
+    }
+
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
     if err != nil {
         return err
     }
 
+    role := RoleUser
+    if s.bootstrapAdmin != "" && strings.ToLower(email) == s.bootstrapAdmin {
+        role = RoleAdmin
+    }
+
     query := `
-        INSERT INTO users (email, password_hash, name)
-        VALUES ($1, $2, $3)
+        INSERT INTO users (email, password_hash, name, role)
+        VALUES ($1, $2, $3, $4)
     `
-    _, err = s.db.ExecContext(ctx, query, email, hashedPassword, name)
+    _, err = s.db.ExecContext(ctx, query, email, hashedPassword, name, role)
     return err
 }
 
-func (s *Service) Login(ctx context.Context, email, password string) (string, error) {
+// SetUserRole changes a user's role and records the change in the audit log.
+// Roles are read from the database on every request, so the change applies
+// to the user's existing tokens immediately.
+func (s *Service) SetUserRole(ctx context.Context, actor *models.User, userID, role string) error {
+    if !ValidRole(role) {
+        return ErrInvalidRole
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var previous string
+    err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&previous)
+    if err == sql.ErrNoRows {
+        return ErrUserNotFound
+    }
+    if err != nil {
+        return err
+    }
+
+    if previous == RoleAdmin && role != RoleAdmin {
+        var admins int
+        if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = $1", RoleAdmin).Scan(&admins); err != nil {
+            return err
+        }
+        if admins <= 1 {
+            return ErrLastAdmin
+        }
+    }
+
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE users SET role = $1, updated_at = $2 WHERE id = $3",
+        role, time.Now(), userID,
+    ); err != nil {
+        return fmt.Errorf("failed to update role: %w", err)
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.role_changed", userID,
+        fmt.Sprintf("%s -> %s", previous, role),
+    ); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// SetUserTier changes a user's subscription tier and records the change in
+// the audit log. Like roles, tiers are read on every request, so the new
+// limits apply immediately. A downgrade deletes nothing: resources beyond
+// the new limits are kept and become read-only.
+func (s *Service) SetUserTier(ctx context.Context, actor *models.User, userID, tier string) error {
+    if !s.tiers.ValidTier(tier) {
+        return ErrInvalidTier
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var previous string
+    err = tx.QueryRowContext(ctx, "SELECT subscription_tier FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&previous)
+    if err == sql.ErrNoRows {
+        return ErrUserNotFound
+    }
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE users SET subscription_tier = $1, updated_at = $2 WHERE id = $3",
+        tier, time.Now(), userID,
+    ); err != nil {
+        return fmt.Errorf("failed to update subscription tier: %w", err)
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.tier_changed", userID,
+        fmt.Sprintf("%s -> %s", previous, tier),
+    ); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// writeAudit records a privileged or account-changing action. It takes the
+// caller's transaction, where there is one, so the entry commits together
+// with the change. The client IP comes from ctx and is empty for background
+// jobs.
+func writeAudit(ctx context.Context, exec auditExecer, actorEmail, action, targetID, details string) error {
+    query := `
+        INSERT INTO audit_logs (actor_email, action, target_id, details, ip)
+        VALUES ($1, $2, $3, $4, $5)
+    `
+    if _, err := exec.ExecContext(ctx, query, actorEmail, action, targetID, details, ClientIPFromContext(ctx)); err != nil {
+        return fmt.Errorf("failed to write audit log: %w", err)
+    }
+    return nil
+}
+
+// WriteAudit is writeAudit for changes made by other packages, such as
+// model activations
+func WriteAudit(ctx context.Context, tx *sql.Tx, actorEmail, action, targetID, details string) error {
+    return writeAudit(ctx, tx, actorEmail, action, targetID, details)
+}
+
+// RecordAdminAction audits an admin action on state outside the database,
+// such as the cache, which has no transaction to write the entry in
+func (s *Service) RecordAdminAction(ctx context.Context, actor *models.User, action, targetID, details string) error {
+    return writeAudit(ctx, s.db, actor.Email, action, targetID, details)
+}
+
+// ListAuditLog returns the most recent audit entries, newest first
+func (s *Service) ListAuditLog(ctx context.Context, limit int) ([]AuditEntry, error) {
+    query := `
+        SELECT id, actor_email, action, target_id, details, ip, actor_id, impersonated_user_id, created_at
+        FROM audit_logs
+        ORDER BY created_at DESC
+        LIMIT $1
+    `
+    rows, err := s.db.QueryContext(ctx, query, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []AuditEntry
+    for rows.Next() {
+        var e AuditEntry
+        if err := rows.Scan(&e.ID, &e.ActorEmail, &e.Action, &e.TargetID, &e.Details, &e.IP, &e.ActorID, &e.ImpersonatedUserID, &e.CreatedAt); err != nil {
+            return nil, err
+        }
+        entries = append(entries, e)
+    }
+
+    return entries, rows.Err()
+}
+
+// Login checks the user's credentials and starts a new session for the
+// device described by client. Accounts with two-factor authentication get a
+// challenge instead, to be completed with CompleteTwoFactor.
+func (s *Service) Login(ctx context.Context, email, password string, client ClientInfo) (*LoginResult, error) {
     var user models.User
+    var twoFactor bool
     query := `
-        SELECT id, email, password_hash, name, role
+        SELECT id, email, password_hash, name, role, totp_enabled
         FROM users
-        WHERE email = $1
+        WHERE email = $1 AND deleted_at IS NULL
     `
     err := s.db.QueryRowContext(ctx, query, email).Scan(
-        &user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.Role,
+        &user.ID, &user.Email, &user.HashedPassword, &user.Name, &user.Role, &twoFactor,
     )
     if err != nil {
-        return "", err
+        return nil, err
+    }
+
+    if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)); err != nil {
+        return nil, errors.New("invalid password")
+    }
+
+    if twoFactor {
+        challenge, err := s.issueChallenge(user.ID)
+        if err != nil {
+            return nil, err
+        }
+        return &LoginResult{TwoFactorRequired: true, ChallengeToken: challenge}, nil
+    }
+
+    if _, err := s.db.ExecContext(ctx, "UPDATE users SET last_login_at = $1 WHERE id = $2", time.Now(), user.ID); err != nil {
+        logger.FromContext(ctx).Warnf("Failed to record login for user %v: %v", user.ID, err)
+    }
+
+    sessionID, err := s.createSession(ctx, user.ID, client)
+    if err != nil {
+        return nil, err
     }
 
-    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-        return "", errors.New("invalid password")
+    token, err := s.issueToken(&user, sessionID)
+    if err != nil {
+        return nil, err
     }
+    s.metrics.countToken(TokenIssued)
+    return &LoginResult{Token: token}, nil
+}
 
+func (s *Service) issueToken(user *models.User, sessionID string) (string, error) {
+    now := time.Now()
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
         "user_id": user.ID,
         "email":   user.Email,
         "role":    user.Role,
-        "exp":     time.Now().Add(24 * time.Hour).Unix(),
+        "sid":     sessionID,
+        "iat":     now.Unix(),
+        "exp":     now.Add(tokenLifetime).Unix(),
     })
 
     return token.SignedString(s.jwtSecret)
 }
 
-func (s *Service) ValidateToken(tokenString string) (*models.User, error) {
+func (s *Service) ValidateToken(ctx context.Context, tokenString string) (*models.User, error) {
+    if s.blacklist.IsBlacklisted(ctx, tokenString) {
+        return nil, ErrTokenRevoked
+    }
+
     token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
         if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
             return nil, errors.New("invalid signing method")
@@ -77,21 +356,46 @@ func (s *Service) ValidateToken(tokenString string) (*models.User, error) {
     }
 
     if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+        // Two-factor challenges only grant access to CompleteTwoFactor
+        if _, ok := claims["purpose"]; ok {
+            return nil, errors.New("invalid token")
+        }
+        if imp, _ := claims["imp"].(string); imp != "" {
+            return s.impersonatedUser(ctx, imp)
+        }
+
         userID := int64(claims["user_id"].(float64))
         var user models.User
         
+        var revokedAt sql.NullTime
+
         query := `
-            SELECT id, email, name, role
+            SELECT id, email, name, role, subscription_tier, tokens_revoked_at
             FROM users
-            WHERE id = $1
+            WHERE id = $1 AND deleted_at IS NULL
         `
-        err := s.db.QueryRow(query, userID).Scan(
-            &user.ID, &user.Email, &user.Name, &user.Role,
+        err := s.db.QueryRowContext(ctx, query, userID).Scan(
+            &user.ID, &user.Email, &user.Name, &user.Role, &user.SubscriptionTier, &revokedAt,
         )
         if err != nil {
             return nil, err
         }
 
+        // Tokens issued before a password change or account deletion are void
+        issuedAt, _ := claims["iat"].(float64)
+        if revokedAt.Valid && int64(issuedAt) < revokedAt.Time.Unix() {
+            return nil, ErrTokenRevoked
+        }
+
+        // Tokens issued before session tracking carry no sid and are only
+        // covered by the checks above
+        if sid, _ := claims["sid"].(string); sid != "" {
+            if err := s.checkSession(ctx, sid); err != nil {
+                return nil, err
+            }
+            s.touchSession(ctx, sid)
+        }
+
         return &user, nil
     }
 
@@ -0,0 +1,129 @@
+package auth
+
+import (
+    "context"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestService_SetUserRole(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    admin := &models.User{Email: "admin@example.com", Role: RoleAdmin}
+    ctx := context.Background()
+
+    t.Run("Promote user and write audit entry", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT role FROM users WHERE id = (.+) FOR UPDATE").
+            WithArgs("42").
+            WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(RoleUser))
+        mock.ExpectExec("UPDATE users SET role = (.+)").
+            WithArgs(RoleAnalyst, sqlmock.AnyArg(), "42").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("admin@example.com", "user.role_changed", "42", "user -> analyst", "").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+
+        err := service.SetUserRole(ctx, admin, "42", RoleAnalyst)
+        assert.NoError(t, err)
+    })
+
+    t.Run("Reject unknown role", func(t *testing.T) {
+        err := service.SetUserRole(ctx, admin, "42", "root")
+        assert.ErrorIs(t, err, ErrInvalidRole)
+    })
+
+    t.Run("Refuse to demote the last admin", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT role FROM users WHERE id = (.+) FOR UPDATE").
+            WithArgs("1").
+            WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(RoleAdmin))
+        mock.ExpectQuery("SELECT COUNT(.+) FROM users WHERE role = (.+)").
+            WithArgs(RoleAdmin).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+        mock.ExpectRollback()
+
+        err := service.SetUserRole(ctx, admin, "1", RoleUser)
+        assert.ErrorIs(t, err, ErrLastAdmin)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_SetUserTier(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+    admin := &models.User{Email: "admin@example.com", Role: RoleAdmin}
+    ctx := context.Background()
+
+    t.Run("Downgrade user and write audit entry", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT subscription_tier FROM users WHERE id = (.+) FOR UPDATE").
+            WithArgs("42").
+            WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(TierPro))
+        mock.ExpectExec("UPDATE users SET subscription_tier = (.+)").
+            WithArgs(TierFree, sqlmock.AnyArg(), "42").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("admin@example.com", "user.tier_changed", "42", "pro -> free", "").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+
+        err := service.SetUserTier(ctx, admin, "42", TierFree)
+        assert.NoError(t, err)
+    })
+
+    t.Run("Reject unknown tier", func(t *testing.T) {
+        err := service.SetUserTier(ctx, admin, "42", "platinum")
+        assert.ErrorIs(t, err, ErrInvalidTier)
+    })
+
+    t.Run("Unknown user", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT subscription_tier FROM users WHERE id = (.+) FOR UPDATE").
+            WithArgs("7").
+            WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}))
+        mock.ExpectRollback()
+
+        err := service.SetUserTier(ctx, admin, "7", TierPro)
+        assert.ErrorIs(t, err, ErrUserNotFound)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_RegisterBootstrapAdmin(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret").WithBootstrapAdmin("Owner@example.com")
+    ctx := context.Background()
+
+    mock.ExpectExec("INSERT INTO users").
+        WithArgs("owner@example.com", sqlmock.AnyArg(), "Owner", RoleAdmin).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectExec("INSERT INTO users").
+        WithArgs("someone@example.com", sqlmock.AnyArg(), "Someone", RoleUser).
+        WillReturnResult(sqlmock.NewResult(2, 1))
+
+    assert.NoError(t, service.Register(ctx, "owner@example.com", "password123", "Owner"))
+    assert.NoError(t, service.Register(ctx, "someone@example.com", "password123", "Someone"))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+    "context"
+    "crypto/rand"
+    "database/sql"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// sessionTouchInterval throttles last-seen updates to one write per session
+const sessionTouchInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo identifies the device a session was created from
+type ClientInfo struct {
+    UserAgent string
+    IP        string
+}
+
+type Session struct {
+    ID         string     `json:"id"`
+    UserAgent  string     `json:"user_agent"`
+    IP         string     `json:"ip"`
+    CreatedAt  time.Time  `json:"created_at"`
+    LastSeenAt time.Time  `json:"last_seen_at"`
+    ExpiresAt  time.Time  `json:"expires_at"`
+    RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+    Current    bool       `json:"current"`
+}
+
+// sessionTouches remembers when each session's last-seen time was written
+type sessionTouches struct {
+    mu   sync.Mutex
+    seen map[string]time.Time
+}
+
+// due reports whether sid should be written now and records the write
+func (t *sessionTouches) due(sid string, now time.Time) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if last, ok := t.seen[sid]; ok && now.Sub(last) < sessionTouchInterval {
+        return false
+    }
+
+    // Drop stale entries so the map stays bounded by recently active sessions
+    if len(t.seen) > 10000 {
+        for id, last := range t.seen {
+            if now.Sub(last) >= sessionTouchInterval {
+                delete(t.seen, id)
+            }
+        }
+    }
+
+    t.seen[sid] = now
+    return true
+}
+
+func newSessionID() (string, error) {
+    b := make([]byte, 24)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Service) createSession(ctx context.Context, userID uuid.UUID, client ClientInfo) (string, error) {
+    sid, err := newSessionID()
+    if err != nil {
+        return "", err
+    }
+
+    now := time.Now()
+    query := `
+        INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $5, $6)
+    `
+    if _, err := s.db.ExecContext(ctx, query,
+        sid, userID, client.UserAgent, client.IP, now, now.Add(tokenLifetime),
+    ); err != nil {
+        return "", fmt.Errorf("failed to create session: %w", err)
+    }
+
+    return sid, nil
+}
+
+// SessionID returns the session a token belongs to, or "" if the token
+// cannot be parsed or predates session tracking
+func (s *Service) SessionID(tokenString string) string {
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, errors.New("invalid signing method")
+        }
+        return s.jwtSecret, nil
+    })
+    if err != nil {
+        return ""
+    }
+
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        return ""
+    }
+    sid, _ := claims["sid"].(string)
+    return sid
+}
+
+// checkSession rejects sessions that were revoked, have expired or no longer
+// exist. The blacklist is consulted first so a revocation takes effect even
+// before the database write is visible.
+func (s *Service) checkSession(ctx context.Context, sid string) error {
+    if s.blacklist.IsBlacklisted(ctx, sessionBlacklistKey(sid)) {
+        return ErrTokenRevoked
+    }
+
+    var expiresAt time.Time
+    var revokedAt sql.NullTime
+    err := s.db.QueryRowContext(ctx,
+        "SELECT expires_at, revoked_at FROM sessions WHERE id = $1", sid,
+    ).Scan(&expiresAt, &revokedAt)
+    if err == sql.ErrNoRows {
+        return ErrTokenRevoked
+    }
+    if err != nil {
+        return err
+    }
+    if revokedAt.Valid || !expiresAt.After(time.Now()) {
+        return ErrTokenRevoked
+    }
+    return nil
+}
+
+// touchSession records activity on a session at most once per
+// sessionTouchInterval. Failures only cost accuracy, so they are logged.
+func (s *Service) touchSession(ctx context.Context, sid string) {
+    now := time.Now()
+    if !s.touches.due(sid, now) {
+        return
+    }
+
+    if _, err := s.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = $1 WHERE id = $2", now, sid); err != nil {
+        logger.FromContext(ctx).Warnf("Failed to update session %s: %v", sid, err)
+    }
+}
+
+// ListSessions returns the user's unexpired sessions, newest first.
+// currentSessionID marks the session making the request.
+func (s *Service) ListSessions(ctx context.Context, userID uuid.UUID, currentSessionID string) ([]Session, error) {
+    query := `
+        SELECT id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at
+        FROM sessions
+        WHERE user_id = $1 AND expires_at > $2
+        ORDER BY created_at DESC
+    `
+    rows, err := s.db.QueryContext(ctx, query, userID, time.Now())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sessions []Session
+    for rows.Next() {
+        var session Session
+        if err := rows.Scan(
+            &session.ID, &session.UserAgent, &session.IP,
+            &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.RevokedAt,
+        ); err != nil {
+            return nil, err
+        }
+        session.Current = session.ID == currentSessionID
+        sessions = append(sessions, session)
+    }
+
+    return sessions, rows.Err()
+}
+
+// RevokeSession logs one of the actor's sessions out. The session is added
+// to the blacklist so its tokens are rejected without a database lookup.
+func (s *Service) RevokeSession(ctx context.Context, actor *models.User, sessionID string) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var expiresAt time.Time
+    query := `
+        UPDATE sessions
+        SET revoked_at = COALESCE(revoked_at, $1)
+        WHERE id = $2 AND user_id = $3
+        RETURNING expires_at
+    `
+    err = tx.QueryRowContext(ctx, query, time.Now(), sessionID, actor.ID).Scan(&expiresAt)
+    if err == sql.ErrNoRows {
+        return ErrSessionNotFound
+    }
+    if err != nil {
+        return fmt.Errorf("failed to revoke session: %w", err)
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "session.revoked", actor.ID.String(), sessionID); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    return s.revoke(ctx, sessionBlacklistKey(sessionID), time.Until(expiresAt))
+}
+
+// revokeOtherSessions marks every session of userID except keepSessionID as
+// revoked, inside the caller's transaction
+func revokeOtherSessions(ctx context.Context, tx *sql.Tx, userID uuid.UUID, keepSessionID string, now time.Time) error {
+    query := `
+        UPDATE sessions
+        SET revoked_at = $1
+        WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL
+    `
+    if _, err := tx.ExecContext(ctx, query, now, userID, keepSessionID); err != nil {
+        return fmt.Errorf("failed to revoke sessions: %w", err)
+    }
+    return nil
+}
+
+// CleanupExpiredSessions deletes sessions whose tokens can no longer be used
+func (s *Service) CleanupExpiredSessions(ctx context.Context) (int64, error) {
+    result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= $1", time.Now())
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+func sessionBlacklistKey(sessionID string) string {
+    return "session:" + sessionID
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestService_RevokeSession(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    metrics := NewMetrics(nil)
+    service := NewService(db, "secret").WithMetrics(metrics)
+    user := &models.User{ID: uuid.New(), Email: "user@example.com", Role: RoleUser}
+    ctx := WithClientIP(context.Background(), "2001:db8::1")
+
+    t.Run("Revoke own session", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("UPDATE sessions SET revoked_at = (.+) WHERE id = (.+) AND user_id = (.+) RETURNING expires_at").
+            WithArgs(sqlmock.AnyArg(), "sess-1", user.ID).
+            WillReturnRows(sqlmock.NewRows([]string{"expires_at"}).AddRow(time.Now().Add(time.Hour)))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("user@example.com", "session.revoked", user.ID.String(), "sess-1", "2001:db8::1").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+
+        assert.NoError(t, service.RevokeSession(ctx, user, "sess-1"))
+        assert.ErrorIs(t, service.checkSession(ctx, "sess-1"), ErrTokenRevoked)
+        assert.Equal(t, 1.0, testutil.ToFloat64(metrics.tokens.WithLabelValues(TokenRevoked)))
+        assert.Equal(t, 1.0, testutil.ToFloat64(metrics.blacklistSize))
+    })
+
+    t.Run("Cannot revoke another user's session", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectQuery("UPDATE sessions SET revoked_at = (.+)").
+            WithArgs(sqlmock.AnyArg(), "sess-2", user.ID).
+            WillReturnRows(sqlmock.NewRows([]string{"expires_at"}))
+        mock.ExpectRollback()
+
+        err := service.RevokeSession(ctx, user, "sess-2")
+        assert.ErrorIs(t, err, ErrSessionNotFound)
+        assert.False(t, service.blacklist.IsBlacklisted(context.Background(), sessionBlacklistKey("sess-2")))
+        assert.Equal(t, 1.0, testutil.ToFloat64(metrics.tokens.WithLabelValues(TokenRevoked)))
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_TouchSessionIsThrottled(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, "secret")
+
+    // Only the first of several back-to-back requests writes last_seen_at
+    mock.ExpectExec("UPDATE sessions SET last_seen_at = (.+) WHERE id = (.+)").
+        WithArgs(sqlmock.AnyArg(), "sess-1").
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    for i := 0; i < 3; i++ {
+        service.touchSession(context.Background(), "sess-1")
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    // Once the interval has passed the session is written again
+    service.touches.seen["sess-1"] = time.Now().Add(-sessionTouchInterval)
+    mock.ExpectExec("UPDATE sessions SET last_seen_at = (.+) WHERE id = (.+)").
+        WithArgs(sqlmock.AnyArg(), "sess-1").
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    service.touchSession(context.Background(), "sess-1")
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+    "errors"
+    "fmt"
+    "os"
+
+    "gopkg.in/yaml.v2"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Subscription tiers. New users get TierFree, and users on a tier the
+// definitions don't know are held to its limits.
+const (
+    TierFree       = "free"
+    TierPro        = "pro"
+    TierEnterprise = "enterprise"
+)
+
+// Features a tier may grant
+const (
+    FeatureOptimization = "optimization"
+    FeatureBacktest     = "backtest"
+)
+
+// Limits a tier may set
+const (
+    LimitMaxPortfolios       = "max_portfolios"
+    LimitDailyPredictions    = "daily_predictions"
+    LimitMaxWatchlistSymbols = "max_watchlist_symbols"
+    // LimitPredictionSubscriptions caps a user's prediction subscriptions,
+    // and LimitDailyScheduledPredictions the predictions generated for
+    // them a day, apart from LimitDailyPredictions
+    LimitPredictionSubscriptions   = "prediction_subscriptions"
+    LimitDailyScheduledPredictions = "daily_scheduled_predictions"
+)
+
+// UpgradeRequiredCode is the error code of responses refused by a tier
+const UpgradeRequiredCode = "UPGRADE_REQUIRED"
+
+var (
+    ErrInvalidTier     = errors.New("invalid subscription tier")
+    ErrUpgradeRequired = errors.New("upgrade required")
+)
+
+// TierLimits is what one subscription tier allows. Limits the tier doesn't
+// list are unlimited.
+type TierLimits struct {
+    Features []string       `yaml:"features" json:"features"`
+    Limits   map[string]int `yaml:"limits" json:"limits"`
+}
+
+// DefaultTiers are the tiers used unless a definitions file replaces them
+func DefaultTiers() map[string]TierLimits {
+    return map[string]TierLimits{
+        TierFree: {
+            Limits: map[string]int{
+                LimitMaxPortfolios:             1,
+                LimitDailyPredictions:          20,
+                LimitMaxWatchlistSymbols:       10,
+                LimitPredictionSubscriptions:   3,
+                LimitDailyScheduledPredictions: 50,
+            },
+        },
+        TierPro: {
+            Features: []string{FeatureOptimization, FeatureBacktest},
+            Limits: map[string]int{
+                LimitMaxPortfolios:             10,
+                LimitDailyPredictions:          500,
+                LimitMaxWatchlistSymbols:       100,
+                LimitPredictionSubscriptions:   50,
+                LimitDailyScheduledPredictions: 2000,
+            },
+        },
+        TierEnterprise: {
+            Features: []string{FeatureOptimization, FeatureBacktest},
+        },
+    }
+}
+
+// LoadTiers reads tier definitions from a YAML file mapping each tier to
+// its features and limits. The free tier must be defined.
+func LoadTiers(path string) (map[string]TierLimits, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read tiers: %w", err)
+    }
+
+    var tiers map[string]TierLimits
+    if err := yaml.Unmarshal(data, &tiers); err != nil {
+        return nil, fmt.Errorf("failed to parse tiers: %w", err)
+    }
+    if _, ok := tiers[TierFree]; !ok {
+        return nil, fmt.Errorf("tiers must define the %s tier", TierFree)
+    }
+    for name, tier := range tiers {
+        for limit, n := range tier.Limits {
+            if n < 0 {
+                return nil, fmt.Errorf("tier %s: %s must not be negative", name, limit)
+            }
+        }
+    }
+    return tiers, nil
+}
+
+// UpgradeRequiredError is returned when the user's tier doesn't grant a
+// feature, or the user has reached one of its limits. Limit is nil for
+// features.
+type UpgradeRequiredError struct {
+    Tier    string `json:"tier"`
+    Feature string `json:"feature"`
+    Limit   *int   `json:"limit,omitempty"`
+}
+
+func (e *UpgradeRequiredError) Error() string {
+    if e.Limit != nil {
+        return fmt.Sprintf("the %s tier allows %s of %d; upgrade to raise it", e.Tier, e.Feature, *e.Limit)
+    }
+    return fmt.Sprintf("the %s tier does not include %s; upgrade to use it", e.Tier, e.Feature)
+}
+
+func (e *UpgradeRequiredError) Is(target error) bool {
+    return target == ErrUpgradeRequired
+}
+
+// FeatureGate decides what users may do from their subscription tier
+type FeatureGate struct {
+    tiers map[string]TierLimits
+}
+
+func NewFeatureGate(tiers map[string]TierLimits) *FeatureGate {
+    return &FeatureGate{tiers: tiers}
+}
+
+// ValidTier reports whether tier is defined
+func (g *FeatureGate) ValidTier(tier string) bool {
+    _, ok := g.tiers[tier]
+    return ok
+}
+
+// tier returns the name and definition of the tier user is held to
+func (g *FeatureGate) tier(user *models.User) (string, TierLimits) {
+    if tier, ok := g.tiers[user.SubscriptionTier]; ok {
+        return user.SubscriptionTier, tier
+    }
+    return TierFree, g.tiers[TierFree]
+}
+
+// Allow reports whether user's tier grants feature
+func (g *FeatureGate) Allow(user *models.User, feature string) bool {
+    _, tier := g.tier(user)
+    for _, f := range tier.Features {
+        if f == feature {
+            return true
+        }
+    }
+    return false
+}
+
+// Features returns the features user's tier grants
+func (g *FeatureGate) Features(user *models.User) []string {
+    _, tier := g.tier(user)
+    return append([]string(nil), tier.Features...)
+}
+
+// LimitFor returns user's limit, and false if the tier doesn't limit it
+func (g *FeatureGate) LimitFor(user *models.User, limit string) (int, bool) {
+    _, tier := g.tier(user)
+    n, ok := tier.Limits[limit]
+    return n, ok
+}
+
+// RequireFeature returns an UpgradeRequiredError unless user's tier grants
+// feature
+func (g *FeatureGate) RequireFeature(user *models.User, feature string) error {
+    if g.Allow(user, feature) {
+        return nil
+    }
+    name, _ := g.tier(user)
+    return &UpgradeRequiredError{Tier: name, Feature: feature}
+}
+
+// CheckLimit returns an UpgradeRequiredError once user has used all of
+// limit
+func (g *FeatureGate) CheckLimit(user *models.User, limit string, used int) error {
+    n, ok := g.LimitFor(user, limit)
+    if !ok || used < n {
+        return nil
+    }
+    name, _ := g.tier(user)
+    return &UpgradeRequiredError{Tier: name, Feature: limit, Limit: &n}
+}
+
+// ReadOnly reports whether the user's resource at index, counting their
+// resources under limit oldest first from 0, is beyond the limit. A
+// downgrade leaves such resources in place but read-only, and upgrading
+// again makes them writable.
+func (g *FeatureGate) ReadOnly(user *models.User, limit string, index int) bool {
+    n, ok := g.LimitFor(user, limit)
+    return ok && index >= n
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestFeatureGate(t *testing.T) {
+    gate := NewFeatureGate(DefaultTiers())
+    free := &models.User{SubscriptionTier: TierFree}
+    pro := &models.User{SubscriptionTier: TierPro}
+    enterprise := &models.User{SubscriptionTier: TierEnterprise}
+    unknown := &models.User{SubscriptionTier: "legacy"}
+
+    t.Run("Features", func(t *testing.T) {
+        err := gate.RequireFeature(free, FeatureOptimization)
+        assert.ErrorIs(t, err, ErrUpgradeRequired)
+        var upgrade *UpgradeRequiredError
+        if assert.True(t, errors.As(err, &upgrade)) {
+            assert.Equal(t, TierFree, upgrade.Tier)
+            assert.Nil(t, upgrade.Limit)
+        }
+
+        assert.NoError(t, gate.RequireFeature(pro, FeatureOptimization))
+        assert.NoError(t, gate.RequireFeature(enterprise, FeatureBacktest))
+        // Unknown tiers are held to the free tier
+        assert.ErrorIs(t, gate.RequireFeature(unknown, FeatureOptimization), ErrUpgradeRequired)
+    })
+
+    t.Run("Limits", func(t *testing.T) {
+        assert.NoError(t, gate.CheckLimit(free, LimitMaxPortfolios, 0))
+
+        err := gate.CheckLimit(free, LimitMaxPortfolios, 1)
+        var upgrade *UpgradeRequiredError
+        if assert.True(t, errors.As(err, &upgrade)) {
+            assert.Equal(t, LimitMaxPortfolios, upgrade.Feature)
+            assert.Equal(t, 1, *upgrade.Limit)
+        }
+
+        assert.NoError(t, gate.CheckLimit(pro, LimitMaxPortfolios, 1))
+        assert.ErrorIs(t, gate.CheckLimit(pro, LimitDailyPredictions, 500), ErrUpgradeRequired)
+        assert.NoError(t, gate.CheckLimit(enterprise, LimitDailyPredictions, 1000000))
+    })
+
+    t.Run("Read-only after a downgrade", func(t *testing.T) {
+        assert.False(t, gate.ReadOnly(free, LimitMaxPortfolios, 0))
+        assert.True(t, gate.ReadOnly(free, LimitMaxPortfolios, 1))
+        assert.False(t, gate.ReadOnly(pro, LimitMaxPortfolios, 1))
+        assert.False(t, gate.ReadOnly(enterprise, LimitMaxPortfolios, 50))
+    })
+}
+
+func TestLoadTiers(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, content string) string {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+            t.Fatalf("Failed to write tiers: %v", err)
+        }
+        return path
+    }
+
+    path := write("tiers.yaml", `
+free:
+  limits:
+    max_portfolios: 2
+team:
+  features: [optimization]
+`)
+    tiers, err := LoadTiers(path)
+    if !assert.NoError(t, err) {
+        return
+    }
+    gate := NewFeatureGate(tiers)
+    assert.True(t, gate.ValidTier("team"))
+    assert.False(t, gate.ValidTier(TierPro))
+    n, ok := gate.LimitFor(&models.User{SubscriptionTier: TierFree}, LimitMaxPortfolios)
+    assert.True(t, ok)
+    assert.Equal(t, 2, n)
+    assert.True(t, gate.Allow(&models.User{SubscriptionTier: "team"}, FeatureOptimization))
+
+    _, err = LoadTiers(write("nofree.yaml", "pro:\n  features: [optimization]\n"))
+    assert.Error(t, err)
+
+    _, err = LoadTiers(write("negative.yaml", "free:\n  limits:\n    max_portfolios: -1\n"))
+    assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "net/url"
+    "sync"
+    "time"
+)
+
+// TOTP parameters from RFC 6238, matching what authenticator apps assume
+const (
+    totpPeriod = 30 * time.Second
+    totpDigits = 6
+    // totpSkew is how many time steps either side of now are accepted
+    totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret in base32
+func newTOTPSecret() (string, error) {
+    b := make([]byte, 20)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURI builds the otpauth:// URI authenticator apps scan as a QR code
+func totpURI(issuer, account, secret string) string {
+    v := url.Values{}
+    v.Set("secret", secret)
+    v.Set("issuer", issuer)
+    v.Set("algorithm", "SHA1")
+    v.Set("digits", fmt.Sprint(totpDigits))
+    v.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+    label := url.PathEscape(issuer + ":" + account)
+    return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode computes the HOTP value for counter (RFC 4226)
+func totpCode(key []byte, counter uint64) string {
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], counter)
+
+    mac := hmac.New(sha1.New, key)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+
+    offset := sum[len(sum)-1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+    mod := uint32(1)
+    for i := 0; i < totpDigits; i++ {
+        mod *= 10
+    }
+    return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// verifyTOTP checks code against the steps around now and returns the
+// matching step, so callers can refuse to accept the same step twice
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+    if len(code) != totpDigits {
+        return 0, false
+    }
+    key, err := totpEncoding.DecodeString(secret)
+    if err != nil {
+        return 0, false
+    }
+
+    current := now.Unix() / int64(totpPeriod/time.Second)
+    for step := current - totpSkew; step <= current+totpSkew; step++ {
+        if step < 0 {
+            continue
+        }
+        if hmac.Equal([]byte(totpCode(key, uint64(step))), []byte(code)) {
+            return step, true
+        }
+    }
+    return 0, false
+}
+
+// codeAttemptLimiter caps two-factor code attempts per user, so the 6-digit
+// code space can't be brute forced
+type codeAttemptLimiter interface {
+    // attempt counts an attempt by key and reports whether it is within the
+    // limit. Counting and checking are one step, so parallel guesses can't
+    // all pass a check made before any of them was counted.
+    attempt(ctx context.Context, key string) bool
+    // reset forgets key's attempts, after one succeeds
+    reset(ctx context.Context, key string)
+}
+
+// attemptLimiter is an in-memory codeAttemptLimiter. Its counts are lost on
+// restart and not shared between instances.
+type attemptLimiter struct {
+    max      int
+    window   time.Duration
+    mu       sync.Mutex
+    attempts map[string][]time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+    return &attemptLimiter{
+        max:      max,
+        window:   window,
+        attempts: make(map[string][]time.Time),
+    }
+}
+
+func (l *attemptLimiter) attempt(ctx context.Context, key string) bool {
+    return l.attemptAt(key, time.Now())
+}
+
+// attemptAt counts an attempt by key at now, unless key already made max
+// attempts within the window
+func (l *attemptLimiter) attemptAt(key string, now time.Time) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    recent := l.prune(key, now)
+    if len(recent) >= l.max {
+        return false
+    }
+    l.attempts[key] = append(recent, now)
+    return true
+}
+
+func (l *attemptLimiter) reset(ctx context.Context, key string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    delete(l.attempts, key)
+}
+
+// prune drops attempts outside the window; the caller holds mu
+func (l *attemptLimiter) prune(key string, now time.Time) []time.Time {
+    recent := l.attempts[key][:0]
+    for _, t := range l.attempts[key] {
+        if now.Sub(t) < l.window {
+            recent = append(recent, t)
+        }
+    }
+    if len(recent) == 0 {
+        delete(l.attempts, key)
+        return nil
+    }
+    l.attempts[key] = recent
+    return recent
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+    // RFC 6238 appendix B SHA-1 vectors, truncated to 6 digits
+    key := []byte("12345678901234567890")
+    tests := []struct {
+        unix int64
+        want string
+    }{
+        {59, "287082"},
+        {1111111109, "081804"},
+        {1234567890, "005924"},
+        {2000000000, "279037"},
+    }
+
+    for _, tt := range tests {
+        assert.Equal(t, tt.want, totpCode(key, uint64(tt.unix/30)))
+    }
+}
+
+func TestVerifyTOTP_Skew(t *testing.T) {
+    secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+    // 287082 is the code for step 1 (t = 30..59s)
+    issued := time.Unix(45, 0)
+
+    t.Run("Same step", func(t *testing.T) {
+        step, ok := verifyTOTP(secret, "287082", issued)
+        assert.True(t, ok)
+        assert.Equal(t, int64(1), step)
+    })
+
+    t.Run("One step either side", func(t *testing.T) {
+        _, ok := verifyTOTP(secret, "287082", issued.Add(-totpPeriod))
+        assert.True(t, ok)
+        _, ok = verifyTOTP(secret, "287082", issued.Add(totpPeriod))
+        assert.True(t, ok)
+    })
+
+    t.Run("Two steps away", func(t *testing.T) {
+        _, ok := verifyTOTP(secret, "287082", issued.Add(2*totpPeriod))
+        assert.False(t, ok)
+    })
+
+    t.Run("Malformed code", func(t *testing.T) {
+        _, ok := verifyTOTP(secret, "28708", issued)
+        assert.False(t, ok)
+    })
+}
+
+func TestAttemptLimiter(t *testing.T) {
+    limiter := newAttemptLimiter(3, time.Minute)
+    now := time.Now()
+
+    for i := 0; i < 3; i++ {
+        assert.True(t, limiter.attemptAt("user", now))
+    }
+    assert.False(t, limiter.attemptAt("user", now))
+    assert.True(t, limiter.attemptAt("other", now))
+
+    // Attempts age out of the window
+    assert.True(t, limiter.attemptAt("user", now.Add(time.Minute)))
+
+    limiter.reset(context.Background(), "user")
+    for i := 0; i < 3; i++ {
+        assert.True(t, limiter.attemptAt("user", now))
+    }
+}
+
+func TestHashRecoveryCode_Normalizes(t *testing.T) {
+    code, err := newRecoveryCode()
+    assert.NoError(t, err)
+    assert.Len(t, code, 11)
+
+    assert.Equal(t, hashRecoveryCode(code), hashRecoveryCode("  "+code+" "))
+    assert.Equal(t, hashRecoveryCode("abcde-fghij"), hashRecoveryCode("ABCDEFGHIJ"))
+    assert.NotEqual(t, hashRecoveryCode("abcde-fghij"), hashRecoveryCode("abcde-fghik"))
+}
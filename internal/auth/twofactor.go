@@ -0,0 +1,451 @@
+package auth
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base32"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    totpIssuer = "WolfAI"
+
+    // challengeLifetime is how long a user has to enter their code after
+    // the password step of a two-factor login
+    challengeLifetime = 5 * time.Minute
+    challengePurpose  = "2fa"
+
+    recoveryCodeCount = 10
+
+    // At most maxCodeAttempts codes per user per codeAttemptWindow, until
+    // one is right
+    maxCodeAttempts   = 5
+    codeAttemptWindow = 15 * time.Minute
+)
+
+var (
+    ErrTwoFactorUnavailable = errors.New("two-factor authentication is not configured")
+    ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+    ErrTwoFactorNotPending  = errors.New("two-factor setup has not been started")
+    ErrTwoFactorDisabled    = errors.New("two-factor authentication is not enabled")
+    ErrInvalidCode          = errors.New("invalid authentication code")
+    ErrInvalidChallenge     = errors.New("invalid or expired two-factor challenge")
+    ErrTooManyAttempts      = errors.New("too many failed attempts, try again later")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LoginResult is the outcome of the password step. Accounts with two-factor
+// authentication get a ChallengeToken to exchange for a Token.
+type LoginResult struct {
+    Token             string `json:"token,omitempty"`
+    TwoFactorRequired bool   `json:"two_factor_required"`
+    ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// TwoFactorSetup is shown to the user once so they can add the account to
+// an authenticator app
+type TwoFactorSetup struct {
+    Secret string `json:"secret"`
+    URI    string `json:"uri"`
+}
+
+// EncryptedColumns lists the columns this package encrypts, for key rotation
+var EncryptedColumns = []crypto.Column{
+    {Table: "users", IDColumn: "id", Column: "totp_secret", AssociatedData: totpAssociatedData},
+    {Table: "users", IDColumn: "id", Column: "totp_pending_secret", AssociatedData: totpAssociatedData},
+}
+
+// totpAssociatedData binds a TOTP secret to its user, so a ciphertext
+// copied onto another account fails to decrypt
+func totpAssociatedData(userID string) []byte {
+    return []byte("totp:" + userID)
+}
+
+// WithKeyring sets the keyring used to encrypt TOTP secrets. Without it
+// two-factor enrollment is unavailable.
+func (s *Service) WithKeyring(keyring *crypto.Keyring) *Service {
+    s.keyring = keyring
+    return s
+}
+
+func (s *Service) decryptTOTPSecret(userID uuid.UUID, encrypted []byte) (string, error) {
+    secret, err := s.keyring.Decrypt(string(encrypted), totpAssociatedData(userID.String()))
+    if err != nil {
+        return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+    }
+    return string(secret), nil
+}
+
+func (s *Service) issueChallenge(userID uuid.UUID) (string, error) {
+    now := time.Now()
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id": userID.String(),
+        "purpose": challengePurpose,
+        "iat":     now.Unix(),
+        "exp":     now.Add(challengeLifetime).Unix(),
+    })
+
+    return token.SignedString(s.jwtSecret)
+}
+
+func (s *Service) parseChallenge(challenge string) (uuid.UUID, error) {
+    token, err := jwt.Parse(challenge, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, errors.New("invalid signing method")
+        }
+        return s.jwtSecret, nil
+    })
+    if err != nil || !token.Valid {
+        return uuid.Nil, ErrInvalidChallenge
+    }
+
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok || claims["purpose"] != challengePurpose {
+        return uuid.Nil, ErrInvalidChallenge
+    }
+    id, _ := claims["user_id"].(string)
+    userID, err := uuid.Parse(id)
+    if err != nil {
+        return uuid.Nil, ErrInvalidChallenge
+    }
+    return userID, nil
+}
+
+// CompleteTwoFactor exchanges a login challenge and a TOTP or recovery code
+// for a session token
+func (s *Service) CompleteTwoFactor(ctx context.Context, challenge, code string, client ClientInfo) (string, error) {
+    if s.keyring == nil {
+        return "", ErrTwoFactorUnavailable
+    }
+
+    userID, err := s.parseChallenge(challenge)
+    if err != nil {
+        return "", err
+    }
+
+    limitKey := userID.String()
+    if !s.codeAttempts.attempt(ctx, limitKey) {
+        return "", ErrTooManyAttempts
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return "", err
+    }
+    defer tx.Rollback()
+
+    var user models.User
+    var encrypted []byte
+    var lastStep int64
+    query := `
+        SELECT id, email, name, role, totp_secret, totp_last_step
+        FROM users
+        WHERE id = $1 AND deleted_at IS NULL AND totp_enabled
+        FOR UPDATE
+    `
+    err = tx.QueryRowContext(ctx, query, userID).Scan(
+        &user.ID, &user.Email, &user.Name, &user.Role, &encrypted, &lastStep,
+    )
+    if err == sql.ErrNoRows {
+        return "", ErrInvalidChallenge
+    }
+    if err != nil {
+        return "", err
+    }
+
+    ok, err := s.consumeTOTP(ctx, tx, userID, encrypted, lastStep, code)
+    if err != nil {
+        return "", err
+    }
+    if !ok {
+        ok, err = consumeRecoveryCode(ctx, tx, &user, code)
+        if err != nil {
+            return "", err
+        }
+    }
+    if !ok {
+        return "", ErrInvalidCode
+    }
+
+    if _, err := tx.ExecContext(ctx, "UPDATE users SET last_login_at = $1 WHERE id = $2", time.Now(), user.ID); err != nil {
+        return "", err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return "", err
+    }
+    s.codeAttempts.reset(ctx, limitKey)
+
+    sessionID, err := s.createSession(ctx, user.ID, client)
+    if err != nil {
+        return "", err
+    }
+
+    token, err := s.issueToken(&user, sessionID)
+    if err != nil {
+        return "", err
+    }
+    s.metrics.countToken(TokenIssued)
+    return token, nil
+}
+
+// consumeTOTP checks code against the encrypted secret and records its time
+// step, so a code that was already used cannot be replayed
+func (s *Service) consumeTOTP(ctx context.Context, tx *sql.Tx, userID uuid.UUID, encrypted []byte, lastStep int64, code string) (bool, error) {
+    secret, err := s.decryptTOTPSecret(userID, encrypted)
+    if err != nil {
+        return false, err
+    }
+
+    step, ok := verifyTOTP(secret, code, time.Now())
+    if !ok || step <= lastStep {
+        return false, nil
+    }
+
+    if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+func consumeRecoveryCode(ctx context.Context, tx *sql.Tx, user *models.User, code string) (bool, error) {
+    query := `
+        UPDATE recovery_codes
+        SET used_at = $1
+        WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+    `
+    result, err := tx.ExecContext(ctx, query, time.Now(), user.ID, hashRecoveryCode(code))
+    if err != nil {
+        return false, err
+    }
+    n, err := result.RowsAffected()
+    if err != nil || n == 0 {
+        return false, err
+    }
+
+    if err := writeAudit(ctx, tx, user.Email, "user.recovery_code_used", user.ID.String(), ""); err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+// SetupTwoFactor starts enrollment with a fresh secret. 2FA is not enabled
+// until EnableTwoFactor confirms the user can generate codes for it.
+func (s *Service) SetupTwoFactor(ctx context.Context, actor *models.User) (*TwoFactorSetup, error) {
+    if s.keyring == nil {
+        return nil, ErrTwoFactorUnavailable
+    }
+
+    secret, err := newTOTPSecret()
+    if err != nil {
+        return nil, err
+    }
+    encrypted, err := s.keyring.Encrypt([]byte(secret), totpAssociatedData(actor.ID.String()))
+    if err != nil {
+        return nil, err
+    }
+
+    query := `
+        UPDATE users
+        SET totp_pending_secret = $1, updated_at = $2
+        WHERE id = $3 AND deleted_at IS NULL AND NOT totp_enabled
+    `
+    result, err := s.db.ExecContext(ctx, query, []byte(encrypted), time.Now(), actor.ID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to start two-factor setup: %w", err)
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return nil, err
+    } else if n == 0 {
+        return nil, ErrTwoFactorEnabled
+    }
+
+    return &TwoFactorSetup{
+        Secret: secret,
+        URI:    totpURI(totpIssuer, actor.Email, secret),
+    }, nil
+}
+
+// EnableTwoFactor confirms enrollment with a code from the pending secret
+// and returns the account's recovery codes. They are only shown once.
+func (s *Service) EnableTwoFactor(ctx context.Context, actor *models.User, code string) ([]string, error) {
+    if s.keyring == nil {
+        return nil, ErrTwoFactorUnavailable
+    }
+
+    limitKey := actor.ID.String()
+    if !s.codeAttempts.attempt(ctx, limitKey) {
+        return nil, ErrTooManyAttempts
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var pending []byte
+    var enabled bool
+    err = tx.QueryRowContext(ctx,
+        "SELECT totp_pending_secret, totp_enabled FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE",
+        actor.ID,
+    ).Scan(&pending, &enabled)
+    if err == sql.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if enabled {
+        return nil, ErrTwoFactorEnabled
+    }
+    if pending == nil {
+        return nil, ErrTwoFactorNotPending
+    }
+
+    secret, err := s.decryptTOTPSecret(actor.ID, pending)
+    if err != nil {
+        return nil, err
+    }
+    step, ok := verifyTOTP(secret, code, time.Now())
+    if !ok {
+        return nil, ErrInvalidCode
+    }
+
+    query := `
+        UPDATE users
+        SET totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+            totp_enabled = TRUE, totp_last_step = $1, updated_at = $2
+        WHERE id = $3
+    `
+    if _, err := tx.ExecContext(ctx, query, step, time.Now(), actor.ID); err != nil {
+        return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+    }
+
+    codes, err := replaceRecoveryCodes(ctx, tx, actor.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.2fa_enabled", actor.ID.String(), ""); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    s.codeAttempts.reset(ctx, limitKey)
+
+    return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current TOTP code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, actor *models.User, code string) ([]string, error) {
+    if s.keyring == nil {
+        return nil, ErrTwoFactorUnavailable
+    }
+
+    limitKey := actor.ID.String()
+    if !s.codeAttempts.attempt(ctx, limitKey) {
+        return nil, ErrTooManyAttempts
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var encrypted []byte
+    var lastStep int64
+    err = tx.QueryRowContext(ctx,
+        "SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND deleted_at IS NULL AND totp_enabled FOR UPDATE",
+        actor.ID,
+    ).Scan(&encrypted, &lastStep)
+    if err == sql.ErrNoRows {
+        return nil, ErrTwoFactorDisabled
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    ok, err := s.consumeTOTP(ctx, tx, actor.ID, encrypted, lastStep, code)
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return nil, ErrInvalidCode
+    }
+
+    codes, err := replaceRecoveryCodes(ctx, tx, actor.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := writeAudit(ctx, tx, actor.Email, "user.recovery_codes_regenerated", actor.ID.String(), ""); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    s.codeAttempts.reset(ctx, limitKey)
+
+    return codes, nil
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and stores hashes
+// of a new set, returning the plaintext codes
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID) ([]string, error) {
+    if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+        return nil, fmt.Errorf("failed to clear recovery codes: %w", err)
+    }
+
+    codes := make([]string, recoveryCodeCount)
+    for i := range codes {
+        code, err := newRecoveryCode()
+        if err != nil {
+            return nil, err
+        }
+        if _, err := tx.ExecContext(ctx,
+            "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+            userID, hashRecoveryCode(code),
+        ); err != nil {
+            return nil, fmt.Errorf("failed to store recovery code: %w", err)
+        }
+        codes[i] = code
+    }
+
+    return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+    b := make([]byte, 7)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+    return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode hashes a code after normalising case and separators.
+// Recovery codes carry 50 random bits, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+    normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+    sum := sha256.Sum256([]byte(normalized))
+    return hex.EncodeToString(sum[:])
+}
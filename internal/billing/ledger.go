@@ -0,0 +1,272 @@
+package billing
+
+import (
+    "context"
+    "database/sql"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/google/uuid"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+)
+
+// aggregationDelay is how long after an hour ends its counters are taken as
+// final, leaving requests in flight at the boundary time to be counted
+const aggregationDelay = 5 * time.Minute
+
+// UsageRecord is one user's count of a metric in an hour
+type UsageRecord struct {
+    UserID    uuid.UUID `json:"user_id"`
+    Metric    string    `json:"metric"`
+    Hour      time.Time `json:"hour"`
+    Count     int64     `json:"count"`
+    Estimated bool      `json:"estimated"`
+}
+
+// MonthlyUsage is one user's usage over a calendar month
+type MonthlyUsage struct {
+    UserID      uuid.UUID `json:"user_id"`
+    Month       time.Time `json:"month"`
+    Requests    int64     `json:"requests"`
+    Predictions int64     `json:"predictions"`
+    // ScheduledPredictions are those generated for prediction
+    // subscriptions, billed apart from Predictions
+    ScheduledPredictions int64 `json:"scheduled_predictions"`
+    // Estimated is set when any hour of the month is
+    Estimated bool `json:"estimated"`
+}
+
+// UsageLedger moves the hourly counters of a UsageMeter into the immutable
+// usage_records table and reports from it. Redis stays the source usage is
+// enforced from; usage_records is what it is billed from.
+type UsageLedger struct {
+    db     *sql.DB
+    client *redis.Client
+    health *cache.RedisHealth
+    now    func() time.Time
+}
+
+func NewUsageLedger(db *sql.DB, client *redis.Client) *UsageLedger {
+    return &UsageLedger{db: db, client: client, now: time.Now}
+}
+
+// WithHealth postpones aggregation while health reports Redis down
+func (l *UsageLedger) WithHealth(health *cache.RedisHealth) *UsageLedger {
+    l.health = health
+    return l
+}
+
+// Aggregate records every finished hour not yet recorded, oldest first. It
+// is meant to run as an hourly job; an hour missed while Redis was down is
+// picked up on a later run as long as its counters haven't expired.
+func (l *UsageLedger) Aggregate(ctx context.Context) error {
+    if l.client == nil || !l.health.Available() {
+        return cache.ErrRedisUnavailable
+    }
+
+    last := l.now().UTC().Add(-aggregationDelay).Truncate(time.Hour).Add(-time.Hour)
+    oldest := l.now().UTC().Add(-counterTTL).Truncate(time.Hour).Add(time.Hour)
+
+    var latest sql.NullTime
+    if err := l.db.QueryRowContext(ctx,
+        "SELECT MAX(hour) FROM usage_aggregations",
+    ).Scan(&latest); err != nil {
+        return fmt.Errorf("failed to read last aggregated hour: %w", err)
+    }
+    if latest.Valid && !latest.Time.Before(oldest) {
+        oldest = latest.Time.UTC().Add(time.Hour)
+    }
+
+    for hour := oldest; !hour.After(last); hour = hour.Add(time.Hour) {
+        if err := l.aggregateHour(ctx, hour); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// aggregateHour writes the counters of hour to usage_records. Claiming the
+// hour in usage_aggregations within the same transaction keeps two
+// instances from recording it twice.
+func (l *UsageLedger) aggregateHour(ctx context.Context, hour time.Time) error {
+    records, err := l.readCounters(ctx, hour)
+    if err != nil {
+        l.health.Observe(err)
+        return err
+    }
+
+    tx, err := l.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to start usage aggregation: %w", err)
+    }
+    defer tx.Rollback()
+
+    var estimated bool
+    if err := tx.QueryRowContext(ctx,
+        "SELECT EXISTS (SELECT 1 FROM usage_outages WHERE hour = $1)", hour,
+    ).Scan(&estimated); err != nil {
+        return fmt.Errorf("failed to check usage outages: %w", err)
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO usage_aggregations (hour, estimated, aggregated_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (hour) DO NOTHING
+    `, hour, estimated, l.now())
+    if err != nil {
+        return fmt.Errorf("failed to claim usage hour: %w", err)
+    }
+    if claimed, err := result.RowsAffected(); err != nil {
+        return fmt.Errorf("failed to claim usage hour: %w", err)
+    } else if claimed == 0 {
+        return nil
+    }
+
+    for _, record := range records {
+        if _, err := tx.ExecContext(ctx, `
+            INSERT INTO usage_records (user_id, metric, hour, count, estimated)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (user_id, metric, hour) DO NOTHING
+        `, record.UserID, record.Metric, hour, record.Count, estimated); err != nil {
+            return fmt.Errorf("failed to record usage: %w", err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit usage aggregation: %w", err)
+    }
+    return nil
+}
+
+// readCounters returns every counter of hour in Redis
+func (l *UsageLedger) readCounters(ctx context.Context, hour time.Time) ([]UsageRecord, error) {
+    prefix := fmt.Sprintf("%s%d:", usageKeyPrefix, hour.Unix())
+
+    var records []UsageRecord
+    iter := l.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+    for iter.Next(ctx) {
+        key := iter.Val()
+        parts := strings.SplitN(strings.TrimPrefix(key, prefix), ":", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        userID, err := uuid.Parse(parts[1])
+        if err != nil {
+            continue
+        }
+
+        count, err := l.client.Get(ctx, key).Int64()
+        if err == redis.Nil {
+            continue
+        }
+        if err != nil {
+            return nil, fmt.Errorf("failed to read usage counter %s: %w", key, err)
+        }
+        records = append(records, UsageRecord{UserID: userID, Metric: parts[0], Hour: hour, Count: count})
+    }
+    if err := iter.Err(); err != nil {
+        return nil, fmt.Errorf("failed to scan usage counters: %w", err)
+    }
+    return records, nil
+}
+
+// History returns the hourly usage from from up to to, of userID or, when
+// userID is uuid.Nil, of every user
+func (l *UsageLedger) History(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]UsageRecord, error) {
+    query := `
+        SELECT user_id, metric, hour, count, estimated
+        FROM usage_records
+        WHERE hour >= $1 AND hour < $2
+    `
+    args := []interface{}{from, to}
+    if userID != uuid.Nil {
+        query += " AND user_id = $3"
+        args = append(args, userID)
+    }
+    query += " ORDER BY hour, user_id, metric"
+
+    rows, err := l.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query usage history: %w", err)
+    }
+    defer rows.Close()
+
+    records := []UsageRecord{}
+    for rows.Next() {
+        var record UsageRecord
+        if err := rows.Scan(&record.UserID, &record.Metric, &record.Hour, &record.Count, &record.Estimated); err != nil {
+            return nil, fmt.Errorf("failed to scan usage record: %w", err)
+        }
+        records = append(records, record)
+    }
+    return records, rows.Err()
+}
+
+// MonthlyRollups returns each user's usage over the calendar month holding
+// month
+func (l *UsageLedger) MonthlyRollups(ctx context.Context, month time.Time) ([]MonthlyUsage, error) {
+    start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+    end := start.AddDate(0, 1, 0)
+
+    rows, err := l.db.QueryContext(ctx, `
+        SELECT user_id,
+               COALESCE(SUM(count) FILTER (WHERE metric = $3), 0) as requests,
+               COALESCE(SUM(count) FILTER (WHERE metric = $4), 0) as predictions,
+               COALESCE(SUM(count) FILTER (WHERE metric = $5), 0) as scheduled_predictions,
+               BOOL_OR(estimated) as estimated
+        FROM usage_records
+        WHERE hour >= $1 AND hour < $2
+        GROUP BY user_id
+        ORDER BY user_id
+    `, start, end, MetricRequests, MetricPredictions, MetricScheduledPredictions)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query monthly usage: %w", err)
+    }
+    defer rows.Close()
+
+    rollups := []MonthlyUsage{}
+    for rows.Next() {
+        usage := MonthlyUsage{Month: start}
+        if err := rows.Scan(&usage.UserID, &usage.Requests, &usage.Predictions, &usage.ScheduledPredictions, &usage.Estimated); err != nil {
+            return nil, fmt.Errorf("failed to scan monthly usage: %w", err)
+        }
+        rollups = append(rollups, usage)
+    }
+    return rollups, rows.Err()
+}
+
+// WriteBillingCSV writes the monthly rollups of month to w, a row per user
+func (l *UsageLedger) WriteBillingCSV(ctx context.Context, w io.Writer, month time.Time) error {
+    rollups, err := l.MonthlyRollups(ctx, month)
+    if err != nil {
+        return err
+    }
+
+    out := csv.NewWriter(w)
+    if err := out.Write([]string{"user_id", "month", "requests", "predictions", "scheduled_predictions", "estimated"}); err != nil {
+        return fmt.Errorf("failed to write billing export: %w", err)
+    }
+    for _, usage := range rollups {
+        if err := out.Write([]string{
+            usage.UserID.String(),
+            usage.Month.Format("2006-01"),
+            strconv.FormatInt(usage.Requests, 10),
+            strconv.FormatInt(usage.Predictions, 10),
+            strconv.FormatInt(usage.ScheduledPredictions, 10),
+            strconv.FormatBool(usage.Estimated),
+        }); err != nil {
+            return fmt.Errorf("failed to write billing export: %w", err)
+        }
+    }
+    out.Flush()
+    if err := out.Error(); err != nil {
+        return fmt.Errorf("failed to write billing export: %w", err)
+    }
+    return nil
+}
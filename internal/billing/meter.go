@@ -0,0 +1,139 @@
+package billing
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/google/uuid"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Metrics usage is counted in
+const (
+    MetricRequests    = "requests"
+    MetricPredictions = "predictions"
+    // MetricScheduledPredictions counts predictions generated for
+    // prediction subscriptions rather than on request
+    MetricScheduledPredictions = "scheduled"
+)
+
+const (
+    // counterTTL is how long an hour's counters stay in Redis, and so how
+    // far aggregation can fall behind, as through a Redis outage, before
+    // the hour's usage is lost
+    counterTTL = 48 * time.Hour
+
+    usageKeyPrefix = "usage:"
+)
+
+// usageKey is the counter of userID's metric in the hour starting at hour.
+// Keys lead with the hour so aggregation can scan one hour's counters.
+func usageKey(hour time.Time, metric string, userID uuid.UUID) string {
+    return fmt.Sprintf("%s%d:%s:%s", usageKeyPrefix, hour.Unix(), metric, userID)
+}
+
+// UsageMeter counts each user's requests and predictions by hour in Redis,
+// where a UsageLedger picks them up once the hour is over. Counting never
+// fails the request it is for: an increment Redis can't take is dropped and
+// its hour recorded in usage_outages, so the hour's usage is reported as
+// estimated.
+type UsageMeter struct {
+    client *redis.Client
+    health *cache.RedisHealth
+    db     *sql.DB
+    now    func() time.Time
+
+    mu sync.Mutex
+    // outageHour is the last hour this instance recorded an outage in, so
+    // an outage is written once per hour rather than once per request
+    outageHour time.Time
+}
+
+// NewUsageMeter counts in client, recording outages in db
+func NewUsageMeter(client *redis.Client, db *sql.DB) *UsageMeter {
+    return &UsageMeter{client: client, db: db, now: time.Now}
+}
+
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (m *UsageMeter) WithHealth(health *cache.RedisHealth) *UsageMeter {
+    m.health = health
+    return m
+}
+
+// Count adds n to userID's metric for the current hour. It does nothing on
+// a nil UsageMeter or one without Redis.
+func (m *UsageMeter) Count(ctx context.Context, userID uuid.UUID, metric string, n int64) {
+    if m == nil || m.client == nil || n <= 0 {
+        return
+    }
+    hour := m.now().UTC().Truncate(time.Hour)
+
+    if !m.health.Available() {
+        m.health.Fallback("usage_meter", nil)
+        m.recordOutage(ctx, hour)
+        return
+    }
+
+    key := usageKey(hour, metric, userID)
+    pipe := m.client.TxPipeline()
+    pipe.IncrBy(ctx, key, n)
+    pipe.Expire(ctx, key, counterTTL)
+    if _, err := pipe.Exec(ctx); err != nil {
+        m.health.Fallback("usage_meter", err)
+        m.recordOutage(ctx, hour)
+        return
+    }
+    m.health.Observe(nil)
+}
+
+// CountPredictions adds n predictions to userID's usage
+func (m *UsageMeter) CountPredictions(ctx context.Context, userID uuid.UUID, n int64) {
+    m.Count(ctx, userID, MetricPredictions, n)
+}
+
+// CountScheduledPredictions adds n scheduled predictions to userID's usage
+func (m *UsageMeter) CountScheduledPredictions(ctx context.Context, userID uuid.UUID, n int64) {
+    m.Count(ctx, userID, MetricScheduledPredictions, n)
+}
+
+// CountRequests is middleware counting each request of the authenticated
+// user. The request is counted before it is served, while its context is
+// still live.
+func (m *UsageMeter) CountRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if user, ok := r.Context().Value("user").(*models.User); ok && user != nil {
+            m.Count(r.Context(), user.ID, MetricRequests, 1)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// recordOutage marks hour as one whose counts are incomplete. A failed
+// write is retried on the next dropped increment.
+func (m *UsageMeter) recordOutage(ctx context.Context, hour time.Time) {
+    m.mu.Lock()
+    if m.outageHour.Equal(hour) {
+        m.mu.Unlock()
+        return
+    }
+    m.outageHour = hour
+    m.mu.Unlock()
+
+    _, err := m.db.ExecContext(ctx,
+        "INSERT INTO usage_outages (hour) VALUES ($1) ON CONFLICT (hour) DO NOTHING", hour)
+    if err != nil {
+        m.mu.Lock()
+        if m.outageHour.Equal(hour) {
+            m.outageHour = time.Time{}
+        }
+        m.mu.Unlock()
+    }
+}
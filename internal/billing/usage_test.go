@@ -0,0 +1,160 @@
+package billing
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+)
+
+// Increments Redis drops while it is down make the hour estimated, but
+// those it took are still recorded
+func TestUsageLedger_FlagsOutageHoursEstimated(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    health := cache.NewRedisHealth(client, time.Minute, nil)
+    ctx := context.Background()
+
+    hour := time.Date(2024, time.March, 12, 14, 0, 0, 0, time.UTC)
+    userID := uuid.New()
+
+    meter := NewUsageMeter(client, db).WithHealth(health)
+    meter.now = func() time.Time { return hour.Add(10 * time.Minute) }
+
+    meter.Count(ctx, userID, MetricRequests, 1)
+    meter.CountPredictions(ctx, userID, 3)
+
+    // The outage is recorded once for the hour, however many increments
+    // are dropped
+    mr.Close()
+    mock.ExpectExec("INSERT INTO usage_outages").
+        WithArgs(hour).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    meter.Count(ctx, userID, MetricRequests, 1)
+    meter.Count(ctx, userID, MetricRequests, 1)
+    require.NoError(t, mr.Restart())
+    meter.Count(ctx, userID, MetricRequests, 1)
+
+    ledger := NewUsageLedger(db, client).WithHealth(health)
+    ledger.now = func() time.Time { return hour.Add(70 * time.Minute) }
+
+    mock.ExpectQuery("SELECT MAX\\(hour\\) FROM usage_aggregations").
+        WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(hour.Add(-time.Hour)))
+    mock.ExpectBegin()
+    mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM usage_outages").
+        WithArgs(hour).
+        WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+    mock.ExpectExec("INSERT INTO usage_aggregations").
+        WithArgs(hour, true, sqlmock.AnyArg()).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("INSERT INTO usage_records").
+        WithArgs(userID, MetricPredictions, hour, int64(3), true).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("INSERT INTO usage_records").
+        WithArgs(userID, MetricRequests, hour, int64(2), true).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectCommit()
+
+    require.NoError(t, ledger.Aggregate(ctx))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUsageLedger_Aggregate(t *testing.T) {
+    hour := time.Date(2024, time.March, 12, 14, 0, 0, 0, time.UTC)
+    userID := uuid.New()
+
+    setup := func(t *testing.T) (*UsageLedger, sqlmock.Sqlmock) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        t.Cleanup(func() { db.Close() })
+
+        client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+        meter := NewUsageMeter(client, db)
+        meter.now = func() time.Time { return hour }
+        meter.Count(context.Background(), userID, MetricRequests, 5)
+
+        ledger := NewUsageLedger(db, client)
+        ledger.now = func() time.Time { return hour.Add(70 * time.Minute) }
+        mock.ExpectQuery("SELECT MAX\\(hour\\) FROM usage_aggregations").
+            WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(hour.Add(-time.Hour)))
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM usage_outages").
+            WithArgs(hour).
+            WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+        return ledger, mock
+    }
+
+    t.Run("Exact hour", func(t *testing.T) {
+        ledger, mock := setup(t)
+        mock.ExpectExec("INSERT INTO usage_aggregations").
+            WithArgs(hour, false, sqlmock.AnyArg()).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO usage_records").
+            WithArgs(userID, MetricRequests, hour, int64(5), false).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectCommit()
+
+        require.NoError(t, ledger.Aggregate(context.Background()))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Hour claimed by another instance", func(t *testing.T) {
+        ledger, mock := setup(t)
+        mock.ExpectExec("INSERT INTO usage_aggregations").
+            WithArgs(hour, false, sqlmock.AnyArg()).
+            WillReturnResult(sqlmock.NewResult(0, 0))
+        mock.ExpectRollback()
+
+        require.NoError(t, ledger.Aggregate(context.Background()))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Redis disabled", func(t *testing.T) {
+        err := NewUsageLedger(nil, nil).Aggregate(context.Background())
+        assert.True(t, errors.Is(err, cache.ErrRedisUnavailable))
+    })
+}
+
+func TestUsageLedger_WriteBillingCSV(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    first := uuid.MustParse("0b6f2c3e-7d1a-4f5e-9c2b-8a4d6e1f3a01")
+    second := uuid.MustParse("5d2e8f1a-3c4b-4a6d-8e9f-1b2c3d4e5f02")
+    start := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+    mock.ExpectQuery("FROM usage_records").
+        WithArgs(start, start.AddDate(0, 1, 0), MetricRequests, MetricPredictions, MetricScheduledPredictions).
+        WillReturnRows(sqlmock.NewRows([]string{"user_id", "requests", "predictions", "scheduled_predictions", "estimated"}).
+            AddRow(first.String(), 1200, 40, 60, false).
+            AddRow(second.String(), 35, 0, 0, true))
+
+    var buf bytes.Buffer
+    require.NoError(t, NewUsageLedger(db, nil).WriteBillingCSV(context.Background(), &buf, start.AddDate(0, 0, 17)))
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    assert.Equal(t, "user_id,month,requests,predictions,scheduled_predictions,estimated\n"+
+        first.String()+",2024-02,1200,40,60,false\n"+
+        second.String()+",2024-02,35,0,0,true\n", buf.String())
+}
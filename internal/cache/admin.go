@@ -0,0 +1,378 @@
+package cache
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    // DefaultKeysPageSize is the keys a page of Keys holds when no limit
+    // is given, and MaxKeysPageSize the most it may be asked for
+    DefaultKeysPageSize = 100
+    MaxKeysPageSize     = 1000
+    // DefaultFlushConfirmAbove is the most keys a prefix flush deletes
+    // without a confirmation token
+    DefaultFlushConfirmAbove = 1000
+    // flushConfirmationTTL is how long a flush confirmation token is valid
+    flushConfirmationTTL = 5 * time.Minute
+    // flushConfirmationKeyPrefix is followed by the token in the keys that
+    // hold the prefix a confirmation token was issued for
+    flushConfirmationKeyPrefix = "cache:flush:confirm:"
+)
+
+var (
+    // ErrNotCacheKey is returned for keys outside CacheNamespaces, which
+    // belong to sessions, rate limits and the like rather than the cache
+    ErrNotCacheKey = errors.New("not a cache key")
+    // ErrKeyNotFound is returned for a key that doesn't exist
+    ErrKeyNotFound = errors.New("cache key not found")
+)
+
+// ConfirmationRequiredError is returned by Flush for a prefix with more
+// keys than it deletes unconfirmed. Repeating the flush with Token, within
+// ExpiresIn, deletes them.
+type ConfirmationRequiredError struct {
+    Prefix    string        `json:"prefix"`
+    Keys      int64         `json:"keys"`
+    Token     string        `json:"confirmation_token"`
+    ExpiresIn time.Duration `json:"-"`
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+    return fmt.Sprintf("flushing %d keys under %s needs confirmation", e.Keys, e.Prefix)
+}
+
+// KeyInfo describes one key. TTLSeconds is -1 for a key without expiry.
+type KeyInfo struct {
+    Key        string `json:"key"`
+    TTLSeconds int64  `json:"ttl_seconds"`
+    SizeBytes  int64  `json:"size_bytes"`
+}
+
+// KeyPage is a page of keys. NextCursor continues the scan, and is 0 once
+// it is complete.
+type KeyPage struct {
+    Keys       []KeyInfo `json:"keys"`
+    NextCursor uint64    `json:"next_cursor"`
+}
+
+// CacheEntry is a key's value, decoded by the schema of its namespace.
+// Schema is "raw" for keys of other namespaces, whose JSON is returned as
+// it is stored, or as a string when it isn't JSON.
+type CacheEntry struct {
+    Key        string      `json:"key"`
+    Schema     string      `json:"schema"`
+    TTLSeconds int64       `json:"ttl_seconds"`
+    Value      interface{} `json:"value"`
+}
+
+// FlushResult is the keys a Flush deleted
+type FlushResult struct {
+    Prefix  string `json:"prefix"`
+    Deleted int64  `json:"deleted"`
+}
+
+// keySchema decodes the values of the keys under prefix
+type keySchema struct {
+    prefix string
+    name   string
+    value  func() interface{}
+}
+
+var keySchemas = []keySchema{
+    {prefix: "market:data:", name: "market_data", value: func() interface{} { return &models.MarketData{} }},
+    {prefix: "market:realtime:", name: "market_data", value: func() interface{} { return &models.MarketData{} }},
+    {prefix: "market:historical:", name: "historical", value: func() interface{} { return &[]models.MarketData{} }},
+}
+
+// CacheAdmin inspects and removes individual cache entries, for repairing
+// stale or corrupt entries without waiting out their TTL or flushing all
+// of Redis. Scans are batched with COUNT, so none blocks Redis for long.
+type CacheAdmin struct {
+    client       *redis.Client
+    health       *RedisHealth
+    confirmAbove int64
+}
+
+// NewCacheAdmin administers client, which is nil when Redis is disabled
+func NewCacheAdmin(client *redis.Client) *CacheAdmin {
+    return &CacheAdmin{client: client, confirmAbove: DefaultFlushConfirmAbove}
+}
+
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (a *CacheAdmin) WithHealth(health *RedisHealth) *CacheAdmin {
+    a.health = health
+    return a
+}
+
+// WithFlushConfirmAbove sets the most keys a prefix flush deletes without
+// a confirmation token
+func (a *CacheAdmin) WithFlushConfirmAbove(keys int64) *CacheAdmin {
+    a.confirmAbove = keys
+    return a
+}
+
+func (a *CacheAdmin) available() error {
+    if a.client == nil || !a.health.Available() {
+        return ErrRedisUnavailable
+    }
+    return nil
+}
+
+// Keys returns the keys matching pattern from cursor on, with their TTL
+// and size. SCAN returns whole batches, so a page may hold somewhat more
+// than limit keys.
+func (a *CacheAdmin) Keys(ctx context.Context, pattern string, cursor uint64, limit int) (*KeyPage, error) {
+    if err := a.available(); err != nil {
+        return nil, err
+    }
+    if limit <= 0 {
+        limit = DefaultKeysPageSize
+    }
+    if limit > MaxKeysPageSize {
+        limit = MaxKeysPageSize
+    }
+
+    var keys []string
+    for {
+        batch, next, err := a.client.Scan(ctx, cursor, pattern, int64(limit)).Result()
+        a.health.Observe(err)
+        if err != nil {
+            return nil, fmt.Errorf("failed to scan %s: %w", pattern, err)
+        }
+        keys = append(keys, batch...)
+        cursor = next
+        if cursor == 0 || len(keys) >= limit {
+            break
+        }
+    }
+
+    page := &KeyPage{Keys: make([]KeyInfo, 0, len(keys)), NextCursor: cursor}
+    if len(keys) == 0 {
+        return page, nil
+    }
+    pipe := a.client.Pipeline()
+    ttls := make([]*redis.DurationCmd, len(keys))
+    sizes := make([]*redis.IntCmd, len(keys))
+    for i, key := range keys {
+        ttls[i] = pipe.TTL(ctx, key)
+        // Spelled out, as not every Redis-compatible server accepts the
+        // lower-case subcommand MemoryUsage sends
+        sizes[i] = redis.NewIntCmd(ctx, "MEMORY", "USAGE", key)
+        pipe.Process(ctx, sizes[i])
+    }
+    _, err := pipe.Exec(ctx)
+    if err != nil && err != redis.Nil {
+        a.health.Observe(err)
+        return nil, fmt.Errorf("failed to describe keys: %w", err)
+    }
+    a.health.Observe(nil)
+
+    for i, key := range keys {
+        ttl, err := ttls[i].Result()
+        // Expired since the scan found it
+        if err != nil || ttl == -2 {
+            continue
+        }
+        info := KeyInfo{Key: key, TTLSeconds: int64(ttl / time.Second), SizeBytes: sizes[i].Val()}
+        if ttl < 0 {
+            info.TTLSeconds = -1
+        }
+        page.Keys = append(page.Keys, info)
+    }
+    return page, nil
+}
+
+// Entry returns key's value, decoded by the schema of its namespace
+func (a *CacheAdmin) Entry(ctx context.Context, key string) (*CacheEntry, error) {
+    if err := a.available(); err != nil {
+        return nil, err
+    }
+    pipe := a.client.Pipeline()
+    get := pipe.Get(ctx, key)
+    ttl := pipe.TTL(ctx, key)
+    _, err := pipe.Exec(ctx)
+    if err == redis.Nil {
+        return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+    }
+    if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+        return nil, fmt.Errorf("%w: %s doesn't hold a string", ErrNotCacheKey, key)
+    }
+    a.health.Observe(err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %w", key, err)
+    }
+
+    entry := &CacheEntry{Key: key, Schema: "raw", TTLSeconds: -1}
+    if d := ttl.Val(); d >= 0 {
+        entry.TTLSeconds = int64(d / time.Second)
+    }
+    data := []byte(get.Val())
+    for _, schema := range keySchemas {
+        if !strings.HasPrefix(key, schema.prefix) {
+            continue
+        }
+        value := schema.value()
+        // A value that doesn't match its schema is shown raw, as that is
+        // most likely what is being investigated
+        if json.Unmarshal(data, value) == nil {
+            entry.Schema = schema.name
+            entry.Value = value
+            return entry, nil
+        }
+        break
+    }
+    if json.Valid(data) {
+        entry.Value = json.RawMessage(data)
+    } else {
+        entry.Value = string(data)
+    }
+    return entry, nil
+}
+
+// Delete removes key, which must be in one of the CacheNamespaces
+func (a *CacheAdmin) Delete(ctx context.Context, key string) error {
+    if !isCacheKey(key) {
+        return fmt.Errorf("%w: %s", ErrNotCacheKey, key)
+    }
+    if err := a.available(); err != nil {
+        return err
+    }
+    deleted, err := a.client.Del(ctx, key).Result()
+    a.health.Observe(err)
+    if err != nil {
+        return fmt.Errorf("failed to delete %s: %w", key, err)
+    }
+    if deleted == 0 {
+        return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+    }
+    return nil
+}
+
+// Flush deletes every key under prefix, which must be in one of the
+// CacheNamespaces. More keys than the confirmation threshold are only
+// deleted given the token of the *ConfirmationRequiredError an unconfirmed
+// flush of the same prefix returned.
+func (a *CacheAdmin) Flush(ctx context.Context, prefix, token string) (*FlushResult, error) {
+    if !isCacheKey(prefix) {
+        return nil, fmt.Errorf("%w: %s", ErrNotCacheKey, prefix)
+    }
+    if err := a.available(); err != nil {
+        return nil, err
+    }
+    pattern := escapePattern(prefix) + "*"
+
+    confirmed := false
+    if token != "" {
+        issued, err := a.client.GetDel(ctx, flushConfirmationKeyPrefix+token).Result()
+        if err != nil && err != redis.Nil {
+            a.health.Observe(err)
+            return nil, fmt.Errorf("failed to check confirmation token: %w", err)
+        }
+        confirmed = err == nil && issued == prefix
+    }
+    if !confirmed {
+        count, err := a.count(ctx, pattern)
+        if err != nil {
+            return nil, err
+        }
+        if count > a.confirmAbove {
+            return nil, a.requireConfirmation(ctx, prefix, count)
+        }
+    }
+
+    result := &FlushResult{Prefix: prefix}
+    iter := a.client.Scan(ctx, 0, pattern, statsScanCount).Iterator()
+    batch := make([]string, 0, statsScanCount)
+    remove := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        deleted, err := a.client.Unlink(ctx, batch...).Result()
+        result.Deleted += deleted
+        batch = batch[:0]
+        return err
+    }
+    for iter.Next(ctx) {
+        batch = append(batch, iter.Val())
+        if len(batch) == cap(batch) {
+            if err := remove(); err != nil {
+                a.health.Observe(err)
+                return result, fmt.Errorf("failed to flush %s: %w", prefix, err)
+            }
+        }
+    }
+    err := iter.Err()
+    if err == nil {
+        err = remove()
+    }
+    a.health.Observe(err)
+    if err != nil {
+        return result, fmt.Errorf("failed to flush %s: %w", prefix, err)
+    }
+    return result, nil
+}
+
+// count scans the keys matching pattern
+func (a *CacheAdmin) count(ctx context.Context, pattern string) (int64, error) {
+    var count int64
+    iter := a.client.Scan(ctx, 0, pattern, statsScanCount).Iterator()
+    for iter.Next(ctx) {
+        count++
+    }
+    a.health.Observe(iter.Err())
+    if err := iter.Err(); err != nil {
+        return 0, fmt.Errorf("failed to count %s: %w", pattern, err)
+    }
+    return count, nil
+}
+
+// requireConfirmation issues a token confirming a flush of prefix
+func (a *CacheAdmin) requireConfirmation(ctx context.Context, prefix string, count int64) error {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return fmt.Errorf("failed to generate confirmation token: %w", err)
+    }
+    token := hex.EncodeToString(b)
+    err := a.client.Set(ctx, flushConfirmationKeyPrefix+token, prefix, flushConfirmationTTL).Err()
+    a.health.Observe(err)
+    if err != nil {
+        return fmt.Errorf("failed to store confirmation token: %w", err)
+    }
+    return &ConfirmationRequiredError{Prefix: prefix, Keys: count, Token: token, ExpiresIn: flushConfirmationTTL}
+}
+
+// isCacheKey reports whether key, or a prefix, is in one of the
+// CacheNamespaces
+func isCacheKey(key string) bool {
+    for _, namespace := range CacheNamespaces {
+        if strings.HasPrefix(key, namespace+":") {
+            return true
+        }
+    }
+    return false
+}
+
+// escapePattern escapes the glob characters of s, so a prefix matches
+// only itself
+func escapePattern(s string) string {
+    var b strings.Builder
+    for _, r := range s {
+        switch r {
+        case '*', '?', '[', ']', '\\':
+            b.WriteByte('\\')
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
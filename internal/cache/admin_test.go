@@ -0,0 +1,178 @@
+package cache
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestCacheAdmin_Keys(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    admin := NewCacheAdmin(client)
+    ctx := context.Background()
+
+    for i := 0; i < 25; i++ {
+        client.Set(ctx, fmt.Sprintf("market:data:BTC%d", i), `{"symbol":"BTC"}`, time.Minute)
+    }
+    client.Set(ctx, "market:data:ETH", `{"symbol":"ETH"}`, 0)
+
+    seen := map[string]KeyInfo{}
+    var cursor uint64
+    for pages := 0; ; pages++ {
+        if !assert.Less(t, pages, 25, "scan doesn't finish") {
+            return
+        }
+        page, err := admin.Keys(ctx, "market:data:BTC*", cursor, 10)
+        if !assert.NoError(t, err) {
+            return
+        }
+        for _, key := range page.Keys {
+            seen[key.Key] = key
+        }
+        if cursor = page.NextCursor; cursor == 0 {
+            break
+        }
+    }
+    assert.Len(t, seen, 25)
+    btc := seen["market:data:BTC0"]
+    assert.Equal(t, int64(60), btc.TTLSeconds)
+    assert.Greater(t, btc.SizeBytes, int64(0))
+
+    page, err := admin.Keys(ctx, "market:data:ETH", 0, 0)
+    if assert.NoError(t, err) && assert.Len(t, page.Keys, 1) {
+        assert.Equal(t, int64(-1), page.Keys[0].TTLSeconds)
+    }
+
+    _, err = NewCacheAdmin(nil).Keys(ctx, "*", 0, 10)
+    assert.ErrorIs(t, err, ErrRedisUnavailable)
+}
+
+func TestCacheAdmin_Entry(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    admin := NewCacheAdmin(client)
+    ctx := context.Background()
+
+    data, _ := json.Marshal(&models.MarketData{Symbol: "BTC", Close: 64000})
+    client.Set(ctx, "market:data:BTC", data, time.Minute)
+    client.Set(ctx, "market:historical:BTC:2024-03-01:2024-03-12", `[{"symbol":"BTC","close":61000}]`, 0)
+    client.Set(ctx, "prediction:lstm:BTC", `{"confidence":0.8}`, 0)
+    client.Set(ctx, "market:data:ETH", "not json", 0)
+
+    entry, err := admin.Entry(ctx, "market:data:BTC")
+    if assert.NoError(t, err) {
+        assert.Equal(t, "market_data", entry.Schema)
+        assert.Equal(t, int64(60), entry.TTLSeconds)
+        if assert.IsType(t, &models.MarketData{}, entry.Value) {
+            assert.Equal(t, 64000.0, entry.Value.(*models.MarketData).Close)
+        }
+    }
+
+    entry, err = admin.Entry(ctx, "market:historical:BTC:2024-03-01:2024-03-12")
+    if assert.NoError(t, err) {
+        assert.Equal(t, "historical", entry.Schema)
+        assert.Equal(t, &[]models.MarketData{{Symbol: "BTC", Close: 61000}}, entry.Value)
+    }
+
+    entry, err = admin.Entry(ctx, "prediction:lstm:BTC")
+    if assert.NoError(t, err) {
+        assert.Equal(t, "raw", entry.Schema)
+        assert.Equal(t, int64(-1), entry.TTLSeconds)
+        assert.Equal(t, json.RawMessage(`{"confidence":0.8}`), entry.Value)
+    }
+
+    // A corrupt entry is shown as stored
+    entry, err = admin.Entry(ctx, "market:data:ETH")
+    if assert.NoError(t, err) {
+        assert.Equal(t, "raw", entry.Schema)
+        assert.Equal(t, "not json", entry.Value)
+    }
+
+    _, err = admin.Entry(ctx, "market:data:SOL")
+    assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestCacheAdmin_Delete(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    admin := NewCacheAdmin(client)
+    ctx := context.Background()
+
+    mr.Set("market:data:BTC", `{"symbol":"BTC"}`)
+    mr.Set("session:abc", "not a cache key")
+
+    assert.NoError(t, admin.Delete(ctx, "market:data:BTC"))
+    assert.False(t, mr.Exists("market:data:BTC"))
+    assert.ErrorIs(t, admin.Delete(ctx, "market:data:BTC"), ErrKeyNotFound)
+
+    assert.ErrorIs(t, admin.Delete(ctx, "session:abc"), ErrNotCacheKey)
+    assert.True(t, mr.Exists("session:abc"))
+}
+
+func TestCacheAdmin_Flush(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    admin := NewCacheAdmin(client).WithFlushConfirmAbove(10)
+    ctx := context.Background()
+
+    for i := 0; i < 5; i++ {
+        mr.Set(fmt.Sprintf("prediction:lstm:SYM%d", i), "{}")
+    }
+    for i := 0; i < 20; i++ {
+        mr.Set(fmt.Sprintf("market:historical:SYM%d", i), "[]")
+    }
+    mr.Set("market:data:BTC", "{}")
+
+    // Few enough keys go at once
+    result, err := admin.Flush(ctx, "prediction:lstm:", "")
+    if assert.NoError(t, err) {
+        assert.Equal(t, int64(5), result.Deleted)
+    }
+
+    // More need the token an unconfirmed flush returns
+    _, err = admin.Flush(ctx, "market:historical:", "")
+    var confirm *ConfirmationRequiredError
+    if !assert.ErrorAs(t, err, &confirm) {
+        return
+    }
+    assert.Equal(t, int64(20), confirm.Keys)
+    assert.NotEmpty(t, confirm.Token)
+    assert.True(t, mr.Exists("market:historical:SYM0"))
+
+    // The token only confirms the prefix it was issued for
+    _, err = admin.Flush(ctx, "market:historical:SYM2", confirm.Token)
+    assert.NoError(t, err)
+    _, err = admin.Flush(ctx, "market:historical:", confirm.Token)
+    assert.ErrorAs(t, err, &confirm)
+
+    result, err = admin.Flush(ctx, "market:historical:", confirm.Token)
+    if assert.NoError(t, err) {
+        assert.Equal(t, int64(19), result.Deleted)
+    }
+    assert.True(t, mr.Exists("market:data:BTC"))
+
+    // The prefix matches literally
+    result, err = admin.Flush(ctx, "market:data:*", "")
+    if assert.NoError(t, err) {
+        assert.Equal(t, int64(0), result.Deleted)
+    }
+    assert.True(t, mr.Exists("market:data:BTC"))
+
+    // Tokens are single use
+    for i := 0; i < 20; i++ {
+        mr.Set(fmt.Sprintf("market:historical:SYM%d", i), "[]")
+    }
+    _, err = admin.Flush(ctx, "market:historical:", confirm.Token)
+    assert.ErrorAs(t, err, &confirm)
+
+    _, err = admin.Flush(ctx, "session:", "")
+    assert.ErrorIs(t, err, ErrNotCacheKey)
+}
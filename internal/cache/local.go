@@ -0,0 +1,221 @@
+package cache
+
+import (
+    "container/list"
+    "context"
+    "hash/fnv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    // DefaultLocalTTL is how long LocalMarketData serves an entry read from
+    // Redis
+    DefaultLocalTTL = 2 * time.Second
+    // MaxLocalStaleness caps the TTL of LocalMarketData, so an invalidation
+    // that never arrives can't serve prices older than this
+    MaxLocalStaleness = 10 * time.Second
+    // localShards is the number of independently locked LRUs the entries
+    // are spread over
+    localShards = 16
+    // updateChannelPrefix is followed by the symbol in the channels the
+    // pipeline publishes a symbol's changed market data to
+    updateChannelPrefix = "market:updates:"
+)
+
+// Local cache lookup results, the result label of its lookups counter
+const (
+    localHit      = "hit"
+    localMiss     = "miss"
+    localExpired  = "expired"
+    localDisabled = "disabled"
+)
+
+// LocalMarketData is a small in-process LRU of the latest market data of
+// the hottest symbols, in front of MarketDataCache's Redis reads. Entries
+// live for a few seconds and are dropped as soon as the pipeline announces
+// a symbol's update, so it only serves while Subscribe is receiving those
+// announcements: until the subscription is confirmed, and whenever it is
+// lost, every lookup misses. A process that never subscribes, such as a
+// one-off CLI tool, reads Redis every time. A nil LocalMarketData is always
+// disabled.
+type LocalMarketData struct {
+    shards     [localShards]*localShard
+    ttl        time.Duration
+    subscribed int32
+    lookups    *prometheus.CounterVec
+    now        func() time.Time
+}
+
+type localEntry struct {
+    symbol   string
+    data     models.MarketData
+    storedAt time.Time
+}
+
+// localShard is one LRU. gen counts its invalidations, so a Redis read
+// that raced one isn't stored over it.
+type localShard struct {
+    mu       sync.Mutex
+    capacity int
+    entries  map[string]*list.Element
+    order    *list.List
+    gen      uint64
+}
+
+// NewLocalMarketData holds the data of up to size symbols, each for ttl.
+// A ttl of zero is DefaultLocalTTL and one above MaxLocalStaleness is
+// capped to it.
+func NewLocalMarketData(size int, ttl time.Duration) *LocalMarketData {
+    if ttl <= 0 {
+        ttl = DefaultLocalTTL
+    }
+    if ttl > MaxLocalStaleness {
+        ttl = MaxLocalStaleness
+    }
+    capacity := (size + localShards - 1) / localShards
+    if capacity < 1 {
+        capacity = 1
+    }
+    l := &LocalMarketData{
+        ttl: ttl,
+        lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "market_data_local_cache_lookups_total",
+            Help: "Latest market data lookups in the in-process cache, by result",
+        }, []string{"result"}),
+        now: time.Now,
+    }
+    for i := range l.shards {
+        l.shards[i] = &localShard{
+            capacity: capacity,
+            entries:  make(map[string]*list.Element, capacity),
+            order:    list.New(),
+        }
+    }
+    return l
+}
+
+// WithRegisterer registers the lookups counter with reg
+func (l *LocalMarketData) WithRegisterer(reg prometheus.Registerer) *LocalMarketData {
+    reg.MustRegister(l.lookups)
+    return l
+}
+
+// Subscribe listens to the pipeline's update announcements on rdb until
+// ctx is done, dropping each announced symbol. The cache serves while the
+// subscription is up and is emptied whenever it drops, as announcements
+// sent meanwhile are lost.
+func (l *LocalMarketData) Subscribe(ctx context.Context, rdb *redis.Client, log *logger.Logger) {
+    SubscribeWithState(ctx, func(ctx context.Context) *redis.PubSub {
+        return rdb.PSubscribe(ctx, updateChannelPrefix+"*")
+    }, func(msg *redis.Message) {
+        l.Invalidate(strings.TrimPrefix(msg.Channel, updateChannelPrefix))
+    }, l.setSubscribed, log)
+}
+
+// Enabled reports whether the cache is serving, which it does while
+// subscribed to update announcements
+func (l *LocalMarketData) Enabled() bool {
+    return l != nil && atomic.LoadInt32(&l.subscribed) == 1
+}
+
+// setSubscribed turns the cache on or off. Either way it is emptied and
+// every generation moves on, so no read that started before is stored.
+func (l *LocalMarketData) setSubscribed(subscribed bool) {
+    var state int32
+    if subscribed {
+        state = 1
+    }
+    atomic.StoreInt32(&l.subscribed, state)
+    for _, shard := range l.shards {
+        shard.mu.Lock()
+        shard.entries = make(map[string]*list.Element, shard.capacity)
+        shard.order.Init()
+        shard.gen++
+        shard.mu.Unlock()
+    }
+}
+
+// Invalidate drops symbol's data
+func (l *LocalMarketData) Invalidate(symbol string) {
+    if l == nil {
+        return
+    }
+    shard := l.shard(symbol)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    shard.gen++
+    if elem, ok := shard.entries[symbol]; ok {
+        shard.order.Remove(elem)
+        delete(shard.entries, symbol)
+    }
+}
+
+// get returns a copy of symbol's data if it is cached and fresh. On a miss
+// it returns the generation to pass to put with the data read instead.
+func (l *LocalMarketData) get(symbol string) (*models.MarketData, uint64, bool) {
+    if !l.Enabled() {
+        if l != nil {
+            l.lookups.WithLabelValues(localDisabled).Inc()
+        }
+        return nil, 0, false
+    }
+    shard := l.shard(symbol)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    elem, ok := shard.entries[symbol]
+    if !ok {
+        l.lookups.WithLabelValues(localMiss).Inc()
+        return nil, shard.gen, false
+    }
+    entry := elem.Value.(*localEntry)
+    if l.now().Sub(entry.storedAt) >= l.ttl {
+        shard.order.Remove(elem)
+        delete(shard.entries, symbol)
+        l.lookups.WithLabelValues(localExpired).Inc()
+        return nil, shard.gen, false
+    }
+    shard.order.MoveToFront(elem)
+    l.lookups.WithLabelValues(localHit).Inc()
+    data := entry.data
+    return &data, 0, true
+}
+
+// put caches data for symbol, unless the cache is disabled or symbol's
+// shard was invalidated since get returned gen
+func (l *LocalMarketData) put(symbol string, data *models.MarketData, gen uint64) {
+    if !l.Enabled() {
+        return
+    }
+    shard := l.shard(symbol)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    if gen != shard.gen {
+        return
+    }
+    entry := &localEntry{symbol: symbol, data: *data, storedAt: l.now()}
+    if elem, ok := shard.entries[symbol]; ok {
+        elem.Value = entry
+        shard.order.MoveToFront(elem)
+        return
+    }
+    shard.entries[symbol] = shard.order.PushFront(entry)
+    if shard.order.Len() > shard.capacity {
+        oldest := shard.order.Back()
+        shard.order.Remove(oldest)
+        delete(shard.entries, oldest.Value.(*localEntry).symbol)
+    }
+}
+
+func (l *LocalMarketData) shard(symbol string) *localShard {
+    h := fnv.New32a()
+    h.Write([]byte(symbol))
+    return l.shards[h.Sum32()%localShards]
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestLocalMarketData(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    local := NewLocalMarketData(localShards, 2*time.Second)
+    local.now = func() time.Time { return now }
+    c := NewMarketDataCache(client, time.Minute, nil, nil).WithLocal(local)
+    ctx := context.Background()
+
+    assert.NoError(t, c.SetMarketData(ctx, "BTC", &models.MarketData{Symbol: "BTC", Close: 64000}))
+    // setRedis changes the price behind the cache's back, as another
+    // process would
+    setRedis := func(price float64) {
+        mr.Set("market:data:BTC", fmt.Sprintf(`{"symbol":"BTC","close":%v}`, price))
+    }
+    closeOf := func() float64 {
+        data, err := c.GetMarketData(ctx, "BTC")
+        if !assert.NoError(t, err) || !assert.NotNil(t, data) {
+            return 0
+        }
+        return data.Close
+    }
+
+    // Until subscribed, every read goes to Redis
+    assert.Equal(t, 64000.0, closeOf())
+    setRedis(64100)
+    assert.Equal(t, 64100.0, closeOf())
+    assert.Equal(t, 2.0, testutil.ToFloat64(local.lookups.WithLabelValues(localDisabled)))
+
+    local.setSubscribed(true)
+    assert.Equal(t, 64100.0, closeOf())
+    setRedis(64200)
+    assert.Equal(t, 64100.0, closeOf())
+    assert.Equal(t, 1.0, testutil.ToFloat64(local.lookups.WithLabelValues(localMiss)))
+    assert.Equal(t, 1.0, testutil.ToFloat64(local.lookups.WithLabelValues(localHit)))
+
+    // Callers get their own copy
+    data, _ := c.GetMarketData(ctx, "BTC")
+    data.Close = 0
+    assert.Equal(t, 64100.0, closeOf())
+
+    now = now.Add(2 * time.Second)
+    assert.Equal(t, 64200.0, closeOf())
+    assert.Equal(t, 1.0, testutil.ToFloat64(local.lookups.WithLabelValues(localExpired)))
+
+    setRedis(64300)
+    local.Invalidate("BTC")
+    assert.Equal(t, 64300.0, closeOf())
+
+    // Writes through the cache are seen at once
+    assert.NoError(t, c.SetMarketData(ctx, "BTC", &models.MarketData{Symbol: "BTC", Close: 64400}))
+    assert.Equal(t, 64400.0, closeOf())
+
+    // A read that raced an invalidation isn't kept
+    local.Invalidate("BTC")
+    _, gen, ok := local.get("BTC")
+    assert.False(t, ok)
+    local.Invalidate("BTC")
+    local.put("BTC", &models.MarketData{Close: 1}, gen)
+    _, _, ok = local.get("BTC")
+    assert.False(t, ok)
+
+    // Losing the subscription empties the cache and turns it off
+    assert.Equal(t, 64400.0, closeOf())
+    local.setSubscribed(false)
+    setRedis(64500)
+    assert.False(t, local.Enabled())
+    assert.Equal(t, 64500.0, closeOf())
+
+    var disabled *LocalMarketData
+    assert.False(t, disabled.Enabled())
+    disabled.Invalidate("BTC")
+}
+
+func TestLocalMarketData_EvictsLeastRecentlyUsed(t *testing.T) {
+    // One entry per shard, so symbols of the same shard evict each other
+    local := NewLocalMarketData(localShards, time.Second)
+    local.setSubscribed(true)
+
+    var symbols []string
+    shard := local.shard("BTC")
+    for i := 0; len(symbols) < 2; i++ {
+        if symbol := fmt.Sprintf("SYM%d", i); local.shard(symbol) == shard {
+            symbols = append(symbols, symbol)
+        }
+    }
+
+    for _, symbol := range append([]string{"BTC"}, symbols...) {
+        _, gen, _ := local.get(symbol)
+        local.put(symbol, &models.MarketData{Symbol: symbol}, gen)
+    }
+    _, _, ok := local.get("BTC")
+    assert.False(t, ok)
+    _, _, ok = local.get(symbols[0])
+    assert.False(t, ok)
+    data, _, ok := local.get(symbols[1])
+    if assert.True(t, ok) {
+        assert.Equal(t, symbols[1], data.Symbol)
+    }
+
+    // The TTL can't exceed the staleness cap
+    assert.Equal(t, MaxLocalStaleness, NewLocalMarketData(10, time.Hour).ttl)
+}
+
+func TestLocalMarketData_Subscribe(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    local := NewLocalMarketData(100, MaxLocalStaleness)
+    c := NewMarketDataCache(client, time.Minute, nil, nil).WithLocal(local)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go local.Subscribe(ctx, client, nil)
+
+    assert.Eventually(t, local.Enabled, 5*time.Second, 10*time.Millisecond)
+    mr.Set("market:data:ETH", `{"symbol":"ETH","close":3100}`)
+    data, err := c.GetMarketData(ctx, "ETH")
+    if !assert.NoError(t, err) || !assert.NotNil(t, data) {
+        return
+    }
+    assert.Equal(t, 3100.0, data.Close)
+
+    // The pipeline stores the new price, then announces it
+    mr.Set("market:data:ETH", `{"symbol":"ETH","close":3150}`)
+    mr.Publish(updateChannelPrefix+"ETH", `{"symbol":"ETH","close":3150}`)
+    assert.Eventually(t, func() bool {
+        data, err := c.GetMarketData(ctx, "ETH")
+        return err == nil && data != nil && data.Close == 3150
+    }, 5*time.Second, 10*time.Millisecond)
+
+    // Without the announcements the cache can't be trusted
+    mr.Close()
+    assert.Eventually(t, func() bool { return !local.Enabled() }, 5*time.Second, 10*time.Millisecond)
+}
+
+// BenchmarkMarketDataCache_GetMarketData reads one hot symbol through
+// Redis alone and with the local cache in front
+func BenchmarkMarketDataCache_GetMarketData(b *testing.B) {
+    mr := miniredis.RunT(b)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    ctx := context.Background()
+    if err := NewMarketDataCache(client, time.Minute, nil, nil).
+        SetMarketData(ctx, "BTC", &models.MarketData{Symbol: "BTC", Close: 64000}); err != nil {
+        b.Fatalf("Failed to seed cache: %v", err)
+    }
+
+    local := NewLocalMarketData(1024, MaxLocalStaleness)
+    local.setSubscribed(true)
+    caches := map[string]*MarketDataCache{
+        "redis": NewMarketDataCache(client, time.Minute, nil, nil),
+        "local": NewMarketDataCache(client, time.Minute, nil, nil).WithLocal(local),
+    }
+    for _, name := range []string{"redis", "local"} {
+        c := caches[name]
+        b.Run(name, func(b *testing.B) {
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    if data, err := c.GetMarketData(ctx, "BTC"); err != nil || data == nil {
+                        b.Fatalf("Failed to read BTC: %v", err)
+                    }
+                }
+            })
+        })
+    }
+}
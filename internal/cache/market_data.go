@@ -4,96 +4,184 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "sync"
     "time"
 
     "github.com/go-redis/redis/v8"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
 )
 
+// prefetchComponent names cache prefetches in provider usage
+const prefetchComponent = "cache_prefetch"
+
+// prefetchBatchSize is the number of symbols requested from the collector per call
+const prefetchBatchSize = 10
+
+// BatchCollector fetches fresh market data for a set of symbols
+type BatchCollector interface {
+    CollectBatch(ctx context.Context, symbols []string) (map[string]models.MarketData, error)
+}
+
+// MarketDataCache keeps market data in Redis. It never fails a caller over
+// Redis: while Redis is unreachable or disabled reads miss, so callers fall
+// back to the database, and writes are dropped.
 type MarketDataCache struct {
-    client *redis.Client
-    ttl    time.Duration
+    client    *redis.Client
+    ttl       time.Duration
+    collector BatchCollector
+    logger    *logger.Logger
+    health    *RedisHealth
+    local     *LocalMarketData
+    usage     *monitoring.ProviderUsage
+    provider  string
+
+    statsMu      sync.RWMutex
+    lastPrefetch PrefetchStats
+}
+
+// PrefetchStats summarises a cache warm-up run
+type PrefetchStats struct {
+    Requested int           `json:"requested"`
+    CacheHits int           `json:"cache_hits"`
+    Fetched   int           `json:"fetched"`
+    Failed    int           `json:"failed"`
+    Duration  time.Duration `json:"duration"`
 }
 
-func NewMarketDataCache(client *redis.Client, ttl time.Duration) *MarketDataCache {
+// NewMarketDataCache caches in client, which is nil to run without Redis
+func NewMarketDataCache(client *redis.Client, ttl time.Duration, collector BatchCollector, log *logger.Logger) *MarketDataCache {
     return &MarketDataCache{
-        client: client,
-        ttl:    ttl,
+        client:    client,
+        ttl:       ttl,
+        collector: collector,
+        logger:    log,
     }
 }
 
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (c *MarketDataCache) WithHealth(health *RedisHealth) *MarketDataCache {
+    c.health = health
+    return c
+}
+
+// WithUsage records the collector requests prefetches spare in usage, as
+// cached calls to provider
+func (c *MarketDataCache) WithUsage(usage *monitoring.ProviderUsage, provider string) *MarketDataCache {
+    c.usage = usage
+    c.provider = provider
+    return c
+}
+
+// WithLocal puts local in front of the latest market data reads, so hot
+// symbols are read from Redis once every few seconds rather than on every
+// call
+func (c *MarketDataCache) WithLocal(local *LocalMarketData) *MarketDataCache {
+    c.local = local
+    return c
+}
+
+func (c *MarketDataCache) available() bool {
+    return c.client != nil && c.health.Available()
+}
+
 func (c *MarketDataCache) GetMarketData(ctx context.Context, symbol string) (*models.MarketData, error) {
-    key := fmt.Sprintf("market:data:%s", symbol)
-    data, err := c.client.Get(ctx, key).Bytes()
-    if err == redis.Nil {
-        return nil, nil
-    }
-    if err != nil {
-        return nil, err
+    data, gen, ok := c.local.get(symbol)
+    if ok {
+        return data, nil
     }
-
+    key := fmt.Sprintf("market:data:%s", symbol)
     var marketData models.MarketData
-    if err := json.Unmarshal(data, &marketData); err != nil {
-        return nil, err
+    if !c.get(ctx, key, &marketData) {
+        return nil, nil
     }
-
+    c.local.put(symbol, &marketData, gen)
     return &marketData, nil
 }
 
 func (c *MarketDataCache) SetMarketData(ctx context.Context, symbol string, data *models.MarketData) error {
     key := fmt.Sprintf("market:data:%s", symbol)
-    
-    jsonData, err := json.Marshal(data)
-    if err != nil {
-        return err
-    }
-
-    return c.client.Set(ctx, key, jsonData, c.ttl).Err()
+    defer c.local.Invalidate(symbol)
+    return c.set(ctx, key, data)
 }
 
 func (c *MarketDataCache) GetHistoricalData(ctx context.Context, symbol string, start, end time.Time) ([]models.MarketData, error) {
     key := fmt.Sprintf("market:historical:%s:%d:%d", symbol, start.Unix(), end.Unix())
-    data, err := c.client.Get(ctx, key).Bytes()
-    if err == redis.Nil {
-        return nil, nil
-    }
-    if err != nil {
-        return nil, err
-    }
-
     var historicalData []models.MarketData
-    if err := json.Unmarshal(data, &historicalData); err != nil {
-        return nil, err
+    if !c.get(ctx, key, &historicalData) {
+        return nil, nil
     }
-
     return historicalData, nil
 }
 
 func (c *MarketDataCache) SetHistoricalData(ctx context.Context, symbol string, start, end time.Time, data []models.MarketData) error {
     key := fmt.Sprintf("market:historical:%s:%d:%d", symbol, start.Unix(), end.Unix())
-    
-    jsonData, err := json.Marshal(data)
+    return c.set(ctx, key, data)
+}
+
+// get decodes key into dest, reporting whether it was cached. A Redis
+// failure or an undecodable entry is a miss.
+func (c *MarketDataCache) get(ctx context.Context, key string, dest interface{}) bool {
+    if !c.available() {
+        c.health.Fallback("market_cache_read", nil)
+        return false
+    }
+    data, err := c.client.Get(ctx, key).Bytes()
+    if err == redis.Nil {
+        return false
+    }
     if err != nil {
-        return err
+        c.health.Fallback("market_cache_read", err)
+        return false
     }
+    c.health.Observe(nil)
+    return json.Unmarshal(data, dest) == nil
+}
 
-    return c.client.Set(ctx, key, jsonData, c.ttl).Err()
+// set stores value under key on a best-effort basis: only a value that
+// can't be encoded is an error
+func (c *MarketDataCache) set(ctx context.Context, key string, value interface{}) error {
+    jsonData, err := json.Marshal(value)
+    if err != nil {
+        return err
+    }
+    if !c.available() {
+        c.health.Fallback("market_cache_write", nil)
+        return nil
+    }
+    if err := c.client.Set(ctx, key, jsonData, c.ttl).Err(); err != nil {
+        c.health.Fallback("market_cache_write", err)
+    }
+    return nil
 }
 
+// InvalidateSymbol drops the symbol's entries. Without Redis there are none,
+// so there is nothing to do.
 func (c *MarketDataCache) InvalidateSymbol(ctx context.Context, symbol string) error {
+    c.local.Invalidate(symbol)
+    if !c.available() {
+        return nil
+    }
     pattern := fmt.Sprintf("market:*:%s:*", symbol)
     
     iter := c.client.Scan(ctx, 0, pattern, 0).Iterator()
     for iter.Next(ctx) {
         if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+            c.health.Observe(err)
             return err
         }
     }
     
+    c.health.Observe(iter.Err())
     return iter.Err()
 }
 
 func (c *MarketDataCache) GetCachedSymbols(ctx context.Context) ([]string, error) {
+    if !c.available() {
+        return nil, nil
+    }
     pattern := "market:data:*"
     var symbols []string
 
@@ -105,6 +193,7 @@ func (c *MarketDataCache) GetCachedSymbols(ctx context.Context) ([]string, error
     }
     
     if err := iter.Err(); err != nil {
+        c.health.Observe(err)
         return nil, err
     }
 
@@ -112,6 +201,9 @@ func (c *MarketDataCache) GetCachedSymbols(ctx context.Context) ([]string, error
 }
 
 func (c *MarketDataCache) PurgeTTLExpired(ctx context.Context) error {
+    if !c.available() {
+        return nil
+    }
     pattern := "market:*"
     
     iter := c.client.Scan(ctx, 0, pattern, 0).Iterator()
@@ -131,3 +223,115 @@ func (c *MarketDataCache) PurgeTTLExpired(ctx context.Context) error {
     
     return iter.Err()
 }
+
+// Prefetch warms the cache for the given symbols. Symbols that already have a
+// fresh entry are skipped; the rest are fetched from the collector in
+// concurrent batches and stored. The caller bounds the total time via ctx.
+// Without a reachable Redis there is nothing to warm, so it returns
+// ErrRedisUnavailable rather than fetch data it can't keep.
+func (c *MarketDataCache) Prefetch(ctx context.Context, symbols []string) error {
+    if !c.available() {
+        return ErrRedisUnavailable
+    }
+    if err := c.client.Ping(ctx).Err(); err != nil {
+        c.health.Observe(err)
+        return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+    }
+
+    start := time.Now()
+    stats := PrefetchStats{Requested: len(symbols)}
+
+    var missing []string
+    for _, symbol := range symbols {
+        data, _ := c.GetMarketData(ctx, symbol)
+        if data != nil {
+            stats.CacheHits++
+            c.usage.Record(monitoring.ProviderCall{
+                Provider:  c.provider,
+                Endpoint:  "candles",
+                Symbol:    symbol,
+                Component: prefetchComponent,
+                Cached:    true,
+            })
+            continue
+        }
+        missing = append(missing, symbol)
+    }
+
+    var (
+        wg       sync.WaitGroup
+        mu       sync.Mutex
+        firstErr error
+    )
+
+    for i := 0; i < len(missing); i += prefetchBatchSize {
+        end := i + prefetchBatchSize
+        if end > len(missing) {
+            end = len(missing)
+        }
+        batch := missing[i:end]
+
+        wg.Add(1)
+        go func(batch []string) {
+            defer wg.Done()
+
+            stored, err := c.prefetchBatch(ctx, batch)
+
+            mu.Lock()
+            defer mu.Unlock()
+            stats.Fetched += stored
+            stats.Failed += len(batch) - stored
+            if err != nil && firstErr == nil {
+                firstErr = err
+            }
+        }(batch)
+    }
+
+    wg.Wait()
+    stats.Duration = time.Since(start)
+
+    c.statsMu.Lock()
+    c.lastPrefetch = stats
+    c.statsMu.Unlock()
+
+    if c.logger != nil {
+        c.logger.WithFields(map[string]interface{}{
+            "operation":   "cache_prefetch",
+            "requested":   stats.Requested,
+            "cache_hits":  stats.CacheHits,
+            "fetched":     stats.Fetched,
+            "failed":      stats.Failed,
+            "duration_ms": stats.Duration.Milliseconds(),
+        }).Info("Market data cache prefetch completed")
+    }
+
+    return firstErr
+}
+
+// LastPrefetchStats returns the statistics of the most recent Prefetch run
+func (c *MarketDataCache) LastPrefetchStats() PrefetchStats {
+    c.statsMu.RLock()
+    defer c.statsMu.RUnlock()
+    return c.lastPrefetch
+}
+
+func (c *MarketDataCache) prefetchBatch(ctx context.Context, symbols []string) (int, error) {
+    data, err := c.collector.CollectBatch(monitoring.WithProviderCaller(ctx, prefetchComponent), symbols)
+    if err != nil {
+        return 0, fmt.Errorf("collect batch: %w", err)
+    }
+
+    stored := 0
+    for _, symbol := range symbols {
+        marketData, ok := data[symbol]
+        if !ok {
+            continue
+        }
+        if err := c.SetMarketData(ctx, symbol, &marketData); err != nil {
+            return stored, fmt.Errorf("store market data for %s: %w", symbol, err)
+        }
+        stored++
+    }
+
+    return stored, nil
+}
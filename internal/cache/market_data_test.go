@@ -0,0 +1,70 @@
+package cache
+
+import (
+    "context"
+    "encoding/json"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/go-redis/redismock/v8"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type fakeCollector struct {
+    mu        sync.Mutex
+    requested []string
+}
+
+func (f *fakeCollector) CollectBatch(ctx context.Context, symbols []string) (map[string]models.MarketData, error) {
+    f.mu.Lock()
+    f.requested = append(f.requested, symbols...)
+    f.mu.Unlock()
+
+    data := make(map[string]models.MarketData, len(symbols))
+    for _, symbol := range symbols {
+        data[symbol] = models.MarketData{}
+    }
+    return data, nil
+}
+
+func TestMarketDataCache_Prefetch(t *testing.T) {
+    client, mock := redismock.NewClientMock()
+    mock.MatchExpectationsInOrder(false)
+
+    ttl := time.Minute
+    collector := &fakeCollector{}
+    c := NewMarketDataCache(client, ttl, collector, nil)
+    ctx := context.Background()
+
+    payload, err := json.Marshal(&models.MarketData{})
+    assert.NoError(t, err)
+
+    cached := []string{"AAPL"}
+    uncached := []string{"BTC", "ETH", "GOOGL"}
+
+    mock.ExpectPing().SetVal("PONG")
+    for _, symbol := range cached {
+        mock.ExpectGet("market:data:" + symbol).SetVal(string(payload))
+    }
+    for _, symbol := range uncached {
+        mock.ExpectGet("market:data:" + symbol).RedisNil()
+        // Each missing symbol must be written exactly once
+        mock.ExpectSet("market:data:"+symbol, payload, ttl).SetVal("OK")
+    }
+
+    err = c.Prefetch(ctx, append(cached, uncached...))
+    assert.NoError(t, err)
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    sort.Strings(collector.requested)
+    assert.Equal(t, uncached, collector.requested)
+
+    stats := c.LastPrefetchStats()
+    assert.Equal(t, 4, stats.Requested)
+    assert.Equal(t, 1, stats.CacheHits)
+    assert.Equal(t, 3, stats.Fetched)
+    assert.Equal(t, 0, stats.Failed)
+}
@@ -0,0 +1,266 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// Redis availability as seen by RedisHealth
+const (
+    RedisUp = "up"
+    // RedisDegraded is Redis failing for less than the down threshold.
+    // Caches are bypassed but every call still tries Redis first.
+    RedisDegraded = "degraded"
+    // RedisDown is Redis failing for longer than the down threshold.
+    // Callers stop trying it, so features that need it are off until a
+    // health ping succeeds again.
+    RedisDown = "down"
+    // RedisDisabled is a deployment running without Redis
+    RedisDisabled = "disabled"
+)
+
+// DefaultRedisDownAfter is how long Redis may fail before it is taken as down
+const DefaultRedisDownAfter = 30 * time.Second
+
+const (
+    minResubscribeBackoff = 100 * time.Millisecond
+    maxResubscribeBackoff = 30 * time.Second
+)
+
+// ErrRedisUnavailable is returned by operations that need Redis while it is
+// down or disabled
+var ErrRedisUnavailable = errors.New("redis unavailable")
+
+// RedisHealth tracks whether Redis is answering, from the outcome of the
+// calls its users make and periodic pings. Users consult Available before a
+// call and report failures with Fallback, which counts and logs the
+// degradation. A nil RedisHealth reports Redis as up and records nothing, so
+// users needn't check for one.
+type RedisHealth struct {
+    client    *redis.Client
+    downAfter time.Duration
+    logger    *logger.Logger
+    fallbacks *prometheus.CounterVec
+    now       func() time.Time
+
+    mu           sync.Mutex
+    failingSince time.Time
+    lastErr      error
+}
+
+// NewRedisHealth tracks client, which is nil when Redis is disabled.
+// Redis counts as down after failing for downAfter.
+func NewRedisHealth(client *redis.Client, downAfter time.Duration, log *logger.Logger) *RedisHealth {
+    if downAfter <= 0 {
+        downAfter = DefaultRedisDownAfter
+    }
+    if log == nil {
+        log = logger.Default()
+    }
+    return &RedisHealth{
+        client:    client,
+        downAfter: downAfter,
+        logger:    log.WithFields(map[string]interface{}{"component": "redis"}),
+        fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "redis_fallbacks_total",
+            Help: "Redis calls that failed or were skipped and fell back to degraded behavior",
+        }, []string{"feature"}),
+        now: time.Now,
+    }
+}
+
+// WithRegisterer registers the fallback counter with reg
+func (h *RedisHealth) WithRegisterer(reg prometheus.Registerer) *RedisHealth {
+    reg.MustRegister(h.fallbacks)
+    return h
+}
+
+// Status is RedisUp, RedisDegraded, RedisDown or RedisDisabled
+func (h *RedisHealth) Status() string {
+    if h == nil {
+        return RedisUp
+    }
+    if h.client == nil {
+        return RedisDisabled
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.statusLocked()
+}
+
+func (h *RedisHealth) statusLocked() string {
+    switch {
+    case h.failingSince.IsZero():
+        return RedisUp
+    case h.now().Sub(h.failingSince) < h.downAfter:
+        return RedisDegraded
+    default:
+        return RedisDown
+    }
+}
+
+// Available reports whether Redis is worth calling: it is configured and
+// not down
+func (h *RedisHealth) Available() bool {
+    status := h.Status()
+    return status == RedisUp || status == RedisDegraded
+}
+
+// Observe records the outcome of a Redis call. redis.Nil and cancelled
+// contexts say nothing about Redis and are ignored.
+func (h *RedisHealth) Observe(err error) {
+    if h == nil || h.client == nil || err == redis.Nil ||
+        errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return
+    }
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if err == nil {
+        if !h.failingSince.IsZero() {
+            h.logger.WithFields(map[string]interface{}{
+                "outage_ms": h.now().Sub(h.failingSince).Milliseconds(),
+            }).Info("Redis recovered")
+        }
+        h.failingSince = time.Time{}
+        h.lastErr = nil
+        return
+    }
+    if h.failingSince.IsZero() {
+        h.failingSince = h.now()
+        h.logger.WithFields(map[string]interface{}{"error": err.Error()}).
+            Warn("Redis unreachable, degrading to uncached operation")
+    }
+    h.lastErr = err
+}
+
+// Fallback records that feature went without Redis, because err failed it
+// or, when err is nil, because Redis was unavailable
+func (h *RedisHealth) Fallback(feature string, err error) {
+    if h == nil {
+        return
+    }
+    if err != nil {
+        h.Observe(err)
+    }
+    h.fallbacks.WithLabelValues(feature).Inc()
+}
+
+// Ping checks Redis and records the outcome. It is meant to run on a
+// schedule, so Redis is noticed coming back while callers skip it.
+func (h *RedisHealth) Ping(ctx context.Context) error {
+    if h == nil || h.client == nil {
+        return nil
+    }
+    err := h.client.Ping(ctx).Err()
+    h.Observe(err)
+    return err
+}
+
+// HealthCheck reports Redis for the health checker: the latency and
+// keyspace stats of a ping, and whether Redis-backed features are running
+// degraded or are off. The pings are how Redis is noticed coming back while
+// callers skip it. A deployment that runs without Redis is healthy.
+func (h *RedisHealth) HealthCheck() monitoring.HealthCheckFunc {
+    var ping *monitoring.RedisCheck
+    if h != nil && h.client != nil {
+        ping = monitoring.NewRedisCheck(h.client).WithObserver(h.Observe)
+    }
+    return func(ctx context.Context) *monitoring.CheckResult {
+        result := &monitoring.CheckResult{
+            Status:    monitoring.StatusUp,
+            Component: "redis",
+            Details:   make(map[string]interface{}),
+        }
+        if ping != nil {
+            result = ping.Check(ctx)
+        }
+        result.Details["mode"] = h.Status()
+        switch h.Status() {
+        case RedisDegraded:
+            result.Error = fmt.Sprintf("Redis unreachable, serving without cache: %v", h.lastError())
+        case RedisDown:
+            result.Error = fmt.Sprintf("Redis down, dependent features disabled: %v", h.lastError())
+        }
+        return result
+    }
+}
+
+func (h *RedisHealth) lastError() error {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.lastErr
+}
+
+// Subscribe passes the messages of the subscription open returns to handle
+// until ctx is done. A dropped subscription is reopened with exponential
+// backoff, so a Redis restart only loses the messages published meanwhile.
+func Subscribe(ctx context.Context, open func(ctx context.Context) *redis.PubSub, handle func(*redis.Message), log *logger.Logger) {
+    SubscribeWithState(ctx, open, handle, nil, log)
+}
+
+// SubscribeWithState is Subscribe, also telling onState when the
+// subscription is confirmed and when it is lost, for users that must not
+// act on what they would have been told meanwhile. onState may be nil.
+func SubscribeWithState(ctx context.Context, open func(ctx context.Context) *redis.PubSub, handle func(*redis.Message), onState func(subscribed bool), log *logger.Logger) {
+    if onState == nil {
+        onState = func(bool) {}
+    }
+    if log == nil {
+        log = logger.Default()
+    }
+    backoff := minResubscribeBackoff
+    for {
+        sub := open(ctx)
+        // Receive doesn't watch ctx, so closing the subscription ends it
+        stop := make(chan struct{})
+        go func() {
+            select {
+            case <-ctx.Done():
+                sub.Close()
+            case <-stop:
+            }
+        }()
+
+        for {
+            msg, err := sub.Receive(ctx)
+            if err != nil {
+                if ctx.Err() == nil {
+                    log.WithFields(map[string]interface{}{
+                        "error":      err.Error(),
+                        "backoff_ms": backoff.Milliseconds(),
+                    }).Warn("Redis subscription lost, resubscribing")
+                }
+                break
+            }
+            switch msg := msg.(type) {
+            case *redis.Subscription:
+                // Confirmed, so the connection is healthy again
+                backoff = minResubscribeBackoff
+                onState(true)
+            case *redis.Message:
+                handle(msg)
+            }
+        }
+        onState(false)
+        close(stop)
+        sub.Close()
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > maxResubscribeBackoff {
+            backoff = maxResubscribeBackoff
+        }
+    }
+}
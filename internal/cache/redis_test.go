@@ -0,0 +1,129 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestRedisHealth_Status(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    health := NewRedisHealth(redis.NewClient(&redis.Options{}), 30*time.Second, nil)
+    health.now = func() time.Time { return now }
+
+    assert.Equal(t, RedisUp, health.Status())
+
+    // Misses and cancelled calls say nothing about Redis
+    health.Observe(redis.Nil)
+    health.Observe(context.Canceled)
+    assert.Equal(t, RedisUp, health.Status())
+
+    health.Observe(errors.New("dial tcp: connection refused"))
+    assert.Equal(t, RedisDegraded, health.Status())
+    assert.True(t, health.Available())
+
+    now = now.Add(30 * time.Second)
+    assert.Equal(t, RedisDown, health.Status())
+    assert.False(t, health.Available())
+
+    health.Observe(nil)
+    assert.Equal(t, RedisUp, health.Status())
+
+    disabled := NewRedisHealth(nil, 0, nil)
+    assert.Equal(t, RedisDisabled, disabled.Status())
+    assert.False(t, disabled.Available())
+
+    var untracked *RedisHealth
+    assert.True(t, untracked.Available())
+    untracked.Fallback("market_cache_read", errors.New("ignored"))
+}
+
+func TestMarketDataCache_RedisOutage(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    health := NewRedisHealth(client, time.Minute, nil)
+    c := NewMarketDataCache(client, time.Minute, nil, nil).WithHealth(health)
+    ctx := context.Background()
+
+    assert.NoError(t, c.SetMarketData(ctx, "AAPL", &models.MarketData{Symbol: "AAPL", Close: 190}))
+    data, err := c.GetMarketData(ctx, "AAPL")
+    if !assert.NoError(t, err) || !assert.NotNil(t, data) {
+        return
+    }
+    assert.Equal(t, 190.0, data.Close)
+
+    mr.Close()
+
+    // Reads miss and writes are dropped, neither fails
+    data, err = c.GetMarketData(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Nil(t, data)
+    assert.NoError(t, c.SetMarketData(ctx, "AAPL", &models.MarketData{Symbol: "AAPL", Close: 191}))
+    assert.Equal(t, RedisDegraded, health.Status())
+    assert.ErrorIs(t, c.Prefetch(ctx, []string{"AAPL"}), ErrRedisUnavailable)
+
+    // Without Redis at all the cache is always empty
+    disabled := NewMarketDataCache(nil, time.Minute, nil, nil)
+    assert.NoError(t, disabled.SetMarketData(ctx, "AAPL", &models.MarketData{Close: 190}))
+    data, err = disabled.GetMarketData(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Nil(t, data)
+}
+
+func TestSubscribe_Resubscribes(t *testing.T) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    ctx, cancel := context.WithCancel(context.Background())
+
+    received := make(chan string, 10)
+    done := make(chan struct{})
+    go func() {
+        Subscribe(ctx, func(ctx context.Context) *redis.PubSub {
+            return client.Subscribe(ctx, "updates")
+        }, func(msg *redis.Message) {
+            received <- msg.Payload
+        }, nil)
+        close(done)
+    }()
+
+    publish := func(payload string) bool {
+        // Publish until the subscription is (re)established
+        deadline := time.Now().Add(5 * time.Second)
+        for time.Now().Before(deadline) {
+            if mr.Publish("updates", payload) > 0 {
+                break
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        select {
+        case got := <-received:
+            return got == payload
+        case <-time.After(time.Second):
+            return false
+        }
+    }
+
+    assert.True(t, publish("before"))
+
+    addr := mr.Addr()
+    mr.Close()
+    time.Sleep(50 * time.Millisecond)
+    if !assert.NoError(t, mr.StartAddr(addr)) {
+        cancel()
+        return
+    }
+    assert.True(t, publish("after restart"))
+
+    cancel()
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+        t.Fatal("Subscribe did not return after ctx was cancelled")
+    }
+}
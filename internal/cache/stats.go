@@ -0,0 +1,148 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// CacheNamespaces are the key prefixes CacheStatsService reports on
+var CacheNamespaces = []string{"market:data", "market:historical", "prediction", "portfolio"}
+
+const (
+    // statsSampleSize is the keys per namespace whose memory is measured
+    statsSampleSize = 100
+    // statsScanCount is the SCAN batch size hint
+    statsScanCount = 1000
+)
+
+// NamespaceStat is the size of one key namespace, extrapolated from
+// SampledKeys of its keys
+type NamespaceStat struct {
+    Keys              int64   `json:"keys"`
+    SampledKeys       int     `json:"sampled_keys"`
+    EstimatedMemoryMB float64 `json:"estimated_memory_mb"`
+}
+
+// CacheStats is the memory the cache namespaces use. TotalKeys and
+// EstimatedMemoryMB cover those namespaces only. CacheEvictions is the keys
+// Redis has evicted under memory pressure since it started.
+type CacheStats struct {
+    TotalKeys         int64                    `json:"total_keys"`
+    EstimatedMemoryMB float64                  `json:"estimated_memory_mb"`
+    ByNamespace       map[string]NamespaceStat `json:"by_namespace"`
+    CacheEvictions    int64                    `json:"cache_evictions"`
+    Warnings          []string                 `json:"warnings,omitempty"`
+}
+
+// CacheStatsService estimates the Redis memory each cache namespace uses
+type CacheStatsService struct {
+    client               *redis.Client
+    health               *RedisHealth
+    maxNamespaceMemoryMB float64
+}
+
+// NewCacheStatsService reports on client, which is nil when Redis is
+// disabled. Namespaces estimated above maxNamespaceMemoryMB are warned
+// about; zero disables the warning.
+func NewCacheStatsService(client *redis.Client, maxNamespaceMemoryMB float64) *CacheStatsService {
+    return &CacheStatsService{client: client, maxNamespaceMemoryMB: maxNamespaceMemoryMB}
+}
+
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (s *CacheStatsService) WithHealth(health *RedisHealth) *CacheStatsService {
+    s.health = health
+    return s
+}
+
+// GetStats counts the keys of each namespace and extrapolates its memory
+// from MEMORY USAGE of up to statsSampleSize of them. SCAN visits keys in
+// hash order, so the first keys found serve as the sample.
+func (s *CacheStatsService) GetStats(ctx context.Context) (*CacheStats, error) {
+    if s.client == nil || !s.health.Available() {
+        return nil, ErrRedisUnavailable
+    }
+
+    stats := &CacheStats{ByNamespace: make(map[string]NamespaceStat, len(CacheNamespaces))}
+    for _, namespace := range CacheNamespaces {
+        stat, err := s.namespaceStat(ctx, namespace)
+        s.health.Observe(err)
+        if err != nil {
+            return nil, fmt.Errorf("failed to measure namespace %s: %w", namespace, err)
+        }
+        stats.ByNamespace[namespace] = stat
+        stats.TotalKeys += stat.Keys
+        stats.EstimatedMemoryMB += stat.EstimatedMemoryMB
+
+        if s.maxNamespaceMemoryMB > 0 && stat.EstimatedMemoryMB > s.maxNamespaceMemoryMB {
+            stats.Warnings = append(stats.Warnings, fmt.Sprintf(
+                "namespace %s uses an estimated %.1f MB, above the %.1f MB limit",
+                namespace, stat.EstimatedMemoryMB, s.maxNamespaceMemoryMB))
+        }
+    }
+
+    // Not every Redis-compatible server reports stats, and the key counts
+    // are still worth returning without them
+    evictions, err := s.evictions(ctx)
+    if err != nil {
+        stats.Warnings = append(stats.Warnings, fmt.Sprintf("eviction count unavailable: %v", err))
+    }
+    stats.CacheEvictions = evictions
+
+    return stats, nil
+}
+
+func (s *CacheStatsService) namespaceStat(ctx context.Context, namespace string) (NamespaceStat, error) {
+    var stat NamespaceStat
+    var sampledBytes int64
+
+    iter := s.client.Scan(ctx, 0, namespace+":*", statsScanCount).Iterator()
+    for iter.Next(ctx) {
+        stat.Keys++
+        if stat.SampledKeys >= statsSampleSize {
+            continue
+        }
+        // Spelled out, as not every Redis-compatible server accepts the
+        // lower-case subcommand MemoryUsage sends
+        usage, err := s.client.Do(ctx, "MEMORY", "USAGE", iter.Val()).Int64()
+        if err == redis.Nil {
+            // Expired since the scan found it
+            continue
+        }
+        if err != nil {
+            return stat, err
+        }
+        sampledBytes += usage
+        stat.SampledKeys++
+    }
+    if err := iter.Err(); err != nil {
+        return stat, err
+    }
+
+    if stat.SampledKeys > 0 {
+        perKey := float64(sampledBytes) / float64(stat.SampledKeys)
+        stat.EstimatedMemoryMB = perKey * float64(stat.Keys) / (1 << 20)
+    }
+    return stat, nil
+}
+
+// evictions reads evicted_keys from INFO stats
+func (s *CacheStatsService) evictions(ctx context.Context) (int64, error) {
+    info, err := s.client.Info(ctx, "stats").Result()
+    if err != nil {
+        return 0, err
+    }
+
+    for _, line := range strings.Split(info, "\n") {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, "evicted_keys:") {
+            return strconv.ParseInt(strings.TrimPrefix(line, "evicted_keys:"), 10, 64)
+        }
+    }
+    return 0, errors.New("INFO stats has no evicted_keys")
+}
@@ -0,0 +1,139 @@
+package calendar
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// AssetClassCrypto is the asset class of symbols that trade around the clock
+const AssetClassCrypto = "crypto"
+
+// CryptoBoundary selects where crypto, which has no exchange calendar, is
+// split into days
+type CryptoBoundary string
+
+const (
+    // CryptoUTC splits crypto days at midnight UTC
+    CryptoUTC CryptoBoundary = "utc"
+    // CryptoUserLocal splits crypto days at midnight in the user's timezone
+    CryptoUserLocal CryptoBoundary = "user"
+)
+
+// ParseCryptoBoundary parses a CryptoBoundary, defaulting to CryptoUTC when
+// s is empty
+func ParseCryptoBoundary(s string) (CryptoBoundary, error) {
+    switch b := CryptoBoundary(s); b {
+    case "":
+        return CryptoUTC, nil
+    case CryptoUTC, CryptoUserLocal:
+        return b, nil
+    }
+    return "", fmt.Errorf("unknown crypto day boundary %q, want %q or %q", s, CryptoUTC, CryptoUserLocal)
+}
+
+// Date returns the calendar day t falls on in loc, as midnight UTC of that
+// day. Keying days this way keeps them comparable whatever zone they came
+// from, and a 23 or 25 hour day around a DST change is still one key.
+func Date(t time.Time, loc *time.Location) time.Time {
+    y, m, d := t.In(loc).Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Point is a value observed at an instant
+type Point struct {
+    Time  time.Time
+    Value float64
+}
+
+// Daily buckets points, which must be in time order, into calendar days in
+// loc and keeps the last value of each day. The Time of each returned point
+// is its day as Date returns it.
+func Daily(points []Point, loc *time.Location) []Point {
+    var days []Point
+    for _, p := range points {
+        day := Date(p.Time, loc)
+        if n := len(days); n > 0 && days[n-1].Time.Equal(day) {
+            days[n-1].Value = p.Value
+            continue
+        }
+        days = append(days, Point{Time: day, Value: p.Value})
+    }
+    return days
+}
+
+// Location returns the calendar a symbol's days are counted in: its
+// exchange's timezone for equities, and for crypto the user's timezone or
+// UTC as crypto selects. Unknown timezones fall back to UTC.
+func Location(assetClass, exchangeTZ, userTZ string, crypto CryptoBoundary) *time.Location {
+    tz := exchangeTZ
+    if assetClass == AssetClassCrypto {
+        tz = ""
+        if crypto == CryptoUserLocal {
+            tz = userTZ
+        }
+    }
+    if tz == "" {
+        return time.UTC
+    }
+    loc, err := time.LoadLocation(tz)
+    if err != nil {
+        return time.UTC
+    }
+    return loc
+}
+
+// Resolver looks up symbol calendars in symbol_metadata
+type Resolver struct {
+    db      *sql.DB
+    crypto  CryptoBoundary
+    trading *Calendars
+}
+
+func NewResolver(db *sql.DB, crypto CryptoBoundary) *Resolver {
+    return &Resolver{db: db, crypto: crypto, trading: NewCalendars()}
+}
+
+// WithTradingCalendars replaces the built-in trading calendars
+func (r *Resolver) WithTradingCalendars(trading *Calendars) *Resolver {
+    r.trading = trading
+    return r
+}
+
+// TradingCalendars returns the trading calendar of each asset class
+func (r *Resolver) TradingCalendars() *Calendars {
+    return r.trading
+}
+
+// CryptoBoundary returns where the resolver splits crypto days
+func (r *Resolver) CryptoBoundary() CryptoBoundary {
+    return r.crypto
+}
+
+// SymbolLocation returns the calendar symbol's days are counted in for a
+// user in userTZ, which may be empty. Symbols without metadata use UTC.
+func (r *Resolver) SymbolLocation(ctx context.Context, symbol, userTZ string) (*time.Location, error) {
+    var assetClass, timezone string
+    query := `SELECT asset_class, timezone FROM symbol_metadata WHERE symbol = $1`
+    err := r.db.QueryRowContext(ctx, query, symbol).Scan(&assetClass, &timezone)
+    if err == sql.ErrNoRows {
+        return time.UTC, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get calendar of %s: %w", symbol, err)
+    }
+    return Location(assetClass, timezone, userTZ, r.crypto), nil
+}
+
+// SymbolCalendar returns the trading calendar of symbol's asset class.
+// Symbols without metadata are taken to be equities.
+func (r *Resolver) SymbolCalendar(ctx context.Context, symbol string) (*TradingCalendar, error) {
+    var assetClass string
+    query := `SELECT asset_class FROM symbol_metadata WHERE symbol = $1`
+    err := r.db.QueryRowContext(ctx, query, symbol).Scan(&assetClass)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, fmt.Errorf("failed to get asset class of %s: %w", symbol, err)
+    }
+    return r.trading.For(assetClass), nil
+}
@@ -0,0 +1,94 @@
+package calendar
+
+import (
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+// hourly returns one point per hour from start for n hours, valued by index
+func hourly(start time.Time, n int) []Point {
+    points := make([]Point, n)
+    for i := range points {
+        points[i] = Point{Time: start.Add(time.Duration(i) * time.Hour), Value: float64(i)}
+    }
+    return points
+}
+
+func TestDaily_DSTTransitions(t *testing.T) {
+    newYork, err := time.LoadLocation("America/New_York")
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    tests := []struct {
+        name string
+        // start is local midnight before the transition day
+        start    time.Time
+        days     []string
+        dayHours []int
+    }{
+        {
+            name:     "Spring forward",
+            start:    time.Date(2024, time.March, 9, 0, 0, 0, 0, newYork),
+            days:     []string{"2024-03-09", "2024-03-10", "2024-03-11"},
+            dayHours: []int{24, 23, 24},
+        },
+        {
+            name:     "Fall back",
+            start:    time.Date(2024, time.November, 2, 0, 0, 0, 0, newYork),
+            days:     []string{"2024-11-02", "2024-11-03", "2024-11-04"},
+            dayHours: []int{24, 25, 24},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var total int
+            for _, h := range tt.dayHours {
+                total += h
+            }
+            points := hourly(tt.start.UTC(), total)
+
+            days := Daily(points, newYork)
+            if !assert.Len(t, days, len(tt.days)) {
+                return
+            }
+            last := -1
+            for i, day := range days {
+                assert.Equal(t, tt.days[i], day.Time.Format("2006-01-02"))
+                // Each day closes on its own last hour, so no hour is
+                // counted twice or dropped
+                last += tt.dayHours[i]
+                assert.Equal(t, float64(last), day.Value)
+            }
+        })
+    }
+}
+
+func TestDate(t *testing.T) {
+    tokyo, err := time.LoadLocation("Asia/Tokyo")
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // 23:30 UTC is already the next morning in Tokyo
+    instant := time.Date(2024, time.March, 1, 23, 30, 0, 0, time.UTC)
+    assert.Equal(t, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), Date(instant, time.UTC))
+    assert.Equal(t, time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC), Date(instant, tokyo))
+}
+
+func TestLocation(t *testing.T) {
+    assert.Equal(t, "America/New_York", Location("equity", "America/New_York", "Asia/Tokyo", CryptoUserLocal).String())
+    assert.Equal(t, "UTC", Location("crypto", "UTC", "Asia/Tokyo", CryptoUTC).String())
+    assert.Equal(t, "Asia/Tokyo", Location("crypto", "UTC", "Asia/Tokyo", CryptoUserLocal).String())
+    assert.Equal(t, "UTC", Location("crypto", "UTC", "", CryptoUserLocal).String())
+    assert.Equal(t, "UTC", Location("equity", "Mars/Olympus_Mons", "", CryptoUTC).String())
+
+    _, err := ParseCryptoBoundary("local")
+    assert.Error(t, err)
+    boundary, err := ParseCryptoBoundary("")
+    assert.NoError(t, err)
+    assert.Equal(t, CryptoUTC, boundary)
+}
@@ -0,0 +1,326 @@
+package calendar
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Built-in trading calendars a Config can extend
+const (
+    CalendarNYSE   = "nyse"
+    CalendarCrypto = "crypto"
+)
+
+// TradingCalendar is the days an asset class trades on. A day is the
+// calendar day an instant falls on in its own location, so Date keys and
+// times in the exchange's timezone both work.
+type TradingCalendar struct {
+    name        string
+    weekends    bool
+    daysPerYear float64
+    // open is when the session starts, after local midnight
+    open    time.Duration
+    session time.Duration
+    // rules returns the calendar's recurring holidays in a year
+    rules func(year int) []time.Time
+    extra map[time.Time]bool
+}
+
+// NYSE is the New York Stock Exchange: weekdays other than its full-day
+// holidays, with a session from 9:30 to 16:00
+func NYSE() *TradingCalendar {
+    return &TradingCalendar{
+        name:        CalendarNYSE,
+        daysPerYear: 252,
+        open:        9*time.Hour + 30*time.Minute,
+        session:     6*time.Hour + 30*time.Minute,
+        rules:       nyseHolidays,
+        extra:       make(map[time.Time]bool),
+    }
+}
+
+// Crypto trades around the clock every day of the year
+func Crypto() *TradingCalendar {
+    return &TradingCalendar{
+        name:        CalendarCrypto,
+        weekends:    true,
+        daysPerYear: 365,
+        session:     24 * time.Hour,
+        extra:       make(map[time.Time]bool),
+    }
+}
+
+func (c *TradingCalendar) Name() string {
+    return c.name
+}
+
+// DaysPerYear is the trading days a year annualizes daily figures over
+func (c *TradingCalendar) DaysPerYear() float64 {
+    return c.daysPerYear
+}
+
+// Session is how long the calendar trades on each trading day
+func (c *TradingCalendar) Session() time.Duration {
+    return c.session
+}
+
+// IsTradingDay reports whether the calendar trades on day
+func (c *TradingCalendar) IsTradingDay(day time.Time) bool {
+    return c.trades(dayKey(day), c.holidays(day.Year()))
+}
+
+// NextTradingDay returns the first trading day after day, keyed as Date
+// keys it
+func (c *TradingCalendar) NextTradingDay(day time.Time) time.Time {
+    next := dayKey(day).AddDate(0, 0, 1)
+    holidays := c.holidays(next.Year())
+    for !c.trades(next, holidays) {
+        next = next.AddDate(0, 0, 1)
+        if next.Month() == time.January && next.Day() == 1 {
+            holidays = c.holidays(next.Year())
+        }
+    }
+    return next
+}
+
+// TradingDaysBetween counts the trading days after from up to and
+// including to, which is how many daily returns lie between closes on the
+// two days. It is zero when to isn't after from.
+func (c *TradingCalendar) TradingDaysBetween(from, to time.Time) int {
+    end := dayKey(to)
+    day := dayKey(from).AddDate(0, 0, 1)
+    var n int
+    holidays := c.holidays(day.Year())
+    for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+        if day.Month() == time.January && day.Day() == 1 {
+            holidays = c.holidays(day.Year())
+        }
+        if c.trades(day, holidays) {
+            n++
+        }
+    }
+    return n
+}
+
+// TradingTime returns how long the calendar is in session between from
+// and to, with each day's session at its local time in loc. A session
+// lasting all day runs from midnight to midnight, however long DST makes
+// the day.
+func (c *TradingCalendar) TradingTime(from, to time.Time, loc *time.Location) time.Duration {
+    var total time.Duration
+    y, m, d := from.In(loc).Date()
+    for day := time.Date(y, m, d, 0, 0, 0, 0, loc); day.Before(to); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+        if !c.IsTradingDay(day) {
+            continue
+        }
+        open := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, int(c.open), loc)
+        close := open.Add(c.session)
+        if c.session >= 24*time.Hour {
+            close = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+        }
+        if open.Before(from) {
+            open = from
+        }
+        if close.After(to) {
+            close = to
+        }
+        if close.After(open) {
+            total += close.Sub(open)
+        }
+    }
+    return total
+}
+
+func (c *TradingCalendar) trades(day time.Time, holidays map[time.Time]bool) bool {
+    if !c.weekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+        return false
+    }
+    return !holidays[day] && !c.extra[day]
+}
+
+func (c *TradingCalendar) holidays(year int) map[time.Time]bool {
+    holidays := make(map[time.Time]bool)
+    if c.rules != nil {
+        for _, day := range c.rules(year) {
+            holidays[day] = true
+        }
+    }
+    return holidays
+}
+
+// dayKey is the calendar day of t in its own location, as midnight UTC
+func dayKey(t time.Time) time.Time {
+    y, m, d := t.Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// nyseHolidays returns the NYSE's full-day holidays in year under its
+// current rules. Holidays on a Saturday are observed on the Friday before,
+// except New Year's Day, and those on a Sunday on the Monday after.
+func nyseHolidays(year int) []time.Time {
+    date := func(m time.Month, d int) time.Time {
+        return time.Date(year, m, d, 0, 0, 0, 0, time.UTC)
+    }
+    observed := func(day time.Time) time.Time {
+        switch day.Weekday() {
+        case time.Saturday:
+            return day.AddDate(0, 0, -1)
+        case time.Sunday:
+            return day.AddDate(0, 0, 1)
+        }
+        return day
+    }
+
+    var holidays []time.Time
+    if newYear := date(time.January, 1); newYear.Weekday() != time.Saturday {
+        holidays = append(holidays, observed(newYear))
+    }
+    holidays = append(holidays,
+        nthWeekday(year, time.January, time.Monday, 3),
+        nthWeekday(year, time.February, time.Monday, 3),
+        easter(year).AddDate(0, 0, -2),
+        lastWeekday(year, time.May, time.Monday),
+    )
+    if year >= 2022 {
+        holidays = append(holidays, observed(date(time.June, 19)))
+    }
+    holidays = append(holidays,
+        observed(date(time.July, 4)),
+        nthWeekday(year, time.September, time.Monday, 1),
+        nthWeekday(year, time.November, time.Thursday, 4),
+        observed(date(time.December, 25)),
+    )
+    return holidays
+}
+
+// nthWeekday returns the nth weekday of month, counting from 1
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+    first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+    offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+    return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+    last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+    offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+    return last.AddDate(0, 0, -offset)
+}
+
+// easter returns Easter Sunday of year in the Gregorian calendar
+func easter(year int) time.Time {
+    a := year % 19
+    b, c := year/100, year%100
+    d, e := b/4, b%4
+    f := (b + 8) / 25
+    g := (b - f + 1) / 3
+    h := (19*a + b - d - g + 15) % 30
+    i, k := c/4, c%4
+    l := (32 + 2*e + 2*i - h - k) % 7
+    m := (a + 11*h + 22*l) / 451
+    month := (h + l - 7*m + 114) / 31
+    day := (h+l-7*m+114)%31 + 1
+    return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// Config extends the trading calendar of an asset class
+type Config struct {
+    AssetClass string
+    // Base is the built-in calendar extended, CalendarNYSE or
+    // CalendarCrypto. It defaults to CalendarCrypto for crypto and
+    // CalendarNYSE for everything else.
+    Base string
+    // Holidays are extra closures as YYYY-MM-DD, such as unscheduled
+    // market closures
+    Holidays []string
+    // DaysPerYear overrides the base calendar's annualization when positive
+    DaysPerYear float64
+}
+
+// Calendars picks the trading calendar of each asset class. Crypto trades
+// on the crypto calendar and every other class on the NYSE's.
+type Calendars struct {
+    byClass map[string]*TradingCalendar
+    equity  *TradingCalendar
+}
+
+func NewCalendars() *Calendars {
+    return &Calendars{
+        byClass: map[string]*TradingCalendar{AssetClassCrypto: Crypto()},
+        equity:  NYSE(),
+    }
+}
+
+// Configure replaces the calendars of the configured asset classes. It is
+// meant for startup, before the calendars are shared.
+func (c *Calendars) Configure(configs ...Config) error {
+    for _, cfg := range configs {
+        if cfg.AssetClass == "" {
+            return fmt.Errorf("trading calendar config has no asset class")
+        }
+        base := cfg.Base
+        if base == "" {
+            base = CalendarNYSE
+            if cfg.AssetClass == AssetClassCrypto {
+                base = CalendarCrypto
+            }
+        }
+
+        var cal *TradingCalendar
+        switch base {
+        case CalendarNYSE:
+            cal = NYSE()
+        case CalendarCrypto:
+            cal = Crypto()
+        default:
+            return fmt.Errorf("unknown trading calendar %q for %s", base, cfg.AssetClass)
+        }
+        for _, h := range cfg.Holidays {
+            day, err := time.Parse("2006-01-02", h)
+            if err != nil {
+                return fmt.Errorf("invalid %s holiday %q: %w", cfg.AssetClass, h, err)
+            }
+            cal.extra[day] = true
+        }
+        if cfg.DaysPerYear > 0 {
+            cal.daysPerYear = cfg.DaysPerYear
+        }
+        c.byClass[cfg.AssetClass] = cal
+    }
+    return nil
+}
+
+// For returns the trading calendar of assetClass
+func (c *Calendars) For(assetClass string) *TradingCalendar {
+    if cal, ok := c.byClass[assetClass]; ok {
+        return cal
+    }
+    return c.equity
+}
+
+// AnnualizationFactor is the trading days per year daily returns of
+// assetClass annualize over: the square of the volatility multiplier
+func (c *Calendars) AnnualizationFactor(assetClass string) float64 {
+    return c.For(assetClass).DaysPerYear()
+}
+
+// ParseHolidays parses extra closures given as asset_class:YYYY-MM-DD into
+// one Config per asset class
+func ParseHolidays(entries []string) ([]Config, error) {
+    var configs []Config
+    index := make(map[string]int)
+    for _, entry := range entries {
+        assetClass, day, ok := strings.Cut(strings.TrimSpace(entry), ":")
+        if !ok || assetClass == "" {
+            return nil, fmt.Errorf("invalid trading holiday %q, want asset_class:YYYY-MM-DD", entry)
+        }
+        i, seen := index[assetClass]
+        if !seen {
+            i = len(configs)
+            index[assetClass] = i
+            configs = append(configs, Config{AssetClass: assetClass})
+        }
+        configs[i].Holidays = append(configs[i].Holidays, day)
+    }
+    return configs, nil
+}
@@ -0,0 +1,113 @@
+package calendar
+
+import (
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+    return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNYSE_ThanksgivingWeek(t *testing.T) {
+    nyse := NYSE()
+
+    // Monday 25 to Sunday 1 December 2024, shut for Thanksgiving on the 28th
+    trading := []bool{true, true, true, false, true, false, false}
+    for i, want := range trading {
+        d := day(2024, time.November, 25+i)
+        assert.Equal(t, want, nyse.IsTradingDay(d), d.Format("Mon 2 Jan"))
+    }
+
+    assert.Equal(t, day(2024, time.November, 29), nyse.NextTradingDay(day(2024, time.November, 27)))
+    assert.Equal(t, day(2024, time.December, 2), nyse.NextTradingDay(day(2024, time.November, 29)))
+    // Friday to Friday
+    assert.Equal(t, 4, nyse.TradingDaysBetween(day(2024, time.November, 22), day(2024, time.November, 29)))
+    assert.Equal(t, 0, nyse.TradingDaysBetween(day(2024, time.November, 29), day(2024, time.November, 22)))
+
+    // A day is read in its own location, so the evening of Thanksgiving in
+    // New York is still Thanksgiving
+    newYork, err := time.LoadLocation("America/New_York")
+    if assert.NoError(t, err) {
+        assert.False(t, nyse.IsTradingDay(time.Date(2024, time.November, 28, 21, 0, 0, 0, newYork)))
+    }
+
+    // Crypto trades through it
+    assert.Equal(t, 7, Crypto().TradingDaysBetween(day(2024, time.November, 22), day(2024, time.November, 29)))
+}
+
+func TestNYSE_Holidays(t *testing.T) {
+    nyse := NYSE()
+
+    closed := []time.Time{
+        day(2024, time.March, 29),    // Good Friday
+        day(2022, time.June, 20),     // Juneteenth, observed from a Sunday
+        day(2026, time.July, 3),      // Independence Day, observed from a Saturday
+        day(2022, time.December, 26), // Christmas, observed from a Sunday
+    }
+    for _, d := range closed {
+        assert.False(t, nyse.IsTradingDay(d), d.Format("2006-01-02"))
+    }
+
+    // New Year's Day on a Saturday isn't observed on the Friday before
+    assert.True(t, nyse.IsTradingDay(day(2021, time.December, 31)))
+}
+
+func TestTradingDaysBetween_LeapYear(t *testing.T) {
+    // 2024 has 29 February, a Thursday
+    assert.True(t, NYSE().IsTradingDay(day(2024, time.February, 29)))
+    assert.Equal(t, day(2024, time.February, 29), NYSE().NextTradingDay(day(2024, time.February, 28)))
+    assert.Equal(t, day(2024, time.February, 29), Crypto().NextTradingDay(day(2024, time.February, 28)))
+
+    assert.Equal(t, 366, Crypto().TradingDaysBetween(day(2023, time.December, 31), day(2024, time.December, 31)))
+    assert.Equal(t, 365, Crypto().TradingDaysBetween(day(2022, time.December, 31), day(2023, time.December, 31)))
+    assert.Equal(t, 252, NYSE().TradingDaysBetween(day(2023, time.December, 31), day(2024, time.December, 31)))
+    assert.Equal(t, 250, NYSE().TradingDaysBetween(day(2022, time.December, 31), day(2023, time.December, 31)))
+
+    // The annualization factor is a convention, not the count of the year
+    calendars := NewCalendars()
+    assert.Equal(t, 365.0, calendars.AnnualizationFactor(AssetClassCrypto))
+    assert.Equal(t, 252.0, calendars.AnnualizationFactor("equity"))
+}
+
+func TestTradingTime(t *testing.T) {
+    newYork, err := time.LoadLocation("America/New_York")
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // Sunday 10 March 2024 is 23 hours long in New York; the session the
+    // next morning still opens at 9:30 local
+    from := time.Date(2024, time.March, 10, 0, 0, 0, 0, newYork)
+    to := time.Date(2024, time.March, 11, 12, 0, 0, 0, newYork)
+    assert.Equal(t, 2*time.Hour+30*time.Minute, NYSE().TradingTime(from, to, newYork))
+    assert.Equal(t, to.Sub(from), Crypto().TradingTime(from, to, newYork))
+}
+
+func TestCalendars_Configure(t *testing.T) {
+    configs, err := ParseHolidays([]string{"equity:2025-01-09", "etf:2025-01-09", "equity:2018-12-05"})
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, []Config{
+        {AssetClass: "equity", Holidays: []string{"2025-01-09", "2018-12-05"}},
+        {AssetClass: "etf", Holidays: []string{"2025-01-09"}},
+    }, configs)
+
+    calendars := NewCalendars()
+    if !assert.NoError(t, calendars.Configure(configs...)) {
+        return
+    }
+    // The day of mourning for President Carter, a Thursday
+    assert.False(t, calendars.For("equity").IsTradingDay(day(2025, time.January, 9)))
+    assert.False(t, calendars.For("equity").IsTradingDay(day(2018, time.December, 5)))
+    // Unconfigured classes keep the built-in calendar
+    assert.True(t, calendars.For("adr").IsTradingDay(day(2025, time.January, 9)))
+
+    assert.Error(t, calendars.Configure(Config{AssetClass: "equity", Base: "lse"}))
+    assert.Error(t, calendars.Configure(Config{AssetClass: "equity", Holidays: []string{"9 Jan 2025"}}))
+    _, err = ParseHolidays([]string{"2025-01-09"})
+    assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
     "os"
+    "path/filepath"
     "strconv"
     "strings"
     "time"
@@ -11,12 +12,15 @@ import (
 )
 
 type Config struct {
-    App      AppConfig      `yaml:"app"`
-    Database DatabaseConfig `yaml:"database"`
-    Auth     AuthConfig     `yaml:"auth"`
-    ML       MLConfig       `yaml:"ml"`
-    Redis    RedisConfig    `yaml:"redis"`
-    Services ServicesConfig `yaml:"services"`
+    App       AppConfig       `yaml:"app"`
+    Database  DatabaseConfig  `yaml:"database"`
+    Auth      AuthConfig      `yaml:"auth"`
+    ML        MLConfig        `yaml:"ml"`
+    Redis     RedisConfig     `yaml:"redis"`
+    Cache     CacheConfig     `yaml:"cache"`
+    Analytics AnalyticsConfig `yaml:"analytics"`
+    Services  ServicesConfig  `yaml:"services"`
+    Mail      MailConfig      `yaml:"mail"`
 }
 
 type AppConfig struct {
@@ -24,6 +28,10 @@ type AppConfig struct {
     Env   string `yaml:"env"`
     Port  int    `yaml:"port"`
     Debug bool   `yaml:"debug"`
+    // MaxPositionValueUSD and MaxPortfolioValueUSD cap what a single asset
+    // and a whole portfolio may be worth when positions are entered
+    MaxPositionValueUSD  float64 `yaml:"max_position_value_usd"`
+    MaxPortfolioValueUSD float64 `yaml:"max_portfolio_value_usd"`
 }
 
 type DatabaseConfig struct {
@@ -45,6 +53,46 @@ type MLConfig struct {
     ModelPath      string        `yaml:"model_path"`
     UpdateInterval time.Duration `yaml:"update_interval"`
     BatchSize      int          `yaml:"batch_size"`
+    // EWMAHalfLifeDays is the half-life of the EWMA expected return estimate
+    EWMAHalfLifeDays float64 `yaml:"ewma_half_life_days"`
+    Artifacts        ArtifactStoreConfig `yaml:"artifacts"`
+}
+
+// ArtifactStoreConfig selects where trained model artifacts are stored and
+// where they are cached for serving
+type ArtifactStoreConfig struct {
+    // Backend is local or s3
+    Backend string `yaml:"backend"`
+    // Path is the root of the local store
+    Path      string `yaml:"path"`
+    Endpoint  string `yaml:"endpoint"`
+    Bucket    string `yaml:"bucket"`
+    Region    string `yaml:"region"`
+    AccessKey string `yaml:"access_key"`
+    SecretKey string `yaml:"secret_key"`
+    UseSSL    bool   `yaml:"use_ssl"`
+    // CacheDir holds artifacts downloaded for serving, trimmed to
+    // CacheBudgetBytes
+    CacheDir         string `yaml:"cache_dir"`
+    CacheBudgetBytes int64  `yaml:"cache_budget_bytes"`
+}
+
+// MailConfig configures email delivery. With DevDir set, rendered emails
+// are written there instead of being sent.
+type MailConfig struct {
+    Host     string `yaml:"host"`
+    Port     int    `yaml:"port"`
+    Username string `yaml:"username"`
+    Password string `yaml:"password"`
+    From     string `yaml:"from"`
+    // TLS is starttls, tls for implicit TLS, or none
+    TLS    string `yaml:"tls"`
+    DevDir string `yaml:"dev_dir"`
+    // MaxAttempts is how often a failing email is tried before it is
+    // marked failed
+    MaxAttempts int `yaml:"max_attempts"`
+    // RecipientHourlyLimit caps the emails sent to one address per hour
+    RecipientHourlyLimit int `yaml:"recipient_hourly_limit"`
 }
 
 type RedisConfig struct {
@@ -53,6 +101,31 @@ type RedisConfig struct {
     Password string `yaml:"password"`
 }
 
+type CacheConfig struct {
+    TTL             time.Duration `yaml:"ttl"`
+    PrefetchTimeout time.Duration `yaml:"prefetch_timeout"`
+    // MaxNamespaceMemoryMB is the estimated memory a cache namespace may
+    // use before cache stats warn about it
+    MaxNamespaceMemoryMB float64 `yaml:"max_namespace_memory_mb"`
+    // LocalSize is the symbols whose latest market data is also kept in
+    // process for LocalTTL, in front of Redis. Zero disables it.
+    LocalSize int           `yaml:"local_size"`
+    LocalTTL  time.Duration `yaml:"local_ttl"`
+    // FlushConfirmAbove is the most keys an admin prefix flush deletes
+    // without a confirmation token
+    FlushConfirmAbove int `yaml:"flush_confirm_above"`
+}
+
+type AnalyticsConfig struct {
+    MarketSymbol string `yaml:"market_symbol"`
+    // MaxAnalysisAgeHours is how long market analyses are kept before they
+    // are purged
+    MaxAnalysisAgeHours int `yaml:"max_analysis_age_hours"`
+    // AnalysisHistoryRetentionHours is how long past market analyses are
+    // kept; zero keeps no history
+    AnalysisHistoryRetentionHours int `yaml:"analysis_history_retention_hours"`
+}
+
 type ServicesConfig struct {
     MarketData MarketDataConfig `yaml:"market_data"`
 }
@@ -61,9 +134,34 @@ type MarketDataConfig struct {
     Provider       string        `yaml:"provider"`
     APIKey        string        `yaml:"api_key"`
     UpdateInterval time.Duration `yaml:"update_interval"`
+    // CollectionJitter is the most a collection is delayed past each
+    // update_interval boundary
+    CollectionJitter time.Duration `yaml:"collection_jitter"`
+    Symbols          []string      `yaml:"symbols"`
+    // DegradedSymbols and DegradedInterval replace symbols and
+    // update_interval once the provider's monthly budget is used up
+    DegradedSymbols  []string      `yaml:"degraded_symbols"`
+    DegradedInterval time.Duration `yaml:"degraded_interval"`
 }
 
+// Load reads the config at path with Parse and validates it, returning a
+// *ValidationError listing every problem found
 func Load(path string) (*Config, error) {
+    cfg, err := Parse(path)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+
+    return cfg, nil
+}
+
+// Parse reads the config at path, fills in defaults and applies overrides
+// from the environment, without validating it
+func Parse(path string) (*Config, error) {
     file, err := os.ReadFile(path)
     if err != nil {
         return nil, err
@@ -74,15 +172,79 @@ func Load(path string) (*Config, error) {
         return nil, err
     }
 
+    cfg.setDefaults()
+
     if err := cfg.loadFromEnv(); err != nil {
         return nil, err
     }
 
-    if err := cfg.validate(); err != nil {
-        return nil, err
+    return &cfg, nil
+}
+
+func (c *Config) setDefaults() {
+    if c.Cache.PrefetchTimeout == 0 {
+        c.Cache.PrefetchTimeout = 30 * time.Second
     }
 
-    return &cfg, nil
+    if c.Cache.MaxNamespaceMemoryMB == 0 {
+        c.Cache.MaxNamespaceMemoryMB = 256
+    }
+
+    if c.Cache.LocalTTL == 0 {
+        c.Cache.LocalTTL = 2 * time.Second
+    }
+
+    if c.Cache.FlushConfirmAbove == 0 {
+        c.Cache.FlushConfirmAbove = 1000
+    }
+
+    if c.ML.EWMAHalfLifeDays == 0 {
+        c.ML.EWMAHalfLifeDays = 30
+    }
+
+    if c.ML.Artifacts.Backend == "" {
+        c.ML.Artifacts.Backend = "local"
+    }
+
+    if c.ML.Artifacts.Path == "" {
+        c.ML.Artifacts.Path = filepath.Join(c.ML.ModelPath, "store")
+    }
+
+    if c.ML.Artifacts.CacheDir == "" {
+        c.ML.Artifacts.CacheDir = filepath.Join(c.ML.ModelPath, "cache")
+    }
+
+    if c.Mail.Port == 0 {
+        c.Mail.Port = 587
+    }
+
+    if c.Mail.TLS == "" {
+        c.Mail.TLS = "starttls"
+    }
+
+    if c.Mail.MaxAttempts == 0 {
+        c.Mail.MaxAttempts = 5
+    }
+
+    if c.Mail.RecipientHourlyLimit == 0 {
+        c.Mail.RecipientHourlyLimit = 10
+    }
+
+    if c.Analytics.MarketSymbol == "" {
+        c.Analytics.MarketSymbol = "SPY"
+    }
+
+    if c.Analytics.MaxAnalysisAgeHours == 0 {
+        c.Analytics.MaxAnalysisAgeHours = 24
+    }
+
+    if c.App.MaxPositionValueUSD == 0 {
+        c.App.MaxPositionValueUSD = 1000000
+    }
+
+    if c.App.MaxPortfolioValueUSD == 0 {
+        c.App.MaxPortfolioValueUSD = 10000000
+    }
 }
 
 func (c *Config) loadFromEnv() error {
@@ -112,29 +274,62 @@ func (c *Config) loadFromEnv() error {
         c.Services.MarketData.APIKey = apiKey
     }
 
-    return nil
-}
+    if v := os.Getenv("ARTIFACT_STORE_ACCESS_KEY"); v != "" {
+        c.ML.Artifacts.AccessKey = v
+    }
 
-func (c *Config) validate() error {
-    if c.App.Port <= 0 || c.App.Port > 65535 {
-        return fmt.Errorf("invalid port number: %d", c.App.Port)
+    if v := os.Getenv("ARTIFACT_STORE_SECRET_KEY"); v != "" {
+        c.ML.Artifacts.SecretKey = v
     }
 
-    if c.Database.Host == "" {
-        return fmt.Errorf("database host is required")
+    if v := os.Getenv("MAIL_PASSWORD"); v != "" {
+        c.Mail.Password = v
     }
 
-    if c.Auth.JWTSecret == "" {
-        return fmt.Errorf("JWT secret is required")
+    if symbol := os.Getenv("MARKET_SYMBOL"); symbol != "" {
+        c.Analytics.MarketSymbol = symbol
     }
 
-    if c.Services.MarketData.APIKey == "" {
-        return fmt.Errorf("market data API key is required")
+    if v := os.Getenv("MAX_POSITION_VALUE_USD"); v != "" {
+        limit, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return fmt.Errorf("invalid MAX_POSITION_VALUE_USD: %w", err)
+        }
+        c.App.MaxPositionValueUSD = limit
+    }
+
+    if v := os.Getenv("MAX_PORTFOLIO_VALUE_USD"); v != "" {
+        limit, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return fmt.Errorf("invalid MAX_PORTFOLIO_VALUE_USD: %w", err)
+        }
+        c.App.MaxPortfolioValueUSD = limit
     }
 
     return nil
 }
 
+// redactedSecret replaces secrets in Redacted
+const redactedSecret = "********"
+
+// Redacted returns a copy of the config with its secrets masked, for
+// printing. Empty secrets stay empty, so a missing one still shows.
+func (c Config) Redacted() Config {
+    mask := func(s *string) {
+        if *s != "" {
+            *s = redactedSecret
+        }
+    }
+    mask(&c.Database.Password)
+    mask(&c.Auth.JWTSecret)
+    mask(&c.Redis.Password)
+    mask(&c.ML.Artifacts.AccessKey)
+    mask(&c.ML.Artifacts.SecretKey)
+    mask(&c.Services.MarketData.APIKey)
+    mask(&c.Mail.Password)
+    return c
+}
+
 func parseDatabaseURL(url string) (*DatabaseConfig, error) {
     cfg := &DatabaseConfig{
         SSLMode: "disable",
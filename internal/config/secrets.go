@@ -0,0 +1,34 @@
+package config
+
+import (
+    "fmt"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+)
+
+// MarketDataAPIKeyAssociatedData binds an encrypted market data API key to
+// its setting, so a ciphertext can't be moved to another field
+var MarketDataAPIKeyAssociatedData = []byte("config:market_data.api_key")
+
+// DecryptSecrets replaces encrypted secret fields with their plaintext.
+// Fields that aren't in the ciphertext format are left as they are.
+func (c *Config) DecryptSecrets(k *crypto.Keyring) error {
+    return c.Services.MarketData.DecryptSecrets(k)
+}
+
+// DecryptSecrets decrypts APIKey if it was stored encrypted
+func (c *MarketDataConfig) DecryptSecrets(k *crypto.Keyring) error {
+    if !crypto.IsEncrypted(c.APIKey) {
+        return nil
+    }
+    if k == nil {
+        return fmt.Errorf("market data API key is encrypted but no encryption keys are configured")
+    }
+
+    apiKey, err := k.Decrypt(c.APIKey, MarketDataAPIKeyAssociatedData)
+    if err != nil {
+        return fmt.Errorf("market data API key: %w", err)
+    }
+    c.APIKey = string(apiKey)
+    return nil
+}
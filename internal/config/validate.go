@@ -0,0 +1,290 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Violation is one problem with a config value, at its YAML path or, for
+// the server's environment config, its variable
+type Violation struct {
+    Path    string
+    Message string
+}
+
+func (v Violation) String() string {
+    return v.Path + ": " + v.Message
+}
+
+// ValidationError lists every Violation found in a config, so all of them
+// can be fixed in one go rather than one per restart
+type ValidationError struct {
+    Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+    lines := make([]string, len(e.Violations))
+    for i, v := range e.Violations {
+        lines[i] = "  " + v.String()
+    }
+    return fmt.Sprintf("invalid config, %d problems:\n%s", len(e.Violations), strings.Join(lines, "\n"))
+}
+
+// checker collects the Violations of one section, with paths relative to it
+type checker struct {
+    violations []Violation
+}
+
+func (c *checker) addf(path, format string, args ...interface{}) {
+    c.violations = append(c.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) required(path, value string) {
+    if value == "" {
+        c.addf(path, "is required")
+    }
+}
+
+func (c *checker) port(path string, port int) {
+    if port <= 0 || port > 65535 {
+        c.addf(path, "must be between 1 and 65535, got %d", port)
+    }
+}
+
+func (c *checker) oneOf(path, value string, allowed ...string) {
+    for _, a := range allowed {
+        if value == a {
+            return
+        }
+    }
+    c.addf(path, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+// merge adds the violations of a section's Validate error under prefix
+func (c *checker) merge(prefix string, err error) {
+    var verr *ValidationError
+    if !errors.As(err, &verr) {
+        if err != nil {
+            c.addf(prefix, "%v", err)
+        }
+        return
+    }
+    for _, v := range verr.Violations {
+        c.violations = append(c.violations, Violation{Path: prefix + "." + v.Path, Message: v.Message})
+    }
+}
+
+func (c *checker) err() error {
+    if len(c.violations) == 0 {
+        return nil
+    }
+    return &ValidationError{Violations: c.violations}
+}
+
+// Validate checks every section, returning a *ValidationError listing all
+// their violations at their full YAML paths
+func (c *Config) Validate() error {
+    var check checker
+    check.merge("app", c.App.Validate())
+    check.merge("database", c.Database.Validate())
+    check.merge("auth", c.Auth.Validate())
+    check.merge("ml", c.ML.Validate())
+    check.merge("redis", c.Redis.Validate())
+    check.merge("cache", c.Cache.Validate())
+    check.merge("analytics", c.Analytics.Validate())
+    check.merge("services", c.Services.Validate())
+    check.merge("mail", c.Mail.Validate())
+    return check.err()
+}
+
+// Validate checks the port and the position and portfolio value limits
+func (c AppConfig) Validate() error {
+    var check checker
+    check.port("port", c.Port)
+    if c.MaxPositionValueUSD < 0 {
+        check.addf("max_position_value_usd", "must be non-negative, got %v", c.MaxPositionValueUSD)
+    }
+    if c.MaxPortfolioValueUSD < 0 {
+        check.addf("max_portfolio_value_usd", "must be non-negative, got %v", c.MaxPortfolioValueUSD)
+    }
+    if c.MaxPositionValueUSD > 0 && c.MaxPortfolioValueUSD > 0 && c.MaxPositionValueUSD > c.MaxPortfolioValueUSD {
+        check.addf("max_position_value_usd", "must not exceed max_portfolio_value_usd (%v), got %v", c.MaxPortfolioValueUSD, c.MaxPositionValueUSD)
+    }
+    return check.err()
+}
+
+// Validate checks the connection settings
+func (c DatabaseConfig) Validate() error {
+    var check checker
+    check.required("host", c.Host)
+    check.port("port", c.Port)
+    check.required("name", c.Name)
+    check.required("user", c.User)
+    if c.SSLMode != "" {
+        check.oneOf("sslmode", c.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+    }
+    return check.err()
+}
+
+// Validate checks the JWT secret and that refresh tokens outlive the access
+// tokens they renew
+func (c AuthConfig) Validate() error {
+    var check checker
+    check.required("jwt_secret", c.JWTSecret)
+    if c.TokenExpiry <= 0 {
+        check.addf("token_expiry", "must be positive, got %v", c.TokenExpiry)
+    }
+    if c.RefreshExpiry <= c.TokenExpiry {
+        check.addf("refresh_expiry", "must be longer than token_expiry (%v), got %v", c.TokenExpiry, c.RefreshExpiry)
+    }
+    return check.err()
+}
+
+// MaxMLBatchSize is the largest batch of predictions ml.batch_size may ask
+// for
+const MaxMLBatchSize = 1000
+
+// Validate checks the model path, which ML routes serve from, the update
+// interval, batch size and artifact store
+func (c MLConfig) Validate() error {
+    var check checker
+    check.required("model_path", c.ModelPath)
+    if c.UpdateInterval < time.Second {
+        check.addf("update_interval", "must be at least 1s, got %v", c.UpdateInterval)
+    }
+    if c.BatchSize < 1 || c.BatchSize > MaxMLBatchSize {
+        check.addf("batch_size", "must be between 1 and %d, got %d", MaxMLBatchSize, c.BatchSize)
+    }
+    if c.EWMAHalfLifeDays <= 0 {
+        check.addf("ewma_half_life_days", "must be positive, got %v", c.EWMAHalfLifeDays)
+    }
+    check.merge("artifacts", c.Artifacts.Validate())
+    return check.err()
+}
+
+// Validate checks the backend and what it needs
+func (c ArtifactStoreConfig) Validate() error {
+    var check checker
+    check.oneOf("backend", c.Backend, "local", "s3")
+    switch c.Backend {
+    case "local":
+        check.required("path", c.Path)
+    case "s3":
+        check.required("endpoint", c.Endpoint)
+        check.required("bucket", c.Bucket)
+    }
+    if c.CacheBudgetBytes < 0 {
+        check.addf("cache_budget_bytes", "must be non-negative, got %d", c.CacheBudgetBytes)
+    }
+    return check.err()
+}
+
+// Validate checks the address
+func (c RedisConfig) Validate() error {
+    var check checker
+    check.required("host", c.Host)
+    check.port("port", c.Port)
+    return check.err()
+}
+
+// MaxLocalCacheTTL is the longest cache.local_ttl, the staleness cap the
+// in-process market data cache applies
+const MaxLocalCacheTTL = 10 * time.Second
+
+// Validate checks the TTL, as a TTL of 0 would keep nothing cached, the
+// prefetch timeout and memory warning, the in-process cache and the flush
+// confirmation threshold
+func (c CacheConfig) Validate() error {
+    var check checker
+    if c.TTL <= 0 {
+        check.addf("ttl", "must be positive, got %v", c.TTL)
+    }
+    if c.PrefetchTimeout <= 0 {
+        check.addf("prefetch_timeout", "must be positive, got %v", c.PrefetchTimeout)
+    }
+    if c.MaxNamespaceMemoryMB <= 0 {
+        check.addf("max_namespace_memory_mb", "must be positive, got %v", c.MaxNamespaceMemoryMB)
+    }
+    if c.LocalSize < 0 {
+        check.addf("local_size", "must be non-negative, got %d", c.LocalSize)
+    }
+    if c.LocalTTL <= 0 || c.LocalTTL > MaxLocalCacheTTL {
+        check.addf("local_ttl", "must be positive and at most %v, got %v", MaxLocalCacheTTL, c.LocalTTL)
+    }
+    if c.FlushConfirmAbove < 0 {
+        check.addf("flush_confirm_above", "must be non-negative, got %d", c.FlushConfirmAbove)
+    }
+    return check.err()
+}
+
+// Validate checks the retention periods
+func (c AnalyticsConfig) Validate() error {
+    var check checker
+    check.required("market_symbol", c.MarketSymbol)
+    if c.MaxAnalysisAgeHours <= 0 {
+        check.addf("max_analysis_age_hours", "must be positive, got %d", c.MaxAnalysisAgeHours)
+    }
+    if c.AnalysisHistoryRetentionHours < 0 {
+        check.addf("analysis_history_retention_hours", "must be non-negative, got %d", c.AnalysisHistoryRetentionHours)
+    }
+    return check.err()
+}
+
+// Validate checks each external service
+func (c ServicesConfig) Validate() error {
+    var check checker
+    check.merge("market_data", c.MarketData.Validate())
+    return check.err()
+}
+
+// Validate checks the provider, the schedule and that degraded collection
+// is a subset of normal collection, run no more often
+func (c MarketDataConfig) Validate() error {
+    var check checker
+    check.required("provider", c.Provider)
+    check.required("api_key", c.APIKey)
+    if c.UpdateInterval < time.Second {
+        check.addf("update_interval", "must be at least 1s, got %v", c.UpdateInterval)
+    }
+    if c.CollectionJitter < 0 {
+        check.addf("collection_jitter", "must be non-negative, got %v", c.CollectionJitter)
+    } else if c.UpdateInterval >= time.Second && c.CollectionJitter >= c.UpdateInterval {
+        check.addf("collection_jitter", "must be shorter than update_interval (%v), got %v", c.UpdateInterval, c.CollectionJitter)
+    }
+    if len(c.Symbols) == 0 {
+        check.addf("symbols", "must list at least one symbol")
+    }
+    if c.DegradedInterval != 0 && c.DegradedInterval < c.UpdateInterval {
+        check.addf("degraded_interval", "must not be shorter than update_interval (%v), got %v", c.UpdateInterval, c.DegradedInterval)
+    }
+    collected := make(map[string]bool, len(c.Symbols))
+    for _, s := range c.Symbols {
+        collected[s] = true
+    }
+    for i, s := range c.DegradedSymbols {
+        if !collected[s] {
+            check.addf(fmt.Sprintf("degraded_symbols[%d]", i), "%s is not in symbols", s)
+        }
+    }
+    return check.err()
+}
+
+// Validate checks the TLS mode and delivery limits, and the SMTP settings
+// when there is a host to send to
+func (c MailConfig) Validate() error {
+    var check checker
+    if c.Host != "" {
+        check.port("port", c.Port)
+        check.required("from", c.From)
+    }
+    check.oneOf("tls", c.TLS, "starttls", "tls", "none")
+    if c.MaxAttempts < 1 {
+        check.addf("max_attempts", "must be at least 1, got %d", c.MaxAttempts)
+    }
+    if c.RecipientHourlyLimit < 1 {
+        check.addf("recipient_hourly_limit", "must be at least 1, got %d", c.RecipientHourlyLimit)
+    }
+    return check.err()
+}
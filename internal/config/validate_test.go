@@ -0,0 +1,209 @@
+package config
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "gopkg.in/yaml.v2"
+)
+
+// validConfig is the example config, which must pass validation
+func validConfig(t *testing.T) *Config {
+    cfg, err := Parse(filepath.Join("..", "..", "config", "app.example.yaml"))
+    if err != nil {
+        t.Fatalf("Failed to parse example config: %v", err)
+    }
+    return cfg
+}
+
+// violationPaths returns the paths of err's violations
+func violationPaths(t *testing.T, err error) []string {
+    var verr *ValidationError
+    if !errors.As(err, &verr) {
+        t.Fatalf("Expected a *ValidationError, got %v", err)
+    }
+    paths := make([]string, len(verr.Violations))
+    for i, v := range verr.Violations {
+        paths[i] = v.Path
+    }
+    return paths
+}
+
+func TestConfig_Validate(t *testing.T) {
+    assert.NoError(t, validConfig(t).Validate())
+
+    tests := []struct {
+        name   string
+        modify func(c *Config)
+        paths  []string
+    }{
+        {
+            name: "Every section at once",
+            modify: func(c *Config) {
+                c.App.Port = 0
+                c.Database.Host = ""
+                c.Auth.JWTSecret = ""
+                c.ML.BatchSize = 0
+                c.Redis.Port = 70000
+                c.Cache.TTL = 0
+                c.Services.MarketData.APIKey = ""
+            },
+            paths: []string{
+                "app.port",
+                "database.host",
+                "auth.jwt_secret",
+                "ml.batch_size",
+                "redis.port",
+                "cache.ttl",
+                "services.market_data.api_key",
+            },
+        },
+        {
+            name: "Ranges",
+            modify: func(c *Config) {
+                c.ML.BatchSize = 1001
+                c.ML.UpdateInterval = 500 * time.Millisecond
+                c.Services.MarketData.UpdateInterval = -time.Minute
+                c.Services.MarketData.CollectionJitter = -time.Second
+                c.Cache.LocalSize = -1
+                c.Cache.LocalTTL = time.Minute
+                c.Cache.FlushConfirmAbove = -1
+                c.Mail.MaxAttempts = -1
+            },
+            paths: []string{
+                "ml.update_interval",
+                "ml.batch_size",
+                "cache.local_size",
+                "cache.local_ttl",
+                "cache.flush_confirm_above",
+                "services.market_data.update_interval",
+                "services.market_data.collection_jitter",
+                "mail.max_attempts",
+            },
+        },
+        {
+            name: "Cross-field consistency",
+            modify: func(c *Config) {
+                c.Auth.RefreshExpiry = c.Auth.TokenExpiry
+                c.App.MaxPositionValueUSD = 2 * c.App.MaxPortfolioValueUSD
+                c.Services.MarketData.CollectionJitter = c.Services.MarketData.UpdateInterval
+                c.Services.MarketData.DegradedInterval = time.Minute
+                c.Services.MarketData.DegradedSymbols = []string{"SPY", "DOGE"}
+            },
+            paths: []string{
+                "app.max_position_value_usd",
+                "auth.refresh_expiry",
+                "services.market_data.collection_jitter",
+                "services.market_data.degraded_interval",
+                "services.market_data.degraded_symbols[1]",
+            },
+        },
+        {
+            name: "Nested sections",
+            modify: func(c *Config) {
+                c.ML.ModelPath = ""
+                c.ML.Artifacts.Backend = "s3"
+                c.ML.Artifacts.Bucket = ""
+                c.ML.Artifacts.Endpoint = ""
+                c.Database.SSLMode = "sometimes"
+            },
+            paths: []string{
+                "database.sslmode",
+                "ml.model_path",
+                "ml.artifacts.endpoint",
+                "ml.artifacts.bucket",
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg := validConfig(t)
+            tt.modify(cfg)
+            assert.Equal(t, tt.paths, violationPaths(t, cfg.Validate()))
+        })
+    }
+}
+
+func TestValidationError_ListsEveryProblem(t *testing.T) {
+    cfg := validConfig(t)
+    cfg.Auth.TokenExpiry = 2 * time.Hour
+    cfg.Auth.RefreshExpiry = time.Hour
+    cfg.Cache.TTL = 0
+
+    assert.EqualError(t, cfg.Validate(), "invalid config, 2 problems:\n"+
+        "  auth.refresh_expiry: must be longer than token_expiry (2h0m0s), got 1h0m0s\n"+
+        "  cache.ttl: must be positive, got 0s")
+}
+
+func TestLoad_ReportsAllViolations(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "app.yaml")
+    contents := `
+app:
+  port: 8080
+database:
+  host: localhost
+  port: 5432
+  name: wolfai
+  user: postgres
+auth:
+  jwt_secret: secret
+  token_expiry: 24h
+  refresh_expiry: 1h
+ml:
+  model_path: ./models
+  update_interval: 0s
+  batch_size: 0
+redis:
+  host: localhost
+  port: 6379
+cache:
+  ttl: 0s
+services:
+  market_data:
+    provider: alphavantage
+    api_key: key
+    update_interval: 5m
+    symbols: [SPY]
+`
+    if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+        t.Fatalf("Failed to write config: %v", err)
+    }
+
+    cfg, err := Load(path)
+    assert.Nil(t, cfg)
+    assert.Equal(t, []string{
+        "auth.refresh_expiry",
+        "ml.update_interval",
+        "ml.batch_size",
+        "cache.ttl",
+    }, violationPaths(t, err))
+}
+
+func TestConfig_Redacted(t *testing.T) {
+    cfg := validConfig(t)
+    cfg.Redis.Password = ""
+    cfg.ML.Artifacts.SecretKey = "s3-secret"
+
+    redacted := cfg.Redacted()
+    assert.Equal(t, redactedSecret, redacted.Database.Password)
+    assert.Equal(t, redactedSecret, redacted.Auth.JWTSecret)
+    assert.Equal(t, redactedSecret, redacted.Services.MarketData.APIKey)
+    assert.Equal(t, redactedSecret, redacted.ML.Artifacts.SecretKey)
+    // A missing secret still shows as missing
+    assert.Empty(t, redacted.Redis.Password)
+    // The original is left as it was
+    assert.Equal(t, "your-secret-key", cfg.Auth.JWTSecret)
+
+    out, err := yaml.Marshal(redacted)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.NotContains(t, string(out), "your-secret-key")
+    assert.NotContains(t, string(out), "s3-secret")
+    assert.Contains(t, string(out), "token_expiry: 24h0m0s")
+}
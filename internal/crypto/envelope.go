@@ -0,0 +1,240 @@
+// Package crypto provides envelope encryption for secrets stored at rest:
+// 2FA secrets, provider credentials in config, and any future webhook
+// secrets or peppers. Every value is encrypted with its own random data key,
+// which is in turn wrapped by a master key. Ciphertexts name the master key
+// they were wrapped with, so keys can be rotated without downtime.
+package crypto
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// ciphertextPrefix marks an encrypted value and its format version
+const ciphertextPrefix = "wolfenc:v1:"
+
+const keySize = 32
+
+var (
+    ErrMalformedCiphertext = errors.New("malformed ciphertext")
+    ErrUnknownKey          = errors.New("unknown encryption key")
+    ErrAuthentication      = errors.New("ciphertext failed authentication")
+)
+
+var b64 = base64.RawURLEncoding
+
+// DecryptError is returned for every decryption failure. Reason is one of
+// ErrMalformedCiphertext, ErrUnknownKey or ErrAuthentication.
+type DecryptError struct {
+    KeyID  string
+    Reason error
+}
+
+func (e *DecryptError) Error() string {
+    if e.KeyID == "" {
+        return "decrypt: " + e.Reason.Error()
+    }
+    return fmt.Sprintf("decrypt with key %q: %v", e.KeyID, e.Reason)
+}
+
+func (e *DecryptError) Unwrap() error {
+    return e.Reason
+}
+
+// Keyring holds the master keys. New values are always wrapped with the
+// primary key; the others are kept so older values stay readable.
+type Keyring struct {
+    primary string
+    keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from 32-byte AES-256 master keys by ID
+func NewKeyring(primaryID string, keys map[string][]byte) (*Keyring, error) {
+    if _, ok := keys[primaryID]; !ok {
+        return nil, fmt.Errorf("primary key %q not in keyring", primaryID)
+    }
+
+    k := &Keyring{primary: primaryID, keys: make(map[string]cipher.AEAD, len(keys))}
+    for id, key := range keys {
+        if id == "" || strings.Contains(id, ":") {
+            return nil, fmt.Errorf("invalid key ID %q", id)
+        }
+        aead, err := newAEAD(key)
+        if err != nil {
+            return nil, fmt.Errorf("key %q: %w", id, err)
+        }
+        k.keys[id] = aead
+    }
+    return k, nil
+}
+
+// ParseKeys parses master keys written as id:base64key, separated by commas
+// or newlines
+func ParseKeys(spec string) (map[string][]byte, error) {
+    keys := make(map[string][]byte)
+    for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        parts := strings.SplitN(entry, ":", 2)
+        if len(parts) != 2 {
+            return nil, fmt.Errorf("key entry must be id:base64key")
+        }
+        key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+        if err != nil {
+            return nil, fmt.Errorf("key %q is not valid base64: %w", parts[0], err)
+        }
+        keys[strings.TrimSpace(parts[0])] = key
+    }
+    return keys, nil
+}
+
+// LoadKeyring builds a keyring from a key spec, or from the file at
+// keysFile when it is set. It returns nil if no keys are configured.
+func LoadKeyring(primaryID, spec, keysFile string) (*Keyring, error) {
+    if keysFile != "" {
+        data, err := os.ReadFile(keysFile)
+        if err != nil {
+            return nil, err
+        }
+        spec = string(data)
+    }
+    if strings.TrimSpace(spec) == "" {
+        return nil, nil
+    }
+
+    keys, err := ParseKeys(spec)
+    if err != nil {
+        return nil, err
+    }
+    return NewKeyring(primaryID, keys)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    if len(key) != keySize {
+        return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether value has the ciphertext format. It does not
+// check that the value can be decrypted.
+func IsEncrypted(value string) bool {
+    return strings.HasPrefix(value, ciphertextPrefix)
+}
+
+// Encrypt seals plaintext under a fresh data key wrapped by the primary
+// key. associatedData binds the ciphertext to its context, such as the row
+// it belongs to, and must be passed again to Decrypt.
+func (k *Keyring) Encrypt(plaintext, associatedData []byte) (string, error) {
+    dataKey := make([]byte, keySize)
+    if _, err := rand.Read(dataKey); err != nil {
+        return "", err
+    }
+    dataAEAD, err := newAEAD(dataKey)
+    if err != nil {
+        return "", err
+    }
+
+    wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+    if err != nil {
+        return "", err
+    }
+    sealed, err := seal(dataAEAD, plaintext, associatedData)
+    if err != nil {
+        return "", err
+    }
+
+    return ciphertextPrefix + k.primary + ":" + b64.EncodeToString(wrapped) + ":" + b64.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Anything that isn't a valid
+// ciphertext is an error; values are never passed through as plaintext.
+func (k *Keyring) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+    keyID, wrapped, sealed, err := parseCiphertext(ciphertext)
+    if err != nil {
+        return nil, err
+    }
+
+    master, ok := k.keys[keyID]
+    if !ok {
+        return nil, &DecryptError{KeyID: keyID, Reason: ErrUnknownKey}
+    }
+
+    dataKey, err := open(master, wrapped, []byte(keyID))
+    if err != nil {
+        return nil, &DecryptError{KeyID: keyID, Reason: ErrAuthentication}
+    }
+    dataAEAD, err := newAEAD(dataKey)
+    if err != nil {
+        return nil, &DecryptError{KeyID: keyID, Reason: ErrMalformedCiphertext}
+    }
+
+    plaintext, err := open(dataAEAD, sealed, associatedData)
+    if err != nil {
+        return nil, &DecryptError{KeyID: keyID, Reason: ErrAuthentication}
+    }
+    return plaintext, nil
+}
+
+// KeyID returns the ID of the master key a ciphertext was wrapped with
+func KeyID(ciphertext string) (string, error) {
+    keyID, _, _, err := parseCiphertext(ciphertext)
+    return keyID, err
+}
+
+// NeedsRotation reports whether ciphertext was wrapped with a key other
+// than the primary
+func (k *Keyring) NeedsRotation(ciphertext string) bool {
+    keyID, err := KeyID(ciphertext)
+    return err != nil || keyID != k.primary
+}
+
+func parseCiphertext(ciphertext string) (string, []byte, []byte, error) {
+    if !IsEncrypted(ciphertext) {
+        return "", nil, nil, &DecryptError{Reason: ErrMalformedCiphertext}
+    }
+
+    parts := strings.Split(strings.TrimPrefix(ciphertext, ciphertextPrefix), ":")
+    if len(parts) != 3 || parts[0] == "" {
+        return "", nil, nil, &DecryptError{Reason: ErrMalformedCiphertext}
+    }
+
+    wrapped, err := b64.DecodeString(parts[1])
+    if err != nil {
+        return "", nil, nil, &DecryptError{KeyID: parts[0], Reason: ErrMalformedCiphertext}
+    }
+    sealed, err := b64.DecodeString(parts[2])
+    if err != nil {
+        return "", nil, nil, &DecryptError{KeyID: parts[0], Reason: ErrMalformedCiphertext}
+    }
+    return parts[0], wrapped, sealed, nil
+}
+
+// seal returns nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(aead cipher.AEAD, data, associatedData []byte) ([]byte, error) {
+    n := aead.NonceSize()
+    if len(data) < n {
+        return nil, ErrMalformedCiphertext
+    }
+    return aead.Open(nil, data[:n], data[n:], associatedData)
+}
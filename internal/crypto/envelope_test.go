@@ -0,0 +1,148 @@
+package crypto
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+func testKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+    keys := make(map[string][]byte)
+    for i, id := range ids {
+        keys[id] = bytes.Repeat([]byte{byte(i + 1)}, keySize)
+    }
+    k, err := NewKeyring(primary, keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return k
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+    k := testKeyring(t, "k1", "k1")
+    aad := []byte("totp:1")
+
+    ct, err := k.Encrypt([]byte("secret"), aad)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assert.True(t, IsEncrypted(ct))
+    assert.NotContains(t, ct, "secret")
+
+    id, err := KeyID(ct)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assert.Equal(t, "k1", id)
+
+    pt, err := k.Decrypt(ct, aad)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assert.Equal(t, "secret", string(pt))
+
+    t.Run("wrong associated data", func(t *testing.T) {
+        _, err := k.Decrypt(ct, []byte("totp:2"))
+        assert.True(t, errors.Is(err, ErrAuthentication))
+    })
+
+    t.Run("tampered", func(t *testing.T) {
+        tampered := ct[:len(ct)-2] + "AA"
+        if tampered == ct {
+            tampered = ct[:len(ct)-2] + "BB"
+        }
+        _, err := k.Decrypt(tampered, aad)
+        assert.Error(t, err)
+    })
+
+    t.Run("unknown key", func(t *testing.T) {
+        other := testKeyring(t, "k2", "k2")
+        _, err := other.Decrypt(ct, aad)
+        var decryptErr *DecryptError
+        if !assert.True(t, errors.As(err, &decryptErr)) {
+            return
+        }
+        assert.Equal(t, "k1", decryptErr.KeyID)
+        assert.True(t, errors.Is(err, ErrUnknownKey))
+    })
+
+    t.Run("plaintext is rejected", func(t *testing.T) {
+        _, err := k.Decrypt("secret", aad)
+        assert.True(t, errors.Is(err, ErrMalformedCiphertext))
+    })
+}
+
+func TestNewKeyring_Validation(t *testing.T) {
+    _, err := NewKeyring("missing", map[string][]byte{"k1": make([]byte, keySize)})
+    assert.Error(t, err)
+
+    _, err = NewKeyring("k1", map[string][]byte{"k1": make([]byte, 16)})
+    assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+    keys, err := ParseKeys("k1:" + strings.Repeat("A", 43) + "=,\nk2:" + strings.Repeat("B", 43) + "=\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    assert.Len(t, keys, 2)
+    assert.Len(t, keys["k1"], keySize)
+
+    _, err = ParseKeys("no-separator")
+    assert.Error(t, err)
+}
+
+func TestKeyring_NeedsRotation(t *testing.T) {
+    old := testKeyring(t, "k1", "k1", "k2")
+    ct, err := old.Encrypt([]byte("secret"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    rotated := testKeyring(t, "k2", "k1", "k2")
+    assert.True(t, rotated.NeedsRotation(ct))
+    assert.False(t, old.NeedsRotation(ct))
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    aad := func(id string) []byte { return []byte("totp:" + id) }
+    old := testKeyring(t, "k1", "k1", "k2")
+    stale, err := old.Encrypt([]byte("secret"), aad("1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    k := testKeyring(t, "k2", "k1", "k2")
+    current, err := k.Encrypt([]byte("other"), aad("2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    mock.ExpectQuery("SELECT id::text, totp_secret FROM users").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).
+            AddRow("1", []byte(stale)).
+            AddRow("2", []byte(current)))
+    mock.ExpectExec("UPDATE users SET totp_secret").
+        WithArgs(sqlmock.AnyArg(), "1", []byte(stale)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    n, err := k.Rotate(context.Background(), db, Column{
+        Table: "users", IDColumn: "id", Column: "totp_secret", AssociatedData: aad,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    assert.Equal(t, 1, n)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+)
+
+// Column describes a database column holding values from Keyring.Encrypt
+type Column struct {
+    Table    string
+    IDColumn string
+    Column   string
+    // AssociatedData returns the data the value was bound to for a row ID
+    AssociatedData func(id string) []byte
+}
+
+// Rotate re-encrypts every value in col that is not wrapped with the
+// keyring's primary key and returns the number of rows updated. Each row is
+// updated only if it still holds the value that was read, so concurrent
+// writes are not overwritten. A value that fails to decrypt aborts the run.
+func (k *Keyring) Rotate(ctx context.Context, db *sql.DB, col Column) (int, error) {
+    query := fmt.Sprintf(
+        "SELECT %s::text, %s FROM %s WHERE %s IS NOT NULL",
+        col.IDColumn, col.Column, col.Table, col.Column,
+    )
+    rows, err := db.QueryContext(ctx, query)
+    if err != nil {
+        return 0, err
+    }
+
+    type row struct {
+        id    string
+        value string
+    }
+    var stale []row
+    for rows.Next() {
+        var r row
+        var value []byte
+        if err := rows.Scan(&r.id, &value); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        r.value = string(value)
+        if k.NeedsRotation(r.value) {
+            stale = append(stale, r)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    update := fmt.Sprintf(
+        "UPDATE %s SET %s = $1 WHERE %s::text = $2 AND %s = $3",
+        col.Table, col.Column, col.IDColumn, col.Column,
+    )
+
+    rotated := 0
+    for _, r := range stale {
+        aad := col.AssociatedData(r.id)
+        plaintext, err := k.Decrypt(r.value, aad)
+        if err != nil {
+            return rotated, fmt.Errorf("%s.%s row %s: %w", col.Table, col.Column, r.id, err)
+        }
+        reencrypted, err := k.Encrypt(plaintext, aad)
+        if err != nil {
+            return rotated, err
+        }
+
+        result, err := db.ExecContext(ctx, update, []byte(reencrypted), r.id, []byte(r.value))
+        if err != nil {
+            return rotated, fmt.Errorf("%s.%s row %s: %w", col.Table, col.Column, r.id, err)
+        }
+        if n, err := result.RowsAffected(); err == nil && n > 0 {
+            rotated++
+        }
+    }
+
+    return rotated, nil
+}
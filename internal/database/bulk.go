@@ -0,0 +1,187 @@
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+const (
+    // bulkUpsertBatchSize is the most rows sent in one statement
+    bulkUpsertBatchSize = 500
+    // maxBindParams is Postgres' limit on parameters in one statement
+    maxBindParams = 65535
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// BulkUpsert inserts rows in batches of up to 500 using multi-row INSERT
+// statements. Rows that conflict on conflictCols have updateCols replaced
+// with the new values, or are skipped if updateCols is empty. All batches
+// run in one transaction. It returns the number of rows inserted or
+// updated.
+//
+// A statement can't update the same row twice, so when rows within a batch
+// share conflict keys only the last of them is kept.
+//
+// With a pgx pool the rows are sent with COPY instead, through a staging
+// table upserted from in one statement; see copyUpsert.
+func (db *DB) BulkUpsert(
+    ctx context.Context,
+    table string,
+    columns []string,
+    rows [][]interface{},
+    conflictCols []string,
+    updateCols []string,
+) (int64, error) {
+    if len(rows) == 0 {
+        return 0, nil
+    }
+    if err := validateUpsert(table, columns, rows, conflictCols, updateCols); err != nil {
+        return 0, err
+    }
+
+    batchSize := bulkUpsertBatchSize
+    if limit := maxBindParams / len(columns); limit < batchSize {
+        batchSize = limit
+    }
+
+    conflictIdx := columnIndexes(columns, conflictCols)
+    suffix := upsertSuffix(conflictCols, updateCols)
+    if db.pool != nil {
+        return db.copyUpsert(ctx, table, columns, dedupeByKey(rows, conflictIdx), suffix)
+    }
+
+    var total int64
+    err := db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        for start := 0; start < len(rows); start += batchSize {
+            end := start + batchSize
+            if end > len(rows) {
+                end = len(rows)
+            }
+
+            batch := dedupeByKey(rows[start:end], conflictIdx)
+            query, args := buildUpsert(table, columns, batch, suffix)
+            result, err := tx.ExecContext(ctx, query, args...)
+            if err != nil {
+                return fmt.Errorf("upsert %s rows %d-%d: %w", table, start, end-1, err)
+            }
+            n, err := result.RowsAffected()
+            if err != nil {
+                return err
+            }
+            total += n
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+    return total, nil
+}
+
+func validateUpsert(table string, columns []string, rows [][]interface{}, conflictCols, updateCols []string) error {
+    if !identifierPattern.MatchString(table) {
+        return fmt.Errorf("invalid table name %q", table)
+    }
+    if len(columns) == 0 {
+        return fmt.Errorf("at least one column required")
+    }
+
+    known := make(map[string]bool, len(columns))
+    for _, col := range columns {
+        if !identifierPattern.MatchString(col) || strings.Contains(col, ".") {
+            return fmt.Errorf("invalid column name %q", col)
+        }
+        known[col] = true
+    }
+    for _, col := range append(append([]string{}, conflictCols...), updateCols...) {
+        if !known[col] {
+            return fmt.Errorf("column %q is not being inserted", col)
+        }
+    }
+    if len(updateCols) > 0 && len(conflictCols) == 0 {
+        return fmt.Errorf("update columns require conflict columns")
+    }
+
+    for i, row := range rows {
+        if len(row) != len(columns) {
+            return fmt.Errorf("row %d has %d values, expected %d", i, len(row), len(columns))
+        }
+    }
+    return nil
+}
+
+func upsertSuffix(conflictCols, updateCols []string) string {
+    if len(conflictCols) == 0 {
+        return ""
+    }
+    if len(updateCols) == 0 {
+        return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(conflictCols, ", "))
+    }
+
+    sets := make([]string, len(updateCols))
+    for i, col := range updateCols {
+        sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+    }
+    return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(conflictCols, ", "), strings.Join(sets, ", "))
+}
+
+func buildUpsert(table string, columns []string, rows [][]interface{}, suffix string) (string, []interface{}) {
+    qb := NewQueryBuilder()
+
+    values := make([]string, len(rows))
+    placeholders := make([]string, len(columns))
+    for i, row := range rows {
+        for j, value := range row {
+            placeholders[j] = qb.Arg(value)
+        }
+        values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+    }
+
+    return qb.Build(fmt.Sprintf(
+        "INSERT INTO %s (%s) VALUES %s%s",
+        table, strings.Join(columns, ", "), strings.Join(values, ", "), suffix,
+    ))
+}
+
+func columnIndexes(columns, names []string) []int {
+    idx := make([]int, len(names))
+    for i, name := range names {
+        for j, col := range columns {
+            if col == name {
+                idx[i] = j
+                break
+            }
+        }
+    }
+    return idx
+}
+
+// dedupeByKey keeps the last row for each conflict key, in the order the
+// keys first appeared
+func dedupeByKey(rows [][]interface{}, keyIdx []int) [][]interface{} {
+    if len(keyIdx) == 0 {
+        return rows
+    }
+
+    positions := make(map[string]int, len(rows))
+    out := make([][]interface{}, 0, len(rows))
+    for _, row := range rows {
+        parts := make([]string, len(keyIdx))
+        for i, idx := range keyIdx {
+            parts[i] = fmt.Sprintf("%T:%v", row[idx], row[idx])
+        }
+        key := strings.Join(parts, "\x00")
+
+        if pos, ok := positions[key]; ok {
+            out[pos] = row
+            continue
+        }
+        positions[key] = len(out)
+        out = append(out, row)
+    }
+    return out
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+var ohlcvColumns = []string{"symbol", "timestamp", "open", "high", "low", "close", "volume"}
+
+func ohlcvRows(n int, start time.Time) [][]interface{} {
+    rows := make([][]interface{}, n)
+    for i := range rows {
+        rows[i] = []interface{}{"BTC", start.Add(time.Duration(i) * time.Minute), 1.0, 2.0, 0.5, 1.5, 100.0}
+    }
+    return rows
+}
+
+func TestBulkUpsert(t *testing.T) {
+    dbConn, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer dbConn.Close()
+    db := New(dbConn)
+    ctx := context.Background()
+
+    t.Run("Inserted and updated rows are both counted", func(t *testing.T) {
+        // 1000 rows, the first 200 of which are already stored. Postgres
+        // reports one affected row per insert and per update.
+        rows := ohlcvRows(1000, time.Now().Add(-24*time.Hour))
+
+        mock.ExpectBegin()
+        mock.ExpectExec(`INSERT INTO market_data \(symbol, timestamp, open, high, low, close, volume\) VALUES \(\$1, \$2, .+\), \(\$8, .+ ON CONFLICT \(symbol, timestamp\) DO UPDATE SET open = EXCLUDED.open`).
+            WillReturnResult(sqlmock.NewResult(0, 500))
+        mock.ExpectExec(`INSERT INTO market_data .+\$3500\) ON CONFLICT`).
+            WillReturnResult(sqlmock.NewResult(0, 500))
+        mock.ExpectCommit()
+
+        n, err := db.BulkUpsert(ctx, "market_data", ohlcvColumns, rows,
+            []string{"symbol", "timestamp"}, []string{"open", "high", "low", "close", "volume"})
+        assert.NoError(t, err)
+        assert.Equal(t, int64(1000), n)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Failed batch rolls back", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectExec("INSERT INTO market_data").WillReturnResult(sqlmock.NewResult(0, 500))
+        mock.ExpectExec("INSERT INTO market_data").WillReturnError(fmt.Errorf("connection reset"))
+        mock.ExpectRollback()
+
+        _, err := db.BulkUpsert(ctx, "market_data", ohlcvColumns, ohlcvRows(600, time.Now()),
+            []string{"symbol", "timestamp"}, []string{"close"})
+        assert.Error(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Invalid identifiers are rejected", func(t *testing.T) {
+        _, err := db.BulkUpsert(ctx, "market_data; DROP TABLE users", ohlcvColumns, ohlcvRows(1, time.Now()), nil, nil)
+        assert.Error(t, err)
+
+        _, err = db.BulkUpsert(ctx, "market_data", ohlcvColumns, ohlcvRows(1, time.Now()),
+            []string{"symbol"}, []string{"missing"})
+        assert.Error(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestBuildUpsert(t *testing.T) {
+    rows := [][]interface{}{{"BTC", 1}, {"ETH", 2}}
+    query, args := buildUpsert("prices", []string{"symbol", "price"}, rows, upsertSuffix([]string{"symbol"}, nil))
+
+    assert.Equal(t, "INSERT INTO prices (symbol, price) VALUES ($1, $2), ($3, $4) ON CONFLICT (symbol) DO NOTHING", query)
+    assert.Equal(t, []interface{}{"BTC", 1, "ETH", 2}, args)
+}
+
+func TestDedupeByKey(t *testing.T) {
+    rows := [][]interface{}{{"BTC", 1}, {"ETH", 2}, {"BTC", 3}}
+    deduped := dedupeByKey(rows, []int{0})
+
+    assert.Equal(t, [][]interface{}{{"BTC", 3}, {"ETH", 2}}, deduped)
+}
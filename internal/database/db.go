@@ -5,14 +5,19 @@ import (
     "database/sql"
     "fmt"
     "strings"
+
+    "github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DB wraps the database/sql connection, with an optional pgx pool that
+// array scans and bulk writes use when set; see WithPool
 type DB struct {
     *sql.DB
+    pool *pgxpool.Pool
 }
 
 func New(db *sql.DB) *DB {
-    return &DB{db}
+    return &DB{DB: db}
 }
 
 func (db *DB) ExecSafe(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
@@ -71,8 +76,14 @@ func (qb *QueryBuilder) AddParam(name string, value interface{}) {
     qb.params[name] = value
 }
 
+// Arg binds value positionally and returns its placeholder
+func (qb *QueryBuilder) Arg(value interface{}) string {
+    qb.args = append(qb.args, value)
+    return fmt.Sprintf("$%d", len(qb.args))
+}
+
 func (qb *QueryBuilder) Build(baseQuery string) (string, []interface{}) {
-    paramCount := 1
+    paramCount := len(qb.args) + 1
     query := baseQuery
 
     for name, value := range qb.params {
@@ -0,0 +1,165 @@
+//go:build integration
+
+package database
+
+import (
+    "context"
+    "database/sql"
+    "testing"
+    "time"
+
+    _ "github.com/lib/pq"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/testcontainers/testcontainers-go"
+    "github.com/testcontainers/testcontainers-go/modules/postgres"
+    "github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Needs Docker:
+//   go test -tags integration ./internal/database/
+//
+// Every case runs once per driver, so the pgx paths can't drift from the
+// database/sql ones.
+func TestDrivers(t *testing.T) {
+    ctx := context.Background()
+    container, err := postgres.RunContainer(ctx,
+        testcontainers.WithImage("postgres:15-alpine"),
+        postgres.WithDatabase("wolfai"),
+        postgres.WithUsername("wolfai"),
+        postgres.WithPassword("wolfai"),
+        testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+            WithOccurrence(2).WithStartupTimeout(time.Minute)),
+    )
+    if err != nil {
+        t.Fatalf("Failed to start postgres: %v", err)
+    }
+    t.Cleanup(func() { container.Terminate(ctx) })
+
+    dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+    if err != nil {
+        t.Fatalf("Failed to get connection string: %v", err)
+    }
+    conn, err := sql.Open("postgres", dsn)
+    if err != nil {
+        t.Fatalf("Failed to connect: %v", err)
+    }
+    defer conn.Close()
+    pool, err := NewPool(ctx, dsn)
+    if err != nil {
+        t.Fatalf("Failed to connect pgx pool: %v", err)
+    }
+    defer pool.Close()
+
+    _, err = conn.ExecContext(ctx, `
+        CREATE TABLE market_data (
+            id BIGSERIAL PRIMARY KEY,
+            symbol VARCHAR(20) NOT NULL,
+            timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+            open DECIMAL(20, 8) NOT NULL,
+            high DECIMAL(20, 8) NOT NULL,
+            low DECIMAL(20, 8) NOT NULL,
+            close DECIMAL(20, 8) NOT NULL,
+            volume DECIMAL(30, 8) NOT NULL,
+            UNIQUE (symbol, timestamp)
+        );
+        CREATE TABLE market_analysis (
+            asset_symbol VARCHAR(50) PRIMARY KEY,
+            indicators JSONB
+        );
+    `)
+    if err != nil {
+        t.Fatalf("Failed to create schema: %v", err)
+    }
+
+    for _, driver := range []struct {
+        name string
+        db   *DB
+    }{
+        {DriverPQ, New(conn)},
+        {DriverPgx, New(conn).WithPool(pool)},
+    } {
+        db := driver.db
+        t.Run(driver.name, func(t *testing.T) {
+            _, err := conn.ExecContext(ctx, "TRUNCATE market_data, market_analysis")
+            require.NoError(t, err)
+            start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+            t.Run("BulkUpsert inserts, updates and keeps the last duplicate", func(t *testing.T) {
+                n, err := db.BulkUpsert(ctx, "market_data", ohlcvColumns, ohlcvRows(1200, start),
+                    []string{"symbol", "timestamp"}, []string{"open", "high", "low", "close", "volume"})
+                require.NoError(t, err)
+                assert.Equal(t, int64(1200), n)
+
+                // 200 stored rows change, one of them twice, and 100 are new
+                rows := ohlcvRows(300, start.Add(1000*time.Minute))
+                for _, row := range rows {
+                    row[5] = 9.0
+                }
+                last := append([]interface{}(nil), rows[0]...)
+                last[5] = 10.0
+                rows = append(rows, last)
+                n, err = db.BulkUpsert(ctx, "market_data", ohlcvColumns, rows,
+                    []string{"symbol", "timestamp"}, []string{"close"})
+                require.NoError(t, err)
+                assert.Equal(t, int64(300), n)
+
+                var count int
+                var total float64
+                require.NoError(t, conn.QueryRowContext(ctx, "SELECT COUNT(*), SUM(close) FROM market_data").Scan(&count, &total))
+                assert.Equal(t, 1300, count)
+                assert.InDelta(t, 1000*1.5+299*9.0+10.0, total, 1e-9)
+
+                // Without update columns conflicts are skipped
+                n, err = db.BulkUpsert(ctx, "market_data", ohlcvColumns, ohlcvRows(2, start),
+                    []string{"symbol", "timestamp"}, nil)
+                require.NoError(t, err)
+                assert.Equal(t, int64(0), n)
+            })
+
+            t.Run("CopyFrom inserts", func(t *testing.T) {
+                rows := ohlcvRows(50, start)
+                for _, row := range rows {
+                    row[0] = "ETH"
+                }
+                n, err := db.CopyFrom(ctx, "market_data", ohlcvColumns, rows)
+                require.NoError(t, err)
+                assert.Equal(t, int64(50), n)
+
+                // Inserting them again violates the unique key
+                _, err = db.CopyFrom(ctx, "market_data", ohlcvColumns, rows)
+                assert.Error(t, err)
+            })
+
+            t.Run("QueryFloat64Arrays aggregates arrays", func(t *testing.T) {
+                arrays, err := db.QueryFloat64Arrays(ctx, `
+                    SELECT symbol, ARRAY_AGG(close::float8 ORDER BY timestamp)
+                    FROM market_data
+                    WHERE symbol = ANY($1) AND timestamp < $2
+                    GROUP BY symbol
+                `, []string{"ETH", "SOL"}, start.Add(3*time.Minute))
+                require.NoError(t, err)
+                assert.Equal(t, map[string][]float64{"ETH": {1.5, 1.5, 1.5}}, arrays)
+            })
+
+            t.Run("JSONB round trips", func(t *testing.T) {
+                indicators := map[string]float64{"rsi": 71.5, "macd": -0.2}
+                _, err := conn.ExecContext(ctx, "INSERT INTO market_analysis (asset_symbol, indicators) VALUES ($1, $2)",
+                    "BTC", JSONB{V: indicators})
+                require.NoError(t, err)
+
+                var read map[string]float64
+                require.NoError(t, conn.QueryRowContext(ctx, "SELECT indicators FROM market_analysis WHERE asset_symbol = $1", "BTC").
+                    Scan(&JSONB{V: &read}))
+                assert.Equal(t, indicators, read)
+
+                if pool := db.Pool(); pool != nil {
+                    read = nil
+                    require.NoError(t, pool.QueryRow(ctx, "SELECT indicators FROM market_analysis WHERE asset_symbol = $1", "BTC").
+                        Scan(&JSONB{V: &read}))
+                    assert.Equal(t, indicators, read)
+                }
+            })
+        })
+    }
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Drivers the database is reached through. Everything runs over
+// database/sql with lib/pq; pgx adds a native pool that array scans and
+// bulk writes go through instead.
+const (
+    DriverPQ  = "pq"
+    DriverPgx = "pgx"
+)
+
+// NewPool connects a pgx pool to the database at url
+func NewPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+    pool, err := pgxpool.New(ctx, url)
+    if err != nil {
+        return nil, fmt.Errorf("create pgx pool: %w", err)
+    }
+    if err := pool.Ping(ctx); err != nil {
+        pool.Close()
+        return nil, fmt.Errorf("ping pgx pool: %w", err)
+    }
+    return pool, nil
+}
+
+// WithPool sends array scans and bulk writes through pool. With a nil pool
+// they stay on database/sql, which is what sqlmock tests exercise.
+func (db *DB) WithPool(pool *pgxpool.Pool) *DB {
+    db.pool = pool
+    return db
+}
+
+// Pool returns the pgx pool, nil when there is none
+func (db *DB) Pool() *pgxpool.Pool {
+    return db.pool
+}
+
+// CopyFrom inserts rows into table with COPY through the pgx pool, or with
+// batched multi-row INSERTs without one. It returns the number of rows
+// inserted.
+func (db *DB) CopyFrom(ctx context.Context, table string, columns []string, rows [][]interface{}) (int64, error) {
+    if len(rows) == 0 {
+        return 0, nil
+    }
+    if err := validateUpsert(table, columns, rows, nil, nil); err != nil {
+        return 0, err
+    }
+    if db.pool == nil {
+        return db.BulkUpsert(ctx, table, columns, rows, nil, nil)
+    }
+
+    n, err := db.pool.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+    if err != nil {
+        return 0, fmt.Errorf("copy %s rows: %w", table, err)
+    }
+    return n, nil
+}
+
+// copyUpsert is BulkUpsert through the pgx pool: rows are copied into a
+// temporary table shaped like columns of table, then upserted from it in
+// one statement. rows must already be deduplicated on their conflict keys.
+func (db *DB) copyUpsert(ctx context.Context, table string, columns []string, rows [][]interface{}, suffix string) (int64, error) {
+    tx, err := db.pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    staging := "bulk_upsert_" + strings.ReplaceAll(table, ".", "_")
+    cols := strings.Join(columns, ", ")
+    _, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", staging, cols, table))
+    if err != nil {
+        return 0, fmt.Errorf("create staging table for %s: %w", table, err)
+    }
+    if _, err := tx.CopyFrom(ctx, pgx.Identifier{staging}, columns, pgx.CopyFromRows(rows)); err != nil {
+        return 0, fmt.Errorf("copy %s rows: %w", table, err)
+    }
+
+    tag, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s%s", table, cols, cols, staging, suffix))
+    if err != nil {
+        return 0, fmt.Errorf("upsert %s rows: %w", table, err)
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+    return tag.RowsAffected(), nil
+}
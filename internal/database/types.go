@@ -0,0 +1,119 @@
+package database
+
+import (
+    "context"
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+
+    "github.com/lib/pq"
+)
+
+// Float64Array scans a float8[] through database/sql, from the text form
+// lib/pq returns or from a []float64 as sqlmock rows hold it
+type Float64Array []float64
+
+func (a *Float64Array) Scan(src interface{}) error {
+    switch v := src.(type) {
+    case nil:
+        *a = nil
+    case []float64:
+        *a = append(Float64Array(nil), v...)
+    default:
+        var parsed pq.Float64Array
+        if err := parsed.Scan(src); err != nil {
+            return err
+        }
+        *a = Float64Array(parsed)
+    }
+    return nil
+}
+
+// JSONB stores V as a jsonb value, and scanned into as *JSONB decodes one
+// into V, which must then be a pointer. Both drivers use Value and Scan.
+type JSONB struct {
+    V interface{}
+}
+
+func (j JSONB) Value() (driver.Value, error) {
+    if j.V == nil {
+        return nil, nil
+    }
+    raw, err := json.Marshal(j.V)
+    if err != nil {
+        return nil, fmt.Errorf("marshal jsonb: %w", err)
+    }
+    return string(raw), nil
+}
+
+func (j *JSONB) Scan(src interface{}) error {
+    var raw []byte
+    switch v := src.(type) {
+    case nil:
+        return nil
+    case []byte:
+        raw = v
+    case string:
+        raw = []byte(v)
+    default:
+        return fmt.Errorf("cannot scan %T into jsonb", src)
+    }
+    if err := json.Unmarshal(raw, j.V); err != nil {
+        return fmt.Errorf("unmarshal jsonb: %w", err)
+    }
+    return nil
+}
+
+// arrayArgs wraps slice arguments in pq.Array, as lib/pq only sends arrays
+// it is handed that way. pgx sends slices natively.
+func arrayArgs(args []interface{}) []interface{} {
+    wrapped := make([]interface{}, len(args))
+    for i, arg := range args {
+        switch arg.(type) {
+        case []string, []float64, []int64, []bool:
+            wrapped[i] = pq.Array(arg)
+        default:
+            wrapped[i] = arg
+        }
+    }
+    return wrapped
+}
+
+// QueryFloat64Arrays runs query, whose rows are a text key and a float8[],
+// and returns the arrays by key. Slice arguments are sent as Postgres
+// arrays with either driver.
+func (db *DB) QueryFloat64Arrays(ctx context.Context, query string, args ...interface{}) (map[string][]float64, error) {
+    arrays := make(map[string][]float64)
+
+    if db.pool != nil {
+        rows, err := db.pool.Query(ctx, query, args...)
+        if err != nil {
+            return nil, fmt.Errorf("execute query: %w", err)
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var key string
+            var values []float64
+            if err := rows.Scan(&key, &values); err != nil {
+                return nil, err
+            }
+            arrays[key] = values
+        }
+        return arrays, rows.Err()
+    }
+
+    rows, err := db.QueryContext(ctx, query, arrayArgs(args)...)
+    if err != nil {
+        return nil, fmt.Errorf("execute query: %w", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var key string
+        var values Float64Array
+        if err := rows.Scan(&key, &values); err != nil {
+            return nil, err
+        }
+        arrays[key] = values
+    }
+    return arrays, rows.Err()
+}
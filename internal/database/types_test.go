@@ -0,0 +1,70 @@
+package database
+
+import (
+    "context"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestFloat64Array_Scan(t *testing.T) {
+    var a Float64Array
+    require.NoError(t, a.Scan([]byte("{0.5,-1.25,3}")))
+    assert.Equal(t, Float64Array{0.5, -1.25, 3}, a)
+
+    require.NoError(t, a.Scan([]float64{1, 2}))
+    assert.Equal(t, Float64Array{1, 2}, a)
+
+    require.NoError(t, a.Scan(nil))
+    assert.Nil(t, a)
+
+    assert.Error(t, a.Scan([]byte("not an array")))
+}
+
+func TestJSONB(t *testing.T) {
+    type signal struct {
+        Type  string  `json:"type"`
+        Value float64 `json:"value"`
+    }
+
+    value, err := JSONB{V: []signal{{Type: "rsi", Value: 71.5}}}.Value()
+    require.NoError(t, err)
+    assert.Equal(t, `[{"type":"rsi","value":71.5}]`, value)
+
+    var signals []signal
+    require.NoError(t, (&JSONB{V: &signals}).Scan([]byte(`[{"type":"macd","value":-0.2}]`)))
+    assert.Equal(t, []signal{{Type: "macd", Value: -0.2}}, signals)
+
+    value, err = JSONB{}.Value()
+    require.NoError(t, err)
+    assert.Nil(t, value)
+    assert.Error(t, (&JSONB{V: &signals}).Scan(42))
+}
+
+func TestQueryFloat64Arrays(t *testing.T) {
+    dbConn, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer dbConn.Close()
+
+    // lib/pq is handed the symbols as an array, and returns the returns in
+    // their text form
+    mock.ExpectQuery("SELECT symbol, ARRAY_AGG").
+        WithArgs("{\"AAPL\",\"MSFT\"}").
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "returns"}).
+            AddRow("AAPL", []byte("{0.01,-0.02}")).
+            AddRow("MSFT", []byte("{0.005}")))
+
+    arrays, err := New(dbConn).QueryFloat64Arrays(context.Background(),
+        "SELECT symbol, ARRAY_AGG(return) FROM daily_returns WHERE symbol = ANY($1) GROUP BY symbol",
+        []string{"AAPL", "MSFT"})
+    require.NoError(t, err)
+    assert.Equal(t, map[string][]float64{
+        "AAPL": {0.01, -0.02},
+        "MSFT": {0.005},
+    }, arrays)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,6 +3,7 @@ package errors
 import (
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // ErrorType represents the type of error
@@ -19,6 +20,7 @@ const (
 	ErrorTypeInternal
 	ErrorTypeExternal
 	ErrorTypeRateLimit
+	ErrorTypeMethodNotAllowed
 )
 
 // Error represents a custom error with additional context
@@ -107,6 +109,10 @@ func NewRateLimitError(message string, err error) *Error {
 	return NewError(ErrorTypeRateLimit, message, err)
 }
 
+func NewMethodNotAllowedError(message string, err error) *Error {
+	return NewError(ErrorTypeMethodNotAllowed, message, err)
+}
+
 // Helper functions
 func errorTypeToStatusCode(errType ErrorType) int {
 	switch errType {
@@ -126,6 +132,8 @@ func errorTypeToStatusCode(errType ErrorType) int {
 		return http.StatusBadGateway
 	case ErrorTypeRateLimit:
 		return http.StatusTooManyRequests
+	case ErrorTypeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
 	default:
 		return http.StatusInternalServerError
 	}
@@ -149,6 +157,8 @@ func errorTypeToCode(errType ErrorType) string {
 		return "EXTERNAL_ERROR"
 	case ErrorTypeRateLimit:
 		return "RATE_LIMIT_EXCEEDED"
+	case ErrorTypeMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
 	default:
 		return "UNKNOWN_ERROR"
 	}
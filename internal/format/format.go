@@ -0,0 +1,113 @@
+// Package format decides how monetary amounts are displayed, so clients
+// don't each work out how many decimals a BTC price or a portfolio's value
+// deserves. Responses carry a Block of Money alongside the numeric fields.
+package format
+
+import (
+    "strings"
+
+    "github.com/shopspring/decimal"
+)
+
+// Money is the display metadata of one monetary field: the currency it is
+// in, the decimal places to show it with and its exact value as a decimal
+// string, free of float rounding
+type Money struct {
+    Currency string `json:"currency"`
+    Decimals int    `json:"decimals"`
+    Value    string `json:"value"`
+}
+
+// Block is the Money of a response's monetary fields, keyed by field name
+type Block map[string]Money
+
+// Rule decides the decimal places of a price: enough for Significant
+// digits, but no fewer than Min nor more than Max
+type Rule struct {
+    Min         int
+    Max         int
+    Significant int
+}
+
+// Decimals returns the decimal places v is shown with
+func (r Rule) Decimals(v decimal.Decimal) int {
+    v = v.Abs()
+    if v.IsZero() {
+        return r.Min
+    }
+    // The digits before the point, or less the zeros after it
+    magnitude := v.NumDigits() + int(v.Exponent())
+    decimals := r.Significant - magnitude
+    if decimals < r.Min {
+        return r.Min
+    }
+    if decimals > r.Max {
+        return r.Max
+    }
+    return decimals
+}
+
+// priceRules are the Rule of each asset class's prices, keyed by the asset
+// types of the valuation package. Crypto trades down to fractions of a cent,
+// so keeps more digits than equities.
+var priceRules = map[string]Rule{
+    "equity":     {Min: 2, Max: 4, Significant: 4},
+    "stock":      {Min: 2, Max: 4, Significant: 4},
+    "crypto":     {Min: 2, Max: 8, Significant: 6},
+    "stablecoin": {Min: 2, Max: 4, Significant: 4},
+    "cash":       {Min: 2, Max: 2},
+}
+
+// defaultPriceRule is the Rule of asset classes without one
+var defaultPriceRule = priceRules["equity"]
+
+// PriceRule returns the Rule of assetClass's prices
+func PriceRule(assetClass string) Rule {
+    if r, ok := priceRules[strings.ToLower(assetClass)]; ok {
+        return r
+    }
+    return defaultPriceRule
+}
+
+// minorUnits are the decimal places of currencies without two, per ISO 4217
+var minorUnits = map[string]int{
+    "JPY": 0,
+    "KRW": 0,
+    "VND": 0,
+    "CLP": 0,
+    "ISK": 0,
+    "BHD": 3,
+    "KWD": 3,
+    "OMR": 3,
+    "JOD": 3,
+    "TND": 3,
+}
+
+// WholeUnitsFrom is the amount from which Amount suggests dropping the
+// minor units, as a figure in the millions reads better without its cents
+var WholeUnitsFrom = decimal.NewFromInt(1000000)
+
+// Amount returns the Money of an amount held in currency, such as a
+// portfolio's value or a position's profit, shown with the currency's minor
+// units below WholeUnitsFrom and in whole units from it
+func Amount(value decimal.Decimal, currency string) Money {
+    currency = strings.ToUpper(currency)
+    decimals, ok := minorUnits[currency]
+    if !ok {
+        decimals = 2
+    }
+    if value.Abs().GreaterThanOrEqual(WholeUnitsFrom) {
+        decimals = 0
+    }
+    return Money{Currency: currency, Decimals: decimals, Value: value.String()}
+}
+
+// Price returns the Money of one unit of an asset of assetClass priced in
+// currency, with the decimals of its PriceRule
+func Price(value decimal.Decimal, assetClass, currency string) Money {
+    return Money{
+        Currency: strings.ToUpper(currency),
+        Decimals: PriceRule(assetClass).Decimals(value),
+        Value:    value.String(),
+    }
+}
@@ -0,0 +1,71 @@
+package format
+
+import (
+    "testing"
+
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestPrice_Decimals(t *testing.T) {
+    tests := []struct {
+        name       string
+        price      string
+        assetClass string
+        decimals   int
+    }{
+        {"Stock price", "189.84", "equity", 2},
+        {"Penny stock", "0.5123", "stock", 4},
+        {"Sub-penny stock is capped", "0.0004", "equity", 4},
+        {"BTC", "64250.5", "crypto", 2},
+        {"ETH", "3120.4", "crypto", 2},
+        {"Dollar-priced token", "1.23456", "crypto", 5},
+        {"Sub-cent token", "0.004321", "crypto", 8},
+        {"Sub-cent token keeps significant digits", "0.04321", "crypto", 7},
+        {"Meme coin is capped", "0.00001234", "crypto", 8},
+        {"Stablecoin", "0.9998", "stablecoin", 4},
+        {"Cash", "1", "cash", 2},
+        {"Unknown class follows equities", "12.5", "bond", 2},
+        {"Zero", "0", "crypto", 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            money := Price(decimal.RequireFromString(tt.price), tt.assetClass, "usd")
+            assert.Equal(t, tt.decimals, money.Decimals)
+            assert.Equal(t, "USD", money.Currency)
+        })
+    }
+}
+
+func TestAmount_Decimals(t *testing.T) {
+    tests := []struct {
+        name     string
+        amount   string
+        currency string
+        decimals int
+    }{
+        {"Portfolio value", "10234.56", "USD", 2},
+        {"Loss", "-250.1", "USD", 2},
+        {"Fraction of a cent", "0.0042", "USD", 2},
+        {"Just under a million", "999999.99", "USD", 2},
+        {"Large AUM", "1250000000.75", "USD", 0},
+        {"Large loss", "-2000000", "EUR", 0},
+        {"Yen have no minor unit", "15230", "JPY", 0},
+        {"Dinar have three", "12.345", "KWD", 3},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            money := Amount(decimal.RequireFromString(tt.amount), tt.currency)
+            assert.Equal(t, tt.decimals, money.Decimals)
+        })
+    }
+}
+
+func TestMoney_ExactValue(t *testing.T) {
+    // The value keeps every digit, however few are suggested for display
+    money := Price(decimal.RequireFromString("0.000012345678"), "crypto", "USD")
+    assert.Equal(t, "0.000012345678", money.Value)
+
+    money = Amount(decimal.NewFromFloat(0.1).Add(decimal.NewFromFloat(0.2)), "USD")
+    assert.Equal(t, "0.3", money.Value)
+}
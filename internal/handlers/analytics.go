@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
@@ -64,9 +66,11 @@ func (h *AnalyticsHandler) GetMarketAnalysis(w http.ResponseWriter, r *http.Requ
 		Predictions: predictions,
 	}
 
+	// Let pollers revalidate until the analysis is regenerated
+	middleware.SetVersion(w, analysis.UpdatedAt.Format(time.RFC3339Nano), analysis.FreshUntil())
+
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusOK, response)
 }
 
 func (h *AnalyticsHandler) GetPredictions(w http.ResponseWriter, r *http.Request) {
@@ -81,8 +85,8 @@ func (h *AnalyticsHandler) GetPredictions(w http.ResponseWriter, r *http.Request
 	}
 
 	// Validate timeframe
-	if !isValidTimeframe(timeframe) {
-		http.Error(w, "Invalid timeframe. Valid values: 1h, 4h, 24h, 7d", http.StatusBadRequest)
+	if !models.ValidTimeframe(timeframe) {
+		http.Error(w, invalidTimeframeMessage, http.StatusBadRequest)
 		return
 	}
 
@@ -108,9 +112,10 @@ func (h *AnalyticsHandler) GetPredictions(w http.ResponseWriter, r *http.Request
 		ValidUntil:  prediction.ValidUntil,
 	}
 
+	middleware.SetVersion(w, prediction.ID.String()+"@"+prediction.ValidUntil.Format(time.RFC3339Nano), prediction.ValidUntil)
+
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusOK, response)
 }
 
 func (h *AnalyticsHandler) GetPortfolioAnalytics(w http.ResponseWriter, r *http.Request) {
@@ -120,22 +125,20 @@ func (h *AnalyticsHandler) GetPortfolioAnalytics(w http.ResponseWriter, r *http.
 
 	// Get timeframe from query params (default to all)
 	timeframe := r.URL.Query().Get("timeframe")
+	if timeframe != "" && !models.ValidTimeframe(timeframe) {
+		http.Error(w, invalidTimeframeMessage, http.StatusBadRequest)
+		return
+	}
 
-	// Get analytics
-	analytics, err := h.analyticsService.GetAdvancedAnalytics(r.Context(), portfolioID)
+	// Get analytics over the timeframe only
+	analytics, err := h.analyticsService.GetTimeframeAnalytics(r.Context(), portfolioID, timeframe)
 	if err != nil {
 		http.Error(w, "Error fetching portfolio analytics", http.StatusInternalServerError)
 		return
 	}
 
-	// If timeframe specified, filter metrics
-	if timeframe != "" {
-		analytics = filterAnalyticsByTimeframe(analytics, timeframe)
-	}
-
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(analytics)
+	render.JSON(w, r, http.StatusOK, analytics)
 }
 
 func (h *AnalyticsHandler) GetHistoricalPerformance(w http.ResponseWriter, r *http.Request) {
@@ -168,39 +171,10 @@ func (h *AnalyticsHandler) GetHistoricalPerformance(w http.ResponseWriter, r *ht
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(performance)
+	render.JSON(w, r, http.StatusOK, performance)
 }
 
-func isValidTimeframe(timeframe string) bool {
-	validTimeframes := map[string]bool{
-		"1h":  true,
-		"4h":  true,
-		"24h": true,
-		"7d":  true,
-	}
-	return validTimeframes[timeframe]
-}
-
-func filterAnalyticsByTimeframe(analytics *models.AdvancedAnalytics, timeframe string) *models.AdvancedAnalytics {
-	// Create a copy of analytics
-	filtered := *analytics
-
-	// Filter metrics based on timeframe
-	switch timeframe {
-	case "24h":
-		filtered.PortfolioMetrics.WeeklyReturn = 0
-		filtered.PortfolioMetrics.MonthlyReturn = 0
-		filtered.PortfolioMetrics.YearlyReturn = 0
-	case "7d":
-		filtered.PortfolioMetrics.MonthlyReturn = 0
-		filtered.PortfolioMetrics.YearlyReturn = 0
-	case "30d":
-		filtered.PortfolioMetrics.YearlyReturn = 0
-	}
-
-	return &filtered
-}
+var invalidTimeframeMessage = "Invalid timeframe. Valid values: " + strings.Join(models.Timeframes, ", ")
 
 // Error types for analytics operations
 var (
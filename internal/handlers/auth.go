@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -104,8 +105,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	render.JSON(w, r, http.StatusOK, res)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -149,8 +149,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	render.JSON(w, r, http.StatusOK, res)
 }
 
 func validateRegisterRequest(req RegisterRequest) error {
@@ -3,13 +3,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/degrade"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
 )
 
 // Custom errors for portfolio operations
@@ -18,11 +25,27 @@ var (
 	ErrEmptyPortfolio      = NewValidationError("portfolio must contain at least one asset")
 	ErrInvalidAssetSymbol  = NewValidationError("invalid asset symbol")
 	ErrInvalidAssetQuantity = NewValidationError("invalid asset quantity")
+	ErrNoPortfolioIDs       = NewValidationError("no portfolio IDs given")
+	ErrTooManyPortfolioIDs  = NewValidationError(fmt.Sprintf("at most %d portfolios can be deleted at once", MaxBulkDeletePortfolios))
 )
 
+// MaxBulkDeletePortfolios caps the portfolios one bulk delete request covers
+const MaxBulkDeletePortfolios = 50
+
 type PortfolioHandler struct {
 	portfolioService PortfolioService
 	analyticsService AnalyticsService
+	limits           PositionLimits
+	stageMetrics     *monitoring.StageMetrics
+	sectionBudget    time.Duration
+	degradedMetrics  *degrade.Metrics
+}
+
+// PositionLimits caps the value of a single position and of a whole
+// portfolio, in USD. A zero limit is not enforced.
+type PositionLimits struct {
+	MaxPositionValueUSD  float64
+	MaxPortfolioValueUSD float64
 }
 
 type PortfolioService interface {
@@ -31,11 +54,14 @@ type PortfolioService interface {
 	CreatePortfolio(ctx context.Context, portfolio *models.Portfolio) error
 	UpdatePortfolio(ctx context.Context, portfolio *models.Portfolio) error
 	DeletePortfolio(ctx context.Context, id uuid.UUID) error
+	BulkDeletePortfolios(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (*models.BulkDeleteResult, error)
+	UpsertPosition(ctx context.Context, portfolio *models.Portfolio, asset models.Asset) error
 	UpdatePortfolioValue(ctx context.Context, portfolio *models.Portfolio) error
 }
 
 type AnalyticsService interface {
 	GetAdvancedAnalytics(ctx context.Context, portfolioID string) (*models.AdvancedAnalytics, error)
+	GetTimeframeAnalytics(ctx context.Context, portfolioID, timeframe string) (*models.AdvancedAnalytics, error)
 	GetMarketAnalysis(ctx context.Context, symbol string) (*models.MarketAnalysis, error)
 }
 
@@ -51,18 +77,72 @@ type UpdatePortfolioRequest struct {
 	Assets      []models.Asset `json:"assets"`
 }
 
+type UpsertPositionRequest struct {
+	Type     string          `json:"type"`
+	Quantity decimal.Decimal `json:"quantity"`
+	AvgPrice decimal.Decimal `json:"avg_price"`
+}
+
+type BulkDeletePortfoliosRequest struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
 type PortfolioResponse struct {
 	*models.Portfolio
-	Analytics *models.AdvancedAnalytics `json:"analytics,omitempty"`
+	Analytics *models.AdvancedAnalytics `json:"analytics"`
+	Degraded  []degrade.Missing         `json:"degraded,omitempty"`
+	Debug     *debugInfo                `json:"debug,omitempty"`
+}
+
+// debugInfo is what a response carries under "debug" for callers who ask
+type debugInfo struct {
+	Timing monitoring.TimingBreakdown `json:"timing"`
+}
+
+// timingDebug returns the stage timings so far when the caller is an admin
+// or sent X-Debug-Timing: 1, and nil otherwise
+func timingDebug(r *http.Request, timer *monitoring.StageTimer) *debugInfo {
+	user, _ := r.Context().Value("user").(*models.User)
+	if (user == nil || user.Role != auth.RoleAdmin) && !monitoring.TimingRequested(r) {
+		return nil
+	}
+	return &debugInfo{Timing: timer.Breakdown()}
 }
 
 func NewPortfolioHandler(portfolioService PortfolioService, analyticsService AnalyticsService) *PortfolioHandler {
 	return &PortfolioHandler{
 		portfolioService: portfolioService,
 		analyticsService: analyticsService,
+		sectionBudget:    degrade.DefaultBudget,
 	}
 }
 
+// WithLimits enforces limits whenever assets are added or changed
+func (h *PortfolioHandler) WithLimits(limits PositionLimits) *PortfolioHandler {
+	h.limits = limits
+	return h
+}
+
+// WithStageMetrics exports how long GetPortfolio spends in each stage
+func (h *PortfolioHandler) WithStageMetrics(metrics *monitoring.StageMetrics) *PortfolioHandler {
+	h.stageMetrics = metrics
+	return h
+}
+
+// WithSectionBudget sets how long GetPortfolio's analytics may take before
+// the portfolio is served without them
+func (h *PortfolioHandler) WithSectionBudget(budget time.Duration) *PortfolioHandler {
+	h.sectionBudget = budget
+	return h
+}
+
+// WithDegradedMetrics counts GetPortfolio responses served without their
+// analytics
+func (h *PortfolioHandler) WithDegradedMetrics(metrics *degrade.Metrics) *PortfolioHandler {
+	h.degradedMetrics = metrics
+	return h
+}
+
 func (h *PortfolioHandler) GetPortfolios(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID, ok := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
@@ -101,10 +181,15 @@ func (h *PortfolioHandler) GetPortfolios(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusOK, response)
 }
 
+// GetPortfolio returns the portfolio valued at current prices, with its
+// analytics. The analytics get the section budget, cut short by the
+// request's deadline; when they fail or run out of time they are served as
+// null, listed under "degraded" and named in the X-Degraded header. Admins,
+// and callers sending X-Debug-Timing: 1, also get how long each stage of
+// the request took under "debug".
 func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request) {
 	// Get portfolio ID from URL
 	vars := mux.Vars(r)
@@ -114,8 +199,13 @@ func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	ctx, timer := monitoring.WithStageTimer(r.Context())
+	defer func() { h.stageMetrics.Observe(timer.Breakdown()) }()
+
 	// Get portfolio
-	portfolio, err := h.portfolioService.GetPortfolio(r.Context(), portfolioID)
+	done := monitoring.StartStage(ctx, monitoring.StagePortfolio)
+	portfolio, err := h.portfolioService.GetPortfolio(ctx, portfolioID)
+	done()
 	if err != nil {
 		http.Error(w, "Error fetching portfolio", http.StatusInternalServerError)
 		return
@@ -129,26 +219,35 @@ func (h *PortfolioHandler) GetPortfolio(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Update portfolio value
-	if err := h.portfolioService.UpdatePortfolioValue(r.Context(), portfolio); err != nil {
+	done = monitoring.StartStage(ctx, monitoring.StagePrices)
+	if err := h.portfolioService.UpdatePortfolioValue(ctx, portfolio); err != nil {
 		// Log error but continue
 		// logger.Error("Failed to update portfolio value", "error", err)
 	}
-
-	// Get analytics
-	analytics, err := h.analyticsService.GetAdvancedAnalytics(r.Context(), portfolio.ID.String())
-	if err != nil {
-		// Log error but continue
-		// logger.Error("Failed to get portfolio analytics", "error", err)
-	}
+	done()
+
+	// Get analytics, without waiting on them past the budget
+	result := degrade.Run(ctx, degrade.Budget(ctx, h.sectionBudget), degrade.Section{
+		Name: "analytics",
+		Load: func(ctx context.Context) (interface{}, error) {
+			done := monitoring.StartStage(ctx, monitoring.StageAnalytics)
+			defer done()
+			return h.analyticsService.GetAdvancedAnalytics(ctx, portfolio.ID.String())
+		},
+	})
+	analytics, _ := result.Values["analytics"].(*models.AdvancedAnalytics)
+	h.degradedMetrics.Observe("portfolio_detail", result)
+	result.SetHeader(w)
 
 	response := PortfolioResponse{
 		Portfolio:  portfolio,
 		Analytics: analytics,
+		Degraded:  result.Degraded,
+		Debug:     timingDebug(r, timer),
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusOK, response)
 }
 
 func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Request) {
@@ -166,12 +265,6 @@ func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Validate request
-	if err := validateCreatePortfolioRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
 	// Create portfolio model
 	portfolio := &models.Portfolio{
 		ID:          uuid.New(),
@@ -189,6 +282,13 @@ func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Validate request against the current values of its assets
+	req.Assets = portfolio.Assets
+	if err := validateCreatePortfolioRequest(req, h.limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create portfolio
 	if err := h.portfolioService.CreatePortfolio(r.Context(), portfolio); err != nil {
 		http.Error(w, "Error creating portfolio", http.StatusInternalServerError)
@@ -208,9 +308,7 @@ func (h *PortfolioHandler) CreatePortfolio(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusCreated, response)
 }
 
 func (h *PortfolioHandler) UpdatePortfolio(w http.ResponseWriter, r *http.Request) {
@@ -274,8 +372,99 @@ func (h *PortfolioHandler) UpdatePortfolio(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	render.JSON(w, r, http.StatusOK, response)
+}
+
+// UpsertPosition sets the quantity and average price of one asset of a
+// portfolio, adding the asset if the portfolio doesn't hold it yet
+func (h *PortfolioHandler) UpsertPosition(w http.ResponseWriter, r *http.Request) {
+	// Get portfolio ID and symbol from URL
+	vars := mux.Vars(r)
+	portfolioID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+		return
+	}
+	symbol := strings.ToUpper(vars["symbol"])
+	if symbol == "" {
+		http.Error(w, ErrInvalidAssetSymbol.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse request
+	var req UpsertPositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Quantity.IsPositive() {
+		http.Error(w, ErrInvalidAssetQuantity.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get existing portfolio
+	portfolio, err := h.portfolioService.GetPortfolio(r.Context(), portfolioID)
+	if err != nil {
+		http.Error(w, "Error fetching portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	// Check if portfolio belongs to user
+	userID, ok := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok || portfolio.UserID != userID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Replace the asset, or add it. Paper trading portfolios store it apart
+	// from the live positions.
+	asset := models.Asset{
+		Symbol:     symbol,
+		Type:       req.Type,
+		Quantity:   req.Quantity,
+		AvgPrice:   req.AvgPrice,
+		LastUpdate: time.Now(),
+	}
+	found := false
+	for i := range portfolio.Assets {
+		if portfolio.Assets[i].Symbol == symbol {
+			if asset.Type == "" {
+				asset.Type = portfolio.Assets[i].Type
+			}
+			portfolio.Assets[i] = asset
+			found = true
+			break
+		}
+	}
+	if !found {
+		portfolio.Assets = append(portfolio.Assets, asset)
+	}
+	portfolio.UpdatedAt = time.Now()
+
+	// Update portfolio value, then check it against the limits
+	if err := h.portfolioService.UpdatePortfolioValue(r.Context(), portfolio); err != nil {
+		http.Error(w, "Error calculating portfolio value", http.StatusInternalServerError)
+		return
+	}
+	if err := validatePositionLimits(portfolio.Assets, h.limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, held := range portfolio.Assets {
+		if held.Symbol == symbol {
+			asset = held
+			break
+		}
+	}
+
+	// Save changes
+	if err := h.portfolioService.UpsertPosition(r.Context(), portfolio, asset); err != nil {
+		http.Error(w, "Error updating portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	render.JSON(w, r, http.StatusOK, PortfolioResponse{Portfolio: portfolio})
 }
 
 func (h *PortfolioHandler) DeletePortfolio(w http.ResponseWriter, r *http.Request) {
@@ -311,7 +500,41 @@ func (h *PortfolioHandler) DeletePortfolio(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func validateCreatePortfolioRequest(req CreatePortfolioRequest) error {
+// BulkDeletePortfolios deletes the caller's portfolios among the IDs in the
+// body, reporting those it didn't delete rather than failing on them
+func (h *PortfolioHandler) BulkDeletePortfolios(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req BulkDeletePortfoliosRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, ErrNoPortfolioIDs.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > MaxBulkDeletePortfolios {
+		http.Error(w, ErrTooManyPortfolioIDs.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.portfolioService.BulkDeletePortfolios(r.Context(), userID, req.IDs)
+	if err != nil {
+		http.Error(w, "Error deleting portfolios", http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, http.StatusOK, result)
+}
+
+// validateCreatePortfolioRequest checks a request whose assets have been
+// valued by UpdatePortfolioValue
+func validateCreatePortfolioRequest(req CreatePortfolioRequest, limits PositionLimits) error {
 	if req.Name == "" {
 		return ErrInvalidPortfolioName
 	}
@@ -324,10 +547,31 @@ func validateCreatePortfolioRequest(req CreatePortfolioRequest) error {
 		if asset.Symbol == "" {
 			return ErrInvalidAssetSymbol
 		}
-		if asset.Quantity <= 0 {
+		if !asset.Quantity.IsPositive() {
 			return ErrInvalidAssetQuantity
 		}
 	}
 	
+	return validatePositionLimits(req.Assets, limits)
+}
+
+// validatePositionLimits checks the current values of assets against limits
+func validatePositionLimits(assets []models.Asset, limits PositionLimits) error {
+	total := decimal.Zero
+	for _, asset := range assets {
+		value := asset.Value.InexactFloat64()
+		if limits.MaxPositionValueUSD > 0 && value > limits.MaxPositionValueUSD {
+			return NewValidationError(fmt.Sprintf(
+				"position %s is worth $%.2f, above the maximum position value of $%.2f",
+				asset.Symbol, value, limits.MaxPositionValueUSD))
+		}
+		total = total.Add(asset.Value)
+	}
+
+	if value := total.InexactFloat64(); limits.MaxPortfolioValueUSD > 0 && value > limits.MaxPortfolioValueUSD {
+		return NewValidationError(fmt.Sprintf(
+			"portfolio would be worth $%.2f, above the maximum portfolio value of $%.2f",
+			value, limits.MaxPortfolioValueUSD))
+	}
 	return nil
 }
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/degrade"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/middleware"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// pricedPortfolioService values assets at fixed prices and keeps the last
+// portfolio saved
+type pricedPortfolioService struct {
+	PortfolioService
+	portfolio *models.Portfolio
+	prices    map[string]float64
+	saved     *models.Portfolio
+	upserted  models.Asset
+}
+
+func (s *pricedPortfolioService) GetPortfolio(ctx context.Context, id uuid.UUID) (*models.Portfolio, error) {
+	return s.portfolio, nil
+}
+
+func (s *pricedPortfolioService) UpdatePortfolioValue(ctx context.Context, portfolio *models.Portfolio) error {
+	total := decimal.Zero
+	for i := range portfolio.Assets {
+		asset := &portfolio.Assets[i]
+		asset.Value = asset.Quantity.Mul(decimal.NewFromFloat(s.prices[asset.Symbol]))
+		total = total.Add(asset.Value)
+	}
+	portfolio.TotalValue = total
+	return nil
+}
+
+func (s *pricedPortfolioService) UpsertPosition(ctx context.Context, portfolio *models.Portfolio, asset models.Asset) error {
+	s.saved = portfolio
+	s.upserted = asset
+	return nil
+}
+
+func TestPortfolioHandler_UpsertPosition(t *testing.T) {
+	userID := uuid.New()
+	limits := PositionLimits{MaxPositionValueUSD: 100000, MaxPortfolioValueUSD: 150000}
+
+	upsert := func(service *pricedPortfolioService, symbol, body string) *httptest.ResponseRecorder {
+		handler := NewPortfolioHandler(service, nil).WithLimits(limits)
+
+		req := httptest.NewRequest(http.MethodPut, "/portfolios/"+service.portfolio.ID.String()+"/positions/"+symbol, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": service.portfolio.ID.String(), "symbol": symbol})
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+
+		rec := httptest.NewRecorder()
+		handler.UpsertPosition(rec, req)
+		return rec
+	}
+
+	newService := func() *pricedPortfolioService {
+		return &pricedPortfolioService{
+			portfolio: &models.Portfolio{
+				ID:     uuid.New(),
+				UserID: userID,
+				Assets: []models.Asset{
+					{Symbol: "AAPL", Quantity: decimal.NewFromInt(400), AvgPrice: decimal.NewFromInt(180)},
+				},
+			},
+			prices: map[string]float64{"AAPL": 200, "MSFT": 400, "NVDA": 5000},
+		}
+	}
+
+	t.Run("Position pushing the portfolio over its cap", func(t *testing.T) {
+		// 80k of AAPL plus 80k of MSFT is over the 150k portfolio cap
+		service := newService()
+		rec := upsert(service, "MSFT", `{"quantity": "200", "avg_price": "390"}`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "portfolio would be worth $160000.00, above the maximum portfolio value of $150000.00")
+		assert.Nil(t, service.saved)
+	})
+
+	t.Run("Position over the single position cap", func(t *testing.T) {
+		service := newService()
+		rec := upsert(service, "NVDA", `{"quantity": "10000", "avg_price": "5000"}`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "position NVDA is worth $50000000.00, above the maximum position value of $100000.00")
+		assert.Nil(t, service.saved)
+	})
+
+	t.Run("Position within limits", func(t *testing.T) {
+		service := newService()
+		rec := upsert(service, "MSFT", `{"quantity": "100", "avg_price": "390"}`)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if !assert.NotNil(t, service.saved) {
+			return
+		}
+		assert.Len(t, service.saved.Assets, 2)
+		assert.True(t, decimal.NewFromInt(120000).Equal(service.saved.TotalValue), "total %s", service.saved.TotalValue)
+		assert.Equal(t, "MSFT", service.upserted.Symbol)
+		assert.True(t, decimal.NewFromInt(40000).Equal(service.upserted.Value), "value %s", service.upserted.Value)
+	})
+
+	t.Run("Existing position resized", func(t *testing.T) {
+		service := newService()
+		rec := upsert(service, "aapl", `{"quantity": "600", "avg_price": "190"}`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "position AAPL is worth $120000.00")
+	})
+}
+
+func TestValidateCreatePortfolioRequest_Limits(t *testing.T) {
+	limits := PositionLimits{MaxPositionValueUSD: 1000000, MaxPortfolioValueUSD: 2000000}
+
+	req := CreatePortfolioRequest{
+		Name: "Growth",
+		Assets: []models.Asset{
+			{Symbol: "AAPL", Quantity: decimal.NewFromInt(10000), Value: decimal.NewFromInt(50000000)},
+		},
+	}
+	err := validateCreatePortfolioRequest(req, limits)
+	if assert.Error(t, err) {
+		assert.IsType(t, ValidationError{}, err)
+		assert.Contains(t, err.Error(), "maximum position value of $1000000.00")
+	}
+
+	req.Assets[0].Quantity = decimal.NewFromInt(10)
+	req.Assets[0].Value = decimal.NewFromInt(50000)
+	assert.NoError(t, validateCreatePortfolioRequest(req, limits))
+
+	// Zero limits are not enforced
+	req.Assets[0].Value = decimal.NewFromInt(50000000)
+	assert.NoError(t, validateCreatePortfolioRequest(req, PositionLimits{}))
+}
+
+// bulkDeleteService deletes every portfolio it is asked to
+type bulkDeleteService struct {
+	PortfolioService
+	calls int
+}
+
+func (s *bulkDeleteService) BulkDeletePortfolios(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (*models.BulkDeleteResult, error) {
+	s.calls++
+	return &models.BulkDeleteResult{DeletedCount: len(ids), NotFoundIDs: []uuid.UUID{}, UnauthorizedIDs: []uuid.UUID{}}, nil
+}
+
+func TestPortfolioHandler_BulkDeletePortfolios(t *testing.T) {
+	userID := uuid.New()
+
+	bulkDelete := func(service *bulkDeleteService, count int) *httptest.ResponseRecorder {
+		ids := make([]uuid.UUID, count)
+		for i := range ids {
+			ids[i] = uuid.New()
+		}
+		body, _ := json.Marshal(BulkDeletePortfoliosRequest{IDs: ids})
+
+		req := httptest.NewRequest(http.MethodDelete, "/portfolios", strings.NewReader(string(body)))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		NewPortfolioHandler(service, nil).BulkDeletePortfolios(rec, req)
+		return rec
+	}
+
+	t.Run("Within the limit", func(t *testing.T) {
+		service := &bulkDeleteService{}
+		rec := bulkDelete(service, MaxBulkDeletePortfolios)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var result models.BulkDeleteResult
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+		assert.Equal(t, MaxBulkDeletePortfolios, result.DeletedCount)
+	})
+
+	t.Run("Over the limit", func(t *testing.T) {
+		service := &bulkDeleteService{}
+		rec := bulkDelete(service, MaxBulkDeletePortfolios+1)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, 0, service.calls)
+	})
+
+	t.Run("No IDs", func(t *testing.T) {
+		service := &bulkDeleteService{}
+		rec := bulkDelete(service, 0)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, 0, service.calls)
+	})
+}
+
+// slowPortfolioService takes delay to load and to value a portfolio
+type slowPortfolioService struct {
+	pricedPortfolioService
+	delay time.Duration
+}
+
+func (s *slowPortfolioService) GetPortfolio(ctx context.Context, id uuid.UUID) (*models.Portfolio, error) {
+	time.Sleep(s.delay)
+	return s.portfolio, nil
+}
+
+func (s *slowPortfolioService) UpdatePortfolioValue(ctx context.Context, portfolio *models.Portfolio) error {
+	time.Sleep(s.delay)
+	return s.pricedPortfolioService.UpdatePortfolioValue(ctx, portfolio)
+}
+
+// slowAnalytics records its own stage, as the analytics service does
+type slowAnalytics struct {
+	AnalyticsService
+	delay time.Duration
+}
+
+func (a slowAnalytics) GetAdvancedAnalytics(ctx context.Context, portfolioID string) (*models.AdvancedAnalytics, error) {
+	defer monitoring.StartStage(ctx, monitoring.StageAnalytics)()
+	time.Sleep(a.delay)
+	return &models.AdvancedAnalytics{}, nil
+}
+
+func TestPortfolioHandler_GetPortfolio_Timing(t *testing.T) {
+	userID := uuid.New()
+	service := &slowPortfolioService{
+		pricedPortfolioService: pricedPortfolioService{
+			portfolio: &models.Portfolio{
+				ID:     uuid.New(),
+				UserID: userID,
+				Assets: []models.Asset{{Symbol: "AAPL", Quantity: decimal.NewFromInt(10)}},
+			},
+			prices: map[string]float64{"AAPL": 200},
+		},
+		delay: 5 * time.Millisecond,
+	}
+	handler := NewPortfolioHandler(service, slowAnalytics{delay: 10 * time.Millisecond}).
+		WithStageMetrics(monitoring.NewStageMetrics(nil))
+
+	get := func(user *models.User, debugHeader string) PortfolioResponse {
+		req := httptest.NewRequest(http.MethodGet, "/portfolios/"+service.portfolio.ID.String(), nil)
+		req = mux.SetURLVars(req, map[string]string{"id": service.portfolio.ID.String()})
+		ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+		if user != nil {
+			ctx = context.WithValue(ctx, "user", user)
+		}
+		req = req.WithContext(ctx)
+		if debugHeader != "" {
+			req.Header.Set(monitoring.TimingHeader, debugHeader)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.GetPortfolio(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response PortfolioResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response
+	}
+
+	assert.Nil(t, get(nil, "").Debug, "no breakdown unless asked for")
+	assert.Nil(t, get(&models.User{ID: userID, Role: auth.RoleUser}, "0").Debug)
+
+	for name, response := range map[string]PortfolioResponse{
+		"header": get(nil, "1"),
+		"admin":  get(&models.User{ID: userID, Role: auth.RoleAdmin}, ""),
+	} {
+		if !assert.NotNil(t, response.Debug, name) {
+			continue
+		}
+		timing := response.Debug.Timing
+
+		var stages []string
+		var sum float64
+		for _, stage := range timing.Stages {
+			stages = append(stages, stage.Stage)
+			assert.Equal(t, 1, stage.Calls, "%s: %s", name, stage.Stage)
+			sum += stage.Ms
+		}
+		assert.Equal(t, []string{monitoring.StagePortfolio, monitoring.StagePrices, monitoring.StageAnalytics}, stages, name)
+		assert.GreaterOrEqual(t, timing.Stages[2].Ms, 10.0, name)
+		// Everything the handler does besides its stages takes well under a
+		// millisecond
+		assert.GreaterOrEqual(t, timing.TotalMs, 20.0, name)
+		assert.LessOrEqual(t, sum, timing.TotalMs, name)
+		assert.InDelta(t, timing.TotalMs, sum, 1, name)
+	}
+}
+
+// failingAnalytics fails every request for analytics
+type failingAnalytics struct {
+	AnalyticsService
+}
+
+func (failingAnalytics) GetAdvancedAnalytics(ctx context.Context, portfolioID string) (*models.AdvancedAnalytics, error) {
+	return nil, errors.New("analytics database unavailable")
+}
+
+func TestPortfolioHandler_GetPortfolio_Degraded(t *testing.T) {
+	userID := uuid.New()
+	service := &pricedPortfolioService{
+		portfolio: &models.Portfolio{
+			ID:     uuid.New(),
+			UserID: userID,
+			Assets: []models.Asset{{Symbol: "AAPL", Quantity: decimal.NewFromInt(10)}},
+		},
+		prices: map[string]float64{"AAPL": 200},
+	}
+	budget := 50 * time.Millisecond
+
+	get := func(handler *PortfolioHandler, ctx context.Context) (*httptest.ResponseRecorder, PortfolioResponse, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, "/portfolios/"+service.portfolio.ID.String(), nil)
+		req = mux.SetURLVars(req, map[string]string{"id": service.portfolio.ID.String()})
+		req = req.WithContext(context.WithValue(ctx, middleware.UserIDKey, userID))
+
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.GetPortfolio(rec, req)
+		elapsed := time.Since(start)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response PortfolioResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		return rec, response, elapsed
+	}
+
+	t.Run("Analytics in time", func(t *testing.T) {
+		handler := NewPortfolioHandler(service, slowAnalytics{delay: time.Millisecond}).WithSectionBudget(budget)
+		rec, response, _ := get(handler, context.Background())
+		assert.NotNil(t, response.Analytics)
+		assert.Empty(t, response.Degraded)
+		assert.Empty(t, rec.Header().Get(degrade.Header))
+	})
+
+	t.Run("Slow analytics", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		metrics := degrade.NewMetrics(reg)
+		// The analytics ignore their context, so only the budget stops
+		// the handler waiting on them
+		handler := NewPortfolioHandler(service, slowAnalytics{delay: time.Second}).
+			WithSectionBudget(budget).
+			WithDegradedMetrics(metrics)
+		rec, response, elapsed := get(handler, context.Background())
+
+		assert.Less(t, elapsed, budget+100*time.Millisecond)
+		assert.Equal(t, service.portfolio.ID, response.ID)
+		assert.True(t, response.TotalValue.Equal(decimal.NewFromInt(2000)))
+		assert.Nil(t, response.Analytics)
+		assert.Equal(t, []degrade.Missing{{Section: "analytics", Reason: degrade.ReasonTimeout}}, response.Degraded)
+		assert.Equal(t, "analytics", rec.Header().Get(degrade.Header))
+		assert.Contains(t, rec.Body.String(), `"analytics":null`)
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP degraded_responses_total Responses served without an optional section that failed or ran out of time
+# TYPE degraded_responses_total counter
+degraded_responses_total{endpoint="portfolio_detail",reason="timeout",section="analytics"} 1
+`), "degraded_responses_total"))
+	})
+
+	t.Run("Request deadline shorter than the budget", func(t *testing.T) {
+		handler := NewPortfolioHandler(service, slowAnalytics{delay: time.Second}).WithSectionBudget(time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		_, response, elapsed := get(handler, ctx)
+
+		assert.Less(t, elapsed, 300*time.Millisecond)
+		assert.Equal(t, service.portfolio.ID, response.ID)
+		assert.Equal(t, []degrade.Missing{{Section: "analytics", Reason: degrade.ReasonTimeout}}, response.Degraded)
+	})
+
+	t.Run("Failing analytics", func(t *testing.T) {
+		handler := NewPortfolioHandler(service, failingAnalytics{}).WithSectionBudget(budget)
+		rec, response, _ := get(handler, context.Background())
+
+		assert.Nil(t, response.Analytics)
+		assert.Equal(t, []degrade.Missing{{
+			Section: "analytics",
+			Reason:  degrade.ReasonError,
+			Error:   "analytics database unavailable",
+		}}, response.Degraded)
+		assert.Equal(t, "analytics", rec.Header().Get(degrade.Header))
+	})
+}
@@ -0,0 +1,482 @@
+package jobs
+
+import (
+    "archive/zip"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "time"
+
+    "github.com/google/uuid"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
+)
+
+// Export job statuses
+const (
+    ExportPending   = "pending"
+    ExportRunning   = "running"
+    ExportCompleted = "completed"
+    ExportFailed    = "failed"
+    // ExportExpired jobs have had their archive deleted
+    ExportExpired = "expired"
+)
+
+const (
+    // exportArtifact is the artifact name exports are stored under, with
+    // the job ID as version and each file of a job stored on its own until
+    // the archive is assembled
+    exportArtifact = "user-exports"
+    // exportStaleAfter is how long a running export may go without progress
+    // before another worker takes it over
+    exportStaleAfter = 10 * time.Minute
+    // maxExportAttempts bounds how often a failing export is retried
+    maxExportAttempts = 3
+)
+
+var (
+    ErrExportNotFound = errors.New("export not found")
+    // ErrExportRateLimited is returned for an export requested too soon
+    // after the user's last one
+    ErrExportRateLimited = errors.New("export requested too recently")
+    // ErrExportNotReady is returned when downloading an export that hasn't
+    // completed or has expired
+    ErrExportNotReady = errors.New("export is not available for download")
+    // ErrInvalidSignature is returned for a download URL that wasn't signed
+    // by the exporter or has expired
+    ErrInvalidSignature = errors.New("invalid or expired download signature")
+)
+
+// ExportConfig bounds how often users can export their data and how long
+// exports are kept
+type ExportConfig struct {
+    // SigningKey signs download URLs
+    SigningKey []byte
+    // MinInterval is the least time between two exports of one user
+    MinInterval time.Duration
+    // Retention is how long a completed export is kept before deletion
+    Retention time.Duration
+    // URLTTL is how long a download URL is valid, within Retention
+    URLTTL time.Duration
+}
+
+// ExportFile is one file of an export and the rows written to it
+type ExportFile struct {
+    Name string `json:"name"`
+    Rows int    `json:"rows"`
+}
+
+// ExportJob is the state and progress of a user's data export
+type ExportJob struct {
+    ID          int64        `json:"id"`
+    UserID      uuid.UUID    `json:"-"`
+    Status      string       `json:"status"`
+    TotalFiles  int          `json:"total_files"`
+    Files       []ExportFile `json:"files"`
+    Error       string       `json:"error,omitempty"`
+    CreatedAt   time.Time    `json:"created_at"`
+    StartedAt   *time.Time   `json:"started_at,omitempty"`
+    CompletedAt *time.Time   `json:"completed_at,omitempty"`
+    ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+    // DownloadURL is set on completed exports, as a path signed to expire
+    DownloadURL string `json:"download_url,omitempty"`
+
+    // parts are the files stored so far, kept so an interrupted export
+    // resumes after the last one
+    parts    []exportPart
+    checksum string
+    attempts int
+}
+
+// exportPart is a file of an export stored on its own before assembly
+type exportPart struct {
+    Name     string `json:"name"`
+    Rows     int    `json:"rows"`
+    Checksum string `json:"checksum"`
+}
+
+// ExportManifest lists the files of an export archive with their row counts
+type ExportManifest struct {
+    UserID    uuid.UUID    `json:"user_id"`
+    ExportID  int64        `json:"export_id"`
+    CreatedAt time.Time    `json:"created_at"`
+    Files     []ExportFile `json:"files"`
+}
+
+// Exporter assembles users' data into zip archives in the artifact store,
+// one file at a time and streaming rows, so an export of any size runs in
+// constant memory and resumes after a restart
+type Exporter struct {
+    db       *sql.DB
+    store    artifacts.Store
+    cfg      ExportConfig
+    datasets []exportDataset
+    now      func() time.Time
+}
+
+func NewExporter(db *sql.DB, store artifacts.Store, cfg ExportConfig) *Exporter {
+    if cfg.MinInterval <= 0 {
+        cfg.MinInterval = 24 * time.Hour
+    }
+    if cfg.Retention <= 0 {
+        cfg.Retention = 7 * 24 * time.Hour
+    }
+    if cfg.URLTTL <= 0 || cfg.URLTTL > cfg.Retention {
+        cfg.URLTTL = 24 * time.Hour
+    }
+    return &Exporter{db: db, store: store, cfg: cfg, datasets: exportDatasets, now: time.Now}
+}
+
+// Enqueue queues an export of the user's data for Run to pick up. Users
+// get one export per MinInterval.
+func (e *Exporter) Enqueue(ctx context.Context, userID uuid.UUID) (*ExportJob, error) {
+    var last time.Time
+    err := e.db.QueryRowContext(ctx,
+        `SELECT created_at FROM user_exports WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`,
+        userID).Scan(&last)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, fmt.Errorf("failed to check last export: %w", err)
+    }
+    if err == nil {
+        if next := last.Add(e.cfg.MinInterval); e.now().Before(next) {
+            return nil, fmt.Errorf("%w: next export allowed at %s", ErrExportRateLimited, next.UTC().Format(time.RFC3339))
+        }
+    }
+
+    job := &ExportJob{UserID: userID, Status: ExportPending, TotalFiles: len(e.datasets) + 1, Files: []ExportFile{}}
+    query := `
+        INSERT INTO user_exports (user_id, status, total_files, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $4)
+        RETURNING id, created_at
+    `
+    err = e.db.QueryRowContext(ctx, query, userID, ExportPending, job.TotalFiles, e.now()).Scan(&job.ID, &job.CreatedAt)
+    if err != nil {
+        return nil, fmt.Errorf("failed to queue export: %w", err)
+    }
+    return job, nil
+}
+
+const exportColumns = `id, user_id, status, total_files, parts, checksum, error, attempts,
+    created_at, started_at, completed_at, expires_at`
+
+// Get returns the user's export, with a download URL once it completes
+func (e *Exporter) Get(ctx context.Context, userID uuid.UUID, id int64) (*ExportJob, error) {
+    query := `SELECT ` + exportColumns + ` FROM user_exports WHERE id = $1 AND user_id = $2`
+    job, err := scanExportJob(e.db.QueryRowContext(ctx, query, id, userID))
+    if err == sql.ErrNoRows {
+        return nil, ErrExportNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if job.Status == ExportCompleted {
+        expires := e.now().Add(e.cfg.URLTTL)
+        if job.ExpiresAt != nil && job.ExpiresAt.Before(expires) {
+            expires = *job.ExpiresAt
+        }
+        job.DownloadURL = fmt.Sprintf("/api/v1/exports/%d/download?expires=%d&signature=%s",
+            job.ID, expires.Unix(), e.sign(job.ID, expires.Unix()))
+    }
+    return job, nil
+}
+
+func scanExportJob(row rowScanner) (*ExportJob, error) {
+    var job ExportJob
+    var parts []byte
+    var checksum, jobErr sql.NullString
+    var startedAt, completedAt, expiresAt sql.NullTime
+    err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.TotalFiles, &parts, &checksum, &jobErr,
+        &job.attempts, &job.CreatedAt, &startedAt, &completedAt, &expiresAt)
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(parts, &job.parts); err != nil {
+        return nil, fmt.Errorf("export %d parts: %w", job.ID, err)
+    }
+    job.Files = make([]ExportFile, len(job.parts))
+    for i, p := range job.parts {
+        job.Files[i] = ExportFile{Name: p.Name, Rows: p.Rows}
+    }
+    job.checksum = checksum.String
+    job.Error = jobErr.String
+    if startedAt.Valid {
+        job.StartedAt = &startedAt.Time
+    }
+    if completedAt.Valid {
+        job.CompletedAt = &completedAt.Time
+    }
+    if expiresAt.Valid {
+        job.ExpiresAt = &expiresAt.Time
+    }
+    return &job, nil
+}
+
+// sign is the signature of a download URL for export id valid until expires
+func (e *Exporter) sign(id, expires int64) string {
+    mac := hmac.New(sha256.New, e.cfg.SigningKey)
+    mac.Write([]byte(strconv.FormatInt(id, 10) + ":" + strconv.FormatInt(expires, 10)))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Open verifies a signed download URL and returns the export's archive,
+// which the caller closes. The archive is an io.ReadSeeker when the store
+// supports it, so downloads can be resumed with range requests.
+func (e *Exporter) Open(ctx context.Context, id, expires int64, signature string) (io.ReadCloser, error) {
+    if e.now().Unix() > expires || !hmac.Equal([]byte(signature), []byte(e.sign(id, expires))) {
+        return nil, ErrInvalidSignature
+    }
+
+    var status string
+    var checksum sql.NullString
+    err := e.db.QueryRowContext(ctx, `SELECT status, checksum FROM user_exports WHERE id = $1`, id).Scan(&status, &checksum)
+    if err == sql.ErrNoRows {
+        return nil, ErrExportNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if status != ExportCompleted || !checksum.Valid {
+        return nil, ErrExportNotReady
+    }
+    return e.store.Get(ctx, checksum.String)
+}
+
+// Run claims the oldest pending export, or a running one that has stalled,
+// and works through it. Each file is stored as soon as it is written, so
+// an interrupted export resumes after its last stored file. It is meant to
+// run on a schedule.
+func (e *Exporter) Run(ctx context.Context) error {
+    job, err := e.claim(ctx)
+    if err != nil || job == nil {
+        return err
+    }
+
+    var email string
+    if err := e.db.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1`, job.UserID).Scan(&email); err != nil {
+        return e.release(ctx, job, fmt.Errorf("failed to get user of export %d: %w", job.ID, err))
+    }
+    scope := exportScope{userID: job.UserID, email: email}
+
+    if len(job.parts) > len(e.datasets) {
+        job.parts = job.parts[:0]
+    }
+    for _, dataset := range e.datasets[len(job.parts):] {
+        part, err := e.storePart(ctx, job, dataset, scope)
+        if err != nil {
+            return e.release(ctx, job, err)
+        }
+        job.parts = append(job.parts, part)
+        job.Files = append(job.Files, ExportFile{Name: part.Name, Rows: part.Rows})
+        if err := e.saveProgress(ctx, job); err != nil {
+            return e.release(ctx, job, err)
+        }
+    }
+
+    checksum, err := e.assemble(ctx, job)
+    if err != nil {
+        return e.release(ctx, job, err)
+    }
+    return e.complete(ctx, job, checksum)
+}
+
+func (e *Exporter) claim(ctx context.Context) (*ExportJob, error) {
+    now := e.now()
+    query := `
+        UPDATE user_exports
+        SET status = $1, started_at = COALESCE(started_at, $2), updated_at = $2, attempts = attempts + 1
+        WHERE id = (
+            SELECT id FROM user_exports
+            WHERE status = $3 OR (status = $1 AND updated_at < $4)
+            ORDER BY id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING ` + exportColumns
+    job, err := scanExportJob(e.db.QueryRowContext(ctx, query,
+        ExportRunning, now, ExportPending, now.Add(-exportStaleAfter)))
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim export: %w", err)
+    }
+    return job, nil
+}
+
+// storePart writes one dataset to the artifact store, streaming its rows
+// through a pipe
+func (e *Exporter) storePart(ctx context.Context, job *ExportJob, dataset exportDataset, scope exportScope) (exportPart, error) {
+    r, w := io.Pipe()
+    written := make(chan int, 1)
+    go func() {
+        rows, err := dataset.write(ctx, e.db, scope, w)
+        written <- rows
+        w.CloseWithError(err)
+    }()
+    checksum, err := e.store.Put(ctx, exportArtifact, partVersion(job.ID, dataset.name), r)
+    r.Close()
+    if err != nil {
+        return exportPart{}, fmt.Errorf("failed to export %s: %w", dataset.name, err)
+    }
+    return exportPart{Name: dataset.name, Rows: <-written, Checksum: checksum}, nil
+}
+
+func partVersion(id int64, name string) string {
+    return fmt.Sprintf("%d.%s", id, name)
+}
+
+// assemble zips the stored files and a manifest into the export's archive
+func (e *Exporter) assemble(ctx context.Context, job *ExportJob) (string, error) {
+    r, w := io.Pipe()
+    go func() {
+        w.CloseWithError(e.writeArchive(ctx, job, w))
+    }()
+    checksum, err := e.store.Put(ctx, exportArtifact, strconv.FormatInt(job.ID, 10), r)
+    r.Close()
+    if err != nil {
+        return "", fmt.Errorf("failed to store export %d: %w", job.ID, err)
+    }
+    return checksum, nil
+}
+
+func (e *Exporter) writeArchive(ctx context.Context, job *ExportJob, w io.Writer) error {
+    archive := zip.NewWriter(w)
+    for _, part := range job.parts {
+        entry, err := archive.CreateHeader(&zip.FileHeader{Name: part.Name, Method: zip.Deflate, Modified: job.CreatedAt})
+        if err != nil {
+            return err
+        }
+        content, err := e.store.Get(ctx, part.Checksum)
+        if err != nil {
+            return fmt.Errorf("failed to read %s: %w", part.Name, err)
+        }
+        _, err = io.Copy(entry, content)
+        content.Close()
+        if err != nil {
+            return err
+        }
+    }
+
+    manifest := ExportManifest{UserID: job.UserID, ExportID: job.ID, CreatedAt: job.CreatedAt, Files: job.Files}
+    entry, err := archive.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: job.CreatedAt})
+    if err != nil {
+        return err
+    }
+    encoder := json.NewEncoder(entry)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(manifest); err != nil {
+        return err
+    }
+    return archive.Close()
+}
+
+func (e *Exporter) saveProgress(ctx context.Context, job *ExportJob) error {
+    parts, err := json.Marshal(job.parts)
+    if err != nil {
+        return err
+    }
+    query := `UPDATE user_exports SET parts = $2, updated_at = $3 WHERE id = $1`
+    if _, err := e.db.ExecContext(ctx, query, job.ID, parts, e.now()); err != nil {
+        return fmt.Errorf("failed to save progress of export %d: %w", job.ID, err)
+    }
+    return nil
+}
+
+func (e *Exporter) complete(ctx context.Context, job *ExportJob, checksum string) error {
+    now := e.now()
+    expires := now.Add(e.cfg.Retention)
+    query := `
+        UPDATE user_exports
+        SET status = $2, checksum = $3, completed_at = $4, expires_at = $5, updated_at = $4
+        WHERE id = $1
+    `
+    if _, err := e.db.ExecContext(ctx, query, job.ID, ExportCompleted, checksum, now, expires); err != nil {
+        return fmt.Errorf("failed to complete export %d: %w", job.ID, err)
+    }
+    job.Status = ExportCompleted
+    job.checksum = checksum
+    job.CompletedAt = &now
+    job.ExpiresAt = &expires
+
+    // The archive holds a copy of every file
+    e.deleteParts(ctx, job)
+    return nil
+}
+
+func (e *Exporter) deleteParts(ctx context.Context, job *ExportJob) {
+    for _, dataset := range e.datasets {
+        e.store.Delete(ctx, exportArtifact, partVersion(job.ID, dataset.name))
+    }
+}
+
+// release hands an interrupted export back to the queue, keeping the files
+// already stored, or fails it once it has used up its attempts
+func (e *Exporter) release(ctx context.Context, job *ExportJob, cause error) error {
+    // ctx may be what was cancelled, so this detaches from it
+    ctx, cancel := lifecycle.Detach(ctx, 5*time.Second)
+    defer cancel()
+
+    status := ExportPending
+    if job.attempts >= maxExportAttempts {
+        status = ExportFailed
+        e.deleteParts(ctx, job)
+        job.parts = nil
+    }
+    parts, err := json.Marshal(job.parts)
+    if err != nil {
+        return err
+    }
+    query := `
+        UPDATE user_exports SET status = $2, parts = $3, error = $4, updated_at = $5
+        WHERE id = $1
+    `
+    if _, err := e.db.ExecContext(ctx, query, job.ID, status, parts, cause.Error(), e.now()); err != nil {
+        return fmt.Errorf("failed to release export %d after %v: %w", job.ID, cause, err)
+    }
+    return fmt.Errorf("export %d interrupted: %w", job.ID, cause)
+}
+
+// Cleanup deletes the archives of exports past their retention and returns
+// how many it deleted
+func (e *Exporter) Cleanup(ctx context.Context) (int, error) {
+    rows, err := e.db.QueryContext(ctx,
+        `SELECT id FROM user_exports WHERE status = $1 AND expires_at < $2 ORDER BY id`,
+        ExportCompleted, e.now())
+    if err != nil {
+        return 0, fmt.Errorf("failed to list expired exports: %w", err)
+    }
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    deleted := 0
+    for _, id := range ids {
+        if err := e.store.Delete(ctx, exportArtifact, strconv.FormatInt(id, 10)); err != nil {
+            return deleted, fmt.Errorf("failed to delete export %d: %w", id, err)
+        }
+        query := `UPDATE user_exports SET status = $2, checksum = NULL, updated_at = $3 WHERE id = $1`
+        if _, err := e.db.ExecContext(ctx, query, id, ExportExpired, e.now()); err != nil {
+            return deleted, fmt.Errorf("failed to expire export %d: %w", id, err)
+        }
+        deleted++
+    }
+    return deleted, nil
+}
@@ -0,0 +1,235 @@
+package jobs
+
+import (
+    "context"
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "io"
+    "regexp"
+    "strings"
+
+    "github.com/google/uuid"
+)
+
+// redacted replaces details of other users in an export
+const redacted = "[redacted]"
+
+// exportFlushRows is how many rows are buffered before a flush, so a
+// file's rows stream out rather than build up
+const exportFlushRows = 500
+
+var uuidPattern = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// exportScope is the user whose data is exported
+type exportScope struct {
+    userID uuid.UUID
+    email  string
+}
+
+// redactAudit hides other users in an audit entry concerning the user:
+// the admin who acted on the account, and other users' IDs in the target
+// and details
+func (s exportScope) redactAudit(row map[string]string, column, value string) string {
+    switch column {
+    case "actor_email", "ip":
+        if row["actor_email"] != s.email {
+            return redacted
+        }
+    case "target_id", "details":
+        own := s.userID.String()
+        return uuidPattern.ReplaceAllStringFunc(value, func(id string) string {
+            if strings.EqualFold(id, own) {
+                return id
+            }
+            return redacted
+        })
+    }
+    return value
+}
+
+// exportDataset is one file of an export. write streams the user's rows
+// to w and returns how many it wrote.
+type exportDataset struct {
+    name  string
+    write func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error)
+}
+
+// rowRedactor rewrites a column's value of one row before it is written.
+// It sees the whole row, keyed by column, so it can depend on other columns.
+type rowRedactor func(row map[string]string, column, value string) string
+
+var exportDatasets = []exportDataset{
+    {name: "profile.json", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeJSONRows(ctx, db, w, nil, `
+            SELECT id, email, name, role, timezone, base_currency, created_at, updated_at, last_login_at
+            FROM users
+            WHERE id = $1
+        `, scope.userID)
+    }},
+    {name: "portfolios.csv", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeCSVRows(ctx, db, w, `
+            SELECT id, name, description, created_at, updated_at
+            FROM portfolios
+            WHERE user_id = $1
+            ORDER BY id
+        `, scope.userID)
+    }},
+    {name: "positions.csv", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeCSVRows(ctx, db, w, `
+            SELECT portfolio_id, symbol, quantity, entry_price, created_at, updated_at
+            FROM positions
+            WHERE portfolio_id IN (SELECT id FROM portfolios WHERE user_id = $1)
+            ORDER BY portfolio_id, id
+        `, scope.userID)
+    }},
+    {name: "trades.csv", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeCSVRows(ctx, db, w, `
+            SELECT id, portfolio_id, symbol, side, quantity, price, fee, executed_at
+            FROM trades
+            WHERE portfolio_id IN (SELECT id FROM portfolios WHERE user_id = $1)
+            ORDER BY portfolio_id, executed_at, id
+        `, scope.userID)
+    }},
+    {name: "snapshots.csv", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeCSVRows(ctx, db, w, `
+            SELECT portfolio_id, snapshot_date, taken_at, timezone, total_value
+            FROM portfolio_snapshots
+            WHERE portfolio_id IN (SELECT id FROM portfolios WHERE user_id = $1)
+            ORDER BY portfolio_id, snapshot_date
+        `, scope.userID)
+    }},
+    {name: "cashflows.csv", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        return writeCSVRows(ctx, db, w, `
+            SELECT portfolio_id, amount, occurred_at
+            FROM portfolio_cashflows
+            WHERE portfolio_id IN (SELECT id FROM portfolios WHERE user_id = $1)
+            ORDER BY portfolio_id, occurred_at, id
+        `, scope.userID)
+    }},
+    {name: "audit_log.json", write: func(ctx context.Context, db *sql.DB, scope exportScope, w io.Writer) (int, error) {
+        // Entries the user caused, and those others made on their account
+        return writeJSONRows(ctx, db, w, scope.redactAudit, `
+            SELECT id, actor_email, action, target_id, details, ip, created_at
+            FROM audit_logs
+            WHERE target_id = $1 OR actor_email = $2
+            ORDER BY id
+        `, scope.userID.String(), scope.email)
+    }},
+}
+
+// scanRows runs query, calling header with its columns and then row with
+// each row's values, which are reused between rows
+func scanRows(ctx context.Context, db *sql.DB, query string, args []interface{}, header func(columns []string) error, row func(values []sql.NullString) error) error {
+    rows, err := db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        return err
+    }
+    if err := header(columns); err != nil {
+        return err
+    }
+    values := make([]sql.NullString, len(columns))
+    dest := make([]interface{}, len(columns))
+    for i := range values {
+        dest[i] = &values[i]
+    }
+    for rows.Next() {
+        if err := rows.Scan(dest...); err != nil {
+            return err
+        }
+        if err := row(values); err != nil {
+            return err
+        }
+    }
+    return rows.Err()
+}
+
+// writeCSVRows writes query's rows as CSV with a header of its columns.
+// NULL is written as an empty field.
+func writeCSVRows(ctx context.Context, db *sql.DB, w io.Writer, query string, args ...interface{}) (int, error) {
+    writer := csv.NewWriter(w)
+    var n int
+    var record []string
+    header := func(columns []string) error {
+        record = make([]string, len(columns))
+        return writer.Write(columns)
+    }
+    err := scanRows(ctx, db, query, args, header, func(values []sql.NullString) error {
+        for i, v := range values {
+            record[i] = v.String
+        }
+        if err := writer.Write(record); err != nil {
+            return err
+        }
+        n++
+        if n%exportFlushRows == 0 {
+            writer.Flush()
+        }
+        return writer.Error()
+    })
+    if err != nil {
+        return n, err
+    }
+    writer.Flush()
+    return n, writer.Error()
+}
+
+// writeJSONRows writes query's rows as a JSON array of objects keyed by
+// column, passing each value that isn't NULL through redact if it is set
+func writeJSONRows(ctx context.Context, db *sql.DB, w io.Writer, redact rowRedactor, query string, args ...interface{}) (int, error) {
+    if _, err := io.WriteString(w, "["); err != nil {
+        return 0, err
+    }
+    var n int
+    var columns []string
+    header := func(c []string) error {
+        columns = c
+        return nil
+    }
+    err := scanRows(ctx, db, query, args, header, func(values []sql.NullString) error {
+        row := make(map[string]string, len(columns))
+        for i, column := range columns {
+            row[column] = values[i].String
+        }
+        object := make(map[string]interface{}, len(columns))
+        for i, column := range columns {
+            if !values[i].Valid {
+                object[column] = nil
+                continue
+            }
+            value := values[i].String
+            if redact != nil {
+                value = redact(row, column, value)
+            }
+            object[column] = value
+        }
+
+        separator := "\n  "
+        if n > 0 {
+            separator = ",\n  "
+        }
+        if _, err := io.WriteString(w, separator); err != nil {
+            return err
+        }
+        encoded, err := json.Marshal(object)
+        if err != nil {
+            return err
+        }
+        if _, err := w.Write(encoded); err != nil {
+            return err
+        }
+        n++
+        return nil
+    })
+    if err != nil {
+        return n, err
+    }
+    _, err = io.WriteString(w, "\n]\n")
+    return n, err
+}
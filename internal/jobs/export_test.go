@@ -0,0 +1,236 @@
+package jobs
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
+)
+
+var exportJobColumns = []string{
+    "id", "user_id", "status", "total_files", "parts", "checksum", "error", "attempts",
+    "created_at", "started_at", "completed_at", "expires_at",
+}
+
+func TestExporter_Enqueue(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    userID := uuid.New()
+    exporter := NewExporter(db, nil, ExportConfig{})
+    exporter.now = func() time.Time { return now }
+
+    t.Run("Rate limited within a day of the last export", func(t *testing.T) {
+        mock.ExpectQuery("SELECT created_at FROM user_exports").
+            WithArgs(userID).
+            WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now.Add(-23 * time.Hour)))
+
+        _, err := exporter.Enqueue(context.Background(), userID)
+        assert.True(t, errors.Is(err, ErrExportRateLimited))
+        assert.Contains(t, err.Error(), "2024-03-12T10:00:00Z")
+    })
+
+    t.Run("Queued once the interval has passed", func(t *testing.T) {
+        mock.ExpectQuery("SELECT created_at FROM user_exports").
+            WithArgs(userID).
+            WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now.Add(-25 * time.Hour)))
+        mock.ExpectQuery("INSERT INTO user_exports").
+            WithArgs(userID, ExportPending, len(exportDatasets)+1, now).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(int64(9), now))
+
+        job, err := exporter.Enqueue(context.Background(), userID)
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, int64(9), job.ID)
+        assert.Equal(t, ExportPending, job.Status)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExporter_Open(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    store, err := artifacts.NewLocalStore(t.TempDir())
+    if err != nil {
+        t.Fatalf("Failed to create store: %v", err)
+    }
+    checksum, err := store.Put(context.Background(), exportArtifact, "3", strings.NewReader("archive"))
+    if err != nil {
+        t.Fatalf("Failed to store archive: %v", err)
+    }
+
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    userID := uuid.New()
+    exporter := NewExporter(db, store, ExportConfig{SigningKey: []byte("secret")})
+    exporter.now = func() time.Time { return now }
+
+    mock.ExpectQuery("SELECT (.+) FROM user_exports WHERE id = \\$1 AND user_id = \\$2").
+        WithArgs(int64(3), userID).
+        WillReturnRows(sqlmock.NewRows(exportJobColumns).
+            AddRow(int64(3), userID, ExportCompleted, 2, []byte("[]"), checksum, nil, 1, now, now, now, now.Add(time.Hour)))
+    job, err := exporter.Get(context.Background(), userID, 3)
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // The URL expires with the archive when that is sooner than its TTL
+    link, err := url.Parse(job.DownloadURL)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, "/api/v1/exports/3/download", link.Path)
+    expires, _ := strconv.ParseInt(link.Query().Get("expires"), 10, 64)
+    assert.Equal(t, now.Add(time.Hour).Unix(), expires)
+    signature := link.Query().Get("signature")
+
+    t.Run("Tampered signature", func(t *testing.T) {
+        _, err := exporter.Open(context.Background(), 4, expires, signature)
+        assert.Equal(t, ErrInvalidSignature, err)
+        _, err = exporter.Open(context.Background(), 3, expires+3600, signature)
+        assert.Equal(t, ErrInvalidSignature, err)
+    })
+
+    t.Run("Expired URL", func(t *testing.T) {
+        exporter.now = func() time.Time { return now.Add(2 * time.Hour) }
+        defer func() { exporter.now = func() time.Time { return now } }()
+
+        _, err := exporter.Open(context.Background(), 3, expires, signature)
+        assert.Equal(t, ErrInvalidSignature, err)
+    })
+
+    t.Run("Valid URL", func(t *testing.T) {
+        mock.ExpectQuery("SELECT status, checksum FROM user_exports").
+            WithArgs(int64(3)).
+            WillReturnRows(sqlmock.NewRows([]string{"status", "checksum"}).AddRow(ExportCompleted, checksum))
+
+        archive, err := exporter.Open(context.Background(), 3, expires, signature)
+        if !assert.NoError(t, err) {
+            return
+        }
+        defer archive.Close()
+        content, _ := io.ReadAll(archive)
+        assert.Equal(t, "archive", string(content))
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExporter_Run(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    store, err := artifacts.NewLocalStore(t.TempDir())
+    if err != nil {
+        t.Fatalf("Failed to create store: %v", err)
+    }
+
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    userID := uuid.New()
+    otherID := uuid.New()
+    exporter := NewExporter(db, store, ExportConfig{SigningKey: []byte("secret")})
+    exporter.now = func() time.Time { return now }
+    exporter.datasets = []exportDataset{exportDatasets[1], exportDatasets[len(exportDatasets)-1]}
+
+    // The portfolios file was stored before the export was interrupted
+    portfolios := "id,name,description,created_at,updated_at\n1,Core,,2024-01-01,2024-01-01\n"
+    stored, err := store.Put(context.Background(), exportArtifact, partVersion(5, "portfolios.csv"), strings.NewReader(portfolios))
+    if err != nil {
+        t.Fatalf("Failed to store part: %v", err)
+    }
+    parts, _ := json.Marshal([]exportPart{{Name: "portfolios.csv", Rows: 1, Checksum: stored}})
+
+    mock.ExpectQuery("UPDATE user_exports SET status (.+) RETURNING").
+        WillReturnRows(sqlmock.NewRows(exportJobColumns).
+            AddRow(int64(5), userID, ExportRunning, 3, parts, nil, nil, 2, now, now, nil, nil))
+    mock.ExpectQuery("SELECT email FROM users").
+        WithArgs(userID).
+        WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("user@example.com"))
+    mock.ExpectQuery("SELECT (.+) FROM audit_logs").
+        WithArgs(userID.String(), "user@example.com").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "actor_email", "action", "target_id", "details", "ip", "created_at"}).
+            AddRow(1, "user@example.com", "portfolio.share", otherID.String(), `{"owner":"`+userID.String()+`"}`, "10.0.0.1", now).
+            AddRow(2, "admin@example.com", "user.lock", userID.String(), nil, "10.0.0.2", now))
+    mock.ExpectExec("UPDATE user_exports SET parts").
+        WithArgs(int64(5), sqlmock.AnyArg(), now).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("UPDATE user_exports SET status (.+) completed_at").
+        WithArgs(int64(5), ExportCompleted, sqlmock.AnyArg(), now, now.Add(7*24*time.Hour)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    if !assert.NoError(t, exporter.Run(context.Background())) {
+        return
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    infos, err := store.List(context.Background(), exportArtifact, "5")
+    if !assert.NoError(t, err) || !assert.Len(t, infos, 1) {
+        return
+    }
+    content, err := store.Get(context.Background(), infos[0].Checksum)
+    if !assert.NoError(t, err) {
+        return
+    }
+    data, _ := io.ReadAll(content)
+    content.Close()
+    archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    files := make(map[string]string)
+    for _, f := range archive.File {
+        r, _ := f.Open()
+        b, _ := io.ReadAll(r)
+        r.Close()
+        files[f.Name] = string(b)
+    }
+    assert.Equal(t, portfolios, files["portfolios.csv"])
+
+    var manifest ExportManifest
+    if !assert.NoError(t, json.Unmarshal([]byte(files["manifest.json"]), &manifest)) {
+        return
+    }
+    assert.Equal(t, []ExportFile{{Name: "portfolios.csv", Rows: 1}, {Name: "audit_log.json", Rows: 2}}, manifest.Files)
+
+    var audit []map[string]interface{}
+    if !assert.NoError(t, json.Unmarshal([]byte(files["audit_log.json"]), &audit)) || !assert.Len(t, audit, 2) {
+        return
+    }
+    assert.Equal(t, redacted, audit[0]["target_id"])
+    assert.Equal(t, `{"owner":"`+userID.String()+`"}`, audit[0]["details"])
+    assert.Equal(t, "10.0.0.1", audit[0]["ip"])
+    assert.Equal(t, redacted, audit[1]["actor_email"])
+    assert.Equal(t, redacted, audit[1]["ip"])
+    assert.Equal(t, userID.String(), audit[1]["target_id"])
+    assert.Nil(t, audit[1]["details"])
+
+    // Only the archive outlives the export
+    partInfos, _ := store.List(context.Background(), exportArtifact, partVersion(5, "portfolios.csv"))
+    assert.Empty(t, partInfos)
+}
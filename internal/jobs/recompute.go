@@ -0,0 +1,564 @@
+package jobs
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/google/uuid"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+)
+
+// Recompute job statuses
+const (
+    RecomputePending   = "pending"
+    RecomputeRunning   = "running"
+    RecomputePaused    = "paused"
+    RecomputeCompleted = "completed"
+)
+
+const (
+    // maxRecomputeErrors bounds the failures kept on a job; Failed still
+    // counts them all
+    maxRecomputeErrors = 100
+    // maxScopePortfolios bounds an explicit portfolio list
+    maxScopePortfolios = 10000
+    // recomputeStaleAfter is how long a running job may go without progress
+    // before another worker takes it over, e.g. after a crash
+    recomputeStaleAfter = 10 * time.Minute
+    // loadPollInterval is how often a throttled job rechecks the pool
+    loadPollInterval = time.Second
+)
+
+var (
+    ErrRecomputeNotFound = errors.New("recompute job not found")
+    ErrInvalidScope      = errors.New("invalid recompute scope")
+    // ErrRecomputeState is returned when pausing or resuming a job whose
+    // status doesn't allow it
+    ErrRecomputeState = errors.New("recompute job can't change state")
+)
+
+// RecomputeScope selects what a recompute covers: every portfolio, or
+// those listed, over all days or those from From to To. With a date range
+// and no list, only portfolios with snapshots in the range are visited.
+type RecomputeScope struct {
+    PortfolioIDs []int64 `json:"portfolio_ids,omitempty"`
+    // From and To are YYYY-MM-DD dates, either of which may be empty
+    From string `json:"from,omitempty"`
+    To   string `json:"to,omitempty"`
+}
+
+// normalize validates the scope and sorts and dedupes its portfolio list
+func (s *RecomputeScope) normalize() error {
+    if len(s.PortfolioIDs) > maxScopePortfolios {
+        return fmt.Errorf("%w: at most %d portfolios", ErrInvalidScope, maxScopePortfolios)
+    }
+    sort.Slice(s.PortfolioIDs, func(i, j int) bool { return s.PortfolioIDs[i] < s.PortfolioIDs[j] })
+    ids := s.PortfolioIDs[:0]
+    for i, id := range s.PortfolioIDs {
+        if id <= 0 {
+            return fmt.Errorf("%w: invalid portfolio ID %d", ErrInvalidScope, id)
+        }
+        if i == 0 || id != s.PortfolioIDs[i-1] {
+            ids = append(ids, id)
+        }
+    }
+    s.PortfolioIDs = ids
+
+    from, to, err := s.bounds()
+    if err != nil {
+        return err
+    }
+    if !from.IsZero() && !to.IsZero() && to.Before(from) {
+        return fmt.Errorf("%w: to is before from", ErrInvalidScope)
+    }
+    return nil
+}
+
+// bounds returns the scope's date range, with zero for an open end
+func (s RecomputeScope) bounds() (from, to time.Time, err error) {
+    if s.From != "" {
+        if from, err = time.Parse("2006-01-02", s.From); err != nil {
+            return from, to, fmt.Errorf("%w: from must be YYYY-MM-DD", ErrInvalidScope)
+        }
+    }
+    if s.To != "" {
+        if to, err = time.Parse("2006-01-02", s.To); err != nil {
+            return from, to, fmt.Errorf("%w: to must be YYYY-MM-DD", ErrInvalidScope)
+        }
+    }
+    return from, to, nil
+}
+
+func (s RecomputeScope) ranged() bool {
+    return s.From != "" || s.To != ""
+}
+
+// RecomputeError is a portfolio a recompute step failed on
+type RecomputeError struct {
+    PortfolioID int64  `json:"portfolio_id"`
+    Step        string `json:"step"`
+    Error       string `json:"error"`
+}
+
+// RecomputeJob is the state and progress of a recompute
+type RecomputeJob struct {
+    ID                 int64            `json:"id"`
+    Scope              RecomputeScope   `json:"scope"`
+    Status             string           `json:"status"`
+    Total              int              `json:"total"`
+    Done               int              `json:"done"`
+    Failed             int              `json:"failed"`
+    CurrentPortfolioID *int64           `json:"current_portfolio_id,omitempty"`
+    Errors             []RecomputeError `json:"errors"`
+    CreatedAt          time.Time        `json:"created_at"`
+    StartedAt          *time.Time       `json:"started_at,omitempty"`
+    CompletedAt        *time.Time       `json:"completed_at,omitempty"`
+
+    // cursor is the last portfolio finished
+    cursor int64
+}
+
+func (j *RecomputeJob) fail(portfolioID int64, step string, err error) {
+    j.Failed++
+    if len(j.Errors) < maxRecomputeErrors {
+        j.Errors = append(j.Errors, RecomputeError{PortfolioID: portfolioID, Step: step, Error: err.Error()})
+    }
+}
+
+// RecomputeStep recomputes one kind of derived data for a portfolio. from
+// and to are the scope's date range, zero where open.
+type RecomputeStep struct {
+    Name string
+    Run  func(ctx context.Context, portfolioID int64, from, to time.Time) error
+}
+
+// RecomputeConfig sizes a recompute and bounds the database load it adds
+type RecomputeConfig struct {
+    // BatchSize is how many portfolios are fetched at a time
+    BatchSize int
+    // DutyCycle is the share of time, in (0, 1], the job may spend working.
+    // After each portfolio it sleeps long enough to stay within it.
+    DutyCycle float64
+    // MaxConnsInUse holds the job back while at least this many pool
+    // connections are busy with other work; 0 disables the check
+    MaxConnsInUse int
+}
+
+// Recomputer works through queued recompute jobs, one portfolio at a time,
+// recording progress so jobs can be paused, resumed and survive restarts
+type Recomputer struct {
+    db         *sql.DB
+    steps      []RecomputeStep
+    cfg        RecomputeConfig
+    onComplete func()
+    now        func() time.Time
+    sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func NewRecomputer(db *sql.DB, cfg RecomputeConfig, steps ...RecomputeStep) *Recomputer {
+    if cfg.BatchSize <= 0 {
+        cfg.BatchSize = 50
+    }
+    if cfg.DutyCycle <= 0 || cfg.DutyCycle > 1 {
+        cfg.DutyCycle = 1
+    }
+    return &Recomputer{db: db, steps: steps, cfg: cfg, now: time.Now, sleep: sleepContext}
+}
+
+// WithOnComplete calls fn after each job completes, e.g. to drop caches of
+// data derived from what the job recomputed
+func (r *Recomputer) WithOnComplete(fn func()) *Recomputer {
+    r.onComplete = fn
+    return r
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// Enqueue queues a recompute of scope for Run to pick up
+func (r *Recomputer) Enqueue(ctx context.Context, scope RecomputeScope, createdBy uuid.UUID) (*RecomputeJob, error) {
+    if err := scope.normalize(); err != nil {
+        return nil, err
+    }
+    raw, err := json.Marshal(scope)
+    if err != nil {
+        return nil, err
+    }
+
+    job := &RecomputeJob{Scope: scope, Status: RecomputePending, Errors: []RecomputeError{}}
+    query := `
+        INSERT INTO recompute_jobs (scope, status, created_by, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $4)
+        RETURNING id, created_at
+    `
+    err = r.db.QueryRowContext(ctx, query, raw, RecomputePending, createdBy, r.now()).Scan(&job.ID, &job.CreatedAt)
+    if err != nil {
+        return nil, fmt.Errorf("failed to queue recompute: %w", err)
+    }
+    return job, nil
+}
+
+// Get returns a job's status and progress
+func (r *Recomputer) Get(ctx context.Context, id int64) (*RecomputeJob, error) {
+    query := `
+        SELECT id, scope, status, total, done, failed, cursor, current_portfolio_id,
+            errors, created_at, started_at, completed_at
+        FROM recompute_jobs
+        WHERE id = $1
+    `
+    job, err := scanRecomputeJob(r.db.QueryRowContext(ctx, query, id))
+    if err == sql.ErrNoRows {
+        return nil, ErrRecomputeNotFound
+    }
+    return job, err
+}
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanRecomputeJob(row rowScanner) (*RecomputeJob, error) {
+    var job RecomputeJob
+    var scope, errs []byte
+    var current sql.NullInt64
+    var startedAt, completedAt sql.NullTime
+    err := row.Scan(&job.ID, &scope, &job.Status, &job.Total, &job.Done, &job.Failed, &job.cursor,
+        &current, &errs, &job.CreatedAt, &startedAt, &completedAt)
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(scope, &job.Scope); err != nil {
+        return nil, fmt.Errorf("recompute job %d scope: %w", job.ID, err)
+    }
+    if err := json.Unmarshal(errs, &job.Errors); err != nil {
+        return nil, fmt.Errorf("recompute job %d errors: %w", job.ID, err)
+    }
+    if current.Valid {
+        job.CurrentPortfolioID = &current.Int64
+    }
+    if startedAt.Valid {
+        job.StartedAt = &startedAt.Time
+    }
+    if completedAt.Valid {
+        job.CompletedAt = &completedAt.Time
+    }
+    return &job, nil
+}
+
+// Pause stops a pending or running job after the portfolio it is on
+func (r *Recomputer) Pause(ctx context.Context, id int64) (*RecomputeJob, error) {
+    query := `
+        UPDATE recompute_jobs SET status = $2, updated_at = $3
+        WHERE id = $1 AND status IN ($4, $5)
+    `
+    return r.transition(ctx, id, query, RecomputePaused, r.now(), RecomputePending, RecomputeRunning)
+}
+
+// Resume queues a paused job to continue where it stopped
+func (r *Recomputer) Resume(ctx context.Context, id int64) (*RecomputeJob, error) {
+    query := `
+        UPDATE recompute_jobs SET status = $2, updated_at = $3
+        WHERE id = $1 AND status = $4
+    `
+    return r.transition(ctx, id, query, RecomputePending, r.now(), RecomputePaused)
+}
+
+func (r *Recomputer) transition(ctx context.Context, id int64, query string, args ...interface{}) (*RecomputeJob, error) {
+    result, err := r.db.ExecContext(ctx, query, append([]interface{}{id}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return nil, err
+    }
+
+    job, err := r.Get(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if n == 0 {
+        return nil, fmt.Errorf("%w: job %d is %s", ErrRecomputeState, id, job.Status)
+    }
+    return job, nil
+}
+
+// Run claims the oldest pending job, or a running one that has stalled,
+// and works through it until it completes, is paused or ctx is done. It is
+// meant to run on a schedule.
+func (r *Recomputer) Run(ctx context.Context) error {
+    job, err := r.claim(ctx)
+    if err != nil || job == nil {
+        return err
+    }
+    from, to, err := job.Scope.bounds()
+    if err != nil {
+        return err
+    }
+
+    if job.cursor == 0 && job.Done == 0 {
+        if job.Total, err = r.count(ctx, job.Scope); err != nil {
+            return r.release(ctx, job, err)
+        }
+    }
+
+    for {
+        ids, err := r.nextBatch(ctx, job)
+        if err != nil {
+            return r.release(ctx, job, err)
+        }
+        if len(ids) == 0 {
+            return r.complete(ctx, job)
+        }
+
+        for _, id := range ids {
+            if err := r.throttle(ctx); err != nil {
+                return r.release(ctx, job, err)
+            }
+            // Saving progress before each portfolio is also how a pause is
+            // noticed
+            current := id
+            job.CurrentPortfolioID = &current
+            status, err := r.saveProgress(ctx, job)
+            if err != nil {
+                return r.release(ctx, job, err)
+            }
+            if status != RecomputeRunning {
+                return nil
+            }
+
+            start := r.now()
+            for _, step := range r.steps {
+                if err := step.Run(ctx, id, from, to); err != nil {
+                    if ctx.Err() != nil {
+                        return r.release(ctx, job, ctx.Err())
+                    }
+                    job.fail(id, step.Name, err)
+                    break
+                }
+            }
+            job.Done++
+            job.cursor = id
+
+            if err := r.rest(ctx, r.now().Sub(start)); err != nil {
+                return r.release(ctx, job, err)
+            }
+        }
+    }
+}
+
+func (r *Recomputer) claim(ctx context.Context) (*RecomputeJob, error) {
+    now := r.now()
+    query := `
+        UPDATE recompute_jobs
+        SET status = $1, started_at = COALESCE(started_at, $2), updated_at = $2
+        WHERE id = (
+            SELECT id FROM recompute_jobs
+            WHERE status = $3 OR (status = $1 AND updated_at < $4)
+            ORDER BY id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, scope, status, total, done, failed, cursor, current_portfolio_id,
+            errors, created_at, started_at, completed_at
+    `
+    job, err := scanRecomputeJob(r.db.QueryRowContext(ctx, query,
+        RecomputeRunning, now, RecomputePending, now.Add(-recomputeStaleAfter)))
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim recompute job: %w", err)
+    }
+    return job, nil
+}
+
+func (r *Recomputer) count(ctx context.Context, scope RecomputeScope) (int, error) {
+    if len(scope.PortfolioIDs) > 0 {
+        return len(scope.PortfolioIDs), nil
+    }
+
+    query := `SELECT COUNT(*) FROM portfolios`
+    var args []interface{}
+    if scope.ranged() {
+        query = `
+            SELECT COUNT(*) FROM portfolios p
+            WHERE EXISTS (
+                SELECT 1 FROM portfolio_snapshots s
+                WHERE s.portfolio_id = p.id AND s.snapshot_date BETWEEN $1 AND $2
+            )
+        `
+        args = append(args, dateBound(scope.From, "-infinity"), dateBound(scope.To, "infinity"))
+    }
+    var total int
+    err := r.db.QueryRowContext(ctx, query, args...).Scan(&total)
+    return total, err
+}
+
+// nextBatch returns the next portfolios in scope after the job's cursor
+func (r *Recomputer) nextBatch(ctx context.Context, job *RecomputeJob) ([]int64, error) {
+    if len(job.Scope.PortfolioIDs) > 0 {
+        ids := job.Scope.PortfolioIDs
+        start := sort.Search(len(ids), func(i int) bool { return ids[i] > job.cursor })
+        end := start + r.cfg.BatchSize
+        if end > len(ids) {
+            end = len(ids)
+        }
+        return ids[start:end], nil
+    }
+
+    query := `SELECT id FROM portfolios WHERE id > $1 ORDER BY id LIMIT $2`
+    args := []interface{}{job.cursor, r.cfg.BatchSize}
+    if job.Scope.ranged() {
+        query = `
+            SELECT p.id FROM portfolios p
+            WHERE p.id > $1 AND EXISTS (
+                SELECT 1 FROM portfolio_snapshots s
+                WHERE s.portfolio_id = p.id AND s.snapshot_date BETWEEN $3 AND $4
+            )
+            ORDER BY p.id
+            LIMIT $2
+        `
+        args = append(args, dateBound(job.Scope.From, "-infinity"), dateBound(job.Scope.To, "infinity"))
+    }
+
+    rows, err := r.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list portfolios to recompute: %w", err)
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+func dateBound(date, open string) string {
+    if date == "" {
+        return open
+    }
+    return date
+}
+
+// throttle waits while the connection pool is busier than the load budget
+// allows
+func (r *Recomputer) throttle(ctx context.Context) error {
+    if r.cfg.MaxConnsInUse <= 0 {
+        return ctx.Err()
+    }
+    for r.db.Stats().InUse >= r.cfg.MaxConnsInUse {
+        if err := r.sleep(ctx, loadPollInterval); err != nil {
+            return err
+        }
+    }
+    return ctx.Err()
+}
+
+// rest sleeps after work so the job stays within its duty cycle
+func (r *Recomputer) rest(ctx context.Context, work time.Duration) error {
+    if r.cfg.DutyCycle >= 1 || work <= 0 {
+        return nil
+    }
+    return r.sleep(ctx, time.Duration(float64(work)*(1-r.cfg.DutyCycle)/r.cfg.DutyCycle))
+}
+
+// saveProgress records the job's progress and returns its status, which
+// is no longer running once it has been paused. A job that isn't running
+// has no current portfolio.
+func (r *Recomputer) saveProgress(ctx context.Context, job *RecomputeJob) (string, error) {
+    errs, err := json.Marshal(job.Errors)
+    if err != nil {
+        return "", err
+    }
+    query := `
+        UPDATE recompute_jobs
+        SET total = $2, done = $3, failed = $4, cursor = $5,
+            current_portfolio_id = CASE WHEN status = $9 THEN $6::bigint END,
+            errors = $7, updated_at = $8
+        WHERE id = $1
+        RETURNING status
+    `
+    var status string
+    err = r.db.QueryRowContext(ctx, query, job.ID, job.Total, job.Done, job.Failed, job.cursor,
+        job.CurrentPortfolioID, errs, r.now(), RecomputeRunning).Scan(&status)
+    if err != nil {
+        return "", fmt.Errorf("failed to save progress of recompute job %d: %w", job.ID, err)
+    }
+    return status, nil
+}
+
+func (r *Recomputer) complete(ctx context.Context, job *RecomputeJob) error {
+    job.CurrentPortfolioID = nil
+    status, err := r.saveProgress(ctx, job)
+    if err != nil {
+        return err
+    }
+    // A job paused after its last portfolio completes once resumed
+    if status != RecomputeRunning {
+        return nil
+    }
+
+    now := r.now()
+    query := `
+        UPDATE recompute_jobs SET status = $2, completed_at = $3, updated_at = $3
+        WHERE id = $1 AND status = $4
+    `
+    result, err := r.db.ExecContext(ctx, query, job.ID, RecomputeCompleted, now, RecomputeRunning)
+    if err != nil {
+        return fmt.Errorf("failed to complete recompute job %d: %w", job.ID, err)
+    }
+    if n, err := result.RowsAffected(); err != nil || n == 0 {
+        return err
+    }
+
+    job.Status = RecomputeCompleted
+    job.CompletedAt = &now
+    if r.onComplete != nil {
+        r.onComplete()
+    }
+    return nil
+}
+
+// release saves the job's progress and hands it back to the queue after
+// it was interrupted by cause, so the next Run resumes it
+func (r *Recomputer) release(ctx context.Context, job *RecomputeJob, cause error) error {
+    errs, err := json.Marshal(job.Errors)
+    if err != nil {
+        return err
+    }
+    // ctx may be what was cancelled, so this detaches from it
+    ctx, cancel := lifecycle.Detach(ctx, 5*time.Second)
+    defer cancel()
+    query := `
+        UPDATE recompute_jobs
+        SET status = CASE WHEN status = $9 THEN $2 ELSE status END,
+            total = $3, done = $4, failed = $5, cursor = $6, current_portfolio_id = NULL,
+            errors = $7, updated_at = $8
+        WHERE id = $1
+    `
+    if _, err := r.db.ExecContext(ctx, query, job.ID, RecomputePending, job.Total, job.Done, job.Failed,
+        job.cursor, errs, r.now(), RecomputeRunning); err != nil {
+        return fmt.Errorf("failed to release recompute job %d after %v: %w", job.ID, cause, err)
+    }
+    return fmt.Errorf("recompute job %d interrupted: %w", job.ID, cause)
+}
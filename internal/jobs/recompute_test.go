@@ -0,0 +1,152 @@
+package jobs
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+)
+
+var recomputeColumns = []string{
+    "id", "scope", "status", "total", "done", "failed", "cursor", "current_portfolio_id",
+    "errors", "created_at", "started_at", "completed_at",
+}
+
+func claimedJob(id int64, scope string, done int, cursor int64) *sqlmock.Rows {
+    created := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    return sqlmock.NewRows(recomputeColumns).
+        AddRow(id, []byte(scope), RecomputeRunning, 3, done, 0, cursor, nil, []byte("[]"), created, created, nil)
+}
+
+func TestRecomputer_Run(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    expectProgress := func(done, failed int, cursor int64, status string) {
+        mock.ExpectQuery("UPDATE recompute_jobs SET total (.+) RETURNING status").
+            WithArgs(int64(7), 3, done, failed, cursor, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), RecomputeRunning).
+            WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(status))
+    }
+
+    t.Run("Failed portfolio is recorded and skipped", func(t *testing.T) {
+        var visited []int64
+        var completed bool
+        recomputer := NewRecomputer(db, RecomputeConfig{BatchSize: 2},
+            RecomputeStep{Name: "snapshots", Run: func(ctx context.Context, id int64, from, to time.Time) error {
+                if id == 2 {
+                    return errors.New("no market data for DOGE")
+                }
+                return nil
+            }},
+            RecomputeStep{Name: "risk", Run: func(ctx context.Context, id int64, from, to time.Time) error {
+                visited = append(visited, id)
+                return nil
+            }},
+        ).WithOnComplete(func() { completed = true })
+
+        mock.ExpectQuery("UPDATE recompute_jobs SET status (.+) RETURNING").
+            WillReturnRows(claimedJob(7, `{"portfolio_ids":[1,2,3]}`, 0, 0))
+        expectProgress(0, 0, 0, RecomputeRunning)
+        expectProgress(1, 0, 1, RecomputeRunning)
+        expectProgress(2, 1, 2, RecomputeRunning)
+        expectProgress(3, 1, 3, RecomputeRunning)
+        mock.ExpectExec("UPDATE recompute_jobs SET status (.+) completed_at").
+            WithArgs(int64(7), RecomputeCompleted, sqlmock.AnyArg(), RecomputeRunning).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        assert.NoError(t, recomputer.Run(context.Background()))
+        // The failing step stops only its own portfolio
+        assert.Equal(t, []int64{1, 3}, visited)
+        assert.True(t, completed)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Pause stops before the next portfolio", func(t *testing.T) {
+        var visited []int64
+        recomputer := NewRecomputer(db, RecomputeConfig{},
+            RecomputeStep{Name: "snapshots", Run: func(ctx context.Context, id int64, from, to time.Time) error {
+                visited = append(visited, id)
+                return nil
+            }},
+        )
+
+        // Resumed after portfolio 1
+        mock.ExpectQuery("UPDATE recompute_jobs SET status (.+) RETURNING").
+            WillReturnRows(claimedJob(7, `{"portfolio_ids":[1,2,3]}`, 1, 1))
+        expectProgress(1, 0, 1, RecomputeRunning)
+        expectProgress(2, 0, 2, RecomputePaused)
+
+        assert.NoError(t, recomputer.Run(context.Background()))
+        assert.Equal(t, []int64{2}, visited)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Nothing queued", func(t *testing.T) {
+        recomputer := NewRecomputer(db, RecomputeConfig{})
+        mock.ExpectQuery("UPDATE recompute_jobs SET status (.+) RETURNING").
+            WillReturnRows(sqlmock.NewRows(recomputeColumns))
+
+        assert.NoError(t, recomputer.Run(context.Background()))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestRecomputer_Enqueue_ValidatesScope(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    recomputer := NewRecomputer(db, RecomputeConfig{})
+    ctx := context.Background()
+
+    invalid := []RecomputeScope{
+        {PortfolioIDs: []int64{3, -1}},
+        {From: "12/03/2024"},
+        {From: "2024-03-12", To: "2024-03-01"},
+    }
+    for _, scope := range invalid {
+        _, err := recomputer.Enqueue(ctx, scope, uuid.New())
+        assert.True(t, errors.Is(err, ErrInvalidScope), "scope %+v", scope)
+    }
+
+    mock.ExpectQuery("INSERT INTO recompute_jobs").
+        WithArgs([]byte(`{"portfolio_ids":[1,2,3],"from":"2024-03-01"}`), RecomputePending, sqlmock.AnyArg(), sqlmock.AnyArg()).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(9, time.Now()))
+
+    job, err := recomputer.Enqueue(ctx, RecomputeScope{PortfolioIDs: []int64{3, 1, 2, 3}, From: "2024-03-01"}, uuid.New())
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, int64(9), job.ID)
+    assert.Equal(t, []int64{1, 2, 3}, job.Scope.PortfolioIDs)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecomputer_Rest(t *testing.T) {
+    var slept []time.Duration
+    recomputer := NewRecomputer(nil, RecomputeConfig{DutyCycle: 0.25})
+    recomputer.sleep = func(ctx context.Context, d time.Duration) error {
+        slept = append(slept, d)
+        return nil
+    }
+
+    // Working a quarter of the time means resting three times as long
+    assert.NoError(t, recomputer.rest(context.Background(), time.Second))
+    assert.Equal(t, []time.Duration{3 * time.Second}, slept)
+
+    recomputer = NewRecomputer(nil, RecomputeConfig{})
+    recomputer.sleep = func(ctx context.Context, d time.Duration) error {
+        t.Errorf("Unexpected rest of %v at full duty cycle", d)
+        return nil
+    }
+    assert.NoError(t, recomputer.rest(context.Background(), time.Second))
+}
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// Job is a unit of background work run on a fixed interval
+type Job struct {
+    Name     string
+    Interval time.Duration
+    Run      func(ctx context.Context) error
+}
+
+type Scheduler struct {
+    logger *logger.Logger
+    jobs   []Job
+    wg     sync.WaitGroup
+}
+
+func NewScheduler(log *logger.Logger) *Scheduler {
+    return &Scheduler{logger: log}
+}
+
+// Register adds a job. Jobs must be registered before Start.
+func (s *Scheduler) Register(job Job) {
+    s.jobs = append(s.jobs, job)
+}
+
+// Start runs every registered job in its own goroutine until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+    for _, job := range s.jobs {
+        s.wg.Add(1)
+        go func(job Job) {
+            defer s.wg.Done()
+            s.loop(ctx, job)
+        }(job)
+    }
+}
+
+// Wait blocks until all jobs have stopped after their context is cancelled
+func (s *Scheduler) Wait() {
+    s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+    ticker := time.NewTicker(job.Interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            s.runOnce(ctx, job)
+        }
+    }
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+    start := time.Now()
+    err := job.Run(ctx)
+    if s.logger == nil {
+        return
+    }
+
+    fields := map[string]interface{}{
+        "job":      job.Name,
+        "duration": time.Since(start).Milliseconds(),
+    }
+    if err != nil {
+        fields["error"] = err.Error()
+        s.logger.WithFields(fields).Error("Job failed")
+        return
+    }
+    s.logger.WithFields(fields).Debug("Job completed")
+}
@@ -0,0 +1,46 @@
+// Package lifecycle bounds work that doesn't end with the request that
+// started it: detached tasks get a deadline of their own rather than
+// context.Background, and subprocesses are stopped when their context is.
+package lifecycle
+
+import (
+    "context"
+    "os/exec"
+    "syscall"
+    "time"
+)
+
+// DefaultGracePeriod is how long a cancelled subprocess has to exit after
+// SIGTERM before it is killed
+const DefaultGracePeriod = 10 * time.Second
+
+// Detach returns a context that keeps ctx's values, such as the request ID
+// and client IP, but not its cancellation, and ends after timeout instead.
+// It is for work that must finish even though the request that started it
+// was cancelled, like saving state on the way out.
+func Detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// Go runs fn in the background with a context detached from ctx and
+// bounded by timeout, for fire-and-forget tasks that outlive a request
+func Go(ctx context.Context, timeout time.Duration, fn func(ctx context.Context)) {
+    ctx, cancel := Detach(ctx, timeout)
+    go func() {
+        defer cancel()
+        fn(ctx)
+    }()
+}
+
+// Command is exec.CommandContext, except that cancelling ctx sends the
+// process SIGTERM and only kills it if it hasn't exited within grace, so
+// it can clean up. Wait then fails as for any signal, so callers tell a
+// stop from a crash by ctx.Err().
+func Command(ctx context.Context, grace time.Duration, name string, args ...string) *exec.Cmd {
+    cmd := exec.CommandContext(ctx, name, args...)
+    cmd.Cancel = func() error {
+        return cmd.Process.Signal(syscall.SIGTERM)
+    }
+    cmd.WaitDelay = grace
+    return cmd
+}
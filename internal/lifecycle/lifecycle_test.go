@@ -0,0 +1,63 @@
+package lifecycle
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestDetach(t *testing.T) {
+    parent, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "req-1"))
+    ctx, stop := Detach(parent, time.Minute)
+    defer stop()
+    cancel()
+
+    assert.NoError(t, ctx.Err())
+    assert.Equal(t, "req-1", ctx.Value(ctxKey{}))
+    deadline, ok := ctx.Deadline()
+    if assert.True(t, ok) {
+        assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+    }
+
+    done := make(chan error, 1)
+    Go(parent, 10*time.Millisecond, func(ctx context.Context) {
+        <-ctx.Done()
+        done <- ctx.Err()
+    })
+    select {
+    case err := <-done:
+        assert.ErrorIs(t, err, context.DeadlineExceeded)
+    case <-time.After(5 * time.Second):
+        t.Fatal("detached task outlived its timeout")
+    }
+}
+
+func TestCommand(t *testing.T) {
+    run := func(script string, grace time.Duration) (time.Duration, error) {
+        ctx, cancel := context.WithCancel(context.Background())
+        cmd := Command(ctx, grace, "sh", "-c", script)
+        if err := cmd.Start(); err != nil {
+            t.Fatalf("Failed to start %q: %v", script, err)
+        }
+        time.Sleep(50 * time.Millisecond)
+        start := time.Now()
+        cancel()
+        err := cmd.Wait()
+        return time.Since(start), err
+    }
+
+    // SIGTERM stops a process that honours it at once
+    took, err := run("exec sleep 30", time.Minute)
+    assert.Error(t, err)
+    assert.Less(t, took, 5*time.Second)
+
+    // One that ignores it is killed after the grace period
+    took, err = run("trap '' TERM; exec sleep 30", 200*time.Millisecond)
+    assert.Error(t, err)
+    assert.GreaterOrEqual(t, took, 200*time.Millisecond)
+    assert.Less(t, took, 5*time.Second)
+}
@@ -13,6 +13,8 @@ import (
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
 // Logger wraps the underlying zap logger with additional functionality
@@ -65,6 +67,137 @@ type Config struct {
 	Compress   bool
 }
 
+// New creates a logger from the given configuration
+func New(cfg Config) (*Logger, error) {
+	zapConfig := zap.NewProductionConfig()
+	if cfg.Format == "console" {
+		zapConfig.Encoding = "console"
+	}
+	if cfg.Output != "" {
+		zapConfig.OutputPaths = []string{cfg.Output}
+	}
+	if cfg.Level != "" {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+		}
+		zapConfig.Level = zap.NewAtomicLevelAt(level)
+	}
+
+	base, err := zapConfig.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build logger: %w", err)
+	}
+
+	return FromZap(base), nil
+}
+
+// FromZap wraps an already built zap logger; see logtest.NewObserved
+func FromZap(base *zap.Logger) *Logger {
+	return &Logger{
+		SugaredLogger: base.Sugar(),
+		metrics: &Metrics{
+			ErrorRates: make(map[string]int64),
+		},
+		contextFields: make(map[string]interface{}),
+	}
+}
+
+// WithFields returns a child logger that attaches the given fields to every entry
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	l.mu.RLock()
+	merged := make(map[string]interface{}, len(l.contextFields)+len(fields))
+	for k, v := range l.contextFields {
+		merged[k] = v
+	}
+	l.mu.RUnlock()
+
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	return &Logger{
+		SugaredLogger: l.SugaredLogger.With(fieldsToArgs(fields)...),
+		metrics:       l.metrics,
+		contextFields: merged,
+	}
+}
+
+type contextKey int
+
+const loggerKey contextKey = iota
+
+var (
+	defaultLogger   *Logger
+	defaultLoggerMu sync.Mutex
+)
+
+// WithRequest returns a child logger carrying the request's request_id,
+// user_id, method, path and remote_addr. Bind it to the request with
+// NewContext so handlers can retrieve it via FromContext.
+func (l *Logger) WithRequest(r *http.Request) *Logger {
+	fields := extractContextFields(r.Context())
+	if _, ok := fields["request_id"]; !ok {
+		if requestID := r.Header.Get("X-Request-ID"); requestID != "" {
+			fields["request_id"] = requestID
+		}
+	}
+	fields["method"] = r.Method
+	fields["path"] = r.URL.Path
+	fields["remote_addr"] = r.RemoteAddr
+
+	return l.WithFields(fields)
+}
+
+// BindRequest is middleware that binds l.WithRequest(r) to each request's
+// context. Running it again after authentication picks up the user_id.
+func (l *Logger) BindRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context(), l.WithRequest(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// NewContext returns a copy of ctx carrying l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger bound to ctx, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// SetDefault replaces the logger FromContext falls back to
+func SetDefault(l *Logger) {
+	defaultLoggerMu.Lock()
+	defer defaultLoggerMu.Unlock()
+	defaultLogger = l
+}
+
+// Default returns the logger set by SetDefault, or a production logger at
+// info level
+func Default() *Logger {
+	defaultLoggerMu.Lock()
+	defer defaultLoggerMu.Unlock()
+
+	if defaultLogger == nil {
+		l, err := New(Config{})
+		if err != nil {
+			l = &Logger{
+				SugaredLogger: zap.NewNop().Sugar(),
+				metrics:       &Metrics{ErrorRates: make(map[string]int64)},
+				contextFields: make(map[string]interface{}),
+			}
+		}
+		defaultLogger = l
+	}
+	return defaultLogger
+}
+
 func (l *Logger) LogMemoryStats() {
 	var stats runtime.MemStats
 	runtime.ReadMemStats(&stats)
@@ -231,9 +364,11 @@ func extractContextFields(ctx context.Context) map[string]interface{} {
 		fields["request_id"] = requestID
 	}
 	
-	// Extract user ID if present
+	// Extract user ID if present, either directly or from the authenticated user
 	if userID, ok := ctx.Value("user_id").(string); ok {
 		fields["user_id"] = userID
+	} else if user, ok := ctx.Value("user").(*models.User); ok {
+		fields["user_id"] = user.ID.String()
 	}
 
 	return fields
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestFromContext_WithRequest(t *testing.T) {
+	base := &Logger{
+		SugaredLogger: zap.NewNop().Sugar(),
+		metrics:       &Metrics{ErrorRates: make(map[string]int64)},
+		contextFields: make(map[string]interface{}),
+	}
+
+	t.Run("Bound logger has request fields", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/portfolios/7?verbose=1", nil)
+		r.Header.Set("X-Request-ID", "req-123")
+		ctx := NewContext(r.Context(), base.WithRequest(r))
+
+		l := FromContext(ctx)
+		assert.Equal(t, "GET", l.contextFields["method"])
+		assert.Equal(t, "/api/v1/portfolios/7", l.contextFields["path"])
+		assert.Equal(t, "req-123", l.contextFields["request_id"])
+		assert.Equal(t, r.RemoteAddr, l.contextFields["remote_addr"])
+	})
+
+	t.Run("BindRequest middleware", func(t *testing.T) {
+		var bound *Logger
+		handler := base.BindRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bound = FromContext(r.Context())
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/ml/predict", nil))
+
+		if !assert.NotNil(t, bound) {
+			return
+		}
+		assert.Equal(t, "POST", bound.contextFields["method"])
+		assert.Equal(t, "/api/v1/ml/predict", bound.contextFields["path"])
+	})
+
+	t.Run("Default without a bound logger", func(t *testing.T) {
+		SetDefault(base)
+		defer SetDefault(nil)
+
+		assert.Same(t, base, FromContext(context.Background()))
+	})
+}
@@ -0,0 +1,17 @@
+// Package logtest provides loggers for tests to inspect what was logged
+package logtest
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// NewObserved returns a logger that keeps entries at debug level and above
+// in memory instead of writing them
+func NewObserved() (*logger.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return logger.FromZap(zap.New(core)), logs
+}
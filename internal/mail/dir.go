@@ -0,0 +1,37 @@
+package mail
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9@._-]+`)
+
+// DirTransport writes each email to a .eml file instead of sending it, so
+// local development needs no SMTP server. Any mail client opens the files.
+type DirTransport struct {
+    dir string
+    now func() time.Time
+}
+
+func NewDirTransport(dir string) (*DirTransport, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, fmt.Errorf("failed to create mail directory: %w", err)
+    }
+    return &DirTransport{dir: dir, now: time.Now}, nil
+}
+
+func (t *DirTransport) Deliver(ctx context.Context, msg Message) error {
+    now := t.now()
+    body, err := msg.Bytes(now)
+    if err != nil {
+        return err
+    }
+
+    name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+    return os.WriteFile(filepath.Join(t.dir, name), body, 0644)
+}
@@ -0,0 +1,120 @@
+package mail
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net/mail"
+    "net/textproto"
+    "strings"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// Mailer sends the email template renders with data to one recipient
+type Mailer interface {
+    Send(ctx context.Context, to, template string, data interface{}) error
+}
+
+// Transport delivers a rendered message
+type Transport interface {
+    Deliver(ctx context.Context, msg Message) error
+}
+
+var (
+    // ErrUnknownTemplate is returned for a template name with no template
+    ErrUnknownTemplate = errors.New("unknown email template")
+    // ErrBounced marks a delivery the receiving server permanently refused,
+    // so retrying it is pointless
+    ErrBounced = errors.New("email bounced")
+)
+
+// Message is a rendered email with HTML and plaintext bodies
+type Message struct {
+    From    string
+    To      string
+    Subject string
+    HTML    string
+    Text    string
+}
+
+// NewTransport returns the transport cfg selects: rendered emails are
+// written to DevDir when it is set, and sent over SMTP otherwise. It returns
+// nil if neither is configured.
+func NewTransport(cfg config.MailConfig) (Transport, error) {
+    switch {
+    case cfg.DevDir != "":
+        t, err := NewDirTransport(cfg.DevDir)
+        if err != nil {
+            return nil, err
+        }
+        return t, nil
+    case cfg.Host != "":
+        t, err := NewSMTPTransport(cfg)
+        if err != nil {
+            return nil, err
+        }
+        return t, nil
+    }
+    return nil, nil
+}
+
+// validAddress rejects anything but a single bare address, which also keeps
+// line breaks out of the headers it is written to
+func validAddress(addr string) error {
+    parsed, err := mail.ParseAddress(addr)
+    if err != nil || parsed.Address != addr {
+        return fmt.Errorf("invalid email address %q", addr)
+    }
+    return nil
+}
+
+// Bytes encodes the message as multipart/alternative MIME, plaintext first
+// so clients that can't show HTML fall back to it
+func (m Message) Bytes(date time.Time) ([]byte, error) {
+    if err := validAddress(m.From); err != nil {
+        return nil, err
+    }
+    if err := validAddress(m.To); err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    parts := multipart.NewWriter(&buf)
+
+    fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+    fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+    fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(m.Subject), " ")))
+    fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+    fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+    for _, part := range []struct{ contentType, body string }{
+        {"text/plain; charset=utf-8", m.Text},
+        {"text/html; charset=utf-8", m.HTML},
+    } {
+        w, err := parts.CreatePart(textproto.MIMEHeader{
+            "Content-Type":              {part.contentType},
+            "Content-Transfer-Encoding": {"quoted-printable"},
+        })
+        if err != nil {
+            return nil, err
+        }
+        qp := quotedprintable.NewWriter(w)
+        if _, err := qp.Write([]byte(part.body)); err != nil {
+            return nil, err
+        }
+        if err := qp.Close(); err != nil {
+            return nil, err
+        }
+    }
+    if err := parts.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,214 @@
+package mail
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// Outbox statuses
+const (
+    StatusPending = "pending"
+    StatusSent    = "sent"
+    StatusFailed  = "failed"
+    StatusBounced = "bounced"
+)
+
+const (
+    deliveryBatchSize = 50
+    // claimLease keeps other instances off claimed emails; emails whose
+    // delivery was interrupted are retried once it runs out
+    claimLease = 5 * time.Minute
+    maxBackoff = time.Hour
+)
+
+// Queue is the Mailer backed by the email_outbox table. Send renders and
+// stores an email, and Deliver, run as a scheduled job, sends the emails
+// that are due.
+type Queue struct {
+    db          *sql.DB
+    renderer    *Renderer
+    transport   Transport
+    from        string
+    maxAttempts int
+    hourlyLimit int
+    now         func() time.Time
+}
+
+func NewQueue(db *sql.DB, renderer *Renderer, transport Transport, cfg config.MailConfig) *Queue {
+    return &Queue{
+        db:          db,
+        renderer:    renderer,
+        transport:   transport,
+        from:        cfg.From,
+        maxAttempts: cfg.MaxAttempts,
+        hourlyLimit: cfg.RecipientHourlyLimit,
+        now:         time.Now,
+    }
+}
+
+// Send renders template with data and queues it for to. Rendering errors
+// are returned here rather than at delivery.
+func (q *Queue) Send(ctx context.Context, to, template string, data interface{}) error {
+    if err := validAddress(to); err != nil {
+        return err
+    }
+    msg, err := q.renderer.Render(template, data)
+    if err != nil {
+        return err
+    }
+
+    _, err = q.db.ExecContext(ctx, `
+        INSERT INTO email_outbox (recipient, template, subject, html_body, text_body, status, next_attempt_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+        to, template, msg.Subject, msg.HTML, msg.Text, StatusPending, q.now())
+    if err != nil {
+        return fmt.Errorf("failed to queue %s email: %w", template, err)
+    }
+    return nil
+}
+
+type outboxEmail struct {
+    id       int64
+    msg      Message
+    attempts int
+}
+
+// Deliver sends a batch of due emails and returns how many were sent.
+// Failed sends are retried with exponential backoff up to the configured
+// attempts, bounces aren't retried, and emails to a recipient already at
+// the hourly cap wait until it has room.
+func (q *Queue) Deliver(ctx context.Context) (int, error) {
+    now := q.now()
+    emails, err := q.claim(ctx, now)
+    if err != nil {
+        return 0, err
+    }
+
+    sent := 0
+    for _, email := range emails {
+        if ctx.Err() != nil {
+            return sent, ctx.Err()
+        }
+
+        wait, err := q.rateLimited(ctx, email.msg.To, now)
+        if err != nil {
+            return sent, err
+        }
+        if !wait.IsZero() {
+            if _, err := q.db.ExecContext(ctx, `UPDATE email_outbox SET next_attempt_at = $1 WHERE id = $2`, wait, email.id); err != nil {
+                return sent, fmt.Errorf("failed to defer email %d: %w", email.id, err)
+            }
+            continue
+        }
+
+        deliveryErr := q.transport.Deliver(ctx, email.msg)
+        if err := q.record(ctx, email, deliveryErr, now); err != nil {
+            return sent, err
+        }
+        if deliveryErr == nil {
+            sent++
+        }
+    }
+    return sent, nil
+}
+
+func (q *Queue) claim(ctx context.Context, now time.Time) ([]outboxEmail, error) {
+    rows, err := q.db.QueryContext(ctx, `
+        UPDATE email_outbox SET next_attempt_at = $1
+        WHERE id IN (
+            SELECT id FROM email_outbox
+            WHERE status = $2 AND next_attempt_at <= $3
+            ORDER BY next_attempt_at
+            LIMIT $4
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, recipient, subject, html_body, text_body, attempts`,
+        now.Add(claimLease), StatusPending, now, deliveryBatchSize)
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim emails: %w", err)
+    }
+    defer rows.Close()
+
+    var emails []outboxEmail
+    for rows.Next() {
+        email := outboxEmail{msg: Message{From: q.from}}
+        if err := rows.Scan(&email.id, &email.msg.To, &email.msg.Subject, &email.msg.HTML, &email.msg.Text, &email.attempts); err != nil {
+            return nil, err
+        }
+        emails = append(emails, email)
+    }
+    return emails, rows.Err()
+}
+
+// rateLimited returns when to next try an email to recipient if it has
+// reached its hourly cap, or the zero time if it may be sent now
+func (q *Queue) rateLimited(ctx context.Context, recipient string, now time.Time) (time.Time, error) {
+    if q.hourlyLimit <= 0 {
+        return time.Time{}, nil
+    }
+
+    var count int
+    var oldest sql.NullTime
+    err := q.db.QueryRowContext(ctx, `
+        SELECT COUNT(*), MIN(sent_at) FROM email_outbox
+        WHERE recipient = $1 AND status = $2 AND sent_at > $3`,
+        recipient, StatusSent, now.Add(-time.Hour)).Scan(&count, &oldest)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to count emails to %s: %w", recipient, err)
+    }
+    if count < q.hourlyLimit || !oldest.Valid {
+        return time.Time{}, nil
+    }
+    // There is room again once the oldest send leaves the window
+    return oldest.Time.Add(time.Hour), nil
+}
+
+// record stores the outcome of a delivery attempt
+func (q *Queue) record(ctx context.Context, email outboxEmail, deliveryErr error, now time.Time) error {
+    attempts := email.attempts + 1
+    status := StatusPending
+    next := now.Add(backoff(attempts))
+    var sentAt sql.NullTime
+    var lastError sql.NullString
+
+    switch {
+    case deliveryErr == nil:
+        status = StatusSent
+        sentAt = sql.NullTime{Time: now, Valid: true}
+    case errors.Is(deliveryErr, ErrBounced):
+        status = StatusBounced
+    case attempts >= q.maxAttempts:
+        status = StatusFailed
+    }
+    if deliveryErr != nil {
+        lastError = sql.NullString{String: deliveryErr.Error(), Valid: true}
+    }
+
+    _, err := q.db.ExecContext(ctx, `
+        UPDATE email_outbox
+        SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, sent_at = $5
+        WHERE id = $6`,
+        status, attempts, lastError, next, sentAt, email.id)
+    if err != nil {
+        return fmt.Errorf("failed to record delivery of email %d: %w", email.id, err)
+    }
+    return nil
+}
+
+// backoff doubles the wait after each failed attempt, from a minute up to
+// an hour
+func backoff(attempts int) time.Duration {
+    if attempts > 7 {
+        return maxBackoff
+    }
+    wait := time.Minute << (attempts - 1)
+    if wait > maxBackoff {
+        return maxBackoff
+    }
+    return wait
+}
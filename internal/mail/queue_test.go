@@ -0,0 +1,171 @@
+package mail
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/mail"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// fakeTransport fails deliveries to the addresses in errs
+type fakeTransport struct {
+    errs      map[string]error
+    delivered []Message
+}
+
+func (f *fakeTransport) Deliver(ctx context.Context, msg Message) error {
+    if err := f.errs[msg.To]; err != nil {
+        return err
+    }
+    f.delivered = append(f.delivered, msg)
+    return nil
+}
+
+var testMailConfig = config.MailConfig{From: "noreply@wolfai.com", MaxAttempts: 3, RecipientHourlyLimit: 2}
+
+var emailColumns = []string{"id", "recipient", "subject", "html_body", "text_body", "attempts"}
+
+func TestQueue_Send(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    renderer, err := NewRenderer()
+    if !assert.NoError(t, err) {
+        return
+    }
+    queue := NewQueue(db, renderer, &fakeTransport{}, testMailConfig)
+
+    mock.ExpectExec("INSERT INTO email_outbox").
+        WithArgs("ada@example.com", TemplateVerification, "Verify your WOLFAI email address",
+            sqlmock.AnyArg(), sqlmock.AnyArg(), StatusPending, sqlmock.AnyArg()).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    assert.NoError(t, queue.Send(context.Background(), "ada@example.com", TemplateVerification,
+        VerificationData{Name: "Ada", Link: "https://wolfai.com/verify?token=abc"}))
+
+    // Nothing is queued for a bad address or template
+    err = queue.Send(context.Background(), "Ada <ada@example.com>\r\nBcc: all@example.com", TemplateVerification, VerificationData{})
+    assert.Error(t, err)
+    err = queue.Send(context.Background(), "ada@example.com", "welcome", nil)
+    assert.ErrorIs(t, err, ErrUnknownTemplate)
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueue_Deliver(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    transport := &fakeTransport{errs: map[string]error{
+        "gone@example.com":  fmt.Errorf("%w: 550 mailbox unavailable", ErrBounced),
+        "flaky@example.com": errors.New("connection reset"),
+        "dead@example.com":  errors.New("connection reset"),
+    }}
+    queue := NewQueue(db, nil, transport, testMailConfig)
+    queue.now = func() time.Time { return now }
+
+    mock.ExpectQuery(`(?s)UPDATE email_outbox SET next_attempt_at = \$1\s+WHERE id IN \(\s+SELECT id FROM email_outbox\s+.+FOR UPDATE SKIP LOCKED\s+\)\s+RETURNING`).
+        WithArgs(now.Add(claimLease), StatusPending, now, deliveryBatchSize).
+        WillReturnRows(sqlmock.NewRows(emailColumns).
+            AddRow(1, "ada@example.com", "Hello", "<p>Hi</p>", "Hi", 0).
+            AddRow(2, "gone@example.com", "Hello", "<p>Hi</p>", "Hi", 0).
+            AddRow(3, "flaky@example.com", "Hello", "<p>Hi</p>", "Hi", 1).
+            AddRow(4, "dead@example.com", "Hello", "<p>Hi</p>", "Hi", 2).
+            AddRow(5, "busy@example.com", "Hello", "<p>Hi</p>", "Hi", 0))
+
+    expectCount := func(recipient string, count int, oldest interface{}) {
+        mock.ExpectQuery("SELECT COUNT\\(\\*\\), MIN\\(sent_at\\) FROM email_outbox").
+            WithArgs(recipient, StatusSent, now.Add(-time.Hour)).
+            WillReturnRows(sqlmock.NewRows([]string{"count", "min"}).AddRow(count, oldest))
+    }
+    expectRecord := func(id int64, status string, attempts int, next time.Time) {
+        mock.ExpectExec("UPDATE email_outbox SET status = (.+) WHERE id = (.+)").
+            WithArgs(status, attempts, sqlmock.AnyArg(), next, sqlmock.AnyArg(), id).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+    }
+
+    // Sent
+    expectCount("ada@example.com", 1, now.Add(-30*time.Minute))
+    expectRecord(1, StatusSent, 1, now.Add(time.Minute))
+    // A bounce isn't retried
+    expectCount("gone@example.com", 0, nil)
+    expectRecord(2, StatusBounced, 1, now.Add(time.Minute))
+    // A transient failure backs off
+    expectCount("flaky@example.com", 0, nil)
+    expectRecord(3, StatusPending, 2, now.Add(2*time.Minute))
+    // ...until the attempts run out
+    expectCount("dead@example.com", 0, nil)
+    expectRecord(4, StatusFailed, 3, now.Add(4*time.Minute))
+    // A recipient at the cap waits for the oldest send to leave the window
+    expectCount("busy@example.com", 2, now.Add(-45*time.Minute))
+    mock.ExpectExec("UPDATE email_outbox SET next_attempt_at = (.+) WHERE id = (.+)").
+        WithArgs(now.Add(15*time.Minute), int64(5)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    sent, err := queue.Deliver(context.Background())
+    assert.NoError(t, err)
+    assert.Equal(t, 1, sent)
+    if assert.Len(t, transport.delivered, 1) {
+        assert.Equal(t, "noreply@wolfai.com", transport.delivered[0].From)
+        assert.Equal(t, "ada@example.com", transport.delivered[0].To)
+    }
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDirTransport(t *testing.T) {
+    dir := t.TempDir()
+    transport, err := NewDirTransport(dir)
+    if !assert.NoError(t, err) {
+        return
+    }
+    transport.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+    err = transport.Deliver(context.Background(), Message{
+        From:    "noreply@wolfai.com",
+        To:      "ada@example.com",
+        Subject: "Your WOLFAI week digest",
+        HTML:    "<p>Hi Ada</p>",
+        Text:    "Hi Ada",
+    })
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+    if !assert.Len(t, files, 1) {
+        return
+    }
+    f, err := os.Open(files[0])
+    if !assert.NoError(t, err) {
+        return
+    }
+    defer f.Close()
+
+    parsed, err := mail.ReadMessage(f)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, "ada@example.com", parsed.Header.Get("To"))
+    assert.Equal(t, "Your WOLFAI week digest", parsed.Header.Get("Subject"))
+    assert.Contains(t, parsed.Header.Get("Content-Type"), "multipart/alternative")
+    body, _ := io.ReadAll(parsed.Body)
+    assert.Contains(t, string(body), "Content-Type: text/plain; charset=utf-8")
+    assert.Contains(t, string(body), "<p>Hi Ada</p>")
+}
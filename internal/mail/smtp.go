@@ -0,0 +1,117 @@
+package mail
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "net"
+    "net/smtp"
+    "net/textproto"
+    "strconv"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// TLS modes of an SMTP connection
+const (
+    TLSStartTLS = "starttls"
+    TLSImplicit = "tls"
+    TLSNone     = "none"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTPTransport sends email through an SMTP relay
+type SMTPTransport struct {
+    cfg config.MailConfig
+}
+
+func NewSMTPTransport(cfg config.MailConfig) (*SMTPTransport, error) {
+    switch cfg.TLS {
+    case TLSStartTLS, TLSImplicit, TLSNone:
+    default:
+        return nil, fmt.Errorf("unknown mail TLS mode %q", cfg.TLS)
+    }
+    if cfg.Port == 0 {
+        return nil, fmt.Errorf("mail port is required")
+    }
+    return &SMTPTransport{cfg: cfg}, nil
+}
+
+func (t *SMTPTransport) Deliver(ctx context.Context, msg Message) error {
+    body, err := msg.Bytes(time.Now())
+    if err != nil {
+        return err
+    }
+
+    conn, err := t.dial(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to connect to %s: %w", t.cfg.Host, err)
+    }
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(smtpTimeout)
+    }
+    conn.SetDeadline(deadline)
+
+    client, err := smtp.NewClient(conn, t.cfg.Host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer client.Close()
+
+    if t.cfg.TLS == TLSStartTLS {
+        if ok, _ := client.Extension("STARTTLS"); !ok {
+            return fmt.Errorf("%s doesn't support STARTTLS", t.cfg.Host)
+        }
+        if err := client.StartTLS(&tls.Config{ServerName: t.cfg.Host}); err != nil {
+            return fmt.Errorf("failed to start TLS: %w", err)
+        }
+    }
+    if t.cfg.Username != "" {
+        if err := client.Auth(smtp.PlainAuth("", t.cfg.Username, t.cfg.Password, t.cfg.Host)); err != nil {
+            return fmt.Errorf("failed to authenticate: %w", err)
+        }
+    }
+
+    if err := client.Mail(msg.From); err != nil {
+        return fmt.Errorf("sender refused: %w", err)
+    }
+    if err := client.Rcpt(msg.To); err != nil {
+        return recipientError(err)
+    }
+    w, err := client.Data()
+    if err != nil {
+        return recipientError(err)
+    }
+    if _, err := w.Write(body); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return recipientError(err)
+    }
+    return client.Quit()
+}
+
+func (t *SMTPTransport) dial(ctx context.Context) (net.Conn, error) {
+    addr := net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port))
+    dialer := &net.Dialer{Timeout: smtpTimeout}
+    if t.cfg.TLS == TLSImplicit {
+        tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: t.cfg.Host}}
+        return tlsDialer.DialContext(ctx, "tcp", addr)
+    }
+    return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// recipientError marks permanent (5xx) refusals of a message as bounces;
+// anything else may succeed on retry
+func recipientError(err error) error {
+    var reply *textproto.Error
+    if errors.As(err, &reply) && reply.Code >= 500 {
+        return fmt.Errorf("%w: %v", ErrBounced, err)
+    }
+    return fmt.Errorf("recipient refused: %w", err)
+}
@@ -0,0 +1,147 @@
+package mail
+
+import (
+    "bytes"
+    "embed"
+    "fmt"
+    htmltemplate "html/template"
+    "path"
+    "strings"
+    texttemplate "text/template"
+    "time"
+)
+
+// Every template is a pair: name.html for the HTML body and name.txt for
+// the plaintext body, which also defines the subject
+//
+//go:embed templates/*.html templates/*.txt
+var templateFiles embed.FS
+
+// Template names
+const (
+    TemplateVerification = "verification"
+    TemplateReset        = "reset"
+    TemplateDigest       = "digest"
+    TemplateAlert        = "alert"
+    // TemplatePredictionFailure tells users a prediction subscription
+    // keeps failing
+    TemplatePredictionFailure = "prediction_failure"
+    // TemplateAdminNotice tells an admin about a change to the platform,
+    // such as a model rollback
+    TemplateAdminNotice = "admin_notice"
+)
+
+// VerificationData fills the verification template
+type VerificationData struct {
+    Name string
+    Link string
+}
+
+// ResetData fills the reset template
+type ResetData struct {
+    Name      string
+    Link      string
+    ExpiresIn time.Duration
+}
+
+// DigestData fills the digest template
+type DigestData struct {
+    Name       string
+    Period     string
+    Portfolios []DigestPortfolio
+}
+
+type DigestPortfolio struct {
+    Name      string
+    Value     string
+    ChangePct float64
+}
+
+// AlertData fills the alert template
+type AlertData struct {
+    Name      string
+    Portfolio string
+    Severity  string
+    Message   string
+    Link      string
+}
+
+// PredictionFailureData fills the prediction failure template
+type PredictionFailureData struct {
+    Name      string
+    Symbol    string
+    Timeframe string
+    Failures  int
+    Error     string
+    Link      string
+}
+
+// AdminNoticeData fills the admin notice template
+type AdminNoticeData struct {
+    Name    string
+    Subject string
+    Message string
+}
+
+var templateFuncs = map[string]interface{}{
+    "minutes": func(d time.Duration) int { return int(d.Minutes()) },
+    "pct":     func(v float64) string { return fmt.Sprintf("%+.2f%%", v) },
+    "upper":   strings.ToUpper,
+}
+
+type emailTemplate struct {
+    html *htmltemplate.Template
+    text *texttemplate.Template
+}
+
+// Renderer renders the embedded email templates
+type Renderer struct {
+    templates map[string]emailTemplate
+}
+
+// NewRenderer parses the embedded templates, failing if any lacks its
+// plaintext or HTML half
+func NewRenderer() (*Renderer, error) {
+    r := &Renderer{templates: make(map[string]emailTemplate)}
+    for _, name := range []string{TemplateVerification, TemplateReset, TemplateDigest, TemplateAlert, TemplatePredictionFailure, TemplateAdminNotice} {
+        html, err := htmltemplate.New(name + ".html").Funcs(templateFuncs).ParseFS(templateFiles, path.Join("templates", name+".html"))
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse %s email: %w", name, err)
+        }
+        text, err := texttemplate.New(name + ".txt").Funcs(templateFuncs).ParseFS(templateFiles, path.Join("templates", name+".txt"))
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse %s email: %w", name, err)
+        }
+        if text.Lookup("subject") == nil {
+            return nil, fmt.Errorf("%s email has no subject", name)
+        }
+        r.templates[name] = emailTemplate{html: html, text: text}
+    }
+    return r, nil
+}
+
+// Render returns the subject, HTML and plaintext bodies of the named
+// template filled with data
+func (r *Renderer) Render(name string, data interface{}) (Message, error) {
+    tmpl, ok := r.templates[name]
+    if !ok {
+        return Message{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+    }
+
+    var subject, html, text bytes.Buffer
+    if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+        return Message{}, fmt.Errorf("failed to render %s subject: %w", name, err)
+    }
+    if err := tmpl.text.Execute(&text, data); err != nil {
+        return Message{}, fmt.Errorf("failed to render %s email: %w", name, err)
+    }
+    if err := tmpl.html.Execute(&html, data); err != nil {
+        return Message{}, fmt.Errorf("failed to render %s email: %w", name, err)
+    }
+
+    return Message{
+        Subject: strings.TrimSpace(subject.String()),
+        HTML:    html.String(),
+        Text:    strings.TrimSpace(text.String()) + "\n",
+    }, nil
+}
@@ -0,0 +1,117 @@
+package mail
+
+import (
+    "flag"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// golden compares got with testdata/name, rewriting it with -update
+func golden(t *testing.T, name, got string) {
+    t.Helper()
+    path := filepath.Join("testdata", name)
+    if *update {
+        if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+            t.Fatalf("Failed to update %s: %v", path, err)
+        }
+    }
+    want, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatalf("Failed to read %s: %v", path, err)
+    }
+    assert.Equal(t, string(want), got)
+}
+
+func TestRenderer_Golden(t *testing.T) {
+    renderer, err := NewRenderer()
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    tests := []struct {
+        template string
+        data     interface{}
+    }{
+        {TemplateVerification, VerificationData{Name: "Ada", Link: "https://wolfai.com/verify?token=abc123"}},
+        {TemplateReset, ResetData{Name: "Ada", Link: "https://wolfai.com/reset?token=def456", ExpiresIn: 30 * time.Minute}},
+        {TemplateDigest, DigestData{Name: "Ada", Period: "week", Portfolios: []DigestPortfolio{
+            {Name: "Retirement Fund", Value: "$125,400.00", ChangePct: 1.25},
+            {Name: "Crypto", Value: "$8,210.50", ChangePct: -4.8},
+        }}},
+        {TemplateAlert, AlertData{
+            Name:      "Ada",
+            Portfolio: "Crypto",
+            Severity:  "high",
+            Message:   "Drawdown of 18% exceeds your 15% limit",
+            Link:      "https://wolfai.com/portfolios/42",
+        }},
+        {TemplatePredictionFailure, PredictionFailureData{
+            Name:      "Ada",
+            Symbol:    "BTC",
+            Timeframe: "24h",
+            Failures:  3,
+            Error:     "no active model for BTC",
+            Link:      "https://wolfai.com/predictions/subscriptions",
+        }},
+        {TemplateAdminNotice, AdminNoticeData{
+            Name:    "Ada",
+            Subject: "Model lstm rolled back",
+            Message: "ops@wolfai.com rolled lstm back from version 2.1 to 2.0",
+        }},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.template, func(t *testing.T) {
+            msg, err := renderer.Render(tt.template, tt.data)
+            if !assert.NoError(t, err) {
+                return
+            }
+            assert.NotEmpty(t, msg.Subject)
+            assert.NotContains(t, msg.Subject, "\n")
+            golden(t, tt.template+".html.golden", msg.HTML)
+            golden(t, tt.template+".txt.golden", "Subject: "+msg.Subject+"\n\n"+msg.Text)
+        })
+    }
+
+    t.Run("Empty digest", func(t *testing.T) {
+        msg, err := renderer.Render(TemplateDigest, DigestData{Name: "Ada", Period: "day"})
+        assert.NoError(t, err)
+        assert.Contains(t, msg.Text, "You don't have any portfolios yet.")
+        assert.Contains(t, msg.HTML, "You don't have any portfolios yet.")
+    })
+}
+
+func TestRenderer_Render(t *testing.T) {
+    renderer, err := NewRenderer()
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    t.Run("HTML is escaped, plaintext isn't", func(t *testing.T) {
+        msg, err := renderer.Render(TemplateAlert, AlertData{
+            Name:      "Ada",
+            Portfolio: "<script>alert(1)</script>",
+            Severity:  "low",
+            Message:   "P&L below target",
+            Link:      "javascript:alert(1)",
+        })
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.NotContains(t, msg.HTML, "<script>")
+        assert.Contains(t, msg.HTML, "P&amp;L below target")
+        assert.NotContains(t, msg.HTML, `href="javascript:`)
+        assert.Contains(t, msg.Text, "P&L below target")
+    })
+
+    t.Run("Unknown template", func(t *testing.T) {
+        _, err := renderer.Render("welcome", nil)
+        assert.ErrorIs(t, err, ErrUnknownTemplate)
+    })
+}
@@ -6,16 +6,45 @@ import (
     "strings"
 
     "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
+type contextKey string
+
+// UserIDKey holds the authenticated user's ID, a uuid.UUID, on the request
+// context
+const UserIDKey contextKey = "user_id"
+
 type AuthMiddleware struct {
     authService *auth.Service
+    metrics     *auth.Metrics
 }
 
 func NewAuthMiddleware(as *auth.Service) *AuthMiddleware {
     return &AuthMiddleware{authService: as}
 }
 
+// WithMetrics counts the outcome of every bearer token validation in
+// metrics, by RouteClass
+func (m *AuthMiddleware) WithMetrics(metrics *auth.Metrics) *AuthMiddleware {
+    m.metrics = metrics
+    return m
+}
+
+// RouteClass groups routes for auth metrics: admin routes, streams and
+// everything else
+func RouteClass(r *http.Request) string {
+    switch {
+    case strings.Contains(r.URL.Path, "/admin/") || strings.HasSuffix(r.URL.Path, "/admin"):
+        return "admin"
+    case strings.HasSuffix(r.URL.Path, "/stream"):
+        return "stream"
+    default:
+        return "api"
+    }
+}
+
 func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         authHeader := r.Header.Get("Authorization")
@@ -26,17 +55,31 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 
         bearerToken := strings.Split(authHeader, " ")
         if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+            m.metrics.ObserveValidation(RouteClass(r), auth.ErrMalformedToken)
             http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
             return
         }
 
-        user, err := m.authService.ValidateToken(bearerToken[1])
+        user, err := m.authService.ValidateToken(r.Context(), bearerToken[1])
+        outcome := m.metrics.ObserveValidation(RouteClass(r), err)
+        // The fingerprint traces one client's tokens across requests
+        logger.FromContext(r.Context()).Debugw("Validated bearer token",
+            "token_fingerprint", auth.Fingerprint(bearerToken[1]),
+            "outcome", outcome,
+        )
         if err != nil {
             http.Error(w, "Invalid token", http.StatusUnauthorized)
             return
         }
 
         ctx := context.WithValue(r.Context(), "user", user)
+        ctx = context.WithValue(ctx, "token", bearerToken[1])
+        ctx = context.WithValue(ctx, UserIDKey, user.ID)
+        // Impersonation tokens resolve to the impersonated user, so
+        // ownership checks see them as that user
+        if imp := m.authService.Impersonation(bearerToken[1]); imp != nil {
+            ctx = auth.WithImpersonation(ctx, imp)
+        }
         next.ServeHTTP(w, r.WithContext(ctx))
     })
 }
@@ -44,13 +87,35 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 func (m *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handler {
     return func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            user := r.Context().Value("user")
-            if user == nil {
+            user, ok := r.Context().Value("user").(*models.User)
+            if !ok || user == nil {
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+
+            if user.Role != role {
+                http.Error(w, "Forbidden", http.StatusForbidden)
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// RequirePermission allows the request through only if the authenticated
+// user's role grants perm. It must run after RequireAuth, which loads the
+// user's current role from the database.
+func RequirePermission(perm auth.Permission) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user, ok := r.Context().Value("user").(*models.User)
+            if !ok || user == nil {
                 http.Error(w, "Unauthorized", http.StatusUnauthorized)
                 return
             }
 
-            if user.(*auth.User).Role != role {
+            if !auth.HasPermission(user.Role, perm) {
                 http.Error(w, "Forbidden", http.StatusForbidden)
                 return
             }
@@ -58,4 +123,4 @@ func (m *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handle
             next.ServeHTTP(w, r)
         })
     }
-}
\ No newline at end of file
+}
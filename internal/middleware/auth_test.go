@@ -0,0 +1,180 @@
+package middleware
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/golang-jwt/jwt"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger/logtest"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// withUser stands in for RequireAuth by placing user on the request context
+func withUser(user *models.User) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if user != nil {
+                r = r.WithContext(context.WithValue(r.Context(), "user", user))
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+func adminRouter(user *models.User) *mux.Router {
+    ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+
+    router := mux.NewRouter()
+    router.Use(withUser(user))
+    router.Handle("/admin/users/{id}/role", RequirePermission(auth.PermManageRoles)(ok)).Methods("PUT")
+    router.Handle("/admin/audit", RequirePermission(auth.PermViewAudit)(ok)).Methods("GET")
+    router.Handle("/admin/jobs", RequirePermission(auth.PermManageJobs)(ok)).Methods("POST")
+    return router
+}
+
+func TestRequirePermission(t *testing.T) {
+    tests := []struct {
+        name   string
+        user   *models.User
+        method string
+        path   string
+        status int
+    }{
+        {"anonymous request", nil, "PUT", "/admin/users/1/role", http.StatusUnauthorized},
+        {"user promotes self to admin", &models.User{Email: "u@example.com", Role: auth.RoleUser}, "PUT", "/admin/users/1/role", http.StatusForbidden},
+        {"user reads audit log", &models.User{Role: auth.RoleUser}, "GET", "/admin/audit", http.StatusForbidden},
+        {"user starts training job", &models.User{Role: auth.RoleUser}, "POST", "/admin/jobs", http.StatusForbidden},
+        {"analyst changes roles", &models.User{Role: auth.RoleAnalyst}, "PUT", "/admin/users/1/role", http.StatusForbidden},
+        {"analyst starts training job", &models.User{Role: auth.RoleAnalyst}, "POST", "/admin/jobs", http.StatusOK},
+        {"unknown role", &models.User{Role: "superuser"}, "PUT", "/admin/users/1/role", http.StatusForbidden},
+        {"role with different case", &models.User{Role: "Admin"}, "PUT", "/admin/users/1/role", http.StatusForbidden},
+        {"admin changes roles", &models.User{Role: auth.RoleAdmin}, "PUT", "/admin/users/1/role", http.StatusOK},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"role":"admin"}`))
+            rec := httptest.NewRecorder()
+
+            adminRouter(tt.user).ServeHTTP(rec, req)
+
+            assert.Equal(t, tt.status, rec.Code)
+        })
+    }
+}
+
+func TestRequireAuth_Metrics(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    blacklist := auth.NewTokenBlacklist(nil)
+    reg := prometheus.NewRegistry()
+    authMiddleware := NewAuthMiddleware(auth.NewService(db, "secret").WithBlacklist(blacklist)).
+        WithMetrics(auth.NewMetrics(reg))
+    log, logs := logtest.NewObserved()
+
+    router := mux.NewRouter()
+    router.Use(authMiddleware.RequireAuth)
+    ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+    router.HandleFunc("/api/v1/portfolios", ok)
+    router.HandleFunc("/api/v1/admin/users", ok)
+
+    sign := func(key string, exp time.Time) string {
+        token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+            "user_id": 1,
+            "iat":     time.Now().Add(-time.Hour).Unix(),
+            "exp":     exp.Unix(),
+        }).SignedString([]byte(key))
+        require.NoError(t, err)
+        return token
+    }
+    valid := sign("secret", time.Now().Add(time.Hour))
+    revoked := sign("secret", time.Now().Add(2*time.Hour))
+    require.NoError(t, blacklist.Add(context.Background(), revoked, time.Hour))
+
+    mock.ExpectQuery("SELECT id, email, name, role, subscription_tier, tokens_revoked_at").
+        WithArgs(int64(1)).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "role", "subscription_tier", "tokens_revoked_at"}).
+            AddRow(uuid.New(), "user@example.com", "User", auth.RoleUser, "free", nil))
+
+    tests := []struct {
+        name          string
+        path          string
+        authorization string
+        status        int
+        outcome       string
+        class         string
+    }{
+        {"valid token", "/api/v1/portfolios", "Bearer " + valid, http.StatusOK, auth.OutcomeValid, "api"},
+        {"expired token", "/api/v1/portfolios", "Bearer " + sign("secret", time.Now().Add(-time.Minute)), http.StatusUnauthorized, auth.OutcomeExpired, "api"},
+        {"token signed with another key", "/api/v1/admin/users", "Bearer " + sign("other-secret", time.Now().Add(time.Hour)), http.StatusUnauthorized, auth.OutcomeInvalidSignature, "admin"},
+        {"blacklisted token", "/api/v1/admin/users", "Bearer " + revoked, http.StatusUnauthorized, auth.OutcomeBlacklisted, "admin"},
+        {"malformed token", "/api/v1/portfolios", "Bearer not-a-token", http.StatusUnauthorized, auth.OutcomeMalformed, "api"},
+        {"not a bearer token", "/api/v1/portfolios", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, auth.OutcomeMalformed, "api"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            before := validations(t, reg, tt.outcome, tt.class)
+
+            req := httptest.NewRequest("GET", tt.path, nil)
+            req.Header.Set("Authorization", tt.authorization)
+            req = req.WithContext(logger.NewContext(req.Context(), log))
+            rec := httptest.NewRecorder()
+            router.ServeHTTP(rec, req)
+
+            assert.Equal(t, tt.status, rec.Code)
+            assert.Equal(t, before+1, validations(t, reg, tt.outcome, tt.class))
+        })
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    // Every bearer token is logged by fingerprint only
+    entries := logs.FilterMessage("Validated bearer token").All()
+    require.Len(t, entries, 5)
+    assert.Equal(t, auth.Fingerprint(valid), entries[0].ContextMap()["token_fingerprint"])
+    assert.Equal(t, auth.OutcomeValid, entries[0].ContextMap()["outcome"])
+    for _, entry := range entries {
+        for _, v := range entry.ContextMap() {
+            assert.NotContains(t, v, ".", "token logged")
+        }
+    }
+}
+
+// validations reads auth_token_validations_total for outcome and class
+func validations(t *testing.T, reg *prometheus.Registry, outcome, class string) float64 {
+    families, err := reg.Gather()
+    require.NoError(t, err)
+    for _, family := range families {
+        if family.GetName() != "auth_token_validations_total" {
+            continue
+        }
+        for _, metric := range family.GetMetric() {
+            labels := make(map[string]string)
+            for _, label := range metric.GetLabel() {
+                labels[label.GetName()] = label.GetValue()
+            }
+            if labels["outcome"] == outcome && labels["route_class"] == class {
+                return metric.GetCounter().GetValue()
+            }
+        }
+    }
+    return 0
+}
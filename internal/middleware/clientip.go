@@ -0,0 +1,147 @@
+package middleware
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "net/netip"
+    "strings"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+)
+
+// ParsePrefixes parses single IPs and CIDR ranges, IPv4 or IPv6. A single
+// IP becomes a prefix covering only that address.
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+    prefixes := make([]netip.Prefix, 0, len(entries))
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        if strings.Contains(entry, "/") {
+            prefix, err := netip.ParsePrefix(entry)
+            if err != nil {
+                return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+            }
+            if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+                prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+            }
+            prefixes = append(prefixes, prefix.Masked())
+            continue
+        }
+
+        addr, err := netip.ParseAddr(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid IP %q: %w", entry, err)
+        }
+        addr = addr.Unmap()
+        prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+    }
+    return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+    for _, p := range prefixes {
+        if p.Contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// parseAddr accepts a bare IP or host:port, with or without brackets and
+// IPv6 zones, and returns the unmapped address
+func parseAddr(s string) (netip.Addr, bool) {
+    s = strings.TrimSpace(s)
+    if host, _, err := net.SplitHostPort(s); err == nil {
+        s = host
+    }
+    s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+    addr, err := netip.ParseAddr(s)
+    if err != nil {
+        return netip.Addr{}, false
+    }
+    return addr.WithZone("").Unmap(), true
+}
+
+// ClientIPResolver derives the client address of a request, trusting
+// forwarding headers only when they were added by a trusted proxy
+type ClientIPResolver struct {
+    trusted []netip.Prefix
+}
+
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+    trusted, err := ParsePrefixes(trustedProxies)
+    if err != nil {
+        return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+    }
+    return &ClientIPResolver{trusted: trusted}, nil
+}
+
+// Resolve returns the client address. X-Forwarded-For is walked from the
+// right, skipping trusted proxies, so a client can't spoof its address by
+// sending its own header. X-Real-IP is used when there is no XFF chain.
+func (c *ClientIPResolver) Resolve(r *http.Request) (netip.Addr, bool) {
+    peer, ok := parseAddr(r.RemoteAddr)
+    if !ok {
+        return netip.Addr{}, false
+    }
+    if !containsAddr(c.trusted, peer) {
+        return peer, true
+    }
+
+    var hops []string
+    for _, header := range r.Header.Values("X-Forwarded-For") {
+        hops = append(hops, strings.Split(header, ",")...)
+    }
+
+    client := peer
+    for i := len(hops) - 1; i >= 0; i-- {
+        addr, ok := parseAddr(hops[i])
+        if !ok {
+            // A malformed hop can't be attributed, so stop at the last
+            // address a trusted proxy vouched for
+            return client, true
+        }
+        client = addr
+        if !containsAddr(c.trusted, addr) {
+            return client, true
+        }
+    }
+
+    if len(hops) == 0 {
+        if addr, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+            return addr, true
+        }
+    }
+
+    return client, true
+}
+
+// ResolveClientIP stores the resolved client address on the request context
+// for the rate limiter, IP filter and audit log
+func ResolveClientIP(resolver *ClientIPResolver) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if addr, ok := resolver.Resolve(r); ok {
+                r = r.WithContext(auth.WithClientIP(r.Context(), addr.String()))
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// ClientIP returns the address stored by ResolveClientIP, falling back to
+// the host part of RemoteAddr when the middleware did not run
+func ClientIP(r *http.Request) string {
+    if ip := auth.ClientIPFromContext(r.Context()); ip != "" {
+        return ip
+    }
+    if addr, ok := parseAddr(r.RemoteAddr); ok {
+        return addr.String()
+    }
+    return r.RemoteAddr
+}
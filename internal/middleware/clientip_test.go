@@ -0,0 +1,166 @@
+package middleware
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefixes(t *testing.T) {
+    prefixes, err := ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32", "::1", " "})
+    assert.NoError(t, err)
+    assert.Len(t, prefixes, 4)
+    assert.Equal(t, 32, prefixes[1].Bits())
+    assert.Equal(t, 128, prefixes[3].Bits())
+
+    _, err = ParsePrefixes([]string{"10.0.0.0/33"})
+    assert.Error(t, err)
+    _, err = ParsePrefixes([]string{"not-an-ip"})
+    assert.Error(t, err)
+}
+
+func TestClientIPResolver_Resolve(t *testing.T) {
+    resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "fd00::/8"})
+    assert.NoError(t, err)
+
+    tests := []struct {
+        name       string
+        remoteAddr string
+        xff        []string
+        realIP     string
+        want       string
+    }{
+        {
+            name:       "IPv4 peer without proxy",
+            remoteAddr: "203.0.113.7:54321",
+            want:       "203.0.113.7",
+        },
+        {
+            name:       "IPv6 peer without proxy",
+            remoteAddr: "[2001:db8::42]:443",
+            want:       "2001:db8::42",
+        },
+        {
+            name:       "IPv6 peer with zone",
+            remoteAddr: "[fe80::1%eth0]:443",
+            want:       "fe80::1",
+        },
+        {
+            name:       "Spoofed XFF from untrusted peer is ignored",
+            remoteAddr: "203.0.113.7:54321",
+            xff:        []string{"1.2.3.4"},
+            want:       "203.0.113.7",
+        },
+        {
+            name:       "Spoofed X-Real-IP from untrusted peer is ignored",
+            remoteAddr: "203.0.113.7:54321",
+            realIP:     "1.2.3.4",
+            want:       "203.0.113.7",
+        },
+        {
+            name:       "Single trusted hop",
+            remoteAddr: "10.1.2.3:8080",
+            xff:        []string{"198.51.100.9"},
+            want:       "198.51.100.9",
+        },
+        {
+            name:       "Multiple trusted hops are skipped right to left",
+            remoteAddr: "10.0.0.1:8080",
+            xff:        []string{"198.51.100.9, 10.2.0.5, 10.3.0.7"},
+            want:       "198.51.100.9",
+        },
+        {
+            name:       "Client-supplied entries left of the first untrusted hop are ignored",
+            remoteAddr: "10.0.0.1:8080",
+            xff:        []string{"1.2.3.4, 198.51.100.9, 10.2.0.5"},
+            want:       "198.51.100.9",
+        },
+        {
+            name:       "Hops split across several headers",
+            remoteAddr: "[fd00::1]:8080",
+            xff:        []string{"2001:db8::7", "fd00::2"},
+            want:       "2001:db8::7",
+        },
+        {
+            name:       "IPv6 hop with brackets and port",
+            remoteAddr: "10.0.0.1:8080",
+            xff:        []string{"[2001:db8::7]:1234"},
+            want:       "2001:db8::7",
+        },
+        {
+            name:       "Malformed hop stops at last trusted address",
+            remoteAddr: "10.0.0.1:8080",
+            xff:        []string{"198.51.100.9, garbage, 10.2.0.5"},
+            want:       "10.2.0.5",
+        },
+        {
+            name:       "All hops trusted",
+            remoteAddr: "10.0.0.1:8080",
+            xff:        []string{"10.9.9.9, 10.2.0.5"},
+            want:       "10.9.9.9",
+        },
+        {
+            name:       "X-Real-IP from trusted proxy",
+            remoteAddr: "10.0.0.1:8080",
+            realIP:     "198.51.100.9",
+            want:       "198.51.100.9",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest("GET", "/", nil)
+            req.RemoteAddr = tt.remoteAddr
+            for _, v := range tt.xff {
+                req.Header.Add("X-Forwarded-For", v)
+            }
+            if tt.realIP != "" {
+                req.Header.Set("X-Real-IP", tt.realIP)
+            }
+
+            addr, ok := resolver.Resolve(req)
+            assert.True(t, ok)
+            assert.Equal(t, tt.want, addr.String())
+        })
+    }
+}
+
+func TestIPFilter(t *testing.T) {
+    ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    resolver, err := NewClientIPResolver([]string{"10.0.0.1"})
+    assert.NoError(t, err)
+    handler := ResolveClientIP(resolver)(IPFilter([]string{"192.168.0.0/16", "2001:db8::/48", "203.0.113.7"})(ok))
+
+    tests := []struct {
+        name       string
+        remoteAddr string
+        xff        string
+        want       int
+    }{
+        {"IPv4 inside CIDR", "192.168.4.20:1000", "", http.StatusOK},
+        {"Single IPv4 entry", "203.0.113.7:1000", "", http.StatusOK},
+        {"IPv4 outside CIDR", "192.169.0.1:1000", "", http.StatusForbidden},
+        {"IPv6 inside CIDR", "[2001:db8:0:1::5]:1000", "", http.StatusOK},
+        {"IPv6 outside CIDR", "[2001:db8:1::5]:1000", "", http.StatusForbidden},
+        {"Client behind trusted proxy", "10.0.0.1:1000", "192.168.1.1", http.StatusOK},
+        {"Spoofed XFF from untrusted peer", "198.51.100.1:1000", "192.168.1.1", http.StatusForbidden},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest("GET", "/", nil)
+            req.RemoteAddr = tt.remoteAddr
+            if tt.xff != "" {
+                req.Header.Set("X-Forwarded-For", tt.xff)
+            }
+
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            assert.Equal(t, tt.want, rec.Code)
+        })
+    }
+}
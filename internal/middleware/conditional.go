@@ -0,0 +1,135 @@
+package middleware
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ConditionalGET answers repeated GET requests for an unchanged resource
+// with 304 Not Modified. Handlers opt in by calling SetVersion before
+// writing the response; the response then carries a strong ETag over the
+// version and body, and a Cache-Control max-age running until the resource
+// goes stale. Responses of handlers that don't call it pass straight
+// through.
+func ConditionalGET(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            next.ServeHTTP(w, r)
+            return
+        }
+        cw := &conditionalWriter{ResponseWriter: w}
+        next.ServeHTTP(cw, r)
+        cw.finish(r)
+    })
+}
+
+// SetVersion identifies the resource about to be written and when it stops
+// being fresh. It has no effect outside ConditionalGET or after the
+// response has started.
+func SetVersion(w http.ResponseWriter, version string, freshUntil time.Time) {
+    if cw, ok := w.(*conditionalWriter); ok && !cw.started {
+        cw.versioned = true
+        cw.version = version
+        cw.freshUntil = freshUntil
+    }
+}
+
+// conditionalWriter buffers a versioned response until the handler returns,
+// since the ETag depends on the whole body
+type conditionalWriter struct {
+    http.ResponseWriter
+    versioned  bool
+    version    string
+    freshUntil time.Time
+
+    // started is set once anything is written; unversioned writes go
+    // straight to the client
+    started bool
+    status  int
+    body    bytes.Buffer
+}
+
+func (cw *conditionalWriter) WriteHeader(status int) {
+    if cw.started {
+        return
+    }
+    cw.started = true
+    if !cw.versioned {
+        cw.ResponseWriter.WriteHeader(status)
+        return
+    }
+    cw.status = status
+}
+
+func (cw *conditionalWriter) Write(b []byte) (int, error) {
+    if !cw.started {
+        cw.WriteHeader(http.StatusOK)
+    }
+    if !cw.versioned {
+        return cw.ResponseWriter.Write(b)
+    }
+    return cw.body.Write(b)
+}
+
+func (cw *conditionalWriter) finish(r *http.Request) {
+    if !cw.versioned {
+        return
+    }
+    if !cw.started {
+        cw.status = http.StatusOK
+    }
+    if cw.status != http.StatusOK {
+        cw.ResponseWriter.WriteHeader(cw.status)
+        cw.ResponseWriter.Write(cw.body.Bytes())
+        return
+    }
+
+    hash := sha256.New()
+    fmt.Fprintf(hash, "%s\n", cw.version)
+    hash.Write(cw.body.Bytes())
+    etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+    header := cw.ResponseWriter.Header()
+    header.Set("ETag", etag)
+    header.Set("Cache-Control", cacheControl(cw.freshUntil))
+
+    if etagMatches(r.Header.Get("If-None-Match"), etag) {
+        header.Del("Content-Length")
+        header.Del("Content-Type")
+        cw.ResponseWriter.WriteHeader(http.StatusNotModified)
+        return
+    }
+    cw.ResponseWriter.WriteHeader(http.StatusOK)
+    if r.Method != http.MethodHead {
+        cw.ResponseWriter.Write(cw.body.Bytes())
+    }
+}
+
+// cacheControl lets private caches reuse a response until freshUntil, after
+// which they must revalidate
+func cacheControl(freshUntil time.Time) string {
+    remaining := time.Until(freshUntil).Round(time.Second)
+    if remaining <= 0 {
+        return "private, max-age=0, must-revalidate"
+    }
+    return fmt.Sprintf("private, max-age=%d", int(remaining.Seconds()))
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+    if ifNoneMatch == "" {
+        return false
+    }
+    for _, candidate := range strings.Split(ifNoneMatch, ",") {
+        candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+        if candidate == "*" || candidate == etag {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestConditionalGET(t *testing.T) {
+    version := "2024-03-01T12:00:00Z"
+    freshUntil := time.Now().Add(10 * time.Minute)
+    payload := map[string]float64{"sentiment": 0.4}
+
+    handler := ConditionalGET(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        SetVersion(w, version, freshUntil)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(payload)
+    }))
+    get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", "/market/BTC/analysis", nil)
+        if ifNoneMatch != "" {
+            req.Header.Set("If-None-Match", ifNoneMatch)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+
+    first := get("")
+    etag := first.Header().Get("ETag")
+
+    t.Run("Miss", func(t *testing.T) {
+        assert.Equal(t, http.StatusOK, first.Code)
+        assert.JSONEq(t, `{"sentiment": 0.4}`, first.Body.String())
+        assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+        maxAge, err := strconv.Atoi(strings.TrimPrefix(first.Header().Get("Cache-Control"), "private, max-age="))
+        assert.NoError(t, err)
+        assert.InDelta(t, 600, maxAge, 2)
+
+        assert.Equal(t, http.StatusOK, get(`"something-else"`).Code)
+    })
+
+    t.Run("Hit", func(t *testing.T) {
+        for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+            rec := get(ifNoneMatch)
+            assert.Equal(t, http.StatusNotModified, rec.Code, ifNoneMatch)
+            assert.Empty(t, rec.Body.String())
+            assert.Equal(t, etag, rec.Header().Get("ETag"))
+        }
+    })
+
+    t.Run("Changed content", func(t *testing.T) {
+        payload = map[string]float64{"sentiment": 0.5}
+        defer func() { payload = map[string]float64{"sentiment": 0.4} }()
+
+        rec := get(etag)
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+    })
+
+    t.Run("Changed version", func(t *testing.T) {
+        version = "2024-03-01T12:15:00Z"
+        defer func() { version = "2024-03-01T12:00:00Z" }()
+
+        assert.Equal(t, http.StatusOK, get(etag).Code)
+    })
+
+    t.Run("Expired", func(t *testing.T) {
+        freshUntil = time.Now().Add(-time.Minute)
+
+        rec := get("")
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.Equal(t, "private, max-age=0, must-revalidate", rec.Header().Get("Cache-Control"))
+
+        // Unchanged content still revalidates without a body
+        assert.Equal(t, http.StatusNotModified, get(etag).Code)
+    })
+}
+
+func TestConditionalGET_Passthrough(t *testing.T) {
+    t.Run("Unversioned", func(t *testing.T) {
+        handler := ConditionalGET(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Write([]byte("live"))
+        }))
+        req := httptest.NewRequest("GET", "/", nil)
+        req.Header.Set("If-None-Match", "*")
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.Equal(t, "live", rec.Body.String())
+        assert.Empty(t, rec.Header().Get("ETag"))
+    })
+
+    t.Run("Error response", func(t *testing.T) {
+        handler := ConditionalGET(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            SetVersion(w, "v1", time.Now().Add(time.Minute))
+            http.Error(w, "Error fetching market analysis", http.StatusInternalServerError)
+        }))
+        req := httptest.NewRequest("GET", "/", nil)
+        req.Header.Set("If-None-Match", "*")
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+
+        assert.Equal(t, http.StatusInternalServerError, rec.Code)
+        assert.Contains(t, rec.Body.String(), "Error fetching market analysis")
+        assert.Empty(t, rec.Header().Get("ETag"))
+    })
+}
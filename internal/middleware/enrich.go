@@ -0,0 +1,221 @@
+package middleware
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    subscriptionTierKey contextKey = "subscription_tier"
+    quotaRemainingKey   contextKey = "quota_remaining"
+    featureFlagsKey     contextKey = "feature_flags"
+)
+
+const (
+    // UserContextTTL is how long a user's context is served from Redis, and
+    // so how long a tier change or used quota can take to show
+    UserContextTTL = 5 * time.Minute
+    // UnlimitedQuota is the remaining quota of tiers without a daily
+    // prediction limit
+    UnlimitedQuota int64 = -1
+
+    userContextKeyPrefix = "user:context:"
+)
+
+// UserContext is what a user's subscription allows, as handlers see it
+type UserContext struct {
+    SubscriptionTier string `json:"subscription_tier"`
+    // QuotaRemaining is the predictions left today, or UnlimitedQuota
+    QuotaRemaining int64 `json:"quota_remaining"`
+    // FeatureFlags are the features the tier grants
+    FeatureFlags []string `json:"feature_flags"`
+}
+
+// UserContextCache loads users' UserContext from the database, keeping it
+// in Redis for UserContextTTL. Without a reachable Redis every load goes to
+// the database.
+type UserContextCache struct {
+    client *redis.Client
+    health *cache.RedisHealth
+    db     *sql.DB
+    gate   *auth.FeatureGate
+    // predictions counts the user's predictions today
+    predictions Usage
+    ttl         time.Duration
+}
+
+func NewUserContextCache(client *redis.Client, db *sql.DB, gate *auth.FeatureGate, predictions Usage) *UserContextCache {
+    return &UserContextCache{
+        client:      client,
+        db:          db,
+        gate:        gate,
+        predictions: predictions,
+        ttl:         UserContextTTL,
+    }
+}
+
+// WithHealth reports Redis failures to health and skips Redis while it is
+// down
+func (c *UserContextCache) WithHealth(health *cache.RedisHealth) *UserContextCache {
+    c.health = health
+    return c
+}
+
+func (c *UserContextCache) available() bool {
+    return c.client != nil && c.health.Available()
+}
+
+// Get returns userID's context, from Redis when cached there
+func (c *UserContextCache) Get(ctx context.Context, userID uuid.UUID) (*UserContext, error) {
+    key := userContextKeyPrefix + userID.String()
+    if uc, ok := c.cached(ctx, key); ok {
+        return uc, nil
+    }
+
+    uc, err := c.load(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+    c.store(ctx, key, uc)
+    return uc, nil
+}
+
+// Invalidate drops userID's cached context, so the next request loads it
+// afresh
+func (c *UserContextCache) Invalidate(ctx context.Context, userID uuid.UUID) error {
+    if !c.available() {
+        return nil
+    }
+    err := c.client.Del(ctx, userContextKeyPrefix+userID.String()).Err()
+    c.health.Observe(err)
+    return err
+}
+
+// cached decodes the context under key, reporting whether it was cached. A
+// Redis failure or an undecodable entry is a miss.
+func (c *UserContextCache) cached(ctx context.Context, key string) (*UserContext, bool) {
+    if !c.available() {
+        c.health.Fallback("user_context_read", nil)
+        return nil, false
+    }
+    data, err := c.client.Get(ctx, key).Bytes()
+    if err == redis.Nil {
+        return nil, false
+    }
+    if err != nil {
+        c.health.Fallback("user_context_read", err)
+        return nil, false
+    }
+    c.health.Observe(nil)
+
+    var uc UserContext
+    if err := json.Unmarshal(data, &uc); err != nil {
+        return nil, false
+    }
+    return &uc, true
+}
+
+// store caches uc under key on a best-effort basis
+func (c *UserContextCache) store(ctx context.Context, key string, uc *UserContext) {
+    if !c.available() {
+        c.health.Fallback("user_context_write", nil)
+        return
+    }
+    data, err := json.Marshal(uc)
+    if err != nil {
+        return
+    }
+    if err := c.client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+        c.health.Fallback("user_context_write", err)
+    }
+}
+
+// load reads userID's tier from the database and works out the rest from it
+func (c *UserContextCache) load(ctx context.Context, userID uuid.UUID) (*UserContext, error) {
+    user := &models.User{ID: userID}
+    err := c.db.QueryRowContext(ctx,
+        "SELECT subscription_tier FROM users WHERE id = $1 AND deleted_at IS NULL",
+        userID,
+    ).Scan(&user.SubscriptionTier)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get subscription tier: %w", err)
+    }
+
+    uc := &UserContext{
+        SubscriptionTier: user.SubscriptionTier,
+        QuotaRemaining:   UnlimitedQuota,
+        FeatureFlags:     c.gate.Features(user),
+    }
+    if limit, ok := c.gate.LimitFor(user, auth.LimitDailyPredictions); ok {
+        used, err := c.predictions(ctx, user)
+        if err != nil {
+            return nil, err
+        }
+        uc.QuotaRemaining = int64(limit - used)
+        if uc.QuotaRemaining < 0 {
+            uc.QuotaRemaining = 0
+        }
+    }
+    return uc, nil
+}
+
+// EnrichUserContext puts the authenticated user's subscription tier, quota
+// and feature flags on the request context, for GetSubscriptionTier,
+// GetQuotaRemaining and GetFeatureFlags. It must run after RequireAuth.
+// A context that can't be loaded is logged and the request continues
+// without it.
+func EnrichUserContext(users *UserContextCache) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+            if !ok {
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            uc, err := users.Get(r.Context(), userID)
+            if err != nil {
+                logger.FromContext(r.Context()).WithFields(map[string]interface{}{
+                    "error": err.Error(),
+                }).Warn("Failed to load user context")
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), subscriptionTierKey, uc.SubscriptionTier)
+            ctx = context.WithValue(ctx, quotaRemainingKey, uc.QuotaRemaining)
+            ctx = context.WithValue(ctx, featureFlagsKey, uc.FeatureFlags)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+// GetSubscriptionTier returns the tier set by EnrichUserContext, or ""
+func GetSubscriptionTier(ctx context.Context) string {
+    tier, _ := ctx.Value(subscriptionTierKey).(string)
+    return tier
+}
+
+// GetQuotaRemaining returns the predictions left today as set by
+// EnrichUserContext, UnlimitedQuota, or 0 without an enriched context
+func GetQuotaRemaining(ctx context.Context) int64 {
+    quota, _ := ctx.Value(quotaRemainingKey).(int64)
+    return quota
+}
+
+// GetFeatureFlags returns the features set by EnrichUserContext
+func GetFeatureFlags(ctx context.Context) []string {
+    flags, _ := ctx.Value(featureFlagsKey).([]string)
+    return flags
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestEnrichUserContext(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mr := miniredis.RunT(t)
+    predictionCounts := 0
+    predictions := func(ctx context.Context, user *models.User) (int, error) {
+        predictionCounts++
+        return 5, nil
+    }
+    users := NewUserContextCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), db,
+        auth.NewFeatureGate(auth.DefaultTiers()), predictions)
+
+    var tier string
+    var quota int64
+    var flags []string
+    handler := EnrichUserContext(users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        tier = GetSubscriptionTier(r.Context())
+        quota = GetQuotaRemaining(r.Context())
+        flags = GetFeatureFlags(r.Context())
+        w.WriteHeader(http.StatusOK)
+    }))
+    send := func(userID uuid.UUID) int {
+        req := httptest.NewRequest("GET", "/me", nil)
+        req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    t.Run("cache miss loads from the database", func(t *testing.T) {
+        userID := uuid.New()
+        mock.ExpectQuery("SELECT subscription_tier FROM users").
+            WithArgs(userID).
+            WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(auth.TierFree))
+
+        assert.Equal(t, http.StatusOK, send(userID))
+        assert.Equal(t, auth.TierFree, tier)
+        assert.Equal(t, int64(15), quota)
+        assert.Empty(t, flags)
+        assert.Equal(t, 1, predictionCounts)
+        assert.NoError(t, mock.ExpectationsWereMet())
+        assert.True(t, mr.Exists(userContextKeyPrefix+userID.String()))
+        assert.Equal(t, UserContextTTL, mr.TTL(userContextKeyPrefix+userID.String()))
+
+        // Within the TTL the database isn't queried again; sqlmock fails
+        // any query it wasn't told to expect
+        for i := 0; i < 3; i++ {
+            tier, quota = "", 0
+            assert.Equal(t, http.StatusOK, send(userID))
+            assert.Equal(t, auth.TierFree, tier)
+            assert.Equal(t, int64(15), quota)
+        }
+        assert.Equal(t, 1, predictionCounts)
+        assert.NoError(t, mock.ExpectationsWereMet())
+
+        // Once it has expired the context is loaded again
+        mr.FastForward(UserContextTTL + time.Second)
+        mock.ExpectQuery("SELECT subscription_tier FROM users").
+            WithArgs(userID).
+            WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(auth.TierPro))
+
+        assert.Equal(t, http.StatusOK, send(userID))
+        assert.Equal(t, auth.TierPro, tier)
+        assert.Equal(t, int64(495), quota)
+        assert.ElementsMatch(t, []string{auth.FeatureOptimization, auth.FeatureBacktest}, flags)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("unlimited tier is not counted", func(t *testing.T) {
+        userID := uuid.New()
+        counted := predictionCounts
+        mock.ExpectQuery("SELECT subscription_tier FROM users").
+            WithArgs(userID).
+            WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(auth.TierEnterprise))
+
+        assert.Equal(t, http.StatusOK, send(userID))
+        assert.Equal(t, UnlimitedQuota, quota)
+        assert.Equal(t, counted, predictionCounts)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("cached context is served without the database", func(t *testing.T) {
+        userID := uuid.New()
+        mr.Set(userContextKeyPrefix+userID.String(), `{"subscription_tier":"pro","quota_remaining":42,"feature_flags":["backtest"]}`)
+
+        assert.Equal(t, http.StatusOK, send(userID))
+        assert.Equal(t, auth.TierPro, tier)
+        assert.Equal(t, int64(42), quota)
+        assert.Equal(t, []string{auth.FeatureBacktest}, flags)
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("unloadable context passes the request through", func(t *testing.T) {
+        userID := uuid.New()
+        mock.ExpectQuery("SELECT subscription_tier FROM users").
+            WithArgs(userID).
+            WillReturnError(context.DeadlineExceeded)
+
+        tier, quota = "stale", 7
+        assert.Equal(t, http.StatusOK, send(userID))
+        assert.Equal(t, "", tier)
+        assert.Equal(t, int64(0), quota)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+    "net/http"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// ImpersonatedByHeader is set on every response to a request made while
+// impersonating, to the email of the admin doing it. JSON object responses
+// also name the admin under render.ImpersonatedByField.
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// readMethods are the methods allowed while impersonating
+var readMethods = map[string]bool{
+    http.MethodGet:     true,
+    http.MethodHead:    true,
+    http.MethodOptions: true,
+}
+
+// GuardImpersonation keeps impersonation sessions read-only and writes
+// every request made during one to the audit log, naming the real admin.
+// Requests that can't be audited are refused. It must run after
+// RequireAuth.
+func (m *AuthMiddleware) GuardImpersonation(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        imp := auth.ImpersonationFromContext(r.Context())
+        if imp == nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Set(ImpersonatedByHeader, imp.AdminEmail)
+
+        blocked := !readMethods[r.Method]
+        if err := m.authService.RecordImpersonatedRequest(r.Context(), imp, r.Method, r.URL.Path, blocked); err != nil {
+            logger.FromContext(r.Context()).Errorf("Failed to audit impersonated request: %v", err)
+            http.Error(w, "Failed to audit request", http.StatusServiceUnavailable)
+            return
+        }
+        if blocked {
+            http.Error(w, auth.ErrReadOnly.Error(), http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(render.WithImpersonator(r.Context(), imp.AdminEmail)))
+    })
+}
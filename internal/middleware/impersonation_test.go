@@ -0,0 +1,140 @@
+package middleware
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestGuardImpersonation(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := auth.NewService(db, "secret")
+    admin := &models.User{ID: uuid.New(), Email: "support@wolfai.com", Role: auth.RoleAdmin}
+    userID := uuid.New()
+
+    mock.ExpectBegin()
+    mock.ExpectQuery("SELECT role FROM users").
+        WithArgs(userID).
+        WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(auth.RoleUser))
+    mock.ExpectExec("INSERT INTO impersonations").
+        WithArgs(sqlmock.AnyArg(), admin.ID, userID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("INSERT INTO audit_logs").
+        WithArgs(admin.Email, admin.ID, userID, "impersonation.started", userID.String(), sqlmock.AnyArg(), "").
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectCommit()
+    result, err := service.Impersonate(context.Background(), admin, userID)
+    require.NoError(t, err)
+    assert.WithinDuration(t, time.Now().Add(auth.ImpersonationLifetime), result.ExpiresAt, time.Minute)
+
+    var served []uuid.UUID
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        served = append(served, r.Context().Value("user").(*models.User).ID)
+        render.JSON(w, r, http.StatusOK, map[string]bool{"ok": true})
+    })
+    authMiddleware := NewAuthMiddleware(service)
+    router := mux.NewRouter()
+    router.Use(authMiddleware.RequireAuth, authMiddleware.GuardImpersonation)
+    router.Handle("/portfolios", handler).Methods("GET", "POST")
+    router.Handle("/portfolios/{id}", handler).Methods("DELETE")
+
+    expectLive := func() {
+        mock.ExpectQuery("SELECT (.+) FROM impersonations").
+            WithArgs(result.ID).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "role", "subscription_tier", "role", "expires_at", "ended_at"}).
+                AddRow(userID, "user@example.com", "User", auth.RoleUser, auth.TierFree, auth.RoleAdmin, result.ExpiresAt, nil))
+    }
+    request := func(method, path string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, nil)
+        req.Header.Set("Authorization", "Bearer "+result.Token)
+        rec := httptest.NewRecorder()
+        router.ServeHTTP(rec, req)
+        return rec
+    }
+
+    t.Run("Reads are served as the user and audited with the admin", func(t *testing.T) {
+        expectLive()
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs(admin.Email, admin.ID, userID, "impersonation.request", userID.String(), "GET /portfolios", "").
+            WillReturnResult(sqlmock.NewResult(2, 1))
+
+        rec := request("GET", "/portfolios")
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.Equal(t, admin.Email, rec.Header().Get(ImpersonatedByHeader))
+        assert.JSONEq(t, `{"impersonated_by": "support@wolfai.com", "ok": true}`, rec.Body.String())
+        assert.Equal(t, []uuid.UUID{userID}, served)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Mutations are rejected", func(t *testing.T) {
+        served = nil
+        for _, tt := range []struct{ method, path string }{{"POST", "/portfolios"}, {"DELETE", "/portfolios/7"}} {
+            expectLive()
+            mock.ExpectExec("INSERT INTO audit_logs").
+                WithArgs(admin.Email, admin.ID, userID, "impersonation.blocked", userID.String(), tt.method+" "+tt.path, "").
+                WillReturnResult(sqlmock.NewResult(3, 1))
+
+            rec := request(tt.method, tt.path)
+            assert.Equal(t, http.StatusForbidden, rec.Code)
+            assert.Equal(t, admin.Email, rec.Header().Get(ImpersonatedByHeader))
+        }
+        assert.Empty(t, served)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Requests that can't be audited are refused", func(t *testing.T) {
+        served = nil
+        expectLive()
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WillReturnError(errors.New("connection reset"))
+
+        rec := request("GET", "/portfolios")
+        assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+        assert.Empty(t, served)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Ended impersonations are rejected", func(t *testing.T) {
+        mock.ExpectBegin()
+        mock.ExpectExec("UPDATE impersonations SET ended_at").
+            WithArgs(sqlmock.AnyArg(), result.ID).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs(admin.Email, admin.ID, userID, "impersonation.ended", userID.String(), result.ID, "").
+            WillReturnResult(sqlmock.NewResult(4, 1))
+        mock.ExpectCommit()
+        require.NoError(t, service.EndImpersonation(context.Background(), service.Impersonation(result.Token)))
+
+        // Blacklisted, so rejected before any query
+        rec := request("GET", "/portfolios")
+        assert.Equal(t, http.StatusUnauthorized, rec.Code)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Ordinary sessions are untouched", func(t *testing.T) {
+        rec := httptest.NewRecorder()
+        req := httptest.NewRequest("POST", "/portfolios", nil)
+        req = req.WithContext(context.WithValue(req.Context(), "user", &models.User{ID: userID}))
+        authMiddleware.GuardImpersonation(handler).ServeHTTP(rec, req)
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.Empty(t, rec.Header().Get(ImpersonatedByHeader))
+        assert.JSONEq(t, `{"ok": true}`, rec.Body.String())
+    })
+}
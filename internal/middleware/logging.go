@@ -23,6 +23,12 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
     return size, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush and adjust deadlines
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+    return rw.ResponseWriter
+}
+
 func Logging(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         start := time.Now()
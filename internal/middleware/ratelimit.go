@@ -65,7 +65,7 @@ func (rl *RateLimiter) RateLimit(next http.Handler) http.Handler {
     go rl.cleanupVisitors()
     
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        ip := r.RemoteAddr
+        ip := ClientIP(r)
         limiter := rl.getVisitor(ip)
         if !limiter.Allow() {
             http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "runtime"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    apperrors "github.com/Cryptoprojectsfun/quantai-clone/internal/errors"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// maxStackSize bounds the stack trace captured for a panic
+const maxStackSize = 64 << 10
+
+// ErrorTracker receives the panics Recoverer recovers, e.g. to forward
+// them to an error reporting service
+type ErrorTracker interface {
+    CaptureError(ctx context.Context, err error, stack []byte)
+}
+
+// NopErrorTracker discards captured errors
+type NopErrorTracker struct{}
+
+func (NopErrorTracker) CaptureError(context.Context, error, []byte) {}
+
+// Recoverer turns handler panics into 500 responses instead of dropping
+// the connection
+type Recoverer struct {
+    logger     *logger.Logger
+    tracker    ErrorTracker
+    recoveries prometheus.Counter
+}
+
+// NewRecoverer logs panics to log, or to the request's logger when log is
+// nil. Its counter is registered with reg when reg is non-nil.
+func NewRecoverer(log *logger.Logger, reg prometheus.Registerer) *Recoverer {
+    rc := &Recoverer{
+        logger:  log,
+        tracker: NopErrorTracker{},
+        recoveries: prometheus.NewCounter(prometheus.CounterOpts{
+            Name: "panic_recoveries_total",
+            Help: "Number of handler panics recovered",
+        }),
+    }
+    if reg != nil {
+        reg.MustRegister(rc.recoveries)
+    }
+    return rc
+}
+
+// WithErrorTracker also reports recovered panics to tracker
+func (rc *Recoverer) WithErrorTracker(tracker ErrorTracker) *Recoverer {
+    rc.tracker = tracker
+    return rc
+}
+
+// Recovery recovers panics in next, logs them with their stack trace and
+// answers with a JSON internal error carrying the request ID
+func (rc *Recoverer) Recovery(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer func() {
+            rec := recover()
+            if rec == nil {
+                return
+            }
+            // The server uses this panic to abort a response on purpose
+            if rec == http.ErrAbortHandler {
+                panic(rec)
+            }
+
+            stack := make([]byte, maxStackSize)
+            stack = stack[:runtime.Stack(stack, false)]
+            err := panicError(rec)
+            rc.recoveries.Inc()
+
+            log := rc.logger
+            if log == nil {
+                log = logger.FromContext(r.Context())
+            }
+            requestID := requestIDFrom(r)
+            log.WithFields(map[string]interface{}{
+                "request_id": requestID,
+                "method":     r.Method,
+                "path":       r.URL.Path,
+                "error":      err.Error(),
+                "stack":      string(stack),
+            }).Error("Recovered from panic")
+            rc.tracker.CaptureError(r.Context(), err, stack)
+
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(err.StatusCode)
+            json.NewEncoder(w).Encode(apperrors.NewErrorResponse(err, requestID))
+        }()
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// panicError wraps a recovered value as an internal error. The message is
+// generic so panic details never reach the client.
+func panicError(rec interface{}) *apperrors.Error {
+    var cause error
+    switch v := rec.(type) {
+    case error:
+        cause = v
+    case string:
+        cause = errors.New(v)
+    default:
+        cause = fmt.Errorf("%v", v)
+    }
+    return apperrors.NewInternalError("Internal server error", fmt.Errorf("panic: %w", cause))
+}
+
+func requestIDFrom(r *http.Request) string {
+    if id, ok := r.Context().Value("request_id").(string); ok && id != "" {
+        return id
+    }
+    return r.Header.Get("X-Request-ID")
+}
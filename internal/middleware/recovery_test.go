@@ -0,0 +1,92 @@
+package middleware
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+)
+
+type recordingTracker struct {
+    errs   []error
+    stacks [][]byte
+}
+
+func (t *recordingTracker) CaptureError(ctx context.Context, err error, stack []byte) {
+    t.errs = append(t.errs, err)
+    t.stacks = append(t.stacks, stack)
+}
+
+func TestRecoverer_Recovery(t *testing.T) {
+    tracker := &recordingTracker{}
+    recoverer := NewRecoverer(nil, prometheus.NewRegistry()).WithErrorTracker(tracker)
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+        panic("portfolio cache corrupted")
+    })
+    mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+        panic(errors.New("nil price source"))
+    })
+    mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    server := httptest.NewServer(recoverer.Recovery(mux))
+    defer server.Close()
+
+    t.Run("String panic", func(t *testing.T) {
+        req, _ := http.NewRequest("GET", server.URL+"/panic", nil)
+        req.Header.Set("X-Request-ID", "req-42")
+        resp, err := http.DefaultClient.Do(req)
+        if !assert.NoError(t, err) {
+            return
+        }
+        defer resp.Body.Close()
+
+        assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+        assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+        var body map[string]interface{}
+        assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+        assert.Equal(t, "error", body["status"])
+        assert.Equal(t, "req-42", body["request_id"])
+        assert.Equal(t, "Internal server error", body["message"])
+        // Panic details stay out of the response
+        assert.NotContains(t, body["message"], "portfolio cache")
+
+        if assert.Len(t, tracker.errs, 1) {
+            assert.Contains(t, tracker.errs[0].Error(), "portfolio cache corrupted")
+            assert.Contains(t, string(tracker.stacks[0]), "recovery_test.go")
+        }
+    })
+
+    t.Run("Error panic keeps the cause", func(t *testing.T) {
+        resp, err := http.Get(server.URL + "/error")
+        if !assert.NoError(t, err) {
+            return
+        }
+        resp.Body.Close()
+
+        assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+        if assert.Len(t, tracker.errs, 2) {
+            assert.Contains(t, tracker.errs[1].Error(), "nil price source")
+        }
+    })
+
+    t.Run("Server keeps serving", func(t *testing.T) {
+        resp, err := http.Get(server.URL + "/ok")
+        if !assert.NoError(t, err) {
+            return
+        }
+        resp.Body.Close()
+        assert.Equal(t, http.StatusOK, resp.StatusCode)
+    })
+
+    assert.Equal(t, float64(2), testutil.ToFloat64(recoverer.recoveries))
+}
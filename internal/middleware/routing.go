@@ -0,0 +1,106 @@
+package middleware
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gorilla/mux"
+
+    apperrors "github.com/Cryptoprojectsfun/quantai-clone/internal/errors"
+)
+
+// routedMethods are the methods routes are registered with. HEAD and
+// OPTIONS are answered by Routing for every route instead.
+var routedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Routing serves router with JSON errors for unknown paths and methods.
+// OPTIONS is answered for every routed path with the methods it allows,
+// ahead of the routes' middleware, so it is never asked to authenticate;
+// CORS preflights are answered by the CORS handler wrapping Routing. HEAD
+// is served by the GET route with the body dropped, running the handler
+// once.
+func Routing(router *mux.Router) http.Handler {
+    router.NotFoundHandler = http.HandlerFunc(notFound)
+    router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        methodNotAllowed(w, r, allowedMethods(router, r))
+    })
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodOptions:
+            allowed := allowedMethods(router, r)
+            if len(allowed) == 0 {
+                notFound(w, r)
+                return
+            }
+            w.Header().Set("Allow", strings.Join(allowed, ", "))
+            w.WriteHeader(http.StatusNoContent)
+            return
+        case http.MethodHead:
+            if !routes(router, r, http.MethodHead) {
+                get := r.WithContext(r.Context())
+                get.Method = http.MethodGet
+                router.ServeHTTP(headWriter{w}, get)
+                return
+            }
+        }
+        router.ServeHTTP(w, r)
+    })
+}
+
+// allowedMethods returns the methods router has a route for r's path with,
+// or none if the path isn't routed
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+    var allowed []string
+    for _, method := range routedMethods {
+        if !routes(router, r, method) {
+            continue
+        }
+        allowed = append(allowed, method)
+        if method == http.MethodGet {
+            allowed = append(allowed, http.MethodHead)
+        }
+    }
+    if len(allowed) > 0 {
+        allowed = append(allowed, http.MethodOptions)
+    }
+    return allowed
+}
+
+// routes reports whether router has a route for r's path with method
+func routes(router *mux.Router, r *http.Request, method string) bool {
+    probe := r.WithContext(r.Context())
+    probe.Method = method
+    var match mux.RouteMatch
+    return router.Match(probe, &match) && match.MatchErr == nil
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+    writeError(w, r, apperrors.NewNotFoundError(fmt.Sprintf("No route for %s", r.URL.Path), nil))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+    w.Header().Set("Allow", strings.Join(allowed, ", "))
+    writeError(w, r, apperrors.NewMethodNotAllowedError(
+        fmt.Sprintf("Method %s not allowed for %s", r.Method, r.URL.Path), nil,
+    ).WithDetails(map[string]interface{}{
+        "allowed": allowed,
+    }))
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err *apperrors.Error) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(err.StatusCode)
+    json.NewEncoder(w).Encode(apperrors.NewErrorResponse(err, requestIDFrom(r)))
+}
+
+// headWriter drops the body a GET route writes in answer to HEAD
+type headWriter struct {
+    http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+    return len(p), nil
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+    "github.com/rs/cors"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    apperrors "github.com/Cryptoprojectsfun/quantai-clone/internal/errors"
+)
+
+func TestRouting(t *testing.T) {
+    var gets, authenticated int
+    router := mux.NewRouter()
+    api := router.PathPrefix("/api/v1").Subrouter()
+    protected := api.PathPrefix("").Subrouter()
+    protected.Use(func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            authenticated++
+            if r.Header.Get("Authorization") == "" {
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    })
+    protected.HandleFunc("/portfolios", func(w http.ResponseWriter, r *http.Request) {
+        gets++
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte(`[{"id": 1}]`))
+    }).Methods("GET")
+    protected.HandleFunc("/portfolios", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusCreated)
+    }).Methods("POST")
+
+    handler := cors.New(cors.Options{
+        AllowedOrigins: []string{"https://app.wolfai.com"},
+        AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+        AllowedHeaders: []string{"Authorization", "Content-Type"},
+    }).Handler(Routing(router))
+
+    serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, nil)
+        for k, v := range header {
+            req.Header[k] = v
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+    decode := func(t *testing.T, rec *httptest.ResponseRecorder) apperrors.ErrorResponse {
+        assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+        var resp apperrors.ErrorResponse
+        require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+        return resp
+    }
+    authorized := http.Header{"Authorization": {"Bearer token"}}
+
+    t.Run("Unknown paths are a JSON 404", func(t *testing.T) {
+        for _, path := range []string{"/nope", "/api/v1/nope"} {
+            rec := serve("GET", path, authorized)
+            assert.Equal(t, http.StatusNotFound, rec.Code, path)
+            resp := decode(t, rec)
+            assert.Equal(t, "error", resp.Status)
+            assert.Equal(t, "NOT_FOUND", resp.ErrorCode)
+        }
+    })
+
+    t.Run("Other methods are a JSON 405 listing the allowed", func(t *testing.T) {
+        authenticated = 0
+        rec := serve("DELETE", "/api/v1/portfolios", authorized)
+        assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+        assert.Equal(t, "GET, HEAD, POST, OPTIONS", rec.Header().Get("Allow"))
+        resp := decode(t, rec)
+        assert.Equal(t, "METHOD_NOT_ALLOWED", resp.ErrorCode)
+        assert.Equal(t, []interface{}{"GET", "HEAD", "POST", "OPTIONS"}, resp.Details["allowed"])
+        assert.Zero(t, authenticated)
+    })
+
+    t.Run("OPTIONS skips authentication", func(t *testing.T) {
+        authenticated = 0
+
+        preflight := serve("OPTIONS", "/api/v1/portfolios", http.Header{
+            "Origin":                        {"https://app.wolfai.com"},
+            "Access-Control-Request-Method": {"POST"},
+        })
+        assert.Equal(t, http.StatusNoContent, preflight.Code)
+        assert.Equal(t, "https://app.wolfai.com", preflight.Header().Get("Access-Control-Allow-Origin"))
+
+        plain := serve("OPTIONS", "/api/v1/portfolios", nil)
+        assert.Equal(t, http.StatusNoContent, plain.Code)
+        assert.Equal(t, "GET, HEAD, POST, OPTIONS", plain.Header().Get("Allow"))
+
+        assert.Equal(t, http.StatusNotFound, serve("OPTIONS", "/api/v1/nope", nil).Code)
+        assert.Zero(t, authenticated)
+    })
+
+    t.Run("HEAD runs the GET route once without its body", func(t *testing.T) {
+        gets = 0
+        rec := serve("HEAD", "/api/v1/portfolios", authorized)
+        assert.Equal(t, http.StatusOK, rec.Code)
+        assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+        assert.Empty(t, rec.Body.String())
+        assert.Equal(t, 1, gets)
+
+        // Still authenticated like the GET
+        assert.Equal(t, http.StatusUnauthorized, serve("HEAD", "/api/v1/portfolios", nil).Code)
+    })
+}
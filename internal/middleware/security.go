@@ -3,7 +3,8 @@ package middleware
 import (
     "net/http"
     "strings"
-    "crypto/subtle"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
 )
 
 type SecurityHeaders struct {
@@ -12,7 +13,14 @@ type SecurityHeaders struct {
     AllowedOrigins  []string
 }
 
+// Security panics if opts.TrustedProxies contains an invalid IP or CIDR,
+// since serving with a half-applied proxy list would misattribute clients
 func Security(opts SecurityHeaders) func(http.Handler) http.Handler {
+    resolver, err := NewClientIPResolver(opts.TrustedProxies)
+    if err != nil {
+        panic(err)
+    }
+
     return func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
             // Content Security Policy
@@ -40,16 +48,8 @@ func Security(opts SecurityHeaders) func(http.Handler) http.Handler {
             }
 
             // Real IP handling
-            realIP := r.Header.Get("X-Real-IP")
-            if realIP != "" {
-                // Validate proxy
-                remoteIP := strings.Split(r.RemoteAddr, ":")[0]
-                for _, trusted := range opts.TrustedProxies {
-                    if subtle.ConstantTimeCompare([]byte(remoteIP), []byte(trusted)) == 1 {
-                        r.RemoteAddr = realIP
-                        break
-                    }
-                }
+            if addr, ok := resolver.Resolve(r); ok {
+                r = r.WithContext(auth.WithClientIP(r.Context(), addr.String()))
             }
 
             next.ServeHTTP(w, r)
@@ -74,20 +74,20 @@ func SecureHeaders() func(http.Handler) http.Handler {
     }
 }
 
+// IPFilter only lets through clients inside allowedIPs, which may mix
+// single IPs and CIDR ranges. It checks the address resolved by Security or
+// ResolveClientIP when either runs first, and the connection's peer otherwise.
+// It panics on invalid entries.
 func IPFilter(allowedIPs []string) func(http.Handler) http.Handler {
+    allowedPrefixes, err := ParsePrefixes(allowedIPs)
+    if err != nil {
+        panic(err)
+    }
+
     return func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            ip := strings.Split(r.RemoteAddr, ":")[0]
-            allowed := false
-
-            for _, allowedIP := range allowedIPs {
-                if subtle.ConstantTimeCompare([]byte(ip), []byte(allowedIP)) == 1 {
-                    allowed = true
-                    break
-                }
-            }
-
-            if !allowed {
+            addr, ok := parseAddr(ClientIP(r))
+            if !ok || !containsAddr(allowedPrefixes, addr) {
                 http.Error(w, "Forbidden", http.StatusForbidden)
                 return
             }
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+)
+
+const testAPISecret = "admin-secret"
+
+func TestRequestSigner_Verify(t *testing.T) {
+    mr := miniredis.RunT(t)
+    signer := NewRequestSigner(redis.NewClient(&redis.Options{Addr: mr.Addr()}), testAPISecret)
+
+    var gotBody string
+    handler := signer.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        gotBody = string(body)
+        w.WriteHeader(http.StatusOK)
+    }))
+
+    body := `{"role":"analyst"}`
+    send := func(method, path, body string, headers map[string]string) int {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        for k, v := range headers {
+            req.Header.Set(k, v)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    headers, err := SignRequest("PUT", "/api/v1/admin/users/7/role", []byte(body), testAPISecret)
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    t.Run("Signed request", func(t *testing.T) {
+        assert.Equal(t, http.StatusOK, send("PUT", "/api/v1/admin/users/7/role", body, headers))
+        // The handler still sees the body the signature covered
+        assert.Equal(t, body, gotBody)
+
+        ttl := mr.TTL(nonceKeyPrefix + headers[NonceHeader])
+        assert.True(t, ttl > 0 && ttl <= nonceTTL, "ttl %s", ttl)
+    })
+
+    t.Run("Replayed nonce", func(t *testing.T) {
+        assert.Equal(t, http.StatusUnauthorized, send("PUT", "/api/v1/admin/users/7/role", body, headers))
+    })
+
+    t.Run("Expired timestamp", func(t *testing.T) {
+        stale := strconv.FormatInt(time.Now().Add(-signatureMaxSkew-time.Minute).Unix(), 10)
+        expired := signedHeaders([]byte(testAPISecret), "PUT", "/api/v1/admin/users/7/role", stale, "stale-nonce", []byte(body))
+        assert.Equal(t, http.StatusUnauthorized, send("PUT", "/api/v1/admin/users/7/role", body, expired))
+
+        future := strconv.FormatInt(time.Now().Add(signatureMaxSkew+time.Minute).Unix(), 10)
+        early := signedHeaders([]byte(testAPISecret), "PUT", "/api/v1/admin/users/7/role", future, "future-nonce", []byte(body))
+        assert.Equal(t, http.StatusUnauthorized, send("PUT", "/api/v1/admin/users/7/role", body, early))
+    })
+
+    t.Run("Tampered request", func(t *testing.T) {
+        headers, err := SignRequest("PUT", "/api/v1/admin/users/7/role", []byte(body), testAPISecret)
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, http.StatusUnauthorized, send("PUT", "/api/v1/admin/users/7/role", `{"role":"admin"}`, headers))
+        assert.Equal(t, http.StatusUnauthorized, send("PUT", "/api/v1/admin/users/8/role", body, headers))
+        assert.Equal(t, http.StatusUnauthorized, send("POST", "/api/v1/admin/users/7/role", body, headers))
+
+        // Rejected requests don't use up the nonce
+        assert.Equal(t, http.StatusOK, send("PUT", "/api/v1/admin/users/7/role", body, headers))
+    })
+
+    t.Run("Wrong secret", func(t *testing.T) {
+        headers, err := SignRequest("GET", "/api/v1/admin/audit?limit=10", nil, "other-secret")
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/admin/audit?limit=10", "", headers))
+    })
+
+    t.Run("Missing headers", func(t *testing.T) {
+        assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/admin/audit", "", nil))
+
+        headers, err := SignRequest("GET", "/api/v1/admin/audit", nil, testAPISecret)
+        if !assert.NoError(t, err) {
+            return
+        }
+        delete(headers, NonceHeader)
+        assert.Equal(t, http.StatusUnauthorized, send("GET", "/api/v1/admin/audit", "", headers))
+    })
+
+    t.Run("Nonce store unavailable", func(t *testing.T) {
+        headers, err := SignRequest("GET", "/api/v1/admin/audit", nil, testAPISecret)
+        if !assert.NoError(t, err) {
+            return
+        }
+        mr.Close()
+        assert.Equal(t, http.StatusServiceUnavailable, send("GET", "/api/v1/admin/audit", "", headers))
+    })
+}
+
+func TestRequestSigner_WithoutRedis(t *testing.T) {
+    signer := NewRequestSigner(nil, testAPISecret)
+    handler := signer.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    }))
+
+    headers, err := SignRequest("GET", "/api/v1/admin/audit", nil, testAPISecret)
+    if !assert.NoError(t, err) {
+        return
+    }
+    send := func() int {
+        req := httptest.NewRequest("GET", "/api/v1/admin/audit", nil)
+        for k, v := range headers {
+            req.Header.Set(k, v)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    // Nonces are kept in memory instead
+    assert.Equal(t, http.StatusOK, send())
+    assert.Equal(t, http.StatusUnauthorized, send())
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Usage returns how much of a tier limit user has used
+type Usage func(ctx context.Context, user *models.User) (int, error)
+
+// UpgradeRequiredResponse is the body of requests refused by the user's tier
+type UpgradeRequiredResponse struct {
+    Code    string `json:"code"`
+    Error   string `json:"error"`
+    Tier    string `json:"tier"`
+    Feature string `json:"feature"`
+    Limit   *int   `json:"limit,omitempty"`
+}
+
+// RequireFeature allows the request through only if the authenticated
+// user's subscription tier grants feature. It must run after RequireAuth,
+// which loads the user's current tier from the database.
+func RequireFeature(gate *auth.FeatureGate, feature string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user, ok := r.Context().Value("user").(*models.User)
+            if !ok || user == nil {
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+
+            if err := gate.RequireFeature(user, feature); err != nil {
+                writeUpgradeRequired(w, err)
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// RequireUnderLimit allows the request through only while usage is below
+// the user's tier limit. The check runs before the request, so a request
+// started under the limit may take the user past it.
+func RequireUnderLimit(gate *auth.FeatureGate, limit string, usage Usage) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user, ok := r.Context().Value("user").(*models.User)
+            if !ok || user == nil {
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+
+            // Unlimited tiers don't need their usage counted
+            if _, limited := gate.LimitFor(user, limit); limited {
+                used, err := usage(r.Context(), user)
+                if err != nil {
+                    http.Error(w, "Failed to check usage", http.StatusInternalServerError)
+                    return
+                }
+                if err := gate.CheckLimit(user, limit, used); err != nil {
+                    writeUpgradeRequired(w, err)
+                    return
+                }
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+func writeUpgradeRequired(w http.ResponseWriter, err error) {
+    response := UpgradeRequiredResponse{Code: auth.UpgradeRequiredCode, Error: err.Error()}
+    var upgrade *auth.UpgradeRequiredError
+    if errors.As(err, &upgrade) {
+        response.Tier = upgrade.Tier
+        response.Feature = upgrade.Feature
+        response.Limit = upgrade.Limit
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusForbidden)
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// tierRouter gates routes the way the API does, with portfolios and
+// predictions already counted at the given usage
+func tierRouter(user *models.User, portfolios, predictions int) *mux.Router {
+    ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    count := func(n int) Usage {
+        return func(ctx context.Context, user *models.User) (int, error) {
+            return n, nil
+        }
+    }
+    gate := auth.NewFeatureGate(auth.DefaultTiers())
+
+    router := mux.NewRouter()
+    router.Use(withUser(user))
+    router.Handle("/portfolios", RequireUnderLimit(gate, auth.LimitMaxPortfolios, count(portfolios))(ok)).Methods("POST")
+    router.Handle("/portfolios/{id}/optimize", RequireFeature(gate, auth.FeatureOptimization)(ok)).Methods("POST")
+    router.Handle("/ml/predict", RequireUnderLimit(gate, auth.LimitDailyPredictions, count(predictions))(ok)).Methods("POST")
+    return router
+}
+
+func TestTierGating(t *testing.T) {
+    free := &models.User{SubscriptionTier: auth.TierFree}
+    pro := &models.User{SubscriptionTier: auth.TierPro}
+    enterprise := &models.User{SubscriptionTier: auth.TierEnterprise}
+
+    tests := []struct {
+        name        string
+        user        *models.User
+        path        string
+        portfolios  int
+        predictions int
+        status      int
+    }{
+        {"anonymous request", nil, "/portfolios/1/optimize", 0, 0, http.StatusUnauthorized},
+        {"free creates first portfolio", free, "/portfolios", 0, 0, http.StatusOK},
+        {"free creates second portfolio", free, "/portfolios", 1, 0, http.StatusForbidden},
+        {"free optimizes", free, "/portfolios/1/optimize", 0, 0, http.StatusForbidden},
+        {"free predicts under limit", free, "/ml/predict", 0, 19, http.StatusOK},
+        {"free predicts at limit", free, "/ml/predict", 0, 20, http.StatusForbidden},
+        {"unknown tier held to free", &models.User{SubscriptionTier: "legacy"}, "/portfolios/1/optimize", 0, 0, http.StatusForbidden},
+        {"pro creates second portfolio", pro, "/portfolios", 1, 0, http.StatusOK},
+        {"pro creates eleventh portfolio", pro, "/portfolios", 10, 0, http.StatusForbidden},
+        {"pro optimizes", pro, "/portfolios/1/optimize", 0, 0, http.StatusOK},
+        {"pro predicts at limit", pro, "/ml/predict", 0, 500, http.StatusForbidden},
+        {"enterprise creates many portfolios", enterprise, "/portfolios", 100, 0, http.StatusOK},
+        {"enterprise optimizes", enterprise, "/portfolios/1/optimize", 0, 0, http.StatusOK},
+        {"enterprise predicts without limit", enterprise, "/ml/predict", 0, 100000, http.StatusOK},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest("POST", tt.path, nil)
+            rec := httptest.NewRecorder()
+
+            tierRouter(tt.user, tt.portfolios, tt.predictions).ServeHTTP(rec, req)
+
+            assert.Equal(t, tt.status, rec.Code)
+        })
+    }
+}
+
+func TestTierGating_UpgradeRequiredResponse(t *testing.T) {
+    user := &models.User{SubscriptionTier: auth.TierFree}
+
+    t.Run("Feature", func(t *testing.T) {
+        rec := httptest.NewRecorder()
+        tierRouter(user, 0, 0).ServeHTTP(rec, httptest.NewRequest("POST", "/portfolios/1/optimize", nil))
+
+        var body UpgradeRequiredResponse
+        if !assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body)) {
+            return
+        }
+        assert.Equal(t, auth.UpgradeRequiredCode, body.Code)
+        assert.Equal(t, auth.TierFree, body.Tier)
+        assert.Equal(t, auth.FeatureOptimization, body.Feature)
+        assert.Nil(t, body.Limit)
+    })
+
+    t.Run("Limit", func(t *testing.T) {
+        rec := httptest.NewRecorder()
+        tierRouter(user, 1, 0).ServeHTTP(rec, httptest.NewRequest("POST", "/portfolios", nil))
+
+        var body UpgradeRequiredResponse
+        if !assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body)) {
+            return
+        }
+        assert.Equal(t, auth.UpgradeRequiredCode, body.Code)
+        assert.Equal(t, auth.LimitMaxPortfolios, body.Feature)
+        if assert.NotNil(t, body.Limit) {
+            assert.Equal(t, 1, *body.Limit)
+        }
+    })
+}
@@ -0,0 +1,274 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/mail"
+)
+
+// Model statuses with special handling. Only one version of a model is
+// active at a time; versions replaced by an activation become inactive and
+// can still be pinned or rolled back to.
+const (
+    StatusActive   = "active"
+    StatusInactive = "inactive"
+    StatusArchived = "archived"
+)
+
+// Activation history actions
+const (
+    activationActivate = "activate"
+    activationRollback = "rollback"
+)
+
+var (
+    ErrModelArchived     = errors.New("model version is archived")
+    ErrModelPinned       = errors.New("model version is pinned")
+    ErrNoPreviousVersion = errors.New("no previous version to roll back to")
+)
+
+// AdminNotifier alerts administrators to changes they should know about
+type AdminNotifier interface {
+    NotifyAdmins(ctx context.Context, subject, message string) error
+}
+
+// MailAdminNotifier is an AdminNotifier emailing every admin account
+type MailAdminNotifier struct {
+    db     *sql.DB
+    mailer mail.Mailer
+}
+
+func NewMailAdminNotifier(db *sql.DB, mailer mail.Mailer) *MailAdminNotifier {
+    return &MailAdminNotifier{db: db, mailer: mailer}
+}
+
+// NotifyAdmins queues the notice for each admin. An admin whose email
+// can't be queued doesn't keep the others from getting theirs.
+func (n *MailAdminNotifier) NotifyAdmins(ctx context.Context, subject, message string) error {
+    rows, err := n.db.QueryContext(ctx,
+        "SELECT email, name FROM users WHERE role = $1 AND deleted_at IS NULL ORDER BY email",
+        auth.RoleAdmin,
+    )
+    if err != nil {
+        return fmt.Errorf("failed to list admins: %w", err)
+    }
+    defer rows.Close()
+
+    type admin struct{ email, name string }
+    var admins []admin
+    for rows.Next() {
+        var a admin
+        if err := rows.Scan(&a.email, &a.name); err != nil {
+            return err
+        }
+        admins = append(admins, a)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    var failed int
+    var firstErr error
+    for _, a := range admins {
+        err := n.mailer.Send(ctx, a.email, mail.TemplateAdminNotice, mail.AdminNoticeData{
+            Name:    a.name,
+            Subject: subject,
+            Message: message,
+        })
+        if err != nil {
+            failed++
+            if firstErr == nil {
+                firstErr = err
+            }
+        }
+    }
+    if firstErr != nil {
+        return fmt.Errorf("failed to notify %d of %d admins: %w", failed, len(admins), firstErr)
+    }
+    return nil
+}
+
+// RollbackResult describes a completed rollback
+type RollbackResult struct {
+    Name         string    `json:"name"`
+    FromVersion  string    `json:"from_version"`
+    ToVersion    string    `json:"to_version"`
+    RolledBackBy string    `json:"rolled_back_by"`
+    RolledBackAt time.Time `json:"rolled_back_at"`
+}
+
+// WithNotifier sets where rollback notifications are sent. Without one
+// they are only logged.
+func (m *ModelManager) WithNotifier(notifier AdminNotifier) *ModelManager {
+    m.notifier = notifier
+    return m
+}
+
+// ActivateModel makes version the only active version of the model and
+// records who activated it in the activation history
+func (m *ModelManager) ActivateModel(ctx context.Context, actor, name, version string) error {
+    tx, err := m.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    versions, err := lockModelVersions(ctx, tx, name)
+    if err != nil {
+        return err
+    }
+    status, ok := versions[version]
+    if !ok {
+        return fmt.Errorf("%w: %s@%s", ErrModelNotFound, name, version)
+    }
+    if status == StatusArchived {
+        return fmt.Errorf("%w: %s@%s", ErrModelArchived, name, version)
+    }
+
+    if err := activate(ctx, tx, actor, name, version, activationActivate); err != nil {
+        return err
+    }
+    if err := auth.WriteAudit(ctx, tx, actor, "model.activated", name, version); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// RollbackModel re-activates the version that was active before the
+// current one, skipping versions archived since. The switch, its history
+// entry and the audit log commit together; admins are notified afterwards.
+func (m *ModelManager) RollbackModel(ctx context.Context, actor, name string) (*RollbackResult, error) {
+    tx, err := m.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    versions, err := lockModelVersions(ctx, tx, name)
+    if err != nil {
+        return nil, err
+    }
+
+    var current string
+    for version, status := range versions {
+        if status == StatusActive {
+            current = version
+            break
+        }
+    }
+    if current == "" {
+        return nil, fmt.Errorf("%w: no active version of %s", ErrModelNotFound, name)
+    }
+
+    var previous string
+    query := `
+        SELECT a.version FROM model_activations a
+        JOIN ml_models m ON m.name = a.model_name AND m.version = a.version
+        WHERE a.model_name = $1 AND a.version != $2 AND m.status != 'archived'
+        ORDER BY a.activated_at DESC, a.id DESC
+        LIMIT 1
+    `
+    err = tx.QueryRowContext(ctx, query, name, current).Scan(&previous)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrNoPreviousVersion, name)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read activation history: %w", err)
+    }
+
+    if err := activate(ctx, tx, actor, name, previous, activationRollback); err != nil {
+        return nil, err
+    }
+    if err := auth.WriteAudit(ctx, tx, actor, "model.rolled_back", name,
+        fmt.Sprintf("%s -> %s", current, previous),
+    ); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    result := &RollbackResult{
+        Name:         name,
+        FromVersion:  current,
+        ToVersion:    previous,
+        RolledBackBy: actor,
+        RolledBackAt: time.Now(),
+    }
+    m.notifyAdmins(ctx,
+        fmt.Sprintf("Model %s rolled back", name),
+        fmt.Sprintf("%s rolled %s back from version %s to %s", actor, name, current, previous),
+    )
+    return result, nil
+}
+
+func (m *ModelManager) notifyAdmins(ctx context.Context, subject, message string) {
+    if m.notifier == nil {
+        logger.FromContext(ctx).Infof("%s: %s", subject, message)
+        return
+    }
+    if err := m.notifier.NotifyAdmins(ctx, subject, message); err != nil {
+        logger.FromContext(ctx).Errorf("Failed to notify admins of %q: %v", subject, err)
+    }
+}
+
+// lockModelVersions locks every version of a model for the rest of tx and
+// returns their statuses, so concurrent activations of one model serialize
+func lockModelVersions(ctx context.Context, tx *sql.Tx, name string) (map[string]string, error) {
+    rows, err := tx.QueryContext(ctx, "SELECT version, status FROM ml_models WHERE name = $1 FOR UPDATE", name)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    versions := make(map[string]string)
+    for rows.Next() {
+        var version, status string
+        if err := rows.Scan(&version, &status); err != nil {
+            return nil, err
+        }
+        versions[version] = status
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    if len(versions) == 0 {
+        return nil, fmt.Errorf("%w: %s", ErrModelNotFound, name)
+    }
+    return versions, nil
+}
+
+// activate switches the model's active version within tx and appends to
+// the activation history
+func activate(ctx context.Context, tx *sql.Tx, actor, name, version, action string) error {
+    now := time.Now()
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE ml_models SET status = $1, updated_at = $2
+        WHERE name = $3 AND status = $4 AND version != $5
+    `, StatusInactive, now, name, StatusActive, version); err != nil {
+        return fmt.Errorf("failed to deactivate %s: %w", name, err)
+    }
+
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE ml_models SET status = $1, updated_at = $2
+        WHERE name = $3 AND version = $4
+    `, StatusActive, now, name, version); err != nil {
+        return fmt.Errorf("failed to activate %s@%s: %w", name, version, err)
+    }
+
+    if _, err := tx.ExecContext(ctx, `
+        INSERT INTO model_activations (model_name, version, action, activated_by, activated_at)
+        VALUES ($1, $2, $3, $4, $5)
+    `, name, version, action, actor, now); err != nil {
+        return fmt.Errorf("failed to record activation: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,182 @@
+package ml
+
+import (
+    "context"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/mail"
+)
+
+func TestModelManager_RollbackModel(t *testing.T) {
+    ctx := context.Background()
+
+    t.Run("Re-activates the previous version", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT version, status FROM ml_models WHERE name = (.+) FOR UPDATE").
+            WithArgs("lstm").
+            WillReturnRows(sqlmock.NewRows([]string{"version", "status"}).
+                AddRow("1", StatusInactive).
+                AddRow("2", StatusActive))
+        mock.ExpectQuery("SELECT a.version FROM model_activations").
+            WithArgs("lstm", "2").
+            WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("1"))
+        mock.ExpectExec("UPDATE ml_models SET status").
+            WithArgs(StatusInactive, sqlmock.AnyArg(), "lstm", StatusActive, "1").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE ml_models SET status").
+            WithArgs(StatusActive, sqlmock.AnyArg(), "lstm", "1").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO model_activations").
+            WithArgs("lstm", "1", activationRollback, "admin@example.com", sqlmock.AnyArg()).
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectExec("INSERT INTO audit_logs").
+            WithArgs("admin@example.com", "model.rolled_back", "lstm", "2 -> 1", "").
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectCommit()
+
+        notifier := &recordingNotifier{}
+        manager := NewModelManager(db).WithNotifier(notifier)
+
+        result, err := manager.RollbackModel(ctx, "admin@example.com", "lstm")
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, "2", result.FromVersion)
+        assert.Equal(t, "1", result.ToVersion)
+        assert.Len(t, notifier.subjects, 1)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("No previous version", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT version, status FROM ml_models").
+            WithArgs("lstm").
+            WillReturnRows(sqlmock.NewRows([]string{"version", "status"}).AddRow("1", StatusActive))
+        mock.ExpectQuery("SELECT a.version FROM model_activations").
+            WithArgs("lstm", "1").
+            WillReturnRows(sqlmock.NewRows([]string{"version"}))
+        mock.ExpectRollback()
+
+        _, err = NewModelManager(db).RollbackModel(ctx, "admin@example.com", "lstm")
+        assert.ErrorIs(t, err, ErrNoPreviousVersion)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestModelManager_ArchivePinnedModel(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mock.ExpectBegin()
+    mock.ExpectQuery("SELECT consumer_type, consumer_id FROM model_version_pins").
+        WithArgs("lstm", "1").
+        WillReturnRows(sqlmock.NewRows([]string{"consumer_type", "consumer_id"}).AddRow("strategy", "42"))
+    mock.ExpectRollback()
+
+    err = NewModelManager(db).ArchiveModel(context.Background(), "lstm", "1")
+    assert.ErrorIs(t, err, ErrModelPinned)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestModelManager_PinVersion(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    manager := NewModelManager(db)
+    mock.ExpectExec("INSERT INTO model_version_pins (.+) ON CONFLICT").
+        WithArgs(PinConsumerSchedule, "lstm:BTC", "lstm", "1").
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectExec("UPDATE model_version_pins SET active = FALSE").
+        WithArgs(PinConsumerSchedule, "lstm:BTC", "lstm").
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    assert.NoError(t, manager.PinVersion(context.Background(), PinConsumerSchedule, "lstm:BTC", "lstm", "1"))
+    assert.NoError(t, manager.UnpinVersion(context.Background(), PinConsumerSchedule, "lstm:BTC", "lstm"))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMailAdminNotifier_NotifyAdmins(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mock.ExpectQuery("SELECT email, name FROM users WHERE role = (.+)").
+        WithArgs(auth.RoleAdmin).
+        WillReturnRows(sqlmock.NewRows([]string{"email", "name"}).
+            AddRow("ada@example.com", "Ada").
+            AddRow("grace@example.com", "Grace"))
+
+    mailer := &recordingMailer{}
+    err = NewMailAdminNotifier(db, mailer).NotifyAdmins(context.Background(), "Model lstm rolled back", "lstm 2 -> 1")
+    assert.NoError(t, err)
+    assert.Equal(t, []sentEmail{
+        {"ada@example.com", mail.TemplateAdminNotice, mail.AdminNoticeData{Name: "Ada", Subject: "Model lstm rolled back", Message: "lstm 2 -> 1"}},
+        {"grace@example.com", mail.TemplateAdminNotice, mail.AdminNoticeData{Name: "Grace", Subject: "Model lstm rolled back", Message: "lstm 2 -> 1"}},
+    }, mailer.sent)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_PinnedVersion(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    service := NewService(db, t.TempDir())
+
+    t.Run("Inactive version can be pinned", func(t *testing.T) {
+        mock.ExpectQuery("SELECT status, config FROM ml_models").
+            WithArgs("lstm", "1").
+            WillReturnRows(sqlmock.NewRows([]string{"status", "config"}).AddRow(StatusInactive, []byte("{}")))
+
+        model, err := service.resolveModel(context.Background(), "lstm", "1")
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, StatusInactive, model.status)
+    })
+
+    t.Run("Archived version is rejected", func(t *testing.T) {
+        mock.ExpectQuery("SELECT status, config FROM ml_models").
+            WithArgs("lstm", "0").
+            WillReturnRows(sqlmock.NewRows([]string{"status", "config"}).AddRow(StatusArchived, []byte("{}")))
+
+        err := service.Validate(context.Background(), &PredictionRequest{ModelName: "lstm", Version: "0"})
+        assert.ErrorIs(t, err, ErrModelArchived)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type recordingNotifier struct {
+    subjects []string
+}
+
+func (n *recordingNotifier) NotifyAdmins(ctx context.Context, subject, message string) error {
+    n.subjects = append(n.subjects, subject)
+    return nil
+}
@@ -0,0 +1,68 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "path/filepath"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
+)
+
+// WithArtifacts uploads trained models to store and serves models from
+// cache, so any replica can serve a model trained on another
+func (s *Service) WithArtifacts(store artifacts.Store, cache *artifacts.Cache) *Service {
+    s.artifacts = store
+    s.cache = cache
+    return s
+}
+
+// modelDir returns the local directory of a model version's artifact.
+// Models without a recorded checksum, trained before artifacts were
+// stored, are read from modelPath.
+func (s *Service) modelDir(ctx context.Context, name, version string) (string, error) {
+    local := filepath.Join(s.modelPath, name, version)
+    if s.cache == nil {
+        return local, nil
+    }
+
+    var checksum sql.NullString
+    query := "SELECT artifact_checksum FROM ml_models WHERE name = $1 AND version = $2"
+    if err := s.db.QueryRowContext(ctx, query, name, version).Scan(&checksum); err != nil {
+        return "", fmt.Errorf("failed to get artifact of %s@%s: %w", name, version, err)
+    }
+    if !checksum.Valid || checksum.String == "" {
+        return local, nil
+    }
+
+    dir, err := s.cache.Path(ctx, checksum.String)
+    if err != nil {
+        return "", fmt.Errorf("failed to fetch artifact of %s@%s: %w", name, version, err)
+    }
+    return dir, nil
+}
+
+// uploadArtifact stores the trained model in dir and records its checksum
+func (s *Service) uploadArtifact(ctx context.Context, name, version, dir string) error {
+    if s.artifacts == nil {
+        return nil
+    }
+
+    r, w := io.Pipe()
+    go func() {
+        w.CloseWithError(artifacts.Pack(dir, w))
+    }()
+    checksum, err := s.artifacts.Put(ctx, name, version, r)
+    r.Close()
+    if err != nil {
+        return fmt.Errorf("failed to upload artifact of %s@%s: %w", name, version, err)
+    }
+
+    query := "UPDATE ml_models SET artifact_checksum = $1, updated_at = $2 WHERE name = $3 AND version = $4"
+    if _, err := s.db.ExecContext(ctx, query, checksum, time.Now(), name, version); err != nil {
+        return fmt.Errorf("failed to record artifact of %s@%s: %w", name, version, err)
+    }
+    return nil
+}
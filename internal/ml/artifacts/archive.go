@@ -0,0 +1,113 @@
+package artifacts
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// Pack writes the files under dir as a gzipped tarball. Entries are in
+// lexical order with no timestamps or owners, so the same files always pack
+// to the same bytes and the same checksum.
+func Pack(dir string, w io.Writer) error {
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(dir, path)
+        if err != nil || rel == "." {
+            return err
+        }
+        if !info.Mode().IsRegular() && !info.IsDir() {
+            // Links and devices can't be restored safely
+            return nil
+        }
+
+        header := &tar.Header{
+            Name:    filepath.ToSlash(rel),
+            Mode:    int64(info.Mode().Perm()),
+            ModTime: time.Unix(0, 0),
+            Format:  tar.FormatPAX,
+        }
+        if info.IsDir() {
+            header.Typeflag = tar.TypeDir
+            header.Name += "/"
+            return tw.WriteHeader(header)
+        }
+        header.Typeflag = tar.TypeReg
+        header.Size = info.Size()
+        if err := tw.WriteHeader(header); err != nil {
+            return err
+        }
+
+        f, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        _, err = io.Copy(tw, f)
+        return err
+    })
+    if err != nil {
+        return fmt.Errorf("failed to pack %s: %w", dir, err)
+    }
+
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+// Unpack extracts a tarball written by Pack into dir, refusing entries that
+// would land outside it
+func Unpack(r io.Reader, dir string) error {
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return fmt.Errorf("failed to read artifact: %w", err)
+    }
+    defer gz.Close()
+
+    tr := tar.NewReader(gz)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("failed to read artifact: %w", err)
+        }
+
+        target := filepath.Join(dir, filepath.FromSlash(header.Name))
+        if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+            return fmt.Errorf("artifact entry %q is outside the artifact", header.Name)
+        }
+
+        switch header.Typeflag {
+        case tar.TypeDir:
+            if err := os.MkdirAll(target, 0755); err != nil {
+                return err
+            }
+        case tar.TypeReg:
+            if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+                return err
+            }
+            f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm()|0600)
+            if err != nil {
+                return err
+            }
+            _, err = io.Copy(f, tr)
+            f.Close()
+            if err != nil {
+                return err
+            }
+        }
+    }
+}
@@ -0,0 +1,180 @@
+package artifacts
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Cache keeps extracted artifacts on local disk, one directory per
+// checksum, downloading each from the store on first use
+type Cache struct {
+    store  Store
+    dir    string
+    budget int64
+
+    // mu serializes downloads so concurrent first uses fetch once
+    mu sync.Mutex
+}
+
+// NewCache caches artifacts of store under dir. Cleanup trims the cache to
+// budgetBytes; zero or less means no limit.
+func NewCache(store Store, dir string, budgetBytes int64) *Cache {
+    return &Cache{store: store, dir: dir, budget: budgetBytes}
+}
+
+// Path returns the directory the artifact with checksum is extracted to,
+// downloading and verifying it if it isn't cached
+func (c *Cache) Path(ctx context.Context, checksum string) (string, error) {
+    if !validChecksum(checksum) {
+        return "", fmt.Errorf("invalid artifact checksum %q", checksum)
+    }
+    target := filepath.Join(c.dir, checksum)
+    if touch(target) {
+        return target, nil
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if touch(target) {
+        return target, nil
+    }
+
+    if err := c.download(ctx, checksum, target); err != nil {
+        return "", err
+    }
+    return target, nil
+}
+
+func (c *Cache) download(ctx context.Context, checksum, target string) error {
+    if err := os.MkdirAll(c.dir, 0755); err != nil {
+        return err
+    }
+
+    src, err := c.store.Get(ctx, checksum)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+
+    // Verify the whole artifact before extracting any of it
+    archive, err := os.CreateTemp(c.dir, ".download-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(archive.Name())
+    defer archive.Close()
+
+    hash := sha256.New()
+    if _, err := io.Copy(io.MultiWriter(archive, hash), src); err != nil {
+        return fmt.Errorf("failed to download artifact %s: %w", checksum, err)
+    }
+    if got := hex.EncodeToString(hash.Sum(nil)); got != checksum {
+        return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, checksum, got)
+    }
+    if _, err := archive.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+
+    staging, err := os.MkdirTemp(c.dir, ".extract-*")
+    if err != nil {
+        return err
+    }
+    if err := Unpack(archive, staging); err != nil {
+        os.RemoveAll(staging)
+        return err
+    }
+    if err := os.Rename(staging, target); err != nil {
+        os.RemoveAll(staging)
+        return fmt.Errorf("failed to cache artifact %s: %w", checksum, err)
+    }
+    touch(target)
+    return nil
+}
+
+// touch marks a cached artifact as just used, reporting whether it exists
+func touch(dir string) bool {
+    now := time.Now()
+    return os.Chtimes(dir, now, now) == nil
+}
+
+type cachedArtifact struct {
+    path     string
+    size     int64
+    lastUsed time.Time
+}
+
+// Cleanup removes the least recently used artifacts until the cache fits
+// its budget, returning how many were removed. Leftovers of interrupted
+// downloads are always removed.
+func (c *Cache) Cleanup() (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    entries, err := os.ReadDir(c.dir)
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    var cached []cachedArtifact
+    var total int64
+    for _, entry := range entries {
+        path := filepath.Join(c.dir, entry.Name())
+        if strings.HasPrefix(entry.Name(), ".") {
+            os.RemoveAll(path)
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil || !entry.IsDir() {
+            continue
+        }
+        size, err := dirSize(path)
+        if err != nil {
+            return 0, err
+        }
+        cached = append(cached, cachedArtifact{path: path, size: size, lastUsed: info.ModTime()})
+        total += size
+    }
+    if c.budget <= 0 || total <= c.budget {
+        return 0, nil
+    }
+
+    sort.Slice(cached, func(i, j int) bool { return cached[i].lastUsed.Before(cached[j].lastUsed) })
+    removed := 0
+    for _, artifact := range cached {
+        if total <= c.budget {
+            break
+        }
+        if err := os.RemoveAll(artifact.path); err != nil {
+            return removed, fmt.Errorf("failed to evict %s: %w", artifact.path, err)
+        }
+        total -= artifact.size
+        removed++
+    }
+    return removed, nil
+}
+
+func dirSize(dir string) (int64, error) {
+    var size int64
+    err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.Mode().IsRegular() {
+            size += info.Size()
+        }
+        return nil
+    })
+    return size, err
+}
@@ -0,0 +1,154 @@
+package artifacts
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+// writeModel creates a model directory with a weights file of size bytes
+func writeModel(t *testing.T, size int) string {
+    dir := t.TempDir()
+    assert.NoError(t, os.MkdirAll(filepath.Join(dir, "checkpoints"), 0755))
+    assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"layers": 2}`), 0644))
+    assert.NoError(t, os.WriteFile(filepath.Join(dir, "checkpoints", "weights.bin"), bytes.Repeat([]byte{byte(size)}, size), 0644))
+    return dir
+}
+
+func putModel(t *testing.T, store Store, name, version, dir string) string {
+    var buf bytes.Buffer
+    assert.NoError(t, Pack(dir, &buf))
+    checksum, err := store.Put(context.Background(), name, version, &buf)
+    assert.NoError(t, err)
+    return checksum
+}
+
+func TestLocalStore(t *testing.T) {
+    store, err := NewLocalStore(t.TempDir())
+    if !assert.NoError(t, err) {
+        return
+    }
+    testStore(t, store)
+}
+
+// testStore checks the behaviour every Store must share
+func testStore(t *testing.T, store Store) {
+    ctx := context.Background()
+    dir := writeModel(t, 1024)
+
+    checksum := putModel(t, store, "lstm", "1.0.0", dir)
+    assert.True(t, validChecksum(checksum))
+
+    // Packing is deterministic, so the same model is stored once
+    assert.Equal(t, checksum, putModel(t, store, "lstm", "1.0.1", dir))
+    other := putModel(t, store, "lstm", "2.0.0", writeModel(t, 2048))
+    assert.NotEqual(t, checksum, other)
+
+    exists, err := store.Exists(ctx, checksum)
+    assert.NoError(t, err)
+    assert.True(t, exists)
+    exists, err = store.Exists(ctx, strings.Repeat("0", 64))
+    assert.NoError(t, err)
+    assert.False(t, exists)
+
+    r, err := store.Get(ctx, checksum)
+    if assert.NoError(t, err) {
+        content, _ := io.ReadAll(r)
+        r.Close()
+        sum := sha256.Sum256(content)
+        assert.Equal(t, checksum, hex.EncodeToString(sum[:]))
+    }
+    _, err = store.Get(ctx, strings.Repeat("0", 64))
+    assert.ErrorIs(t, err, ErrNotFound)
+
+    infos, err := store.List(ctx, "lstm", "1.0.0")
+    assert.NoError(t, err)
+    assert.Equal(t, []Info{{Name: "lstm", Version: "1.0.0", Checksum: checksum}}, infos)
+
+    infos, err = store.List(ctx, "lstm", "")
+    assert.NoError(t, err)
+    assert.Len(t, infos, 3)
+
+    _, err = store.Put(ctx, "../lstm", "1.0.0", strings.NewReader("x"))
+    assert.Error(t, err)
+
+    // 1.0.1 shares its content with 1.0.0, so deleting 1.0.0 keeps it
+    assert.NoError(t, store.Delete(ctx, "lstm", "1.0.0"))
+    infos, err = store.List(ctx, "lstm", "1.0.0")
+    assert.NoError(t, err)
+    assert.Empty(t, infos)
+    exists, err = store.Exists(ctx, checksum)
+    assert.NoError(t, err)
+    assert.True(t, exists)
+
+    assert.NoError(t, store.Delete(ctx, "lstm", "2.0.0"))
+    exists, err = store.Exists(ctx, other)
+    assert.NoError(t, err)
+    assert.False(t, exists)
+    assert.NoError(t, store.Delete(ctx, "lstm", "3.0.0"))
+}
+
+func TestCache(t *testing.T) {
+    store, err := NewLocalStore(t.TempDir())
+    if !assert.NoError(t, err) {
+        return
+    }
+    ctx := context.Background()
+    small := putModel(t, store, "lstm", "1.0.0", writeModel(t, 1000))
+    large := putModel(t, store, "lstm", "2.0.0", writeModel(t, 3000))
+
+    cache := NewCache(store, t.TempDir(), 3500)
+
+    dir, err := cache.Path(ctx, small)
+    if !assert.NoError(t, err) {
+        return
+    }
+    weights, err := os.ReadFile(filepath.Join(dir, "checkpoints", "weights.bin"))
+    assert.NoError(t, err)
+    assert.Len(t, weights, 1000)
+
+    // Cached artifacts aren't downloaded again
+    again, err := cache.Path(ctx, small)
+    assert.NoError(t, err)
+    assert.Equal(t, dir, again)
+
+    t.Run("Cleanup evicts the least recently used", func(t *testing.T) {
+        old := time.Now().Add(-time.Hour)
+        assert.NoError(t, os.Chtimes(dir, old, old))
+        _, err := cache.Path(ctx, large)
+        assert.NoError(t, err)
+
+        removed, err := cache.Cleanup()
+        assert.NoError(t, err)
+        assert.Equal(t, 1, removed)
+        assert.NoDirExists(t, dir)
+        assert.DirExists(t, filepath.Join(cache.dir, large))
+
+        // An evicted artifact is downloaded again on next use
+        _, err = cache.Path(ctx, small)
+        assert.NoError(t, err)
+        assert.DirExists(t, dir)
+    })
+
+    t.Run("Corrupt download", func(t *testing.T) {
+        blob := store.path(blobKey(small))
+        assert.NoError(t, os.WriteFile(blob, []byte("tampered"), 0644))
+
+        _, err := NewCache(store, t.TempDir(), 0).Path(ctx, small)
+        assert.ErrorIs(t, err, ErrChecksumMismatch)
+    })
+
+    t.Run("Unknown artifact", func(t *testing.T) {
+        _, err := cache.Path(ctx, strings.Repeat("a", 64))
+        assert.ErrorIs(t, err, ErrNotFound)
+    })
+}
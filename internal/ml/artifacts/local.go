@@ -0,0 +1,148 @@
+package artifacts
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// LocalStore keeps artifacts in a directory on local disk
+type LocalStore struct {
+    root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+    if root == "" {
+        return nil, fmt.Errorf("local artifact store needs a path")
+    }
+    if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create artifact store: %w", err)
+    }
+    return &LocalStore{root: root}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, name, version string, r io.Reader) (string, error) {
+    if err := validateRef(name, version); err != nil {
+        return "", err
+    }
+
+    // Hash while writing to a temporary file, then move it to its address
+    tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "upload-*")
+    if err != nil {
+        return "", err
+    }
+    defer os.Remove(tmp.Name())
+
+    hash := sha256.New()
+    if _, err := io.Copy(io.MultiWriter(tmp, hash), r); err != nil {
+        tmp.Close()
+        return "", fmt.Errorf("failed to write artifact: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return "", err
+    }
+    checksum := hex.EncodeToString(hash.Sum(nil))
+
+    blob := s.path(blobKey(checksum))
+    if _, err := os.Stat(blob); os.IsNotExist(err) {
+        if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+            return "", err
+        }
+        if err := os.Rename(tmp.Name(), blob); err != nil {
+            return "", fmt.Errorf("failed to store artifact: %w", err)
+        }
+    }
+
+    ref := s.path(refKey(name, version, checksum))
+    if err := os.MkdirAll(filepath.Dir(ref), 0755); err != nil {
+        return "", err
+    }
+    if err := os.WriteFile(ref, nil, 0644); err != nil {
+        return "", fmt.Errorf("failed to record artifact of %s@%s: %w", name, version, err)
+    }
+    return checksum, nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, checksum string) (io.ReadCloser, error) {
+    if !validChecksum(checksum) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, checksum)
+    }
+    f, err := os.Open(s.path(blobKey(checksum)))
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, checksum)
+    }
+    return f, err
+}
+
+func (s *LocalStore) Exists(ctx context.Context, checksum string) (bool, error) {
+    if !validChecksum(checksum) {
+        return false, nil
+    }
+    _, err := os.Stat(s.path(blobKey(checksum)))
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func (s *LocalStore) List(ctx context.Context, name, version string) ([]Info, error) {
+    pattern := filepath.Join(s.root, "refs", name, version, "*")
+    if version == "" {
+        if err := validateRef(name, "*"); err != nil {
+            return nil, err
+        }
+        pattern = filepath.Join(s.root, "refs", name, "*", "*")
+    } else if err := validateRef(name, version); err != nil {
+        return nil, err
+    }
+
+    matches, err := filepath.Glob(pattern)
+    if err != nil {
+        return nil, err
+    }
+
+    refs := filepath.Join(s.root, "refs")
+    var infos []Info
+    for _, match := range matches {
+        rel, err := filepath.Rel(refs, match)
+        if err != nil {
+            continue
+        }
+        if info, ok := parseRefKey(filepath.ToSlash(rel)); ok {
+            infos = append(infos, info)
+        }
+    }
+    return infos, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, name, version string) error {
+    infos, err := s.List(ctx, name, version)
+    if err != nil {
+        return err
+    }
+    for _, info := range infos {
+        if err := os.Remove(s.path(refKey(name, version, info.Checksum))); err != nil && !os.IsNotExist(err) {
+            return fmt.Errorf("failed to delete artifact of %s@%s: %w", name, version, err)
+        }
+        // Other versions, of any model, may share the content
+        shared, err := filepath.Glob(filepath.Join(s.root, "refs", "*", "*", info.Checksum))
+        if err != nil {
+            return err
+        }
+        if len(shared) == 0 {
+            if err := os.Remove(s.path(blobKey(info.Checksum))); err != nil && !os.IsNotExist(err) {
+                return fmt.Errorf("failed to delete artifact %s: %w", info.Checksum, err)
+            }
+        }
+    }
+    os.Remove(filepath.Join(s.root, "refs", name, version))
+    return nil
+}
+
+func (s *LocalStore) path(key string) string {
+    return filepath.Join(s.root, filepath.FromSlash(key))
+}
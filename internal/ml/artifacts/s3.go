@@ -0,0 +1,171 @@
+package artifacts
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+
+    "github.com/minio/minio-go/v7"
+    "github.com/minio/minio-go/v7/pkg/credentials"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// S3Store keeps artifacts in a bucket of any S3-compatible object store
+type S3Store struct {
+    client *minio.Client
+    bucket string
+}
+
+func NewS3Store(cfg config.ArtifactStoreConfig) (*S3Store, error) {
+    if cfg.Endpoint == "" || cfg.Bucket == "" {
+        return nil, fmt.Errorf("S3 artifact store needs an endpoint and bucket")
+    }
+    client, err := minio.New(cfg.Endpoint, &minio.Options{
+        Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+        Secure: cfg.UseSSL,
+        Region: cfg.Region,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to create S3 client: %w", err)
+    }
+    return &S3Store{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, name, version string, r io.Reader) (string, error) {
+    if err := validateRef(name, version); err != nil {
+        return "", err
+    }
+
+    // The key depends on the checksum, so spool the artifact to disk first
+    tmp, err := os.CreateTemp("", "artifact-upload-*")
+    if err != nil {
+        return "", err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+
+    hash := sha256.New()
+    size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+    if err != nil {
+        return "", fmt.Errorf("failed to spool artifact: %w", err)
+    }
+    checksum := hex.EncodeToString(hash.Sum(nil))
+
+    exists, err := s.Exists(ctx, checksum)
+    if err != nil {
+        return "", err
+    }
+    if !exists {
+        if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+            return "", err
+        }
+        _, err := s.client.PutObject(ctx, s.bucket, blobKey(checksum), tmp, size, minio.PutObjectOptions{
+            ContentType: "application/gzip",
+        })
+        if err != nil {
+            return "", fmt.Errorf("failed to upload artifact: %w", err)
+        }
+    }
+
+    _, err = s.client.PutObject(ctx, s.bucket, refKey(name, version, checksum), bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+    if err != nil {
+        return "", fmt.Errorf("failed to record artifact of %s@%s: %w", name, version, err)
+    }
+    return checksum, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, checksum string) (io.ReadCloser, error) {
+    if !validChecksum(checksum) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, checksum)
+    }
+    // GetObject doesn't fail until the first read, so check it exists first
+    exists, err := s.Exists(ctx, checksum)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, checksum)
+    }
+    return s.client.GetObject(ctx, s.bucket, blobKey(checksum), minio.GetObjectOptions{})
+}
+
+func (s *S3Store) Exists(ctx context.Context, checksum string) (bool, error) {
+    if !validChecksum(checksum) {
+        return false, nil
+    }
+    _, err := s.client.StatObject(ctx, s.bucket, blobKey(checksum), minio.StatObjectOptions{})
+    if err == nil {
+        return true, nil
+    }
+    if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+        return false, nil
+    }
+    return false, fmt.Errorf("failed to stat artifact %s: %w", checksum, err)
+}
+
+func (s *S3Store) List(ctx context.Context, name, version string) ([]Info, error) {
+    prefix := "refs/" + name + "/"
+    if version != "" {
+        if err := validateRef(name, version); err != nil {
+            return nil, err
+        }
+        prefix += version + "/"
+    } else if err := validateRef(name, "*"); err != nil {
+        return nil, err
+    }
+
+    var infos []Info
+    for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+        if object.Err != nil {
+            return nil, fmt.Errorf("failed to list artifacts of %s: %w", name, object.Err)
+        }
+        if info, ok := parseRefKey(strings.TrimPrefix(object.Key, "refs/")); ok {
+            infos = append(infos, info)
+        }
+    }
+    return infos, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name, version string) error {
+    infos, err := s.List(ctx, name, version)
+    if err != nil {
+        return err
+    }
+    for _, info := range infos {
+        if err := s.client.RemoveObject(ctx, s.bucket, refKey(name, version, info.Checksum), minio.RemoveObjectOptions{}); err != nil {
+            return fmt.Errorf("failed to delete artifact of %s@%s: %w", name, version, err)
+        }
+        shared, err := s.referenced(ctx, info.Checksum)
+        if err != nil {
+            return err
+        }
+        if !shared {
+            if err := s.client.RemoveObject(ctx, s.bucket, blobKey(info.Checksum), minio.RemoveObjectOptions{}); err != nil {
+                return fmt.Errorf("failed to delete artifact %s: %w", info.Checksum, err)
+            }
+        }
+    }
+    return nil
+}
+
+// referenced reports whether any model version still references checksum.
+// References aren't indexed by checksum, so this lists them all.
+func (s *S3Store) referenced(ctx context.Context, checksum string) (bool, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: "refs/", Recursive: true}) {
+        if object.Err != nil {
+            return false, fmt.Errorf("failed to list artifact references: %w", object.Err)
+        }
+        if strings.HasSuffix(object.Key, "/"+checksum) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
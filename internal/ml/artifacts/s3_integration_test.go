@@ -0,0 +1,63 @@
+//go:build integration
+
+package artifacts
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/minio/minio-go/v7"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+// Runs against a minio container, e.g.
+//   docker run -p 9000:9000 minio/minio server /data
+//   go test -tags integration ./internal/ml/artifacts/
+func TestS3Store(t *testing.T) {
+    cfg := config.ArtifactStoreConfig{
+        Backend:   "s3",
+        Endpoint:  envOr("MINIO_ENDPOINT", "localhost:9000"),
+        AccessKey: envOr("MINIO_ACCESS_KEY", "minioadmin"),
+        SecretKey: envOr("MINIO_SECRET_KEY", "minioadmin"),
+        Bucket:    fmt.Sprintf("artifacts-test-%d", time.Now().UnixNano()),
+    }
+
+    store, err := NewS3Store(cfg)
+    if err != nil {
+        t.Fatalf("Failed to create S3 store: %v", err)
+    }
+    ctx := context.Background()
+    if err := store.client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{}); err != nil {
+        t.Fatalf("Failed to create bucket: %v", err)
+    }
+    t.Cleanup(func() {
+        for object := range store.client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Recursive: true}) {
+            store.client.RemoveObject(ctx, cfg.Bucket, object.Key, minio.RemoveObjectOptions{})
+        }
+        store.client.RemoveBucket(ctx, cfg.Bucket)
+    })
+
+    testStore(t, store)
+
+    t.Run("Cache", func(t *testing.T) {
+        checksum := putModel(t, store, "lstm", "3.0.0", writeModel(t, 512))
+        dir, err := NewCache(store, t.TempDir(), 0).Path(ctx, checksum)
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.FileExists(t, filepath.Join(dir, "checkpoints", "weights.bin"))
+    })
+}
+
+func envOr(key, fallback string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return fallback
+}
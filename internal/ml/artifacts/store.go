@@ -0,0 +1,96 @@
+package artifacts
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "path"
+    "strings"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/config"
+)
+
+var (
+    // ErrNotFound is returned for an artifact missing from a store
+    ErrNotFound = errors.New("artifact not found")
+    // ErrChecksumMismatch is returned when an artifact's content doesn't
+    // hash to the checksum it is stored under
+    ErrChecksumMismatch = errors.New("artifact checksum mismatch")
+)
+
+// Info identifies the artifact stored for a model version
+type Info struct {
+    Name     string `json:"name"`
+    Version  string `json:"version"`
+    Checksum string `json:"checksum"`
+}
+
+// Store keeps model artifacts content-addressed by their hex SHA-256
+// checksum, with a reference from each model version to its artifacts
+type Store interface {
+    // Put stores an artifact of a model version and returns its checksum.
+    // Content already stored is not written again.
+    Put(ctx context.Context, name, version string, r io.Reader) (string, error)
+    Get(ctx context.Context, checksum string) (io.ReadCloser, error)
+    Exists(ctx context.Context, checksum string) (bool, error)
+    // List returns the artifacts of a model version, or of every version of
+    // the model when version is empty
+    List(ctx context.Context, name, version string) ([]Info, error)
+    // Delete removes the references of a model version, and the content
+    // no other version references. Deleting a missing version is a no-op.
+    Delete(ctx context.Context, name, version string) error
+}
+
+// NewStore opens the store cfg selects
+func NewStore(cfg config.ArtifactStoreConfig) (Store, error) {
+    switch strings.ToLower(cfg.Backend) {
+    case "", "local":
+        return NewLocalStore(cfg.Path)
+    case "s3":
+        return NewS3Store(cfg)
+    default:
+        return nil, fmt.Errorf("unknown artifact store backend %q", cfg.Backend)
+    }
+}
+
+// Blobs live under blobs/sha256/<checksum> and references under
+// refs/<name>/<version>/<checksum>, in every store
+func blobKey(checksum string) string {
+    return path.Join("blobs", "sha256", checksum)
+}
+
+func refKey(name, version, checksum string) string {
+    return path.Join("refs", name, version, checksum)
+}
+
+// parseRefKey splits a key relative to refs/ into its Info
+func parseRefKey(key string) (Info, bool) {
+    parts := strings.Split(key, "/")
+    if len(parts) != 3 || !validChecksum(parts[2]) {
+        return Info{}, false
+    }
+    return Info{Name: parts[0], Version: parts[1], Checksum: parts[2]}, true
+}
+
+// validateRef rejects names and versions that aren't a single path segment
+func validateRef(name, version string) error {
+    for _, segment := range []string{name, version} {
+        if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+            return fmt.Errorf("invalid model reference %q@%q", name, version)
+        }
+    }
+    return nil
+}
+
+func validChecksum(checksum string) bool {
+    if len(checksum) != 64 {
+        return false
+    }
+    for _, c := range checksum {
+        if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,117 @@
+package ml
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultScaleInterval = 10 * time.Second
+
+// AutoScaler grows and shrinks the pool of prediction workers with the
+// depth of a PredictionQueue
+type AutoScaler struct {
+    interval    time.Duration
+    workerPool  chan struct{}
+    workers     int
+    workerGauge prometheus.Gauge
+    mu          sync.Mutex
+    wg          sync.WaitGroup
+}
+
+// NewAutoScaler creates a scaler whose ml_worker_count gauge is registered
+// with reg when reg is non-nil
+func NewAutoScaler(reg prometheus.Registerer) *AutoScaler {
+    a := &AutoScaler{
+        interval: defaultScaleInterval,
+        workerGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+            Name: "ml_worker_count",
+            Help: "Number of running prediction workers",
+        }),
+    }
+    if reg != nil {
+        reg.MustRegister(a.workerGauge)
+    }
+    return a
+}
+
+// Workers returns the current worker count
+func (a *AutoScaler) Workers() int {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.workers
+}
+
+// Run starts minWorkers workers and rescales every interval until ctx is
+// done. It returns once all workers have stopped.
+func (a *AutoScaler) Run(ctx context.Context, queue *PredictionQueue, minWorkers, maxWorkers int, targetQueueDepth int) {
+    // Buffered so a shutdown signal never blocks on a busy worker
+    a.workerPool = make(chan struct{}, maxWorkers)
+    for i := 0; i < minWorkers; i++ {
+        a.spawn(ctx, queue)
+    }
+
+    ticker := time.NewTicker(a.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            a.wg.Wait()
+            return
+        case <-ticker.C:
+            a.scale(ctx, queue, minWorkers, maxWorkers, targetQueueDepth)
+        }
+    }
+}
+
+// scale adds or removes at most one worker per call, so a brief spike
+// can't swing the pool from one extreme to the other
+func (a *AutoScaler) scale(ctx context.Context, queue *PredictionQueue, minWorkers, maxWorkers, targetQueueDepth int) {
+    depth := queue.Depth()
+    workers := a.Workers()
+
+    switch {
+    case depth > targetQueueDepth*2 && workers < maxWorkers:
+        a.spawn(ctx, queue)
+    case depth < targetQueueDepth/2 && workers > minWorkers:
+        a.mu.Lock()
+        a.workers--
+        a.workerGauge.Set(float64(a.workers))
+        a.mu.Unlock()
+        queue.setWorkers(a.Workers())
+        a.workerPool <- struct{}{}
+    }
+}
+
+func (a *AutoScaler) spawn(ctx context.Context, queue *PredictionQueue) {
+    a.mu.Lock()
+    a.workers++
+    a.workerGauge.Set(float64(a.workers))
+    a.mu.Unlock()
+    queue.setWorkers(a.Workers())
+
+    a.wg.Add(1)
+    go func() {
+        defer a.wg.Done()
+        a.work(ctx, queue)
+    }()
+}
+
+// work processes jobs until ctx is done or the worker is told to stop
+func (a *AutoScaler) work(ctx context.Context, queue *PredictionQueue) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-a.workerPool:
+            return
+        case <-queue.ready:
+            if job := queue.next(); job != nil {
+                queue.process(job)
+            }
+        }
+    }
+}
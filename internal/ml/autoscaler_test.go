@@ -0,0 +1,78 @@
+package ml
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestAutoScaler_ScalesWithQueueDepth(t *testing.T) {
+    const (
+        minWorkers  = 1
+        maxWorkers  = 5
+        targetDepth = 5
+        interval    = 10 * time.Millisecond
+    )
+
+    // Workers block on every job, so the queue stays deep until released
+    release := make(chan struct{})
+    queue := newPredictionQueue(func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+        <-release
+        return &PredictionResponse{Symbol: req.Symbol}, nil
+    }, QueueConfig{Capacity: 100})
+    for i := 0; i < 50; i++ {
+        assert.NoError(t, queue.enqueue(&predictionJob{
+            ctx:    context.Background(),
+            req:    &PredictionRequest{Symbol: "BTC"},
+            result: make(chan predictionResult, 1),
+        }))
+    }
+
+    scaler := NewAutoScaler(prometheus.NewRegistry())
+    scaler.interval = interval
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        scaler.Run(ctx, queue, minWorkers, maxWorkers, targetDepth)
+        close(done)
+    }()
+
+    // One worker is added per cycle, so max is reached within
+    // maxWorkers-minWorkers cycles; allow generous slack for scheduling
+    cycles := maxWorkers - minWorkers
+    assert.Eventually(t, func() bool {
+        return scaler.Workers() == maxWorkers
+    }, time.Duration(cycles*20)*interval, interval/2)
+
+    // Further high-depth cycles must not exceed the cap
+    time.Sleep(5 * interval)
+    assert.Equal(t, maxWorkers, scaler.Workers())
+    assert.Equal(t, float64(maxWorkers), testutil.ToFloat64(scaler.workerGauge))
+
+    // Once the queue drains the pool shrinks back to the minimum
+    close(release)
+    assert.Eventually(t, func() bool {
+        return scaler.Workers() == minWorkers
+    }, time.Duration(cycles*20)*interval, interval/2)
+    assert.Equal(t, 0, queue.Depth())
+
+    cancel()
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+        t.Fatal("Run did not return after cancel")
+    }
+}
+
+func TestPredictionQueue_SubmitWhenFull(t *testing.T) {
+    queue := newPredictionQueue(nil, QueueConfig{Capacity: 1})
+    assert.NoError(t, queue.enqueue(&predictionJob{}))
+
+    _, err := queue.Submit(context.Background(), &PredictionRequest{Symbol: "BTC"})
+    assert.ErrorIs(t, err, ErrQueueFull)
+}
@@ -0,0 +1,154 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+    // DegradationThreshold is the default rise in live MAE over the trained
+    // baseline that triggers a retrain
+    DegradationThreshold = 0.15
+    // degradationWindow is how far back prediction outcomes are scored
+    degradationWindow = 7 * 24 * time.Hour
+    // minDegradationSamples keeps a handful of outliers from triggering
+    // a retrain
+    minDegradationSamples = 20
+    // baselineMetricKey is the ml_models.metrics key holding the MAE a
+    // version was evaluated at
+    baselineMetricKey = "mae"
+)
+
+var ErrNoBaseline = errors.New("model has no baseline MAE")
+
+// DailyError is the absolute error of one day's prediction outcomes
+type DailyError struct {
+    Day     time.Time
+    MAE     float64
+    Samples int
+}
+
+// DegradationDetector compares a model's recent prediction error with the
+// error it was evaluated at when trained
+type DegradationDetector struct {
+    manager   *ModelManager
+    threshold float64
+    retrains  *prometheus.CounterVec
+}
+
+// NewDegradationDetector creates a detector whose retrain counter is
+// registered with reg when reg is non-nil
+func NewDegradationDetector(manager *ModelManager, reg prometheus.Registerer) *DegradationDetector {
+    d := &DegradationDetector{
+        manager:   manager,
+        threshold: DegradationThreshold,
+        retrains: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "model_degradation_retrains_total",
+            Help: "Retrains started because live error rose above the model's baseline",
+        }, []string{"model"}),
+    }
+    if reg != nil {
+        reg.MustRegister(d.retrains)
+    }
+    return d
+}
+
+// WithThreshold sets the relative MAE increase that triggers a retrain
+func (d *DegradationDetector) WithThreshold(threshold float64) *DegradationDetector {
+    d.threshold = threshold
+    return d
+}
+
+// Check reports whether the active version of modelName should be
+// retrained, along with how far its MAE over the last 7 days has risen
+// above its baseline, e.g. 0.2 for 20% worse. Models without enough recent
+// outcomes are never flagged.
+func (d *DegradationDetector) Check(ctx context.Context, modelName string) (bool, float64, error) {
+    model, err := d.manager.GetActiveModel(ctx, modelName)
+    if err != nil {
+        return false, 0, err
+    }
+    baseline, err := baselineMAE(model.Metrics)
+    if err != nil {
+        return false, 0, fmt.Errorf("%s@%s: %w", model.Name, model.Version, err)
+    }
+
+    daily, err := d.dailyErrors(ctx, modelName, time.Now().Add(-degradationWindow))
+    if err != nil {
+        return false, 0, err
+    }
+
+    shouldRetrain, increase := degraded(baseline, daily, d.threshold)
+    return shouldRetrain, increase, nil
+}
+
+// RecordRetrain counts a retrain started because Check flagged modelName
+func (d *DegradationDetector) RecordRetrain(modelName string) {
+    d.retrains.WithLabelValues(modelName).Inc()
+}
+
+func (d *DegradationDetector) dailyErrors(ctx context.Context, modelName string, since time.Time) ([]DailyError, error) {
+    query := `
+        SELECT date_trunc('day', observed_at) AS day,
+            AVG(ABS(predicted_value - actual_value)),
+            COUNT(*)
+        FROM prediction_outcomes
+        WHERE model_name = $1 AND observed_at >= $2
+            AND predicted_value IS NOT NULL AND actual_value IS NOT NULL
+        GROUP BY day
+        ORDER BY day
+    `
+    rows, err := d.manager.db.QueryContext(ctx, query, modelName, since)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query prediction outcomes: %w", err)
+    }
+    defer rows.Close()
+
+    var daily []DailyError
+    for rows.Next() {
+        var e DailyError
+        if err := rows.Scan(&e.Day, &e.MAE, &e.Samples); err != nil {
+            return nil, err
+        }
+        daily = append(daily, e)
+    }
+    return daily, rows.Err()
+}
+
+// degraded weights each day's MAE by its sample count to get the MAE over
+// the whole window, and compares it with baseline
+func degraded(baseline float64, daily []DailyError, threshold float64) (bool, float64) {
+    var total float64
+    var samples int
+    for _, e := range daily {
+        total += e.MAE * float64(e.Samples)
+        samples += e.Samples
+    }
+    if samples < minDegradationSamples || baseline <= 0 {
+        return false, 0
+    }
+
+    increase := total/float64(samples)/baseline - 1
+    return increase > threshold, increase
+}
+
+func baselineMAE(metrics json.RawMessage) (float64, error) {
+    if len(metrics) == 0 {
+        return 0, ErrNoBaseline
+    }
+
+    var values map[string]interface{}
+    if err := json.Unmarshal(metrics, &values); err != nil {
+        return 0, fmt.Errorf("invalid model metrics: %w", err)
+    }
+    mae, ok := values[baselineMetricKey].(float64)
+    if !ok || mae <= 0 {
+        return 0, ErrNoBaseline
+    }
+    return mae, nil
+}
@@ -0,0 +1,97 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+// maeSeries returns one DailyError per MAE, each from samples outcomes
+func maeSeries(samples int, maes ...float64) []DailyError {
+    start := time.Now().AddDate(0, 0, -len(maes))
+    series := make([]DailyError, len(maes))
+    for i, mae := range maes {
+        series[i] = DailyError{Day: start.AddDate(0, 0, i), MAE: mae, Samples: samples}
+    }
+    return series
+}
+
+func TestDegraded(t *testing.T) {
+    tests := []struct {
+        name     string
+        daily    []DailyError
+        retrain  bool
+        increase float64
+    }{
+        {"Stable error", maeSeries(10, 1.0, 1.05, 0.95, 1.0, 1.02, 0.98, 1.0), false, 0},
+        {"Just under threshold", maeSeries(10, 1.1, 1.1, 1.2, 1.2, 1.1, 1.1, 1.2), false, 0.142857},
+        {"Gradual drift past threshold", maeSeries(10, 1.0, 1.1, 1.2, 1.3, 1.4, 1.5, 1.6), true, 0.3},
+        {"Improved model", maeSeries(10, 0.8, 0.7, 0.75, 0.8, 0.7, 0.7, 0.75), false, -0.257143},
+        {"Too few samples", maeSeries(2, 3.0, 3.0, 3.0), false, 0},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            retrain, increase := degraded(1.0, tt.daily, DegradationThreshold)
+            assert.Equal(t, tt.retrain, retrain)
+            assert.InDelta(t, tt.increase, increase, 1e-6)
+        })
+    }
+
+    t.Run("Days weighted by sample count", func(t *testing.T) {
+        // One bad day of 5 outcomes against 45 good ones stays under 15%
+        daily := append(maeSeries(15, 1.0, 1.0, 1.0), maeSeries(5, 2.0)...)
+        retrain, increase := degraded(1.0, daily, DegradationThreshold)
+        assert.False(t, retrain)
+        assert.InDelta(t, 0.1, increase, 1e-9)
+    })
+}
+
+func TestDegradationDetector_Check(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    now := time.Now()
+    metrics, _ := json.Marshal(map[string]float64{"mae": 2.0, "rmse": 2.5})
+
+    mock.ExpectQuery("SELECT version FROM ml_models").
+        WithArgs("lstm").
+        WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("3"))
+    mock.ExpectQuery("SELECT (.+) FROM ml_models").
+        WithArgs("lstm", "3").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "type", "config", "status", "metrics", "created_at", "updated_at"}).
+            AddRow(1, "lstm", "3", "lstm", []byte("{}"), StatusActive, metrics, now, now))
+    mock.ExpectQuery("FROM prediction_outcomes").
+        WithArgs("lstm", sqlmock.AnyArg()).
+        WillReturnRows(sqlmock.NewRows([]string{"day", "avg", "count"}).
+            AddRow(now.AddDate(0, 0, -1), 2.6, 12).
+            AddRow(now, 2.4, 12))
+
+    detector := NewDegradationDetector(NewModelManager(db), nil)
+    retrain, increase, err := detector.Check(context.Background(), "lstm")
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.True(t, retrain)
+    assert.InDelta(t, 0.25, increase, 1e-9)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBaselineMAE(t *testing.T) {
+    mae, err := baselineMAE(json.RawMessage(`{"mae": 0.42, "r2": 0.8}`))
+    assert.NoError(t, err)
+    assert.Equal(t, 0.42, mae)
+
+    _, err = baselineMAE(nil)
+    assert.ErrorIs(t, err, ErrNoBaseline)
+
+    _, err = baselineMAE(json.RawMessage(`{"accuracy": 0.6}`))
+    assert.ErrorIs(t, err, ErrNoBaseline)
+}
@@ -0,0 +1,260 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// Model config keys read by the ensemble. A model without asset_classes
+// applies to every asset class; one without ensemble_weight weighs 1.
+const (
+    ensembleWeightConfigKey = "ensemble_weight"
+    assetClassesConfigKey   = "asset_classes"
+)
+
+var ErrEnsembleUnavailable = errors.New("no ensemble member returned a prediction")
+
+// EnsembleMember is one model's contribution to an ensemble prediction.
+// Weight is renormalized over the members that succeeded, so a missing
+// member has weight 0 and Error says why it is missing.
+type EnsembleMember struct {
+    ModelID    int64               `json:"id"`
+    Name       string              `json:"name"`
+    Version    string              `json:"version"`
+    Weight     float64             `json:"weight"`
+    Prediction *PredictionResponse `json:"prediction,omitempty"`
+    Missing    bool                `json:"missing,omitempty"`
+    Error      string              `json:"error,omitempty"`
+}
+
+// EnsemblePrediction is the weighted combination of every active model
+// applicable to a symbol, with the members it was built from. Degraded is
+// set when any member failed.
+type EnsemblePrediction struct {
+    Symbol     string              `json:"symbol"`
+    AssetClass string              `json:"asset_class,omitempty"`
+    Combined   *PredictionResponse `json:"combined"`
+    Components []EnsembleMember    `json:"components"`
+    Degraded   bool                `json:"degraded"`
+}
+
+// Ensemble fans a symbol out to all active models and combines their
+// predictions the way EnsembleModel does, as a weighted average
+type Ensemble struct {
+    db       *sql.DB
+    manager  *ModelManager
+    features FeatureSource
+    predict  func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error)
+    outcomes *PredictionOutcomes
+}
+
+// NewEnsemble creates an ensemble that builds member inputs from features
+// and runs them with predict, normally PredictionQueue.Submit so members go
+// through the worker pool
+func NewEnsemble(db *sql.DB, manager *ModelManager, features FeatureSource,
+    predict func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error)) *Ensemble {
+    return &Ensemble{
+        db:       db,
+        manager:  manager,
+        features: features,
+        predict:  predict,
+    }
+}
+
+// WithOutcomes records each member's prediction in outcomes, to be scored
+// once it settles
+func (e *Ensemble) WithOutcomes(outcomes *PredictionOutcomes) *Ensemble {
+    e.outcomes = outcomes
+    return e
+}
+
+// Predict runs every active model applicable to symbol's asset class
+// concurrently. Members that fail are reported as missing and the rest are
+// reweighted; it only fails when no member succeeds.
+func (e *Ensemble) Predict(ctx context.Context, symbol string) (*EnsemblePrediction, error) {
+    assetClass, err := e.assetClass(ctx, symbol)
+    if err != nil {
+        return nil, err
+    }
+
+    active, err := e.manager.ListModels(ctx, StatusActive)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list active models: %w", err)
+    }
+
+    var members []ModelInfo
+    var weights []float64
+    for _, info := range active {
+        settings, err := ensembleSettingsFromConfig(info.Config)
+        if err != nil {
+            return nil, fmt.Errorf("model %s@%s: %w", info.Name, info.Version, err)
+        }
+        if settings.appliesTo(assetClass) {
+            members = append(members, info)
+            weights = append(weights, settings.weight())
+        }
+    }
+    if len(members) == 0 {
+        return nil, fmt.Errorf("%w: no active model for %s", ErrModelNotFound, symbol)
+    }
+
+    components := make([]EnsembleMember, len(members))
+    var wg sync.WaitGroup
+    for i := range members {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            info := members[i]
+            components[i] = EnsembleMember{
+                ModelID: info.ID,
+                Name:    info.Name,
+                Version: info.Version,
+                Weight:  weights[i],
+            }
+            pred, err := e.predictMember(ctx, symbol, &info)
+            if err != nil {
+                components[i].Missing = true
+                components[i].Error = err.Error()
+                return
+            }
+            components[i].Prediction = pred
+        }(i)
+    }
+    wg.Wait()
+
+    combined, err := combineEnsemble(symbol, components)
+    if err != nil {
+        return nil, err
+    }
+
+    result := &EnsemblePrediction{
+        Symbol:     symbol,
+        AssetClass: assetClass,
+        Combined:   combined,
+        Components: components,
+    }
+    for _, c := range components {
+        if c.Missing {
+            result.Degraded = true
+            break
+        }
+    }
+
+    // An outcome that isn't recorded only goes unscored
+    if e.outcomes != nil {
+        if err := e.outcomes.Record(ctx, result); err != nil {
+            logger.FromContext(ctx).Errorf("Failed to record prediction outcomes: %v", err)
+        }
+    }
+    return result, nil
+}
+
+func (e *Ensemble) predictMember(ctx context.Context, symbol string, info *ModelInfo) (*PredictionResponse, error) {
+    var features []float64
+    if info.Schema != nil {
+        var err error
+        if features, err = e.features.Features(ctx, symbol, info.Schema); err != nil {
+            return nil, fmt.Errorf("failed to build features: %w", err)
+        }
+    }
+
+    return e.predict(ctx, &PredictionRequest{
+        Symbol:    symbol,
+        Features:  features,
+        ModelName: info.Name,
+        Version:   info.Version,
+    })
+}
+
+// assetClass returns the type symbol is held as, or "" when no portfolio
+// holds it, in which case only unrestricted models apply
+func (e *Ensemble) assetClass(ctx context.Context, symbol string) (string, error) {
+    var assetClass string
+    err := e.db.QueryRowContext(ctx, "SELECT type FROM assets WHERE symbol = $1 LIMIT 1", symbol).Scan(&assetClass)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    if err != nil {
+        return "", fmt.Errorf("failed to look up asset class: %w", err)
+    }
+    return assetClass, nil
+}
+
+// combineEnsemble averages the successful members' predictions, weighting
+// each by its share of the surviving weight. Components' weights are
+// updated in place to those shares.
+func combineEnsemble(symbol string, components []EnsembleMember) (*PredictionResponse, error) {
+    var totalWeight float64
+    for _, c := range components {
+        if !c.Missing {
+            totalWeight += c.Weight
+        }
+    }
+    if totalWeight <= 0 {
+        return nil, ErrEnsembleUnavailable
+    }
+
+    combined := &PredictionResponse{Symbol: symbol, Timestamp: time.Now()}
+    for i := range components {
+        c := &components[i]
+        if c.Missing {
+            c.Weight = 0
+            continue
+        }
+        c.Weight /= totalWeight
+
+        p := c.Prediction
+        combined.Predictions.PriceHigh += p.Predictions.PriceHigh * c.Weight
+        combined.Predictions.PriceLow += p.Predictions.PriceLow * c.Weight
+        combined.Predictions.PriceClose += p.Predictions.PriceClose * c.Weight
+        combined.Predictions.Direction += p.Predictions.Direction * c.Weight
+        combined.Confidence += p.Confidence * c.Weight
+    }
+    return combined, nil
+}
+
+type ensembleSettings struct {
+    Weight       *float64 `json:"ensemble_weight"`
+    AssetClasses []string `json:"asset_classes"`
+}
+
+func ensembleSettingsFromConfig(config json.RawMessage) (ensembleSettings, error) {
+    var settings ensembleSettings
+    if len(config) == 0 {
+        return settings, nil
+    }
+    if err := json.Unmarshal(config, &settings); err != nil {
+        return settings, fmt.Errorf("invalid %s or %s: %w", ensembleWeightConfigKey, assetClassesConfigKey, err)
+    }
+    if settings.Weight != nil && *settings.Weight < 0 {
+        return settings, fmt.Errorf("%s must not be negative", ensembleWeightConfigKey)
+    }
+    return settings, nil
+}
+
+func (s ensembleSettings) weight() float64 {
+    if s.Weight == nil {
+        return 1
+    }
+    return *s.Weight
+}
+
+func (s ensembleSettings) appliesTo(assetClass string) bool {
+    if len(s.AssetClasses) == 0 {
+        return true
+    }
+    for _, c := range s.AssetClasses {
+        if strings.EqualFold(c, assetClass) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,103 @@
+package ml
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+type staticFeatures struct{}
+
+func (staticFeatures) Features(ctx context.Context, symbol string, schema *FeatureSchema) ([]float64, error) {
+    return make([]float64, len(schema.Features)), nil
+}
+
+func prediction(close, confidence float64) *PredictionResponse {
+    resp := &PredictionResponse{Confidence: confidence}
+    resp.Predictions.PriceClose = close
+    return resp
+}
+
+func TestEnsemble_Predict(t *testing.T) {
+    ctx := context.Background()
+    modelColumns := []string{"id", "name", "version", "type", "config", "status", "metrics", "created_at", "updated_at"}
+
+    expectModels := func(mock sqlmock.Sqlmock) {
+        now := time.Now()
+        schema := `"feature_schema": {"features": [{"name": "close"}]}`
+        mock.ExpectQuery("SELECT type FROM assets").
+            WithArgs("BTC").
+            WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow("crypto"))
+        mock.ExpectQuery("SELECT (.+) FROM ml_models").
+            WithArgs(StatusActive).
+            WillReturnRows(sqlmock.NewRows(modelColumns).
+                AddRow(1, "lstm", "3", "lstm", []byte(`{`+schema+`, "ensemble_weight": 2}`), StatusActive, nil, now, now).
+                AddRow(2, "xgb", "1", "xgboost", []byte(`{`+schema+`}`), StatusActive, nil, now, now).
+                AddRow(3, "arima", "5", "arima", []byte(`{`+schema+`}`), StatusActive, nil, now, now).
+                AddRow(4, "equity", "1", "lstm", []byte(`{`+schema+`, "asset_classes": ["stock"]}`), StatusActive, nil, now, now))
+    }
+
+    t.Run("One model down", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+        expectModels(mock)
+
+        predict := func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+            switch req.ModelName {
+            case "lstm":
+                return prediction(100, 0.9), nil
+            case "xgb":
+                return prediction(130, 0.6), nil
+            case "equity":
+                t.Errorf("stock-only model was asked about BTC")
+            }
+            return nil, errors.New("model server unavailable")
+        }
+
+        result, err := NewEnsemble(db, NewModelManager(db), staticFeatures{}, predict).Predict(ctx, "BTC")
+        if !assert.NoError(t, err) {
+            return
+        }
+
+        assert.True(t, result.Degraded)
+        assert.Equal(t, "crypto", result.AssetClass)
+        if !assert.Len(t, result.Components, 3) {
+            return
+        }
+        // The surviving weights 2 and 1 renormalize to 2/3 and 1/3
+        assert.InDelta(t, 2.0/3, result.Components[0].Weight, 1e-9)
+        assert.InDelta(t, 1.0/3, result.Components[1].Weight, 1e-9)
+        assert.True(t, result.Components[2].Missing)
+        assert.Equal(t, "arima", result.Components[2].Name)
+        assert.Zero(t, result.Components[2].Weight)
+        assert.NotEmpty(t, result.Components[2].Error)
+
+        assert.InDelta(t, 110, result.Combined.Predictions.PriceClose, 1e-9)
+        assert.InDelta(t, 0.8, result.Combined.Confidence, 1e-9)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("All models down", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+        expectModels(mock)
+
+        predict := func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+            return nil, ErrQueueFull
+        }
+
+        _, err = NewEnsemble(db, NewModelManager(db), staticFeatures{}, predict).Predict(ctx, "BTC")
+        assert.ErrorIs(t, err, ErrEnsembleUnavailable)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
@@ -0,0 +1,195 @@
+package ml
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Training event types
+const (
+    EventStatus  = "status"
+    EventLog     = "log"
+    EventMetrics = "metrics"
+)
+
+const (
+    // eventBufferSize is how many events are kept per job for resuming
+    eventBufferSize = 1000
+    // subscriberBuffer is how far a subscriber may fall behind before it is
+    // dropped; it can resume from its last event ID
+    subscriberBuffer = 64
+    // finishedJobRetention is how long events of finished jobs are kept
+    finishedJobRetention = 10 * time.Minute
+)
+
+type TrainingEvent struct {
+    ID      int64              `json:"id"`
+    JobID   int64              `json:"job_id"`
+    Type    string             `json:"type"`
+    Status  string             `json:"status,omitempty"`
+    Line    string             `json:"line,omitempty"`
+    Epoch   int                `json:"epoch,omitempty"`
+    Metrics map[string]float64 `json:"metrics,omitempty"`
+    Time    time.Time          `json:"time"`
+}
+
+// IsTerminalStatus reports whether a training job in status will not change
+func IsTerminalStatus(status string) bool {
+    return status == "completed" || status == "failed"
+}
+
+type jobEvents struct {
+    events      []TrainingEvent
+    nextID      int64
+    subscribers map[chan TrainingEvent]struct{}
+    finishedAt  time.Time
+}
+
+// TrainingEventHub fans out training job events to stream subscribers.
+// Publishing never blocks: a subscriber that falls behind is dropped.
+type TrainingEventHub struct {
+    mu   sync.Mutex
+    jobs map[int64]*jobEvents
+}
+
+func NewTrainingEventHub() *TrainingEventHub {
+    return &TrainingEventHub{jobs: make(map[int64]*jobEvents)}
+}
+
+// Publish assigns event the job's next ID and delivers it. A terminal status
+// event closes every subscriber of the job.
+func (h *TrainingEventHub) Publish(jobID int64, event TrainingEvent) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.prune(time.Now())
+
+    job, ok := h.jobs[jobID]
+    if !ok {
+        job = &jobEvents{nextID: 1, subscribers: make(map[chan TrainingEvent]struct{})}
+        h.jobs[jobID] = job
+    }
+
+    event.ID = job.nextID
+    event.JobID = jobID
+    if event.Time.IsZero() {
+        event.Time = time.Now()
+    }
+    job.nextID++
+
+    job.events = append(job.events, event)
+    if len(job.events) > eventBufferSize {
+        job.events = job.events[len(job.events)-eventBufferSize:]
+    }
+
+    for ch := range job.subscribers {
+        select {
+        case ch <- event:
+        default:
+            delete(job.subscribers, ch)
+            close(ch)
+        }
+    }
+
+    if event.Type == EventStatus && IsTerminalStatus(event.Status) {
+        job.finishedAt = event.Time
+        for ch := range job.subscribers {
+            delete(job.subscribers, ch)
+            close(ch)
+        }
+    }
+}
+
+// Subscribe returns the buffered events after afterID and a channel of later
+// ones. The channel is nil if the job has already finished, and is closed
+// when it finishes or the subscriber falls behind. ok is false if the hub
+// has no events for the job.
+func (h *TrainingEventHub) Subscribe(jobID, afterID int64) (replay []TrainingEvent, live chan TrainingEvent, ok bool) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    job, ok := h.jobs[jobID]
+    if !ok {
+        return nil, nil, false
+    }
+
+    for _, event := range job.events {
+        if event.ID > afterID {
+            replay = append(replay, event)
+        }
+    }
+
+    if !job.finishedAt.IsZero() {
+        return replay, nil, true
+    }
+
+    live = make(chan TrainingEvent, subscriberBuffer)
+    job.subscribers[live] = struct{}{}
+    return replay, live, true
+}
+
+// Unsubscribe stops delivery to live if it is still subscribed
+func (h *TrainingEventHub) Unsubscribe(jobID int64, live chan TrainingEvent) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if job, ok := h.jobs[jobID]; ok {
+        if _, ok := job.subscribers[live]; ok {
+            delete(job.subscribers, live)
+            close(live)
+        }
+    }
+}
+
+// prune drops finished jobs past retention; the caller holds mu
+func (h *TrainingEventHub) prune(now time.Time) {
+    for id, job := range h.jobs {
+        if !job.finishedAt.IsZero() && now.Sub(job.finishedAt) > finishedJobRetention {
+            delete(h.jobs, id)
+        }
+    }
+}
+
+// parseTrainingLine turns a line of training output into an event. Lines of
+// key=value pairs that include an integer epoch become metrics events; any
+// other line is a log event.
+func parseTrainingLine(line string) TrainingEvent {
+    event := TrainingEvent{Type: EventLog, Line: line}
+
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+        return event
+    }
+
+    epoch := -1
+    metrics := make(map[string]float64)
+    for _, field := range fields {
+        parts := strings.SplitN(field, "=", 2)
+        if len(parts) != 2 {
+            return event
+        }
+        if parts[0] == "epoch" {
+            n, err := strconv.Atoi(parts[1])
+            if err != nil {
+                return event
+            }
+            epoch = n
+            continue
+        }
+        value, err := strconv.ParseFloat(parts[1], 64)
+        if err != nil {
+            return event
+        }
+        metrics[parts[0]] = value
+    }
+    if epoch < 0 {
+        return event
+    }
+
+    event.Type = EventMetrics
+    event.Epoch = epoch
+    event.Metrics = metrics
+    return event
+}
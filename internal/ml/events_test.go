@@ -0,0 +1,98 @@
+package ml
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParseTrainingLine(t *testing.T) {
+    t.Run("Epoch metrics", func(t *testing.T) {
+        event := parseTrainingLine("epoch=3 loss=0.012 val_loss=0.02")
+        assert.Equal(t, EventMetrics, event.Type)
+        assert.Equal(t, 3, event.Epoch)
+        assert.Equal(t, map[string]float64{"loss": 0.012, "val_loss": 0.02}, event.Metrics)
+    })
+
+    t.Run("Plain log line", func(t *testing.T) {
+        event := parseTrainingLine("Loading dataset from /data/train.csv")
+        assert.Equal(t, EventLog, event.Type)
+        assert.Equal(t, "Loading dataset from /data/train.csv", event.Line)
+        assert.Nil(t, event.Metrics)
+    })
+
+    t.Run("Key values without epoch", func(t *testing.T) {
+        event := parseTrainingLine("loss=0.5")
+        assert.Equal(t, EventLog, event.Type)
+    })
+
+    t.Run("Non-numeric value", func(t *testing.T) {
+        event := parseTrainingLine("epoch=1 optimizer=adam")
+        assert.Equal(t, EventLog, event.Type)
+    })
+}
+
+func TestTrainingEventHub(t *testing.T) {
+    t.Run("Unknown job", func(t *testing.T) {
+        hub := NewTrainingEventHub()
+        _, _, ok := hub.Subscribe(1, 0)
+        assert.False(t, ok)
+    })
+
+    t.Run("Resume after last event ID", func(t *testing.T) {
+        hub := NewTrainingEventHub()
+        hub.Publish(1, TrainingEvent{Type: EventStatus, Status: "running"})
+        hub.Publish(1, parseTrainingLine("epoch=1 loss=0.4"))
+        hub.Publish(1, parseTrainingLine("epoch=2 loss=0.3"))
+
+        replay, live, ok := hub.Subscribe(1, 1)
+        if !assert.True(t, ok) || !assert.Len(t, replay, 2) {
+            return
+        }
+        assert.Equal(t, int64(2), replay[0].ID)
+        assert.Equal(t, 2, replay[1].Epoch)
+        assert.NotNil(t, live)
+
+        hub.Publish(1, parseTrainingLine("epoch=3 loss=0.2"))
+        event := <-live
+        assert.Equal(t, int64(4), event.ID)
+        assert.Equal(t, int64(1), event.JobID)
+    })
+
+    t.Run("Terminal status closes subscribers", func(t *testing.T) {
+        hub := NewTrainingEventHub()
+        hub.Publish(1, TrainingEvent{Type: EventStatus, Status: "running"})
+        _, live, _ := hub.Subscribe(1, 0)
+
+        hub.Publish(1, TrainingEvent{Type: EventStatus, Status: "completed"})
+        event := <-live
+        assert.Equal(t, "completed", event.Status)
+        _, open := <-live
+        assert.False(t, open)
+
+        replay, live, ok := hub.Subscribe(1, 0)
+        assert.True(t, ok)
+        assert.Len(t, replay, 2)
+        assert.Nil(t, live)
+    })
+
+    t.Run("Slow subscriber is dropped without blocking", func(t *testing.T) {
+        hub := NewTrainingEventHub()
+        hub.Publish(1, TrainingEvent{Type: EventStatus, Status: "running"})
+        _, live, _ := hub.Subscribe(1, 0)
+
+        for i := 0; i < subscriberBuffer+10; i++ {
+            hub.Publish(1, TrainingEvent{Type: EventLog, Line: "step"})
+        }
+
+        received := 0
+        for range live {
+            received++
+        }
+        assert.Equal(t, subscriberBuffer, received)
+
+        // The dropped client can pick up where it left off
+        replay, _, _ := hub.Subscribe(1, int64(1+received))
+        assert.Len(t, replay, 10)
+    })
+}
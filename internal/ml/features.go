@@ -0,0 +1,118 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "math"
+)
+
+// featureHistory is how many candles MarketFeatureSource reads, enough for
+// the longest supported lookback
+const featureHistory = 21
+
+var ErrUnsupportedFeature = errors.New("unsupported feature")
+
+// FeatureSource builds the inputs a model's schema asks for when the caller
+// doesn't supply features itself
+type FeatureSource interface {
+    Features(ctx context.Context, symbol string, schema *FeatureSchema) ([]float64, error)
+}
+
+// MarketFeatureSource computes features from the latest daily candles in
+// market_data. It supports close, volume, return_1d, return_5d, return_20d
+// and volatility_20d.
+type MarketFeatureSource struct {
+    db *sql.DB
+}
+
+func NewMarketFeatureSource(db *sql.DB) *MarketFeatureSource {
+    return &MarketFeatureSource{db: db}
+}
+
+func (f *MarketFeatureSource) Features(ctx context.Context, symbol string, schema *FeatureSchema) ([]float64, error) {
+    query := `
+        SELECT close, volume FROM market_data
+        WHERE symbol = $1
+        ORDER BY timestamp DESC
+        LIMIT $2
+    `
+    rows, err := f.db.QueryContext(ctx, query, symbol, featureHistory)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load candles: %w", err)
+    }
+    defer rows.Close()
+
+    var closes, volumes []float64
+    for rows.Next() {
+        var close, volume float64
+        if err := rows.Scan(&close, &volume); err != nil {
+            return nil, err
+        }
+        closes = append(closes, close)
+        volumes = append(volumes, volume)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return candleFeatures(schema, closes, volumes)
+}
+
+// candleFeatures computes schema's features from candles ordered newest
+// first
+func candleFeatures(schema *FeatureSchema, closes, volumes []float64) ([]float64, error) {
+    features := make([]float64, len(schema.Features))
+    for i, spec := range schema.Features {
+        value, err := marketFeature(spec.Name, closes, volumes)
+        if err != nil {
+            return nil, err
+        }
+        features[i] = value
+    }
+    return features, nil
+}
+
+// marketFeature computes one feature from candles ordered newest first
+func marketFeature(name string, closes, volumes []float64) (float64, error) {
+    lookback := map[string]int{"return_1d": 1, "return_5d": 5, "return_20d": 20, "volatility_20d": 20}
+
+    switch name {
+    case "close", "volume":
+        if len(closes) == 0 {
+            return 0, fmt.Errorf("no candles for %s", name)
+        }
+        if name == "volume" {
+            return volumes[0], nil
+        }
+        return closes[0], nil
+
+    case "return_1d", "return_5d", "return_20d":
+        n := lookback[name]
+        if len(closes) <= n || closes[n] == 0 {
+            return 0, fmt.Errorf("not enough candles for %s", name)
+        }
+        return (closes[0] - closes[n]) / closes[n], nil
+
+    case "volatility_20d":
+        n := lookback[name]
+        if len(closes) <= n {
+            return 0, fmt.Errorf("not enough candles for %s", name)
+        }
+        var sum, sumSq float64
+        for i := 0; i < n; i++ {
+            if closes[i+1] == 0 {
+                return 0, fmt.Errorf("zero close in %s window", name)
+            }
+            r := (closes[i] - closes[i+1]) / closes[i+1]
+            sum += r
+            sumSq += r * r
+        }
+        mean := sum / float64(n)
+        return math.Sqrt(math.Max(0, sumSq/float64(n)-mean*mean)), nil
+
+    default:
+        return 0, fmt.Errorf("%w: %s", ErrUnsupportedFeature, name)
+    }
+}
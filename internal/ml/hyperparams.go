@@ -0,0 +1,383 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "math/rand/v2"
+    "time"
+)
+
+const (
+    // MaxHyperparamCombinations bounds a grid search, as every combination
+    // is a full training run
+    MaxHyperparamCombinations = 25
+    // hyperparamHoldout is the most recent span of the validation symbol's
+    // candles that search versions are trained without and scored on
+    hyperparamHoldout = 30 * 24 * time.Hour
+)
+
+// Statuses of a HyperparamSearch
+const (
+    SearchRunning   = "running"
+    SearchCompleted = "completed"
+    SearchFailed    = "failed"
+)
+
+var (
+    ErrInvalidHyperparamGrid = errors.New("invalid hyperparameter grid")
+    ErrSearchRunning         = errors.New("hyperparameter search already running")
+    ErrSearchNotFound        = errors.New("hyperparameter search not found")
+    ErrNoHoldoutData         = errors.New("no holdout candles")
+)
+
+// HyperparamGrid is the values a grid search tries. Every learning rate is
+// tried with every batch size.
+type HyperparamGrid struct {
+    LearningRates []float64 `json:"learning_rates"`
+    BatchSizes    []int     `json:"batch_sizes"`
+}
+
+// Validate checks that the grid has at least one and at most
+// MaxHyperparamCombinations combinations, all of positive values
+func (g HyperparamGrid) Validate() error {
+    combinations := len(g.LearningRates) * len(g.BatchSizes)
+    if combinations == 0 {
+        return fmt.Errorf("%w: at least one learning rate and batch size required", ErrInvalidHyperparamGrid)
+    }
+    if combinations > MaxHyperparamCombinations {
+        return fmt.Errorf("%w: %d combinations, at most %d allowed", ErrInvalidHyperparamGrid, combinations, MaxHyperparamCombinations)
+    }
+    for _, rate := range g.LearningRates {
+        if !(rate > 0) || math.IsInf(rate, 0) {
+            return fmt.Errorf("%w: learning rate %g must be positive", ErrInvalidHyperparamGrid, rate)
+        }
+    }
+    for _, size := range g.BatchSizes {
+        if size <= 0 {
+            return fmt.Errorf("%w: batch size %d must be positive", ErrInvalidHyperparamGrid, size)
+        }
+    }
+    return nil
+}
+
+// HyperparamTrial is the version trained with one combination of a grid
+// search. A failed trial has Error set and is left out of the comparison.
+type HyperparamTrial struct {
+    LearningRate float64 `json:"learning_rate"`
+    BatchSize    int     `json:"batch_size"`
+    Version      string  `json:"version"`
+    JobID        int64   `json:"job_id,omitempty"`
+    HoldoutMAE   float64 `json:"holdout_mae,omitempty"`
+    Error        string  `json:"error,omitempty"`
+}
+
+// BestHyperparams is the combination whose version had the lowest holdout
+// MAE, along with every trial of the search
+type BestHyperparams struct {
+    LearningRate float64           `json:"learning_rate"`
+    BatchSize    int               `json:"batch_size"`
+    Version      string            `json:"version"`
+    HoldoutMAE   float64           `json:"holdout_mae"`
+    Seed         int64             `json:"seed"`
+    Trials       []HyperparamTrial `json:"trials"`
+}
+
+// HyperparamSearch is a grid search started with StartHyperparameterSearch.
+// Result is set once it completes.
+type HyperparamSearch struct {
+    ModelName        string           `json:"model_name"`
+    ValidationSymbol string           `json:"validation_symbol"`
+    Grid             HyperparamGrid   `json:"grid"`
+    Status           string           `json:"status"`
+    Result           *BestHyperparams `json:"result,omitempty"`
+    Error            string           `json:"error,omitempty"`
+    StartedAt        time.Time        `json:"started_at"`
+    CompletedAt      *time.Time       `json:"completed_at,omitempty"`
+}
+
+// GridSearchHyperparams trains a version of modelName for each combination
+// in grid, one at a time, and returns the combination whose version has
+// the lowest MAE over validationSymbol's last 30 days. Versions are trained
+// from the active version's config on validationSymbol's candles before
+// those 30 days. They are registered inactive, with their holdout MAE as
+// their baseline, so the best can be activated as it is. Every version is
+// trained with the same seed, so trials differ only in their
+// hyperparameters.
+func (t *ModelTrainer) GridSearchHyperparams(ctx context.Context, modelName string, grid HyperparamGrid, validationSymbol string) (*BestHyperparams, error) {
+    if err := validateSearch(grid, validationSymbol); err != nil {
+        return nil, err
+    }
+    base, err := t.manager.GetActiveModel(ctx, modelName)
+    if err != nil {
+        return nil, err
+    }
+
+    log := t.logger.WithFields(map[string]interface{}{
+        "model":  modelName,
+        "symbol": validationSymbol,
+    })
+    holdoutStart := t.now().Add(-hyperparamHoldout)
+    stamp := t.now().Unix()
+
+    best := &BestHyperparams{Seed: rand.Int64N(maxTrainingSeed)}
+    found := false
+    for _, rate := range grid.LearningRates {
+        for _, size := range grid.BatchSizes {
+            trial := HyperparamTrial{
+                LearningRate: rate,
+                BatchSize:    size,
+                Version:      fmt.Sprintf("%s.hp%d.%d", base.Version, stamp, len(best.Trials)+1),
+            }
+            err := t.runTrial(ctx, base, &trial, validationSymbol, holdoutStart, best.Seed)
+            if ctx.Err() != nil {
+                return nil, ctx.Err()
+            }
+            if err != nil {
+                trial.Error = err.Error()
+                t.fail(log.WithFields(map[string]interface{}{"version": trial.Version}),
+                    "hyperparameter_trial", "Hyperparameter trial failed", err)
+            } else if !found || trial.HoldoutMAE < best.HoldoutMAE {
+                found = true
+                best.LearningRate = trial.LearningRate
+                best.BatchSize = trial.BatchSize
+                best.Version = trial.Version
+                best.HoldoutMAE = trial.HoldoutMAE
+            }
+            best.Trials = append(best.Trials, trial)
+        }
+    }
+    if !found {
+        return nil, fmt.Errorf("all %d hyperparameter trials failed, first: %s", len(best.Trials), best.Trials[0].Error)
+    }
+
+    log.WithFields(map[string]interface{}{
+        "version":       best.Version,
+        "learning_rate": best.LearningRate,
+        "batch_size":    best.BatchSize,
+        "holdout_mae":   best.HoldoutMAE,
+    }).Info("Hyperparameter search completed")
+    return best, nil
+}
+
+// runTrial registers, trains and scores trial's version
+func (t *ModelTrainer) runTrial(ctx context.Context, base *ModelInfo, trial *HyperparamTrial, symbol string, holdoutStart time.Time, seed int64) error {
+    config, err := configWithHyperparams(base.Config, trial.LearningRate, trial.BatchSize)
+    if err != nil {
+        return err
+    }
+    err = t.manager.RegisterModel(ctx, ModelInfo{
+        Name:    base.Name,
+        Version: trial.Version,
+        Type:    base.Type,
+        Config:  config,
+        Status:  StatusInactive,
+    })
+    if err != nil {
+        return fmt.Errorf("failed to register %s: %w", trial.Version, err)
+    }
+
+    dataConfig, _ := json.Marshal(map[string]string{
+        "symbol": symbol,
+        "end":    holdoutStart.Format(time.RFC3339),
+    })
+    trainConfig, _ := json.Marshal(map[string]interface{}{
+        "learning_rate": trial.LearningRate,
+        "batch_size":    trial.BatchSize,
+    })
+    trial.JobID, err = t.startTraining(ctx, &TrainingConfig{
+        ModelName:   base.Name,
+        Version:     trial.Version,
+        DataConfig:  dataConfig,
+        ModelConfig: config,
+        TrainConfig: trainConfig,
+        Seed:        &seed,
+    })
+    if err != nil {
+        return err
+    }
+    if err := t.waitForTraining(ctx, trial.JobID); err != nil {
+        return err
+    }
+
+    if trial.HoldoutMAE, err = t.holdoutMAE(ctx, base.Name, trial.Version, symbol, holdoutStart); err != nil {
+        return err
+    }
+    metrics, _ := json.Marshal(map[string]interface{}{
+        baselineMetricKey: trial.HoldoutMAE,
+        "learning_rate":   trial.LearningRate,
+        "batch_size":      trial.BatchSize,
+    })
+    return t.manager.UpdateModelMetrics(ctx, base.Name, trial.Version, metrics)
+}
+
+// evaluateHoldout is the MAE of version's close predictions over symbol's
+// daily candles from from on. Each prediction is made from the features as
+// of one candle and scored against the next candle's close.
+func (t *ModelTrainer) evaluateHoldout(ctx context.Context, modelName, version, symbol string, from time.Time) (float64, error) {
+    info, err := t.manager.GetModel(ctx, modelName, version)
+    if err != nil {
+        return 0, err
+    }
+
+    // Twice the history in calendar days, so closures don't leave the first
+    // holdout candles without enough of it
+    query := `
+        SELECT timestamp, close, volume FROM market_data
+        WHERE symbol = $1 AND timestamp >= $2
+        ORDER BY timestamp
+    `
+    rows, err := t.db.QueryContext(ctx, query, symbol, from.AddDate(0, 0, -2*featureHistory))
+    if err != nil {
+        return 0, fmt.Errorf("failed to load holdout candles: %w", err)
+    }
+    defer rows.Close()
+
+    var timestamps []time.Time
+    var closes, volumes []float64
+    for rows.Next() {
+        var ts time.Time
+        var close, volume float64
+        if err := rows.Scan(&ts, &close, &volume); err != nil {
+            return 0, err
+        }
+        timestamps = append(timestamps, ts)
+        closes = append(closes, close)
+        volumes = append(volumes, volume)
+    }
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    var total float64
+    var samples int
+    for i := 0; i+1 < len(closes); i++ {
+        if timestamps[i].Before(from) {
+            continue
+        }
+
+        var features []float64
+        if info.Schema != nil {
+            features, err = candleFeatures(info.Schema, newestFirst(closes[:i+1]), newestFirst(volumes[:i+1]))
+            if err != nil {
+                return 0, fmt.Errorf("failed to build features for %s: %w", timestamps[i].Format(time.RFC3339), err)
+            }
+        }
+        resp, err := t.service.Evaluate(ctx, &PredictionRequest{
+            Symbol:    symbol,
+            Features:  features,
+            ModelName: modelName,
+            Version:   version,
+        })
+        if err != nil {
+            return 0, err
+        }
+        total += math.Abs(resp.Predictions.PriceClose - closes[i+1])
+        samples++
+    }
+    if samples == 0 {
+        return 0, fmt.Errorf("%w for %s since %s", ErrNoHoldoutData, symbol, from.Format(time.RFC3339))
+    }
+    return total / float64(samples), nil
+}
+
+// StartHyperparameterSearch runs GridSearchHyperparams in the background,
+// as its training runs take far longer than a request. A model has one
+// search running at a time, and HyperparameterSearch reports its latest.
+func (t *ModelTrainer) StartHyperparameterSearch(ctx context.Context, modelName string, grid HyperparamGrid, validationSymbol string) (*HyperparamSearch, error) {
+    if err := validateSearch(grid, validationSymbol); err != nil {
+        return nil, err
+    }
+    // Fail an unknown model now rather than in the background
+    if _, err := t.manager.GetActiveModel(ctx, modelName); err != nil {
+        return nil, err
+    }
+
+    t.searchMu.Lock()
+    if running, ok := t.searches[modelName]; ok && running.Status == SearchRunning {
+        t.searchMu.Unlock()
+        return nil, fmt.Errorf("%w for %s", ErrSearchRunning, modelName)
+    }
+    search := &HyperparamSearch{
+        ModelName:        modelName,
+        ValidationSymbol: validationSymbol,
+        Grid:             grid,
+        Status:           SearchRunning,
+        StartedAt:        t.now(),
+    }
+    t.searches[modelName] = search
+    started := *search
+    t.searchMu.Unlock()
+
+    go func() {
+        // The search outlives the request that started it, like the
+        // training jobs it runs
+        result, err := t.GridSearchHyperparams(t.service.lifetime, modelName, grid, validationSymbol)
+
+        t.searchMu.Lock()
+        defer t.searchMu.Unlock()
+        completed := t.now()
+        search.CompletedAt = &completed
+        if err != nil {
+            search.Status = SearchFailed
+            search.Error = err.Error()
+            return
+        }
+        search.Status = SearchCompleted
+        search.Result = result
+    }()
+
+    return &started, nil
+}
+
+// HyperparameterSearch returns the latest search started for modelName
+func (t *ModelTrainer) HyperparameterSearch(modelName string) (*HyperparamSearch, error) {
+    t.searchMu.Lock()
+    defer t.searchMu.Unlock()
+
+    search, ok := t.searches[modelName]
+    if !ok {
+        return nil, fmt.Errorf("%w for %s", ErrSearchNotFound, modelName)
+    }
+    snapshot := *search
+    return &snapshot, nil
+}
+
+func validateSearch(grid HyperparamGrid, validationSymbol string) error {
+    if err := grid.Validate(); err != nil {
+        return err
+    }
+    if validationSymbol == "" {
+        return fmt.Errorf("%w: validation symbol required", ErrInvalidHyperparamGrid)
+    }
+    return nil
+}
+
+// configWithHyperparams returns config with learning_rate and batch_size
+// set to the given values
+func configWithHyperparams(config json.RawMessage, learningRate float64, batchSize int) (json.RawMessage, error) {
+    wrapper := make(map[string]json.RawMessage)
+    if len(config) > 0 {
+        if err := json.Unmarshal(config, &wrapper); err != nil {
+            return nil, fmt.Errorf("model config must be a JSON object: %w", err)
+        }
+    }
+    wrapper["learning_rate"], _ = json.Marshal(learningRate)
+    wrapper["batch_size"], _ = json.Marshal(batchSize)
+    return json.Marshal(wrapper)
+}
+
+// newestFirst returns the last featureHistory values, newest first
+func newestFirst(values []float64) []float64 {
+    n := len(values)
+    if n > featureHistory {
+        n = featureHistory
+    }
+    out := make([]float64, n)
+    for i := range out {
+        out[i] = values[len(values)-1-i]
+    }
+    return out
+}
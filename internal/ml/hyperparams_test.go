@@ -0,0 +1,196 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "math"
+    "math/rand"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+// linearFit is y = weight*x + bias fitted by mini-batch SGD
+type linearFit struct {
+    weight, bias float64
+}
+
+// regressionProblem is y = 3x + 2 with a little noise, split into training
+// samples and a holdout
+type regressionProblem struct {
+    trainX, trainY     []float64
+    holdoutX, holdoutY []float64
+}
+
+func newRegressionProblem() *regressionProblem {
+    rng := rand.New(rand.NewSource(1))
+    p := &regressionProblem{}
+    for i := 0; i < 360; i++ {
+        x := rng.Float64()
+        y := 3*x + 2 + rng.NormFloat64()*0.05
+        if i < 300 {
+            p.trainX, p.trainY = append(p.trainX, x), append(p.trainY, y)
+        } else {
+            p.holdoutX, p.holdoutY = append(p.holdoutX, x), append(p.holdoutY, y)
+        }
+    }
+    return p
+}
+
+// fit runs a fixed number of epochs, so how far training gets depends on
+// the learning rate and how many updates the batch size allows
+func (p *regressionProblem) fit(learningRate float64, batchSize int) linearFit {
+    var f linearFit
+    for epoch := 0; epoch < 3; epoch++ {
+        for start := 0; start < len(p.trainX); start += batchSize {
+            end := start + batchSize
+            if end > len(p.trainX) {
+                end = len(p.trainX)
+            }
+            var gradW, gradB float64
+            for i := start; i < end; i++ {
+                residual := f.weight*p.trainX[i] + f.bias - p.trainY[i]
+                gradW += residual * p.trainX[i]
+                gradB += residual
+            }
+            n := float64(end - start)
+            f.weight -= learningRate * gradW / n
+            f.bias -= learningRate * gradB / n
+        }
+    }
+    return f
+}
+
+func (p *regressionProblem) holdoutMAE(f linearFit) float64 {
+    var total float64
+    for i, x := range p.holdoutX {
+        total += math.Abs(f.weight*x + f.bias - p.holdoutY[i])
+    }
+    return total / float64(len(p.holdoutX))
+}
+
+func TestModelTrainer_GridSearchHyperparams(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    problem := newRegressionProblem()
+    trainer := NewModelTrainer(db, NewModelManager(db), nil, nil)
+    trainer.now = func() time.Time { return now }
+    trainer.pollInterval = time.Millisecond
+
+    // Training jobs fit the problem in the background with the job's
+    // hyperparameters
+    var mu sync.Mutex
+    fits := make(map[string]linearFit)
+    done := make(map[int64]bool)
+    var configs []*TrainingConfig
+    trainer.startTraining = func(ctx context.Context, config *TrainingConfig) (int64, error) {
+        var params struct {
+            LearningRate float64 `json:"learning_rate"`
+            BatchSize    int     `json:"batch_size"`
+        }
+        if err := json.Unmarshal(config.TrainConfig, &params); err != nil {
+            return 0, err
+        }
+        mu.Lock()
+        defer mu.Unlock()
+        configs = append(configs, config)
+        jobID := int64(len(configs))
+        go func() {
+            f := problem.fit(params.LearningRate, params.BatchSize)
+            mu.Lock()
+            fits[config.Version] = f
+            done[jobID] = true
+            mu.Unlock()
+        }()
+        return jobID, nil
+    }
+    trainer.trainingStatus = func(ctx context.Context, jobID int64) (string, error) {
+        mu.Lock()
+        defer mu.Unlock()
+        if done[jobID] {
+            return "completed", nil
+        }
+        return "running", nil
+    }
+    trainer.holdoutMAE = func(ctx context.Context, modelName, version, symbol string, from time.Time) (float64, error) {
+        assert.Equal(t, "BTC", symbol)
+        assert.Equal(t, now.Add(-30*24*time.Hour), from)
+        mu.Lock()
+        defer mu.Unlock()
+        return problem.holdoutMAE(fits[version]), nil
+    }
+
+    config := `{"feature_schema": {"features": [{"name": "close"}]}, "epochs": 3}`
+    mock.ExpectQuery("SELECT version FROM ml_models").
+        WithArgs("lstm-btc").
+        WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("1.0"))
+    mock.ExpectQuery("SELECT id, name, version, type, config, status, metrics, created_at, updated_at").
+        WithArgs("lstm-btc", "1.0").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "type", "config", "status", "metrics", "created_at", "updated_at"}).
+            AddRow(1, "lstm-btc", "1.0", "lstm", []byte(config), StatusActive, []byte(`{"mae": 0.2}`), now, now))
+    for i := 0; i < 4; i++ {
+        mock.ExpectExec("INSERT INTO ml_models").
+            WillReturnResult(sqlmock.NewResult(int64(i+2), 1))
+        mock.ExpectExec("UPDATE ml_models SET metrics").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+    }
+
+    grid := HyperparamGrid{
+        LearningRates: []float64{0.001, 0.5},
+        BatchSizes:    []int{8, 100},
+    }
+    best, err := trainer.GridSearchHyperparams(context.Background(), "lstm-btc", grid, "BTC")
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // Only the high rate with small batches gets close in three epochs
+    assert.Equal(t, 0.5, best.LearningRate)
+    assert.Equal(t, 8, best.BatchSize)
+    assert.Equal(t, "1.0.hp1710234000.3", best.Version)
+    if !assert.Len(t, best.Trials, 4) {
+        return
+    }
+    for i, trial := range best.Trials {
+        assert.Empty(t, trial.Error)
+        // Trials share one seed, so only the hyperparameters differ
+        if assert.NotNil(t, configs[i].Seed) {
+            assert.Equal(t, best.Seed, *configs[i].Seed)
+        }
+        assert.GreaterOrEqual(t, trial.HoldoutMAE, best.HoldoutMAE)
+    }
+    assert.Less(t, best.HoldoutMAE, 0.1)
+
+    // Versions are trained from the active config without the holdout
+    var modelConfig map[string]interface{}
+    assert.NoError(t, json.Unmarshal(configs[2].ModelConfig, &modelConfig))
+    assert.Equal(t, 0.5, modelConfig["learning_rate"])
+    assert.Equal(t, 8.0, modelConfig["batch_size"])
+    assert.Equal(t, 3.0, modelConfig["epochs"])
+    assert.JSONEq(t, `{"symbol": "BTC", "end": "2024-02-11T09:00:00Z"}`, string(configs[2].DataConfig))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHyperparamGrid_Validate(t *testing.T) {
+    valid := HyperparamGrid{LearningRates: []float64{0.01}, BatchSizes: []int{32}}
+    assert.NoError(t, valid.Validate())
+
+    for name, grid := range map[string]HyperparamGrid{
+        "empty":              {LearningRates: []float64{0.01}},
+        "over 25":            {LearningRates: []float64{1, 2, 3, 4, 5, 6}, BatchSizes: []int{1, 2, 3, 4, 5}},
+        "non-positive rate":  {LearningRates: []float64{0}, BatchSizes: []int{32}},
+        "NaN rate":           {LearningRates: []float64{math.NaN()}, BatchSizes: []int{32}},
+        "non-positive batch": {LearningRates: []float64{0.01}, BatchSizes: []int{-1}},
+    } {
+        assert.True(t, errors.Is(grid.Validate(), ErrInvalidHyperparamGrid), name)
+    }
+}
@@ -4,14 +4,33 @@ import (
     "context"
     "database/sql"
     "encoding/json"
+    "errors"
     "fmt"
     "os"
     "os/exec"
     "path/filepath"
+    "sort"
     "sync"
     "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
 )
 
+// component names the service in logs and error metrics
+const component = "lstm"
+
+// ModelType is the ml_models type of the models the service serves
+const ModelType = "lstm"
+
+// DefaultWarmUpTimeout bounds how long a model may take to start and
+// answer its warm-up prediction
+const DefaultWarmUpTimeout = 2 * time.Minute
+
 type Service struct {
     db          *sql.DB
     modelPath   string
@@ -19,6 +38,29 @@ type Service struct {
     cacheMutex  sync.RWMutex
     batchSize   int
     maxRetries  int
+    artifacts   *artifacts.Cache
+    logger      *logger.Logger
+    errors      *monitoring.ComponentErrors
+
+    // lifetime bounds the serving processes, which are stopped when it is
+    // cancelled
+    lifetime context.Context
+    // command builds the serving process of the model in modelDir
+    command        func(ctx context.Context, modelDir string) *exec.Cmd
+    warmUpTimeout  time.Duration
+    warmUpDuration *prometheus.HistogramVec
+
+    warmUpMu sync.Mutex
+    warmUp   warmUpStatus
+}
+
+// warmUpStatus is the progress of WarmUp, for WarmUpCheck
+type warmUpStatus struct {
+    started bool
+    done    bool
+    total   int
+    warmed  int
+    failed  int
 }
 
 type Model struct {
@@ -28,6 +70,49 @@ type Model struct {
     InputChan chan []float64
     OutputChan chan Prediction
     LastUsed   time.Time
+
+    // exited is closed once the process has exited
+    exited chan struct{}
+    // warm is closed once the model is ready for requests, which wait on
+    // it; warmErr is then set if its warm-up failed
+    warm    chan struct{}
+    warmErr error
+
+    mu             sync.Mutex
+    lastPrediction time.Time
+}
+
+// running reports whether the model's process is still running
+func (m *Model) running() bool {
+    select {
+    case <-m.exited:
+        return false
+    default:
+        return true
+    }
+}
+
+// awaitWarm waits for the model to finish warming up, returning the error
+// it failed with, if any
+func (m *Model) awaitWarm(ctx context.Context) error {
+    select {
+    case <-m.warm:
+        return m.warmErr
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (m *Model) recordPrediction() {
+    m.mu.Lock()
+    m.lastPrediction = time.Now()
+    m.mu.Unlock()
+}
+
+func (m *Model) lastPredictionAt() time.Time {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.lastPrediction
 }
 
 type Prediction struct {
@@ -38,57 +123,135 @@ type Prediction struct {
     Confidence float64
 }
 
-func NewService(db *sql.DB, modelPath string) *Service {
-    return &Service{
-        db:         db,
-        modelPath:  modelPath,
-        modelCache: make(map[string]*Model),
-        batchSize:  32,
-        maxRetries: 3,
+func NewService(db *sql.DB, modelPath string, log *logger.Logger) *Service {
+    if log == nil {
+        log = logger.Default()
+    }
+    s := &Service{
+        db:            db,
+        modelPath:     modelPath,
+        modelCache:    make(map[string]*Model),
+        batchSize:     32,
+        maxRetries:    3,
+        logger:        log.WithFields(map[string]interface{}{"component": component}),
+        lifetime:      context.Background(),
+        warmUpTimeout: DefaultWarmUpTimeout,
     }
+    s.command = s.serveCommand
+    return s
 }
 
-func (s *Service) GetModel(name, version string) (*Model, error) {
+// serveCommand starts the Python inference server on the model in
+// modelDir, terminating it when ctx is done
+func (s *Service) serveCommand(ctx context.Context, modelDir string) *exec.Cmd {
+    return lifecycle.Command(ctx, lifecycle.DefaultGracePeriod, "python",
+        filepath.Join(s.modelPath, "serve.py"),
+        "--model-path", modelDir,
+    )
+}
+
+// WithLifetime runs the serving processes until ctx is cancelled, such as
+// at shutdown, rather than until they are evicted
+func (s *Service) WithLifetime(ctx context.Context) *Service {
+    s.lifetime = ctx
+    return s
+}
+
+// WithMetrics registers the warm-up duration of each model with reg
+func (s *Service) WithMetrics(reg prometheus.Registerer) *Service {
+    s.warmUpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "ml_model_warmup_duration_seconds",
+        Help:    "Time taken to start a model's serving process and answer its warm-up prediction",
+        Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120},
+    }, []string{"model"})
+    reg.MustRegister(s.warmUpDuration)
+    return s
+}
+
+// WithWarmUpTimeout sets how long a model may take to warm up before it is
+// given up on
+func (s *Service) WithWarmUpTimeout(d time.Duration) *Service {
+    s.warmUpTimeout = d
+    return s
+}
+
+// WithErrors counts the service's failures in errs
+func (s *Service) WithErrors(errs *monitoring.ComponentErrors) *Service {
+    s.errors = errs
+    return s
+}
+
+func (s *Service) fail(fields map[string]interface{}, errorType, msg string, err error) {
+    fields["error_type"] = errorType
+    fields["error"] = err.Error()
+    s.logger.WithFields(fields).Error(msg)
+    s.errors.Record(component, errorType)
+}
+
+// WithArtifacts serves models from the artifact cache. Models without a
+// recorded artifact are still read from modelPath.
+func (s *Service) WithArtifacts(cache *artifacts.Cache) *Service {
+    s.artifacts = cache
+    return s
+}
+
+func modelKey(name, version string) string {
+    return fmt.Sprintf("%s@%s", name, version)
+}
+
+func (s *Service) GetModel(ctx context.Context, name, version string) (*Model, error) {
+    model, _, err := s.getModel(ctx, name, version, false)
+    return model, err
+}
+
+// getModel returns the cached model, loading it if it isn't cached yet.
+// loaded reports whether this call loaded it; a model loaded to warm up
+// holds requests until its warm-up is done. ctx bounds the loading, not
+// the process, which serves every later request too.
+func (s *Service) getModel(ctx context.Context, name, version string, warming bool) (model *Model, loaded bool, err error) {
     s.cacheMutex.RLock()
-    modelKey := fmt.Sprintf("%s@%s", name, version)
-    model, exists := s.modelCache[modelKey]
+    key := modelKey(name, version)
+    model, exists := s.modelCache[key]
     s.cacheMutex.RUnlock()
 
     if exists {
         model.LastUsed = time.Now()
-        return model, nil
+        return model, false, nil
     }
 
     s.cacheMutex.Lock()
     defer s.cacheMutex.Unlock()
 
     // Check again in case another goroutine loaded it
-    if model, exists = s.modelCache[modelKey]; exists {
+    if model, exists = s.modelCache[key]; exists {
         model.LastUsed = time.Now()
-        return model, nil
+        return model, false, nil
     }
 
     // Load model
-    model, err := s.loadModel(name, version)
+    model, err = s.loadModel(ctx, name, version)
     if err != nil {
-        return nil, err
+        return nil, false, err
+    }
+    if !warming {
+        close(model.warm)
     }
 
-    s.modelCache[modelKey] = model
-    return model, nil
+    s.modelCache[key] = model
+    return model, true, nil
 }
 
-func (s *Service) loadModel(name, version string) (*Model, error) {
-    modelPath := filepath.Join(s.modelPath, name, version)
+func (s *Service) loadModel(ctx context.Context, name, version string) (*Model, error) {
+    modelPath, err := s.modelDir(ctx, name, version)
+    if err != nil {
+        return nil, err
+    }
     if _, err := os.Stat(modelPath); os.IsNotExist(err) {
         return nil, fmt.Errorf("model not found: %s@%s", name, version)
     }
 
     // Start Python process for model inference
-    cmd := exec.Command("python",
-        filepath.Join(s.modelPath, "serve.py"),
-        "--model-path", modelPath,
-    )
+    cmd := s.command(s.lifetime, modelPath)
 
     // Set up pipes for communication
     stdinPipe, err := cmd.StdinPipe()
@@ -112,17 +275,25 @@ func (s *Service) loadModel(name, version string) (*Model, error) {
         InputChan:  make(chan []float64, s.batchSize),
         OutputChan: make(chan Prediction, s.batchSize),
         LastUsed:   time.Now(),
+        exited:     make(chan struct{}),
+        warm:       make(chan struct{}),
     }
 
-    // Start goroutines for handling I/O
+    // Start goroutines for handling I/O. Both end with the process, which
+    // lifetime bounds.
     go func() {
         defer stdinPipe.Close()
         encoder := json.NewEncoder(stdinPipe)
 
-        for features := range model.InputChan {
-            if err := encoder.Encode(features); err != nil {
-                fmt.Printf("Failed to encode features: %v\n", err)
-                continue
+        for {
+            select {
+            case features := <-model.InputChan:
+                if err := encoder.Encode(features); err != nil {
+                    s.fail(map[string]interface{}{"model": name, "version": version},
+                        "encode", "Failed to encode features", err)
+                }
+            case <-model.exited:
+                return
             }
         }
     }()
@@ -134,7 +305,12 @@ func (s *Service) loadModel(name, version string) (*Model, error) {
         for {
             var pred Prediction
             if err := decoder.Decode(&pred); err != nil {
-                fmt.Printf("Failed to decode prediction: %v\n", err)
+                s.fail(map[string]interface{}{"model": name, "version": version},
+                    "decode", "Failed to decode prediction", err)
+                // Nothing reads the output past here; reap the process
+                // once it exits
+                cmd.Wait()
+                close(model.exited)
                 return
             }
             model.OutputChan <- pred
@@ -144,11 +320,43 @@ func (s *Service) loadModel(name, version string) (*Model, error) {
     return model, nil
 }
 
+func (s *Service) modelDir(ctx context.Context, name, version string) (string, error) {
+    local := filepath.Join(s.modelPath, name, version)
+    if s.artifacts == nil {
+        return local, nil
+    }
+
+    var checksum sql.NullString
+    query := "SELECT artifact_checksum FROM ml_models WHERE name = $1 AND version = $2"
+    err := s.db.QueryRowContext(ctx, query, name, version).Scan(&checksum)
+    if err == sql.ErrNoRows {
+        return "", fmt.Errorf("model not found: %s@%s", name, version)
+    }
+    if err != nil {
+        return "", err
+    }
+    if !checksum.Valid || checksum.String == "" {
+        return local, nil
+    }
+    return s.artifacts.Path(ctx, checksum.String)
+}
+
 func (s *Service) Predict(ctx context.Context, name, version string, features []float64) (*Prediction, error) {
     var lastErr error
     for i := 0; i < s.maxRetries; i++ {
-        model, err := s.GetModel(name, version)
+        model, err := s.GetModel(ctx, name, version)
         if err != nil {
+            s.fail(map[string]interface{}{"model": name, "version": version, "attempt": i + 1},
+                "load", "Failed to load model", err)
+            lastErr = err
+            continue
+        }
+        // A model that failed to warm up has been dropped, and is loaded
+        // again on the next attempt
+        if err := model.awaitWarm(ctx); err != nil {
+            if ctx.Err() != nil {
+                return nil, err
+            }
             lastErr = err
             continue
         }
@@ -161,6 +369,7 @@ func (s *Service) Predict(ctx context.Context, name, version string, features []
 
         select {
         case pred := <-model.OutputChan:
+            model.recordPrediction()
             return &pred, nil
         case <-ctx.Done():
             return nil, ctx.Err()
@@ -171,10 +380,13 @@ func (s *Service) Predict(ctx context.Context, name, version string, features []
 }
 
 func (s *Service) BatchPredict(ctx context.Context, name, version string, featuresBatch [][]float64) ([]Prediction, error) {
-    model, err := s.GetModel(name, version)
+    model, err := s.GetModel(ctx, name, version)
     if err != nil {
         return nil, err
     }
+    if err := model.awaitWarm(ctx); err != nil {
+        return nil, err
+    }
 
     predictions := make([]Prediction, 0, len(featuresBatch))
     
@@ -198,6 +410,7 @@ func (s *Service) BatchPredict(ctx context.Context, name, version string, featur
         for range batch {
             select {
             case pred := <-model.OutputChan:
+                model.recordPrediction()
                 predictions = append(predictions, pred)
             case <-ctx.Done():
                 return nil, ctx.Err()
@@ -208,6 +421,175 @@ func (s *Service) BatchPredict(ctx context.Context, name, version string, featur
     return predictions, nil
 }
 
+// WarmUpModel is a model for WarmUp to start. Inputs is the length of its
+// feature vector.
+type WarmUpModel struct {
+    Name    string
+    Version string
+    Inputs  int
+}
+
+// WarmUp starts the serving process of each model and runs a prediction of
+// zeroes through it, so that real requests don't pay for starting Python
+// and loading the model. At most concurrency models warm up at once.
+// Requests for a model warming up wait for it rather than fail.
+func (s *Service) WarmUp(ctx context.Context, models []WarmUpModel, concurrency int) {
+    if concurrency < 1 {
+        concurrency = 1
+    }
+    s.warmUpMu.Lock()
+    s.warmUp = warmUpStatus{started: true, total: len(models)}
+    s.warmUpMu.Unlock()
+
+    start := time.Now()
+    sem := make(chan struct{}, concurrency)
+    var wg sync.WaitGroup
+    for _, m := range models {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(m WarmUpModel) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            err := s.warmModel(ctx, m)
+            if err != nil {
+                s.fail(map[string]interface{}{"model": m.Name, "version": m.Version},
+                    "warmup", "Failed to warm up model", err)
+            }
+
+            s.warmUpMu.Lock()
+            defer s.warmUpMu.Unlock()
+            if err != nil {
+                s.warmUp.failed++
+            } else {
+                s.warmUp.warmed++
+            }
+        }(m)
+    }
+    wg.Wait()
+
+    s.warmUpMu.Lock()
+    s.warmUp.done = true
+    status := s.warmUp
+    s.warmUpMu.Unlock()
+    s.logger.WithFields(map[string]interface{}{
+        "models":      status.total,
+        "failed":      status.failed,
+        "duration_ms": time.Since(start).Milliseconds(),
+    }).Info("Model pool warmed up")
+}
+
+// warmModel loads m and waits for its warm-up prediction. A model that
+// fails to warm up is dropped, to be loaded again by its next request.
+func (s *Service) warmModel(ctx context.Context, m WarmUpModel) error {
+    start := time.Now()
+    model, loaded, err := s.getModel(ctx, m.Name, m.Version, true)
+    if err != nil {
+        return err
+    }
+    if !loaded {
+        // A request loaded it first
+        return nil
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, s.warmUpTimeout)
+    defer cancel()
+    if err := warmUpPrediction(ctx, model, m.Inputs); err != nil {
+        s.evict(model)
+        model.warmErr = fmt.Errorf("warm-up of %s failed: %w", modelKey(m.Name, m.Version), err)
+        close(model.warm)
+        return err
+    }
+    close(model.warm)
+
+    if s.warmUpDuration != nil {
+        s.warmUpDuration.WithLabelValues(modelKey(m.Name, m.Version)).Observe(time.Since(start).Seconds())
+    }
+    return nil
+}
+
+func warmUpPrediction(ctx context.Context, model *Model, inputs int) error {
+    select {
+    case model.InputChan <- make([]float64, inputs):
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+
+    select {
+    case _, ok := <-model.OutputChan:
+        if !ok {
+            return errors.New("model process exited")
+        }
+        model.recordPrediction()
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// evict kills model's process and drops it from the cache
+func (s *Service) evict(model *Model) {
+    s.cacheMutex.Lock()
+    defer s.cacheMutex.Unlock()
+
+    key := modelKey(model.Name, model.Version)
+    if s.modelCache[key] == model {
+        delete(s.modelCache, key)
+    }
+    model.Process.Process.Kill()
+}
+
+// WarmUpCheck reports the pool down until WarmUp has finished, so traffic
+// isn't sent to a server whose models are still starting. Models that
+// failed to warm up are loaded by their first request instead, and only
+// make it a warning.
+func (s *Service) WarmUpCheck(ctx context.Context) *monitoring.CheckResult {
+    s.warmUpMu.Lock()
+    status := s.warmUp
+    s.warmUpMu.Unlock()
+
+    result := &monitoring.CheckResult{
+        Status:    monitoring.StatusUp,
+        Component: "model_warmup",
+        Details: map[string]interface{}{
+            "models": status.total,
+            "warmed": status.warmed,
+            "failed": status.failed,
+        },
+    }
+    switch {
+    case !status.started:
+        result.Status = monitoring.StatusDown
+        result.Error = "Warm-up not started"
+    case !status.done:
+        result.Status = monitoring.StatusDown
+        result.Error = fmt.Sprintf("Warmed up %d of %d models", status.warmed+status.failed, status.total)
+    case status.failed > 0:
+        result.Status = monitoring.StatusWarning
+        result.Error = fmt.Sprintf("%d models failed to warm up", status.failed)
+    }
+    return result
+}
+
+// Processes reports the state of each cached model's process, for
+// monitoring.ModelPoolCheck
+func (s *Service) Processes() []monitoring.ModelProcess {
+    s.cacheMutex.RLock()
+    defer s.cacheMutex.RUnlock()
+
+    processes := make([]monitoring.ModelProcess, 0, len(s.modelCache))
+    for key, model := range s.modelCache {
+        processes = append(processes, monitoring.ModelProcess{
+            Model:          key,
+            Running:        model.running(),
+            LastPrediction: model.lastPredictionAt(),
+            QueueDepth:     len(model.InputChan),
+        })
+    }
+    sort.Slice(processes, func(i, j int) bool { return processes[i].Model < processes[j].Model })
+    return processes
+}
+
 func (s *Service) CleanupCache(maxAge time.Duration) {
     s.cacheMutex.Lock()
     defer s.cacheMutex.Unlock()
@@ -0,0 +1,163 @@
+package lstm
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// TestHelperServe stands in for serve.py when run by fakeServer: it waits
+// FAKE_SERVE_DELAY before answering, as loading a model does, then returns
+// the sum of each input's features as its close, or exits with
+// FAKE_SERVE_FAIL set.
+func TestHelperServe(t *testing.T) {
+    if os.Getenv("FAKE_SERVE") != "1" {
+        return
+    }
+    delay, _ := time.ParseDuration(os.Getenv("FAKE_SERVE_DELAY"))
+    time.Sleep(delay)
+    if os.Getenv("FAKE_SERVE_FAIL") == "1" {
+        os.Exit(1)
+    }
+
+    scanner := bufio.NewScanner(os.Stdin)
+    encoder := json.NewEncoder(os.Stdout)
+    for scanner.Scan() {
+        var features []float64
+        if err := json.Unmarshal(scanner.Bytes(), &features); err != nil {
+            os.Exit(2)
+        }
+        var sum float64
+        for _, f := range features {
+            sum += f
+        }
+        encoder.Encode(Prediction{PriceClose: sum, Confidence: 1})
+    }
+    os.Exit(0)
+}
+
+// fakeServer returns a command factory starting TestHelperServe, counting
+// the processes started. Processes whose start number is in failing exit
+// instead of serving.
+func fakeServer(delay time.Duration, starts *int32, failing ...int32) func(context.Context, string) *exec.Cmd {
+    return func(ctx context.Context, modelDir string) *exec.Cmd {
+        n := atomic.AddInt32(starts, 1)
+        cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestHelperServe$")
+        cmd.Env = append(os.Environ(), "FAKE_SERVE=1", "FAKE_SERVE_DELAY="+delay.String())
+        for _, f := range failing {
+            if n == f {
+                cmd.Env = append(cmd.Env, "FAKE_SERVE_FAIL=1")
+            }
+        }
+        return cmd
+    }
+}
+
+func newTestService(t *testing.T, models ...string) *Service {
+    dir := t.TempDir()
+    for _, m := range models {
+        require.NoError(t, os.MkdirAll(filepath.Join(dir, m, "1"), 0o755))
+    }
+    s := NewService(nil, dir, nil)
+    t.Cleanup(func() { s.CleanupCache(0) })
+    return s
+}
+
+func waitForProcesses(t *testing.T, s *Service, n int) {
+    require.Eventually(t, func() bool { return len(s.Processes()) == n }, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestService_WarmUp(t *testing.T) {
+    t.Run("Requests wait for a slow model to warm up", func(t *testing.T) {
+        var starts int32
+        s := newTestService(t, "btc", "eth")
+        s.command = fakeServer(300*time.Millisecond, &starts)
+
+        done := make(chan struct{})
+        go func() {
+            s.WarmUp(context.Background(), []WarmUpModel{
+                {Name: "btc", Version: "1", Inputs: 3},
+                {Name: "eth", Version: "1", Inputs: 3},
+            }, 2)
+            close(done)
+        }()
+        waitForProcesses(t, s, 2)
+        assert.Equal(t, monitoring.StatusDown, s.WarmUpCheck(context.Background()).Status)
+
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        pred, err := s.Predict(ctx, "btc", "1", []float64{1, 2, 3})
+        require.NoError(t, err)
+        // The request's own prediction, not the warm-up's
+        assert.Equal(t, 6.0, pred.PriceClose)
+
+        <-done
+        check := s.WarmUpCheck(context.Background())
+        assert.Equal(t, monitoring.StatusUp, check.Status)
+        assert.Equal(t, 2, check.Details["warmed"])
+        // Serving the request didn't start a second process
+        assert.Equal(t, int32(2), atomic.LoadInt32(&starts))
+    })
+
+    t.Run("Models failing to warm up are loaded by their requests", func(t *testing.T) {
+        var starts int32
+        s := newTestService(t, "btc")
+        s.command = fakeServer(200*time.Millisecond, &starts, 1)
+
+        done := make(chan struct{})
+        go func() {
+            s.WarmUp(context.Background(), []WarmUpModel{{Name: "btc", Version: "1", Inputs: 3}}, 1)
+            close(done)
+        }()
+        waitForProcesses(t, s, 1)
+
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        pred, err := s.Predict(ctx, "btc", "1", []float64{2, 2})
+        require.NoError(t, err)
+        assert.Equal(t, 4.0, pred.PriceClose)
+
+        <-done
+        check := s.WarmUpCheck(context.Background())
+        assert.Equal(t, monitoring.StatusWarning, check.Status)
+        assert.Equal(t, 1, check.Details["failed"])
+        assert.Equal(t, int32(2), atomic.LoadInt32(&starts))
+    })
+
+    t.Run("Not ready before warm-up starts", func(t *testing.T) {
+        s := newTestService(t)
+        check := s.WarmUpCheck(context.Background())
+        assert.Equal(t, monitoring.StatusDown, check.Status)
+        assert.Equal(t, 0, check.Details["models"])
+    })
+}
+
+func TestService_WithLifetime(t *testing.T) {
+    var starts int32
+    lifetime, shutdown := context.WithCancel(context.Background())
+    defer shutdown()
+    s := newTestService(t, "btc").WithLifetime(lifetime)
+    s.command = fakeServer(0, &starts)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    _, err := s.Predict(ctx, "btc", "1", []float64{1})
+    require.NoError(t, err)
+
+    // The request ending leaves the process serving; shutdown stops it
+    cancel()
+    waitForProcesses(t, s, 1)
+    assert.True(t, s.Processes()[0].Running)
+    shutdown()
+    require.Eventually(t, func() bool { return !s.Processes()[0].Running }, 5*time.Second, 5*time.Millisecond)
+}
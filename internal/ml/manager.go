@@ -9,7 +9,8 @@ import (
 )
 
 type ModelManager struct {
-    db *sql.DB
+    db       *sql.DB
+    notifier AdminNotifier
 }
 
 type ModelInfo struct {
@@ -20,6 +21,9 @@ type ModelInfo struct {
     Config    json.RawMessage `json:"config"`
     Status    string         `json:"status"`
     Metrics   json.RawMessage `json:"metrics,omitempty"`
+    // Schema is the feature schema stored in Config, surfaced so clients
+    // can build valid prediction requests
+    Schema    *FeatureSchema `json:"feature_schema,omitempty"`
     CreatedAt time.Time      `json:"created_at"`
     UpdatedAt time.Time      `json:"updated_at"`
 }
@@ -28,7 +32,29 @@ func NewModelManager(db *sql.DB) *ModelManager {
     return &ModelManager{db: db}
 }
 
+// RegisterModel stores a new model version. Every model must declare the
+// features it expects, either in info.Schema or under "feature_schema" in
+// info.Config.
 func (m *ModelManager) RegisterModel(ctx context.Context, info ModelInfo) error {
+    schema := info.Schema
+    if schema == nil {
+        var err error
+        if schema, err = schemaFromConfig(info.Config); err != nil {
+            return err
+        }
+    }
+    if schema == nil {
+        return fmt.Errorf("%w: model %s@%s has no feature schema", ErrInvalidFeatureSchema, info.Name, info.Version)
+    }
+    if err := schema.Check(); err != nil {
+        return err
+    }
+
+    config, err := configWithSchema(info.Config, schema)
+    if err != nil {
+        return err
+    }
+
     query := `
         INSERT INTO ml_models (
             name, version, type, config, status, created_at, updated_at
@@ -37,18 +63,27 @@ func (m *ModelManager) RegisterModel(ctx context.Context, info ModelInfo) error
         )
     `
     
-    _, err := m.db.ExecContext(ctx, query,
+    _, err = m.db.ExecContext(ctx, query,
         info.Name,
         info.Version,
         info.Type,
-        info.Config,
+        config,
         info.Status,
         time.Now(),
     )
     return err
 }
 
+// UpdateModelStatus sets any status other than active or archived, which
+// go through ActivateModel and ArchiveModel
 func (m *ModelManager) UpdateModelStatus(ctx context.Context, name, version, status string) error {
+    switch status {
+    case StatusActive:
+        return fmt.Errorf("use ActivateModel to activate %s@%s", name, version)
+    case StatusArchived:
+        return fmt.Errorf("use ArchiveModel to archive %s@%s", name, version)
+    }
+
     query := `
         UPDATE ml_models 
         SET status = $1, updated_at = $2
@@ -102,13 +137,40 @@ func (m *ModelManager) GetModel(ctx context.Context, name, version string) (*Mod
         &info.CreatedAt,
         &info.UpdatedAt,
     )
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s@%s", ErrModelNotFound, name, version)
+    }
     if err != nil {
         return nil, err
     }
 
+    if info.Schema, err = schemaFromConfig(info.Config); err != nil {
+        return nil, err
+    }
+
     return &info, nil
 }
 
+// GetActiveModel returns the newest active version of a model, the one
+// predictions use when no version is given
+func (m *ModelManager) GetActiveModel(ctx context.Context, name string) (*ModelInfo, error) {
+    var version string
+    query := `
+        SELECT version FROM ml_models
+        WHERE name = $1 AND status = 'active'
+        ORDER BY created_at DESC LIMIT 1
+    `
+    err := m.db.QueryRowContext(ctx, query, name).Scan(&version)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: no active version of %s", ErrModelNotFound, name)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return m.GetModel(ctx, name, version)
+}
+
 func (m *ModelManager) ListModels(ctx context.Context, status string) ([]ModelInfo, error) {
     var query string
     var args []interface{}
@@ -152,20 +214,78 @@ func (m *ModelManager) ListModels(ctx context.Context, status string) ([]ModelIn
         if err != nil {
             return nil, err
         }
+        if info.Schema, err = schemaFromConfig(info.Config); err != nil {
+            return nil, err
+        }
         models = append(models, info)
     }
 
     return models, nil
 }
 
+// What depends on a pinned model version
+const (
+    PinConsumerStrategy = "strategy"
+    PinConsumerSchedule = "schedule"
+)
+
+// PinVersion records that a consumer depends on version of a model, so it
+// can't be archived. A consumer pins one version of each model; pinning
+// another moves its pin.
+func (m *ModelManager) PinVersion(ctx context.Context, consumerType, consumerID, name, version string) error {
+    _, err := m.db.ExecContext(ctx, `
+        INSERT INTO model_version_pins (consumer_type, consumer_id, model_name, version)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (consumer_type, consumer_id, model_name)
+        DO UPDATE SET version = EXCLUDED.version, active = TRUE, created_at = CURRENT_TIMESTAMP
+    `, consumerType, consumerID, name, version)
+    if err != nil {
+        return fmt.Errorf("failed to pin %s@%s for %s %s: %w", name, version, consumerType, consumerID, err)
+    }
+    return nil
+}
+
+// UnpinVersion releases a consumer's pin on its version of a model
+func (m *ModelManager) UnpinVersion(ctx context.Context, consumerType, consumerID, name string) error {
+    _, err := m.db.ExecContext(ctx, `
+        UPDATE model_version_pins SET active = FALSE
+        WHERE consumer_type = $1 AND consumer_id = $2 AND model_name = $3
+    `, consumerType, consumerID, name)
+    if err != nil {
+        return fmt.Errorf("failed to unpin %s for %s %s: %w", name, consumerType, consumerID, err)
+    }
+    return nil
+}
+
+// ArchiveModel retires a model version. Versions pinned by an active
+// strategy or schedule can't be archived until the pin is removed.
 func (m *ModelManager) ArchiveModel(ctx context.Context, name, version string) error {
+    tx, err := m.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var consumerType, consumerID string
+    err = tx.QueryRowContext(ctx, `
+        SELECT consumer_type, consumer_id FROM model_version_pins
+        WHERE model_name = $1 AND version = $2 AND active
+        LIMIT 1
+    `, name, version).Scan(&consumerType, &consumerID)
+    if err == nil {
+        return fmt.Errorf("%w: %s@%s is used by %s %s", ErrModelPinned, name, version, consumerType, consumerID)
+    }
+    if err != sql.ErrNoRows {
+        return fmt.Errorf("failed to check model pins: %w", err)
+    }
+
     query := `
         UPDATE ml_models 
         SET status = 'archived', updated_at = $1
         WHERE name = $2 AND version = $3 AND status != 'archived'
     `
     
-    result, err := m.db.ExecContext(ctx, query, time.Now(), name, version)
+    result, err := tx.ExecContext(ctx, query, time.Now(), name, version)
     if err != nil {
         return err
     }
@@ -179,5 +299,5 @@ func (m *ModelManager) ArchiveModel(ctx context.Context, name, version string) e
         return fmt.Errorf("model not found or already archived: %s@%s", name, version)
     }
 
-    return nil
+    return tx.Commit()
 }
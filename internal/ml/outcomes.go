@@ -0,0 +1,84 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "math"
+    "time"
+)
+
+const (
+    // outcomeSource is the prediction_outcomes source of model predictions
+    outcomeSource = "model"
+    // outcomeHorizon is how long after a prediction its outcome is settled
+    outcomeHorizon = 24 * time.Hour
+)
+
+// PredictionOutcomes records what each model predicted, and later what
+// happened, so live error can be scored against the model's baseline
+type PredictionOutcomes struct {
+    db  *sql.DB
+    now func() time.Time
+}
+
+func NewPredictionOutcomes(db *sql.DB) *PredictionOutcomes {
+    return &PredictionOutcomes{db: db, now: time.Now}
+}
+
+// Record stores the predicted close, direction and confidence of each
+// member of pred that answered. They are unsettled until Settle fills in
+// the actual close.
+func (o *PredictionOutcomes) Record(ctx context.Context, pred *EnsemblePrediction) error {
+    query := `
+        INSERT INTO prediction_outcomes
+            (asset_symbol, source, model_name, observed_at, predicted_value, predicted_direction, confidence)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (asset_symbol, source, model_name, observed_at) DO NOTHING
+    `
+    observedAt := o.now()
+    for _, c := range pred.Components {
+        if c.Missing || c.Prediction == nil {
+            continue
+        }
+        direction := 0
+        if d := c.Prediction.Predictions.Direction; d != 0 {
+            direction = int(math.Copysign(1, d))
+        }
+        if _, err := o.db.ExecContext(ctx, query,
+            pred.Symbol, outcomeSource, c.Name, observedAt,
+            c.Prediction.Predictions.PriceClose, direction, c.Prediction.Confidence,
+        ); err != nil {
+            return fmt.Errorf("failed to record %s outcome of %s: %w", c.Name, pred.Symbol, err)
+        }
+    }
+    return nil
+}
+
+// Settle fills in the actual close and return of outcomes a day old,
+// from the last close at or before a day after they were predicted.
+// Outcomes are left unsettled until both closes are known.
+func (o *PredictionOutcomes) Settle(ctx context.Context) (int64, error) {
+    query := `
+        UPDATE prediction_outcomes o
+        SET actual_value = s.actual, next_day_return = s.actual / s.base - 1
+        FROM (
+            SELECT p.id,
+                (SELECT close FROM market_data
+                    WHERE symbol = p.asset_symbol AND timestamp <= p.observed_at
+                    ORDER BY timestamp DESC LIMIT 1) AS base,
+                (SELECT close FROM market_data
+                    WHERE symbol = p.asset_symbol AND timestamp <= p.observed_at + $2 * INTERVAL '1 second'
+                    ORDER BY timestamp DESC LIMIT 1) AS actual
+            FROM prediction_outcomes p
+            WHERE p.source = $3 AND p.actual_value IS NULL AND p.observed_at <= $1
+        ) s
+        WHERE o.id = s.id AND s.base > 0 AND s.actual IS NOT NULL
+    `
+    result, err := o.db.ExecContext(ctx, query,
+        o.now().Add(-outcomeHorizon), outcomeHorizon.Seconds(), outcomeSource)
+    if err != nil {
+        return 0, fmt.Errorf("failed to settle prediction outcomes: %w", err)
+    }
+    return result.RowsAffected()
+}
@@ -0,0 +1,47 @@
+package ml
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPredictionOutcomes(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+
+    now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+    outcomes := NewPredictionOutcomes(db)
+    outcomes.now = func() time.Time { return now }
+
+    down := &PredictionResponse{Confidence: 0.7}
+    down.Predictions.PriceClose = 49000
+    down.Predictions.Direction = -0.4
+    pred := &EnsemblePrediction{
+        Symbol: "BTC",
+        Components: []EnsembleMember{
+            {Name: "lstm", Prediction: down},
+            {Name: "gru", Missing: true, Error: "timeout"},
+        },
+    }
+
+    // Only the member that answered is recorded
+    mock.ExpectExec("INSERT INTO prediction_outcomes").
+        WithArgs("BTC", outcomeSource, "lstm", now, 49000.0, -1, 0.7).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    require.NoError(t, outcomes.Record(context.Background(), pred))
+
+    mock.ExpectExec("UPDATE prediction_outcomes o SET actual_value").
+        WithArgs(now.Add(-outcomeHorizon), outcomeHorizon.Seconds(), outcomeSource).
+        WillReturnResult(sqlmock.NewResult(0, 3))
+    settled, err := outcomes.Settle(context.Background())
+    require.NoError(t, err)
+    assert.Equal(t, int64(3), settled)
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
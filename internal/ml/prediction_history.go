@@ -0,0 +1,200 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "math"
+    "time"
+
+    "github.com/lib/pq"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// DefaultPredictionTTL is how long a stored ensemble prediction is served
+// before the models have to be run again
+const DefaultPredictionTTL = 6 * time.Hour
+
+// StoredPrediction is an ensemble prediction kept for reuse until
+// ValidUntil
+type StoredPrediction struct {
+    ID         int64               `json:"id"`
+    Symbol     string              `json:"symbol"`
+    Prediction *EnsemblePrediction `json:"prediction"`
+    Conviction float64             `json:"conviction"`
+    CreatedAt  time.Time           `json:"created_at"`
+    ValidUntil time.Time           `json:"valid_until"`
+}
+
+// Conviction is how far to trust the direction of pred: its confidence,
+// scaled by the share of the ensemble's weight predicting the same
+// direction. Flat predictions have none.
+func Conviction(pred *EnsemblePrediction) float64 {
+    if pred == nil || pred.Combined == nil || pred.Combined.Predictions.Direction == 0 {
+        return 0
+    }
+    direction := math.Signbit(pred.Combined.Predictions.Direction)
+
+    var agreeing float64
+    for _, c := range pred.Components {
+        if c.Missing || c.Prediction == nil || c.Prediction.Predictions.Direction == 0 {
+            continue
+        }
+        if math.Signbit(c.Prediction.Predictions.Direction) == direction {
+            agreeing += c.Weight
+        }
+    }
+    return pred.Combined.Confidence * agreeing
+}
+
+// PredictionHistory stores ensemble predictions for their TTL
+type PredictionHistory struct {
+    db  *sql.DB
+    ttl time.Duration
+    now func() time.Time
+}
+
+func NewPredictionHistory(db *sql.DB, ttl time.Duration) *PredictionHistory {
+    if ttl <= 0 {
+        ttl = DefaultPredictionTTL
+    }
+    return &PredictionHistory{db: db, ttl: ttl, now: time.Now}
+}
+
+// Record stores pred, valid for the history's TTL from now
+func (h *PredictionHistory) Record(ctx context.Context, pred *EnsemblePrediction) (*StoredPrediction, error) {
+    body, err := json.Marshal(pred)
+    if err != nil {
+        return nil, err
+    }
+
+    now := h.now()
+    stored := &StoredPrediction{
+        Symbol:     pred.Symbol,
+        Prediction: pred,
+        Conviction: Conviction(pred),
+        CreatedAt:  now,
+        ValidUntil: now.Add(h.ttl),
+    }
+    query := `
+        INSERT INTO ensemble_predictions (symbol, prediction, created_at, valid_until)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id
+    `
+    if err := h.db.QueryRowContext(ctx, query,
+        stored.Symbol, body, stored.CreatedAt, stored.ValidUntil,
+    ).Scan(&stored.ID); err != nil {
+        return nil, fmt.Errorf("failed to store prediction: %w", err)
+    }
+    return stored, nil
+}
+
+// Latest returns the freshest prediction still valid of each of symbols
+// that has one
+func (h *PredictionHistory) Latest(ctx context.Context, symbols []string) (map[string]*StoredPrediction, error) {
+    latest := make(map[string]*StoredPrediction, len(symbols))
+    if len(symbols) == 0 {
+        return latest, nil
+    }
+
+    query := `
+        SELECT DISTINCT ON (symbol) id, symbol, prediction, created_at, valid_until
+        FROM ensemble_predictions
+        WHERE symbol = ANY($1) AND valid_until > $2
+        ORDER BY symbol, created_at DESC
+    `
+    rows, err := h.db.QueryContext(ctx, query, pq.Array(symbols), h.now())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var stored StoredPrediction
+        var body []byte
+        if err := rows.Scan(&stored.ID, &stored.Symbol, &body, &stored.CreatedAt, &stored.ValidUntil); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(body, &stored.Prediction); err != nil {
+            return nil, fmt.Errorf("failed to decode prediction %d: %w", stored.ID, err)
+        }
+        stored.Conviction = Conviction(stored.Prediction)
+        latest[stored.Symbol] = &stored
+    }
+    return latest, rows.Err()
+}
+
+// PredictionResolver finds the latest predictions of symbols, generating
+// the missing ones on request within the user's daily prediction limit
+type PredictionResolver struct {
+    history *PredictionHistory
+    predict func(ctx context.Context, symbol string) (*EnsemblePrediction, error)
+    usage   *UsageTracker
+    gate    *auth.FeatureGate
+}
+
+// NewPredictionResolver creates a resolver generating predictions with
+// predict, normally Ensemble.Predict, and counting them in usage
+func NewPredictionResolver(history *PredictionHistory,
+    predict func(ctx context.Context, symbol string) (*EnsemblePrediction, error),
+    usage *UsageTracker, gate *auth.FeatureGate) *PredictionResolver {
+    return &PredictionResolver{history: history, predict: predict, usage: usage, gate: gate}
+}
+
+// Resolve returns the latest valid prediction of each of symbols that has
+// one. With generate set, predictions are generated for the rest while
+// user has predictions left today; quotaExhausted reports that some were
+// left out because the limit was reached. Symbols that can't be predicted
+// are left out rather than failing the rest.
+func (r *PredictionResolver) Resolve(ctx context.Context, user *models.User, symbols []string, generate bool) (
+    predictions map[string]*StoredPrediction, quotaExhausted bool, err error) {
+    predictions, err = r.history.Latest(ctx, symbols)
+    if err != nil {
+        return nil, false, err
+    }
+    if !generate {
+        return predictions, false, nil
+    }
+
+    used, err := r.usage.PredictionsToday(ctx, user.ID)
+    if err != nil {
+        return nil, false, err
+    }
+    for _, symbol := range symbols {
+        if predictions[symbol] != nil {
+            continue
+        }
+        if r.gate.CheckLimit(user, auth.LimitDailyPredictions, used) != nil {
+            return predictions, true, nil
+        }
+
+        pred, err := r.predict(ctx, symbol)
+        if err != nil {
+            logger.FromContext(ctx).Warnf("Failed to generate prediction for %s: %v", symbol, err)
+            continue
+        }
+        // Counted like an interactive ensemble prediction, one per member
+        // that answered
+        var succeeded int
+        for _, c := range pred.Components {
+            if !c.Missing {
+                succeeded++
+            }
+        }
+        used += succeeded
+        if err := r.usage.RecordPredictions(ctx, succeeded); err != nil {
+            logger.FromContext(ctx).Errorf("Failed to record %d predictions: %v", succeeded, err)
+        }
+
+        stored, err := r.history.Record(ctx, pred)
+        if err != nil {
+            return nil, false, err
+        }
+        predictions[symbol] = stored
+    }
+    return predictions, false, nil
+}
@@ -0,0 +1,117 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/lib/pq"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func ensembleOf(symbol string, confidence float64, directions ...float64) *EnsemblePrediction {
+    pred := &EnsemblePrediction{Symbol: symbol, Combined: &PredictionResponse{Symbol: symbol, Confidence: confidence}}
+    for _, d := range directions {
+        member := &PredictionResponse{Symbol: symbol}
+        member.Predictions.Direction = d
+        pred.Components = append(pred.Components, EnsembleMember{Weight: 1 / float64(len(directions)), Prediction: member})
+        pred.Combined.Predictions.Direction += d / float64(len(directions))
+    }
+    return pred
+}
+
+func TestConviction(t *testing.T) {
+    assert.InDelta(t, 0.8, Conviction(ensembleOf("BTC", 0.8, 1, 1)), 1e-9)
+    // Only the half of the weight agreeing with the combined direction
+    assert.InDelta(t, 0.4, Conviction(ensembleOf("BTC", 0.8, 1, 0.5, -0.2, -0.1)), 1e-9)
+    assert.Zero(t, Conviction(ensembleOf("BTC", 0.8, 1, -1)))
+    assert.Zero(t, Conviction(nil))
+}
+
+var storedColumns = []string{"id", "symbol", "prediction", "created_at", "valid_until"}
+
+func TestPredictionResolver_Resolve(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 15, 0, 0, 0, time.UTC)
+    today := time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC)
+    gate := auth.NewFeatureGate(auth.DefaultTiers())
+    user := &models.User{ID: uuid.New(), SubscriptionTier: auth.TierFree}
+    ctx := context.WithValue(context.Background(), "user", user)
+
+    setup := func(t *testing.T, predict func(ctx context.Context, symbol string) (*EnsemblePrediction, error)) (*PredictionResolver, sqlmock.Sqlmock) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        t.Cleanup(func() { db.Close() })
+
+        history := NewPredictionHistory(db, time.Hour)
+        history.now = func() time.Time { return now }
+        usage := NewUsageTracker(db)
+        usage.now = func() time.Time { return now }
+        return NewPredictionResolver(history, predict, usage, gate), mock
+    }
+    btc, _ := json.Marshal(ensembleOf("BTC", 0.7, 1, 1))
+
+    t.Run("Stored predictions only", func(t *testing.T) {
+        resolver, mock := setup(t, nil)
+        mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) (.+) FROM ensemble_predictions").
+            WithArgs(pq.Array([]string{"BTC", "ETH"}), now).
+            WillReturnRows(sqlmock.NewRows(storedColumns).AddRow(4, "BTC", btc, now.Add(-time.Minute), now.Add(time.Hour)))
+
+        predictions, exhausted, err := resolver.Resolve(ctx, user, []string{"BTC", "ETH"}, false)
+        require.NoError(t, err)
+        assert.False(t, exhausted)
+        require.Contains(t, predictions, "BTC")
+        assert.NotContains(t, predictions, "ETH")
+        assert.Equal(t, int64(4), predictions["BTC"].ID)
+        assert.InDelta(t, 0.7, predictions["BTC"].Conviction, 1e-9)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Missing predictions are generated within the daily limit", func(t *testing.T) {
+        var generated []string
+        resolver, mock := setup(t, func(ctx context.Context, symbol string) (*EnsemblePrediction, error) {
+            generated = append(generated, symbol)
+            if symbol == "DOGE" {
+                return nil, errors.New("no active model for DOGE")
+            }
+            return ensembleOf(symbol, 0.6, 1, 1), nil
+        })
+        mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) (.+) FROM ensemble_predictions").
+            WillReturnRows(sqlmock.NewRows(storedColumns).AddRow(4, "BTC", btc, now.Add(-time.Minute), now.Add(time.Hour)))
+        mock.ExpectQuery("SELECT count FROM prediction_usage").
+            WithArgs(user.ID, today).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(17))
+        mock.ExpectExec("INSERT INTO prediction_usage").
+            WithArgs(user.ID, today, 2).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectQuery("INSERT INTO ensemble_predictions").
+            WithArgs("ETH", sqlmock.AnyArg(), now, now.Add(time.Hour)).
+            WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+        mock.ExpectExec("INSERT INTO prediction_usage").
+            WithArgs(user.ID, today, 2).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectQuery("INSERT INTO ensemble_predictions").
+            WithArgs("SOL", sqlmock.AnyArg(), now, now.Add(time.Hour)).
+            WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+
+        // 17 of 20 used: DOGE fails uncounted, ETH and SOL take it to 21,
+        // leaving nothing for ADA
+        predictions, exhausted, err := resolver.Resolve(ctx, user, []string{"BTC", "DOGE", "ETH", "SOL", "ADA"}, true)
+        require.NoError(t, err)
+        assert.True(t, exhausted)
+        assert.Equal(t, []string{"DOGE", "ETH", "SOL"}, generated)
+        assert.Equal(t, int64(5), predictions["ETH"].ID)
+        assert.Equal(t, now.Add(time.Hour), predictions["SOL"].ValidUntil)
+        assert.NotContains(t, predictions, "DOGE")
+        assert.NotContains(t, predictions, "ADA")
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
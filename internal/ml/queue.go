@@ -0,0 +1,317 @@
+package ml
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// BulkRetryAfter is how long bulk submitters turned away by backpressure
+// are told to wait before retrying
+const BulkRetryAfter = 30 * time.Second
+
+var (
+    ErrQueueFull = errors.New("prediction queue is full")
+    // ErrQueueBackpressure turns away bulk work while the bulk lane is at
+    // its bound
+    ErrQueueBackpressure = errors.New("too much bulk prediction work queued")
+    // ErrPreempted fails queued bulk work whose place an interactive
+    // request took
+    ErrPreempted = fmt.Errorf("%w: preempted by interactive work", ErrQueueBackpressure)
+)
+
+// Priority is the lane a prediction request queues in. Callers set it on
+// the context with WithPriority; requests without one are interactive.
+type Priority int
+
+const (
+    // PriorityInteractive is for users waiting on the response
+    PriorityInteractive Priority = iota
+    // PriorityScheduled is for predictions generated on a schedule
+    PriorityScheduled
+    // PriorityBulk is for batch work, which waits behind everything else
+    PriorityBulk
+    numPriorities
+)
+
+func (p Priority) String() string {
+    switch p {
+    case PriorityScheduled:
+        return "scheduled"
+    case PriorityBulk:
+        return "bulk"
+    default:
+        return "interactive"
+    }
+}
+
+type priorityKey struct{}
+
+// WithPriority returns ctx carrying the priority its predictions queue at
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+    return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, or
+// PriorityInteractive
+func PriorityFromContext(ctx context.Context) Priority {
+    if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < numPriorities {
+        return p
+    }
+    return PriorityInteractive
+}
+
+// QueueConfig sizes a PredictionQueue's lanes
+type QueueConfig struct {
+    // Capacity bounds the requests queued across all priorities
+    Capacity int
+    // InteractiveShare is the fraction of workers scheduled and bulk work
+    // is kept off, so interactive requests always find one free. A single
+    // worker is never reserved.
+    InteractiveShare float64
+    // BulkCapacity bounds the queued bulk requests; past it bulk
+    // submitters get ErrQueueBackpressure. Zero leaves only Capacity.
+    BulkCapacity int
+}
+
+type predictionResult struct {
+    resp *PredictionResponse
+    err  error
+}
+
+type predictionJob struct {
+    ctx      context.Context
+    req      *PredictionRequest
+    priority Priority
+    queuedAt time.Time
+    result   chan predictionResult
+}
+
+// PredictionQueue buffers prediction requests for a pool of workers, so
+// bursts are absorbed instead of running every model process at once.
+// Requests queue in a lane per priority. Workers take interactive requests
+// first, then scheduled, then bulk, and leave InteractiveShare of
+// themselves to interactive requests even while the other lanes are full.
+type PredictionQueue struct {
+    cfg     QueueConfig
+    predict func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error)
+    now     func() time.Time
+
+    mu      sync.Mutex
+    lanes   [numPriorities][]*predictionJob
+    running [numPriorities]int
+    workers int
+    // ready wakes a worker when a job may be dispatchable. Each worker that
+    // takes a job passes the signal on while more are.
+    ready chan struct{}
+
+    depthGauge prometheus.Gauge
+    laneDepth  *prometheus.GaugeVec
+    waitTime   *prometheus.HistogramVec
+}
+
+// NewPredictionQueue creates a queue with the lanes cfg describes. Its
+// metrics are registered with reg when reg is non-nil.
+func NewPredictionQueue(service *Service, cfg QueueConfig, reg prometheus.Registerer) *PredictionQueue {
+    q := newPredictionQueue(service.Predict, cfg)
+    if reg != nil {
+        reg.MustRegister(q.depthGauge, q.laneDepth, q.waitTime)
+    }
+    return q
+}
+
+func newPredictionQueue(predict func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error), cfg QueueConfig) *PredictionQueue {
+    return &PredictionQueue{
+        cfg:     cfg,
+        predict: predict,
+        now:     time.Now,
+        ready:   make(chan struct{}, 1),
+        depthGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+            Name: "ml_prediction_queue_depth",
+            Help: "Number of prediction requests waiting for a worker",
+        }),
+        laneDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+            Name: "ml_prediction_queue_priority_depth",
+            Help: "Number of prediction requests waiting for a worker, by priority",
+        }, []string{"priority"}),
+        waitTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "ml_prediction_queue_wait_seconds",
+            Help:    "Time prediction requests waited for a worker, by priority",
+            Buckets: []float64{.005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+        }, []string{"priority"}),
+    }
+}
+
+// Depth returns the number of requests waiting for a worker
+func (q *PredictionQueue) Depth() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.depthLocked()
+}
+
+func (q *PredictionQueue) depthLocked() int {
+    depth := 0
+    for _, lane := range q.lanes {
+        depth += len(lane)
+    }
+    return depth
+}
+
+// Backpressured reports whether submitting at priority would be turned
+// away with ErrQueueBackpressure
+func (q *PredictionQueue) Backpressured(priority Priority) bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return priority == PriorityBulk && q.cfg.BulkCapacity > 0 && len(q.lanes[PriorityBulk]) >= q.cfg.BulkCapacity
+}
+
+// Submit queues req at the context's priority and waits for a worker to
+// run it. It fails fast rather than blocking: with ErrQueueFull when the
+// queue is at capacity, or ErrQueueBackpressure for bulk work when the
+// bulk lane is. An interactive request arriving at capacity takes the
+// place of the newest queued bulk request, which fails with ErrPreempted.
+func (q *PredictionQueue) Submit(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+    job := &predictionJob{
+        ctx:      ctx,
+        req:      req,
+        priority: PriorityFromContext(ctx),
+        queuedAt: q.now(),
+        result:   make(chan predictionResult, 1),
+    }
+    if err := q.enqueue(job); err != nil {
+        return nil, err
+    }
+    q.signal()
+
+    select {
+    case res := <-job.result:
+        return res.resp, res.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+func (q *PredictionQueue) enqueue(job *predictionJob) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if job.priority == PriorityBulk && q.cfg.BulkCapacity > 0 && len(q.lanes[PriorityBulk]) >= q.cfg.BulkCapacity {
+        return ErrQueueBackpressure
+    }
+    if q.depthLocked() >= q.cfg.Capacity {
+        bulk := q.lanes[PriorityBulk]
+        if job.priority != PriorityInteractive || len(bulk) == 0 {
+            return ErrQueueFull
+        }
+        preempted := bulk[len(bulk)-1]
+        q.lanes[PriorityBulk] = bulk[:len(bulk)-1]
+        preempted.result <- predictionResult{err: ErrPreempted}
+    }
+    q.lanes[job.priority] = append(q.lanes[job.priority], job)
+    q.updateDepthLocked()
+    return nil
+}
+
+// signal wakes a worker, unless one is already due to wake
+func (q *PredictionQueue) signal() {
+    select {
+    case q.ready <- struct{}{}:
+    default:
+    }
+}
+
+// setWorkers tells the queue how many workers it has, which sizes the
+// share kept for interactive requests
+func (q *PredictionQueue) setWorkers(n int) {
+    q.mu.Lock()
+    q.workers = n
+    q.mu.Unlock()
+    q.signal()
+}
+
+// next takes the job a worker should run next, or nil if none may run now
+func (q *PredictionQueue) next() *predictionJob {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    job := q.takeLocked()
+    if job == nil {
+        return nil
+    }
+    // Pass the wake-up on while other idle workers have work
+    if q.dispatchableLocked() {
+        q.signal()
+    }
+    return job
+}
+
+func (q *PredictionQueue) takeLocked() *predictionJob {
+    for p := PriorityInteractive; p < numPriorities; p++ {
+        if len(q.lanes[p]) == 0 {
+            continue
+        }
+        if p != PriorityInteractive && !q.sharedFreeLocked() {
+            return nil
+        }
+        job := q.lanes[p][0]
+        q.lanes[p][0] = nil
+        q.lanes[p] = q.lanes[p][1:]
+        q.running[p]++
+        q.updateDepthLocked()
+        q.waitTime.WithLabelValues(p.String()).Observe(q.now().Sub(job.queuedAt).Seconds())
+        return job
+    }
+    return nil
+}
+
+func (q *PredictionQueue) dispatchableLocked() bool {
+    if len(q.lanes[PriorityInteractive]) > 0 {
+        return true
+    }
+    return q.sharedFreeLocked() && (len(q.lanes[PriorityScheduled]) > 0 || len(q.lanes[PriorityBulk]) > 0)
+}
+
+// sharedFreeLocked reports whether scheduled or bulk work may take another
+// worker without eating into the interactive share
+func (q *PredictionQueue) sharedFreeLocked() bool {
+    workers := q.workers
+    if workers < 1 {
+        workers = 1
+    }
+    shared := workers - int(math.Ceil(q.cfg.InteractiveShare*float64(workers)))
+    if shared < 1 {
+        shared = 1
+    }
+    return q.running[PriorityScheduled]+q.running[PriorityBulk] < shared
+}
+
+func (q *PredictionQueue) updateDepthLocked() {
+    q.depthGauge.Set(float64(q.depthLocked()))
+    for p := PriorityInteractive; p < numPriorities; p++ {
+        q.laneDepth.WithLabelValues(p.String()).Set(float64(len(q.lanes[p])))
+    }
+}
+
+// process runs one job, skipping it if the caller has already gone away
+func (q *PredictionQueue) process(job *predictionJob) {
+    defer func() {
+        q.mu.Lock()
+        q.running[job.priority]--
+        q.mu.Unlock()
+        // The worker freed may be one queued work was held back from
+        q.signal()
+    }()
+
+    if err := job.ctx.Err(); err != nil {
+        job.result <- predictionResult{err: err}
+        return
+    }
+
+    resp, err := q.predict(job.ctx, job.req)
+    job.result <- predictionResult{resp: resp, err: err}
+}
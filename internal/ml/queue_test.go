@@ -0,0 +1,160 @@
+package ml
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func queuedJob(priority Priority) *predictionJob {
+    return &predictionJob{
+        ctx:      WithPriority(context.Background(), priority),
+        req:      &PredictionRequest{Symbol: "BTC"},
+        priority: priority,
+        result:   make(chan predictionResult, 1),
+    }
+}
+
+func TestPredictionQueue_InteractiveShare(t *testing.T) {
+    queue := newPredictionQueue(nil, QueueConfig{Capacity: 10, InteractiveShare: 0.5})
+    queue.setWorkers(4)
+    for i := 0; i < 5; i++ {
+        require.NoError(t, queue.enqueue(queuedJob(PriorityBulk)))
+    }
+    require.NoError(t, queue.enqueue(queuedJob(PriorityScheduled)))
+
+    // Half the four workers are kept for interactive work, and scheduled
+    // work goes ahead of bulk
+    first, second := queue.next(), queue.next()
+    require.NotNil(t, first)
+    require.NotNil(t, second)
+    assert.Equal(t, PriorityScheduled, first.priority)
+    assert.Equal(t, PriorityBulk, second.priority)
+    assert.Nil(t, queue.next())
+
+    require.NoError(t, queue.enqueue(queuedJob(PriorityInteractive)))
+    job := queue.next()
+    require.NotNil(t, job)
+    assert.Equal(t, PriorityInteractive, job.priority)
+
+    assert.Equal(t, 4.0, testutil.ToFloat64(queue.laneDepth.WithLabelValues("bulk")))
+    assert.Equal(t, 0.0, testutil.ToFloat64(queue.laneDepth.WithLabelValues("interactive")))
+    assert.Equal(t, 4.0, testutil.ToFloat64(queue.depthGauge))
+    assert.Equal(t, 3, testutil.CollectAndCount(queue.waitTime))
+}
+
+func TestPredictionQueue_Preemption(t *testing.T) {
+    queue := newPredictionQueue(nil, QueueConfig{Capacity: 2})
+    older, newer := queuedJob(PriorityBulk), queuedJob(PriorityBulk)
+    require.NoError(t, queue.enqueue(older))
+    require.NoError(t, queue.enqueue(newer))
+
+    // Only interactive work takes the place of queued bulk work
+    assert.ErrorIs(t, queue.enqueue(queuedJob(PriorityScheduled)), ErrQueueFull)
+    require.NoError(t, queue.enqueue(queuedJob(PriorityInteractive)))
+
+    select {
+    case res := <-newer.result:
+        assert.ErrorIs(t, res.err, ErrPreempted)
+        assert.ErrorIs(t, res.err, ErrQueueBackpressure)
+    default:
+        t.Fatal("newest bulk job was not preempted")
+    }
+    assert.Empty(t, older.result)
+    assert.Equal(t, 2, queue.Depth())
+
+    // With no bulk work left to preempt interactive work is turned away too
+    queue.lanes[PriorityBulk] = nil
+    require.NoError(t, queue.enqueue(queuedJob(PriorityInteractive)))
+    assert.ErrorIs(t, queue.enqueue(queuedJob(PriorityInteractive)), ErrQueueFull)
+}
+
+func TestPredictionQueue_BulkBackpressure(t *testing.T) {
+    queue := newPredictionQueue(nil, QueueConfig{Capacity: 10, BulkCapacity: 1})
+    require.NoError(t, queue.enqueue(queuedJob(PriorityBulk)))
+
+    assert.True(t, queue.Backpressured(PriorityBulk))
+    assert.False(t, queue.Backpressured(PriorityInteractive))
+
+    _, err := queue.Submit(WithPriority(context.Background(), PriorityBulk), &PredictionRequest{Symbol: "BTC"})
+    assert.ErrorIs(t, err, ErrQueueBackpressure)
+    require.NoError(t, queue.enqueue(queuedJob(PriorityScheduled)))
+}
+
+func TestPredictionQueue_InteractiveWaitUnderBulkLoad(t *testing.T) {
+    if testing.Short() {
+        t.Skip("load test")
+    }
+
+    const (
+        workers      = 4
+        modelLatency = 20 * time.Millisecond
+        bulkJobs     = 200
+        interactive  = 40
+    )
+
+    // A slow model the whole pool is kept busy with
+    queue := newPredictionQueue(func(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+        time.Sleep(modelLatency)
+        return &PredictionResponse{Symbol: req.Symbol}, nil
+    }, QueueConfig{Capacity: 500, InteractiveShare: 0.25, BulkCapacity: 400})
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go NewAutoScaler(prometheus.NewRegistry()).Run(ctx, queue, workers, workers, 1)
+
+    bulkCtx := WithPriority(ctx, PriorityBulk)
+    var bulk sync.WaitGroup
+    for i := 0; i < bulkJobs; i++ {
+        bulk.Add(1)
+        go func() {
+            defer bulk.Done()
+            queue.Submit(bulkCtx, &PredictionRequest{Symbol: "ETH"})
+        }()
+    }
+    require.Eventually(t, func() bool {
+        return queue.Depth() > bulkJobs/2
+    }, time.Second, time.Millisecond)
+
+    // Interactive requests arrive steadily while bulk work saturates the
+    // pool. Run through one FIFO lane, each would wait behind the whole
+    // bulk backlog: about bulkJobs*modelLatency/workers, a second.
+    var (
+        mu    sync.Mutex
+        waits []time.Duration
+        users sync.WaitGroup
+    )
+    for i := 0; i < interactive; i++ {
+        users.Add(1)
+        go func() {
+            defer users.Done()
+            start := time.Now()
+            _, err := queue.Submit(ctx, &PredictionRequest{Symbol: "BTC"})
+            assert.NoError(t, err)
+            mu.Lock()
+            waits = append(waits, time.Since(start)-modelLatency)
+            mu.Unlock()
+        }()
+        time.Sleep(modelLatency / 2)
+    }
+    users.Wait()
+
+    queue.mu.Lock()
+    backlog := len(queue.lanes[PriorityBulk])
+    queue.mu.Unlock()
+    assert.Positive(t, backlog, "bulk work should still saturate the pool")
+
+    sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+    p95 := waits[len(waits)*95/100]
+    assert.Less(t, p95, 5*modelLatency, "interactive p95 wait %v", p95)
+
+    cancel()
+    bulk.Wait()
+}
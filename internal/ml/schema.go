@@ -0,0 +1,135 @@
+package ml
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+)
+
+// schemaConfigKey is the ml_models.config key holding the feature schema
+const schemaConfigKey = "feature_schema"
+
+var (
+    ErrInvalidFeatureSchema = errors.New("invalid feature schema")
+    ErrModelNotFound        = errors.New("model not found")
+)
+
+// FeatureSpec describes one input feature. Min and Max are optional bounds.
+type FeatureSpec struct {
+    Name string   `json:"name"`
+    Min  *float64 `json:"min,omitempty"`
+    Max  *float64 `json:"max,omitempty"`
+}
+
+// FeatureSchema is the ordered list of features a model was trained on
+type FeatureSchema struct {
+    Features []FeatureSpec `json:"features"`
+}
+
+// FeatureMismatch describes one way a request violates a model's schema
+type FeatureMismatch struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// FeatureValidationError is returned when features don't match the schema
+// of the model they were sent to
+type FeatureValidationError struct {
+    Mismatches []FeatureMismatch
+}
+
+func (e *FeatureValidationError) Error() string {
+    if len(e.Mismatches) == 1 {
+        return fmt.Sprintf("%s: %s", e.Mismatches[0].Field, e.Mismatches[0].Message)
+    }
+    return fmt.Sprintf("%d feature mismatches", len(e.Mismatches))
+}
+
+// Check reports whether the schema itself is usable
+func (s *FeatureSchema) Check() error {
+    if len(s.Features) == 0 {
+        return fmt.Errorf("%w: at least one feature required", ErrInvalidFeatureSchema)
+    }
+
+    seen := make(map[string]bool, len(s.Features))
+    for i, f := range s.Features {
+        if f.Name == "" {
+            return fmt.Errorf("%w: feature %d has no name", ErrInvalidFeatureSchema, i)
+        }
+        if seen[f.Name] {
+            return fmt.Errorf("%w: duplicate feature %q", ErrInvalidFeatureSchema, f.Name)
+        }
+        seen[f.Name] = true
+        if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+            return fmt.Errorf("%w: feature %q has min above max", ErrInvalidFeatureSchema, f.Name)
+        }
+    }
+    return nil
+}
+
+// Validate returns every mismatch between features and the schema. field
+// prefixes the reported field names, e.g. "features" or "[3].features".
+func (s *FeatureSchema) Validate(field string, features []float64) []FeatureMismatch {
+    if len(features) != len(s.Features) {
+        return []FeatureMismatch{{
+            Field:   field,
+            Message: fmt.Sprintf("expected %d features, got %d", len(s.Features), len(features)),
+        }}
+    }
+
+    var mismatches []FeatureMismatch
+    for i, value := range features {
+        spec := s.Features[i]
+        name := fmt.Sprintf("%s[%d]", field, i)
+        switch {
+        case math.IsNaN(value) || math.IsInf(value, 0):
+            mismatches = append(mismatches, FeatureMismatch{name, fmt.Sprintf("%s must be a finite number", spec.Name)})
+        case spec.Min != nil && value < *spec.Min:
+            mismatches = append(mismatches, FeatureMismatch{name, fmt.Sprintf("%s must be at least %g, got %g", spec.Name, *spec.Min, value)})
+        case spec.Max != nil && value > *spec.Max:
+            mismatches = append(mismatches, FeatureMismatch{name, fmt.Sprintf("%s must be at most %g, got %g", spec.Name, *spec.Max, value)})
+        }
+    }
+    return mismatches
+}
+
+// schemaFromConfig extracts the feature schema from a model's config. Models
+// registered before schemas were required return nil.
+func schemaFromConfig(config json.RawMessage) (*FeatureSchema, error) {
+    if len(config) == 0 {
+        return nil, nil
+    }
+
+    var wrapper map[string]json.RawMessage
+    if err := json.Unmarshal(config, &wrapper); err != nil {
+        return nil, err
+    }
+    raw, ok := wrapper[schemaConfigKey]
+    if !ok || string(raw) == "null" {
+        return nil, nil
+    }
+
+    var schema FeatureSchema
+    if err := json.Unmarshal(raw, &schema); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidFeatureSchema, err)
+    }
+    return &schema, nil
+}
+
+// configWithSchema returns config with schema stored under schemaConfigKey
+func configWithSchema(config json.RawMessage, schema *FeatureSchema) (json.RawMessage, error) {
+    wrapper := make(map[string]json.RawMessage)
+    if len(config) > 0 {
+        if err := json.Unmarshal(config, &wrapper); err != nil {
+            return nil, fmt.Errorf("model config must be a JSON object: %w", err)
+        }
+    }
+
+    raw, err := json.Marshal(schema)
+    if err != nil {
+        return nil, err
+    }
+    wrapper[schemaConfigKey] = raw
+    return json.Marshal(wrapper)
+}
@@ -0,0 +1,123 @@
+package ml
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+func bound(v float64) *float64 {
+    return &v
+}
+
+func testSchema() *FeatureSchema {
+    return &FeatureSchema{Features: []FeatureSpec{
+        {Name: "return_1d"},
+        {Name: "rsi_14", Min: bound(0), Max: bound(100)},
+        {Name: "volume_z", Min: bound(-10), Max: bound(10)},
+    }}
+}
+
+func TestFeatureSchema_Validate(t *testing.T) {
+    schema := testSchema()
+
+    t.Run("Valid features", func(t *testing.T) {
+        assert.Empty(t, schema.Validate("features", []float64{0.01, 55, 1.2}))
+    })
+
+    t.Run("Wrong count", func(t *testing.T) {
+        mismatches := schema.Validate("features", []float64{0.01})
+        if !assert.Len(t, mismatches, 1) {
+            return
+        }
+        assert.Equal(t, "features", mismatches[0].Field)
+        assert.Contains(t, mismatches[0].Message, "expected 3 features, got 1")
+    })
+
+    t.Run("Every out of range feature is reported", func(t *testing.T) {
+        mismatches := schema.Validate("features", []float64{0.01, 140, -11})
+        if !assert.Len(t, mismatches, 2) {
+            return
+        }
+        assert.Equal(t, "features[1]", mismatches[0].Field)
+        assert.Contains(t, mismatches[0].Message, "rsi_14")
+        assert.Equal(t, "features[2]", mismatches[1].Field)
+    })
+}
+
+func TestFeatureSchema_Check(t *testing.T) {
+    assert.NoError(t, testSchema().Check())
+
+    empty := &FeatureSchema{}
+    assert.True(t, errors.Is(empty.Check(), ErrInvalidFeatureSchema))
+
+    duplicate := &FeatureSchema{Features: []FeatureSpec{{Name: "a"}, {Name: "a"}}}
+    assert.True(t, errors.Is(duplicate.Check(), ErrInvalidFeatureSchema))
+
+    inverted := &FeatureSchema{Features: []FeatureSpec{{Name: "a", Min: bound(1), Max: bound(0)}}}
+    assert.True(t, errors.Is(inverted.Check(), ErrInvalidFeatureSchema))
+}
+
+func TestModelManager_RegisterModelRequiresSchema(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    manager := NewModelManager(db)
+    err = manager.RegisterModel(context.Background(), ModelInfo{
+        Name: "lstm", Version: "1", Type: "lstm", Config: []byte(`{"layers": 2}`), Status: "active",
+    })
+    assert.True(t, errors.Is(err, ErrInvalidFeatureSchema))
+
+    mock.ExpectExec("INSERT INTO ml_models").
+        WithArgs("lstm", "1", "lstm", sqlmock.AnyArg(), "active", sqlmock.AnyArg()).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+
+    err = manager.RegisterModel(context.Background(), ModelInfo{
+        Name: "lstm", Version: "1", Type: "lstm", Config: []byte(`{"layers": 2}`), Status: "active",
+        Schema: testSchema(),
+    })
+    assert.NoError(t, err)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_ValidateBatch(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    config, err := configWithSchema(nil, testSchema())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // One lookup serves every row for the same model
+    mock.ExpectQuery("SELECT version, status, config FROM ml_models").
+        WithArgs("lstm").
+        WillReturnRows(sqlmock.NewRows([]string{"version", "status", "config"}).AddRow("1", "active", []byte(config)))
+
+    service := NewService(db, t.TempDir())
+    err = service.ValidateBatch(context.Background(), []PredictionRequest{
+        {Symbol: "BTC", ModelName: "lstm", Features: []float64{0.01, 55, 1.2}},
+        {Symbol: "ETH", ModelName: "lstm", Features: []float64{0.01, 55}},
+        {Symbol: "SPY", ModelName: "lstm", Features: []float64{0.01, 101, 1.2}},
+    })
+
+    var validationErr *FeatureValidationError
+    if !assert.True(t, errors.As(err, &validationErr)) {
+        return
+    }
+    if !assert.Len(t, validationErr.Mismatches, 2) {
+        return
+    }
+    assert.Equal(t, "[1].features", validationErr.Mismatches[0].Field)
+    assert.Equal(t, "[2].features[1]", validationErr.Mismatches[1].Field)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,19 +1,41 @@
 package ml
 
 import (
+    "bufio"
+    "bytes"
     "context"
     "database/sql"
     "encoding/json"
     "fmt"
+    "io"
+    "math/rand/v2"
     "os"
     "os/exec"
     "path/filepath"
+    "strings"
+    "sync"
     "time"
+
+    "github.com/go-redis/redis/v8"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml/artifacts"
 )
 
 type Service struct {
     db        *sql.DB
     modelPath string
+    events    *TrainingEventHub
+    logs      *redis.Client
+    artifacts artifacts.Store
+    cache     *artifacts.Cache
+    // lifetime bounds training jobs, which outlive the request starting
+    // them but are stopped when it is cancelled
+    lifetime context.Context
+    training sync.WaitGroup
+    // trainCommand builds the training process writing the model
+    // configured by configJSON to outputDir
+    trainCommand func(ctx context.Context, configJSON, outputDir string) *exec.Cmd
 }
 
 type PredictionRequest struct {
@@ -33,6 +55,8 @@ type PredictionResponse struct {
         Direction   float64 `json:"direction"`
     } `json:"predictions"`
     Confidence  float64 `json:"confidence"`
+    // Warning is set when the request pinned a version that isn't active
+    Warning     string  `json:"warning,omitempty"`
 }
 
 type TrainingConfig struct {
@@ -41,59 +65,195 @@ type TrainingConfig struct {
     DataConfig   json.RawMessage `json:"data_config"`
     ModelConfig  json.RawMessage `json:"model_config"`
     TrainConfig  json.RawMessage `json:"train_config"`
+    // Seed seeds every random choice the trainer makes, so retraining
+    // with it on the same data reproduces the model's metrics. StartTraining
+    // picks one if it isn't set.
+    Seed         *int64          `json:"seed,omitempty"`
 }
 
+// maxTrainingSeed keeps generated seeds exactly representable as JSON
+// numbers, for the trainer and for clients repeating a job
+const maxTrainingSeed = 1 << 53
+
+// trainingStatusTimeout bounds recording how a training job ended, which
+// happens even when the job was stopped by shutdown
+const trainingStatusTimeout = 5 * time.Second
+
 func NewService(db *sql.DB, modelPath string) *Service {
-    return &Service{
+    s := &Service{
         db:        db,
         modelPath: modelPath,
+        events:    NewTrainingEventHub(),
+        lifetime:  context.Background(),
     }
+    s.trainCommand = s.trainingCommand
+    return s
+}
+
+// WithLifetime runs training jobs until ctx is cancelled, such as at
+// shutdown. A job stopped this way is marked failed.
+func (s *Service) WithLifetime(ctx context.Context) *Service {
+    s.lifetime = ctx
+    return s
+}
+
+// Wait blocks until the running training jobs have ended, as they do soon
+// after the lifetime is cancelled
+func (s *Service) Wait() {
+    s.training.Wait()
 }
 
+// trainingCommand runs the Python trainer, terminating it when ctx is done
+func (s *Service) trainingCommand(ctx context.Context, configJSON, outputDir string) *exec.Cmd {
+    return lifecycle.Command(ctx, lifecycle.DefaultGracePeriod, "python",
+        filepath.Join(s.modelPath, "train.py"),
+        "--config", configJSON,
+        "--output", outputDir,
+    )
+}
+
+// Events returns the hub training job progress is published to
+func (s *Service) Events() *TrainingEventHub {
+    return s.events
+}
+
+// Predict validates req against the model's feature schema and runs it.
+// Requests may pin any version that isn't archived; pinning one other than
+// the active version adds a warning to the response.
 func (s *Service) Predict(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
-    // Get latest model version if not specified
-    if req.Version == "" {
-        query := `
-            SELECT version FROM ml_models 
-            WHERE name = $1 AND status = 'active'
-            ORDER BY created_at DESC LIMIT 1
-        `
-        err := s.db.QueryRowContext(ctx, query, req.ModelName).Scan(&req.Version)
-        if err != nil {
-            return nil, fmt.Errorf("failed to get latest model version: %v", err)
-        }
+    model, err := s.validate(ctx, req)
+    if err != nil {
+        return nil, err
     }
 
-    // Prepare input data
-    inputData := map[string]interface{}{
-        "features": req.Features,
+    resp, err := s.predict(ctx, req)
+    if err != nil {
+        return nil, err
     }
-    inputJSON, err := json.Marshal(inputData)
+    if model.status != StatusActive {
+        resp.Warning = fmt.Sprintf("%s@%s is %s; the active version may differ", req.ModelName, req.Version, model.status)
+    }
+    return resp, nil
+}
+
+// ValidateBatch checks every request against its model's schema before
+// any of them run, so one malformed row fails the batch without spawning
+// a model process. Requests whose model can't be resolved are left to fail
+// on their own when predicted.
+func (s *Service) ValidateBatch(ctx context.Context, reqs []PredictionRequest) error {
+    type modelKey struct{ name, version string }
+    type resolved struct {
+        model *resolvedModel
+        err   error
+    }
+    models := make(map[modelKey]resolved)
+
+    var mismatches []FeatureMismatch
+    for i := range reqs {
+        req := &reqs[i]
+        key := modelKey{req.ModelName, req.Version}
+        r, ok := models[key]
+        if !ok {
+            model, err := s.resolveModel(ctx, req.ModelName, req.Version)
+            r = resolved{model, err}
+            models[key] = r
+        }
+        if r.err != nil {
+            continue
+        }
+
+        req.Version = r.model.version
+        if r.model.schema != nil {
+            mismatches = append(mismatches, r.model.schema.Validate(fmt.Sprintf("[%d].features", i), req.Features)...)
+        }
+    }
+    if len(mismatches) > 0 {
+        return &FeatureValidationError{Mismatches: mismatches}
+    }
+    return nil
+}
+
+// Validate resolves req to a model version, filling in req.Version when it
+// is empty, and checks req.Features against that version's schema
+func (s *Service) Validate(ctx context.Context, req *PredictionRequest) error {
+    _, err := s.validate(ctx, req)
+    return err
+}
+
+func (s *Service) validate(ctx context.Context, req *PredictionRequest) (*resolvedModel, error) {
+    model, err := s.resolveModel(ctx, req.ModelName, req.Version)
     if err != nil {
         return nil, err
     }
+    req.Version = model.version
 
-    // Call Python prediction script
-    scriptPath := filepath.Join(s.modelPath, "predict.py")
-    modelPath := filepath.Join(s.modelPath, req.ModelName, req.Version)
+    if model.schema == nil {
+        return model, nil
+    }
+    if mismatches := model.schema.Validate("features", req.Features); len(mismatches) > 0 {
+        return nil, &FeatureValidationError{Mismatches: mismatches}
+    }
+    return model, nil
+}
 
-    cmd := exec.CommandContext(ctx, "python", scriptPath, 
-        "--model-path", modelPath,
-        "--input", string(inputJSON),
-    )
+// resolvedModel is the model version a request runs against. schema is nil
+// for models registered before schemas were required.
+type resolvedModel struct {
+    version string
+    status  string
+    schema  *FeatureSchema
+}
 
-    output, err := cmd.Output()
+// resolveModel looks up a model version, using the newest active version
+// when version is empty. Archived versions can't be used.
+func (s *Service) resolveModel(ctx context.Context, name, version string) (*resolvedModel, error) {
+    model := &resolvedModel{version: version}
+    var config json.RawMessage
+    var err error
+    if version == "" {
+        query := `
+            SELECT version, status, config FROM ml_models 
+            WHERE name = $1 AND status = 'active'
+            ORDER BY created_at DESC LIMIT 1
+        `
+        err = s.db.QueryRowContext(ctx, query, name).Scan(&model.version, &model.status, &config)
+    } else {
+        query := "SELECT status, config FROM ml_models WHERE name = $1 AND version = $2"
+        err = s.db.QueryRowContext(ctx, query, name, version).Scan(&model.status, &config)
+    }
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrModelNotFound, name)
+    }
     if err != nil {
-        return nil, fmt.Errorf("prediction failed: %v", err)
+        return nil, fmt.Errorf("failed to get model: %w", err)
+    }
+    if model.status == StatusArchived {
+        return nil, fmt.Errorf("%w: %s@%s", ErrModelArchived, name, model.version)
     }
 
-    var resp PredictionResponse
-    if err := json.Unmarshal(output, &resp); err != nil {
+    if model.schema, err = schemaFromConfig(config); err != nil {
         return nil, err
     }
+    return model, nil
+}
 
-    resp.Symbol = req.Symbol
-    resp.Timestamp = time.Now()
+// Evaluate validates req and runs it like Predict, without recording the
+// prediction. It is for scoring versions offline, whose predictions
+// aren't served.
+func (s *Service) Evaluate(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+    if _, err := s.validate(ctx, req); err != nil {
+        return nil, err
+    }
+    return s.run(ctx, req)
+}
+
+// predict runs the model process for a validated request and records the
+// prediction
+func (s *Service) predict(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+    resp, err := s.run(ctx, req)
+    if err != nil {
+        return nil, err
+    }
 
     // Save prediction to database
     query := `
@@ -117,10 +277,53 @@ func (s *Service) Predict(ctx context.Context, req *PredictionRequest) (*Predict
         return nil, fmt.Errorf("failed to save prediction: %v", err)
     }
 
+    return resp, nil
+}
+
+// run runs the model process for a validated request
+func (s *Service) run(ctx context.Context, req *PredictionRequest) (*PredictionResponse, error) {
+    // Prepare input data
+    inputData := map[string]interface{}{
+        "features": req.Features,
+    }
+    inputJSON, err := json.Marshal(inputData)
+    if err != nil {
+        return nil, err
+    }
+
+    // Call Python prediction script
+    scriptPath := filepath.Join(s.modelPath, "predict.py")
+    modelPath, err := s.modelDir(ctx, req.ModelName, req.Version)
+    if err != nil {
+        return nil, err
+    }
+
+    cmd := exec.CommandContext(ctx, "python", scriptPath, 
+        "--model-path", modelPath,
+        "--input", string(inputJSON),
+    )
+
+    output, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("prediction failed: %v", err)
+    }
+
+    var resp PredictionResponse
+    if err := json.Unmarshal(output, &resp); err != nil {
+        return nil, err
+    }
+
+    resp.Symbol = req.Symbol
+    resp.Timestamp = time.Now()
     return &resp, nil
 }
 
 func (s *Service) StartTraining(ctx context.Context, config *TrainingConfig) (int64, error) {
+    if config.Seed == nil {
+        seed := rand.Int64N(maxTrainingSeed)
+        config.Seed = &seed
+    }
+
     // Create training job record
     tx, err := s.db.BeginTx(ctx, nil)
     if err != nil {
@@ -131,25 +334,29 @@ func (s *Service) StartTraining(ctx context.Context, config *TrainingConfig) (in
     var jobID int64
     query := `
         INSERT INTO training_jobs (
-            model_id, status, config, started_at
+            model_id, status, config, seed, started_at
         ) VALUES (
             (SELECT id FROM ml_models WHERE name = $1 AND version = $2),
-            'pending', $3, $4
+            'pending', $3, $4, $5
         ) RETURNING id
     `
     configJSON, _ := json.Marshal(config)
     err = tx.QueryRowContext(ctx, query,
         config.ModelName, config.Version,
-        configJSON, time.Now(),
+        configJSON, *config.Seed, time.Now(),
     ).Scan(&jobID)
     if err != nil {
         return 0, fmt.Errorf("failed to create training job: %v", err)
     }
+    s.events.Publish(jobID, TrainingEvent{Type: EventStatus, Status: "pending"})
 
-    // Prepare training data and start training process
+    // Prepare training data and start training process. The job outlives
+    // the request, so it runs under the service's lifetime instead.
+    s.training.Add(1)
     go func() {
-        if err := s.runTraining(config, jobID); err != nil {
-            s.updateTrainingStatus(jobID, "failed", err.Error())
+        defer s.training.Done()
+        if err := s.runTraining(s.lifetime, config, jobID); err != nil {
+            s.updateTrainingStatus(s.lifetime, jobID, "failed", err.Error())
             return
         }
     }()
@@ -161,8 +368,7 @@ func (s *Service) StartTraining(ctx context.Context, config *TrainingConfig) (in
     return jobID, nil
 }
 
-func (s *Service) runTraining(config *TrainingConfig, jobID int64) error {
-    scriptPath := filepath.Join(s.modelPath, "train.py")
+func (s *Service) runTraining(ctx context.Context, config *TrainingConfig, jobID int64) error {
     modelPath := filepath.Join(s.modelPath, config.ModelName, config.Version)
 
     if err := os.MkdirAll(modelPath, 0755); err != nil {
@@ -170,26 +376,72 @@ func (s *Service) runTraining(config *TrainingConfig, jobID int64) error {
     }
 
     configJSON, _ := json.Marshal(config)
-    cmd := exec.Command("python", scriptPath,
-        "--config", string(configJSON),
-        "--output", modelPath,
-    )
+    cmd := s.trainCommand(ctx, string(configJSON), modelPath)
 
-    output, err := cmd.CombinedOutput()
+    stdout, err := cmd.StdoutPipe()
     if err != nil {
-        return fmt.Errorf("training failed: %v, output: %s", err, string(output))
+        return err
+    }
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+
+    if err := cmd.Start(); err != nil {
+        return fmt.Errorf("failed to start training: %v", err)
     }
+    s.setTrainingRunning(ctx, jobID)
 
-    return s.updateTrainingStatus(jobID, "completed", string(output))
+    // Publish each line as it is printed. Publishing never blocks, so
+    // training runs at full speed whether or not anyone is watching.
+    var output strings.Builder
+    scanner := bufio.NewScanner(stdout)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        line := scanner.Text()
+        output.WriteString(line)
+        output.WriteByte('\n')
+        s.events.Publish(jobID, parseTrainingLine(line))
+        s.publishLog(ctx, jobID, TrainingLogMessage{Type: LogMessageLine, Line: line})
+    }
+    // Keep draining if a line was too long, so the process can't stall on
+    // a full pipe
+    io.Copy(io.Discard, stdout)
+
+    err = cmd.Wait()
+    output.Write(stderr.Bytes())
+    if ctx.Err() != nil {
+        return fmt.Errorf("training stopped: %w, output: %s", ctx.Err(), output.String())
+    }
+    if err != nil {
+        return fmt.Errorf("training failed: %v, output: %s", err, output.String())
+    }
+
+    if err := s.uploadArtifact(ctx, config.ModelName, config.Version, modelPath); err != nil {
+        return err
+    }
+
+    return s.updateTrainingStatus(ctx, jobID, "completed", output.String())
 }
 
-func (s *Service) updateTrainingStatus(jobID int64, status, logs string) error {
+func (s *Service) setTrainingRunning(ctx context.Context, jobID int64) {
+    query := "UPDATE training_jobs SET status = 'running', updated_at = $1 WHERE id = $2"
+    s.db.ExecContext(ctx, query, time.Now(), jobID)
+    s.events.Publish(jobID, TrainingEvent{Type: EventStatus, Status: "running"})
+}
+
+// updateTrainingStatus records how a job ended. It is written even after
+// ctx is cancelled, as shutdown stopping the job is one way for it to end.
+func (s *Service) updateTrainingStatus(ctx context.Context, jobID int64, status, logs string) error {
+    ctx, cancel := lifecycle.Detach(ctx, trainingStatusTimeout)
+    defer cancel()
+
     query := `
         UPDATE training_jobs 
         SET status = $1, logs = $2, completed_at = $3, updated_at = $3
         WHERE id = $4
     `
-    _, err := s.db.Exec(query, status, logs, time.Now(), jobID)
+    _, err := s.db.ExecContext(ctx, query, status, logs, time.Now(), jobID)
+    s.events.Publish(jobID, TrainingEvent{Type: EventStatus, Status: status})
+    s.publishLog(ctx, jobID, TrainingLogMessage{Type: LogMessageDone, Status: status})
     return err
 }
 
@@ -0,0 +1,112 @@
+package ml
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "os/signal"
+    "syscall"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+)
+
+// TestHelperTrain stands in for train.py when run by fakeTrainer: it
+// reports an epoch, then trains until it is stopped. With
+// FAKE_TRAIN_IGNORE_TERM set it ignores SIGTERM, as a trainer stuck in
+// native code would.
+func TestHelperTrain(t *testing.T) {
+    if os.Getenv("FAKE_TRAIN") != "1" {
+        return
+    }
+    if os.Getenv("FAKE_TRAIN_IGNORE_TERM") == "1" {
+        signal.Ignore(syscall.SIGTERM)
+    }
+    fmt.Println("epoch=1 loss=0.5")
+    time.Sleep(time.Minute)
+    os.Exit(0)
+}
+
+// fakeTrainer returns a training command factory starting TestHelperTrain,
+// which is killed grace after SIGTERM
+func fakeTrainer(grace time.Duration, ignoreTerm bool) func(context.Context, string, string) *exec.Cmd {
+    return func(ctx context.Context, configJSON, outputDir string) *exec.Cmd {
+        cmd := lifecycle.Command(ctx, grace, os.Args[0], "-test.run=^TestHelperTrain$")
+        cmd.Env = append(os.Environ(), "FAKE_TRAIN=1")
+        if ignoreTerm {
+            cmd.Env = append(cmd.Env, "FAKE_TRAIN_IGNORE_TERM=1")
+        }
+        return cmd
+    }
+}
+
+func TestService_RunTraining_Stopped(t *testing.T) {
+    tests := []struct {
+        name       string
+        ignoreTerm bool
+    }{
+        {name: "Trainer exits on SIGTERM"},
+        {name: "Trainer ignoring SIGTERM is killed", ignoreTerm: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db, mock, err := sqlmock.New()
+            require.NoError(t, err)
+            defer db.Close()
+
+            const jobID = 7
+            mock.ExpectExec("UPDATE training_jobs SET status = 'running'").
+                WithArgs(sqlmock.AnyArg(), jobID).
+                WillReturnResult(sqlmock.NewResult(0, 1))
+            mock.ExpectExec("UPDATE training_jobs").
+                WithArgs("failed", sqlmock.AnyArg(), sqlmock.AnyArg(), jobID).
+                WillReturnResult(sqlmock.NewResult(0, 1))
+
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            s := NewService(db, t.TempDir()).WithLifetime(ctx)
+            s.trainCommand = fakeTrainer(200*time.Millisecond, tt.ignoreTerm)
+
+            done := make(chan error, 1)
+            go func() {
+                err := s.runTraining(ctx, &TrainingConfig{ModelName: "lstm", Version: "2"}, jobID)
+                if err != nil {
+                    // As StartTraining does
+                    s.updateTrainingStatus(ctx, jobID, "failed", err.Error())
+                }
+                done <- err
+            }()
+
+            // Wait for the trainer to report its epoch
+            require.Eventually(t, func() bool {
+                replay, live, ok := s.Events().Subscribe(jobID, 0)
+                if !ok {
+                    return false
+                }
+                s.Events().Unsubscribe(jobID, live)
+                for _, event := range replay {
+                    if event.Type == EventMetrics {
+                        return true
+                    }
+                }
+                return false
+            }, 10*time.Second, 10*time.Millisecond)
+
+            cancel()
+            select {
+            case err := <-done:
+                assert.ErrorIs(t, err, context.Canceled)
+            case <-time.After(5 * time.Second):
+                t.Fatal("training outlived its context")
+            }
+            // The job is marked failed even though its context is done
+            assert.NoError(t, mock.ExpectationsWereMet())
+        })
+    }
+}
@@ -0,0 +1,319 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/mail"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    // subscriptionClaimLease keeps other instances off claimed
+    // subscriptions; those whose run was interrupted are retried once it
+    // runs out
+    subscriptionClaimLease = 15 * time.Minute
+    // subscriptionRetryBase is the wait after a first failure, doubled with
+    // every further one up to the cadence
+    subscriptionRetryBase = time.Hour
+)
+
+// PredictionGenerator generates the prediction of symbol over timeframe
+type PredictionGenerator func(ctx context.Context, symbol, timeframe string) (*EnsemblePrediction, error)
+
+// ScheduledPredictionMeter counts scheduled predictions, as for billing
+type ScheduledPredictionMeter interface {
+    CountScheduledPredictions(ctx context.Context, userID uuid.UUID, n int64)
+}
+
+// SubscriptionConfig tunes the SubscriptionScheduler
+type SubscriptionConfig struct {
+    // BatchSize is how many due subscriptions a run regenerates
+    BatchSize int
+    // OffPeakStartHour and OffPeakEndHour bound the UTC hours runs
+    // regenerate in. An end before the start wraps past midnight, and equal
+    // hours allow any hour.
+    OffPeakStartHour int
+    OffPeakEndHour   int
+    // NotifyAfter is how many failures in a row the user is emailed after
+    NotifyAfter int
+    // ManageURL is where notification emails send users to manage their
+    // subscriptions
+    ManageURL string
+}
+
+// SubscriptionScheduler regenerates the predictions of due subscriptions,
+// run as a scheduled job. Subscriptions to the same symbol and timeframe
+// share one generation per run, so popular symbols cost one prediction
+// however many users follow them, while each user is still charged theirs
+// against the daily scheduled prediction quota of their tier.
+type SubscriptionScheduler struct {
+    db       *sql.DB
+    generate PredictionGenerator
+    gate     *auth.FeatureGate
+    mailer   mail.Mailer
+    meter    ScheduledPredictionMeter
+    cfg      SubscriptionConfig
+    now      func() time.Time
+}
+
+func NewSubscriptionScheduler(db *sql.DB, generate PredictionGenerator, gate *auth.FeatureGate, cfg SubscriptionConfig) *SubscriptionScheduler {
+    return &SubscriptionScheduler{
+        db:       db,
+        generate: generate,
+        gate:     gate,
+        cfg:      cfg,
+        now:      time.Now,
+    }
+}
+
+// WithMailer emails users whose subscriptions keep failing
+func (s *SubscriptionScheduler) WithMailer(mailer mail.Mailer) *SubscriptionScheduler {
+    s.mailer = mailer
+    return s
+}
+
+// WithMeter reports every scheduled prediction to meter
+func (s *SubscriptionScheduler) WithMeter(meter ScheduledPredictionMeter) *SubscriptionScheduler {
+    s.meter = meter
+    return s
+}
+
+// dueSubscription is a claimed subscription with what its run needs of
+// the user
+type dueSubscription struct {
+    PredictionSubscription
+    user *models.User
+}
+
+type generationKey struct {
+    symbol    string
+    timeframe string
+}
+
+// offPeak reports whether regeneration may run at t
+func (s *SubscriptionScheduler) offPeak(t time.Time) bool {
+    start, end := s.cfg.OffPeakStartHour, s.cfg.OffPeakEndHour
+    hour := t.UTC().Hour()
+    switch {
+    case start == end:
+        return true
+    case start < end:
+        return hour >= start && hour < end
+    default:
+        return hour >= start || hour < end
+    }
+}
+
+// Run regenerates a batch of due subscriptions and returns how many
+// predictions were stored. Outside the off-peak hours it does nothing.
+func (s *SubscriptionScheduler) Run(ctx context.Context) (int, error) {
+    now := s.now()
+    if !s.offPeak(now) {
+        return 0, nil
+    }
+
+    due, err := s.claim(ctx, now)
+    if err != nil {
+        return 0, err
+    }
+
+    var keys []generationKey
+    groups := make(map[generationKey][]dueSubscription)
+    for _, sub := range due {
+        key := generationKey{sub.Symbol, sub.Timeframe}
+        if _, ok := groups[key]; !ok {
+            keys = append(keys, key)
+        }
+        groups[key] = append(groups[key], sub)
+    }
+
+    // Scheduled predictions each user was generated today, read once per
+    // run
+    used := make(map[uuid.UUID]int)
+    stored := 0
+    for _, key := range keys {
+        if ctx.Err() != nil {
+            return stored, ctx.Err()
+        }
+
+        var eligible []dueSubscription
+        for _, sub := range groups[key] {
+            allowed, err := s.withinQuota(ctx, sub, used, now)
+            if err != nil {
+                return stored, err
+            }
+            if allowed {
+                eligible = append(eligible, sub)
+            }
+        }
+        if len(eligible) == 0 {
+            continue
+        }
+
+        // Queued behind interactive requests, ahead of bulk work
+        prediction, genErr := s.generate(WithPriority(ctx, PriorityScheduled), key.symbol, key.timeframe)
+        for _, sub := range eligible {
+            if genErr != nil {
+                if err := s.recordFailure(ctx, sub, genErr, now); err != nil {
+                    return stored, err
+                }
+                continue
+            }
+            if err := s.store(ctx, sub, prediction, now); err != nil {
+                return stored, err
+            }
+            used[sub.UserID]++
+            stored++
+            if s.meter != nil {
+                s.meter.CountScheduledPredictions(ctx, sub.UserID, 1)
+            }
+        }
+    }
+    return stored, nil
+}
+
+func (s *SubscriptionScheduler) claim(ctx context.Context, now time.Time) ([]dueSubscription, error) {
+    rows, err := s.db.QueryContext(ctx, `
+        UPDATE prediction_subscriptions s SET next_run_at = $1
+        FROM users u
+        WHERE u.id = s.user_id AND s.id IN (
+            SELECT id FROM prediction_subscriptions
+            WHERE next_run_at <= $2
+            ORDER BY next_run_at
+            LIMIT $3
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING s.id, s.user_id, s.symbol, s.timeframe, s.cadence, s.failures,
+                  u.email, u.name, COALESCE(u.subscription_tier, '')`,
+        now.Add(subscriptionClaimLease), now, s.cfg.BatchSize)
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim prediction subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    var due []dueSubscription
+    for rows.Next() {
+        sub := dueSubscription{user: &models.User{}}
+        if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Symbol, &sub.Timeframe, &sub.Cadence, &sub.Failures,
+            &sub.user.Email, &sub.user.Name, &sub.user.SubscriptionTier); err != nil {
+            return nil, err
+        }
+        sub.user.ID = sub.UserID
+        due = append(due, sub)
+    }
+    return due, rows.Err()
+}
+
+// withinQuota reports whether sub's user has scheduled predictions left
+// today. Subscriptions of users who don't are put off until the quota
+// resets, without counting as a failure.
+func (s *SubscriptionScheduler) withinQuota(ctx context.Context, sub dueSubscription, used map[uuid.UUID]int, now time.Time) (bool, error) {
+    today := now.UTC().Truncate(24 * time.Hour)
+    count, ok := used[sub.UserID]
+    if !ok {
+        err := s.db.QueryRowContext(ctx,
+            "SELECT COUNT(*) FROM scheduled_predictions WHERE user_id = $1 AND created_at >= $2",
+            sub.UserID, today,
+        ).Scan(&count)
+        if err != nil {
+            return false, fmt.Errorf("failed to count scheduled predictions: %w", err)
+        }
+        used[sub.UserID] = count
+    }
+
+    if s.gate.CheckLimit(sub.user, auth.LimitDailyScheduledPredictions, count) == nil {
+        return true, nil
+    }
+    _, err := s.db.ExecContext(ctx,
+        "UPDATE prediction_subscriptions SET next_run_at = $1 WHERE id = $2",
+        today.Add(24*time.Hour), sub.ID)
+    if err != nil {
+        return false, fmt.Errorf("failed to defer prediction subscription %d: %w", sub.ID, err)
+    }
+    return false, nil
+}
+
+// store records prediction for sub and schedules its next run a cadence
+// from now
+func (s *SubscriptionScheduler) store(ctx context.Context, sub dueSubscription, prediction *EnsemblePrediction, now time.Time) error {
+    data, err := json.Marshal(prediction)
+    if err != nil {
+        return fmt.Errorf("failed to encode prediction for subscription %d: %w", sub.ID, err)
+    }
+    _, err = s.db.ExecContext(ctx, `
+        INSERT INTO scheduled_predictions (subscription_id, user_id, symbol, timeframe, origin, prediction, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+        sub.ID, sub.UserID, sub.Symbol, sub.Timeframe, OriginScheduled, data, now)
+    if err != nil {
+        return fmt.Errorf("failed to store prediction for subscription %d: %w", sub.ID, err)
+    }
+
+    period, _ := cadencePeriod(sub.Cadence)
+    _, err = s.db.ExecContext(ctx, `
+        UPDATE prediction_subscriptions
+        SET next_run_at = $1, failures = 0, last_error = NULL, notified_at = NULL
+        WHERE id = $2`,
+        now.Add(period), sub.ID)
+    if err != nil {
+        return fmt.Errorf("failed to reschedule prediction subscription %d: %w", sub.ID, err)
+    }
+    return nil
+}
+
+// recordFailure backs sub off after a failed generation, emailing the user
+// once it has failed NotifyAfter times in a row
+func (s *SubscriptionScheduler) recordFailure(ctx context.Context, sub dueSubscription, genErr error, now time.Time) error {
+    failures := sub.Failures + 1
+    notify := s.mailer != nil && s.cfg.NotifyAfter > 0 && failures == s.cfg.NotifyAfter
+
+    var notifiedAt sql.NullTime
+    if notify {
+        notifiedAt = sql.NullTime{Time: now, Valid: true}
+    }
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE prediction_subscriptions
+        SET next_run_at = $1, failures = $2, last_error = $3, notified_at = COALESCE($4, notified_at)
+        WHERE id = $5`,
+        now.Add(subscriptionBackoff(failures, sub.Cadence)), failures, genErr.Error(), notifiedAt, sub.ID)
+    if err != nil {
+        return fmt.Errorf("failed to record failure of prediction subscription %d: %w", sub.ID, err)
+    }
+
+    if notify {
+        // The failure is recorded either way, so a lost email isn't retried
+        err := s.mailer.Send(ctx, sub.user.Email, mail.TemplatePredictionFailure, mail.PredictionFailureData{
+            Name:      sub.user.Name,
+            Symbol:    sub.Symbol,
+            Timeframe: sub.Timeframe,
+            Failures:  failures,
+            Error:     genErr.Error(),
+            Link:      s.cfg.ManageURL,
+        })
+        if err != nil {
+            logger.FromContext(ctx).Warnf("Failed to notify user %s of failing %s predictions: %v", sub.UserID, sub.Symbol, err)
+        }
+    }
+    return nil
+}
+
+// subscriptionBackoff doubles the wait after each failure in a row, from
+// an hour up to the cadence, so a failing subscription is still retried
+// at least as often as it would run
+func subscriptionBackoff(failures int, cadence string) time.Duration {
+    period, _ := cadencePeriod(cadence)
+    if failures > 16 {
+        return period
+    }
+    wait := subscriptionRetryBase << (failures - 1)
+    if wait > period {
+        return period
+    }
+    return wait
+}
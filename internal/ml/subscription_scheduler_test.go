@@ -0,0 +1,189 @@
+package ml
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/auth"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/mail"
+)
+
+type sentEmail struct {
+    to       string
+    template string
+    data     interface{}
+}
+
+type recordingMailer struct {
+    sent []sentEmail
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, template string, data interface{}) error {
+    m.sent = append(m.sent, sentEmail{to, template, data})
+    return nil
+}
+
+type countingScheduledMeter struct {
+    counts map[uuid.UUID]int64
+}
+
+func (m *countingScheduledMeter) CountScheduledPredictions(ctx context.Context, userID uuid.UUID, n int64) {
+    m.counts[userID] += n
+}
+
+var claimColumns = []string{"id", "user_id", "symbol", "timeframe", "cadence", "failures", "email", "name", "subscription_tier"}
+
+func TestSubscriptionScheduler_Run(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 3, 0, 0, 0, time.UTC)
+    today := time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC)
+    cfg := SubscriptionConfig{BatchSize: 10, OffPeakStartHour: 1, OffPeakEndHour: 5, NotifyAfter: 3, ManageURL: "https://wolfai.com/predictions/subscriptions"}
+    gate := auth.NewFeatureGate(auth.DefaultTiers())
+
+    setup := func(t *testing.T, generate PredictionGenerator) (*SubscriptionScheduler, sqlmock.Sqlmock) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        t.Cleanup(func() { db.Close() })
+
+        scheduler := NewSubscriptionScheduler(db, generate, gate, cfg)
+        scheduler.now = func() time.Time { return now }
+        return scheduler, mock
+    }
+
+    t.Run("Subscriptions to a symbol share one generation", func(t *testing.T) {
+        var calls []string
+        scheduler, mock := setup(t, func(ctx context.Context, symbol, timeframe string) (*EnsemblePrediction, error) {
+            calls = append(calls, symbol+"/"+timeframe)
+            return &EnsemblePrediction{Symbol: symbol}, nil
+        })
+        meter := &countingScheduledMeter{counts: make(map[uuid.UUID]int64)}
+        scheduler.WithMeter(meter)
+
+        ada, bob := uuid.New(), uuid.New()
+        mock.ExpectQuery("UPDATE prediction_subscriptions s SET next_run_at").
+            WithArgs(now.Add(subscriptionClaimLease), now, 10).
+            WillReturnRows(sqlmock.NewRows(claimColumns).
+                AddRow(1, ada, "BTC", "24h", CadenceDaily, 0, "ada@example.com", "Ada", auth.TierFree).
+                AddRow(2, bob, "BTC", "24h", CadenceWeekly, 0, "bob@example.com", "Bob", auth.TierPro))
+        mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scheduled_predictions").
+            WithArgs(ada, today).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+        mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scheduled_predictions").
+            WithArgs(bob, today).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+        mock.ExpectExec("INSERT INTO scheduled_predictions").
+            WithArgs(int64(1), ada, "BTC", "24h", OriginScheduled, sqlmock.AnyArg(), now).
+            WillReturnResult(sqlmock.NewResult(1, 1))
+        mock.ExpectExec("UPDATE prediction_subscriptions").
+            WithArgs(now.Add(24*time.Hour), int64(1)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO scheduled_predictions").
+            WithArgs(int64(2), bob, "BTC", "24h", OriginScheduled, sqlmock.AnyArg(), now).
+            WillReturnResult(sqlmock.NewResult(2, 1))
+        mock.ExpectExec("UPDATE prediction_subscriptions").
+            WithArgs(now.Add(7*24*time.Hour), int64(2)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        stored, err := scheduler.Run(context.Background())
+        require.NoError(t, err)
+        assert.Equal(t, 2, stored)
+        assert.Equal(t, []string{"BTC/24h"}, calls)
+        assert.Equal(t, map[uuid.UUID]int64{ada: 1, bob: 1}, meter.counts)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Users out of scheduled quota wait for tomorrow", func(t *testing.T) {
+        generated := false
+        scheduler, mock := setup(t, func(ctx context.Context, symbol, timeframe string) (*EnsemblePrediction, error) {
+            generated = true
+            return &EnsemblePrediction{Symbol: symbol}, nil
+        })
+
+        ada := uuid.New()
+        mock.ExpectQuery("UPDATE prediction_subscriptions s SET next_run_at").
+            WillReturnRows(sqlmock.NewRows(claimColumns).
+                AddRow(1, ada, "ETH", "7d", CadenceDaily, 0, "ada@example.com", "Ada", auth.TierFree))
+        mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scheduled_predictions").
+            WithArgs(ada, today).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(50))
+        mock.ExpectExec("UPDATE prediction_subscriptions SET next_run_at").
+            WithArgs(today.Add(24*time.Hour), int64(1)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        stored, err := scheduler.Run(context.Background())
+        require.NoError(t, err)
+        assert.Equal(t, 0, stored)
+        assert.False(t, generated)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Repeated failures back off and notify", func(t *testing.T) {
+        genErr := errors.New("no active model for DOGE")
+        scheduler, mock := setup(t, func(ctx context.Context, symbol, timeframe string) (*EnsemblePrediction, error) {
+            return nil, genErr
+        })
+        mailer := &recordingMailer{}
+        scheduler.WithMailer(mailer)
+
+        ada := uuid.New()
+        mock.ExpectQuery("UPDATE prediction_subscriptions s SET next_run_at").
+            WillReturnRows(sqlmock.NewRows(claimColumns).
+                AddRow(1, ada, "DOGE", "24h", CadenceDaily, 2, "ada@example.com", "Ada", auth.TierPro))
+        mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM scheduled_predictions").
+            WithArgs(ada, today).
+            WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+        mock.ExpectExec("UPDATE prediction_subscriptions").
+            WithArgs(now.Add(4*time.Hour), 3, genErr.Error(), sqlmock.AnyArg(), int64(1)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        stored, err := scheduler.Run(context.Background())
+        require.NoError(t, err)
+        assert.Equal(t, 0, stored)
+        assert.NoError(t, mock.ExpectationsWereMet())
+
+        if assert.Len(t, mailer.sent, 1) {
+            assert.Equal(t, "ada@example.com", mailer.sent[0].to)
+            assert.Equal(t, mail.TemplatePredictionFailure, mailer.sent[0].template)
+            assert.Equal(t, 3, mailer.sent[0].data.(mail.PredictionFailureData).Failures)
+        }
+    })
+
+    t.Run("Nothing runs outside off-peak hours", func(t *testing.T) {
+        scheduler, mock := setup(t, nil)
+        scheduler.now = func() time.Time { return now.Add(9 * time.Hour) }
+
+        stored, err := scheduler.Run(context.Background())
+        require.NoError(t, err)
+        assert.Equal(t, 0, stored)
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestSubscriptionScheduler_OffPeak(t *testing.T) {
+    at := func(hour int) time.Time { return time.Date(2024, time.March, 12, hour, 30, 0, 0, time.UTC) }
+
+    overnight := &SubscriptionScheduler{cfg: SubscriptionConfig{OffPeakStartHour: 22, OffPeakEndHour: 5}}
+    assert.True(t, overnight.offPeak(at(23)))
+    assert.True(t, overnight.offPeak(at(4)))
+    assert.False(t, overnight.offPeak(at(5)))
+    assert.False(t, overnight.offPeak(at(12)))
+
+    always := &SubscriptionScheduler{}
+    assert.True(t, always.offPeak(at(12)))
+}
+
+func TestSubscriptionBackoff(t *testing.T) {
+    assert.Equal(t, time.Hour, subscriptionBackoff(1, CadenceDaily))
+    assert.Equal(t, 8*time.Hour, subscriptionBackoff(4, CadenceDaily))
+    // Never waits longer than the cadence
+    assert.Equal(t, 24*time.Hour, subscriptionBackoff(6, CadenceDaily))
+    assert.Equal(t, 24*time.Hour, subscriptionBackoff(40, CadenceDaily))
+    assert.Equal(t, 64*time.Hour, subscriptionBackoff(7, CadenceWeekly))
+}
@@ -0,0 +1,184 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Prediction subscription cadences
+const (
+    CadenceDaily  = "daily"
+    CadenceWeekly = "weekly"
+)
+
+// OriginScheduled marks predictions generated for a subscription rather
+// than on request
+const OriginScheduled = "scheduled"
+
+var (
+    ErrInvalidSubscription  = errors.New("invalid prediction subscription")
+    ErrSubscriptionExists   = errors.New("prediction subscription already exists")
+    ErrSubscriptionNotFound = errors.New("prediction subscription not found")
+)
+
+// cadencePeriod returns how often cadence regenerates a prediction
+func cadencePeriod(cadence string) (time.Duration, bool) {
+    switch cadence {
+    case CadenceDaily:
+        return 24 * time.Hour, true
+    case CadenceWeekly:
+        return 7 * 24 * time.Hour, true
+    }
+    return 0, false
+}
+
+// PredictionSubscription asks for a user's prediction of a symbol over a
+// timeframe to be regenerated on a cadence
+type PredictionSubscription struct {
+    ID        int64     `json:"id"`
+    UserID    uuid.UUID `json:"user_id"`
+    Symbol    string    `json:"symbol"`
+    Timeframe string    `json:"timeframe"`
+    Cadence   string    `json:"cadence"`
+    NextRunAt time.Time `json:"next_run_at"`
+    // Failures counts the regenerations that failed since the last one
+    // that succeeded
+    Failures  int       `json:"failures"`
+    LastError string    `json:"last_error,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    // Latest is the most recent prediction generated for the subscription
+    Latest *ScheduledPrediction `json:"latest,omitempty"`
+}
+
+// ScheduledPrediction is a prediction generated for a subscription
+type ScheduledPrediction struct {
+    ID         int64               `json:"id"`
+    Origin     string              `json:"origin"`
+    Prediction *EnsemblePrediction `json:"prediction"`
+    CreatedAt  time.Time           `json:"created_at"`
+}
+
+// PredictionSubscriptions stores prediction subscriptions. Tier limits on
+// how many a user has are enforced by the caller, through Count.
+type PredictionSubscriptions struct {
+    db  *sql.DB
+    now func() time.Time
+}
+
+func NewPredictionSubscriptions(db *sql.DB) *PredictionSubscriptions {
+    return &PredictionSubscriptions{db: db, now: time.Now}
+}
+
+// Create subscribes userID to symbol over timeframe. The first prediction
+// is generated in the next scheduled run.
+func (s *PredictionSubscriptions) Create(ctx context.Context, userID uuid.UUID, symbol, timeframe, cadence string) (*PredictionSubscription, error) {
+    symbol = strings.ToUpper(strings.TrimSpace(symbol))
+    if symbol == "" {
+        return nil, fmt.Errorf("%w: symbol is required", ErrInvalidSubscription)
+    }
+    if !models.ValidTimeframe(timeframe) {
+        return nil, fmt.Errorf("%w: timeframe must be one of %s", ErrInvalidSubscription, strings.Join(models.Timeframes, ", "))
+    }
+    if _, ok := cadencePeriod(cadence); !ok {
+        return nil, fmt.Errorf("%w: cadence must be %s or %s", ErrInvalidSubscription, CadenceDaily, CadenceWeekly)
+    }
+
+    sub := &PredictionSubscription{
+        UserID:    userID,
+        Symbol:    symbol,
+        Timeframe: timeframe,
+        Cadence:   cadence,
+        NextRunAt: s.now(),
+    }
+    err := s.db.QueryRowContext(ctx, `
+        INSERT INTO prediction_subscriptions (user_id, symbol, timeframe, cadence, next_run_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $5)
+        ON CONFLICT (user_id, symbol, timeframe) DO NOTHING
+        RETURNING id, created_at`,
+        userID, symbol, timeframe, cadence, sub.NextRunAt,
+    ).Scan(&sub.ID, &sub.CreatedAt)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s %s", ErrSubscriptionExists, symbol, timeframe)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to create prediction subscription: %w", err)
+    }
+    return sub, nil
+}
+
+// List returns userID's subscriptions with their latest predictions
+func (s *PredictionSubscriptions) List(ctx context.Context, userID uuid.UUID) ([]PredictionSubscription, error) {
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT s.id, s.symbol, s.timeframe, s.cadence, s.next_run_at, s.failures,
+               COALESCE(s.last_error, ''), s.created_at,
+               p.id, p.origin, p.prediction, p.created_at
+        FROM prediction_subscriptions s
+        LEFT JOIN LATERAL (
+            SELECT id, origin, prediction, created_at FROM scheduled_predictions
+            WHERE subscription_id = s.id
+            ORDER BY created_at DESC
+            LIMIT 1
+        ) p ON TRUE
+        WHERE s.user_id = $1
+        ORDER BY s.symbol, s.timeframe`,
+        userID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list prediction subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    subs := []PredictionSubscription{}
+    for rows.Next() {
+        sub := PredictionSubscription{UserID: userID}
+        var predictionID sql.NullInt64
+        var origin sql.NullString
+        var prediction []byte
+        var createdAt sql.NullTime
+        if err := rows.Scan(&sub.ID, &sub.Symbol, &sub.Timeframe, &sub.Cadence, &sub.NextRunAt, &sub.Failures,
+            &sub.LastError, &sub.CreatedAt, &predictionID, &origin, &prediction, &createdAt); err != nil {
+            return nil, fmt.Errorf("failed to scan prediction subscription: %w", err)
+        }
+        if predictionID.Valid {
+            latest := &ScheduledPrediction{ID: predictionID.Int64, Origin: origin.String, CreatedAt: createdAt.Time}
+            if err := json.Unmarshal(prediction, &latest.Prediction); err != nil {
+                return nil, fmt.Errorf("failed to decode scheduled prediction %d: %w", latest.ID, err)
+            }
+            sub.Latest = latest
+        }
+        subs = append(subs, sub)
+    }
+    return subs, rows.Err()
+}
+
+// Count returns how many subscriptions userID has
+func (s *PredictionSubscriptions) Count(ctx context.Context, userID uuid.UUID) (int, error) {
+    var count int
+    err := s.db.QueryRowContext(ctx,
+        "SELECT COUNT(*) FROM prediction_subscriptions WHERE user_id = $1", userID,
+    ).Scan(&count)
+    if err != nil {
+        return 0, fmt.Errorf("failed to count prediction subscriptions: %w", err)
+    }
+    return count, nil
+}
+
+// Delete removes userID's subscription id along with its predictions
+func (s *PredictionSubscriptions) Delete(ctx context.Context, userID uuid.UUID, id int64) error {
+    result, err := s.db.ExecContext(ctx,
+        "DELETE FROM prediction_subscriptions WHERE id = $1 AND user_id = $2", id, userID)
+    if err != nil {
+        return fmt.Errorf("failed to delete prediction subscription: %w", err)
+    }
+    if n, err := result.RowsAffected(); err == nil && n == 0 {
+        return ErrSubscriptionNotFound
+    }
+    return nil
+}
@@ -4,50 +4,183 @@ import (
     "context"
     "database/sql"
     "encoding/json"
+    "errors"
     "fmt"
+    "strings"
+    "sync"
     "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+const (
+    // degradationCheckInterval is how often scheduled models are checked
+    // for degradation between their regular retrains
+    degradationCheckInterval = time.Hour
+    // trainerComponent names the trainer in logs and error metrics
+    trainerComponent = "model_trainer"
+    // trainingPollInterval is how often a running training job is checked
+    trainingPollInterval = 30 * time.Second
 )
 
 type ModelTrainer struct {
-    db        *sql.DB
-    manager   *ModelManager
-    service   *Service
+    db          *sql.DB
+    manager     *ModelManager
+    service     *Service
+    degradation *DegradationDetector
+    calendars   *calendar.Resolver
+    logger      *logger.Logger
+    errors      *monitoring.ComponentErrors
+
+    // Seams over service, replaced in tests
+    startTraining  func(ctx context.Context, config *TrainingConfig) (int64, error)
+    trainingStatus func(ctx context.Context, jobID int64) (string, error)
+    holdoutMAE     func(ctx context.Context, modelName, version, symbol string, from time.Time) (float64, error)
+    pollInterval   time.Duration
+    now            func() time.Time
+
+    searchMu sync.Mutex
+    searches map[string]*HyperparamSearch
 }
 
 type TrainingSchedule struct {
-    ModelName     string          `json:"model_name"`
-    Version       string          `json:"version"`
-    Interval      time.Duration   `json:"interval"`
-    DataWindow    time.Duration   `json:"data_window"`
-    MinSamples    int            `json:"min_samples"`
-    Config        json.RawMessage `json:"config"`
+    ModelName      string          `json:"model_name"`
+    Version        string          `json:"version"`
+    Symbol         string          `json:"symbol"`
+    Interval       time.Duration   `json:"interval"`
+    DataWindow     time.Duration   `json:"data_window"`
+    CandleInterval time.Duration   `json:"candle_interval"`
+    MinSamples     int             `json:"min_samples"`
+    Config         json.RawMessage `json:"config"`
+}
+
+// consumerID identifies the schedule in model_version_pins
+func (s *TrainingSchedule) consumerID() string {
+    return s.ModelName + ":" + s.Symbol
 }
 
-func NewModelTrainer(db *sql.DB, manager *ModelManager, service *Service) *ModelTrainer {
-    return &ModelTrainer{
-        db:      db,
-        manager: manager,
-        service: service,
+func NewModelTrainer(db *sql.DB, manager *ModelManager, service *Service, log *logger.Logger) *ModelTrainer {
+    if log == nil {
+        log = logger.Default()
     }
+    t := &ModelTrainer{
+        db:             db,
+        manager:        manager,
+        service:        service,
+        logger:         log.WithFields(map[string]interface{}{"component": trainerComponent}),
+        startTraining:  service.StartTraining,
+        trainingStatus: service.GetTrainingStatus,
+        pollInterval:   trainingPollInterval,
+        now:            time.Now,
+        searches:       make(map[string]*HyperparamSearch),
+    }
+    t.holdoutMAE = t.evaluateHoldout
+    return t
+}
+
+// WithErrors counts the trainer's failures in errs
+func (t *ModelTrainer) WithErrors(errs *monitoring.ComponentErrors) *ModelTrainer {
+    t.errors = errs
+    return t
+}
+
+// WithCalendars validates training data against each symbol's trading
+// sessions, so market closures aren't counted as missing candles
+func (t *ModelTrainer) WithCalendars(calendars *calendar.Resolver) *ModelTrainer {
+    t.calendars = calendars
+    return t
+}
+
+// WithDegradationDetector retrains scheduled models as soon as their live
+// error degrades, instead of only on their interval
+func (t *ModelTrainer) WithDegradationDetector(detector *DegradationDetector) *ModelTrainer {
+    t.degradation = detector
+    return t
 }
 
 func (t *ModelTrainer) StartScheduledTraining(ctx context.Context, schedule *TrainingSchedule) error {
     ticker := time.NewTicker(schedule.Interval)
     defer ticker.Stop()
 
+    // A nil channel never fires, so without a detector only the schedule runs
+    var degradationCheck <-chan time.Time
+    if t.degradation != nil {
+        checkTicker := time.NewTicker(degradationCheckInterval)
+        defer checkTicker.Stop()
+        degradationCheck = checkTicker.C
+    }
+
+    log := t.logger.WithFields(map[string]interface{}{
+        "model":  schedule.ModelName,
+        "symbol": schedule.Symbol,
+    })
+
+    // Retrained versions are built from the schedule's version, so it is
+    // pinned against archiving while the schedule runs. A schedule that
+    // can't pin still trains.
+    if err := t.manager.PinVersion(ctx, PinConsumerSchedule, schedule.consumerID(), schedule.ModelName, schedule.Version); err != nil {
+        t.fail(log, "pin", "Failed to pin scheduled model version", err)
+    }
+
     for {
         select {
         case <-ctx.Done():
             return ctx.Err()
             
         case <-ticker.C:
-            if err := t.runTrainingCycle(ctx, schedule); err != nil {
-                fmt.Printf("Training cycle failed: %v\n", err)
+            t.trainCycle(ctx, log, schedule, "schedule")
+
+        case <-degradationCheck:
+            shouldRetrain, increase, err := t.degradation.Check(ctx, schedule.ModelName)
+            if errors.Is(err, ErrNoBaseline) {
+                log.WithFields(map[string]interface{}{"error": err.Error()}).
+                    Warn("Skipping degradation check")
+                continue
+            }
+            if err != nil {
+                t.fail(log, "degradation_check", "Degradation check failed", err)
+                continue
             }
+            if !shouldRetrain {
+                continue
+            }
+
+            log.WithFields(map[string]interface{}{"mae_increase": increase}).
+                Warn("Model MAE above baseline, retraining")
+            t.degradation.RecordRetrain(schedule.ModelName)
+            t.trainCycle(ctx, log, schedule, "degradation")
+            // The model was just retrained, so the next scheduled run
+            // counts from now
+            ticker.Reset(schedule.Interval)
         }
     }
 }
 
+// trainCycle runs a training cycle of schedule, logging and counting a
+// failure
+func (t *ModelTrainer) trainCycle(ctx context.Context, log *logger.Logger, schedule *TrainingSchedule, trigger string) {
+    err := t.runTrainingCycle(ctx, schedule)
+    if err == nil || ctx.Err() != nil {
+        return
+    }
+
+    errorType := "training"
+    if errors.Is(err, ErrInsufficientDataQuality) {
+        errorType = "data_quality"
+    }
+    t.fail(log.WithFields(map[string]interface{}{"trigger": trigger}), errorType, "Training cycle failed", err)
+}
+
+func (t *ModelTrainer) fail(log *logger.Logger, errorType, msg string, err error) {
+    log.WithFields(map[string]interface{}{
+        "error_type": errorType,
+        "error":      err.Error(),
+    }).Error(msg)
+    t.errors.Record(trainerComponent, errorType)
+}
+
 func (t *ModelTrainer) runTrainingCycle(ctx context.Context, schedule *TrainingSchedule) error {
     // Check if we have enough data
     dataCount, err := t.checkDataAvailability(ctx, schedule.DataWindow)
@@ -59,6 +192,18 @@ func (t *ModelTrainer) runTrainingCycle(ctx context.Context, schedule *TrainingS
         return fmt.Errorf("insufficient data: got %d, need %d", dataCount, schedule.MinSamples)
     }
 
+    // Check the quality of the data the model will be trained on
+    validator := NewTrainingDataValidator(t.db, schedule.CandleInterval, schedule.MinSamples).
+        WithCalendars(t.calendars)
+    report, err := validator.Validate(ctx, schedule.Symbol, schedule.DataWindow)
+    if err != nil {
+        return err
+    }
+
+    if !report.Passed {
+        return fmt.Errorf("%w for %s: %s", ErrInsufficientDataQuality, schedule.Symbol, strings.Join(report.Warnings, "; "))
+    }
+
     // Create new version for retrained model
     newVersion := fmt.Sprintf("%s.%d", schedule.Version, time.Now().Unix())
 
@@ -67,6 +212,7 @@ func (t *ModelTrainer) runTrainingCycle(ctx context.Context, schedule *TrainingS
         ModelName:   schedule.ModelName,
         Version:    newVersion,
         DataConfig: json.RawMessage(`{
+            "symbol": "` + schedule.Symbol + `",
             "window": "` + schedule.DataWindow.String() + `"
         }`),
         ModelConfig: schedule.Config,
@@ -94,7 +240,17 @@ func (t *ModelTrainer) checkDataAvailability(ctx context.Context, window time.Du
 }
 
 func (t *ModelTrainer) monitorTraining(ctx context.Context, jobID int64, modelName, version string) error {
-    ticker := time.NewTicker(30 * time.Second)
+    if err := t.waitForTraining(ctx, jobID); err != nil {
+        return err
+    }
+    // Activate new model version
+    return t.activateNewModel(ctx, modelName, version)
+}
+
+// waitForTraining polls jobID until it completes, failing if the job fails
+// or runs for more than a day
+func (t *ModelTrainer) waitForTraining(ctx context.Context, jobID int64) error {
+    ticker := time.NewTicker(t.pollInterval)
     defer ticker.Stop()
 
     timeout := time.After(24 * time.Hour)
@@ -108,15 +264,14 @@ func (t *ModelTrainer) monitorTraining(ctx context.Context, jobID int64, modelNa
             return fmt.Errorf("training timeout")
             
         case <-ticker.C:
-            status, err := t.service.GetTrainingStatus(ctx, jobID)
+            status, err := t.trainingStatus(ctx, jobID)
             if err != nil {
                 return err
             }
 
             switch status {
             case "completed":
-                // Activate new model version
-                return t.activateNewModel(ctx, modelName, version)
+                return nil
                 
             case "failed":
                 return fmt.Errorf("training failed")
@@ -126,20 +281,7 @@ func (t *ModelTrainer) monitorTraining(ctx context.Context, jobID int64, modelNa
 }
 
 func (t *ModelTrainer) activateNewModel(ctx context.Context, modelName, version string) error {
-    // Archive current active model
-    models, err := t.manager.ListModels(ctx, "active")
-    if err != nil {
-        return err
-    }
-
-    for _, model := range models {
-        if model.Name == modelName {
-            if err := t.manager.ArchiveModel(ctx, model.Name, model.Version); err != nil {
-                return err
-            }
-        }
-    }
-
-    // Activate new model
-    return t.manager.UpdateModelStatus(ctx, modelName, version, "active")
+    // The previous version is only deactivated, so it stays available for
+    // rollback
+    return t.manager.ActivateModel(ctx, "system", modelName, version)
 }
@@ -0,0 +1,64 @@
+package ml
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger/logtest"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+func TestModelTrainer_LogsFailedCycle(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mock.ExpectExec("INSERT INTO model_version_pins").
+        WithArgs(PinConsumerSchedule, "lstm-btc:BTC", "lstm-btc", "1").
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM market_data").
+        WillReturnError(errors.New("connection reset"))
+
+    log, logs := logtest.NewObserved()
+    errs := monitoring.NewComponentErrors(nil, nil)
+    trainer := NewModelTrainer(db, NewModelManager(db), nil, log).WithErrors(errs)
+    schedule := &TrainingSchedule{
+        ModelName: "lstm-btc",
+        Version:   "1",
+        Symbol:    "BTC",
+        Interval:  10 * time.Millisecond,
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        trainer.StartScheduledTraining(ctx, schedule)
+        close(done)
+    }()
+
+    assert.Eventually(t, func() bool {
+        return logs.FilterMessage("Training cycle failed").Len() > 0
+    }, time.Second, 5*time.Millisecond)
+    cancel()
+    <-done
+
+    entries := logs.FilterMessage("Training cycle failed").All()
+    if !assert.NotEmpty(t, entries) {
+        return
+    }
+    fields := entries[0].ContextMap()
+    assert.Equal(t, "model_trainer", fields["component"])
+    assert.Equal(t, "lstm-btc", fields["model"])
+    assert.Equal(t, "BTC", fields["symbol"])
+    assert.Equal(t, "schedule", fields["trigger"])
+    assert.Equal(t, "training", fields["error_type"])
+    assert.Contains(t, fields["error"], "connection reset")
+    assert.GreaterOrEqual(t, errs.PerMinute("model_trainer"), 1)
+}
@@ -0,0 +1,53 @@
+package ml
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+
+    "github.com/go-redis/redis/v8"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// Training log message types
+const (
+    LogMessageLine = "log"
+    LogMessageDone = "done"
+)
+
+// TrainingLogMessage is published to a job's log channel for every line of
+// training output, and once more with the final status when it finishes
+type TrainingLogMessage struct {
+    Type   string `json:"type"`
+    Line   string `json:"line,omitempty"`
+    Status string `json:"status,omitempty"`
+}
+
+// TrainingLogChannel is the Redis pub/sub channel a job's logs are
+// published to
+func TrainingLogChannel(jobID int64) string {
+    return fmt.Sprintf("training:logs:%d", jobID)
+}
+
+// WithLogBroker publishes training output to Redis so any server instance
+// can stream it, not only the one running the job
+func (s *Service) WithLogBroker(client *redis.Client) *Service {
+    s.logs = client
+    return s
+}
+
+// publishLog sends msg to the job's log channel. Pub/sub keeps no history,
+// so a failed publish is only logged.
+func (s *Service) publishLog(ctx context.Context, jobID int64, msg TrainingLogMessage) {
+    if s.logs == nil {
+        return
+    }
+    data, err := json.Marshal(msg)
+    if err != nil {
+        return
+    }
+    if err := s.logs.Publish(ctx, TrainingLogChannel(jobID), data).Err(); err != nil {
+        logger.FromContext(ctx).Errorf("Failed to publish training log of job %d: %v", jobID, err)
+    }
+}
@@ -0,0 +1,76 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// PredictionMeter also counts predictions served, as for billing
+type PredictionMeter interface {
+    CountPredictions(ctx context.Context, userID uuid.UUID, n int64)
+}
+
+// UsageTracker counts the predictions each user is served per UTC day
+type UsageTracker struct {
+    db    *sql.DB
+    meter PredictionMeter
+    now   func() time.Time
+}
+
+func NewUsageTracker(db *sql.DB) *UsageTracker {
+    return &UsageTracker{db: db, now: time.Now}
+}
+
+// WithMeter reports every prediction recorded to meter as well
+func (t *UsageTracker) WithMeter(meter PredictionMeter) *UsageTracker {
+    t.meter = meter
+    return t
+}
+
+func (t *UsageTracker) today() time.Time {
+    return t.now().UTC().Truncate(24 * time.Hour)
+}
+
+// RecordPredictions adds count predictions to today's usage of the user on
+// ctx. Requests without a user, such as background jobs, aren't counted.
+func (t *UsageTracker) RecordPredictions(ctx context.Context, count int) error {
+    user, ok := ctx.Value("user").(*models.User)
+    if !ok || user == nil {
+        return nil
+    }
+
+    query := `
+        INSERT INTO prediction_usage (user_id, day, count)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, day) DO UPDATE SET count = prediction_usage.count + EXCLUDED.count
+    `
+    if _, err := t.db.ExecContext(ctx, query, user.ID, t.today(), count); err != nil {
+        return fmt.Errorf("failed to record prediction usage: %w", err)
+    }
+    if t.meter != nil {
+        t.meter.CountPredictions(ctx, user.ID, int64(count))
+    }
+    return nil
+}
+
+// PredictionsToday returns how many predictions userID has been served
+// today
+func (t *UsageTracker) PredictionsToday(ctx context.Context, userID uuid.UUID) (int, error) {
+    var count int
+    err := t.db.QueryRowContext(ctx,
+        "SELECT count FROM prediction_usage WHERE user_id = $1 AND day = $2",
+        userID, t.today(),
+    ).Scan(&count)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to read prediction usage: %w", err)
+    }
+    return count, nil
+}
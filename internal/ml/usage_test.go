@@ -0,0 +1,51 @@
+package ml
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestUsageTracker(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    tracker := NewUsageTracker(db)
+    tracker.now = func() time.Time { return time.Date(2024, time.March, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*3600)) }
+    // 23:30 EST is already the next UTC day
+    day := time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC)
+    user := &models.User{ID: uuid.New()}
+
+    mock.ExpectExec("INSERT INTO prediction_usage").
+        WithArgs(user.ID, day, 3).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    ctx := context.WithValue(context.Background(), "user", user)
+    assert.NoError(t, tracker.RecordPredictions(ctx, 3))
+
+    // Background predictions have no user to count against
+    assert.NoError(t, tracker.RecordPredictions(context.Background(), 3))
+
+    mock.ExpectQuery("SELECT count FROM prediction_usage").
+        WithArgs(user.ID, day).
+        WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+    count, err := tracker.PredictionsToday(ctx, user.ID)
+    assert.NoError(t, err)
+    assert.Equal(t, 3, count)
+
+    mock.ExpectQuery("SELECT count FROM prediction_usage").
+        WithArgs(user.ID, day).
+        WillReturnRows(sqlmock.NewRows([]string{"count"}))
+    count, err = tracker.PredictionsToday(ctx, user.ID)
+    assert.NoError(t, err)
+    assert.Equal(t, 0, count)
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,205 @@
+package ml
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "gonum.org/v1/gonum/stat"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+)
+
+const (
+    // maxMissingDataPct is the share of expected candles that may be absent
+    maxMissingDataPct = 5.0
+    // maxZeroVolumePct is the share of candles that may report zero volume
+    maxZeroVolumePct = 1.0
+
+    defaultCandleInterval = time.Minute
+    defaultMinSamples     = 1000
+)
+
+// ErrInsufficientDataQuality is returned when training data fails validation
+var ErrInsufficientDataQuality = errors.New("insufficient data quality")
+
+type TrainingDataValidator struct {
+    db             *sql.DB
+    candleInterval time.Duration
+    minSamples     int
+    // calendars counts expected candles over trading sessions; without it
+    // candles are expected around the clock
+    calendars *calendar.Resolver
+}
+
+type ValidationReport struct {
+    Passed         bool     `json:"passed"`
+    MissingDataPct float64  `json:"missing_data_pct"`
+    ZeroVolumePct  float64  `json:"zero_volume_pct"`
+    OutlierCount   int      `json:"outlier_count"`
+    SampleCount    int      `json:"sample_count"`
+    Warnings       []string `json:"warnings"`
+}
+
+type candle struct {
+    timestamp time.Time
+    close     float64
+    volume    float64
+}
+
+// NewTrainingDataValidator creates a validator for market data sampled every
+// candleInterval. Zero values fall back to the defaults.
+func NewTrainingDataValidator(db *sql.DB, candleInterval time.Duration, minSamples int) *TrainingDataValidator {
+    if candleInterval <= 0 {
+        candleInterval = defaultCandleInterval
+    }
+    if minSamples <= 0 {
+        minSamples = defaultMinSamples
+    }
+
+    return &TrainingDataValidator{
+        db:             db,
+        candleInterval: candleInterval,
+        minSamples:     minSamples,
+    }
+}
+
+// WithCalendars expects candles only while each symbol's market is open,
+// so equity weekends and holidays don't count as missing data
+func (v *TrainingDataValidator) WithCalendars(calendars *calendar.Resolver) *TrainingDataValidator {
+    v.calendars = calendars
+    return v
+}
+
+// Validate checks the quality of the market data for symbol over the last
+// window. Outliers are reported as warnings and never fail validation.
+func (v *TrainingDataValidator) Validate(ctx context.Context, symbol string, window time.Duration) (*ValidationReport, error) {
+    now := time.Now()
+    expected, err := v.expectedCandles(ctx, symbol, now.Add(-window), now)
+    if err != nil {
+        return nil, err
+    }
+
+    query := `
+        SELECT timestamp, close, volume
+        FROM market_data
+        WHERE symbol = $1
+        AND timestamp >= $2
+        ORDER BY timestamp ASC
+    `
+
+    rows, err := v.db.QueryContext(ctx, query, symbol, now.Add(-window))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load training data: %w", err)
+    }
+    defer rows.Close()
+
+    var candles []candle
+    for rows.Next() {
+        var c candle
+        if err := rows.Scan(&c.timestamp, &c.close, &c.volume); err != nil {
+            return nil, err
+        }
+        candles = append(candles, c)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return v.evaluate(candles, expected), nil
+}
+
+// expectedCandles is how many candles symbol should have between from and
+// to: one per interval while its market is open, or one per trading day
+// for daily candles
+func (v *TrainingDataValidator) expectedCandles(ctx context.Context, symbol string, from, to time.Time) (int, error) {
+    if v.calendars == nil {
+        return int(to.Sub(from) / v.candleInterval), nil
+    }
+
+    trading, err := v.calendars.SymbolCalendar(ctx, symbol)
+    if err != nil {
+        return 0, err
+    }
+    loc, err := v.calendars.SymbolLocation(ctx, symbol, "")
+    if err != nil {
+        return 0, err
+    }
+    if v.candleInterval >= 24*time.Hour {
+        return trading.TradingDaysBetween(from.In(loc), to.In(loc)), nil
+    }
+    return int(trading.TradingTime(from, to, loc) / v.candleInterval), nil
+}
+
+func (v *TrainingDataValidator) evaluate(candles []candle, expected int) *ValidationReport {
+    report := &ValidationReport{
+        Passed:      true,
+        SampleCount: len(candles),
+    }
+
+    if report.SampleCount < v.minSamples {
+        report.Passed = false
+        report.Warnings = append(report.Warnings,
+            fmt.Sprintf("not enough samples: got %d, need %d", report.SampleCount, v.minSamples))
+    }
+
+    if expected > 0 && report.SampleCount < expected {
+        report.MissingDataPct = float64(expected-report.SampleCount) / float64(expected) * 100
+    }
+    if report.MissingDataPct >= maxMissingDataPct {
+        report.Passed = false
+        report.Warnings = append(report.Warnings,
+            fmt.Sprintf("missing data %.2f%% exceeds %.2f%%", report.MissingDataPct, maxMissingDataPct))
+    }
+
+    if len(candles) == 0 {
+        return report
+    }
+
+    var zeroVolume int
+    closes := make([]float64, len(candles))
+    for i, c := range candles {
+        if c.volume == 0 {
+            zeroVolume++
+        }
+        closes[i] = c.close
+    }
+
+    report.ZeroVolumePct = float64(zeroVolume) / float64(len(candles)) * 100
+    if report.ZeroVolumePct >= maxZeroVolumePct {
+        report.Passed = false
+        report.Warnings = append(report.Warnings,
+            fmt.Sprintf("zero-volume candles %.2f%% exceeds %.2f%%", report.ZeroVolumePct, maxZeroVolumePct))
+    }
+
+    report.OutlierCount = countOutliers(closes)
+    if report.OutlierCount > 0 {
+        report.Warnings = append(report.Warnings,
+            fmt.Sprintf("%d price outliers detected", report.OutlierCount))
+    }
+
+    return report
+}
+
+// countOutliers counts values outside 1.5 interquartile ranges of the quartiles
+func countOutliers(values []float64) int {
+    sorted := make([]float64, len(values))
+    copy(sorted, values)
+    sort.Float64s(sorted)
+
+    q1 := stat.Quantile(0.25, stat.Empirical, sorted, nil)
+    q3 := stat.Quantile(0.75, stat.Empirical, sorted, nil)
+    iqr := q3 - q1
+    lower, upper := q1-1.5*iqr, q3+1.5*iqr
+
+    var count int
+    for _, v := range values {
+        if v < lower || v > upper {
+            count++
+        }
+    }
+    return count
+}
@@ -0,0 +1,113 @@
+package ml
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+)
+
+func candleRows(start time.Time, count int) *sqlmock.Rows {
+    rows := sqlmock.NewRows([]string{"timestamp", "close", "volume"})
+    for i := 0; i < count; i++ {
+        rows.AddRow(start.Add(time.Duration(i)*time.Minute), 100.0+float64(i%5), 10.0)
+    }
+    return rows
+}
+
+func TestTrainingDataValidator_Validate(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    validator := NewTrainingDataValidator(db, time.Minute, 50)
+    ctx := context.Background()
+    window := 100 * time.Minute
+    start := time.Now().Add(-window)
+
+    t.Run("Complete dataset passes", func(t *testing.T) {
+        mock.ExpectQuery("SELECT timestamp, close, volume FROM market_data WHERE symbol = (.+)").
+            WithArgs("BTC", sqlmock.AnyArg()).
+            WillReturnRows(candleRows(start, 100))
+
+        report, err := validator.Validate(ctx, "BTC", window)
+        assert.NoError(t, err)
+        assert.True(t, report.Passed)
+        assert.Equal(t, 100, report.SampleCount)
+        assert.Equal(t, 0.0, report.MissingDataPct)
+    })
+
+    t.Run("Ten percent missing candles fails", func(t *testing.T) {
+        mock.ExpectQuery("SELECT timestamp, close, volume FROM market_data WHERE symbol = (.+)").
+            WithArgs("BTC", sqlmock.AnyArg()).
+            WillReturnRows(candleRows(start, 90))
+
+        report, err := validator.Validate(ctx, "BTC", window)
+        assert.NoError(t, err)
+        assert.False(t, report.Passed)
+        assert.InDelta(t, 10.0, report.MissingDataPct, 0.01)
+        assert.NotEmpty(t, report.Warnings)
+    })
+
+    t.Run("Outliers warn without failing", func(t *testing.T) {
+        rows := candleRows(start, 99)
+        rows.AddRow(start.Add(99*time.Minute), 1000.0, 10.0)
+
+        mock.ExpectQuery("SELECT timestamp, close, volume FROM market_data WHERE symbol = (.+)").
+            WithArgs("BTC", sqlmock.AnyArg()).
+            WillReturnRows(rows)
+
+        report, err := validator.Validate(ctx, "BTC", window)
+        assert.NoError(t, err)
+        assert.True(t, report.Passed)
+        assert.Equal(t, 1, report.OutlierCount)
+    })
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingDataValidator_ExpectedCandles(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    validator := NewTrainingDataValidator(db, time.Minute, 50).
+        WithCalendars(calendar.NewResolver(db, calendar.CryptoUTC))
+    ctx := context.Background()
+
+    newYork, _ := time.LoadLocation("America/New_York")
+    // Thanksgiving week, Monday to Saturday in New York
+    from := time.Date(2024, time.November, 25, 0, 0, 0, 0, newYork)
+    to := time.Date(2024, time.November, 30, 0, 0, 0, 0, newYork)
+
+    expectSymbol := func(symbol, assetClass, timezone string) {
+        mock.ExpectQuery("SELECT asset_class FROM symbol_metadata").
+            WithArgs(symbol).
+            WillReturnRows(sqlmock.NewRows([]string{"asset_class"}).AddRow(assetClass))
+        mock.ExpectQuery("SELECT asset_class, timezone FROM symbol_metadata").
+            WithArgs(symbol).
+            WillReturnRows(sqlmock.NewRows([]string{"asset_class", "timezone"}).AddRow(assetClass, timezone))
+    }
+
+    // Four 6.5 hour sessions, as the NYSE shuts for Thanksgiving
+    expectSymbol("SPY", "equity", "America/New_York")
+    expected, err := validator.expectedCandles(ctx, "SPY", from, to)
+    assert.NoError(t, err)
+    assert.Equal(t, 4*390, expected)
+
+    // Crypto trades all five days
+    expectSymbol("BTC", "crypto", "UTC")
+    expected, err = validator.expectedCandles(ctx, "BTC", from, to)
+    assert.NoError(t, err)
+    assert.Equal(t, 5*1440, expected)
+
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
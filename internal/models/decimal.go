@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// MoneyScale is the number of fractional digits kept for quantities and amounts.
+// It matches the DECIMAL(20,8) columns used by the schema.
+const MoneyScale = 8
+
+// DecimalFromFloat converts a float64 coming from a statistical boundary
+// (market feeds, model output) into a decimal rounded to MoneyScale
+func DecimalFromFloat(f float64) decimal.Decimal {
+	return decimal.NewFromFloat(f).Round(MoneyScale)
+}
+
+// DecimalToFloat converts a decimal amount into a float64 for statistical
+// math such as returns and volatility
+func DecimalToFloat(d decimal.Decimal) float64 {
+	return d.InexactFloat64()
+}
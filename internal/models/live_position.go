@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceSource provides the current price of a symbol
+type PriceSource interface {
+	GetPrice(ctx context.Context, symbol string) (float64, error)
+}
+
+// CashRate returns what one unit of currency is worth in PortfolioCurrency:
+// 1 for PortfolioCurrency itself, and otherwise the price of the pair, e.g.
+// EURUSD
+func CashRate(ctx context.Context, prices PriceSource, currency string) (float64, error) {
+	currency = CashCurrency(currency)
+	if currency == PortfolioCurrency {
+		return 1, nil
+	}
+	return prices.GetPrice(ctx, currency+PortfolioCurrency)
+}
+
+// PreviousCloseSource is optionally implemented by a PriceSource that knows
+// the previous session's close, which LivePositions uses for DayChange
+type PreviousCloseSource interface {
+	GetPreviousClose(ctx context.Context, symbol string) (float64, error)
+}
+
+// LivePosition is an Asset valued at the current market price
+type LivePosition struct {
+	Asset
+	CurrentPrice     float64 `json:"current_price"`
+	UnrealizedPnL    float64 `json:"unrealized_pnl"`
+	UnrealizedPnLPct float64 `json:"unrealized_pnl_pct"`
+	DayChange        float64 `json:"day_change"`
+}
+
+// LivePositions values every asset at the price from priceSource. Value is
+// recomputed from the live price; the stored portfolio is not modified.
+func (p *Portfolio) LivePositions(ctx context.Context, priceSource PriceSource) ([]LivePosition, error) {
+	closes, _ := priceSource.(PreviousCloseSource)
+
+	positions := make([]LivePosition, 0, len(p.Assets))
+	for _, asset := range p.Assets {
+		price, err := priceSource.GetPrice(ctx, asset.Symbol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get price for %s: %w", asset.Symbol, err)
+		}
+
+		current := DecimalFromFloat(price)
+		cost := asset.Quantity.Mul(asset.AvgPrice)
+		pnl := asset.Quantity.Mul(current.Sub(asset.AvgPrice))
+
+		position := LivePosition{
+			Asset:         asset,
+			CurrentPrice:  price,
+			UnrealizedPnL: DecimalToFloat(pnl),
+		}
+		position.Value = asset.Quantity.Mul(current)
+		if cost.IsPositive() {
+			position.UnrealizedPnLPct = DecimalToFloat(pnl.Div(cost).Mul(decimal.NewFromInt(100)))
+		}
+
+		if closes != nil {
+			prev, err := closes.GetPreviousClose(ctx, asset.Symbol)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get previous close for %s: %w", asset.Symbol, err)
+			}
+			if prev > 0 {
+				position.DayChange = DecimalToFloat(asset.Quantity.Mul(current.Sub(DecimalFromFloat(prev))))
+			}
+		}
+
+		positions = append(positions, position)
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPriceSource struct {
+	prices map[string]float64
+	closes map[string]float64
+}
+
+func (m *mockPriceSource) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	price, ok := m.prices[symbol]
+	if !ok {
+		return 0, errors.New("unknown symbol")
+	}
+	return price, nil
+}
+
+type mockCloseSource struct {
+	mockPriceSource
+}
+
+func (m *mockCloseSource) GetPreviousClose(ctx context.Context, symbol string) (float64, error) {
+	return m.closes[symbol], nil
+}
+
+func TestPortfolio_LivePositions(t *testing.T) {
+	portfolio := &Portfolio{
+		Assets: []Asset{
+			{Symbol: "AAPL", Quantity: decimal.NewFromInt(5), AvgPrice: decimal.NewFromInt(100)},
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("Unrealized PnL from live price", func(t *testing.T) {
+		source := &mockPriceSource{prices: map[string]float64{"AAPL": 110}}
+
+		positions, err := portfolio.LivePositions(ctx, source)
+		assert.NoError(t, err)
+		assert.Len(t, positions, 1)
+
+		pos := positions[0]
+		assert.Equal(t, 110.0, pos.CurrentPrice)
+		assert.Equal(t, 5.0*10, pos.UnrealizedPnL)
+		assert.Equal(t, 10.0, pos.UnrealizedPnLPct)
+		assert.True(t, pos.Value.Equal(decimal.NewFromInt(550)))
+		// Without a previous close there is no day change
+		assert.Equal(t, 0.0, pos.DayChange)
+		// The stored portfolio is left untouched
+		assert.True(t, portfolio.Assets[0].Value.IsZero())
+	})
+
+	t.Run("Day change from previous close", func(t *testing.T) {
+		source := &mockCloseSource{mockPriceSource{
+			prices: map[string]float64{"AAPL": 110},
+			closes: map[string]float64{"AAPL": 108},
+		}}
+
+		positions, err := portfolio.LivePositions(ctx, source)
+		assert.NoError(t, err)
+		assert.Equal(t, 10.0, positions[0].DayChange)
+	})
+
+	t.Run("Price lookup failure", func(t *testing.T) {
+		_, err := portfolio.LivePositions(ctx, &mockPriceSource{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// MarketData is the latest quote for a symbol as cached from the market
+// data provider
+type MarketData struct {
+	Symbol        string    `json:"symbol" db:"symbol"`
+	Open          float64   `json:"open" db:"open"`
+	High          float64   `json:"high" db:"high"`
+	Low           float64   `json:"low" db:"low"`
+	Close         float64   `json:"close" db:"close"`
+	Volume        float64   `json:"volume" db:"volume"`
+	PreviousClose float64   `json:"previous_close" db:"previous_close"`
+	Timestamp     time.Time `json:"timestamp" db:"timestamp"`
+}
+
+// OHLCV is one candle of a symbol's price history
+type OHLCV struct {
+	Timestamp time.Time `json:"timestamp"`
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Volume    float64   `json:"volume"`
+}
+
+// CompressedOHLCV is a candle whose prices are stored as signed hundredths
+// relative to BaseClose, the close of the candle that started its run. An
+// int16 delta covers prices within ±327.67 of the base.
+type CompressedOHLCV struct {
+	Timestamp  time.Time
+	BaseClose  float64
+	DeltaOpen  int16
+	DeltaHigh  int16
+	DeltaLow   int16
+	DeltaClose int16
+	Volume     float64
+}
+
+// CompressOHLCV encodes candle against base. It reports false when a price
+// is too far from base for its delta to fit.
+func CompressOHLCV(candle OHLCV, base float64) (CompressedOHLCV, bool) {
+	compressed := CompressedOHLCV{
+		Timestamp: candle.Timestamp,
+		BaseClose: base,
+		Volume:    candle.Volume,
+	}
+	for _, field := range []struct {
+		price float64
+		delta *int16
+	}{
+		{candle.Open, &compressed.DeltaOpen},
+		{candle.High, &compressed.DeltaHigh},
+		{candle.Low, &compressed.DeltaLow},
+		{candle.Close, &compressed.DeltaClose},
+	} {
+		delta := math.Round(field.price*100) - math.Round(base*100)
+		if delta < math.MinInt16 || delta > math.MaxInt16 {
+			return CompressedOHLCV{}, false
+		}
+		*field.delta = int16(delta)
+	}
+	return compressed, true
+}
+
+// Decompress returns the candle, with its prices rounded to hundredths
+func (c CompressedOHLCV) Decompress() OHLCV {
+	base := math.Round(c.BaseClose * 100)
+	price := func(delta int16) float64 {
+		return (base + float64(delta)) / 100
+	}
+	return OHLCV{
+		Timestamp: c.Timestamp,
+		Open:      price(c.DeltaOpen),
+		High:      price(c.DeltaHigh),
+		Low:       price(c.DeltaLow),
+		Close:     price(c.DeltaClose),
+		Volume:    c.Volume,
+	}
+}
+
+// MarketDataStats describes the compressed price history and the space it
+// saves over storing each candle's OHLCV as float64s
+type MarketDataStats struct {
+	Symbols           int64   `json:"symbols"`
+	Candles           int64   `json:"candles"`
+	Bases             int64   `json:"bases"`
+	RawBytes          int64   `json:"raw_bytes"`
+	CompressedBytes   int64   `json:"compressed_bytes"`
+	StorageSavingsPct float64 `json:"storage_savings_pct"`
+}
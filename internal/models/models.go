@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
 type User struct {
@@ -16,27 +17,53 @@ type User struct {
 	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
 	LastLoginAt     *time.Time `json:"last_login_at" db:"last_login_at"`
 	SubscriptionTier string    `json:"subscription_tier" db:"subscription_tier"`
+	Timezone        string     `json:"timezone" db:"timezone"`
+	BaseCurrency    string     `json:"base_currency" db:"base_currency"`
+	DeletedAt       *time.Time `json:"-" db:"deleted_at"`
 }
 
+// Portfolio is a user's portfolio. Paper trading portfolios are forks of
+// ForkOfID, which is uuid.Nil for the others, and keep their assets apart
+// from the live ones.
 type Portfolio struct {
-	ID            uuid.UUID    `json:"id" db:"id"`
-	UserID        uuid.UUID    `json:"user_id" db:"user_id"`
-	Name          string       `json:"name" db:"name"`
-	Description   string       `json:"description" db:"description"`
-	TotalValue    float64      `json:"total_value" db:"total_value"`
-	Assets        []Asset      `json:"assets" db:"assets"`
-	Performance   Performance  `json:"performance" db:"performance"`
-	RiskScore     float64      `json:"risk_score" db:"risk_score"`
-	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time    `json:"updated_at" db:"updated_at"`
+	ID             uuid.UUID       `json:"id" db:"id"`
+	UserID         uuid.UUID       `json:"user_id" db:"user_id"`
+	Name           string          `json:"name" db:"name"`
+	Description    string          `json:"description" db:"description"`
+	TotalValue     decimal.Decimal `json:"total_value" db:"total_value"`
+	Assets         []Asset         `json:"assets" db:"assets"`
+	Performance    Performance     `json:"performance" db:"performance"`
+	RiskScore      float64         `json:"risk_score" db:"risk_score"`
+	IsPaperTrading bool            `json:"is_paper_trading" db:"paper_trading"`
+	ForkOfID       uuid.UUID       `json:"fork_of_id" db:"fork_of_id"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// PositionsTable is the table the assets of a portfolio are stored in,
+// paper_positions for paper trading portfolios and assets otherwise
+func PositionsTable(paperTrading bool) string {
+	if paperTrading {
+		return "paper_positions"
+	}
+	return "assets"
+}
+
+// BulkDeleteResult reports which of the portfolios in a bulk delete were
+// deleted and why the others weren't
+type BulkDeleteResult struct {
+	DeletedCount    int         `json:"deleted_count"`
+	NotFoundIDs     []uuid.UUID `json:"not_found_ids"`
+	UnauthorizedIDs []uuid.UUID `json:"unauthorized_ids"`
 }
 
 type Asset struct {
-	Symbol     string    `json:"symbol" db:"symbol"`
-	Type       string    `json:"type" db:"type"`
-	Quantity   float64   `json:"quantity" db:"quantity"`
-	Value      float64   `json:"value" db:"value"`
-	LastUpdate time.Time `json:"last_update" db:"last_update"`
+	Symbol     string          `json:"symbol" db:"symbol"`
+	Type       string          `json:"type" db:"type"`
+	Quantity   decimal.Decimal `json:"quantity" db:"quantity"`
+	AvgPrice   decimal.Decimal `json:"avg_price" db:"avg_price"`
+	Value      decimal.Decimal `json:"value" db:"value"`
+	LastUpdate time.Time       `json:"last_update" db:"last_update"`
 }
 
 type Performance struct {
@@ -76,6 +103,15 @@ type MarketAnalysis struct {
 	Signals        []Signal    `json:"signals" db:"signals"`
 }
 
+// MarketAnalysisTTL is how long a market analysis is served before it is
+// regenerated
+const MarketAnalysisTTL = 15 * time.Minute
+
+// FreshUntil is when the analysis is due to be regenerated
+func (a *MarketAnalysis) FreshUntil() time.Time {
+	return a.UpdatedAt.Add(MarketAnalysisTTL)
+}
+
 type Signal struct {
 	Type        string    `json:"type" db:"type"`
 	Strength    float64   `json:"strength" db:"strength"`
@@ -1,20 +1,72 @@
 package models
 
 import (
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
 type Portfolio struct {
-	ID          int64      `json:"id" db:"id"`
-	UserID      int64      `json:"user_id" db:"user_id"`
-	Name        string     `json:"name" db:"name"`
-	Description string     `json:"description" db:"description"`
-	Balance     float64    `json:"balance" db:"balance"`
-	Risk        RiskLevel  `json:"risk" db:"risk"`
-	Strategy    string     `json:"strategy" db:"strategy"`
-	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
-	Positions   []Position `json:"positions,omitempty"`
+	ID          int64     `json:"id" db:"id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	// Balance is the cash held in PortfolioCurrency
+	Balance decimal.Decimal `json:"balance" db:"balance"`
+	// CashBalance is the cash held in each currency, Balance included.
+	// Cash and AddCash keep the two in step.
+	CashBalance CashBalance `json:"cash_balance,omitempty"`
+	// MarginEnabled portfolios may spend more cash than they hold
+	MarginEnabled bool      `json:"margin_enabled" db:"margin_enabled"`
+	Risk          RiskLevel `json:"risk" db:"risk"`
+	Strategy      string    `json:"strategy" db:"strategy"`
+	// PaperTrading portfolios are forks of ForkOfID, holding copies of its
+	// positions at the time, so trading them leaves the source untouched
+	PaperTrading bool       `json:"paper_trading" db:"paper_trading"`
+	ForkOfID     *int64     `json:"fork_of_id,omitempty" db:"fork_of_id"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	Positions    []Position `json:"positions,omitempty"`
+}
+
+// PortfolioCurrency is the currency portfolios are valued in, and the one
+// trades and cashflows without a currency are booked in
+const PortfolioCurrency = "USD"
+
+// CashBalance is an amount of cash per currency
+type CashBalance map[string]decimal.Decimal
+
+// CashCurrency normalises the currency cash is held in, an empty currency
+// being PortfolioCurrency
+func CashCurrency(currency string) string {
+	if currency == "" {
+		return PortfolioCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// Cash returns the cash the portfolio holds in currency
+func (p *Portfolio) Cash(currency string) decimal.Decimal {
+	currency = CashCurrency(currency)
+	if currency == PortfolioCurrency {
+		return p.Balance
+	}
+	return p.CashBalance[currency]
+}
+
+// AddCash adds amount to the cash the portfolio holds in currency, or
+// takes it away when amount is negative
+func (p *Portfolio) AddCash(currency string, amount decimal.Decimal) {
+	currency = CashCurrency(currency)
+	if p.CashBalance == nil {
+		p.CashBalance = CashBalance{PortfolioCurrency: p.Balance}
+	}
+	p.CashBalance[currency] = p.Cash(currency).Add(amount)
+	if currency == PortfolioCurrency {
+		p.Balance = p.CashBalance[currency]
+	}
 }
 
 type RiskLevel string
@@ -26,11 +78,21 @@ const (
 )
 
 type Position struct {
-	ID          int64     `json:"id" db:"id"`
-	PortfolioID int64     `json:"portfolio_id" db:"portfolio_id"`
-	Symbol      string    `json:"symbol" db:"symbol"`
-	Quantity    float64   `json:"quantity" db:"quantity"`
-	EntryPrice  float64   `json:"entry_price" db:"entry_price"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
-}
\ No newline at end of file
+	ID          int64           `json:"id" db:"id"`
+	PortfolioID int64           `json:"portfolio_id" db:"portfolio_id"`
+	Symbol      string          `json:"symbol" db:"symbol"`
+	Quantity    decimal.Decimal `json:"quantity" db:"quantity"`
+	EntryPrice  decimal.Decimal `json:"entry_price" db:"entry_price"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// CostBasis returns the amount paid for the open quantity of the position
+func (p Position) CostBasis() decimal.Decimal {
+	return p.Quantity.Mul(p.EntryPrice)
+}
+
+// MarketValue returns the value of the position at the given price
+func (p Position) MarketValue(price decimal.Decimal) decimal.Decimal {
+	return p.Quantity.Mul(price)
+}
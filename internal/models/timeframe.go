@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Timeframes are the windows predictions and analytics can be asked for,
+// shortest first
+var Timeframes = []string{"1h", "4h", "24h", "7d", "30d"}
+
+var timeframeDurations = map[string]time.Duration{
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// ValidTimeframe reports whether timeframe is one of Timeframes
+func ValidTimeframe(timeframe string) bool {
+	_, ok := timeframeDurations[timeframe]
+	return ok
+}
+
+// TimeframeDuration returns how long timeframe spans, or false when it
+// isn't one of Timeframes
+func TimeframeDuration(timeframe string) (time.Duration, bool) {
+	d, ok := timeframeDurations[timeframe]
+	return d, ok
+}
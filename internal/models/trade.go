@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type TradeSide string
+
+const (
+	Buy  TradeSide = "buy"
+	Sell TradeSide = "sell"
+)
+
+type Trade struct {
+	ID          int64           `json:"id" db:"id"`
+	PortfolioID int64           `json:"portfolio_id" db:"portfolio_id"`
+	Symbol      string          `json:"symbol" db:"symbol"`
+	Side        TradeSide       `json:"side" db:"side"`
+	Quantity    decimal.Decimal `json:"quantity" db:"quantity"`
+	Price       decimal.Decimal `json:"price" db:"price"`
+	Fee         decimal.Decimal `json:"fee" db:"fee"`
+	// Currency is the currency of the price and fee, the one the trade
+	// settles in. Empty is PortfolioCurrency.
+	Currency   string    `json:"currency,omitempty" db:"currency"`
+	ExecutedAt time.Time `json:"executed_at" db:"executed_at"`
+}
+
+// Notional returns the traded amount before fees
+func (t Trade) Notional() decimal.Decimal {
+	return t.Quantity.Mul(t.Price)
+}
@@ -0,0 +1,186 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DefaultRedisPingTimeout bounds how long a Redis check waits for PING
+	DefaultRedisPingTimeout = 2 * time.Second
+	// DefaultPredictionStaleAfter is how long a model process may go
+	// without a successful prediction, while requests are queued for it,
+	// before it is reported as stuck
+	DefaultPredictionStaleAfter = 5 * time.Minute
+)
+
+// RedisCheck pings Redis, recording the round trip and keyspace stats
+type RedisCheck struct {
+	client  *redis.Client
+	timeout time.Duration
+	// observe is told the outcome of every ping
+	observe func(error)
+}
+
+func NewRedisCheck(client *redis.Client) *RedisCheck {
+	return &RedisCheck{client: client, timeout: DefaultRedisPingTimeout}
+}
+
+// WithObserver passes the outcome of each ping to observe, so a tracker of
+// Redis availability sees it come back while its callers skip it
+func (c *RedisCheck) WithObserver(observe func(error)) *RedisCheck {
+	c.observe = observe
+	return c
+}
+
+func (c *RedisCheck) Check(ctx context.Context) *CheckResult {
+	result := &CheckResult{
+		Status:    StatusUp,
+		Component: "redis",
+		Details:   make(map[string]interface{}),
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.client.Ping(pingCtx).Err()
+	result.Details["latency_ms"] = time.Since(start).Milliseconds()
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		// Redis not answering in time is a failure of Redis, not of the
+		// caller's context
+		err = fmt.Errorf("no reply to PING within %s", c.timeout)
+	}
+	if c.observe != nil {
+		c.observe(err)
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = fmt.Sprintf("Redis ping failed: %v", err)
+		return result
+	}
+
+	if keyspace, err := c.keyspace(pingCtx); err == nil {
+		result.Details["keyspace"] = keyspace
+	}
+	return result
+}
+
+// keyspace returns the key counts of each database from INFO keyspace,
+// or of the client's own database from DBSIZE on servers without it
+func (c *RedisCheck) keyspace(ctx context.Context) (map[string]map[string]int64, error) {
+	info, err := c.client.Info(ctx, "keyspace").Result()
+	if err == nil {
+		return parseKeyspace(info), nil
+	}
+	keys, err := c.client.DBSize(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]map[string]int64{
+		fmt.Sprintf("db%d", c.client.Options().DB): {"keys": keys},
+	}, nil
+}
+
+// parseKeyspace reads the "db0:keys=1,expires=0,avg_ttl=0" lines of INFO
+// keyspace, skipping any it can't read
+func parseKeyspace(info string) map[string]map[string]int64 {
+	keyspace := make(map[string]map[string]int64)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		db, fields, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(db, "db") {
+			continue
+		}
+		stats := make(map[string]int64)
+		for _, field := range strings.Split(fields, ",") {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				stats[name] = n
+			}
+		}
+		keyspace[db] = stats
+	}
+	return keyspace
+}
+
+// ModelProcess is the state of one model's serving process
+type ModelProcess struct {
+	Model   string
+	Running bool
+	// LastPrediction is when the process last returned a prediction, zero
+	// if it hasn't yet
+	LastPrediction time.Time
+	// QueueDepth is how many inputs are waiting for the process
+	QueueDepth int
+}
+
+// ModelPool is a pool of long-running model processes
+type ModelPool interface {
+	Processes() []ModelProcess
+}
+
+// ModelPoolCheck reports the liveness of each process in a model pool. A
+// process that has exited is down; one with inputs queued that hasn't
+// predicted for staleAfter is taken to be stuck, and is a warning.
+type ModelPoolCheck struct {
+	pool       ModelPool
+	staleAfter time.Duration
+}
+
+func NewModelPoolCheck(pool ModelPool) *ModelPoolCheck {
+	return &ModelPoolCheck{pool: pool, staleAfter: DefaultPredictionStaleAfter}
+}
+
+// WithStaleAfter sets how long a process with queued inputs may go without
+// a prediction
+func (c *ModelPoolCheck) WithStaleAfter(d time.Duration) *ModelPoolCheck {
+	c.staleAfter = d
+	return c
+}
+
+func (c *ModelPoolCheck) Check(ctx context.Context) *CheckResult {
+	result := &CheckResult{
+		Status:    StatusUp,
+		Component: "model_pool",
+		Details:   make(map[string]interface{}),
+	}
+
+	var dead, stuck []string
+	for _, p := range c.pool.Processes() {
+		details := map[string]interface{}{
+			"running":     p.Running,
+			"queue_depth": p.QueueDepth,
+		}
+		if !p.LastPrediction.IsZero() {
+			age := time.Since(p.LastPrediction)
+			details["last_prediction_age_seconds"] = int64(age.Seconds())
+			if p.Running && p.QueueDepth > 0 && age > c.staleAfter {
+				stuck = append(stuck, p.Model)
+			}
+		}
+		if !p.Running {
+			dead = append(dead, p.Model)
+		}
+		result.Details[p.Model] = details
+	}
+
+	switch {
+	case len(dead) > 0:
+		result.Status = StatusDown
+		result.Error = fmt.Sprintf("Model processes not running: %s", strings.Join(dead, ", "))
+	case len(stuck) > 0:
+		result.Status = StatusWarning
+		result.Error = fmt.Sprintf("Model processes not predicting: %s", strings.Join(stuck, ", "))
+	}
+	return result
+}
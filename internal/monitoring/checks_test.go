@@ -0,0 +1,106 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisCheck(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Set("session:1", "a")
+	mr.Set("session:2", "b")
+
+	var observed []error
+	check := NewRedisCheck(redis.NewClient(&redis.Options{Addr: mr.Addr()})).
+		WithObserver(func(err error) { observed = append(observed, err) })
+
+	result := check.Check(context.Background())
+	assert.Equal(t, StatusUp, result.Status)
+	assert.Contains(t, result.Details, "latency_ms")
+	assert.Equal(t, map[string]map[string]int64{"db0": {"keys": 2}}, result.Details["keyspace"])
+
+	mr.Close()
+	result = check.Check(context.Background())
+	assert.Equal(t, StatusDown, result.Status)
+	assert.NotEmpty(t, result.Error)
+	assert.NotContains(t, result.Details, "keyspace")
+
+	if assert.Len(t, observed, 2) {
+		assert.NoError(t, observed[0])
+		assert.Error(t, observed[1])
+	}
+
+	t.Run("No reply in time", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		var observed error
+		check := NewRedisCheck(redis.NewClient(&redis.Options{Addr: mr.Addr()})).
+			WithObserver(func(err error) { observed = err })
+		check.timeout = time.Nanosecond
+
+		result := check.Check(context.Background())
+		assert.Equal(t, StatusDown, result.Status)
+		// Observers take the caller's deadlines as the caller giving up,
+		// so a slow Redis is reported as a plain failure
+		assert.Error(t, observed)
+		assert.False(t, errors.Is(observed, context.DeadlineExceeded))
+	})
+}
+
+func TestParseKeyspace(t *testing.T) {
+	info := "# Keyspace\r\ndb0:keys=12,expires=3,avg_ttl=5000\r\ndb2:keys=1,expires=0,avg_ttl=0\r\n"
+	assert.Equal(t, map[string]map[string]int64{
+		"db0": {"keys": 12, "expires": 3, "avg_ttl": 5000},
+		"db2": {"keys": 1, "expires": 0, "avg_ttl": 0},
+	}, parseKeyspace(info))
+	assert.Empty(t, parseKeyspace("# Keyspace\r\n"))
+}
+
+type fakeModelPool struct {
+	processes []ModelProcess
+}
+
+func (p *fakeModelPool) Processes() []ModelProcess {
+	return p.processes
+}
+
+func TestModelPoolCheck(t *testing.T) {
+	now := time.Now()
+	pool := &fakeModelPool{processes: []ModelProcess{
+		{Model: "lstm@1.0", Running: true, LastPrediction: now.Add(-time.Minute), QueueDepth: 2},
+		{Model: "lstm@2.0", Running: true},
+	}}
+	check := NewModelPoolCheck(pool)
+
+	result := check.Check(context.Background())
+	assert.Equal(t, StatusUp, result.Status)
+	assert.Equal(t, map[string]interface{}{
+		"running":                     true,
+		"queue_depth":                 2,
+		"last_prediction_age_seconds": int64(60),
+	}, result.Details["lstm@1.0"])
+	assert.NotContains(t, result.Details["lstm@2.0"], "last_prediction_age_seconds")
+
+	t.Run("Queued inputs without predictions", func(t *testing.T) {
+		result := NewModelPoolCheck(pool).WithStaleAfter(30 * time.Second).Check(context.Background())
+		assert.Equal(t, StatusWarning, result.Status)
+		assert.Contains(t, result.Error, "lstm@1.0")
+	})
+
+	t.Run("Exited process", func(t *testing.T) {
+		pool.processes[1].Running = false
+		result := check.Check(context.Background())
+		assert.Equal(t, StatusDown, result.Status)
+		assert.Contains(t, result.Error, "lstm@2.0")
+	})
+
+	t.Run("Empty pool", func(t *testing.T) {
+		result := NewModelPoolCheck(&fakeModelPool{}).Check(context.Background())
+		assert.Equal(t, StatusUp, result.Status)
+	})
+}
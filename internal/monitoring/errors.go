@@ -0,0 +1,104 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errorRateWindow is the window ComponentErrors rates are measured over
+const errorRateWindow = time.Minute
+
+// ComponentErrors counts failures of background components such as the
+// market data pipeline. Each failure is added to error_count_total with its
+// type and the component as its code, and to a per-minute rate the health
+// checker reads to mark the component degraded.
+type ComponentErrors struct {
+	metrics *Metrics
+	rate    *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	recent map[string][]time.Time
+	now    func() time.Time
+}
+
+// NewComponentErrors counts failures in metrics, when non-nil, and
+// registers the rate gauge with reg when reg is non-nil
+func NewComponentErrors(metrics *Metrics, reg prometheus.Registerer) *ComponentErrors {
+	c := &ComponentErrors{
+		metrics: metrics,
+		rate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "component_errors_per_minute",
+			Help: "Errors a background component recorded over the last minute",
+		}, []string{"component"}),
+		recent: make(map[string][]time.Time),
+		now:    time.Now,
+	}
+	if reg != nil {
+		reg.MustRegister(c.rate)
+	}
+	return c
+}
+
+// Record counts a failure of component. It does nothing on a nil
+// ComponentErrors, so components can record without checking for one.
+func (c *ComponentErrors) Record(component, errorType string) {
+	if c == nil {
+		return
+	}
+	if c.metrics != nil {
+		c.metrics.ObserveError(errorType, component)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.recent[component] = append(c.prune(component, now), now)
+	c.rate.WithLabelValues(component).Set(float64(len(c.recent[component])))
+}
+
+// PerMinute returns how many failures component recorded over the last
+// minute
+func (c *ComponentErrors) PerMinute(component string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recent := c.prune(component, c.now())
+	c.recent[component] = recent
+	c.rate.WithLabelValues(component).Set(float64(len(recent)))
+	return len(recent)
+}
+
+// prune returns component's failures within the rate window. c.mu must be
+// held.
+func (c *ComponentErrors) prune(component string, now time.Time) []time.Time {
+	recent := c.recent[component]
+	start := 0
+	for start < len(recent) && now.Sub(recent[start]) >= errorRateWindow {
+		start++
+	}
+	return recent[start:]
+}
+
+// HealthCheck reports component degraded while it records at least
+// threshold failures a minute
+func (c *ComponentErrors) HealthCheck(component string, threshold int) HealthCheckFunc {
+	return func(ctx context.Context) *CheckResult {
+		perMinute := c.PerMinute(component)
+		result := &CheckResult{
+			Status:    StatusUp,
+			Component: component,
+			Details: map[string]interface{}{
+				"errors_per_minute": perMinute,
+				"threshold":         threshold,
+			},
+		}
+		if perMinute >= threshold {
+			result.Status = StatusWarning
+			result.Error = fmt.Sprintf("%d errors in the last minute", perMinute)
+		}
+		return result
+	}
+}
@@ -0,0 +1,39 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComponentErrors(t *testing.T) {
+	errs := NewComponentErrors(nil, nil)
+	now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+	errs.now = func() time.Time { return now }
+
+	check := errs.HealthCheck("market_data_pipeline", 3)
+	assert.Equal(t, StatusUp, check(context.Background()).Status)
+
+	errs.Record("market_data_pipeline", "collect")
+	now = now.Add(20 * time.Second)
+	errs.Record("market_data_pipeline", "collect")
+	errs.Record("market_data_pipeline", "notify")
+	errs.Record("model_trainer", "training")
+
+	assert.Equal(t, 3, errs.PerMinute("market_data_pipeline"))
+	assert.Equal(t, 1, errs.PerMinute("model_trainer"))
+	result := check(context.Background())
+	assert.Equal(t, StatusWarning, result.Status)
+	assert.Equal(t, 3, result.Details["errors_per_minute"])
+
+	// The first failure falls out of the window
+	now = now.Add(40 * time.Second)
+	assert.Equal(t, 2, errs.PerMinute("market_data_pipeline"))
+	assert.Equal(t, StatusUp, check(context.Background()).Status)
+
+	// Components without a recorder can record unconditionally
+	var none *ComponentErrors
+	none.Record("lstm", "decode")
+}
@@ -3,12 +3,13 @@ package monitoring
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime"
 	"sync"
 	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
 )
 
 // Health check status constants
@@ -25,6 +26,9 @@ type HealthChecker struct {
 	lastResults   map[string]*CheckResult
 	checkInterval time.Duration
 	mu            sync.RWMutex
+
+	// readiness names the checks a ready server needs to pass
+	readiness map[string]bool
 }
 
 // HealthCheckFunc defines a health check function
@@ -71,6 +75,7 @@ func NewHealthChecker(db *sql.DB, interval time.Duration) *HealthChecker {
 		db:            db,
 		services:      make(map[string]HealthCheckFunc),
 		lastResults:   make(map[string]*CheckResult),
+		readiness:     map[string]bool{"database": true},
 		checkInterval: interval,
 	}
 
@@ -90,10 +95,21 @@ func (h *HealthChecker) RegisterCheck(name string, check HealthCheckFunc) {
 	h.services[name] = check
 }
 
-// StartChecks begins periodic health checking
+// RequireForReadiness makes the named checks gate readiness, along with
+// the database
+func (h *HealthChecker) RequireForReadiness(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range names {
+		h.readiness[name] = true
+	}
+}
+
+// StartChecks runs the checks, then keeps running them periodically
 func (h *HealthChecker) StartChecks(ctx context.Context) {
 	ticker := time.NewTicker(h.checkInterval)
 	go func() {
+		h.performChecks(ctx)
 		for {
 			select {
 			case <-ctx.Done():
@@ -129,12 +145,13 @@ func (h *HealthChecker) GetHealth() *SystemHealth {
 		Timestamp:  time.Now(),
 	}
 
-	// Copy last results
+	// Copy last results. A component readiness doesn't need being down
+	// leaves the server serving, so it is only a warning overall.
 	for name, result := range h.lastResults {
 		health.Components[name] = result
-		if result.Status == StatusDown {
+		if result.Status == StatusDown && h.readiness[name] {
 			health.Status = StatusDown
-		} else if result.Status == StatusWarning && health.Status != StatusDown {
+		} else if result.Status != StatusUp && health.Status != StatusDown {
 			health.Status = StatusWarning
 		}
 	}
@@ -142,6 +159,34 @@ func (h *HealthChecker) GetHealth() *SystemHealth {
 	return health
 }
 
+// GetReadiness returns the health of the checks readiness depends on. A
+// check that hasn't run yet counts as down.
+func (h *HealthChecker) GetReadiness() *SystemHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	readiness := &SystemHealth{
+		Status:     StatusUp,
+		Components: make(map[string]*CheckResult),
+		Timestamp:  time.Now(),
+	}
+
+	for name := range h.readiness {
+		result, ok := h.lastResults[name]
+		if !ok {
+			result = &CheckResult{Status: StatusDown, Component: name, Error: "Not checked yet"}
+		}
+		readiness.Components[name] = result
+		if result.Status == StatusDown {
+			readiness.Status = StatusDown
+		} else if result.Status == StatusWarning && readiness.Status != StatusDown {
+			readiness.Status = StatusWarning
+		}
+	}
+
+	return readiness
+}
+
 // DatabaseCheck checks database connectivity and performance
 func (h *HealthChecker) DatabaseCheck(ctx context.Context) *CheckResult {
 	result := &CheckResult{
@@ -280,17 +325,33 @@ func (h *HealthChecker) DiskCheck(ctx context.Context) *CheckResult {
 	return result
 }
 
-// HTTPHandler returns a health check HTTP handler
+// HTTPHandler returns a health check HTTP handler. Warnings still serve, so
+// only a component readiness needs being down fails the check.
 func (h *HealthChecker) HTTPHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		health := h.GetHealth()
 		
-		w.Header().Set("Content-Type", "application/json")
-		if health.Status != StatusUp {
-			w.WriteHeader(http.StatusServiceUnavailable)
+		status := http.StatusOK
+		if health.Status == StatusDown {
+			status = http.StatusServiceUnavailable
+		}
+
+		render.JSON(w, r, status, health)
+	}
+}
+
+// ReadinessHandler returns a readiness HTTP handler, which fails while any
+// check readiness depends on is down
+func (h *HealthChecker) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := h.GetReadiness()
+
+		status := http.StatusOK
+		if readiness.Status == StatusDown {
+			status = http.StatusServiceUnavailable
 		}
 
-		json.NewEncoder(w).Encode(health)
+		render.JSON(w, r, status, readiness)
 	}
 }
 
@@ -316,24 +377,31 @@ type DiskUsage struct {
 
 // Custom health checks
 
-// ModelHealthCheck checks AI model health
+// ModelHealthCheck checks AI model health. It is updated by the model
+// while the health checker reads it, so its fields are guarded by mu.
 type ModelHealthCheck struct {
+	mu              sync.RWMutex
 	modelID         string
 	predictionCount int64
 	lastPrediction  time.Time
 	avgLatency      time.Duration
 	accuracy        float64
+	// lastCalibratedAt is when the model's weights were last calibrated
+	lastCalibratedAt time.Time
 }
 
-func NewModelHealthCheck(modelID string) HealthCheckFunc {
-	check := &ModelHealthCheck{
+// NewModelHealthCheck creates the health of a model, registered with the
+// health checker through its Check method
+func NewModelHealthCheck(modelID string) *ModelHealthCheck {
+	return &ModelHealthCheck{
 		modelID: modelID,
 	}
-	
-	return check.Check
 }
 
 func (m *ModelHealthCheck) Check(ctx context.Context) *CheckResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	result := &CheckResult{
 		Status:    StatusUp,
 		Component: fmt.Sprintf("model-%s", m.modelID),
@@ -345,6 +413,7 @@ func (m *ModelHealthCheck) Check(ctx context.Context) *CheckResult {
 		"last_prediction": m.lastPrediction,
 		"avg_latency_ms": m.avgLatency.Milliseconds(),
 		"accuracy": m.accuracy,
+		"last_calibrated_at": m.lastCalibratedAt,
 	}
 
 	// Check model health
@@ -368,8 +437,17 @@ func (m *ModelHealthCheck) Check(ctx context.Context) *CheckResult {
 
 // UpdateModelMetrics updates model health metrics
 func (m *ModelHealthCheck) UpdateModelMetrics(latency time.Duration, accuracy float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.predictionCount++
 	m.lastPrediction = time.Now()
 	m.avgLatency = (m.avgLatency + latency) / 2
 	m.accuracy = (m.accuracy + accuracy) / 2
 }
+
+// UpdateCalibration records when the model was last calibrated
+func (m *ModelHealthCheck) UpdateCalibration(calibratedAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastCalibratedAt = calibratedAt
+}
@@ -0,0 +1,44 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecker_Readiness(t *testing.T) {
+	redisStatus := StatusUp
+	hc := NewHealthChecker(nil, time.Minute)
+	hc.services = map[string]HealthCheckFunc{
+		"database": func(ctx context.Context) *CheckResult {
+			return &CheckResult{Status: StatusUp, Component: "database"}
+		},
+		"redis": func(ctx context.Context) *CheckResult {
+			return &CheckResult{Status: redisStatus, Component: "redis"}
+		},
+	}
+
+	// Nothing has been checked yet
+	assert.Equal(t, StatusDown, hc.GetReadiness().Status)
+
+	redisStatus = StatusDown
+	hc.performChecks(context.Background())
+	// Redis isn't needed to be ready unless required, and being down
+	// without it is only a warning
+	readiness := hc.GetReadiness()
+	assert.Equal(t, StatusUp, readiness.Status)
+	assert.NotContains(t, readiness.Components, "redis")
+	health := hc.GetHealth()
+	assert.Equal(t, StatusWarning, health.Status)
+	assert.Equal(t, StatusDown, health.Components["redis"].Status)
+
+	hc.RequireForReadiness("redis")
+	assert.Equal(t, StatusDown, hc.GetReadiness().Status)
+	assert.Equal(t, StatusDown, hc.GetHealth().Status)
+
+	redisStatus = StatusUp
+	hc.performChecks(context.Background())
+	assert.Equal(t, StatusUp, hc.GetReadiness().Status)
+}
@@ -2,9 +2,11 @@ package monitoring
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,16 +25,26 @@ type Metrics struct {
 	modelPredictionCount    *prometheus.CounterVec
 	modelConfidence        *prometheus.HistogramVec
 
-	// Portfolio metrics
-	portfolioValue         *prometheus.GaugeVec
-	portfolioReturnRate    *prometheus.GaugeVec
+	// Portfolio metrics; values and returns are exported by
+	// PortfolioMetrics
 	portfolioTradeCount    *prometheus.CounterVec
 
+	// Optimizer metrics
+	optimizerDuration     prometheus.Histogram
+	optimizerIterations   prometheus.Histogram
+	optimizerNonConverged prometheus.Counter
+
 	// System metrics
 	memoryUsage    *prometheus.GaugeVec
 	goroutineCount prometheus.Gauge
 	cpuUsage       prometheus.Gauge
 
+	// Running totals for snapshots, since the counter vecs can't be read back
+	requestTotal  int64
+	errorTotal    int64
+	modelAccuracy map[string]float64
+	history       *SnapshotHistory
+
 	// Custom metrics
 	customMetrics map[string]prometheus.Collector
 	mu           sync.RWMutex
@@ -101,31 +113,40 @@ func NewMetrics(namespace string) *Metrics {
 		),
 
 		// Portfolio metrics
-		portfolioValue: promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
+		portfolioTradeCount: promauto.NewCounterVec(
+			prometheus.CounterOpts{
 				Namespace: namespace,
-				Name:      "portfolio_value",
-				Help:      "Current portfolio value",
+				Name:      "portfolio_trades_total",
+				Help:      "Total number of portfolio trades",
 			},
-			[]string{"portfolio_id", "currency"},
+			[]string{"portfolio_id", "type"},
 		),
 
-		portfolioReturnRate: promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
+		// Optimizer metrics
+		optimizerDuration: promauto.NewHistogram(
+			prometheus.HistogramOpts{
 				Namespace: namespace,
-				Name:      "portfolio_return_rate",
-				Help:      "Portfolio return rate",
+				Name:      "optimizer_duration_seconds",
+				Help:      "Duration of portfolio optimization runs",
+				Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10},
 			},
-			[]string{"portfolio_id", "timeframe"},
 		),
 
-		portfolioTradeCount: promauto.NewCounterVec(
+		optimizerIterations: promauto.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "optimizer_iterations",
+				Help:      "Major iterations of portfolio optimization runs",
+				Buckets:   []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+			},
+		),
+
+		optimizerNonConverged: promauto.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: namespace,
-				Name:      "portfolio_trades_total",
-				Help:      "Total number of portfolio trades",
+				Name:      "optimizer_nonconverged_total",
+				Help:      "Total number of portfolio optimization runs that did not converge",
 			},
-			[]string{"portfolio_id", "type"},
 		),
 
 		// System metrics
@@ -154,6 +175,8 @@ func NewMetrics(namespace string) *Metrics {
 			},
 		),
 
+		modelAccuracy: make(map[string]float64),
+		history:       NewSnapshotHistory(snapshotRetention),
 		customMetrics: make(map[string]prometheus.Collector),
 	}
 
@@ -164,11 +187,13 @@ func NewMetrics(namespace string) *Metrics {
 func (m *Metrics) ObserveRequest(handler, method string, status int, duration time.Duration) {
 	m.requestDuration.WithLabelValues(handler, method, string(status)).Observe(duration.Seconds())
 	m.requestCount.WithLabelValues(handler, method, string(status)).Inc()
+	atomic.AddInt64(&m.requestTotal, 1)
 }
 
 // ObserveError records error metrics
 func (m *Metrics) ObserveError(errorType, errorCode string) {
 	m.errorCount.WithLabelValues(errorType, errorCode).Inc()
+	atomic.AddInt64(&m.errorTotal, 1)
 }
 
 // ObserveModelPrediction records model prediction metrics
@@ -178,13 +203,12 @@ func (m *Metrics) ObserveModelPrediction(modelID, predictionType string, duratio
 	m.modelConfidence.WithLabelValues(modelID).Observe(confidence)
 }
 
-// UpdatePortfolioMetrics updates portfolio-related metrics
-func (m *Metrics) UpdatePortfolioMetrics(portfolioID string, value float64, currency string, returns map[string]float64) {
-	m.portfolioValue.WithLabelValues(portfolioID, currency).Set(value)
-	
-	for timeframe, returnRate := range returns {
-		m.portfolioReturnRate.WithLabelValues(portfolioID, timeframe).Set(returnRate)
-	}
+// RecordModelAccuracy stores the latest evaluated accuracy of a model
+func (m *Metrics) RecordModelAccuracy(modelID string, accuracy float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.modelAccuracy[modelID] = accuracy
 }
 
 // RecordTrade records a trade for a portfolio
@@ -192,6 +216,15 @@ func (m *Metrics) RecordTrade(portfolioID, tradeType string) {
 	m.portfolioTradeCount.WithLabelValues(portfolioID, tradeType).Inc()
 }
 
+// ObserveOptimization records a portfolio optimization run
+func (m *Metrics) ObserveOptimization(duration time.Duration, iterations int, converged bool) {
+	m.optimizerDuration.Observe(duration.Seconds())
+	m.optimizerIterations.Observe(float64(iterations))
+	if !converged {
+		m.optimizerNonConverged.Inc()
+	}
+}
+
 // UpdateSystemMetrics updates system-level metrics
 func (m *Metrics) UpdateSystemMetrics() {
 	// Update memory metrics
@@ -238,7 +271,8 @@ func (m *Metrics) MetricsHandler() http.Handler {
 	return prometheus.Handler()
 }
 
-// StartMetricsCollection starts periodic collection of system metrics
+// StartMetricsCollection starts periodic collection of system metrics and
+// records a snapshot on every tick for regression checks
 func (m *Metrics) StartMetricsCollection(interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -246,12 +280,16 @@ func (m *Metrics) StartMetricsCollection(interval time.Duration) {
 
 		for range ticker.C {
 			m.UpdateSystemMetrics()
+			if snapshot, err := m.GetSnapshot(); err == nil {
+				m.history.Record(snapshot)
+			}
 		}
 	}()
 }
 
 // GetMetricsSnapshot returns a snapshot of current metrics
 type MetricsSnapshot struct {
+	CapturedAt   time.Time              `json:"captured_at"`
 	RequestCount int64                  `json:"request_count"`
 	ErrorCount   int64                  `json:"error_count"`
 	SystemStats  SystemStats            `json:"system_stats"`
@@ -268,12 +306,22 @@ type ModelStats struct {
 	PredictionCount  int64   `json:"prediction_count"`
 	AvgDuration      float64 `json:"avg_duration"`
 	AvgConfidence    float64 `json:"avg_confidence"`
+	Accuracy         float64 `json:"accuracy"`
 }
 
 func (m *Metrics) GetSnapshot() (*MetricsSnapshot, error) {
 	snapshot := &MetricsSnapshot{
-		ModelStats: make(map[string]ModelStats),
+		CapturedAt:   time.Now(),
+		RequestCount: atomic.LoadInt64(&m.requestTotal),
+		ErrorCount:   atomic.LoadInt64(&m.errorTotal),
+		ModelStats:   make(map[string]ModelStats),
+	}
+
+	m.mu.RLock()
+	for modelID, accuracy := range m.modelAccuracy {
+		snapshot.ModelStats[modelID] = ModelStats{Accuracy: accuracy}
 	}
+	m.mu.RUnlock()
 
 	// Gather system stats
 	var mem runtime.MemStats
@@ -0,0 +1,197 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PortfolioSample is a portfolio's latest value with its returns keyed by
+// timeframe, such as 7d
+type PortfolioSample struct {
+	PortfolioID string
+	Value       float64
+	Currency    string
+	Returns     map[string]float64
+}
+
+var (
+	// portfolioValueBuckets run from 1k to 100M
+	portfolioValueBuckets  = prometheus.ExponentialBuckets(1000, 10, 6)
+	portfolioReturnBuckets = []float64{-0.5, -0.25, -0.1, -0.05, -0.01, 0, 0.01, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+// PortfolioMetrics exports how portfolio values and returns are
+// distributed rather than a series per portfolio, which would grow with
+// every portfolio opened. Only portfolios on the allowlist, such as those
+// being investigated, also get their own portfolio_value and
+// portfolio_return_rate gauges.
+//
+// Each Update replaces the whole picture, so a portfolio missing from it,
+// as a deleted one is, loses its gauges.
+type PortfolioMetrics struct {
+	value      *prometheus.GaugeVec
+	returnRate *prometheus.GaugeVec
+
+	valuesDesc  *prometheus.Desc
+	aumDesc     *prometheus.Desc
+	returnsDesc *prometheus.Desc
+
+	mu        sync.Mutex
+	allowlist map[string]bool
+	// reported are the allowlisted portfolios with gauges set
+	reported map[string]PortfolioSample
+	values   histogramData
+	returns  map[string]histogramData
+}
+
+// histogramData is a distribution ready for a const histogram
+type histogramData struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newHistogramData(bounds []float64) histogramData {
+	h := histogramData{buckets: make(map[float64]uint64, len(bounds))}
+	for _, bound := range bounds {
+		h.buckets[bound] = 0
+	}
+	return h
+}
+
+func (h *histogramData) observe(v float64) {
+	h.count++
+	h.sum += v
+	for bound := range h.buckets {
+		if v <= bound {
+			h.buckets[bound]++
+		}
+	}
+}
+
+// NewPortfolioMetrics registers the portfolio metrics with reg when reg is
+// non-nil. allowlist are the IDs of the portfolios given gauges of their
+// own.
+func NewPortfolioMetrics(reg prometheus.Registerer, allowlist []string) *PortfolioMetrics {
+	m := &PortfolioMetrics{
+		value: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "portfolio_value",
+			Help: "Current value of an allowlisted portfolio",
+		}, []string{"portfolio_id", "currency"}),
+		returnRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "portfolio_return_rate",
+			Help: "Return rate of an allowlisted portfolio over the timeframe",
+		}, []string{"portfolio_id", "timeframe"}),
+		valuesDesc: prometheus.NewDesc("portfolio_values",
+			"Distribution of the latest portfolio values", nil, nil),
+		aumDesc: prometheus.NewDesc("portfolio_aum",
+			"Total value of all portfolios", nil, nil),
+		returnsDesc: prometheus.NewDesc("portfolio_return_rates",
+			"Distribution of portfolio return rates over the timeframe", []string{"timeframe"}, nil),
+		allowlist: make(map[string]bool, len(allowlist)),
+		reported:  make(map[string]PortfolioSample),
+		values:    newHistogramData(portfolioValueBuckets),
+	}
+	for _, id := range allowlist {
+		m.allowlist[id] = true
+	}
+	if reg != nil {
+		reg.MustRegister(m)
+	}
+	return m
+}
+
+// Update replaces the distributions with samples, one per live portfolio,
+// and the gauges of the allowlisted portfolios among them. It does nothing
+// on a nil PortfolioMetrics.
+func (m *PortfolioMetrics) Update(samples []PortfolioSample) {
+	if m == nil {
+		return
+	}
+
+	values := newHistogramData(portfolioValueBuckets)
+	returns := make(map[string]histogramData)
+	for _, sample := range samples {
+		values.observe(sample.Value)
+		for timeframe, rate := range sample.Returns {
+			h, ok := returns[timeframe]
+			if !ok {
+				h = newHistogramData(portfolioReturnBuckets)
+			}
+			h.observe(rate)
+			returns[timeframe] = h
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values, m.returns = values, returns
+
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		if !m.allowlist[sample.PortfolioID] {
+			continue
+		}
+		seen[sample.PortfolioID] = true
+		if previous, ok := m.reported[sample.PortfolioID]; ok {
+			m.forget(previous, sample)
+		}
+		m.value.WithLabelValues(sample.PortfolioID, sample.Currency).Set(sample.Value)
+		for timeframe, rate := range sample.Returns {
+			m.returnRate.WithLabelValues(sample.PortfolioID, timeframe).Set(rate)
+		}
+		m.reported[sample.PortfolioID] = sample
+	}
+	for id, previous := range m.reported {
+		if !seen[id] {
+			m.forget(previous, PortfolioSample{})
+			delete(m.reported, id)
+		}
+	}
+}
+
+// forget deletes the gauges of previous that current no longer sets
+func (m *PortfolioMetrics) forget(previous, current PortfolioSample) {
+	if current.PortfolioID == "" || previous.Currency != current.Currency {
+		m.value.DeleteLabelValues(previous.PortfolioID, previous.Currency)
+	}
+	for timeframe := range previous.Returns {
+		if _, ok := current.Returns[timeframe]; !ok {
+			m.returnRate.DeleteLabelValues(previous.PortfolioID, timeframe)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *PortfolioMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.value.Describe(ch)
+	m.returnRate.Describe(ch)
+	ch <- m.valuesDesc
+	ch <- m.aumDesc
+	ch <- m.returnsDesc
+}
+
+// Collect implements prometheus.Collector
+func (m *PortfolioMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.value.Collect(ch)
+	m.returnRate.Collect(ch)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch <- prometheus.MustNewConstHistogram(m.valuesDesc, m.values.count, m.values.sum, m.values.buckets)
+	ch <- prometheus.MustNewConstMetric(m.aumDesc, prometheus.GaugeValue, m.values.sum)
+
+	timeframes := make([]string, 0, len(m.returns))
+	for timeframe := range m.returns {
+		timeframes = append(timeframes, timeframe)
+	}
+	sort.Strings(timeframes)
+	for _, timeframe := range timeframes {
+		h := m.returns[timeframe]
+		ch <- prometheus.MustNewConstHistogram(m.returnsDesc, h.count, h.sum, h.buckets, timeframe)
+	}
+}
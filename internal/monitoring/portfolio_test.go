@@ -0,0 +1,67 @@
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func portfolioSamples(n int) []PortfolioSample {
+	samples := make([]PortfolioSample, n)
+	for i := range samples {
+		samples[i] = PortfolioSample{
+			PortfolioID: fmt.Sprint(i + 1),
+			Value:       float64(1000 * (i + 1)),
+			Currency:    "USD",
+			Returns:     map[string]float64{"24h": 0.01, "7d": 0.02, "30d": -0.03},
+		}
+	}
+	return samples
+}
+
+func TestPortfolioMetrics_BoundedCardinality(t *testing.T) {
+	m := NewPortfolioMetrics(nil, []string{"2", "7"})
+
+	// Two allowlisted portfolios with a value and three returns each, the
+	// value histogram, AUM and a return histogram per timeframe
+	const series = 2*4 + 1 + 1 + 3
+
+	m.Update(portfolioSamples(10))
+	assert.Equal(t, series, testutil.CollectAndCount(m))
+
+	m.Update(portfolioSamples(20000))
+	assert.Equal(t, series, testutil.CollectAndCount(m))
+
+	expected := `
+# HELP portfolio_aum Total value of all portfolios
+# TYPE portfolio_aum gauge
+portfolio_aum 2.0001e+11
+`
+	require.NoError(t, testutil.CollectAndCompare(m, strings.NewReader(expected), "portfolio_aum"))
+	assert.Equal(t, 7000.0, testutil.ToFloat64(m.value.WithLabelValues("7", "USD")))
+}
+
+func TestPortfolioMetrics_ForgetsDeletedPortfolios(t *testing.T) {
+	m := NewPortfolioMetrics(nil, []string{"2", "7"})
+	m.Update(portfolioSamples(10))
+
+	// Portfolio 7 is deleted and portfolio 2 no longer has a monthly return
+	samples := portfolioSamples(6)
+	delete(samples[1].Returns, "30d")
+	m.Update(samples)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(m.value))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.returnRate))
+	assert.Equal(t, 2000.0, testutil.ToFloat64(m.value.WithLabelValues("2", "USD")))
+
+	m.Update(nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(m.value))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.returnRate))
+
+	var none *PortfolioMetrics
+	none.Update(samples)
+}
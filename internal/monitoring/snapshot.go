@@ -0,0 +1,148 @@
+package monitoring
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/api/render"
+)
+
+const (
+	bytesPerMB = 1024 * 1024
+
+	// snapshotRetention bounds how far back regression checks can look
+	snapshotRetention = 24 * time.Hour
+)
+
+// MetricsDiff describes how metrics changed from one snapshot to a later one
+type MetricsDiff struct {
+	RequestCountDelta   int64              `json:"request_count_delta"`
+	ErrorCountDelta     int64              `json:"error_count_delta"`
+	MemoryDeltaBytes    int64              `json:"memory_delta_bytes"`
+	GoroutineCountDelta int                `json:"goroutine_count_delta"`
+	ModelAccuracyChange map[string]float64 `json:"model_accuracy_change"`
+}
+
+// RegressionThresholds sets how much a metric may worsen before it counts
+// as a regression
+type RegressionThresholds struct {
+	MaxErrorIncrease  int64   `json:"max_error_increase"`
+	MaxMemoryGrowthMB float64 `json:"max_memory_growth_mb"`
+}
+
+// DefaultRegressionThresholds are used by the regression check endpoint
+var DefaultRegressionThresholds = RegressionThresholds{
+	MaxErrorIncrease:  100,
+	MaxMemoryGrowthMB: 256,
+}
+
+// Diff returns the change from a to b, where b is the later snapshot.
+// Accuracy changes are only reported for models present in both.
+func (a *MetricsSnapshot) Diff(b *MetricsSnapshot) *MetricsDiff {
+	diff := &MetricsDiff{
+		RequestCountDelta:   b.RequestCount - a.RequestCount,
+		ErrorCountDelta:     b.ErrorCount - a.ErrorCount,
+		MemoryDeltaBytes:    int64(b.SystemStats.MemoryUsage) - int64(a.SystemStats.MemoryUsage),
+		GoroutineCountDelta: b.SystemStats.GoroutineCount - a.SystemStats.GoroutineCount,
+		ModelAccuracyChange: make(map[string]float64),
+	}
+
+	for modelID, before := range a.ModelStats {
+		if after, ok := b.ModelStats[modelID]; ok {
+			diff.ModelAccuracyChange[modelID] = after.Accuracy - before.Accuracy
+		}
+	}
+
+	return diff
+}
+
+// HasRegression reports whether errors or memory grew by more than the
+// thresholds allow. Growth exactly at a threshold is not a regression.
+func (d *MetricsDiff) HasRegression(thresholds RegressionThresholds) bool {
+	if d.ErrorCountDelta > thresholds.MaxErrorIncrease {
+		return true
+	}
+	return float64(d.MemoryDeltaBytes)/bytesPerMB > thresholds.MaxMemoryGrowthMB
+}
+
+// SnapshotHistory keeps recent snapshots so the current state can be
+// compared with an earlier one
+type SnapshotHistory struct {
+	snapshots []*MetricsSnapshot
+	retention time.Duration
+	mu        sync.RWMutex
+}
+
+// NewSnapshotHistory creates a history that drops snapshots older than retention
+func NewSnapshotHistory(retention time.Duration) *SnapshotHistory {
+	return &SnapshotHistory{retention: retention}
+}
+
+// Record appends a snapshot. Snapshots must be recorded in capture order.
+func (h *SnapshotHistory) Record(snapshot *MetricsSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots = append(h.snapshots, snapshot)
+
+	cutoff := snapshot.CapturedAt.Add(-h.retention)
+	drop := 0
+	for drop < len(h.snapshots)-1 && h.snapshots[drop].CapturedAt.Before(cutoff) {
+		drop++
+	}
+	h.snapshots = h.snapshots[drop:]
+}
+
+// At returns the latest snapshot captured at or before t
+func (h *SnapshotHistory) At(t time.Time) (*MetricsSnapshot, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for i := len(h.snapshots) - 1; i >= 0; i-- {
+		if !h.snapshots[i].CapturedAt.After(t) {
+			return h.snapshots[i], true
+		}
+	}
+	return nil, false
+}
+
+// RegressionCheckHandler compares the current snapshot with the one captured
+// window_minutes ago (default 60)
+func (m *Metrics) RegressionCheckHandler(thresholds RegressionThresholds) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := 60
+		if v := r.URL.Query().Get("window_minutes"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > int(snapshotRetention/time.Minute) {
+				http.Error(w, "Invalid window_minutes", http.StatusBadRequest)
+				return
+			}
+			window = n
+		}
+
+		current, err := m.GetSnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		baseline, ok := m.history.At(current.CapturedAt.Add(-time.Duration(window) * time.Minute))
+		if !ok {
+			http.Error(w, "No snapshot old enough for the requested window", http.StatusNotFound)
+			return
+		}
+
+		diff := baseline.Diff(current)
+
+		render.JSON(w, r, http.StatusOK, map[string]interface{}{
+			"window_minutes": window,
+			"baseline_at":    baseline.CapturedAt,
+			"current_at":     current.CapturedAt,
+			"diff":           diff,
+			"thresholds":     thresholds,
+			"regression":     diff.HasRegression(thresholds),
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsSnapshot_Diff(t *testing.T) {
+	before := &MetricsSnapshot{
+		RequestCount: 100,
+		ErrorCount:   5,
+		SystemStats:  SystemStats{MemoryUsage: 300 * bytesPerMB, GoroutineCount: 40},
+		ModelStats: map[string]ModelStats{
+			"lstm":    {Accuracy: 0.80},
+			"retired": {Accuracy: 0.70},
+		},
+	}
+	after := &MetricsSnapshot{
+		RequestCount: 250,
+		ErrorCount:   7,
+		SystemStats:  SystemStats{MemoryUsage: 200 * bytesPerMB, GoroutineCount: 35},
+		ModelStats: map[string]ModelStats{
+			"lstm": {Accuracy: 0.75},
+			"new":  {Accuracy: 0.90},
+		},
+	}
+
+	diff := before.Diff(after)
+	assert.Equal(t, int64(150), diff.RequestCountDelta)
+	assert.Equal(t, int64(2), diff.ErrorCountDelta)
+	// Memory shrinking must come out negative rather than wrap around
+	assert.Equal(t, int64(-100*bytesPerMB), diff.MemoryDeltaBytes)
+	assert.Equal(t, -5, diff.GoroutineCountDelta)
+
+	// Only models present in both snapshots are compared
+	assert.Len(t, diff.ModelAccuracyChange, 1)
+	assert.InDelta(t, -0.05, diff.ModelAccuracyChange["lstm"], 1e-9)
+}
+
+func TestMetricsDiff_HasRegression(t *testing.T) {
+	thresholds := RegressionThresholds{MaxErrorIncrease: 10, MaxMemoryGrowthMB: 50}
+
+	tests := []struct {
+		name string
+		diff MetricsDiff
+		want bool
+	}{
+		{"No change", MetricsDiff{}, false},
+		{"Errors exactly at threshold", MetricsDiff{ErrorCountDelta: 10}, false},
+		{"Errors above threshold", MetricsDiff{ErrorCountDelta: 11}, true},
+		{"Errors decreased", MetricsDiff{ErrorCountDelta: -50}, false},
+		{"Memory exactly at threshold", MetricsDiff{MemoryDeltaBytes: 50 * bytesPerMB}, false},
+		{"Memory just above threshold", MetricsDiff{MemoryDeltaBytes: 50*bytesPerMB + 1}, true},
+		{"Memory shrank", MetricsDiff{MemoryDeltaBytes: -500 * bytesPerMB}, false},
+		{"Accuracy drop alone", MetricsDiff{ModelAccuracyChange: map[string]float64{"lstm": -0.5}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.diff.HasRegression(thresholds))
+		})
+	}
+
+	t.Run("Zero thresholds flag any growth", func(t *testing.T) {
+		diff := MetricsDiff{ErrorCountDelta: 1}
+		assert.True(t, diff.HasRegression(RegressionThresholds{}))
+	})
+}
+
+func TestSnapshotHistory_At(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := NewSnapshotHistory(2 * time.Hour)
+
+	_, ok := history.At(base)
+	assert.False(t, ok)
+
+	for i := 0; i < 4; i++ {
+		history.Record(&MetricsSnapshot{CapturedAt: base.Add(time.Duration(i) * time.Hour), RequestCount: int64(i)})
+	}
+
+	// The first snapshot is older than the retention window and was dropped
+	_, ok = history.At(base.Add(30 * time.Minute))
+	assert.False(t, ok)
+
+	snapshot, ok := history.At(base.Add(150 * time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), snapshot.RequestCount)
+
+	snapshot, ok = history.At(base.Add(time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), snapshot.RequestCount)
+}
@@ -0,0 +1,158 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimingHeader set to 1 asks for a request's stage timings in its response
+const TimingHeader = "X-Debug-Timing"
+
+// TimingRequested reports whether r asked for its stage timings
+func TimingRequested(r *http.Request) bool {
+	return r.Header.Get(TimingHeader) == "1"
+}
+
+// Stages composite handlers break their time into
+const (
+	StagePortfolio = "portfolio"
+	StageTiers     = "tiers"
+	StagePrices    = "prices"
+	StageAnalytics = "analytics"
+	StageRisk      = "risk"
+)
+
+type stageTimerKey struct{}
+
+// StageTimer adds up how long a request spends in each stage of its work,
+// such as loading the portfolio or resolving prices. Stages are recorded
+// with StartStage by whatever code runs them, so a handler only starts the
+// timer and reads the breakdown.
+//
+// Stages are meant to run one after another. One started while another is
+// still open, as when the risk manager resolves prices, is folded into the
+// open one, so the stages never add up to more than the total.
+type StageTimer struct {
+	start time.Time
+
+	mu     sync.Mutex
+	open   int
+	stages []StageTiming
+}
+
+// StageTiming is the time spent in one stage over all its calls
+type StageTiming struct {
+	Stage string  `json:"stage"`
+	Calls int     `json:"calls"`
+	Ms    float64 `json:"ms"`
+
+	elapsed time.Duration
+}
+
+// TimingBreakdown is a request's time so far and how it was spent
+type TimingBreakdown struct {
+	TotalMs float64       `json:"total_ms"`
+	Stages  []StageTiming `json:"stages"`
+
+	total time.Duration
+}
+
+// WithStageTimer starts timing the request ctx belongs to
+func WithStageTimer(ctx context.Context) (context.Context, *StageTimer) {
+	t := &StageTimer{start: time.Now()}
+	return context.WithValue(ctx, stageTimerKey{}, t), t
+}
+
+func noStage() {}
+
+// StartStage starts timing stage for the request ctx belongs to and returns
+// the func that ends it. Without a timer on ctx it does nothing, so stages
+// are recorded the same way whether or not anyone is timing the request.
+func StartStage(ctx context.Context, stage string) func() {
+	t, _ := ctx.Value(stageTimerKey{}).(*StageTimer)
+	if t == nil {
+		return noStage
+	}
+
+	t.mu.Lock()
+	t.open++
+	outermost := t.open == 1
+	t.mu.Unlock()
+
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.open--
+		if outermost {
+			t.add(stage, elapsed)
+		}
+	}
+}
+
+func (t *StageTimer) add(stage string, elapsed time.Duration) {
+	for i := range t.stages {
+		if t.stages[i].Stage == stage {
+			t.stages[i].Calls++
+			t.stages[i].elapsed += elapsed
+			return
+		}
+	}
+	t.stages = append(t.stages, StageTiming{Stage: stage, Calls: 1, elapsed: elapsed})
+}
+
+// Breakdown returns the time since the timer started and the stages
+// finished so far, in the order they were first recorded
+func (t *StageTimer) Breakdown() TimingBreakdown {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := TimingBreakdown{total: time.Since(t.start), Stages: make([]StageTiming, len(t.stages))}
+	b.TotalMs = milliseconds(b.total)
+	for i, stage := range t.stages {
+		stage.Ms = milliseconds(stage.elapsed)
+		b.Stages[i] = stage
+	}
+	return b
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// StageMetrics exports request breakdowns as request_stage_duration_seconds,
+// labelled by stage, with the whole request under the stage "total"
+type StageMetrics struct {
+	durations *prometheus.HistogramVec
+}
+
+// NewStageMetrics registers the stage histogram with reg when reg is non-nil
+func NewStageMetrics(reg prometheus.Registerer) *StageMetrics {
+	m := &StageMetrics{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "request_stage_duration_seconds",
+			Help:    "Time a request spent in each stage of its work",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"stage"}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.durations)
+	}
+	return m
+}
+
+// Observe records b. It does nothing on a nil StageMetrics.
+func (m *StageMetrics) Observe(b TimingBreakdown) {
+	if m == nil {
+		return
+	}
+	m.durations.WithLabelValues("total").Observe(b.total.Seconds())
+	for _, stage := range b.Stages {
+		m.durations.WithLabelValues(stage.Stage).Observe(stage.elapsed.Seconds())
+	}
+}
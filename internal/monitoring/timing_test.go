@@ -0,0 +1,76 @@
+package monitoring
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStageTimer(t *testing.T) {
+	ctx, timer := WithStageTimer(context.Background())
+
+	done := StartStage(ctx, StagePortfolio)
+	time.Sleep(5 * time.Millisecond)
+	done()
+	for i := 0; i < 3; i++ {
+		done := StartStage(ctx, StagePrices)
+		time.Sleep(2 * time.Millisecond)
+		done()
+	}
+
+	// The risk manager resolving prices is risk time, not price time
+	done = StartStage(ctx, StageRisk)
+	nested := StartStage(ctx, StagePrices)
+	time.Sleep(3 * time.Millisecond)
+	nested()
+	done()
+
+	b := timer.Breakdown()
+	require.Len(t, b.Stages, 3)
+	assert.Equal(t, StagePortfolio, b.Stages[0].Stage)
+	assert.Equal(t, 1, b.Stages[0].Calls)
+	assert.GreaterOrEqual(t, b.Stages[0].Ms, 5.0)
+	assert.Equal(t, StagePrices, b.Stages[1].Stage)
+	assert.Equal(t, 3, b.Stages[1].Calls)
+	assert.GreaterOrEqual(t, b.Stages[1].Ms, 6.0)
+	assert.Equal(t, StageRisk, b.Stages[2].Stage)
+	assert.GreaterOrEqual(t, b.Stages[2].Ms, 3.0)
+
+	var sum float64
+	for _, stage := range b.Stages {
+		sum += stage.Ms
+	}
+	assert.LessOrEqual(t, sum, b.TotalMs)
+	assert.InDelta(t, b.TotalMs, sum, 2)
+
+	// Stages without a timer cost nothing and record nothing
+	StartStage(context.Background(), StagePrices)()
+
+	metrics := NewStageMetrics(nil)
+	metrics.Observe(b)
+	assert.Equal(t, 4, testutil.CollectAndCount(metrics.durations))
+
+	var none *StageMetrics
+	none.Observe(b)
+}
+
+func TestTimingRequested(t *testing.T) {
+	r := httptest.NewRequest("GET", "/portfolios/1", nil)
+	assert.False(t, TimingRequested(r))
+	r.Header.Set(TimingHeader, "1")
+	assert.True(t, TimingRequested(r))
+	r.Header.Set(TimingHeader, "0")
+	assert.False(t, TimingRequested(r))
+}
+
+func BenchmarkStartStage(b *testing.B) {
+	ctx, _ := WithStageTimer(context.Background())
+	for i := 0; i < b.N; i++ {
+		StartStage(ctx, StagePrices)()
+	}
+}
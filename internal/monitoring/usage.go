@@ -0,0 +1,459 @@
+package monitoring
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// ProviderBudgetAlert is the fraction of a monthly budget past which a
+// provider's usage is alerted on
+const ProviderBudgetAlert = 0.8
+
+// ProviderBudget caps the billable requests made to an external provider
+// each calendar month, in UTC. CostPerRequest, when set, turns request
+// counts into estimated spend.
+type ProviderBudget struct {
+	Provider        string  `json:"provider"`
+	MonthlyRequests int64   `json:"monthly_requests"`
+	CostPerRequest  float64 `json:"cost_per_request"`
+}
+
+// ParseProviderBudgets parses budgets given as provider:requests or
+// provider:requests:cost_per_request
+func ParseProviderBudgets(entries []string) ([]ProviderBudget, error) {
+	var budgets []ProviderBudget
+	for _, entry := range entries {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid provider budget %q, want provider:requests[:cost_per_request]", entry)
+		}
+		requests, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("invalid monthly requests in provider budget %q", entry)
+		}
+		budget := ProviderBudget{Provider: parts[0], MonthlyRequests: requests}
+		if len(parts) == 3 {
+			cost, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil || cost < 0 {
+				return nil, fmt.Errorf("invalid cost per request in provider budget %q", entry)
+			}
+			budget.CostPerRequest = cost
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, nil
+}
+
+// ProviderCall is one request to an external provider, or one a cache
+// answered in its place
+type ProviderCall struct {
+	Provider string
+	// Endpoint is the class of endpoint called, such as candles
+	Endpoint string
+	Symbol   string
+	// Component is what triggered the call; see WithProviderCaller
+	Component string
+	// Cached calls were answered by a cache and count against no budget
+	Cached bool
+}
+
+type providerCallerKey struct{}
+
+// WithProviderCaller returns a context attributing the provider calls made
+// with it to component
+func WithProviderCaller(ctx context.Context, component string) context.Context {
+	return context.WithValue(ctx, providerCallerKey{}, component)
+}
+
+// ProviderCaller returns the component provider calls made with ctx are
+// attributed to, or fallback when none was set
+func ProviderCaller(ctx context.Context, fallback string) string {
+	if component, ok := ctx.Value(providerCallerKey{}).(string); ok && component != "" {
+		return component
+	}
+	return fallback
+}
+
+type providerUsageKey struct {
+	hour      time.Time
+	provider  string
+	endpoint  string
+	symbol    string
+	component string
+	cached    bool
+}
+
+// ProviderUsage accounts for the requests made to external providers
+// against their monthly budgets. Calls are counted in memory, so deciding
+// whether a provider is over budget never touches the database, and Flush
+// persists the counts hourly to provider_usage.
+type ProviderUsage struct {
+	db      *sql.DB
+	budgets map[string]ProviderBudget
+	// hard makes Degraded report providers past their budget, so callers
+	// cut back; otherwise budgets are only alerted on
+	hard   bool
+	logger *logger.Logger
+	used   *prometheus.GaugeVec
+	now    func() time.Time
+
+	mu sync.Mutex
+	// pending holds the counts not yet flushed, by hour
+	pending map[providerUsageKey]int64
+	// month is the start of the month monthly and alerted count
+	month   time.Time
+	monthly map[string]int64
+	alerted map[string]bool
+}
+
+func NewProviderUsage(db *sql.DB, budgets []ProviderBudget, log *logger.Logger) *ProviderUsage {
+	if log == nil {
+		log = logger.Default()
+	}
+	u := &ProviderUsage{
+		db:      db,
+		budgets: make(map[string]ProviderBudget, len(budgets)),
+		hard:    true,
+		logger:  log.WithFields(map[string]interface{}{"component": "provider_usage"}),
+		used: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "provider_budget_used_ratio",
+			Help: "Billable requests made to a provider this month, as a fraction of its monthly budget",
+		}, []string{"provider"}),
+		now:     time.Now,
+		pending: make(map[providerUsageKey]int64),
+		monthly: make(map[string]int64),
+		alerted: make(map[string]bool),
+	}
+	for _, budget := range budgets {
+		u.budgets[budget.Provider] = budget
+	}
+	return u
+}
+
+// WithHardLimit sets whether providers past their budget are reported
+// degraded, true by default
+func (u *ProviderUsage) WithHardLimit(hard bool) *ProviderUsage {
+	u.hard = hard
+	return u
+}
+
+// WithRegisterer registers the budget gauge with reg
+func (u *ProviderUsage) WithRegisterer(reg prometheus.Registerer) *ProviderUsage {
+	reg.MustRegister(u.used)
+	return u
+}
+
+// Record counts call. It does nothing on a nil ProviderUsage, so callers
+// can record without checking for one.
+func (u *ProviderUsage) Record(call ProviderCall) {
+	if u == nil {
+		return
+	}
+	now := u.now().UTC()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(now)
+	u.pending[providerUsageKey{
+		hour:      now.Truncate(time.Hour),
+		provider:  call.Provider,
+		endpoint:  call.Endpoint,
+		symbol:    call.Symbol,
+		component: call.Component,
+		cached:    call.Cached,
+	}]++
+	if !call.Cached {
+		u.addBillable(call.Provider, 1)
+	}
+}
+
+// Degraded reports whether provider has used up its monthly budget under a
+// hard limit, in which case callers should cut back on requests
+func (u *ProviderUsage) Degraded(provider string) bool {
+	if u == nil || !u.hard {
+		return false
+	}
+	budget, ok := u.budgets[provider]
+	if !ok {
+		return false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(u.now().UTC())
+	return u.monthly[provider] >= budget.MonthlyRequests
+}
+
+// rollover starts counting a new month once now is past the current one.
+// u.mu must be held.
+func (u *ProviderUsage) rollover(now time.Time) {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if month.Equal(u.month) {
+		return
+	}
+	u.month = month
+	u.monthly = make(map[string]int64)
+	u.alerted = make(map[string]bool)
+	u.used.Reset()
+}
+
+// addBillable adds n billable requests to provider's month, alerting as it
+// passes ProviderBudgetAlert and its budget. u.mu must be held.
+func (u *ProviderUsage) addBillable(provider string, n int64) {
+	before := u.monthly[provider]
+	used := before + n
+	u.monthly[provider] = used
+
+	budget, ok := u.budgets[provider]
+	if !ok {
+		return
+	}
+	limit := budget.MonthlyRequests
+	u.used.WithLabelValues(provider).Set(float64(used) / float64(limit))
+
+	fields := map[string]interface{}{
+		"provider":       provider,
+		"requests":       used,
+		"monthly_budget": limit,
+	}
+	if !u.alerted[provider] && float64(used) >= ProviderBudgetAlert*float64(limit) {
+		u.alerted[provider] = true
+		u.logger.WithFields(fields).Warn("Provider usage passed 80% of its monthly budget")
+	}
+	if before < limit && used >= limit {
+		fields["hard_limit"] = u.hard
+		u.logger.WithFields(fields).Error("Provider monthly budget exhausted")
+	}
+}
+
+// Load counts the billable requests already flushed this month, so a
+// restart doesn't reset the budgets. It is called once, at startup.
+func (u *ProviderUsage) Load(ctx context.Context) error {
+	u.mu.Lock()
+	u.rollover(u.now().UTC())
+	month := u.month
+	u.mu.Unlock()
+
+	rows, err := u.db.QueryContext(ctx, `
+		SELECT provider, COALESCE(SUM(requests), 0)
+		FROM provider_usage
+		WHERE hour >= $1 AND NOT cached
+		GROUP BY provider
+	`, month)
+	if err != nil {
+		return fmt.Errorf("failed to load provider usage: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]int64)
+	for rows.Next() {
+		var provider string
+		var requests int64
+		if err := rows.Scan(&provider, &requests); err != nil {
+			return err
+		}
+		loaded[provider] = requests
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.month.Equal(month) {
+		return nil
+	}
+	for provider, requests := range loaded {
+		u.addBillable(provider, requests)
+	}
+	return nil
+}
+
+// Flush adds the counts recorded since the last flush to provider_usage.
+// Counts that fail to be written are kept for the next flush.
+func (u *ProviderUsage) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[providerUsageKey]int64)
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := u.write(ctx, pending); err != nil {
+		u.mu.Lock()
+		for key, n := range pending {
+			u.pending[key] += n
+		}
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (u *ProviderUsage) write(ctx context.Context, pending map[providerUsageKey]int64) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, n := range pending {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO provider_usage (hour, provider, endpoint_class, symbol, component, cached, requests)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (hour, provider, endpoint_class, symbol, component, cached)
+			DO UPDATE SET requests = provider_usage.requests + EXCLUDED.requests
+		`, key.hour, key.provider, key.endpoint, key.symbol, key.component, key.cached, n)
+		if err != nil {
+			return fmt.Errorf("failed to flush provider usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ProviderBudgetStatus is a provider's usage this month. Requests counts
+// billable requests only.
+type ProviderBudgetStatus struct {
+	Provider      string  `json:"provider"`
+	Requests      int64   `json:"requests"`
+	MonthlyBudget int64   `json:"monthly_budget,omitempty"`
+	UsedFraction  float64 `json:"used_fraction,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost"`
+	Alert         bool    `json:"alert"`
+	Degraded      bool    `json:"degraded"`
+}
+
+// ProviderUsageDay is the requests a component made to a provider on a
+// day, in UTC
+type ProviderUsageDay struct {
+	Day            string  `json:"day"`
+	Provider       string  `json:"provider"`
+	Component      string  `json:"component"`
+	Requests       int64   `json:"requests"`
+	CachedRequests int64   `json:"cached_requests"`
+	EstimatedCost  float64 `json:"estimated_cost"`
+}
+
+type ProviderUsageSummary struct {
+	Month     string                 `json:"month"`
+	Providers []ProviderBudgetStatus `json:"providers"`
+	// Days is newest first
+	Days []ProviderUsageDay `json:"days"`
+}
+
+// Summary reports each provider's usage this month and the daily usage by
+// component over the last days days, counting calls not yet flushed
+func (u *ProviderUsage) Summary(ctx context.Context, days int) (*ProviderUsageSummary, error) {
+	now := u.now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+
+	rows, err := u.db.QueryContext(ctx, `
+		SELECT (hour AT TIME ZONE 'UTC')::date AS day, provider, component,
+			COALESCE(SUM(requests) FILTER (WHERE NOT cached), 0),
+			COALESCE(SUM(requests) FILTER (WHERE cached), 0)
+		FROM provider_usage
+		WHERE hour >= $1
+		GROUP BY day, provider, component
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider usage: %w", err)
+	}
+	defer rows.Close()
+
+	type dayKey struct{ day, provider, component string }
+	byDay := make(map[dayKey]*ProviderUsageDay)
+	add := func(day, provider, component string, requests, cached int64) {
+		key := dayKey{day, provider, component}
+		d, ok := byDay[key]
+		if !ok {
+			d = &ProviderUsageDay{Day: day, Provider: provider, Component: component}
+			byDay[key] = d
+		}
+		d.Requests += requests
+		d.CachedRequests += cached
+	}
+	for rows.Next() {
+		var day time.Time
+		var provider, component string
+		var requests, cached int64
+		if err := rows.Scan(&day, &provider, &component, &requests, &cached); err != nil {
+			return nil, err
+		}
+		add(day.Format("2006-01-02"), provider, component, requests, cached)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	u.rollover(now)
+	for key, n := range u.pending {
+		if key.hour.Before(since) {
+			continue
+		}
+		day := key.hour.Format("2006-01-02")
+		if key.cached {
+			add(day, key.provider, key.component, 0, n)
+		} else {
+			add(day, key.provider, key.component, n, 0)
+		}
+	}
+	summary := &ProviderUsageSummary{
+		Month:     u.month.Format("2006-01"),
+		Providers: []ProviderBudgetStatus{},
+		Days:      make([]ProviderUsageDay, 0, len(byDay)),
+	}
+	providers := make(map[string]bool)
+	for provider := range u.budgets {
+		providers[provider] = true
+	}
+	for provider := range u.monthly {
+		providers[provider] = true
+	}
+	for provider := range providers {
+		budget := u.budgets[provider]
+		status := ProviderBudgetStatus{
+			Provider:      provider,
+			Requests:      u.monthly[provider],
+			MonthlyBudget: budget.MonthlyRequests,
+			EstimatedCost: float64(u.monthly[provider]) * budget.CostPerRequest,
+			Alert:         u.alerted[provider],
+			Degraded:      u.hard && budget.MonthlyRequests > 0 && u.monthly[provider] >= budget.MonthlyRequests,
+		}
+		if budget.MonthlyRequests > 0 {
+			status.UsedFraction = float64(status.Requests) / float64(budget.MonthlyRequests)
+		}
+		summary.Providers = append(summary.Providers, status)
+	}
+	u.mu.Unlock()
+
+	for _, d := range byDay {
+		d.EstimatedCost = float64(d.Requests) * u.budgets[d.Provider].CostPerRequest
+		summary.Days = append(summary.Days, *d)
+	}
+	sort.Slice(summary.Providers, func(i, j int) bool {
+		return summary.Providers[i].Provider < summary.Providers[j].Provider
+	})
+	sort.Slice(summary.Days, func(i, j int) bool {
+		a, b := summary.Days[i], summary.Days[j]
+		if a.Day != b.Day {
+			return a.Day > b.Day
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.Component < b.Component
+	})
+	return summary, nil
+}
@@ -0,0 +1,165 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProviderBudgets(t *testing.T) {
+	budgets, err := ParseProviderBudgets([]string{"alphavantage:100000:0.0025", " newsapi:5000 "})
+	require.NoError(t, err)
+	assert.Equal(t, []ProviderBudget{
+		{Provider: "alphavantage", MonthlyRequests: 100000, CostPerRequest: 0.0025},
+		{Provider: "newsapi", MonthlyRequests: 5000},
+	}, budgets)
+
+	for _, entry := range []string{"alphavantage", ":100", "alphavantage:lots", "alphavantage:0", "alphavantage:100:free", "a:1:2:3"} {
+		_, err := ParseProviderBudgets([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestProviderUsage_Budget(t *testing.T) {
+	usage := NewProviderUsage(nil, []ProviderBudget{{Provider: "alphavantage", MonthlyRequests: 10}}, nil)
+	now := time.Date(2024, time.March, 31, 23, 0, 0, 0, time.UTC)
+	usage.now = func() time.Time { return now }
+	call := ProviderCall{Provider: "alphavantage", Endpoint: "candles", Symbol: "BTC", Component: "market_data_collector"}
+
+	for i := 0; i < 7; i++ {
+		usage.Record(call)
+	}
+	// Cached calls cost nothing
+	cached := call
+	cached.Cached = true
+	for i := 0; i < 5; i++ {
+		usage.Record(cached)
+	}
+	assert.False(t, usage.alerted["alphavantage"])
+
+	usage.Record(call)
+	assert.True(t, usage.alerted["alphavantage"], "alerted at 80%")
+	usage.Record(call)
+	assert.False(t, usage.Degraded("alphavantage"), "degraded before the budget is used up")
+
+	usage.Record(call)
+	assert.True(t, usage.Degraded("alphavantage"), "degraded once the budget is used up")
+	assert.False(t, usage.Degraded("newsapi"), "providers without a budget never degrade")
+
+	// A soft limit only alerts
+	usage.WithHardLimit(false)
+	assert.False(t, usage.Degraded("alphavantage"))
+	usage.WithHardLimit(true)
+
+	// Budgets are monthly
+	now = now.Add(2 * time.Hour)
+	assert.False(t, usage.Degraded("alphavantage"))
+	assert.False(t, usage.alerted["alphavantage"])
+
+	// Recording doesn't need a tracker
+	var none *ProviderUsage
+	none.Record(call)
+	assert.False(t, none.Degraded("alphavantage"))
+}
+
+func TestProviderUsage_Flush(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	usage := NewProviderUsage(db, []ProviderBudget{{Provider: "alphavantage", MonthlyRequests: 100, CostPerRequest: 0.5}}, nil)
+	now := time.Date(2024, time.March, 12, 9, 30, 0, 0, time.UTC)
+	usage.now = func() time.Time { return now }
+
+	// The month so far is read back at startup
+	mock.ExpectQuery("SELECT provider, COALESCE\\(SUM\\(requests\\), 0\\) FROM provider_usage").
+		WithArgs(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"provider", "requests"}).AddRow("alphavantage", 79))
+	require.NoError(t, usage.Load(ctx))
+	assert.False(t, usage.alerted["alphavantage"])
+
+	call := ProviderCall{Provider: "alphavantage", Endpoint: "candles", Symbol: "ETH", Component: "market_data_collector"}
+	usage.Record(call)
+	usage.Record(call)
+	assert.True(t, usage.alerted["alphavantage"])
+
+	hour := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+	upsert := "INSERT INTO provider_usage (.+) ON CONFLICT (.+) DO UPDATE SET requests = provider_usage.requests \\+ EXCLUDED.requests"
+
+	// A failed flush keeps its counts for the next
+	mock.ExpectBegin()
+	mock.ExpectExec(upsert).
+		WithArgs(hour, "alphavantage", "candles", "ETH", "market_data_collector", false, int64(2)).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	assert.Error(t, usage.Flush(ctx))
+
+	usage.Record(call)
+	mock.ExpectBegin()
+	mock.ExpectExec(upsert).
+		WithArgs(hour, "alphavantage", "candles", "ETH", "market_data_collector", false, int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, usage.Flush(ctx))
+
+	// Nothing left to write
+	require.NoError(t, usage.Flush(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProviderUsage_Summary(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	usage := NewProviderUsage(db, []ProviderBudget{{Provider: "alphavantage", MonthlyRequests: 4, CostPerRequest: 0.5}}, nil)
+	now := time.Date(2024, time.March, 12, 9, 30, 0, 0, time.UTC)
+	usage.now = func() time.Time { return now }
+
+	mock.ExpectQuery("SELECT (.+) FROM provider_usage").
+		WithArgs(time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "provider", "component", "requests", "cached"}).
+			AddRow(time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), "alphavantage", "market_data_collector", 6, 0).
+			AddRow(time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC), "alphavantage", "market_data_collector", 1, 0))
+
+	call := ProviderCall{Provider: "alphavantage", Endpoint: "candles", Symbol: "BTC", Component: "market_data_collector"}
+	for i := 0; i < 4; i++ {
+		usage.Record(call)
+	}
+	call.Component, call.Cached = "cache_prefetch", true
+	usage.Record(call)
+	usage.Record(ProviderCall{Provider: "newsapi", Endpoint: "headlines", Component: "sentiment"})
+
+	summary, err := usage.Summary(context.Background(), 7)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, "2024-03", summary.Month)
+	assert.Equal(t, []ProviderBudgetStatus{
+		{Provider: "alphavantage", Requests: 4, MonthlyBudget: 4, UsedFraction: 1, EstimatedCost: 2, Alert: true, Degraded: true},
+		{Provider: "newsapi", Requests: 1},
+	}, summary.Providers)
+	// Unflushed calls are added to the day they were made
+	assert.Equal(t, []ProviderUsageDay{
+		{Day: "2024-03-12", Provider: "alphavantage", Component: "cache_prefetch", CachedRequests: 1},
+		{Day: "2024-03-12", Provider: "alphavantage", Component: "market_data_collector", Requests: 5, EstimatedCost: 2.5},
+		{Day: "2024-03-12", Provider: "newsapi", Component: "sentiment", Requests: 1},
+		{Day: "2024-03-11", Provider: "alphavantage", Component: "market_data_collector", Requests: 6, EstimatedCost: 3},
+	}, summary.Days)
+}
+
+func TestProviderCaller(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "market_data_collector", ProviderCaller(ctx, "market_data_collector"))
+	assert.Equal(t, "cache_prefetch", ProviderCaller(WithProviderCaller(ctx, "cache_prefetch"), "market_data_collector"))
+}
@@ -3,88 +3,191 @@ package pipeline
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "math"
+    "sort"
     "sync"
     "time"
 
     "github.com/go-redis/redis/v8"
+    "github.com/prometheus/client_golang/prometheus"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/services/market"
 )
 
+// component names the pipeline in logs and error metrics
+const component = "market_data_pipeline"
+
+// symbolsUpdateChannel carries the JSON list of symbols the pipeline tracks
+const symbolsUpdateChannel = "market:symbols:update"
+
+const (
+    // updateChannelPrefix is followed by the symbol in the channels a
+    // symbol's changed market data is published to
+    updateChannelPrefix = "market:updates:"
+    // realtimeKeyPrefix is followed by the symbol in the keys the latest
+    // market data is stored under
+    realtimeKeyPrefix = "market:realtime:"
+)
+
 type MarketDataPipeline struct {
-    collector  *market.MarketDataCollector
+    collector  cache.BatchCollector
     cache      *cache.MarketDataCache
     rdb        *redis.Client
-    pubsub     *redis.PubSub
+    health     *cache.RedisHealth
     batchSize  int
     interval   time.Duration
+    schedule   *market.Schedule
     symbols    []string
     updateChan chan struct{}
     mu         sync.RWMutex
+    logger     *logger.Logger
+    errors     *monitoring.ComponentErrors
+    // notifications counts update notifications by channel, published or
+    // suppressed because the data hadn't changed
+    notifications *prometheus.CounterVec
+}
+
+// priceUpdate is a symbol's changed market data waiting to be published.
+// move is the relative change in close since the stored data, and
+// infinite for a symbol with none stored.
+type priceUpdate struct {
+    data models.MarketData
+    move float64
+}
+
+// stageError is a failure of one stage of a pipeline run on a batch of
+// symbols
+type stageError struct {
+    stage   string
+    symbols []string
+    err     error
+}
+
+func (e *stageError) Error() string {
+    return fmt.Sprintf("failed to %s batch: %v", e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+    return e.err
 }
 
 func NewMarketDataPipeline(
-    collector *market.MarketDataCollector,
+    collector cache.BatchCollector,
     cache *cache.MarketDataCache,
     rdb *redis.Client,
     batchSize int,
     interval time.Duration,
+    log *logger.Logger,
 ) *MarketDataPipeline {
+    if log == nil {
+        log = logger.Default()
+    }
+    log = log.WithFields(map[string]interface{}{"component": component})
     return &MarketDataPipeline{
         collector:  collector,
         cache:      cache,
         rdb:        rdb,
         batchSize:  batchSize,
         interval:   interval,
+        schedule:   market.NewSchedule(interval, log),
         updateChan: make(chan struct{}, 1),
+        logger:     log,
+        notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "market_update_notifications_total",
+            Help: "Market data update notifications, by channel and whether they were published or suppressed",
+        }, []string{"channel", "outcome"}),
     }
 }
 
+// WithRedisHealth reports Redis failures to health and skips the real-time
+// store and notifications while Redis is down
+func (p *MarketDataPipeline) WithRedisHealth(health *cache.RedisHealth) *MarketDataPipeline {
+    p.health = health
+    return p
+}
+
+// WithRegisterer registers the notification counter with reg
+func (p *MarketDataPipeline) WithRegisterer(reg prometheus.Registerer) *MarketDataPipeline {
+    reg.MustRegister(p.notifications)
+    return p
+}
+
+// WithJitter delays each run by up to jitter past its interval boundary
+func (p *MarketDataPipeline) WithJitter(jitter time.Duration) *MarketDataPipeline {
+    p.schedule.WithJitter(jitter)
+    return p
+}
+
+// WithErrors counts the pipeline's failures in errs
+func (p *MarketDataPipeline) WithErrors(errs *monitoring.ComponentErrors) *MarketDataPipeline {
+    p.errors = errs
+    return p
+}
+
+// Start collects at every interval boundary, and whenever the tracked
+// symbols change, until ctx is done. Without Redis the
+// tracked symbols can't be updated and nothing is streamed, but data is
+// still collected and cached.
 func (p *MarketDataPipeline) Start(ctx context.Context) error {
     // Subscribe to symbol updates
-    p.pubsub = p.rdb.Subscribe(ctx, "market:symbols:update")
-    go p.handleSymbolUpdates(ctx)
+    if p.rdb != nil {
+        go cache.Subscribe(ctx, func(ctx context.Context) *redis.PubSub {
+            return p.rdb.Subscribe(ctx, symbolsUpdateChannel)
+        }, p.handleSymbolUpdate, p.logger)
+    }
 
     // Start data collection
-    ticker := time.NewTicker(p.interval)
-    defer ticker.Stop()
-
-    for {
-        select {
-        case <-ctx.Done():
-            return ctx.Err()
-        case <-ticker.C:
-            if err := p.collectAndProcess(ctx); err != nil {
-                fmt.Printf("Pipeline error: %v\n", err)
-            }
-        case <-p.updateChan:
-            if err := p.collectAndProcess(ctx); err != nil {
-                fmt.Printf("Pipeline error after update: %v\n", err)
-            }
+    return p.schedule.Run(ctx, nil, p.updateChan, func(_ time.Time, triggered bool) {
+        trigger := "interval"
+        if triggered {
+            trigger = "symbols_update"
         }
+        p.run(ctx, trigger)
+    })
+}
+
+// run collects and processes the tracked symbols, logging and counting a
+// failure
+func (p *MarketDataPipeline) run(ctx context.Context, trigger string) {
+    err := p.collectAndProcess(ctx)
+    if err == nil || ctx.Err() != nil {
+        return
+    }
+
+    fields := map[string]interface{}{
+        "trigger": trigger,
+        "error":   err.Error(),
+    }
+    errorType := "run"
+    var stageErr *stageError
+    if errors.As(err, &stageErr) {
+        errorType = stageErr.stage
+        fields["symbols"] = stageErr.symbols
     }
+    fields["error_type"] = errorType
+    p.logger.WithFields(fields).Error("Market data pipeline run failed")
+    p.errors.Record(component, errorType)
 }
 
-func (p *MarketDataPipeline) handleSymbolUpdates(ctx context.Context) {
-    ch := p.pubsub.Channel()
-    for {
-        select {
-        case <-ctx.Done():
-            return
-        case msg := <-ch:
-            var symbols []string
-            if err := json.Unmarshal([]byte(msg.Payload), &symbols); err != nil {
-                fmt.Printf("Failed to unmarshal symbols update: %v\n", err)
-                continue
-            }
-            p.updateSymbols(symbols)
-            select {
-            case p.updateChan <- struct{}{}:
-            default:
-            }
-        }
+func (p *MarketDataPipeline) handleSymbolUpdate(msg *redis.Message) {
+    var symbols []string
+    if err := json.Unmarshal([]byte(msg.Payload), &symbols); err != nil {
+        p.logger.WithFields(map[string]interface{}{
+            "error_type": "symbols_update",
+            "error":      err.Error(),
+        }).Warn("Ignoring malformed symbols update")
+        p.errors.Record(component, "symbols_update")
+        return
+    }
+    p.updateSymbols(symbols)
+    select {
+    case p.updateChan <- struct{}{}:
+    default:
     }
 }
 
@@ -94,6 +197,10 @@ func (p *MarketDataPipeline) updateSymbols(symbols []string) {
     p.mu.Unlock()
 }
 
+// collectAndProcess collects and caches the tracked symbols in batches,
+// then publishes the ones that changed. Updates are published once the
+// batches are done, or one fails, so each symbol gets at most one message
+// per run carrying its latest data.
 func (p *MarketDataPipeline) collectAndProcess(ctx context.Context) error {
     p.mu.RLock()
     symbols := p.symbols
@@ -103,6 +210,9 @@ func (p *MarketDataPipeline) collectAndProcess(ctx context.Context) error {
         return nil
     }
 
+    updates := make(map[string]priceUpdate)
+    defer func() { p.notifyUpdates(ctx, updates) }()
+
     // Collect data in batches
     for i := 0; i < len(symbols); i += p.batchSize {
         end := i + p.batchSize
@@ -112,54 +222,161 @@ func (p *MarketDataPipeline) collectAndProcess(ctx context.Context) error {
         batch := symbols[i:end]
 
         // Collect market data
-        data, err := p.collector.CollectBatch(ctx, batch)
+        data, err := p.collector.CollectBatch(monitoring.WithProviderCaller(ctx, component), batch)
         if err != nil {
-            return fmt.Errorf("failed to collect data: %v", err)
+            return &stageError{stage: "collect", symbols: batch, err: err}
         }
 
         // Process and cache data
-        if err := p.processData(ctx, data); err != nil {
-            return fmt.Errorf("failed to process data: %v", err)
+        changed, err := p.processData(ctx, data)
+        if err != nil {
+            return &stageError{stage: "process", symbols: batch, err: err}
         }
-
-        // Notify subscribers
-        if err := p.notifyUpdates(ctx, batch); err != nil {
-            return fmt.Errorf("failed to notify updates: %v", err)
+        for symbol, update := range changed {
+            updates[symbol] = update
         }
     }
 
     return nil
 }
 
-func (p *MarketDataPipeline) processData(ctx context.Context, data map[string]models.MarketData) error {
+// processData caches the collected data and returns the symbols whose
+// candle differs from the one stored before. Redis only speeds up readers,
+// which fall back to the database, so a Redis failure is logged and counted
+// rather than failing the run.
+func (p *MarketDataPipeline) processData(ctx context.Context, data map[string]models.MarketData) (map[string]priceUpdate, error) {
     // Update cache
     for symbol, marketData := range data {
         if err := p.cache.SetMarketData(ctx, symbol, &marketData); err != nil {
-            return err
+            return nil, err
         }
     }
 
-    // Store in Redis for real-time access
+    if p.rdb == nil || !p.health.Available() {
+        p.health.Fallback("realtime_prices", nil)
+        return nil, nil
+    }
+
+    previous, err := p.realtimeData(ctx, data)
+    if err != nil {
+        p.redisFailed(ctx, "realtime_prices", err)
+        return nil, nil
+    }
+
+    // Store in Redis for real-time access. Unchanged data is stored again
+    // to keep it from expiring, but isn't published.
+    changed := make(map[string]priceUpdate, len(data))
     pipe := p.rdb.Pipeline()
     for symbol, marketData := range data {
-        key := fmt.Sprintf("market:realtime:%s", symbol)
+        key := realtimeKeyPrefix + symbol
         jsonData, err := json.Marshal(marketData)
         if err != nil {
-            return err
+            return nil, err
         }
         pipe.Set(ctx, key, jsonData, time.Hour)
+
+        prev, ok := previous[symbol]
+        switch {
+        case !ok || prev.Close <= 0:
+            changed[symbol] = priceUpdate{data: marketData, move: math.Inf(1)}
+        case sameCandle(prev, marketData):
+            p.notifications.WithLabelValues(updateChannelPrefix+symbol, "suppressed").Inc()
+        default:
+            changed[symbol] = priceUpdate{data: marketData, move: math.Abs(marketData.Close/prev.Close - 1)}
+        }
+    }
+    if _, err := pipe.Exec(ctx); err != nil {
+        p.redisFailed(ctx, "realtime_prices", err)
+        return nil, nil
+    }
+    return changed, nil
+}
+
+// realtimeData reads the stored real-time data of the symbols in data in
+// one round trip. Symbols with none stored, or unreadable data, are left
+// out.
+func (p *MarketDataPipeline) realtimeData(ctx context.Context, data map[string]models.MarketData) (map[string]models.MarketData, error) {
+    symbols := make([]string, 0, len(data))
+    keys := make([]string, 0, len(data))
+    for symbol := range data {
+        symbols = append(symbols, symbol)
+        keys = append(keys, realtimeKeyPrefix+symbol)
+    }
+    if len(keys) == 0 {
+        return nil, nil
+    }
+
+    values, err := p.rdb.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+
+    stored := make(map[string]models.MarketData, len(values))
+    for i, value := range values {
+        raw, ok := value.(string)
+        if !ok {
+            continue
+        }
+        var marketData models.MarketData
+        if err := json.Unmarshal([]byte(raw), &marketData); err == nil {
+            stored[symbols[i]] = marketData
+        }
     }
-    _, err := pipe.Exec(ctx)
-    return err
+    return stored, nil
+}
+
+// sameCandle reports whether b carries nothing new over a
+func sameCandle(a, b models.MarketData) bool {
+    return a.Close == b.Close && a.Volume == b.Volume && a.Timestamp.Equal(b.Timestamp)
 }
 
-func (p *MarketDataPipeline) notifyUpdates(ctx context.Context, symbols []string) error {
-    // Publish updates to subscribers
+// notifyUpdates publishes each updated symbol's data to its channel,
+// biggest moves first, so they are the ones out if publishing is cut
+// short. Pub/sub keeps no history, so subscribers miss updates published
+// while Redis is unreachable and catch up on the next change.
+func (p *MarketDataPipeline) notifyUpdates(ctx context.Context, updates map[string]priceUpdate) {
+    if len(updates) == 0 {
+        return
+    }
+    if p.rdb == nil || !p.health.Available() {
+        p.health.Fallback("price_updates", nil)
+        return
+    }
+
+    symbols := make([]string, 0, len(updates))
+    for symbol := range updates {
+        symbols = append(symbols, symbol)
+    }
+    sort.Slice(symbols, func(i, j int) bool {
+        a, b := updates[symbols[i]], updates[symbols[j]]
+        if a.move != b.move {
+            return a.move > b.move
+        }
+        return symbols[i] < symbols[j]
+    })
+
     for _, symbol := range symbols {
-        channel := fmt.Sprintf("market:updates:%s", symbol)
-        if err := p.rdb.Publish(ctx, channel, "updated").Err(); err != nil {
-            return err
+        payload, err := json.Marshal(updates[symbol].data)
+        if err != nil {
+            continue
+        }
+        channel := updateChannelPrefix + symbol
+        if err := p.rdb.Publish(ctx, channel, payload).Err(); err != nil {
+            p.redisFailed(ctx, "price_updates", err)
+            return
         }
+        p.notifications.WithLabelValues(channel, "published").Inc()
     }
-    return nil
+}
+
+func (p *MarketDataPipeline) redisFailed(ctx context.Context, feature string, err error) {
+    if ctx.Err() != nil {
+        return
+    }
+    p.health.Fallback(feature, err)
+    p.logger.WithFields(map[string]interface{}{
+        "error_type": feature,
+        "error":      err.Error(),
+    }).Warn("Redis unavailable, continuing without it")
+    p.errors.Record(component, feature)
 }
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// fakeCollector returns its batches in turn, one per call
+type fakeCollector struct {
+    batches []map[string]models.MarketData
+}
+
+func (f *fakeCollector) CollectBatch(ctx context.Context, symbols []string) (map[string]models.MarketData, error) {
+    batch := f.batches[0]
+    f.batches = f.batches[1:]
+    return batch, nil
+}
+
+func newTestPipeline(t *testing.T, batchSize int, batches ...map[string]models.MarketData) (*MarketDataPipeline, *redis.Client) {
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    t.Cleanup(func() { client.Close() })
+
+    marketCache := cache.NewMarketDataCache(client, time.Minute, nil, nil)
+    p := NewMarketDataPipeline(&fakeCollector{batches: batches}, marketCache, client, batchSize, time.Minute, nil)
+    return p, client
+}
+
+// subscribe subscribes to the update channel of each of symbols and waits
+// for the subscriptions to be confirmed
+func subscribe(t *testing.T, client *redis.Client, symbols ...string) *redis.PubSub {
+    channels := make([]string, len(symbols))
+    for i, symbol := range symbols {
+        channels[i] = updateChannelPrefix + symbol
+    }
+    sub := client.Subscribe(context.Background(), channels...)
+    t.Cleanup(func() { sub.Close() })
+    for range channels {
+        _, err := sub.Receive(context.Background())
+        require.NoError(t, err)
+    }
+    return sub
+}
+
+// received collects the messages published to sub until none arrives for
+// a short while
+func received(t *testing.T, sub *redis.PubSub) []*redis.Message {
+    var messages []*redis.Message
+    for {
+        msg, err := sub.ReceiveTimeout(context.Background(), 100*time.Millisecond)
+        if err != nil {
+            return messages
+        }
+        if m, ok := msg.(*redis.Message); ok {
+            messages = append(messages, m)
+        }
+    }
+}
+
+func storeRealtime(t *testing.T, client *redis.Client, data models.MarketData) {
+    payload, err := json.Marshal(data)
+    require.NoError(t, err)
+    require.NoError(t, client.Set(context.Background(), realtimeKeyPrefix+data.Symbol, payload, time.Hour).Err())
+}
+
+func TestMarketDataPipeline_SuppressesUnchangedCandles(t *testing.T) {
+    ts := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    btc := models.MarketData{Symbol: "BTC", Close: 65000, Volume: 12, Timestamp: ts}
+    eth := models.MarketData{Symbol: "ETH", Close: 3500, Volume: 40, Timestamp: ts}
+
+    p, client := newTestPipeline(t, 10, map[string]models.MarketData{"BTC": btc, "ETH": eth})
+    storeRealtime(t, client, btc)
+    p.updateSymbols([]string{"BTC", "ETH"})
+    sub := subscribe(t, client, "BTC", "ETH")
+
+    require.NoError(t, p.collectAndProcess(context.Background()))
+
+    // BTC is unchanged, and ETH has no stored data to compare with
+    messages := received(t, sub)
+    require.Len(t, messages, 1)
+    assert.Equal(t, updateChannelPrefix+"ETH", messages[0].Channel)
+
+    var published models.MarketData
+    require.NoError(t, json.Unmarshal([]byte(messages[0].Payload), &published))
+    assert.Equal(t, 3500.0, published.Close)
+
+    assert.Equal(t, 1.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"BTC", "suppressed")))
+    assert.Equal(t, 0.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"BTC", "published")))
+    assert.Equal(t, 1.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"ETH", "published")))
+    assert.Equal(t, 0.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"ETH", "suppressed")))
+
+    // Unchanged data is stored again to keep it from expiring
+    ttl, err := client.TTL(context.Background(), realtimeKeyPrefix+"BTC").Result()
+    require.NoError(t, err)
+    assert.Equal(t, time.Hour, ttl)
+}
+
+func TestMarketDataPipeline_PublishesLatestDataOncePerRun(t *testing.T) {
+    ts := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    first := models.MarketData{Symbol: "BTC", Close: 65000, Volume: 12, Timestamp: ts}
+    second := models.MarketData{Symbol: "BTC", Close: 65500, Volume: 15, Timestamp: ts.Add(time.Minute)}
+
+    // Listing the symbol twice with batches of one collects it twice in a run
+    p, client := newTestPipeline(t, 1,
+        map[string]models.MarketData{"BTC": first},
+        map[string]models.MarketData{"BTC": second},
+    )
+    p.updateSymbols([]string{"BTC", "BTC"})
+    sub := subscribe(t, client, "BTC")
+
+    require.NoError(t, p.collectAndProcess(context.Background()))
+
+    messages := received(t, sub)
+    require.Len(t, messages, 1)
+
+    var published models.MarketData
+    require.NoError(t, json.Unmarshal([]byte(messages[0].Payload), &published))
+    assert.Equal(t, second.Close, published.Close)
+    assert.Equal(t, second.Volume, published.Volume)
+    assert.True(t, second.Timestamp.Equal(published.Timestamp))
+
+    assert.Equal(t, 1.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"BTC", "published")))
+    assert.Equal(t, 0.0, testutil.ToFloat64(p.notifications.WithLabelValues(updateChannelPrefix+"BTC", "suppressed")))
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+
+    "github.com/QUOTRIX/WOLFAI/internal/database"
+    "github.com/QUOTRIX/WOLFAI/internal/models"
+)
+
+// MarketAnalysisRepository keeps the latest market analysis of each symbol
+// in market_analysis, one row per symbol, and past analyses in
+// market_analysis_history
+type MarketAnalysisRepository struct {
+    db *database.DB
+}
+
+func NewMarketAnalysisRepository(db *database.DB) *MarketAnalysisRepository {
+    return &MarketAnalysisRepository{db: db}
+}
+
+// SaveMarketAnalysis stores analysis as the latest of its symbol, replacing
+// the stored one and its trading signals, and sets analysis.ID to the
+// symbol's row. Saving an analysis again, as a retried refresh does, leaves
+// the one row, and an analysis older than the stored one is not saved.
+//
+// The upsert locks the symbol's row before touching its signals, so
+// concurrent saves of a symbol queue on that row rather than deadlocking.
+func (r *MarketAnalysisRepository) SaveMarketAnalysis(ctx context.Context, analysis *models.MarketAnalysis) error {
+    if analysis.ID == uuid.Nil {
+        analysis.ID = uuid.New()
+    }
+
+    upsert := `
+        INSERT INTO market_analysis (id, asset_symbol, sentiment, volume_24h, price_change_24h, trend_strength, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (asset_symbol) DO UPDATE SET
+            sentiment = EXCLUDED.sentiment,
+            volume_24h = EXCLUDED.volume_24h,
+            price_change_24h = EXCLUDED.price_change_24h,
+            trend_strength = EXCLUDED.trend_strength,
+            updated_at = EXCLUDED.updated_at
+        WHERE market_analysis.updated_at <= EXCLUDED.updated_at
+        RETURNING id
+    `
+
+    return r.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        var id uuid.UUID
+        err := tx.QueryRowContext(ctx, upsert,
+            analysis.ID,
+            analysis.AssetSymbol,
+            analysis.Sentiment,
+            analysis.Volume24h,
+            analysis.PriceChange24h,
+            analysis.TrendStrength,
+            analysis.UpdatedAt,
+        ).Scan(&id)
+        if errors.Is(err, sql.ErrNoRows) {
+            // A newer analysis is already stored
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("upsert market analysis: %w", err)
+        }
+        analysis.ID = id
+
+        if _, err := tx.ExecContext(ctx, "DELETE FROM trading_signals WHERE analysis_id = $1", id); err != nil {
+            return fmt.Errorf("clear trading signals: %w", err)
+        }
+        for _, signal := range analysis.Signals {
+            _, err := tx.ExecContext(ctx, `
+                INSERT INTO trading_signals (id, analysis_id, symbol, type, strength, description, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7)
+            `, uuid.New(), id, analysis.AssetSymbol, signal.Type, signal.Strength, signal.Description, signal.CreatedAt)
+            if err != nil {
+                return fmt.Errorf("insert trading signal: %w", err)
+            }
+        }
+        return nil
+    })
+}
+
+// GetMarketAnalysis returns the latest analysis of symbol with its trading
+// signals. The error wraps sql.ErrNoRows when symbol has none.
+func (r *MarketAnalysisRepository) GetMarketAnalysis(ctx context.Context, symbol string) (*models.MarketAnalysis, error) {
+    var analysis models.MarketAnalysis
+    err := r.db.QueryRowContext(ctx, `
+        SELECT id, asset_symbol, sentiment, volume_24h, price_change_24h, trend_strength, updated_at
+        FROM market_analysis
+        WHERE asset_symbol = $1
+    `, symbol).Scan(
+        &analysis.ID,
+        &analysis.AssetSymbol,
+        &analysis.Sentiment,
+        &analysis.Volume24h,
+        &analysis.PriceChange24h,
+        &analysis.TrendStrength,
+        &analysis.UpdatedAt,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("get market analysis: %w", err)
+    }
+
+    rows, err := r.db.QueryContext(ctx, `
+        SELECT type, strength, description, created_at
+        FROM trading_signals
+        WHERE analysis_id = $1
+        ORDER BY created_at
+    `, analysis.ID)
+    if err != nil {
+        return nil, fmt.Errorf("get trading signals: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var signal models.Signal
+        if err := rows.Scan(&signal.Type, &signal.Strength, &signal.Description, &signal.CreatedAt); err != nil {
+            return nil, fmt.Errorf("scan trading signal: %w", err)
+        }
+        analysis.Signals = append(analysis.Signals, signal)
+    }
+    return &analysis, rows.Err()
+}
+
+// RecordMarketAnalysisHistory adds analysis to market_analysis_history.
+// Recording one twice keeps a single entry.
+func (r *MarketAnalysisRepository) RecordMarketAnalysisHistory(ctx context.Context, analysis *models.MarketAnalysis) error {
+    _, err := r.db.ExecContext(ctx, `
+        INSERT INTO market_analysis_history (analysis_id, asset_symbol, sentiment, volume_24h, price_change_24h, trend_strength, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (asset_symbol, updated_at) DO NOTHING
+    `,
+        analysis.ID,
+        analysis.AssetSymbol,
+        analysis.Sentiment,
+        analysis.Volume24h,
+        analysis.PriceChange24h,
+        analysis.TrendStrength,
+        analysis.UpdatedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("record market analysis history: %w", err)
+    }
+    return nil
+}
+
+// PruneMarketAnalysisHistory deletes the history of analyses updated before
+// cutoff, returning how many were deleted
+func (r *MarketAnalysisRepository) PruneMarketAnalysisHistory(ctx context.Context, cutoff time.Time) (int64, error) {
+    result, err := r.db.ExecContext(ctx, "DELETE FROM market_analysis_history WHERE updated_at < $1", cutoff)
+    if err != nil {
+        return 0, fmt.Errorf("prune market analysis history: %w", err)
+    }
+    return result.RowsAffected()
+}
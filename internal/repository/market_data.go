@@ -0,0 +1,291 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/QUOTRIX/WOLFAI/internal/database"
+    "github.com/QUOTRIX/WOLFAI/internal/models"
+)
+
+// Bytes per candle, leaving out the symbol and timestamp both tables share
+const (
+    // rawCandleBytes is OHLCV stored as five float64s
+    rawCandleBytes = 5 * 8
+    // compressedCandleBytes is four int16 price deltas and a float64 volume
+    compressedCandleBytes = 4*2 + 8
+    // baseBytes is the float64 base close stored with the first candle of a run
+    baseBytes = 8
+)
+
+// compressedInsertBatchSize is the most candles sent in one INSERT
+const compressedInsertBatchSize = 500
+
+var ErrCandleTooWide = errors.New("candle range too wide to delta-encode")
+
+// queryer is satisfied by both the database and a transaction
+type queryer interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// CompressedMarketDataRepository stores price history delta-encoded in
+// market_data_compressed. Candles are kept in runs: the first candle of a
+// run stores its close as base_close, and every candle stores its prices as
+// int16 hundredths relative to the base of its run.
+type CompressedMarketDataRepository struct {
+    db *database.DB
+}
+
+func NewCompressedMarketDataRepository(db *database.DB) *CompressedMarketDataRepository {
+    return &CompressedMarketDataRepository{db: db}
+}
+
+// BulkStore stores candles for symbol, replacing any stored with the same
+// timestamps. A candle too far from its run's base starts a new run.
+//
+// Stored candles decode against the latest base before them, which the new
+// candles may replace. So the stored candles within the new ones' span are
+// stored again with them, and the first one after it becomes a base with
+// its encoding unchanged.
+func (r *CompressedMarketDataRepository) BulkStore(ctx context.Context, symbol string, candles []models.OHLCV) error {
+    if len(candles) == 0 {
+        return nil
+    }
+
+    sorted := append([]models.OHLCV(nil), candles...)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+    })
+    from, to := sorted[0].Timestamp, sorted[len(sorted)-1].Timestamp
+
+    return r.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        qb := database.NewQueryBuilder()
+        qb.AddParam("symbol", symbol)
+        qb.AddParam("to", to)
+        query, args := qb.Build(`
+            SELECT MIN(timestamp) FROM market_data_compressed
+            WHERE symbol = @symbol AND timestamp > @to
+        `)
+        var next sql.NullTime
+        if err := tx.QueryRowContext(ctx, query, args...).Scan(&next); err != nil {
+            return fmt.Errorf("find next candle: %w", err)
+        }
+
+        end := to
+        if next.Valid {
+            end = next.Time
+        }
+        stored, err := fetchCompressed(ctx, tx, symbol, from, end)
+        if err != nil {
+            return err
+        }
+
+        var successor *models.CompressedOHLCV
+        existing := make([]models.OHLCV, 0, len(stored))
+        for i := range stored {
+            if next.Valid && stored[i].Timestamp.Equal(next.Time) {
+                successor = &stored[i]
+                continue
+            }
+            existing = append(existing, stored[i].Decompress())
+        }
+
+        rows, err := compressCandles(mergeCandles(existing, sorted))
+        if err != nil {
+            return fmt.Errorf("compress %s candles: %w", symbol, err)
+        }
+        if successor != nil {
+            rows = append(rows, *successor)
+        }
+
+        qb = database.NewQueryBuilder()
+        qb.AddParam("symbol", symbol)
+        qb.AddParam("from", from)
+        qb.AddParam("end", end)
+        query, args = qb.Build(`
+            DELETE FROM market_data_compressed
+            WHERE symbol = @symbol AND timestamp >= @from AND timestamp <= @end
+        `)
+        if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+            return fmt.Errorf("replace %s candles: %w", symbol, err)
+        }
+
+        return insertCompressed(ctx, tx, symbol, rows)
+    })
+}
+
+// FetchRange returns symbol's candles from from through to, oldest first
+func (r *CompressedMarketDataRepository) FetchRange(ctx context.Context, symbol string, from, to time.Time) ([]models.OHLCV, error) {
+    stored, err := fetchCompressed(ctx, r.db, symbol, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    candles := make([]models.OHLCV, len(stored))
+    for i, c := range stored {
+        candles[i] = c.Decompress()
+    }
+    return candles, nil
+}
+
+// Stats counts the stored candles and the space delta encoding saves
+func (r *CompressedMarketDataRepository) Stats(ctx context.Context) (*models.MarketDataStats, error) {
+    var stats models.MarketDataStats
+    err := r.db.QueryRowContext(ctx, `
+        SELECT COUNT(DISTINCT symbol), COUNT(*), COUNT(base_close)
+        FROM market_data_compressed
+    `).Scan(&stats.Symbols, &stats.Candles, &stats.Bases)
+    if err != nil {
+        return nil, fmt.Errorf("market data stats: %w", err)
+    }
+
+    stats.RawBytes = stats.Candles * rawCandleBytes
+    stats.CompressedBytes = stats.Candles*compressedCandleBytes + stats.Bases*baseBytes
+    if stats.RawBytes > 0 {
+        stats.StorageSavingsPct = 100 * (1 - float64(stats.CompressedBytes)/float64(stats.RawBytes))
+    }
+    return &stats, nil
+}
+
+// fetchCompressed returns symbol's candles from from through to, each with
+// the base of its run. The query starts at the latest base at or before
+// from, so candles before from are read only to carry that base forward.
+func fetchCompressed(ctx context.Context, q queryer, symbol string, from, to time.Time) ([]models.CompressedOHLCV, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("symbol", symbol)
+    qb.AddParam("from", from)
+    qb.AddParam("to", to)
+
+    query, args := qb.Build(`
+        SELECT timestamp, base_close, delta_open, delta_high, delta_low, delta_close, volume
+        FROM market_data_compressed
+        WHERE symbol = @symbol AND timestamp <= @to AND timestamp >= COALESCE((
+            SELECT MAX(timestamp) FROM market_data_compressed
+            WHERE symbol = @symbol AND base_close IS NOT NULL AND timestamp <= @from
+        ), @from)
+        ORDER BY timestamp
+    `)
+
+    rows, err := q.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("fetch %s candles: %w", symbol, err)
+    }
+    defer rows.Close()
+
+    var candles []models.CompressedOHLCV
+    var base sql.NullFloat64
+    for rows.Next() {
+        var c models.CompressedOHLCV
+        var rowBase sql.NullFloat64
+        err := rows.Scan(
+            &c.Timestamp,
+            &rowBase,
+            &c.DeltaOpen,
+            &c.DeltaHigh,
+            &c.DeltaLow,
+            &c.DeltaClose,
+            &c.Volume,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("scan candle: %w", err)
+        }
+        if rowBase.Valid {
+            base = rowBase
+        }
+        if !base.Valid {
+            return nil, fmt.Errorf("%s candle at %s has no base", symbol, c.Timestamp.Format(time.RFC3339))
+        }
+        if c.Timestamp.Before(from) {
+            continue
+        }
+        c.BaseClose = base.Float64
+        candles = append(candles, c)
+    }
+
+    return candles, rows.Err()
+}
+
+// insertCompressed writes rows, storing base_close only where a run starts.
+// The first row always stores it, so rows never depend on ones before them.
+func insertCompressed(ctx context.Context, tx *sql.Tx, symbol string, rows []models.CompressedOHLCV) error {
+    for start := 0; start < len(rows); start += compressedInsertBatchSize {
+        end := start + compressedInsertBatchSize
+        if end > len(rows) {
+            end = len(rows)
+        }
+
+        qb := database.NewQueryBuilder()
+        values := make([]string, 0, end-start)
+        for i := start; i < end; i++ {
+            var base interface{}
+            if i == 0 || rows[i].BaseClose != rows[i-1].BaseClose {
+                base = rows[i].BaseClose
+            }
+            values = append(values, fmt.Sprintf("(%s, %s, %s, %s, %s, %s, %s, %s)",
+                qb.Arg(symbol),
+                qb.Arg(rows[i].Timestamp),
+                qb.Arg(base),
+                qb.Arg(rows[i].DeltaOpen),
+                qb.Arg(rows[i].DeltaHigh),
+                qb.Arg(rows[i].DeltaLow),
+                qb.Arg(rows[i].DeltaClose),
+                qb.Arg(rows[i].Volume),
+            ))
+        }
+
+        query, args := qb.Build(`
+            INSERT INTO market_data_compressed
+                (symbol, timestamp, base_close, delta_open, delta_high, delta_low, delta_close, volume)
+            VALUES ` + strings.Join(values, ", "))
+        if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+            return fmt.Errorf("insert %s candles %d-%d: %w", symbol, start, end-1, err)
+        }
+    }
+    return nil
+}
+
+// compressCandles delta-encodes candles, which are in time order. The first
+// candle starts a run, as does any candle too far from the current base.
+func compressCandles(candles []models.OHLCV) ([]models.CompressedOHLCV, error) {
+    compressed := make([]models.CompressedOHLCV, len(candles))
+    var base float64
+    for i, candle := range candles {
+        c, ok := models.CompressOHLCV(candle, base)
+        if i == 0 || !ok {
+            base = candle.Close
+            if c, ok = models.CompressOHLCV(candle, base); !ok {
+                return nil, fmt.Errorf("%w: %s spans %.2f-%.2f", ErrCandleTooWide,
+                    candle.Timestamp.Format(time.RFC3339), candle.Low, candle.High)
+            }
+        }
+        compressed[i] = c
+    }
+    return compressed, nil
+}
+
+// mergeCandles merges two time-ordered series. A candle in update replaces
+// one in existing with the same timestamp, and of several in update with
+// one timestamp the last is kept.
+func mergeCandles(existing, update []models.OHLCV) []models.OHLCV {
+    merged := make([]models.OHLCV, 0, len(existing)+len(update))
+    i := 0
+    for j, candle := range update {
+        if j+1 < len(update) && update[j+1].Timestamp.Equal(candle.Timestamp) {
+            continue
+        }
+        for i < len(existing) && existing[i].Timestamp.Before(candle.Timestamp) {
+            merged = append(merged, existing[i])
+            i++
+        }
+        if i < len(existing) && existing[i].Timestamp.Equal(candle.Timestamp) {
+            i++
+        }
+        merged = append(merged, candle)
+    }
+    return append(merged, existing[i:]...)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+    "context"
+    "errors"
+    "math"
+    "math/rand"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/QUOTRIX/WOLFAI/internal/database"
+    "github.com/QUOTRIX/WOLFAI/internal/models"
+)
+
+func TestCompressCandles_RoundTrip(t *testing.T) {
+    rng := rand.New(rand.NewSource(7))
+    start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+    // A random walk with a few gaps too large for one run
+    candles := make([]models.OHLCV, 1000)
+    price := 150.0
+    for i := range candles {
+        if i%250 == 249 {
+            price += 400
+        }
+        open := price
+        price += rng.NormFloat64() * 2
+        candles[i] = models.OHLCV{
+            Timestamp: start.Add(time.Duration(i) * time.Hour),
+            Open:      open,
+            High:      math.Max(open, price) + rng.Float64(),
+            Low:       math.Min(open, price) - rng.Float64(),
+            Close:     price,
+            Volume:    rng.Float64() * 1e6,
+        }
+    }
+
+    compressed, err := compressCandles(candles)
+    if !assert.NoError(t, err) || !assert.Len(t, compressed, len(candles)) {
+        return
+    }
+
+    bases := map[float64]bool{}
+    for i, c := range compressed {
+        bases[c.BaseClose] = true
+        got := c.Decompress()
+        want := candles[i]
+        assert.True(t, got.Timestamp.Equal(want.Timestamp))
+        assert.InDelta(t, want.Open, got.Open, 0.01)
+        assert.InDelta(t, want.High, got.High, 0.01)
+        assert.InDelta(t, want.Low, got.Low, 0.01)
+        assert.InDelta(t, want.Close, got.Close, 0.01)
+        assert.Equal(t, want.Volume, got.Volume)
+    }
+    assert.GreaterOrEqual(t, len(bases), 4, "each gap should start a new run")
+
+    wide := models.OHLCV{Timestamp: start, Open: 60000, High: 61000, Low: 59000, Close: 60500}
+    _, err = compressCandles([]models.OHLCV{wide})
+    assert.True(t, errors.Is(err, ErrCandleTooWide))
+}
+
+func TestCompressedMarketDataRepository_FetchRange(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+    from := start.Add(time.Hour)
+    to := start.Add(3 * time.Hour)
+
+    // The query starts at the base before from, whose candle is dropped
+    mock.ExpectQuery("SELECT timestamp, base_close, (.+) FROM market_data_compressed").
+        WillReturnRows(sqlmock.NewRows([]string{"timestamp", "base_close", "delta_open", "delta_high", "delta_low", "delta_close", "volume"}).
+            AddRow(start, 100.0, 0, 50, -50, 0, 10.0).
+            AddRow(from, nil, 0, 150, -20, 125, 11.0).
+            AddRow(start.Add(2*time.Hour), 600.0, -100, 20, -110, 0, 12.0).
+            AddRow(to, nil, 0, 30, -10, 25, 13.0))
+
+    repo := NewCompressedMarketDataRepository(database.New(db))
+    candles, err := repo.FetchRange(context.Background(), "AAPL", from, to)
+    if !assert.NoError(t, err) || !assert.Len(t, candles, 3) {
+        return
+    }
+    assert.Equal(t, models.OHLCV{Timestamp: from, Open: 100, High: 101.5, Low: 99.8, Close: 101.25, Volume: 11}, candles[0])
+    assert.Equal(t, models.OHLCV{Timestamp: start.Add(2 * time.Hour), Open: 599, High: 600.2, Low: 598.9, Close: 600, Volume: 12}, candles[1])
+    assert.Equal(t, 600.25, candles[2].Close)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
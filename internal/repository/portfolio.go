@@ -2,11 +2,24 @@ package repository
 
 import (
     "context"
+    "database/sql"
+    "errors"
     "fmt"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/google/uuid"
+    "github.com/lib/pq"
+
     "github.com/QUOTRIX/WOLFAI/internal/database"
     "github.com/QUOTRIX/WOLFAI/internal/models"
+    portfoliosvc "github.com/QUOTRIX/WOLFAI/internal/services/portfolio"
 )
 
+// ErrPortfolioNotFound is returned for a portfolio that doesn't exist or
+// that the user doesn't own
+var ErrPortfolioNotFound = errors.New("portfolio not found")
+
 type PortfolioRepository struct {
     db *database.DB
 }
@@ -21,12 +34,13 @@ func (r *PortfolioRepository) Create(ctx context.Context, portfolio *models.Port
     qb.AddParam("name", portfolio.Name)
     qb.AddParam("description", portfolio.Description)
     qb.AddParam("balance", portfolio.Balance)
+    qb.AddParam("margin_enabled", portfolio.MarginEnabled)
     qb.AddParam("risk", portfolio.Risk)
     qb.AddParam("strategy", portfolio.Strategy)
 
     query, args := qb.Build(`
-        INSERT INTO portfolios (user_id, name, description, balance, risk, strategy)
-        VALUES (@user_id, @name, @description, @balance, @risk, @strategy)
+        INSERT INTO portfolios (user_id, name, description, balance, margin_enabled, risk, strategy)
+        VALUES (@user_id, @name, @description, @balance, @margin_enabled, @risk, @strategy)
         RETURNING id, created_at, updated_at
     `)
 
@@ -40,7 +54,8 @@ func (r *PortfolioRepository) Create(ctx context.Context, portfolio *models.Port
             return fmt.Errorf("create portfolio: %w", err)
         }
 
-        return nil
+        return portfoliosvc.AppendEvent(ctx, tx, portfolio.ID, portfoliosvc.EventPortfolioCreated,
+            portfoliosvc.NewPortfolioDetails(portfolio))
     })
 }
 
@@ -50,7 +65,7 @@ func (r *PortfolioRepository) Get(ctx context.Context, id, userID int64) (*model
     qb.AddParam("user_id", userID)
 
     query, args := qb.Build(`
-        SELECT id, user_id, name, description, balance, risk, strategy, created_at, updated_at
+        SELECT id, user_id, name, description, balance, margin_enabled, risk, strategy, created_at, updated_at
         FROM portfolios
         WHERE id = @id AND user_id = @user_id
     `)
@@ -62,6 +77,7 @@ func (r *PortfolioRepository) Get(ctx context.Context, id, userID int64) (*model
         &portfolio.Name,
         &portfolio.Description,
         &portfolio.Balance,
+        &portfolio.MarginEnabled,
         &portfolio.Risk,
         &portfolio.Strategy,
         &portfolio.CreatedAt,
@@ -71,19 +87,27 @@ func (r *PortfolioRepository) Get(ctx context.Context, id, userID int64) (*model
         return nil, fmt.Errorf("get portfolio: %w", err)
     }
 
+    portfolio.CashBalance, err = portfoliosvc.LoadCash(ctx, r.db, portfolio.ID, portfolio.Balance)
+    if err != nil {
+        return nil, fmt.Errorf("get portfolio: %w", err)
+    }
+
     return &portfolio, nil
 }
 
-func (r *PortfolioRepository) List(ctx context.Context, userID int64, limit, offset int) ([]models.Portfolio, error) {
+// List returns a page of the user's portfolios, newest first. A non-nil
+// paperTrading lists only the paper trading portfolios, or only the others.
+func (r *PortfolioRepository) List(ctx context.Context, userID uuid.UUID, paperTrading *bool, limit, offset int) ([]models.Portfolio, error) {
     qb := database.NewQueryBuilder()
     qb.AddParam("user_id", userID)
+    qb.AddParam("paper_trading", paperTrading)
     qb.AddParam("limit", database.SafeLimit(limit))
     qb.AddParam("offset", database.SafeOffset(offset))
 
     query, args := qb.Build(`
-        SELECT id, user_id, name, description, balance, risk, strategy, created_at, updated_at
+        SELECT id, user_id, name, description, balance, margin_enabled, risk, strategy, paper_trading, fork_of_id, created_at, updated_at
         FROM portfolios
-        WHERE user_id = @user_id
+        WHERE user_id = @user_id AND (@paper_trading::boolean IS NULL OR paper_trading = @paper_trading)
         ORDER BY created_at DESC
         LIMIT @limit OFFSET @offset
     `)
@@ -103,8 +127,11 @@ func (r *PortfolioRepository) List(ctx context.Context, userID int64, limit, off
             &p.Name,
             &p.Description,
             &p.Balance,
+            &p.MarginEnabled,
             &p.Risk,
             &p.Strategy,
+            &p.PaperTrading,
+            &p.ForkOfID,
             &p.CreatedAt,
             &p.UpdatedAt,
         )
@@ -114,7 +141,200 @@ func (r *PortfolioRepository) List(ctx context.Context, userID int64, limit, off
         portfolios = append(portfolios, p)
     }
 
-    return portfolios, nil
+    return portfolios, rows.Err()
+}
+
+// Fork creates a paper trading portfolio named name from the user's
+// portfolio sourceID, with its balance, settings and copies of its
+// positions. Forking a portfolio the user doesn't own returns
+// ErrPortfolioNotFound.
+func (r *PortfolioRepository) Fork(ctx context.Context, sourceID int64, userID uuid.UUID, name string) (*models.Portfolio, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("source_id", sourceID)
+    qb.AddParam("user_id", userID)
+    qb.AddParam("name", name)
+
+    query, args := qb.Build(`
+        INSERT INTO portfolios (user_id, name, description, balance, margin_enabled, risk, strategy, paper_trading, fork_of_id)
+        SELECT user_id, @name, description, balance, margin_enabled, risk, strategy, TRUE, id
+        FROM portfolios
+        WHERE id = @source_id AND user_id = @user_id
+        RETURNING id, user_id, name, description, balance, margin_enabled, risk, strategy, paper_trading, fork_of_id, created_at, updated_at
+    `)
+
+    var fork models.Portfolio
+    err := r.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        err := tx.QueryRowContext(ctx, query, args...).Scan(
+            &fork.ID,
+            &fork.UserID,
+            &fork.Name,
+            &fork.Description,
+            &fork.Balance,
+            &fork.MarginEnabled,
+            &fork.Risk,
+            &fork.Strategy,
+            &fork.PaperTrading,
+            &fork.ForkOfID,
+            &fork.CreatedAt,
+            &fork.UpdatedAt,
+        )
+        if errors.Is(err, sql.ErrNoRows) {
+            return ErrPortfolioNotFound
+        }
+        if err != nil {
+            return fmt.Errorf("fork portfolio: %w", err)
+        }
+
+        fork.Positions, err = copyPositions(ctx, tx, sourceID, fork.ID)
+        if err != nil {
+            return fmt.Errorf("copy positions: %w", err)
+        }
+
+        details := portfoliosvc.NewPortfolioDetails(&fork)
+        for _, pos := range fork.Positions {
+            details.Positions = append(details.Positions, portfoliosvc.PositionChange{
+                Symbol:     pos.Symbol,
+                Quantity:   pos.Quantity,
+                EntryPrice: pos.EntryPrice,
+            })
+        }
+        return portfoliosvc.AppendEvent(ctx, tx, fork.ID, portfoliosvc.EventPortfolioCreated, details)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &fork, nil
+}
+
+// copyPositions copies the positions of portfolio from to portfolio to and
+// returns the copies
+func copyPositions(ctx context.Context, tx *sql.Tx, from, to int64) ([]models.Position, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("from", from)
+    qb.AddParam("to", to)
+    query, args := qb.Build(`
+        INSERT INTO positions (portfolio_id, symbol, quantity, entry_price)
+        SELECT @to, symbol, quantity, entry_price
+        FROM positions
+        WHERE portfolio_id = @from
+        RETURNING id, portfolio_id, symbol, quantity, entry_price, created_at, updated_at
+    `)
+
+    rows, err := tx.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var positions []models.Position
+    for rows.Next() {
+        var pos models.Position
+        if err := rows.Scan(&pos.ID, &pos.PortfolioID, &pos.Symbol, &pos.Quantity, &pos.EntryPrice, &pos.CreatedAt, &pos.UpdatedAt); err != nil {
+            return nil, err
+        }
+        positions = append(positions, pos)
+    }
+    return positions, rows.Err()
+}
+
+// CountByUser returns how many portfolios the user has
+func (r *PortfolioRepository) CountByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("user_id", userID)
+
+    query, args := qb.Build(`
+        SELECT COUNT(*) FROM portfolios WHERE user_id = @user_id
+    `)
+
+    var count int
+    if err := r.db.QueryRowSafe(ctx, query, args...).Scan(&count); err != nil {
+        return 0, fmt.Errorf("count portfolios: %w", err)
+    }
+    return count, nil
+}
+
+// Rank returns how many of the user's portfolios were created before the
+// given one, so their oldest is 0. A portfolio the user doesn't own ranks 0.
+func (r *PortfolioRepository) Rank(ctx context.Context, id int64, userID uuid.UUID) (int, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("id", id)
+    qb.AddParam("user_id", userID)
+
+    query, args := qb.Build(`
+        SELECT COUNT(*)
+        FROM portfolios o
+        JOIN portfolios p ON p.id = @id AND p.user_id = @user_id
+        WHERE o.user_id = @user_id AND (o.created_at, o.id) < (p.created_at, p.id)
+    `)
+
+    var rank int
+    if err := r.db.QueryRowSafe(ctx, query, args...).Scan(&rank); err != nil {
+        return 0, fmt.Errorf("rank portfolio: %w", err)
+    }
+    return rank, nil
+}
+
+// Search query length bounds, in characters
+const (
+    minSearchQueryLength = 2
+    maxSearchQueryLength = 200
+)
+
+var ErrSearchQueryTooShort = errors.New("search query must be at least 2 characters")
+
+// Search returns the user's portfolios whose name or description match the
+// words of query, best matches first
+func (r *PortfolioRepository) Search(ctx context.Context, userID uuid.UUID, query string, limit int) ([]*models.Portfolio, error) {
+    query = strings.Join(strings.Fields(query), " ")
+    if utf8.RuneCountInString(query) < minSearchQueryLength {
+        return nil, ErrSearchQueryTooShort
+    }
+    if utf8.RuneCountInString(query) > maxSearchQueryLength {
+        query = string([]rune(query)[:maxSearchQueryLength])
+    }
+
+    qb := database.NewQueryBuilder()
+    qb.AddParam("user_id", userID)
+    qb.AddParam("query", query)
+    qb.AddParam("limit", database.SafeLimit(limit))
+
+    // plainto_tsquery treats the query as plain words, so operators in it
+    // are never interpreted
+    sqlQuery, args := qb.Build(`
+        SELECT id, user_id, name, description, balance, risk, strategy, created_at, updated_at
+        FROM portfolios
+        WHERE search_vector @@ plainto_tsquery('english', @query) AND user_id = @user_id
+        ORDER BY ts_rank(search_vector, plainto_tsquery('english', @query)) DESC, created_at DESC
+        LIMIT @limit
+    `)
+
+    rows, err := r.db.QuerySafe(ctx, sqlQuery, args...)
+    if err != nil {
+        return nil, fmt.Errorf("search portfolios: %w", err)
+    }
+    defer rows.Close()
+
+    var portfolios []*models.Portfolio
+    for rows.Next() {
+        var p models.Portfolio
+        err := rows.Scan(
+            &p.ID,
+            &p.UserID,
+            &p.Name,
+            &p.Description,
+            &p.Balance,
+            &p.Risk,
+            &p.Strategy,
+            &p.CreatedAt,
+            &p.UpdatedAt,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("scan portfolio: %w", err)
+        }
+        portfolios = append(portfolios, &p)
+    }
+
+    return portfolios, rows.Err()
 }
 
 func (r *PortfolioRepository) Update(ctx context.Context, portfolio *models.Portfolio) error {
@@ -124,6 +344,7 @@ func (r *PortfolioRepository) Update(ctx context.Context, portfolio *models.Port
     qb.AddParam("name", portfolio.Name)
     qb.AddParam("description", portfolio.Description)
     qb.AddParam("balance", portfolio.Balance)
+    qb.AddParam("margin_enabled", portfolio.MarginEnabled)
     qb.AddParam("risk", portfolio.Risk)
     qb.AddParam("strategy", portfolio.Strategy)
 
@@ -132,29 +353,34 @@ func (r *PortfolioRepository) Update(ctx context.Context, portfolio *models.Port
         SET name = @name,
             description = @description,
             balance = @balance,
+            margin_enabled = @margin_enabled,
             risk = @risk,
             strategy = @strategy,
             updated_at = NOW()
         WHERE id = @id AND user_id = @user_id
     `)
 
-    result, err := r.db.ExecSafe(ctx, query, args...)
-    if err != nil {
-        return fmt.Errorf("update portfolio: %w", err)
-    }
+    return r.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        result, err := tx.ExecContext(ctx, query, args...)
+        if err != nil {
+            return fmt.Errorf("update portfolio: %w", err)
+        }
 
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return fmt.Errorf("get rows affected: %w", err)
-    }
+        rows, err := result.RowsAffected()
+        if err != nil {
+            return fmt.Errorf("get rows affected: %w", err)
+        }
 
-    if rows == 0 {
-        return fmt.Errorf("portfolio not found")
-    }
+        if rows == 0 {
+            return fmt.Errorf("portfolio not found")
+        }
 
-    return nil
+        return portfoliosvc.AppendEvent(ctx, tx, portfolio.ID, portfoliosvc.EventPortfolioUpdated,
+            portfoliosvc.NewPortfolioDetails(portfolio))
+    })
 }
 
+// Delete removes the portfolio outright, and its events with it
 func (r *PortfolioRepository) Delete(ctx context.Context, id, userID int64) error {
     qb := database.NewQueryBuilder()
     qb.AddParam("id", id)
@@ -180,4 +406,112 @@ func (r *PortfolioRepository) Delete(ctx context.Context, id, userID int64) erro
     }
 
     return nil
-}
\ No newline at end of file
+}
+
+// BulkDeleteResult reports which of the portfolios in a bulk delete were
+// deleted and why the others weren't
+type BulkDeleteResult struct {
+    DeletedCount    int     `json:"deleted_count"`
+    NotFoundIDs     []int64 `json:"not_found_ids"`
+    UnauthorizedIDs []int64 `json:"unauthorized_ids"`
+}
+
+// BulkDelete removes the portfolios among ids that the user owns, as Delete
+// does, in one transaction. The others are reported as not found, or as
+// unauthorized when another user owns them.
+func (r *PortfolioRepository) BulkDelete(ctx context.Context, userID uuid.UUID, ids []int64) (*BulkDeleteResult, error) {
+    result := &BulkDeleteResult{NotFoundIDs: []int64{}, UnauthorizedIDs: []int64{}}
+    if len(ids) == 0 {
+        return result, nil
+    }
+
+    err := r.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+        owners, err := portfolioOwners(ctx, tx, ids)
+        if err != nil {
+            return fmt.Errorf("look up portfolios: %w", err)
+        }
+
+        owned := make([]int64, 0, len(ids))
+        seen := make(map[int64]bool, len(ids))
+        for _, id := range ids {
+            if seen[id] {
+                continue
+            }
+            seen[id] = true
+            owner, ok := owners[id]
+            switch {
+            case !ok:
+                result.NotFoundIDs = append(result.NotFoundIDs, id)
+            case owner != userID:
+                result.UnauthorizedIDs = append(result.UnauthorizedIDs, id)
+            default:
+                owned = append(owned, id)
+            }
+        }
+        if len(owned) == 0 {
+            return nil
+        }
+
+        // Positions don't cascade with their portfolio
+        qb := database.NewQueryBuilder()
+        qb.AddParam("ids", pq.Array(owned))
+        query, args := qb.Build(`
+            DELETE FROM positions WHERE portfolio_id = ANY(@ids)
+        `)
+        if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+            return fmt.Errorf("delete positions: %w", err)
+        }
+
+        qb = database.NewQueryBuilder()
+        qb.AddParam("ids", pq.Array(owned))
+        qb.AddParam("user_id", userID)
+        query, args = qb.Build(`
+            DELETE FROM portfolios
+            WHERE id = ANY(@ids) AND user_id = @user_id
+        `)
+
+        res, err := tx.ExecContext(ctx, query, args...)
+        if err != nil {
+            return fmt.Errorf("delete portfolios: %w", err)
+        }
+        deleted, err := res.RowsAffected()
+        if err != nil {
+            return fmt.Errorf("get rows affected: %w", err)
+        }
+        result.DeletedCount = int(deleted)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// portfolioOwners locks the portfolios among ids that exist and returns
+// who owns each
+func portfolioOwners(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]uuid.UUID, error) {
+    qb := database.NewQueryBuilder()
+    qb.AddParam("ids", pq.Array(ids))
+    query, args := qb.Build(`
+        SELECT id, user_id FROM portfolios
+        WHERE id = ANY(@ids)
+        FOR UPDATE
+    `)
+
+    rows, err := tx.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    owners := make(map[int64]uuid.UUID)
+    for rows.Next() {
+        var id int64
+        var owner uuid.UUID
+        if err := rows.Scan(&id, &owner); err != nil {
+            return nil, err
+        }
+        owners[id] = owner
+    }
+    return owners, rows.Err()
+}
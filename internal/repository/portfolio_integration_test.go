@@ -0,0 +1,251 @@
+//go:build integration
+
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    _ "github.com/lib/pq"
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+    "github.com/testcontainers/testcontainers-go"
+    "github.com/testcontainers/testcontainers-go/modules/postgres"
+    "github.com/testcontainers/testcontainers-go/wait"
+
+    "github.com/QUOTRIX/WOLFAI/internal/database"
+    "github.com/QUOTRIX/WOLFAI/internal/models"
+)
+
+// newTestRepository starts Postgres with the portfolios table and the
+// migrations the repository relies on. Needs Docker:
+//   go test -tags integration ./internal/repository/
+func newTestRepository(t *testing.T) *PortfolioRepository {
+    ctx := context.Background()
+    container, err := postgres.RunContainer(ctx,
+        testcontainers.WithImage("postgres:15-alpine"),
+        postgres.WithDatabase("wolfai"),
+        postgres.WithUsername("wolfai"),
+        postgres.WithPassword("wolfai"),
+        testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+            WithOccurrence(2).WithStartupTimeout(time.Minute)),
+    )
+    if err != nil {
+        t.Fatalf("Failed to start postgres: %v", err)
+    }
+    t.Cleanup(func() { container.Terminate(ctx) })
+
+    dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+    if err != nil {
+        t.Fatalf("Failed to get connection string: %v", err)
+    }
+    conn, err := sql.Open("postgres", dsn)
+    if err != nil {
+        t.Fatalf("Failed to connect: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+
+    _, err = conn.ExecContext(ctx, `
+        CREATE TABLE portfolios (
+            id BIGSERIAL PRIMARY KEY,
+            user_id UUID NOT NULL,
+            name VARCHAR(255) NOT NULL,
+            description TEXT,
+            balance DECIMAL(20, 8) NOT NULL DEFAULT 0,
+            margin_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+            risk VARCHAR(20),
+            strategy VARCHAR(255),
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+        )`)
+    if err != nil {
+        t.Fatalf("Failed to create portfolios: %v", err)
+    }
+    _, err = conn.ExecContext(ctx, `
+        CREATE TABLE positions (
+            id BIGSERIAL PRIMARY KEY,
+            portfolio_id BIGINT REFERENCES portfolios(id),
+            symbol VARCHAR(20) NOT NULL,
+            quantity DECIMAL(20,8) NOT NULL,
+            entry_price DECIMAL(20,8) NOT NULL
+        )`)
+    if err != nil {
+        t.Fatalf("Failed to create positions: %v", err)
+    }
+    for _, name := range []string{
+        "000016_portfolio_search.up.sql",
+        "000023_portfolio_soft_delete.up.sql",
+        "000026_portfolio_events.up.sql",
+        "000027_paper_trading.up.sql",
+    } {
+        migration, err := os.ReadFile("../../migrations/" + name)
+        if err != nil {
+            t.Fatalf("Failed to read migration: %v", err)
+        }
+        if _, err := conn.ExecContext(ctx, string(migration)); err != nil {
+            t.Fatalf("Failed to apply migration %s: %v", name, err)
+        }
+    }
+
+    return NewPortfolioRepository(database.New(conn))
+}
+
+func TestPortfolioRepository_Search(t *testing.T) {
+    ctx := context.Background()
+    repo := newTestRepository(t)
+    user, other := uuid.New(), uuid.New()
+    for _, p := range []*models.Portfolio{
+        {UserID: user, Name: "Retirement Fund", Description: "Long-term index funds"},
+        {UserID: user, Name: "Crypto", Description: "High risk, not for retirement"},
+        {UserID: user, Name: "Trading", Description: "Short-term swing trades"},
+        {UserID: other, Name: "Retirement", Description: "Someone else's savings"},
+    } {
+        p.Balance = decimal.NewFromInt(1000)
+        if err := repo.Create(ctx, p); err != nil {
+            t.Fatalf("Failed to create portfolio: %v", err)
+        }
+    }
+
+    results, err := repo.Search(ctx, user, "retirement", 10)
+    if !assert.NoError(t, err) {
+        return
+    }
+    var names []string
+    for _, p := range results {
+        assert.Equal(t, user, p.UserID)
+        names = append(names, p.Name)
+    }
+    // Matches in either the name or the description, only the user's own
+    assert.ElementsMatch(t, []string{"Retirement Fund", "Crypto"}, names)
+
+    results, err = repo.Search(ctx, user, "retirement crypto", 10)
+    assert.NoError(t, err)
+    if assert.Len(t, results, 1) {
+        assert.Equal(t, "Crypto", results[0].Name)
+    }
+
+    results, err = repo.Search(ctx, user, "retirement", 1)
+    assert.NoError(t, err)
+    assert.Len(t, results, 1)
+
+    results, err = repo.Search(ctx, user, "!&|", 10)
+    assert.NoError(t, err)
+    assert.Empty(t, results)
+
+    _, err = repo.Search(ctx, user, " r ", 10)
+    assert.ErrorIs(t, err, ErrSearchQueryTooShort)
+}
+
+func TestPortfolioRepository_BulkDelete(t *testing.T) {
+    ctx := context.Background()
+    repo := newTestRepository(t)
+    user, other := uuid.New(), uuid.New()
+
+    var mine, theirs []int64
+    for _, p := range []*models.Portfolio{
+        {UserID: user, Name: "Retirement Fund"},
+        {UserID: user, Name: "Crypto"},
+        {UserID: user, Name: "Trading"},
+        {UserID: other, Name: "Savings"},
+    } {
+        if err := repo.Create(ctx, p); err != nil {
+            t.Fatalf("Failed to create portfolio: %v", err)
+        }
+        if p.UserID == user {
+            mine = append(mine, p.ID)
+        } else {
+            theirs = append(theirs, p.ID)
+        }
+    }
+    missing := theirs[0] + 1000
+    _, err := repo.db.ExecContext(ctx, `
+        INSERT INTO positions (portfolio_id, symbol, quantity, entry_price) VALUES ($1, 'BTC', 1, 30000)
+    `, mine[0])
+    if err != nil {
+        t.Fatalf("Failed to create position: %v", err)
+    }
+
+    // Repeated IDs are only counted once
+    result, err := repo.BulkDelete(ctx, user, []int64{mine[0], mine[1], mine[0], theirs[0], missing})
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, 2, result.DeletedCount)
+    assert.Equal(t, []int64{theirs[0]}, result.UnauthorizedIDs)
+    assert.Equal(t, []int64{missing}, result.NotFoundIDs)
+
+    count, err := repo.CountByUser(ctx, user)
+    assert.NoError(t, err)
+    assert.Equal(t, 1, count)
+    count, err = repo.CountByUser(ctx, other)
+    assert.NoError(t, err)
+    assert.Equal(t, 1, count)
+    err = repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM positions`).Scan(&count)
+    assert.NoError(t, err)
+    assert.Equal(t, 0, count)
+}
+
+func TestPortfolioRepository_Fork(t *testing.T) {
+    ctx := context.Background()
+    repo := newTestRepository(t)
+    user, other := uuid.New(), uuid.New()
+
+    source := &models.Portfolio{UserID: user, Name: "Live", Description: "Real money", Balance: decimal.NewFromInt(5000)}
+    if err := repo.Create(ctx, source); err != nil {
+        t.Fatalf("Failed to create portfolio: %v", err)
+    }
+    _, err := repo.db.ExecContext(ctx, `
+        INSERT INTO positions (portfolio_id, symbol, quantity, entry_price) VALUES ($1, 'BTC', 1, 30000)
+    `, source.ID)
+    if err != nil {
+        t.Fatalf("Failed to create position: %v", err)
+    }
+
+    fork, err := repo.Fork(ctx, source.ID, user, "Sandbox")
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, "Sandbox", fork.Name)
+    assert.Equal(t, "Real money", fork.Description)
+    assert.True(t, fork.Balance.Equal(decimal.NewFromInt(5000)))
+    assert.True(t, fork.PaperTrading)
+    if assert.NotNil(t, fork.ForkOfID) {
+        assert.Equal(t, source.ID, *fork.ForkOfID)
+    }
+    if assert.Len(t, fork.Positions, 1) {
+        assert.Equal(t, fork.ID, fork.Positions[0].PortfolioID)
+        assert.Equal(t, "BTC", fork.Positions[0].Symbol)
+    }
+
+    // Trading the fork leaves the source's positions alone
+    _, err = repo.db.ExecContext(ctx, `UPDATE positions SET quantity = 3 WHERE portfolio_id = $1`, fork.ID)
+    assert.NoError(t, err)
+    var quantity decimal.Decimal
+    err = repo.db.QueryRowContext(ctx, `SELECT quantity FROM positions WHERE portfolio_id = $1`, source.ID).Scan(&quantity)
+    assert.NoError(t, err)
+    assert.True(t, quantity.Equal(decimal.NewFromInt(1)), "source quantity %s", quantity)
+
+    _, err = repo.Fork(ctx, source.ID, other, "Stolen")
+    assert.ErrorIs(t, err, ErrPortfolioNotFound)
+
+    paper, live := true, false
+    for filter, want := range map[*bool][]int64{
+        nil:    {fork.ID, source.ID},
+        &paper: {fork.ID},
+        &live:  {source.ID},
+    } {
+        portfolios, err := repo.List(ctx, user, filter, 10, 0)
+        if !assert.NoError(t, err) {
+            continue
+        }
+        var ids []int64
+        for _, p := range portfolios {
+            ids = append(ids, p.ID)
+        }
+        assert.Equal(t, want, ids)
+    }
+}
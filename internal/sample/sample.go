@@ -0,0 +1,99 @@
+// Package sample decides whether a statistic has enough observations to be
+// reported. A Sharpe ratio from three days of returns looks as confident as
+// one from three years, so statistics below their minimum are reported as
+// null with a Note saying why.
+package sample
+
+import "fmt"
+
+// Metric names a statistic with a minimum number of observations
+type Metric string
+
+const (
+    Volatility     Metric = "volatility"
+    SharpeRatio    Metric = "sharpe_ratio"
+    SortinoRatio   Metric = "sortino_ratio"
+    VaR            Metric = "var"
+    Correlation    Metric = "correlation"
+    ExpectedReturn Metric = "expected_return"
+    // Seasonality is the minimum of each seasonality bucket
+    Seasonality Metric = "seasonality"
+)
+
+// Portfolio returns are measured from days of snapshots, and need at least
+// as many days as they span rather than a Policy minimum
+const (
+    DailyReturn   Metric = "daily_return"
+    WeeklyReturn  Metric = "weekly_return"
+    MonthlyReturn Metric = "monthly_return"
+    YearlyReturn  Metric = "yearly_return"
+)
+
+// Policy is the minimum observations of each Metric. Metrics it leaves out,
+// and those of a nil Policy, have no minimum.
+type Policy map[Metric]int
+
+// DefaultPolicy asks for three weeks of daily returns for volatility, which
+// the risk manager measures over 30 days, six for correlation and a quarter
+// for the ratios, tail risk and expected returns whose estimates converge
+// more slowly
+func DefaultPolicy() Policy {
+    return Policy{
+        Volatility:     15,
+        SharpeRatio:    60,
+        SortinoRatio:   60,
+        VaR:            60,
+        Correlation:    30,
+        ExpectedReturn: 60,
+        Seasonality:    20,
+    }
+}
+
+// Required returns the minimum observations of metric
+func (p Policy) Required(metric Metric) int {
+    return p[metric]
+}
+
+// Check returns the Note of a metric of symbol measured from observations,
+// or nil when that meets the metric's minimum
+func (p Policy) Check(metric Metric, symbol string, observations int) *Note {
+    required := p.Required(metric)
+    if observations >= required {
+        return nil
+    }
+    note := Insufficient(metric, symbol, observations, required)
+    return &note
+}
+
+// Insufficient returns the Note of a metric of symbol measured from
+// observations when it requires required
+func Insufficient(metric Metric, symbol string, observations, required int) Note {
+    return Note{
+        Metric:       metric,
+        Symbol:       symbol,
+        Observations: observations,
+        Required:     required,
+        Reason:       fmt.Sprintf("insufficient history (%d of %d required observations)", observations, required),
+    }
+}
+
+// Guard returns v when observations meet metric's minimum. Otherwise it
+// returns nil, adding the metric's Note to notes.
+func (p Policy) Guard(notes *[]Note, metric Metric, symbol string, observations int, v float64) *float64 {
+    if note := p.Check(metric, symbol, observations); note != nil {
+        *notes = append(*notes, *note)
+        return nil
+    }
+    return &v
+}
+
+// Note explains why a metric was reported as null
+type Note struct {
+    Metric Metric `json:"metric"`
+    // Symbol is what the metric was measured for: a symbol, a pair of them
+    // for correlations, a seasonality bucket or a portfolio
+    Symbol       string `json:"symbol,omitempty"`
+    Observations int    `json:"observations"`
+    Required     int    `json:"required"`
+    Reason       string `json:"reason"`
+}
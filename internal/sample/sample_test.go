@@ -0,0 +1,50 @@
+package sample
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+    policy := DefaultPolicy()
+
+    note := policy.Check(SharpeRatio, "AAPL", 12)
+    if !assert.NotNil(t, note) {
+        return
+    }
+    assert.Equal(t, Note{
+        Metric:       SharpeRatio,
+        Symbol:       "AAPL",
+        Observations: 12,
+        Required:     60,
+        Reason:       "insufficient history (12 of 60 required observations)",
+    }, *note)
+
+    t.Run("Threshold boundary", func(t *testing.T) {
+        assert.NotNil(t, policy.Check(SharpeRatio, "AAPL", 59))
+        assert.Nil(t, policy.Check(SharpeRatio, "AAPL", 60))
+        assert.Nil(t, policy.Check(SharpeRatio, "AAPL", 61))
+    })
+
+    t.Run("No minimum", func(t *testing.T) {
+        assert.Nil(t, Policy(nil).Check(VaR, "AAPL", 0))
+        assert.Nil(t, Policy{Volatility: 20}.Check(VaR, "AAPL", 1))
+    })
+}
+
+func TestPolicy_Guard(t *testing.T) {
+    policy := Policy{Volatility: 20}
+    var notes []Note
+
+    assert.Nil(t, policy.Guard(&notes, Volatility, "BTC", 19, 0.04))
+    if v := policy.Guard(&notes, Volatility, "ETH", 20, 0.05); assert.NotNil(t, v) {
+        assert.Equal(t, 0.05, *v)
+    }
+
+    // Only the metric returned as null has a note
+    if assert.Len(t, notes, 1) {
+        assert.Equal(t, "BTC", notes[0].Symbol)
+        assert.Equal(t, "insufficient history (19 of 20 required observations)", notes[0].Reason)
+    }
+}
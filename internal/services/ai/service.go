@@ -2,14 +2,21 @@ package ai
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	aimodels "github.com/Cryptoprojectsfun/quantai-clone/internal/ai/models"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 	"gonum.org/v1/gonum/stat"
 )
 
 type Service struct {
 	repository PredictionRepository
+
+	// model names the registered model predictions are made with, and
+	// modelConfig configures it
+	model       string
+	modelConfig aimodels.ModelConfig
 }
 
 type PredictionRepository interface {
@@ -18,12 +25,22 @@ type PredictionRepository interface {
 	SaveMarketAnalysis(ctx context.Context, analysis *models.MarketAnalysis) error
 }
 
+// NewService predicts with the ridge model, which every build registers
 func NewService(repo PredictionRepository) *Service {
 	return &Service{
 		repository: repo,
+		model:      aimodels.Ridge,
 	}
 }
 
+// WithModel predicts with the model registered under name, such as
+// aimodels.Transformer in builds with the gorgonia tag
+func (s *Service) WithModel(name string, config aimodels.ModelConfig) *Service {
+	s.model = name
+	s.modelConfig = config
+	return s
+}
+
 // GeneratePrediction creates a new price prediction using AI models
 func (s *Service) GeneratePrediction(ctx context.Context, symbol string, timeframe string) (*models.Prediction, error) {
 	// Fetch historical data
@@ -36,7 +53,10 @@ func (s *Service) GeneratePrediction(ctx context.Context, symbol string, timefra
 	indicators := s.calculateIndicators(historicalData)
 
 	// Run AI model prediction
-	prediction := s.runAIModel(historicalData, indicators)
+	prediction, err := s.runAIModel(ctx, symbol, historicalData, indicators)
+	if err != nil {
+		return nil, err
+	}
 
 	// Calculate confidence score
 	confidence := s.calculateConfidence(prediction, historicalData)
@@ -291,20 +311,53 @@ func (s *Service) getTimeframeValidation(timeframe string) time.Duration {
 }
 
 // AI Model Functions
-func (s *Service) runAIModel(data *HistoricalData, indicators []models.Indicator) AIModelPrediction {
+
+// runAIModel trains a fresh instance of the service's model on symbol's
+// history and predicts from it
+func (s *Service) runAIModel(ctx context.Context, symbol string, data *HistoricalData, indicators []models.Indicator) (AIModelPrediction, error) {
 	// Initialize prediction
 	pred := AIModelPrediction{}
 
-	// Prepare features for the model
-	features := s.prepareFeatures(data, indicators)
+	model, err := aimodels.New(s.model, s.modelConfig)
+	if err != nil {
+		return pred, err
+	}
+	if err := model.Train(ctx, &aimodels.TrainingData{
+		AssetSymbol: symbol,
+		Prices:      data.Prices,
+		Volumes:     data.Volumes,
+		Times:       data.Times,
+		Indicators:  indicators,
+	}); err != nil {
+		return pred, fmt.Errorf("failed to train %s model for %s: %w", s.model, symbol, err)
+	}
+
+	historical := make([]aimodels.OHLCV, len(data.Prices))
+	for i, price := range data.Prices {
+		historical[i] = aimodels.OHLCV{Open: price, High: price, Low: price, Close: price}
+		if i < len(data.Volumes) {
+			historical[i].Volume = data.Volumes[i]
+		}
+		if i < len(data.Times) {
+			historical[i].Time = data.Times[i]
+		}
+	}
 
 	// Run prediction model
-	pred.High, pred.Low = s.predictPrices(features)
+	output, err := model.Predict(ctx, &aimodels.PredictionInput{
+		AssetSymbol: symbol,
+		Historical:  historical,
+		Indicators:  indicators,
+	})
+	if err != nil {
+		return pred, fmt.Errorf("failed to predict %s with %s model: %w", symbol, s.model, err)
+	}
+	pred.High, pred.Low = output.PredictedHigh, output.PredictedLow
 
 	// Generate trading signal
 	pred.Signal = s.generateTradingSignal(pred, data)
 
-	return pred
+	return pred, nil
 }
 
 func (s *Service) calculateConfidence(pred AIModelPrediction, data *HistoricalData) float64 {
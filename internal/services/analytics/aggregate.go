@@ -0,0 +1,222 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gonum.org/v1/gonum/stat"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+)
+
+const (
+	// aggregateViewTTL is how long a user's aggregate view is cached
+	aggregateViewTTL = 15 * time.Minute
+	// aggregateLookbackDays is the snapshot history the aggregate view is
+	// measured over
+	aggregateLookbackDays = 365
+)
+
+// AggregateView combines the risk and return of all of a user's portfolios,
+// leaving out deleted portfolios and paper trading forks. Return statistics
+// are taken over the snapshot days all the portfolios share, and annualized
+// over the NYSE trading year.
+type AggregateView struct {
+	Portfolios int     `json:"portfolios"`
+	TotalValue float64 `json:"total_value"`
+	// WeightedSharpe is the portfolios' Sharpe ratios weighted by value
+	WeightedSharpe float64 `json:"weighted_sharpe"`
+	// AggregateBeta is the portfolios' betas weighted by value, which is the
+	// beta of their combined holdings
+	AggregateBeta float64 `json:"aggregate_beta"`
+	// CrossPortfolioCorrelation is the mean correlation of the daily returns
+	// of each pair of portfolios
+	CrossPortfolioCorrelation float64 `json:"cross_portfolio_correlation"`
+	// DiversificationBenefit is how far the volatility of the portfolios
+	// combined falls below their value-weighted average volatility
+	DiversificationBenefit float64   `json:"diversification_benefit"`
+	GeneratedAt            time.Time `json:"generated_at"`
+}
+
+// portfolioSeries is a portfolio's snapshot values by day
+type portfolioSeries struct {
+	id     string
+	values map[string]float64
+	latest float64
+}
+
+// GetAggregatePortfolioView returns the combined view of userID's
+// portfolios, cached for 15 minutes. Portfolios without snapshots in the
+// last year are left out.
+func (s *Service) GetAggregatePortfolioView(ctx context.Context, userID uuid.UUID) (*AggregateView, error) {
+	s.aggregateMu.Lock()
+	cached, ok := s.aggregates[userID]
+	s.aggregateMu.Unlock()
+	if ok && time.Since(cached.GeneratedAt) < aggregateViewTTL {
+		return cached, nil
+	}
+
+	portfolios, err := s.userPortfolioSeries(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := aggregatePortfolios(portfolios, calendar.NYSE().DaysPerYear())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range portfolios {
+		if view.TotalValue > 0 {
+			beta, err := s.calculateBeta(ctx, p.id, fullScope(time.Now()).riskSince)
+			if err != nil {
+				return nil, err
+			}
+			view.AggregateBeta += p.latest / view.TotalValue * beta
+		}
+	}
+	view.GeneratedAt = time.Now()
+
+	s.aggregateMu.Lock()
+	if s.aggregates == nil {
+		s.aggregates = make(map[uuid.UUID]*AggregateView)
+	}
+	s.aggregates[userID] = view
+	s.aggregateMu.Unlock()
+
+	return view, nil
+}
+
+// userPortfolioSeries reads the last year of snapshots of userID's
+// portfolios, in portfolio order
+func (s *Service) userPortfolioSeries(ctx context.Context, userID uuid.UUID) ([]*portfolioSeries, error) {
+	query := `
+		SELECT s.portfolio_id, s.snapshot_date, s.total_value
+		FROM portfolio_snapshots s
+		JOIN portfolios p ON p.id = s.portfolio_id
+		WHERE p.user_id = $1
+		AND p.deleted_at IS NULL
+		AND NOT p.paper_trading
+		AND s.snapshot_date >= $2
+		ORDER BY s.portfolio_id, s.snapshot_date
+	`
+	since := time.Now().AddDate(0, 0, -aggregateLookbackDays).Format("2006-01-02")
+	rows, err := s.db.QueryContext(ctx, query, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots of user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var portfolios []*portfolioSeries
+	for rows.Next() {
+		var id string
+		var date time.Time
+		var value float64
+		if err := rows.Scan(&id, &date, &value); err != nil {
+			return nil, err
+		}
+		if len(portfolios) == 0 || portfolios[len(portfolios)-1].id != id {
+			portfolios = append(portfolios, &portfolioSeries{id: id, values: make(map[string]float64)})
+		}
+		p := portfolios[len(portfolios)-1]
+		p.values[date.Format("2006-01-02")] = value
+		p.latest = value
+	}
+	return portfolios, rows.Err()
+}
+
+// aggregatePortfolios measures the portfolios over the days they all have
+// snapshots, with returns annualized over factor trading days a year. It
+// leaves AggregateBeta to the caller.
+func aggregatePortfolios(portfolios []*portfolioSeries, factor float64) (*AggregateView, error) {
+	view := &AggregateView{Portfolios: len(portfolios)}
+	if len(portfolios) == 0 {
+		return view, nil
+	}
+	for _, p := range portfolios {
+		view.TotalValue += p.latest
+	}
+
+	days := sharedDays(portfolios)
+	if len(days) < 3 {
+		return nil, fmt.Errorf("portfolios share %d snapshot days, need at least 3: %w", len(days), ErrInsufficientHistory)
+	}
+
+	returns := make([][]float64, len(portfolios))
+	combined := make([]float64, len(days))
+	for i, p := range portfolios {
+		values := make([]float64, len(days))
+		for j, day := range days {
+			values[j] = p.values[day]
+			combined[j] += values[j]
+		}
+		returns[i] = dailyReturns(values)
+	}
+
+	annualize := math.Sqrt(factor)
+	var weightedVolatility float64
+	for i, p := range portfolios {
+		if view.TotalValue == 0 {
+			break
+		}
+		weight := p.latest / view.TotalValue
+		mean, std := stat.MeanStdDev(returns[i], nil)
+		if std > 0 {
+			view.WeightedSharpe += weight * mean / std * annualize
+		}
+		weightedVolatility += weight * std * annualize
+	}
+	combinedVolatility := stat.StdDev(dailyReturns(combined), nil) * annualize
+	view.DiversificationBenefit = weightedVolatility - combinedVolatility
+
+	var pairs int
+	for i := range returns {
+		for j := i + 1; j < len(returns); j++ {
+			if c := stat.Correlation(returns[i], returns[j], nil); !math.IsNaN(c) {
+				view.CrossPortfolioCorrelation += c
+				pairs++
+			}
+		}
+	}
+	if pairs > 0 {
+		view.CrossPortfolioCorrelation /= float64(pairs)
+	}
+	return view, nil
+}
+
+// sharedDays returns the days, oldest first, on which every portfolio has a
+// snapshot
+func sharedDays(portfolios []*portfolioSeries) []string {
+	var days []string
+	for day := range portfolios[0].values {
+		shared := true
+		for _, p := range portfolios[1:] {
+			if _, ok := p.values[day]; !ok {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+// dailyReturns converts a value series into day-over-day returns. A day
+// after a zero value has a zero return.
+func dailyReturns(values []float64) []float64 {
+	returns := make([]float64, 0, len(values))
+	for i := 1; i < len(values); i++ {
+		if values[i-1] == 0 {
+			returns = append(returns, 0)
+			continue
+		}
+		returns = append(returns, values[i]/values[i-1]-1)
+	}
+	return returns
+}
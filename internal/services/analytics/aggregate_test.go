@@ -0,0 +1,105 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// portfolioSnapshots returns snapshot rows of each portfolio, one per day
+// ending today, in the order the aggregate query reads them
+func portfolioSnapshots(values map[string][]float64, ids ...string) *sqlmock.Rows {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"portfolio_id", "snapshot_date", "total_value"})
+	for _, id := range ids {
+		series := values[id]
+		for i, value := range series {
+			rows.AddRow(id, today.AddDate(0, 0, i-len(series)+1), value)
+		}
+	}
+	return rows
+}
+
+func TestGetAggregatePortfolioView(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("Perfectly correlated portfolios", func(t *testing.T) {
+		service := NewService(db, nil).WithBetaCalculator(fixedBetas{1: 0.9, 2: 1.2})
+		userID := uuid.New()
+		// 2 is a copy of 1 at twice the size
+		values := map[string][]float64{
+			"1": {100, 102, 99, 103, 104, 101},
+			"2": {200, 204, 198, 206, 208, 202},
+		}
+		mock.ExpectQuery("SELECT (.+) FROM portfolio_snapshots s JOIN portfolios p").
+			WithArgs(userID, sqlmock.AnyArg()).
+			WillReturnRows(portfolioSnapshots(values, "1", "2"))
+
+		view, err := service.GetAggregatePortfolioView(ctx, userID)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, view.Portfolios)
+		assert.InDelta(t, 303, view.TotalValue, 1e-9)
+		assert.InDelta(t, 1, view.CrossPortfolioCorrelation, 1e-9)
+		assert.InDelta(t, 0, view.DiversificationBenefit, 1e-9)
+		// Weighted by the latest values, 101 and 202
+		assert.InDelta(t, (0.9*101+1.2*202)/303, view.AggregateBeta, 1e-9)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		t.Run("Served from cache", func(t *testing.T) {
+			cached, err := service.GetAggregatePortfolioView(ctx, userID)
+			assert.NoError(t, err)
+			assert.Same(t, view, cached)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Negatively correlated portfolios", func(t *testing.T) {
+		service := NewService(db, nil).WithBetaCalculator(fixedBetas{1: 1, 2: -1})
+		userID := uuid.New()
+		// Each gains what the other loses, so together they never move
+		values := map[string][]float64{
+			"1": {100, 110, 100, 110, 100, 110},
+			"2": {100, 90, 100, 90, 100, 90},
+		}
+		mock.ExpectQuery("SELECT (.+) FROM portfolio_snapshots s JOIN portfolios p").
+			WithArgs(userID, sqlmock.AnyArg()).
+			WillReturnRows(portfolioSnapshots(values, "1", "2"))
+
+		view, err := service.GetAggregatePortfolioView(ctx, userID)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Less(t, view.CrossPortfolioCorrelation, 0.0)
+		assert.Greater(t, view.DiversificationBenefit, 0.0)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Too few shared days", func(t *testing.T) {
+		service := NewService(db, nil)
+		userID := uuid.New()
+		values := map[string][]float64{
+			"1": {100, 101, 102, 103},
+			"2": {100, 101},
+		}
+		mock.ExpectQuery("SELECT (.+) FROM portfolio_snapshots s JOIN portfolios p").
+			WithArgs(userID, sqlmock.AnyArg()).
+			WillReturnRows(portfolioSnapshots(values, "1", "2"))
+
+		_, err := service.GetAggregatePortfolioView(ctx, userID)
+		assert.ErrorIs(t, err, ErrInsufficientHistory)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
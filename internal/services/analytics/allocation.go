@@ -0,0 +1,289 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Allocation history granularities
+const (
+	GranularityDaily   = "daily"
+	GranularityWeekly  = "weekly"
+	GranularityMonthly = "monthly"
+)
+
+const (
+	// DefaultAllocationOtherBelow is the weight below which a holding is
+	// grouped under OtherSymbol
+	DefaultAllocationOtherBelow = 0.02
+	// maxAllocationPeriods bounds the periods an allocation history returns,
+	// so long ranges stay chartable
+	maxAllocationPeriods = 200
+
+	// OtherSymbol is the series small holdings are grouped under
+	OtherSymbol = "Other"
+	// CashSymbol is the series of the portfolio's cash
+	CashSymbol = "Cash"
+)
+
+// AllocationPeriod is one point of an allocation history. Interpolated
+// periods had no snapshot; their weights are interpolated linearly from the
+// neighbouring periods that had.
+type AllocationPeriod struct {
+	Start        time.Time `json:"start"`
+	Interpolated bool      `json:"interpolated"`
+}
+
+// AllocationSeries is one holding's weight of the portfolio in each period
+// of its AllocationHistory
+type AllocationSeries struct {
+	Symbol  string    `json:"symbol"`
+	Weights []float64 `json:"weights"`
+}
+
+// AllocationHistory is a portfolio's allocation over time, ready to stack:
+// every series has a weight per period, and a period's weights sum to one.
+// Series are ordered by mean weight, largest first, with OtherSymbol last.
+type AllocationHistory struct {
+	Granularity string             `json:"granularity"`
+	Periods     []AllocationPeriod `json:"periods"`
+	Series      []AllocationSeries `json:"series"`
+}
+
+type allocationSnapshot struct {
+	date       time.Time
+	totalValue float64
+	assets     []models.Asset
+}
+
+// GetAllocationHistory derives the portfolio's allocation in each period of
+// granularity from the snapshots dated from to to. Each period takes the
+// weights of its last snapshot. Holdings that never reach otherBelow of the
+// portfolio are grouped under OtherSymbol, and ranges of more than 200
+// periods are downsampled to every nth period, keeping the latest. The
+// history starts at the first period with a snapshot and ends at the last.
+func (s *Service) GetAllocationHistory(ctx context.Context, portfolioID string, from, to time.Time, granularity string, otherBelow float64) (*AllocationHistory, error) {
+	query := `
+		SELECT snapshot_date, total_value, assets
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1 AND snapshot_date BETWEEN $2 AND $3
+		ORDER BY snapshot_date
+	`
+	rows, err := s.db.QueryContext(ctx, query, portfolioID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots of portfolio %s: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var snapshots []allocationSnapshot
+	for rows.Next() {
+		var snap allocationSnapshot
+		var raw []byte
+		if err := rows.Scan(&snap.date, &snap.totalValue, &raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &snap.assets); err != nil {
+			return nil, fmt.Errorf("snapshot of portfolio %s on %s: %w", portfolioID, snap.date.Format("2006-01-02"), err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildAllocationHistory(snapshots, granularity, otherBelow, maxAllocationPeriods), nil
+}
+
+// buildAllocationHistory buckets date-ordered snapshots into periods of
+// granularity and returns at most maxPeriods of them
+func buildAllocationHistory(snapshots []allocationSnapshot, granularity string, otherBelow float64, maxPeriods int) *AllocationHistory {
+	history := &AllocationHistory{
+		Granularity: granularity,
+		Periods:     []AllocationPeriod{},
+		Series:      []AllocationSeries{},
+	}
+
+	// Later snapshots replace earlier ones, leaving each period's close.
+	// Snapshots without a value have no weights and count as missing.
+	closes := make(map[time.Time]map[string]float64)
+	var first, last time.Time
+	for _, snap := range snapshots {
+		if !(snap.totalValue > 0) {
+			continue
+		}
+		start := periodStart(snap.date, granularity)
+		closes[start] = snapshotWeights(snap)
+		if first.IsZero() {
+			first = start
+		}
+		last = start
+	}
+	if len(closes) == 0 {
+		return history
+	}
+
+	var periods []AllocationPeriod
+	var weights []map[string]float64
+	for start := first; !start.After(last); start = nextPeriod(start, granularity) {
+		w, ok := closes[start]
+		periods = append(periods, AllocationPeriod{Start: start, Interpolated: !ok})
+		weights = append(weights, w)
+	}
+	interpolateWeights(periods, weights)
+
+	var sampled []map[string]float64
+	for _, i := range sampleIndexes(len(periods), maxPeriods) {
+		history.Periods = append(history.Periods, periods[i])
+		sampled = append(sampled, weights[i])
+	}
+	history.Series = groupAllocationSeries(sampled, otherBelow)
+	return history
+}
+
+// snapshotWeights returns each holding's share of the snapshot's value,
+// with the value not held in assets as cash
+func snapshotWeights(snap allocationSnapshot) map[string]float64 {
+	weights := make(map[string]float64, len(snap.assets)+1)
+	invested := 0.0
+	for _, asset := range snap.assets {
+		value := asset.Value.InexactFloat64()
+		if !(value > 0) {
+			continue
+		}
+		weights[asset.Symbol] += value / snap.totalValue
+		invested += value
+	}
+	// Ignore rounding left over from valuing the assets
+	if cash := snap.totalValue - invested; cash > snap.totalValue*1e-9 {
+		weights[CashSymbol] = cash / snap.totalValue
+	}
+	return weights
+}
+
+// interpolateWeights fills the weights of interpolated periods linearly
+// from the nearest periods either side with a snapshot. The first and last
+// periods always have one.
+func interpolateWeights(periods []AllocationPeriod, weights []map[string]float64) {
+	prev := 0
+	for i := 1; i < len(periods); i++ {
+		if !periods[i].Interpolated {
+			prev = i
+			continue
+		}
+		next := i + 1
+		for periods[next].Interpolated {
+			next++
+		}
+
+		frac := float64(i-prev) / float64(next-prev)
+		filled := make(map[string]float64)
+		for symbol, w := range weights[prev] {
+			filled[symbol] += w * (1 - frac)
+		}
+		for symbol, w := range weights[next] {
+			filled[symbol] += w * frac
+		}
+		weights[i] = filled
+	}
+}
+
+// sampleIndexes returns the indexes of every nth of count periods, n being
+// as small as keeps them to limit, counting back from the last so the
+// latest period is always kept
+func sampleIndexes(count, limit int) []int {
+	step := 1
+	if count > limit {
+		step = int(math.Ceil(float64(count) / float64(limit)))
+	}
+
+	indexes := make([]int, 0, count/step+1)
+	for i := (count - 1) % step; i < count; i += step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// groupAllocationSeries turns per-period weights into one series per
+// holding, grouping holdings whose weight never reaches otherBelow
+func groupAllocationSeries(weights []map[string]float64, otherBelow float64) []AllocationSeries {
+	peak := make(map[string]float64)
+	for _, period := range weights {
+		for symbol, w := range period {
+			peak[symbol] = math.Max(peak[symbol], w)
+		}
+	}
+
+	bySymbol := make(map[string][]float64)
+	var symbols []string
+	series := func(symbol string) []float64 {
+		s, ok := bySymbol[symbol]
+		if !ok {
+			s = make([]float64, len(weights))
+			bySymbol[symbol] = s
+			symbols = append(symbols, symbol)
+		}
+		return s
+	}
+	for i, period := range weights {
+		for symbol, w := range period {
+			if peak[symbol] < otherBelow {
+				symbol = OtherSymbol
+			}
+			series(symbol)[i] += w
+		}
+	}
+
+	mean := make(map[string]float64, len(symbols))
+	for _, symbol := range symbols {
+		for _, w := range bySymbol[symbol] {
+			mean[symbol] += w / float64(len(weights))
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := symbols[i], symbols[j]
+		if (a == OtherSymbol) != (b == OtherSymbol) {
+			return b == OtherSymbol
+		}
+		if mean[a] != mean[b] {
+			return mean[a] > mean[b]
+		}
+		return a < b
+	})
+
+	result := make([]AllocationSeries, len(symbols))
+	for i, symbol := range symbols {
+		result[i] = AllocationSeries{Symbol: symbol, Weights: bySymbol[symbol]}
+	}
+	return result
+}
+
+// periodStart returns the start of the period of granularity containing day:
+// the day itself, the Monday of its ISO week, or the first of its month
+func periodStart(day time.Time, granularity string) time.Time {
+	d := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case GranularityWeekly:
+		return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+	case GranularityMonthly:
+		return d.AddDate(0, 0, 1-d.Day())
+	default:
+		return d
+	}
+}
+
+func nextPeriod(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeekly:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// holdings returns a snapshot of the given asset values on date, with cash
+// making up the rest of total
+func holdings(date time.Time, total float64, values map[string]float64) allocationSnapshot {
+	snap := allocationSnapshot{date: date, totalValue: total}
+	for symbol, v := range values {
+		snap.assets = append(snap.assets, models.Asset{Symbol: symbol, Value: decimal.NewFromFloat(v)})
+	}
+	return snap
+}
+
+func assertStacked(t *testing.T, history *AllocationHistory) {
+	t.Helper()
+	for i := range history.Periods {
+		var sum float64
+		for _, s := range history.Series {
+			require.Len(t, s.Weights, len(history.Periods))
+			sum += s.Weights[i]
+		}
+		assert.InDelta(t, 1, sum, 1e-9, "period %d", i)
+	}
+}
+
+func TestBuildAllocationHistory_GroupsSmallHoldings(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	history := buildAllocationHistory([]allocationSnapshot{
+		holdings(day, 1000, map[string]float64{"BTC": 200, "ETH": 700, "DOGE": 10, "SHIB": 5, "PEPE": 5}),
+		holdings(day.AddDate(0, 0, 1), 1000, map[string]float64{"BTC": 450, "ETH": 480, "DOGE": 15, "SHIB": 30, "PEPE": 5}),
+	}, GranularityDaily, 0.02, maxAllocationPeriods)
+
+	assertStacked(t, history)
+	var symbols []string
+	for _, s := range history.Series {
+		symbols = append(symbols, s.Symbol)
+	}
+	// SHIB reached 3% once, so it keeps its own series throughout; the
+	// holdings that never reached 2% are grouped
+	assert.Equal(t, []string{"ETH", "BTC", CashSymbol, "SHIB", OtherSymbol}, symbols)
+
+	assert.InDeltaSlice(t, []float64{0.2, 0.45}, history.Series[1].Weights, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.08, 0.02}, history.Series[2].Weights, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.005, 0.03}, history.Series[3].Weights, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.015, 0.02}, history.Series[4].Weights, 1e-9)
+
+	// With no threshold nothing is grouped, and cash is a series of its own
+	history = buildAllocationHistory([]allocationSnapshot{
+		holdings(day, 1000, map[string]float64{"BTC": 900, "DOGE": 1}),
+	}, GranularityDaily, 0, maxAllocationPeriods)
+	require.Len(t, history.Series, 3)
+	assert.Equal(t, CashSymbol, history.Series[1].Symbol)
+	assert.InDelta(t, 0.099, history.Series[1].Weights[0], 1e-9)
+	assert.Equal(t, "DOGE", history.Series[2].Symbol)
+}
+
+func TestBuildAllocationHistory_Downsamples(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var snapshots []allocationSnapshot
+	for i := 0; i < 500; i++ {
+		snapshots = append(snapshots, holdings(start.AddDate(0, 0, i), 1000, map[string]float64{
+			"BTC": 200 + float64(i), "ETH": 800 - float64(i),
+		}))
+	}
+
+	history := buildAllocationHistory(snapshots, GranularityDaily, 0.02, maxAllocationPeriods)
+	assertStacked(t, history)
+
+	// Every third day, counting back from the last so it is kept
+	require.Len(t, history.Periods, 167)
+	assert.Equal(t, start.AddDate(0, 0, 499), history.Periods[166].Start)
+	assert.Equal(t, start.AddDate(0, 0, 1), history.Periods[0].Start)
+	for i := 1; i < len(history.Periods); i++ {
+		assert.Equal(t, 3*24*time.Hour, history.Periods[i].Start.Sub(history.Periods[i-1].Start))
+	}
+	assert.Equal(t, "BTC", history.Series[1].Symbol)
+	assert.InDelta(t, 0.699, history.Series[1].Weights[166], 1e-9)
+
+	// Coarser granularity needs no downsampling
+	assert.Len(t, buildAllocationHistory(snapshots, GranularityMonthly, 0.02, maxAllocationPeriods).Periods, 17)
+}
+
+func TestBuildAllocationHistory_Interpolates(t *testing.T) {
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := buildAllocationHistory([]allocationSnapshot{
+		holdings(monday, 1000, map[string]float64{"BTC": 200, "ETH": 800}),
+		// Each week takes its last snapshot
+		holdings(monday.AddDate(0, 0, 6), 1000, map[string]float64{"BTC": 300, "ETH": 700}),
+		// A zero value can't be weighted, leaving the third week missing
+		holdings(monday.AddDate(0, 0, 15), 0, map[string]float64{"BTC": 0}),
+		holdings(monday.AddDate(0, 0, 30), 1000, map[string]float64{"BTC": 600, "ETH": 400}),
+	}, GranularityWeekly, 0.02, maxAllocationPeriods)
+
+	assertStacked(t, history)
+	require.Len(t, history.Periods, 5)
+	for i, interpolated := range []bool{false, true, true, true, false} {
+		assert.Equal(t, monday.AddDate(0, 0, 7*i), history.Periods[i].Start)
+		assert.Equal(t, interpolated, history.Periods[i].Interpolated, "week %d", i)
+	}
+	assert.Equal(t, "ETH", history.Series[0].Symbol)
+	assert.InDeltaSlice(t, []float64{0.3, 0.375, 0.45, 0.525, 0.6}, history.Series[1].Weights, 1e-9)
+
+	empty := buildAllocationHistory(nil, GranularityWeekly, 0.02, maxAllocationPeriods)
+	assert.Empty(t, empty.Periods)
+	assert.NotNil(t, empty.Series)
+}
+
+func TestSampleIndexes(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2}, sampleIndexes(3, 200))
+	assert.Equal(t, []int{1, 3, 5, 7, 9}, sampleIndexes(10, 5))
+	assert.Equal(t, []int{0, 3, 6, 9}, sampleIndexes(10, 4))
+	assert.Empty(t, sampleIndexes(0, 200))
+}
+
+func TestGetAllocationHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	raw, err := json.Marshal([]models.Asset{{Symbol: "BTC", Value: decimal.NewFromInt(500)}})
+	require.NoError(t, err)
+	mock.ExpectQuery("SELECT snapshot_date, total_value, assets FROM portfolio_snapshots").
+		WithArgs("42", "2024-03-01", "2024-03-31").
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_date", "total_value", "assets"}).AddRow(day, 1000.0, raw))
+
+	history, err := NewService(db, nil).GetAllocationHistory(context.Background(), "42",
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), GranularityMonthly, 0.02)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, []AllocationPeriod{{Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}}, history.Periods)
+	assert.Equal(t, []AllocationSeries{
+		{Symbol: "BTC", Weights: []float64{0.5}},
+		{Symbol: CashSymbol, Weights: []float64{0.5}},
+	}, history.Series)
+}
@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/lifecycle"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// historyWriteTimeout bounds a write to the analysis history, which runs
+// after the request that stored the analysis has returned
+const historyWriteTimeout = 10 * time.Second
+
+// WithAnalysisHistory keeps every stored market analysis in
+// market_analysis_history for retention, pruned by PurgeStaleAnalyses.
+// Without it only the latest analysis of each symbol is kept.
+func (s *Service) WithAnalysisHistory(retention time.Duration) *Service {
+	s.historyRetention = retention
+	return s
+}
+
+// getStoredAnalysis returns the latest stored analysis of symbol
+func (s *Service) getStoredAnalysis(ctx context.Context, symbol string) (*models.MarketAnalysis, error) {
+	return s.analyses.GetMarketAnalysis(ctx, symbol)
+}
+
+// storeAnalysis upserts analysis as the latest of its symbol, so retried and
+// concurrent refreshes leave one row. With history enabled it is recorded
+// there too, in the background.
+func (s *Service) storeAnalysis(ctx context.Context, analysis *models.MarketAnalysis) error {
+	if err := s.analyses.SaveMarketAnalysis(ctx, analysis); err != nil {
+		return err
+	}
+	if s.historyRetention <= 0 {
+		return nil
+	}
+
+	record := *analysis
+	s.historyWrites.Add(1)
+	lifecycle.Go(ctx, historyWriteTimeout, func(ctx context.Context) {
+		defer s.historyWrites.Done()
+		if err := s.analyses.RecordMarketAnalysisHistory(ctx, &record); err != nil {
+			logger.FromContext(ctx).Errorf("Failed to record %s analysis history: %v", record.AssetSymbol, err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,161 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// sentimentAI is an AIService returning a fresh analysis of any symbol
+type sentimentAI struct {
+	calls int32
+}
+
+func (a *sentimentAI) GeneratePrediction(ctx context.Context, symbol string, timeframe string) (*models.Prediction, error) {
+	return nil, nil
+}
+
+func (a *sentimentAI) AnalyzeMarketSentiment(ctx context.Context, symbol string) (*models.MarketAnalysis, error) {
+	atomic.AddInt32(&a.calls, 1)
+	now := time.Now()
+	return &models.MarketAnalysis{
+		AssetSymbol:   symbol,
+		Sentiment:     0.4,
+		TrendStrength: 0.7,
+		UpdatedAt:     now,
+		Signals:       []models.Signal{{Type: "BUY", Strength: 0.8, Description: "Momentum", CreatedAt: now}},
+	}, nil
+}
+
+// expectAnalysisUpsert expects one save of symbol's analysis, upserted into
+// the row with storedID
+func expectAnalysisUpsert(mock sqlmock.Sqlmock, symbol string, storedID uuid.UUID) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO market_analysis (.+) ON CONFLICT \\(asset_symbol\\) DO UPDATE").
+		WithArgs(sqlmock.AnyArg(), symbol, 0.4, 0.0, 0.0, 0.7, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(storedID))
+	mock.ExpectExec("DELETE FROM trading_signals WHERE analysis_id = \\$1").
+		WithArgs(storedID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO trading_signals").
+		WithArgs(sqlmock.AnyArg(), storedID, symbol, "BUY", 0.8, "Momentum", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestGetMarketAnalysis_ConcurrentColdSymbol(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+	// The two refreshes interleave in any order
+	mock.MatchExpectationsInOrder(false)
+
+	ai := &sentimentAI{}
+	service := NewService(db, ai)
+	storedID := uuid.New()
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM market_analysis WHERE asset_symbol = \\$1").
+			WithArgs("BTC").
+			WillReturnError(sql.ErrNoRows)
+		// Both upsert the symbol's one row, whichever inserted it
+		expectAnalysisUpsert(mock, "BTC", storedID)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*models.MarketAnalysis, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = service.GetMarketAnalysis(context.Background(), "BTC")
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		require.NoError(t, errs[i])
+		assert.Equal(t, storedID, results[i].ID)
+	}
+	assert.Equal(t, int32(2), ai.calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMarketAnalysis_NoAIService(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil)
+	mock.ExpectQuery("SELECT (.+) FROM market_analysis WHERE asset_symbol = \\$1").
+		WithArgs("BTC").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = service.GetMarketAnalysis(context.Background(), "BTC")
+	assert.ErrorIs(t, err, ErrNoAIService)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStoreAnalysis(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("Older analysis than the stored one is dropped", func(t *testing.T) {
+		service := NewService(db, nil)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO market_analysis (.+) ON CONFLICT \\(asset_symbol\\) DO UPDATE (.+) WHERE market_analysis.updated_at <= EXCLUDED.updated_at").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		analysis, _ := (&sentimentAI{}).AnalyzeMarketSentiment(ctx, "ETH")
+		assert.NoError(t, service.storeAnalysis(ctx, analysis))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("History is recorded in the background", func(t *testing.T) {
+		service := NewService(db, nil).WithAnalysisHistory(30 * 24 * time.Hour)
+		storedID := uuid.New()
+		expectAnalysisUpsert(mock, "ETH", storedID)
+		mock.ExpectExec("INSERT INTO market_analysis_history (.+) ON CONFLICT \\(asset_symbol, updated_at\\) DO NOTHING").
+			WithArgs(storedID, "ETH", 0.4, 0.0, 0.0, 0.7, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		analysis, _ := (&sentimentAI{}).AnalyzeMarketSentiment(ctx, "ETH")
+		assert.NoError(t, service.storeAnalysis(ctx, analysis))
+		service.historyWrites.Wait()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Purge prunes history past its retention", func(t *testing.T) {
+		service := NewService(db, nil).WithAnalysisHistory(30 * 24 * time.Hour)
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM market_analysis WHERE updated_at < \\$1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM market_analysis_history WHERE updated_at < \\$1").
+			WithArgs(cutoffBetween{stale: now.Add(-31 * 24 * time.Hour), fresh: now.Add(-29 * 24 * time.Hour)}).
+			WillReturnResult(sqlmock.NewResult(0, 12))
+
+		assert.NoError(t, service.PurgeStaleAnalyses(ctx))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// annualizationFactor returns the trading days a year symbol's daily
+// returns annualize over, from the trading calendar of its asset class.
+// Without calendars every symbol annualizes as an NYSE equity.
+func (s *Service) annualizationFactor(ctx context.Context, symbol string) (float64, error) {
+	if s.calendars == nil {
+		return calendar.NYSE().DaysPerYear(), nil
+	}
+	cal, err := s.calendars.SymbolCalendar(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+	return cal.DaysPerYear(), nil
+}
+
+// annualizedVolatility returns the volatility of symbol's daily returns
+// over the last year, annualized over its own trading year
+func (s *Service) annualizedVolatility(ctx context.Context, symbol string) (float64, error) {
+	factor, err := s.annualizationFactor(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		WITH daily_returns AS (
+			SELECT
+				(price - LAG(price) OVER (ORDER BY date)) / LAG(price) OVER (ORDER BY date) as return
+			FROM asset_prices
+			WHERE symbol = $1
+			AND date >= $2
+			AND price > 0
+		)
+		SELECT COALESCE(STDDEV(return), 0) * SQRT($3) FROM daily_returns
+	`
+	var volatility float64
+	err = s.db.QueryRowContext(ctx, query, symbol, time.Now().AddDate(-1, 0, 0), factor).Scan(&volatility)
+	return volatility, err
+}
+
+// calculatePortfolioVolatility annualizes each asset's volatility over its
+// own trading year before combining them by value weight and correlation,
+// so crypto and equities held together each scale by their own calendar.
+// It is zero when the portfolio holds no value or a volatility can't be
+// computed.
+func (s *Service) calculatePortfolioVolatility(ctx context.Context, assets []models.Asset) float64 {
+	var total float64
+	for _, a := range assets {
+		total += a.Value.InexactFloat64()
+	}
+	if total <= 0 {
+		return 0
+	}
+
+	since := fullScope(time.Now()).correlationSince
+	weights := make([]float64, len(assets))
+	vols := make([]float64, len(assets))
+	corr := make([][]float64, len(assets))
+	for i, a := range assets {
+		weights[i] = a.Value.InexactFloat64() / total
+		vol, err := s.annualizedVolatility(ctx, a.Symbol)
+		if err != nil {
+			return 0
+		}
+		vols[i] = vol
+
+		corr[i] = make([]float64, len(assets))
+		corr[i][i] = 1
+		for j := 0; j < i; j++ {
+			// calculateCorrelation pairs symbols in order. Symbols without
+			// overlapping history are taken as uncorrelated.
+			first, second := assets[j].Symbol, a.Symbol
+			if second < first {
+				first, second = second, first
+			}
+			c, _, err := s.calculateCorrelation(ctx, first, second, since)
+			if err != nil {
+				c = 0
+			}
+			corr[i][j], corr[j][i] = c, c
+		}
+	}
+	return portfolioVolatility(weights, vols, corr)
+}
+
+// portfolioVolatility combines annualized asset volatilities by weight and
+// pairwise correlation
+func portfolioVolatility(weights, vols []float64, corr [][]float64) float64 {
+	var variance float64
+	for i := range weights {
+		for j := range weights {
+			variance += weights[i] * weights[j] * vols[i] * vols[j] * corr[i][j]
+		}
+	}
+	if variance <= 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
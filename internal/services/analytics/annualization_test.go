@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestCalculatePortfolioVolatility_AnnualizesPerAsset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil).WithCalendars(calendar.NewResolver(db, calendar.CryptoUTC))
+
+	// BTC annualizes over 365 days and SPY over 252
+	expectVolatility := func(symbol, assetClass string, factor, volatility float64) {
+		mock.ExpectQuery("SELECT asset_class FROM symbol_metadata").
+			WithArgs(symbol).
+			WillReturnRows(sqlmock.NewRows([]string{"asset_class"}).AddRow(assetClass))
+		mock.ExpectQuery("SELECT COALESCE\\(STDDEV\\(return\\), 0\\) \\* SQRT\\(\\$3\\)").
+			WithArgs(symbol, sqlmock.AnyArg(), factor).
+			WillReturnRows(sqlmock.NewRows([]string{"volatility"}).AddRow(volatility))
+	}
+	expectVolatility("BTC", "crypto", 365, 0.04*math.Sqrt(365))
+	expectVolatility("SPY", "equity", 252, 0.01*math.Sqrt(252))
+	mock.ExpectQuery("SELECT CORR").
+		WithArgs("BTC", "SPY", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"correlation", "observations"}).AddRow(0.5, 120))
+
+	assets := []models.Asset{
+		{Symbol: "BTC", Value: decimal.NewFromInt(2500)},
+		{Symbol: "SPY", Value: decimal.NewFromInt(7500)},
+	}
+	btc, spy := 0.25*0.04*math.Sqrt(365), 0.75*0.01*math.Sqrt(252)
+	expected := math.Sqrt(btc*btc + spy*spy + 2*0.5*btc*spy)
+
+	assert.InDelta(t, expected, service.calculatePortfolioVolatility(context.Background(), assets), 1e-12)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPortfolioVolatility(t *testing.T) {
+	// Perfectly correlated assets add; uncorrelated ones add in quadrature
+	correlated := [][]float64{{1, 1}, {1, 1}}
+	assert.InDelta(t, 0.25, portfolioVolatility([]float64{0.5, 0.5}, []float64{0.2, 0.3}, correlated), 1e-12)
+
+	uncorrelated := [][]float64{{1, 0}, {0, 1}}
+	assert.InDelta(t, math.Sqrt(0.01+0.0225), portfolioVolatility([]float64{0.5, 0.5}, []float64{0.2, 0.3}, uncorrelated), 1e-12)
+
+	assert.Equal(t, 0.0, portfolioVolatility(nil, nil, nil))
+}
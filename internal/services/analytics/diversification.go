@@ -0,0 +1,138 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+	// diversificationSmoothingDays is the trailing window SmoothScore
+	// averages over
+	diversificationSmoothingDays = 7
+	// diversificationTrendDays is the window DiversificationDecreasing looks
+	// back over
+	diversificationTrendDays = 30
+	// minDiversificationDecline is how far the smoothed score must fall over
+	// the trend window to count as decreasing, so noise doesn't trigger it
+	minDiversificationDecline = 0.02
+)
+
+// DiversificationDataPoint is a portfolio's diversification on one
+// snapshot day
+type DiversificationDataPoint struct {
+	Date       time.Time `json:"date"`
+	Score      float64   `json:"score"`
+	AssetCount int       `json:"asset_count"`
+	HHI        float64   `json:"hhi"`
+	// SmoothScore is the mean score over the trailing 7 days
+	SmoothScore float64 `json:"smooth_score"`
+}
+
+// DiversificationTrend is a portfolio's diversification history
+type DiversificationTrend struct {
+	Points []DiversificationDataPoint `json:"points"`
+	// DiversificationDecreasing warns that the smoothed score fell over the
+	// last 30 days
+	DiversificationDecreasing bool `json:"diversification_decreasing"`
+}
+
+// GetDiversificationTrend reconstructs the portfolio's asset weights from
+// each of its snapshots over the last days days and scores them as
+// calculateDiversificationScore does.
+func (s *Service) GetDiversificationTrend(ctx context.Context, portfolioID string, days int) ([]DiversificationDataPoint, error) {
+	query := `
+		SELECT snapshot_date, assets
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1 AND snapshot_date >= $2
+		ORDER BY snapshot_date
+	`
+	// Read a smoothing window further back so the first day's SmoothScore
+	// covers a full week
+	from := time.Now().AddDate(0, 0, -days)
+	since := from.AddDate(0, 0, -(diversificationSmoothingDays - 1))
+	rows, err := s.db.QueryContext(ctx, query, portfolioID, since.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots of portfolio %s: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var dates []time.Time
+	var holdings [][]models.Asset
+	for rows.Next() {
+		var date time.Time
+		var raw []byte
+		if err := rows.Scan(&date, &raw); err != nil {
+			return nil, err
+		}
+		var assets []models.Asset
+		if err := json.Unmarshal(raw, &assets); err != nil {
+			return nil, fmt.Errorf("snapshot of portfolio %s on %s: %w", portfolioID, date.Format("2006-01-02"), err)
+		}
+		dates = append(dates, date)
+		holdings = append(holdings, assets)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	points := s.diversificationPoints(dates, holdings)
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	start := 0
+	for start < len(points) && points[start].Date.Before(fromDay) {
+		start++
+	}
+	return points[start:], nil
+}
+
+// diversificationPoints scores each day's holdings and smooths the scores
+// over a trailing calendar window, so gaps such as weekends shorten the
+// average rather than stretching it
+func (s *Service) diversificationPoints(dates []time.Time, holdings [][]models.Asset) []DiversificationDataPoint {
+	points := make([]DiversificationDataPoint, len(dates))
+	for i, assets := range holdings {
+		var count int
+		for _, a := range assets {
+			if a.Value.IsPositive() {
+				count++
+			}
+		}
+		points[i] = DiversificationDataPoint{
+			Date:       dates[i],
+			Score:      s.calculateDiversificationScore(assets),
+			AssetCount: count,
+			HHI:        herfindahl(assets),
+		}
+
+		var sum float64
+		var n int
+		windowStart := dates[i].AddDate(0, 0, -(diversificationSmoothingDays - 1))
+		for j := i; j >= 0 && !dates[j].Before(windowStart); j-- {
+			sum += points[j].Score
+			n++
+		}
+		points[i].SmoothScore = sum / float64(n)
+	}
+	return points
+}
+
+// NewDiversificationTrend flags a trend whose smoothed score fell by more
+// than minDiversificationDecline over its last 30 days
+func NewDiversificationTrend(points []DiversificationDataPoint) *DiversificationTrend {
+	trend := &DiversificationTrend{Points: points}
+	if len(points) < 2 {
+		return trend
+	}
+
+	last := points[len(points)-1]
+	windowStart := last.Date.AddDate(0, 0, -diversificationTrendDays)
+	first := last
+	for i := len(points) - 1; i >= 0 && !points[i].Date.Before(windowStart); i-- {
+		first = points[i]
+	}
+	trend.DiversificationDecreasing = first.SmoothScore-last.SmoothScore > minDiversificationDecline
+	return trend
+}
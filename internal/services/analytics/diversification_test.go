@@ -0,0 +1,125 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// snapshotAssets returns daily snapshot rows ending today in which BTC
+// grows from an equal share to dominate three other holdings when grow is
+// set, and every holding stays flat otherwise
+func snapshotAssets(t *testing.T, days int, grow bool) *sqlmock.Rows {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"snapshot_date", "assets"})
+	for i := 0; i < days; i++ {
+		btc := 100.0
+		if grow {
+			btc += 900 * float64(i) / float64(days-1)
+		}
+		raw, err := json.Marshal([]models.Asset{
+			{Symbol: "BTC", Value: decimal.NewFromFloat(btc)},
+			{Symbol: "ETH", Value: decimal.NewFromInt(100)},
+			{Symbol: "SPY", Value: decimal.NewFromInt(100)},
+			{Symbol: "AAPL", Value: decimal.NewFromInt(100)},
+		})
+		if err != nil {
+			t.Fatalf("Failed to encode assets: %v", err)
+		}
+		rows.AddRow(today.AddDate(0, 0, i-days+1), raw)
+	}
+	return rows
+}
+
+func TestGetDiversificationTrend(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil)
+	ctx := context.Background()
+
+	t.Run("One asset comes to dominate", func(t *testing.T) {
+		mock.ExpectQuery("SELECT snapshot_date, assets FROM portfolio_snapshots").
+			WithArgs("7", sqlmock.AnyArg()).
+			WillReturnRows(snapshotAssets(t, 60, true))
+
+		points, err := service.GetDiversificationTrend(ctx, "7", 30)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// Today and the 30 days before it; the older rows only feed the
+		// first days' smoothing
+		if !assert.Len(t, points, 31) {
+			return
+		}
+
+		for i := 1; i < len(points); i++ {
+			assert.Less(t, points[i].Score, points[i-1].Score)
+			assert.Greater(t, points[i].HHI, points[i-1].HHI)
+			assert.Less(t, points[i].SmoothScore, points[i-1].SmoothScore)
+			assert.InDelta(t, 1, points[i].Score+points[i].HHI, 1e-9)
+		}
+		last := points[len(points)-1]
+		assert.Equal(t, 4, last.AssetCount)
+		// BTC ends at 1000 of 1300
+		assert.InDelta(t, (1000.0*1000+3*100*100)/(1300*1300), last.HHI, 1e-9)
+		// The smoothed score lags the raw score as it falls
+		assert.Greater(t, last.SmoothScore, last.Score)
+
+		assert.True(t, NewDiversificationTrend(points).DiversificationDecreasing)
+	})
+
+	t.Run("Balanced portfolio", func(t *testing.T) {
+		mock.ExpectQuery("SELECT snapshot_date, assets FROM portfolio_snapshots").
+			WithArgs("8", sqlmock.AnyArg()).
+			WillReturnRows(snapshotAssets(t, 60, false))
+
+		points, err := service.GetDiversificationTrend(ctx, "8", 90)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, points, 60)
+		for _, p := range points {
+			assert.InDelta(t, 0.75, p.Score, 1e-9)
+			assert.InDelta(t, 0.75, p.SmoothScore, 1e-9)
+		}
+		assert.False(t, NewDiversificationTrend(points).DiversificationDecreasing)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDiversificationPoints_SmoothsOverCalendarDays(t *testing.T) {
+	service := NewService(nil, nil)
+	concentrated := []models.Asset{{Symbol: "BTC", Value: decimal.NewFromInt(100)}}
+	balanced := []models.Asset{
+		{Symbol: "BTC", Value: decimal.NewFromInt(50)},
+		{Symbol: "ETH", Value: decimal.NewFromInt(50)},
+	}
+
+	// A fortnight's gap: the second day's average can't reach back to the
+	// first
+	dates := []time.Time{
+		time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC),
+	}
+	points := service.diversificationPoints(dates, [][]models.Asset{balanced, concentrated, balanced})
+
+	assert.Equal(t, 0.5, points[0].SmoothScore)
+	assert.Equal(t, 0.0, points[1].SmoothScore)
+	assert.Equal(t, 0.25, points[2].SmoothScore)
+	assert.Equal(t, 1, points[1].AssetCount)
+	assert.Equal(t, 1.0, points[1].HHI)
+}
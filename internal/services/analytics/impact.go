@@ -0,0 +1,200 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Trade sides
+const (
+	SideBuy  = "BUY"
+	SideSell = "SELL"
+)
+
+const (
+	// impactWindowDays is the trading days ADV and volatility are measured
+	// over, and minImpactDays the fewest an estimate is made from
+	impactWindowDays = 20
+	minImpactDays    = 5
+	// maxParticipationRate is the share of ADV a trade is recommended to
+	// take each day
+	maxParticipationRate = 0.1
+	// minTradeDuration is the shortest time a trade is recommended to take
+	minTradeDuration = time.Hour
+	// tradingDay is the market time a day of trading adds
+	tradingDay = 24 * time.Hour
+	// permanentImpactCoef and temporaryImpactCoef scale volatility times
+	// participation into price impact, as calibrated by Almgren, Thum,
+	// Hauptmann and Li (2005)
+	permanentImpactCoef = 0.314
+	temporaryImpactCoef = 0.142
+)
+
+var (
+	ErrInvalidSide     = errors.New("side must be BUY or SELL")
+	ErrInvalidQuantity = errors.New("quantity must be positive")
+)
+
+// MarketImpactEstimate is the expected cost of a trade moving the price
+// against itself. Impacts are percentages of the pre-trade price.
+type MarketImpactEstimate struct {
+	Symbol            string  `json:"symbol"`
+	Side              string  `json:"side"`
+	Quantity          float64 `json:"quantity"`
+	Price             float64 `json:"price"`
+	ADV               float64 `json:"adv"`
+	DailyVolatility   float64 `json:"daily_volatility"`
+	ParticipationRate float64 `json:"participation_rate"`
+	// TemporaryImpactPct is the premium paid while trading, which fades
+	// once the trade completes; PermanentImpactPct is the price move that
+	// remains
+	TemporaryImpactPct       float64       `json:"temporary_impact_pct"`
+	PermanentImpactPct       float64       `json:"permanent_impact_pct"`
+	EstimatedSlippageUSD     float64       `json:"estimated_slippage_usd"`
+	RecommendedTradeDuration time.Duration `json:"recommended_trade_duration_ns"`
+}
+
+// liquidity is what a symbol's recent trading says about the cost of
+// trading it
+type liquidity struct {
+	symbol string
+	// price is the last close, adv the mean daily volume and volatility the
+	// standard deviation of daily returns
+	price      float64
+	adv        float64
+	volatility float64
+}
+
+// EstimateMarketImpact estimates the impact of trading quantity of symbol
+// with the Almgren-Chriss model, from its average daily volume (ADV) and
+// daily volatility over the last impactWindowDays trading days
+func (s *Service) EstimateMarketImpact(ctx context.Context, symbol string, quantity float64, side string) (*MarketImpactEstimate, error) {
+	side = strings.ToUpper(side)
+	if side != SideBuy && side != SideSell {
+		return nil, ErrInvalidSide
+	}
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	liq, err := s.liquidity(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return liq.impact(quantity, side), nil
+}
+
+// liquidity measures symbol's trading over its last impactWindowDays days
+// of market data, summing the volume and taking the last close of each day
+func (s *Service) liquidity(ctx context.Context, symbol string) (*liquidity, error) {
+	query := `
+		SELECT close, volume FROM (
+			SELECT
+				date_trunc('day', timestamp) AS day,
+				(array_agg(close ORDER BY timestamp DESC))[1] AS close,
+				SUM(volume) AS volume
+			FROM market_data
+			WHERE symbol = $1 AND timestamp >= $2
+			GROUP BY 1
+			ORDER BY 1 DESC
+			LIMIT $3
+		) days
+		ORDER BY day
+	`
+	// Twice the window in calendar days covers weekends and holidays
+	since := time.Now().AddDate(0, 0, -2*impactWindowDays)
+	rows, err := s.db.QueryContext(ctx, query, symbol, since, impactWindowDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get market data of %s: %w", symbol, err)
+	}
+	defer rows.Close()
+
+	var closes, volumes []float64
+	for rows.Next() {
+		var close, volume float64
+		if err := rows.Scan(&close, &volume); err != nil {
+			return nil, err
+		}
+		if close <= 0 {
+			continue
+		}
+		closes = append(closes, close)
+		volumes = append(volumes, volume)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(closes) < minImpactDays {
+		return nil, ErrInsufficientHistory
+	}
+
+	liq := &liquidity{symbol: symbol, price: closes[len(closes)-1]}
+	for _, v := range volumes {
+		liq.adv += v
+	}
+	liq.adv /= float64(len(volumes))
+	if liq.adv <= 0 {
+		return nil, ErrInsufficientHistory
+	}
+
+	returns := make([]float64, len(closes)-1)
+	for i := 1; i < len(closes); i++ {
+		returns[i-1] = closes[i]/closes[i-1] - 1
+	}
+	liq.volatility = stdDev(returns)
+	return liq, nil
+}
+
+// impact applies the model to a trade of quantity. The trade is spread
+// over T days so no day takes more than maxParticipationRate of ADV. The
+// permanent impact grows with the share of ADV traded, and the temporary
+// impact also with the price risk sigma * sqrt(T) borne while trading. Of
+// the permanent move, the trade pays half on average as it builds up.
+func (l *liquidity) impact(quantity float64, side string) *MarketImpactEstimate {
+	participation := quantity / l.adv
+
+	duration := time.Duration(participation / maxParticipationRate * float64(tradingDay))
+	if duration < minTradeDuration {
+		duration = minTradeDuration
+	}
+	days := float64(duration) / float64(tradingDay)
+
+	permanent := permanentImpactCoef * l.volatility * participation
+	temporary := temporaryImpactCoef * l.volatility * participation * math.Sqrt(days)
+
+	return &MarketImpactEstimate{
+		Symbol:                   l.symbol,
+		Side:                     side,
+		Quantity:                 quantity,
+		Price:                    l.price,
+		ADV:                      l.adv,
+		DailyVolatility:          l.volatility,
+		ParticipationRate:        participation,
+		TemporaryImpactPct:       temporary * 100,
+		PermanentImpactPct:       permanent * 100,
+		EstimatedSlippageUSD:     (temporary + permanent/2) * quantity * l.price,
+		RecommendedTradeDuration: duration,
+	}
+}
+
+// stdDev returns the sample standard deviation of values
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var sumSq float64
+	for _, v := range values {
+		sumSq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sumSq / float64(len(values)-1))
+}
@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// liquidRows is a month of trading alternating between two closes, so
+// daily returns swing by about 2%, on a volume of a million a day
+func liquidRows() *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"close", "volume"})
+	for i := 0; i < impactWindowDays; i++ {
+		close := 100.0
+		if i%2 == 1 {
+			close = 102
+		}
+		rows.AddRow(close, 1000000)
+	}
+	return rows
+}
+
+func TestEstimateMarketImpact(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil)
+	ctx := context.Background()
+
+	estimate := func(quantity float64) *MarketImpactEstimate {
+		mock.ExpectQuery("FROM market_data").
+			WithArgs("AAPL", sqlmock.AnyArg(), impactWindowDays).
+			WillReturnRows(liquidRows())
+		e, err := service.EstimateMarketImpact(ctx, "AAPL", quantity, "buy")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return e
+	}
+
+	small := estimate(10000)
+	large := estimate(200000)
+
+	assert.Equal(t, SideBuy, small.Side)
+	assert.Equal(t, 1000000.0, small.ADV)
+	assert.Equal(t, 102.0, small.Price)
+	assert.InDelta(t, 0.01, small.ParticipationRate, 1e-9)
+	assert.InDelta(t, 0.2, large.ParticipationRate, 1e-9)
+
+	// A fifth of ADV costs far more per share than a hundredth of it
+	smallImpact := small.TemporaryImpactPct + small.PermanentImpactPct
+	largeImpact := large.TemporaryImpactPct + large.PermanentImpactPct
+	assert.Greater(t, smallImpact, 0.0)
+	assert.Greater(t, largeImpact, 10*smallImpact)
+	assert.Greater(t, large.EstimatedSlippageUSD, 20*10*small.EstimatedSlippageUSD)
+
+	// Trades are spread to take at most a tenth of ADV a day
+	assert.Equal(t, 48*time.Hour, large.RecommendedTradeDuration)
+	assert.InDelta(t, float64(144*time.Minute), float64(small.RecommendedTradeDuration), float64(time.Second))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEstimateMarketImpact_Errors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil)
+	ctx := context.Background()
+
+	_, err = service.EstimateMarketImpact(ctx, "AAPL", 100, "hold")
+	assert.ErrorIs(t, err, ErrInvalidSide)
+
+	_, err = service.EstimateMarketImpact(ctx, "AAPL", 0, SideSell)
+	assert.ErrorIs(t, err, ErrInvalidQuantity)
+
+	mock.ExpectQuery("FROM market_data").
+		WithArgs("NEW", sqlmock.AnyArg(), impactWindowDays).
+		WillReturnRows(sqlmock.NewRows([]string{"close", "volume"}).AddRow(10, 500).AddRow(11, 700))
+	_, err = service.EstimateMarketImpact(ctx, "NEW", 100, SideSell)
+	assert.ErrorIs(t, err, ErrInsufficientHistory)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,497 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gonum.org/v1/gonum/stat"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+)
+
+const (
+	// DefaultMinModelOutcomes is how many scored predictions a model needs
+	// in a window before it is ranked
+	DefaultMinModelOutcomes = 30
+	// DefaultMinStrategyDays is how many daily returns a strategy needs in
+	// a window before it is ranked
+	DefaultMinStrategyDays = 20
+	// DefaultLeaderboardWindow is the window, in days, leaderboards are
+	// shown for unless another is asked for
+	DefaultLeaderboardWindow = 30
+)
+
+// Leaderboard orderings
+const (
+	SortAccuracy    = "accuracy"
+	SortCalibration = "calibration"
+	SortError       = "error"
+	SortReturn      = "return"
+	SortSharpe      = "sharpe"
+	SortDrawdown    = "drawdown"
+)
+
+// LeaderboardWindows are the windows, in days, leaderboards are ranked over
+var LeaderboardWindows = []int{7, 30, 90}
+
+var (
+	ErrUnknownWindow = errors.New("unknown leaderboard window")
+	ErrUnknownSort   = errors.New("unknown leaderboard ordering")
+)
+
+// ParseLeaderboardWindow reads a window such as "30d", which must be one
+// of LeaderboardWindows
+func ParseLeaderboardWindow(window string) (int, error) {
+	days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+	if err != nil || !strings.HasSuffix(window, "d") {
+		return 0, fmt.Errorf("%w %q", ErrUnknownWindow, window)
+	}
+	for _, w := range LeaderboardWindows {
+		if w == days {
+			return days, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownWindow, window)
+}
+
+// ModelRanking is how an active model's predictions fared over a window.
+// Samples counts the predictions scored for direction and calibration, and
+// ErrorSamples those that also recorded a predicted and actual value.
+type ModelRanking struct {
+	Rank                int     `json:"rank"`
+	Model               string  `json:"model"`
+	Samples             int     `json:"samples"`
+	DirectionalAccuracy float64 `json:"directional_accuracy"`
+	MeanConfidence      float64 `json:"mean_confidence"`
+	// CalibrationError is the sample-weighted gap between confidence and
+	// hit rate across confidence deciles, 0 for a perfectly calibrated model
+	CalibrationError  float64 `json:"calibration_error"`
+	MeanAbsoluteError float64 `json:"mean_absolute_error"`
+	ErrorSamples      int     `json:"error_samples"`
+}
+
+type ModelLeaderboard struct {
+	WindowDays  int            `json:"window_days"`
+	MinSamples  int            `json:"min_samples"`
+	Models      []ModelRanking `json:"models"`
+	GeneratedAt time.Time      `json:"generated_at"`
+}
+
+// StrategyRanking is how a paper trading portfolio performed over a
+// window. Samples counts its daily returns.
+type StrategyRanking struct {
+	Rank        int     `json:"rank"`
+	PortfolioID string  `json:"portfolio_id"`
+	Name        string  `json:"name"`
+	Samples     int     `json:"samples"`
+	Return      float64 `json:"return"`
+	SharpeRatio float64 `json:"sharpe_ratio"`
+	// MaxDrawdown is the largest fall from a high, as a fraction of it
+	MaxDrawdown float64 `json:"max_drawdown"`
+}
+
+type StrategyLeaderboard struct {
+	WindowDays  int               `json:"window_days"`
+	MinSamples  int               `json:"min_samples"`
+	Strategies  []StrategyRanking `json:"strategies"`
+	GeneratedAt time.Time         `json:"generated_at"`
+}
+
+// Leaderboards ranks active models by their prediction outcomes and each
+// user's paper trading strategies by their performance. Rankings are
+// computed for every window by Refresh, which runs as a periodic job, and
+// served from the last refresh.
+type Leaderboards struct {
+	db               *sql.DB
+	minModelOutcomes int
+	minStrategyDays  int
+	now              func() time.Time
+
+	mu          sync.Mutex
+	models      map[int]*ModelLeaderboard
+	strategies  map[int]map[uuid.UUID]*StrategyLeaderboard
+	generatedAt time.Time
+}
+
+func NewLeaderboards(db *sql.DB) *Leaderboards {
+	return &Leaderboards{
+		db:               db,
+		minModelOutcomes: DefaultMinModelOutcomes,
+		minStrategyDays:  DefaultMinStrategyDays,
+		now:              time.Now,
+	}
+}
+
+// WithMinSamples sets how many scored predictions a model, and how many
+// daily returns a strategy, needs to be ranked
+func (l *Leaderboards) WithMinSamples(modelOutcomes, strategyDays int) *Leaderboards {
+	l.minModelOutcomes = modelOutcomes
+	l.minStrategyDays = strategyDays
+	return l
+}
+
+// Refresh recomputes every leaderboard
+func (l *Leaderboards) Refresh(ctx context.Context) error {
+	now := l.now()
+
+	models := make(map[int]*ModelLeaderboard, len(LeaderboardWindows))
+	for _, window := range LeaderboardWindows {
+		lb, err := l.rankModels(ctx, window, now)
+		if err != nil {
+			return err
+		}
+		models[window] = lb
+	}
+	strategies, err := l.rankStrategies(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.models = models
+	l.strategies = strategies
+	l.generatedAt = now
+	l.mu.Unlock()
+	return nil
+}
+
+// ensure refreshes the leaderboards if they haven't been computed yet, so
+// requests before the job's first run are still answered
+func (l *Leaderboards) ensure(ctx context.Context) error {
+	l.mu.Lock()
+	computed := !l.generatedAt.IsZero()
+	l.mu.Unlock()
+	if computed {
+		return nil
+	}
+	return l.Refresh(ctx)
+}
+
+// Models returns the model leaderboard of window, in days, ordered by
+// sortBy: accuracy (the default), calibration or error
+func (l *Leaderboards) Models(ctx context.Context, window int, sortBy string) (*ModelLeaderboard, error) {
+	if err := l.ensure(ctx); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	lb, ok := l.models[window]
+	l.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %dd", ErrUnknownWindow, window)
+	}
+
+	sorted := *lb
+	sorted.Models = append([]ModelRanking(nil), lb.Models...)
+	if err := sortModels(sorted.Models, sortBy); err != nil {
+		return nil, err
+	}
+	return &sorted, nil
+}
+
+// Strategies returns the leaderboard of userID's strategies over window,
+// in days, ordered by sortBy: sharpe (the default), return or drawdown
+func (l *Leaderboards) Strategies(ctx context.Context, userID uuid.UUID, window int, sortBy string) (*StrategyLeaderboard, error) {
+	if err := l.ensure(ctx); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	byUser, ok := l.strategies[window]
+	generatedAt := l.generatedAt
+	l.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %dd", ErrUnknownWindow, window)
+	}
+
+	sorted := StrategyLeaderboard{WindowDays: window, MinSamples: l.minStrategyDays, Strategies: []StrategyRanking{}, GeneratedAt: generatedAt}
+	if lb, ok := byUser[userID]; ok {
+		sorted.Strategies = append(sorted.Strategies, lb.Strategies...)
+	}
+	if err := sortStrategies(sorted.Strategies, sortBy); err != nil {
+		return nil, err
+	}
+	return &sorted, nil
+}
+
+// modelOutcomes accumulates a model's scored predictions
+type modelOutcomes struct {
+	samples        int
+	hits           int
+	confidence     float64
+	errorSum       float64
+	errorSamples   int
+	calibrationGap float64
+}
+
+// rankModels scores the prediction outcomes of active models over the last
+// window days. Outcomes are grouped by confidence decile, a decile's gap
+// between summed confidence and hits adding to the calibration error.
+func (l *Leaderboards) rankModels(ctx context.Context, window int, now time.Time) (*ModelLeaderboard, error) {
+	query := `
+		SELECT o.model_name,
+			LEAST(FLOOR(o.confidence * 10), 9) AS decile,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE SIGN(o.predicted_direction) = SIGN(o.next_day_return)),
+			SUM(o.confidence),
+			COALESCE(SUM(ABS(o.predicted_value - o.actual_value)), 0),
+			COUNT(o.predicted_value - o.actual_value)
+		FROM prediction_outcomes o
+		WHERE o.model_name != ''
+		AND o.observed_at >= $1
+		AND o.predicted_direction IS NOT NULL
+		AND o.next_day_return IS NOT NULL
+		AND o.confidence IS NOT NULL
+		AND EXISTS (
+			SELECT 1 FROM ml_models m WHERE m.name = o.model_name AND m.status = 'active'
+		)
+		GROUP BY o.model_name, decile
+		ORDER BY o.model_name, decile
+	`
+	rows, err := l.db.QueryContext(ctx, query, now.AddDate(0, 0, -window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prediction outcomes: %w", err)
+	}
+	defer rows.Close()
+
+	outcomes := make(map[string]*modelOutcomes)
+	var names []string
+	for rows.Next() {
+		var name string
+		var decile float64
+		var samples, hits, errorSamples int
+		var confidence, errorSum float64
+		if err := rows.Scan(&name, &decile, &samples, &hits, &confidence, &errorSum, &errorSamples); err != nil {
+			return nil, err
+		}
+		o, ok := outcomes[name]
+		if !ok {
+			o = &modelOutcomes{}
+			outcomes[name] = o
+			names = append(names, name)
+		}
+		o.samples += samples
+		o.hits += hits
+		o.confidence += confidence
+		o.errorSum += errorSum
+		o.errorSamples += errorSamples
+		o.calibrationGap += math.Abs(confidence - float64(hits))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lb := &ModelLeaderboard{WindowDays: window, MinSamples: l.minModelOutcomes, Models: []ModelRanking{}, GeneratedAt: now}
+	for _, name := range names {
+		o := outcomes[name]
+		if o.samples < l.minModelOutcomes {
+			continue
+		}
+		ranking := ModelRanking{
+			Model:               name,
+			Samples:             o.samples,
+			DirectionalAccuracy: float64(o.hits) / float64(o.samples),
+			MeanConfidence:      o.confidence / float64(o.samples),
+			CalibrationError:    o.calibrationGap / float64(o.samples),
+			ErrorSamples:        o.errorSamples,
+		}
+		if o.errorSamples > 0 {
+			ranking.MeanAbsoluteError = o.errorSum / float64(o.errorSamples)
+		}
+		lb.Models = append(lb.Models, ranking)
+	}
+	sortModels(lb.Models, SortAccuracy)
+	return lb, nil
+}
+
+// strategySeries is a paper trading portfolio's snapshots, oldest first
+type strategySeries struct {
+	userID uuid.UUID
+	id     string
+	name   string
+	days   []time.Time
+	values []float64
+}
+
+// rankStrategies ranks each user's paper trading portfolios over every
+// window from their snapshots
+func (l *Leaderboards) rankStrategies(ctx context.Context, now time.Time) (map[int]map[uuid.UUID]*StrategyLeaderboard, error) {
+	longest := 0
+	for _, window := range LeaderboardWindows {
+		if window > longest {
+			longest = window
+		}
+	}
+
+	query := `
+		SELECT p.user_id, p.id, p.name, s.snapshot_date, s.total_value
+		FROM portfolio_snapshots s
+		JOIN portfolios p ON p.id = s.portfolio_id
+		WHERE p.paper_trading
+		AND p.deleted_at IS NULL
+		AND s.snapshot_date >= $1
+		ORDER BY p.user_id, p.id, s.snapshot_date
+	`
+	rows, err := l.db.QueryContext(ctx, query, now.AddDate(0, 0, -longest).Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get strategy snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var series []*strategySeries
+	for rows.Next() {
+		var userID uuid.UUID
+		var id, name string
+		var day time.Time
+		var value float64
+		if err := rows.Scan(&userID, &id, &name, &day, &value); err != nil {
+			return nil, err
+		}
+		if len(series) == 0 || series[len(series)-1].id != id {
+			series = append(series, &strategySeries{userID: userID, id: id, name: name})
+		}
+		s := series[len(series)-1]
+		s.days = append(s.days, day)
+		s.values = append(s.values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	annualize := math.Sqrt(calendar.NYSE().DaysPerYear())
+	leaderboards := make(map[int]map[uuid.UUID]*StrategyLeaderboard, len(LeaderboardWindows))
+	for _, window := range LeaderboardWindows {
+		since := now.AddDate(0, 0, -window).Format("2006-01-02")
+		byUser := make(map[uuid.UUID]*StrategyLeaderboard)
+		for _, s := range series {
+			// Snapshots are oldest first, so the window is a suffix
+			start := sort.Search(len(s.days), func(i int) bool {
+				return s.days[i].Format("2006-01-02") >= since
+			})
+			values := s.values[start:]
+			returns := dailyReturns(values)
+			if len(returns) < l.minStrategyDays {
+				continue
+			}
+
+			ranking := StrategyRanking{
+				PortfolioID: s.id,
+				Name:        s.name,
+				Samples:     len(returns),
+				MaxDrawdown: maxDrawdown(values),
+			}
+			if values[0] > 0 {
+				ranking.Return = values[len(values)-1]/values[0] - 1
+			}
+			if mean, std := stat.MeanStdDev(returns, nil); std > 0 {
+				ranking.SharpeRatio = mean / std * annualize
+			}
+
+			lb, ok := byUser[s.userID]
+			if !ok {
+				lb = &StrategyLeaderboard{WindowDays: window, MinSamples: l.minStrategyDays, GeneratedAt: now}
+				byUser[s.userID] = lb
+			}
+			lb.Strategies = append(lb.Strategies, ranking)
+		}
+		for _, lb := range byUser {
+			sortStrategies(lb.Strategies, SortSharpe)
+		}
+		leaderboards[window] = byUser
+	}
+	return leaderboards, nil
+}
+
+// maxDrawdown returns the largest fall of values from a prior high, as a
+// fraction of that high
+func maxDrawdown(values []float64) float64 {
+	var high, drawdown float64
+	for _, v := range values {
+		if v > high {
+			high = v
+		}
+		if high > 0 {
+			drawdown = math.Max(drawdown, (high-v)/high)
+		}
+	}
+	return drawdown
+}
+
+// sortModels orders rankings by sortBy, best first, and numbers them.
+// Ties are broken by accuracy, then calibration, then error.
+func sortModels(rankings []ModelRanking, sortBy string) error {
+	byAccuracy := func(a, b ModelRanking) bool { return a.DirectionalAccuracy > b.DirectionalAccuracy }
+	byCalibration := func(a, b ModelRanking) bool { return a.CalibrationError < b.CalibrationError }
+	byError := func(a, b ModelRanking) bool { return a.MeanAbsoluteError < b.MeanAbsoluteError }
+
+	var order []func(a, b ModelRanking) bool
+	switch sortBy {
+	case "", SortAccuracy:
+		order = []func(a, b ModelRanking) bool{byAccuracy, byCalibration, byError}
+	case SortCalibration:
+		order = []func(a, b ModelRanking) bool{byCalibration, byAccuracy, byError}
+	case SortError:
+		order = []func(a, b ModelRanking) bool{byError, byAccuracy, byCalibration}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownSort, sortBy)
+	}
+
+	sort.SliceStable(rankings, func(i, j int) bool {
+		for _, less := range order {
+			if less(rankings[i], rankings[j]) {
+				return true
+			}
+			if less(rankings[j], rankings[i]) {
+				return false
+			}
+		}
+		return false
+	})
+	for i := range rankings {
+		rankings[i].Rank = i + 1
+	}
+	return nil
+}
+
+// sortStrategies orders rankings by sortBy, best first, and numbers them.
+// Ties are broken by Sharpe ratio, then return, then drawdown.
+func sortStrategies(rankings []StrategyRanking, sortBy string) error {
+	bySharpe := func(a, b StrategyRanking) bool { return a.SharpeRatio > b.SharpeRatio }
+	byReturn := func(a, b StrategyRanking) bool { return a.Return > b.Return }
+	byDrawdown := func(a, b StrategyRanking) bool { return a.MaxDrawdown < b.MaxDrawdown }
+
+	var order []func(a, b StrategyRanking) bool
+	switch sortBy {
+	case "", SortSharpe:
+		order = []func(a, b StrategyRanking) bool{bySharpe, byReturn, byDrawdown}
+	case SortReturn:
+		order = []func(a, b StrategyRanking) bool{byReturn, bySharpe, byDrawdown}
+	case SortDrawdown:
+		order = []func(a, b StrategyRanking) bool{byDrawdown, bySharpe, byReturn}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownSort, sortBy)
+	}
+
+	sort.SliceStable(rankings, func(i, j int) bool {
+		for _, less := range order {
+			if less(rankings[i], rankings[j]) {
+				return true
+			}
+			if less(rankings[j], rankings[i]) {
+				return false
+			}
+		}
+		return false
+	})
+	for i := range rankings {
+		rankings[i].Rank = i + 1
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLeaderboardWindow(t *testing.T) {
+	for _, tt := range []struct {
+		window string
+		days   int
+		ok     bool
+	}{
+		{"30d", 30, true},
+		{"7d", 7, true},
+		{"90d", 90, true},
+		{"14d", 0, false},
+		{"30", 0, false},
+		{"d", 0, false},
+	} {
+		days, err := ParseLeaderboardWindow(tt.window)
+		if tt.ok {
+			assert.NoError(t, err, tt.window)
+			assert.Equal(t, tt.days, days)
+		} else {
+			assert.ErrorIs(t, err, ErrUnknownWindow, tt.window)
+		}
+	}
+}
+
+// expectModelOutcomes expects the outcomes query of each window, answered
+// with rows for the 30 day window and nothing otherwise
+func expectModelOutcomes(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	columns := []string{"model_name", "decile", "count", "hits", "confidence", "error_sum", "error_count"}
+	for _, window := range LeaderboardWindows {
+		result := sqlmock.NewRows(columns)
+		if window == 30 {
+			result = rows
+		}
+		mock.ExpectQuery("SELECT (.+) FROM prediction_outcomes o").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(result)
+	}
+}
+
+// strategySnapshots adds n daily snapshot rows of a strategy ending on
+// today, its value moving by each of moves in turn
+func strategySnapshots(rows *sqlmock.Rows, today time.Time, userID uuid.UUID, id, name string, n int, moves ...float64) *sqlmock.Rows {
+	value := 100.0
+	for i := 0; i < n; i++ {
+		rows.AddRow(userID.String(), id, name, today.AddDate(0, 0, i-n+1), value)
+		value *= 1 + moves[i%len(moves)]
+	}
+	return rows
+}
+
+func TestLeaderboards(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	today := time.Date(2024, 3, 28, 0, 0, 0, 0, time.UTC)
+	leaderboards := NewLeaderboards(db)
+	leaderboards.now = func() time.Time { return today.Add(18 * time.Hour) }
+	user, other := uuid.New(), uuid.New()
+
+	expectModelOutcomes(mock, sqlmock.NewRows([]string{"model_name", "decile", "count", "hits", "confidence", "error_sum", "error_count"}).
+		// 40 predictions at 0.6 confidence, 24 right: perfectly calibrated
+		AddRow("calibrated", 6, 40, 24, 24.0, 8.0, 40).
+		// 40 predictions at 0.85 to 0.9 confidence, 28 right: more accurate,
+		// but overconfident
+		AddRow("overconfident", 9, 20, 14, 18.0, 2.0, 10).
+		AddRow("overconfident", 8, 20, 14, 17.0, 2.0, 10).
+		// Only 10 predictions, all right
+		AddRow("lucky", 9, 10, 10, 9.5, 0.0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM portfolio_snapshots s JOIN portfolios p").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(func() *sqlmock.Rows {
+			rows := sqlmock.NewRows([]string{"user_id", "id", "name", "snapshot_date", "total_value"})
+			strategySnapshots(rows, today, user, "steady", "Steady", 40, 0.01, -0.005)
+			strategySnapshots(rows, today, user, "volatile", "Volatile", 40, 0.05, -0.04)
+			// Too few days to be ranked
+			strategySnapshots(rows, today, user, "new", "New", 10, 0.02)
+			strategySnapshots(rows, today, other, "others", "Someone else's", 40, 0.01)
+			return rows
+		}())
+
+	require.NoError(t, leaderboards.Refresh(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("Models", func(t *testing.T) {
+		lb, err := leaderboards.Models(ctx, 30, "")
+		require.NoError(t, err)
+		assert.Equal(t, DefaultMinModelOutcomes, lb.MinSamples)
+		require.Len(t, lb.Models, 2)
+
+		over, calibrated := lb.Models[0], lb.Models[1]
+		assert.Equal(t, "overconfident", over.Model)
+		assert.Equal(t, 1, over.Rank)
+		assert.Equal(t, 40, over.Samples)
+		assert.InDelta(t, 0.7, over.DirectionalAccuracy, 1e-9)
+		assert.InDelta(t, 0.875, over.MeanConfidence, 1e-9)
+		// (|18-14| + |17-14|) / 40
+		assert.InDelta(t, 0.175, over.CalibrationError, 1e-9)
+		assert.InDelta(t, 0.2, over.MeanAbsoluteError, 1e-9)
+		assert.Equal(t, 20, over.ErrorSamples)
+
+		assert.Equal(t, "calibrated", calibrated.Model)
+		assert.InDelta(t, 0, calibrated.CalibrationError, 1e-9)
+
+		lb, err = leaderboards.Models(ctx, 30, SortCalibration)
+		require.NoError(t, err)
+		assert.Equal(t, "calibrated", lb.Models[0].Model)
+		assert.Equal(t, 1, lb.Models[0].Rank)
+
+		lb, err = leaderboards.Models(ctx, 7, "")
+		require.NoError(t, err)
+		assert.Empty(t, lb.Models)
+
+		_, err = leaderboards.Models(ctx, 30, "luck")
+		assert.ErrorIs(t, err, ErrUnknownSort)
+	})
+
+	t.Run("Strategies", func(t *testing.T) {
+		lb, err := leaderboards.Strategies(ctx, user, 30, "")
+		require.NoError(t, err)
+		require.Len(t, lb.Strategies, 2)
+
+		steady, volatile := lb.Strategies[0], lb.Strategies[1]
+		assert.Equal(t, "steady", steady.PortfolioID)
+		assert.Equal(t, 1, steady.Rank)
+		// 31 snapshots in the window
+		assert.Equal(t, 30, steady.Samples)
+		assert.Greater(t, steady.SharpeRatio, volatile.SharpeRatio)
+		assert.InDelta(t, 0.005, steady.MaxDrawdown, 1e-9)
+		assert.InDelta(t, 0.04, volatile.MaxDrawdown, 1e-9)
+		assert.Greater(t, volatile.Return, steady.Return)
+
+		lb, err = leaderboards.Strategies(ctx, user, 30, SortReturn)
+		require.NoError(t, err)
+		assert.Equal(t, "volatile", lb.Strategies[0].PortfolioID)
+
+		// Neither strategy has 20 days in a week
+		lb, err = leaderboards.Strategies(ctx, user, 7, "")
+		require.NoError(t, err)
+		assert.Empty(t, lb.Strategies)
+
+		lb, err = leaderboards.Strategies(ctx, uuid.New(), 30, "")
+		require.NoError(t, err)
+		assert.Empty(t, lb.Strategies)
+	})
+
+	_, err = leaderboards.Models(ctx, 14, "")
+	assert.ErrorIs(t, err, ErrUnknownWindow)
+	// Answered from the refresh
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	assert.InDelta(t, 0, maxDrawdown([]float64{100, 110, 120}), 1e-9)
+	assert.InDelta(t, 0.25, maxDrawdown([]float64{100, 120, 90, 110, 95}), 1e-9)
+	assert.InDelta(t, 0, maxDrawdown(nil), 1e-9)
+}
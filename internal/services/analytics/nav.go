@@ -0,0 +1,324 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+const (
+	// initialNAV is the NAV per unit of a portfolio's first deposit
+	initialNAV = 100
+	// navDust is the units outstanding below which a portfolio is empty
+	navDust = 1e-9
+)
+
+// ErrInvalidCashflow is returned for a cashflow of zero
+var ErrInvalidCashflow = errors.New("cashflow amount must be non-zero")
+
+// Cashflow is money moved into or out of a portfolio
+type Cashflow struct {
+	At time.Time `json:"at"`
+	// Amount is positive for a deposit and negative for a withdrawal
+	Amount float64 `json:"amount"`
+	// Currency is the cash the flow moves, models.PortfolioCurrency if empty
+	Currency string `json:"currency,omitempty"`
+}
+
+// navValuation is one snapshot of a portfolio's value
+type navValuation struct {
+	date     time.Time
+	takenAt  time.Time
+	timezone string
+	value    float64
+}
+
+// navPoint is a portfolio's unitized value at one snapshot
+type navPoint struct {
+	valuation navValuation
+	nav       float64
+	units     float64
+}
+
+// unitize replays valuations and flows, both in chronological order, into
+// a NAV per unit at each valuation. Flows are applied before the first
+// valuation taken at or after them, buying or selling units at the NAV of
+// the valuation before. The first deposit buys units at initialNAV.
+//
+// A valuation of zero closes out the units, for a full withdrawal or a
+// wipeout, and NAV is held until the portfolio is funded again at that NAV.
+// Value that appears without a recorded deposit buys units the same way.
+func unitize(valuations []navValuation, flows []Cashflow) []navPoint {
+	points := make([]navPoint, 0, len(valuations))
+	var units float64
+	nav := float64(initialNAV)
+	next := 0
+	for _, v := range valuations {
+		for ; next < len(flows) && !flows[next].At.After(v.takenAt); next++ {
+			units += flows[next].Amount / nav
+			// Withdrawing more than the last valuation left leaves nothing
+			if units < navDust {
+				units = 0
+			}
+		}
+
+		switch {
+		case v.value <= 0:
+			units = 0
+		case units == 0:
+			units = v.value / nav
+		default:
+			nav = v.value / units
+		}
+		points = append(points, navPoint{valuation: v, nav: nav, units: units})
+	}
+	return points
+}
+
+// timeWeightedReturn chains the returns of the periods between valuations,
+// taking flows to arrive at the start of the period they fall in. Periods
+// starting or ending empty don't move it, as they don't move NAV.
+func timeWeightedReturn(valuations []navValuation, flows []Cashflow) float64 {
+	growth := 1.0
+	var prev float64
+	next := 0
+	for _, v := range valuations {
+		start := prev
+		for ; next < len(flows) && !flows[next].At.After(v.takenAt); next++ {
+			start += flows[next].Amount
+		}
+		if start > 0 && v.value > 0 {
+			growth *= v.value / start
+		}
+		prev = v.value
+	}
+	return growth - 1
+}
+
+// RecordCashflow saves a deposit or withdrawal, moves the portfolio's cash
+// in the flow's currency by it and recomputes the portfolio's NAV, as a
+// backdated flow changes every NAV after it. It returns the portfolio's
+// cash afterwards. A withdrawal of more than the portfolio holds fails with
+// portfolio.ErrInsufficientBalance unless the portfolio is margin-enabled.
+func (s *Service) RecordCashflow(ctx context.Context, portfolioID int64, flow Cashflow) (models.CashBalance, error) {
+	if flow.Amount == 0 {
+		return nil, ErrInvalidCashflow
+	}
+	flow.Currency = models.CashCurrency(flow.Currency)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	amount := decimal.NewFromFloat(flow.Amount)
+	cash, err := portfolio.AdjustCash(ctx, tx, portfolioID, flow.Currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO portfolio_cashflows (portfolio_id, amount, currency, occurred_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := tx.ExecContext(ctx, query, portfolioID, flow.Amount, flow.Currency, flow.At); err != nil {
+		return nil, fmt.Errorf("failed to record cashflow of portfolio %d: %w", portfolioID, err)
+	}
+	change := portfolio.CashflowChange{Amount: amount, Currency: flow.Currency, At: flow.At}
+	if err := portfolio.AppendEvent(ctx, tx, portfolioID, portfolio.EventCashflowRecorded, change); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cash, s.RecomputeNAV(ctx, portfolioID)
+}
+
+// RecomputeNAV replays the portfolio's snapshots and cashflows from the
+// start and replaces its stored NAV series
+func (s *Service) RecomputeNAV(ctx context.Context, portfolioID int64) error {
+	valuations, flows, err := s.navHistory(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM portfolio_nav WHERE portfolio_id = $1`, portfolioID); err != nil {
+		return fmt.Errorf("failed to clear NAV of portfolio %d: %w", portfolioID, err)
+	}
+
+	query := `
+		INSERT INTO portfolio_nav (portfolio_id, nav_date, taken_at, timezone, nav, units, total_value)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	for _, p := range unitize(valuations, flows) {
+		v := p.valuation
+		if _, err := tx.ExecContext(ctx, query, portfolioID, v.date.Format("2006-01-02"), v.takenAt, v.timezone, p.nav, p.units, v.value); err != nil {
+			return fmt.Errorf("failed to save NAV of portfolio %d: %w", portfolioID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// RecomputeAllNAV recomputes the NAV of every portfolio. A portfolio that
+// fails is skipped and reported in the error.
+func (s *Service) RecomputeAllNAV(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM portfolios ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to list portfolios: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var failed int
+	var firstErr error
+	for _, id := range ids {
+		if err := s.RecomputeNAV(ctx, id); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to recompute NAV of %d of %d portfolios: %w", failed, len(ids), firstErr)
+	}
+	return nil
+}
+
+func (s *Service) navHistory(ctx context.Context, portfolioID int64) ([]navValuation, []Cashflow, error) {
+	query := `
+		SELECT snapshot_date, taken_at, timezone, total_value
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1
+		ORDER BY taken_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get snapshots of portfolio %d: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var valuations []navValuation
+	for rows.Next() {
+		var v navValuation
+		if err := rows.Scan(&v.date, &v.takenAt, &v.timezone, &v.value); err != nil {
+			return nil, nil, err
+		}
+		valuations = append(valuations, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Flows in other currencies are unitized at what they were worth in the
+	// portfolio currency when they happened, at the pair's last close by
+	// then, e.g. EURUSD
+	query = `
+		SELECT f.occurred_at, f.amount, f.currency, rate.close
+		FROM portfolio_cashflows f
+		LEFT JOIN LATERAL (
+			SELECT close FROM market_data
+			WHERE symbol = f.currency || $2 AND timestamp <= f.occurred_at
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) rate ON f.currency <> $2
+		WHERE f.portfolio_id = $1
+		ORDER BY f.occurred_at, f.id
+	`
+	flowRows, err := s.db.QueryContext(ctx, query, portfolioID, models.PortfolioCurrency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get cashflows of portfolio %d: %w", portfolioID, err)
+	}
+	defer flowRows.Close()
+
+	var flows []Cashflow
+	for flowRows.Next() {
+		var f Cashflow
+		var rate sql.NullFloat64
+		if err := flowRows.Scan(&f.At, &f.Amount, &f.Currency, &rate); err != nil {
+			return nil, nil, err
+		}
+		if f.Currency != models.PortfolioCurrency {
+			if !rate.Valid {
+				return nil, nil, fmt.Errorf("no %s%s price by %s to value a cashflow of portfolio %d",
+					f.Currency, models.PortfolioCurrency, f.At.Format(time.RFC3339), portfolioID)
+			}
+			f.Amount *= rate.Float64
+		}
+		flows = append(flows, f)
+	}
+	return valuations, flows, flowRows.Err()
+}
+
+// GetNAVPerformance returns the portfolio's daily NAV per unit over the last
+// days days, as stored by RecomputeNAV. Returns are of NAV, so deposits and
+// withdrawals don't show as performance.
+func (s *Service) GetNAVPerformance(ctx context.Context, portfolioID string, days int) (*PerformanceSeries, error) {
+	query := `
+		SELECT nav_date, nav, units, timezone
+		FROM portfolio_nav
+		WHERE portfolio_id = $1 AND nav_date >= $2
+		ORDER BY nav_date
+	`
+	since := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := s.db.QueryContext(ctx, query, portfolioID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NAV of portfolio %s: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var points []calendar.Point
+	var units []float64
+	timezones := make(map[string]bool)
+	for rows.Next() {
+		var date time.Time
+		var nav, u float64
+		var timezone string
+		if err := rows.Scan(&date, &nav, &u, &timezone); err != nil {
+			return nil, err
+		}
+		points = append(points, calendar.Point{Time: date, Value: nav})
+		units = append(units, u)
+		timezones[timezone] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := &PerformanceSeries{PortfolioID: portfolioID, Basis: BasisNAV}
+	if len(timezones) == 1 {
+		for tz := range timezones {
+			series.Timezone = tz
+		}
+	}
+	series.Days, series.CumulativeReturn = dailyPerformance(points)
+	for i := range series.Days {
+		series.Days[i].Units = units[i]
+	}
+	return series, nil
+}
@@ -0,0 +1,215 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+// navDay is the time of day i of a test history, with snapshots taken in
+// the evening and cashflows in the morning before them
+func navDay(i, hour int) time.Time {
+	return time.Date(2024, time.March, 11+i, hour, 0, 0, 0, time.UTC)
+}
+
+func navValuations(values ...float64) []navValuation {
+	valuations := make([]navValuation, len(values))
+	for i, v := range values {
+		valuations[i] = navValuation{date: navDay(i, 0), takenAt: navDay(i, 20), timezone: "UTC", value: v}
+	}
+	return valuations
+}
+
+func TestUnitize(t *testing.T) {
+	// Funded, grown 10%, topped up, fully withdrawn, then funded again
+	valuations := navValuations(1000, 1100, 1800, 0, 660)
+	flows := []Cashflow{
+		{At: navDay(0, 9), Amount: 1000},
+		{At: navDay(2, 9), Amount: 550},
+		{At: navDay(3, 9), Amount: -1800},
+		{At: navDay(4, 9), Amount: 600},
+	}
+
+	points := unitize(valuations, flows)
+	if !assert.Len(t, points, 5) {
+		return
+	}
+	expected := []struct{ nav, units float64 }{
+		// The first deposit buys units at 100
+		{100, 10},
+		{110, 10},
+		// The top-up buys 5 units at the previous NAV of 110
+		{120, 15},
+		// Emptied: NAV is held with no units outstanding
+		{120, 0},
+		// Refunded at the held NAV
+		{132, 5},
+	}
+	for i, p := range points {
+		assert.InDelta(t, expected[i].nav, p.nav, 1e-9, "day %d", i)
+		assert.InDelta(t, expected[i].units, p.units, 1e-9, "day %d", i)
+	}
+
+	t.Run("Value without a recorded deposit", func(t *testing.T) {
+		points := unitize(navValuations(500, 550), nil)
+		if assert.Len(t, points, 2) {
+			assert.Equal(t, 100.0, points[0].nav)
+			assert.Equal(t, 5.0, points[0].units)
+			assert.InDelta(t, 110.0, points[1].nav, 1e-9)
+		}
+	})
+
+	t.Run("Withdrawal beyond the last valuation", func(t *testing.T) {
+		// The price rose after the last valuation, so the withdrawal is
+		// worth more units than are outstanding
+		points := unitize(navValuations(1000, 0), []Cashflow{
+			{At: navDay(0, 9), Amount: 1000},
+			{At: navDay(1, 9), Amount: -1050},
+		})
+		if assert.Len(t, points, 2) {
+			assert.Equal(t, 0.0, points[1].units)
+			assert.Equal(t, 100.0, points[1].nav)
+		}
+	})
+}
+
+// NAV set at 100 by the first deposit must end at 100 times the growth of
+// the time-weighted return of the same history
+func TestUnitize_ReconcilesWithTimeWeightedReturn(t *testing.T) {
+	tests := []struct {
+		name       string
+		valuations []navValuation
+		flows      []Cashflow
+	}{
+		{
+			"Deposits and full withdrawal",
+			navValuations(1000, 1100, 1800, 0, 660),
+			[]Cashflow{
+				{At: navDay(0, 9), Amount: 1000},
+				{At: navDay(2, 9), Amount: 550},
+				{At: navDay(3, 9), Amount: -1800},
+				{At: navDay(4, 9), Amount: 600},
+			},
+		},
+		{
+			"Several flows between valuations",
+			navValuations(2500, 2430.5, 3987.25, 3102.8, 3350.17, 1204.9),
+			[]Cashflow{
+				{At: navDay(0, 8), Amount: 2000},
+				{At: navDay(0, 15), Amount: 500},
+				{At: navDay(2, 9), Amount: 1500},
+				{At: navDay(3, 9), Amount: -750},
+				{At: navDay(3, 10), Amount: 125.5},
+				{At: navDay(5, 9), Amount: -2200},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := unitize(tt.valuations, tt.flows)
+			navReturn := points[len(points)-1].nav/initialNAV - 1
+			assert.InDelta(t, timeWeightedReturn(tt.valuations, tt.flows), navReturn, 1e-9)
+		})
+	}
+
+	// 10%, then 1800 on 1650, then 10% again after refunding
+	twr := timeWeightedReturn(tests[0].valuations, tests[0].flows)
+	assert.InDelta(t, 0.32, twr, 1e-9)
+}
+
+func TestRecomputeNAV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	snapshots := sqlmock.NewRows([]string{"snapshot_date", "taken_at", "timezone", "total_value"})
+	for i, v := range []float64{1000, 1100} {
+		snapshots.AddRow(navDay(i, 0), navDay(i, 20), "UTC", v)
+	}
+	mock.ExpectQuery("SELECT snapshot_date, taken_at, timezone, total_value FROM portfolio_snapshots").
+		WithArgs(int64(7)).
+		WillReturnRows(snapshots)
+	// EUR 800 deposited when EURUSD closed at 1.25 buys $1,000 of units
+	mock.ExpectQuery("SELECT f.occurred_at, f.amount, f.currency, rate.close FROM portfolio_cashflows").
+		WithArgs(int64(7), "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"occurred_at", "amount", "currency", "close"}).
+			AddRow(navDay(0, 9), 800.0, "EUR", 1.25))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM portfolio_nav").
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO portfolio_nav").
+		WithArgs(int64(7), "2024-03-11", navDay(0, 20), "UTC", 100.0, 10.0, 1000.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO portfolio_nav").
+		WithArgs(int64(7), "2024-03-12", navDay(1, 20), "UTC", 110.0, 10.0, 1100.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, NewService(db, nil).RecomputeNAV(context.Background(), 7))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordCashflow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	lock := func(balance string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT balance, margin_enabled FROM portfolios").
+			WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "margin_enabled"}).AddRow(balance, false))
+		mock.ExpectQuery("SELECT currency, amount FROM portfolio_cash").
+			WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "amount"}).AddRow("EUR", "100"))
+	}
+
+	// A EUR deposit goes to the EUR bucket, leaving the balance alone
+	lock("1000")
+	mock.ExpectExec("INSERT INTO portfolio_cash").
+		WithArgs(int64(7), "EUR", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO portfolio_cashflows").
+		WithArgs(int64(7), 250.0, "EUR", navDay(0, 9)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SELECT id FROM portfolios").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO portfolio_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT snapshot_date, taken_at, timezone, total_value FROM portfolio_snapshots").
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_date", "taken_at", "timezone", "total_value"}))
+	mock.ExpectQuery("SELECT f.occurred_at, f.amount, f.currency, rate.close FROM portfolio_cashflows").
+		WithArgs(int64(7), "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"occurred_at", "amount", "currency", "close"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM portfolio_nav").WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	svc := NewService(db, nil)
+	cash, err := svc.RecordCashflow(context.Background(), 7, Cashflow{At: navDay(0, 9), Amount: 250, Currency: "eur"})
+	require.NoError(t, err)
+	assert.Equal(t, "1000", cash["USD"].String())
+	assert.Equal(t, "350", cash["EUR"].String())
+
+	// Withdrawing more than the portfolio holds records nothing
+	lock("1000")
+	mock.ExpectRollback()
+	_, err = svc.RecordCashflow(context.Background(), 7, Cashflow{At: navDay(0, 9), Amount: -1500})
+	assert.ErrorIs(t, err, portfolio.ErrInsufficientBalance)
+
+	_, err = svc.RecordCashflow(context.Background(), 7, Cashflow{At: navDay(0, 9)})
+	assert.ErrorIs(t, err, ErrInvalidCashflow)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,114 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+)
+
+// Bases a performance series can be measured on
+const (
+	// BasisValue measures the portfolio's total value, so deposits and
+	// withdrawals show as returns
+	BasisValue = "value"
+	// BasisNAV measures NAV per unit, which only performance moves
+	BasisNAV = "nav"
+)
+
+// DailyPerformance is a portfolio's value at the close of one day
+type DailyPerformance struct {
+	Date   string  `json:"date"`
+	Value  float64 `json:"value"`
+	Return float64 `json:"return"`
+	// Units is the units outstanding, on the NAV basis
+	Units float64 `json:"units,omitempty"`
+}
+
+// PerformanceSeries is a portfolio's daily values in one calendar
+type PerformanceSeries struct {
+	PortfolioID string `json:"portfolio_id"`
+	// Basis is what Value measures, BasisValue or BasisNAV
+	Basis string `json:"basis"`
+	// Timezone is the calendar the days are counted in. It is empty when
+	// the series' snapshots were dated in more than one timezone.
+	Timezone         string             `json:"timezone"`
+	Days             []DailyPerformance `json:"days"`
+	CumulativeReturn float64            `json:"cumulative_return"`
+}
+
+// WithCalendars counts each symbol's days in its exchange timezone instead
+// of UTC
+func (s *Service) WithCalendars(calendars *calendar.Resolver) *Service {
+	s.calendars = calendars
+	return s
+}
+
+// GetDailyPerformance returns the portfolio's daily values over the last
+// days days. With a nil loc the days are those the snapshot job dated them
+// on, in the portfolio's own calendar. Otherwise snapshots are re-bucketed
+// into calendar days in loc by when they were taken, and each day's value is
+// the last snapshot taken on it.
+func (s *Service) GetDailyPerformance(ctx context.Context, portfolioID string, days int, loc *time.Location) (*PerformanceSeries, error) {
+	query := `
+		SELECT snapshot_date, taken_at, total_value, timezone
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1 AND snapshot_date >= $2
+		ORDER BY taken_at
+	`
+	since := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := s.db.QueryContext(ctx, query, portfolioID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots of portfolio %s: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var dated, taken []calendar.Point
+	timezones := make(map[string]bool)
+	for rows.Next() {
+		var date, takenAt time.Time
+		var value float64
+		var timezone string
+		if err := rows.Scan(&date, &takenAt, &value, &timezone); err != nil {
+			return nil, err
+		}
+		dated = append(dated, calendar.Point{Time: date, Value: value})
+		taken = append(taken, calendar.Point{Time: takenAt, Value: value})
+		timezones[timezone] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := &PerformanceSeries{PortfolioID: portfolioID, Basis: BasisValue}
+	points := dated
+	if loc != nil {
+		points = calendar.Daily(taken, loc)
+		series.Timezone = loc.String()
+	} else if len(timezones) == 1 {
+		for tz := range timezones {
+			series.Timezone = tz
+		}
+	}
+	series.Days, series.CumulativeReturn = dailyPerformance(points)
+	return series, nil
+}
+
+// dailyPerformance turns daily values into day-over-day returns. The first
+// day has no prior close and reports a zero return.
+func dailyPerformance(points []calendar.Point) ([]DailyPerformance, float64) {
+	days := make([]DailyPerformance, len(points))
+	for i, p := range points {
+		days[i] = DailyPerformance{Date: p.Time.Format("2006-01-02"), Value: p.Value}
+		if i > 0 && points[i-1].Value != 0 {
+			days[i].Return = p.Value/points[i-1].Value - 1
+		}
+	}
+
+	var cumulative float64
+	if len(points) > 1 && points[0].Value != 0 {
+		cumulative = points[len(points)-1].Value/points[0].Value - 1
+	}
+	return days, cumulative
+}
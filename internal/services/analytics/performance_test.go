@@ -0,0 +1,110 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDailyPerformance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	service := NewService(db, nil)
+	ctx := context.Background()
+
+	// Snapshots dated in New York and taken late each evening, across the
+	// end of DST on 3 November, which is 25 hours long
+	snapshots := func() *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"snapshot_date", "taken_at", "total_value", "timezone"})
+		for i, value := range []float64{100, 102, 101, 104} {
+			day := time.Date(2024, time.November, 2+i, 0, 0, 0, 0, time.UTC)
+			taken := time.Date(2024, time.November, 2+i, 23, 30, 0, 0, newYork)
+			rows.AddRow(day, taken, value, "America/New_York")
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		timezone string
+		dates    []string
+	}{
+		{"As snapshotted", nil, "America/New_York", []string{"2024-11-02", "2024-11-03", "2024-11-04", "2024-11-05"}},
+		{"Same calendar", newYork, "America/New_York", []string{"2024-11-02", "2024-11-03", "2024-11-04", "2024-11-05"}},
+		// 23:30 in New York is past midnight in UTC and Tokyo, before and
+		// after the clocks change
+		{"UTC", time.UTC, "UTC", []string{"2024-11-03", "2024-11-04", "2024-11-05", "2024-11-06"}},
+		{"Tokyo", tokyo, "Asia/Tokyo", []string{"2024-11-03", "2024-11-04", "2024-11-05", "2024-11-06"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery("SELECT snapshot_date, taken_at, total_value, timezone FROM portfolio_snapshots").
+				WithArgs("7", sqlmock.AnyArg()).
+				WillReturnRows(snapshots())
+
+			series, err := service.GetDailyPerformance(ctx, "7", 30, tt.loc)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.timezone, series.Timezone)
+			if !assert.Len(t, series.Days, len(tt.dates)) {
+				return
+			}
+			for i, day := range series.Days {
+				assert.Equal(t, tt.dates[i], day.Date)
+			}
+			assert.Equal(t, 0.0, series.Days[0].Return)
+			assert.InDelta(t, 0.02, series.Days[1].Return, 1e-9)
+			assert.InDelta(t, 0.04, series.CumulativeReturn, 1e-9)
+		})
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDailyPerformance_RebucketsDuplicateDays(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	// Snapshots of two UTC days taken on the same New York evening collapse
+	// into the later one
+	taken := []time.Time{
+		time.Date(2024, time.March, 9, 23, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 10, 3, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 11, 3, 0, 0, 0, time.UTC),
+	}
+	rows := sqlmock.NewRows([]string{"snapshot_date", "taken_at", "total_value", "timezone"})
+	for i, at := range taken {
+		rows.AddRow(at.Truncate(24*time.Hour), at, 100+float64(i), "UTC")
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery("SELECT snapshot_date, taken_at, total_value, timezone FROM portfolio_snapshots").
+		WillReturnRows(rows)
+
+	series, err := NewService(db, nil).GetDailyPerformance(context.Background(), "7", 30, newYork)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, series.Days, 2) {
+		assert.Equal(t, "2024-03-09", series.Days[0].Date)
+		assert.Equal(t, 101.0, series.Days[0].Value)
+		// 10 March is 23 hours long in New York and still one day
+		assert.Equal(t, "2024-03-10", series.Days[1].Date)
+		assert.Equal(t, 102.0, series.Days[1].Value)
+	}
+}
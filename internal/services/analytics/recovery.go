@@ -0,0 +1,111 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DrawdownRecovery compares a portfolio's current drawdown with how long
+// its past drawdowns took to recover. Recovery times are in trading days,
+// counted in daily snapshots from each trough to the snapshot that first
+// exceeded the prior high-water mark.
+type DrawdownRecovery struct {
+	CurrentDrawdownPct        float64 `json:"current_drawdown_pct"`
+	HistoricalAvgRecoveryDays float64 `json:"historical_avg_recovery_days"`
+	HistoricalMaxRecoveryDays int     `json:"historical_max_recovery_days"`
+	// RecoveredDrawdowns is how many drawdowns the averages are taken over
+	RecoveredDrawdowns int `json:"recovered_drawdowns"`
+	// EstimatedRecoveryDate is today plus the average recovery time, in
+	// trading days
+	EstimatedRecoveryDate time.Time `json:"estimated_recovery_date"`
+}
+
+// EstimateRecoveryTime estimates when a portfolio will recover from its
+// current drawdown based on its snapshot history
+func (s *Service) EstimateRecoveryTime(ctx context.Context, portfolioID string) (*DrawdownRecovery, error) {
+	query := `
+		SELECT total_value
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1
+		ORDER BY snapshot_date
+	`
+	rows, err := s.db.QueryContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots of portfolio %s: %w", portfolioID, err)
+	}
+	defer rows.Close()
+
+	var values []float64
+	for rows.Next() {
+		var value float64
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return drawdownRecovery(values, time.Now())
+}
+
+// drawdownRecovery measures every completed drawdown of a daily equity curve
+func drawdownRecovery(values []float64, today time.Time) (*DrawdownRecovery, error) {
+	if len(values) < 2 {
+		return nil, fmt.Errorf("need at least 2 snapshots: %w", ErrInsufficientHistory)
+	}
+
+	recovery := &DrawdownRecovery{}
+	highWaterMark := values[0]
+	trough, troughDay := values[0], 0
+	inDrawdown := false
+	totalDays := 0
+
+	for i, v := range values[1:] {
+		day := i + 1
+		switch {
+		case v > highWaterMark:
+			if inDrawdown {
+				days := day - troughDay
+				totalDays += days
+				recovery.RecoveredDrawdowns++
+				if days > recovery.HistoricalMaxRecoveryDays {
+					recovery.HistoricalMaxRecoveryDays = days
+				}
+				inDrawdown = false
+			}
+			highWaterMark = v
+		case v < highWaterMark:
+			if !inDrawdown || v < trough {
+				trough, troughDay = v, day
+			}
+			inDrawdown = true
+		}
+	}
+
+	last := values[len(values)-1]
+	if highWaterMark > 0 {
+		recovery.CurrentDrawdownPct = math.Max(0, (highWaterMark-last)/highWaterMark*100)
+	}
+	if recovery.RecoveredDrawdowns > 0 {
+		recovery.HistoricalAvgRecoveryDays = float64(totalDays) / float64(recovery.RecoveredDrawdowns)
+	}
+
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	recovery.EstimatedRecoveryDate = addTradingDays(start, int(math.Ceil(recovery.HistoricalAvgRecoveryDays)))
+	return recovery, nil
+}
+
+// addTradingDays moves n weekdays forward from day
+func addTradingDays(day time.Time, n int) time.Time {
+	for n > 0 {
+		day = day.AddDate(0, 0, 1)
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			n--
+		}
+	}
+	return day
+}
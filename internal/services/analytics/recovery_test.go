@@ -0,0 +1,79 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// twoCycleCurve falls 18% from 110 and takes 3 days from its trough to make
+// a new high, then falls 20% from 115 and takes 4 days, and ends 3.4% below
+// its last high
+var twoCycleCurve = []float64{100, 110, 100, 90, 95, 105, 111, 115, 100, 92, 100, 105, 110, 116, 112}
+
+func TestDrawdownRecovery(t *testing.T) {
+	friday := time.Date(2024, time.March, 1, 15, 30, 0, 0, time.UTC)
+
+	recovery, err := drawdownRecovery(twoCycleCurve, friday)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, recovery.RecoveredDrawdowns)
+	assert.InDelta(t, 3.5, recovery.HistoricalAvgRecoveryDays, 1e-9)
+	assert.Equal(t, 4, recovery.HistoricalMaxRecoveryDays)
+	assert.InDelta(t, 4.0/116*100, recovery.CurrentDrawdownPct, 1e-9)
+	// 4 trading days on from a Friday skips the weekend
+	assert.Equal(t, time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC), recovery.EstimatedRecoveryDate)
+
+	t.Run("At a new high", func(t *testing.T) {
+		recovery, err := drawdownRecovery(append(twoCycleCurve, 120), friday)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 0.0, recovery.CurrentDrawdownPct)
+		// The new high also recovers the final dip from 116 to 112
+		assert.Equal(t, 3, recovery.RecoveredDrawdowns)
+	})
+
+	t.Run("Never recovered", func(t *testing.T) {
+		recovery, err := drawdownRecovery([]float64{100, 90, 80}, friday)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.InDelta(t, 20, recovery.CurrentDrawdownPct, 1e-9)
+		assert.Equal(t, 0, recovery.RecoveredDrawdowns)
+		assert.Equal(t, 0.0, recovery.HistoricalAvgRecoveryDays)
+	})
+
+	t.Run("Too few snapshots", func(t *testing.T) {
+		_, err := drawdownRecovery([]float64{100}, friday)
+		assert.ErrorIs(t, err, ErrInsufficientHistory)
+	})
+}
+
+func TestEstimateRecoveryTime(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"total_value"})
+	for _, v := range twoCycleCurve {
+		rows.AddRow(v)
+	}
+	mock.ExpectQuery("SELECT total_value FROM portfolio_snapshots WHERE portfolio_id = \\$1 ORDER BY snapshot_date").
+		WithArgs("7").
+		WillReturnRows(rows)
+
+	recovery, err := NewService(db, nil).EstimateRecoveryTime(context.Background(), "7")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 4, recovery.HistoricalMaxRecoveryDays)
+	assert.True(t, recovery.EstimatedRecoveryDate.After(time.Now()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,224 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// MarketRegime is the trend regime of a single symbol
+type MarketRegime string
+
+const (
+	RegimeBull     MarketRegime = "bull"
+	RegimeBear     MarketRegime = "bear"
+	RegimeSideways MarketRegime = "sideways"
+)
+
+// GlobalRegime classifies the market as a whole
+type GlobalRegime string
+
+const (
+	RiskOn  GlobalRegime = "risk_on"
+	RiskOff GlobalRegime = "risk_off"
+	Mixed   GlobalRegime = "mixed"
+)
+
+// EventGlobalRegimeChange is dispatched when the global regime changes
+const EventGlobalRegimeChange = "GLOBAL_REGIME_CHANGE"
+
+const (
+	// regimeTrendWindow is the moving average a symbol's price is compared to
+	regimeTrendWindow = 50
+	// regimeMomentumWindow is the lookback for the momentum check
+	regimeMomentumWindow = 20
+	// globalRegimeThreshold is the share of symbols in one regime needed to
+	// call the market risk-on or risk-off
+	globalRegimeThreshold = 0.6
+	globalRegimeTTL       = time.Hour
+)
+
+var ErrInsufficientHistory = errors.New("insufficient price history")
+
+// SubscriptionService lists the symbols users are subscribed to
+type SubscriptionService interface {
+	GetActiveSymbols(ctx context.Context) ([]string, error)
+}
+
+// WebhookDispatcher delivers events to registered webhooks
+type WebhookDispatcher interface {
+	Dispatch(ctx context.Context, event string, payload interface{}) error
+}
+
+type GlobalRegimeReport struct {
+	Regime             GlobalRegime            `json:"regime"`
+	RegimeDistribution map[string]int          `json:"regime_distribution"`
+	ConfidenceScore    float64                 `json:"confidence_score"`
+	Symbols            map[string]MarketRegime `json:"symbols"`
+	GeneratedAt        time.Time               `json:"generated_at"`
+}
+
+// GlobalRegimeChange is the payload of EventGlobalRegimeChange
+type GlobalRegimeChange struct {
+	Previous GlobalRegime        `json:"previous"`
+	Current  GlobalRegime        `json:"current"`
+	Report   *GlobalRegimeReport `json:"report"`
+}
+
+// WithSubscriptions sets where DetectGlobalRegime gets its symbols
+func (s *Service) WithSubscriptions(subscriptions SubscriptionService) *Service {
+	s.subscriptions = subscriptions
+	return s
+}
+
+// WithWebhooks enables regime change events
+func (s *Service) WithWebhooks(webhooks WebhookDispatcher) *Service {
+	s.webhooks = webhooks
+	return s
+}
+
+// DetectMarketRegime classifies symbol as bull when its price is above its
+// 50-day average and up over 20 days, bear when below and down, and
+// sideways otherwise
+func (s *Service) DetectMarketRegime(ctx context.Context, symbol string) (MarketRegime, error) {
+	query := `
+		SELECT price FROM (
+			SELECT price, date FROM asset_prices
+			WHERE symbol = $1
+			ORDER BY date DESC
+			LIMIT $2
+		) recent
+		ORDER BY date
+	`
+	rows, err := s.db.QueryContext(ctx, query, symbol, regimeTrendWindow)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var prices []float64
+	for rows.Next() {
+		var price float64
+		if err := rows.Scan(&price); err != nil {
+			return "", err
+		}
+		prices = append(prices, price)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return classifyRegime(prices)
+}
+
+func classifyRegime(prices []float64) (MarketRegime, error) {
+	if len(prices) < regimeTrendWindow {
+		return "", ErrInsufficientHistory
+	}
+
+	var sum float64
+	for _, p := range prices {
+		sum += p
+	}
+	average := sum / float64(len(prices))
+
+	last := prices[len(prices)-1]
+	earlier := prices[len(prices)-1-regimeMomentumWindow]
+	if earlier == 0 {
+		return RegimeSideways, nil
+	}
+	momentum := (last - earlier) / earlier
+
+	switch {
+	case last > average && momentum > 0:
+		return RegimeBull, nil
+	case last < average && momentum < 0:
+		return RegimeBear, nil
+	default:
+		return RegimeSideways, nil
+	}
+}
+
+// DetectGlobalRegime classifies the market from the regimes of every
+// subscribed symbol. Reports are cached for an hour, and a change of
+// classification is sent to webhooks as EventGlobalRegimeChange.
+func (s *Service) DetectGlobalRegime(ctx context.Context) (*GlobalRegimeReport, error) {
+	s.regimeMu.Lock()
+	defer s.regimeMu.Unlock()
+
+	previous := s.globalRegime
+	if previous != nil && time.Since(previous.GeneratedAt) < globalRegimeTTL {
+		return previous, nil
+	}
+
+	if s.subscriptions == nil {
+		return nil, fmt.Errorf("no subscription service configured")
+	}
+	symbols, err := s.subscriptions.GetActiveSymbols(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active symbols: %w", err)
+	}
+
+	regimes := make(map[string]MarketRegime, len(symbols))
+	for _, symbol := range symbols {
+		regime, err := s.DetectMarketRegime(ctx, symbol)
+		if err != nil {
+			// A symbol without enough history shouldn't hide the rest
+			logger.FromContext(ctx).Warnf("Skipping %s in global regime: %v", symbol, err)
+			continue
+		}
+		regimes[symbol] = regime
+	}
+	if len(regimes) == 0 {
+		return nil, fmt.Errorf("no symbol regimes available: %w", ErrInsufficientHistory)
+	}
+
+	report := classifyGlobalRegime(regimes)
+	s.globalRegime = report
+
+	if previous != nil && previous.Regime != report.Regime && s.webhooks != nil {
+		change := GlobalRegimeChange{Previous: previous.Regime, Current: report.Regime, Report: report}
+		if err := s.webhooks.Dispatch(ctx, EventGlobalRegimeChange, change); err != nil {
+			logger.FromContext(ctx).Errorf("Failed to dispatch %s: %v", EventGlobalRegimeChange, err)
+		}
+	}
+
+	return report, nil
+}
+
+func classifyGlobalRegime(regimes map[string]MarketRegime) *GlobalRegimeReport {
+	report := &GlobalRegimeReport{
+		Regime:             Mixed,
+		RegimeDistribution: make(map[string]int),
+		Symbols:            regimes,
+		GeneratedAt:        time.Now(),
+	}
+	if len(regimes) == 0 {
+		return report
+	}
+
+	for _, regime := range regimes {
+		report.RegimeDistribution[string(regime)]++
+	}
+
+	total := float64(len(regimes))
+	dominant := 0
+	for _, count := range report.RegimeDistribution {
+		if count > dominant {
+			dominant = count
+		}
+	}
+	report.ConfidenceScore = float64(dominant) / total
+
+	switch {
+	case float64(report.RegimeDistribution[string(RegimeBull)])/total > globalRegimeThreshold:
+		report.Regime = RiskOn
+	case float64(report.RegimeDistribution[string(RegimeBear)])/total > globalRegimeThreshold:
+		report.Regime = RiskOff
+	}
+
+	return report
+}
@@ -0,0 +1,142 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func regimesOf(counts map[MarketRegime]int) map[string]MarketRegime {
+	regimes := make(map[string]MarketRegime)
+	for regime, n := range counts {
+		for i := 0; i < n; i++ {
+			regimes[fmt.Sprintf("%s-%d", regime, i)] = regime
+		}
+	}
+	return regimes
+}
+
+func TestClassifyGlobalRegime(t *testing.T) {
+	tests := []struct {
+		name       string
+		counts     map[MarketRegime]int
+		regime     GlobalRegime
+		confidence float64
+	}{
+		{"mostly bull", map[MarketRegime]int{RegimeBull: 7, RegimeBear: 3}, RiskOn, 0.7},
+		{"mostly bear", map[MarketRegime]int{RegimeBear: 8, RegimeSideways: 2}, RiskOff, 0.8},
+		{"exactly 60% bull is not enough", map[MarketRegime]int{RegimeBull: 6, RegimeBear: 4}, Mixed, 0.6},
+		{"split", map[MarketRegime]int{RegimeBull: 4, RegimeBear: 3, RegimeSideways: 3}, Mixed, 0.4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := classifyGlobalRegime(regimesOf(tt.counts))
+			assert.Equal(t, tt.regime, report.Regime)
+			assert.InDelta(t, tt.confidence, report.ConfidenceScore, 1e-9)
+			for regime, n := range tt.counts {
+				assert.Equal(t, n, report.RegimeDistribution[string(regime)])
+			}
+		})
+	}
+}
+
+func TestClassifyRegime(t *testing.T) {
+	rising := make([]float64, regimeTrendWindow)
+	falling := make([]float64, regimeTrendWindow)
+	for i := range rising {
+		rising[i] = 100 + float64(i)
+		falling[i] = 200 - float64(i)
+	}
+
+	regime, err := classifyRegime(rising)
+	assert.NoError(t, err)
+	assert.Equal(t, RegimeBull, regime)
+
+	regime, err = classifyRegime(falling)
+	assert.NoError(t, err)
+	assert.Equal(t, RegimeBear, regime)
+
+	_, err = classifyRegime(rising[:10])
+	assert.ErrorIs(t, err, ErrInsufficientHistory)
+}
+
+type staticSymbols []string
+
+func (s staticSymbols) GetActiveSymbols(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+type recordedEvent struct {
+	event   string
+	payload interface{}
+}
+
+type recordingWebhooks struct {
+	events []recordedEvent
+}
+
+func (r *recordingWebhooks) Dispatch(ctx context.Context, event string, payload interface{}) error {
+	r.events = append(r.events, recordedEvent{event, payload})
+	return nil
+}
+
+func priceRows(start, step float64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"price"})
+	for i := 0; i < regimeTrendWindow; i++ {
+		rows.AddRow(start + step*float64(i))
+	}
+	return rows
+}
+
+func TestDetectGlobalRegime(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	webhooks := &recordingWebhooks{}
+	service := NewService(db, nil).
+		WithSubscriptions(staticSymbols{"BTC", "ETH"}).
+		WithWebhooks(webhooks)
+	ctx := context.Background()
+
+	t.Run("First report is cached and sends no event", func(t *testing.T) {
+		mock.ExpectQuery("SELECT price FROM").WithArgs("BTC", regimeTrendWindow).WillReturnRows(priceRows(100, 1))
+		mock.ExpectQuery("SELECT price FROM").WithArgs("ETH", regimeTrendWindow).WillReturnRows(priceRows(100, 1))
+
+		report, err := service.DetectGlobalRegime(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, RiskOn, report.Regime)
+		assert.Empty(t, webhooks.events)
+
+		// Served from cache without touching the database
+		cached, err := service.DetectGlobalRegime(ctx)
+		assert.NoError(t, err)
+		assert.Same(t, report, cached)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Regime change after expiry is dispatched", func(t *testing.T) {
+		service.globalRegime.GeneratedAt = time.Now().Add(-2 * globalRegimeTTL)
+
+		mock.ExpectQuery("SELECT price FROM").WithArgs("BTC", regimeTrendWindow).WillReturnRows(priceRows(200, -1))
+		mock.ExpectQuery("SELECT price FROM").WithArgs("ETH", regimeTrendWindow).WillReturnRows(priceRows(200, -1))
+
+		report, err := service.DetectGlobalRegime(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, RiskOff, report.Regime)
+		if assert.Len(t, webhooks.events, 1) {
+			assert.Equal(t, EventGlobalRegimeChange, webhooks.events[0].event)
+			change := webhooks.events[0].payload.(GlobalRegimeChange)
+			assert.Equal(t, RiskOn, change.Previous)
+			assert.Equal(t, RiskOff, change.Current)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
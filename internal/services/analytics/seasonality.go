@@ -0,0 +1,256 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+)
+
+const (
+	// seasonalityTTL is how long a seasonality report is cached
+	seasonalityTTL = 24 * time.Hour
+	// minSeasonalityHistory is the least history seasonality is computed
+	// from; with less, most buckets hold a handful of observations
+	minSeasonalityHistory = 365 * 24 * time.Hour
+	// turnOfMonthDays is how many trading days either side of month-end
+	// count as the turn of the month
+	turnOfMonthDays = 3
+	// significantTStat is the |t| above which a bucket's mean is flagged as
+	// unlikely to be zero, roughly 95% two-sided
+	significantTStat = 1.96
+)
+
+// SeasonalityBucket summarises the daily returns that fall in one bucket.
+// Buckets with fewer Samples than the sample.Seasonality minimum leave their
+// statistics nil.
+type SeasonalityBucket struct {
+	Bucket     string   `json:"bucket"`
+	Samples    int      `json:"samples"`
+	MeanReturn *float64 `json:"mean_return"`
+	WinRate    *float64 `json:"win_rate"`
+	// TStat tests the mean return against zero. It is naive: it assumes
+	// independent returns and isn't corrected for testing many buckets.
+	TStat       *float64 `json:"t_stat"`
+	Significant bool     `json:"significant"`
+}
+
+type SeasonalityReport struct {
+	Symbol   string              `json:"symbol"`
+	Years    int                 `json:"years"`
+	From     time.Time           `json:"from"`
+	To       time.Time           `json:"to"`
+	Weekday  []SeasonalityBucket `json:"weekday"`
+	Month    []SeasonalityBucket `json:"month"`
+	MonthEnd []SeasonalityBucket `json:"month_end"`
+	// DataQuality explains the buckets left without statistics
+	DataQuality []sample.Note `json:"data_quality,omitempty"`
+	GeneratedAt time.Time     `json:"generated_at"`
+}
+
+type seasonalityKey struct {
+	symbol string
+	years  int
+}
+
+type dailyClose struct {
+	date  time.Time
+	close float64
+}
+
+// GetSeasonality computes average return and win rate by weekday, by month
+// and around month-end over the last years of daily candles, with days
+// counted in the symbol's exchange timezone. Reports are cached for a day.
+// Symbols with under a year of data return ErrInsufficientHistory.
+func (s *Service) GetSeasonality(ctx context.Context, symbol string, years int) (*SeasonalityReport, error) {
+	key := seasonalityKey{symbol, years}
+
+	s.seasonalityMu.Lock()
+	cached, ok := s.seasonality[key]
+	s.seasonalityMu.Unlock()
+	if ok && time.Since(cached.GeneratedAt) < seasonalityTTL {
+		return cached, nil
+	}
+
+	loc := time.UTC
+	trading := calendar.NYSE()
+	if s.calendars != nil {
+		var err error
+		if loc, err = s.calendars.SymbolLocation(ctx, symbol, ""); err != nil {
+			return nil, err
+		}
+		if trading, err = s.calendars.SymbolCalendar(ctx, symbol); err != nil {
+			return nil, err
+		}
+	}
+	closes, err := s.dailyCloses(ctx, symbol, time.Now().AddDate(-years, 0, 0), loc)
+	if err != nil {
+		return nil, err
+	}
+	report, err := seasonality(closes, trading, s.samples)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", symbol, err)
+	}
+	report.Symbol = symbol
+	report.Years = years
+
+	s.seasonalityMu.Lock()
+	if s.seasonality == nil {
+		s.seasonality = make(map[seasonalityKey]*SeasonalityReport)
+	}
+	s.seasonality[key] = report
+	s.seasonalityMu.Unlock()
+
+	return report, nil
+}
+
+// dailyCloses returns the last close of each calendar day in loc
+func (s *Service) dailyCloses(ctx context.Context, symbol string, since time.Time, loc *time.Location) ([]dailyClose, error) {
+	query := `
+		SELECT timestamp, close FROM market_data
+		WHERE symbol = $1 AND timestamp >= $2
+		ORDER BY timestamp
+	`
+	rows, err := s.db.QueryContext(ctx, query, symbol, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []calendar.Point
+	for rows.Next() {
+		var p calendar.Point
+		if err := rows.Scan(&p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	days := calendar.Daily(points, loc)
+	closes := make([]dailyClose, len(days))
+	for i, d := range days {
+		closes[i] = dailyClose{date: d.Time, close: d.Value}
+	}
+	return closes, nil
+}
+
+// seasonality buckets the close-to-close return of each trading day in
+// trading by the day it closed on. closes are keyed by calendar day as
+// calendar.Date returns them; closes printed on days the market was shut
+// are dropped, so a holiday's return is counted on the next trading day.
+// The turn of the month is counted in trading days, so data gaps don't
+// move a day into or out of it.
+func seasonality(closes []dailyClose, trading *calendar.TradingCalendar, policy sample.Policy) (*SeasonalityReport, error) {
+	var open []dailyClose
+	for _, c := range closes {
+		if trading.IsTradingDay(c.date) {
+			open = append(open, c)
+		}
+	}
+	closes = open
+
+	if len(closes) < 2 || closes[len(closes)-1].date.Sub(closes[0].date) < minSeasonalityHistory {
+		var span time.Duration
+		if len(closes) > 1 {
+			span = closes[len(closes)-1].date.Sub(closes[0].date)
+		}
+		return nil, fmt.Errorf("%w: %d days of data, need at least a year",
+			ErrInsufficientHistory, int(span.Hours()/24))
+	}
+
+	byWeekday := make(map[time.Weekday][]float64)
+	byMonth := make(map[time.Month][]float64)
+	byMonthEnd := make(map[string][]float64)
+
+	for i := 1; i < len(closes); i++ {
+		prev, cur := closes[i-1], closes[i]
+		if prev.close == 0 {
+			continue
+		}
+		r := (cur.close - prev.close) / prev.close
+		date := cur.date.UTC()
+
+		monthStart := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		fromStart := trading.TradingDaysBetween(monthStart.AddDate(0, 0, -1), date)
+		fromEnd := trading.TradingDaysBetween(date, monthStart.AddDate(0, 1, -1)) + 1
+		switch {
+		case fromEnd <= turnOfMonthDays:
+			byMonthEnd["last_3_days"] = append(byMonthEnd["last_3_days"], r)
+		case fromStart <= turnOfMonthDays:
+			byMonthEnd["first_3_days"] = append(byMonthEnd["first_3_days"], r)
+		default:
+			byMonthEnd["mid_month"] = append(byMonthEnd["mid_month"], r)
+		}
+
+		byWeekday[date.Weekday()] = append(byWeekday[date.Weekday()], r)
+		byMonth[date.Month()] = append(byMonth[date.Month()], r)
+	}
+
+	report := &SeasonalityReport{
+		From:        closes[0].date,
+		To:          closes[len(closes)-1].date,
+		GeneratedAt: time.Now(),
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		// Weekend buckets only appear for markets that trade on weekends
+		if returns, ok := byWeekday[d]; ok {
+			report.Weekday = append(report.Weekday, report.summarizeBucket(d.String(), returns, policy))
+		}
+	}
+	for m := time.January; m <= time.December; m++ {
+		if returns, ok := byMonth[m]; ok {
+			report.Month = append(report.Month, report.summarizeBucket(m.String(), returns, policy))
+		}
+	}
+	for _, bucket := range []string{"first_3_days", "mid_month", "last_3_days"} {
+		if returns, ok := byMonthEnd[bucket]; ok {
+			report.MonthEnd = append(report.MonthEnd, report.summarizeBucket(bucket, returns, policy))
+		}
+	}
+	return report, nil
+}
+
+// summarizeBucket notes the buckets with too few returns in the report's
+// DataQuality rather than summarizing them
+func (report *SeasonalityReport) summarizeBucket(name string, returns []float64, policy sample.Policy) SeasonalityBucket {
+	bucket := SeasonalityBucket{Bucket: name, Samples: len(returns)}
+	if note := policy.Check(sample.Seasonality, name, len(returns)); note != nil {
+		report.DataQuality = append(report.DataQuality, *note)
+		return bucket
+	}
+
+	var sum float64
+	var wins int
+	for _, r := range returns {
+		sum += r
+		if r > 0 {
+			wins++
+		}
+	}
+	n := float64(len(returns))
+	mean := sum / n
+	winRate := float64(wins) / n
+	bucket.MeanReturn, bucket.WinRate = &mean, &winRate
+
+	var tStat float64
+	bucket.TStat = &tStat
+	if len(returns) < 2 {
+		return bucket
+	}
+	var sumSq float64
+	for _, r := range returns {
+		sumSq += (r - mean) * (r - mean)
+	}
+	stdErr := math.Sqrt(sumSq/(n-1)) / math.Sqrt(n)
+	if stdErr > 0 {
+		tStat = mean / stdErr
+		bucket.Significant = math.Abs(tStat) > significantTStat
+	}
+	return bucket
+}
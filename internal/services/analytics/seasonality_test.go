@@ -0,0 +1,204 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+)
+
+// weekdayCloses returns two years of weekday closes where Mondays gain
+// about 1.1% and Tuesdays alternate between +0.5% and -0.5%
+func weekdayCloses() []dailyClose {
+	start := time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC)
+	closes := []dailyClose{{date: start, close: 100}}
+	var mondays, tuesdays int
+	for d := start.AddDate(0, 0, 1); d.Before(start.AddDate(2, 0, 0)); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+			continue
+		}
+		r := 0.0
+		switch d.Weekday() {
+		case time.Monday:
+			r = 0.010 + 0.002*float64(mondays%2)
+			mondays++
+		case time.Tuesday:
+			r = 0.005 * float64(1-2*(tuesdays%2))
+			tuesdays++
+		}
+		closes = append(closes, dailyClose{date: d, close: closes[len(closes)-1].close * (1 + r)})
+	}
+	return closes
+}
+
+func bucketByName(buckets []SeasonalityBucket, name string) SeasonalityBucket {
+	for _, b := range buckets {
+		if b.Bucket == name {
+			return b
+		}
+	}
+	return SeasonalityBucket{}
+}
+
+func TestSeasonality(t *testing.T) {
+	// The crypto calendar trades every day, so no close is dropped
+	report, err := seasonality(weekdayCloses(), calendar.Crypto(), sample.DefaultPolicy())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Len(t, report.Weekday, 5)
+	assert.Len(t, report.Month, 12)
+	assert.Len(t, report.MonthEnd, 3)
+
+	monday := bucketByName(report.Weekday, "Monday")
+	assert.InDelta(t, 0.011, *monday.MeanReturn, 1e-9)
+	assert.Equal(t, 1.0, *monday.WinRate)
+	assert.True(t, monday.Significant)
+	assert.Greater(t, monday.Samples, 100)
+
+	tuesday := bucketByName(report.Weekday, "Tuesday")
+	assert.InDelta(t, 0, *tuesday.MeanReturn, 1e-4)
+	assert.InDelta(t, 0.5, *tuesday.WinRate, 0.01)
+	assert.False(t, tuesday.Significant)
+
+	var total int
+	for _, b := range report.MonthEnd {
+		total += b.Samples
+	}
+	assert.Equal(t, len(weekdayCloses())-1, total)
+	assert.Empty(t, report.DataQuality)
+
+	t.Run("Buckets below the minimum", func(t *testing.T) {
+		// Monday exactly meets the minimum, so only the emptier buckets
+		// lose their statistics
+		policy := sample.Policy{sample.Seasonality: monday.Samples}
+		report, err := seasonality(weekdayCloses(), calendar.Crypto(), policy)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotNil(t, bucketByName(report.Weekday, "Monday").MeanReturn)
+
+		first := bucketByName(report.MonthEnd, "first_3_days")
+		assert.Nil(t, first.MeanReturn)
+		assert.Nil(t, first.WinRate)
+		assert.False(t, first.Significant)
+		assert.Contains(t, report.DataQuality, sample.Note{
+			Metric:       sample.Seasonality,
+			Symbol:       "first_3_days",
+			Observations: first.Samples,
+			Required:     monday.Samples,
+			Reason:       fmt.Sprintf("insufficient history (%d of %d required observations)", first.Samples, monday.Samples),
+		})
+
+		// One more sample than Monday has nulls it too
+		policy[sample.Seasonality]++
+		report, err = seasonality(weekdayCloses(), calendar.Crypto(), policy)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Nil(t, bucketByName(report.Weekday, "Monday").MeanReturn)
+	})
+
+	t.Run("Under a year of data", func(t *testing.T) {
+		_, err := seasonality(weekdayCloses()[:200], calendar.Crypto(), sample.DefaultPolicy())
+		assert.ErrorIs(t, err, ErrInsufficientHistory)
+
+		_, err = seasonality(nil, calendar.NYSE(), sample.DefaultPolicy())
+		assert.ErrorIs(t, err, ErrInsufficientHistory)
+	})
+
+	t.Run("Close printed on a holiday", func(t *testing.T) {
+		nyse := calendar.NYSE()
+		var open []dailyClose
+		for _, c := range weekdayCloses() {
+			if nyse.IsTradingDay(c.date) {
+				open = append(open, c)
+			}
+		}
+		expected, err := seasonality(open, nyse, sample.DefaultPolicy())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// A stale close on Good Friday, 7 April 2023, is dropped rather
+		// than counted as a flat Friday
+		goodFriday := time.Date(2023, time.April, 7, 0, 0, 0, 0, time.UTC)
+		var closes []dailyClose
+		for _, c := range open {
+			closes = append(closes, c)
+			if c.date.Equal(goodFriday.AddDate(0, 0, -1)) {
+				closes = append(closes, dailyClose{date: goodFriday, close: c.close})
+			}
+		}
+		report, err := seasonality(closes, nyse, sample.DefaultPolicy())
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, expected.Weekday, report.Weekday)
+		assert.Equal(t, expected.MonthEnd, report.MonthEnd)
+	})
+}
+
+func TestSeasonality_TurnOfMonthInTradingDays(t *testing.T) {
+	// Crypto trades on weekends, so the last three trading days of March
+	// 2024 are Friday 29 to Sunday 31; on the NYSE, which shuts for Good
+	// Friday, they are Tuesday 26 to Thursday 28
+	start := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	var closes []dailyClose
+	for d := start; !d.After(time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)); d = d.AddDate(0, 0, 1) {
+		closes = append(closes, dailyClose{date: d, close: 100 + float64(len(closes))})
+	}
+
+	crypto, err := seasonality(closes, calendar.Crypto(), sample.DefaultPolicy())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, crypto.Weekday, 7)
+	// Every month of the 13 includes its first and last three days
+	assert.Equal(t, 13*3-1, bucketByName(crypto.MonthEnd, "first_3_days").Samples)
+	assert.Equal(t, 13*3, bucketByName(crypto.MonthEnd, "last_3_days").Samples)
+
+	nyse, err := seasonality(closes, calendar.NYSE(), sample.DefaultPolicy())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, nyse.Weekday, 5)
+	assert.Equal(t, 13*3, bucketByName(nyse.MonthEnd, "last_3_days").Samples)
+}
+
+func TestGetSeasonality_Cached(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"timestamp", "close"})
+	for _, c := range weekdayCloses() {
+		rows.AddRow(c.date, c.close)
+	}
+	mock.ExpectQuery("SELECT timestamp, close FROM market_data").
+		WithArgs("SPY", sqlmock.AnyArg()).
+		WillReturnRows(rows)
+
+	service := NewService(db, nil)
+	ctx := context.Background()
+
+	report, err := service.GetSeasonality(ctx, "SPY", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "SPY", report.Symbol)
+
+	cached, err := service.GetSeasonality(ctx, "SPY", 5)
+	assert.NoError(t, err)
+	assert.Same(t, report, cached)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,15 +3,73 @@ package analytics
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 	"math"
+	"strconv"
+	"sync"
 
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shopspring/decimal"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/database"
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/repository"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
 )
 
+// BetaCalculator regresses a portfolio's daily returns on those of a
+// market symbol over its last window days
+type BetaCalculator interface {
+	CalculateBeta(ctx context.Context, portfolioID int64, marketSymbol string, window int) (float64, error)
+}
+
 type Service struct {
-	db        *sql.DB
-	aiService AIService
+	db           *sql.DB
+	aiService    AIService
+	marketSymbol string
+	beta         BetaCalculator
+	riskFree     risk.RiskFreeRateProvider
+	// samples is the minimum history of each statistic
+	samples sample.Policy
+
+	subscriptions SubscriptionService
+	webhooks      WebhookDispatcher
+
+	// regimeMu guards the cached global regime report
+	regimeMu     sync.Mutex
+	globalRegime *GlobalRegimeReport
+
+	// seasonalityMu guards the cached seasonality reports
+	seasonalityMu sync.Mutex
+	seasonality   map[seasonalityKey]*SeasonalityReport
+
+	// aggregateMu guards the cached aggregate views of each user
+	aggregateMu sync.Mutex
+	aggregates  map[uuid.UUID]*AggregateView
+
+	optimizer PortfolioOptimizer
+
+	// calendars picks the timezone a symbol's days are counted in; symbols
+	// use UTC without it
+	calendars *calendar.Resolver
+
+	// maxAnalysisAge is how long market analyses are kept before
+	// PurgeStaleAnalyses deletes them
+	maxAnalysisAge time.Duration
+	staleServed    prometheus.Counter
+
+	analyses *repository.MarketAnalysisRepository
+	// historyRetention is how long past analyses are kept, or zero to keep
+	// none; historyWrites tracks those still being recorded
+	historyRetention time.Duration
+	historyWrites    sync.WaitGroup
 }
 
 type AIService interface {
@@ -19,48 +77,141 @@ type AIService interface {
 	AnalyzeMarketSentiment(ctx context.Context, symbol string) (*models.MarketAnalysis, error)
 }
 
+// RiskMetrics leaves the metrics measured from less history than the
+// Service's sample.Policy asks for nil, with a note in the analytics'
+// DataQuality
 type RiskMetrics struct {
-	Volatility   float64 `json:"volatility"`
-	SharpeRatio  float64 `json:"sharpe_ratio"`
-	SortinoRatio float64 `json:"sortino_ratio"`
-	MaxDrawdown  float64 `json:"max_drawdown"`
-	VaR          float64 `json:"var"` // Value at Risk
+	Volatility   *float64 `json:"volatility"`
+	SharpeRatio  *float64 `json:"sharpe_ratio"`
+	SortinoRatio *float64 `json:"sortino_ratio"`
+	MaxDrawdown  float64  `json:"max_drawdown"`
+	VaR          *float64 `json:"var"` // Value at Risk
+	// ExpectedShortfall is the mean loss beyond VaR, positive like VaR
+	ExpectedShortfall *float64 `json:"expected_shortfall"`
+	// SkippedPoints counts the NaN or infinite returns left out of VaR and
+	// expected shortfall
+	SkippedPoints int `json:"skipped_points"`
 }
 
 type AdvancedAnalytics struct {
-	CorrelationMatrix map[string]map[string]float64 `json:"correlation_matrix"`
-	RiskMetrics       map[string]RiskMetrics        `json:"risk_metrics"`
-	PortfolioMetrics  PortfolioMetrics             `json:"portfolio_metrics"`
+	CorrelationMatrix map[string]map[string]*float64 `json:"correlation_matrix"`
+	RiskMetrics       map[string]RiskMetrics         `json:"risk_metrics"`
+	PortfolioMetrics  PortfolioMetrics               `json:"portfolio_metrics"`
+	// RiskFreeRate is the annual rate the Sharpe and Sortino ratios and
+	// the risk adjusted return were measured against
+	RiskFreeRate float64 `json:"risk_free_rate"`
+	// SkippedPoints is the total of the assets' SkippedPoints
+	SkippedPoints int `json:"skipped_points"`
+	// DataQuality explains the correlations, risk metrics and returns left
+	// null for too little history
+	DataQuality []sample.Note `json:"data_quality,omitempty"`
 }
 
+// PortfolioMetrics omits the returns longer than the timeframe asked for,
+// along with RiskAdjusted, which is only computed with the yearly return.
+// Returns the portfolio's snapshots don't go back far enough for are nil.
 type PortfolioMetrics struct {
-	TotalValue     float64   `json:"total_value"`
-	DailyReturn    float64   `json:"daily_return"`
-	WeeklyReturn   float64   `json:"weekly_return"`
-	MonthlyReturn  float64   `json:"monthly_return"`
-	YearlyReturn   float64   `json:"yearly_return"`
-	RiskAdjusted   float64   `json:"risk_adjusted"`
+	TotalValue     decimal.Decimal `json:"total_value"`
+	DailyReturn    *float64  `json:"daily_return"`
+	WeeklyReturn   *float64  `json:"weekly_return,omitempty"`
+	MonthlyReturn  *float64  `json:"monthly_return,omitempty"`
+	YearlyReturn   *float64  `json:"yearly_return,omitempty"`
+	RiskAdjusted   *float64  `json:"risk_adjusted,omitempty"`
 	Diversification float64   `json:"diversification"`
+	Beta           float64   `json:"beta"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 func NewService(db *sql.DB, aiService AIService) *Service {
 	return &Service{
-		db:        db,
-		aiService: aiService,
+		db:             db,
+		aiService:      aiService,
+		marketSymbol:   portfolio.DefaultMarketSymbol,
+		beta:           portfolio.NewPortfolioAnalyzer(db),
+		riskFree:       risk.StaticRiskFreeRate(risk.DefaultRiskFreeRate),
+		samples:        sample.DefaultPolicy(),
+		maxAnalysisAge: defaultMaxAnalysisAge,
+		analyses:       repository.NewMarketAnalysisRepository(database.New(db)),
+		staleServed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stale_analysis_served_total",
+			Help: "Number of market analyses served past their TTL because a fresh one couldn't be generated",
+		}),
+	}
+}
+
+// WithRiskFreeRate measures Sharpe and Sortino ratios against the rate
+// riskFree provides
+func (s *Service) WithRiskFreeRate(riskFree risk.RiskFreeRateProvider) *Service {
+	s.riskFree = riskFree
+	return s
+}
+
+// WithSamplePolicy sets the minimum history of correlations, risk metrics
+// and seasonality buckets
+func (s *Service) WithSamplePolicy(policy sample.Policy) *Service {
+	s.samples = policy
+	return s
+}
+
+// WithMarketSymbol sets the market proxy used for beta calculations
+func (s *Service) WithMarketSymbol(symbol string) *Service {
+	if symbol != "" {
+		s.marketSymbol = symbol
+	}
+	return s
+}
+
+// WithBetaCalculator sets what portfolio betas are calculated with, in
+// place of a PortfolioAnalyzer with default valuers
+func (s *Service) WithBetaCalculator(beta BetaCalculator) *Service {
+	s.beta = beta
+	return s
+}
+
+// InvalidateCaches drops the cached regime, seasonality and aggregate
+// reports so the next request recomputes them
+func (s *Service) InvalidateCaches() {
+	s.regimeMu.Lock()
+	// Keep the report, expired, so a regime change is still detected
+	if s.globalRegime != nil {
+		expired := *s.globalRegime
+		expired.GeneratedAt = time.Time{}
+		s.globalRegime = &expired
 	}
+	s.regimeMu.Unlock()
+
+	s.seasonalityMu.Lock()
+	s.seasonality = nil
+	s.seasonalityMu.Unlock()
+
+	s.aggregateMu.Lock()
+	s.aggregates = nil
+	s.aggregateMu.Unlock()
 }
 
+// ErrNoAIService is returned for a market analysis that has to be generated
+// by a Service created without an AI service
+var ErrNoAIService = errors.New("no AI service configured")
+
 func (s *Service) GetMarketAnalysis(ctx context.Context, symbol string) (*models.MarketAnalysis, error) {
 	// First, try to get recent analysis from cache/db
-	analysis, err := s.getStoredAnalysis(ctx, symbol)
-	if err == nil && analysis.UpdatedAt.Add(15*time.Minute).After(time.Now()) {
-		return analysis, nil
+	stored, err := s.getStoredAnalysis(ctx, symbol)
+	if err == nil && stored.FreshUntil().After(time.Now()) {
+		return stored, nil
+	}
+	if err != nil {
+		stored = nil
 	}
 
 	// Generate new analysis using AI service
-	analysis, err = s.aiService.AnalyzeMarketSentiment(ctx, symbol)
+	analysis, err := s.analyzeMarket(ctx, symbol)
 	if err != nil {
+		// Serve the stale analysis rather than fail; PurgeStaleAnalyses
+		// bounds how old it can be
+		if stored != nil {
+			s.staleServed.Inc()
+			return stored, nil
+		}
 		return nil, err
 	}
 
@@ -72,10 +223,36 @@ func (s *Service) GetMarketAnalysis(ctx context.Context, symbol string) (*models
 	return analysis, nil
 }
 
+func (s *Service) analyzeMarket(ctx context.Context, symbol string) (*models.MarketAnalysis, error) {
+	if s.aiService == nil {
+		return nil, ErrNoAIService
+	}
+	return s.aiService.AnalyzeMarketSentiment(ctx, symbol)
+}
+
 func (s *Service) GetAdvancedAnalytics(ctx context.Context, portfolioID string) (*AdvancedAnalytics, error) {
+	return s.GetTimeframeAnalytics(ctx, portfolioID, "")
+}
+
+// GetTimeframeAnalytics computes a portfolio's analytics over timeframe,
+// one of models.Timeframes, or over the full history when it is empty.
+// Only the returns within timeframe are queried, and correlations and risk
+// are computed over correspondingly shorter lookbacks.
+func (s *Service) GetTimeframeAnalytics(ctx context.Context, portfolioID, timeframe string) (*AdvancedAnalytics, error) {
+	scope, err := newAnalyticsScope(timeframe, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	riskFreeRate, err := s.riskFree.AnnualRiskFreeRate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+	}
+
+	defer monitoring.StartStage(ctx, monitoring.StageAnalytics)()
 	metrics := &AdvancedAnalytics{
-		CorrelationMatrix: make(map[string]map[string]float64),
+		CorrelationMatrix: make(map[string]map[string]*float64),
 		RiskMetrics:       make(map[string]RiskMetrics),
+		RiskFreeRate:      riskFreeRate,
 	}
 
 	// Get portfolio assets
@@ -86,25 +263,27 @@ func (s *Service) GetAdvancedAnalytics(ctx context.Context, portfolioID string)
 
 	// Calculate correlation matrix
 	for _, asset1 := range assets {
-		metrics.CorrelationMatrix[asset1.Symbol] = make(map[string]float64)
+		metrics.CorrelationMatrix[asset1.Symbol] = make(map[string]*float64)
 		for _, asset2 := range assets {
-			correlation, err := s.calculateCorrelation(ctx, asset1.Symbol, asset2.Symbol)
+			correlation, observations, err := s.calculateCorrelation(ctx, asset1.Symbol, asset2.Symbol, scope.correlationSince)
 			if err != nil {
 				return nil, err
 			}
-			metrics.CorrelationMatrix[asset1.Symbol][asset2.Symbol] = correlation
+			metrics.CorrelationMatrix[asset1.Symbol][asset2.Symbol] = s.samples.Guard(&metrics.DataQuality,
+				sample.Correlation, asset1.Symbol+"/"+asset2.Symbol, observations, correlation)
 		}
 
 		// Calculate risk metrics for each asset
-		riskMetrics, err := s.calculateRiskMetrics(ctx, asset1.Symbol)
+		riskMetrics, err := s.calculateRiskMetrics(ctx, asset1.Symbol, scope.riskSince, riskFreeRate, &metrics.DataQuality)
 		if err != nil {
 			return nil, err
 		}
 		metrics.RiskMetrics[asset1.Symbol] = riskMetrics
+		metrics.SkippedPoints += riskMetrics.SkippedPoints
 	}
 
 	// Calculate portfolio-level metrics
-	portfolioMetrics, err := s.calculatePortfolioMetrics(ctx, portfolioID, assets)
+	portfolioMetrics, err := s.calculatePortfolioMetrics(ctx, portfolioID, assets, scope, riskFreeRate, &metrics.DataQuality)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +292,42 @@ func (s *Service) GetAdvancedAnalytics(ctx context.Context, portfolioID string)
 	return metrics, nil
 }
 
-func (s *Service) calculateCorrelation(ctx context.Context, symbol1, symbol2 string) (float64, error) {
+// getPortfolioAssets reads a portfolio's assets from the table its kind of
+// portfolio keeps them in, so paper trading forks are analyzed on their own
+// positions
+func (s *Service) getPortfolioAssets(ctx context.Context, portfolioID string) ([]models.Asset, error) {
+	var paperTrading bool
+	err := s.db.QueryRowContext(ctx, "SELECT paper_trading FROM portfolios WHERE id = $1", portfolioID).Scan(&paperTrading)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT symbol, type, quantity, avg_price, value, last_update
+		FROM ` + models.PositionsTable(paperTrading) + `
+		WHERE portfolio_id = $1
+		ORDER BY symbol
+	`
+	rows, err := s.db.QueryContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []models.Asset
+	for rows.Next() {
+		var asset models.Asset
+		if err := rows.Scan(&asset.Symbol, &asset.Type, &asset.Quantity, &asset.AvgPrice, &asset.Value, &asset.LastUpdate); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// calculateCorrelation returns the correlation of the symbols' daily returns
+// and how many days both have a return on
+func (s *Service) calculateCorrelation(ctx context.Context, symbol1, symbol2 string, since time.Time) (float64, int, error) {
 	query := `
 		WITH daily_returns AS (
 			SELECT 
@@ -123,30 +337,44 @@ func (s *Service) calculateCorrelation(ctx context.Context, symbol1, symbol2 str
 			FROM asset_prices
 			WHERE symbol IN ($1, $2)
 			AND date >= $3
+			AND price > 0
 		)
-		SELECT CORR(r1.return, r2.return) as correlation
+		SELECT
+			CORR(r1.return, r2.return) as correlation,
+			REGR_COUNT(r1.return, r2.return) as observations
 		FROM daily_returns r1
 		JOIN daily_returns r2 ON r1.date = r2.date AND r1.symbol < r2.symbol
 		WHERE r1.symbol = $1 AND r2.symbol = $2
 	`
 
-	var correlation float64
+	var correlation sql.NullFloat64
+	var observations int
 	err := s.db.QueryRowContext(
 		ctx,
 		query,
 		symbol1,
 		symbol2,
-		time.Now().AddDate(0, -6, 0),
-	).Scan(&correlation)
+		since,
+	).Scan(&correlation, &observations)
 
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return correlation, nil
+	return finiteOrZero(correlation.Float64), observations, nil
 }
 
-func (s *Service) calculateRiskMetrics(ctx context.Context, symbol string) (RiskMetrics, error) {
+// calculateRiskMetrics measures the Sharpe and Sortino ratios against the
+// annual riskFreeRate, converted to the symbol's daily rate. The metrics
+// without enough returns behind them are left nil, with a note added to
+// notes.
+func (s *Service) calculateRiskMetrics(ctx context.Context, symbol string, since time.Time, riskFreeRate float64, notes *[]sample.Note) (RiskMetrics, error) {
+	factor, err := s.annualizationFactor(ctx, symbol)
+	if err != nil {
+		return RiskMetrics{}, err
+	}
+	rf := risk.PeriodRate(riskFreeRate, factor)
+
 	query := `
 		WITH daily_returns AS (
 			SELECT 
@@ -155,99 +383,212 @@ func (s *Service) calculateRiskMetrics(ctx context.Context, symbol string) (Risk
 			FROM asset_prices
 			WHERE symbol = $1
 			AND date >= $2
+			AND price > 0
 			ORDER BY date
 		)
 		SELECT 
-			STDDEV(return) * SQRT(252) as volatility,
-			AVG(return) / STDDEV(return) * SQRT(252) as sharpe_ratio,
-			MIN(return) as max_drawdown
+			STDDEV(return) * SQRT($3) as volatility,
+			(AVG(return) - $4) / STDDEV(return) * SQRT($3) as sharpe_ratio,
+			MIN(return) as max_drawdown,
+			COUNT(return) as observations
 		FROM daily_returns
 	`
 
-	var metrics RiskMetrics
-	err := s.db.QueryRowContext(
+	var volatility, sharpeRatio, maxDrawdown sql.NullFloat64
+	var observations int
+	err = s.db.QueryRowContext(
 		ctx,
 		query,
 		symbol,
-		time.Now().AddDate(-1, 0, 0),
+		since,
+		factor,
+		rf,
 	).Scan(
-		&metrics.Volatility,
-		&metrics.SharpeRatio,
-		&metrics.MaxDrawdown,
+		&volatility,
+		&sharpeRatio,
+		&maxDrawdown,
+		&observations,
 	)
 
 	if err != nil {
 		return RiskMetrics{}, err
 	}
-
-	// Calculate Value at Risk (VaR) using historical simulation
-	metrics.VaR = s.calculateVaR(ctx, symbol)
+	var metrics RiskMetrics
+	metrics.Volatility = s.samples.Guard(notes, sample.Volatility, symbol, observations, finiteOrZero(volatility.Float64))
+	metrics.SharpeRatio = s.samples.Guard(notes, sample.SharpeRatio, symbol, observations, finiteOrZero(sharpeRatio.Float64))
+	metrics.MaxDrawdown = finiteOrZero(maxDrawdown.Float64)
+
+	// Calculate Value at Risk (VaR) and expected shortfall using historical simulation
+	valueAtRisk, expectedShortfall, tailObservations, skipped := s.calculateTailRisk(ctx, symbol, since)
+	metrics.SkippedPoints = skipped
+	if note := s.samples.Check(sample.VaR, symbol, tailObservations); note != nil {
+		*notes = append(*notes, *note)
+	} else {
+		metrics.VaR, metrics.ExpectedShortfall = &valueAtRisk, &expectedShortfall
+	}
 	
 	// Calculate Sortino Ratio (similar to Sharpe but only considering negative returns)
-	metrics.SortinoRatio = s.calculateSortinoRatio(ctx, symbol)
+	sortinoRatio, sortinoObservations := s.calculateSortinoRatio(ctx, symbol, factor, rf, since)
+	metrics.SortinoRatio = s.samples.Guard(notes, sample.SortinoRatio, symbol, sortinoObservations, sortinoRatio)
 
 	return metrics, nil
 }
 
-func (s *Service) calculatePortfolioMetrics(ctx context.Context, portfolioID string, assets []models.Asset) (PortfolioMetrics, error) {
+// calculatePortfolioMetrics leaves the returns the portfolio's snapshots
+// don't go back far enough for nil, with a note in notes
+func (s *Service) calculatePortfolioMetrics(ctx context.Context, portfolioID string, assets []models.Asset, scope analyticsScope, riskFreeRate float64, notes *[]sample.Note) (PortfolioMetrics, error) {
 	var metrics PortfolioMetrics
+	var err error
 
 	// Calculate total portfolio value
 	for _, asset := range assets {
-		metrics.TotalValue += asset.Value
+		metrics.TotalValue = metrics.TotalValue.Add(asset.Value)
 	}
 
-	// Calculate returns for different timeframes
-	metrics.DailyReturn = s.calculateReturn(ctx, portfolioID, time.Hour*24)
-	metrics.WeeklyReturn = s.calculateReturn(ctx, portfolioID, time.Hour*24*7)
-	metrics.MonthlyReturn = s.calculateReturn(ctx, portfolioID, time.Hour*24*30)
-	metrics.YearlyReturn = s.calculateReturn(ctx, portfolioID, time.Hour*24*365)
+	// Calculate returns for the timeframes in scope; the daily return is
+	// the shortest tracked, so it is reported for every timeframe
+	if metrics.DailyReturn, err = s.calculateReturn(ctx, portfolioID, 24*time.Hour, sample.DailyReturn, notes); err != nil {
+		return metrics, err
+	}
+	if metrics.WeeklyReturn, err = s.scopedReturn(ctx, portfolioID, weeklySpan, sample.WeeklyReturn, scope, notes); err != nil {
+		return metrics, err
+	}
+	if metrics.MonthlyReturn, err = s.scopedReturn(ctx, portfolioID, monthlySpan, sample.MonthlyReturn, scope, notes); err != nil {
+		return metrics, err
+	}
+	if metrics.YearlyReturn, err = s.scopedReturn(ctx, portfolioID, yearlySpan, sample.YearlyReturn, scope, notes); err != nil {
+		return metrics, err
+	}
 
-	// Calculate risk-adjusted return (Sharpe Ratio)
-	riskFreeRate := 0.02 // 2% annual risk-free rate
-	portfolioReturn := metrics.YearlyReturn
-	portfolioVolatility := s.calculatePortfolioVolatility(ctx, portfolioID)
-	metrics.RiskAdjusted = (portfolioReturn - riskFreeRate) / portfolioVolatility
+	// Calculate risk-adjusted return (Sharpe Ratio), annual like the
+	// risk-free rate and portfolio volatility
+	if metrics.YearlyReturn != nil {
+		portfolioVolatility := s.calculatePortfolioVolatility(ctx, assets)
+		if portfolioVolatility > 0 {
+			riskAdjusted := (*metrics.YearlyReturn - riskFreeRate) / portfolioVolatility
+			metrics.RiskAdjusted = &riskAdjusted
+		}
+	}
 
 	// Calculate portfolio diversification score
 	metrics.Diversification = s.calculateDiversificationScore(assets)
 
+	// Calculate market beta
+	beta, err := s.calculateBeta(ctx, portfolioID, scope.riskSince)
+	if err != nil {
+		return metrics, err
+	}
+	metrics.Beta = beta
+
 	metrics.UpdatedAt = time.Now()
 
 	return metrics, nil
 }
 
+// scopedReturn returns the portfolio's return over span, or nil when span
+// is longer than the scope's timeframe
+func (s *Service) scopedReturn(ctx context.Context, portfolioID string, span time.Duration, metric sample.Metric, scope analyticsScope, notes *[]sample.Note) (*float64, error) {
+	if !scope.includes(span) {
+		return nil, nil
+	}
+	return s.calculateReturn(ctx, portfolioID, span, metric, notes)
+}
+
+// calculateReturn returns the portfolio's return from its last snapshot at
+// least span before its latest one. Without such a snapshot the return is
+// nil, with a note in notes of how many days of snapshots there are.
+func (s *Service) calculateReturn(ctx context.Context, portfolioID string, span time.Duration, metric sample.Metric, notes *[]sample.Note) (*float64, error) {
+	query := `
+		WITH latest AS (
+			SELECT snapshot_date, total_value
+			FROM portfolio_snapshots
+			WHERE portfolio_id = $1
+			ORDER BY snapshot_date DESC
+			LIMIT 1
+		)
+		SELECT
+			l.total_value,
+			(
+				SELECT s.total_value
+				FROM portfolio_snapshots s
+				WHERE s.portfolio_id = $1
+				AND s.snapshot_date <= l.snapshot_date - $2::int
+				ORDER BY s.snapshot_date DESC
+				LIMIT 1
+			),
+			l.snapshot_date - (SELECT MIN(snapshot_date) FROM portfolio_snapshots WHERE portfolio_id = $1) as history_days
+		FROM latest l
+	`
+
+	days := int(span / (24 * time.Hour))
+	var end, start sql.NullFloat64
+	var historyDays int
+	err := s.db.QueryRowContext(ctx, query, portfolioID, days).Scan(&end, &start, &historyDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		*notes = append(*notes, sample.Insufficient(metric, portfolioID, 0, days))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s of portfolio %s: %w", metric, portfolioID, err)
+	}
+	if !start.Valid {
+		*notes = append(*notes, sample.Insufficient(metric, portfolioID, historyDays, days))
+		return nil, nil
+	}
+	// A portfolio that started out worthless has no return to speak of
+	if !end.Valid || start.Float64 <= 0 {
+		return nil, nil
+	}
+
+	ret := end.Float64/start.Float64 - 1
+	return &ret, nil
+}
+
 func (s *Service) calculateDiversificationScore(assets []models.Asset) float64 {
-	if len(assets) == 0 {
+	hhi := herfindahl(assets)
+	if hhi == 0 {
 		return 0
 	}
 
-	// Calculate Herfindahl-Hirschman Index (HHI)
-	var sumSquares float64
-	totalValue := 0.0
+	// Convert HHI to diversification score (1 - HHI)
+	// This gives a score between 0 (completely concentrated) and 1 (perfectly diversified)
+	return 1 - hhi
+}
 
+// herfindahl returns the Herfindahl-Hirschman Index of the assets' value
+// weights, or 0 when they hold no value
+func herfindahl(assets []models.Asset) float64 {
+	totalValue := decimal.Zero
 	for _, asset := range assets {
-		totalValue += asset.Value
+		totalValue = totalValue.Add(asset.Value)
+	}
+	if totalValue.IsZero() {
+		return 0
 	}
 
+	var sumSquares float64
 	for _, asset := range assets {
-		weight := asset.Value / totalValue
+		weight := asset.Value.Div(totalValue).InexactFloat64()
 		sumSquares += weight * weight
 	}
-
-	// Convert HHI to diversification score (1 - HHI)
-	// This gives a score between 0 (completely concentrated) and 1 (perfectly diversified)
-	return 1 - sumSquares
+	return sumSquares
 }
 
-func (s *Service) calculateVaR(ctx context.Context, symbol string) float64 {
+// calculateTailRisk returns the 95% VaR and expected shortfall of a symbol's
+// daily returns, as positive losses, how many returns they were measured
+// from and how many it left out for not being finite
+func (s *Service) calculateTailRisk(ctx context.Context, symbol string, since time.Time) (float64, float64, int, int) {
 	// Fetch historical returns
 	query := `
-		SELECT 
-			(price - LAG(price) OVER (ORDER BY date)) / LAG(price) OVER (ORDER BY date) as return
-		FROM asset_prices
-		WHERE symbol = $1
-		AND date >= $2
+		SELECT return FROM (
+			SELECT 
+				(price - LAG(price) OVER (ORDER BY date)) / LAG(price) OVER (ORDER BY date) as return
+			FROM asset_prices
+			WHERE symbol = $1
+			AND date >= $2
+			AND price > 0
+		) daily_returns
+		WHERE return IS NOT NULL
 		ORDER BY return
 	`
 
@@ -255,10 +596,10 @@ func (s *Service) calculateVaR(ctx context.Context, symbol string) float64 {
 		ctx,
 		query,
 		symbol,
-		time.Now().AddDate(-1, 0, 0),
+		since,
 	)
 	if err != nil {
-		return 0
+		return 0, 0, 0, 0
 	}
 	defer rows.Close()
 
@@ -266,22 +607,20 @@ func (s *Service) calculateVaR(ctx context.Context, symbol string) float64 {
 	for rows.Next() {
 		var ret float64
 		if err := rows.Scan(&ret); err != nil {
-			return 0
+			return 0, 0, 0, 0
 		}
 		returns = append(returns, ret)
 	}
+	returns, skipped := risk.FiniteReturns(returns)
 
-	// Calculate 95% VaR
-	if len(returns) == 0 {
-		return 0
-	}
-
-	// Sort returns (should already be sorted from query)
-	percentileIndex := int(float64(len(returns)) * 0.05)
-	return -returns[percentileIndex] // Convert to positive number for reporting
+	tail := risk.HistoricalTail(returns, 0.95)
+	return -tail.VaR, -tail.ExpectedShortfall, len(returns), skipped // Convert to positive numbers for reporting
 }
 
-func (s *Service) calculateSortinoRatio(ctx context.Context, symbol string) float64 {
+// calculateSortinoRatio annualizes over factor trading days a year, with
+// rf the risk-free rate per day, and returns how many returns it was
+// measured from
+func (s *Service) calculateSortinoRatio(ctx context.Context, symbol string, factor, rf float64, since time.Time) (float64, int) {
 	query := `
 		WITH daily_returns AS (
 			SELECT 
@@ -289,25 +628,59 @@ func (s *Service) calculateSortinoRatio(ctx context.Context, symbol string) floa
 			FROM asset_prices
 			WHERE symbol = $1
 			AND date >= $2
+			AND price > 0
 		)
 		SELECT 
 			AVG(return) as avg_return,
-			STDDEV(CASE WHEN return < 0 THEN return ELSE 0 END) as downside_deviation
+			STDDEV(CASE WHEN return < 0 THEN return ELSE 0 END) as downside_deviation,
+			COUNT(return) as observations
 		FROM daily_returns
 	`
 
-	var avgReturn, downsideDeviation float64
+	var avgReturn, downsideDeviation sql.NullFloat64
+	var observations int
 	err := s.db.QueryRowContext(
 		ctx,
 		query,
 		symbol,
-		time.Now().AddDate(-1, 0, 0),
-	).Scan(&avgReturn, &downsideDeviation)
+		since,
+	).Scan(&avgReturn, &downsideDeviation, &observations)
 
-	if err != nil || downsideDeviation == 0 {
-		return 0
+	if err != nil {
+		return 0, 0
 	}
+	if downsideDeviation.Float64 == 0 {
+		return 0, observations
+	}
+
+	return finiteOrZero((avgReturn.Float64 - rf) / downsideDeviation.Float64 * math.Sqrt(factor)), observations
+}
+
+// calculateBeta is the portfolio's beta to the market symbol over the
+// days since since. A portfolio without enough snapshots to regress has a
+// beta of zero.
+func (s *Service) calculateBeta(ctx context.Context, portfolioID string, since time.Time) (float64, error) {
+	id, err := strconv.ParseInt(portfolioID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid portfolio ID %q: %w", portfolioID, err)
+	}
+
+	window := int(time.Since(since) / (24 * time.Hour))
+	beta, err := s.beta.CalculateBeta(ctx, id, s.marketSymbol, window)
+	if errors.Is(err, portfolio.ErrInsufficientData) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate beta of portfolio %s: %w", portfolioID, err)
+	}
+	return finiteOrZero(beta), nil
+}
 
-	riskFreeRate := 0.02 / 252 // Daily risk-free rate (2% annual)
-	return (avgReturn - riskFreeRate) / downsideDeviation * math.Sqrt(252)
-}
\ No newline at end of file
+// finiteOrZero is v, or zero when v is NaN or infinite. Metrics are encoded
+// as JSON, which has neither.
+func finiteOrZero(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,290 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+)
+
+// fixedBetas calculates the beta of each portfolio from a map
+type fixedBetas map[int64]float64
+
+func (b fixedBetas) CalculateBeta(ctx context.Context, portfolioID int64, marketSymbol string, window int) (float64, error) {
+	beta, ok := b[portfolioID]
+	if !ok {
+		return 0, portfolio.ErrInsufficientData
+	}
+	return beta, nil
+}
+
+// expectReturns expects the return of portfolioID over each number of days,
+// from snapshots going back a year and a half
+func expectReturns(mock sqlmock.Sqlmock, portfolioID string, days ...int) {
+	for _, d := range days {
+		mock.ExpectQuery("FROM portfolio_snapshots").
+			WithArgs(portfolioID, d).
+			WillReturnRows(sqlmock.NewRows([]string{"end", "start", "history_days"}).AddRow(11000.0, 10000.0, 540))
+	}
+}
+
+func TestGetAdvancedAnalytics_BadTicks(t *testing.T) {
+	const portfolioID = "42"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	// AAPL has a candle that closed at zero. Every return query leaves it
+	// out, and whatever non-finite values still come back, as they would
+	// from a NaN price, are dropped or zeroed rather than poisoning the
+	// response.
+	now := time.Now()
+	correlationSince, riskSince := now.AddDate(0, -6, 0), now.AddDate(-1, 0, 0)
+	mock.ExpectQuery("SELECT paper_trading FROM portfolios").
+		WithArgs(portfolioID).
+		WillReturnRows(sqlmock.NewRows([]string{"paper_trading"}).AddRow(false))
+	mock.ExpectQuery("SELECT symbol, type, quantity, avg_price, value, last_update").
+		WithArgs(portfolioID).
+		WillReturnRows(sqlmock.NewRows([]string{"symbol", "type", "quantity", "avg_price", "value", "last_update"}).
+			AddRow("AAPL", "stock", "10", "150", "2000", now))
+	mock.ExpectQuery("AND price > 0(.|\n)*SELECT CORR").
+		WithArgs("AAPL", "AAPL", sinceArg{correlationSince}).
+		WillReturnRows(sqlmock.NewRows([]string{"correlation", "observations"}).AddRow(math.NaN(), 4))
+	mock.ExpectQuery("AND price > 0(.|\n)*STDDEV\\(return\\) \\* SQRT\\(\\$3\\) as volatility").
+		WithArgs("AAPL", sinceArg{riskSince}, 252.0, risk.DailyRate(risk.DefaultRiskFreeRate)).
+		WillReturnRows(sqlmock.NewRows([]string{"volatility", "sharpe_ratio", "max_drawdown", "observations"}).
+			AddRow(math.Inf(1), math.NaN(), math.Inf(-1), 4))
+	mock.ExpectQuery("AND price > 0(.|\n)*WHERE return IS NOT NULL(.|\n)*ORDER BY return").
+		WithArgs("AAPL", sinceArg{riskSince}).
+		WillReturnRows(sqlmock.NewRows([]string{"return"}).
+			AddRow(math.Inf(-1)).AddRow(-0.03).AddRow(0.01).AddRow(math.NaN()).AddRow(math.Inf(1)))
+	mock.ExpectQuery("AND price > 0(.|\n)*downside_deviation").
+		WithArgs("AAPL", sinceArg{riskSince}).
+		WillReturnRows(sqlmock.NewRows([]string{"avg_return", "downside_deviation", "observations"}).AddRow(math.Inf(1), 0.01, 4))
+	expectReturns(mock, portfolioID, 1, 7, 30, 365)
+	mock.ExpectQuery("AND price > 0(.|\n)*SELECT COALESCE\\(STDDEV\\(return\\), 0\\) \\* SQRT\\(\\$3\\)").
+		WithArgs("AAPL", sinceArg{riskSince}, 252.0).
+		WillReturnRows(sqlmock.NewRows([]string{"volatility"}).AddRow(math.NaN()))
+
+	// No minimum history, so the few returns are still measured
+	analytics, err := NewService(db, nil).WithSamplePolicy(nil).
+		WithBetaCalculator(fixedBetas{42: math.Inf(1)}).
+		GetAdvancedAnalytics(context.Background(), portfolioID)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	aapl := analytics.RiskMetrics["AAPL"]
+	assert.Equal(t, 3, aapl.SkippedPoints)
+	assert.Equal(t, 3, analytics.SkippedPoints)
+	// VaR and expected shortfall come from the two finite returns
+	require.NotNil(t, aapl.VaR)
+	assert.Equal(t, 0.03, *aapl.VaR)
+	assert.Equal(t, 0.03, *aapl.ExpectedShortfall)
+	for name, v := range map[string]*float64{
+		"correlation":   analytics.CorrelationMatrix["AAPL"]["AAPL"],
+		"volatility":    aapl.Volatility,
+		"sharpe ratio":  aapl.SharpeRatio,
+		"sortino ratio": aapl.SortinoRatio,
+		"max drawdown":  &aapl.MaxDrawdown,
+		"beta":          &analytics.PortfolioMetrics.Beta,
+	} {
+		if assert.NotNil(t, v, name) {
+			assert.False(t, math.IsNaN(*v) || math.IsInf(*v, 0), name)
+		}
+	}
+	assert.Empty(t, analytics.DataQuality)
+	// No risk adjusted return without a portfolio volatility
+	assert.Nil(t, analytics.PortfolioMetrics.RiskAdjusted)
+
+	_, err = json.Marshal(analytics)
+	assert.NoError(t, err)
+}
+
+func TestGetAdvancedAnalytics_InsufficientHistory(t *testing.T) {
+	const portfolioID = "42"
+
+	// analyze returns the analytics of AAPL with observations daily returns
+	// behind each metric, each of them 60 returns required
+	analyze := func(observations int) *AdvancedAnalytics {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create mock DB: %v", err)
+		}
+		defer db.Close()
+
+		returns := sqlmock.NewRows([]string{"return"})
+		for i := 0; i < observations; i++ {
+			returns.AddRow(-0.02 + 0.001*float64(i))
+		}
+		mock.ExpectQuery("SELECT paper_trading FROM portfolios").
+			WithArgs(portfolioID).
+			WillReturnRows(sqlmock.NewRows([]string{"paper_trading"}).AddRow(false))
+		mock.ExpectQuery("SELECT symbol, type, quantity, avg_price, value, last_update").
+			WithArgs(portfolioID).
+			WillReturnRows(sqlmock.NewRows([]string{"symbol", "type", "quantity", "avg_price", "value", "last_update"}).
+				AddRow("AAPL", "stock", "10", "150", "2000", time.Now()))
+		mock.ExpectQuery("SELECT CORR").
+			WillReturnRows(sqlmock.NewRows([]string{"correlation", "observations"}).AddRow(1.0, observations))
+		mock.ExpectQuery("STDDEV\\(return\\) \\* SQRT\\(\\$3\\) as volatility").
+			WillReturnRows(sqlmock.NewRows([]string{"volatility", "sharpe_ratio", "max_drawdown", "observations"}).
+				AddRow(0.2, 1.1, -0.05, observations))
+		mock.ExpectQuery("ORDER BY return").
+			WillReturnRows(returns)
+		mock.ExpectQuery("downside_deviation").
+			WillReturnRows(sqlmock.NewRows([]string{"avg_return", "downside_deviation", "observations"}).
+				AddRow(0.001, 0.01, observations))
+		expectReturns(mock, portfolioID, 1, 7, 30, 365)
+		mock.ExpectQuery("SELECT COALESCE\\(STDDEV\\(return\\), 0\\) \\* SQRT\\(\\$3\\)").
+			WillReturnRows(sqlmock.NewRows([]string{"volatility"}).AddRow(0.2))
+
+		policy := sample.Policy{
+			sample.Volatility:   60,
+			sample.SharpeRatio:  60,
+			sample.SortinoRatio: 60,
+			sample.VaR:          60,
+			sample.Correlation:  60,
+		}
+		analytics, err := NewService(db, nil).WithSamplePolicy(policy).WithBetaCalculator(fixedBetas{42: 0.9}).
+			GetAdvancedAnalytics(context.Background(), portfolioID)
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return analytics
+	}
+
+	t.Run("Below the minimum", func(t *testing.T) {
+		analytics := analyze(12)
+
+		aapl := analytics.RiskMetrics["AAPL"]
+		assert.Nil(t, analytics.CorrelationMatrix["AAPL"]["AAPL"])
+		assert.Nil(t, aapl.Volatility)
+		assert.Nil(t, aapl.SharpeRatio)
+		assert.Nil(t, aapl.SortinoRatio)
+		assert.Nil(t, aapl.VaR)
+		assert.Nil(t, aapl.ExpectedShortfall)
+		// Drawdown isn't a statistic of the sample, so is still reported
+		assert.Equal(t, -0.05, aapl.MaxDrawdown)
+
+		if !assert.Len(t, analytics.DataQuality, 5) {
+			return
+		}
+		assert.Equal(t, sample.Note{
+			Metric:       sample.SharpeRatio,
+			Symbol:       "AAPL",
+			Observations: 12,
+			Required:     60,
+			Reason:       "insufficient history (12 of 60 required observations)",
+		}, analytics.DataQuality[2])
+		assert.Equal(t, "AAPL/AAPL", analytics.DataQuality[0].Symbol)
+
+		// The nulls are encoded as such, next to the reasons
+		body, err := json.Marshal(analytics)
+		require.NoError(t, err)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &decoded))
+		riskMetrics := decoded["risk_metrics"].(map[string]interface{})["AAPL"].(map[string]interface{})
+		assert.Contains(t, riskMetrics, "sharpe_ratio")
+		assert.Nil(t, riskMetrics["sharpe_ratio"])
+		assert.Len(t, decoded["data_quality"], 5)
+	})
+
+	t.Run("At the minimum", func(t *testing.T) {
+		analytics := analyze(60)
+
+		aapl := analytics.RiskMetrics["AAPL"]
+		assert.NotNil(t, analytics.CorrelationMatrix["AAPL"]["AAPL"])
+		if assert.NotNil(t, aapl.SharpeRatio) {
+			assert.Equal(t, 1.1, *aapl.SharpeRatio)
+		}
+		assert.NotNil(t, aapl.Volatility)
+		assert.NotNil(t, aapl.SortinoRatio)
+		assert.NotNil(t, aapl.VaR)
+		assert.Empty(t, analytics.DataQuality)
+	})
+}
+
+func TestCalculateBeta(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(nil, nil).WithBetaCalculator(fixedBetas{7: 1.3})
+	since := time.Now().AddDate(-1, 0, 0)
+
+	beta, err := service.calculateBeta(ctx, "7", since)
+	require.NoError(t, err)
+	assert.Equal(t, 1.3, beta)
+
+	// Too few snapshots to regress is no exposure rather than a failure
+	beta, err = service.calculateBeta(ctx, "8", since)
+	require.NoError(t, err)
+	assert.Zero(t, beta)
+
+	_, err = service.calculateBeta(ctx, "4b7e5c2a-0f5e-4d8c-9a51-3c1d2e6f7a80", since)
+	assert.Error(t, err)
+}
+
+func TestCalculateReturn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	service := NewService(db, nil)
+	ctx := context.Background()
+	var notes []sample.Note
+
+	mock.ExpectQuery("FROM portfolio_snapshots(.|\n)*snapshot_date <= l.snapshot_date - \\$2::int").
+		WithArgs("42", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"end", "start", "history_days"}).AddRow(11000.0, 10000.0, 14))
+	ret, err := service.calculateReturn(ctx, "42", weeklySpan, sample.WeeklyReturn, &notes)
+	require.NoError(t, err)
+	if assert.NotNil(t, ret) {
+		assert.InDelta(t, 0.1, *ret, 1e-9)
+	}
+
+	// Two weeks of snapshots have no yearly return, rather than a zero one
+	mock.ExpectQuery("FROM portfolio_snapshots").
+		WithArgs("42", 365).
+		WillReturnRows(sqlmock.NewRows([]string{"end", "start", "history_days"}).AddRow(11000.0, nil, 14))
+	ret, err = service.calculateReturn(ctx, "42", yearlySpan, sample.YearlyReturn, &notes)
+	require.NoError(t, err)
+	assert.Nil(t, ret)
+
+	// Nor does a portfolio without snapshots have a daily one
+	mock.ExpectQuery("FROM portfolio_snapshots").
+		WithArgs("43", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"end", "start", "history_days"}))
+	ret, err = service.calculateReturn(ctx, "43", 24*time.Hour, sample.DailyReturn, &notes)
+	require.NoError(t, err)
+	assert.Nil(t, ret)
+
+	if assert.Len(t, notes, 2) {
+		assert.Equal(t, sample.Note{
+			Metric:       sample.YearlyReturn,
+			Symbol:       "42",
+			Observations: 14,
+			Required:     365,
+			Reason:       "insufficient history (14 of 365 required observations)",
+		}, notes[0])
+		assert.Equal(t, sample.DailyReturn, notes[1].Metric)
+		assert.Equal(t, 0, notes[1].Observations)
+	}
+
+	// Failing to read the snapshots isn't mistaken for too few of them
+	mock.ExpectQuery("FROM portfolio_snapshots").
+		WithArgs("42", 30).
+		WillReturnError(errors.New("connection reset"))
+	_, err = service.calculateReturn(ctx, "42", monthlySpan, sample.MonthlyReturn, &notes)
+	assert.Error(t, err)
+	assert.Len(t, notes, 2)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
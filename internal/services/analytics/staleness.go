@@ -0,0 +1,57 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultMaxAnalysisAge is how long market analyses are kept when no
+// maximum age is configured
+const defaultMaxAnalysisAge = 24 * time.Hour
+
+// WithMaxAnalysisAge sets how old a market analysis may get before
+// PurgeStaleAnalyses deletes it
+func (s *Service) WithMaxAnalysisAge(age time.Duration) *Service {
+	if age > 0 {
+		s.maxAnalysisAge = age
+	}
+	return s
+}
+
+// WithRegisterer registers the service's metrics with reg
+func (s *Service) WithRegisterer(reg prometheus.Registerer) *Service {
+	if reg != nil {
+		reg.MustRegister(s.staleServed)
+	}
+	return s
+}
+
+// PurgeStaleAnalyses deletes market analyses last updated more than the
+// maximum analysis age ago, along with their trading signals, and history
+// past its retention. It is meant to run on a schedule.
+func (s *Service) PurgeStaleAnalyses(ctx context.Context) error {
+	query := `DELETE FROM market_analysis WHERE updated_at < $1`
+	if _, err := s.db.ExecContext(ctx, query, time.Now().Add(-s.maxAnalysisAge)); err != nil {
+		return fmt.Errorf("failed to purge stale market analyses: %w", err)
+	}
+	if s.historyRetention > 0 {
+		if _, err := s.analyses.PruneMarketAnalysisHistory(ctx, time.Now().Add(-s.historyRetention)); err != nil {
+			return fmt.Errorf("failed to purge market analysis history: %w", err)
+		}
+	}
+	return nil
+}
+
+// CountStaleAnalyses returns how many market analyses are older than the
+// maximum analysis age and due to be purged
+func (s *Service) CountStaleAnalyses(ctx context.Context) (int, error) {
+	query := `SELECT COUNT(*) FROM market_analysis WHERE updated_at < $1`
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, time.Now().Add(-s.maxAnalysisAge)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count stale market analyses: %w", err)
+	}
+	return count, nil
+}
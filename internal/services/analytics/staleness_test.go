@@ -0,0 +1,73 @@
+package analytics
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// cutoffBetween matches a purge cutoff that deletes a record updated at
+// stale but keeps one updated at fresh
+type cutoffBetween struct {
+	stale, fresh time.Time
+}
+
+func (c cutoffBetween) Match(v driver.Value) bool {
+	cutoff, ok := v.(time.Time)
+	return ok && c.stale.Before(cutoff) && !c.fresh.Before(cutoff)
+}
+
+func TestPurgeStaleAnalyses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("Default age", func(t *testing.T) {
+		service := NewService(db, nil)
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM market_analysis WHERE updated_at < \\$1").
+			WithArgs(cutoffBetween{stale: now.Add(-25 * time.Hour), fresh: now.Add(-23 * time.Hour)}).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		assert.NoError(t, service.PurgeStaleAnalyses(ctx))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Configured age", func(t *testing.T) {
+		service := NewService(db, nil).WithMaxAnalysisAge(6 * time.Hour)
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM market_analysis WHERE updated_at < \\$1").
+			WithArgs(cutoffBetween{stale: now.Add(-7 * time.Hour), fresh: now.Add(-5 * time.Hour)}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, service.PurgeStaleAnalyses(ctx))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCountStaleAnalyses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewService(db, nil).WithMaxAnalysisAge(12 * time.Hour)
+	now := time.Now()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM market_analysis WHERE updated_at < \\$1").
+		WithArgs(cutoffBetween{stale: now.Add(-13 * time.Hour), fresh: now.Add(-11 * time.Hour)}).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+
+	count, err := service.CountStaleAnalyses(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,77 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// ErrInvalidTimeframe is returned for a timeframe that isn't one of
+// models.Timeframes
+var ErrInvalidTimeframe = errors.New("invalid timeframe")
+
+// minStatsLookback is the least price history correlations and risk
+// statistics are computed over, since a handful of daily prices says
+// nothing about either
+const minStatsLookback = 30 * 24 * time.Hour
+
+// Spans of the portfolio returns beyond the daily one
+const (
+	weeklySpan  = 7 * 24 * time.Hour
+	monthlySpan = 30 * 24 * time.Hour
+	yearlySpan  = 365 * 24 * time.Hour
+)
+
+// analyticsScope is how much history advanced analytics are computed over
+type analyticsScope struct {
+	// window is the longest return reported, or zero for all of them
+	window time.Duration
+
+	correlationSince time.Time
+	riskSince        time.Time
+}
+
+// fullScope reports every return, with correlations over the last six
+// months and risk over the last year
+func fullScope(now time.Time) analyticsScope {
+	return analyticsScope{
+		correlationSince: now.AddDate(0, -6, 0),
+		riskSince:        now.AddDate(-1, 0, 0),
+	}
+}
+
+// newAnalyticsScope narrows the full scope to timeframe, or keeps it when
+// timeframe is empty. Lookbacks shrink to the timeframe but never below
+// minStatsLookback.
+func newAnalyticsScope(timeframe string, now time.Time) (analyticsScope, error) {
+	scope := fullScope(now)
+	if timeframe == "" {
+		return scope, nil
+	}
+
+	window, ok := models.TimeframeDuration(timeframe)
+	if !ok {
+		return analyticsScope{}, fmt.Errorf("%w: %q", ErrInvalidTimeframe, timeframe)
+	}
+	scope.window = window
+
+	lookback := window
+	if lookback < minStatsLookback {
+		lookback = minStatsLookback
+	}
+	since := now.Add(-lookback)
+	if since.After(scope.correlationSince) {
+		scope.correlationSince = since
+	}
+	if since.After(scope.riskSince) {
+		scope.riskSince = since
+	}
+	return scope, nil
+}
+
+// includes reports whether the return over span is reported
+func (s analyticsScope) includes(span time.Duration) bool {
+	return s.window == 0 || span <= s.window
+}
@@ -0,0 +1,143 @@
+package analytics
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+)
+
+// sinceArg matches a lookback start within a minute of when
+type sinceArg struct {
+	when time.Time
+}
+
+func (a sinceArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	d := t.Sub(a.when)
+	return d > -time.Minute && d < time.Minute
+}
+
+func TestGetTimeframeAnalytics(t *testing.T) {
+	const portfolioID = "42"
+
+	// expectAnalytics expects the queries for a portfolio holding only AAPL,
+	// with correlations from correlationSince and risk from riskSince
+	expectAnalytics := func(mock sqlmock.Sqlmock, correlationSince, riskSince time.Time, full bool) {
+		mock.ExpectQuery("SELECT paper_trading FROM portfolios").
+			WithArgs(portfolioID).
+			WillReturnRows(sqlmock.NewRows([]string{"paper_trading"}).AddRow(false))
+		mock.ExpectQuery("SELECT symbol, type, quantity, avg_price, value, last_update").
+			WithArgs(portfolioID).
+			WillReturnRows(sqlmock.NewRows([]string{"symbol", "type", "quantity", "avg_price", "value", "last_update"}).
+				AddRow("AAPL", "stock", "10", "150", "2000", time.Now()))
+		mock.ExpectQuery("SELECT CORR").
+			WithArgs("AAPL", "AAPL", sinceArg{correlationSince}).
+			WillReturnRows(sqlmock.NewRows([]string{"correlation", "observations"}).AddRow(1.0, 120))
+		mock.ExpectQuery("STDDEV\\(return\\) \\* SQRT\\(\\$3\\) as volatility").
+			WithArgs("AAPL", sinceArg{riskSince}, 252.0, risk.DailyRate(risk.DefaultRiskFreeRate)).
+			WillReturnRows(sqlmock.NewRows([]string{"volatility", "sharpe_ratio", "max_drawdown", "observations"}).AddRow(0.2, 1.1, -0.05, 250))
+		mock.ExpectQuery("ORDER BY return").
+			WithArgs("AAPL", sinceArg{riskSince}).
+			WillReturnRows(sqlmock.NewRows([]string{"return"}).AddRow(-0.03).AddRow(0.01))
+		mock.ExpectQuery("downside_deviation").
+			WithArgs("AAPL", sinceArg{riskSince}).
+			WillReturnRows(sqlmock.NewRows([]string{"avg_return", "downside_deviation", "observations"}).AddRow(0.001, 0.01, 250))
+		if !full {
+			// Only the daily and weekly returns are within the week
+			expectReturns(mock, portfolioID, 1, 7)
+			return
+		}
+		expectReturns(mock, portfolioID, 1, 7, 30, 365)
+		// Only the yearly return is risk adjusted, over the portfolio's
+		// volatility
+		mock.ExpectQuery("SELECT COALESCE\\(STDDEV\\(return\\), 0\\) \\* SQRT\\(\\$3\\)").
+			WithArgs("AAPL", sinceArg{riskSince}, 252.0).
+			WillReturnRows(sqlmock.NewRows([]string{"volatility"}).AddRow(0.2))
+	}
+
+	t.Run("Full history", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create mock DB: %v", err)
+		}
+		defer db.Close()
+
+		now := time.Now()
+		expectAnalytics(mock, now.AddDate(0, -6, 0), now.AddDate(-1, 0, 0), true)
+
+		analytics, err := NewService(db, nil).WithBetaCalculator(fixedBetas{42: 0.9}).GetTimeframeAnalytics(context.Background(), portfolioID, "")
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, risk.DefaultRiskFreeRate, analytics.RiskFreeRate)
+
+		metrics := analytics.PortfolioMetrics
+		assert.NotNil(t, metrics.WeeklyReturn)
+		assert.NotNil(t, metrics.MonthlyReturn)
+		assert.NotNil(t, metrics.YearlyReturn)
+		assert.NotNil(t, metrics.RiskAdjusted)
+	})
+
+	t.Run("Week", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create mock DB: %v", err)
+		}
+		defer db.Close()
+
+		// A week of daily prices is too few, so statistics cover a month,
+		// and the portfolio volatility behind the yearly risk adjusted
+		// return isn't queried at all
+		since := time.Now().Add(-minStatsLookback)
+		expectAnalytics(mock, since, since, false)
+
+		analytics, err := NewService(db, nil).WithBetaCalculator(fixedBetas{42: 0.9}).GetTimeframeAnalytics(context.Background(), portfolioID, "7d")
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		metrics := analytics.PortfolioMetrics
+		assert.NotNil(t, metrics.WeeklyReturn)
+		assert.Nil(t, metrics.MonthlyReturn)
+		assert.Nil(t, metrics.YearlyReturn)
+		assert.Nil(t, metrics.RiskAdjusted)
+	})
+
+	t.Run("Unknown timeframe", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create mock DB: %v", err)
+		}
+		defer db.Close()
+
+		_, err = NewService(db, nil).WithBetaCalculator(fixedBetas{42: 0.9}).GetTimeframeAnalytics(context.Background(), portfolioID, "90d")
+		assert.True(t, errors.Is(err, ErrInvalidTimeframe))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestNewAnalyticsScope(t *testing.T) {
+	now := time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC)
+
+	full, err := newAnalyticsScope("", now)
+	require.NoError(t, err)
+	assert.Equal(t, fullScope(now), full)
+	assert.True(t, full.includes(yearlySpan))
+
+	month, err := newAnalyticsScope("30d", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -30), month.correlationSince)
+	assert.Equal(t, now.AddDate(0, 0, -30), month.riskSince)
+	assert.True(t, month.includes(monthlySpan))
+	assert.False(t, month.includes(yearlySpan))
+}
@@ -0,0 +1,232 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+const (
+	// minSharpeImprovement is the Sharpe ratio gain below which rebalancing
+	// isn't considered worth its cost
+	minSharpeImprovement = 0.1
+	// rebalancingCostRate is the estimated cost of trading, as a fraction of
+	// the notional bought or sold
+	rebalancingCostRate = 0.001
+	// minOrderNotional is the trade value below which GenerateRebalancingOrders
+	// leaves a position as it is
+	minOrderNotional = 1.0
+)
+
+// ErrEmptyPortfolio is returned when a portfolio has no valued positions
+var ErrEmptyPortfolio = errors.New("portfolio has no positions")
+
+// PortfolioOptimizer finds and evaluates portfolio weights
+type PortfolioOptimizer interface {
+	Optimize(ctx context.Context, symbols []string, riskTolerance float64, method portfolio.ExpectedReturnMethod) (*portfolio.OptimizationResult, error)
+	Evaluate(ctx context.Context, symbols []string, weights []float64, method portfolio.ExpectedReturnMethod) (*portfolio.OptimizationResult, error)
+}
+
+// ImprovementEstimate compares a portfolio's current weights with the
+// weights the optimizer suggests
+type ImprovementEstimate struct {
+	PortfolioID       string    `json:"portfolio_id"`
+	Symbols           []string  `json:"symbols"`
+	CurrentWeights    []float64 `json:"current_weights"`
+	OptimizedWeights  []float64 `json:"optimized_weights"`
+	CurrentSharpe     float64   `json:"current_sharpe"`
+	OptimizedSharpe   float64   `json:"optimized_sharpe"`
+	CurrentRisk       float64   `json:"current_risk"`
+	OptimizedRisk     float64   `json:"optimized_risk"`
+	SharpeImprovement float64   `json:"sharpe_improvement"`
+	RiskReduction     float64   `json:"risk_reduction"`
+	TotalValue        float64   `json:"total_value"`
+	// EstimatedRebalancingCost is the cost of trading from the current to the
+	// optimized weights, in the portfolio's currency
+	EstimatedRebalancingCost float64 `json:"estimated_rebalancing_cost"`
+	IsWorthRebalancing       bool    `json:"is_worth_rebalancing"`
+}
+
+// riskTolerances maps a portfolio's risk level to the tolerance it is
+// optimized with
+var riskTolerances = map[models.RiskLevel]float64{
+	models.LowRisk:    0.25,
+	models.MediumRisk: 0.5,
+	models.HighRisk:   0.75,
+}
+
+// WithOptimizer enables WhatIfOptimization
+func (s *Service) WithOptimizer(optimizer PortfolioOptimizer) *Service {
+	s.optimizer = optimizer
+	return s
+}
+
+// WhatIfOptimization estimates how much a portfolio would gain from being
+// rebalanced to the optimizer's weights at the risk tolerance of its risk
+// level
+func (s *Service) WhatIfOptimization(ctx context.Context, portfolioID string) (*ImprovementEstimate, error) {
+	if s.optimizer == nil {
+		return nil, errors.New("no portfolio optimizer configured")
+	}
+
+	var risk models.RiskLevel
+	err := s.db.QueryRowContext(ctx, `SELECT risk FROM portfolios WHERE id = $1`, portfolioID).Scan(&risk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio %s: %w", portfolioID, err)
+	}
+	riskTolerance, ok := riskTolerances[risk]
+	if !ok {
+		riskTolerance = riskTolerances[models.MediumRisk]
+	}
+
+	symbols, values, err := s.positionValues(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	weights, total := weightsOf(values)
+	if total <= 0 {
+		return nil, ErrEmptyPortfolio
+	}
+
+	current, err := s.optimizer.Evaluate(ctx, symbols, weights, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate current weights: %w", err)
+	}
+	optimized, err := s.optimizer.Optimize(ctx, symbols, riskTolerance, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to optimize: %w", err)
+	}
+
+	estimate := improvementEstimate(current, optimized, total)
+	estimate.PortfolioID = portfolioID
+	estimate.Symbols = symbols
+	estimate.TotalValue = total
+	return estimate, nil
+}
+
+// RebalancingOrder is a trade moving one position to its optimized weight.
+// Quantity and MarketImpact are only set for symbols with enough market
+// data to price the trade.
+type RebalancingOrder struct {
+	Symbol       string                `json:"symbol"`
+	Side         string                `json:"side"`
+	Notional     float64               `json:"notional"`
+	Quantity     float64               `json:"quantity,omitempty"`
+	MarketImpact *MarketImpactEstimate `json:"market_impact,omitempty"`
+}
+
+// GenerateRebalancingOrders returns the trades that move a portfolio to the
+// weights WhatIfOptimization suggests, largest first, each with its
+// estimated market impact
+func (s *Service) GenerateRebalancingOrders(ctx context.Context, portfolioID string) ([]RebalancingOrder, error) {
+	estimate, err := s.WhatIfOptimization(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []RebalancingOrder{}
+	for i, symbol := range estimate.Symbols {
+		notional := (estimate.OptimizedWeights[i] - estimate.CurrentWeights[i]) * estimate.TotalValue
+		if math.Abs(notional) < minOrderNotional {
+			continue
+		}
+		order := RebalancingOrder{Symbol: symbol, Side: SideBuy, Notional: math.Abs(notional)}
+		if notional < 0 {
+			order.Side = SideSell
+		}
+
+		liq, err := s.liquidity(ctx, symbol)
+		if err != nil && !errors.Is(err, ErrInsufficientHistory) {
+			return nil, err
+		}
+		if err == nil {
+			order.Quantity = order.Notional / liq.price
+			order.MarketImpact = liq.impact(order.Quantity, order.Side)
+		}
+		orders = append(orders, order)
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Notional > orders[j].Notional
+	})
+	return orders, nil
+}
+
+// positionValues returns the market value of each position, falling back to
+// its cost basis for symbols without market data
+func (s *Service) positionValues(ctx context.Context, portfolioID string) ([]string, []float64, error) {
+	query := `
+		SELECT p.symbol, p.quantity * COALESCE(md.close, p.entry_price)
+		FROM positions p
+		LEFT JOIN LATERAL (
+			SELECT close FROM market_data
+			WHERE symbol = p.symbol
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) md ON true
+		WHERE p.portfolio_id = $1 AND p.quantity > 0
+		ORDER BY p.symbol
+	`
+	rows, err := s.db.QueryContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var symbols []string
+	var values []float64
+	for rows.Next() {
+		var symbol string
+		var value sql.NullFloat64
+		if err := rows.Scan(&symbol, &value); err != nil {
+			return nil, nil, err
+		}
+		symbols = append(symbols, symbol)
+		values = append(values, value.Float64)
+	}
+	return symbols, values, rows.Err()
+}
+
+// weightsOf returns each value's share of their total, and the total
+func weightsOf(values []float64) ([]float64, float64) {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	weights := make([]float64, len(values))
+	if total <= 0 {
+		return weights, total
+	}
+	for i, v := range values {
+		weights[i] = v / total
+	}
+	return weights, total
+}
+
+func improvementEstimate(current, optimized *portfolio.OptimizationResult, totalValue float64) *ImprovementEstimate {
+	// Every unit of weight moved is sold on one side and bought on the other
+	var turnover float64
+	for i, w := range optimized.Weights {
+		turnover += math.Abs(w - current.Weights[i])
+	}
+
+	estimate := &ImprovementEstimate{
+		CurrentWeights:           current.Weights,
+		OptimizedWeights:         optimized.Weights,
+		CurrentSharpe:            current.SharpeRatio,
+		OptimizedSharpe:          optimized.SharpeRatio,
+		CurrentRisk:              current.Risk,
+		OptimizedRisk:            optimized.Risk,
+		SharpeImprovement:        optimized.SharpeRatio - current.SharpeRatio,
+		RiskReduction:            current.Risk - optimized.Risk,
+		EstimatedRebalancingCost: turnover * totalValue * rebalancingCostRate,
+	}
+	estimate.IsWorthRebalancing = estimate.SharpeImprovement >= minSharpeImprovement
+	return estimate
+}
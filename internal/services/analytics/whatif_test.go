@@ -0,0 +1,156 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/services/portfolio"
+)
+
+// diagonalOptimizer scores weights over uncorrelated assets and always
+// suggests the same optimal weights
+type diagonalOptimizer struct {
+	returns       []float64
+	vols          []float64
+	optimal       []float64
+	riskTolerance float64
+}
+
+func (o *diagonalOptimizer) Evaluate(ctx context.Context, symbols []string, weights []float64, method portfolio.ExpectedReturnMethod) (*portfolio.OptimizationResult, error) {
+	var ret, variance float64
+	for i, w := range weights {
+		ret += w * o.returns[i]
+		variance += w * w * o.vols[i] * o.vols[i]
+	}
+	risk := math.Sqrt(variance)
+	return &portfolio.OptimizationResult{Weights: weights, ExpectedReturn: ret, Risk: risk, SharpeRatio: ret / risk}, nil
+}
+
+func (o *diagonalOptimizer) Optimize(ctx context.Context, symbols []string, riskTolerance float64, method portfolio.ExpectedReturnMethod) (*portfolio.OptimizationResult, error) {
+	o.riskTolerance = riskTolerance
+	return o.Evaluate(ctx, symbols, o.optimal, method)
+}
+
+func TestWhatIfOptimization(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	// Max-Sharpe weights of uncorrelated assets are proportional to
+	// return / variance: 10 : 1.25
+	optimizer := &diagonalOptimizer{
+		returns: []float64{0.10, 0.05},
+		vols:    []float64{0.10, 0.20},
+		optimal: []float64{8.0 / 9, 1.0 / 9},
+	}
+	service := NewService(db, nil).WithOptimizer(optimizer)
+	ctx := context.Background()
+
+	expectPortfolio := func(id string, values ...float64) {
+		mock.ExpectQuery("SELECT risk FROM portfolios").
+			WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"risk"}).AddRow(models.MediumRisk))
+		rows := sqlmock.NewRows([]string{"symbol", "value"})
+		for i, v := range values {
+			rows.AddRow([]string{"AAA", "BBB"}[i], v)
+		}
+		mock.ExpectQuery("FROM positions p").WithArgs(id).WillReturnRows(rows)
+	}
+
+	t.Run("Already at optimal weights", func(t *testing.T) {
+		expectPortfolio("1", 80000, 10000)
+
+		estimate, err := service.WhatIfOptimization(ctx, "1")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.InDelta(t, 0, estimate.SharpeImprovement, 1e-9)
+		assert.InDelta(t, 0, estimate.RiskReduction, 1e-9)
+		assert.InDelta(t, 0, estimate.EstimatedRebalancingCost, 1e-6)
+		assert.False(t, estimate.IsWorthRebalancing)
+		assert.Equal(t, 0.5, optimizer.riskTolerance)
+	})
+
+	t.Run("Far from optimal weights", func(t *testing.T) {
+		expectPortfolio("2", 20000, 80000)
+
+		estimate, err := service.WhatIfOptimization(ctx, "2")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.InDeltaSlice(t, []float64{0.2, 0.8}, estimate.CurrentWeights, 1e-9)
+		assert.Greater(t, estimate.SharpeImprovement, 0.5)
+		assert.Greater(t, estimate.RiskReduction, 0.0)
+		assert.True(t, estimate.IsWorthRebalancing)
+		// 0.689 of weight moves each way on a 100k portfolio at 10bps
+		assert.InDelta(t, 137.78, estimate.EstimatedRebalancingCost, 0.01)
+	})
+
+	t.Run("No positions", func(t *testing.T) {
+		expectPortfolio("3")
+
+		_, err := service.WhatIfOptimization(ctx, "3")
+		assert.ErrorIs(t, err, ErrEmptyPortfolio)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGenerateRebalancingOrders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	optimizer := &diagonalOptimizer{
+		returns: []float64{0.10, 0.05},
+		vols:    []float64{0.10, 0.20},
+		optimal: []float64{8.0 / 9, 1.0 / 9},
+	}
+	service := NewService(db, nil).WithOptimizer(optimizer)
+
+	mock.ExpectQuery("SELECT risk FROM portfolios").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"risk"}).AddRow(models.MediumRisk))
+	mock.ExpectQuery("FROM positions p").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"symbol", "value"}).AddRow("AAA", 20000).AddRow("BBB", 80000))
+	mock.ExpectQuery("FROM market_data").
+		WithArgs("AAA", sqlmock.AnyArg(), impactWindowDays).
+		WillReturnRows(liquidRows())
+	// BBB has no market data to price its trade
+	mock.ExpectQuery("FROM market_data").
+		WithArgs("BBB", sqlmock.AnyArg(), impactWindowDays).
+		WillReturnRows(sqlmock.NewRows([]string{"close", "volume"}))
+
+	orders, err := service.GenerateRebalancingOrders(context.Background(), "2")
+	if !assert.NoError(t, err) || !assert.Len(t, orders, 2) {
+		return
+	}
+
+	// 0.689 of a 100k portfolio moves from BBB to AAA
+	assert.Equal(t, "AAA", orders[0].Symbol)
+	assert.Equal(t, SideBuy, orders[0].Side)
+	assert.InDelta(t, 68888.89, orders[0].Notional, 0.01)
+	assert.InDelta(t, 68888.89/102, orders[0].Quantity, 0.01)
+	if assert.NotNil(t, orders[0].MarketImpact) {
+		assert.Equal(t, SideBuy, orders[0].MarketImpact.Side)
+		assert.Greater(t, orders[0].MarketImpact.EstimatedSlippageUSD, 0.0)
+	}
+
+	assert.Equal(t, "BBB", orders[1].Symbol)
+	assert.Equal(t, SideSell, orders[1].Side)
+	assert.InDelta(t, 68888.89, orders[1].Notional, 0.01)
+	assert.Zero(t, orders[1].Quantity)
+	assert.Nil(t, orders[1].MarketImpact)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -6,16 +6,46 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/database"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
 )
 
+// collectorComponent names the collector in logs and error metrics
+const collectorComponent = "market_data_collector"
+
+// DegradedCollection is how the collector cuts back once its provider's
+// monthly budget is used up
+type DegradedCollection struct {
+	// Symbols replaces the tracked symbols; empty keeps them all
+	Symbols []string
+	// Interval replaces the collection interval. Collections only run at
+	// its boundaries, so it should be a multiple of the normal interval.
+	Interval time.Duration
+}
+
 type MarketDataCollector struct {
 	db        *sql.DB
+	pool      *pgxpool.Pool
 	apiKey    string
 	provider  string
+	baseURL   string
 	symbols   []string
 	interval  time.Duration
+	schedule  *Schedule
 	stopChan  chan struct{}
+	logger    *logger.Logger
+	errors    *monitoring.ComponentErrors
+	usage     *monitoring.ProviderUsage
+	degraded  DegradedCollection
+	// degradedMode is whether the last collection ran degraded
+	degradedMode bool
 }
 
 func NewMarketDataCollector(
@@ -24,55 +54,130 @@ func NewMarketDataCollector(
 	apiKey string,
 	symbols []string,
 	interval time.Duration,
+	log *logger.Logger,
 ) *MarketDataCollector {
+	if log == nil {
+		log = logger.Default()
+	}
+	log = log.WithFields(map[string]interface{}{"component": collectorComponent})
 	return &MarketDataCollector{
 		db:       db,
 		provider: provider,
+		baseURL:  fmt.Sprintf("https://api.%s.com", provider),
 		apiKey:   apiKey,
 		symbols:  symbols,
 		interval: interval,
+		schedule: NewSchedule(interval, log),
 		stopChan: make(chan struct{}),
+		logger:   log,
 	}
 }
 
+// WithJitter delays each collection by up to jitter past its interval
+// boundary
+func (c *MarketDataCollector) WithJitter(jitter time.Duration) *MarketDataCollector {
+	c.schedule.WithJitter(jitter)
+	return c
+}
+
+// WithErrors counts the collector's failures in errs
+func (c *MarketDataCollector) WithErrors(errs *monitoring.ComponentErrors) *MarketDataCollector {
+	c.errors = errs
+	return c
+}
+
+// WithPool writes candles through pool rather than database/sql
+func (c *MarketDataCollector) WithPool(pool *pgxpool.Pool) *MarketDataCollector {
+	c.pool = pool
+	return c
+}
+
+// WithUsage records the collector's provider calls in usage, collecting as
+// degraded describes while usage reports the provider degraded
+func (c *MarketDataCollector) WithUsage(usage *monitoring.ProviderUsage, degraded DegradedCollection) *MarketDataCollector {
+	c.usage = usage
+	c.degraded = degraded
+	return c
+}
+
+// Start collects at every interval boundary until ctx is done or Stop is
+// called
 func (c *MarketDataCollector) Start(ctx context.Context) error {
-	ticker := time.NewTicker(c.interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-c.stopChan:
-			return nil
-		case <-ticker.C:
-			if err := c.collect(ctx); err != nil {
-				fmt.Printf("Error collecting market data: %v\n", err)
-			}
-		}
-	}
+	return c.schedule.Run(ctx, c.stopChan, nil, func(boundary time.Time, _ bool) {
+		c.collect(ctx, boundary)
+	})
+}
+
+// GetActiveSymbols returns the symbols the collector tracks
+func (c *MarketDataCollector) GetActiveSymbols(ctx context.Context) ([]string, error) {
+	return c.symbols, nil
 }
 
 func (c *MarketDataCollector) Stop() {
 	close(c.stopChan)
 }
 
-func (c *MarketDataCollector) collect(ctx context.Context) error {
-	for _, symbol := range c.symbols {
+// collect fetches and stores every tracked symbol's candles up to
+// boundary. A symbol that fails is logged and counted, and the rest are
+// still collected.
+func (c *MarketDataCollector) collect(ctx context.Context, boundary time.Time) {
+	for _, symbol := range c.plannedSymbols(boundary) {
+		if ctx.Err() != nil {
+			return
+		}
+
 		data, err := c.fetchMarketData(ctx, symbol)
 		if err != nil {
-			return fmt.Errorf("failed to fetch data for %s: %v", symbol, err)
+			c.fail(symbol, "fetch", err)
+			continue
 		}
 
-		if err := c.saveMarketData(ctx, symbol, data); err != nil {
-			return fmt.Errorf("failed to save data for %s: %v", symbol, err)
+		if err := c.saveMarketData(ctx, symbol, data, boundary); err != nil {
+			c.fail(symbol, "save", err)
 		}
 	}
-	return nil
+}
+
+// plannedSymbols returns the symbols to collect at boundary: every tracked
+// symbol, or while the provider is over budget the degraded symbols, and
+// none at boundaries between the degraded interval's
+func (c *MarketDataCollector) plannedSymbols(boundary time.Time) []string {
+	degraded := c.usage.Degraded(c.provider)
+	if degraded != c.degradedMode {
+		c.degradedMode = degraded
+		fields := map[string]interface{}{"provider": c.provider}
+		if degraded {
+			fields["symbols"] = len(c.degraded.Symbols)
+			fields["interval"] = c.degraded.Interval.String()
+			c.logger.WithFields(fields).Warn("Provider budget exhausted, collecting in degraded mode")
+		} else {
+			c.logger.WithFields(fields).Info("Provider back under budget, collecting normally")
+		}
+	}
+	if !degraded {
+		return c.symbols
+	}
+
+	if c.degraded.Interval > 0 && !boundary.Truncate(c.degraded.Interval).Equal(boundary) {
+		return nil
+	}
+	if len(c.degraded.Symbols) > 0 {
+		return c.degraded.Symbols
+	}
+	return c.symbols
+}
+
+func (c *MarketDataCollector) fail(symbol, errorType string, err error) {
+	c.logger.WithFields(map[string]interface{}{
+		"symbol":     symbol,
+		"error_type": errorType,
+		"error":      err.Error(),
+	}).Error("Failed to collect market data")
+	c.errors.Record(collectorComponent, errorType)
 }
 
 func (c *MarketDataCollector) fetchMarketData(ctx context.Context, symbol string) (map[string]interface{}, error) {
-	url := fmt.Sprintf("https://api.%s.com/v1/data/%s?apikey=%s", c.provider, symbol, c.apiKey)
+	url := fmt.Sprintf("%s/v1/data/%s?apikey=%s", c.baseURL, symbol, c.apiKey)
 	
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -84,6 +189,12 @@ func (c *MarketDataCollector) fetchMarketData(ctx context.Context, symbol string
 		return nil, err
 	}
 	defer resp.Body.Close()
+	c.usage.Record(monitoring.ProviderCall{
+		Provider:  c.provider,
+		Endpoint:  "candles",
+		Symbol:    symbol,
+		Component: monitoring.ProviderCaller(ctx, collectorComponent),
+	})
 
 	var data map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
@@ -93,45 +204,147 @@ func (c *MarketDataCollector) fetchMarketData(ctx context.Context, symbol string
 	return data, nil
 }
 
-func (c *MarketDataCollector) saveMarketData(ctx context.Context, symbol string, data map[string]interface{}) error {
-	tx, err := c.db.BeginTx(ctx, nil)
+func (c *MarketDataCollector) saveMarketData(ctx context.Context, symbol string, data map[string]interface{}, boundary time.Time) error {
+	candles, err := parseCandles(symbol, data)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	query := `
-		INSERT INTO market_data (symbol, timestamp, open, high, low, close, volume)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (symbol, timestamp) DO UPDATE 
-		SET open = EXCLUDED.open,
-			high = EXCLUDED.high,
-			low = EXCLUDED.low,
-			close = EXCLUDED.close,
-			volume = EXCLUDED.volume
-	`
+	_, err = c.BulkUpsertOHLCV(ctx, alignCandles(candles, c.interval, boundary))
+	return err
+}
 
-	stmt, err := tx.PrepareContext(ctx, query)
-	if err != nil {
-		return err
+// CollectBatch fetches the latest candle of each of symbols, for callers
+// that cache or publish current prices rather than store history. A symbol
+// the provider has no candles for is left out, and the first that fails
+// fails the batch.
+func (c *MarketDataCollector) CollectBatch(ctx context.Context, symbols []string) (map[string]models.MarketData, error) {
+	batch := make(map[string]models.MarketData, len(symbols))
+	for _, symbol := range symbols {
+		data, err := c.fetchMarketData(ctx, symbol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", symbol, err)
+		}
+		candles, err := parseCandles(symbol, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", symbol, err)
+		}
+
+		for _, candle := range candles {
+			if latest, ok := batch[symbol]; !ok || candle.Timestamp.After(latest.Timestamp) {
+				batch[symbol] = candle
+			}
+		}
+	}
+	return batch, nil
+}
+
+// alignCandles resamples candles into candles of interval, each stamped
+// with the boundary it starts at, so the stored grid never depends on when
+// the provider's candles or the collector's runs happened to fall. The
+// interval starting at boundary is still in progress and left for the next
+// run.
+func alignCandles(candles []models.MarketData, interval time.Duration, boundary time.Time) []models.MarketData {
+	sorted := append([]models.MarketData(nil), candles...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	var aligned []models.MarketData
+	for _, candle := range sorted {
+		start := candle.Timestamp.Truncate(interval)
+		if !start.Before(boundary) {
+			break
+		}
+
+		if n := len(aligned); n > 0 && aligned[n-1].Timestamp.Equal(start) {
+			bar := &aligned[n-1]
+			if candle.High > bar.High {
+				bar.High = candle.High
+			}
+			if candle.Low < bar.Low {
+				bar.Low = candle.Low
+			}
+			bar.Close = candle.Close
+			bar.Volume += candle.Volume
+			continue
+		}
+		candle.Timestamp = start
+		aligned = append(aligned, candle)
+	}
+	return aligned
+}
+
+// BulkUpsertOHLCV stores candles in market_data, replacing the prices of
+// any already stored for the same symbol and timestamp
+func (c *MarketDataCollector) BulkUpsertOHLCV(ctx context.Context, candles []models.MarketData) (int64, error) {
+	rows := make([][]interface{}, len(candles))
+	for i, candle := range candles {
+		rows[i] = []interface{}{
+			candle.Symbol,
+			candle.Timestamp,
+			candle.Open,
+			candle.High,
+			candle.Low,
+			candle.Close,
+			candle.Volume,
+		}
 	}
-	defer stmt.Close()
-
-	for _, candle := range data["candles"].([]interface{}) {
-		c := candle.(map[string]interface{})
-		_, err = stmt.ExecContext(ctx,
-			symbol,
-			c["timestamp"],
-			c["open"],
-			c["high"],
-			c["low"],
-			c["close"],
-			c["volume"],
-		)
+
+	return database.New(c.db).WithPool(c.pool).BulkUpsert(ctx, "market_data",
+		[]string{"symbol", "timestamp", "open", "high", "low", "close", "volume"},
+		rows,
+		[]string{"symbol", "timestamp"},
+		[]string{"open", "high", "low", "close", "volume"},
+	)
+}
+
+// parseCandles reads the candles of a provider response for symbol
+func parseCandles(symbol string, data map[string]interface{}) ([]models.MarketData, error) {
+	raw, ok := data["candles"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response has no candles")
+	}
+
+	candles := make([]models.MarketData, 0, len(raw))
+	for i, item := range raw {
+		candle, err := parseCandle(symbol, item)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("candle %d: %v", i, err)
 		}
+		candles = append(candles, candle)
 	}
+	return candles, nil
+}
 
-	return tx.Commit()
+func parseCandle(symbol string, item interface{}) (models.MarketData, error) {
+	fields, ok := item.(map[string]interface{})
+	if !ok {
+		return models.MarketData{}, fmt.Errorf("not an object")
+	}
+
+	ts, ok := fields["timestamp"].(string)
+	if !ok {
+		return models.MarketData{}, fmt.Errorf("missing timestamp")
+	}
+	timestamp, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return models.MarketData{}, fmt.Errorf("invalid timestamp: %v", err)
+	}
+
+	candle := models.MarketData{Symbol: symbol, Timestamp: timestamp}
+	for name, dst := range map[string]*float64{
+		"open":   &candle.Open,
+		"high":   &candle.High,
+		"low":    &candle.Low,
+		"close":  &candle.Close,
+		"volume": &candle.Volume,
+	} {
+		value, ok := fields[name].(float64)
+		if !ok {
+			return models.MarketData{}, fmt.Errorf("missing %s", name)
+		}
+		*dst = value
+	}
+	return candle, nil
 }
@@ -0,0 +1,76 @@
+package market
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+func TestMarketDataCollector_DegradedCollection(t *testing.T) {
+	usage := monitoring.NewProviderUsage(nil, []monitoring.ProviderBudget{{Provider: "alphavantage", MonthlyRequests: 5}}, nil)
+	c := NewMarketDataCollector(nil, "alphavantage", "key", []string{"BTC", "ETH", "SPY"}, 5*time.Minute, nil).
+		WithUsage(usage, DegradedCollection{Symbols: []string{"SPY"}, Interval: time.Hour})
+
+	onTheHour := time.Date(2024, time.March, 12, 10, 0, 0, 0, time.UTC)
+	past := onTheHour.Add(5 * time.Minute)
+	call := monitoring.ProviderCall{Provider: "alphavantage", Endpoint: "candles", Component: collectorComponent}
+
+	for i := 0; i < 4; i++ {
+		usage.Record(call)
+	}
+	assert.Equal(t, []string{"BTC", "ETH", "SPY"}, c.plannedSymbols(past), "under budget")
+
+	// The budget's last request switches the collector to the reduced
+	// symbols, collected hourly
+	usage.Record(call)
+	assert.Empty(t, c.plannedSymbols(past))
+	assert.Equal(t, []string{"SPY"}, c.plannedSymbols(onTheHour))
+
+	// Without degraded symbols every symbol is still collected, less often
+	c.degraded.Symbols = nil
+	assert.Empty(t, c.plannedSymbols(past))
+	assert.Equal(t, []string{"BTC", "ETH", "SPY"}, c.plannedSymbols(onTheHour))
+
+	// A soft limit never degrades
+	usage.WithHardLimit(false)
+	assert.Equal(t, []string{"BTC", "ETH", "SPY"}, c.plannedSymbols(past))
+}
+
+func TestMarketDataCollector_CollectBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/v1/data/") {
+		case "BTC":
+			fmt.Fprint(w, `{"candles": [
+				{"timestamp": "2024-03-12T10:05:00Z", "open": 64100, "high": 64300, "low": 64000, "close": 64200, "volume": 12},
+				{"timestamp": "2024-03-12T10:00:00Z", "open": 64000, "high": 64200, "low": 63900, "close": 64100, "volume": 10}
+			]}`)
+		case "ETH":
+			fmt.Fprint(w, `{"candles": []}`)
+		default:
+			fmt.Fprint(w, `{"error": "unknown symbol"}`)
+		}
+	}))
+	defer server.Close()
+
+	c := NewMarketDataCollector(nil, "alphavantage", "key", nil, 5*time.Minute, nil)
+	c.baseURL = server.URL
+
+	// Each symbol gets its latest candle
+	batch, err := c.CollectBatch(context.Background(), []string{"BTC", "ETH"})
+	if assert.NoError(t, err) {
+		assert.Len(t, batch, 1)
+		assert.Equal(t, 64200.0, batch["BTC"].Close)
+		assert.Equal(t, time.Date(2024, 3, 12, 10, 5, 0, 0, time.UTC), batch["BTC"].Timestamp)
+	}
+
+	_, err = c.CollectBatch(context.Background(), []string{"BTC", "XYZ"})
+	assert.ErrorContains(t, err, "XYZ")
+}
@@ -0,0 +1,116 @@
+package market
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// DefaultCollectionJitter is the most a scheduled collection is delayed
+// past its boundary, so instances don't all hit the provider at once
+const DefaultCollectionJitter = 2 * time.Second
+
+// Clock is the time source a Schedule waits on
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Schedule runs work at the wall-clock boundaries of an interval: the top
+// of every minute for a one minute interval, :00, :05, :10 for five
+// minutes. Unlike a ticker started with the process, the grid doesn't move
+// when the process restarts.
+type Schedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	clock    Clock
+	// randDelay picks the jitter of a run from [0, n)
+	randDelay func(n int64) int64
+	logger    *logger.Logger
+}
+
+func NewSchedule(interval time.Duration, log *logger.Logger) *Schedule {
+	if log == nil {
+		log = logger.Default()
+	}
+	return &Schedule{
+		interval:  interval,
+		jitter:    DefaultCollectionJitter,
+		clock:     realClock{},
+		randDelay: rand.Int63n,
+		logger:    log,
+	}
+}
+
+// WithJitter delays each scheduled run by up to jitter past its boundary.
+// Zero runs exactly on the boundary.
+func (s *Schedule) WithJitter(jitter time.Duration) *Schedule {
+	s.jitter = jitter
+	return s
+}
+
+// Boundary returns the boundary at or before t
+func (s *Schedule) Boundary(t time.Time) time.Time {
+	return t.Truncate(s.interval)
+}
+
+// next returns the first boundary after t
+func (s *Schedule) next(t time.Time) time.Time {
+	return s.Boundary(t).Add(s.interval)
+}
+
+// wait returns a channel receiving once boundary, plus jitter, is reached
+func (s *Schedule) wait(boundary time.Time) <-chan time.Time {
+	delay := boundary.Sub(s.clock.Now())
+	if s.jitter > 0 {
+		delay += time.Duration(s.randDelay(int64(s.jitter)))
+	}
+	return s.clock.After(delay)
+}
+
+// Run calls run with each boundary until ctx is done or stop is closed. A
+// receive on trigger runs it at once, with the boundary already passed.
+// Runs never overlap: one still going at the next boundary skips that
+// boundary, and any others it overran, rather than queueing runs behind it.
+func (s *Schedule) Run(ctx context.Context, stop, trigger <-chan struct{}, run func(boundary time.Time, triggered bool)) error {
+	next := s.next(s.clock.Now())
+	wait := s.wait(next)
+
+	for {
+		scheduled := false
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-stop:
+			return nil
+		case <-trigger:
+			run(s.Boundary(s.clock.Now()), true)
+		case <-wait:
+			run(next, false)
+			next = next.Add(s.interval)
+			scheduled = true
+		}
+
+		if now := s.clock.Now(); !now.Before(next) {
+			upcoming := s.next(now)
+			s.logger.WithFields(map[string]interface{}{
+				"interval":      s.interval.String(),
+				"skipped":       int(upcoming.Sub(next) / s.interval),
+				"first_skipped": next,
+				"resume_at":     upcoming,
+			}).Warn("Run overran its interval, skipping boundaries")
+			next = upcoming
+			scheduled = true
+		}
+		if scheduled {
+			wait = s.wait(next)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package market
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	// delays receives the delay of every After call
+	delays chan time.Duration
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, delays: make(chan time.Duration, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.mu.Unlock()
+	c.delays <- d
+	return ch
+}
+
+// advance moves the clock on by d and fires the waiters now due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+func testSchedule(clock *fakeClock, interval time.Duration) *Schedule {
+	s := NewSchedule(interval, nil).WithJitter(0)
+	s.clock = clock
+	return s
+}
+
+func TestSchedule_Run(t *testing.T) {
+	start := time.Date(2024, 1, 2, 12, 0, 7, 0, time.UTC)
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 1, 2, hour, min, 0, 0, time.UTC)
+	}
+
+	t.Run("runs on boundaries and skips those it overran", func(t *testing.T) {
+		clock := newFakeClock(start)
+		s := testSchedule(clock, time.Minute)
+
+		runs := make(chan time.Time)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(ctx, nil, nil, func(boundary time.Time, triggered bool) {
+				assert.False(t, triggered)
+				if boundary.Equal(at(12, 2)) {
+					// Runs through the 12:03 and 12:04 boundaries
+					clock.advance(150 * time.Second)
+				}
+				runs <- boundary
+			})
+		}()
+
+		// Started 7s past the minute, the first run waits for 12:01
+		assert.Equal(t, 53*time.Second, <-clock.delays)
+		clock.advance(53 * time.Second)
+		assert.Equal(t, at(12, 1), <-runs)
+
+		assert.Equal(t, time.Minute, <-clock.delays)
+		clock.advance(time.Minute)
+		assert.Equal(t, at(12, 2), <-runs)
+
+		// The overrun ended at 12:04:30, so the next run is at 12:05
+		assert.Equal(t, 30*time.Second, <-clock.delays)
+		clock.advance(30 * time.Second)
+		assert.Equal(t, at(12, 5), <-runs)
+
+		<-clock.delays
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	t.Run("delays runs by the jitter", func(t *testing.T) {
+		clock := newFakeClock(start)
+		s := testSchedule(clock, time.Minute).WithJitter(2 * time.Second)
+		s.randDelay = func(n int64) int64 {
+			assert.Equal(t, int64(2*time.Second), n)
+			return int64(1500 * time.Millisecond)
+		}
+
+		runs := make(chan time.Time)
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(context.Background(), stop, nil, func(boundary time.Time, _ bool) {
+				runs <- boundary
+			})
+		}()
+
+		assert.Equal(t, 53*time.Second+1500*time.Millisecond, <-clock.delays)
+		clock.advance(53*time.Second + 1500*time.Millisecond)
+		// The run still belongs to the boundary, not the moment it began
+		assert.Equal(t, at(12, 1), <-runs)
+
+		assert.Equal(t, time.Minute, <-clock.delays)
+		close(stop)
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("runs at once when triggered", func(t *testing.T) {
+		clock := newFakeClock(start)
+		s := testSchedule(clock, time.Minute)
+
+		type call struct {
+			boundary  time.Time
+			triggered bool
+		}
+		runs := make(chan call)
+		trigger := make(chan struct{})
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(context.Background(), stop, trigger, func(boundary time.Time, triggered bool) {
+				runs <- call{boundary, triggered}
+			})
+		}()
+
+		<-clock.delays
+		trigger <- struct{}{}
+		assert.Equal(t, call{at(12, 0), true}, <-runs)
+
+		// The boundary already waited for still runs on time
+		clock.advance(53 * time.Second)
+		assert.Equal(t, call{at(12, 1), false}, <-runs)
+
+		<-clock.delays
+		close(stop)
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestAlignCandles(t *testing.T) {
+	base := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	candle := func(offset time.Duration, open, high, low, close, volume float64) models.MarketData {
+		return models.MarketData{
+			Symbol: "BTC", Timestamp: base.Add(offset),
+			Open: open, High: high, Low: low, Close: close, Volume: volume,
+		}
+	}
+
+	candles := []models.MarketData{
+		candle(5*time.Minute+30*time.Second, 103, 106, 102, 105, 20),
+		candle(7*time.Second, 100, 102, 99, 101, 10),
+		candle(2*time.Minute, 101, 104, 100, 103, 30),
+		// Still in progress at the boundary
+		candle(10*time.Minute+5*time.Second, 105, 107, 104, 106, 5),
+	}
+
+	aligned := alignCandles(candles, 5*time.Minute, base.Add(10*time.Minute))
+	require.Len(t, aligned, 2)
+
+	assert.Equal(t, base, aligned[0].Timestamp)
+	assert.Equal(t, 100.0, aligned[0].Open)
+	assert.Equal(t, 104.0, aligned[0].High)
+	assert.Equal(t, 99.0, aligned[0].Low)
+	assert.Equal(t, 103.0, aligned[0].Close)
+	assert.Equal(t, 40.0, aligned[0].Volume)
+
+	assert.Equal(t, base.Add(5*time.Minute), aligned[1].Timestamp)
+	assert.Equal(t, 105.0, aligned[1].Close)
+	assert.Equal(t, 20.0, aligned[1].Volume)
+
+	// The input is left as the provider returned it
+	assert.Equal(t, base.Add(5*time.Minute+30*time.Second), candles[0].Timestamp)
+}
@@ -4,10 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
 )
 
+// ErrPortfolioNotFound is returned for portfolios that don't exist, are
+// deleted or belong to another user
+var ErrPortfolioNotFound = errors.New("portfolio not found")
+
 type PortfolioService struct {
 	db *sql.DB
 }
@@ -45,4 +54,243 @@ func (s *PortfolioService) Create(ctx context.Context, portfolio *models.Portfol
 	}
 
 	return tx.Commit()
-}
\ No newline at end of file
+}
+
+// ForkPortfolio creates a paper trading portfolio named name holding the
+// same assets as sourceID, which userID must own. The fork's assets are
+// stored in paper_positions, so trading it leaves the source untouched.
+func (s *PortfolioService) ForkPortfolio(ctx context.Context, sourceID uuid.UUID, userID uuid.UUID, name string) (*models.Portfolio, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var source models.Portfolio
+	var description sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, description, total_value, paper_trading
+		FROM portfolios
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		FOR SHARE
+	`, sourceID, userID).Scan(&source.ID, &description, &source.TotalValue, &source.IsPaperTrading)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPortfolioNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio: %w", err)
+	}
+
+	assets, err := portfolioAssets(ctx, tx, source.ID, source.IsPaperTrading)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio assets: %w", err)
+	}
+
+	now := time.Now()
+	fork := &models.Portfolio{
+		ID:             uuid.New(),
+		UserID:         userID,
+		Name:           name,
+		Description:    description.String,
+		TotalValue:     source.TotalValue,
+		Assets:         assets,
+		IsPaperTrading: true,
+		ForkOfID:       source.ID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO portfolios (id, user_id, name, description, total_value, paper_trading, fork_of_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, TRUE, $6, $7, $7)
+	`, fork.ID, fork.UserID, fork.Name, fork.Description, fork.TotalValue, fork.ForkOfID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fork: %w", err)
+	}
+
+	for _, asset := range assets {
+		if err := insertAsset(ctx, tx, fork, asset); err != nil {
+			return nil, fmt.Errorf("failed to copy asset %s: %w", asset.Symbol, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+// UpsertPosition stores asset in the portfolio's positions table, replacing
+// the asset of the same symbol if it holds one, along with the portfolio's
+// total value
+func (s *PortfolioService) UpsertPosition(ctx context.Context, portfolio *models.Portfolio, asset models.Asset) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	table := models.PositionsTable(portfolio.IsPaperTrading)
+	res, err := tx.ExecContext(ctx, `
+		UPDATE `+table+`
+		SET type = $3, quantity = $4, avg_price = $5, value = $6, last_update = $7
+		WHERE portfolio_id = $1 AND symbol = $2
+	`, portfolio.ID, asset.Symbol, asset.Type, asset.Quantity, asset.AvgPrice, asset.Value, asset.LastUpdate)
+	if err != nil {
+		return fmt.Errorf("failed to update position: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		if err := insertAsset(ctx, tx, portfolio, asset); err != nil {
+			return fmt.Errorf("failed to add position: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE portfolios SET total_value = $2, updated_at = $3
+		WHERE id = $1
+	`, portfolio.ID, portfolio.TotalValue, portfolio.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update portfolio value: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// portfolioAssets reads a portfolio's assets from its positions table
+func portfolioAssets(ctx context.Context, tx *sql.Tx, portfolioID uuid.UUID, paperTrading bool) ([]models.Asset, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT symbol, type, quantity, avg_price, value, last_update
+		FROM `+models.PositionsTable(paperTrading)+`
+		WHERE portfolio_id = $1
+		ORDER BY symbol
+	`, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []models.Asset
+	for rows.Next() {
+		var asset models.Asset
+		if err := rows.Scan(&asset.Symbol, &asset.Type, &asset.Quantity, &asset.AvgPrice, &asset.Value, &asset.LastUpdate); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// insertAsset adds asset to the portfolio's positions table
+func insertAsset(ctx context.Context, tx *sql.Tx, portfolio *models.Portfolio, asset models.Asset) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO `+models.PositionsTable(portfolio.IsPaperTrading)+` (id, portfolio_id, symbol, type, quantity, avg_price, value, last_update)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, uuid.New(), portfolio.ID, asset.Symbol, asset.Type, asset.Quantity, asset.AvgPrice, asset.Value, asset.LastUpdate)
+	return err
+}
+
+// BulkDeletePortfolios soft-deletes the portfolios among ids that userID
+// owns, in one transaction. The others are reported as not found, or as
+// unauthorized when another user owns them.
+func (s *PortfolioService) BulkDeletePortfolios(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (*models.BulkDeleteResult, error) {
+	result := &models.BulkDeleteResult{NotFoundIDs: []uuid.UUID{}, UnauthorizedIDs: []uuid.UUID{}}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	requested := make([]string, len(ids))
+	for i, id := range ids {
+		requested[i] = id.String()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owned, err := portfolioIDs(ctx, tx, `
+		SELECT id FROM portfolios
+		WHERE id = ANY($1) AND user_id = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, pq.Array(requested), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify portfolio ownership: %w", err)
+	}
+
+	var others []string
+	for _, id := range ids {
+		if !owned[id] {
+			others = append(others, id.String())
+		}
+	}
+	existing := make(map[uuid.UUID]bool)
+	if len(others) > 0 {
+		existing, err = portfolioIDs(ctx, tx, `
+			SELECT id FROM portfolios
+			WHERE id = ANY($1) AND deleted_at IS NULL
+		`, pq.Array(others))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up portfolios: %w", err)
+		}
+	}
+
+	verified := make([]string, 0, len(owned))
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		switch {
+		case owned[id]:
+			verified = append(verified, id.String())
+		case existing[id]:
+			result.UnauthorizedIDs = append(result.UnauthorizedIDs, id)
+		default:
+			result.NotFoundIDs = append(result.NotFoundIDs, id)
+		}
+	}
+
+	if len(verified) > 0 {
+		now := time.Now()
+		res, err := tx.ExecContext(ctx, `
+			UPDATE portfolios SET deleted_at = $1, updated_at = $1
+			WHERE id = ANY($2) AND user_id = $3 AND deleted_at IS NULL
+		`, now, pq.Array(verified), userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete portfolios: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		result.DeletedCount = int(deleted)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func portfolioIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (map[uuid.UUID]bool, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -3,40 +3,102 @@ package portfolio
 import (
     "context"
     "database/sql"
+    "errors"
     "fmt"
     "math"
     "time"
 
+    "github.com/shopspring/decimal"
+    "gonum.org/v1/gonum/stat"
+
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/valuation"
+)
+
+const (
+    // DefaultMarketSymbol is the market proxy used for beta when none is configured
+    DefaultMarketSymbol = "SPY"
+    // defaultBetaWindow is the number of daily returns used for beta in AnalyzePortfolio
+    defaultBetaWindow = 90
+    // DefaultInformationRatioWindow is the number of daily returns used for
+    // the information ratio in AnalyzePortfolio
+    DefaultInformationRatioWindow = 90
 )
 
+// ErrInsufficientData is returned when there are too few observations for a
+// statistic. It is risk.ErrInsufficientData, so the risk manager tells a new
+// portfolio's information ratio from a failed one.
+var ErrInsufficientData = risk.ErrInsufficientData
+
+var errZeroMarketVariance = errors.New("market returns have zero variance")
+
 type PortfolioAnalyzer struct {
-    db *sql.DB
+    db           *sql.DB
+    marketSymbol string
+    valuers      *valuation.Registry
+    riskFree     risk.RiskFreeRateProvider
 }
 
 type PortfolioMetrics struct {
-    TotalValue     float64   `json:"total_value"`
-    PnL            float64   `json:"pnl"`
-    PnLPercentage  float64   `json:"pnl_percentage"`
-    Volatility     float64   `json:"volatility"`
-    SharpeRatio    float64   `json:"sharpe_ratio"`
-    LastUpdated    time.Time `json:"last_updated"`
+    TotalValue     decimal.Decimal `json:"total_value"`
+    PnL            decimal.Decimal `json:"pnl"`
+    PnLPercentage  float64         `json:"pnl_percentage"`
+    Volatility     float64         `json:"volatility"`
+    SharpeRatio    float64         `json:"sharpe_ratio"`
+    // RiskFreeRate is the annual rate SharpeRatio was measured against
+    RiskFreeRate float64 `json:"risk_free_rate"`
+    Beta           float64         `json:"beta"`
+    // InformationRatio is the annualised active return over the market
+    // symbol per unit of tracking error
+    InformationRatio float64   `json:"information_ratio"`
+    LastUpdated      time.Time `json:"last_updated"`
 }
 
 type PositionMetrics struct {
-    Symbol         string    `json:"symbol"`
-    Quantity       float64   `json:"quantity"`
-    CurrentPrice   float64   `json:"current_price"`
-    Value          float64   `json:"value"`
-    PnL            float64   `json:"pnl"`
-    PnLPercentage  float64   `json:"pnl_percentage"`
+    Symbol         string          `json:"symbol"`
+    AssetType      string          `json:"asset_type"`
+    Quantity       decimal.Decimal `json:"quantity"`
+    CurrentPrice   decimal.Decimal `json:"current_price"`
+    Value          decimal.Decimal `json:"value"`
+    PnL            decimal.Decimal `json:"pnl"`
+    PnLPercentage  float64         `json:"pnl_percentage"`
 }
 
 func NewPortfolioAnalyzer(db *sql.DB) *PortfolioAnalyzer {
-    return &PortfolioAnalyzer{db: db}
+    return &PortfolioAnalyzer{
+        db:           db,
+        marketSymbol: DefaultMarketSymbol,
+        valuers:      valuation.NewRegistry(db, NewDBPriceSource(db), valuation.DefaultCurrency),
+        riskFree:     risk.StaticRiskFreeRate(risk.DefaultRiskFreeRate),
+    }
+}
+
+// WithValuers values positions with valuers instead of the latest
+// market_data close of every symbol
+func (a *PortfolioAnalyzer) WithValuers(valuers *valuation.Registry) *PortfolioAnalyzer {
+    a.valuers = valuers
+    return a
+}
+
+// WithRiskFreeRate measures Sharpe ratios against the rate riskFree
+// provides
+func (a *PortfolioAnalyzer) WithRiskFreeRate(riskFree risk.RiskFreeRateProvider) *PortfolioAnalyzer {
+    a.riskFree = riskFree
+    return a
+}
+
+// WithMarketSymbol sets the market proxy used for beta calculations
+func (a *PortfolioAnalyzer) WithMarketSymbol(symbol string) *PortfolioAnalyzer {
+    if symbol != "" {
+        a.marketSymbol = symbol
+    }
+    return a
 }
 
 func (a *PortfolioAnalyzer) AnalyzePortfolio(ctx context.Context, portfolioID int64) (*PortfolioMetrics, error) {
+    defer monitoring.StartStage(ctx, monitoring.StageAnalytics)()
     positions, err := a.getPositions(ctx, portfolioID)
     if err != nil {
         return nil, err
@@ -47,7 +109,167 @@ func (a *PortfolioAnalyzer) AnalyzePortfolio(ctx context.Context, portfolioID in
         return nil, err
     }
 
-    return a.calculatePortfolioMetrics(ctx, positionMetrics)
+    metrics, err := a.calculatePortfolioMetrics(ctx, positionMetrics)
+    if err != nil {
+        return nil, err
+    }
+
+    // Beta and the information ratio need snapshot history; a new
+    // portfolio simply reports zero for both
+    beta, err := a.CalculateBeta(ctx, portfolioID, a.marketSymbol, defaultBetaWindow)
+    if err != nil && !errors.Is(err, ErrInsufficientData) {
+        return nil, fmt.Errorf("failed to calculate beta of portfolio %d: %w", portfolioID, err)
+    }
+    metrics.Beta = beta
+
+    ir, err := a.ComputeInformationRatio(ctx, portfolioID, a.marketSymbol, DefaultInformationRatioWindow)
+    if err != nil && !errors.Is(err, ErrInsufficientData) {
+        return nil, fmt.Errorf("failed to compute information ratio of portfolio %d: %w", portfolioID, err)
+    }
+    metrics.InformationRatio = ir
+
+    return metrics, nil
+}
+
+// CalculateBeta regresses the portfolio's daily returns, taken from
+// portfolio_snapshots, against the daily returns of marketSymbol over the
+// last window days. Market days are counted in marketSymbol's exchange
+// timezone. Beta is cov(portfolio, market) / var(market).
+func (a *PortfolioAnalyzer) CalculateBeta(ctx context.Context, portfolioID int64, marketSymbol string, window int) (float64, error) {
+    if window < 2 {
+        return 0, fmt.Errorf("beta window must be at least 2, got %d", window)
+    }
+
+    portfolioValues, marketCloses, err := a.benchmarkHistory(ctx, portfolioID, marketSymbol, window)
+    if err != nil {
+        return 0, err
+    }
+
+    beta, err := olsBeta(dailyReturns(portfolioValues), dailyReturns(marketCloses))
+    if errors.Is(err, errZeroMarketVariance) {
+        return 0, fmt.Errorf("market returns for %s have zero variance", marketSymbol)
+    }
+    return beta, err
+}
+
+// ComputeInformationRatio measures the portfolio's daily returns, taken
+// from portfolio_snapshots, against those of benchmarkSymbol over the last
+// window days. The active return is their difference, and the ratio is the
+// mean active return over its standard deviation, the tracking error,
+// annualised by sqrt(252). A portfolio without tracking error has a ratio
+// of zero.
+func (a *PortfolioAnalyzer) ComputeInformationRatio(ctx context.Context, portfolioID int64, benchmarkSymbol string, window int) (float64, error) {
+    if window < 2 {
+        return 0, fmt.Errorf("information ratio window must be at least 2, got %d", window)
+    }
+
+    portfolioValues, benchmarkCloses, err := a.benchmarkHistory(ctx, portfolioID, benchmarkSymbol, window)
+    if err != nil {
+        return 0, err
+    }
+    return informationRatio(dailyReturns(portfolioValues), dailyReturns(benchmarkCloses))
+}
+
+// benchmarkHistory returns the portfolio's last window+1 snapshot values
+// and benchmarkSymbol's closes on the same days, oldest first. Market days
+// are counted in benchmarkSymbol's exchange timezone.
+func (a *PortfolioAnalyzer) benchmarkHistory(ctx context.Context, portfolioID int64, benchmarkSymbol string, window int) ([]float64, []float64, error) {
+    query := `
+        WITH portfolio AS (
+            SELECT snapshot_date AS day, total_value
+            FROM portfolio_snapshots
+            WHERE portfolio_id = $1
+            ORDER BY snapshot_date DESC
+            LIMIT $3
+        ),
+        market_calendar AS (
+            SELECT COALESCE((SELECT timezone FROM symbol_metadata WHERE symbol = $2), 'UTC') AS timezone
+        ),
+        market AS (
+            SELECT DISTINCT ON (day) day, close
+            FROM (
+                SELECT (timestamp AT TIME ZONE c.timezone)::date AS day, timestamp, close
+                FROM market_data, market_calendar c
+                WHERE symbol = $2
+            ) closes
+            ORDER BY day, timestamp DESC
+        )
+        SELECT p.day, p.total_value, m.close
+        FROM portfolio p
+        JOIN market m ON m.day = p.day
+        ORDER BY p.day
+    `
+
+    // window returns need window+1 observations
+    rows, err := a.db.QueryContext(ctx, query, portfolioID, benchmarkSymbol, window+1)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+
+    var portfolioValues, benchmarkCloses []float64
+    for rows.Next() {
+        var day time.Time
+        var value, close float64
+        if err := rows.Scan(&day, &value, &close); err != nil {
+            return nil, nil, err
+        }
+        portfolioValues = append(portfolioValues, value)
+        benchmarkCloses = append(benchmarkCloses, close)
+    }
+    return portfolioValues, benchmarkCloses, rows.Err()
+}
+
+// informationRatio is the annualised mean of returns in excess of
+// benchmarkReturns over their standard deviation. The two must be aligned
+// period by period.
+func informationRatio(returns, benchmarkReturns []float64) (float64, error) {
+    if len(benchmarkReturns) < 2 || len(returns) != len(benchmarkReturns) {
+        return 0, ErrInsufficientData
+    }
+
+    active := make([]float64, len(returns))
+    for i := range returns {
+        active[i] = returns[i] - benchmarkReturns[i]
+    }
+
+    mean, trackingError := stat.MeanStdDev(active, nil)
+    if trackingError == 0 {
+        return 0, nil
+    }
+    return mean / trackingError * math.Sqrt(risk.TradingDaysPerYear), nil
+}
+
+// olsBeta is the OLS slope of returns regressed on marketReturns, which must
+// be aligned period by period
+func olsBeta(returns, marketReturns []float64) (float64, error) {
+    if len(marketReturns) < 2 || len(returns) != len(marketReturns) {
+        return 0, ErrInsufficientData
+    }
+
+    marketVariance := stat.Variance(marketReturns, nil)
+    if marketVariance == 0 {
+        return 0, errZeroMarketVariance
+    }
+
+    return stat.Covariance(returns, marketReturns, nil) / marketVariance, nil
+}
+
+// dailyReturns converts a price or value series into simple returns
+func dailyReturns(values []float64) []float64 {
+    if len(values) < 2 {
+        return nil
+    }
+
+    returns := make([]float64, 0, len(values)-1)
+    for i := 1; i < len(values); i++ {
+        if values[i-1] == 0 {
+            returns = append(returns, 0)
+            continue
+        }
+        returns = append(returns, (values[i]-values[i-1])/values[i-1])
+    }
+    return returns
 }
 
 func (a *PortfolioAnalyzer) getPositions(ctx context.Context, portfolioID int64) ([]models.Position, error) {
@@ -75,25 +297,40 @@ func (a *PortfolioAnalyzer) getPositions(ctx context.Context, portfolioID int64)
     return positions, nil
 }
 
+// analyzePositions values each position with the valuer of its asset type
 func (a *PortfolioAnalyzer) analyzePositions(ctx context.Context, positions []models.Position) ([]PositionMetrics, error) {
-    var metrics []PositionMetrics
+    symbols := make([]string, len(positions))
+    for i, pos := range positions {
+        symbols[i] = pos.Symbol
+    }
+    types, err := a.valuers.Types(ctx, symbols)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get asset types: %w", err)
+    }
 
+    var metrics []PositionMetrics
     for _, pos := range positions {
-        currentPrice, err := a.getLatestPrice(ctx, pos.Symbol)
+        currentPrice, err := a.valuers.Price(ctx, types[pos.Symbol], pos.Symbol)
         if err != nil {
             return nil, fmt.Errorf("failed to get price for %s: %v", pos.Symbol, err)
         }
 
-        value := pos.Quantity * currentPrice
-        pnl := value - (pos.Quantity * pos.EntryPrice)
-        pnlPercentage := (pnl / (pos.Quantity * pos.EntryPrice)) * 100
+        value := pos.MarketValue(currentPrice)
+        costBasis := pos.CostBasis()
+        pnl := value.Sub(costBasis)
+
+        var pnlPercentage float64
+        if !costBasis.IsZero() {
+            pnlPercentage = pnl.Div(costBasis).InexactFloat64() * 100
+        }
 
         metrics = append(metrics, PositionMetrics{
             Symbol:        pos.Symbol,
+            AssetType:     types[pos.Symbol],
             Quantity:      pos.Quantity,
             CurrentPrice:  currentPrice,
-            Value:        value,
-            PnL:          pnl,
+            Value:         value,
+            PnL:           pnl,
             PnLPercentage: pnlPercentage,
         })
     }
@@ -102,11 +339,11 @@ func (a *PortfolioAnalyzer) analyzePositions(ctx context.Context, positions []mo
 }
 
 func (a *PortfolioAnalyzer) calculatePortfolioMetrics(ctx context.Context, positions []PositionMetrics) (*PortfolioMetrics, error) {
-    var totalValue, totalPnL float64
+    totalValue, totalPnL := decimal.Zero, decimal.Zero
     
     for _, pos := range positions {
-        totalValue += pos.Value
-        totalPnL += pos.PnL
+        totalValue = totalValue.Add(pos.Value)
+        totalPnL = totalPnL.Add(pos.PnL)
     }
 
     volatility, err := a.calculateVolatility(ctx, positions)
@@ -114,20 +351,35 @@ func (a *PortfolioAnalyzer) calculatePortfolioMetrics(ctx context.Context, posit
         return nil, err
     }
 
-    // Calculate Sharpe ratio using risk-free rate of 2%
-    riskFreeRate := 0.02
-    sharpeRatio := (totalPnL/totalValue - riskFreeRate) / volatility
+    // Ratios are statistical, so they are computed in float64
+    value := models.DecimalToFloat(totalValue)
+    pnl := models.DecimalToFloat(totalPnL)
+
+    // The Sharpe ratio takes the return as annual, against the annual
+    // risk-free rate and the daily volatility annualized
+    riskFreeRate, err := a.riskFree.AnnualRiskFreeRate(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+    }
+    var sharpeRatio float64
+    if volatility > 0 {
+        sharpeRatio = (pnl/value - riskFreeRate) / (volatility * math.Sqrt(risk.TradingDaysPerYear))
+    }
 
     return &PortfolioMetrics{
         TotalValue:    totalValue,
         PnL:           totalPnL,
-        PnLPercentage: (totalPnL / (totalValue - totalPnL)) * 100,
+        PnLPercentage: (pnl / (value - pnl)) * 100,
         Volatility:    volatility,
         SharpeRatio:   sharpeRatio,
+        RiskFreeRate:  riskFreeRate,
         LastUpdated:   time.Now(),
     }, nil
 }
 
+// calculateVolatility returns the value-weighted 30-day volatility of the
+// positions whose asset type is volatile. Cash and stablecoins are left
+// out, rather than diluting it as zero-volatility holdings.
 func (a *PortfolioAnalyzer) calculateVolatility(ctx context.Context, positions []PositionMetrics) (float64, error) {
     // Calculate 30-day rolling volatility
     days := 30
@@ -138,11 +390,26 @@ func (a *PortfolioAnalyzer) calculateVolatility(ctx context.Context, positions [
         FROM market_data 
         WHERE symbol = $1 
         AND timestamp >= NOW() - INTERVAL '30 days'
+        AND close > 0
         ORDER BY timestamp DESC
     `
 
+    var volatileValue float64
+    for _, pos := range positions {
+        if a.valuers.Volatile(pos.AssetType) {
+            volatileValue += models.DecimalToFloat(pos.Value)
+        }
+    }
+    if volatileValue == 0 {
+        return 0, nil
+    }
+
     var totalVolatility float64
     for _, pos := range positions {
+        if !a.valuers.Volatile(pos.AssetType) {
+            continue
+        }
+
         rows, err := a.db.QueryContext(ctx, query, pos.Symbol)
         if err != nil {
             return 0, err
@@ -163,6 +430,9 @@ func (a *PortfolioAnalyzer) calculateVolatility(ctx context.Context, positions [
             prevClose = close
             i++
         }
+        if i < 3 {
+            return 0, fmt.Errorf("%w for %s", ErrInsufficientData, pos.Symbol)
+        }
 
         // Calculate standard deviation of returns
         var sum, sumSq float64
@@ -174,26 +444,9 @@ func (a *PortfolioAnalyzer) calculateVolatility(ctx context.Context, positions [
         variance := (sumSq / float64(i-1)) - (mean * mean)
         
         // Weight volatility by position value
-        weight := pos.Value / totalVolatility
+        weight := models.DecimalToFloat(pos.Value) / volatileValue
         totalVolatility += math.Sqrt(variance) * weight
     }
 
     return totalVolatility, nil
 }
-
-func (a *PortfolioAnalyzer) getLatestPrice(ctx context.Context, symbol string) (float64, error) {
-    query := `
-        SELECT close 
-        FROM market_data 
-        WHERE symbol = $1 
-        ORDER BY timestamp DESC 
-        LIMIT 1
-    `
-    
-    var price float64
-    if err := a.db.QueryRowContext(ctx, query, symbol).Scan(&price); err != nil {
-        return 0, err
-    }
-    
-    return price, nil
-}
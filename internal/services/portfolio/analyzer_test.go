@@ -3,12 +3,15 @@ package portfolio
 import (
     "context"
     "database/sql"
+    "math"
     "testing"
     "time"
 
     "github.com/DATA-DOG/go-sqlmock"
+    "github.com/shopspring/decimal"
     "github.com/stretchr/testify/assert"
-    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/valuation"
 )
 
 func TestPortfolioAnalyzer_AnalyzePortfolio(t *testing.T) {
@@ -33,6 +36,12 @@ func TestPortfolioAnalyzer_AnalyzePortfolio(t *testing.T) {
             WithArgs(portfolioID).
             WillReturnRows(positionRows)
 
+        mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+            WithArgs([]string{"AAPL", "GOOGL"}).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+                AddRow("AAPL", valuation.TypeStock).
+                AddRow("GOOGL", valuation.TypeStock))
+
         // Mock market data queries for current prices
         priceRows := sqlmock.NewRows([]string{"close"}).AddRow(160.0)
         mock.ExpectQuery("SELECT close FROM market_data WHERE symbol = (.+) ORDER BY timestamp DESC LIMIT 1").
@@ -65,16 +74,29 @@ func TestPortfolioAnalyzer_AnalyzePortfolio(t *testing.T) {
             WithArgs("GOOGL").
             WillReturnRows(historicalRows)
 
+        // Without snapshots there is too little history for beta or the
+        // information ratio, which are reported as zero
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, defaultBetaWindow+1).
+            WillReturnRows(sqlmock.NewRows([]string{"day", "total_value", "close"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, DefaultInformationRatioWindow+1).
+            WillReturnRows(sqlmock.NewRows([]string{"day", "total_value", "close"}))
+
         metrics, err := analyzer.AnalyzePortfolio(ctx, portfolioID)
         assert.NoError(t, err)
         assert.NotNil(t, metrics)
 
         // Verify calculated metrics
-        assert.InDelta(t, 16000.0, metrics.TotalValue, 0.01)  // (10 * 160) + (5 * 2900)
-        assert.InDelta(t, 600.0, metrics.PnL, 0.01)          // ((160-150)*10 + (2900-2800)*5)
-        assert.InDelta(t, 3.89, metrics.PnLPercentage, 0.01) // (600 / 15500) * 100
-        assert.InDelta(t, 0.015, metrics.Volatility, 0.01)   // Based on historical prices
-        assert.InDelta(t, 0.8, metrics.SharpeRatio, 0.1)     // (3.89 - 2) / (0.015 * sqrt(252))
+        assert.True(t, decimal.NewFromInt(16100).Equal(metrics.TotalValue)) // (10 * 160) + (5 * 2900)
+        assert.True(t, decimal.NewFromInt(600).Equal(metrics.PnL))           // ((160-150)*10 + (2900-2800)*5)
+        assert.InDelta(t, 3.87, metrics.PnLPercentage, 0.01) // (600 / 15500) * 100
+        // Value-weighted daily volatility of AAPL (0.01205) and GOOGL (0.00658)
+        assert.InDelta(t, 0.0071255, metrics.Volatility, 1e-6)
+        // A return of 600 / 16100 against the 2% risk-free rate, over the
+        // volatility annualized
+        sharpe := (600.0/16100 - risk.DefaultRiskFreeRate) / (0.0071255 * math.Sqrt(risk.TradingDaysPerYear))
+        assert.InDelta(t, sharpe, metrics.SharpeRatio, 1e-4)
     })
 
     t.Run("Handle empty portfolio", func(t *testing.T) {
@@ -83,12 +105,18 @@ func TestPortfolioAnalyzer_AnalyzePortfolio(t *testing.T) {
         mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
             WithArgs(portfolioID).
             WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, defaultBetaWindow+1).
+            WillReturnRows(sqlmock.NewRows([]string{"day", "total_value", "close"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, DefaultInformationRatioWindow+1).
+            WillReturnRows(sqlmock.NewRows([]string{"day", "total_value", "close"}))
 
         metrics, err := analyzer.AnalyzePortfolio(ctx, portfolioID)
         assert.NoError(t, err)
         assert.NotNil(t, metrics)
-        assert.Equal(t, 0.0, metrics.TotalValue)
-        assert.Equal(t, 0.0, metrics.PnL)
+        assert.True(t, metrics.TotalValue.IsZero())
+        assert.True(t, metrics.PnL.IsZero())
         assert.Equal(t, 0.0, metrics.Volatility)
     })
 
@@ -104,6 +132,104 @@ func TestPortfolioAnalyzer_AnalyzePortfolio(t *testing.T) {
         assert.Nil(t, metrics)
         assert.Equal(t, sql.ErrConnDone, err)
     })
+
+    t.Run("Handle beta query errors", func(t *testing.T) {
+        portfolioID := int64(4)
+
+        mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+            WithArgs(portfolioID).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, defaultBetaWindow+1).
+            WillReturnError(sql.ErrConnDone)
+
+        // A failed query isn't mistaken for too little history
+        metrics, err := analyzer.AnalyzePortfolio(ctx, portfolioID)
+        assert.ErrorIs(t, err, sql.ErrConnDone)
+        assert.Nil(t, metrics)
+    })
+
+    t.Run("Handle information ratio query errors", func(t *testing.T) {
+        portfolioID := int64(5)
+
+        mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+            WithArgs(portfolioID).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, defaultBetaWindow+1).
+            WillReturnRows(sqlmock.NewRows([]string{"day", "total_value", "close"}))
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, DefaultMarketSymbol, DefaultInformationRatioWindow+1).
+            WillReturnError(sql.ErrConnDone)
+
+        metrics, err := analyzer.AnalyzePortfolio(ctx, portfolioID)
+        assert.ErrorIs(t, err, sql.ErrConnDone)
+        assert.Nil(t, metrics)
+    })
+}
+
+func TestPortfolioAnalyzer_MixedPortfolio(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    analyzer := NewPortfolioAnalyzer(db)
+    portfolioID := int64(1)
+
+    mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+        WithArgs(portfolioID).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(1, portfolioID, "AAPL", 10.0, 150.0).
+            AddRow(2, portfolioID, "USD", 5000.0, 1.0).
+            AddRow(3, portfolioID, "USDC", 5000.0, 1.0))
+
+    // USDC has no row, and is recognised as a stablecoin
+    mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+        WithArgs([]string{"AAPL", "USD", "USDC"}).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+            AddRow("AAPL", valuation.TypeStock).
+            AddRow("USD", valuation.TypeCash))
+
+    // Cash needs no price, and USDC's price is only checked against its peg
+    mock.ExpectQuery("SELECT close FROM market_data WHERE symbol = (.+) ORDER BY timestamp DESC LIMIT 1").
+        WithArgs("AAPL").
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).AddRow(160.0))
+    mock.ExpectQuery("SELECT close FROM market_data WHERE symbol = (.+) ORDER BY timestamp DESC LIMIT 1").
+        WithArgs("USDC").
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).AddRow(0.999))
+
+    // Only the stock's volatility is measured
+    mock.ExpectQuery("SELECT close FROM market_data WHERE symbol = (.+) AND timestamp >= (.+)").
+        WithArgs("AAPL").
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).
+            AddRow(160.0).
+            AddRow(158.0).
+            AddRow(157.0).
+            AddRow(155.0))
+
+    positions, err := analyzer.getPositions(context.Background(), portfolioID)
+    if !assert.NoError(t, err) {
+        return
+    }
+    metrics, err := analyzer.analyzePositions(context.Background(), positions)
+    if !assert.NoError(t, err) {
+        return
+    }
+    if !assert.Len(t, metrics, 3) {
+        return
+    }
+    assert.True(t, decimal.NewFromInt(1600).Equal(metrics[0].Value))
+    assert.True(t, decimal.NewFromInt(5000).Equal(metrics[1].Value), "cash at face")
+    assert.True(t, decimal.NewFromInt(5000).Equal(metrics[2].Value), "stablecoin at its peg")
+    assert.Equal(t, valuation.TypeStablecoin, metrics[2].AssetType)
+
+    // The stock's volatility, undiluted by the cash and USDC
+    volatility, err := analyzer.calculateVolatility(context.Background(), metrics)
+    assert.NoError(t, err)
+    assert.InDelta(t, 0.002967, volatility, 1e-6)
+    assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestPortfolioAnalyzer_CalculateVolatility(t *testing.T) {
@@ -117,11 +243,12 @@ func TestPortfolioAnalyzer_CalculateVolatility(t *testing.T) {
     ctx := context.Background()
 
     t.Run("Calculate volatility for single position", func(t *testing.T) {
-        positions := []models.Position{
+        positions := []PositionMetrics{
             {
-                Symbol:     "AAPL",
-                Quantity:   10,
-                EntryPrice: 150,
+                Symbol:    "AAPL",
+                AssetType: valuation.TypeStock,
+                Quantity:  decimal.NewFromInt(10),
+                Value:     decimal.NewFromInt(1500),
             },
         }
 
@@ -142,11 +269,12 @@ func TestPortfolioAnalyzer_CalculateVolatility(t *testing.T) {
     })
 
     t.Run("Handle insufficient data points", func(t *testing.T) {
-        positions := []models.Position{
+        positions := []PositionMetrics{
             {
-                Symbol:     "AAPL",
-                Quantity:   10,
-                EntryPrice: 150,
+                Symbol:    "AAPL",
+                AssetType: valuation.TypeStock,
+                Quantity:  decimal.NewFromInt(10),
+                Value:     decimal.NewFromInt(1500),
             },
         }
 
@@ -163,3 +291,142 @@ func TestPortfolioAnalyzer_CalculateVolatility(t *testing.T) {
         assert.Equal(t, 0.0, volatility)
     })
 }
+
+func TestPortfolioAnalyzer_CalculateBeta(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    analyzer := NewPortfolioAnalyzer(db)
+    ctx := context.Background()
+
+    marketCloses := []float64{400, 404, 398, 410, 415, 409, 420, 418, 425, 430}
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    t.Run("Portfolio mirroring the market has beta of one", func(t *testing.T) {
+        portfolioID := int64(1)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"})
+        for i, close := range marketCloses {
+            // Synthetic portfolio holding exactly 25 units of the index
+            rows.AddRow(start.AddDate(0, 0, i), close*25, close)
+        }
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        beta, err := analyzer.CalculateBeta(ctx, portfolioID, "SPY", 30)
+        assert.NoError(t, err)
+        assert.InDelta(t, 1.0, beta, 0.01)
+    })
+
+    t.Run("Pure cash portfolio has zero beta", func(t *testing.T) {
+        portfolioID := int64(2)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"})
+        for i, close := range marketCloses {
+            rows.AddRow(start.AddDate(0, 0, i), 10000.0, close)
+        }
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        beta, err := analyzer.CalculateBeta(ctx, portfolioID, "SPY", 30)
+        assert.NoError(t, err)
+        assert.InDelta(t, 0.0, beta, 0.01)
+    })
+
+    t.Run("Handle insufficient snapshot history", func(t *testing.T) {
+        portfolioID := int64(3)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"}).
+            AddRow(start, 10000.0, 400.0)
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        beta, err := analyzer.CalculateBeta(ctx, portfolioID, "SPY", 30)
+        assert.ErrorIs(t, err, ErrInsufficientData)
+        assert.Equal(t, 0.0, beta)
+    })
+}
+
+func TestPortfolioAnalyzer_ComputeInformationRatio(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    analyzer := NewPortfolioAnalyzer(db)
+    ctx := context.Background()
+
+    benchmarkCloses := []float64{400, 404, 398, 410, 415, 409, 420, 418, 425, 430}
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    t.Run("Portfolio tracking the benchmark has a ratio of zero", func(t *testing.T) {
+        portfolioID := int64(1)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"})
+        for i, close := range benchmarkCloses {
+            rows.AddRow(start.AddDate(0, 0, i), close*25, close)
+        }
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        ir, err := analyzer.ComputeInformationRatio(ctx, portfolioID, "SPY", 30)
+        assert.NoError(t, err)
+        assert.InDelta(t, 0.0, ir, 1e-9)
+    })
+
+    t.Run("Consistent outperformance has a positive ratio", func(t *testing.T) {
+        portfolioID := int64(2)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"})
+        value := 10000.0
+        for i, close := range benchmarkCloses {
+            if i > 0 {
+                // 0.1% a day ahead of the benchmark, give or take 0.05%
+                active := 0.001 + 0.0005
+                if i%2 == 0 {
+                    active = 0.001 - 0.0005
+                }
+                value *= close/benchmarkCloses[i-1] + active
+            }
+            rows.AddRow(start.AddDate(0, 0, i), value, close)
+        }
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        ir, err := analyzer.ComputeInformationRatio(ctx, portfolioID, "SPY", 30)
+        assert.NoError(t, err)
+        assert.Greater(t, ir, 0.0)
+        // Mean active return of 0.106% over a 0.053% tracking error
+        assert.InDelta(t, 31.8, ir, 0.1)
+    })
+
+    t.Run("Handle insufficient snapshot history", func(t *testing.T) {
+        portfolioID := int64(3)
+
+        rows := sqlmock.NewRows([]string{"day", "total_value", "close"}).
+            AddRow(start, 10000.0, 400.0).
+            AddRow(start.AddDate(0, 0, 1), 10100.0, 404.0)
+
+        mock.ExpectQuery("WITH portfolio AS (.+) FROM portfolio_snapshots (.+) FROM market_data").
+            WithArgs(portfolioID, "SPY", 31).
+            WillReturnRows(rows)
+
+        ir, err := analyzer.ComputeInformationRatio(ctx, portfolioID, "SPY", 30)
+        assert.ErrorIs(t, err, ErrInsufficientData)
+        assert.Equal(t, 0.0, ir)
+    })
+}
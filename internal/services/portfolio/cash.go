@@ -0,0 +1,82 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// AdjustCash adds amount to the portfolio's cash in currency within the
+// caller's transaction, taking it away when amount is negative, and returns
+// the portfolio's cash afterwards. Unless the portfolio is margin-enabled it
+// may not hold less than zero of a currency it is taking cash from. Cash in
+// models.PortfolioCurrency is the portfolio's balance; other currencies are
+// kept in portfolio_cash.
+func AdjustCash(ctx context.Context, tx *sql.Tx, portfolioID int64, currency string, amount decimal.Decimal) (models.CashBalance, error) {
+    p := &models.Portfolio{ID: portfolioID}
+    query := `SELECT balance, margin_enabled FROM portfolios WHERE id = $1 FOR UPDATE`
+    if err := tx.QueryRowContext(ctx, query, portfolioID).Scan(&p.Balance, &p.MarginEnabled); err != nil {
+        return nil, fmt.Errorf("failed to lock portfolio %d: %w", portfolioID, err)
+    }
+    cash, err := LoadCash(ctx, tx, portfolioID, p.Balance)
+    if err != nil {
+        return nil, err
+    }
+    p.CashBalance = cash
+
+    currency = models.CashCurrency(currency)
+    held := p.Cash(currency)
+    p.AddCash(currency, amount)
+    if amount.IsNegative() && p.Cash(currency).IsNegative() && !p.MarginEnabled {
+        return nil, fmt.Errorf("%w: portfolio %d holds %s %s", ErrInsufficientBalance, portfolioID, held, currency)
+    }
+
+    if currency == models.PortfolioCurrency {
+        query = `UPDATE portfolios SET balance = $2, updated_at = NOW() WHERE id = $1`
+        _, err = tx.ExecContext(ctx, query, portfolioID, p.Balance)
+    } else {
+        query = `
+            INSERT INTO portfolio_cash (portfolio_id, currency, amount)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (portfolio_id, currency)
+            DO UPDATE SET amount = EXCLUDED.amount, updated_at = NOW()
+        `
+        _, err = tx.ExecContext(ctx, query, portfolioID, currency, p.Cash(currency))
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to update %s cash of portfolio %d: %w", currency, portfolioID, err)
+    }
+    return p.CashBalance, nil
+}
+
+// LoadCash returns the portfolio's cash in each currency, balance being its
+// cash in models.PortfolioCurrency
+func LoadCash(ctx context.Context, q queryer, portfolioID int64, balance decimal.Decimal) (models.CashBalance, error) {
+    cash := models.CashBalance{models.PortfolioCurrency: balance}
+    query := `
+        SELECT currency, amount
+        FROM portfolio_cash
+        WHERE portfolio_id = $1
+    `
+    rows, err := q.QueryContext(ctx, query, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load cash of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var currency string
+        var amount decimal.Decimal
+        if err := rows.Scan(&currency, &amount); err != nil {
+            return nil, err
+        }
+        if currency = models.CashCurrency(currency); currency != models.PortfolioCurrency {
+            cash[currency] = amount
+        }
+    }
+    return cash, rows.Err()
+}
@@ -0,0 +1,569 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/shopspring/decimal"
+)
+
+// Sources a portfolio's holdings are stored in. Until they are unified both
+// positions and assets hold them, and portfolios.total_value is their
+// denormalized value.
+const (
+    SourcePositions = "positions"
+    SourceAssets    = "assets"
+)
+
+// Severities of a DataInconsistency, by how far the stored value is off
+const (
+    SeverityLow    = "low"
+    SeverityMedium = "medium"
+    SeverityHigh   = "high"
+)
+
+// ResolutionConsistent resolves an inconsistency whose values agree again.
+// A repaired one is resolved with the source it was repaired from.
+const ResolutionConsistent = "consistent"
+
+const (
+    // DefaultConsistencyTolerance is the relative difference between the
+    // stored and computed value of a portfolio that is still consistent
+    DefaultConsistencyTolerance = 0.005
+    // Relative differences beyond which an inconsistency is of medium and
+    // of high severity
+    mediumInconsistency = 0.05
+    highInconsistency   = 0.25
+    // repairedAssetType is the type of assets a repair adds from
+    // positions, which don't record one
+    repairedAssetType = "unknown"
+)
+
+var (
+    // ErrUnknownHoldingsSource is returned when repairing from a source
+    // that is neither SourcePositions nor SourceAssets
+    ErrUnknownHoldingsSource = errors.New("unknown holdings source")
+    // ErrConsistencyPortfolioNotFound is returned when checking or
+    // repairing a portfolio that doesn't exist or is deleted
+    ErrConsistencyPortfolioNotFound = errors.New("portfolio not found")
+)
+
+// DataInconsistency is a portfolio whose stored total_value disagrees with
+// the value of its holdings in Source. Difference is relative to the
+// larger of the two values.
+type DataInconsistency struct {
+    ID            int64           `json:"id"`
+    PortfolioID   int64           `json:"portfolio_id"`
+    Source        string          `json:"source"`
+    StoredValue   decimal.Decimal `json:"stored_value"`
+    ComputedValue decimal.Decimal `json:"computed_value"`
+    Difference    float64         `json:"difference"`
+    Severity      string          `json:"severity"`
+    DetectedAt    time.Time       `json:"detected_at"`
+    LastSeenAt    time.Time       `json:"last_seen_at"`
+}
+
+// RepairResult is what a repair rewrote: the portfolio's total_value, from
+// PreviousValue to TotalValue, and the holdings of the other source
+type RepairResult struct {
+    PortfolioID   int64           `json:"portfolio_id"`
+    Source        string          `json:"source"`
+    PreviousValue decimal.Decimal `json:"previous_value"`
+    TotalValue    decimal.Decimal `json:"total_value"`
+    Added         int             `json:"added"`
+    Updated       int             `json:"updated"`
+    Removed       int             `json:"removed"`
+}
+
+// ConsistencyChecker compares each portfolio's stored total_value with the
+// value of its holdings in positions and in assets, both at the latest
+// close, and records where they disagree in data_inconsistencies. Holdings
+// without market data count for nothing in either source. Paper trading
+// portfolios hold copies of another's positions and are not checked.
+type ConsistencyChecker struct {
+    db        *sql.DB
+    tolerance float64
+    open      *prometheus.GaugeVec
+    now       func() time.Time
+}
+
+// NewConsistencyChecker treats relative differences up to tolerance as
+// consistent
+func NewConsistencyChecker(db *sql.DB, tolerance float64) *ConsistencyChecker {
+    return &ConsistencyChecker{db: db, tolerance: tolerance, now: time.Now}
+}
+
+// WithRegisterer exports the open inconsistencies by severity as
+// data_inconsistencies_open, refreshed after every run and repair
+func (c *ConsistencyChecker) WithRegisterer(reg prometheus.Registerer) *ConsistencyChecker {
+    c.open = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "data_inconsistencies_open",
+        Help: "Portfolios whose stored value disagrees with their holdings, by severity",
+    }, []string{"severity"})
+    reg.MustRegister(c.open)
+    return c
+}
+
+type storedHolding struct {
+    quantity  decimal.Decimal
+    costPrice decimal.Decimal
+}
+
+// storedHoldings is a portfolio as both sources hold it, with the latest
+// close of every symbol in either
+type storedHoldings struct {
+    totalValue decimal.Decimal
+    positions  map[string]storedHolding
+    assets     map[string]storedHolding
+    prices     map[string]decimal.Decimal
+}
+
+func (h *storedHoldings) source(source string) map[string]storedHolding {
+    if source == SourcePositions {
+        return h.positions
+    }
+    return h.assets
+}
+
+func (h *storedHoldings) value(holdings map[string]storedHolding) decimal.Decimal {
+    value := decimal.Zero
+    for symbol, holding := range holdings {
+        value = value.Add(holding.quantity.Mul(h.prices[symbol]))
+    }
+    return value.Round(eventDecimalPlaces)
+}
+
+// Run checks every portfolio. A portfolio that can't be checked is skipped
+// and reported in the error.
+func (c *ConsistencyChecker) Run(ctx context.Context) error {
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT id FROM portfolios
+        WHERE deleted_at IS NULL AND NOT paper_trading
+        ORDER BY id
+    `)
+    if err != nil {
+        return fmt.Errorf("failed to list portfolios: %w", err)
+    }
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return err
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    var failed int
+    var firstErr error
+    for _, id := range ids {
+        if _, err := c.CheckPortfolio(ctx, id); err != nil {
+            failed++
+            if firstErr == nil {
+                firstErr = err
+            }
+        }
+    }
+    if err := c.refreshOpen(ctx); err != nil {
+        return err
+    }
+    if failed > 0 {
+        return fmt.Errorf("failed to check %d of %d portfolios: %w", failed, len(ids), firstErr)
+    }
+    return nil
+}
+
+// CheckPortfolio compares the portfolio's stored value with both sources,
+// records an inconsistency for each source off by more than the tolerance
+// and resolves those that agree again. It returns the inconsistencies
+// found.
+func (c *ConsistencyChecker) CheckPortfolio(ctx context.Context, portfolioID int64) ([]DataInconsistency, error) {
+    holdings, err := loadStoredHoldings(ctx, c.db, portfolioID, false)
+    if err != nil {
+        return nil, err
+    }
+
+    now := c.now()
+    found := []DataInconsistency{}
+    for _, source := range []string{SourcePositions, SourceAssets} {
+        computed := holdings.value(holdings.source(source))
+        difference := relativeDifference(holdings.totalValue, computed)
+        if difference <= c.tolerance {
+            if err := resolveInconsistencies(ctx, c.db, portfolioID, source, ResolutionConsistent, now); err != nil {
+                return nil, err
+            }
+            continue
+        }
+
+        inconsistency := DataInconsistency{
+            PortfolioID:   portfolioID,
+            Source:        source,
+            StoredValue:   holdings.totalValue,
+            ComputedValue: computed,
+            Difference:    difference,
+            Severity:      inconsistencySeverity(difference),
+            LastSeenAt:    now,
+        }
+        if err := c.record(ctx, &inconsistency); err != nil {
+            return nil, err
+        }
+        found = append(found, inconsistency)
+    }
+    return found, nil
+}
+
+// record upserts the open inconsistency of its portfolio and source,
+// keeping when it was first detected
+func (c *ConsistencyChecker) record(ctx context.Context, i *DataInconsistency) error {
+    query := `
+        INSERT INTO data_inconsistencies (portfolio_id, source, stored_value, computed_value, difference, severity, detected_at, last_seen_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+        ON CONFLICT (portfolio_id, source) WHERE resolved_at IS NULL
+        DO UPDATE SET stored_value = EXCLUDED.stored_value, computed_value = EXCLUDED.computed_value,
+            difference = EXCLUDED.difference, severity = EXCLUDED.severity, last_seen_at = EXCLUDED.last_seen_at
+        RETURNING id, detected_at
+    `
+    err := c.db.QueryRowContext(ctx, query, i.PortfolioID, i.Source, i.StoredValue, i.ComputedValue,
+        i.Difference, i.Severity, i.LastSeenAt).Scan(&i.ID, &i.DetectedAt)
+    if err != nil {
+        return fmt.Errorf("failed to record %s inconsistency of portfolio %d: %w", i.Source, i.PortfolioID, err)
+    }
+    return nil
+}
+
+// Open returns the unresolved inconsistencies, the largest first
+func (c *ConsistencyChecker) Open(ctx context.Context) ([]DataInconsistency, error) {
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT id, portfolio_id, source, stored_value, computed_value, difference, severity, detected_at, last_seen_at
+        FROM data_inconsistencies
+        WHERE resolved_at IS NULL
+        ORDER BY difference DESC, id
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list data inconsistencies: %w", err)
+    }
+    defer rows.Close()
+
+    open := []DataInconsistency{}
+    for rows.Next() {
+        var i DataInconsistency
+        err := rows.Scan(&i.ID, &i.PortfolioID, &i.Source, &i.StoredValue, &i.ComputedValue,
+            &i.Difference, &i.Severity, &i.DetectedAt, &i.LastSeenAt)
+        if err != nil {
+            return nil, err
+        }
+        open = append(open, i)
+    }
+    return open, rows.Err()
+}
+
+// Repair takes source as authoritative for the portfolio's holdings: the
+// other source is rewritten to hold the same, and total_value and the
+// asset values are recomputed from them at the latest close. Positions a
+// repair changes are recorded as position.changed events. The portfolio's
+// open inconsistencies are resolved with source.
+func (c *ConsistencyChecker) Repair(ctx context.Context, portfolioID int64, source string) (*RepairResult, error) {
+    if source != SourcePositions && source != SourceAssets {
+        return nil, fmt.Errorf("%w %q", ErrUnknownHoldingsSource, source)
+    }
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    holdings, err := loadStoredHoldings(ctx, tx, portfolioID, true)
+    if err != nil {
+        return nil, err
+    }
+    now := c.now()
+    authoritative := holdings.source(source)
+    result := &RepairResult{
+        PortfolioID:   portfolioID,
+        Source:        source,
+        PreviousValue: holdings.totalValue,
+        TotalValue:    holdings.value(authoritative),
+    }
+
+    if source == SourcePositions {
+        err = repairAssets(ctx, tx, portfolioID, holdings, result, now)
+    } else {
+        err = repairPositions(ctx, tx, portfolioID, holdings, result, now)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.ExecContext(ctx, `UPDATE portfolios SET total_value = $2, updated_at = $3 WHERE id = $1`,
+        portfolioID, result.TotalValue, now)
+    if err != nil {
+        return nil, fmt.Errorf("failed to update value of portfolio %d: %w", portfolioID, err)
+    }
+    for _, s := range []string{SourcePositions, SourceAssets} {
+        if err := resolveInconsistencies(ctx, tx, portfolioID, s, source, now); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    if err := c.refreshOpen(ctx); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// repairAssets rewrites the portfolio's assets to hold its positions,
+// keeping the type of assets it already held
+func repairAssets(ctx context.Context, tx *sql.Tx, portfolioID int64, h *storedHoldings, result *RepairResult, now time.Time) error {
+    for _, symbol := range sortedSymbols(h.assets) {
+        if _, ok := h.positions[symbol]; ok {
+            continue
+        }
+        if _, err := tx.ExecContext(ctx, `DELETE FROM assets WHERE portfolio_id = $1 AND symbol = $2`, portfolioID, symbol); err != nil {
+            return fmt.Errorf("failed to remove asset %s: %w", symbol, err)
+        }
+        result.Removed++
+    }
+
+    for _, symbol := range sortedSymbols(h.positions) {
+        pos := h.positions[symbol]
+        value := pos.quantity.Mul(h.prices[symbol]).Round(eventDecimalPlaces)
+        if _, ok := h.assets[symbol]; ok {
+            _, err := tx.ExecContext(ctx, `
+                UPDATE assets SET quantity = $3, avg_price = $4, value = $5, last_update = $6
+                WHERE portfolio_id = $1 AND symbol = $2
+            `, portfolioID, symbol, pos.quantity, pos.costPrice, value, now)
+            if err != nil {
+                return fmt.Errorf("failed to update asset %s: %w", symbol, err)
+            }
+            result.Updated++
+            continue
+        }
+        _, err := tx.ExecContext(ctx, `
+            INSERT INTO assets (id, portfolio_id, symbol, type, quantity, avg_price, value, last_update)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        `, uuid.New(), portfolioID, symbol, repairedAssetType, pos.quantity, pos.costPrice, value, now)
+        if err != nil {
+            return fmt.Errorf("failed to add asset %s: %w", symbol, err)
+        }
+        result.Added++
+    }
+    return nil
+}
+
+// repairPositions rewrites the portfolio's positions to hold its assets,
+// and the asset values at the latest close
+func repairPositions(ctx context.Context, tx *sql.Tx, portfolioID int64, h *storedHoldings, result *RepairResult, now time.Time) error {
+    for _, symbol := range sortedSymbols(h.positions) {
+        if _, ok := h.assets[symbol]; ok {
+            continue
+        }
+        if _, err := tx.ExecContext(ctx, `DELETE FROM positions WHERE portfolio_id = $1 AND symbol = $2`, portfolioID, symbol); err != nil {
+            return fmt.Errorf("failed to remove position %s: %w", symbol, err)
+        }
+        if err := AppendEvent(ctx, tx, portfolioID, EventPositionChanged, PositionChange{Symbol: symbol}); err != nil {
+            return err
+        }
+        result.Removed++
+    }
+
+    for _, symbol := range sortedSymbols(h.assets) {
+        asset := h.assets[symbol]
+        value := asset.quantity.Mul(h.prices[symbol]).Round(eventDecimalPlaces)
+        _, err := tx.ExecContext(ctx, `UPDATE assets SET value = $3, last_update = $4 WHERE portfolio_id = $1 AND symbol = $2`,
+            portfolioID, symbol, value, now)
+        if err != nil {
+            return fmt.Errorf("failed to update asset %s: %w", symbol, err)
+        }
+
+        pos, ok := h.positions[symbol]
+        if ok && pos.quantity.Equal(asset.quantity) && pos.costPrice.Equal(asset.costPrice) {
+            continue
+        }
+        if ok {
+            _, err = tx.ExecContext(ctx, `UPDATE positions SET quantity = $3, entry_price = $4, updated_at = $5 WHERE portfolio_id = $1 AND symbol = $2`,
+                portfolioID, symbol, asset.quantity, asset.costPrice, now)
+            result.Updated++
+        } else {
+            _, err = tx.ExecContext(ctx, `INSERT INTO positions (portfolio_id, symbol, quantity, entry_price) VALUES ($1, $2, $3, $4)`,
+                portfolioID, symbol, asset.quantity, asset.costPrice)
+            result.Added++
+        }
+        if err != nil {
+            return fmt.Errorf("failed to write position %s: %w", symbol, err)
+        }
+        change := PositionChange{Symbol: symbol, Quantity: asset.quantity, EntryPrice: asset.costPrice}
+        if err := AppendEvent(ctx, tx, portfolioID, EventPositionChanged, change); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type execQueryer interface {
+    queryer
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func resolveInconsistencies(ctx context.Context, q execQueryer, portfolioID int64, source, resolution string, now time.Time) error {
+    _, err := q.ExecContext(ctx, `
+        UPDATE data_inconsistencies SET resolved_at = $4, resolution = $3
+        WHERE portfolio_id = $1 AND source = $2 AND resolved_at IS NULL
+    `, portfolioID, source, resolution, now)
+    if err != nil {
+        return fmt.Errorf("failed to resolve %s inconsistency of portfolio %d: %w", source, portfolioID, err)
+    }
+    return nil
+}
+
+// refreshOpen sets the open inconsistency gauge, when there is one
+func (c *ConsistencyChecker) refreshOpen(ctx context.Context) error {
+    if c.open == nil {
+        return nil
+    }
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT severity, COUNT(*) FROM data_inconsistencies
+        WHERE resolved_at IS NULL
+        GROUP BY severity
+    `)
+    if err != nil {
+        return fmt.Errorf("failed to count data inconsistencies: %w", err)
+    }
+    defer rows.Close()
+
+    counts := map[string]float64{SeverityLow: 0, SeverityMedium: 0, SeverityHigh: 0}
+    for rows.Next() {
+        var severity string
+        var count float64
+        if err := rows.Scan(&severity, &count); err != nil {
+            return err
+        }
+        counts[severity] = count
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    for severity, count := range counts {
+        c.open.WithLabelValues(severity).Set(count)
+    }
+    return nil
+}
+
+// loadStoredHoldings reads the portfolio's stored value, its holdings in
+// both sources and their latest closes. forUpdate locks the portfolio row.
+func loadStoredHoldings(ctx context.Context, q queryer, portfolioID int64, forUpdate bool) (*storedHoldings, error) {
+    h := &storedHoldings{
+        positions: make(map[string]storedHolding),
+        assets:    make(map[string]storedHolding),
+        prices:    make(map[string]decimal.Decimal),
+    }
+
+    query := `SELECT total_value FROM portfolios WHERE id = $1 AND deleted_at IS NULL`
+    if forUpdate {
+        query += ` FOR UPDATE`
+    }
+    err := q.QueryRowContext(ctx, query, portfolioID).Scan(&h.totalValue)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, fmt.Errorf("%w: %d", ErrConsistencyPortfolioNotFound, portfolioID)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to load portfolio %d: %w", portfolioID, err)
+    }
+
+    err = scanHoldings(ctx, q, h.positions, `SELECT symbol, quantity, entry_price FROM positions WHERE portfolio_id = $1`, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load positions of portfolio %d: %w", portfolioID, err)
+    }
+    err = scanHoldings(ctx, q, h.assets, `SELECT symbol, quantity, avg_price FROM assets WHERE portfolio_id = $1`, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load assets of portfolio %d: %w", portfolioID, err)
+    }
+
+    rows, err := q.QueryContext(ctx, `
+        SELECT DISTINCT ON (symbol) symbol, close
+        FROM market_data
+        WHERE symbol IN (
+            SELECT symbol FROM positions WHERE portfolio_id = $1
+            UNION SELECT symbol FROM assets WHERE portfolio_id = $1
+        )
+        ORDER BY symbol, timestamp DESC
+    `, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load prices of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var symbol string
+        var price decimal.Decimal
+        if err := rows.Scan(&symbol, &price); err != nil {
+            return nil, err
+        }
+        h.prices[symbol] = price
+    }
+    return h, rows.Err()
+}
+
+// scanHoldings adds the holdings query returns to holdings. A symbol held
+// more than once is summed.
+func scanHoldings(ctx context.Context, q queryer, holdings map[string]storedHolding, query string, portfolioID int64) error {
+    rows, err := q.QueryContext(ctx, query, portfolioID)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var symbol string
+        var h storedHolding
+        if err := rows.Scan(&symbol, &h.quantity, &h.costPrice); err != nil {
+            return err
+        }
+        if held, ok := holdings[symbol]; ok {
+            h.quantity = h.quantity.Add(held.quantity)
+        }
+        holdings[symbol] = h
+    }
+    return rows.Err()
+}
+
+// relativeDifference is how far apart stored and computed are, relative to
+// the larger of the two; zero when both are
+func relativeDifference(stored, computed decimal.Decimal) float64 {
+    scale := decimal.Max(stored.Abs(), computed.Abs())
+    if scale.IsZero() {
+        return 0
+    }
+    return stored.Sub(computed).Abs().Div(scale).InexactFloat64()
+}
+
+func inconsistencySeverity(difference float64) string {
+    switch {
+    case difference > highInconsistency:
+        return SeverityHigh
+    case difference > mediumInconsistency:
+        return SeverityMedium
+    default:
+        return SeverityLow
+    }
+}
+
+func sortedSymbols(holdings map[string]storedHolding) []string {
+    symbols := make([]string, 0, len(holdings))
+    for symbol := range holdings {
+        symbols = append(symbols, symbol)
+    }
+    sort.Strings(symbols)
+    return symbols
+}
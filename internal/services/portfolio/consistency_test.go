@@ -0,0 +1,211 @@
+package portfolio
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// expectInconsistentPortfolio seeds portfolio 7, stored at 15000: its
+// assets hold AAPL, MSFT and NVDA worth 15000, but NVDA is missing from its
+// positions, which hold AAPL at another entry price and a TSLA position the
+// assets lack
+func expectInconsistentPortfolio(mock sqlmock.Sqlmock, forUpdate bool) {
+    query := "SELECT total_value FROM portfolios WHERE id = \\$1 AND deleted_at IS NULL"
+    if forUpdate {
+        query += " FOR UPDATE"
+    }
+    mock.ExpectQuery(query).
+        WithArgs(int64(7)).
+        WillReturnRows(sqlmock.NewRows([]string{"total_value"}).AddRow("15000"))
+    mock.ExpectQuery("SELECT symbol, quantity, entry_price FROM positions").
+        WithArgs(int64(7)).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "quantity", "entry_price"}).
+            AddRow("AAPL", "10", "140").
+            AddRow("MSFT", "20", "300").
+            AddRow("TSLA", "1", "250"))
+    mock.ExpectQuery("SELECT symbol, quantity, avg_price FROM assets").
+        WithArgs(int64(7)).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "quantity", "avg_price"}).
+            AddRow("AAPL", "10", "150").
+            AddRow("MSFT", "20", "300").
+            AddRow("NVDA", "5", "900"))
+    mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, close FROM market_data").
+        WithArgs(int64(7)).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "close"}).
+            AddRow("AAPL", "200").
+            AddRow("MSFT", "400").
+            AddRow("NVDA", "1000").
+            AddRow("TSLA", "200"))
+}
+
+func TestConsistencyChecker_CheckPortfolio(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    checker := NewConsistencyChecker(db, DefaultConsistencyTolerance)
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    checker.now = func() time.Time { return now }
+    detected := now.Add(-24 * time.Hour)
+
+    expectInconsistentPortfolio(mock, false)
+    // The positions are worth 10200, a third less than stored
+    mock.ExpectQuery("INSERT INTO data_inconsistencies (.+) ON CONFLICT \\(portfolio_id, source\\) WHERE resolved_at IS NULL DO UPDATE").
+        WithArgs(int64(7), SourcePositions, "15000", "10200", sqlmock.AnyArg(), SeverityHigh, now).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "detected_at"}).AddRow(3, detected))
+    // The assets agree with the stored value
+    mock.ExpectExec("UPDATE data_inconsistencies SET resolved_at = \\$4, resolution = \\$3").
+        WithArgs(int64(7), SourceAssets, ResolutionConsistent, now).
+        WillReturnResult(sqlmock.NewResult(0, 0))
+
+    found, err := checker.CheckPortfolio(context.Background(), 7)
+    require.NoError(t, err)
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    require.Len(t, found, 1)
+    assert.Equal(t, int64(3), found[0].ID)
+    assert.Equal(t, SourcePositions, found[0].Source)
+    assert.Equal(t, "10200", found[0].ComputedValue.String())
+    assert.InDelta(t, 0.32, found[0].Difference, 1e-9)
+    assert.Equal(t, SeverityHigh, found[0].Severity)
+    assert.Equal(t, detected, found[0].DetectedAt, "first detection is kept")
+}
+
+func TestConsistencyChecker_Repair(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    newChecker := func(t *testing.T) (*ConsistencyChecker, sqlmock.Sqlmock) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        t.Cleanup(func() { db.Close() })
+        checker := NewConsistencyChecker(db, DefaultConsistencyTolerance)
+        checker.now = func() time.Time { return now }
+        return checker, mock
+    }
+    expectResolved := func(mock sqlmock.Sqlmock, source string) {
+        for _, s := range []string{SourcePositions, SourceAssets} {
+            mock.ExpectExec("UPDATE data_inconsistencies SET resolved_at").
+                WithArgs(int64(7), s, source, now).
+                WillReturnResult(sqlmock.NewResult(0, 1))
+        }
+    }
+    event := func(mock sqlmock.Sqlmock, payload string) {
+        mock.ExpectExec("SELECT id FROM portfolios WHERE id = \\$1 FOR UPDATE").
+            WithArgs(int64(7)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO portfolio_events").
+            WithArgs(int64(7), EventPositionChanged, []byte(payload), "system").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+    }
+
+    t.Run("From assets", func(t *testing.T) {
+        checker, mock := newChecker(t)
+        mock.ExpectBegin()
+        expectInconsistentPortfolio(mock, true)
+
+        // TSLA is closed, AAPL takes the assets' entry price and NVDA is
+        // opened, each recorded as an event
+        mock.ExpectExec("DELETE FROM positions").
+            WithArgs(int64(7), "TSLA").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        event(mock, `{"symbol":"TSLA","quantity":"0","entry_price":"0"}`)
+        mock.ExpectExec("UPDATE assets SET value = \\$3").
+            WithArgs(int64(7), "AAPL", "2000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE positions SET quantity = \\$3, entry_price = \\$4").
+            WithArgs(int64(7), "AAPL", "10", "150", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        event(mock, `{"symbol":"AAPL","quantity":"10","entry_price":"150"}`)
+        mock.ExpectExec("UPDATE assets SET value = \\$3").
+            WithArgs(int64(7), "MSFT", "8000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE assets SET value = \\$3").
+            WithArgs(int64(7), "NVDA", "5000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO positions").
+            WithArgs(int64(7), "NVDA", "5", "900").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        event(mock, `{"symbol":"NVDA","quantity":"5","entry_price":"900"}`)
+
+        mock.ExpectExec("UPDATE portfolios SET total_value = \\$2").
+            WithArgs(int64(7), "15000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        expectResolved(mock, SourceAssets)
+        mock.ExpectCommit()
+
+        result, err := checker.Repair(context.Background(), 7, SourceAssets)
+        require.NoError(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+        assert.Equal(t, "15000", result.PreviousValue.String())
+        assert.Equal(t, "15000", result.TotalValue.String())
+        assert.Equal(t, 1, result.Added)
+        assert.Equal(t, 1, result.Updated)
+        assert.Equal(t, 1, result.Removed)
+    })
+
+    t.Run("From positions", func(t *testing.T) {
+        checker, mock := newChecker(t)
+        mock.ExpectBegin()
+        expectInconsistentPortfolio(mock, true)
+
+        mock.ExpectExec("DELETE FROM assets").
+            WithArgs(int64(7), "NVDA").
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE assets SET quantity = \\$3, avg_price = \\$4, value = \\$5").
+            WithArgs(int64(7), "AAPL", "10", "140", "2000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("UPDATE assets SET quantity = \\$3, avg_price = \\$4, value = \\$5").
+            WithArgs(int64(7), "MSFT", "20", "300", "8000", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectExec("INSERT INTO assets").
+            WithArgs(sqlmock.AnyArg(), int64(7), "TSLA", repairedAssetType, "1", "250", "200", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        mock.ExpectExec("UPDATE portfolios SET total_value = \\$2").
+            WithArgs(int64(7), "10200", now).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+        expectResolved(mock, SourcePositions)
+        mock.ExpectCommit()
+
+        result, err := checker.Repair(context.Background(), 7, SourcePositions)
+        require.NoError(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+        assert.Equal(t, "10200", result.TotalValue.String())
+        assert.Equal(t, 1, result.Added)
+        assert.Equal(t, 2, result.Updated)
+        assert.Equal(t, 1, result.Removed)
+    })
+
+    t.Run("Unknown source", func(t *testing.T) {
+        checker, mock := newChecker(t)
+        _, err := checker.Repair(context.Background(), 7, "snapshots")
+        assert.True(t, errors.Is(err, ErrUnknownHoldingsSource))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestInconsistencySeverity(t *testing.T) {
+    for _, tc := range []struct {
+        stored, computed float64
+        severity         string
+    }{
+        {1000, 990, SeverityLow},
+        {1000, 900, SeverityMedium},
+        {1000, 0, SeverityHigh},
+        {0, 50, SeverityHigh},
+    } {
+        difference := relativeDifference(decimal.NewFromFloat(tc.stored), decimal.NewFromFloat(tc.computed))
+        assert.Equal(t, tc.severity, inconsistencySeverity(difference), "%v vs %v", tc.stored, tc.computed)
+    }
+    assert.Zero(t, relativeDifference(decimal.NewFromFloat(0), decimal.NewFromFloat(0)))
+}
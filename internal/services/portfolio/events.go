@@ -0,0 +1,504 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Portfolio event types
+const (
+    EventPortfolioCreated  = "portfolio.created"
+    EventPortfolioUpdated  = "portfolio.updated"
+    EventPositionChanged   = "position.changed"
+    EventTradeExecuted     = "trade.executed"
+    EventCashflowRecorded  = "cashflow.recorded"
+    EventPortfolioDeleted  = "portfolio.deleted"
+    EventPortfolioRestored = "portfolio.restored"
+)
+
+// eventDecimalPlaces is the scale of the DECIMAL(20,8) columns replayed
+// amounts are compared with
+const eventDecimalPlaces = 8
+
+// ErrNoPortfolioEvents is returned when replaying a portfolio with no
+// recorded events, such as one created before events were recorded
+var ErrNoPortfolioEvents = errors.New("no events recorded for portfolio")
+
+// PortfolioDetails is the payload of portfolio.created and
+// portfolio.updated. Only portfolio.created has positions.
+type PortfolioDetails struct {
+    Name        string           `json:"name"`
+    Description string           `json:"description"`
+    Balance     decimal.Decimal  `json:"balance"`
+    Risk        models.RiskLevel `json:"risk"`
+    Strategy    string           `json:"strategy"`
+    Positions   []PositionChange `json:"positions,omitempty"`
+    // MarginEnabled is left out for portfolios without margin, as it was
+    // before margin existed
+    MarginEnabled bool `json:"margin_enabled,omitempty"`
+}
+
+// NewPortfolioDetails returns the portfolio.created or portfolio.updated
+// payload of p, without positions
+func NewPortfolioDetails(p *models.Portfolio) PortfolioDetails {
+    return PortfolioDetails{
+        Name:          p.Name,
+        Description:   p.Description,
+        Balance:       p.Balance,
+        Risk:          p.Risk,
+        Strategy:      p.Strategy,
+        MarginEnabled: p.MarginEnabled,
+    }
+}
+
+// PositionChange is the payload of position.changed: the position as it
+// is after the change. A zero quantity closes it.
+type PositionChange struct {
+    Symbol     string          `json:"symbol"`
+    Quantity   decimal.Decimal `json:"quantity"`
+    EntryPrice decimal.Decimal `json:"entry_price"`
+}
+
+// CashflowChange is the payload of cashflow.recorded. trade.executed
+// carries a models.Trade, and the delete and restore events nothing.
+// Cashflows recorded before cash was kept per currency have no currency and
+// left the portfolio's cash alone.
+type CashflowChange struct {
+    Amount   decimal.Decimal `json:"amount"`
+    Currency string          `json:"currency,omitempty"`
+    At       time.Time       `json:"at"`
+}
+
+// PortfolioEvent is one recorded change to a portfolio
+type PortfolioEvent struct {
+    PortfolioID int64           `json:"portfolio_id"`
+    Seq         int64           `json:"seq"`
+    Type        string          `json:"type"`
+    Payload     json.RawMessage `json:"payload"`
+    Actor       string          `json:"actor"`
+    OccurredAt  time.Time       `json:"occurred_at"`
+}
+
+// AppendEvent records a change to a portfolio. It takes the caller's
+// transaction so the event commits together with the change, and locks
+// the portfolio row so its events are numbered in the order they commit.
+// The actor is the user handling the request in ctx, or "system".
+func AppendEvent(ctx context.Context, tx *sql.Tx, portfolioID int64, eventType string, payload interface{}) error {
+    data := []byte("{}")
+    if payload != nil {
+        var err error
+        if data, err = json.Marshal(payload); err != nil {
+            return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+        }
+    }
+
+    if _, err := tx.ExecContext(ctx, `SELECT id FROM portfolios WHERE id = $1 FOR UPDATE`, portfolioID); err != nil {
+        return fmt.Errorf("failed to lock portfolio %d: %w", portfolioID, err)
+    }
+
+    query := `
+        INSERT INTO portfolio_events (portfolio_id, seq, type, payload, actor)
+        SELECT $1, COALESCE(MAX(seq), 0) + 1, $2, $3, $4
+        FROM portfolio_events
+        WHERE portfolio_id = $1
+    `
+    if _, err := tx.ExecContext(ctx, query, portfolioID, eventType, data, eventActor(ctx)); err != nil {
+        return fmt.Errorf("failed to record %s event of portfolio %d: %w", eventType, portfolioID, err)
+    }
+    return nil
+}
+
+func eventActor(ctx context.Context) string {
+    if u, ok := ctx.Value("user").(*models.User); ok {
+        return u.Email
+    }
+    return "system"
+}
+
+// PortfolioState is a portfolio's holdings, either rebuilt from its events
+// or read from the live tables. Seq is the last event applied, and zero
+// for live state. Cash holds the cash in each currency, Balance included.
+// NetCashflow is deposits less withdrawals in models.PortfolioCurrency, and
+// BookValue the balance plus the cost basis of the positions.
+type PortfolioState struct {
+    PortfolioID int64                      `json:"portfolio_id"`
+    Seq         int64                      `json:"seq,omitempty"`
+    Name        string                     `json:"name"`
+    Description string                     `json:"description"`
+    Balance     decimal.Decimal            `json:"balance"`
+    Cash        models.CashBalance         `json:"cash_balance"`
+    Margin      bool                       `json:"margin_enabled"`
+    Risk        models.RiskLevel           `json:"risk"`
+    Strategy    string                     `json:"strategy"`
+    Positions   map[string]models.Position `json:"positions"`
+    NetCashflow decimal.Decimal            `json:"net_cashflow"`
+    BookValue   decimal.Decimal            `json:"book_value"`
+    Deleted     bool                       `json:"deleted"`
+}
+
+func newPortfolioState(portfolioID int64) *PortfolioState {
+    return &PortfolioState{
+        PortfolioID: portfolioID,
+        Cash:        models.CashBalance{models.PortfolioCurrency: decimal.Zero},
+        Positions:   make(map[string]models.Position),
+    }
+}
+
+func (s *PortfolioState) setPosition(change PositionChange) {
+    if change.Quantity.IsZero() {
+        delete(s.Positions, change.Symbol)
+        return
+    }
+    s.Positions[change.Symbol] = models.Position{
+        PortfolioID: s.PortfolioID,
+        Symbol:      change.Symbol,
+        Quantity:    change.Quantity,
+        EntryPrice:  change.EntryPrice,
+    }
+}
+
+func (s *PortfolioState) setDetails(d PortfolioDetails) {
+    s.Name = d.Name
+    s.Description = d.Description
+    s.Balance = d.Balance
+    s.Cash[models.PortfolioCurrency] = d.Balance
+    s.Margin = d.MarginEnabled
+    s.Risk = d.Risk
+    s.Strategy = d.Strategy
+}
+
+// portfolio returns a portfolio holding s's cash, for booking against
+func (s *PortfolioState) portfolio() *models.Portfolio {
+    return &models.Portfolio{ID: s.PortfolioID, Balance: s.Balance, CashBalance: s.Cash, MarginEnabled: s.Margin}
+}
+
+// apply changes s by one event. Trades are booked with ApplyTrade, so
+// replay recomputes balances and entry prices rather than trusting them.
+func (s *PortfolioState) apply(e PortfolioEvent) error {
+    switch e.Type {
+    case EventPortfolioCreated, EventPortfolioUpdated:
+        var d PortfolioDetails
+        if err := json.Unmarshal(e.Payload, &d); err != nil {
+            return err
+        }
+        s.setDetails(d)
+        for _, change := range d.Positions {
+            s.setPosition(change)
+        }
+
+    case EventPositionChanged:
+        var change PositionChange
+        if err := json.Unmarshal(e.Payload, &change); err != nil {
+            return err
+        }
+        s.setPosition(change)
+
+    case EventTradeExecuted:
+        var trade models.Trade
+        if err := json.Unmarshal(e.Payload, &trade); err != nil {
+            return err
+        }
+        p := s.portfolio()
+        pos := s.Positions[trade.Symbol]
+        if _, err := ApplyTrade(p, &pos, trade); err != nil {
+            return err
+        }
+        s.Balance, s.Cash = p.Balance, p.CashBalance
+        s.setPosition(PositionChange{Symbol: trade.Symbol, Quantity: pos.Quantity, EntryPrice: pos.EntryPrice})
+
+    case EventCashflowRecorded:
+        var flow CashflowChange
+        if err := json.Unmarshal(e.Payload, &flow); err != nil {
+            return err
+        }
+        if models.CashCurrency(flow.Currency) == models.PortfolioCurrency {
+            s.NetCashflow = s.NetCashflow.Add(flow.Amount)
+        }
+        if flow.Currency != "" {
+            p := s.portfolio()
+            p.AddCash(flow.Currency, flow.Amount)
+            s.Balance, s.Cash = p.Balance, p.CashBalance
+        }
+
+    case EventPortfolioDeleted:
+        s.Deleted = true
+
+    case EventPortfolioRestored:
+        s.Deleted = false
+
+    default:
+        return fmt.Errorf("unknown event type %q", e.Type)
+    }
+
+    s.Seq = e.Seq
+    return nil
+}
+
+func (s *PortfolioState) updateBookValue() {
+    s.BookValue = s.Balance
+    for _, pos := range s.Positions {
+        s.BookValue = s.BookValue.Add(pos.CostBasis())
+    }
+}
+
+// Discrepancy is one field where a portfolio's replayed state differs from
+// its live state. Symbol is set for position fields.
+type Discrepancy struct {
+    Field    string `json:"field"`
+    Symbol   string `json:"symbol,omitempty"`
+    Replayed string `json:"replayed"`
+    Live     string `json:"live"`
+}
+
+// ConsistencyReport compares a portfolio's replayed and live state
+type ConsistencyReport struct {
+    PortfolioID   int64           `json:"portfolio_id"`
+    CheckedAt     time.Time       `json:"checked_at"`
+    Consistent    bool            `json:"consistent"`
+    Replayed      *PortfolioState `json:"replayed"`
+    Live          *PortfolioState `json:"live"`
+    Discrepancies []Discrepancy   `json:"discrepancies"`
+}
+
+// queryer is satisfied by both the database and a transaction
+type queryer interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// EventLog reads portfolio events back, to replay portfolios and check
+// them against the live tables
+type EventLog struct {
+    db  *sql.DB
+    now func() time.Time
+}
+
+func NewEventLog(db *sql.DB) *EventLog {
+    return &EventLog{db: db, now: time.Now}
+}
+
+// Events returns the portfolio's events up to and including asOf, oldest
+// first
+func (l *EventLog) Events(ctx context.Context, portfolioID int64, asOf time.Time) ([]PortfolioEvent, error) {
+    return loadEvents(ctx, l.db, portfolioID, asOf)
+}
+
+// ReplayPortfolio rebuilds the portfolio's state from its events up to and
+// including asOf
+func (l *EventLog) ReplayPortfolio(ctx context.Context, portfolioID int64, asOf time.Time) (*PortfolioState, error) {
+    return replay(ctx, l.db, portfolioID, asOf)
+}
+
+// Verify replays all of the portfolio's events and reports where the
+// result differs from the live tables. Both are read from one snapshot, so
+// changes committed meanwhile can't show up as discrepancies.
+func (l *EventLog) Verify(ctx context.Context, portfolioID int64) (*ConsistencyReport, error) {
+    tx, err := l.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    replayed, err := replay(ctx, tx, portfolioID, time.Time{})
+    if err != nil {
+        return nil, err
+    }
+    live, err := liveState(ctx, tx, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+
+    discrepancies := diffStates(replayed, live)
+    return &ConsistencyReport{
+        PortfolioID:   portfolioID,
+        CheckedAt:     l.now(),
+        Consistent:    len(discrepancies) == 0,
+        Replayed:      replayed,
+        Live:          live,
+        Discrepancies: discrepancies,
+    }, nil
+}
+
+// loadEvents returns the portfolio's events oldest first, all of them when
+// asOf is zero
+func loadEvents(ctx context.Context, q queryer, portfolioID int64, asOf time.Time) ([]PortfolioEvent, error) {
+    query := `
+        SELECT seq, type, payload, actor, occurred_at
+        FROM portfolio_events
+        WHERE portfolio_id = $1
+    `
+    args := []interface{}{portfolioID}
+    if !asOf.IsZero() {
+        query += ` AND occurred_at <= $2`
+        args = append(args, asOf)
+    }
+    query += ` ORDER BY seq`
+
+    rows, err := q.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load events of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+
+    var events []PortfolioEvent
+    for rows.Next() {
+        e := PortfolioEvent{PortfolioID: portfolioID}
+        var payload []byte
+        if err := rows.Scan(&e.Seq, &e.Type, &payload, &e.Actor, &e.OccurredAt); err != nil {
+            return nil, err
+        }
+        e.Payload = json.RawMessage(payload)
+        events = append(events, e)
+    }
+    return events, rows.Err()
+}
+
+func replay(ctx context.Context, q queryer, portfolioID int64, asOf time.Time) (*PortfolioState, error) {
+    events, err := loadEvents(ctx, q, portfolioID, asOf)
+    if err != nil {
+        return nil, err
+    }
+    if len(events) == 0 {
+        return nil, ErrNoPortfolioEvents
+    }
+
+    state := newPortfolioState(portfolioID)
+    for _, e := range events {
+        if err := state.apply(e); err != nil {
+            return nil, fmt.Errorf("failed to replay event %d (%s) of portfolio %d: %w", e.Seq, e.Type, portfolioID, err)
+        }
+    }
+    state.updateBookValue()
+    return state, nil
+}
+
+// liveState reads the portfolio's state from portfolios, portfolio_cash,
+// positions and portfolio_cashflows
+func liveState(ctx context.Context, q queryer, portfolioID int64) (*PortfolioState, error) {
+    state := newPortfolioState(portfolioID)
+
+    var deletedAt sql.NullTime
+    query := `
+        SELECT name, description, balance, margin_enabled, risk, strategy, deleted_at
+        FROM portfolios
+        WHERE id = $1
+    `
+    err := q.QueryRowContext(ctx, query, portfolioID).Scan(
+        &state.Name, &state.Description, &state.Balance, &state.Margin, &state.Risk, &state.Strategy, &deletedAt,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to load portfolio %d: %w", portfolioID, err)
+    }
+    state.Deleted = deletedAt.Valid
+    if state.Cash, err = LoadCash(ctx, q, portfolioID, state.Balance); err != nil {
+        return nil, err
+    }
+
+    query = `
+        SELECT symbol, quantity, entry_price
+        FROM positions
+        WHERE portfolio_id = $1
+    `
+    rows, err := q.QueryContext(ctx, query, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load positions of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var change PositionChange
+        if err := rows.Scan(&change.Symbol, &change.Quantity, &change.EntryPrice); err != nil {
+            return nil, err
+        }
+        state.setPosition(change)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    query = `SELECT COALESCE(SUM(amount), 0) FROM portfolio_cashflows WHERE portfolio_id = $1 AND currency = $2`
+    if err := q.QueryRowContext(ctx, query, portfolioID, models.PortfolioCurrency).Scan(&state.NetCashflow); err != nil {
+        return nil, fmt.Errorf("failed to load cashflows of portfolio %d: %w", portfolioID, err)
+    }
+
+    state.updateBookValue()
+    return state, nil
+}
+
+// diffStates lists the fields where replayed and live differ. Amounts are
+// compared at the scale they're stored at, and a position missing on one
+// side is compared as zero.
+func diffStates(replayed, live *PortfolioState) []Discrepancy {
+    discrepancies := []Discrepancy{}
+    text := func(field, symbol, r, l string) {
+        if r != l {
+            discrepancies = append(discrepancies, Discrepancy{Field: field, Symbol: symbol, Replayed: r, Live: l})
+        }
+    }
+    amount := func(field, symbol string, r, l decimal.Decimal) {
+        r, l = r.Round(eventDecimalPlaces), l.Round(eventDecimalPlaces)
+        if !r.Equal(l) {
+            discrepancies = append(discrepancies, Discrepancy{Field: field, Symbol: symbol, Replayed: r.String(), Live: l.String()})
+        }
+    }
+
+    text("name", "", replayed.Name, live.Name)
+    text("description", "", replayed.Description, live.Description)
+    amount("balance", "", replayed.Balance, live.Balance)
+    text("margin_enabled", "", fmt.Sprint(replayed.Margin), fmt.Sprint(live.Margin))
+    text("risk", "", string(replayed.Risk), string(live.Risk))
+    text("strategy", "", replayed.Strategy, live.Strategy)
+    amount("net_cashflow", "", replayed.NetCashflow, live.NetCashflow)
+    text("deleted", "", fmt.Sprint(replayed.Deleted), fmt.Sprint(live.Deleted))
+
+    // Cash in the portfolio currency is compared as the balance
+    for _, currency := range currencies(replayed.Cash, live.Cash) {
+        if currency != models.PortfolioCurrency {
+            amount("cash_balance", currency, replayed.Cash[currency], live.Cash[currency])
+        }
+    }
+
+    symbols := make(map[string]bool)
+    for symbol := range replayed.Positions {
+        symbols[symbol] = true
+    }
+    for symbol := range live.Positions {
+        symbols[symbol] = true
+    }
+    sorted := make([]string, 0, len(symbols))
+    for symbol := range symbols {
+        sorted = append(sorted, symbol)
+    }
+    sort.Strings(sorted)
+
+    for _, symbol := range sorted {
+        r, l := replayed.Positions[symbol], live.Positions[symbol]
+        amount("position.quantity", symbol, r.Quantity, l.Quantity)
+        amount("position.entry_price", symbol, r.EntryPrice, l.EntryPrice)
+    }
+    return discrepancies
+}
+
+// currencies returns the currencies cash is held in on either side, sorted
+func currencies(a, b models.CashBalance) []string {
+    seen := make(map[string]bool, len(a)+len(b))
+    var sorted []string
+    for _, cash := range []models.CashBalance{a, b} {
+        for currency := range cash {
+            if !seen[currency] {
+                seen[currency] = true
+                sorted = append(sorted, currency)
+            }
+        }
+    }
+    sort.Strings(sorted)
+    return sorted
+}
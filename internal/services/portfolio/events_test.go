@@ -0,0 +1,142 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql/driver"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+var eventColumns = []string{"seq", "type", "payload", "actor", "occurred_at"}
+
+// eventRows is a portfolio created with AAPL, then a buy of more AAPL, a
+// deposit from before cash was kept per currency, an MSFT position added by
+// hand and a EUR deposit
+func eventRows(start time.Time) [][]driver.Value {
+    return [][]driver.Value{
+        {1, EventPortfolioCreated, []byte(`{"name": "Core", "balance": "10000", "risk": "medium",
+            "positions": [{"symbol": "AAPL", "quantity": "10", "entry_price": "150"}]}`), "user@example.com", start},
+        {2, EventTradeExecuted, []byte(`{"symbol": "AAPL", "side": "buy", "quantity": "5", "price": "160", "fee": "1"}`),
+            "user@example.com", start.Add(time.Hour)},
+        {3, EventCashflowRecorded, []byte(`{"amount": "500", "at": "2024-03-01T10:00:00Z"}`), "system", start.Add(2 * time.Hour)},
+        {4, EventPositionChanged, []byte(`{"symbol": "MSFT", "quantity": "2", "entry_price": "400"}`),
+            "admin@example.com", start.Add(3 * time.Hour)},
+        {5, EventCashflowRecorded, []byte(`{"amount": "250", "currency": "EUR", "at": "2024-03-01T13:00:00Z"}`),
+            "user@example.com", start.Add(4 * time.Hour)},
+    }
+}
+
+func TestEventLog_ReplayPortfolio(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+    asOf := start.Add(90 * time.Minute)
+    rows := sqlmock.NewRows(eventColumns)
+    for _, row := range eventRows(start)[:2] {
+        rows.AddRow(row...)
+    }
+    mock.ExpectQuery("SELECT seq, type, payload, actor, occurred_at FROM portfolio_events").
+        WithArgs(int64(7), asOf).
+        WillReturnRows(rows)
+
+    state, err := NewEventLog(db).ReplayPortfolio(context.Background(), 7, asOf)
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // The buy is booked again: 801 out of cash, AAPL at its average cost
+    assert.Equal(t, int64(2), state.Seq)
+    assert.Equal(t, "9199", state.Balance.String())
+    if !assert.Len(t, state.Positions, 1) {
+        return
+    }
+    aapl := state.Positions["AAPL"]
+    assert.Equal(t, "15", aapl.Quantity.String())
+    assert.Equal(t, "153.33333333", aapl.EntryPrice.Round(eventDecimalPlaces).String())
+    assert.Equal(t, "11499", state.BookValue.Round(eventDecimalPlaces).String())
+    assert.True(t, state.NetCashflow.IsZero())
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventLog_Verify(t *testing.T) {
+    start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+
+    verify := func(aaplQuantity, eurCash string) *ConsistencyReport {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        events := sqlmock.NewRows(eventColumns)
+        for _, row := range eventRows(start) {
+            events.AddRow(row...)
+        }
+        mock.ExpectBegin()
+        mock.ExpectQuery("SELECT seq, type, payload, actor, occurred_at FROM portfolio_events").
+            WithArgs(int64(7)).
+            WillReturnRows(events)
+        mock.ExpectQuery("SELECT name, description, balance, margin_enabled, risk, strategy, deleted_at FROM portfolios").
+            WithArgs(int64(7)).
+            WillReturnRows(sqlmock.NewRows([]string{"name", "description", "balance", "margin_enabled", "risk", "strategy", "deleted_at"}).
+                AddRow("Core", "", "9199", false, "medium", "", nil))
+        mock.ExpectQuery("SELECT currency, amount FROM portfolio_cash").
+            WithArgs(int64(7)).
+            WillReturnRows(sqlmock.NewRows([]string{"currency", "amount"}).AddRow("EUR", eurCash))
+        mock.ExpectQuery("SELECT symbol, quantity, entry_price FROM positions").
+            WithArgs(int64(7)).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "quantity", "entry_price"}).
+                AddRow("AAPL", aaplQuantity, "153.33333333").
+                AddRow("MSFT", "2", "400"))
+        mock.ExpectQuery("SELECT COALESCE(.+) FROM portfolio_cashflows").
+            WithArgs(int64(7), "USD").
+            WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("500"))
+        mock.ExpectRollback()
+
+        log := NewEventLog(db)
+        log.now = func() time.Time { return start.Add(24 * time.Hour) }
+        report, err := log.Verify(context.Background(), 7)
+        assert.NoError(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+        return report
+    }
+
+    // Stored entry prices are rounded to the column's scale, which isn't a
+    // discrepancy
+    report := verify("15", "250")
+    if !assert.NotNil(t, report) {
+        return
+    }
+    assert.True(t, report.Consistent)
+    assert.Empty(t, report.Discrepancies)
+
+    // A position row changed behind the event log's back is caught
+    report = verify("25", "250")
+    if !assert.NotNil(t, report) {
+        return
+    }
+    assert.False(t, report.Consistent)
+    assert.Equal(t, []Discrepancy{
+        {Field: "position.quantity", Symbol: "AAPL", Replayed: "15", Live: "25"},
+    }, report.Discrepancies)
+    assert.Equal(t, "15", report.Replayed.Positions["AAPL"].Quantity.String())
+    assert.Equal(t, "25", report.Live.Positions["AAPL"].Quantity.String())
+
+    // So is cash moved without a cashflow. The deposit without a currency
+    // never moved the balance, so the portfolio currency is consistent.
+    report = verify("15", "300")
+    if !assert.NotNil(t, report) {
+        return
+    }
+    assert.Equal(t, []Discrepancy{
+        {Field: "cash_balance", Symbol: "EUR", Replayed: "250", Live: "300"},
+    }, report.Discrepancies)
+    assert.Equal(t, "250", report.Replayed.Cash["EUR"].String())
+}
@@ -0,0 +1,211 @@
+package portfolio
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+
+    "gonum.org/v1/gonum/stat"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+)
+
+// ExpectedReturnMethod selects how expected returns are estimated for
+// optimization
+type ExpectedReturnMethod string
+
+const (
+    // ReturnsHistorical is the arithmetic mean of daily returns
+    ReturnsHistorical ExpectedReturnMethod = "historical"
+    // ReturnsEWMA is the exponentially weighted mean of daily returns
+    ReturnsEWMA ExpectedReturnMethod = "ewma"
+    // ReturnsCAPM is rf + beta * (rm - rf) against the market symbol
+    ReturnsCAPM ExpectedReturnMethod = "capm"
+)
+
+const DefaultEWMAHalfLifeDays = 30
+
+var ErrUnknownReturnMethod = errors.New("unknown expected return method")
+
+// Valid reports whether m is a known method. The empty method is valid and
+// means the optimizer's configured default.
+func (m ExpectedReturnMethod) Valid() bool {
+    switch m {
+    case "", ReturnsHistorical, ReturnsEWMA, ReturnsCAPM:
+        return true
+    }
+    return false
+}
+
+type OptimizerConfig struct {
+    ExpectedReturnMethod ExpectedReturnMethod
+    // EWMAHalfLifeDays is the age in days at which a return's EWMA weight halves
+    EWMAHalfLifeDays float64
+    // MarketSymbol is the market proxy for CAPM betas
+    MarketSymbol string
+}
+
+// WithConfig overrides the optimizer defaults with the non-zero fields of config
+func (o *PortfolioOptimizer) WithConfig(config OptimizerConfig) *PortfolioOptimizer {
+    if config.ExpectedReturnMethod != "" {
+        o.config.ExpectedReturnMethod = config.ExpectedReturnMethod
+    }
+    if config.EWMAHalfLifeDays > 0 {
+        o.config.EWMAHalfLifeDays = config.EWMAHalfLifeDays
+    }
+    if config.MarketSymbol != "" {
+        o.config.MarketSymbol = config.MarketSymbol
+    }
+    return o
+}
+
+// WithSamplePolicy sets the minimum daily returns of a symbol whose expected
+// return is estimated
+func (o *PortfolioOptimizer) WithSamplePolicy(policy sample.Policy) *PortfolioOptimizer {
+    o.samples = policy
+    return o
+}
+
+// ComputeExpectedReturns estimates the daily expected return of each symbol
+// with method, or the configured default if method is empty. Symbols with
+// fewer daily returns than the sample.ExpectedReturn minimum are left nil,
+// with a note saying so.
+func (o *PortfolioOptimizer) ComputeExpectedReturns(ctx context.Context, symbols []string, method ExpectedReturnMethod) ([]*float64, []sample.Note, error) {
+    if !method.Valid() {
+        return nil, nil, fmt.Errorf("%w: %q", ErrUnknownReturnMethod, method)
+    }
+
+    returns, err := o.getHistoricalReturns(ctx, symbols)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return o.guardedExpectedReturns(ctx, symbols, returns, method)
+}
+
+// guardedExpectedReturns estimates the expected returns of the symbols with
+// enough history, leaving the others nil
+func (o *PortfolioOptimizer) guardedExpectedReturns(ctx context.Context, symbols []string, returns [][]float64, method ExpectedReturnMethod) ([]*float64, []sample.Note, error) {
+    var notes []sample.Note
+    var measured []int
+    var measuredSymbols []string
+    var measuredReturns [][]float64
+    for i, r := range returns {
+        if note := o.samples.Check(sample.ExpectedReturn, symbols[i], len(r)); note != nil {
+            notes = append(notes, *note)
+            continue
+        }
+        measured = append(measured, i)
+        measuredSymbols = append(measuredSymbols, symbols[i])
+        measuredReturns = append(measuredReturns, r)
+    }
+
+    expected := make([]*float64, len(symbols))
+    if len(measured) == 0 {
+        return expected, notes, nil
+    }
+    estimates, err := o.expectedReturns(ctx, measuredSymbols, measuredReturns, method)
+    if err != nil {
+        return nil, nil, err
+    }
+    for j, i := range measured {
+        expected[i] = &estimates[j]
+    }
+    return expected, notes, nil
+}
+
+// checkHistory returns ErrInsufficientData for the first symbol with too
+// few daily returns to estimate its expected return. Optimizing needs an
+// expected return for every symbol, so can't leave any out.
+func (o *PortfolioOptimizer) checkHistory(symbols []string, returns [][]float64) error {
+    for i, r := range returns {
+        if note := o.samples.Check(sample.ExpectedReturn, symbols[i], len(r)); note != nil {
+            return fmt.Errorf("%w for %s: %s", ErrInsufficientData, symbols[i], note.Reason)
+        }
+    }
+    return nil
+}
+
+func (o *PortfolioOptimizer) expectedReturns(ctx context.Context, symbols []string, returns [][]float64, method ExpectedReturnMethod) ([]float64, error) {
+    if method == "" {
+        method = o.config.ExpectedReturnMethod
+    }
+
+    switch method {
+    case ReturnsHistorical:
+        return o.calculateExpectedReturns(returns), nil
+    case ReturnsEWMA:
+        expected := make([]float64, len(returns))
+        for i, r := range returns {
+            expected[i] = ewmaMean(r, o.config.EWMAHalfLifeDays)
+        }
+        return expected, nil
+    case ReturnsCAPM:
+        return o.capmReturns(ctx, symbols, returns)
+    default:
+        return nil, fmt.Errorf("%w: %q", ErrUnknownReturnMethod, method)
+    }
+}
+
+// capmReturns prices each symbol as rf + beta * (rm - rf), with beta
+// regressed against the market symbol's daily returns and rf the daily
+// risk-free rate
+func (o *PortfolioOptimizer) capmReturns(ctx context.Context, symbols []string, returns [][]float64) ([]float64, error) {
+    market, err := o.getHistoricalReturns(ctx, []string{o.config.MarketSymbol})
+    if err != nil {
+        return nil, fmt.Errorf("failed to get market returns: %w", err)
+    }
+    marketReturns := market[0]
+    if len(marketReturns) < 2 {
+        return nil, fmt.Errorf("%w for market symbol %s", ErrInsufficientData, o.config.MarketSymbol)
+    }
+
+    riskFreeRate, err := o.riskFree.AnnualRiskFreeRate(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+    }
+    rf := risk.DailyRate(riskFreeRate)
+    rm := stat.Mean(marketReturns, nil)
+
+    expected := make([]float64, len(returns))
+    for i, r := range returns {
+        beta, err := olsBeta(alignRecent(r, marketReturns))
+        if err != nil {
+            return nil, fmt.Errorf("failed to calculate beta for %s: %w", symbols[i], err)
+        }
+        expected[i] = rf + beta*(rm-rf)
+    }
+    return expected, nil
+}
+
+// ewmaMean weights each return by 0.5^(age/halfLife), where the latest
+// return has age 0
+func ewmaMean(returns []float64, halfLife float64) float64 {
+    if len(returns) == 0 {
+        return 0
+    }
+    if halfLife <= 0 {
+        halfLife = DefaultEWMAHalfLifeDays
+    }
+
+    var weighted, totalWeight float64
+    for i, r := range returns {
+        age := float64(len(returns) - 1 - i)
+        w := math.Pow(0.5, age/halfLife)
+        weighted += w * r
+        totalWeight += w
+    }
+    return weighted / totalWeight
+}
+
+// alignRecent trims both series to their common most recent observations.
+// Both come from the same window of daily data, so they end on the same day.
+func alignRecent(a, b []float64) ([]float64, []float64) {
+    n := len(a)
+    if len(b) < n {
+        n = len(b)
+    }
+    return a[len(a)-n:], b[len(b)-n:]
+}
@@ -0,0 +1,262 @@
+package portfolio
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+
+    "gonum.org/v1/gonum/mat"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+)
+
+const (
+    minFrontierPoints = 3
+    maxFrontierPoints = 100
+    // weightTolerance absorbs rounding noise when checking w >= 0
+    weightTolerance = 1e-12
+)
+
+// ErrDegenerateFrontier is returned when every asset has the same expected
+// return, so there is no risk-return tradeoff to trace
+var ErrDegenerateFrontier = errors.New("efficient frontier is degenerate")
+
+type EfficientFrontierPoint struct {
+    TargetReturn float64   `json:"target_return"`
+    MinRisk      float64   `json:"min_risk"`
+    Weights      []float64 `json:"weights"`
+    SharpeRatio  float64   `json:"sharpe_ratio"`
+    // RiskFreeRate is the annual rate SharpeRatio was measured against
+    RiskFreeRate float64 `json:"risk_free_rate"`
+}
+
+// GenerateEfficientFrontier returns numPoints long-only minimum-variance
+// portfolios at equally spaced target returns, from the global minimum
+// variance portfolio up to the asset with the highest expected return
+func (o *PortfolioOptimizer) GenerateEfficientFrontier(ctx context.Context, symbols []string, numPoints int) ([]EfficientFrontierPoint, error) {
+    if numPoints < minFrontierPoints {
+        return nil, fmt.Errorf("need at least %d frontier points, got %d", minFrontierPoints, numPoints)
+    }
+    if numPoints > maxFrontierPoints {
+        numPoints = maxFrontierPoints
+    }
+    if len(symbols) < 2 {
+        return nil, fmt.Errorf("need at least 2 symbols, got %d", len(symbols))
+    }
+
+    returns, err := o.getHistoricalReturns(ctx, symbols)
+    if err != nil {
+        return nil, err
+    }
+    for i, r := range returns {
+        if len(r) < 2 {
+            return nil, fmt.Errorf("%w for %s", ErrInsufficientData, symbols[i])
+        }
+    }
+    if err := o.checkHistory(symbols, returns); err != nil {
+        return nil, err
+    }
+
+    expectedReturns, err := o.expectedReturns(ctx, symbols, returns, "")
+    if err != nil {
+        return nil, err
+    }
+    covMatrix := o.calculateCovarianceMatrix(returns)
+    riskFreeRate, err := o.riskFree.AnnualRiskFreeRate(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+    }
+
+    return o.efficientFrontier(expectedReturns, covMatrix, numPoints, riskFreeRate)
+}
+
+// efficientFrontier measures Sharpe ratios against the annual
+// riskFreeRate, converted to a daily rate like the expected returns
+func (o *PortfolioOptimizer) efficientFrontier(expectedReturns []float64, covMatrix *mat.Dense, numPoints int, riskFreeRate float64) ([]EfficientFrontierPoint, error) {
+    rf := risk.DailyRate(riskFreeRate)
+    n := len(expectedReturns)
+    ones := make([]float64, n)
+    for i := range ones {
+        ones[i] = 1
+    }
+
+    // Global minimum variance portfolio anchors the bottom of the frontier
+    minVar, err := minimizeVariance(covMatrix, [][]float64{ones}, []float64{1})
+    if err != nil {
+        return nil, fmt.Errorf("failed to find minimum variance portfolio: %w", err)
+    }
+    lowReturn := o.calculatePortfolioReturn(minVar, expectedReturns)
+
+    best := 0
+    for i, r := range expectedReturns {
+        if r > expectedReturns[best] {
+            best = i
+        }
+    }
+    highReturn := expectedReturns[best]
+
+    if highReturn-lowReturn < 1e-12 {
+        return nil, ErrDegenerateFrontier
+    }
+
+    points := make([]EfficientFrontierPoint, 0, numPoints)
+    step := (highReturn - lowReturn) / float64(numPoints-1)
+
+    for k := 0; k < numPoints; k++ {
+        target := lowReturn + step*float64(k)
+
+        var weights []float64
+        switch k {
+        case 0:
+            weights = minVar
+        case numPoints - 1:
+            // Only the highest-return asset reaches the top of the range
+            weights = make([]float64, n)
+            weights[best] = 1
+        default:
+            weights, err = minimizeVariance(covMatrix,
+                [][]float64{ones, expectedReturns},
+                []float64{1, target},
+            )
+            if err != nil {
+                return nil, fmt.Errorf("failed to optimize for target return %.6f: %w", target, err)
+            }
+        }
+
+        risk := o.calculatePortfolioRisk(weights, covMatrix)
+        sharpe := 0.0
+        if risk > 0 {
+            sharpe = (target - rf) / risk
+        }
+
+        points = append(points, EfficientFrontierPoint{
+            TargetReturn: target,
+            MinRisk:      risk,
+            Weights:      weights,
+            SharpeRatio:  sharpe,
+            RiskFreeRate: riskFreeRate,
+        })
+    }
+
+    sort.Slice(points, func(i, j int) bool {
+        return points[i].TargetReturn < points[j].TargetReturn
+    })
+
+    return points, nil
+}
+
+// minimizeVariance solves min w'Σw subject to Aw = b and w >= 0 with an
+// active-set method: assets are fixed at zero while their weight would go
+// negative and released again when their KKT multiplier says it pays off
+func minimizeVariance(cov *mat.Dense, A [][]float64, b []float64) ([]float64, error) {
+    n, _ := cov.Dims()
+    free := make([]bool, n)
+    for i := range free {
+        free[i] = true
+    }
+
+    for iter := 0; iter < 10*n+10; iter++ {
+        w, nu, err := solveEqualityConstrained(cov, A, b, free)
+        if err != nil {
+            return nil, err
+        }
+
+        // Pin the most negative weight to zero and re-solve
+        worst, worstWeight := -1, -weightTolerance
+        for i := 0; i < n; i++ {
+            if free[i] && w[i] < worstWeight {
+                worst, worstWeight = i, w[i]
+            }
+        }
+        if worst >= 0 {
+            free[worst] = false
+            continue
+        }
+
+        // Release a pinned asset whose gradient shows variance would fall
+        release, releaseGrad := -1, -weightTolerance
+        for i := 0; i < n; i++ {
+            if free[i] {
+                continue
+            }
+            grad := 0.0
+            for j := 0; j < n; j++ {
+                grad += 2 * cov.At(i, j) * w[j]
+            }
+            // The KKT system is solved as 2Σw + A'ν = 0, so this is the
+            // multiplier of the w_i >= 0 bound
+            for c := range A {
+                grad += nu[c] * A[c][i]
+            }
+            if grad < releaseGrad {
+                release, releaseGrad = i, grad
+            }
+        }
+        if release < 0 {
+            for i := range w {
+                if w[i] < 0 {
+                    w[i] = 0
+                }
+            }
+            return w, nil
+        }
+        free[release] = true
+    }
+
+    return nil, errors.New("active set did not converge")
+}
+
+// solveEqualityConstrained solves the KKT system for the free assets,
+// returning full-length weights (zero for pinned assets) and the multipliers
+func solveEqualityConstrained(cov *mat.Dense, A [][]float64, b []float64, free []bool) ([]float64, []float64, error) {
+    var idx []int
+    for i, f := range free {
+        if f {
+            idx = append(idx, i)
+        }
+    }
+
+    k, m := len(idx), len(A)
+    if k < m {
+        return nil, nil, errors.New("too few assets to satisfy the constraints")
+    }
+
+    kkt := mat.NewDense(k+m, k+m, nil)
+    rhs := mat.NewVecDense(k+m, nil)
+    for r, i := range idx {
+        for c, j := range idx {
+            kkt.Set(r, c, 2*cov.At(i, j))
+        }
+        for c := 0; c < m; c++ {
+            kkt.Set(r, k+c, A[c][i])
+            kkt.Set(k+c, r, A[c][i])
+        }
+    }
+    for c := 0; c < m; c++ {
+        rhs.SetVec(k+c, b[c])
+    }
+
+    var sol mat.VecDense
+    if err := sol.SolveVec(kkt, rhs); err != nil {
+        return nil, nil, fmt.Errorf("singular KKT system: %w", err)
+    }
+
+    w := make([]float64, len(free))
+    for r, i := range idx {
+        w[i] = sol.AtVec(r)
+    }
+    nu := make([]float64, m)
+    for c := 0; c < m; c++ {
+        nu[c] = sol.AtVec(k + c)
+    }
+
+    for _, v := range w {
+        if math.IsNaN(v) || math.IsInf(v, 0) {
+            return nil, nil, errors.New("numerically unstable KKT system")
+        }
+    }
+
+    return w, nu, nil
+}
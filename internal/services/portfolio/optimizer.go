@@ -3,16 +3,53 @@ package portfolio
 import (
     "context"
     "database/sql"
+    "fmt"
+    "gonum.org/v1/gonum/floats"
     "gonum.org/v1/gonum/mat"
     "gonum.org/v1/gonum/optimize"
     "math"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/database"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/valuation"
 )
 
+// defaultMaxIterations caps the major iterations of one optimization run
+const defaultMaxIterations = 1000
+
+// OptimizerMetrics records optimization runs
+type OptimizerMetrics interface {
+    ObserveOptimization(runtime time.Duration, iterations int, converged bool)
+}
+
 type PortfolioOptimizer struct {
     db *sql.DB
-    riskFreeRate float64
+    // pool, when set, reads historical returns as native arrays
+    pool *pgxpool.Pool
+    riskFree     risk.RiskFreeRateProvider
     minWeight    float64
     maxWeight    float64
+    config       OptimizerConfig
+
+    maxIterations int
+    metrics       OptimizerMetrics
+    // valuers says which symbols have market returns to measure
+    valuers *valuation.Registry
+    // samples is the minimum history of a symbol's expected return
+    samples sample.Policy
+
+    // LastOptimizationWeights maps the WarmStartKey of a set of symbols to
+    // the weights by symbol of its last run, which the next optimization of
+    // the same symbols starts from
+    LastOptimizationWeights sync.Map
 }
 
 type OptimizationResult struct {
@@ -20,77 +57,322 @@ type OptimizationResult struct {
     ExpectedReturn float64   `json:"expected_return"`
     Risk          float64   `json:"risk"`
     SharpeRatio   float64   `json:"sharpe_ratio"`
+    // RiskFreeRate is the annual rate SharpeRatio was measured against
+    RiskFreeRate float64     `json:"risk_free_rate"`
+    Diagnostics  Diagnostics `json:"diagnostics"`
+}
+
+// Diagnostics describes how an optimization run ended
+type Diagnostics struct {
+    Iterations int  `json:"iterations"`
+    Converged  bool `json:"converged"`
+    // HitIterationLimit is set when the run stopped at the iteration cap
+    HitIterationLimit bool          `json:"hit_iteration_limit"`
+    FinalObjective    float64       `json:"final_objective"`
+    GradientNorm      float64       `json:"gradient_norm"`
+    Runtime           time.Duration `json:"runtime_ns"`
+    // ConstraintViolation is how far the weights are from summing to 1
+    ConstraintViolation float64 `json:"constraint_violation"`
+    Status              string  `json:"status"`
+    // Evaluations counts objective function evaluations, including those
+    // of the numerical gradient
+    Evaluations int `json:"evaluations"`
+    // WarmStarted is set when the run started from the last result for
+    // the same symbols rather than equal weights
+    WarmStarted bool `json:"warm_started"`
 }
 
 func NewPortfolioOptimizer(db *sql.DB) *PortfolioOptimizer {
     return &PortfolioOptimizer{
         db:           db,
-        riskFreeRate: 0.02, // 2% risk-free rate
+        riskFree:     risk.StaticRiskFreeRate(risk.DefaultRiskFreeRate),
         minWeight:    0.0,  // minimum weight per asset
         maxWeight:    0.4,  // maximum weight per asset (40%)
+        config: OptimizerConfig{
+            ExpectedReturnMethod: ReturnsHistorical,
+            EWMAHalfLifeDays:     DefaultEWMAHalfLifeDays,
+            MarketSymbol:         DefaultMarketSymbol,
+        },
+        maxIterations: defaultMaxIterations,
+        valuers:       valuation.NewRegistry(db, nil, valuation.DefaultCurrency),
+        samples:       sample.DefaultPolicy(),
     }
 }
 
-func (o *PortfolioOptimizer) Optimize(ctx context.Context, symbols []string, riskTolerance float64) (*OptimizationResult, error) {
+// WithValuers resolves which symbols are cash or stablecoins with valuers
+func (o *PortfolioOptimizer) WithValuers(valuers *valuation.Registry) *PortfolioOptimizer {
+    o.valuers = valuers
+    return o
+}
+
+// WithPool reads historical returns through pool rather than database/sql
+func (o *PortfolioOptimizer) WithPool(pool *pgxpool.Pool) *PortfolioOptimizer {
+    o.pool = pool
+    return o
+}
+
+// WithRiskFreeRate measures Sharpe ratios and CAPM returns against the
+// rate riskFree provides
+func (o *PortfolioOptimizer) WithRiskFreeRate(riskFree risk.RiskFreeRateProvider) *PortfolioOptimizer {
+    o.riskFree = riskFree
+    return o
+}
+
+// WithMetrics records the runtime, iterations and convergence of each run
+func (o *PortfolioOptimizer) WithMetrics(metrics OptimizerMetrics) *PortfolioOptimizer {
+    o.metrics = metrics
+    return o
+}
+
+// Optimize finds Sharpe-maximising weights for symbols, estimating expected
+// returns with method, or the configured default if method is empty
+func (o *PortfolioOptimizer) Optimize(ctx context.Context, symbols []string, riskTolerance float64, method ExpectedReturnMethod) (*OptimizationResult, error) {
+    if !method.Valid() {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownReturnMethod, method)
+    }
+
     // Get historical returns
     returns, err := o.getHistoricalReturns(ctx, symbols)
     if err != nil {
         return nil, err
     }
+    if err := o.checkHistory(symbols, returns); err != nil {
+        return nil, err
+    }
 
     // Calculate expected returns and covariance matrix
-    expectedReturns := o.calculateExpectedReturns(returns)
+    expectedReturns, err := o.expectedReturns(ctx, symbols, returns, method)
+    if err != nil {
+        return nil, err
+    }
     covMatrix := o.calculateCovarianceMatrix(returns)
 
-    // Initialize optimization problem
+    riskFreeRate, err := o.riskFree.AnnualRiskFreeRate(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+    }
+
+    return o.optimize(ctx, symbols, expectedReturns, covMatrix, riskTolerance, riskFreeRate), nil
+}
+
+// WarmStartKey identifies a set of symbols regardless of their order
+func WarmStartKey(symbols []string) string {
+    sorted := append([]string(nil), symbols...)
+    sort.Strings(sorted)
+    return strings.Join(sorted, ",")
+}
+
+// ClearWarmStart forgets the last result for portfolioKey, a WarmStartKey,
+// so its next optimization starts from equal weights
+func (o *PortfolioOptimizer) ClearWarmStart(portfolioKey string) {
+    o.LastOptimizationWeights.Delete(portfolioKey)
+}
+
+// optimize runs the Sharpe maximisation from the last weights found for the
+// same symbols, or equal weights if there are none. A run that fails to
+// converge still returns its last weights, flagged in Diagnostics.
+// Expected returns and risk are daily, so the annual riskFreeRate is
+// converted to a daily one.
+func (o *PortfolioOptimizer) optimize(ctx context.Context, symbols []string, expectedReturns []float64, covMatrix *mat.Dense, riskTolerance, riskFreeRate float64) *OptimizationResult {
+    rf := risk.DailyRate(riskFreeRate)
     n := len(symbols)
-    weights := make([]float64, n)
-    for i := range weights {
-        weights[i] = 1.0 / float64(n) // Start with equal weights
+    key := WarmStartKey(symbols)
+    weights, warm := o.warmStart(key, symbols)
+    if !warm {
+        weights = make([]float64, n)
+        for i := range weights {
+            weights[i] = 1.0 / float64(n) // Start with equal weights
+        }
     }
 
     // Define optimization problem
+    var evaluations int
     problem := optimize.Problem{
         Func: func(w []float64) float64 {
-            return o.objectiveFunction(w, expectedReturns, covMatrix, riskTolerance)
+            evaluations++
+            return o.objectiveFunction(w, expectedReturns, covMatrix, riskTolerance, rf)
         },
         Grad: func(grad, w []float64) {
-            o.calculateGradient(grad, w, expectedReturns, covMatrix, riskTolerance)
-        },
-    }
-
-    // Set constraints
-    fc := optimize.FunctionConstraint{
-        Func: func(w []float64) float64 {
-            sum := 0.0
-            for _, weight := range w {
-                sum += weight
-            }
-            return sum - 1.0 // Sum of weights should be 1
+            // Two objective evaluations per weight
+            evaluations += 2 * len(w)
+            o.calculateGradient(grad, w, expectedReturns, covMatrix, riskTolerance, rf)
         },
     }
+    settings := &optimize.Settings{MajorIterations: o.maxIterations}
 
     // Run optimization
-    result, err := optimize.Minimize(problem, weights, nil, nil)
+    start := time.Now()
+    result, err := optimize.Minimize(problem, weights, settings, nil)
+    runtime := time.Since(start)
+
+    diagnostics := Diagnostics{Runtime: runtime, Status: "failure", Evaluations: evaluations, WarmStarted: warm}
+    optimizedWeights := weights
+    if result != nil {
+        optimizedWeights = result.X
+        diagnostics.Iterations = result.MajorIterations
+        diagnostics.Converged = err == nil && converged(result.Status)
+        diagnostics.HitIterationLimit = result.Status == optimize.IterationLimit
+        diagnostics.FinalObjective = result.F
+        diagnostics.Status = result.Status.String()
+    }
     if err != nil {
-        return nil, err
+        diagnostics.Status = err.Error()
+    }
+
+    grad := make([]float64, n)
+    o.calculateGradient(grad, optimizedWeights, expectedReturns, covMatrix, riskTolerance, rf)
+    diagnostics.GradientNorm = floats.Norm(grad, 2)
+    // Weights should sum to 1
+    diagnostics.ConstraintViolation = math.Abs(floats.Sum(optimizedWeights) - 1.0)
+
+    if o.metrics != nil {
+        o.metrics.ObserveOptimization(runtime, diagnostics.Iterations, diagnostics.Converged)
+    }
+    // A run stopped short of convergence, as line searches on the numerical
+    // gradient often are, still ends no worse than it started, so its
+    // weights are kept for the next warm start too
+    if result != nil && !math.IsNaN(result.F) && !math.IsInf(result.F, 0) {
+        o.storeWarmStart(key, symbols, optimizedWeights)
+    }
+    if !diagnostics.Converged {
+        logger.FromContext(ctx).Warnf("Portfolio optimization of %v did not converge after %d iterations: %s",
+            symbols, diagnostics.Iterations, diagnostics.Status)
     }
 
     // Calculate metrics for optimized portfolio
-    optimizedWeights := result.X
     portfolioReturn := o.calculatePortfolioReturn(optimizedWeights, expectedReturns)
     portfolioRisk := o.calculatePortfolioRisk(optimizedWeights, covMatrix)
-    sharpeRatio := (portfolioReturn - o.riskFreeRate) / portfolioRisk
+    sharpeRatio := (portfolioReturn - rf) / portfolioRisk
 
     return &OptimizationResult{
         Weights:        optimizedWeights,
         ExpectedReturn: portfolioReturn,
         Risk:          portfolioRisk,
         SharpeRatio:   sharpeRatio,
+        RiskFreeRate:  riskFreeRate,
+        Diagnostics:   diagnostics,
+    }
+}
+
+// warmStart returns the last weights stored under key, reordered to match
+// symbols
+func (o *PortfolioOptimizer) warmStart(key string, symbols []string) ([]float64, bool) {
+    stored, ok := o.LastOptimizationWeights.Load(key)
+    if !ok {
+        return nil, false
+    }
+    bySymbol := stored.(map[string]float64)
+    if len(bySymbol) != len(symbols) {
+        return nil, false
+    }
+    weights := make([]float64, len(symbols))
+    for i, symbol := range symbols {
+        w, ok := bySymbol[symbol]
+        if !ok {
+            return nil, false
+        }
+        weights[i] = w
+    }
+    return weights, true
+}
+
+func (o *PortfolioOptimizer) storeWarmStart(key string, symbols []string, weights []float64) {
+    bySymbol := make(map[string]float64, len(symbols))
+    for i, symbol := range symbols {
+        bySymbol[symbol] = weights[i]
+    }
+    o.LastOptimizationWeights.Store(key, bySymbol)
+}
+
+// converged reports whether status is a successful termination rather
+// than a limit or failure
+func converged(status optimize.Status) bool {
+    switch status {
+    case optimize.Success, optimize.FunctionThreshold, optimize.FunctionConvergence,
+        optimize.GradientThreshold, optimize.StepConvergence, optimize.MethodConverge:
+        return true
+    }
+    return false
+}
+
+// Evaluate returns the expected return, risk and Sharpe ratio of holding
+// symbols at weights, estimated the same way as Optimize
+func (o *PortfolioOptimizer) Evaluate(ctx context.Context, symbols []string, weights []float64, method ExpectedReturnMethod) (*OptimizationResult, error) {
+    if !method.Valid() {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownReturnMethod, method)
+    }
+    if len(weights) != len(symbols) {
+        return nil, fmt.Errorf("got %d weights for %d symbols", len(weights), len(symbols))
+    }
+
+    returns, err := o.getHistoricalReturns(ctx, symbols)
+    if err != nil {
+        return nil, err
+    }
+    if err := o.checkHistory(symbols, returns); err != nil {
+        return nil, err
+    }
+    expectedReturns, err := o.expectedReturns(ctx, symbols, returns, method)
+    if err != nil {
+        return nil, err
+    }
+    covMatrix := o.calculateCovarianceMatrix(returns)
+    riskFreeRate, err := o.riskFree.AnnualRiskFreeRate(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get risk-free rate: %w", err)
+    }
+
+    portfolioReturn := o.calculatePortfolioReturn(weights, expectedReturns)
+    portfolioRisk := o.calculatePortfolioRisk(weights, covMatrix)
+
+    return &OptimizationResult{
+        Weights:        weights,
+        ExpectedReturn: portfolioReturn,
+        Risk:          portfolioRisk,
+        SharpeRatio:   (portfolioReturn - risk.DailyRate(riskFreeRate)) / portfolioRisk,
+        RiskFreeRate:  riskFreeRate,
     }, nil
 }
 
+// getHistoricalReturns returns each symbol's daily returns over the last
+// year. Cash and stablecoins hold their value, so rather than being read
+// from market_data they return zero on each day the others were measured.
 func (o *PortfolioOptimizer) getHistoricalReturns(ctx context.Context, symbols []string) ([][]float64, error) {
+    types, err := o.valuers.Types(ctx, symbols)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get asset types: %w", err)
+    }
+    var marketSymbols []string
+    for _, symbol := range symbols {
+        if o.valuers.Volatile(types[symbol]) {
+            marketSymbols = append(marketSymbols, symbol)
+        }
+    }
+
+    returns := make([][]float64, len(symbols))
+    if len(marketSymbols) > 0 {
+        if err := o.getMarketReturns(ctx, symbols, marketSymbols, returns); err != nil {
+            return nil, err
+        }
+    }
+
+    periods := 0
+    for _, r := range returns {
+        if len(r) > periods {
+            periods = len(r)
+        }
+    }
+    for i, symbol := range symbols {
+        if !o.valuers.Volatile(types[symbol]) {
+            returns[i] = make([]float64, periods)
+        }
+    }
+    return returns, nil
+}
+
+// getMarketReturns reads the returns of marketSymbols from market_data into
+// returns, indexed like symbols
+func (o *PortfolioOptimizer) getMarketReturns(ctx context.Context, symbols, marketSymbols []string, returns [][]float64) error {
     query := `
         WITH daily_returns AS (
             SELECT 
@@ -100,6 +382,7 @@ func (o *PortfolioOptimizer) getHistoricalReturns(ctx context.Context, symbols [
             FROM market_data
             WHERE symbol = ANY($1)
             AND timestamp >= NOW() - INTERVAL '1 year'
+            AND close > 0
             ORDER BY timestamp
         )
         SELECT symbol, ARRAY_AGG(return ORDER BY timestamp) as returns
@@ -108,29 +391,18 @@ func (o *PortfolioOptimizer) getHistoricalReturns(ctx context.Context, symbols [
         GROUP BY symbol
     `
 
-    rows, err := o.db.QueryContext(ctx, query, symbols)
+    bySymbol, err := database.New(o.db).WithPool(o.pool).QueryFloat64Arrays(ctx, query, marketSymbols)
     if err != nil {
-        return nil, err
+        return err
     }
-    defer rows.Close()
-
-    returns := make([][]float64, len(symbols))
-    for rows.Next() {
-        var symbol string
-        var symbolReturns []float64
-        if err := rows.Scan(&symbol, &symbolReturns); err != nil {
-            return nil, err
-        }
 
-        for i, s := range symbols {
-            if s == symbol {
-                returns[i] = symbolReturns
-                break
-            }
+    for i, symbol := range symbols {
+        if symbolReturns, ok := bySymbol[symbol]; ok {
+            returns[i], _ = risk.FiniteReturns(symbolReturns)
         }
     }
 
-    return returns, nil
+    return nil
 }
 
 func (o *PortfolioOptimizer) calculateExpectedReturns(returns [][]float64) []float64 {
@@ -167,16 +439,18 @@ func (o *PortfolioOptimizer) calculateCovarianceMatrix(returns [][]float64) *mat
     return &covMatrix
 }
 
-func (o *PortfolioOptimizer) objectiveFunction(weights []float64, expectedReturns []float64, covMatrix *mat.Dense, riskTolerance float64) float64 {
+// objectiveFunction is the negated Sharpe ratio of weights over the daily
+// risk-free rate rf
+func (o *PortfolioOptimizer) objectiveFunction(weights []float64, expectedReturns []float64, covMatrix *mat.Dense, riskTolerance, rf float64) float64 {
     portfolioReturn := o.calculatePortfolioReturn(weights, expectedReturns)
     portfolioRisk := o.calculatePortfolioRisk(weights, covMatrix)
     
     // Objective: Maximize Sharpe Ratio
     // For minimization, we return negative Sharpe ratio
-    return -(portfolioReturn - o.riskFreeRate) / portfolioRisk
+    return -(portfolioReturn - rf) / portfolioRisk
 }
 
-func (o *PortfolioOptimizer) calculateGradient(grad, weights []float64, expectedReturns []float64, covMatrix *mat.Dense, riskTolerance float64) {
+func (o *PortfolioOptimizer) calculateGradient(grad, weights []float64, expectedReturns []float64, covMatrix *mat.Dense, riskTolerance, rf float64) {
     n := len(weights)
     h := 1e-8 // Small value for numerical gradient calculation
 
@@ -190,8 +464,8 @@ func (o *PortfolioOptimizer) calculateGradient(grad, weights []float64, expected
         weightsPlus[i] += h
         weightsMinus[i] -= h
 
-        fPlus := o.objectiveFunction(weightsPlus, expectedReturns, covMatrix, riskTolerance)
-        fMinus := o.objectiveFunction(weightsMinus, expectedReturns, covMatrix, riskTolerance)
+        fPlus := o.objectiveFunction(weightsPlus, expectedReturns, covMatrix, riskTolerance, rf)
+        fMinus := o.objectiveFunction(weightsMinus, expectedReturns, covMatrix, riskTolerance, rf)
         
         grad[i] = (fPlus - fMinus) / (2 * h)
     }
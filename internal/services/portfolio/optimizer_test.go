@@ -0,0 +1,302 @@
+package portfolio
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "gonum.org/v1/gonum/mat"
+    "gonum.org/v1/gonum/optimize"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/risk"
+)
+
+func TestPortfolioOptimizer_EfficientFrontier(t *testing.T) {
+    optimizer := NewPortfolioOptimizer(nil)
+
+    t.Run("Two asset frontier is convex", func(t *testing.T) {
+        expectedReturns := []float64{0.0005, 0.001}
+        covMatrix := mat.NewDense(2, 2, []float64{
+            0.0001, 0.00002,
+            0.00002, 0.0004,
+        })
+
+        points, err := optimizer.efficientFrontier(expectedReturns, covMatrix, 20, risk.DefaultRiskFreeRate)
+        assert.NoError(t, err)
+        assert.Len(t, points, 20)
+
+        // Starts at the minimum variance portfolio, ends fully in the best asset
+        assert.InDelta(t, 0.826087, points[0].Weights[0], 1e-4)
+        assert.InDelta(t, 1.0, points[len(points)-1].Weights[1], 1e-9)
+
+        for i, p := range points {
+            assert.InDelta(t, 1.0, p.Weights[0]+p.Weights[1], 1e-9)
+            assert.GreaterOrEqual(t, p.Weights[0], 0.0)
+            assert.GreaterOrEqual(t, p.Weights[1], 0.0)
+
+            if i == 0 {
+                continue
+            }
+            prev := points[i-1]
+            assert.Greater(t, p.TargetReturn, prev.TargetReturn)
+            // Beyond the minimum variance point more return costs more risk
+            assert.Greater(t, p.MinRisk, prev.MinRisk)
+
+            if i >= 2 {
+                // Equal return steps, so convexity means growing risk increments
+                assert.GreaterOrEqual(t, (p.MinRisk-prev.MinRisk)-(prev.MinRisk-points[i-2].MinRisk), -1e-12)
+            }
+        }
+    })
+
+    t.Run("Identical expected returns", func(t *testing.T) {
+        covMatrix := mat.NewDense(2, 2, []float64{
+            0.0001, 0,
+            0, 0.0004,
+        })
+
+        _, err := optimizer.efficientFrontier([]float64{0.001, 0.001}, covMatrix, 10, risk.DefaultRiskFreeRate)
+        assert.ErrorIs(t, err, ErrDegenerateFrontier)
+    })
+
+    t.Run("Reject too few points", func(t *testing.T) {
+        _, err := optimizer.GenerateEfficientFrontier(context.Background(), []string{"AAPL", "GOOGL"}, 2)
+        assert.Error(t, err)
+    })
+}
+
+func TestPortfolioOptimizer_ExpectedReturns(t *testing.T) {
+    optimizer := NewPortfolioOptimizer(nil)
+    ctx := context.Background()
+
+    // A quiet year followed by a strong rally in the last 10 days
+    returns := make([]float64, 100)
+    for i := range returns {
+        returns[i] = 0.001
+        if i >= 90 {
+            returns[i] = 0.02
+        }
+    }
+
+    t.Run("EWMA weights recent returns more than the historical mean", func(t *testing.T) {
+        historical, err := optimizer.expectedReturns(ctx, []string{"AAPL"}, [][]float64{returns}, ReturnsHistorical)
+        assert.NoError(t, err)
+        ewma, err := optimizer.expectedReturns(ctx, []string{"AAPL"}, [][]float64{returns}, ReturnsEWMA)
+        assert.NoError(t, err)
+
+        assert.InDelta(t, 0.0029, historical[0], 1e-9)
+        assert.Greater(t, ewma[0], historical[0])
+    })
+
+    t.Run("Shorter half-life reacts faster", func(t *testing.T) {
+        assert.Greater(t, ewmaMean(returns, 5), ewmaMean(returns, 30))
+    })
+
+    t.Run("Constant series", func(t *testing.T) {
+        assert.InDelta(t, 0.001, ewmaMean(returns[:90], 30), 1e-12)
+    })
+
+    t.Run("Too little history", func(t *testing.T) {
+        // NEWCO listed 12 days ago; AAPL has exactly the 60 returns required
+        symbols := []string{"AAPL", "NEWCO"}
+        history := [][]float64{returns[:60], returns[:12]}
+
+        expected, notes, err := optimizer.guardedExpectedReturns(ctx, symbols, history, ReturnsHistorical)
+        if !assert.NoError(t, err) {
+            return
+        }
+        if assert.NotNil(t, expected[0]) {
+            assert.InDelta(t, 0.001, *expected[0], 1e-12)
+        }
+        assert.Nil(t, expected[1])
+        assert.Equal(t, []sample.Note{{
+            Metric:       sample.ExpectedReturn,
+            Symbol:       "NEWCO",
+            Observations: 12,
+            Required:     60,
+            Reason:       "insufficient history (12 of 60 required observations)",
+        }}, notes)
+
+        // Weights can't be optimized without every expected return
+        err = optimizer.checkHistory(symbols, history)
+        assert.ErrorIs(t, err, ErrInsufficientData)
+        assert.Contains(t, err.Error(), "NEWCO: insufficient history (12 of 60 required observations)")
+        assert.NoError(t, optimizer.checkHistory(symbols[:1], history[:1]))
+        assert.Error(t, optimizer.checkHistory(symbols[:1], [][]float64{returns[:59]}))
+    })
+
+    t.Run("Reject unknown method", func(t *testing.T) {
+        _, _, err := optimizer.ComputeExpectedReturns(ctx, []string{"AAPL"}, "momentum")
+        assert.ErrorIs(t, err, ErrUnknownReturnMethod)
+
+        _, err = optimizer.Optimize(ctx, []string{"AAPL"}, 0.5, "momentum")
+        assert.ErrorIs(t, err, ErrUnknownReturnMethod)
+    })
+}
+
+type optimizationRun struct {
+    iterations int
+    converged  bool
+}
+
+type recordingMetrics struct {
+    runs []optimizationRun
+}
+
+func (m *recordingMetrics) ObserveOptimization(runtime time.Duration, iterations int, converged bool) {
+    m.runs = append(m.runs, optimizationRun{iterations, converged})
+}
+
+func TestPortfolioOptimizer_Diagnostics(t *testing.T) {
+    metrics := &recordingMetrics{}
+    optimizer := NewPortfolioOptimizer(nil).WithMetrics(metrics)
+    optimizer.maxIterations = 1
+
+    expectedReturns := []float64{0.0005, 0.001}
+    covMatrix := mat.NewDense(2, 2, []float64{
+        0.0001, 0.00002,
+        0.00002, 0.0004,
+    })
+
+    result := optimizer.optimize(context.Background(), []string{"AAPL", "GOOGL"}, expectedReturns, covMatrix, 0.5, risk.DefaultRiskFreeRate)
+    d := result.Diagnostics
+
+    assert.False(t, d.Converged)
+    assert.True(t, d.HitIterationLimit)
+    assert.LessOrEqual(t, d.Iterations, 1)
+    assert.Greater(t, d.Runtime, time.Duration(0))
+    assert.Greater(t, d.GradientNorm, 0.0)
+    assert.InDelta(t, math.Abs(result.Weights[0]+result.Weights[1]-1), d.ConstraintViolation, 1e-12)
+    assert.Equal(t, optimizer.objectiveFunction(result.Weights, expectedReturns, covMatrix, 0.5, risk.DailyRate(risk.DefaultRiskFreeRate)), d.FinalObjective)
+
+    if !assert.Len(t, metrics.runs, 1) {
+        return
+    }
+    assert.False(t, metrics.runs[0].converged)
+    assert.Equal(t, d.Iterations, metrics.runs[0].iterations)
+}
+
+func TestConverged(t *testing.T) {
+    assert.True(t, converged(optimize.GradientThreshold))
+    assert.True(t, converged(optimize.FunctionConvergence))
+    assert.False(t, converged(optimize.IterationLimit))
+    assert.False(t, converged(optimize.Failure))
+    assert.False(t, converged(optimize.NotTerminated))
+}
+
+// tenAssetsRiskFreeRate is the rate tenAssets is optimized against. Its
+// returns are annual while optimize converts the rate to a daily one, so
+// the annual rate is passed scaled up for the two to match.
+var tenAssetsRiskFreeRate = risk.AnnualRate(risk.DefaultRiskFreeRate, risk.TradingDaysPerYear)
+
+// tenAssets is a universe of ten assets with rising annual return and risk
+// and a common correlation of 0.3
+func tenAssets() ([]string, []float64, *mat.Dense) {
+    n := 10
+    symbols := make([]string, n)
+    expectedReturns := make([]float64, n)
+    vols := make([]float64, n)
+    for i := 0; i < n; i++ {
+        symbols[i] = fmt.Sprintf("ASSET%d", i)
+        expectedReturns[i] = 0.04 + 0.01*float64(i)
+        vols[i] = 0.15 + 0.02*float64(i)
+    }
+    covMatrix := mat.NewDense(n, n, nil)
+    for i := 0; i < n; i++ {
+        for j := 0; j < n; j++ {
+            corr := 0.3
+            if i == j {
+                corr = 1
+            }
+            covMatrix.Set(i, j, corr*vols[i]*vols[j])
+        }
+    }
+    return symbols, expectedReturns, covMatrix
+}
+
+func TestPortfolioOptimizer_WarmStart(t *testing.T) {
+    optimizer := NewPortfolioOptimizer(nil)
+    ctx := context.Background()
+    symbols, expectedReturns, covMatrix := tenAssets()
+    key := WarmStartKey(symbols)
+    const repeats = 5
+
+    var coldEvaluations int
+    var cold *OptimizationResult
+    for i := 0; i < repeats; i++ {
+        optimizer.ClearWarmStart(key)
+        cold = optimizer.optimize(ctx, symbols, expectedReturns, covMatrix, 0.5, tenAssetsRiskFreeRate)
+        assert.False(t, cold.Diagnostics.WarmStarted)
+        coldEvaluations += cold.Diagnostics.Evaluations
+    }
+
+    var warmEvaluations int
+    for i := 0; i < repeats; i++ {
+        warm := optimizer.optimize(ctx, symbols, expectedReturns, covMatrix, 0.5, tenAssetsRiskFreeRate)
+        assert.True(t, warm.Diagnostics.WarmStarted)
+        // Starting from the last result can only improve on it
+        assert.LessOrEqual(t, warm.Diagnostics.FinalObjective, cold.Diagnostics.FinalObjective)
+        warmEvaluations += warm.Diagnostics.Evaluations
+    }
+
+    assert.LessOrEqual(t, float64(warmEvaluations), 0.7*float64(coldEvaluations),
+        "warm start took %d evaluations against %d cold", warmEvaluations, coldEvaluations)
+
+    t.Run("Key ignores symbol order", func(t *testing.T) {
+        reversed := make([]string, len(symbols))
+        returns := make([]float64, len(symbols))
+        for i := range symbols {
+            reversed[len(symbols)-1-i] = symbols[i]
+            returns[len(symbols)-1-i] = expectedReturns[i]
+        }
+        assert.Equal(t, key, WarmStartKey(reversed))
+
+        start, ok := optimizer.warmStart(key, reversed)
+        if !assert.True(t, ok) {
+            return
+        }
+        stored, _ := optimizer.warmStart(key, symbols)
+        for i := range symbols {
+            assert.Equal(t, stored[i], start[len(symbols)-1-i])
+        }
+    })
+
+    t.Run("Cleared key starts cold", func(t *testing.T) {
+        optimizer.ClearWarmStart(key)
+        _, ok := optimizer.warmStart(key, symbols)
+        assert.False(t, ok)
+        // Other symbol sets share nothing with these
+        _, ok = optimizer.warmStart(WarmStartKey(symbols[:9]), symbols[:9])
+        assert.False(t, ok)
+    })
+}
+
+func BenchmarkPortfolioOptimizer_WarmStart(b *testing.B) {
+    symbols, expectedReturns, covMatrix := tenAssets()
+    ctx := context.Background()
+
+    b.Run("Cold", func(b *testing.B) {
+        optimizer := NewPortfolioOptimizer(nil)
+        var evaluations int
+        for i := 0; i < b.N; i++ {
+            optimizer.ClearWarmStart(WarmStartKey(symbols))
+            evaluations += optimizer.optimize(ctx, symbols, expectedReturns, covMatrix, 0.5, tenAssetsRiskFreeRate).Diagnostics.Evaluations
+        }
+        b.ReportMetric(float64(evaluations)/float64(b.N), "evals/op")
+    })
+
+    b.Run("Warm", func(b *testing.B) {
+        optimizer := NewPortfolioOptimizer(nil)
+        optimizer.optimize(ctx, symbols, expectedReturns, covMatrix, 0.5, tenAssetsRiskFreeRate)
+        b.ResetTimer()
+        var evaluations int
+        for i := 0; i < b.N; i++ {
+            evaluations += optimizer.optimize(ctx, symbols, expectedReturns, covMatrix, 0.5, tenAssetsRiskFreeRate).Diagnostics.Evaluations
+        }
+        b.ReportMetric(float64(evaluations)/float64(b.N), "evals/op")
+    })
+}
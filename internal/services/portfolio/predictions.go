@@ -0,0 +1,111 @@
+package portfolio
+
+import (
+    "context"
+    "time"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// PositionPrediction lays the latest prediction of a position's symbol
+// over what the position was bought at and is worth now. Prediction is nil
+// when the symbol has no valid prediction.
+type PositionPrediction struct {
+    Symbol     string          `json:"symbol"`
+    Quantity   decimal.Decimal `json:"quantity"`
+    EntryPrice decimal.Decimal `json:"entry_price"`
+    // CurrentPrice is nil when the symbol couldn't be priced
+    CurrentPrice *float64         `json:"current_price"`
+    Prediction   *PositionOutlook `json:"prediction"`
+}
+
+// PositionOutlook is a prediction as it bears on one position. Percentages
+// are the predicted price's change from the current or entry price, so
+// DownsidePct is negative when the predicted low is below the current
+// price; each is nil when the price it is measured from is unknown.
+type PositionOutlook struct {
+    PredictedLow   float64   `json:"predicted_low"`
+    PredictedHigh  float64   `json:"predicted_high"`
+    PredictedClose float64   `json:"predicted_close"`
+    UpsidePct      *float64  `json:"upside_pct"`
+    DownsidePct    *float64  `json:"downside_pct"`
+    HighVsEntryPct *float64  `json:"high_vs_entry_pct"`
+    LowVsEntryPct  *float64  `json:"low_vs_entry_pct"`
+    Confidence     float64   `json:"confidence"`
+    Conviction     float64   `json:"conviction"`
+    Degraded       bool      `json:"degraded"`
+    PredictedAt    time.Time `json:"predicted_at"`
+    ValidUntil     time.Time `json:"valid_until"`
+}
+
+// HeldSymbols returns the distinct symbols of p's positions, in the order
+// they first appear
+func HeldSymbols(p *models.Portfolio) []string {
+    seen := make(map[string]bool, len(p.Positions))
+    var symbols []string
+    for _, pos := range p.Positions {
+        if !seen[pos.Symbol] {
+            seen[pos.Symbol] = true
+            symbols = append(symbols, pos.Symbol)
+        }
+    }
+    return symbols
+}
+
+// OverlayPredictions returns a row for each of p's positions, joining it
+// to the prediction of its symbol in predictions and its price from
+// prices. Positions that can't be priced keep their row without the
+// comparisons to the current price.
+func OverlayPredictions(ctx context.Context, p *models.Portfolio, predictions map[string]*ml.StoredPrediction,
+    prices models.PriceSource) []PositionPrediction {
+    rows := make([]PositionPrediction, 0, len(p.Positions))
+    for _, pos := range p.Positions {
+        row := PositionPrediction{
+            Symbol:     pos.Symbol,
+            Quantity:   pos.Quantity,
+            EntryPrice: pos.EntryPrice,
+        }
+        if prices != nil {
+            if price, err := prices.GetPrice(ctx, pos.Symbol); err == nil {
+                row.CurrentPrice = &price
+            }
+        }
+
+        if stored := predictions[pos.Symbol]; stored != nil && stored.Prediction != nil && stored.Prediction.Combined != nil {
+            combined := stored.Prediction.Combined
+            outlook := &PositionOutlook{
+                PredictedLow:   combined.Predictions.PriceLow,
+                PredictedHigh:  combined.Predictions.PriceHigh,
+                PredictedClose: combined.Predictions.PriceClose,
+                Confidence:     combined.Confidence,
+                Conviction:     stored.Conviction,
+                Degraded:       stored.Prediction.Degraded,
+                PredictedAt:    stored.CreatedAt,
+                ValidUntil:     stored.ValidUntil,
+            }
+            if row.CurrentPrice != nil {
+                outlook.UpsidePct = percentChange(*row.CurrentPrice, outlook.PredictedHigh)
+                outlook.DownsidePct = percentChange(*row.CurrentPrice, outlook.PredictedLow)
+            }
+            entry := pos.EntryPrice.InexactFloat64()
+            outlook.HighVsEntryPct = percentChange(entry, outlook.PredictedHigh)
+            outlook.LowVsEntryPct = percentChange(entry, outlook.PredictedLow)
+            row.Prediction = outlook
+        }
+        rows = append(rows, row)
+    }
+    return rows
+}
+
+// percentChange returns the change from from to to in percent, or nil
+// without a positive price to measure from
+func percentChange(from, to float64) *float64 {
+    if from <= 0 {
+        return nil
+    }
+    pct := (to - from) / from * 100
+    return &pct
+}
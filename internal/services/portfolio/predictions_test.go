@@ -0,0 +1,61 @@
+package portfolio
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/ml"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestOverlayPredictions(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 15, 0, 0, 0, time.UTC)
+    p := &models.Portfolio{Positions: []models.Position{
+        {Symbol: "ETH", Quantity: decimal.NewFromInt(2), EntryPrice: decimal.NewFromInt(2500)},
+        {Symbol: "AAPL", Quantity: decimal.NewFromInt(10), EntryPrice: decimal.NewFromInt(150)},
+        {Symbol: "XYZ", Quantity: decimal.NewFromInt(1), EntryPrice: decimal.NewFromInt(10)},
+    }}
+    assert.Equal(t, []string{"ETH", "AAPL", "XYZ"}, HeldSymbols(p))
+
+    eth := &ml.PredictionResponse{Confidence: 0.7}
+    eth.Predictions.PriceLow = 2300
+    eth.Predictions.PriceHigh = 2750
+    aapl := &ml.PredictionResponse{Confidence: 0.9}
+    aapl.Predictions.PriceLow = 160
+    aapl.Predictions.PriceHigh = 190
+    predictions := map[string]*ml.StoredPrediction{
+        "ETH":  {Prediction: &ml.EnsemblePrediction{Symbol: "ETH", Combined: eth, Degraded: true}, Conviction: 0.35, CreatedAt: now, ValidUntil: now.Add(time.Hour)},
+        "AAPL": {Prediction: &ml.EnsemblePrediction{Symbol: "AAPL", Combined: aapl}, Conviction: 0.9, CreatedAt: now, ValidUntil: now.Add(time.Hour)},
+    }
+
+    // AAPL has no price
+    rows := OverlayPredictions(context.Background(), p, predictions, fakePrices{"ETH": 2500, "XYZ": 12})
+    require.Len(t, rows, 3)
+
+    ethRow := rows[0]
+    require.NotNil(t, ethRow.CurrentPrice)
+    require.NotNil(t, ethRow.Prediction)
+    assert.InDelta(t, 10, *ethRow.Prediction.UpsidePct, 1e-9)
+    // The predicted range implies 8% downside from the basis
+    assert.InDelta(t, -8, *ethRow.Prediction.DownsidePct, 1e-9)
+    assert.InDelta(t, -8, *ethRow.Prediction.LowVsEntryPct, 1e-9)
+    assert.True(t, ethRow.Prediction.Degraded)
+    assert.Equal(t, 0.35, ethRow.Prediction.Conviction)
+
+    aaplRow := rows[1]
+    assert.Nil(t, aaplRow.CurrentPrice)
+    require.NotNil(t, aaplRow.Prediction)
+    assert.Nil(t, aaplRow.Prediction.UpsidePct)
+    // Entry is below the predicted low
+    assert.InDelta(t, 6.6667, *aaplRow.Prediction.LowVsEntryPct, 1e-4)
+
+    // Symbols without a prediction stay, with a null prediction
+    assert.Equal(t, "XYZ", rows[2].Symbol)
+    assert.Nil(t, rows[2].Prediction)
+    assert.Equal(t, 12.0, *rows[2].CurrentPrice)
+}
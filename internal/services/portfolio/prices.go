@@ -0,0 +1,90 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// DBPriceSource reads prices from the market_data table
+type DBPriceSource struct {
+    db *sql.DB
+}
+
+func NewDBPriceSource(db *sql.DB) *DBPriceSource {
+    return &DBPriceSource{db: db}
+}
+
+func (s *DBPriceSource) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    var price float64
+    query := `
+        SELECT close FROM market_data
+        WHERE symbol = $1
+        ORDER BY timestamp DESC
+        LIMIT 1
+    `
+    err := s.db.QueryRowContext(ctx, query, symbol).Scan(&price)
+    if err == sql.ErrNoRows {
+        return 0, fmt.Errorf("no market data for %s", symbol)
+    }
+    return price, err
+}
+
+// GetPreviousClose returns the last close before the start of the current
+// UTC day, or 0 if there is none
+func (s *DBPriceSource) GetPreviousClose(ctx context.Context, symbol string) (float64, error) {
+    var price float64
+    query := `
+        SELECT close FROM market_data
+        WHERE symbol = $1 AND timestamp < date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+        ORDER BY timestamp DESC
+        LIMIT 1
+    `
+    err := s.db.QueryRowContext(ctx, query, symbol).Scan(&price)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return price, err
+}
+
+// CachedPriceSource reads prices from the market data cache and falls back
+// to another source on a cache miss
+type CachedPriceSource struct {
+    cache    *cache.MarketDataCache
+    fallback models.PriceSource
+}
+
+func NewCachedPriceSource(c *cache.MarketDataCache, fallback models.PriceSource) *CachedPriceSource {
+    return &CachedPriceSource{cache: c, fallback: fallback}
+}
+
+func (s *CachedPriceSource) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    defer monitoring.StartStage(ctx, monitoring.StagePrices)()
+    data, err := s.cache.GetMarketData(ctx, symbol)
+    if err == nil && data != nil && data.Close > 0 {
+        return data.Close, nil
+    }
+    if s.fallback == nil {
+        if err != nil {
+            return 0, err
+        }
+        return 0, fmt.Errorf("no cached price for %s", symbol)
+    }
+    return s.fallback.GetPrice(ctx, symbol)
+}
+
+func (s *CachedPriceSource) GetPreviousClose(ctx context.Context, symbol string) (float64, error) {
+    defer monitoring.StartStage(ctx, monitoring.StagePrices)()
+    data, err := s.cache.GetMarketData(ctx, symbol)
+    if err == nil && data != nil && data.PreviousClose > 0 {
+        return data.PreviousClose, nil
+    }
+    if closes, ok := s.fallback.(models.PreviousCloseSource); ok {
+        return closes.GetPreviousClose(ctx, symbol)
+    }
+    return 0, nil
+}
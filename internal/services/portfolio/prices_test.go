@@ -0,0 +1,61 @@
+package portfolio
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Portfolio valuations read prices through the cache. Killing Redis midway
+// must only move the reads to the database.
+func TestCachedPriceSource_RedisOutage(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    mr := miniredis.RunT(t)
+    client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+    health := cache.NewRedisHealth(client, time.Minute, nil)
+    marketCache := cache.NewMarketDataCache(client, time.Minute, nil, nil).WithHealth(health)
+    prices := NewCachedPriceSource(marketCache, NewDBPriceSource(db))
+    ctx := context.Background()
+
+    assert.NoError(t, marketCache.SetMarketData(ctx, "AAPL", &models.MarketData{Symbol: "AAPL", Close: 190, PreviousClose: 188}))
+
+    // Served from Redis, without touching the database
+    price, err := prices.GetPrice(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Equal(t, 190.0, price)
+    prev, err := prices.GetPreviousClose(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Equal(t, 188.0, prev)
+
+    mr.Close()
+
+    mock.ExpectQuery("SELECT close FROM market_data").
+        WithArgs("AAPL").
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).AddRow(189.5))
+    mock.ExpectQuery("SELECT close FROM market_data").
+        WithArgs("AAPL").
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).AddRow(187.0))
+
+    price, err = prices.GetPrice(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Equal(t, 189.5, price)
+    prev, err = prices.GetPreviousClose(ctx, "AAPL")
+    assert.NoError(t, err)
+    assert.Equal(t, 187.0, prev)
+
+    assert.Equal(t, cache.RedisDegraded, health.Status())
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
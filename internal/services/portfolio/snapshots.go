@@ -0,0 +1,414 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+// Snapshotter records each portfolio's value and holdings once per
+// calendar day in portfolio_snapshots
+type Snapshotter struct {
+    db      *sql.DB
+    prices  models.PriceSource
+    crypto  calendar.CryptoBoundary
+    trading *calendar.Calendars
+    metrics *monitoring.PortfolioMetrics
+    now     func() time.Time
+}
+
+func NewSnapshotter(db *sql.DB, prices models.PriceSource, crypto calendar.CryptoBoundary) *Snapshotter {
+    return &Snapshotter{db: db, prices: prices, crypto: crypto, trading: calendar.NewCalendars(), now: time.Now}
+}
+
+// WithTradingCalendars replaces the built-in calendars of the days equity
+// portfolios are snapshotted on
+func (s *Snapshotter) WithTradingCalendars(trading *calendar.Calendars) *Snapshotter {
+    s.trading = trading
+    return s
+}
+
+// WithMetrics publishes the portfolio value and return distributions to
+// metrics after every run
+func (s *Snapshotter) WithMetrics(metrics *monitoring.PortfolioMetrics) *Snapshotter {
+    s.metrics = metrics
+    return s
+}
+
+type snapshotHolding struct {
+    symbol     string
+    quantity   float64
+    assetClass string
+    timezone   string
+}
+
+type snapshotPortfolio struct {
+    id       int64
+    balance  float64
+    userTZ   string
+    holdings []snapshotHolding
+    // cash is the cash held in currencies other than the portfolio's
+    cash map[string]float64
+}
+
+// location returns the calendar the portfolio's days are counted in.
+// Portfolios of equities on one exchange follow that exchange and are only
+// snapshotted on its trading days; equities across exchanges follow UTC.
+// Portfolios holding crypto, or nothing but cash, trade every day and
+// follow the crypto boundary.
+func (p *snapshotPortfolio) location(crypto calendar.CryptoBoundary) (loc *time.Location, weekdaysOnly bool) {
+    around := calendar.Location(calendar.AssetClassCrypto, "", p.userTZ, crypto)
+    if len(p.holdings) == 0 {
+        return around, false
+    }
+
+    timezone := p.holdings[0].timezone
+    for _, h := range p.holdings {
+        if h.assetClass == calendar.AssetClassCrypto {
+            return around, false
+        }
+        if h.timezone != timezone {
+            timezone = ""
+        }
+    }
+    return calendar.Location("equity", timezone, p.userTZ, crypto), true
+}
+
+// Run values every portfolio and upserts its snapshot for today in the
+// portfolio's calendar. Each run overwrites the day's value, so running
+// more often than daily leaves the last valuation of each day as its close.
+// A portfolio that can't be valued is skipped and reported in the error.
+// The metrics are then published from the latest snapshots.
+func (s *Snapshotter) Run(ctx context.Context) error {
+    portfolios, err := s.load(ctx)
+    if err != nil {
+        return err
+    }
+
+    now := s.now()
+    var failed int
+    var firstErr error
+    for _, p := range portfolios {
+        if err := s.snapshot(ctx, p, now); err != nil {
+            failed++
+            if firstErr == nil {
+                firstErr = err
+            }
+        }
+    }
+
+    metricsErr := s.publishMetrics(ctx)
+    if failed > 0 {
+        return fmt.Errorf("failed to snapshot %d of %d portfolios: %w", failed, len(portfolios), firstErr)
+    }
+    return metricsErr
+}
+
+// metricsTimeframes are the returns published to the metrics, as days
+// before each portfolio's latest snapshot
+var metricsTimeframes = []struct {
+    name string
+    days int
+}{{"24h", 1}, {"7d", 7}, {"30d", 30}}
+
+// publishMetrics reads every live portfolio's latest snapshot, with the
+// snapshots its returns are measured from, and replaces the published
+// metrics with them. Portfolios without a snapshot far enough back have no
+// return over that timeframe.
+func (s *Snapshotter) publishMetrics(ctx context.Context) error {
+    if s.metrics == nil {
+        return nil
+    }
+
+    query := `
+        WITH latest AS (
+            SELECT DISTINCT ON (ps.portfolio_id) ps.portfolio_id, ps.snapshot_date, ps.total_value, u.base_currency
+            FROM portfolio_snapshots ps
+            JOIN portfolios p ON p.id = ps.portfolio_id
+            JOIN users u ON u.id = p.user_id
+            WHERE p.deleted_at IS NULL AND u.deleted_at IS NULL
+            ORDER BY ps.portfolio_id, ps.snapshot_date DESC
+        )
+        SELECT l.portfolio_id, l.total_value, l.base_currency, tf.days, prev.total_value
+        FROM latest l
+        CROSS JOIN unnest($1::int[]) AS tf(days)
+        LEFT JOIN LATERAL (
+            SELECT total_value FROM portfolio_snapshots
+            WHERE portfolio_id = l.portfolio_id AND snapshot_date <= l.snapshot_date - tf.days
+            ORDER BY snapshot_date DESC
+            LIMIT 1
+        ) prev ON true
+        ORDER BY l.portfolio_id, tf.days
+    `
+    days := make([]int64, len(metricsTimeframes))
+    names := make(map[int64]string, len(metricsTimeframes))
+    for i, tf := range metricsTimeframes {
+        days[i] = int64(tf.days)
+        names[days[i]] = tf.name
+    }
+    rows, err := s.db.QueryContext(ctx, query, pq.Array(days))
+    if err != nil {
+        return fmt.Errorf("failed to load portfolio metrics: %w", err)
+    }
+    defer rows.Close()
+
+    var samples []monitoring.PortfolioSample
+    var lastID int64
+    for rows.Next() {
+        var id, day int64
+        var value float64
+        var currency string
+        var previous sql.NullFloat64
+        if err := rows.Scan(&id, &value, &currency, &day, &previous); err != nil {
+            return err
+        }
+
+        if len(samples) == 0 || id != lastID {
+            samples = append(samples, monitoring.PortfolioSample{
+                PortfolioID: strconv.FormatInt(id, 10),
+                Value:       value,
+                Currency:    currency,
+                Returns:     make(map[string]float64),
+            })
+            lastID = id
+        }
+        if previous.Valid && previous.Float64 > 0 {
+            samples[len(samples)-1].Returns[names[day]] = value/previous.Float64 - 1
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    s.metrics.Update(samples)
+    return nil
+}
+
+func (s *Snapshotter) snapshot(ctx context.Context, p *snapshotPortfolio, now time.Time) error {
+    loc, weekdaysOnly := p.location(s.crypto)
+    if weekdaysOnly && !s.trading.For(p.holdings[0].assetClass).IsTradingDay(now.In(loc)) {
+        return nil
+    }
+
+    // Foreign cash is valued at the price of the currency pair, e.g. EURUSD
+    value := p.balance
+    cash := map[string]float64{models.PortfolioCurrency: p.balance}
+    for currency, amount := range p.cash {
+        rate, err := models.CashRate(ctx, s.prices, currency)
+        if err != nil {
+            return fmt.Errorf("failed to price %s cash in portfolio %d: %w", currency, p.id, err)
+        }
+        value += amount * rate
+        cash[currency] = amount
+    }
+    balances, err := json.Marshal(cash)
+    if err != nil {
+        return err
+    }
+
+    assets := make([]models.Asset, 0, len(p.holdings))
+    for _, h := range p.holdings {
+        price, err := s.prices.GetPrice(ctx, h.symbol)
+        if err != nil {
+            return fmt.Errorf("failed to price %s in portfolio %d: %w", h.symbol, p.id, err)
+        }
+        value += h.quantity * price
+        assets = append(assets, models.Asset{
+            Symbol:     h.symbol,
+            Type:       h.assetClass,
+            Quantity:   decimal.NewFromFloat(h.quantity),
+            Value:      decimal.NewFromFloat(h.quantity * price),
+            LastUpdate: now,
+        })
+    }
+    holdings, err := json.Marshal(assets)
+    if err != nil {
+        return err
+    }
+
+    query := `
+        INSERT INTO portfolio_snapshots (portfolio_id, snapshot_date, total_value, timezone, taken_at, assets, cash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (portfolio_id, snapshot_date)
+        DO UPDATE SET total_value = EXCLUDED.total_value, timezone = EXCLUDED.timezone,
+            taken_at = EXCLUDED.taken_at, assets = EXCLUDED.assets, cash = EXCLUDED.cash
+    `
+    // The date is sent as text so the database session's timezone can't
+    // move it to a neighbouring day
+    day := calendar.Date(now, loc).Format("2006-01-02")
+    if _, err := s.db.ExecContext(ctx, query, p.id, day, value, loc.String(), now, holdings, balances); err != nil {
+        return fmt.Errorf("failed to save snapshot of portfolio %d: %w", p.id, err)
+    }
+    return nil
+}
+
+func (s *Snapshotter) load(ctx context.Context) ([]*snapshotPortfolio, error) {
+    query := `
+        SELECT p.id, p.balance, u.timezone, pos.symbol, pos.quantity,
+            COALESCE(sm.asset_class, 'equity'), COALESCE(sm.timezone, 'UTC')
+        FROM portfolios p
+        JOIN users u ON u.id = p.user_id
+        LEFT JOIN positions pos ON pos.portfolio_id = p.id
+        LEFT JOIN symbol_metadata sm ON sm.symbol = pos.symbol
+        WHERE u.deleted_at IS NULL
+        ORDER BY p.id
+    `
+    rows, err := s.db.QueryContext(ctx, query)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load portfolios to snapshot: %w", err)
+    }
+    defer rows.Close()
+
+    var portfolios []*snapshotPortfolio
+    for rows.Next() {
+        var p snapshotPortfolio
+        var symbol sql.NullString
+        var quantity sql.NullFloat64
+        var h snapshotHolding
+        if err := rows.Scan(&p.id, &p.balance, &p.userTZ, &symbol, &quantity, &h.assetClass, &h.timezone); err != nil {
+            return nil, err
+        }
+
+        if n := len(portfolios); n == 0 || portfolios[n-1].id != p.id {
+            portfolios = append(portfolios, &p)
+        }
+        if symbol.Valid {
+            h.symbol, h.quantity = symbol.String, quantity.Float64
+            last := portfolios[len(portfolios)-1]
+            last.holdings = append(last.holdings, h)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    byID := make(map[int64]*snapshotPortfolio, len(portfolios))
+    for _, p := range portfolios {
+        byID[p.id] = p
+    }
+    cashRows, err := s.db.QueryContext(ctx, `SELECT portfolio_id, currency, amount FROM portfolio_cash WHERE amount <> 0`)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load cash to snapshot: %w", err)
+    }
+    defer cashRows.Close()
+    for cashRows.Next() {
+        var id int64
+        var currency string
+        var amount float64
+        if err := cashRows.Scan(&id, &currency, &amount); err != nil {
+            return nil, err
+        }
+        if p, ok := byID[id]; ok {
+            if p.cash == nil {
+                p.cash = make(map[string]float64)
+            }
+            p.cash[models.CashCurrency(currency)] = amount
+        }
+    }
+    return portfolios, cashRows.Err()
+}
+
+// Revalue recomputes the portfolio's snapshots dated from to to, either of
+// which may be zero to leave that end open. Each snapshot's recorded
+// holdings are repriced at the last close at or before it was taken; the
+// cash it recorded is kept.
+func (s *Snapshotter) Revalue(ctx context.Context, portfolioID int64, from, to time.Time) error {
+    snapshots, err := s.storedSnapshots(ctx, portfolioID, from, to)
+    if err != nil {
+        return err
+    }
+
+    for _, snap := range snapshots {
+        value := snap.value
+        for i, asset := range snap.assets {
+            price, err := s.closeAt(ctx, asset.Symbol, snap.takenAt)
+            if err != nil {
+                return err
+            }
+            revalued := asset.Quantity.Mul(decimal.NewFromFloat(price))
+            value += revalued.Sub(asset.Value).InexactFloat64()
+            snap.assets[i].Value = revalued
+        }
+        holdings, err := json.Marshal(snap.assets)
+        if err != nil {
+            return err
+        }
+
+        query := `
+            UPDATE portfolio_snapshots SET total_value = $3, assets = $4
+            WHERE portfolio_id = $1 AND snapshot_date = $2
+        `
+        if _, err := s.db.ExecContext(ctx, query, portfolioID, snap.date.Format("2006-01-02"), value, holdings); err != nil {
+            return fmt.Errorf("failed to revalue snapshot of portfolio %d: %w", portfolioID, err)
+        }
+    }
+    return nil
+}
+
+type storedSnapshot struct {
+    date    time.Time
+    takenAt time.Time
+    value   float64
+    assets  []models.Asset
+}
+
+func (s *Snapshotter) storedSnapshots(ctx context.Context, portfolioID int64, from, to time.Time) ([]storedSnapshot, error) {
+    lower, upper := "-infinity", "infinity"
+    if !from.IsZero() {
+        lower = from.Format("2006-01-02")
+    }
+    if !to.IsZero() {
+        upper = to.Format("2006-01-02")
+    }
+
+    query := `
+        SELECT snapshot_date, taken_at, total_value, assets
+        FROM portfolio_snapshots
+        WHERE portfolio_id = $1 AND snapshot_date BETWEEN $2 AND $3
+        ORDER BY snapshot_date
+    `
+    rows, err := s.db.QueryContext(ctx, query, portfolioID, lower, upper)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get snapshots of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+
+    var snapshots []storedSnapshot
+    for rows.Next() {
+        var snap storedSnapshot
+        var raw []byte
+        if err := rows.Scan(&snap.date, &snap.takenAt, &snap.value, &raw); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(raw, &snap.assets); err != nil {
+            return nil, fmt.Errorf("snapshot of portfolio %d on %s: %w", portfolioID, snap.date.Format("2006-01-02"), err)
+        }
+        snapshots = append(snapshots, snap)
+    }
+    return snapshots, rows.Err()
+}
+
+func (s *Snapshotter) closeAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+    var price float64
+    query := `
+        SELECT close FROM market_data
+        WHERE symbol = $1 AND timestamp <= $2
+        ORDER BY timestamp DESC
+        LIMIT 1
+    `
+    err := s.db.QueryRowContext(ctx, query, symbol, at).Scan(&price)
+    if err == sql.ErrNoRows {
+        return 0, fmt.Errorf("no market data for %s at %s", symbol, at.Format(time.RFC3339))
+    }
+    return price, err
+}
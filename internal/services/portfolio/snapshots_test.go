@@ -0,0 +1,233 @@
+package portfolio
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/calendar"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+)
+
+type fakePrices map[string]float64
+
+func (f fakePrices) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    price, ok := f[symbol]
+    if !ok {
+        return 0, fmt.Errorf("no market data for %s", symbol)
+    }
+    return price, nil
+}
+
+var snapshotColumns = []string{"id", "balance", "timezone", "symbol", "quantity", "asset_class", "symbol_timezone"}
+
+func snapshotRows() *sqlmock.Rows {
+    return sqlmock.NewRows(snapshotColumns).
+        AddRow(1, 1000.0, "Asia/Tokyo", "SPY", 10.0, "equity", "America/New_York").
+        AddRow(2, 0.0, "UTC", "BTC", 0.5, "crypto", "UTC").
+        AddRow(3, 500.0, "Asia/Tokyo", nil, nil, "equity", "UTC")
+}
+
+// expectCash expects the cash held in currencies other than the portfolio's,
+// 200 EUR in portfolio 3 of snapshotRows
+func expectCash(mock sqlmock.Sqlmock) {
+    mock.ExpectQuery("SELECT portfolio_id, currency, amount FROM portfolio_cash").
+        WillReturnRows(sqlmock.NewRows([]string{"portfolio_id", "currency", "amount"}).AddRow(3, "EUR", 200.0))
+}
+
+func TestSnapshotter_Run(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    snapshotter := NewSnapshotter(db, fakePrices{"SPY": 500, "BTC": 60000, "EURUSD": 1.1}, calendar.CryptoUserLocal)
+    ctx := context.Background()
+
+    expectSnapshot := func(id int64, day string, value float64, timezone string, now time.Time) {
+        mock.ExpectExec("INSERT INTO portfolio_snapshots (.+) ON CONFLICT \\(portfolio_id, snapshot_date\\)").
+            WithArgs(id, day, value, timezone, now, sqlmock.AnyArg(), sqlmock.AnyArg()).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+    }
+
+    t.Run("Days follow each portfolio's calendar", func(t *testing.T) {
+        // Monday evening in New York, Tuesday in UTC and Tokyo
+        now := time.Date(2024, time.March, 12, 2, 30, 0, 0, time.UTC)
+        snapshotter.now = func() time.Time { return now }
+
+        mock.ExpectQuery("SELECT (.+) FROM portfolios p JOIN users u (.+) LEFT JOIN symbol_metadata").
+            WillReturnRows(snapshotRows())
+        expectCash(mock)
+        expectSnapshot(1, "2024-03-11", 6000, "America/New_York", now)
+        expectSnapshot(2, "2024-03-12", 30000, "UTC", now)
+        // The cash in each currency is recorded, and valued in the
+        // portfolio's
+        mock.ExpectExec("INSERT INTO portfolio_snapshots").
+            WithArgs(int64(3), "2024-03-12", 720.0, "Asia/Tokyo", now, []byte("[]"), []byte(`{"EUR":200,"USD":500}`)).
+            WillReturnResult(sqlmock.NewResult(0, 1))
+
+        assert.NoError(t, snapshotter.Run(ctx))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Equities skip their exchange's weekend", func(t *testing.T) {
+        // Sunday evening in New York, the day after the DST change
+        now := time.Date(2024, time.March, 11, 2, 30, 0, 0, time.UTC)
+        snapshotter.now = func() time.Time { return now }
+
+        mock.ExpectQuery("SELECT (.+) FROM portfolios p JOIN users u (.+) LEFT JOIN symbol_metadata").
+            WillReturnRows(snapshotRows())
+        expectCash(mock)
+        expectSnapshot(2, "2024-03-11", 30000, "UTC", now)
+        expectSnapshot(3, "2024-03-11", 720, "Asia/Tokyo", now)
+
+        assert.NoError(t, snapshotter.Run(ctx))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Equities skip their exchange's holidays", func(t *testing.T) {
+        // Good Friday evening in New York, when the NYSE is shut
+        now := time.Date(2024, time.March, 29, 22, 0, 0, 0, time.UTC)
+        snapshotter.now = func() time.Time { return now }
+
+        mock.ExpectQuery("SELECT (.+) FROM portfolios p JOIN users u (.+) LEFT JOIN symbol_metadata").
+            WillReturnRows(snapshotRows())
+        expectCash(mock)
+        expectSnapshot(2, "2024-03-29", 30000, "UTC", now)
+        expectSnapshot(3, "2024-03-30", 720, "Asia/Tokyo", now)
+
+        assert.NoError(t, snapshotter.Run(ctx))
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("Unpriced portfolio is reported", func(t *testing.T) {
+        now := time.Date(2024, time.March, 12, 2, 30, 0, 0, time.UTC)
+        snapshotter.now = func() time.Time { return now }
+
+        mock.ExpectQuery("SELECT (.+) FROM portfolios p JOIN users u (.+) LEFT JOIN symbol_metadata").
+            WillReturnRows(sqlmock.NewRows(snapshotColumns).
+                AddRow(4, 0.0, "UTC", "DOGE", 100.0, "crypto", "UTC").
+                AddRow(5, 250.0, "UTC", nil, nil, "equity", "UTC"))
+        mock.ExpectQuery("SELECT portfolio_id, currency, amount FROM portfolio_cash").
+            WillReturnRows(sqlmock.NewRows([]string{"portfolio_id", "currency", "amount"}))
+        expectSnapshot(5, "2024-03-12", 250, "UTC", now)
+
+        err := snapshotter.Run(ctx)
+        if assert.Error(t, err) {
+            assert.Contains(t, err.Error(), "1 of 2 portfolios")
+            assert.Contains(t, err.Error(), "DOGE")
+        }
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestSnapshotter_Revalue(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    snapshotter := NewSnapshotter(db, fakePrices{}, calendar.CryptoUTC)
+    takenAt := time.Date(2024, time.March, 12, 23, 45, 0, 0, time.UTC)
+    // 1000 cash plus 10 SPY recorded at 500
+    assets := `[{"symbol":"SPY","type":"equity","quantity":"10","value":"5000"}]`
+
+    mock.ExpectQuery("SELECT snapshot_date, taken_at, total_value, assets FROM portfolio_snapshots").
+        WithArgs(int64(1), "2024-03-01", "infinity").
+        WillReturnRows(sqlmock.NewRows([]string{"snapshot_date", "taken_at", "total_value", "assets"}).
+            AddRow(time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC), takenAt, 6000.0, []byte(assets)))
+    mock.ExpectQuery("SELECT close FROM market_data").
+        WithArgs("SPY", takenAt).
+        WillReturnRows(sqlmock.NewRows([]string{"close"}).AddRow(510.0))
+    mock.ExpectExec("UPDATE portfolio_snapshots SET total_value").
+        WithArgs(int64(1), "2024-03-12", 6100.0, sqlmock.AnyArg()).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    err = snapshotter.Revalue(context.Background(), 1, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+    assert.NoError(t, err)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnapshotter_PublishMetrics(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    reg := prometheus.NewRegistry()
+    snapshotter := NewSnapshotter(db, fakePrices{}, calendar.CryptoUTC).
+        WithMetrics(monitoring.NewPortfolioMetrics(reg, []string{"1"}))
+
+    mock.ExpectQuery("SELECT (.+) FROM portfolios p JOIN users u (.+) LEFT JOIN symbol_metadata").
+        WillReturnRows(sqlmock.NewRows(snapshotColumns))
+    mock.ExpectQuery("SELECT portfolio_id, currency, amount FROM portfolio_cash").
+        WillReturnRows(sqlmock.NewRows([]string{"portfolio_id", "currency", "amount"}))
+    // Portfolio 1 has only been snapshotted for a week and portfolio 2 was
+    // worth nothing a month ago
+    mock.ExpectQuery("WITH latest AS (.+) DISTINCT ON \\(ps.portfolio_id\\) (.+) LEFT JOIN LATERAL").
+        WithArgs("{1,7,30}").
+        WillReturnRows(sqlmock.NewRows([]string{"portfolio_id", "total_value", "base_currency", "days", "total_value"}).
+            AddRow(1, 1100.0, "USD", 1, 1000.0).
+            AddRow(1, 1100.0, "USD", 7, 880.0).
+            AddRow(1, 1100.0, "USD", 30, nil).
+            AddRow(2, 5000.0, "EUR", 1, 5000.0).
+            AddRow(2, 5000.0, "EUR", 7, 4000.0).
+            AddRow(2, 5000.0, "EUR", 30, 0.0))
+
+    assert.NoError(t, snapshotter.Run(context.Background()))
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    expected := `
+# HELP portfolio_aum Total value of all portfolios
+# TYPE portfolio_aum gauge
+portfolio_aum 6100
+# HELP portfolio_return_rate Return rate of an allowlisted portfolio over the timeframe
+# TYPE portfolio_return_rate gauge
+portfolio_return_rate{portfolio_id="1",timeframe="24h"} 0.10000000000000009
+portfolio_return_rate{portfolio_id="1",timeframe="7d"} 0.25
+# HELP portfolio_value Current value of an allowlisted portfolio
+# TYPE portfolio_value gauge
+portfolio_value{currency="USD",portfolio_id="1"} 1100
+`
+    assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+        "portfolio_aum", "portfolio_return_rate", "portfolio_value"))
+}
+
+func TestSnapshotPortfolio_Location(t *testing.T) {
+    nyse := snapshotHolding{symbol: "SPY", assetClass: "equity", timezone: "America/New_York"}
+    lse := snapshotHolding{symbol: "VOD", assetClass: "equity", timezone: "Europe/London"}
+    btc := snapshotHolding{symbol: "BTC", assetClass: "crypto", timezone: "UTC"}
+
+    tests := []struct {
+        name         string
+        holdings     []snapshotHolding
+        crypto       calendar.CryptoBoundary
+        want         string
+        weekdaysOnly bool
+    }{
+        {"One exchange", []snapshotHolding{nyse, nyse}, calendar.CryptoUserLocal, "America/New_York", true},
+        {"Several exchanges", []snapshotHolding{nyse, lse}, calendar.CryptoUserLocal, "UTC", true},
+        {"Crypto in user time", []snapshotHolding{nyse, btc}, calendar.CryptoUserLocal, "Asia/Tokyo", false},
+        {"Crypto in UTC", []snapshotHolding{btc}, calendar.CryptoUTC, "UTC", false},
+        {"Cash only", nil, calendar.CryptoUserLocal, "Asia/Tokyo", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := &snapshotPortfolio{userTZ: "Asia/Tokyo", holdings: tt.holdings}
+            loc, weekdaysOnly := p.location(tt.crypto)
+            assert.Equal(t, tt.want, loc.String())
+            assert.Equal(t, tt.weekdaysOnly, weekdaysOnly)
+        })
+    }
+}
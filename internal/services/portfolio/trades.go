@@ -0,0 +1,66 @@
+package portfolio
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+var (
+    // ErrInsufficientQuantity is returned when a sell exceeds the open position
+    ErrInsufficientQuantity = errors.New("insufficient quantity")
+    // ErrInsufficientBalance is returned when a buy or withdrawal takes more
+    // cash than a portfolio without margin holds in its currency
+    ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// ApplyTrade books a trade against a portfolio's position and its cash in
+// the trade's currency. Buys move the entry price to the weighted average
+// cost, sells keep it and realise PnL against it. The realised PnL (net of
+// fees) is returned.
+func ApplyTrade(p *models.Portfolio, pos *models.Position, trade models.Trade) (decimal.Decimal, error) {
+    if !trade.Quantity.IsPositive() || !trade.Price.IsPositive() {
+        return decimal.Zero, fmt.Errorf("invalid trade %s %s@%s", trade.Symbol, trade.Quantity, trade.Price)
+    }
+    if pos.Symbol != "" && pos.Symbol != trade.Symbol {
+        return decimal.Zero, fmt.Errorf("trade symbol %s does not match position %s", trade.Symbol, pos.Symbol)
+    }
+
+    notional := trade.Notional()
+
+    switch trade.Side {
+    case models.Buy:
+        cost := notional.Add(trade.Fee)
+        if cost.GreaterThan(p.Cash(trade.Currency)) && !p.MarginEnabled {
+            return decimal.Zero, ErrInsufficientBalance
+        }
+
+        quantity := pos.Quantity.Add(trade.Quantity)
+        pos.EntryPrice = pos.CostBasis().Add(notional).Div(quantity)
+        pos.Quantity = quantity
+        pos.Symbol = trade.Symbol
+        p.AddCash(trade.Currency, cost.Neg())
+
+        return trade.Fee.Neg(), nil
+
+    case models.Sell:
+        if trade.Quantity.GreaterThan(pos.Quantity) {
+            return decimal.Zero, ErrInsufficientQuantity
+        }
+
+        realised := trade.Price.Sub(pos.EntryPrice).Mul(trade.Quantity).Sub(trade.Fee)
+        pos.Quantity = pos.Quantity.Sub(trade.Quantity)
+        if pos.Quantity.IsZero() {
+            pos.EntryPrice = decimal.Zero
+        }
+        p.AddCash(trade.Currency, notional.Sub(trade.Fee))
+
+        return realised, nil
+
+    default:
+        return decimal.Zero, fmt.Errorf("unknown trade side %q", trade.Side)
+    }
+}
@@ -0,0 +1,133 @@
+package portfolio
+
+import (
+    "testing"
+
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func d(s string) decimal.Decimal {
+    return decimal.RequireFromString(s)
+}
+
+func TestApplyTrade_Reconciliation(t *testing.T) {
+    t.Run("Repeated fractional buys sum exactly", func(t *testing.T) {
+        p := &models.Portfolio{Balance: d("10000")}
+        pos := &models.Position{}
+
+        for i := 0; i < 10; i++ {
+            _, err := ApplyTrade(p, pos, models.Trade{
+                Symbol:   "BTC",
+                Side:     models.Buy,
+                Quantity: d("0.1"),
+                Price:    d("1000.1"),
+            })
+            assert.NoError(t, err)
+        }
+
+        assert.True(t, d("1").Equal(pos.Quantity), "quantity %s", pos.Quantity)
+        assert.True(t, d("1000.1").Equal(pos.EntryPrice), "entry price %s", pos.EntryPrice)
+        assert.True(t, d("8999.9").Equal(p.Balance), "balance %s", p.Balance)
+    })
+
+    t.Run("Round trip realised PnL matches cash", func(t *testing.T) {
+        initial := d("10000")
+        p := &models.Portfolio{Balance: initial}
+        pos := &models.Position{}
+
+        trades := []models.Trade{
+            {Symbol: "ETH", Side: models.Buy, Quantity: d("1.5"), Price: d("2000.10"), Fee: d("0.3")},
+            {Symbol: "ETH", Side: models.Buy, Quantity: d("0.5"), Price: d("2100.30"), Fee: d("0.1")},
+            {Symbol: "ETH", Side: models.Sell, Quantity: d("0.7"), Price: d("2200.01"), Fee: d("0.15")},
+            {Symbol: "ETH", Side: models.Sell, Quantity: d("1.3"), Price: d("1999.99"), Fee: d("0.25")},
+        }
+
+        realised := decimal.Zero
+        for _, trade := range trades {
+            pnl, err := ApplyTrade(p, pos, trade)
+            assert.NoError(t, err)
+            realised = realised.Add(pnl)
+        }
+
+        assert.True(t, pos.Quantity.IsZero())
+        assert.True(t, realised.Equal(p.Balance.Sub(initial)), "realised %s, cash delta %s", realised, p.Balance.Sub(initial))
+    })
+
+    t.Run("Reject sell larger than position", func(t *testing.T) {
+        p := &models.Portfolio{Balance: d("100")}
+        pos := &models.Position{Symbol: "AAPL", Quantity: d("1"), EntryPrice: d("50")}
+
+        _, err := ApplyTrade(p, pos, models.Trade{Symbol: "AAPL", Side: models.Sell, Quantity: d("1.00000001"), Price: d("60")})
+        assert.ErrorIs(t, err, ErrInsufficientQuantity)
+        assert.True(t, d("1").Equal(pos.Quantity))
+        assert.True(t, d("100").Equal(p.Balance))
+    })
+
+    t.Run("Reject buy larger than balance", func(t *testing.T) {
+        p := &models.Portfolio{Balance: d("100")}
+        pos := &models.Position{}
+
+        _, err := ApplyTrade(p, pos, models.Trade{Symbol: "AAPL", Side: models.Buy, Quantity: d("2"), Price: d("50"), Fee: d("0.01")})
+        assert.ErrorIs(t, err, ErrInsufficientBalance)
+        assert.True(t, pos.Quantity.IsZero())
+    })
+}
+
+func TestApplyTrade_Cash(t *testing.T) {
+    t.Run("Reject overdraft", func(t *testing.T) {
+        p := &models.Portfolio{Balance: d("1000")}
+        pos := &models.Position{}
+
+        _, err := ApplyTrade(p, pos, models.Trade{Symbol: "BTC", Side: models.Buy, Quantity: d("1"), Price: d("1000"), Fee: d("0.01")})
+        assert.ErrorIs(t, err, ErrInsufficientBalance)
+        assert.True(t, d("1000").Equal(p.Balance))
+        assert.Nil(t, p.CashBalance)
+
+        // Spending exactly the cash held is no overdraft
+        _, err = ApplyTrade(p, pos, models.Trade{Symbol: "BTC", Side: models.Buy, Quantity: d("1"), Price: d("999.99"), Fee: d("0.01")})
+        assert.NoError(t, err)
+        assert.True(t, p.Balance.IsZero(), "balance %s", p.Balance)
+    })
+
+    t.Run("Margin allows overdraft", func(t *testing.T) {
+        p := &models.Portfolio{Balance: d("1000"), MarginEnabled: true}
+        pos := &models.Position{}
+
+        _, err := ApplyTrade(p, pos, models.Trade{Symbol: "BTC", Side: models.Buy, Quantity: d("2"), Price: d("1000")})
+        assert.NoError(t, err)
+        assert.True(t, d("-1000").Equal(p.Balance), "balance %s", p.Balance)
+        assert.True(t, d("-1000").Equal(p.CashBalance[models.PortfolioCurrency]))
+    })
+
+    t.Run("Multi-currency buckets", func(t *testing.T) {
+        p := &models.Portfolio{
+            Balance:     d("500"),
+            CashBalance: models.CashBalance{"USD": d("500"), "EUR": d("2000")},
+        }
+        sap := &models.Position{}
+
+        // A EUR buy is paid from the EUR bucket, however much USD there is
+        _, err := ApplyTrade(p, sap, models.Trade{Symbol: "SAP", Side: models.Buy, Quantity: d("10"), Price: d("180"), Fee: d("1"), Currency: "eur"})
+        assert.NoError(t, err)
+        assert.True(t, d("199").Equal(p.Cash("EUR")), "EUR %s", p.Cash("EUR"))
+        assert.True(t, d("500").Equal(p.Balance))
+
+        // ...and can't overdraw it even though USD would cover the cost
+        _, err = ApplyTrade(p, sap, models.Trade{Symbol: "SAP", Side: models.Buy, Quantity: d("2"), Price: d("180"), Currency: "EUR"})
+        assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+        // A sale in a currency not yet held opens its bucket
+        _, err = ApplyTrade(p, sap, models.Trade{Symbol: "SAP", Side: models.Sell, Quantity: d("5"), Price: d("200"), Currency: "GBP"})
+        assert.NoError(t, err)
+        assert.Equal(t, models.CashBalance{"USD": d("500"), "EUR": d("199"), "GBP": d("1000")}, p.CashBalance)
+
+        // Trades without a currency settle in the portfolio currency
+        aapl := &models.Position{}
+        _, err = ApplyTrade(p, aapl, models.Trade{Symbol: "AAPL", Side: models.Buy, Quantity: d("1"), Price: d("200")})
+        assert.NoError(t, err)
+        assert.True(t, d("300").Equal(p.Balance))
+        assert.True(t, d("300").Equal(p.CashBalance["USD"]))
+    })
+}
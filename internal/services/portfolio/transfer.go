@@ -0,0 +1,251 @@
+package portfolio
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// ExportSchemaVersion is the version of the export document written by
+// Export. Import accepts documents up to this version.
+const ExportSchemaVersion = 1
+
+var (
+    // ErrUnsupportedSchemaVersion is returned when importing a document
+    // written by a newer or unknown schema version
+    ErrUnsupportedSchemaVersion = errors.New("unsupported export schema version")
+    // ErrInvalidExport is returned when an export document fails validation
+    ErrInvalidExport = errors.New("invalid portfolio export")
+)
+
+// ExportDocument is a portable copy of a portfolio. It holds nothing that
+// identifies its owner, and balances and prices only when exported with
+// values.
+type ExportDocument struct {
+    SchemaVersion int               `json:"schema_version"`
+    ExportedAt    time.Time         `json:"exported_at"`
+    Portfolio     ExportedPortfolio `json:"portfolio"`
+}
+
+type ExportedPortfolio struct {
+    Name        string             `json:"name"`
+    Description string             `json:"description,omitempty"`
+    Risk        models.RiskLevel   `json:"risk"`
+    Strategy    string             `json:"strategy,omitempty"`
+    Balance     *decimal.Decimal   `json:"balance,omitempty"`
+    Positions   []ExportedPosition `json:"positions"`
+}
+
+type ExportedPosition struct {
+    Symbol     string           `json:"symbol"`
+    Quantity   decimal.Decimal  `json:"quantity"`
+    EntryPrice *decimal.Decimal `json:"entry_price,omitempty"`
+}
+
+// ImportChange is one row an import creates
+type ImportChange struct {
+    Action       string           `json:"action"` // create
+    Type         string           `json:"type"`   // portfolio, position
+    Name         string           `json:"name,omitempty"`
+    Symbol       string           `json:"symbol,omitempty"`
+    // SourceSymbol is the symbol as written in the document when the
+    // normalizer changed it
+    SourceSymbol string           `json:"source_symbol,omitempty"`
+    Quantity     *decimal.Decimal `json:"quantity,omitempty"`
+}
+
+// ImportPlan is a validated document ready to be written by Import
+type ImportPlan struct {
+    Portfolio models.Portfolio `json:"-"`
+    Changes   []ImportChange   `json:"changes"`
+}
+
+// SymbolNormalizer maps a symbol as written in a document to the symbol
+// stored for it
+type SymbolNormalizer interface {
+    Normalize(symbol string) (string, error)
+}
+
+// upperCaseNormalizer trims and upper-cases symbols
+type upperCaseNormalizer struct{}
+
+func (upperCaseNormalizer) Normalize(symbol string) (string, error) {
+    normalized := strings.ToUpper(strings.TrimSpace(symbol))
+    if normalized == "" {
+        return "", errors.New("empty symbol")
+    }
+    return normalized, nil
+}
+
+// PortfolioTransfer exports portfolios to versioned documents and imports
+// them back
+type PortfolioTransfer struct {
+    db         *sql.DB
+    normalizer SymbolNormalizer
+}
+
+func NewPortfolioTransfer(db *sql.DB) *PortfolioTransfer {
+    return &PortfolioTransfer{
+        db:         db,
+        normalizer: upperCaseNormalizer{},
+    }
+}
+
+// WithNormalizer sets how imported symbols are mapped to stored symbols
+func (t *PortfolioTransfer) WithNormalizer(normalizer SymbolNormalizer) *PortfolioTransfer {
+    t.normalizer = normalizer
+    return t
+}
+
+// Export writes p and its positions to a document. Balance and entry prices
+// are only included when includeValues is set.
+func (t *PortfolioTransfer) Export(p *models.Portfolio, includeValues bool) *ExportDocument {
+    doc := &ExportDocument{
+        SchemaVersion: ExportSchemaVersion,
+        ExportedAt:    time.Now().UTC(),
+        Portfolio: ExportedPortfolio{
+            Name:        p.Name,
+            Description: p.Description,
+            Risk:        p.Risk,
+            Strategy:    p.Strategy,
+            Positions:   make([]ExportedPosition, 0, len(p.Positions)),
+        },
+    }
+    if includeValues {
+        balance := p.Balance
+        doc.Portfolio.Balance = &balance
+    }
+
+    for _, pos := range p.Positions {
+        exported := ExportedPosition{Symbol: pos.Symbol, Quantity: pos.Quantity}
+        if includeValues {
+            entryPrice := pos.EntryPrice
+            exported.EntryPrice = &entryPrice
+        }
+        doc.Portfolio.Positions = append(doc.Portfolio.Positions, exported)
+    }
+    return doc
+}
+
+// Plan validates doc against its schema version and maps its symbols through
+// the normalizer. Nothing is written.
+func (t *PortfolioTransfer) Plan(doc *ExportDocument) (*ImportPlan, error) {
+    if doc.SchemaVersion < 1 || doc.SchemaVersion > ExportSchemaVersion {
+        return nil, fmt.Errorf("%w: %d, this server reads up to %d",
+            ErrUnsupportedSchemaVersion, doc.SchemaVersion, ExportSchemaVersion)
+    }
+
+    src := doc.Portfolio
+    if strings.TrimSpace(src.Name) == "" {
+        return nil, fmt.Errorf("%w: portfolio name is required", ErrInvalidExport)
+    }
+    switch src.Risk {
+    case models.LowRisk, models.MediumRisk, models.HighRisk:
+    default:
+        return nil, fmt.Errorf("%w: risk must be one of: low, medium, high", ErrInvalidExport)
+    }
+
+    plan := &ImportPlan{
+        Portfolio: models.Portfolio{
+            Name:        src.Name,
+            Description: src.Description,
+            Risk:        src.Risk,
+            Strategy:    src.Strategy,
+        },
+    }
+    if src.Balance != nil {
+        if src.Balance.IsNegative() {
+            return nil, fmt.Errorf("%w: balance must be non-negative", ErrInvalidExport)
+        }
+        plan.Portfolio.Balance = *src.Balance
+    }
+    plan.Changes = append(plan.Changes, ImportChange{Action: "create", Type: "portfolio", Name: src.Name})
+
+    seen := make(map[string]bool, len(src.Positions))
+    for i, pos := range src.Positions {
+        symbol, err := t.normalizer.Normalize(pos.Symbol)
+        if err != nil {
+            return nil, fmt.Errorf("%w: position %d: %v", ErrInvalidExport, i, err)
+        }
+        if seen[symbol] {
+            return nil, fmt.Errorf("%w: duplicate position %s", ErrInvalidExport, symbol)
+        }
+        seen[symbol] = true
+
+        if !pos.Quantity.IsPositive() {
+            return nil, fmt.Errorf("%w: position %s: quantity must be positive", ErrInvalidExport, symbol)
+        }
+        position := models.Position{Symbol: symbol, Quantity: pos.Quantity}
+        if pos.EntryPrice != nil {
+            if pos.EntryPrice.IsNegative() {
+                return nil, fmt.Errorf("%w: position %s: entry price must be non-negative", ErrInvalidExport, symbol)
+            }
+            position.EntryPrice = *pos.EntryPrice
+        }
+        plan.Portfolio.Positions = append(plan.Portfolio.Positions, position)
+
+        quantity := pos.Quantity
+        change := ImportChange{Action: "create", Type: "position", Symbol: symbol, Quantity: &quantity}
+        if symbol != pos.Symbol {
+            change.SourceSymbol = pos.Symbol
+        }
+        plan.Changes = append(plan.Changes, change)
+    }
+    return plan, nil
+}
+
+// Import creates the portfolio and positions of plan in one transaction,
+// filling in the IDs of plan.Portfolio. plan.Portfolio.UserID must be set.
+func (t *PortfolioTransfer) Import(ctx context.Context, plan *ImportPlan) error {
+    tx, err := t.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    p := &plan.Portfolio
+    query := `
+        INSERT INTO portfolios (user_id, name, description, balance, risk, strategy)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at, updated_at
+    `
+    err = tx.QueryRowContext(ctx, query, p.UserID, p.Name, p.Description, p.Balance, p.Risk, p.Strategy).
+        Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+    if err != nil {
+        return fmt.Errorf("failed to create portfolio: %w", err)
+    }
+
+    query = `
+        INSERT INTO positions (portfolio_id, symbol, quantity, entry_price)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, updated_at
+    `
+    created := NewPortfolioDetails(p)
+    for i := range p.Positions {
+        pos := &p.Positions[i]
+        pos.PortfolioID = p.ID
+        err := tx.QueryRowContext(ctx, query, p.ID, pos.Symbol, pos.Quantity, pos.EntryPrice).
+            Scan(&pos.ID, &pos.CreatedAt, &pos.UpdatedAt)
+        if err != nil {
+            return fmt.Errorf("failed to create position %s: %w", pos.Symbol, err)
+        }
+        created.Positions = append(created.Positions, PositionChange{
+            Symbol:     pos.Symbol,
+            Quantity:   pos.Quantity,
+            EntryPrice: pos.EntryPrice,
+        })
+    }
+
+    if err := AppendEvent(ctx, tx, p.ID, EventPortfolioCreated, created); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
@@ -0,0 +1,175 @@
+package portfolio
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/google/uuid"
+    "github.com/stretchr/testify/assert"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func samplePortfolio() *models.Portfolio {
+    return &models.Portfolio{
+        ID:          7,
+        UserID:      uuid.New(),
+        Name:        "Core",
+        Description: "Long-term holdings",
+        Balance:     d("2500.50"),
+        Risk:        models.MediumRisk,
+        Strategy:    "momentum",
+        Positions: []models.Position{
+            {ID: 1, PortfolioID: 7, Symbol: "AAPL", Quantity: d("10"), EntryPrice: d("150.25")},
+            {ID: 2, PortfolioID: 7, Symbol: "BTC", Quantity: d("0.125"), EntryPrice: d("30000")},
+        },
+    }
+}
+
+func TestPortfolioTransfer_RoundTrip(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    transfer := NewPortfolioTransfer(db)
+    original := samplePortfolio()
+
+    body, err := json.Marshal(transfer.Export(original, true))
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.NotContains(t, string(body), "user_id")
+
+    var doc ExportDocument
+    if !assert.NoError(t, json.Unmarshal(body, &doc)) {
+        return
+    }
+    plan, err := transfer.Plan(&doc)
+    if !assert.NoError(t, err) {
+        return
+    }
+    owner := uuid.New()
+    plan.Portfolio.UserID = owner
+
+    now := time.Now()
+    mock.ExpectBegin()
+    mock.ExpectQuery("INSERT INTO portfolios").
+        WithArgs(owner, "Core", "Long-term holdings", sqlmock.AnyArg(), models.MediumRisk, "momentum").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(8, now, now))
+    mock.ExpectQuery("INSERT INTO positions").
+        WithArgs(int64(8), "AAPL", sqlmock.AnyArg(), sqlmock.AnyArg()).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(11, now, now))
+    mock.ExpectQuery("INSERT INTO positions").
+        WithArgs(int64(8), "BTC", sqlmock.AnyArg(), sqlmock.AnyArg()).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(12, now, now))
+    mock.ExpectExec("SELECT id FROM portfolios WHERE id = (.+) FOR UPDATE").
+        WithArgs(int64(8)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectExec("INSERT INTO portfolio_events").
+        WithArgs(int64(8), EventPortfolioCreated, sqlmock.AnyArg(), "system").
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectCommit()
+
+    if !assert.NoError(t, transfer.Import(context.Background(), plan)) {
+        return
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    imported := plan.Portfolio
+    assert.Equal(t, int64(8), imported.ID)
+    assert.Equal(t, original.Name, imported.Name)
+    assert.Equal(t, original.Description, imported.Description)
+    assert.Equal(t, original.Risk, imported.Risk)
+    assert.Equal(t, original.Strategy, imported.Strategy)
+    assert.True(t, original.Balance.Equal(imported.Balance), "balance %s", imported.Balance)
+    if !assert.Len(t, imported.Positions, len(original.Positions)) {
+        return
+    }
+    for i, pos := range imported.Positions {
+        assert.Equal(t, int64(8), pos.PortfolioID)
+        assert.Equal(t, original.Positions[i].Symbol, pos.Symbol)
+        assert.True(t, original.Positions[i].Quantity.Equal(pos.Quantity), "quantity %s", pos.Quantity)
+        assert.True(t, original.Positions[i].EntryPrice.Equal(pos.EntryPrice), "entry price %s", pos.EntryPrice)
+    }
+
+    // Exporting the imported copy gives the same document
+    assert.Equal(t, doc.Portfolio, transfer.Export(&imported, true).Portfolio)
+}
+
+func TestPortfolioTransfer_ExportWithoutValues(t *testing.T) {
+    transfer := NewPortfolioTransfer(nil)
+
+    doc := transfer.Export(samplePortfolio(), false)
+    assert.Equal(t, ExportSchemaVersion, doc.SchemaVersion)
+    assert.Nil(t, doc.Portfolio.Balance)
+    for _, pos := range doc.Portfolio.Positions {
+        assert.Nil(t, pos.EntryPrice)
+    }
+
+    plan, err := transfer.Plan(doc)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.True(t, plan.Portfolio.Balance.IsZero())
+    assert.True(t, plan.Portfolio.Positions[0].EntryPrice.IsZero())
+}
+
+func TestPortfolioTransfer_Plan(t *testing.T) {
+    transfer := NewPortfolioTransfer(nil)
+
+    t.Run("Newer schema version", func(t *testing.T) {
+        doc := transfer.Export(samplePortfolio(), true)
+        doc.SchemaVersion = ExportSchemaVersion + 1
+
+        plan, err := transfer.Plan(doc)
+        assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+        assert.Nil(t, plan)
+    })
+
+    t.Run("Missing schema version", func(t *testing.T) {
+        doc := transfer.Export(samplePortfolio(), true)
+        doc.SchemaVersion = 0
+
+        _, err := transfer.Plan(doc)
+        assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+    })
+
+    t.Run("Symbols are normalized", func(t *testing.T) {
+        doc := transfer.Export(samplePortfolio(), false)
+        doc.Portfolio.Positions[1].Symbol = " btc "
+
+        plan, err := transfer.Plan(doc)
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, "BTC", plan.Portfolio.Positions[1].Symbol)
+        assert.Len(t, plan.Changes, 3)
+        assert.Equal(t, "portfolio", plan.Changes[0].Type)
+        assert.Equal(t, "", plan.Changes[1].SourceSymbol)
+        assert.Equal(t, " btc ", plan.Changes[2].SourceSymbol)
+    })
+
+    t.Run("Duplicate symbols after normalization", func(t *testing.T) {
+        doc := transfer.Export(samplePortfolio(), false)
+        doc.Portfolio.Positions[1].Symbol = "aapl"
+
+        _, err := transfer.Plan(doc)
+        assert.ErrorIs(t, err, ErrInvalidExport)
+    })
+
+    t.Run("Invalid values", func(t *testing.T) {
+        doc := transfer.Export(samplePortfolio(), false)
+        doc.Portfolio.Risk = "extreme"
+        _, err := transfer.Plan(doc)
+        assert.ErrorIs(t, err, ErrInvalidExport)
+
+        doc = transfer.Export(samplePortfolio(), false)
+        doc.Portfolio.Positions[0].Quantity = d("0")
+        _, err = transfer.Plan(doc)
+        assert.ErrorIs(t, err, ErrInvalidExport)
+    })
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+func TestPortfolioService_BulkDeletePortfolios(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewPortfolioService(db)
+	userID := uuid.New()
+	owned := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	unowned, missing := uuid.New(), uuid.New()
+	ids := append(append([]uuid.UUID{}, owned...), unowned, missing)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM portfolios WHERE id = ANY\\(\\$1\\) AND user_id = \\$2").
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).
+			AddRow(owned[0].String()).AddRow(owned[1].String()).AddRow(owned[2].String()))
+	mock.ExpectQuery("SELECT id FROM portfolios WHERE id = ANY\\(\\$1\\) AND deleted_at IS NULL").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(unowned.String()))
+	mock.ExpectExec("UPDATE portfolios SET deleted_at = \\$1, updated_at = \\$1 WHERE id = ANY\\(\\$2\\) AND user_id = \\$3").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	result, err := service.BulkDeletePortfolios(context.Background(), userID, ids)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, result.DeletedCount)
+	assert.Equal(t, []uuid.UUID{unowned}, result.UnauthorizedIDs)
+	assert.Equal(t, []uuid.UUID{missing}, result.NotFoundIDs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("Nothing owned deletes nothing", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM portfolios WHERE id = ANY\\(\\$1\\) AND user_id = \\$2").
+			WithArgs(sqlmock.AnyArg(), userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery("SELECT id FROM portfolios WHERE id = ANY\\(\\$1\\) AND deleted_at IS NULL").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(unowned.String()))
+		mock.ExpectCommit()
+
+		result, err := service.BulkDeletePortfolios(context.Background(), userID, []uuid.UUID{unowned})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 0, result.DeletedCount)
+		assert.Equal(t, []uuid.UUID{unowned}, result.UnauthorizedIDs)
+		assert.Empty(t, result.NotFoundIDs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPortfolioService_ForkPortfolio(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	service := NewPortfolioService(db)
+	userID, sourceID := uuid.New(), uuid.New()
+	updated := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	assetColumns := []string{"symbol", "type", "quantity", "avg_price", "value", "last_update"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, description, total_value, paper_trading FROM portfolios WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(sourceID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "description", "total_value", "paper_trading"}).
+			AddRow(sourceID.String(), "Long term", "20000", false))
+	mock.ExpectQuery("SELECT symbol, type, quantity, avg_price, value, last_update FROM assets WHERE portfolio_id = \\$1").
+		WithArgs(sourceID).
+		WillReturnRows(sqlmock.NewRows(assetColumns).
+			AddRow("AAPL", "stock", "50", "180", "10000", updated).
+			AddRow("MSFT", "stock", "25", "390", "10000", updated))
+	mock.ExpectExec("INSERT INTO portfolios \\(id, user_id, name, description, total_value, paper_trading, fork_of_id").
+		WithArgs(sqlmock.AnyArg(), userID, "Sandbox", "Long term", sqlmock.AnyArg(), sourceID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		mock.ExpectExec("INSERT INTO paper_positions").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), symbol, "stock", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), updated).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	fork, err := service.ForkPortfolio(context.Background(), sourceID, userID, "Sandbox")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, fork.IsPaperTrading)
+	assert.Equal(t, sourceID, fork.ForkOfID)
+	assert.NotEqual(t, sourceID, fork.ID)
+	assert.Len(t, fork.Assets, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Trading the fork writes to paper_positions only. Any statement on the
+	// source's assets would fail as unexpected.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE paper_positions SET type = \\$3, quantity = \\$4").
+		WithArgs(fork.ID, "AAPL", "stock", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE portfolios SET total_value = \\$2").
+		WithArgs(fork.ID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE paper_positions").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO paper_positions").
+		WithArgs(sqlmock.AnyArg(), fork.ID, "NVDA", "stock", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE portfolios SET total_value = \\$2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	assert.NoError(t, service.UpsertPosition(ctx, fork, models.Asset{
+		Symbol: "AAPL", Type: "stock", Quantity: decimal.NewFromInt(10), AvgPrice: decimal.NewFromInt(180), LastUpdate: updated,
+	}))
+	assert.NoError(t, service.UpsertPosition(ctx, fork, models.Asset{
+		Symbol: "NVDA", Type: "stock", Quantity: decimal.NewFromInt(5), AvgPrice: decimal.NewFromInt(900), LastUpdate: updated,
+	}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("Another user's portfolio", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, description, total_value, paper_trading FROM portfolios").
+			WithArgs(sourceID, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "description", "total_value", "paper_trading"}))
+		mock.ExpectRollback()
+
+		_, err := service.ForkPortfolio(context.Background(), sourceID, uuid.New(), "Sandbox")
+		assert.ErrorIs(t, err, ErrPortfolioNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,355 @@
+package regime
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+// Label is the regime a symbol, or the market as a whole, is in
+type Label string
+
+const (
+    Trending       Label = "trending"
+    Ranging        Label = "ranging"
+    HighVolatility Label = "high_volatility"
+)
+
+// MarketSymbol is the symbol the market-wide composite is reported and
+// stored under
+const MarketSymbol = "MARKET"
+
+var ErrInsufficientHistory = errors.New("insufficient price history")
+
+// Config sets the indicator windows and the thresholds between regimes
+type Config struct {
+    // ADXWindow is the Wilder smoothing window of the ADX
+    ADXWindow int
+    // VolWindow is the realized volatility window, and VolLookback how many
+    // days of rolling volatility it is ranked against
+    VolWindow   int
+    VolLookback int
+    // MAWindow is the moving average whose slope over SlopeLookback days
+    // gives trend direction
+    MAWindow      int
+    SlopeLookback int
+
+    // HighVolPercentile is the volatility percentile at or above which the
+    // regime is high_volatility, whatever the trend
+    HighVolPercentile float64
+    // TrendADX and MinSlope must both be met for a trending regime
+    TrendADX float64
+    MinSlope float64
+}
+
+// DefaultConfig is a 14-day ADX, 20-day volatility ranked over a year and
+// the slope of the 50-day average over two weeks
+func DefaultConfig() Config {
+    return Config{
+        ADXWindow:         14,
+        VolWindow:         20,
+        VolLookback:       252,
+        MAWindow:          50,
+        SlopeLookback:     10,
+        HighVolPercentile: 0.8,
+        TrendADX:          25,
+        MinSlope:          0.01,
+    }
+}
+
+// history is how many daily candles the indicators need
+func (c Config) history() int {
+    n := 2*c.ADXWindow + 1
+    if v := c.VolLookback + c.VolWindow + 1; v > n {
+        n = v
+    }
+    if v := c.MAWindow + c.SlopeLookback; v > n {
+        n = v
+    }
+    return n
+}
+
+// Reading is a regime label with the indicators it was derived from
+type Reading struct {
+    Symbol        string    `json:"symbol"`
+    Label         Label     `json:"label"`
+    Direction     string    `json:"direction"`
+    ADX           float64   `json:"adx"`
+    VolPercentile float64   `json:"vol_percentile"`
+    MASlope       float64   `json:"ma_slope"`
+    Date          time.Time `json:"date"`
+    // Components lists the symbols a composite was built from
+    Components []string `json:"components,omitempty"`
+}
+
+// SymbolSource lists the symbols that make up the market composite
+type SymbolSource interface {
+    GetActiveSymbols(ctx context.Context) ([]string, error)
+}
+
+type Detector struct {
+    db      *sql.DB
+    config  Config
+    symbols SymbolSource
+}
+
+func NewDetector(db *sql.DB) *Detector {
+    return &Detector{
+        db:     db,
+        config: DefaultConfig(),
+    }
+}
+
+// WithConfig replaces the default windows and thresholds
+func (d *Detector) WithConfig(config Config) *Detector {
+    d.config = config
+    return d
+}
+
+// WithSymbols sets the symbols the market composite is built from
+func (d *Detector) WithSymbols(symbols SymbolSource) *Detector {
+    d.symbols = symbols
+    return d
+}
+
+// Detect computes the current regime of symbol, or of the market composite
+// when symbol is MarketSymbol
+func (d *Detector) Detect(ctx context.Context, symbol string) (*Reading, error) {
+    if strings.EqualFold(symbol, MarketSymbol) {
+        return d.DetectMarket(ctx)
+    }
+
+    candles, err := d.candles(ctx, symbol)
+    if err != nil {
+        return nil, err
+    }
+    reading, err := d.classify(candles)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", symbol, err)
+    }
+    reading.Symbol = symbol
+    return reading, nil
+}
+
+// DetectMarket averages the indicators of every active symbol and labels
+// the result with the same thresholds as a single symbol. Symbols without
+// enough history are left out.
+func (d *Detector) DetectMarket(ctx context.Context) (*Reading, error) {
+    _, market, err := d.detectAll(ctx)
+    return market, err
+}
+
+// detectAll returns the reading of every active symbol with enough history
+// and the composite built from them
+func (d *Detector) detectAll(ctx context.Context) ([]*Reading, *Reading, error) {
+    if d.symbols == nil {
+        return nil, nil, fmt.Errorf("no symbol source configured")
+    }
+    symbols, err := d.symbols.GetActiveSymbols(ctx)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to get active symbols: %w", err)
+    }
+
+    var readings []*Reading
+    for _, symbol := range symbols {
+        if strings.EqualFold(symbol, MarketSymbol) {
+            continue
+        }
+        reading, err := d.Detect(ctx, symbol)
+        if errors.Is(err, ErrInsufficientHistory) {
+            logger.FromContext(ctx).Warnf("Skipping %s in market regime: %v", symbol, err)
+            continue
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        readings = append(readings, reading)
+    }
+    if len(readings) == 0 {
+        return nil, nil, fmt.Errorf("no symbol regimes available: %w", ErrInsufficientHistory)
+    }
+
+    return readings, d.composite(readings), nil
+}
+
+func (d *Detector) composite(readings []*Reading) *Reading {
+    market := &Reading{Symbol: MarketSymbol, Date: today()}
+    for _, r := range readings {
+        market.ADX += r.ADX / float64(len(readings))
+        market.VolPercentile += r.VolPercentile / float64(len(readings))
+        market.MASlope += r.MASlope / float64(len(readings))
+        market.Components = append(market.Components, r.Symbol)
+    }
+    market.Label, market.Direction = d.label(market.ADX, market.VolPercentile, market.MASlope)
+    return market
+}
+
+// classify labels candles ordered oldest first
+func (d *Detector) classify(candles []Candle) (*Reading, error) {
+    closes := make([]float64, len(candles))
+    for i, c := range candles {
+        closes[i] = c.Close
+    }
+
+    adxValue, ok := adx(candles, d.config.ADXWindow)
+    if !ok {
+        return nil, ErrInsufficientHistory
+    }
+    volPct, ok := volatilityPercentile(closes, d.config.VolWindow, d.config.VolLookback)
+    if !ok {
+        return nil, ErrInsufficientHistory
+    }
+    slope, ok := maSlope(closes, d.config.MAWindow, d.config.SlopeLookback)
+    if !ok {
+        return nil, ErrInsufficientHistory
+    }
+
+    reading := &Reading{
+        ADX:           adxValue,
+        VolPercentile: volPct,
+        MASlope:       slope,
+        Date:          today(),
+    }
+    reading.Label, reading.Direction = d.label(adxValue, volPct, slope)
+    return reading, nil
+}
+
+func (d *Detector) label(adxValue, volPct, slope float64) (Label, string) {
+    direction := "flat"
+    switch {
+    case slope >= d.config.MinSlope:
+        direction = "up"
+    case slope <= -d.config.MinSlope:
+        direction = "down"
+    }
+
+    switch {
+    case volPct >= d.config.HighVolPercentile:
+        return HighVolatility, direction
+    case adxValue >= d.config.TrendADX && math.Abs(slope) >= d.config.MinSlope:
+        return Trending, direction
+    default:
+        return Ranging, direction
+    }
+}
+
+// candles returns the latest daily candles for symbol, oldest first
+func (d *Detector) candles(ctx context.Context, symbol string) ([]Candle, error) {
+    query := `
+        SELECT high, low, close FROM (
+            SELECT high, low, close, timestamp FROM market_data
+            WHERE symbol = $1
+            ORDER BY timestamp DESC
+            LIMIT $2
+        ) recent
+        ORDER BY timestamp
+    `
+    rows, err := d.db.QueryContext(ctx, query, symbol, d.config.history())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var candles []Candle
+    for rows.Next() {
+        var c Candle
+        if err := rows.Scan(&c.High, &c.Low, &c.Close); err != nil {
+            return nil, err
+        }
+        candles = append(candles, c)
+    }
+    return candles, rows.Err()
+}
+
+// Record computes today's regime for every active symbol and the market
+// composite and stores them, replacing any reading already taken today
+func (d *Detector) Record(ctx context.Context) error {
+    readings, market, err := d.detectAll(ctx)
+    if err != nil {
+        return err
+    }
+
+    for _, reading := range append(readings, market) {
+        if err := d.save(ctx, reading); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (d *Detector) save(ctx context.Context, r *Reading) error {
+    query := `
+        INSERT INTO market_regimes (symbol, date, label, direction, adx, vol_percentile, ma_slope, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (symbol, date) DO UPDATE SET
+            label = EXCLUDED.label,
+            direction = EXCLUDED.direction,
+            adx = EXCLUDED.adx,
+            vol_percentile = EXCLUDED.vol_percentile,
+            ma_slope = EXCLUDED.ma_slope,
+            created_at = EXCLUDED.created_at
+    `
+    _, err := d.db.ExecContext(ctx, query, r.Symbol, r.Date, r.Label, r.Direction, r.ADX, r.VolPercentile, r.MASlope, time.Now())
+    if err != nil {
+        return fmt.Errorf("failed to save %s regime: %w", r.Symbol, err)
+    }
+    return nil
+}
+
+// History returns the stored daily readings for symbol over the last days
+// days, oldest first
+func (d *Detector) History(ctx context.Context, symbol string, days int) ([]Reading, error) {
+    query := `
+        SELECT symbol, date, label, direction, adx, vol_percentile, ma_slope
+        FROM market_regimes
+        WHERE symbol = $1 AND date > $2
+        ORDER BY date
+    `
+    if strings.EqualFold(symbol, MarketSymbol) {
+        symbol = MarketSymbol
+    }
+    since := today().AddDate(0, 0, -days)
+    rows, err := d.db.QueryContext(ctx, query, symbol, since)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var readings []Reading
+    for rows.Next() {
+        var r Reading
+        if err := rows.Scan(&r.Symbol, &r.Date, &r.Label, &r.Direction, &r.ADX, &r.VolPercentile, &r.MASlope); err != nil {
+            return nil, err
+        }
+        readings = append(readings, r)
+    }
+    return readings, rows.Err()
+}
+
+// IsHighVolatility reports whether the latest stored market composite is
+// high_volatility. No stored reading counts as normal.
+func (d *Detector) IsHighVolatility(ctx context.Context) (bool, error) {
+    var label Label
+    err := d.db.QueryRowContext(ctx, `
+        SELECT label FROM market_regimes
+        WHERE symbol = $1
+        ORDER BY date DESC LIMIT 1
+    `, MarketSymbol).Scan(&label)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    if err != nil {
+        return false, fmt.Errorf("failed to read market regime: %w", err)
+    }
+    return label == HighVolatility, nil
+}
+
+func today() time.Time {
+    return time.Now().UTC().Truncate(24 * time.Hour)
+}
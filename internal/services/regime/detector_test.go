@@ -0,0 +1,147 @@
+package regime
+
+import (
+    "context"
+    "math"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+)
+
+const syntheticDays = 300
+
+// zigzag alternates around center with an amplitude fading linearly from
+// start to end, so realized volatility is lowest on the last day
+func zigzag(i int, start, end float64) float64 {
+    amp := start + (end-start)*float64(i)/float64(syntheticDays-1)
+    if i%2 == 0 {
+        return amp
+    }
+    return -amp
+}
+
+func candlesFrom(closes []float64, spread float64) []Candle {
+    candles := make([]Candle, len(closes))
+    for i, c := range closes {
+        candles[i] = Candle{High: c + spread, Low: c - spread, Close: c}
+    }
+    return candles
+}
+
+func trendingSeries() []Candle {
+    closes := make([]float64, syntheticDays)
+    for i := range closes {
+        closes[i] = 100*math.Pow(1.004, float64(i)) + zigzag(i, 0.3, 0.05)
+    }
+    return candlesFrom(closes, 0.2)
+}
+
+func meanRevertingSeries() []Candle {
+    closes := make([]float64, syntheticDays)
+    for i := range closes {
+        closes[i] = 100 + zigzag(i, 3, 2)
+    }
+    return candlesFrom(closes, 0.5)
+}
+
+func volatileSeries() []Candle {
+    candles := meanRevertingSeries()
+    for i := len(candles) - 20; i < len(candles); i++ {
+        c := 100 + 5*zigzag(i, 3, 3)
+        candles[i] = Candle{High: c + 0.5, Low: c - 0.5, Close: c}
+    }
+    return candles
+}
+
+func TestDetector_Classify(t *testing.T) {
+    detector := NewDetector(nil)
+
+    tests := []struct {
+        name      string
+        candles   []Candle
+        label     Label
+        direction string
+    }{
+        {"trending", trendingSeries(), Trending, "up"},
+        {"mean reverting", meanRevertingSeries(), Ranging, "flat"},
+        {"volatility spike", volatileSeries(), HighVolatility, "flat"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            reading, err := detector.classify(tt.candles)
+            if !assert.NoError(t, err) {
+                return
+            }
+            assert.Equal(t, tt.label, reading.Label)
+            assert.Equal(t, tt.direction, reading.Direction)
+        })
+    }
+
+    t.Run("Falling trend", func(t *testing.T) {
+        candles := trendingSeries()
+        for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+            candles[i], candles[j] = candles[j], candles[i]
+        }
+        reading, err := detector.classify(candles)
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.Equal(t, "down", reading.Direction)
+        assert.Greater(t, reading.ADX, 25.0)
+    })
+
+    t.Run("Insufficient history", func(t *testing.T) {
+        _, err := detector.classify(trendingSeries()[:20])
+        assert.ErrorIs(t, err, ErrInsufficientHistory)
+    })
+}
+
+func TestADX(t *testing.T) {
+    trend, ok := adx(trendingSeries(), 14)
+    assert.True(t, ok)
+    chop, ok := adx(meanRevertingSeries(), 14)
+    assert.True(t, ok)
+
+    assert.Greater(t, trend, 50.0)
+    assert.Less(t, chop, 20.0)
+}
+
+type staticSymbols []string
+
+func (s staticSymbols) GetActiveSymbols(ctx context.Context) ([]string, error) {
+    return s, nil
+}
+
+func TestDetector_DetectMarket(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    expectCandles := func(symbol string, candles []Candle) {
+        rows := sqlmock.NewRows([]string{"high", "low", "close"})
+        for _, c := range candles {
+            rows.AddRow(c.High, c.Low, c.Close)
+        }
+        mock.ExpectQuery("SELECT high, low, close FROM").
+            WithArgs(symbol, DefaultConfig().history()).
+            WillReturnRows(rows)
+    }
+    expectCandles("SPY", trendingSeries())
+    expectCandles("QQQ", trendingSeries())
+    expectCandles("NEW", trendingSeries()[:30])
+
+    detector := NewDetector(db).WithSymbols(staticSymbols{"SPY", "QQQ", "NEW"})
+    market, err := detector.Detect(context.Background(), "market")
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    assert.Equal(t, MarketSymbol, market.Symbol)
+    assert.Equal(t, Trending, market.Label)
+    assert.Equal(t, []string{"SPY", "QQQ"}, market.Components)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,148 @@
+package regime
+
+import (
+    "math"
+)
+
+// Candle is the part of a daily bar the indicators use
+type Candle struct {
+    High  float64
+    Low   float64
+    Close float64
+}
+
+// adx is Wilder's Average Directional Index over window bars, from 0 (no
+// trend) to 100. It needs at least 2*window+1 candles.
+func adx(candles []Candle, window int) (float64, bool) {
+    if window < 1 || len(candles) < 2*window+1 {
+        return 0, false
+    }
+
+    n := float64(window)
+    var smoothTR, smoothPlus, smoothMinus, adxValue float64
+    var dxCount int
+    for i := 1; i < len(candles); i++ {
+        cur, prev := candles[i], candles[i-1]
+
+        tr := math.Max(cur.High-cur.Low, math.Max(math.Abs(cur.High-prev.Close), math.Abs(cur.Low-prev.Close)))
+        up, down := cur.High-prev.High, prev.Low-cur.Low
+        var plusDM, minusDM float64
+        if up > down && up > 0 {
+            plusDM = up
+        }
+        if down > up && down > 0 {
+            minusDM = down
+        }
+
+        // The first window bars seed the smoothed sums
+        if i <= window {
+            smoothTR += tr
+            smoothPlus += plusDM
+            smoothMinus += minusDM
+            if i < window {
+                continue
+            }
+        } else {
+            smoothTR = smoothTR - smoothTR/n + tr
+            smoothPlus = smoothPlus - smoothPlus/n + plusDM
+            smoothMinus = smoothMinus - smoothMinus/n + minusDM
+        }
+
+        var dx float64
+        if smoothTR > 0 {
+            plusDI := 100 * smoothPlus / smoothTR
+            minusDI := 100 * smoothMinus / smoothTR
+            if sum := plusDI + minusDI; sum > 0 {
+                dx = 100 * math.Abs(plusDI-minusDI) / sum
+            }
+        }
+
+        // ADX starts as the mean of the first window DX values, then is
+        // smoothed like the sums
+        dxCount++
+        if dxCount <= window {
+            adxValue += dx / n
+        } else {
+            adxValue = (adxValue*(n-1) + dx) / n
+        }
+    }
+    return adxValue, true
+}
+
+// volatilityPercentile ranks the latest window-day realized volatility
+// against the rolling volatilities of the preceding lookback days, from 0
+// (calmest) to 1 (most volatile)
+func volatilityPercentile(closes []float64, window, lookback int) (float64, bool) {
+    returns := dailyReturns(closes)
+    if window < 2 || len(returns) < window+1 {
+        return 0, false
+    }
+
+    var vols []float64
+    for end := window; end <= len(returns); end++ {
+        vols = append(vols, stdDev(returns[end-window:end]))
+    }
+    current := vols[len(vols)-1]
+    history := vols[:len(vols)-1]
+    if lookback > 0 && len(history) > lookback {
+        history = history[len(history)-lookback:]
+    }
+
+    var below, equal float64
+    for _, v := range history {
+        switch {
+        case v < current:
+            below++
+        case v == current:
+            equal++
+        }
+    }
+    return (below + equal/2) / float64(len(history)), true
+}
+
+// maSlope is the relative change of the window-day simple moving average
+// over the last lookback days
+func maSlope(closes []float64, window, lookback int) (float64, bool) {
+    if window < 1 || lookback < 1 || len(closes) < window+lookback {
+        return 0, false
+    }
+
+    current := mean(closes[len(closes)-window:])
+    earlier := mean(closes[len(closes)-window-lookback : len(closes)-lookback])
+    if earlier == 0 {
+        return 0, false
+    }
+    return (current - earlier) / earlier, true
+}
+
+func dailyReturns(closes []float64) []float64 {
+    if len(closes) < 2 {
+        return nil
+    }
+    returns := make([]float64, 0, len(closes)-1)
+    for i := 1; i < len(closes); i++ {
+        if closes[i-1] == 0 {
+            returns = append(returns, 0)
+            continue
+        }
+        returns = append(returns, (closes[i]-closes[i-1])/closes[i-1])
+    }
+    return returns
+}
+
+func mean(values []float64) float64 {
+    var sum float64
+    for _, v := range values {
+        sum += v
+    }
+    return sum / float64(len(values))
+}
+
+func stdDev(values []float64) float64 {
+    m := mean(values)
+    var sumSq float64
+    for _, v := range values {
+        sumSq += (v - m) * (v - m)
+    }
+    return math.Sqrt(sumSq / float64(len(values)-1))
+}
@@ -0,0 +1,109 @@
+package risk
+
+import (
+    "context"
+    "strings"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Asset classes stored in assets.asset_class
+const (
+    AssetClassEquity = "equity"
+    AssetClassCrypto = "crypto"
+)
+
+// AssetClassRiskConfig holds the limits a single position of an asset class
+// is checked against. Volatility is daily.
+type AssetClassRiskConfig struct {
+    MaxVolatility    float64
+    MaxDrawdown      float64
+    MaxConcentration float64
+    // MaxExpectedShortfall and MinInformationRatio are only checked
+    // portfolio-wide
+    MaxExpectedShortfall float64
+    MinInformationRatio  float64
+}
+
+func defaultAssetClassRiskConfigs() map[string]AssetClassRiskConfig {
+    return map[string]AssetClassRiskConfig{
+        AssetClassEquity: {MaxVolatility: 0.03, MaxDrawdown: 0.15, MaxConcentration: 0.30},
+        // Crypto routinely moves several percent a day, so equity limits
+        // would alert on every position
+        AssetClassCrypto: {MaxVolatility: 0.10, MaxDrawdown: 0.40, MaxConcentration: 0.20},
+    }
+}
+
+// WithAssetClassConfig sets the per-position limits of an asset class
+func (rm *RiskManager) WithAssetClassConfig(assetClass string, cfg AssetClassRiskConfig) *RiskManager {
+    rm.assetClasses[strings.ToLower(assetClass)] = cfg
+    return rm
+}
+
+// assetClassConfig returns the limits of assetClass. Unknown classes get the
+// equity limits.
+func (rm *RiskManager) assetClassConfig(assetClass string) AssetClassRiskConfig {
+    if cfg, ok := rm.assetClasses[assetClass]; ok {
+        return cfg
+    }
+    return rm.assetClasses[AssetClassEquity]
+}
+
+// getAssetClasses resolves the asset class of each position's symbol.
+// Symbols not in assets are treated as equities, unless they are cash or a
+// known stablecoin.
+func (rm *RiskManager) getAssetClasses(ctx context.Context, positions []models.Position) (map[string]string, error) {
+    symbols := make([]string, len(positions))
+    for i, pos := range positions {
+        symbols[i] = pos.Symbol
+    }
+    return rm.valuers.Types(ctx, symbols)
+}
+
+// volatilePositions returns the positions whose asset class carries market
+// risk
+func (rm *RiskManager) volatilePositions(positions []models.Position, classes map[string]string) []models.Position {
+    var volatile []models.Position
+    for _, pos := range positions {
+        if rm.valuers.Volatile(classes[pos.Symbol]) {
+            volatile = append(volatile, pos)
+        }
+    }
+    return volatile
+}
+
+// assetClassAlerts checks each position against the limits of its asset
+// class, grouping the alerts by class. VaR and expected shortfall are only
+// assessed portfolio-wide. Volatility limits are scaled by volScale like the
+// portfolio's. Cash and stablecoins have no limits to breach, but count
+// towards the portfolio value concentration is measured against.
+func (rm *RiskManager) assetClassAlerts(positions []models.Position, classes map[string]string, drawdowns, volatilities map[string]float64, volScale float64) map[string][]Alert {
+    totalValue := decimal.Zero
+    for _, pos := range positions {
+        totalValue = totalValue.Add(pos.CostBasis())
+    }
+
+    byClass := make(map[string][]Alert)
+    for _, pos := range positions {
+        assetClass := classes[pos.Symbol]
+        if !rm.valuers.Volatile(assetClass) {
+            continue
+        }
+        limits := rm.assetClassConfig(assetClass)
+        limits.MaxVolatility *= volScale
+
+        var concentration float64
+        if !totalValue.IsZero() {
+            concentration = models.DecimalToFloat(pos.CostBasis()) / models.DecimalToFloat(totalValue)
+        }
+
+        alerts := rm.generateAlerts(0, 0, drawdowns[pos.Symbol], concentration, volatilities[pos.Symbol], 0, limits)
+        for _, alert := range alerts {
+            alert.Symbol = pos.Symbol
+            byClass[assetClass] = append(byClass[assetClass], alert)
+        }
+    }
+    return byClass
+}
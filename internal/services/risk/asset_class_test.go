@@ -0,0 +1,150 @@
+package risk
+
+import (
+    "context"
+    "fmt"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type fakePrices map[string]float64
+
+func (f fakePrices) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    price, ok := f[symbol]
+    if !ok {
+        return 0, fmt.Errorf("no market data for %s", symbol)
+    }
+    return price, nil
+}
+
+func alertsOfType(alerts []Alert, alertType string) []Alert {
+    var matched []Alert
+    for _, a := range alerts {
+        if a.Type == alertType {
+            matched = append(matched, a)
+        }
+    }
+    return matched
+}
+
+func TestRiskManager_AssetClassAlerts(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    manager := NewRiskManager(db)
+    ctx := context.Background()
+
+    positions := []models.Position{
+        {ID: 1, Symbol: "BTC", Quantity: decimal.NewFromInt(1), EntryPrice: decimal.NewFromInt(10000)},
+        {ID: 2, Symbol: "AAPL", Quantity: decimal.NewFromInt(100), EntryPrice: decimal.NewFromInt(100)},
+        {ID: 3, Symbol: "UNLISTED", Quantity: decimal.NewFromInt(10), EntryPrice: decimal.NewFromInt(1000)},
+    }
+
+    mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+        WithArgs([]string{"BTC", "AAPL", "UNLISTED"}).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+            AddRow("BTC", "CRYPTO").
+            AddRow("AAPL", AssetClassEquity))
+
+    classes, err := manager.getAssetClasses(ctx, positions)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.Equal(t, map[string]string{
+        "BTC":      AssetClassCrypto,
+        "AAPL":     AssetClassEquity,
+        "UNLISTED": AssetClassEquity,
+    }, classes)
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    t.Run("Same volatility against each class's threshold", func(t *testing.T) {
+        // 8% daily volatility is within crypto's 10% but over equity's 3%
+        volatilities := map[string]float64{"BTC": 0.08, "AAPL": 0.08, "UNLISTED": 0.01}
+        byClass := manager.assetClassAlerts(positions, classes, map[string]float64{}, volatilities, 1)
+
+        assert.Empty(t, alertsOfType(byClass[AssetClassCrypto], "HIGH_VOLATILITY"))
+
+        equityVol := alertsOfType(byClass[AssetClassEquity], "HIGH_VOLATILITY")
+        if !assert.Len(t, equityVol, 1) {
+            return
+        }
+        assert.Equal(t, "AAPL", equityVol[0].Symbol)
+    })
+
+    t.Run("Configured class limits", func(t *testing.T) {
+        strict := NewRiskManager(nil).WithAssetClassConfig("Crypto", AssetClassRiskConfig{MaxVolatility: 0.05})
+        byClass := strict.assetClassAlerts(positions, classes, map[string]float64{}, map[string]float64{"BTC": 0.08}, 1)
+        assert.Len(t, alertsOfType(byClass[AssetClassCrypto], "HIGH_VOLATILITY"), 1)
+    })
+}
+
+func TestRiskManager_MixedPortfolio(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    // The portfolio's euros are worth $1.10 each
+    manager := NewRiskManager(db).WithPriceSource(fakePrices{"EURUSD": 1.1})
+    portfolioID := int64(1)
+
+    // $15,000 of stock beside $5,000 of cash and $5,000 of USDC
+    mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+        WithArgs(portfolioID).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(1, portfolioID, "AAPL", 100.0, 150.0).
+            AddRow(2, portfolioID, "USD", 5000.0, 1.0).
+            AddRow(3, portfolioID, "USDC", 5000.0, 1.0))
+
+    // USDC has no row, and is recognised as a stablecoin
+    mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+        WithArgs([]string{"AAPL", "USD", "USDC"}).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+            AddRow("AAPL", AssetClassEquity).
+            AddRow("USD", "cash"))
+
+    // The portfolio's cash is another $4,000 and EUR 1,000
+    mock.ExpectQuery("SELECT (.+) FROM portfolios (.+) UNION ALL SELECT currency, amount FROM portfolio_cash").
+        WithArgs(portfolioID, "USD").
+        WillReturnRows(sqlmock.NewRows([]string{"currency", "amount"}).
+            AddRow("USD", 4000.0).
+            AddRow("EUR", 1000.0))
+
+    // Only the stock's market data is read
+    mock.ExpectQuery("WITH position_returns").
+        WithArgs([]int64{1}, sqlmock.AnyArg()).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "var_return", "es_return", "observations"}).
+            AddRow("AAPL", -0.02, -0.03, 250))
+    mock.ExpectQuery("SELECT (.+) FROM market_data WHERE symbol = (.+)").
+        WithArgs("AAPL").
+        WillReturnRows(sqlmock.NewRows([]string{"drawdown"}).AddRow(0.1))
+    mock.ExpectQuery("WITH daily_returns").
+        WithArgs([]string{"AAPL"}).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "volatility", "observations"}).AddRow("AAPL", 0.02, 21))
+
+    metrics, err := manager.AnalyzeRisk(context.Background(), portfolioID)
+    if !assert.NoError(t, err) {
+        return
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    // Cash and USDC don't dilute the stock's volatility or add to VaR...
+    assert.InDelta(t, 0.02, *metrics.Volatility, 1e-9)
+    assert.InDelta(t, 15000*-0.02*10, *metrics.ValueAtRisk, 1e-6)
+    // ...but are part of the portfolio the stock is concentrated in, with
+    // the euros at their dollar value
+    assert.InDelta(t, 15000.0/30100, metrics.Concentration, 1e-9)
+
+    assert.Contains(t, metrics.AlertsByAssetClass, AssetClassEquity)
+    assert.NotContains(t, metrics.AlertsByAssetClass, "cash")
+    assert.NotContains(t, metrics.AlertsByAssetClass, "stablecoin")
+}
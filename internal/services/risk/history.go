@@ -0,0 +1,43 @@
+package risk
+
+import (
+    "context"
+    "fmt"
+    "time"
+)
+
+// RecordRisk runs AnalyzeRisk and stores the result as the portfolio's
+// risk for the current UTC day in portfolio_risk_history, replacing any
+// earlier result for the day. AnalyzeRisk measures the current positions,
+// so only today's entry can be recorded.
+func (rm *RiskManager) RecordRisk(ctx context.Context, portfolioID int64) (*RiskMetrics, error) {
+    metrics, err := rm.AnalyzeRisk(ctx, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+
+    now := time.Now().UTC()
+    query := `
+        INSERT INTO portfolio_risk_history (
+            portfolio_id, day, var, expected_shortfall, drawdown,
+            concentration, volatility, alert_level, computed_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (portfolio_id, day) DO UPDATE SET
+            var = EXCLUDED.var,
+            expected_shortfall = EXCLUDED.expected_shortfall,
+            drawdown = EXCLUDED.drawdown,
+            concentration = EXCLUDED.concentration,
+            volatility = EXCLUDED.volatility,
+            alert_level = EXCLUDED.alert_level,
+            computed_at = EXCLUDED.computed_at
+    `
+    _, err = rm.db.ExecContext(ctx, query,
+        portfolioID, now.Format("2006-01-02"),
+        metrics.ValueAtRisk, metrics.ExpectedShortfall, metrics.Drawdown,
+        metrics.Concentration, metrics.Volatility, metrics.AlertLevel, now,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to record risk of portfolio %d: %w", portfolioID, err)
+    }
+    return metrics, nil
+}
@@ -3,12 +3,52 @@ package risk
 import (
     "context"
     "database/sql"
+    "errors"
     "fmt"
     "time"
 
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/monitoring"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/services/valuation"
 )
 
+// DefaultVolatilityAlertThreshold is the daily portfolio volatility above
+// which a HIGH_VOLATILITY alert is raised
+const DefaultVolatilityAlertThreshold = 0.02
+
+// DefaultMaxExpectedShortfall is the expected shortfall, as a loss in a
+// fraction of the portfolio's value, above which an ES_EXCEEDED alert is
+// raised. It sits above the VaR threshold since the shortfall is never
+// smaller than VaR.
+const DefaultMaxExpectedShortfall = 0.25
+
+// DefaultMinInformationRatio is the information ratio below which an
+// InformationRatioAlert is raised, as the portfolio is persistently
+// trailing its benchmark
+const DefaultMinInformationRatio = -0.5
+
+// InformationRatioAlert is the type of alert raised for persistent
+// underperformance of the benchmark
+const InformationRatioAlert = "INFORMATION_RATIO_LOW"
+
+// RegimeSource reports whether the market is in a high-volatility regime
+type RegimeSource interface {
+    IsHighVolatility(ctx context.Context) (bool, error)
+}
+
+// ErrInsufficientData is returned by a PerformanceSource without enough
+// snapshot history to measure a portfolio
+var ErrInsufficientData = errors.New("insufficient data")
+
+// PerformanceSource measures a portfolio's performance against a benchmark
+type PerformanceSource interface {
+    ComputeInformationRatio(ctx context.Context, portfolioID int64, benchmarkSymbol string, window int) (float64, error)
+}
+
 type RiskManager struct {
     db *sql.DB
     // Risk thresholds
@@ -16,15 +56,45 @@ type RiskManager struct {
     maxConcentration float64
     varConfidence   float64
     varDays         int
+    varMethod       VaRMethod
+    maxExpectedShortfall float64
+    volatilityThreshold float64
+
+    regimes           RegimeSource
+    highVolMultiplier float64
+
+    performance            PerformanceSource
+    benchmarkSymbol        string
+    informationRatioWindow int
+    minInformationRatio    float64
+
+    // assetClasses holds the per-position thresholds of each asset class
+    assetClasses map[string]AssetClassRiskConfig
+    // valuers resolves asset classes and which of them are volatile
+    valuers *valuation.Registry
+    // samples is the minimum history of each symbol's VaR and volatility
+    samples sample.Policy
+    // prices converts cash held in other currencies
+    prices models.PriceSource
 }
 
+// RiskMetrics leaves VaR, expected shortfall and volatility nil when none
+// of the volatile positions have enough history to measure them, with the
+// positions left out noted in DataQuality
 type RiskMetrics struct {
-    ValueAtRisk    float64   `json:"var"`          // Value at Risk
+    ValueAtRisk    *float64  `json:"var"`          // Value at Risk
+    ExpectedShortfall *float64 `json:"expected_shortfall"` // Mean loss beyond VaR
     Drawdown       float64   `json:"drawdown"`     // Current drawdown
     Concentration  float64   `json:"concentration"` // Highest single asset concentration
-    Volatility    float64   `json:"volatility"`   // Portfolio volatility
+    Volatility    *float64  `json:"volatility"`   // Portfolio volatility
+    InformationRatio float64 `json:"information_ratio"` // Annualised active return per unit of tracking error
     AlertLevel    string    `json:"alert_level"`  // GREEN, YELLOW, RED
     Alerts        []Alert   `json:"alerts"`       // Active risk alerts
+    // AlertsByAssetClass holds the alerts of individual positions, checked
+    // against their asset class's thresholds
+    AlertsByAssetClass map[string][]Alert `json:"alerts_by_asset_class"`
+    // DataQuality explains the positions left out of VaR or volatility
+    DataQuality []sample.Note `json:"data_quality,omitempty"`
 }
 
 type Alert struct {
@@ -32,6 +102,8 @@ type Alert struct {
     Message     string    `json:"message"`
     Severity    string    `json:"severity"`
     Timestamp   time.Time `json:"timestamp"`
+    // Symbol is set on alerts about a single position
+    Symbol      string    `json:"symbol,omitempty"`
 }
 
 func NewRiskManager(db *sql.DB) *RiskManager {
@@ -41,54 +113,233 @@ func NewRiskManager(db *sql.DB) *RiskManager {
         maxConcentration: 0.30,  // 30% maximum in single asset
         varConfidence:   0.95,  // 95% VaR confidence
         varDays:         10,    // 10-day VaR
+        varMethod:       VaRHistorical,
+        maxExpectedShortfall: DefaultMaxExpectedShortfall,
+        volatilityThreshold: DefaultVolatilityAlertThreshold,
+        minInformationRatio: DefaultMinInformationRatio,
+        assetClasses:    defaultAssetClassRiskConfigs(),
+        valuers:         valuation.NewRegistry(db, nil, valuation.DefaultCurrency),
+        samples:         sample.DefaultPolicy(),
+    }
+}
+
+// WithSamplePolicy sets the minimum history of the VaR and volatility of
+// each position
+func (rm *RiskManager) WithSamplePolicy(policy sample.Policy) *RiskManager {
+    rm.samples = policy
+    return rm
+}
+
+// WithValuers resolves asset classes, and which of them carry market risk,
+// with valuers
+func (rm *RiskManager) WithValuers(valuers *valuation.Registry) *RiskManager {
+    rm.valuers = valuers
+    return rm
+}
+
+// WithPriceSource values cash held in other currencies than
+// models.PortfolioCurrency at the price of its pair from prices, as
+// snapshots do
+func (rm *RiskManager) WithPriceSource(prices models.PriceSource) *RiskManager {
+    rm.prices = prices
+    return rm
+}
+
+// WithMaxExpectedShortfall sets the expected shortfall, as a fraction of
+// the portfolio's value, above which an ES_EXCEEDED alert is raised. Zero
+// raises none.
+func (rm *RiskManager) WithMaxExpectedShortfall(limit float64) *RiskManager {
+    rm.maxExpectedShortfall = limit
+    return rm
+}
+
+// WithVaRMethod sets how VaR and expected shortfall are estimated
+func (rm *RiskManager) WithVaRMethod(method VaRMethod) *RiskManager {
+    rm.varMethod = method
+    return rm
+}
+
+// WithRegimes scales the volatility alert threshold by multiplier while the
+// market is in a high-volatility regime, so a multiplier below 1 alerts
+// sooner when markets are turbulent
+func (rm *RiskManager) WithRegimes(regimes RegimeSource, multiplier float64) *RiskManager {
+    rm.regimes = regimes
+    rm.highVolMultiplier = multiplier
+    return rm
+}
+
+// WithPerformance raises an InformationRatioAlert when the portfolio's
+// information ratio against benchmarkSymbol, over window daily returns,
+// falls below DefaultMinInformationRatio
+func (rm *RiskManager) WithPerformance(performance PerformanceSource, benchmarkSymbol string, window int) *RiskManager {
+    rm.performance = performance
+    rm.benchmarkSymbol = benchmarkSymbol
+    rm.informationRatioWindow = window
+    return rm
+}
+
+// informationRatio is the portfolio's information ratio, or zero without a
+// PerformanceSource or enough snapshot history to measure it
+func (rm *RiskManager) informationRatio(ctx context.Context, portfolioID int64) (float64, error) {
+    if rm.performance == nil {
+        return 0, nil
+    }
+    ir, err := rm.performance.ComputeInformationRatio(ctx, portfolioID, rm.benchmarkSymbol, rm.informationRatioWindow)
+    if errors.Is(err, ErrInsufficientData) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to compute information ratio of portfolio %d: %w", portfolioID, err)
+    }
+    return ir, nil
+}
+
+// volatilityScale is what volatility thresholds are multiplied by in the
+// current regime. If the regime can't be read the normal thresholds apply.
+func (rm *RiskManager) volatilityScale(ctx context.Context) float64 {
+    if rm.regimes == nil || rm.highVolMultiplier <= 0 {
+        return 1
+    }
+    highVol, err := rm.regimes.IsHighVolatility(ctx)
+    if err != nil {
+        logger.FromContext(ctx).Warnf("Failed to read market regime, using default volatility threshold: %v", err)
+        return 1
+    }
+    if highVol {
+        return rm.highVolMultiplier
+    }
+    return 1
+}
+
+// portfolioLimits are the limits the portfolio as a whole is checked against
+func (rm *RiskManager) portfolioLimits(volScale float64) AssetClassRiskConfig {
+    return AssetClassRiskConfig{
+        MaxVolatility:    rm.volatilityThreshold * volScale,
+        MaxDrawdown:      rm.maxDrawdown,
+        MaxConcentration: rm.maxConcentration,
+        MaxExpectedShortfall: rm.maxExpectedShortfall,
+        MinInformationRatio:  rm.minInformationRatio,
     }
 }
 
 func (rm *RiskManager) AnalyzeRisk(ctx context.Context, portfolioID int64) (*RiskMetrics, error) {
+    defer monitoring.StartStage(ctx, monitoring.StageRisk)()
     // Get portfolio positions
     positions, err := rm.getPositions(ctx, portfolioID)
     if err != nil {
         return nil, err
     }
 
+    classes, err := rm.getAssetClasses(ctx, positions)
+    if err != nil {
+        return nil, err
+    }
+    cash, err := rm.getCash(ctx, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    for _, pos := range cash {
+        classes[pos.Symbol] = valuation.TypeCash
+    }
+    positions = append(positions, cash...)
+    // Cash and stablecoins have no market risk, so only the other positions
+    // are measured
+    volatile := rm.volatilePositions(positions, classes)
+
     // Calculate metrics
-    valueAtRisk, err := rm.calculateVaR(ctx, positions)
+    var notes []sample.Note
+    valueAtRisk, expectedShortfall, err := rm.calculateVaR(ctx, volatile, &notes)
+    if err != nil {
+        return nil, err
+    }
+
+    drawdown, drawdowns, err := rm.calculateDrawdown(ctx, volatile)
     if err != nil {
         return nil, err
     }
 
-    drawdown, err := rm.calculateDrawdown(ctx, positions)
+    concentration, err := rm.calculateConcentration(ctx, positions, classes)
     if err != nil {
         return nil, err
     }
 
-    concentration, err := rm.calculateConcentration(ctx, positions)
+    volatility, volatilities, err := rm.calculateVolatility(ctx, volatile, &notes)
     if err != nil {
         return nil, err
     }
 
-    volatility, err := rm.calculateVolatility(ctx, positions)
+    informationRatio, err := rm.informationRatio(ctx, portfolioID)
     if err != nil {
         return nil, err
     }
 
-    // Generate alerts
-    alerts := rm.generateAlerts(valueAtRisk, drawdown, concentration, volatility)
-    alertLevel := rm.determineAlertLevel(alerts)
+    // Generate alerts. VaR and expected shortfall are dollar amounts, so
+    // they are checked as losses in a fraction of the portfolio's value.
+    var portfolioValue float64
+    for _, pos := range positions {
+        portfolioValue += models.DecimalToFloat(pos.CostBasis())
+    }
+    volScale := rm.volatilityScale(ctx)
+    // Unmeasured metrics raise no alerts
+    alerts := rm.generateAlerts(lossFraction(valueAtRisk, portfolioValue), lossFraction(expectedShortfall, portfolioValue),
+        drawdown, concentration, valueOrZero(volatility), informationRatio, rm.portfolioLimits(volScale))
+    byClass := rm.assetClassAlerts(positions, classes, drawdowns, volatilities, volScale)
+
+    var allAlerts []Alert
+    allAlerts = append(allAlerts, alerts...)
+    for _, classAlerts := range byClass {
+        allAlerts = append(allAlerts, classAlerts...)
+    }
+    alertLevel := rm.determineAlertLevel(allAlerts)
 
     return &RiskMetrics{
         ValueAtRisk:   valueAtRisk,
+        ExpectedShortfall: expectedShortfall,
         Drawdown:      drawdown,
         Concentration: concentration,
         Volatility:    volatility,
+        InformationRatio: informationRatio,
         AlertLevel:    alertLevel,
         Alerts:        alerts,
+        AlertsByAssetClass: byClass,
+        DataQuality:   notes,
     }, nil
 }
 
-func (rm *RiskManager) calculateVaR(ctx context.Context, positions []models.Position) (float64, error) {
-    // Historical VaR calculation
-    query := `
+// valueOrZero is *v, or zero when v is nil
+func valueOrZero(v *float64) float64 {
+    if v == nil {
+        return 0
+    }
+    return *v
+}
+
+// lossFraction is the loss in v, a dollar VaR or expected shortfall with
+// losses negative, as a fraction of value. Unmeasured metrics and gains
+// are no loss.
+func lossFraction(v *float64, value float64) float64 {
+    if v == nil || *v >= 0 || value <= 0 {
+        return 0
+    }
+    return -*v / value
+}
+
+// measured returns a pointer to v, or nil when there were positions to
+// measure but none of them could be
+func measured(v float64, positions, measuredPositions int) *float64 {
+    if positions > 0 && measuredPositions == 0 {
+        return nil
+    }
+    return &v
+}
+
+// calculateVaR returns the VaR and expected shortfall of the positions at
+// the configured confidence, scaled to the VaR period. Both are position
+// value times the tail return, so losses are negative. Symbols with fewer
+// daily returns than the sample.VaR minimum are left out, with a note added
+// to notes.
+func (rm *RiskManager) calculateVaR(ctx context.Context, positions []models.Position, notes *[]sample.Note) (*float64, *float64, error) {
+    returnsQuery := `
         WITH position_returns AS (
             SELECT 
                 p.symbol,
@@ -99,54 +350,118 @@ func (rm *RiskManager) calculateVaR(ctx context.Context, positions []models.Posi
             JOIN market_data m ON p.symbol = m.symbol
             WHERE p.id = ANY($1)
             AND m.timestamp >= NOW() - INTERVAL '1 year'
+            AND m.close > 0
             ORDER BY m.timestamp DESC
-        )
+        )`
+
+    positionIDs := make([]int64, len(positions))
+    for i, p := range positions {
+        positionIDs[i] = p.ID
+    }
+
+    var rows *sql.Rows
+    var err error
+    if rm.varMethod == VaRParametric {
+        // Mean and deviation of daily returns, turned into VaR and ES
+        // assuming they are normal
+        query := returnsQuery + `
         SELECT 
             symbol,
-            PERCENTILE_CONT($2) WITHIN GROUP (ORDER BY daily_return) as var_return
+            AVG(daily_return) as mean_return,
+            STDDEV(daily_return) as stddev_return,
+            COUNT(*) as observations
         FROM position_returns
         WHERE daily_return IS NOT NULL
         GROUP BY symbol
     `
-
-    positionIDs := make([]int64, len(positions))
-    for i, p := range positions {
-        positionIDs[i] = p.ID
+        rows, err = rm.db.QueryContext(ctx, query, positionIDs)
+    } else {
+        // Historical VaR is the return percentile and ES the mean of the
+        // returns at or below it
+        query := returnsQuery + `,
+        cutoffs AS (
+            SELECT 
+                symbol,
+                PERCENTILE_CONT($2) WITHIN GROUP (ORDER BY daily_return) as var_return,
+            COUNT(*) as observations
+            FROM position_returns
+            WHERE daily_return IS NOT NULL
+            GROUP BY symbol
+        )
+        SELECT 
+            c.symbol,
+            c.var_return,
+            AVG(r.daily_return) as es_return,
+            c.observations
+        FROM cutoffs c
+        JOIN position_returns r ON r.symbol = c.symbol AND r.daily_return <= c.var_return
+        GROUP BY c.symbol, c.var_return, c.observations
+    `
+        rows, err = rm.db.QueryContext(ctx, query, positionIDs, 1-rm.varConfidence)
     }
-
-    rows, err := rm.db.QueryContext(ctx, query, positionIDs, 1-rm.varConfidence)
     if err != nil {
-        return 0, err
+        return nil, nil, err
     }
     defer rows.Close()
 
-    var totalVaR float64
+    var totalVaR, totalES float64
+    observed := make(map[string]int, len(positions))
     for rows.Next() {
         var symbol string
-        var varReturn float64
-        if err := rows.Scan(&symbol, &varReturn); err != nil {
-            return 0, err
+        var tail TailReturns
+        var observations int
+        if rm.varMethod == VaRParametric {
+            var mean, stdDev float64
+            if err := rows.Scan(&symbol, &mean, &stdDev, &observations); err != nil {
+                return nil, nil, err
+            }
+            tail = ParametricTail(mean, stdDev, rm.varConfidence)
+        } else {
+            if err := rows.Scan(&symbol, &tail.VaR, &tail.ExpectedShortfall, &observations); err != nil {
+                return nil, nil, err
+            }
+        }
+        observed[symbol] = observations
+        if rm.samples.Check(sample.VaR, symbol, observations) != nil {
+            continue
         }
 
         // Find position value
         var positionValue float64
         for _, p := range positions {
             if p.Symbol == symbol {
-                positionValue = p.Quantity * p.EntryPrice
+                positionValue = models.DecimalToFloat(p.CostBasis())
                 break
             }
         }
 
-        // Add to total VaR
-        totalVaR += positionValue * varReturn
+        totalVaR += positionValue * tail.VaR
+        totalES += positionValue * tail.ExpectedShortfall
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, nil, err
+    }
+
+    measuredPositions := 0
+    for _, p := range positions {
+        if note := rm.samples.Check(sample.VaR, p.Symbol, observed[p.Symbol]); note != nil {
+            *notes = append(*notes, *note)
+        } else {
+            measuredPositions++
+        }
     }
 
     // Scale to configured VaR period
-    return totalVaR * float64(rm.varDays), nil
+    return measured(totalVaR*float64(rm.varDays), len(positions), measuredPositions),
+        measured(totalES*float64(rm.varDays), len(positions), measuredPositions), nil
 }
 
-func (rm *RiskManager) calculateDrawdown(ctx context.Context, positions []models.Position) (float64, error) {
+// calculateDrawdown returns the value-weighted drawdown of the positions and
+// the drawdown of each symbol
+func (rm *RiskManager) calculateDrawdown(ctx context.Context, positions []models.Position) (float64, map[string]float64, error) {
     var totalDrawdown float64
+    drawdowns := make(map[string]float64, len(positions))
 
     for _, pos := range positions {
         query := `
@@ -158,30 +473,38 @@ func (rm *RiskManager) calculateDrawdown(ctx context.Context, positions []models
 
         var drawdown float64
         if err := rm.db.QueryRowContext(ctx, query, pos.Symbol).Scan(&drawdown); err != nil {
-            return 0, err
+            return 0, nil, err
         }
 
-        totalDrawdown += drawdown * (pos.Quantity * pos.EntryPrice)
+        drawdowns[pos.Symbol] = drawdown
+        totalDrawdown += drawdown * models.DecimalToFloat(pos.CostBasis())
     }
 
-    return totalDrawdown, nil
+    return totalDrawdown, drawdowns, nil
 }
 
-func (rm *RiskManager) calculateConcentration(ctx context.Context, positions []models.Position) (float64, error) {
-    var totalValue, maxPosition float64
+// calculateConcentration returns the largest volatile position's share of
+// the portfolio. Cash and stablecoins aren't a concentration risk, but do
+// make up part of the portfolio.
+func (rm *RiskManager) calculateConcentration(ctx context.Context, positions []models.Position, classes map[string]string) (float64, error) {
+    totalValue, maxPosition := decimal.Zero, decimal.Zero
 
     for _, pos := range positions {
-        value := pos.Quantity * pos.EntryPrice
-        totalValue += value
-        if value > maxPosition {
+        value := pos.CostBasis()
+        totalValue = totalValue.Add(value)
+        if rm.valuers.Volatile(classes[pos.Symbol]) && value.GreaterThan(maxPosition) {
             maxPosition = value
         }
     }
 
-    return maxPosition / totalValue, nil
+    return models.DecimalToFloat(maxPosition) / models.DecimalToFloat(totalValue), nil
 }
 
-func (rm *RiskManager) calculateVolatility(ctx context.Context, positions []models.Position) (float64, error) {
+// calculateVolatility returns the value-weighted daily volatility of the
+// positions and the volatility of each symbol. Symbols with fewer daily
+// returns than the sample.Volatility minimum are left out of both, with a
+// note added to notes.
+func (rm *RiskManager) calculateVolatility(ctx context.Context, positions []models.Position, notes *[]sample.Note) (*float64, map[string]float64, error) {
     query := `
         WITH daily_returns AS (
             SELECT 
@@ -190,10 +513,12 @@ func (rm *RiskManager) calculateVolatility(ctx context.Context, positions []mode
             FROM market_data
             WHERE symbol = ANY($1)
             AND timestamp >= NOW() - INTERVAL '30 days'
+            AND close > 0
         )
         SELECT 
             symbol,
-            STDDEV(return) as volatility
+            STDDEV(return) as volatility,
+            COUNT(*) as observations
         FROM daily_returns
         WHERE return IS NOT NULL
         GROUP BY symbol
@@ -206,69 +531,114 @@ func (rm *RiskManager) calculateVolatility(ctx context.Context, positions []mode
 
     rows, err := rm.db.QueryContext(ctx, query, symbols)
     if err != nil {
-        return 0, err
+        return nil, nil, err
     }
     defer rows.Close()
 
     var totalVolatility float64
     totalValue := 0.0
+    volatilities := make(map[string]float64, len(positions))
+    observed := make(map[string]int, len(positions))
 
     for rows.Next() {
         var symbol string
-        var volatility float64
-        if err := rows.Scan(&symbol, &volatility); err != nil {
-            return 0, err
+        var volatility sql.NullFloat64
+        var observations int
+        if err := rows.Scan(&symbol, &volatility, &observations); err != nil {
+            return nil, nil, err
+        }
+        observed[symbol] = observations
+        if rm.samples.Check(sample.Volatility, symbol, observations) != nil {
+            continue
         }
+        volatilities[symbol] = volatility.Float64
 
         // Find position value
         for _, p := range positions {
             if p.Symbol == symbol {
-                value := p.Quantity * p.EntryPrice
-                totalVolatility += volatility * value
+                value := models.DecimalToFloat(p.CostBasis())
+                totalVolatility += volatility.Float64 * value
                 totalValue += value
                 break
             }
         }
     }
 
-    return totalVolatility / totalValue, nil
+    if err := rows.Err(); err != nil {
+        return nil, nil, err
+    }
+
+    measuredPositions := 0
+    for _, p := range positions {
+        if note := rm.samples.Check(sample.Volatility, p.Symbol, observed[p.Symbol]); note != nil {
+            *notes = append(*notes, *note)
+        } else {
+            measuredPositions++
+        }
+    }
+
+    if totalValue == 0 {
+        return measured(0, len(positions), measuredPositions), volatilities, nil
+    }
+    return measured(totalVolatility/totalValue, len(positions), measuredPositions), volatilities, nil
 }
 
-func (rm *RiskManager) generateAlerts(var_, drawdown, concentration, volatility float64) []Alert {
+// generateAlerts checks metrics against limits. VaR and expected shortfall
+// are losses in a fraction of the portfolio's value. Limits left at zero
+// are not checked.
+func (rm *RiskManager) generateAlerts(var_, expectedShortfall, drawdown, concentration, volatility, informationRatio float64, limits AssetClassRiskConfig) []Alert {
     var alerts []Alert
     now := time.Now()
 
-    if var_ > rm.maxDrawdown {
+    if limits.MaxDrawdown > 0 && var_ > limits.MaxDrawdown {
         alerts = append(alerts, Alert{
             Type:      "VAR_EXCEEDED",
-            Message:   fmt.Sprintf("Value at Risk (%.2f%%) exceeds threshold (%.2f%%)", var_*100, rm.maxDrawdown*100),
+            Message:   fmt.Sprintf("Value at Risk (%.2f%%) exceeds threshold (%.2f%%)", var_*100, limits.MaxDrawdown*100),
             Severity:  "HIGH",
             Timestamp: now,
         })
     }
 
-    if drawdown > rm.maxDrawdown {
+    if limits.MaxExpectedShortfall > 0 && expectedShortfall > limits.MaxExpectedShortfall {
+        alerts = append(alerts, Alert{
+            Type:      "ES_EXCEEDED",
+            Message:   fmt.Sprintf("Expected shortfall (%.2f%%) exceeds threshold (%.2f%%)", expectedShortfall*100, limits.MaxExpectedShortfall*100),
+            Severity:  "HIGH",
+            Timestamp: now,
+        })
+    }
+
+    if limits.MaxDrawdown > 0 && drawdown > limits.MaxDrawdown {
         alerts = append(alerts, Alert{
             Type:      "DRAWDOWN_EXCEEDED",
-            Message:   fmt.Sprintf("Portfolio drawdown (%.2f%%) exceeds maximum (%.2f%%)", drawdown*100, rm.maxDrawdown*100),
+            Message:   fmt.Sprintf("Drawdown (%.2f%%) exceeds maximum (%.2f%%)", drawdown*100, limits.MaxDrawdown*100),
             Severity:  "HIGH",
             Timestamp: now,
         })
     }
 
-    if concentration > rm.maxConcentration {
+    if limits.MaxConcentration > 0 && concentration > limits.MaxConcentration {
         alerts = append(alerts, Alert{
             Type:      "CONCENTRATION_EXCEEDED",
-            Message:   fmt.Sprintf("Asset concentration (%.2f%%) exceeds maximum (%.2f%%)", concentration*100, rm.maxConcentration*100),
+            Message:   fmt.Sprintf("Asset concentration (%.2f%%) exceeds maximum (%.2f%%)", concentration*100, limits.MaxConcentration*100),
             Severity:  "MEDIUM",
             Timestamp: now,
         })
     }
 
-    if volatility > 0.02 { // 2% daily volatility threshold
+    if limits.MaxVolatility > 0 && volatility > limits.MaxVolatility {
         alerts = append(alerts, Alert{
             Type:      "HIGH_VOLATILITY",
-            Message:   fmt.Sprintf("Portfolio volatility (%.2f%%) is high", volatility*100),
+            Message:   fmt.Sprintf("Volatility (%.2f%%) exceeds threshold (%.2f%%)", volatility*100, limits.MaxVolatility*100),
+            Severity:  "MEDIUM",
+            Timestamp: now,
+        })
+    }
+
+    if limits.MinInformationRatio != 0 && informationRatio < limits.MinInformationRatio {
+        alerts = append(alerts, Alert{
+            Type:      InformationRatioAlert,
+            Message:   fmt.Sprintf("Information ratio (%.2f) is below minimum (%.2f)", informationRatio, limits.MinInformationRatio),
             Severity:  "MEDIUM",
             Timestamp: now,
         })
@@ -323,3 +693,46 @@ func (rm *RiskManager) getPositions(ctx context.Context, portfolioID int64) ([]m
 
     return positions, nil
 }
+
+// getCash returns the portfolio's cash as a position in each currency it
+// holds, priced in models.PortfolioCurrency by models.CashRate. Cash
+// borrowed on margin is left out, as it holds nothing to be concentrated
+// against.
+func (rm *RiskManager) getCash(ctx context.Context, portfolioID int64) ([]models.Position, error) {
+    query := `
+        SELECT $2::text, balance FROM portfolios WHERE id = $1 AND balance > 0
+        UNION ALL
+        SELECT currency, amount FROM portfolio_cash WHERE portfolio_id = $1 AND amount > 0
+    `
+    rows, err := rm.db.QueryContext(ctx, query, portfolioID, models.PortfolioCurrency)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get cash of portfolio %d: %w", portfolioID, err)
+    }
+    defer rows.Close()
+
+    var cash []models.Position
+    for rows.Next() {
+        pos := models.Position{PortfolioID: portfolioID}
+        if err := rows.Scan(&pos.Symbol, &pos.Quantity); err != nil {
+            return nil, err
+        }
+        cash = append(cash, pos)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    for i, pos := range cash {
+        rate := 1.0
+        if pos.Symbol != models.PortfolioCurrency {
+            if rm.prices == nil {
+                return nil, fmt.Errorf("no price source to value %s cash in portfolio %d", pos.Symbol, portfolioID)
+            }
+            if rate, err = models.CashRate(ctx, rm.prices, pos.Symbol); err != nil {
+                return nil, fmt.Errorf("failed to price %s cash in portfolio %d: %w", pos.Symbol, portfolioID, err)
+            }
+        }
+        cash[i].EntryPrice = decimal.NewFromFloat(rate)
+    }
+    return cash, nil
+}
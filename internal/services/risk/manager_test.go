@@ -3,12 +3,14 @@ package risk
 import (
     "context"
     "database/sql"
+    "fmt"
     "testing"
     "time"
 
     "github.com/DATA-DOG/go-sqlmock"
     "github.com/stretchr/testify/assert"
     "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/sample"
 )
 
 func TestRiskManager_AnalyzeRisk(t *testing.T) {
@@ -33,10 +35,16 @@ func TestRiskManager_AnalyzeRisk(t *testing.T) {
             WithArgs(portfolioID).
             WillReturnRows(positionRows)
 
+        mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+            WithArgs([]string{"AAPL", "GOOGL"}).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+                AddRow("AAPL", AssetClassEquity).
+                AddRow("GOOGL", AssetClassEquity))
+
         // Mock historical returns for VaR calculation
-        returnsRows := sqlmock.NewRows([]string{"symbol", "var_return"}).
-            AddRow("AAPL", -0.02).
-            AddRow("GOOGL", -0.025)
+        returnsRows := sqlmock.NewRows([]string{"symbol", "var_return", "es_return", "observations"}).
+            AddRow("AAPL", -0.02, -0.03, 250).
+            AddRow("GOOGL", -0.025, -0.035, 250)
 
         mock.ExpectQuery("WITH position_returns").
             WithArgs([]int64{1, 2}, 0.05).
@@ -56,9 +64,9 @@ func TestRiskManager_AnalyzeRisk(t *testing.T) {
             WillReturnRows(drawdownRows)
 
         // Mock volatility calculation
-        volRows := sqlmock.NewRows([]string{"symbol", "volatility"}).
-            AddRow("AAPL", 0.2).
-            AddRow("GOOGL", 0.25)
+        volRows := sqlmock.NewRows([]string{"symbol", "volatility", "observations"}).
+            AddRow("AAPL", 0.2, 21).
+            AddRow("GOOGL", 0.25, 21)
 
         mock.ExpectQuery("WITH daily_returns").
             WithArgs([]string{"AAPL", "GOOGL"}).
@@ -69,9 +77,10 @@ func TestRiskManager_AnalyzeRisk(t *testing.T) {
         assert.NotNil(t, metrics)
 
         // Verify risk metrics
-        assert.InDelta(t, 0.022, metrics.ValueAtRisk, 0.001)
+        assert.InDelta(t, 0.022, *metrics.ValueAtRisk, 0.001)
+        assert.LessOrEqual(t, *metrics.ExpectedShortfall, *metrics.ValueAtRisk)
         assert.InDelta(t, 0.14, metrics.Drawdown, 0.01)
-        assert.InDelta(t, 0.22, metrics.Volatility, 0.01)
+        assert.InDelta(t, 0.22, *metrics.Volatility, 0.01)
         assert.Equal(t, "YELLOW", metrics.AlertLevel)
         assert.Len(t, metrics.Alerts, 1)
     })
@@ -86,9 +95,9 @@ func TestRiskManager_AnalyzeRisk(t *testing.T) {
         metrics, err := manager.AnalyzeRisk(ctx, portfolioID)
         assert.NoError(t, err)
         assert.NotNil(t, metrics)
-        assert.Equal(t, 0.0, metrics.ValueAtRisk)
+        assert.Equal(t, 0.0, *metrics.ValueAtRisk)
         assert.Equal(t, 0.0, metrics.Drawdown)
-        assert.Equal(t, 0.0, metrics.Volatility)
+        assert.Equal(t, 0.0, *metrics.Volatility)
         assert.Equal(t, "GREEN", metrics.AlertLevel)
         assert.Len(t, metrics.Alerts, 0)
     })
@@ -113,15 +122,18 @@ func TestRiskManager_GenerateAlerts(t *testing.T) {
     tests := []struct {
         name          string
         var_         float64
+        es           float64
         drawdown     float64
         concentration float64
         volatility   float64
+        informationRatio float64
         wantLevel    string
         wantAlerts   int
     }{
         {
             name:          "No alerts",
             var_:         0.05,
+            es:           0.08,
             drawdown:     0.10,
             concentration: 0.20,
             volatility:   0.015,
@@ -131,26 +143,50 @@ func TestRiskManager_GenerateAlerts(t *testing.T) {
         {
             name:          "High VaR alert",
             var_:         0.20,
+            es:           0.22,
+            drawdown:     0.10,
+            concentration: 0.20,
+            volatility:   0.015,
+            wantLevel:    "RED",
+            wantAlerts:   1,
+        },
+        {
+            name:          "High expected shortfall alert",
+            var_:         0.12,
+            es:           0.30,
             drawdown:     0.10,
             concentration: 0.20,
             volatility:   0.015,
             wantLevel:    "RED",
             wantAlerts:   1,
         },
+        {
+            name:          "Persistent underperformance alert",
+            var_:         0.05,
+            es:           0.08,
+            drawdown:     0.10,
+            concentration: 0.20,
+            volatility:   0.015,
+            informationRatio: -0.8,
+            wantLevel:    "YELLOW",
+            wantAlerts:   1,
+        },
         {
             name:          "Multiple alerts",
             var_:         0.20,
+            es:           0.30,
             drawdown:     0.18,
             concentration: 0.35,
             volatility:   0.025,
+            informationRatio: -0.6,
             wantLevel:    "RED",
-            wantAlerts:   4,
+            wantAlerts:   6,
         },
     }
 
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            alerts := manager.generateAlerts(tt.var_, tt.drawdown, tt.concentration, tt.volatility)
+            alerts := manager.generateAlerts(tt.var_, tt.es, tt.drawdown, tt.concentration, tt.volatility, tt.informationRatio, manager.portfolioLimits(1))
             level := manager.determineAlertLevel(alerts)
 
             assert.Equal(t, tt.wantLevel, level)
@@ -158,3 +194,185 @@ func TestRiskManager_GenerateAlerts(t *testing.T) {
         })
     }
 }
+
+type staticRegime struct {
+    highVol bool
+}
+
+func (r staticRegime) IsHighVolatility(ctx context.Context) (bool, error) {
+    return r.highVol, nil
+}
+
+func TestRiskManager_VolatilityThresholdByRegime(t *testing.T) {
+    ctx := context.Background()
+
+    assert.Equal(t, 1.0, NewRiskManager(nil).volatilityScale(ctx))
+
+    calm := NewRiskManager(nil).WithRegimes(staticRegime{highVol: false}, 0.5)
+    assert.Equal(t, 1.0, calm.volatilityScale(ctx))
+
+    turbulent := NewRiskManager(nil).WithRegimes(staticRegime{highVol: true}, 0.5)
+    limits := turbulent.portfolioLimits(turbulent.volatilityScale(ctx))
+    assert.InDelta(t, 0.01, limits.MaxVolatility, 1e-12)
+
+    // 1.5% daily volatility only alerts in the high-volatility regime
+    assert.Len(t, calm.generateAlerts(0, 0, 0, 0, 0.015, 0, calm.portfolioLimits(calm.volatilityScale(ctx))), 0)
+    assert.Len(t, turbulent.generateAlerts(0, 0, 0, 0, 0.015, 0, limits), 1)
+}
+
+func TestRiskManager_ExpectedShortfallAlert(t *testing.T) {
+    loss := func(v float64) *float64 { return &v }
+
+    assert.Equal(t, 0.0, lossFraction(nil, 10000))
+    assert.Equal(t, 0.0, lossFraction(loss(500), 10000))
+    assert.Equal(t, 0.0, lossFraction(loss(-500), 0))
+    assert.InDelta(t, 0.3, lossFraction(loss(-3000), 10000), 1e-12)
+
+    // A $3000 shortfall on a $10000 portfolio is a 30% loss, above the
+    // default 25% limit
+    manager := NewRiskManager(nil)
+    alerts := manager.generateAlerts(0, lossFraction(loss(-3000), 10000), 0, 0, 0, 0, manager.portfolioLimits(1))
+    if assert.Len(t, alerts, 1) {
+        assert.Equal(t, "ES_EXCEEDED", alerts[0].Type)
+    }
+
+    lenient := NewRiskManager(nil).WithMaxExpectedShortfall(0.4)
+    assert.Empty(t, lenient.generateAlerts(0, lossFraction(loss(-3000), 10000), 0, 0, 0, 0, lenient.portfolioLimits(1)))
+}
+
+type staticPerformance struct {
+    informationRatio float64
+    err              error
+}
+
+func (p staticPerformance) ComputeInformationRatio(ctx context.Context, portfolioID int64, benchmarkSymbol string, window int) (float64, error) {
+    return p.informationRatio, p.err
+}
+
+func TestRiskManager_InformationRatio(t *testing.T) {
+    ctx := context.Background()
+
+    ir, err := NewRiskManager(nil).informationRatio(ctx, 1)
+    assert.NoError(t, err)
+    assert.Equal(t, 0.0, ir)
+
+    trailing := NewRiskManager(nil).WithPerformance(staticPerformance{informationRatio: -0.8}, "SPY", 90)
+    ir, err = trailing.informationRatio(ctx, 1)
+    assert.NoError(t, err)
+    assert.Equal(t, -0.8, ir)
+    alerts := trailing.generateAlerts(0, 0, 0, 0, 0, ir, trailing.portfolioLimits(1))
+    if assert.Len(t, alerts, 1) {
+        assert.Equal(t, InformationRatioAlert, alerts[0].Type)
+        assert.Equal(t, "MEDIUM", alerts[0].Severity)
+    }
+
+    // A portfolio too new to measure is not alerted on
+    young := NewRiskManager(nil).WithPerformance(staticPerformance{err: fmt.Errorf("%w for SPY", ErrInsufficientData)}, "SPY", 90)
+    ir, err = young.informationRatio(ctx, 1)
+    assert.NoError(t, err)
+    assert.Equal(t, 0.0, ir)
+
+    // but a failure to measure it isn't taken for a new portfolio
+    failing := NewRiskManager(nil).WithPerformance(staticPerformance{err: sql.ErrConnDone}, "SPY", 90)
+    _, err = failing.informationRatio(ctx, 1)
+    assert.ErrorIs(t, err, sql.ErrConnDone)
+}
+
+func TestRiskManager_InsufficientHistory(t *testing.T) {
+    portfolioID := int64(1)
+
+    // analyze measures $15,000 of AAPL, with a year of history, beside
+    // $5,000 of a stock listed newcoReturns days ago
+    analyze := func(manager *RiskManager, mock sqlmock.Sqlmock, newcoReturns int) *RiskMetrics {
+        mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+            WithArgs(portfolioID).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+                AddRow(1, portfolioID, "AAPL", 100.0, 150.0).
+                AddRow(2, portfolioID, "NEWCO", 100.0, 50.0))
+        mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+            WithArgs([]string{"AAPL", "NEWCO"}).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+                AddRow("AAPL", AssetClassEquity).
+                AddRow("NEWCO", AssetClassEquity))
+        mock.ExpectQuery("SELECT (.+) FROM portfolios (.+) UNION ALL SELECT currency, amount FROM portfolio_cash").
+            WithArgs(portfolioID, "USD").
+            WillReturnRows(sqlmock.NewRows([]string{"currency", "amount"}))
+        mock.ExpectQuery("WITH position_returns").
+            WithArgs([]int64{1, 2}, sqlmock.AnyArg()).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "var_return", "es_return", "observations"}).
+                AddRow("AAPL", -0.02, -0.03, 250).
+                AddRow("NEWCO", -0.05, -0.08, newcoReturns))
+        for _, symbol := range []string{"AAPL", "NEWCO"} {
+            mock.ExpectQuery("SELECT (.+) FROM market_data WHERE symbol = (.+)").
+                WithArgs(symbol).
+                WillReturnRows(sqlmock.NewRows([]string{"drawdown"}).AddRow(0.05))
+        }
+        mock.ExpectQuery("WITH daily_returns").
+            WithArgs([]string{"AAPL", "NEWCO"}).
+            WillReturnRows(sqlmock.NewRows([]string{"symbol", "volatility", "observations"}).
+                AddRow("AAPL", 0.01, 21).
+                AddRow("NEWCO", 0.04, newcoReturns))
+
+        metrics, err := manager.AnalyzeRisk(context.Background(), portfolioID)
+        assert.NoError(t, err)
+        assert.NoError(t, mock.ExpectationsWereMet())
+        return metrics
+    }
+
+    t.Run("Position below the minimum is left out", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        metrics := analyze(NewRiskManager(db), mock, 12)
+        if !assert.NotNil(t, metrics) {
+            return
+        }
+        assert.InDelta(t, 15000*-0.02*10, *metrics.ValueAtRisk, 1e-6)
+        assert.InDelta(t, 0.01, *metrics.Volatility, 1e-9)
+        assert.Equal(t, []sample.Note{
+            {Metric: sample.VaR, Symbol: "NEWCO", Observations: 12, Required: 60,
+                Reason: "insufficient history (12 of 60 required observations)"},
+            {Metric: sample.Volatility, Symbol: "NEWCO", Observations: 12, Required: 15,
+                Reason: "insufficient history (12 of 15 required observations)"},
+        }, metrics.DataQuality)
+    })
+
+    t.Run("Position at the minimum is measured", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        policy := sample.Policy{sample.VaR: 20, sample.Volatility: 20}
+        metrics := analyze(NewRiskManager(db).WithSamplePolicy(policy), mock, 20)
+        if !assert.NotNil(t, metrics) {
+            return
+        }
+        assert.InDelta(t, (15000*-0.02+5000*-0.05)*10, *metrics.ValueAtRisk, 1e-6)
+        assert.InDelta(t, (15000*0.01+5000*0.04)/20000, *metrics.Volatility, 1e-9)
+        assert.Empty(t, metrics.DataQuality)
+    })
+
+    t.Run("No position with enough history", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        policy := sample.Policy{sample.VaR: 500, sample.Volatility: 500}
+        metrics := analyze(NewRiskManager(db).WithSamplePolicy(policy), mock, 12)
+        if !assert.NotNil(t, metrics) {
+            return
+        }
+        assert.Nil(t, metrics.ValueAtRisk)
+        assert.Nil(t, metrics.ExpectedShortfall)
+        assert.Nil(t, metrics.Volatility)
+        assert.Len(t, metrics.DataQuality, 4)
+        assert.Empty(t, alertsOfType(metrics.Alerts, "HIGH_VOLATILITY"))
+    })
+}
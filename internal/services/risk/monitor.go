@@ -0,0 +1,516 @@
+package risk
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/cache"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    // priceUpdateChannelPrefix is followed by the symbol in the channels the
+    // market data pipeline publishes a symbol's changed market data to
+    priceUpdateChannelPrefix = "market:updates:"
+    // realtimeKeyPrefix is followed by the symbol in the keys the pipeline
+    // stores the latest market data under, read when a notification carries
+    // no data
+    realtimeKeyPrefix = "market:realtime:"
+
+    defaultMonitorDebounce  = 30 * time.Second
+    defaultAlertDedupWindow = time.Hour
+    // monitorFlushInterval is how often pending price updates are applied
+    monitorFlushInterval = time.Second
+    // maxRecalcsPerFlush caps the portfolios recalculated per flush. The
+    // rest stay pending and are picked up by later flushes.
+    maxRecalcsPerFlush = 500
+)
+
+// AlertSink delivers risk alerts that passed deduplication
+type AlertSink interface {
+    Notify(ctx context.Context, portfolioID int64, alerts []Alert) error
+}
+
+// IntradayMetrics are the risk metrics the monitor keeps current between
+// full recalculations, valued at the latest streamed prices
+type IntradayMetrics struct {
+    PortfolioID   int64     `json:"portfolio_id"`
+    Value         float64   `json:"value"`
+    Concentration float64   `json:"concentration"`
+    // Drawdown is from the highest value seen today
+    Drawdown      float64   `json:"drawdown"`
+    UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type monitoredPortfolio struct {
+    positions []models.Position
+    peak      float64
+    peakDay   time.Time
+    lastCalc  time.Time
+    metrics   IntradayMetrics
+}
+
+type alertKey struct {
+    portfolioID int64
+    alertType   string
+    symbol      string
+}
+
+// Monitor recalculates cheap risk metrics of the portfolios holding a
+// symbol as its price updates stream in, debounced per portfolio. Full
+// AnalyzeRisk runs only happen in RunFull. Under load, updates coalesce
+// into bounded pending sets and recalculations are deferred, never queued.
+type Monitor struct {
+    rm       *RiskManager
+    rdb      *redis.Client
+    sink     AlertSink
+    debounce time.Duration
+    now      func() time.Time
+
+    mu         sync.Mutex
+    portfolios map[int64]*monitoredPortfolio
+    bySymbol   map[string]map[int64]struct{}
+    prices     map[string]float64
+    // streamed holds prices received since the last flush, dirty symbols
+    // updated since then whose price has to be read, pending the
+    // portfolios awaiting recalculation and stale those whose positions
+    // changed
+    streamed map[string]float64
+    dirty    map[string]struct{}
+    pending  map[int64]struct{}
+    stale    map[int64]struct{}
+    // deferred counts recalculations put off by the per-flush cap
+    deferred int64
+
+    alertMu     sync.Mutex
+    dedupWindow time.Duration
+    lastAlerted map[alertKey]time.Time
+}
+
+func NewMonitor(rm *RiskManager, rdb *redis.Client, sink AlertSink) *Monitor {
+    return &Monitor{
+        rm:          rm,
+        rdb:         rdb,
+        sink:        sink,
+        debounce:    defaultMonitorDebounce,
+        now:         time.Now,
+        portfolios:  make(map[int64]*monitoredPortfolio),
+        bySymbol:    make(map[string]map[int64]struct{}),
+        prices:      make(map[string]float64),
+        streamed:    make(map[string]float64),
+        dirty:       make(map[string]struct{}),
+        pending:     make(map[int64]struct{}),
+        stale:       make(map[int64]struct{}),
+        dedupWindow: defaultAlertDedupWindow,
+        lastAlerted: make(map[alertKey]time.Time),
+    }
+}
+
+// WithDebounce sets the minimum time between recalculations of a portfolio
+func (m *Monitor) WithDebounce(d time.Duration) *Monitor {
+    m.debounce = d
+    return m
+}
+
+// Start loads every portfolio's positions, then applies price updates until
+// ctx is done. The subscription to updates is renewed whenever Redis drops
+// it. Without Redis nothing is streamed, so only RunFull updates risk.
+func (m *Monitor) Start(ctx context.Context) error {
+    if err := m.Reload(ctx); err != nil {
+        return err
+    }
+
+    if m.rdb == nil {
+        logger.FromContext(ctx).Infof("Redis disabled, intraday risk monitoring off")
+        <-ctx.Done()
+        return ctx.Err()
+    }
+    go cache.Subscribe(ctx, func(ctx context.Context) *redis.PubSub {
+        return m.rdb.PSubscribe(ctx, priceUpdateChannelPrefix+"*")
+    }, m.receive, nil)
+
+    ticker := time.NewTicker(monitorFlushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+            m.flush(ctx)
+        }
+    }
+}
+
+// receive only records the update, so a burst of updates costs one map
+// insert each and can't back up the subscription. The pipeline sends the
+// market data along; notifications without it have the price read on the
+// next flush.
+func (m *Monitor) receive(msg *redis.Message) {
+    symbol := strings.TrimPrefix(msg.Channel, priceUpdateChannelPrefix)
+    var data models.MarketData
+    if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil || data.Close <= 0 {
+        m.markUpdated(symbol)
+        return
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, held := m.bySymbol[symbol]; held {
+        m.streamed[symbol] = data.Close
+        delete(m.dirty, symbol)
+    }
+}
+
+// markUpdated queues symbol's price to be read on the next flush if any
+// monitored portfolio holds it
+func (m *Monitor) markUpdated(symbol string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, held := m.bySymbol[symbol]; held {
+        m.dirty[symbol] = struct{}{}
+    }
+}
+
+// PositionsChanged reloads the portfolio's positions before its next
+// recalculation
+func (m *Monitor) PositionsChanged(portfolioID int64) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.stale[portfolioID] = struct{}{}
+}
+
+// Reload rebuilds the symbol index from every open position. Today's peaks
+// of portfolios already monitored are kept.
+func (m *Monitor) Reload(ctx context.Context) error {
+    query := `
+        SELECT id, portfolio_id, symbol, quantity, entry_price
+        FROM positions
+        WHERE quantity > 0
+    `
+    rows, err := m.rm.db.QueryContext(ctx, query)
+    if err != nil {
+        return fmt.Errorf("failed to load positions: %w", err)
+    }
+    defer rows.Close()
+
+    positions := make(map[int64][]models.Position)
+    for rows.Next() {
+        var pos models.Position
+        if err := rows.Scan(&pos.ID, &pos.PortfolioID, &pos.Symbol, &pos.Quantity, &pos.EntryPrice); err != nil {
+            return err
+        }
+        positions[pos.PortfolioID] = append(positions[pos.PortfolioID], pos)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for id := range m.portfolios {
+        if _, ok := positions[id]; !ok {
+            m.removeLocked(id)
+        }
+    }
+    for id, held := range positions {
+        m.setPositionsLocked(id, held)
+    }
+    return nil
+}
+
+// setPositionsLocked replaces a portfolio's positions in the index; the
+// caller holds mu
+func (m *Monitor) setPositionsLocked(portfolioID int64, positions []models.Position) {
+    p, ok := m.portfolios[portfolioID]
+    if !ok {
+        p = &monitoredPortfolio{}
+        m.portfolios[portfolioID] = p
+    }
+    for _, pos := range p.positions {
+        delete(m.bySymbol[pos.Symbol], portfolioID)
+        if len(m.bySymbol[pos.Symbol]) == 0 {
+            delete(m.bySymbol, pos.Symbol)
+        }
+    }
+
+    p.positions = positions
+    for _, pos := range positions {
+        if m.bySymbol[pos.Symbol] == nil {
+            m.bySymbol[pos.Symbol] = make(map[int64]struct{})
+        }
+        m.bySymbol[pos.Symbol][portfolioID] = struct{}{}
+    }
+}
+
+// removeLocked stops monitoring a portfolio; the caller holds mu
+func (m *Monitor) removeLocked(portfolioID int64) {
+    m.setPositionsLocked(portfolioID, nil)
+    delete(m.portfolios, portfolioID)
+    delete(m.pending, portfolioID)
+}
+
+// flush applies the prices of symbols updated since the last flush and
+// recalculates the affected portfolios that are due
+func (m *Monitor) flush(ctx context.Context) {
+    m.mu.Lock()
+    dirty := make([]string, 0, len(m.dirty))
+    for symbol := range m.dirty {
+        dirty = append(dirty, symbol)
+    }
+    m.dirty = make(map[string]struct{})
+    streamed := m.streamed
+    m.streamed = make(map[string]float64)
+    stale := m.stale
+    m.stale = make(map[int64]struct{})
+    m.mu.Unlock()
+
+    for id := range stale {
+        positions, err := m.rm.getPositions(ctx, id)
+        if err != nil {
+            logger.FromContext(ctx).Errorf("Failed to reload positions of portfolio %d: %v", id, err)
+            continue
+        }
+        m.mu.Lock()
+        if len(positions) == 0 {
+            m.removeLocked(id)
+        } else {
+            m.setPositionsLocked(id, positions)
+            m.pending[id] = struct{}{}
+        }
+        m.mu.Unlock()
+    }
+
+    prices, err := m.latestPrices(ctx, dirty)
+    if err != nil {
+        logger.FromContext(ctx).Errorf("Failed to read streamed prices: %v", err)
+    }
+    if prices == nil {
+        prices = make(map[string]float64, len(streamed))
+    }
+    for symbol, price := range streamed {
+        prices[symbol] = price
+    }
+
+    m.mu.Lock()
+    for symbol, price := range prices {
+        m.prices[symbol] = price
+        for id := range m.bySymbol[symbol] {
+            m.pending[id] = struct{}{}
+        }
+    }
+    results := m.recalculateDueLocked()
+    m.mu.Unlock()
+
+    for _, metrics := range results {
+        m.raise(ctx, metrics.PortfolioID, m.intradayAlerts(metrics))
+    }
+}
+
+// latestPrices reads the pipeline's stored prices of symbols in one round
+// trip
+func (m *Monitor) latestPrices(ctx context.Context, symbols []string) (map[string]float64, error) {
+    if len(symbols) == 0 {
+        return nil, nil
+    }
+    keys := make([]string, len(symbols))
+    for i, symbol := range symbols {
+        keys[i] = realtimeKeyPrefix + symbol
+    }
+    values, err := m.rdb.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+
+    prices := make(map[string]float64, len(symbols))
+    for i, value := range values {
+        raw, ok := value.(string)
+        if !ok {
+            continue
+        }
+        var data models.MarketData
+        if err := json.Unmarshal([]byte(raw), &data); err != nil || data.Close <= 0 {
+            continue
+        }
+        prices[symbols[i]] = data.Close
+    }
+    return prices, nil
+}
+
+// recalculateDueLocked recalculates pending portfolios whose debounce has
+// passed, up to maxRecalcsPerFlush; the caller holds mu
+func (m *Monitor) recalculateDueLocked() []IntradayMetrics {
+    now := m.now()
+    var results []IntradayMetrics
+    for id := range m.pending {
+        p, ok := m.portfolios[id]
+        if !ok {
+            delete(m.pending, id)
+            continue
+        }
+        if now.Sub(p.lastCalc) < m.debounce {
+            continue
+        }
+        if len(results) >= maxRecalcsPerFlush {
+            m.deferred++
+            continue
+        }
+        delete(m.pending, id)
+        p.metrics = m.recalculate(id, p, now)
+        results = append(results, p.metrics)
+    }
+    return results
+}
+
+// recalculate values a portfolio at the latest known prices, falling back
+// to entry prices, and tracks its peak value of the day
+func (m *Monitor) recalculate(portfolioID int64, p *monitoredPortfolio, now time.Time) IntradayMetrics {
+    var total, largest float64
+    for _, pos := range p.positions {
+        price, ok := m.prices[pos.Symbol]
+        if !ok {
+            price = models.DecimalToFloat(pos.EntryPrice)
+        }
+        value := models.DecimalToFloat(pos.Quantity) * price
+        total += value
+        if value > largest {
+            largest = value
+        }
+    }
+
+    day := now.UTC().Truncate(24 * time.Hour)
+    if !p.peakDay.Equal(day) || total > p.peak {
+        p.peak = total
+        p.peakDay = day
+    }
+    p.lastCalc = now
+
+    metrics := IntradayMetrics{PortfolioID: portfolioID, Value: total, UpdatedAt: now}
+    if total > 0 {
+        metrics.Concentration = largest / total
+    }
+    if p.peak > 0 {
+        metrics.Drawdown = (p.peak - total) / p.peak
+    }
+    return metrics
+}
+
+// intradayAlerts checks the cheap metrics against the portfolio limits.
+// VaR, expected shortfall and volatility need history, so they wait for
+// the full recalculation.
+func (m *Monitor) intradayAlerts(metrics IntradayMetrics) []Alert {
+    limits := m.rm.portfolioLimits(1)
+    limits.MaxVolatility = 0
+    limits.MaxExpectedShortfall = 0
+    return m.rm.generateAlerts(0, 0, metrics.Drawdown, metrics.Concentration, 0, 0, limits)
+}
+
+// Metrics returns the latest intraday metrics of a portfolio
+func (m *Monitor) Metrics(portfolioID int64) (IntradayMetrics, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    p, ok := m.portfolios[portfolioID]
+    if !ok || p.lastCalc.IsZero() {
+        return IntradayMetrics{}, false
+    }
+    return p.metrics, true
+}
+
+// Deferred returns how many recalculations were put off by the per-flush cap
+func (m *Monitor) Deferred() int64 {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.deferred
+}
+
+// RunFull refreshes the symbol index and records the risk of every
+// monitored portfolio. It is meant to run on a schedule.
+func (m *Monitor) RunFull(ctx context.Context) error {
+    if err := m.Reload(ctx); err != nil {
+        return err
+    }
+
+    m.mu.Lock()
+    ids := make([]int64, 0, len(m.portfolios))
+    for id := range m.portfolios {
+        ids = append(ids, id)
+    }
+    m.mu.Unlock()
+
+    failed := 0
+    for _, id := range ids {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        metrics, err := m.rm.RecordRisk(ctx, id)
+        if err != nil {
+            logger.FromContext(ctx).Errorf("Failed to analyze risk of portfolio %d: %v", id, err)
+            failed++
+            continue
+        }
+        alerts := append([]Alert(nil), metrics.Alerts...)
+        for _, classAlerts := range metrics.AlertsByAssetClass {
+            alerts = append(alerts, classAlerts...)
+        }
+        m.raise(ctx, id, alerts)
+    }
+    if failed > 0 {
+        return fmt.Errorf("risk analysis failed for %d of %d portfolios", failed, len(ids))
+    }
+    return nil
+}
+
+// raise delivers the alerts not already raised for the portfolio within
+// the dedup window, whichever path raised them
+func (m *Monitor) raise(ctx context.Context, portfolioID int64, alerts []Alert) {
+    if m.sink == nil || len(alerts) == 0 {
+        return
+    }
+
+    now := m.now()
+    var fresh []Alert
+    m.alertMu.Lock()
+    for key, at := range m.lastAlerted {
+        if now.Sub(at) >= m.dedupWindow {
+            delete(m.lastAlerted, key)
+        }
+    }
+    for _, alert := range alerts {
+        key := alertKey{portfolioID: portfolioID, alertType: alert.Type, symbol: alert.Symbol}
+        if _, seen := m.lastAlerted[key]; seen {
+            continue
+        }
+        m.lastAlerted[key] = now
+        fresh = append(fresh, alert)
+    }
+    m.alertMu.Unlock()
+
+    if len(fresh) == 0 {
+        return
+    }
+    if err := m.sink.Notify(ctx, portfolioID, fresh); err != nil {
+        logger.FromContext(ctx).Errorf("Failed to notify risk alerts of portfolio %d: %v", portfolioID, err)
+    }
+}
+
+// LogAlertSink writes alerts to the context logger
+type LogAlertSink struct{}
+
+func (LogAlertSink) Notify(ctx context.Context, portfolioID int64, alerts []Alert) error {
+    for _, alert := range alerts {
+        if alert.Symbol != "" {
+            logger.FromContext(ctx).Warnf("Risk alert for portfolio %d (%s): [%s] %s", portfolioID, alert.Symbol, alert.Severity, alert.Message)
+            continue
+        }
+        logger.FromContext(ctx).Warnf("Risk alert for portfolio %d: [%s] %s", portfolioID, alert.Severity, alert.Message)
+    }
+    return nil
+}
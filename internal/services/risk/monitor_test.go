@@ -0,0 +1,205 @@
+package risk
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/alicebob/miniredis/v2"
+    "github.com/go-redis/redis/v8"
+    "github.com/stretchr/testify/assert"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+type recordingSink struct {
+    alerts map[int64][]Alert
+}
+
+func (s *recordingSink) Notify(ctx context.Context, portfolioID int64, alerts []Alert) error {
+    s.alerts[portfolioID] = append(s.alerts[portfolioID], alerts...)
+    return nil
+}
+
+type monitorFixture struct {
+    monitor *Monitor
+    mock    sqlmock.Sqlmock
+    mr      *miniredis.Miniredis
+    sink    *recordingSink
+    now     time.Time
+}
+
+func newMonitorFixture(t *testing.T) *monitorFixture {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    mr := miniredis.RunT(t)
+    f := &monitorFixture{
+        mock: mock,
+        mr:   mr,
+        sink: &recordingSink{alerts: make(map[int64][]Alert)},
+        now:  time.Date(2024, 3, 4, 14, 0, 0, 0, time.UTC),
+    }
+    f.monitor = NewMonitor(NewRiskManager(db), redis.NewClient(&redis.Options{Addr: mr.Addr()}), f.sink).
+        WithDebounce(30 * time.Second)
+    f.monitor.now = func() time.Time { return f.now }
+    return f
+}
+
+// tick delivers a price update the way the pipeline publishes it, with the
+// market data inline
+func (f *monitorFixture) tick(t *testing.T, symbol string, price float64) {
+    data, err := json.Marshal(models.MarketData{Symbol: symbol, Close: price})
+    assert.NoError(t, err)
+    f.monitor.receive(&redis.Message{Channel: priceUpdateChannelPrefix + symbol, Payload: string(data)})
+}
+
+func TestMonitor_IntradayRecalculation(t *testing.T) {
+    f := newMonitorFixture(t)
+    ctx := context.Background()
+
+    f.mock.ExpectQuery("FROM positions WHERE quantity > 0").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(1, 1, "AAPL", 100.0, 100.0).
+            AddRow(2, 1, "MSFT", 100.0, 100.0).
+            AddRow(3, 1, "GOOGL", 100.0, 100.0).
+            AddRow(4, 1, "AMZN", 100.0, 100.0).
+            AddRow(5, 2, "MSFT", 10.0, 100.0))
+    assert.NoError(t, f.monitor.Reload(ctx))
+
+    // Only portfolio 1 holds AAPL
+    f.tick(t, "AAPL", 110)
+    f.monitor.flush(ctx)
+
+    metrics, ok := f.monitor.Metrics(1)
+    if !assert.True(t, ok) {
+        return
+    }
+    assert.InDelta(t, 41000, metrics.Value, 1e-9)
+    assert.InDelta(t, 11000.0/41000, metrics.Concentration, 1e-9)
+    assert.InDelta(t, 0, metrics.Drawdown, 1e-9)
+    _, ok = f.monitor.Metrics(2)
+    assert.False(t, ok)
+
+    // A crash within the debounce window waits for it to pass
+    f.now = f.now.Add(10 * time.Second)
+    f.tick(t, "AAPL", 50)
+    f.tick(t, "MSFT", 60)
+    f.monitor.flush(ctx)
+    metrics, _ = f.monitor.Metrics(1)
+    assert.InDelta(t, 41000, metrics.Value, 1e-9)
+    assert.Empty(t, f.sink.alerts[1])
+
+    // Portfolio 2 hadn't been recalculated yet, so nothing holds it back
+    metrics, ok = f.monitor.Metrics(2)
+    assert.True(t, ok)
+    assert.InDelta(t, 600, metrics.Value, 1e-9)
+
+    // Once due, the pending recalculation runs without another tick
+    f.now = f.now.Add(30 * time.Second)
+    f.monitor.flush(ctx)
+    metrics, _ = f.monitor.Metrics(1)
+    assert.InDelta(t, 31000, metrics.Value, 1e-9)
+    assert.InDelta(t, 10000.0/41000, metrics.Drawdown, 1e-9)
+    assert.Len(t, alertsOfType(f.sink.alerts[1], "DRAWDOWN_EXCEEDED"), 1)
+    assert.Len(t, alertsOfType(f.sink.alerts[1], "CONCENTRATION_EXCEEDED"), 1)
+
+    // The same alerts aren't raised again within the dedup window
+    f.now = f.now.Add(time.Minute)
+    f.tick(t, "AAPL", 49)
+    f.monitor.flush(ctx)
+    assert.Len(t, f.sink.alerts[1], 2)
+
+    // The peak resets each day
+    f.now = f.now.Add(24 * time.Hour)
+    f.tick(t, "AAPL", 50)
+    f.monitor.flush(ctx)
+    metrics, _ = f.monitor.Metrics(1)
+    assert.InDelta(t, 0, metrics.Drawdown, 1e-9)
+
+    assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestMonitor_ShedsLoad(t *testing.T) {
+    f := newMonitorFixture(t)
+    ctx := context.Background()
+
+    portfolios := maxRecalcsPerFlush + 20
+    rows := sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"})
+    for i := 1; i <= portfolios; i++ {
+        rows.AddRow(i, i, "BTC", 1.0, 60000.0)
+    }
+    f.mock.ExpectQuery("FROM positions WHERE quantity > 0").WillReturnRows(rows)
+    assert.NoError(t, f.monitor.Reload(ctx))
+
+    // Repeated ticks of a symbol coalesce into one pending update
+    for i := 0; i < 1000; i++ {
+        f.tick(t, "BTC", float64(60001+i))
+    }
+    f.tick(t, "BTC", 61000)
+    assert.Len(t, f.monitor.streamed, 1)
+
+    f.monitor.flush(ctx)
+    assert.Len(t, f.monitor.pending, 20)
+    assert.Equal(t, int64(20), f.monitor.Deferred())
+
+    f.monitor.flush(ctx)
+    assert.Empty(t, f.monitor.pending)
+    for i := 1; i <= portfolios; i++ {
+        metrics, ok := f.monitor.Metrics(int64(i))
+        assert.True(t, ok)
+        assert.InDelta(t, 61000, metrics.Value, 1e-9)
+    }
+}
+
+func TestMonitor_NotificationWithoutData(t *testing.T) {
+    f := newMonitorFixture(t)
+    ctx := context.Background()
+
+    f.mock.ExpectQuery("FROM positions WHERE quantity > 0").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(1, 1, "AAPL", 10.0, 100.0))
+    assert.NoError(t, f.monitor.Reload(ctx))
+
+    // A bare notification has the stored price read instead
+    data, _ := json.Marshal(models.MarketData{Symbol: "AAPL", Close: 120})
+    assert.NoError(t, f.mr.Set(realtimeKeyPrefix+"AAPL", string(data)))
+    f.monitor.receive(&redis.Message{Channel: priceUpdateChannelPrefix + "AAPL", Payload: "updated"})
+    assert.Contains(t, f.monitor.dirty, "AAPL")
+    f.monitor.flush(ctx)
+
+    metrics, ok := f.monitor.Metrics(1)
+    assert.True(t, ok)
+    assert.InDelta(t, 1200, metrics.Value, 1e-9)
+    assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestMonitor_PositionsChanged(t *testing.T) {
+    f := newMonitorFixture(t)
+    ctx := context.Background()
+
+    f.mock.ExpectQuery("FROM positions WHERE quantity > 0").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(1, 1, "AAPL", 10.0, 100.0))
+    assert.NoError(t, f.monitor.Reload(ctx))
+
+    // Portfolio 1 sold AAPL and bought ETH
+    f.monitor.PositionsChanged(1)
+    f.mock.ExpectQuery("SELECT (.+) FROM positions WHERE portfolio_id = ?").
+        WithArgs(int64(1)).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "portfolio_id", "symbol", "quantity", "entry_price"}).
+            AddRow(2, 1, "ETH", 2.0, 3000.0))
+    f.monitor.flush(ctx)
+
+    assert.NotContains(t, f.monitor.bySymbol, "AAPL")
+    assert.Contains(t, f.monitor.bySymbol["ETH"], int64(1))
+    metrics, _ := f.monitor.Metrics(1)
+    assert.InDelta(t, 6000, metrics.Value, 1e-9)
+
+    assert.NoError(t, f.mock.ExpectationsWereMet())
+}
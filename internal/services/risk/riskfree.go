@@ -0,0 +1,141 @@
+package risk
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+)
+
+const (
+    // DefaultRiskFreeRate is the annual risk-free rate used unless
+    // configured otherwise
+    DefaultRiskFreeRate = 0.02
+
+    // TradingDaysPerYear is how many daily returns make up a year of
+    // stock prices
+    TradingDaysPerYear = 252
+
+    // defaultMaxYieldAge is how stale the latest yield may be before the
+    // fallback rate is used instead. It spans long weekends and holidays,
+    // on which no yield is published.
+    defaultMaxYieldAge = 7 * 24 * time.Hour
+    // yieldCacheTTL is how long a yield read from market_data is reused
+    yieldCacheTTL = time.Hour
+)
+
+// RiskFreeRateProvider supplies the risk-free rate Sharpe ratios, Sortino
+// ratios and CAPM returns are measured against
+type RiskFreeRateProvider interface {
+    // AnnualRiskFreeRate returns the annual risk-free rate as a fraction,
+    // such as 0.02 for 2%
+    AnnualRiskFreeRate(ctx context.Context) (float64, error)
+}
+
+// PeriodRate converts an annual rate to the rate per period of a year of
+// periodsPerYear periods, such as TradingDaysPerYear trading days. Rates
+// are divided rather than compounded, as mean returns are annualized.
+func PeriodRate(annual, periodsPerYear float64) float64 {
+    return annual / periodsPerYear
+}
+
+// AnnualRate is the inverse of PeriodRate
+func AnnualRate(periodic, periodsPerYear float64) float64 {
+    return periodic * periodsPerYear
+}
+
+// DailyRate converts an annual rate to the rate per trading day
+func DailyRate(annual float64) float64 {
+    return PeriodRate(annual, TradingDaysPerYear)
+}
+
+// StaticRiskFreeRate is a fixed annual risk-free rate, as configured
+type StaticRiskFreeRate float64
+
+// AnnualRiskFreeRate implements RiskFreeRateProvider
+func (r StaticRiskFreeRate) AnnualRiskFreeRate(ctx context.Context) (float64, error) {
+    return float64(r), nil
+}
+
+// MarketRiskFreeRate reads the risk-free rate from the latest close of a
+// yield symbol in market_data, such as the 3-month T-bill yield the market
+// data pipeline collects. Yields are quoted in percent. Without a recent
+// yield the fallback rate is used.
+type MarketRiskFreeRate struct {
+    db       *sql.DB
+    symbol   string
+    fallback RiskFreeRateProvider
+    maxAge   time.Duration
+    now      func() time.Time
+
+    mu       sync.Mutex
+    rate     float64
+    cachedAt time.Time
+}
+
+// NewMarketRiskFreeRate reads the yield of symbol, falling back to
+// DefaultRiskFreeRate
+func NewMarketRiskFreeRate(db *sql.DB, symbol string) *MarketRiskFreeRate {
+    return &MarketRiskFreeRate{
+        db:       db,
+        symbol:   symbol,
+        fallback: StaticRiskFreeRate(DefaultRiskFreeRate),
+        maxAge:   defaultMaxYieldAge,
+        now:      time.Now,
+    }
+}
+
+// WithFallback uses fallback while no recent yield is stored
+func (r *MarketRiskFreeRate) WithFallback(fallback RiskFreeRateProvider) *MarketRiskFreeRate {
+    r.fallback = fallback
+    return r
+}
+
+// AnnualRiskFreeRate implements RiskFreeRateProvider. Failing to read the
+// yield is logged and the fallback rate returned, so analytics don't fail
+// for want of a yield.
+func (r *MarketRiskFreeRate) AnnualRiskFreeRate(ctx context.Context) (float64, error) {
+    r.mu.Lock()
+    if !r.cachedAt.IsZero() && r.now().Sub(r.cachedAt) < yieldCacheTTL {
+        rate := r.rate
+        r.mu.Unlock()
+        return rate, nil
+    }
+    r.mu.Unlock()
+
+    rate, err := r.latestYield(ctx)
+    if err != nil {
+        logger.FromContext(ctx).Warnf("Using fallback risk-free rate: %v", err)
+        return r.fallback.AnnualRiskFreeRate(ctx)
+    }
+
+    r.mu.Lock()
+    r.rate, r.cachedAt = rate, r.now()
+    r.mu.Unlock()
+    return rate, nil
+}
+
+func (r *MarketRiskFreeRate) latestYield(ctx context.Context) (float64, error) {
+    var yield float64
+    var at time.Time
+    query := `
+        SELECT close, timestamp FROM market_data
+        WHERE symbol = $1
+        ORDER BY timestamp DESC
+        LIMIT 1
+    `
+    err := r.db.QueryRowContext(ctx, query, r.symbol).Scan(&yield, &at)
+    if err == sql.ErrNoRows {
+        return 0, fmt.Errorf("no yield stored for %s", r.symbol)
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to read yield of %s: %w", r.symbol, err)
+    }
+    if age := r.now().Sub(at); age > r.maxAge {
+        return 0, fmt.Errorf("latest yield of %s is %s old", r.symbol, age.Round(time.Hour))
+    }
+    return yield / 100, nil
+}
@@ -0,0 +1,101 @@
+package risk
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestRiskFreeRateConversions(t *testing.T) {
+    assert.InDelta(t, 0.02/252, DailyRate(0.02), 1e-15)
+    assert.InDelta(t, 0.0000793650793650, DailyRate(0.02), 1e-15)
+    assert.InDelta(t, 0.05/365, PeriodRate(0.05, 365), 1e-15)
+
+    // Conversions round trip whatever the period
+    for _, periods := range []float64{TradingDaysPerYear, 365, 52, 12} {
+        assert.InDelta(t, 0.0375, AnnualRate(PeriodRate(0.0375, periods), periods), 1e-15)
+    }
+}
+
+func TestMarketRiskFreeRate(t *testing.T) {
+    now := time.Date(2024, time.March, 12, 15, 0, 0, 0, time.UTC)
+    const query = "SELECT close, timestamp FROM market_data WHERE symbol = (.+) ORDER BY timestamp DESC LIMIT 1"
+
+    tests := []struct {
+        name   string
+        expect func(mock sqlmock.Sqlmock)
+        want   float64
+    }{
+        {
+            name: "Latest yield in percent",
+            expect: func(mock sqlmock.Sqlmock) {
+                mock.ExpectQuery(query).
+                    WithArgs("^IRX").
+                    WillReturnRows(sqlmock.NewRows([]string{"close", "timestamp"}).AddRow(5.25, now.Add(-3*24*time.Hour)))
+            },
+            want: 0.0525,
+        },
+        {
+            name: "Stale yield",
+            expect: func(mock sqlmock.Sqlmock) {
+                mock.ExpectQuery(query).
+                    WithArgs("^IRX").
+                    WillReturnRows(sqlmock.NewRows([]string{"close", "timestamp"}).AddRow(5.25, now.Add(-8*24*time.Hour)))
+            },
+            want: 0.03,
+        },
+        {
+            name: "No yield",
+            expect: func(mock sqlmock.Sqlmock) {
+                mock.ExpectQuery(query).
+                    WithArgs("^IRX").
+                    WillReturnRows(sqlmock.NewRows([]string{"close", "timestamp"}))
+            },
+            want: 0.03,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db, mock, err := sqlmock.New()
+            if err != nil {
+                t.Fatalf("Failed to create mock DB: %v", err)
+            }
+            defer db.Close()
+
+            tt.expect(mock)
+            provider := NewMarketRiskFreeRate(db, "^IRX").WithFallback(StaticRiskFreeRate(0.03))
+            provider.now = func() time.Time { return now }
+
+            rate, err := provider.AnnualRiskFreeRate(context.Background())
+            require.NoError(t, err)
+            assert.InDelta(t, tt.want, rate, 1e-12)
+            assert.NoError(t, mock.ExpectationsWereMet())
+        })
+    }
+
+    t.Run("Cached for an hour", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        if err != nil {
+            t.Fatalf("Failed to create mock DB: %v", err)
+        }
+        defer db.Close()
+
+        mock.ExpectQuery(query).
+            WithArgs("^IRX").
+            WillReturnRows(sqlmock.NewRows([]string{"close", "timestamp"}).AddRow(4.8, now))
+        provider := NewMarketRiskFreeRate(db, "^IRX")
+        provider.now = func() time.Time { return now }
+
+        for i := 0; i < 3; i++ {
+            rate, err := provider.AnnualRiskFreeRate(context.Background())
+            require.NoError(t, err)
+            assert.InDelta(t, 0.048, rate, 1e-12)
+        }
+        assert.NoError(t, mock.ExpectationsWereMet())
+    })
+}
@@ -0,0 +1,346 @@
+package risk
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "math/rand/v2"
+    "sort"
+
+    "gonum.org/v1/gonum/mat"
+    "gonum.org/v1/gonum/stat"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+const (
+    // MaxStressSimulations caps the paths run by MonteCarloStressTest
+    MaxStressSimulations = 100000
+    histogramBuckets     = 20
+    // covarianceJitter is added to the diagonal when the sampled covariance
+    // is not positive definite, e.g. for perfectly correlated assets
+    covarianceJitter = 1e-10
+    // maxSeed keeps generated seeds exactly representable as JSON numbers,
+    // so clients can send back the seed of a run they want to repeat
+    maxSeed = 1 << 53
+)
+
+var (
+    ErrUnknownScenario = errors.New("unknown stress scenario")
+    ErrNoPositions     = errors.New("portfolio has no positions")
+)
+
+// StressScenario is a market shock, expressed as the return each asset is
+// expected to see over HorizonDays trading days
+type StressScenario struct {
+    Name          string             `json:"name"`
+    Description   string             `json:"description"`
+    HorizonDays   int                `json:"horizon_days"`
+    ShocksByAsset map[string]float64 `json:"shocks_by_asset"`
+    // DefaultShock applies to assets without their own shock
+    DefaultShock float64 `json:"default_shock"`
+}
+
+// StressScenarios are the predefined scenarios, keyed by name
+var StressScenarios = map[string]StressScenario{
+    "2008_crisis": {
+        Name:          "2008_crisis",
+        Description:   "Global financial crisis, September to November 2008",
+        HorizonDays:   60,
+        ShocksByAsset: map[string]float64{"SPY": -0.38, "QQQ": -0.41, "GLD": 0.05, "TLT": 0.12},
+        DefaultShock:  -0.40,
+    },
+    "covid_2020": {
+        Name:          "covid_2020",
+        Description:   "COVID-19 crash, February to March 2020",
+        HorizonDays:   23,
+        ShocksByAsset: map[string]float64{"SPY": -0.34, "QQQ": -0.28, "GLD": -0.03, "TLT": 0.10, "BTC": -0.50, "ETH": -0.60},
+        DefaultShock:  -0.33,
+    },
+    "crypto_winter_2022": {
+        Name:          "crypto_winter_2022",
+        Description:   "Crypto drawdown, April to June 2022",
+        HorizonDays:   60,
+        ShocksByAsset: map[string]float64{"SPY": -0.20, "QQQ": -0.28, "BTC": -0.58, "ETH": -0.70},
+        DefaultShock:  -0.25,
+    },
+}
+
+// LookupScenario returns the predefined scenario called name
+func LookupScenario(name string) (StressScenario, error) {
+    scenario, ok := StressScenarios[name]
+    if !ok {
+        return StressScenario{}, fmt.Errorf("%w: %q", ErrUnknownScenario, name)
+    }
+    return scenario, nil
+}
+
+// Shock returns the scenario's shock for symbol
+func (s StressScenario) Shock(symbol string) float64 {
+    if shock, ok := s.ShocksByAsset[symbol]; ok {
+        return shock
+    }
+    return s.DefaultShock
+}
+
+type HistogramBucket struct {
+    Lower float64 `json:"lower"`
+    Upper float64 `json:"upper"`
+    Count int     `json:"count"`
+}
+
+// StressDistribution summarises simulated portfolio profit and loss in the
+// portfolio's currency. Losses are negative, so P5 is the bad tail. Seed
+// repeats the run when passed back to MonteCarloStressTest.
+type StressDistribution struct {
+    Scenario         string            `json:"scenario"`
+    Simulations      int               `json:"simulations"`
+    PortfolioValue   float64           `json:"portfolio_value"`
+    Mean             float64           `json:"mean"`
+    Std              float64           `json:"std"`
+    P5               float64           `json:"p5"`
+    P25              float64           `json:"p25"`
+    P75              float64           `json:"p75"`
+    P95              float64           `json:"p95"`
+    Seed             int64             `json:"seed"`
+    HistogramBuckets []HistogramBucket `json:"histogram_buckets"`
+}
+
+// MonteCarloStressTest simulates the scenario with correlated noise: each
+// path samples asset returns from a multivariate normal centred on the
+// scenario's shocks, with the covariance of the last year of daily returns
+// scaled to the scenario horizon. simulations is capped at
+// MaxStressSimulations. Paths are drawn from seed, so the same seed over the
+// same positions and returns gives the same distribution; a nil seed picks a
+// new one, returned in the distribution.
+func (rm *RiskManager) MonteCarloStressTest(ctx context.Context, portfolioID int64, scenario StressScenario, simulations int, seed *int64) (*StressDistribution, error) {
+    if simulations < 1 {
+        return nil, fmt.Errorf("need at least 1 simulation, got %d", simulations)
+    }
+    if simulations > MaxStressSimulations {
+        simulations = MaxStressSimulations
+    }
+
+    positions, err := rm.getPositions(ctx, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    if len(positions) == 0 {
+        return nil, ErrNoPositions
+    }
+
+    symbols := make([]string, len(positions))
+    values := make([]float64, len(positions))
+    shocks := make([]float64, len(positions))
+    for i, p := range positions {
+        symbols[i] = p.Symbol
+        values[i] = models.DecimalToFloat(p.CostBasis())
+        shocks[i] = scenario.Shock(p.Symbol)
+    }
+
+    returns, err := rm.getDailyReturns(ctx, symbols)
+    if err != nil {
+        return nil, err
+    }
+    covMatrix, err := calculateCovarianceMatrix(returns)
+    if err != nil {
+        return nil, err
+    }
+    horizon := scenario.HorizonDays
+    if horizon < 1 {
+        horizon = 1
+    }
+    covMatrix.ScaleSym(float64(horizon), covMatrix)
+
+    runSeed := NewSeed()
+    if seed != nil {
+        runSeed = *seed
+    }
+    pnl, err := simulateStress(values, shocks, covMatrix, simulations, seededRand(runSeed))
+    if err != nil {
+        return nil, err
+    }
+
+    dist := summarizeStress(pnl)
+    dist.Scenario = scenario.Name
+    dist.Seed = runSeed
+    for _, v := range values {
+        dist.PortfolioValue += v
+    }
+    return dist, nil
+}
+
+// NewSeed returns a seed for a simulation run that wasn't given one
+func NewSeed() int64 {
+    return rand.Int64N(maxSeed)
+}
+
+// seededRand is the source of a run's paths. Every run gets its own, so
+// concurrent runs never share state and a seed always replays the same
+// draws.
+func seededRand(seed int64) *rand.Rand {
+    return rand.New(rand.NewPCG(uint64(seed), 0))
+}
+
+// getDailyReturns returns the last year of daily returns for each symbol,
+// in the order given
+func (rm *RiskManager) getDailyReturns(ctx context.Context, symbols []string) ([][]float64, error) {
+    query := `
+        WITH daily_returns AS (
+            SELECT
+                symbol,
+                timestamp,
+                (close - LAG(close) OVER (PARTITION BY symbol ORDER BY timestamp)) / LAG(close) OVER (PARTITION BY symbol ORDER BY timestamp) as return
+            FROM market_data
+            WHERE symbol = ANY($1)
+            AND timestamp >= NOW() - INTERVAL '1 year'
+            AND close > 0
+        )
+        SELECT symbol, ARRAY_AGG(return ORDER BY timestamp) as returns
+        FROM daily_returns
+        WHERE return IS NOT NULL
+        GROUP BY symbol
+    `
+
+    rows, err := rm.db.QueryContext(ctx, query, symbols)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    bySymbol := make(map[string][]float64)
+    for rows.Next() {
+        var symbol string
+        var symbolReturns []float64
+        if err := rows.Scan(&symbol, &symbolReturns); err != nil {
+            return nil, err
+        }
+        bySymbol[symbol], _ = FiniteReturns(symbolReturns)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    returns := make([][]float64, len(symbols))
+    for i, s := range symbols {
+        returns[i] = bySymbol[s]
+    }
+    return returns, nil
+}
+
+// calculateCovarianceMatrix is the sample covariance of the assets' daily
+// returns, using their common most recent observations
+func calculateCovarianceMatrix(returns [][]float64) (*mat.SymDense, error) {
+    periods := -1
+    for _, r := range returns {
+        if periods < 0 || len(r) < periods {
+            periods = len(r)
+        }
+    }
+    if periods < 2 {
+        return nil, fmt.Errorf("need at least 2 daily returns per asset, got %d", periods)
+    }
+
+    data := mat.NewDense(periods, len(returns), nil)
+    for j, r := range returns {
+        recent := r[len(r)-periods:]
+        for i, v := range recent {
+            data.Set(i, j, v)
+        }
+    }
+
+    cov := mat.NewSymDense(len(returns), nil)
+    stat.CovarianceMatrix(cov, data, nil)
+    return cov, nil
+}
+
+// simulateStress returns the portfolio profit and loss of n paths whose
+// asset returns are drawn from N(shocks, cov)
+func simulateStress(values, shocks []float64, cov *mat.SymDense, n int, rng *rand.Rand) ([]float64, error) {
+    k := len(values)
+
+    var chol mat.Cholesky
+    if !chol.Factorize(cov) {
+        jittered := mat.NewSymDense(k, nil)
+        jittered.CopySym(cov)
+        for i := 0; i < k; i++ {
+            jittered.SetSym(i, i, jittered.At(i, i)+covarianceJitter)
+        }
+        if !chol.Factorize(jittered) {
+            return nil, fmt.Errorf("covariance matrix is not positive definite")
+        }
+    }
+    var lower mat.TriDense
+    chol.LTo(&lower)
+
+    pnl := make([]float64, n)
+    z := make([]float64, k)
+    for s := 0; s < n; s++ {
+        for i := range z {
+            z[i] = rng.NormFloat64()
+        }
+        var total float64
+        for i := 0; i < k; i++ {
+            // Row i of L times z gives asset i's correlated noise
+            r := shocks[i]
+            for j := 0; j <= i; j++ {
+                r += lower.At(i, j) * z[j]
+            }
+            // An asset can't lose more than its full value
+            total += values[i] * math.Max(r, -1)
+        }
+        pnl[s] = total
+    }
+    return pnl, nil
+}
+
+func summarizeStress(pnl []float64) *StressDistribution {
+    sorted := make([]float64, len(pnl))
+    copy(sorted, pnl)
+    sort.Float64s(sorted)
+
+    mean, std := stat.MeanStdDev(sorted, nil)
+    if len(sorted) < 2 {
+        std = 0
+    }
+
+    return &StressDistribution{
+        Simulations:      len(sorted),
+        Mean:             mean,
+        Std:              std,
+        P5:               stat.Quantile(0.05, stat.Empirical, sorted, nil),
+        P25:              stat.Quantile(0.25, stat.Empirical, sorted, nil),
+        P75:              stat.Quantile(0.75, stat.Empirical, sorted, nil),
+        P95:              stat.Quantile(0.95, stat.Empirical, sorted, nil),
+        HistogramBuckets: histogram(sorted, histogramBuckets),
+    }
+}
+
+// histogram splits sorted into n equal-width buckets spanning its range
+func histogram(sorted []float64, n int) []HistogramBucket {
+    if len(sorted) == 0 {
+        return nil
+    }
+
+    min, max := sorted[0], sorted[len(sorted)-1]
+    width := (max - min) / float64(n)
+    buckets := make([]HistogramBucket, n)
+    for i := range buckets {
+        buckets[i].Lower = min + float64(i)*width
+        buckets[i].Upper = min + float64(i+1)*width
+    }
+    buckets[n-1].Upper = max
+
+    for _, v := range sorted {
+        i := n - 1
+        if width > 0 {
+            i = int((v - min) / width)
+            if i >= n {
+                i = n - 1
+            }
+        }
+        buckets[i].Count++
+    }
+    return buckets
+}
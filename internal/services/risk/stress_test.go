@@ -0,0 +1,111 @@
+package risk
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "gonum.org/v1/gonum/mat"
+)
+
+func TestRiskManager_MonteCarloStressTest(t *testing.T) {
+    scenario, err := LookupScenario("2008_crisis")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("Distribution of correlated shocks", func(t *testing.T) {
+        values := []float64{60000, 40000}
+        shocks := []float64{scenario.Shock("SPY"), scenario.Shock("TLT")}
+        cov := mat.NewSymDense(2, []float64{
+            0.04, -0.01,
+            -0.01, 0.02,
+        })
+
+        pnl, err := simulateStress(values, shocks, cov, 20000, seededRand(1))
+        if !assert.NoError(t, err) {
+            return
+        }
+        dist := summarizeStress(pnl)
+
+        assert.Less(t, dist.P5, dist.P25)
+        assert.Less(t, dist.P25, dist.P75)
+        assert.Less(t, dist.P75, dist.P95)
+        // Centred on the deterministic shock: 60000 * -0.38 + 40000 * 0.12
+        assert.InDelta(t, -18000, dist.Mean, 500)
+        assert.Greater(t, dist.Std, 0.0)
+
+        assert.Len(t, dist.HistogramBuckets, 20)
+        total := 0
+        for _, b := range dist.HistogramBuckets {
+            total += b.Count
+        }
+        assert.Equal(t, 20000, total)
+    })
+
+    t.Run("Perfectly correlated assets", func(t *testing.T) {
+        spy := []float64{0.01, -0.02, 0.015, -0.005, 0.02, -0.01}
+        qqq := make([]float64, len(spy))
+        for i, r := range spy {
+            qqq[i] = 2 * r
+        }
+        cov, err := calculateCovarianceMatrix([][]float64{spy, qqq})
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.InDelta(t, 2*cov.At(0, 0), cov.At(0, 1), 1e-12)
+
+        // Singular covariance still simulates, via the diagonal jitter
+        shocks := []float64{scenario.Shock("SPY"), scenario.Shock("QQQ")}
+        pnl, err := simulateStress([]float64{40000, 15000}, shocks, cov, 5000, seededRand(2))
+        if !assert.NoError(t, err) {
+            return
+        }
+        dist := summarizeStress(pnl)
+        assert.Equal(t, 5000, dist.Simulations)
+        assert.Less(t, dist.P5, dist.P95)
+    })
+
+    t.Run("Seeds reproduce runs", func(t *testing.T) {
+        values := []float64{60000, 40000}
+        shocks := []float64{scenario.Shock("SPY"), scenario.Shock("TLT")}
+        cov := mat.NewSymDense(2, []float64{
+            0.04, -0.01,
+            -0.01, 0.02,
+        })
+        run := func(seed int64) *StressDistribution {
+            pnl, err := simulateStress(values, shocks, cov, 2000, seededRand(seed))
+            if err != nil {
+                t.Fatal(err)
+            }
+            return summarizeStress(pnl)
+        }
+
+        assert.Equal(t, run(42), run(42))
+        other := run(43)
+        assert.NotEqual(t, run(42).Mean, other.Mean)
+        assert.NotEqual(t, run(42).P5, other.P5)
+
+        seed := NewSeed()
+        assert.GreaterOrEqual(t, seed, int64(0))
+        assert.Less(t, seed, int64(maxSeed))
+    })
+
+    t.Run("Covariance uses the common recent window", func(t *testing.T) {
+        cov, err := calculateCovarianceMatrix([][]float64{
+            {0.5, 0.01, -0.01, 0.02},
+            {0.01, -0.01, 0.02},
+        })
+        if !assert.NoError(t, err) {
+            return
+        }
+        assert.InDelta(t, cov.At(0, 0), cov.At(1, 1), 1e-12)
+
+        _, err = calculateCovarianceMatrix([][]float64{{0.01}, {0.01, 0.02}})
+        assert.Error(t, err)
+    })
+
+    t.Run("Unknown scenario", func(t *testing.T) {
+        _, err := LookupScenario("alien_invasion")
+        assert.ErrorIs(t, err, ErrUnknownScenario)
+    })
+}
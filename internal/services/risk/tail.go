@@ -0,0 +1,80 @@
+package risk
+
+import (
+    "math"
+    "sort"
+)
+
+// VaRMethod selects how VaR and expected shortfall are estimated
+type VaRMethod string
+
+const (
+    // VaRHistorical uses the empirical distribution of past returns
+    VaRHistorical VaRMethod = "historical"
+    // VaRParametric assumes normally distributed returns
+    VaRParametric VaRMethod = "parametric"
+)
+
+// TailReturns are the VaR cutoff and expected shortfall of a return
+// distribution, as returns: losses are negative
+type TailReturns struct {
+    VaR               float64
+    ExpectedShortfall float64
+}
+
+// FiniteReturns returns returns without its NaN and infinite values, which
+// a zero or missing price leaves behind, and how many were dropped. One
+// such value would otherwise carry into every statistic of the series.
+func FiniteReturns(returns []float64) ([]float64, int) {
+    finite := returns[:0:0]
+    for _, r := range returns {
+        if math.IsNaN(r) || math.IsInf(r, 0) {
+            continue
+        }
+        finite = append(finite, r)
+    }
+    return finite, len(returns) - len(finite)
+}
+
+// HistoricalTail returns the (1-confidence) quantile of returns and the mean
+// of the returns at or below it
+func HistoricalTail(returns []float64, confidence float64) TailReturns {
+    if len(returns) == 0 {
+        return TailReturns{}
+    }
+
+    sorted := append([]float64(nil), returns...)
+    sort.Float64s(sorted)
+
+    cutoff := int(float64(len(sorted)) * (1 - confidence))
+    if cutoff >= len(sorted) {
+        cutoff = len(sorted) - 1
+    }
+
+    var sum float64
+    for _, r := range sorted[:cutoff+1] {
+        sum += r
+    }
+    // The mean of returns at or below VaR can only exceed it by rounding
+    return TailReturns{
+        VaR:               sorted[cutoff],
+        ExpectedShortfall: math.Min(sum/float64(cutoff+1), sorted[cutoff]),
+    }
+}
+
+// ParametricTail returns VaR and expected shortfall for normally
+// distributed returns: VaR = mean + z*stdDev and
+// ES = mean - stdDev*phi(z)/(1-confidence), with z the (1-confidence)
+// standard normal quantile
+func ParametricTail(mean, stdDev, confidence float64) TailReturns {
+    z := standardNormalQuantile(1 - confidence)
+    density := math.Exp(-z*z/2) / math.Sqrt(2*math.Pi)
+    return TailReturns{
+        VaR:               mean + z*stdDev,
+        ExpectedShortfall: mean - stdDev*density/(1-confidence),
+    }
+}
+
+func standardNormalQuantile(p float64) float64 {
+    return math.Sqrt2 * math.Erfinv(2*p-1)
+}
@@ -0,0 +1,100 @@
+package risk
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestHistoricalTail(t *testing.T) {
+    rng := rand.New(rand.NewSource(42))
+    sample := func(n int, draw func() float64) []float64 {
+        returns := make([]float64, n)
+        for i := range returns {
+            returns[i] = draw()
+        }
+        return returns
+    }
+
+    distributions := map[string][]float64{
+        "Normal": sample(1000, func() float64 { return 0.0005 + 0.02*rng.NormFloat64() }),
+        "Uniform": sample(1000, func() float64 { return -0.05 + 0.1*rng.Float64() }),
+        // Mostly calm with occasional crashes
+        "Fat tailed": sample(1000, func() float64 {
+            if rng.Float64() < 0.03 {
+                return -0.15 + 0.05*rng.NormFloat64()
+            }
+            return 0.01 * rng.NormFloat64()
+        }),
+        // Small frequent gains and rare large losses
+        "Skewed": sample(1000, func() float64 { return 0.01 - rng.ExpFloat64()*0.01 }),
+        "Constant": sample(100, func() float64 { return -0.01 }),
+        "Single": {-0.03},
+    }
+
+    for name, returns := range distributions {
+        t.Run(name, func(t *testing.T) {
+            for _, confidence := range []float64{0.90, 0.95, 0.99} {
+                tail := HistoricalTail(returns, confidence)
+                // As returns, a larger loss is more negative
+                assert.LessOrEqual(t, tail.ExpectedShortfall, tail.VaR, "confidence %v", confidence)
+            }
+        })
+    }
+
+    t.Run("Known cutoff", func(t *testing.T) {
+        returns := make([]float64, 100)
+        for i := range returns {
+            returns[i] = float64(i-50) / 1000
+        }
+        tail := HistoricalTail(returns, 0.95)
+        assert.InDelta(t, -0.045, tail.VaR, 1e-12)
+        assert.InDelta(t, -0.0475, tail.ExpectedShortfall, 1e-12)
+    })
+
+    t.Run("No returns", func(t *testing.T) {
+        assert.Equal(t, TailReturns{}, HistoricalTail(nil, 0.95))
+    })
+}
+
+func TestParametricTail(t *testing.T) {
+    // For a standard normal, VaR is the quantile z and ES is phi(z)/(1-c)
+    tests := []struct {
+        confidence float64
+        wantVaR    float64
+        wantES     float64
+    }{
+        {confidence: 0.95, wantVaR: -1.644854, wantES: -2.062713},
+        {confidence: 0.99, wantVaR: -2.326348, wantES: -2.665214},
+        {confidence: 0.975, wantVaR: -1.959964, wantES: -2.337803},
+    }
+
+    for _, tt := range tests {
+        tail := ParametricTail(0, 1, tt.confidence)
+        assert.InDelta(t, tt.wantVaR, tail.VaR, 1e-5)
+        assert.InDelta(t, tt.wantES, tail.ExpectedShortfall, 1e-5)
+    }
+
+    // Mean and deviation shift and scale both
+    tail := ParametricTail(0.001, 0.02, 0.95)
+    assert.InDelta(t, 0.001-0.02*1.644854, tail.VaR, 1e-6)
+    assert.InDelta(t, 0.001-0.02*2.062713, tail.ExpectedShortfall, 1e-6)
+
+    for _, stdDev := range []float64{0, 0.01, 0.05, 0.5} {
+        tail := ParametricTail(0.0005, stdDev, 0.95)
+        assert.LessOrEqual(t, tail.ExpectedShortfall, tail.VaR)
+        assert.False(t, math.IsNaN(tail.ExpectedShortfall))
+    }
+}
+
+func TestFiniteReturns(t *testing.T) {
+    returns, skipped := FiniteReturns([]float64{0.01, math.Inf(1), -0.02, math.NaN(), math.Inf(-1)})
+    assert.Equal(t, []float64{0.01, -0.02}, returns)
+    assert.Equal(t, 3, skipped)
+
+    returns, skipped = FiniteReturns(nil)
+    assert.Empty(t, returns)
+    assert.Zero(t, skipped)
+}
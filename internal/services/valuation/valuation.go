@@ -0,0 +1,255 @@
+package valuation
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+
+    "github.com/shopspring/decimal"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/logger"
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/models"
+)
+
+// Asset types. Equities are stored as either stock or equity.
+const (
+    TypeStock      = "stock"
+    TypeEquity     = "equity"
+    TypeCrypto     = "crypto"
+    TypeCash       = "cash"
+    TypeStablecoin = "stablecoin"
+)
+
+const (
+    // DefaultCurrency is the portfolio currency cash is valued in unless
+    // another is configured
+    DefaultCurrency = "USD"
+    // DefaultDepegTolerance is how far a stablecoin's market price may
+    // stray from its peg, relative to the peg, before the peg is no longer
+    // trusted
+    DefaultDepegTolerance = 0.02
+)
+
+// ErrUnsupportedCurrency is returned for cash held in a currency other than
+// the portfolio's, which can't be converted
+var ErrUnsupportedCurrency = errors.New("cash is not in the portfolio currency")
+
+// DefaultPegs are the stablecoins recognised without an asset type, and the
+// value each is pegged to
+func DefaultPegs() map[string]decimal.Decimal {
+    one := decimal.NewFromInt(1)
+    return map[string]decimal.Decimal{
+        "USDT": one,
+        "USDC": one,
+        "DAI":  one,
+        "BUSD": one,
+        "TUSD": one,
+    }
+}
+
+// Valuer prices the assets of one asset type
+type Valuer interface {
+    // Price returns the current value of one unit of symbol
+    Price(ctx context.Context, symbol string) (decimal.Decimal, error)
+    // Volatile reports whether the type's prices move with a market, so
+    // its assets count towards volatility and VaR
+    Volatile() bool
+}
+
+// MarketValuer prices assets at their latest market price
+type MarketValuer struct {
+    prices models.PriceSource
+}
+
+func NewMarketValuer(prices models.PriceSource) *MarketValuer {
+    return &MarketValuer{prices: prices}
+}
+
+func (v *MarketValuer) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+    if v.prices == nil {
+        return decimal.Zero, fmt.Errorf("no price source for %s", symbol)
+    }
+    price, err := v.prices.GetPrice(ctx, symbol)
+    if err != nil {
+        return decimal.Zero, err
+    }
+    return decimal.NewFromFloat(price), nil
+}
+
+func (v *MarketValuer) Volatile() bool { return true }
+
+// CashValuer values cash at face in the portfolio currency. A cash asset's
+// symbol is its currency, or empty for the portfolio currency.
+type CashValuer struct {
+    currency string
+}
+
+func NewCashValuer(currency string) *CashValuer {
+    return &CashValuer{currency: strings.ToUpper(currency)}
+}
+
+func (v *CashValuer) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+    if symbol != "" && strings.ToUpper(symbol) != v.currency {
+        return decimal.Zero, fmt.Errorf("%w: %s held in a %s portfolio", ErrUnsupportedCurrency, symbol, v.currency)
+    }
+    return decimal.NewFromInt(1), nil
+}
+
+func (v *CashValuer) Volatile() bool { return false }
+
+// StablecoinValuer values stablecoins at their peg, unless the market price
+// has strayed beyond the tolerance, in which case the coin has depegged and
+// is valued at the market price. Without a market price the peg is used.
+type StablecoinValuer struct {
+    prices    models.PriceSource
+    pegs      map[string]decimal.Decimal
+    tolerance float64
+    logger    *logger.Logger
+}
+
+func NewStablecoinValuer(prices models.PriceSource, pegs map[string]decimal.Decimal, tolerance float64) *StablecoinValuer {
+    return &StablecoinValuer{
+        prices:    prices,
+        pegs:      pegs,
+        tolerance: tolerance,
+        logger:    logger.Default().WithFields(map[string]interface{}{"component": "stablecoin_valuer"}),
+    }
+}
+
+// peg returns what symbol is pegged to. Coins without a configured peg are
+// taken to track the dollar.
+func (v *StablecoinValuer) peg(symbol string) decimal.Decimal {
+    if peg, ok := v.pegs[strings.ToUpper(symbol)]; ok {
+        return peg
+    }
+    return decimal.NewFromInt(1)
+}
+
+func (v *StablecoinValuer) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+    peg := v.peg(symbol)
+    if v.prices == nil {
+        return peg, nil
+    }
+    market, err := v.prices.GetPrice(ctx, symbol)
+    if err != nil || market <= 0 {
+        return peg, nil
+    }
+
+    pegValue := models.DecimalToFloat(peg)
+    deviation := math.Abs(market-pegValue) / pegValue
+    if deviation > v.tolerance {
+        v.logger.WithFields(map[string]interface{}{
+            "symbol":    symbol,
+            "peg":       pegValue,
+            "price":     market,
+            "deviation": deviation,
+        }).Warn("Stablecoin has depegged, valuing at market price")
+        return decimal.NewFromFloat(market), nil
+    }
+    return peg, nil
+}
+
+func (v *StablecoinValuer) Volatile() bool { return false }
+
+// Registry picks the Valuer of each asset type. Types it doesn't know are
+// valued at their market price, like equities.
+type Registry struct {
+    db       *sql.DB
+    valuers  map[string]Valuer
+    fallback Valuer
+    pegs     map[string]decimal.Decimal
+}
+
+// NewRegistry values equities and crypto at their prices, cash at face in
+// currency and stablecoins at their DefaultPegs. Asset types are resolved
+// from db. prices may be nil when the registry only classifies assets.
+func NewRegistry(db *sql.DB, prices models.PriceSource, currency string) *Registry {
+    market := NewMarketValuer(prices)
+    pegs := DefaultPegs()
+    return &Registry{
+        db: db,
+        valuers: map[string]Valuer{
+            TypeStock:      market,
+            TypeEquity:     market,
+            TypeCrypto:     market,
+            TypeCash:       NewCashValuer(currency),
+            TypeStablecoin: NewStablecoinValuer(prices, pegs, DefaultDepegTolerance),
+        },
+        fallback: market,
+        pegs:     pegs,
+    }
+}
+
+// Register values assets of assetType with v
+func (r *Registry) Register(assetType string, v Valuer) *Registry {
+    r.valuers[strings.ToLower(assetType)] = v
+    return r
+}
+
+// For returns the Valuer of assetType
+func (r *Registry) For(assetType string) Valuer {
+    if v, ok := r.valuers[strings.ToLower(assetType)]; ok {
+        return v
+    }
+    return r.fallback
+}
+
+// Price returns the current value of one unit of symbol, an asset of
+// assetType
+func (r *Registry) Price(ctx context.Context, assetType, symbol string) (decimal.Decimal, error) {
+    return r.For(assetType).Price(ctx, symbol)
+}
+
+// Volatile reports whether assets of assetType count towards volatility
+// and VaR
+func (r *Registry) Volatile(assetType string) bool {
+    return r.For(assetType).Volatile()
+}
+
+// defaultType is the type of a symbol with none stored: cash when it has no
+// symbol, a stablecoin when it is a known one, and otherwise an equity
+func (r *Registry) defaultType(symbol string) string {
+    if symbol == "" {
+        return TypeCash
+    }
+    if _, ok := r.pegs[strings.ToUpper(symbol)]; ok {
+        return TypeStablecoin
+    }
+    return TypeEquity
+}
+
+// Types resolves the asset type of each symbol from assets.asset_class,
+// falling back to the symbol's default type for symbols not listed there
+func (r *Registry) Types(ctx context.Context, symbols []string) (map[string]string, error) {
+    types := make(map[string]string, len(symbols))
+    for _, symbol := range symbols {
+        types[symbol] = r.defaultType(symbol)
+    }
+    if len(symbols) == 0 || r.db == nil {
+        return types, nil
+    }
+
+    query := `
+        SELECT DISTINCT ON (symbol) symbol, asset_class
+        FROM assets
+        WHERE symbol = ANY($1)
+        ORDER BY symbol, last_update DESC
+    `
+    rows, err := r.db.QueryContext(ctx, query, symbols)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var symbol, assetType string
+        if err := rows.Scan(&symbol, &assetType); err != nil {
+            return nil, err
+        }
+        types[symbol] = strings.ToLower(assetType)
+    }
+    return types, rows.Err()
+}
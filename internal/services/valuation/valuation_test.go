@@ -0,0 +1,105 @@
+package valuation
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/shopspring/decimal"
+    "github.com/stretchr/testify/assert"
+)
+
+type mockPriceSource struct {
+    prices map[string]float64
+}
+
+func (m *mockPriceSource) GetPrice(ctx context.Context, symbol string) (float64, error) {
+    price, ok := m.prices[symbol]
+    if !ok {
+        return 0, errors.New("unknown symbol")
+    }
+    return price, nil
+}
+
+func TestRegistry_Price(t *testing.T) {
+    ctx := context.Background()
+    prices := &mockPriceSource{prices: map[string]float64{
+        "AAPL": 150,
+        "BTC":  40000,
+        "USDC": 0.999,
+        "USDT": 0.9,
+    }}
+    registry := NewRegistry(nil, prices, DefaultCurrency)
+
+    tests := []struct {
+        name      string
+        assetType string
+        symbol    string
+        want      decimal.Decimal
+        volatile  bool
+    }{
+        {"stock at market", TypeStock, "AAPL", decimal.NewFromInt(150), true},
+        {"crypto at market", TypeCrypto, "BTC", decimal.NewFromInt(40000), true},
+        {"unknown type at market", "etf", "AAPL", decimal.NewFromInt(150), true},
+        {"cash at face", TypeCash, "USD", decimal.NewFromInt(1), false},
+        {"cash without a symbol", TypeCash, "", decimal.NewFromInt(1), false},
+        {"stablecoin within tolerance at peg", TypeStablecoin, "USDC", decimal.NewFromInt(1), false},
+        {"depegged stablecoin at market", TypeStablecoin, "USDT", decimal.NewFromFloat(0.9), false},
+        {"stablecoin without a price at peg", TypeStablecoin, "DAI", decimal.NewFromInt(1), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            price, err := registry.Price(ctx, tt.assetType, tt.symbol)
+            assert.NoError(t, err)
+            assert.True(t, tt.want.Equal(price), "got %s", price)
+            assert.Equal(t, tt.volatile, registry.Volatile(tt.assetType))
+        })
+    }
+
+    t.Run("cash in another currency", func(t *testing.T) {
+        _, err := registry.Price(ctx, TypeCash, "EUR")
+        assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+    })
+
+    t.Run("market price unavailable", func(t *testing.T) {
+        _, err := registry.Price(ctx, TypeStock, "MSFT")
+        assert.Error(t, err)
+    })
+}
+
+func TestRegistry_Types(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatalf("Failed to create mock DB: %v", err)
+    }
+    defer db.Close()
+
+    registry := NewRegistry(db, nil, DefaultCurrency)
+    symbols := []string{"AAPL", "BTC", "USDC", "USD", "XYZ"}
+
+    mock.ExpectQuery("SELECT DISTINCT ON \\(symbol\\) symbol, asset_class FROM assets").
+        WithArgs(symbols).
+        WillReturnRows(sqlmock.NewRows([]string{"symbol", "asset_class"}).
+            AddRow("AAPL", "Stock").
+            AddRow("BTC", "crypto").
+            AddRow("USD", "cash"))
+
+    types, err := registry.Types(context.Background(), symbols)
+    assert.NoError(t, err)
+    assert.Equal(t, map[string]string{
+        "AAPL": TypeStock,
+        "BTC":  TypeCrypto,
+        "USD":  TypeCash,
+        // Not listed in assets
+        "USDC": TypeStablecoin,
+        "XYZ":  TypeEquity,
+    }, types)
+    assert.NoError(t, mock.ExpectationsWereMet())
+
+    t.Run("no symbols", func(t *testing.T) {
+        types, err := registry.Types(context.Background(), nil)
+        assert.NoError(t, err)
+        assert.Empty(t, types)
+    })
+}
@@ -0,0 +1,343 @@
+// Package webhooks delivers events to the URLs registered for them.
+// Dispatch stores a delivery for every webhook subscribed to the event,
+// and Deliver, run as a scheduled job, POSTs the ones that are due,
+// retrying failures with backoff as the email outbox does.
+package webhooks
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+)
+
+// Delivery statuses
+const (
+    StatusPending   = "pending"
+    StatusDelivered = "delivered"
+    StatusFailed    = "failed"
+)
+
+// Headers sent with every delivery. SignatureHeader is the hex HMAC-SHA256
+// of the body under the webhook's secret, prefixed with "sha256=".
+const (
+    EventHeader     = "X-Webhook-Event"
+    DeliveryHeader  = "X-Webhook-Delivery"
+    SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+    deliveryBatchSize  = 50
+    defaultMaxAttempts = 8
+    // claimLease keeps other instances off claimed deliveries; deliveries
+    // that were interrupted are retried once it runs out
+    claimLease      = 5 * time.Minute
+    maxBackoff      = time.Hour
+    deliveryTimeout = 10 * time.Second
+    secretBytes     = 32
+)
+
+var (
+    ErrNotFound   = errors.New("webhook not found")
+    ErrInvalidURL = errors.New("webhook URL must be an absolute http or https URL")
+    ErrNoEvents   = errors.New("webhook must subscribe to at least one event")
+)
+
+// EncryptedColumns lists the columns this package encrypts, for key rotation
+var EncryptedColumns = []crypto.Column{
+    {Table: "webhooks", IDColumn: "id", Column: "secret", AssociatedData: secretAssociatedData},
+}
+
+// secretAssociatedData binds a signing secret to its webhook, so a
+// ciphertext copied onto another webhook fails to decrypt
+func secretAssociatedData(webhookID string) []byte {
+    return []byte("webhook:" + webhookID)
+}
+
+// Webhook is a URL subscribed to events
+type Webhook struct {
+    ID        int64     `json:"id"`
+    URL       string    `json:"url"`
+    Events    []string  `json:"events"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// Queue stores and delivers webhook events. Signing secrets are encrypted
+// with keyring.
+type Queue struct {
+    db          *sql.DB
+    keyring     *crypto.Keyring
+    client      *http.Client
+    maxAttempts int
+    now         func() time.Time
+}
+
+func NewQueue(db *sql.DB, keyring *crypto.Keyring) *Queue {
+    return &Queue{
+        db:          db,
+        keyring:     keyring,
+        client:      &http.Client{Timeout: deliveryTimeout},
+        maxAttempts: defaultMaxAttempts,
+        now:         time.Now,
+    }
+}
+
+// WithClient sets the client deliveries are sent with
+func (q *Queue) WithClient(client *http.Client) *Queue {
+    q.client = client
+    return q
+}
+
+// Register subscribes rawURL to events. The secret deliveries are signed
+// with is only returned here.
+func (q *Queue) Register(ctx context.Context, rawURL string, events []string) (*Webhook, string, error) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+        return nil, "", ErrInvalidURL
+    }
+    if len(events) == 0 {
+        return nil, "", ErrNoEvents
+    }
+
+    raw := make([]byte, secretBytes)
+    if _, err := rand.Read(raw); err != nil {
+        return nil, "", err
+    }
+    secret := hex.EncodeToString(raw)
+
+    tx, err := q.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, "", err
+    }
+    defer tx.Rollback()
+
+    webhook := &Webhook{URL: rawURL, Events: events}
+    if err := tx.QueryRowContext(ctx, `
+        INSERT INTO webhooks (url, events, created_at) VALUES ($1, $2, $3)
+        RETURNING id, created_at`,
+        rawURL, pq.Array(events), q.now(),
+    ).Scan(&webhook.ID, &webhook.CreatedAt); err != nil {
+        return nil, "", fmt.Errorf("failed to register webhook: %w", err)
+    }
+
+    // The secret is bound to the webhook's ID, so it is stored once that
+    // is known
+    encrypted, err := q.keyring.Encrypt([]byte(secret), secretAssociatedData(strconv.FormatInt(webhook.ID, 10)))
+    if err != nil {
+        return nil, "", err
+    }
+    if _, err := tx.ExecContext(ctx, `UPDATE webhooks SET secret = $1 WHERE id = $2`, encrypted, webhook.ID); err != nil {
+        return nil, "", fmt.Errorf("failed to store webhook secret: %w", err)
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, "", err
+    }
+    return webhook, secret, nil
+}
+
+// Remove deactivates a webhook. Its pending deliveries are dropped when
+// they come due.
+func (q *Queue) Remove(ctx context.Context, id int64) error {
+    result, err := q.db.ExecContext(ctx, `UPDATE webhooks SET active = FALSE WHERE id = $1 AND active`, id)
+    if err != nil {
+        return fmt.Errorf("failed to remove webhook %d: %w", id, err)
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// Dispatch queues payload, encoded as JSON, for every active webhook
+// subscribed to event
+func (q *Queue) Dispatch(ctx context.Context, event string, payload interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("failed to encode %s payload: %w", event, err)
+    }
+
+    _, err = q.db.ExecContext(ctx, `
+        INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+        SELECT id, $1, $2, $3, $4, $4 FROM webhooks
+        WHERE active AND $1 = ANY(events)`,
+        event, body, StatusPending, q.now())
+    if err != nil {
+        return fmt.Errorf("failed to queue %s: %w", event, err)
+    }
+    return nil
+}
+
+type delivery struct {
+    id        int64
+    webhookID int64
+    url       string
+    secret    string
+    event     string
+    payload   []byte
+    attempts  int
+}
+
+// Deliver sends a batch of due deliveries and returns how many were
+// accepted. A delivery is accepted by any 2xx response; others are retried
+// with exponential backoff up to the queue's attempts.
+func (q *Queue) Deliver(ctx context.Context) (int, error) {
+    now := q.now()
+    deliveries, err := q.claim(ctx, now)
+    if err != nil {
+        return 0, err
+    }
+
+    delivered := 0
+    for _, d := range deliveries {
+        if ctx.Err() != nil {
+            return delivered, ctx.Err()
+        }
+
+        deliveryErr := q.send(ctx, d)
+        if err := q.record(ctx, d, deliveryErr, now); err != nil {
+            return delivered, err
+        }
+        if deliveryErr == nil {
+            delivered++
+        }
+    }
+    return delivered, nil
+}
+
+func (q *Queue) claim(ctx context.Context, now time.Time) ([]delivery, error) {
+    // Deliveries of removed webhooks are dropped rather than sent
+    if _, err := q.db.ExecContext(ctx, `
+        UPDATE webhook_deliveries d SET status = $1, last_error = 'webhook removed'
+        FROM webhooks w
+        WHERE w.id = d.webhook_id AND NOT w.active AND d.status = $2`,
+        StatusFailed, StatusPending); err != nil {
+        return nil, fmt.Errorf("failed to drop deliveries of removed webhooks: %w", err)
+    }
+
+    rows, err := q.db.QueryContext(ctx, `
+        WITH claimed AS (
+            UPDATE webhook_deliveries SET next_attempt_at = $1
+            WHERE id IN (
+                SELECT id FROM webhook_deliveries
+                WHERE status = $2 AND next_attempt_at <= $3
+                ORDER BY next_attempt_at
+                LIMIT $4
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING id, webhook_id, event, payload, attempts
+        )
+        SELECT c.id, c.webhook_id, w.url, w.secret, c.event, c.payload, c.attempts
+        FROM claimed c JOIN webhooks w ON w.id = c.webhook_id`,
+        now.Add(claimLease), StatusPending, now, deliveryBatchSize)
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+    }
+    defer rows.Close()
+
+    var deliveries []delivery
+    for rows.Next() {
+        var d delivery
+        var encrypted string
+        if err := rows.Scan(&d.id, &d.webhookID, &d.url, &encrypted, &d.event, &d.payload, &d.attempts); err != nil {
+            return nil, err
+        }
+        secret, err := q.keyring.Decrypt(encrypted, secretAssociatedData(strconv.FormatInt(d.webhookID, 10)))
+        if err != nil {
+            return nil, fmt.Errorf("failed to decrypt secret of webhook %d: %w", d.webhookID, err)
+        }
+        d.secret = string(secret)
+        deliveries = append(deliveries, d)
+    }
+    return deliveries, rows.Err()
+}
+
+// send POSTs a delivery, signed with its webhook's secret
+func (q *Queue) send(ctx context.Context, d delivery) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(EventHeader, d.event)
+    req.Header.Set(DeliveryHeader, strconv.FormatInt(d.id, 10))
+    req.Header.Set(SignatureHeader, Sign(d.secret, d.payload))
+
+    resp, err := q.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook responded %s", resp.Status)
+    }
+    return nil
+}
+
+// Sign returns the SignatureHeader value of body under secret
+func Sign(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// record stores the outcome of a delivery attempt
+func (q *Queue) record(ctx context.Context, d delivery, deliveryErr error, now time.Time) error {
+    attempts := d.attempts + 1
+    status := StatusPending
+    next := now.Add(backoff(attempts))
+    var deliveredAt sql.NullTime
+    var lastError sql.NullString
+
+    switch {
+    case deliveryErr == nil:
+        status = StatusDelivered
+        deliveredAt = sql.NullTime{Time: now, Valid: true}
+    case attempts >= q.maxAttempts:
+        status = StatusFailed
+    }
+    if deliveryErr != nil {
+        lastError = sql.NullString{String: deliveryErr.Error(), Valid: true}
+    }
+
+    _, err := q.db.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, delivered_at = $5
+        WHERE id = $6`,
+        status, attempts, lastError, next, deliveredAt, d.id)
+    if err != nil {
+        return fmt.Errorf("failed to record webhook delivery %d: %w", d.id, err)
+    }
+    return nil
+}
+
+// backoff doubles the wait after each failed attempt, from a minute up to
+// an hour
+func backoff(attempts int) time.Duration {
+    if attempts > 7 {
+        return maxBackoff
+    }
+    wait := time.Minute << (attempts - 1)
+    if wait > maxBackoff {
+        return maxBackoff
+    }
+    return wait
+}
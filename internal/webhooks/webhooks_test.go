@@ -0,0 +1,127 @@
+package webhooks
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/Cryptoprojectsfun/quantai-clone/internal/crypto"
+)
+
+func testKeyring(t *testing.T) *crypto.Keyring {
+    keyring, err := crypto.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)})
+    require.NoError(t, err)
+    return keyring
+}
+
+func TestQueue_Dispatch(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+
+    queue := NewQueue(db, testKeyring(t))
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    queue.now = func() time.Time { return now }
+
+    mock.ExpectExec("INSERT INTO webhook_deliveries (.+) SELECT id, (.+) FROM webhooks").
+        WithArgs("GLOBAL_REGIME_CHANGE", []byte(`{"current":"risk_off"}`), StatusPending, now).
+        WillReturnResult(sqlmock.NewResult(0, 2))
+    assert.NoError(t, queue.Dispatch(context.Background(), "GLOBAL_REGIME_CHANGE", map[string]string{"current": "risk_off"}))
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueue_Register_Invalid(t *testing.T) {
+    queue := NewQueue(nil, testKeyring(t))
+
+    _, _, err := queue.Register(context.Background(), "ftp://example.com/hook", []string{"GLOBAL_REGIME_CHANGE"})
+    assert.ErrorIs(t, err, ErrInvalidURL)
+    _, _, err = queue.Register(context.Background(), "/hook", []string{"GLOBAL_REGIME_CHANGE"})
+    assert.ErrorIs(t, err, ErrInvalidURL)
+    _, _, err = queue.Register(context.Background(), "https://example.com/hook", nil)
+    assert.ErrorIs(t, err, ErrNoEvents)
+}
+
+func TestQueue_Deliver(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+
+    var signatures []string
+    ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        assert.Equal(t, "GLOBAL_REGIME_CHANGE", r.Header.Get(EventHeader))
+        assert.Equal(t, "1", r.Header.Get(DeliveryHeader))
+        assert.Equal(t, Sign("s3cret", body), r.Header.Get(SignatureHeader))
+        signatures = append(signatures, r.Header.Get(SignatureHeader))
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer ok.Close()
+    down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadGateway)
+    }))
+    defer down.Close()
+
+    keyring := testKeyring(t)
+    secret := func(webhookID string) string {
+        encrypted, err := keyring.Encrypt([]byte("s3cret"), secretAssociatedData(webhookID))
+        require.NoError(t, err)
+        return encrypted
+    }
+
+    queue := NewQueue(db, keyring)
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    queue.now = func() time.Time { return now }
+
+    mock.ExpectExec("UPDATE webhook_deliveries d SET status").
+        WithArgs(StatusFailed, StatusPending).
+        WillReturnResult(sqlmock.NewResult(0, 0))
+    mock.ExpectQuery("UPDATE webhook_deliveries SET next_attempt_at").
+        WithArgs(now.Add(claimLease), StatusPending, now, deliveryBatchSize).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "url", "secret", "event", "payload", "attempts"}).
+            AddRow(1, 10, ok.URL, secret("10"), "GLOBAL_REGIME_CHANGE", []byte(`{"current":"risk_off"}`), 0).
+            AddRow(2, 11, down.URL, secret("11"), "GLOBAL_REGIME_CHANGE", []byte(`{"current":"risk_off"}`), 2))
+    mock.ExpectExec("UPDATE webhook_deliveries SET status").
+        WithArgs(StatusDelivered, 1, nil, sqlmock.AnyArg(), now, int64(1)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+    // The failed delivery waits 4 minutes after its third attempt
+    mock.ExpectExec("UPDATE webhook_deliveries SET status").
+        WithArgs(StatusPending, 3, "webhook responded 502 Bad Gateway", now.Add(4*time.Minute), nil, int64(2)).
+        WillReturnResult(sqlmock.NewResult(0, 1))
+
+    delivered, err := queue.Deliver(context.Background())
+    require.NoError(t, err)
+    assert.Equal(t, 1, delivered)
+    assert.Len(t, signatures, 1)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueue_Deliver_SecretOfAnotherWebhook(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+
+    keyring := testKeyring(t)
+    copied, err := keyring.Encrypt([]byte("s3cret"), secretAssociatedData("10"))
+    require.NoError(t, err)
+
+    mock.ExpectExec("UPDATE webhook_deliveries d SET status").WillReturnResult(sqlmock.NewResult(0, 0))
+    mock.ExpectQuery("UPDATE webhook_deliveries SET next_attempt_at").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "url", "secret", "event", "payload", "attempts"}).
+            AddRow(1, 11, "https://example.com", copied, "GLOBAL_REGIME_CHANGE", []byte(`{}`), 0))
+
+    _, err = NewQueue(db, keyring).Deliver(context.Background())
+    assert.ErrorIs(t, err, crypto.ErrAuthentication)
+}
+
+func TestBackoff(t *testing.T) {
+    for attempts, want := range map[int]time.Duration{1: time.Minute, 3: 4 * time.Minute, 7: time.Hour, 20: time.Hour} {
+        assert.Equal(t, want, backoff(attempts), "attempts %d", attempts)
+    }
+}
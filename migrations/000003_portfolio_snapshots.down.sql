@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_portfolio_snapshots_portfolio_date;
+DROP TABLE IF EXISTS portfolio_snapshots;
@@ -0,0 +1,11 @@
+-- Daily portfolio valuations used for return-based analytics
+CREATE TABLE portfolio_snapshots (
+    id BIGSERIAL PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    snapshot_date DATE NOT NULL,
+    total_value DECIMAL(20,8) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (portfolio_id, snapshot_date)
+);
+
+CREATE INDEX idx_portfolio_snapshots_portfolio_date ON portfolio_snapshots(portfolio_id, snapshot_date);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_trades_portfolio_executed;
+DROP TABLE IF EXISTS trades;
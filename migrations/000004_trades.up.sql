@@ -0,0 +1,13 @@
+-- Executed trades; amounts are exact decimals so PnL reconciles to the unit
+CREATE TABLE trades (
+    id BIGSERIAL PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    side VARCHAR(4) NOT NULL CHECK (side IN ('buy', 'sell')),
+    quantity DECIMAL(20,8) NOT NULL CHECK (quantity > 0),
+    price DECIMAL(20,8) NOT NULL CHECK (price > 0),
+    fee DECIMAL(20,8) NOT NULL DEFAULT 0,
+    executed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_trades_portfolio_executed ON trades(portfolio_id, executed_at);
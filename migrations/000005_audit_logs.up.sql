@@ -0,0 +1,11 @@
+-- Record of privileged actions such as role changes
+CREATE TABLE audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    actor_email VARCHAR(255) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_id VARCHAR(64) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at);
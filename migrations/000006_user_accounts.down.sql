@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_users_purge_after;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS purge_after,
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS tokens_revoked_at,
+    DROP COLUMN IF EXISTS base_currency,
+    DROP COLUMN IF EXISTS timezone;
@@ -0,0 +1,11 @@
+-- Profile preferences, soft deletion and token revocation for user accounts
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS subscription_tier VARCHAR(50) NOT NULL DEFAULT 'free',
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    ADD COLUMN base_currency CHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN tokens_revoked_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN purge_after TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_purge_after ON users(purge_after) WHERE deleted_at IS NOT NULL;
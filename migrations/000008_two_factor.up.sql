@@ -0,0 +1,17 @@
+-- TOTP two-factor authentication. Secrets are AES-GCM encrypted by the
+-- application; recovery codes are stored as SHA-256 hashes.
+ALTER TABLE users
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_secret BYTEA,
+    ADD COLUMN totp_pending_secret BYTEA,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
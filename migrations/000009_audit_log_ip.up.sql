@@ -0,0 +1,2 @@
+-- Client address of the request that caused each audit entry
+ALTER TABLE audit_logs ADD COLUMN ip VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS sentiment_source_calibrations;
+DROP TABLE IF EXISTS prediction_outcomes;
+ALTER TABLE market_analysis DROP COLUMN IF EXISTS source;
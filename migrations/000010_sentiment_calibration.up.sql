@@ -0,0 +1,23 @@
+-- Source of each sentiment reading, so readings can be scored per source
+ALTER TABLE market_analysis ADD COLUMN source VARCHAR(50) NOT NULL DEFAULT 'news';
+
+-- Realised next-day return for each asset, source and trading day
+CREATE TABLE prediction_outcomes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    asset_symbol VARCHAR(50) NOT NULL,
+    source VARCHAR(50) NOT NULL,
+    observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    next_day_return DECIMAL(10, 6) NOT NULL,
+    CONSTRAINT unique_outcome_per_day UNIQUE (asset_symbol, source, observed_at)
+);
+
+CREATE INDEX idx_prediction_outcomes_observed_at ON prediction_outcomes(observed_at);
+
+-- Latest calibrated weight for each sentiment source
+CREATE TABLE sentiment_source_calibrations (
+    source VARCHAR(50) PRIMARY KEY,
+    weight DECIMAL(6, 5) NOT NULL,
+    correlation DECIMAL(6, 5) NOT NULL,
+    sample_count INTEGER NOT NULL,
+    calibrated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS model_version_pins;
+DROP TABLE IF EXISTS model_activations;
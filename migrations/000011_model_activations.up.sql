@@ -0,0 +1,29 @@
+-- Who activated which model version and when, newest last; rollbacks
+-- re-activate the previous entry
+CREATE TABLE model_activations (
+    id BIGSERIAL PRIMARY KEY,
+    model_name VARCHAR(255) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('activate', 'rollback')),
+    activated_by VARCHAR(255) NOT NULL,
+    activated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (model_name, version) REFERENCES ml_models(name, version)
+);
+
+CREATE INDEX idx_model_activations_model ON model_activations(model_name, activated_at);
+
+-- Model versions that strategies and schedules depend on; pinned versions
+-- can't be archived while the pin is active
+CREATE TABLE model_version_pins (
+    id BIGSERIAL PRIMARY KEY,
+    consumer_type VARCHAR(20) NOT NULL CHECK (consumer_type IN ('strategy', 'schedule')),
+    consumer_id VARCHAR(64) NOT NULL,
+    model_name VARCHAR(255) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (model_name, version) REFERENCES ml_models(name, version),
+    CONSTRAINT unique_model_pin UNIQUE (consumer_type, consumer_id, model_name)
+);
+
+CREATE INDEX idx_model_version_pins_version ON model_version_pins(model_name, version) WHERE active;
@@ -0,0 +1,14 @@
+-- Daily regime labels per symbol; the market-wide composite is stored
+-- under the symbol MARKET
+CREATE TABLE market_regimes (
+    id BIGSERIAL PRIMARY KEY,
+    symbol VARCHAR(20) NOT NULL,
+    date DATE NOT NULL,
+    label VARCHAR(20) NOT NULL CHECK (label IN ('trending', 'ranging', 'high_volatility')),
+    direction VARCHAR(10) NOT NULL CHECK (direction IN ('up', 'down', 'flat')),
+    adx DOUBLE PRECISION NOT NULL,
+    vol_percentile DOUBLE PRECISION NOT NULL,
+    ma_slope DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_market_regime UNIQUE (symbol, date)
+);
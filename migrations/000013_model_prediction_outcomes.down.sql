@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_prediction_outcomes_model;
+DELETE FROM prediction_outcomes WHERE model_name != '';
+
+ALTER TABLE prediction_outcomes DROP CONSTRAINT unique_outcome_per_day;
+ALTER TABLE prediction_outcomes ADD CONSTRAINT unique_outcome_per_day
+    UNIQUE (asset_symbol, source, observed_at);
+
+ALTER TABLE prediction_outcomes
+    DROP COLUMN actual_value,
+    DROP COLUMN predicted_value,
+    DROP COLUMN model_name;
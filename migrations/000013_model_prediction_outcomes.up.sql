@@ -0,0 +1,12 @@
+-- Outcomes of model predictions, so live error can be compared with the
+-- error a model was trained to. Sentiment outcomes leave model_name empty.
+ALTER TABLE prediction_outcomes
+    ADD COLUMN model_name VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN predicted_value DECIMAL(20, 8),
+    ADD COLUMN actual_value DECIMAL(20, 8);
+
+ALTER TABLE prediction_outcomes DROP CONSTRAINT unique_outcome_per_day;
+ALTER TABLE prediction_outcomes ADD CONSTRAINT unique_outcome_per_day
+    UNIQUE (asset_symbol, source, model_name, observed_at);
+
+CREATE INDEX idx_prediction_outcomes_model ON prediction_outcomes(model_name, observed_at) WHERE model_name != '';
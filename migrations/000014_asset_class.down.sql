@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_assets_symbol_asset_class;
+ALTER TABLE assets DROP COLUMN IF EXISTS asset_class;
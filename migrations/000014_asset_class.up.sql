@@ -0,0 +1,6 @@
+-- Risk thresholds are applied per asset class
+ALTER TABLE assets ADD COLUMN asset_class VARCHAR(20) NOT NULL DEFAULT 'equity';
+
+UPDATE assets SET asset_class = 'crypto' WHERE LOWER(type) = 'crypto';
+
+CREATE INDEX idx_assets_symbol_asset_class ON assets(symbol, asset_class);
@@ -0,0 +1 @@
+ALTER TABLE ml_models DROP COLUMN IF EXISTS artifact_checksum;
@@ -0,0 +1,3 @@
+-- SHA-256 of the model version's artifact in the artifact store. NULL for
+-- models trained before artifacts were uploaded, which are read from disk.
+ALTER TABLE ml_models ADD COLUMN artifact_checksum CHAR(64);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_portfolios_search_vector;
+ALTER TABLE portfolios DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,5 @@
+-- Full-text search over portfolio names and descriptions
+ALTER TABLE portfolios ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', name || ' ' || COALESCE(description, ''))) STORED;
+
+CREATE INDEX idx_portfolios_search_vector ON portfolios USING GIN (search_vector);
@@ -0,0 +1,5 @@
+ALTER TABLE portfolio_snapshots
+    DROP COLUMN IF EXISTS taken_at,
+    DROP COLUMN IF EXISTS timezone;
+
+DROP TABLE IF EXISTS symbol_metadata;
@@ -0,0 +1,23 @@
+-- Calendars daily boundaries are counted in: the exchange timezone of each
+-- symbol, and the timezone and time each portfolio snapshot was taken in
+CREATE TABLE symbol_metadata (
+    symbol VARCHAR(20) PRIMARY KEY,
+    asset_class VARCHAR(20) NOT NULL DEFAULT 'equity',
+    exchange VARCHAR(20),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO symbol_metadata (symbol, asset_class, exchange, timezone) VALUES
+    ('SPY', 'equity', 'NYSEARCA', 'America/New_York'),
+    ('BTC', 'crypto', NULL, 'UTC'),
+    ('ETH', 'crypto', NULL, 'UTC');
+
+ALTER TABLE portfolio_snapshots
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    ADD COLUMN taken_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE portfolio_snapshots
+SET taken_at = COALESCE(created_at, snapshot_date::timestamp AT TIME ZONE 'UTC');
+
+ALTER TABLE portfolio_snapshots ALTER COLUMN taken_at SET NOT NULL;
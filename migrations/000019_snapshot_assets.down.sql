@@ -0,0 +1 @@
+ALTER TABLE portfolio_snapshots DROP COLUMN IF EXISTS assets;
@@ -0,0 +1,2 @@
+-- Holdings at each snapshot, so allocation history can be reconstructed
+ALTER TABLE portfolio_snapshots ADD COLUMN assets JSONB NOT NULL DEFAULT '[]';
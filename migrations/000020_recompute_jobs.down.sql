@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_recompute_jobs_status;
+DROP TABLE IF EXISTS recompute_jobs;
+DROP TABLE IF EXISTS portfolio_risk_history;
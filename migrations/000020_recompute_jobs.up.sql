@@ -0,0 +1,35 @@
+-- Daily risk metrics of each portfolio, as the scheduled risk analysis
+-- recorded them
+CREATE TABLE portfolio_risk_history (
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    var DECIMAL(20,8) NOT NULL,
+    expected_shortfall DECIMAL(20,8) NOT NULL,
+    drawdown DECIMAL(20,8) NOT NULL,
+    concentration DECIMAL(20,8) NOT NULL,
+    volatility DECIMAL(20,8) NOT NULL,
+    alert_level VARCHAR(20) NOT NULL,
+    computed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (portfolio_id, day)
+);
+
+-- Admin-requested recomputation of derived portfolio data. cursor is the
+-- last portfolio finished, so a paused or interrupted job resumes after it.
+CREATE TABLE recompute_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    scope JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    done INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    cursor BIGINT NOT NULL DEFAULT 0,
+    current_portfolio_id BIGINT,
+    errors JSONB NOT NULL DEFAULT '[]',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_recompute_jobs_status ON recompute_jobs(status, id);
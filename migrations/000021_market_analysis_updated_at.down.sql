@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_market_analysis_updated_at;
@@ -0,0 +1,2 @@
+-- Supports purging and counting stale analyses by age
+CREATE INDEX idx_market_analysis_updated_at ON market_analysis(updated_at);
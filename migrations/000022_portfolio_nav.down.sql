@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS portfolio_nav;
+DROP INDEX IF EXISTS idx_portfolio_cashflows_portfolio_time;
+DROP TABLE IF EXISTS portfolio_cashflows;
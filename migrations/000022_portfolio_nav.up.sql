@@ -0,0 +1,23 @@
+-- Deposits (positive) and withdrawals (negative) of each portfolio's cash
+CREATE TABLE portfolio_cashflows (
+    id BIGSERIAL PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    amount DECIMAL(20,8) NOT NULL CHECK (amount <> 0),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_portfolio_cashflows_portfolio_time ON portfolio_cashflows(portfolio_id, occurred_at);
+
+-- Unitized value of each portfolio at each snapshot: deposits buy units at
+-- the prevailing NAV, so NAV per unit moves only with performance
+CREATE TABLE portfolio_nav (
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    nav_date DATE NOT NULL,
+    taken_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    timezone VARCHAR(64) NOT NULL,
+    nav DECIMAL(20,8) NOT NULL,
+    units DECIMAL(28,12) NOT NULL,
+    total_value DECIMAL(20,8) NOT NULL,
+    PRIMARY KEY (portfolio_id, nav_date)
+);
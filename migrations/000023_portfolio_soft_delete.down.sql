@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_portfolios_user_active;
+ALTER TABLE portfolios DROP COLUMN IF EXISTS deleted_at;
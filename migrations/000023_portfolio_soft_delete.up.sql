@@ -0,0 +1,4 @@
+-- Soft deletion for portfolios removed in bulk
+ALTER TABLE portfolios ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_portfolios_user_active ON portfolios(user_id) WHERE deleted_at IS NULL;
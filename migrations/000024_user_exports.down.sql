@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_user_exports_status;
+DROP INDEX IF EXISTS idx_user_exports_user;
+DROP TABLE IF EXISTS user_exports;
@@ -0,0 +1,21 @@
+-- Users' exports of their own data. parts are the files stored so far, so
+-- an interrupted export resumes after them; checksum addresses the
+-- finished archive in the artifact store until expires_at.
+CREATE TABLE user_exports (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    total_files INTEGER NOT NULL DEFAULT 0,
+    parts JSONB NOT NULL DEFAULT '[]',
+    checksum VARCHAR(64),
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_exports_user ON user_exports(user_id, created_at);
+CREATE INDEX idx_user_exports_status ON user_exports(status, id);
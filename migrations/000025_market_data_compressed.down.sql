@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_market_data_compressed_bases;
+DROP TABLE IF EXISTS market_data_compressed;
@@ -0,0 +1,17 @@
+-- Delta-encoded price history. Prices are hundredths relative to the
+-- base_close of the latest row at or before them that has one; a NULL
+-- base_close continues the run of the row before.
+CREATE TABLE market_data_compressed (
+    symbol VARCHAR(20) NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    base_close DOUBLE PRECISION,
+    delta_open SMALLINT NOT NULL,
+    delta_high SMALLINT NOT NULL,
+    delta_low SMALLINT NOT NULL,
+    delta_close SMALLINT NOT NULL,
+    volume DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (symbol, timestamp)
+);
+
+CREATE INDEX idx_market_data_compressed_bases ON market_data_compressed(symbol, timestamp)
+    WHERE base_close IS NOT NULL;
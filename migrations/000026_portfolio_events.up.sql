@@ -0,0 +1,11 @@
+-- Append-only log of every change to a portfolio, written in the same
+-- transaction as the change. seq numbers each portfolio's events from 1.
+CREATE TABLE portfolio_events (
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    actor VARCHAR(255) NOT NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (portfolio_id, seq)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_portfolios_user_paper;
+DROP TABLE IF EXISTS paper_positions;
+ALTER TABLE IF EXISTS assets DROP COLUMN IF EXISTS avg_price;
+ALTER TABLE portfolios DROP COLUMN IF EXISTS fork_of_id;
+ALTER TABLE portfolios DROP COLUMN IF EXISTS paper_trading;
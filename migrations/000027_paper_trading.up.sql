@@ -0,0 +1,22 @@
+-- Paper trading portfolios are forks of a portfolio holding copies of its
+-- positions, so trading them never touches the source's. Portfolios keyed
+-- the old way keep a fork's assets in paper_positions.
+ALTER TABLE portfolios
+    ADD COLUMN paper_trading BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN fork_of_id BIGINT REFERENCES portfolios(id) ON DELETE SET NULL;
+
+ALTER TABLE IF EXISTS assets ADD COLUMN avg_price DECIMAL(20, 8) NOT NULL DEFAULT 0;
+
+CREATE TABLE paper_positions (
+    id UUID PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    symbol VARCHAR(50) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    quantity DECIMAL(20, 8) NOT NULL,
+    avg_price DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    value DECIMAL(20, 8) NOT NULL,
+    last_update TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT unique_paper_position_per_portfolio UNIQUE (portfolio_id, symbol)
+);
+
+CREATE INDEX idx_portfolios_user_paper ON portfolios(user_id, paper_trading) WHERE deleted_at IS NULL;
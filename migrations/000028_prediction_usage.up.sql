@@ -0,0 +1,8 @@
+-- Predictions served per user per UTC day, counted against the daily
+-- prediction limit of the user's subscription tier
+CREATE TABLE prediction_usage (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
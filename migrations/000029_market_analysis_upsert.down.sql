@@ -0,0 +1,3 @@
+ALTER TABLE market_analysis DROP CONSTRAINT IF EXISTS unique_market_analysis_symbol;
+CREATE INDEX IF NOT EXISTS idx_market_analysis_symbol ON market_analysis(asset_symbol);
+DROP TABLE IF EXISTS market_analysis_history;
@@ -0,0 +1,32 @@
+-- Past analyses, kept for the history retention once market_analysis only
+-- holds the latest analysis of each symbol
+CREATE TABLE market_analysis_history (
+    id BIGSERIAL PRIMARY KEY,
+    analysis_id UUID NOT NULL,
+    asset_symbol VARCHAR(50) NOT NULL,
+    sentiment DECIMAL(5, 2) NOT NULL,
+    volume_24h DECIMAL(20, 8) NOT NULL,
+    price_change_24h DECIMAL(10, 4) NOT NULL,
+    trend_strength DECIMAL(5, 2) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT unique_market_analysis_history UNIQUE (asset_symbol, updated_at)
+);
+
+CREATE INDEX idx_market_analysis_history_updated_at ON market_analysis_history(updated_at);
+
+INSERT INTO market_analysis_history (analysis_id, asset_symbol, sentiment, volume_24h, price_change_24h, trend_strength, updated_at)
+SELECT id, asset_symbol, sentiment, volume_24h, price_change_24h, trend_strength, updated_at
+FROM market_analysis
+ON CONFLICT (asset_symbol, updated_at) DO NOTHING;
+
+-- Keep only the latest analysis of each symbol; the trading signals of the
+-- others go with them
+DELETE FROM market_analysis a
+USING market_analysis b
+WHERE a.asset_symbol = b.asset_symbol
+AND (a.updated_at, a.id) < (b.updated_at, b.id);
+
+-- Analyses are upserted on their symbol, whose unique index replaces the
+-- plain one
+DROP INDEX IF EXISTS idx_market_analysis_symbol;
+ALTER TABLE market_analysis ADD CONSTRAINT unique_market_analysis_symbol UNIQUE (asset_symbol);
@@ -0,0 +1,3 @@
+ALTER TABLE prediction_outcomes
+    DROP COLUMN confidence,
+    DROP COLUMN predicted_direction;
@@ -0,0 +1,6 @@
+-- The direction and confidence a model predicted, so leaderboards can score
+-- its directional accuracy and calibration against next_day_return.
+-- predicted_direction is 1 for up, -1 for down and 0 for flat.
+ALTER TABLE prediction_outcomes
+    ADD COLUMN predicted_direction SMALLINT,
+    ADD COLUMN confidence DECIMAL(6, 5);
@@ -0,0 +1,14 @@
+-- Requests made to external data providers, counted by hour. Cached rows
+-- are requests a cache answered in the provider's place.
+CREATE TABLE provider_usage (
+    hour TIMESTAMP WITH TIME ZONE NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    endpoint_class VARCHAR(50) NOT NULL,
+    symbol VARCHAR(50) NOT NULL DEFAULT '',
+    component VARCHAR(100) NOT NULL,
+    cached BOOLEAN NOT NULL DEFAULT FALSE,
+    requests BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, provider, endpoint_class, symbol, component, cached)
+);
+
+CREATE INDEX idx_provider_usage_provider_hour ON provider_usage(provider, hour);
@@ -0,0 +1,20 @@
+-- Portfolios whose stored total_value disagrees with the value of their
+-- holdings in positions or in assets, as the consistency check found them.
+-- A portfolio has at most one open row per source. It is resolved as
+-- 'consistent' once the values agree again, or with the source a repair
+-- rewrote the portfolio from.
+CREATE TABLE data_inconsistencies (
+    id BIGSERIAL PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    source VARCHAR(20) NOT NULL,
+    stored_value DECIMAL(20,8) NOT NULL,
+    computed_value DECIMAL(20,8) NOT NULL,
+    difference DOUBLE PRECISION NOT NULL,
+    severity VARCHAR(10) NOT NULL,
+    detected_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    resolution VARCHAR(20)
+);
+
+CREATE UNIQUE INDEX idx_data_inconsistencies_open ON data_inconsistencies(portfolio_id, source) WHERE resolved_at IS NULL;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS usage_aggregations;
+DROP TABLE IF EXISTS usage_outages;
+DROP TRIGGER IF EXISTS usage_records_immutable ON usage_records;
+DROP FUNCTION IF EXISTS reject_usage_record_change();
+DROP TABLE IF EXISTS usage_records;
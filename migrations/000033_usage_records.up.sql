@@ -0,0 +1,41 @@
+-- Each user's requests and predictions by hour, written once the hour's
+-- Redis counters are final. Rows are never changed afterwards, so billing
+-- reports can be reproduced. Estimated rows are for hours in which Redis
+-- failed some increments, and so count no more than the user's real usage.
+-- user_id has no foreign key so billing history survives account purges.
+CREATE TABLE usage_records (
+    user_id UUID NOT NULL,
+    metric VARCHAR(20) NOT NULL,
+    hour TIMESTAMP WITH TIME ZONE NOT NULL,
+    count BIGINT NOT NULL,
+    estimated BOOLEAN NOT NULL DEFAULT FALSE,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, metric, hour)
+);
+
+CREATE INDEX idx_usage_records_hour ON usage_records(hour);
+
+CREATE OR REPLACE FUNCTION reject_usage_record_change()
+RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'usage_records rows are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER usage_records_immutable
+    BEFORE UPDATE OR DELETE ON usage_records
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_usage_record_change();
+
+-- Hours in which an instance couldn't count usage in Redis
+CREATE TABLE usage_outages (
+    hour TIMESTAMP WITH TIME ZONE PRIMARY KEY,
+    first_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Hours whose counters have been written to usage_records
+CREATE TABLE usage_aggregations (
+    hour TIMESTAMP WITH TIME ZONE PRIMARY KEY,
+    estimated BOOLEAN NOT NULL,
+    aggregated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
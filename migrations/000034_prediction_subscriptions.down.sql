@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS scheduled_predictions;
+DROP TABLE IF EXISTS prediction_subscriptions;
@@ -0,0 +1,34 @@
+-- Symbols users want a prediction regenerated for on a cadence. failures
+-- counts consecutive failed regenerations, which push next_run_at back,
+-- and notified_at is when the user was last told they keep failing.
+CREATE TABLE prediction_subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    timeframe VARCHAR(10) NOT NULL,
+    cadence VARCHAR(10) NOT NULL CHECK (cadence IN ('daily', 'weekly')),
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    notified_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, symbol, timeframe)
+);
+
+CREATE INDEX idx_prediction_subscriptions_next_run ON prediction_subscriptions(next_run_at);
+
+-- Predictions generated for subscriptions. origin tells them apart from
+-- interactive predictions in usage reports.
+CREATE TABLE scheduled_predictions (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES prediction_subscriptions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    timeframe VARCHAR(10) NOT NULL,
+    origin VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+    prediction JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_scheduled_predictions_subscription ON scheduled_predictions(subscription_id, created_at DESC);
+CREATE INDEX idx_scheduled_predictions_user_day ON scheduled_predictions(user_id, created_at);
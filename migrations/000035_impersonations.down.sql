@@ -0,0 +1,3 @@
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS impersonated_user_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS actor_id;
+DROP TABLE IF EXISTS impersonations;
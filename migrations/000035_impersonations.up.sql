@@ -0,0 +1,17 @@
+-- Support sessions in which an admin sees the API as another user, read
+-- only. Tokens name their impersonation, so ending it voids them early.
+CREATE TABLE impersonations (
+    id VARCHAR(64) PRIMARY KEY,
+    admin_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_impersonations_admin ON impersonations(admin_id, started_at);
+
+-- Entries written while impersonating name the real admin as well as the
+-- user they acted as
+ALTER TABLE audit_logs ADD COLUMN actor_id UUID;
+ALTER TABLE audit_logs ADD COLUMN impersonated_user_id UUID;
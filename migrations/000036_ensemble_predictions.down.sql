@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ensemble_predictions;
@@ -0,0 +1,11 @@
+-- Ensemble predictions, kept so that until valid_until they can be served
+-- again without rerunning the models
+CREATE TABLE ensemble_predictions (
+    id BIGSERIAL PRIMARY KEY,
+    symbol VARCHAR(20) NOT NULL,
+    prediction JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    valid_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_ensemble_predictions_symbol ON ensemble_predictions(symbol, created_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE training_jobs DROP COLUMN IF EXISTS seed;
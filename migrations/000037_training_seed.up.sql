@@ -0,0 +1,2 @@
+-- The seed a training job ran with, so its model can be reproduced
+ALTER TABLE training_jobs ADD COLUMN seed BIGINT;
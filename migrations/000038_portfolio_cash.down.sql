@@ -0,0 +1,5 @@
+ALTER TABLE portfolio_snapshots DROP COLUMN IF EXISTS cash;
+ALTER TABLE trades DROP COLUMN IF EXISTS currency;
+ALTER TABLE portfolio_cashflows DROP COLUMN IF EXISTS currency;
+ALTER TABLE portfolios DROP COLUMN IF EXISTS margin_enabled;
+DROP TABLE IF EXISTS portfolio_cash;
@@ -0,0 +1,24 @@
+-- Cash held in currencies other than the portfolio currency, whose cash
+-- stays in portfolios.balance
+CREATE TABLE portfolio_cash (
+    portfolio_id BIGINT NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+    currency CHAR(3) NOT NULL,
+    amount DECIMAL(20,8) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (portfolio_id, currency)
+);
+
+-- Margin-enabled portfolios may spend more cash than they hold
+ALTER TABLE portfolios ADD COLUMN margin_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE portfolio_cashflows ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE trades ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+-- The cash each snapshot held per currency. Earlier snapshots held their
+-- cash in the portfolio currency: whatever of the value wasn't in assets.
+ALTER TABLE portfolio_snapshots ADD COLUMN cash JSONB NOT NULL DEFAULT '{}';
+UPDATE portfolio_snapshots s
+SET cash = jsonb_build_object('USD', s.total_value - COALESCE((
+    SELECT SUM((a->>'value')::numeric) FROM jsonb_array_elements(s.assets) a
+), 0))
+WHERE jsonb_typeof(s.assets) = 'array';
@@ -0,0 +1,5 @@
+DELETE FROM portfolio_risk_history
+WHERE var IS NULL OR expected_shortfall IS NULL OR volatility IS NULL;
+ALTER TABLE portfolio_risk_history ALTER COLUMN volatility SET NOT NULL;
+ALTER TABLE portfolio_risk_history ALTER COLUMN expected_shortfall SET NOT NULL;
+ALTER TABLE portfolio_risk_history ALTER COLUMN var SET NOT NULL;
@@ -0,0 +1,5 @@
+-- VaR, expected shortfall and volatility are null on the days none of a
+-- portfolio's positions had enough history to measure them
+ALTER TABLE portfolio_risk_history ALTER COLUMN var DROP NOT NULL;
+ALTER TABLE portfolio_risk_history ALTER COLUMN expected_shortfall DROP NOT NULL;
+ALTER TABLE portfolio_risk_history ALTER COLUMN volatility DROP NOT NULL;
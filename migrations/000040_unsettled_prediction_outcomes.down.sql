@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_prediction_outcomes_unsettled;
+DELETE FROM prediction_outcomes WHERE next_day_return IS NULL;
+ALTER TABLE prediction_outcomes ALTER COLUMN next_day_return SET NOT NULL;
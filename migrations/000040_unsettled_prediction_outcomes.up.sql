@@ -0,0 +1,6 @@
+-- Model predictions are recorded when they are made, and their next-day
+-- return and actual value filled in once the next day's close is known
+ALTER TABLE prediction_outcomes ALTER COLUMN next_day_return DROP NOT NULL;
+
+CREATE INDEX idx_prediction_outcomes_unsettled ON prediction_outcomes(observed_at)
+    WHERE actual_value IS NULL AND model_name != '';
@@ -4,10 +4,12 @@ import os
 import json
 import argparse
 import logging
-from typing import Tuple
+import random
+from typing import Optional, Tuple
 
 import numpy as np
 import pandas as pd
+import tensorflow as tf
 from sklearn.model_selection import train_test_split
 
 from models.lstm import PricePredictor
@@ -61,22 +63,26 @@ def prepare_features(df: pd.DataFrame) -> Tuple[np.ndarray, np.ndarray]:
     
     return X, y
 
-def main():
-    parser = argparse.ArgumentParser(description='Train LSTM model for price prediction')
-    parser.add_argument('--data', type=str, required=True, help='Path to training data CSV')
-    parser.add_argument('--config', type=str, required=True, help='Path to model config JSON')
-    parser.add_argument('--output', type=str, required=True, help='Output directory for model')
-    args = parser.parse_args()
+def set_seed(seed: Optional[int]) -> None:
+    """Seed every source of randomness so a run can be reproduced"""
+    if seed is None:
+        return
+    random.seed(seed)
+    np.random.seed(seed % 2**32)
+    tf.random.set_seed(seed)
+    # Identical seeds are only enough for identical metrics if ops that
+    # choose kernels nondeterministically are avoided too
+    tf.config.experimental.enable_op_determinism()
+
+def train_model(df: pd.DataFrame, config: dict) -> PricePredictor:
+    """Train a model on df's candles, seeded from config's seed if it has one"""
+    # Seed before anything random happens
+    seed = config.get('seed')
+    logger.info(f"Training with seed {seed}")
+    set_seed(seed)
     
-    # Load and prepare data
-    logger.info("Loading and preparing data...")
-    df = load_data(args.data)
     X, y = prepare_features(df)
     
-    # Load model configuration
-    with open(args.config) as f:
-        config = json.load(f)
-    
     # Create model instance
     model = PricePredictor(config)
     
@@ -97,6 +103,24 @@ def main():
         batch_size=config.get('batch_size', 32),
         epochs=config.get('epochs', 100)
     )
+    return model
+
+def main():
+    parser = argparse.ArgumentParser(description='Train LSTM model for price prediction')
+    parser.add_argument('--data', type=str, required=True, help='Path to training data CSV')
+    parser.add_argument('--config', type=str, required=True, help='Path to model config JSON')
+    parser.add_argument('--output', type=str, required=True, help='Output directory for model')
+    args = parser.parse_args()
+    
+    # Load model configuration
+    with open(args.config) as f:
+        config = json.load(f)
+    
+    # Load and prepare data
+    logger.info("Loading and preparing data...")
+    df = load_data(args.data)
+    
+    model = train_model(df, config)
     
     # Save model
     logger.info(f"Saving model to {args.output}")